@@ -7,6 +7,7 @@ package ast
 import (
 	"encoding/json"
 	"fmt"
+	"reflect"
 	"testing"
 )
 
@@ -1095,6 +1096,56 @@ func TestAnnotations_toObject(t *testing.T) {
 	}
 }
 
+func TestCompilerDecisionAnnotations(t *testing.T) {
+	opts := ParserOptions{ProcessAnnotation: true}
+	c := NewCompiler()
+	c.Compile(map[string]*Module{
+		"root.rego": MustParseModuleWithOpts(`# METADATA
+# scope: subpackages
+# title: root
+package root`, opts),
+		"policy.rego": MustParseModuleWithOpts(`# METADATA
+# title: policy
+package root.policy
+
+# METADATA
+# scope: document
+# title: allow document
+
+# METADATA
+# title: allow rule
+allow { input.x }
+
+deny { input.y }`, opts),
+	})
+	if c.Failed() {
+		t.Fatal(c.Errors)
+	}
+
+	tests := []struct {
+		path string
+		exp  []string
+	}{
+		{path: "data.root.policy.allow", exp: []string{"root", "policy", "allow document", "allow rule"}},
+		{path: "data.root.policy.deny", exp: []string{"root", "policy"}},
+		{path: "data.root.policy", exp: []string{"root", "policy"}},
+		{path: "data.root", exp: []string{"root"}},
+		{path: "data.missing"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.path, func(t *testing.T) {
+			var titles []string
+			for _, a := range c.DecisionAnnotations(MustParseRef(tc.path)) {
+				titles = append(titles, a.Title)
+			}
+			if !reflect.DeepEqual(titles, tc.exp) {
+				t.Fatalf("expected %v, got %v", tc.exp, titles)
+			}
+		})
+	}
+}
+
 func toJSON(v interface{}) string {
 	b, _ := json.MarshalIndent(v, "", "  ")
 	return string(b)
@@ -158,6 +158,8 @@ var DefaultBuiltins = [...]*Builtin{
 	YAMLMarshal,
 	YAMLUnmarshal,
 	YAMLIsValid,
+	XMLUnmarshal,
+	XMLXPath,
 	HexEncode,
 	HexDecode,
 
@@ -192,6 +194,11 @@ var DefaultBuiltins = [...]*Builtin{
 	JWTDecodeVerify,
 	JWTEncodeSignRaw,
 	JWTEncodeSign,
+	JWTEncodeSignJWKS,
+
+	// Token Encryption
+	JWEEncrypt,
+	JWEDecrypt,
 
 	// Time
 	NowNanos,
@@ -203,6 +210,9 @@ var DefaultBuiltins = [...]*Builtin{
 	Clock,
 	Weekday,
 	AddDate,
+	AddMonths,
+	StartOf,
+	ISOWeek,
 	Diff,
 
 	// Crypto
@@ -226,6 +236,8 @@ var DefaultBuiltins = [...]*Builtin{
 	WalkBuiltin,
 	ReachableBuiltin,
 	ReachablePathsBuiltin,
+	ShortestPathBuiltin,
+	TransitiveClosureBuiltin,
 
 	// Sort
 	Sort,
@@ -243,6 +255,9 @@ var DefaultBuiltins = [...]*Builtin{
 	// HTTP
 	HTTPSend,
 
+	// gRPC
+	GRPCSend,
+
 	// GraphQL
 	GraphQLParse,
 	GraphQLParseAndVerify,
@@ -265,6 +280,7 @@ var DefaultBuiltins = [...]*Builtin{
 
 	// OPA
 	OPARuntime,
+	BundlesStatus,
 
 	// Tracing
 	Trace,
@@ -289,8 +305,13 @@ var DefaultBuiltins = [...]*Builtin{
 
 	// UUIDs
 	UUIDRFC4122,
+	UUIDRFC9562V7,
 	UUIDParse,
 
+	// ULIDs
+	ULIDNew,
+	ULIDParse,
+
 	// SemVers
 	SemVerIsValid,
 	SemVerCompare,
@@ -1323,7 +1344,7 @@ var StringReverse = &Builtin{
 var RenderTemplate = &Builtin{
 	Name: "strings.render_template",
 	Description: `Renders a templated string with given template variables injected. For a given templated string and key/value mapping, values will be injected into the template where they are referenced by key.
-	For examples of templating syntax, see https://pkg.go.dev/text/template`,
+	For examples of templating syntax, see https://pkg.go.dev/text/template. Templates cannot access files, cannot range over number literals, and cannot render more than 1 MiB.`,
 	Decl: types.NewFunction(
 		types.Args(
 			types.Named("value", types.S).Description("a templated string"),
@@ -1449,6 +1470,49 @@ var UUIDParse = &Builtin{
 	Relation: false,
 }
 
+// UUIDRFC9562V7 returns a version 7 UUID string.
+// Marked non-deterministic because it relies on RNG and time internally.
+var UUIDRFC9562V7 = &Builtin{
+	Name:        "uuid.rfc9562_v7",
+	Description: "Returns a new UUIDv7, ordered by the time of the query evaluation.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("k", types.S),
+		),
+		types.Named("output", types.S).Description("a version 7 UUID; for any given `k`, the output will be consistent throughout a query evaluation"),
+	),
+	Nondeterministic: true,
+}
+
+/**
+ * ULID
+ */
+
+// ULIDNew returns a ULID string.
+// Marked non-deterministic because it relies on RNG and time internally.
+var ULIDNew = &Builtin{
+	Name:        "ulid.new",
+	Description: "Returns a new ULID, ordered by the time of the query evaluation.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("k", types.S),
+		),
+		types.Named("output", types.S).Description("a ULID; for any given `k`, the output will be consistent throughout a query evaluation"),
+	),
+	Nondeterministic: true,
+}
+
+var ULIDParse = &Builtin{
+	Name:        "ulid.parse",
+	Description: "Parses the string value as a ULID and returns an object with its time and randomness if valid.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("ulid", types.S),
+		),
+		types.Named("result", types.NewObject(nil, types.NewDynamicProperty(types.S, types.A))).Description("the `time` of the ULID in nanoseconds since epoch, at millisecond precision, and its `randomness` as a hex string. Undefined otherwise."),
+	),
+}
+
 /**
  * JSON
  */
@@ -1918,6 +1982,46 @@ var YAMLIsValid = &Builtin{
 	Categories: encoding,
 }
 
+var xmlElement = types.NewObject(
+	[]*types.StaticProperty{
+		types.NewStaticProperty("name", types.S),
+		types.NewStaticProperty("namespace", types.S),
+		types.NewStaticProperty("attributes", types.NewObject(nil, types.NewDynamicProperty(types.S, types.S))),
+		types.NewStaticProperty("children", types.NewArray(nil, types.A)),
+	},
+	nil,
+)
+
+var XMLUnmarshal = &Builtin{
+	Name: "xml.unmarshal",
+	Description: "Deserializes the input XML document. Documents with DTDs are rejected, so entities other than the predefined ones are never expanded. " +
+		"Elements are returned as objects with their `name`, `namespace`, `attributes` and `children`. " +
+		"Children are elements or text nodes, as strings. Comments, processing instructions and whitespace between elements are not kept.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("x", types.S).Description("an XML string"),
+		),
+		types.Named("y", xmlElement).Description("the root element of `x`"),
+	),
+	Categories: encoding,
+}
+
+var XMLXPath = &Builtin{
+	Name: "xml.xpath",
+	Description: "Evaluates an XPath 1.0 expression on an XML document. " +
+		"Location paths with the child, descendant, descendant-or-self, self, parent and attribute axes, predicates, unions, comparisons, and the " +
+		"`last`, `position`, `count`, `name`, `local-name`, `string`, `number`, `normalize-space`, `contains`, `starts-with`, `not`, `true` and `false` functions are supported. " +
+		"Names are matched against the local names of elements and attributes.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("doc", types.NewAny(types.S, xmlElement)).Description("an XML string, or an element returned by `xml.unmarshal`"),
+			types.Named("expr", types.S).Description("the XPath expression"),
+		),
+		types.Named("result", types.A).Description("the nodes selected by `expr`, in document order: elements as returned by `xml.unmarshal`, and the values of attributes and text nodes; or the string, number or boolean `expr` evaluates to"),
+	),
+	Categories: encoding,
+}
+
 var HexEncode = &Builtin{
 	Name:        "hex.encode",
 	Description: "Serializes the input string using hex-encoding.",
@@ -2173,6 +2277,54 @@ var JWTEncodeSign = &Builtin{
 	Nondeterministic: true,
 }
 
+// Marked non-deterministic because it relies on RNG internally.
+var JWTEncodeSignJWKS = &Builtin{
+	Name:        "io.jwt.encode_sign_jwks",
+	Description: "Encodes and signs a JSON Web Token with the key of a JSON Web Key Set identified by `kid`, which is set as the `kid` header. The `alg` header defaults to the algorithm of the key. Inputs are taken as objects, not encoded strings.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("headers", types.NewObject(nil, types.NewDynamicProperty(types.S, types.A))).Description("JWS Protected Header"),
+			types.Named("payload", types.NewObject(nil, types.NewDynamicProperty(types.S, types.A))).Description("JWS Payload"),
+			types.Named("jwks", types.NewAny(types.S, types.NewObject(nil, types.NewDynamicProperty(types.S, types.A)))).Description("JSON Web Key Set (RFC7517), as an object or a JSON string"),
+			types.Named("kid", types.S).Description("ID of the signing key"),
+		),
+		types.Named("output", types.S).Description("signed JWT"),
+	),
+	Categories:       tokenSign,
+	Nondeterministic: true,
+}
+
+var tokenEncrypt = category("tokenencrypt")
+
+// Marked non-deterministic because it relies on RNG internally.
+var JWEEncrypt = &Builtin{
+	Name:        "io.jwe.encrypt",
+	Description: "Encrypts a payload into a JSON Web Encryption in compact serialization. If `keys` holds several keys, the key is selected by the `kid` header; otherwise the `kid` header defaults to the ID of the key.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("headers", types.NewObject(nil, types.NewDynamicProperty(types.S, types.A))).Description("JWE Protected Header, with the `alg` key management and `enc` content encryption algorithms"),
+			types.Named("payload", types.S).Description("plaintext to encrypt"),
+			types.Named("keys", types.NewAny(types.S, types.NewObject(nil, types.NewDynamicProperty(types.S, types.A)))).Description("JSON Web Key or Key Set (RFC7517), as an object or a JSON string"),
+		),
+		types.Named("output", types.S).Description("encrypted JWE"),
+	),
+	Categories:       tokenEncrypt,
+	Nondeterministic: true,
+}
+
+var JWEDecrypt = &Builtin{
+	Name:        "io.jwe.decrypt",
+	Description: "Decrypts a JSON Web Encryption in compact serialization. If `keys` holds several keys, the key is selected by the `kid` header of the JWE.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("jwe", types.S).Description("JWE to decrypt"),
+			types.Named("keys", types.NewAny(types.S, types.NewObject(nil, types.NewDynamicProperty(types.S, types.A)))).Description("JSON Web Key or Key Set (RFC7517), as an object or a JSON string"),
+		),
+		types.Named("output", types.S).Description("decrypted payload"),
+	),
+	Categories: tokenEncrypt,
+}
+
 /**
  * Time
  */
@@ -2216,7 +2368,7 @@ var ParseDurationNanos = &Builtin{
 	Description: "Returns the duration in nanoseconds represented by a string.",
 	Decl: types.NewFunction(
 		types.Args(
-			types.Named("duration", types.S).Description("a duration like \"3m\"; see the [Go `time` package documentation](https://golang.org/pkg/time/#ParseDuration) for more details"),
+			types.Named("duration", types.S).Description("a duration like \"3m\"; see the [Go `time` package documentation](https://golang.org/pkg/time/#ParseDuration) for more details. The `d` and `w` units are also accepted, as 24 hours and 7 days respectively"),
 		),
 		types.Named("ns", types.N).Description("the `duration` in nanoseconds"),
 	),
@@ -2294,6 +2446,48 @@ var AddDate = &Builtin{
 	),
 }
 
+var AddMonths = &Builtin{
+	Name:        "time.add_months",
+	Description: "Returns the nanoseconds since epoch after adding months to nanoseconds, keeping the time of day. Unlike `time.add_date`, a day beyond the end of the resulting month is clamped to its last day - for example, January 31 plus one month is the last day of February.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("x", types.NewAny(
+				types.N,
+				types.NewArray([]types.Type{types.N, types.S}, nil),
+			)).Description("a number representing the nanoseconds since the epoch (UTC); or a two-element array of the nanoseconds, and a timezone string"),
+			types.Named("months", types.N).Description("number of months to add, which may be negative"),
+		),
+		types.Named("output", types.N).Description("nanoseconds since the epoch representing the input time, with months added in the supplied timezone (or UTC)"),
+	),
+}
+
+var StartOf = &Builtin{
+	Name:        "time.start_of",
+	Description: "Returns the nanoseconds since epoch of the start of the day, week, month, quarter or year containing nanoseconds in a timezone. Weeks start on Monday.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("ns", types.N).Description("nanoseconds since the epoch"),
+			types.Named("unit", types.S).Description("one of `day`, `week`, `month`, `quarter` or `year`"),
+			types.Named("tz", types.S).Description("timezone string; UTC if empty"),
+		),
+		types.Named("output", types.N).Description("nanoseconds since the epoch of midnight on the first day of the period in `tz`"),
+	),
+}
+
+var ISOWeek = &Builtin{
+	Name:        "time.iso_week",
+	Description: "Returns the ISO 8601 year, week number and weekday for the nanoseconds since epoch.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("x", types.NewAny(
+				types.N,
+				types.NewArray([]types.Type{types.N, types.S}, nil),
+			)).Description("a number representing the nanoseconds since the epoch (UTC); or a two-element array of the nanoseconds, and a timezone string"),
+		),
+		types.Named("output", types.NewArray([]types.Type{types.N, types.N, types.N}, nil)).Description("`[year, week, weekday]`: the week-numbering year, the week from 1 to 53, and the weekday from 1 (Monday) to 7 (Sunday)"),
+	),
+}
+
 var Diff = &Builtin{
 	Name:        "time.diff",
 	Description: "Returns the difference between two unix timestamps in nanoseconds (with optional timezone strings).",
@@ -2578,6 +2772,46 @@ var ReachablePathsBuiltin = &Builtin{
 	),
 }
 
+var ShortestPathBuiltin = &Builtin{
+	Name:        "graph.shortest_path",
+	Description: "Computes a path with the fewest edges between two vertices of a graph. The output is undefined if `to` is not reachable from `from`.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("graph", types.NewObject(
+				nil,
+				types.NewDynamicProperty(
+					types.A,
+					types.NewAny(
+						types.NewSet(types.A),
+						types.NewArray(nil, types.A)),
+				)),
+			).Description("object containing a set or array of neighboring vertices"),
+			types.Named("from", types.A).Description("vertex the path starts from"),
+			types.Named("to", types.A).Description("vertex the path ends at"),
+		),
+		types.Named("output", types.NewArray(nil, types.A)).Description("vertices of the path, from `from` to `to`"),
+	),
+}
+
+var TransitiveClosureBuiltin = &Builtin{
+	Name:        "graph.transitive_closure",
+	Description: "Computes the set of vertices reachable from each vertex of a graph through one or more edges.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("graph", types.NewObject(
+				nil,
+				types.NewDynamicProperty(
+					types.A,
+					types.NewAny(
+						types.NewSet(types.A),
+						types.NewArray(nil, types.A)),
+				)),
+			).Description("object containing a set or array of neighboring vertices"),
+		),
+		types.Named("output", types.NewObject(nil, types.NewDynamicProperty(types.A, types.NewSet(types.A)))).Description("object mapping each vertex of `graph` to the set of vertices reachable from it; a vertex is reachable from itself only if it is part of a cycle"),
+	),
+}
+
 /**
  * Type
  */
@@ -2701,6 +2935,23 @@ var HTTPSend = &Builtin{
 	Nondeterministic: true,
 }
 
+/**
+ * gRPC Request
+ */
+
+// Marked non-deterministic because gRPC responses can be non-deterministic.
+var GRPCSend = &Builtin{
+	Name:        "grpc.send",
+	Description: "Returns the response to the given unary gRPC request. Messages are transcoded from and to JSON using descriptors obtained through server reflection or from a descriptor set file.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("request", types.NewObject(nil, types.NewDynamicProperty(types.S, types.A))),
+		),
+		types.Named("response", types.NewObject(nil, types.NewDynamicProperty(types.A, types.A))),
+	),
+	Nondeterministic: true,
+}
+
 /**
  * GraphQL
  */
@@ -2818,7 +3069,7 @@ var JSONSchemaVerify = &Builtin{
 // and returns non-empty array with error objects otherwise.
 var JSONMatchSchema = &Builtin{
 	Name:        "json.match_schema",
-	Description: "Checks that the document matches the JSON schema.",
+	Description: "Checks that the document matches the JSON schema. String values are checked against the `format` of the schema, e.g., `date-time`, `email` or `uri`.",
 	Decl: types.NewFunction(
 		types.Args(
 			types.Named("document", types.NewAny(types.S, types.NewObject(nil, types.NewDynamicProperty(types.A, types.A)))).
@@ -2918,6 +3169,24 @@ var OPARuntime = &Builtin{
 	Nondeterministic: true,
 }
 
+var BundlesStatus = &Builtin{
+	Name:        "bundles.status",
+	Description: "Returns the revisions, roots and metadata of the bundles that are activated in the store the policy is evaluated against.",
+	Decl: types.NewFunction(
+		nil,
+		types.Named("output", types.NewObject(nil, types.NewDynamicProperty(types.S, types.NewObject(
+			[]*types.StaticProperty{
+				types.NewStaticProperty("revision", types.S),
+				types.NewStaticProperty("roots", types.NewArray(nil, types.S)),
+				types.NewStaticProperty("metadata", types.NewObject(nil, types.NewDynamicProperty(types.S, types.A))),
+			},
+			nil,
+		)))).Description("mapping of bundle names to objects with the `revision`, `roots` and `metadata` of the bundle manifests"),
+	),
+	Categories:       category("opa"),
+	Nondeterministic: true,
+}
+
 /**
  * Trace
  */
@@ -207,6 +207,19 @@ func (c *Capabilities) MinimumCompatibleVersion() (string, bool) {
 	return maxVersion.String(), true
 }
 
+// RequiredCapabilities compiles modules and returns the minimal capabilities
+// required to compile them: the built-in functions they call, and the future
+// keywords and language features they use. Print statements are kept, so the
+// print built-in function is required if modules call it.
+func RequiredCapabilities(modules map[string]*Module) (*Capabilities, error) {
+	c := NewCompiler().WithEnablePrintStatements(true)
+	c.Compile(modules)
+	if c.Failed() {
+		return nil, c.Errors
+	}
+	return c.Required, nil
+}
+
 func (c *Capabilities) ContainsFeature(feature string) bool {
 	for _, f := range c.Features {
 		if f == feature {
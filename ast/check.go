@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"sync"
 
 	"github.com/open-policy-agent/opa/types"
 	"github.com/open-policy-agent/opa/util"
@@ -55,6 +56,33 @@ func (tc *typeChecker) newEnv(exist *TypeEnv) *TypeEnv {
 	return env
 }
 
+// clone returns a typeChecker with the same configuration as tc and no
+// errors. If tc records required capabilities, the clone records them
+// separately so that they can be merged back into tc.
+func (tc *typeChecker) clone() *typeChecker {
+	cpy := newTypeChecker().
+		WithBuiltins(tc.builtins).
+		WithVarRewriter(tc.varRewriter).
+		WithSchemaSet(tc.ss).
+		WithAllowNet(tc.allowNet).
+		WithInputType(tc.input).
+		WithAllowUndefinedFunctionCalls(tc.allowUndefinedFuncs)
+	if tc.required != nil {
+		cpy.required = &Capabilities{}
+	}
+	return cpy
+}
+
+// merge adds the errors and required capabilities recorded by other to tc.
+func (tc *typeChecker) merge(other *typeChecker) {
+	tc.err(other.errs)
+	if tc.required != nil && other.required != nil {
+		for _, bi := range other.required.Builtins {
+			tc.required.addBuiltinSorted(bi)
+		}
+	}
+}
+
 func (tc *typeChecker) copy() *typeChecker {
 	return newTypeChecker().
 		WithVarRewriter(tc.varRewriter).
@@ -165,6 +193,86 @@ func (tc *typeChecker) CheckTypes(env *TypeEnv, sorted []util.T, as *AnnotationS
 	return env, tc.errs
 }
 
+// CheckTypesParallel is like CheckTypes except that rules which do not depend
+// on each other are type checked concurrently by up to n goroutines. The deps
+// function returns the rules that a rule depends on.
+func (tc *typeChecker) CheckTypesParallel(env *TypeEnv, sorted []util.T, as *AnnotationSet, deps func(util.T) map[util.T]struct{}, n int) (*TypeEnv, Errors) {
+	env = tc.newEnv(env)
+	for _, level := range ruleLevels(sorted, deps) {
+		// Rules in a level only read the types of rules in lower levels so
+		// the environment is not modified until the whole level is checked.
+		for _, result := range tc.inferRules(env, as, level, n) {
+			result.apply(env)
+		}
+	}
+	tc.errs.Sort()
+	return env, tc.errs
+}
+
+// ruleLevels groups the sorted rules such that every rule only depends on
+// rules in preceding groups. The order of the rules within a group follows
+// the order of sorted.
+func ruleLevels(sorted []util.T, deps func(util.T) map[util.T]struct{}) [][]*Rule {
+	var levels [][]*Rule
+	depth := make(map[util.T]int, len(sorted))
+	for _, s := range sorted {
+		d := 0
+		for dep := range deps(s) {
+			if x, ok := depth[dep]; ok && x+1 > d {
+				d = x + 1
+			}
+		}
+		depth[s] = d
+		if d == len(levels) {
+			levels = append(levels, nil)
+		}
+		levels[d] = append(levels[d], s.(*Rule))
+	}
+	return levels
+}
+
+func (tc *typeChecker) inferRules(env *TypeEnv, as *AnnotationSet, rules []*Rule, n int) []ruleType {
+	results := make([]ruleType, len(rules))
+
+	if n > len(rules) {
+		n = len(rules)
+	}
+
+	if n < 2 {
+		for i, rule := range rules {
+			results[i] = tc.inferRule(env, as, rule)
+		}
+		return results
+	}
+
+	workers := make([]*typeChecker, n)
+	indices := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := range workers {
+		workers[w] = tc.clone()
+		wg.Add(1)
+		go func(checker *typeChecker) {
+			defer wg.Done()
+			for i := range indices {
+				results[i] = checker.inferRule(env, as, rules[i])
+			}
+		}(workers[w])
+	}
+
+	for i := range rules {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+
+	for _, w := range workers {
+		tc.merge(w)
+	}
+
+	return results
+}
+
 func (tc *typeChecker) checkClosures(env *TypeEnv, expr *Expr) Errors {
 	var result Errors
 	WalkClosures(expr, func(x interface{}) bool {
@@ -193,7 +301,35 @@ func (tc *typeChecker) checkClosures(env *TypeEnv, expr *Expr) Errors {
 	return result
 }
 
+// ruleType is the type inferred for a rule.
+type ruleType struct {
+	path   Ref
+	tpe    types.Type
+	failed bool
+}
+
+// apply records the rule type in env.
+func (rt ruleType) apply(env *TypeEnv) {
+	if rt.failed {
+		// if the rule/function contains an error, add it to the type env so
+		// that expressions that refer to this rule/function do not encounter
+		// type errors.
+		env.tree.Put(rt.path, types.A)
+		return
+	}
+
+	if rt.tpe != nil {
+		env.tree.Insert(rt.path, rt.tpe, env)
+	}
+}
+
 func (tc *typeChecker) checkRule(env *TypeEnv, as *AnnotationSet, rule *Rule) {
+	tc.inferRule(env, as, rule).apply(env)
+}
+
+// inferRule type checks the rule and returns its type. The environment is
+// not modified.
+func (tc *typeChecker) inferRule(env *TypeEnv, as *AnnotationSet, rule *Rule) ruleType {
 
 	env = env.wrap()
 
@@ -221,15 +357,10 @@ func (tc *typeChecker) checkRule(env *TypeEnv, as *AnnotationSet, rule *Rule) {
 	}
 
 	cpy, err := tc.CheckBody(env, rule.Body)
-	env = env.next
 	path := rule.Ref()
 
 	if len(err) > 0 {
-		// if the rule/function contains an error, add it to the type env so
-		// that expressions that refer to this rule/function do not encounter
-		// type errors.
-		env.tree.Put(path, types.A)
-		return
+		return ruleType{path: path, failed: true}
 	}
 
 	var tpe types.Type
@@ -280,9 +411,7 @@ func (tc *typeChecker) checkRule(env *TypeEnv, as *AnnotationSet, rule *Rule) {
 		}
 	}
 
-	if tpe != nil {
-		env.tree.Insert(path, tpe, env)
-	}
+	return ruleType{path: path, tpe: tpe}
 }
 
 // nestedObject creates a nested structure of object types, where each term on path corresponds to a level in the
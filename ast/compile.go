@@ -148,6 +148,8 @@ type Compiler struct {
 	useTypeCheckAnnotations bool                          // whether to provide annotated information (schemas) to the type checker
 	allowUndefinedFuncCalls bool                          // don't error on calls to unknown functions.
 	evalMode                CompilerEvalMode
+	parallelism             int          // maximum number of goroutines used to type check rules
+	indexHints              []*IndexHint // hot values that rule indices are specialized for
 }
 
 // CompilerStage defines the interface for stages in the compiler.
@@ -463,6 +465,23 @@ func (c *Compiler) WithAllowUndefinedFunctionCalls(allow bool) *Compiler {
 	return c
 }
 
+// WithIndexHints sets hints about values that references indexed by rule
+// indices are frequently looked up with. Rule indices build specialized tries
+// for these values. See Compiler.IndexHints for how to obtain hints from an
+// evaluation profile.
+func (c *Compiler) WithIndexHints(hints []*IndexHint) *Compiler {
+	c.indexHints = hints
+	return c
+}
+
+// WithParallelism sets the maximum number of goroutines the compiler uses to
+// type check rules that do not depend on each other. Values less than two
+// (the default) type check all rules on the calling goroutine.
+func (c *Compiler) WithParallelism(n int) *Compiler {
+	c.parallelism = n
+	return c
+}
+
 // WithEvalMode allows setting the CompilerEvalMode of the compiler
 func (c *Compiler) WithEvalMode(e CompilerEvalMode) *Compiler {
 	c.evalMode = e
@@ -891,6 +910,23 @@ func (c *Compiler) counterAdd(name string, n uint64) {
 }
 
 func (c *Compiler) buildRuleIndices() {
+	c.ruleSets(func(path Ref, rules []*Rule) {
+		index := newBaseDocEqIndex(func(ref Ref) bool {
+			return isVirtual(c.RuleTree, ref.GroundPrefix())
+		})
+		for _, hint := range c.indexHints {
+			if hint.Path.Equal(path) {
+				index.hints = append(index.hints, hint)
+			}
+		}
+		if index.Build(rules) {
+			c.ruleIndices.Put(path, index)
+		}
+	})
+}
+
+// ruleSets calls fn for each set of rules that a rule index is built for.
+func (c *Compiler) ruleSets(fn func(path Ref, rules []*Rule)) {
 
 	c.RuleTree.DepthFirst(func(node *TreeNode) bool {
 		if len(node.Values) == 0 {
@@ -917,17 +953,73 @@ func (c *Compiler) buildRuleIndices() {
 			}
 		}
 
-		index := newBaseDocEqIndex(func(ref Ref) bool {
-			return isVirtual(c.RuleTree, ref.GroundPrefix())
-		})
-		if index.Build(rules) {
-			c.ruleIndices.Put(rules[0].Ref().GroundPrefix(), index)
-		}
+		fn(rules[0].Ref().GroundPrefix(), rules)
 		return hasNonGroundRef // currently, we don't allow those branches to go deeper
 	})
 
 }
 
+// IndexHints returns hints for the rule indexer derived from the evaluation
+// counts of expressions in the compiled rules. The count function returns the
+// number of times the expression at the given location was evaluated. Only
+// expressions that compare an indexed reference against a scalar value yield
+// hints. Since the rule indexer skips rules whose indexed expressions cannot
+// match, these counts approximate how often lookups resolve the reference to
+// the value.
+func (c *Compiler) IndexHints(count func(*Location) int) []*IndexHint {
+	var hints []*IndexHint
+
+	isVirtual := func(ref Ref) bool {
+		return isVirtual(c.RuleTree, ref.GroundPrefix())
+	}
+
+	c.ruleSets(func(path Ref, rules []*Rule) {
+		for _, rule := range rules {
+			WalkRules(rule, func(rule *Rule) bool {
+				for _, expr := range rule.Body {
+					if expr.Location == nil {
+						continue
+					}
+					n := count(expr.Location)
+					if n <= 0 {
+						continue
+					}
+					indices := newrefindices(isVirtual)
+					indices.Update(rule, expr)
+					for _, index := range indices.rules[rule] {
+						if index.Mapper == nil && IsScalar(index.Value) {
+							hints = addIndexHint(hints, &IndexHint{Path: path, Ref: index.Ref, Value: index.Value, Count: n})
+						}
+					}
+				}
+				return false
+			})
+		}
+	})
+
+	sort.Slice(hints, func(i, j int) bool {
+		if cmp := hints[i].Path.Compare(hints[j].Path); cmp != 0 {
+			return cmp < 0
+		}
+		if cmp := hints[i].Ref.Compare(hints[j].Ref); cmp != 0 {
+			return cmp < 0
+		}
+		return hints[i].Value.Compare(hints[j].Value) < 0
+	})
+
+	return hints
+}
+
+func addIndexHint(hints []*IndexHint, hint *IndexHint) []*IndexHint {
+	for _, other := range hints {
+		if other.Path.Equal(hint.Path) && other.Ref.Equal(hint.Ref) && other.Value.Compare(hint.Value) == 0 {
+			other.Count += hint.Count
+			return hints
+		}
+	}
+	return append(hints, hint)
+}
+
 func (c *Compiler) buildComprehensionIndices() {
 	for _, name := range c.sorted {
 		WalkRules(c.Modules[name], func(r *Rule) bool {
@@ -1525,7 +1617,13 @@ func (c *Compiler) checkTypes() {
 	if c.useTypeCheckAnnotations {
 		as = c.annotationSet
 	}
-	env, errs := checker.CheckTypes(c.TypeEnv, sorted, as)
+	var env *TypeEnv
+	var errs Errors
+	if c.parallelism > 1 {
+		env, errs = checker.CheckTypesParallel(c.TypeEnv, sorted, as, c.Graph.Dependencies, c.parallelism)
+	} else {
+		env, errs = checker.CheckTypes(c.TypeEnv, sorted, as)
+	}
 	for _, err := range errs {
 		c.err(err)
 	}
@@ -1690,6 +1788,71 @@ func (c *Compiler) GetAnnotationSet() *AnnotationSet {
 	return c.annotationSet
 }
 
+// DecisionAnnotations returns the annotations that apply to the rules at path,
+// or to the package at path if there are no such rules. The annotations are
+// ordered from the broadest to the narrowest scope, so callers merging them
+// should let later annotations take precedence.
+func (c *Compiler) DecisionAnnotations(path Ref) []*Annotations {
+	if c.annotationSet == nil {
+		return nil
+	}
+
+	var result []*Annotations
+	if rules := c.GetRulesExact(path); len(rules) > 0 {
+		for _, rule := range rules {
+			result = append(result, c.annotationSet.GetSubpackagesScope(rule.Module.Package.Path)...)
+			if x := c.annotationSet.GetPackageScope(rule.Module.Package); x != nil {
+				result = append(result, x)
+			}
+			if x := c.annotationSet.GetDocumentScope(rule.Ref().GroundPrefix()); x != nil {
+				result = append(result, x)
+			}
+			result = append(result, c.annotationSet.GetRuleScope(rule)...)
+		}
+	} else {
+		result = append(result, c.annotationSet.GetSubpackagesScope(path)...)
+		if x := c.annotationSet.GetPackageScope(&Package{Path: path}); x != nil {
+			result = append(result, x)
+		}
+	}
+
+	return result
+}
+
+// InputSchema returns the JSON schema of the input document declared by the
+// schema annotations of the rules at path, or of the package at path, or nil
+// if no schema is declared. Annotations of narrower scopes take precedence,
+// e.g., rule annotations over package annotations. Schema refs are resolved
+// with the compiler's schema set.
+func (c *Compiler) InputSchema(path Ref) (interface{}, error) {
+	if c.annotationSet == nil {
+		return nil, nil
+	}
+
+	var schema *SchemaAnnotation
+	for _, a := range c.DecisionAnnotations(path) {
+		for _, x := range a.Schemas {
+			if x.Path.Equal(InputRootRef) {
+				schema = x
+			}
+		}
+	}
+
+	switch {
+	case schema == nil:
+		return nil, nil
+	case schema.Schema != nil:
+		raw := c.schemaSet.Get(schema.Schema)
+		if raw == nil {
+			return nil, fmt.Errorf("undefined schema: %v", schema.Schema)
+		}
+		return raw, nil
+	case schema.Definition != nil:
+		return *schema.Definition, nil
+	}
+	return nil, nil
+}
+
 func (c *Compiler) checkDuplicateImports() {
 	modules := make([]*Module, 0, len(c.Modules))
 
@@ -1775,6 +1775,79 @@ func TestCompilerCheckTypes(t *testing.T) {
 	assertNotFailed(t, c)
 }
 
+func TestCompilerCheckTypesParallel(t *testing.T) {
+	modules := map[string]string{
+		"a.rego": `package a
+
+import rego.v1
+
+p contains x if some x in [1, 2, 3]
+p contains "foo"
+
+q := {"a": count(p), "b": r}
+
+r := data.b.s[_].t
+
+f(x) := y if y := concat(",", x)
+
+g := f(["a", "b"])
+
+obj.nested[k] := v if some k, v in {"x": 1}
+
+obj.other := "bar"
+`,
+		"b.rego": `package b
+
+import rego.v1
+
+s contains {"t": data.a.g}
+
+u := upper(data.a.q.b)
+
+bad := x if x := data.a.q.a + "str"
+
+uses_bad := data.b.bad + 1
+`,
+	}
+
+	parsed := make(map[string]*Module, len(modules))
+	for name, src := range modules {
+		parsed[name] = MustParseModule(src)
+	}
+
+	sequential := NewCompiler()
+	sequential.Compile(parsed)
+
+	parsed = make(map[string]*Module, len(modules))
+	for name, src := range modules {
+		parsed[name] = MustParseModule(src)
+	}
+
+	parallel := NewCompiler().WithParallelism(4)
+	parallel.Compile(parsed)
+
+	if !sequential.Failed() || sequential.Errors.Error() != parallel.Errors.Error() {
+		t.Fatalf("Expected equal errors:\n\nsequential:\n%v\n\nparallel:\n%v", sequential.Errors, parallel.Errors)
+	}
+
+	for _, ref := range []string{"data.a.p", "data.a.q", "data.a.r", "data.a.f", "data.a.g", "data.a.obj", "data.b.s", "data.b.u", "data.b.bad", "data.b.uses_bad"} {
+		exp := sequential.TypeEnv.Get(MustParseRef(ref))
+		act := parallel.TypeEnv.Get(MustParseRef(ref))
+		if types.Compare(exp, act) != 0 {
+			t.Errorf("Expected type of %v to be %v but got %v", ref, exp, act)
+		}
+	}
+
+	if len(sequential.Required.Builtins) != len(parallel.Required.Builtins) {
+		t.Fatalf("Expected required builtins %v but got %v", sequential.Required.Builtins, parallel.Required.Builtins)
+	}
+	for i := range sequential.Required.Builtins {
+		if sequential.Required.Builtins[i].Name != parallel.Required.Builtins[i].Name {
+			t.Fatalf("Expected required builtins %v but got %v", sequential.Required.Builtins, parallel.Required.Builtins)
+		}
+	}
+}
+
 func TestCompilerCheckRuleConflicts(t *testing.T) {
 
 	c := getCompilerWithParsedModules(map[string]string{
@@ -0,0 +1,287 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package ast
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DeadCode returns errors for the dead code of the compiled modules:
+//
+//   - rules that are not reachable from any of the entrypoints; entrypoints are
+//     the refs passed, the rules and packages annotated as entrypoints, and the
+//     test rules. If there are no entrypoints, this check is skipped,
+//   - rule bodies that can never succeed, because they contain a constant
+//     expression that is false, e.g., `1 > 2`, or because they compare the
+//     same value with different constants, e.g., `input.x == 1; input.x == 2`,
+//   - else branches that are shadowed by an earlier branch that always
+//     succeeds.
+//
+// DeadCode must be called after the modules were compiled successfully. It is
+// used by 'opa check --strict'.
+func (c *Compiler) DeadCode(entrypoints []Ref) Errors {
+	var errs Errors
+
+	for _, name := range c.sorted {
+		WalkRules(c.Modules[name], func(r *Rule) bool {
+			if expr, msg := c.unsatisfiableBody(r.Body); expr != nil {
+				errs = append(errs, NewError(CompileErr, expr.Location, "rule body can never succeed: %v", msg))
+			}
+			if r.Else != nil && alwaysTrue(r.Body) {
+				errs = append(errs, NewError(CompileErr, r.Else.Location, "else branch is shadowed by an earlier branch that always succeeds"))
+			}
+			return false
+		})
+	}
+
+	return append(errs, c.unreachableRules(entrypoints)...)
+}
+
+// unreachableRules returns errors for the rules that cannot be reached from
+// the entrypoints through the dependency graph.
+func (c *Compiler) unreachableRules(entrypoints []Ref) Errors {
+	entrypoints = append([]Ref{}, entrypoints...)
+	for _, ar := range c.GetAnnotationSet().Flatten() {
+		if ar.Annotations != nil && ar.Annotations.Entrypoint {
+			entrypoints = append(entrypoints, ar.Path)
+		}
+	}
+
+	if len(entrypoints) == 0 {
+		return nil
+	}
+
+	paths, rules, reached := c.reachable(entrypoints, true)
+
+	var errs Errors
+	for _, key := range paths {
+		if _, ok := reached[key]; !ok {
+			errs = append(errs, NewError(CompileErr, rules[key][0].Location, "rule %v is unreachable from the entrypoints", key))
+		}
+	}
+	return errs
+}
+
+// ReachableRules returns the rules of the compiled modules that can be reached
+// from the entrypoints through the dependency graph, in the order of the
+// modules. Rules that share the path of a reachable rule, e.g., the default
+// rule and the other definitions of a rule, are reachable too. ReachableRules
+// must be called after the modules were compiled successfully.
+func (c *Compiler) ReachableRules(entrypoints []Ref) []*Rule {
+	paths, rules, reached := c.reachable(entrypoints, false)
+
+	var result []*Rule
+	for _, key := range paths {
+		if _, ok := reached[key]; ok {
+			result = append(result, rules[key]...)
+		}
+	}
+	return result
+}
+
+// reachable returns the paths of the rules in the order of the modules, the
+// rules by path, and the set of paths that are reachable from the entrypoints
+// and, if tests is true, from the test rules.
+func (c *Compiler) reachable(entrypoints []Ref, tests bool) ([]string, map[string][]*Rule, map[string]struct{}) {
+	var paths []string
+	rules := map[string][]*Rule{}
+	var queue []*Rule
+
+	for _, name := range c.sorted {
+		WalkRules(c.Modules[name], func(r *Rule) bool {
+			path := r.Path()
+			key := path.String()
+			if _, ok := rules[key]; !ok {
+				paths = append(paths, key)
+			}
+			rules[key] = append(rules[key], r)
+			if tests && isTestRule(r) {
+				queue = append(queue, r)
+				return false
+			}
+			for _, e := range entrypoints {
+				if path.HasPrefix(e) || e.HasPrefix(path) {
+					queue = append(queue, r)
+					break
+				}
+			}
+			return false
+		})
+	}
+
+	reached := map[string]struct{}{}
+	for len(queue) > 0 {
+		r := queue[0]
+		queue = queue[1:]
+		key := r.Path().String()
+		if _, ok := reached[key]; ok {
+			continue
+		}
+		reached[key] = struct{}{}
+		for _, other := range rules[key] {
+			for dep := range c.Graph.Dependencies(other) {
+				if d, ok := dep.(*Rule); ok && d.Module != nil {
+					queue = append(queue, d)
+				}
+			}
+		}
+	}
+
+	return paths, rules, reached
+}
+
+// isTestRule returns true if r is a test, or a skipped test, that is run by
+// 'opa test'.
+func isTestRule(r *Rule) bool {
+	ref := r.Head.Ref()
+	if len(ref) == 0 {
+		return false
+	}
+	name := ref[0].Value.String()
+	return strings.HasPrefix(name, "test_") || strings.HasPrefix(name, "todo_test_")
+}
+
+// unsatisfiableBody returns an expression of body that makes the body fail,
+// and a message describing why.
+func (c *Compiler) unsatisfiableBody(body Body) (*Expr, string) {
+	eqs := map[string]*Term{}
+	var neqs []*Expr
+
+	for _, expr := range body {
+		if v, ok := constantExpr(expr); ok {
+			if !v {
+				return expr, "expression is always false"
+			}
+			continue
+		}
+
+		if expr.Negated || len(expr.With) > 0 || !expr.IsCall() || len(expr.Operands()) != 2 {
+			continue
+		}
+
+		switch {
+		case expr.IsEquality(), expr.Operator().Equal(Equal.Ref()):
+		case expr.Operator().Equal(NotEqual.Ref()):
+			neqs = append(neqs, expr)
+			continue
+		default:
+			continue
+		}
+
+		x, value, ok := comparedValue(expr)
+		if !ok {
+			continue
+		}
+		key := x.String()
+		if prev, ok := eqs[key]; ok {
+			if prev.Value.Compare(value.Value) != 0 {
+				return expr, fmt.Sprintf("%v cannot be equal to both %v and %v", c.originalTerm(x), prev, value)
+			}
+			continue
+		}
+		eqs[key] = value
+	}
+
+	for _, expr := range neqs {
+		x, value, ok := comparedValue(expr)
+		if !ok {
+			continue
+		}
+		if prev, ok := eqs[x.String()]; ok && prev.Value.Compare(value.Value) == 0 {
+			return expr, fmt.Sprintf("%v cannot be both equal and not equal to %v", c.originalTerm(x), value)
+		}
+	}
+
+	return nil, ""
+}
+
+// originalTerm returns x with the variables rewritten by the compiler
+// replaced by the variables of the policy.
+func (c *Compiler) originalTerm(x *Term) *Term {
+	y, err := TransformVars(x.Copy().Value, func(v Var) (Value, error) {
+		if orig, ok := c.RewrittenVars[v]; ok {
+			return orig, nil
+		}
+		return v, nil
+	})
+	if err != nil {
+		return x
+	}
+	return NewTerm(y.(Value))
+}
+
+// comparedValue returns the ref or var and the constant compared by the
+// expression.
+func comparedValue(expr *Expr) (*Term, *Term, bool) {
+	a, b := expr.Operand(0), expr.Operand(1)
+	if IsConstant(a.Value) {
+		a, b = b, a
+	}
+	switch a.Value.(type) {
+	case Ref, Var:
+	default:
+		return nil, nil, false
+	}
+	if !IsConstant(b.Value) {
+		return nil, nil, false
+	}
+	return a, b, true
+}
+
+// alwaysTrue returns true if all expressions of body are constant and true.
+func alwaysTrue(body Body) bool {
+	for _, expr := range body {
+		if v, ok := constantExpr(expr); !ok || !v {
+			return false
+		}
+	}
+	return true
+}
+
+// constantExpr returns the truth value of expr if it does not depend on any
+// input, data or variables.
+func constantExpr(expr *Expr) (bool, bool) {
+	if len(expr.With) > 0 {
+		return false, false
+	}
+
+	var v bool
+	switch terms := expr.Terms.(type) {
+	case *Term:
+		if !IsConstant(terms.Value) {
+			return false, false
+		}
+		v = Compare(terms.Value, Boolean(false)) != 0
+	case []*Term:
+		if len(terms) != 3 || !IsConstant(terms[1].Value) || !IsConstant(terms[2].Value) {
+			return false, false
+		}
+		cmp := Compare(terms[1].Value, terms[2].Value)
+		switch op := expr.Operator(); {
+		case op.Equal(Equality.Ref()), op.Equal(Equal.Ref()):
+			v = cmp == 0
+		case op.Equal(NotEqual.Ref()):
+			v = cmp != 0
+		case op.Equal(LessThan.Ref()):
+			v = cmp < 0
+		case op.Equal(LessThanEq.Ref()):
+			v = cmp <= 0
+		case op.Equal(GreaterThan.Ref()):
+			v = cmp > 0
+		case op.Equal(GreaterThanEq.Ref()):
+			v = cmp >= 0
+		default:
+			return false, false
+		}
+	default:
+		return false, false
+	}
+
+	if expr.Negated {
+		v = !v
+	}
+	return v, true
+}
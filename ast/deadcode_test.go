@@ -0,0 +1,195 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package ast
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCompilerDeadCode(t *testing.T) {
+	tests := []struct {
+		note        string
+		module      string
+		entrypoints []string
+		exp         []string
+	}{
+		{
+			note: "no dead code",
+			module: `package test
+
+allow if {
+	input.x == 1
+	input.y == 2
+	x := input.z
+	x != 3
+}
+
+f(x) := 1 if x > 0
+
+else := 2`,
+		},
+		{
+			note: "constant false expression",
+			module: `package test
+
+p if {
+	input.x
+	1 > 2
+}`,
+			exp: []string{"5:2: rego_compile_error: rule body can never succeed: expression is always false"},
+		},
+		{
+			note: "negated constant expression",
+			module: `package test
+
+p if not "a" == "a"
+
+q if not "a" == "b"`,
+			exp: []string{"3:6: rego_compile_error: rule body can never succeed: expression is always false"},
+		},
+		{
+			note: "contradictory comparisons",
+			module: `package test
+
+p if {
+	input.x == 1
+	input.x == "1"
+}`,
+			exp: []string{"5:2: rego_compile_error: rule body can never succeed: input.x cannot be equal to both 1 and \"1\""},
+		},
+		{
+			note: "contradictory comparisons of local",
+			module: `package test
+
+p if {
+	x := input.x
+	x == 1
+	x != 1
+}`,
+			exp: []string{"6:2: rego_compile_error: rule body can never succeed: x cannot be both equal and not equal to 1"},
+		},
+		{
+			note: "shadowed else",
+			module: `package test
+
+p := 1 if {
+	true
+} else := 2 if {
+	input.x
+}`,
+			exp: []string{"5:3: rego_compile_error: else branch is shadowed by an earlier branch that always succeeds"},
+		},
+		{
+			note: "unreachable rules",
+			module: `package test
+
+allow if {
+	helper
+}
+
+helper if input.x
+
+deny contains "x" if unused
+
+unused if input.y
+
+test_helper if helper`,
+			entrypoints: []string{"data.test.allow"},
+			exp: []string{
+				"9:1: rego_compile_error: rule data.test.deny is unreachable from the entrypoints",
+				"11:1: rego_compile_error: rule data.test.unused is unreachable from the entrypoints",
+			},
+		},
+		{
+			note: "annotated entrypoints",
+			module: `package test
+
+# METADATA
+# entrypoint: true
+allow if helper
+
+helper if input.x
+
+unused if input.y`,
+			exp: []string{"9:1: rego_compile_error: rule data.test.unused is unreachable from the entrypoints"},
+		},
+		{
+			note: "package entrypoint",
+			module: `package test
+
+allow if input.x
+
+deny if input.y`,
+			entrypoints: []string{"data.test"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			c := NewCompiler()
+			c.Compile(map[string]*Module{
+				"test.rego": MustParseModuleWithOpts(tc.module, ParserOptions{RegoVersion: RegoV1, ProcessAnnotation: true}),
+			})
+			if c.Failed() {
+				t.Fatal(c.Errors)
+			}
+
+			var entrypoints []Ref
+			for _, e := range tc.entrypoints {
+				entrypoints = append(entrypoints, MustParseRef(e))
+			}
+
+			errs := c.DeadCode(entrypoints)
+			if len(errs) != len(tc.exp) {
+				t.Fatalf("expected %d errors, got %v", len(tc.exp), errs)
+			}
+			for i, exp := range tc.exp {
+				if act := fmt.Sprintf("%d:%d: %v: %v", errs[i].Location.Row, errs[i].Location.Col, errs[i].Code, errs[i].Message); act != exp {
+					t.Errorf("expected %q, got %q", exp, act)
+				}
+			}
+		})
+	}
+}
+
+func TestCompilerReachableRules(t *testing.T) {
+	c := NewCompiler()
+	c.Compile(map[string]*Module{
+		"test.rego": MustParseModuleWithOpts(`package test
+
+import data.lib
+
+default allow := false
+
+allow if lib.f(input.x)
+
+deny if input.y
+
+test_allow if allow`, ParserOptions{RegoVersion: RegoV1}),
+		"lib.rego": MustParseModuleWithOpts(`package lib
+
+f(x) := g(x)
+
+g(x) := x if x > 0
+
+else := 0
+
+h := 1`, ParserOptions{RegoVersion: RegoV1}),
+	})
+	if c.Failed() {
+		t.Fatal(c.Errors)
+	}
+
+	var act []string
+	for _, r := range c.ReachableRules([]Ref{MustParseRef("data.test.allow")}) {
+		act = append(act, fmt.Sprintf("%v:%d", r.Path(), r.Location.Row))
+	}
+
+	exp := []string{"data.lib.f:3", "data.lib.g:5", "data.lib.g:7", "data.test.allow:5", "data.test.allow:7"}
+	if fmt.Sprint(act) != fmt.Sprint(exp) {
+		t.Fatalf("expected %v, got %v", exp, act)
+	}
+}
@@ -6,6 +6,7 @@ package ast
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/open-policy-agent/opa/types"
@@ -226,6 +227,87 @@ func (env *TypeEnv) getRefRecExtent(node *typeTreeNode) types.Type {
 	return types.NewObject(children, types.NewDynamicProperty(types.S, types.A))
 }
 
+// TypedRef is a ref with the type inferred for it.
+type TypedRef struct {
+	Ref  Ref
+	Type types.Type
+}
+
+// Children returns the refs directly beneath prefix with their inferred types,
+// e.g., the packages and rules beneath data.policies, or the static keys of
+// the object produced by a rule. The refs are sorted. Children is intended for
+// completion in editors and the REPL: refs to documents the type checker knows
+// nothing about, like base documents, have no children.
+func (env *TypeEnv) Children(prefix Ref) []TypedRef {
+	if len(prefix) == 0 {
+		return nil
+	}
+
+	seen := NewSet()
+	var result []TypedRef
+	for e := env; e != nil; e = e.next {
+		e.children(prefix, func(key Value, tpe types.Type) {
+			term := NewTerm(key)
+			if seen.Contains(term) {
+				return
+			}
+			seen.Add(term)
+			result = append(result, TypedRef{Ref: prefix.Append(term), Type: tpe})
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Ref.Compare(result[j].Ref) < 0
+	})
+	return result
+}
+
+func (env *TypeEnv) children(prefix Ref, iter func(Value, types.Type)) {
+	node := env.tree.Child(prefix[0].Value)
+	if node == nil {
+		return
+	}
+
+	tail := prefix[1:]
+	for len(tail) > 0 {
+		child := node.Child(tail[0].Value)
+		if child == nil {
+			break
+		}
+		node, tail = child, tail[1:]
+	}
+
+	if len(tail) == 0 {
+		node.Children().Iter(func(k, v util.T) bool {
+			iter(k.(Value), env.getRefRecExtent(v.(*typeTreeNode)))
+			return false
+		})
+	}
+
+	if node.Leaf() {
+		staticProperties(selectRef(node.Value(), tail), iter)
+	}
+}
+
+// staticProperties calls iter for the static properties of tpe, or of the
+// object types tpe can be.
+func staticProperties(tpe types.Type, iter func(Value, types.Type)) {
+	switch tpe := tpe.(type) {
+	case *types.Object:
+		for _, p := range tpe.StaticProperties() {
+			key, err := InterfaceToValue(p.Key)
+			if err != nil {
+				continue
+			}
+			iter(key, p.Value)
+		}
+	case types.Any:
+		for _, t := range tpe {
+			staticProperties(t, iter)
+		}
+	}
+}
+
 func (env *TypeEnv) wrap() *TypeEnv {
 	cpy := *env
 	cpy.next = env
@@ -557,3 +557,88 @@ func TestTypeTreeInsert(t *testing.T) {
 		t.Fatalf("Expected %v but got %v", expected, actual)
 	}
 }
+
+func TestTypeEnvChildren(t *testing.T) {
+	c := MustCompileModules(map[string]string{
+		"a.rego": `package policies.a
+
+p := {"x": 1, "y": {"z": "s"}}
+
+q := true`,
+		"b.rego": `package policies.b.c
+
+r := "s"`,
+	})
+
+	tests := []struct {
+		note     string
+		prefix   string
+		expected map[string]types.Type
+	}{
+		{
+			note:   "packages",
+			prefix: "data.policies",
+			expected: map[string]types.Type{
+				"data.policies.a": nil,
+				"data.policies.b": nil,
+			},
+		},
+		{
+			note:   "rules",
+			prefix: "data.policies.a",
+			expected: map[string]types.Type{
+				"data.policies.a.p": nil,
+				"data.policies.a.q": types.B,
+			},
+		},
+		{
+			note:   "object keys",
+			prefix: "data.policies.a.p",
+			expected: map[string]types.Type{
+				"data.policies.a.p.x": types.N,
+				"data.policies.a.p.y": nil,
+			},
+		},
+		{
+			note:   "nested object keys",
+			prefix: "data.policies.a.p.y",
+			expected: map[string]types.Type{
+				`data.policies.a.p.y.z`: types.S,
+			},
+		},
+		{
+			note:     "scalar",
+			prefix:   "data.policies.a.q",
+			expected: map[string]types.Type{},
+		},
+		{
+			note:     "unknown",
+			prefix:   "data.foo",
+			expected: map[string]types.Type{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			result := c.TypeEnv.Children(MustParseRef(tc.prefix))
+			if len(result) != len(tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, result)
+			}
+			for i, x := range result {
+				if i > 0 && result[i-1].Ref.Compare(x.Ref) >= 0 {
+					t.Fatalf("expected sorted refs, got %v", result)
+				}
+				exp, ok := tc.expected[x.Ref.String()]
+				if !ok {
+					t.Fatalf("unexpected ref %v in %v", x.Ref, result)
+				}
+				if x.Type == nil {
+					t.Fatalf("expected type for %v", x.Ref)
+				}
+				if exp != nil && types.Compare(exp, x.Type) != 0 {
+					t.Fatalf("expected %v to be of type %v, got %v", x.Ref, exp, x.Type)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package ast
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DependencyGraph is an export of the dependencies between the rules, and
+// between the packages, of compiled modules. Rules that share a path, e.g.,
+// incrementally defined rules and functions, are represented by one node.
+// The graph can be serialized as JSON, or as DOT with the DOT method.
+type DependencyGraph struct {
+	Rules    []*DependencyGraphNode `json:"rules"`
+	Packages []*DependencyGraphNode `json:"packages"`
+}
+
+// DependencyGraphNode is a rule or a package of a DependencyGraph. Nodes are
+// identified by their path, e.g., "data.policy.allow" or "data.policy".
+type DependencyGraphNode struct {
+	Path         string      `json:"path"`
+	Package      string      `json:"package,omitempty"`
+	Locations    []*Location `json:"locations,omitempty"`
+	Dependencies []string    `json:"dependencies,omitempty"`
+	Dependents   []string    `json:"dependents,omitempty"`
+}
+
+// DependencyGraph returns the dependency graph of the compiled modules. Nodes,
+// and the dependencies and dependents of each node, are sorted by path.
+func (c *Compiler) DependencyGraph() *DependencyGraph {
+	rules := map[string]*DependencyGraphNode{}
+	packages := map[string]*DependencyGraphNode{}
+
+	node := func(nodes map[string]*DependencyGraphNode, path string) *DependencyGraphNode {
+		n, ok := nodes[path]
+		if !ok {
+			n = &DependencyGraphNode{Path: path}
+			nodes[path] = n
+		}
+		return n
+	}
+
+	for _, name := range c.sorted {
+		WalkRules(c.Modules[name], func(r *Rule) bool {
+			pkg := r.Module.Package.Path.String()
+			n := node(rules, r.Path().String())
+			n.Package = pkg
+			n.Locations = append(n.Locations, r.Location)
+			p := node(packages, pkg)
+			p.Locations = appendLocation(p.Locations, r.Module.Package.Location)
+
+			for dep := range c.Graph.Dependencies(r) {
+				d, ok := dep.(*Rule)
+				if !ok || d.Module == nil {
+					continue
+				}
+				if path := d.Path().String(); path != n.Path {
+					n.Dependencies = appendPath(n.Dependencies, path)
+				}
+				if other := d.Module.Package.Path.String(); other != pkg {
+					p.Dependencies = appendPath(p.Dependencies, other)
+				}
+			}
+			return false
+		})
+	}
+
+	g := &DependencyGraph{
+		Rules:    sortedNodes(rules),
+		Packages: sortedNodes(packages),
+	}
+	linkNodes(rules)
+	linkNodes(packages)
+	return g
+}
+
+// linkNodes sorts the dependencies of the nodes and records the dependents.
+func linkNodes(nodes map[string]*DependencyGraphNode) {
+	for _, n := range sortedNodes(nodes) {
+		sort.Strings(n.Dependencies)
+		for _, dep := range n.Dependencies {
+			if d, ok := nodes[dep]; ok {
+				d.Dependents = append(d.Dependents, n.Path)
+			}
+		}
+	}
+}
+
+// Rule returns the rule node with the given path, or nil if there is none.
+func (g *DependencyGraph) Rule(path string) *DependencyGraphNode {
+	i := sort.Search(len(g.Rules), func(i int) bool { return g.Rules[i].Path >= path })
+	if i < len(g.Rules) && g.Rules[i].Path == path {
+		return g.Rules[i]
+	}
+	return nil
+}
+
+// TransitiveDependents returns the paths of the rules that depend, directly
+// or indirectly, on the rule with the given path, i.e., the rules that may be
+// affected by a change of the rule. The paths are sorted.
+func (g *DependencyGraph) TransitiveDependents(path string) []string {
+	visited := map[string]struct{}{}
+	queue := []string{path}
+	for len(queue) > 0 {
+		n := g.Rule(queue[0])
+		queue = queue[1:]
+		if n == nil {
+			continue
+		}
+		for _, dep := range n.Dependents {
+			if _, ok := visited[dep]; !ok && dep != path {
+				visited[dep] = struct{}{}
+				queue = append(queue, dep)
+			}
+		}
+	}
+
+	result := make([]string, 0, len(visited))
+	for dep := range visited {
+		result = append(result, dep)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Unused returns the rule nodes that no other rule depends on, and that are
+// not contained in one of the entrypoints. Entrypoints are paths of rules or
+// packages, e.g., "data.policy.allow" or "data.policy".
+func (g *DependencyGraph) Unused(entrypoints ...string) []*DependencyGraphNode {
+	var result []*DependencyGraphNode
+	for _, n := range g.Rules {
+		if len(n.Dependents) > 0 {
+			continue
+		}
+		used := false
+		for _, e := range entrypoints {
+			if n.Path == e || strings.HasPrefix(n.Path, e+".") || strings.HasPrefix(n.Path, e+"[") {
+				used = true
+				break
+			}
+		}
+		if !used {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// DOT returns the graph in the DOT language of Graphviz. Rules are grouped in
+// clusters by package.
+func (g *DependencyGraph) DOT() string {
+	var buf strings.Builder
+	buf.WriteString("digraph {\n")
+	buf.WriteString("\trankdir=LR;\n")
+	buf.WriteString("\tnode [shape=box];\n")
+
+	for i, p := range g.Packages {
+		fmt.Fprintf(&buf, "\tsubgraph cluster_%d {\n", i)
+		fmt.Fprintf(&buf, "\t\tlabel=%s;\n", strconv.Quote(p.Path))
+		for _, r := range g.Rules {
+			if r.Package == p.Path {
+				label := strings.TrimPrefix(r.Path, p.Path+".")
+				fmt.Fprintf(&buf, "\t\t%s [label=%s];\n", strconv.Quote(r.Path), strconv.Quote(label))
+			}
+		}
+		buf.WriteString("\t}\n")
+	}
+
+	for _, r := range g.Rules {
+		for _, dep := range r.Dependencies {
+			fmt.Fprintf(&buf, "\t%s -> %s;\n", strconv.Quote(r.Path), strconv.Quote(dep))
+		}
+	}
+
+	buf.WriteString("}\n")
+	return buf.String()
+}
+
+func sortedNodes(nodes map[string]*DependencyGraphNode) []*DependencyGraphNode {
+	result := make([]*DependencyGraphNode, 0, len(nodes))
+	for _, n := range nodes {
+		result = append(result, n)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result
+}
+
+func appendPath(paths []string, path string) []string {
+	for _, p := range paths {
+		if p == path {
+			return paths
+		}
+	}
+	return append(paths, path)
+}
+
+func appendLocation(locs []*Location, loc *Location) []*Location {
+	if loc == nil {
+		return locs
+	}
+	for _, l := range locs {
+		if l.Equal(loc) {
+			return locs
+		}
+	}
+	return append(locs, loc)
+}
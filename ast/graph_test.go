@@ -0,0 +1,109 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package ast
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompilerDependencyGraph(t *testing.T) {
+	c := NewCompiler()
+	c.Compile(map[string]*Module{
+		"lib.rego": MustParseModuleWithOpts(`package lib
+
+default allowed := false
+
+allowed if input.user == "admin"
+
+allowed if is_root(input.user)
+
+is_root(u) if u == "root"
+
+unused := true
+`, ParserOptions{RegoVersion: RegoV1}),
+		"policy.rego": MustParseModuleWithOpts(`package policy
+
+import data.lib
+
+allow if {
+	lib.allowed
+	count(deny) == 0
+}
+
+deny contains msg if {
+	not lib.allowed
+	msg := "denied"
+}
+`, ParserOptions{RegoVersion: RegoV1}),
+	})
+	if c.Failed() {
+		t.Fatal(c.Errors)
+	}
+
+	g := c.DependencyGraph()
+
+	var paths []string
+	for _, n := range g.Rules {
+		paths = append(paths, n.Path)
+	}
+	exp := []string{"data.lib.allowed", "data.lib.is_root", "data.lib.unused", "data.policy.allow", "data.policy.deny"}
+	if !reflect.DeepEqual(paths, exp) {
+		t.Fatalf("expected rules %v, got %v", exp, paths)
+	}
+
+	allowed := g.Rule("data.lib.allowed")
+	if len(allowed.Locations) != 3 || allowed.Package != "data.lib" {
+		t.Fatalf("unexpected node: %+v", allowed)
+	}
+	if exp := []string{"data.lib.is_root"}; !reflect.DeepEqual(allowed.Dependencies, exp) {
+		t.Fatalf("expected dependencies %v, got %v", exp, allowed.Dependencies)
+	}
+	if exp := []string{"data.policy.allow", "data.policy.deny"}; !reflect.DeepEqual(allowed.Dependents, exp) {
+		t.Fatalf("expected dependents %v, got %v", exp, allowed.Dependents)
+	}
+	if exp := []string{"data.lib.allowed", "data.policy.deny"}; !reflect.DeepEqual(g.Rule("data.policy.allow").Dependencies, exp) {
+		t.Fatalf("expected dependencies %v, got %v", exp, g.Rule("data.policy.allow").Dependencies)
+	}
+
+	if len(g.Packages) != 2 || g.Packages[0].Path != "data.lib" || len(g.Packages[0].Dependencies) != 0 {
+		t.Fatalf("unexpected packages: %+v", g.Packages)
+	}
+	if exp := []string{"data.lib"}; !reflect.DeepEqual(g.Packages[1].Dependencies, exp) {
+		t.Fatalf("expected package dependencies %v, got %v", exp, g.Packages[1].Dependencies)
+	}
+	if exp := []string{"data.policy"}; !reflect.DeepEqual(g.Packages[0].Dependents, exp) {
+		t.Fatalf("expected package dependents %v, got %v", exp, g.Packages[0].Dependents)
+	}
+
+	exp = []string{"data.lib.allowed", "data.policy.allow", "data.policy.deny"}
+	if act := g.TransitiveDependents("data.lib.is_root"); !reflect.DeepEqual(act, exp) {
+		t.Fatalf("expected transitive dependents %v, got %v", exp, act)
+	}
+	if act := g.TransitiveDependents("data.missing"); len(act) != 0 {
+		t.Fatalf("expected no transitive dependents, got %v", act)
+	}
+
+	var unused []string
+	for _, n := range g.Unused("data.policy") {
+		unused = append(unused, n.Path)
+	}
+	if exp := []string{"data.lib.unused"}; !reflect.DeepEqual(unused, exp) {
+		t.Fatalf("expected unused %v, got %v", exp, unused)
+	}
+
+	dot := g.DOT()
+	for _, exp := range []string{
+		"subgraph cluster_0 {\n\t\tlabel=\"data.lib\";",
+		"\"data.lib.allowed\" [label=\"allowed\"];",
+		"\"data.policy.allow\" -> \"data.lib.allowed\";",
+		"\"data.lib.allowed\" -> \"data.lib.is_root\";",
+	} {
+		if !strings.Contains(dot, exp) {
+			t.Fatalf("expected %q in DOT output:\n%v", exp, dot)
+		}
+	}
+}
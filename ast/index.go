@@ -53,6 +53,19 @@ func (ir *IndexResult) Empty() bool {
 	return len(ir.Rules) == 0 && ir.Default == nil
 }
 
+// IndexHint describes a value that a reference indexed by a rule index is
+// frequently looked up with.
+type IndexHint struct {
+	Path  Ref   // path of the rule set that the index is built for, e.g., data.example.allow
+	Ref   Ref   // indexed reference, e.g., input.method or args[0]
+	Value Value // scalar value that the reference is frequently resolved to
+	Count int   // relative frequency of the value
+}
+
+// maxSpecializedTries is the maximum number of specialized tries built for a
+// single rule index.
+const maxSpecializedTries = 8
+
 type baseDocEqIndex struct {
 	skipIndexing   Set
 	isVirtual      func(Ref) bool
@@ -60,6 +73,8 @@ type baseDocEqIndex struct {
 	defaultRule    *Rule
 	kind           RuleKind
 	onlyGroundRefs bool
+	hints          []*IndexHint
+	specialized    *specializedTries
 }
 
 func newBaseDocEqIndex(isVirtual func(Ref) bool) *baseDocEqIndex {
@@ -126,6 +141,9 @@ func (i *baseDocEqIndex) Build(rules []*Rule) bool {
 			return false
 		})
 	}
+
+	i.specialized = newSpecializedTries(rules, indices, i.hints)
+
 	return true
 }
 
@@ -133,11 +151,19 @@ func (i *baseDocEqIndex) Lookup(resolver ValueResolver) (*IndexResult, error) {
 
 	tr := newTrieTraversalResult()
 
-	err := i.root.Traverse(resolver, tr)
+	root, specialized := i.trie(resolver)
+
+	err := root.Traverse(resolver, tr)
 	if err != nil {
 		return nil, err
 	}
 
+	if specialized {
+		// Specialized tries order references differently, so return rules in
+		// the order they were passed to Build.
+		sort.Ints(tr.ordering)
+	}
+
 	result := NewIndexResult(i.kind)
 	result.Default = i.defaultRule
 	result.OnlyGroundRefs = i.onlyGroundRefs
@@ -164,6 +190,26 @@ func (i *baseDocEqIndex) Lookup(resolver ValueResolver) (*IndexResult, error) {
 	return result, nil
 }
 
+// trie returns the trie to traverse for the resolver. If the resolver resolves
+// the reference that specialized tries were built for to one of the hinted
+// values, the specialized trie for the value is returned. Otherwise, the
+// general trie is returned.
+func (i *baseDocEqIndex) trie(resolver ValueResolver) (*trieNode, bool) {
+	if i.specialized == nil {
+		return i.root, false
+	}
+	v, err := resolver.Resolve(i.specialized.ref)
+	if err != nil || v == nil {
+		// Errors, including unknown values, are left to the traversal of the
+		// general trie.
+		return i.root, false
+	}
+	if node, ok := i.specialized.tries.Get(v); ok {
+		return node.(*trieNode), true
+	}
+	return i.root, false
+}
+
 func (i *baseDocEqIndex) AllRules(_ ValueResolver) (*IndexResult, error) {
 	tr := newTrieTraversalResult()
 
@@ -196,6 +242,158 @@ func (i *baseDocEqIndex) AllRules(_ ValueResolver) (*IndexResult, error) {
 	return result, nil
 }
 
+// specializedTries contains tries that are specialized for frequently looked up
+// values of a single indexed reference. A specialized trie only contains the
+// rules that can match when the reference resolves to its value, and it
+// orders the remaining references by how frequently they appear in those
+// rules.
+type specializedTries struct {
+	ref   Ref
+	tries *util.HashMap
+}
+
+func newSpecializedTries(rules []*Rule, indices *refindices, hints []*IndexHint) *specializedTries {
+
+	if len(hints) == 0 {
+		return nil
+	}
+
+	// Specialize on the hinted reference with the highest total count. Ties
+	// are broken by the general ordering of the references. References that
+	// rules match with mappers cannot be specialized on because lookups
+	// transform the values of such references.
+	totals := util.NewHashMap(valueEq, valueHash)
+	for _, hint := range hints {
+		if !IsScalar(hint.Value) {
+			continue
+		}
+		n, ok := totals.Get(hint.Ref)
+		if !ok {
+			n = 0
+		}
+		totals.Put(hint.Ref, n.(int)+hint.Count)
+	}
+
+	var ref Ref
+	var total int
+	for _, candidate := range indices.Sorted() {
+		n, ok := totals.Get(candidate)
+		if ok && n.(int) > total && !indices.mapped(candidate) {
+			ref, total = candidate, n.(int)
+		}
+	}
+
+	if ref == nil {
+		return nil
+	}
+
+	// Select the most frequent values of the reference.
+	counts := util.NewHashMap(valueEq, valueHash)
+	var values []Value
+	for _, hint := range hints {
+		if !hint.Ref.Equal(ref) || !IsScalar(hint.Value) {
+			continue
+		}
+		n, ok := counts.Get(hint.Value)
+		if !ok {
+			values = append(values, hint.Value)
+			n = 0
+		}
+		counts.Put(hint.Value, n.(int)+hint.Count)
+	}
+
+	sort.SliceStable(values, func(a, b int) bool {
+		ca, _ := counts.Get(values[a])
+		cb, _ := counts.Get(values[b])
+		if ca.(int) != cb.(int) {
+			return ca.(int) > cb.(int)
+		}
+		return values[a].Compare(values[b]) < 0
+	})
+
+	if len(values) > maxSpecializedTries {
+		values = values[:maxSpecializedTries]
+	}
+
+	s := &specializedTries{
+		ref:   ref,
+		tries: util.NewHashMap(valueEq, valueHash),
+	}
+
+	for _, value := range values {
+		s.tries.Put(value, s.build(rules, indices, value))
+	}
+
+	return s
+}
+
+// build returns a trie containing the rules that can match if the specialized
+// reference resolves to value.
+func (s *specializedTries) build(rules []*Rule, indices *refindices, value Value) *trieNode {
+
+	match := func(rule *Rule) bool {
+		switch v := indices.Value(rule, s.ref).(type) {
+		case nil, Var:
+			return true
+		default:
+			return v.Compare(value) == 0
+		}
+	}
+
+	// Order the remaining references by the number of matching rules that
+	// are indexed on them. Ties retain the general ordering.
+	var refs []Ref
+	var counts []int
+	for _, ref := range indices.Sorted() {
+		if ref.Equal(s.ref) {
+			continue
+		}
+		var n int
+		for idx := range rules {
+			WalkRules(rules[idx], func(rule *Rule) bool {
+				if !rule.Default && match(rule) && indices.index(rule, ref) != nil {
+					n++
+				}
+				return false
+			})
+		}
+		refs = append(refs, ref)
+		counts = append(counts, n)
+	}
+
+	order := make([]int, len(refs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return counts[order[a]] > counts[order[b]]
+	})
+
+	root := newTrieNodeImpl()
+
+	for idx := range rules {
+		var prio int
+		WalkRules(rules[idx], func(rule *Rule) bool {
+			if rule.Default {
+				return false
+			}
+			if match(rule) {
+				node := root
+				if indices.Indexed(rule) {
+					for _, i := range order {
+						node = node.Insert(refs[i], indices.Value(rule, refs[i]), indices.Mapper(rule, refs[i]))
+					}
+				}
+				node.append([...]int{idx, prio}, rule)
+			}
+			prio++
+			return false
+		})
+	}
+
+	return root
+}
+
 type ruleWalker struct {
 	result *trieTraversalResult
 }
@@ -396,6 +594,16 @@ func (i *refindices) insert(rule *Rule, index *refindex) {
 	i.rules[rule] = append(i.rules[rule], index)
 }
 
+// mapped returns true if any rule matches the reference with a mapper.
+func (i *refindices) mapped(ref Ref) bool {
+	for rule := range i.rules {
+		if i.Mapper(rule, ref) != nil {
+			return true
+		}
+	}
+	return false
+}
+
 func (i *refindices) index(rule *Rule, ref Ref) *refindex {
 	for _, index := range i.rules[rule] {
 		if index.Ref.Equal(ref) {
@@ -665,9 +873,13 @@ func (node *trieNode) traverse(resolver ValueResolver, tr *trieTraversalResult)
 		return err
 	}
 
-	for i := range node.mappers {
-		if err := node.traverseValue(resolver, tr, node.mappers[i].MapValue(v)); err != nil {
-			return err
+	// Mappers only transform strings. Traversing them with other values
+	// would visit the same children twice.
+	if _, ok := v.(String); ok {
+		for i := range node.mappers {
+			if err := node.traverseValue(resolver, tr, node.mappers[i].MapValue(v)); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -742,16 +954,26 @@ func (node *trieNode) traverseUnknown(resolver ValueResolver, tr *trieTraversalR
 		return err
 	}
 
-	var iterErr error
-	node.scalars.Iter(func(_, v util.T) bool {
-		child := v.(*trieNode)
-		if iterErr = child.traverseUnknown(resolver, tr); iterErr != nil {
-			return true
-		}
+	// Children are traversed in the order of their values so that the rules
+	// are returned in the same order every time, e.g., to keep the output of
+	// partial evaluation stable.
+	keys := make([]Value, 0, node.scalars.Len())
+	node.scalars.Iter(func(k, _ util.T) bool {
+		keys = append(keys, k.(Value))
 		return false
 	})
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Compare(keys[j]) < 0
+	})
+
+	for _, k := range keys {
+		child, _ := node.scalars.Get(k)
+		if err := child.(*trieNode).traverseUnknown(resolver, tr); err != nil {
+			return err
+		}
+	}
 
-	return iterErr
+	return nil
 }
 
 // If term `a` is one of the function's operands, we store a Ref: `args[0]`
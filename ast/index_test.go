@@ -860,6 +860,47 @@ func TestBaseDocEqIndexingPriorities(t *testing.T) {
 	}
 }
 
+func TestBaseDocEqIndexingUnknownOrder(t *testing.T) {
+
+	module := MustParseModule(`
+	package test
+
+	p { input.x = "c" }
+	p { input.x = "a" }
+	p { input.x = 1 }
+	p { input.x = "b" }
+	p { input.x = true }
+	p { input.x = "e" }
+	p { input.x = "d" }
+	`)
+
+	index := newBaseDocEqIndex(func(Ref) bool { return false })
+
+	if !index.Build(module.Rules) {
+		t.Fatalf("Expected index build to succeed")
+	}
+
+	resolver := testResolver{input: MustParseTerm(`{}`), unknownRefs: NewSet(MustParseTerm("input.x"))}
+
+	// The rules for scalar values are returned in the order of the values.
+	exp := []*Rule{module.Rules[4], module.Rules[2], module.Rules[1], module.Rules[3], module.Rules[0], module.Rules[6], module.Rules[5]}
+
+	for i := 0; i < 10; i++ {
+		result, err := index.Lookup(resolver)
+		if err != nil {
+			t.Fatalf("Unexpected error during index lookup: %v", err)
+		}
+		if len(result.Rules) != len(exp) {
+			t.Fatalf("Expected rules %v but got: %v", exp, result.Rules)
+		}
+		for j := range exp {
+			if result.Rules[j] != exp[j] {
+				t.Fatalf("Expected rules %v but got: %v", exp, result.Rules)
+			}
+		}
+	}
+}
+
 func TestBaseDocEqIndexingErrors(t *testing.T) {
 	index := newBaseDocEqIndex(func(Ref) bool {
 		return false
@@ -1401,3 +1442,213 @@ r = local0 {
 		})
 	}
 }
+
+func TestBaseDocEqIndexingHints(t *testing.T) {
+
+	module := MustParseModule(`package test
+
+	p {
+		input.method = "GET"
+		input.path = ["users"]
+	}
+
+	p {
+		input.method = "GET"
+		input.user = "alice"
+	} else {
+		input.method = "POST"
+		input.user = "bob"
+	}
+
+	p {
+		input.method = "POST"
+	}
+
+	p {
+		input.user = "alice"
+	}
+
+	p {
+		input.method = x
+		x != "DELETE"
+	}
+
+	p {
+		x = input.path
+		glob.match("a.*", ["."], x)
+	}
+
+	p {
+		input.method = ["GET"]
+	}`)
+
+	hints := []*IndexHint{
+		{Ref: MustParseRef("input.method"), Value: String("GET"), Count: 10},
+		{Ref: MustParseRef("input.method"), Value: String("POST"), Count: 5},
+		{Ref: MustParseRef("input.user"), Value: String("alice"), Count: 12},
+		{Ref: MustParseRef("input.user"), Value: MustParseTerm(`["alice"]`).Value, Count: 100},
+		{Ref: MustParseRef("input.path"), Value: String("a.b"), Count: 100},
+	}
+
+	general := newBaseDocEqIndex(func(Ref) bool { return false })
+	if !general.Build(module.Rules) {
+		t.Fatal("expected index build to succeed")
+	}
+
+	specialized := newBaseDocEqIndex(func(Ref) bool { return false })
+	specialized.hints = hints
+	if !specialized.Build(module.Rules) {
+		t.Fatal("expected index build to succeed")
+	}
+
+	// input.path is matched with a mapper and non-scalar hints are ignored,
+	// so the index specializes on input.method which has the highest total.
+	if specialized.specialized == nil || !specialized.specialized.ref.Equal(MustParseRef("input.method")) {
+		t.Fatalf("expected tries specialized on input.method but got: %v", specialized.specialized.ref)
+	}
+	if n := specialized.specialized.tries.Len(); n != 2 {
+		t.Fatalf("expected 2 specialized tries but got %d", n)
+	}
+
+	inputs := []string{
+		`{"method": "GET", "path": ["users"], "user": "alice"}`,
+		`{"method": "GET", "path": "a.b", "user": "bob"}`,
+		`{"method": "POST", "user": "bob"}`,
+		`{"method": "POST", "user": "alice"}`,
+		`{"method": "DELETE", "user": "alice"}`,
+		`{"method": ["GET"]}`,
+		`{"user": "alice"}`,
+		`{}`,
+	}
+
+	for _, input := range inputs {
+		t.Run(input, func(t *testing.T) {
+			resolver := testResolver{input: MustParseTerm(input)}
+
+			exp, err := general.Lookup(resolver)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := specialized.Lookup(resolver)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !NewRuleSet(result.Rules...).Equal(NewRuleSet(exp.Rules...)) {
+				t.Fatalf("expected rules to be %v but got: %v", exp.Rules, result.Rules)
+			}
+
+			for i := 1; i < len(result.Rules); i++ {
+				if result.Rules[i-1].Location.Row > result.Rules[i].Location.Row {
+					t.Fatalf("expected rules in declaration order but got: %v", result.Rules)
+				}
+			}
+
+			for _, rule := range exp.Rules {
+				if !NewRuleSet(result.Else[rule]...).Equal(NewRuleSet(exp.Else[rule]...)) {
+					t.Fatalf("expected else to be %v but got: %v", exp.Else[rule], result.Else[rule])
+				}
+			}
+
+			if result.EarlyExit != exp.EarlyExit {
+				t.Fatalf("expected early exit to be %v but got: %v", exp.EarlyExit, result.EarlyExit)
+			}
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		resolver := testResolver{input: MustParseTerm(`{"method": "GET"}`), unknownRefs: NewSet(MustParseTerm("input.method"))}
+
+		exp, err := general.Lookup(resolver)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := specialized.Lookup(resolver)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !NewRuleSet(result.Rules...).Equal(NewRuleSet(exp.Rules...)) {
+			t.Fatalf("expected rules to be %v but got: %v", exp.Rules, result.Rules)
+		}
+	})
+}
+
+func TestCompilerIndexHints(t *testing.T) {
+
+	module, err := ParseModule("test.rego", `package test
+
+p {
+	input.method == "GET"
+}
+
+p {
+	input.method == "POST"; input.user == "bob"
+}
+
+p {
+	input.method == "PUT"
+}
+
+f(x) {
+	x == "a"
+}
+
+q := true
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewCompiler()
+	if c.Compile(map[string]*Module{"test.rego": module}); c.Failed() {
+		t.Fatal(c.Errors)
+	}
+
+	// The counts of the expressions are keyed by row, like profiles are.
+	counts := map[int]int{4: 10, 8: 3, 16: 7}
+	hints := c.IndexHints(func(loc *Location) int {
+		if loc.File != "test.rego" {
+			return 0
+		}
+		return counts[loc.Row]
+	})
+
+	exp := []*IndexHint{
+		{Path: MustParseRef("data.test.f"), Ref: MustParseRef("args[0]"), Value: String("a"), Count: 7},
+		{Path: MustParseRef("data.test.p"), Ref: MustParseRef("input.method"), Value: String("GET"), Count: 10},
+		{Path: MustParseRef("data.test.p"), Ref: MustParseRef("input.method"), Value: String("POST"), Count: 3},
+		{Path: MustParseRef("data.test.p"), Ref: MustParseRef("input.user"), Value: String("bob"), Count: 3},
+	}
+
+	if len(hints) != len(exp) {
+		t.Fatalf("expected %d hints but got %d: %v", len(exp), len(hints), hints)
+	}
+
+	for i := range exp {
+		if !hints[i].Path.Equal(exp[i].Path) || !hints[i].Ref.Equal(exp[i].Ref) || hints[i].Value.Compare(exp[i].Value) != 0 || hints[i].Count != exp[i].Count {
+			t.Errorf("expected hint %d to be %v but got %v", i, exp[i], hints[i])
+		}
+	}
+
+	c = NewCompiler().WithIndexHints(hints)
+	if c.Compile(map[string]*Module{"test.rego": module}); c.Failed() {
+		t.Fatal(c.Errors)
+	}
+
+	index := c.RuleIndex(MustParseRef("data.test.p")).(*baseDocEqIndex)
+	if index.specialized == nil || !index.specialized.ref.Equal(MustParseRef("input.method")) || index.specialized.tries.Len() != 2 {
+		t.Fatalf("expected index to be specialized on input.method but got: %v", index.specialized)
+	}
+
+	result, err := index.Lookup(testResolver{input: MustParseTerm(`{"method": "GET"}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Rules) != 1 || !result.Rules[0].Equal(c.Modules["test.rego"].Rules[0]) {
+		t.Fatalf("expected first rule but got: %v", result.Rules)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package ast
+
+import (
+	"sync"
+)
+
+// InternPool deduplicates the string terms of the values converted with it,
+// e.g., the keys and enum-like values that the inputs of a server share. The
+// pool is safe for concurrent use, and a nil pool converts values like
+// InterfaceToValue and LazyObject.
+//
+// The terms of the pool are shared by all the values converted with it, so
+// they must not be modified.
+type InternPool struct {
+	maxEntries int
+	maxLength  int
+
+	mtx      sync.RWMutex
+	current  map[string]*Term
+	previous map[string]*Term
+}
+
+// NewInternPool returns a new pool that holds up to maxEntries strings of up
+// to maxLength bytes. Longer strings are not interned. When the pool is full,
+// the strings that were not used since it was last full are dropped.
+func NewInternPool(maxEntries, maxLength int) *InternPool {
+	return &InternPool{
+		maxEntries: maxEntries,
+		maxLength:  maxLength,
+		current:    map[string]*Term{},
+	}
+}
+
+// StringTerm returns a term of s, shared with the previous calls for s while
+// the pool holds it.
+func (p *InternPool) StringTerm(s string) *Term {
+	if p == nil || len(s) > p.maxLength || p.maxEntries <= 0 {
+		return StringTerm(s)
+	}
+
+	p.mtx.RLock()
+	t, ok := p.current[s]
+	p.mtx.RUnlock()
+	if ok {
+		return t
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if t, ok := p.current[s]; ok {
+		return t
+	}
+
+	// The pool holds two generations of half its size each: the strings of
+	// the previous generation that are still used are moved to the current
+	// one, and the others are dropped with it.
+	t, ok = p.previous[s]
+	if ok {
+		delete(p.previous, s)
+	} else {
+		t = StringTerm(s)
+	}
+	if 2*len(p.current) >= p.maxEntries {
+		p.previous = p.current
+		p.current = make(map[string]*Term, len(p.previous))
+	}
+	p.current[s] = t
+	return t
+}
+
+// Len returns the number of strings held by the pool.
+func (p *InternPool) Len() int {
+	if p == nil {
+		return 0
+	}
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+	return len(p.current) + len(p.previous)
+}
+
+// InterfaceToValue converts x like InterfaceToValue, with the string terms
+// of the pool.
+func (p *InternPool) InterfaceToValue(x interface{}) (Value, error) {
+	return interfaceToValue(x, p)
+}
+
+// LazyObject returns a lazy object like LazyObject, that converts its values
+// with the string terms of the pool.
+func (p *InternPool) LazyObject(blob map[string]interface{}) Object {
+	return &lazyObj{native: blob, cache: map[string]Value{}, pool: p}
+}
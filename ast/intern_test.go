@@ -0,0 +1,107 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package ast
+
+import (
+	"testing"
+
+	"github.com/open-policy-agent/opa/util"
+)
+
+func TestInternPoolStringTerm(t *testing.T) {
+	p := NewInternPool(4, 3)
+
+	if p.StringTerm("a") != p.StringTerm("a") {
+		t.Fatal("Expected terms of the same string to be shared")
+	}
+	if p.StringTerm("long") == p.StringTerm("long") {
+		t.Fatal("Expected strings longer than the max length not to be interned")
+	}
+
+	// "a" is used again after the pool was full, so it outlives "b".
+	a := p.StringTerm("a")
+	b := p.StringTerm("b")
+	p.StringTerm("c")
+	p.StringTerm("a")
+	p.StringTerm("d")
+	p.StringTerm("e")
+
+	if p.Len() > 4 {
+		t.Fatalf("Expected at most 4 strings but got %d", p.Len())
+	}
+	if p.StringTerm("a") != a {
+		t.Fatal("Expected used string to be kept")
+	}
+	if p.StringTerm("b") == b {
+		t.Fatal("Expected unused string to be dropped")
+	}
+
+	var nilPool *InternPool
+	if nilPool.StringTerm("a").Value.Compare(String("a")) != 0 || nilPool.Len() != 0 {
+		t.Fatal("Expected nil pool to return plain terms")
+	}
+}
+
+func TestInternPoolInterfaceToValue(t *testing.T) {
+	p := NewInternPool(100, 64)
+
+	var x1, x2 interface{}
+	if err := util.UnmarshalJSON([]byte(`{"method": "GET", "tags": ["GET"], "nested": {"method": "GET"}}`), &x1); err != nil {
+		t.Fatal(err)
+	}
+	if err := util.UnmarshalJSON([]byte(`{"method": "GET"}`), &x2); err != nil {
+		t.Fatal(err)
+	}
+
+	v1, err := p.InterfaceToValue(x1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := p.InterfaceToValue(x2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v1.Compare(MustInterfaceToValue(x1)) != 0 {
+		t.Fatalf("Expected %v but got %v", MustInterfaceToValue(x1), v1)
+	}
+
+	method := p.StringTerm("method")
+	get := p.StringTerm("GET")
+	if v2.(Object).Get(method) != get {
+		t.Fatal("Expected values to be shared across conversions")
+	}
+	if v1.(Object).Get(StringTerm("tags")).Value.(*Array).Elem(0) != get {
+		t.Fatal("Expected array elements to be shared")
+	}
+
+	var key *Term
+	v1.(Object).Get(StringTerm("nested")).Value.(Object).Foreach(func(k, _ *Term) { key = k })
+	if key != method {
+		t.Fatal("Expected keys to be shared")
+	}
+}
+
+func TestInternPoolLazyObject(t *testing.T) {
+	p := NewInternPool(100, 64)
+
+	var x map[string]interface{}
+	if err := util.UnmarshalJSON([]byte(`{"user": {"role": "admin"}}`), &x); err != nil {
+		t.Fatal(err)
+	}
+
+	obj := p.LazyObject(x)
+	user := obj.Get(StringTerm("user")).Value.(Object)
+	if user.Keys()[0] != p.StringTerm("role") {
+		t.Fatal("Expected keys of nested lazy objects to be shared")
+	}
+
+	if obj.Compare(MustInterfaceToValue(x)) != 0 {
+		t.Fatalf("Expected %v but got %v", MustInterfaceToValue(x), obj)
+	}
+	if user.Copy().Keys()[0] != p.StringTerm("role") {
+		t.Fatal("Expected copies to keep the pool")
+	}
+}
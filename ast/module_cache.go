@@ -0,0 +1,807 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package ast
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/open-policy-agent/opa/ast/internal/tokens"
+)
+
+// ModuleCache stores parsed modules, keyed by the module contents and the
+// parser options. Parsing a module found in the cache only decodes the cached
+// module. Since the keys do not cover the OPA version, implementations that
+// persist entries must not share them between OPA versions. Implementations
+// must be safe for concurrent use.
+type ModuleCache interface {
+	// Get returns the value stored for key, if any.
+	Get(key string) ([]byte, bool)
+
+	// Put stores value for key.
+	Put(key string, value []byte)
+}
+
+// moduleCacheFormat is the version of the encoding of cached modules. It must
+// be incremented whenever the encoding changes.
+const moduleCacheFormat = 1
+
+// parseModuleCached returns the module cached for filename, input and popts,
+// or parses and caches it. Modules with annotations are not cached, since the
+// annotation values cannot be encoded losslessly.
+func parseModuleCached(filename, input string, popts ParserOptions) (*Module, error) {
+	cache := popts.ModuleCache
+	key := moduleCacheKey(filename, input, popts)
+
+	if bs, ok := cache.Get(key); ok {
+		// NOTE: entries that cannot be decoded, e.g., because they were
+		// truncated, are replaced below.
+		if mod, err := decodeModule(bs, filename, input); err == nil {
+			return mod, nil
+		}
+	}
+
+	popts.ModuleCache = nil
+	mod, err := ParseModuleWithOpts(filename, input, popts)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(mod.Annotations) == 0 {
+		if bs, err := encodeModule(mod, filename, input); err == nil {
+			cache.Put(key, bs)
+		}
+	}
+
+	return mod, nil
+}
+
+// moduleCacheKey returns the hash of everything that affects the result of
+// parsing input.
+func moduleCacheKey(filename, input string, popts ParserOptions) string {
+	h := sha256.New()
+	writeKey := func(s string) {
+		var n [binary.MaxVarintLen64]byte
+		h.Write(n[:binary.PutUvarint(n[:], uint64(len(s)))])
+		h.Write([]byte(s))
+	}
+	writeKeys := func(ss []string) {
+		writeKey(strconv.Itoa(len(ss)))
+		for _, s := range ss {
+			writeKey(s)
+		}
+	}
+
+	writeKey(strconv.Itoa(moduleCacheFormat))
+	writeKey(filename)
+	writeKey(input)
+	writeKey(strconv.Itoa(int(popts.RegoVersion)))
+	writeKey(strconv.FormatBool(popts.ProcessAnnotation))
+	writeKey(strconv.FormatBool(popts.AllFutureKeywords))
+	writeKey(strconv.FormatBool(popts.SkipRules))
+	writeKey(strconv.FormatBool(popts.unreleasedKeywords))
+	writeKeys(popts.FutureKeywords)
+
+	// Only the future keywords and features of the capabilities affect the
+	// parser. Without capabilities, the parser uses the capabilities of this
+	// version.
+	if popts.Capabilities != nil {
+		writeKeys(popts.Capabilities.FutureKeywords)
+		writeKeys(popts.Capabilities.Features)
+	} else {
+		writeKey("")
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+var errModuleCacheEncoding = errors.New("module cannot be cached")
+
+// Values of the kinds of encoded values and expression terms.
+const (
+	encNull byte = iota
+	encFalse
+	encTrue
+	encNumber
+	encString
+	encVar
+	encRef
+	encArray
+	encSet
+	encObject
+	encArrayComprehension
+	encSetComprehension
+	encObjectComprehension
+	encCall
+)
+
+const (
+	encExprTerm byte = iota
+	encExprCall
+	encExprSomeDecl
+	encExprEvery
+)
+
+// moduleEncoder encodes modules in a compact binary format that preserves
+// the unexported parser state of the nodes, and the sharing of terms and
+// locations between nodes. Location texts that are found at the location's
+// offset in the input are encoded as references into the input.
+type moduleEncoder struct {
+	buf      []byte
+	filename string
+	input    string
+	terms    map[*Term]uint64
+	locs     map[*Location]uint64
+}
+
+func encodeModule(mod *Module, filename, input string) ([]byte, error) {
+	e := &moduleEncoder{
+		filename: filename,
+		input:    input,
+		terms:    map[*Term]uint64{},
+		locs:     map[*Location]uint64{},
+	}
+
+	e.uvarint(moduleCacheFormat)
+	e.uvarint(uint64(mod.regoVersion))
+
+	e.location(mod.Package.Location)
+	if err := e.termSlice(mod.Package.Path); err != nil {
+		return nil, err
+	}
+
+	e.uvarint(uint64(len(mod.Imports)))
+	for _, imp := range mod.Imports {
+		e.location(imp.Location)
+		if err := e.term(imp.Path); err != nil {
+			return nil, err
+		}
+		e.string(string(imp.Alias))
+	}
+
+	e.uvarint(uint64(len(mod.Rules)))
+	for _, rule := range mod.Rules {
+		if err := e.rule(rule); err != nil {
+			return nil, err
+		}
+	}
+
+	e.uvarint(uint64(len(mod.Comments)))
+	for _, c := range mod.Comments {
+		e.bytes(c.Text)
+		e.location(c.Location)
+	}
+
+	return e.buf, nil
+}
+
+func (e *moduleEncoder) uvarint(n uint64) {
+	e.buf = binary.AppendUvarint(e.buf, n)
+}
+
+func (e *moduleEncoder) int(n int) {
+	e.buf = binary.AppendVarint(e.buf, int64(n))
+}
+
+func (e *moduleEncoder) bool(b bool) {
+	if b {
+		e.buf = append(e.buf, 1)
+	} else {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *moduleEncoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *moduleEncoder) bytes(bs []byte) {
+	if bs == nil {
+		e.uvarint(0)
+		return
+	}
+	e.uvarint(uint64(len(bs)) + 1)
+	e.buf = append(e.buf, bs...)
+}
+
+// location encodes 0 for nil, 1 followed by the location for a location seen
+// for the first time, and 2+i for the i-th location seen before.
+func (e *moduleEncoder) location(loc *Location) {
+	if loc == nil {
+		e.uvarint(0)
+		return
+	}
+	if i, ok := e.locs[loc]; ok {
+		e.uvarint(2 + i)
+		return
+	}
+	e.locs[loc] = uint64(len(e.locs))
+	e.uvarint(1)
+
+	switch {
+	case loc.Text == nil:
+		e.uvarint(0)
+	case loc.Offset >= 0 && loc.Offset+len(loc.Text) <= len(e.input) && e.input[loc.Offset:loc.Offset+len(loc.Text)] == string(loc.Text):
+		e.uvarint(1)
+		e.uvarint(uint64(len(loc.Text)))
+	default:
+		e.uvarint(2)
+		e.bytes(loc.Text)
+	}
+
+	if loc.File == e.filename {
+		e.bool(true)
+	} else {
+		e.bool(false)
+		e.string(loc.File)
+	}
+	e.int(loc.Row)
+	e.int(loc.Col)
+	e.int(loc.Offset)
+}
+
+// term encodes terms like locations, so that terms shared between nodes are
+// shared after decoding as well.
+func (e *moduleEncoder) term(t *Term) error {
+	if t == nil {
+		e.uvarint(0)
+		return nil
+	}
+	if i, ok := e.terms[t]; ok {
+		e.uvarint(2 + i)
+		return nil
+	}
+	e.terms[t] = uint64(len(e.terms))
+	e.uvarint(1)
+	e.location(t.Location)
+	return e.value(t.Value)
+}
+
+// termSlice encodes 0 for nil, and n+1 followed by the terms otherwise.
+func (e *moduleEncoder) termSlice(ts []*Term) error {
+	if ts == nil {
+		e.uvarint(0)
+		return nil
+	}
+	e.uvarint(uint64(len(ts)) + 1)
+	for _, t := range ts {
+		if err := e.term(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *moduleEncoder) value(v Value) error {
+	switch v := v.(type) {
+	case Null:
+		e.buf = append(e.buf, encNull)
+	case Boolean:
+		if v {
+			e.buf = append(e.buf, encTrue)
+		} else {
+			e.buf = append(e.buf, encFalse)
+		}
+	case Number:
+		e.buf = append(e.buf, encNumber)
+		e.string(string(v))
+	case String:
+		e.buf = append(e.buf, encString)
+		e.string(string(v))
+	case Var:
+		e.buf = append(e.buf, encVar)
+		e.string(string(v))
+	case Ref:
+		e.buf = append(e.buf, encRef)
+		return e.termSlice(v)
+	case Call:
+		e.buf = append(e.buf, encCall)
+		return e.termSlice(v)
+	case *Array:
+		e.buf = append(e.buf, encArray)
+		e.uvarint(uint64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			if err := e.term(v.Elem(i)); err != nil {
+				return err
+			}
+		}
+	case Set:
+		e.buf = append(e.buf, encSet)
+		e.uvarint(uint64(v.Len()))
+		return v.Iter(e.term)
+	case Object:
+		e.buf = append(e.buf, encObject)
+		e.uvarint(uint64(v.Len()))
+		return v.Iter(func(k, v *Term) error {
+			if err := e.term(k); err != nil {
+				return err
+			}
+			return e.term(v)
+		})
+	case *ArrayComprehension:
+		e.buf = append(e.buf, encArrayComprehension)
+		if err := e.term(v.Term); err != nil {
+			return err
+		}
+		return e.body(v.Body)
+	case *SetComprehension:
+		e.buf = append(e.buf, encSetComprehension)
+		if err := e.term(v.Term); err != nil {
+			return err
+		}
+		return e.body(v.Body)
+	case *ObjectComprehension:
+		e.buf = append(e.buf, encObjectComprehension)
+		if err := e.term(v.Key); err != nil {
+			return err
+		}
+		if err := e.term(v.Value); err != nil {
+			return err
+		}
+		return e.body(v.Body)
+	default:
+		return fmt.Errorf("%w: unexpected value %T", errModuleCacheEncoding, v)
+	}
+	return nil
+}
+
+func (e *moduleEncoder) body(body Body) error {
+	if body == nil {
+		e.uvarint(0)
+		return nil
+	}
+	e.uvarint(uint64(len(body)) + 1)
+	for _, expr := range body {
+		if err := e.expr(expr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *moduleEncoder) expr(expr *Expr) error {
+	e.location(expr.Location)
+	e.int(expr.Index)
+	e.bool(expr.Generated)
+	e.bool(expr.Negated)
+
+	e.uvarint(uint64(len(expr.With)))
+	for _, w := range expr.With {
+		e.location(w.Location)
+		if err := e.term(w.Target); err != nil {
+			return err
+		}
+		if err := e.term(w.Value); err != nil {
+			return err
+		}
+	}
+
+	switch ts := expr.Terms.(type) {
+	case *Term:
+		e.buf = append(e.buf, encExprTerm)
+		return e.term(ts)
+	case []*Term:
+		e.buf = append(e.buf, encExprCall)
+		return e.termSlice(ts)
+	case *SomeDecl:
+		e.buf = append(e.buf, encExprSomeDecl)
+		e.location(ts.Location)
+		return e.termSlice(ts.Symbols)
+	case *Every:
+		e.buf = append(e.buf, encExprEvery)
+		e.location(ts.Location)
+		for _, t := range []*Term{ts.Key, ts.Value, ts.Domain} {
+			if err := e.term(t); err != nil {
+				return err
+			}
+		}
+		return e.body(ts.Body)
+	default:
+		return fmt.Errorf("%w: unexpected expression terms %T", errModuleCacheEncoding, ts)
+	}
+}
+
+func (e *moduleEncoder) rule(rule *Rule) error {
+	if len(rule.Annotations) > 0 {
+		return fmt.Errorf("%w: rule annotations", errModuleCacheEncoding)
+	}
+
+	e.location(rule.Location)
+	e.bool(rule.Default)
+	e.bool(rule.generatedBody)
+
+	head := rule.Head
+	e.location(head.Location)
+	e.string(string(head.Name))
+	if err := e.termSlice(head.Reference); err != nil {
+		return err
+	}
+	if err := e.termSlice(head.Args); err != nil {
+		return err
+	}
+	if err := e.term(head.Key); err != nil {
+		return err
+	}
+	if err := e.term(head.Value); err != nil {
+		return err
+	}
+	e.bool(head.Assign)
+	e.bool(head.generatedValue)
+	e.uvarint(uint64(len(head.keywords)))
+	for _, kw := range head.keywords {
+		e.int(int(kw))
+	}
+
+	if err := e.body(rule.Body); err != nil {
+		return err
+	}
+
+	if rule.Else == nil {
+		e.bool(false)
+		return nil
+	}
+	e.bool(true)
+	return e.rule(rule.Else)
+}
+
+// moduleDecoder decodes modules encoded by moduleEncoder.
+type moduleDecoder struct {
+	buf      []byte
+	filename string
+	input    string
+	terms    []*Term
+	locs     []*Location
+	err      error
+}
+
+func decodeModule(bs []byte, filename, input string) (*Module, error) {
+	d := &moduleDecoder{buf: bs, filename: filename, input: input}
+
+	if format := d.uvarint(); format != moduleCacheFormat {
+		return nil, fmt.Errorf("unexpected module cache format %d", format)
+	}
+
+	mod := &Module{regoVersion: RegoVersion(d.uvarint())}
+
+	mod.Package = &Package{Location: d.location(), Path: d.termSlice()}
+
+	if n := d.length(); n > 0 {
+		mod.Imports = make([]*Import, n)
+		for i := range mod.Imports {
+			mod.Imports[i] = &Import{Location: d.location(), Path: d.term(), Alias: Var(d.string())}
+		}
+	}
+
+	if n := d.length(); n > 0 {
+		mod.Rules = make([]*Rule, n)
+		for i := range mod.Rules {
+			mod.Rules[i] = d.rule()
+			if d.err == nil {
+				setRuleModule(mod.Rules[i], mod)
+			}
+		}
+	}
+
+	if n := d.length(); n > 0 {
+		mod.Comments = make([]*Comment, n)
+		for i := range mod.Comments {
+			mod.Comments[i] = &Comment{Text: d.bytes(), Location: d.location()}
+		}
+	}
+
+	if d.err == nil && len(d.buf) > 0 {
+		d.err = fmt.Errorf("unexpected trailing bytes in cached module")
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return mod, nil
+}
+
+func (d *moduleDecoder) fail(f string, a ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("malformed cached module: "+f, a...)
+	}
+}
+
+func (d *moduleDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	n, size := binary.Uvarint(d.buf)
+	if size <= 0 {
+		d.fail("invalid uvarint")
+		return 0
+	}
+	d.buf = d.buf[size:]
+	return n
+}
+
+func (d *moduleDecoder) int() int {
+	if d.err != nil {
+		return 0
+	}
+	n, size := binary.Varint(d.buf)
+	if size <= 0 {
+		d.fail("invalid varint")
+		return 0
+	}
+	d.buf = d.buf[size:]
+	return int(n)
+}
+
+// length returns a length read from the input. Since every element takes at
+// least one byte, lengths are checked against the remaining input so that
+// corrupt entries cannot cause large allocations.
+func (d *moduleDecoder) length() int {
+	n := d.uvarint()
+	if n > uint64(len(d.buf)) {
+		d.fail("invalid length %d", n)
+		return 0
+	}
+	return int(n)
+}
+
+func (d *moduleDecoder) bool() bool {
+	return d.byte() == 1
+}
+
+func (d *moduleDecoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.buf) == 0 {
+		d.fail("unexpected end")
+		return 0
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b
+}
+
+func (d *moduleDecoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if len(d.buf) < n {
+		d.fail("unexpected end")
+		return nil
+	}
+	bs := d.buf[:n:n]
+	d.buf = d.buf[n:]
+	return bs
+}
+
+func (d *moduleDecoder) string() string {
+	return string(d.next(d.length()))
+}
+
+func (d *moduleDecoder) bytes() []byte {
+	n := d.length()
+	if n == 0 {
+		return nil
+	}
+	bs := d.next(n - 1)
+	if bs == nil {
+		return nil
+	}
+	cpy := make([]byte, len(bs))
+	copy(cpy, bs)
+	return cpy
+}
+
+func (d *moduleDecoder) location() *Location {
+	switch tag := d.uvarint(); {
+	case d.err != nil || tag == 0:
+		return nil
+	case tag == 1:
+	default:
+		if i := tag - 2; i < uint64(len(d.locs)) {
+			return d.locs[i]
+		}
+		d.fail("invalid location reference %d", tag)
+		return nil
+	}
+
+	loc := &Location{}
+	d.locs = append(d.locs, loc)
+
+	var textLen uint64
+	switch kind := d.uvarint(); kind {
+	case 0:
+	case 1:
+		textLen = d.uvarint()
+	case 2:
+		loc.Text = d.bytes()
+	default:
+		d.fail("invalid location text %d", kind)
+	}
+
+	if d.bool() {
+		loc.File = d.filename
+	} else {
+		loc.File = d.string()
+	}
+	loc.Row = d.int()
+	loc.Col = d.int()
+	loc.Offset = d.int()
+
+	if textLen > 0 {
+		if loc.Offset < 0 || uint64(loc.Offset)+textLen > uint64(len(d.input)) {
+			d.fail("invalid location text reference")
+			return nil
+		}
+		loc.Text = []byte(d.input[loc.Offset : loc.Offset+int(textLen)])
+	}
+
+	return loc
+}
+
+func (d *moduleDecoder) term() *Term {
+	switch tag := d.uvarint(); {
+	case d.err != nil || tag == 0:
+		return nil
+	case tag == 1:
+	default:
+		if i := tag - 2; i < uint64(len(d.terms)) {
+			return d.terms[i]
+		}
+		d.fail("invalid term reference %d", tag)
+		return nil
+	}
+
+	t := &Term{}
+	d.terms = append(d.terms, t)
+	t.Location = d.location()
+	t.Value = d.value()
+	return t
+}
+
+func (d *moduleDecoder) termSlice() []*Term {
+	n := d.length()
+	if n == 0 {
+		return nil
+	}
+	ts := make([]*Term, n-1)
+	for i := range ts {
+		ts[i] = d.term()
+	}
+	return ts
+}
+
+func (d *moduleDecoder) value() Value {
+	switch kind := d.byte(); kind {
+	case encNull:
+		return Null{}
+	case encFalse:
+		return Boolean(false)
+	case encTrue:
+		return Boolean(true)
+	case encNumber:
+		return Number(d.string())
+	case encString:
+		return String(d.string())
+	case encVar:
+		return Var(d.string())
+	case encRef:
+		return Ref(d.termSlice())
+	case encCall:
+		return Call(d.termSlice())
+	case encArray:
+		ts := make([]*Term, d.length())
+		for i := range ts {
+			ts[i] = d.term()
+		}
+		return NewArray(ts...)
+	case encSet:
+		n := d.length()
+		s := NewSet()
+		for i := 0; i < n && d.err == nil; i++ {
+			s.Add(d.term())
+		}
+		return s
+	case encObject:
+		n := d.length()
+		obj := NewObject()
+		for i := 0; i < n && d.err == nil; i++ {
+			k, v := d.term(), d.term()
+			if d.err == nil {
+				obj.Insert(k, v)
+			}
+		}
+		return obj
+	case encArrayComprehension:
+		return &ArrayComprehension{Term: d.term(), Body: d.body()}
+	case encSetComprehension:
+		return &SetComprehension{Term: d.term(), Body: d.body()}
+	case encObjectComprehension:
+		return &ObjectComprehension{Key: d.term(), Value: d.term(), Body: d.body()}
+	default:
+		d.fail("invalid value kind %d", kind)
+		return Null{}
+	}
+}
+
+func (d *moduleDecoder) body() Body {
+	n := d.length()
+	if n == 0 {
+		return nil
+	}
+	body := make(Body, n-1)
+	for i := range body {
+		body[i] = d.expr()
+	}
+	return body
+}
+
+func (d *moduleDecoder) expr() *Expr {
+	expr := &Expr{
+		Location:  d.location(),
+		Index:     d.int(),
+		Generated: d.bool(),
+		Negated:   d.bool(),
+	}
+
+	if n := d.length(); n > 0 {
+		expr.With = make([]*With, n)
+		for i := range expr.With {
+			expr.With[i] = &With{Location: d.location(), Target: d.term(), Value: d.term()}
+		}
+	}
+
+	switch kind := d.byte(); kind {
+	case encExprTerm:
+		expr.Terms = d.term()
+	case encExprCall:
+		expr.Terms = d.termSlice()
+	case encExprSomeDecl:
+		expr.Terms = &SomeDecl{Location: d.location(), Symbols: d.termSlice()}
+	case encExprEvery:
+		expr.Terms = &Every{Location: d.location(), Key: d.term(), Value: d.term(), Domain: d.term(), Body: d.body()}
+	default:
+		d.fail("invalid expression kind %d", kind)
+	}
+
+	return expr
+}
+
+func (d *moduleDecoder) rule() *Rule {
+	rule := &Rule{
+		Location:      d.location(),
+		Default:       d.bool(),
+		generatedBody: d.bool(),
+	}
+
+	head := &Head{
+		Location:  d.location(),
+		Name:      Var(d.string()),
+		Reference: d.termSlice(),
+		Args:      d.termSlice(),
+		Key:       d.term(),
+		Value:     d.term(),
+		Assign:    d.bool(),
+	}
+	head.generatedValue = d.bool()
+	if n := d.length(); n > 0 {
+		head.keywords = make([]tokens.Token, n)
+		for i := range head.keywords {
+			head.keywords[i] = tokens.Token(d.int())
+		}
+	}
+	rule.Head = head
+
+	rule.Body = d.body()
+
+	if d.bool() && d.err == nil {
+		rule.Else = d.rule()
+	}
+
+	return rule
+}
@@ -0,0 +1,223 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package ast
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type testModuleCache struct {
+	sync.Mutex
+	entries    map[string][]byte
+	gets, hits int
+}
+
+func newTestModuleCache() *testModuleCache {
+	return &testModuleCache{entries: map[string][]byte{}}
+}
+
+func (c *testModuleCache) Get(key string) ([]byte, bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.gets++
+	bs, ok := c.entries[key]
+	if ok {
+		c.hits++
+	}
+	return bs, ok
+}
+
+func (c *testModuleCache) Put(key string, value []byte) {
+	c.Lock()
+	defer c.Unlock()
+	c.entries[key] = value
+}
+
+// moduleLocations returns the locations of all nodes of mod, in the order
+// they are visited.
+func moduleLocations(mod *Module) []string {
+	var locs []string
+	NewGenericVisitor(func(x interface{}) bool {
+		if n, ok := x.(Node); ok && !reflect.ValueOf(n).IsNil() {
+			if loc := n.Loc(); loc != nil {
+				locs = append(locs, fmt.Sprintf("%T %v:%d:%d:%d %q", n, loc.File, loc.Row, loc.Col, loc.Offset, loc.Text))
+			}
+		}
+		return false
+	}).Walk(mod)
+	return locs
+}
+
+func TestModuleCacheRoundTrip(t *testing.T) {
+	files, err := filepath.Glob("../format/testfiles/*.rego")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1Files, err := filepath.Glob("../format/testfiles/rego_v1/*.rego")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var count int
+	for _, tc := range []struct {
+		files []string
+		popts ParserOptions
+	}{
+		{files: files, popts: ParserOptions{RegoVersion: RegoV0}},
+		{files: v1Files, popts: ParserOptions{RegoVersion: RegoV1}},
+	} {
+		for _, file := range tc.files {
+			bs, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			exp, err := ParseModuleWithOpts(file, string(bs), tc.popts)
+			if err != nil || exp == nil || len(exp.Annotations) > 0 {
+				continue
+			}
+
+			enc, err := encodeModule(exp, file, string(bs))
+			if err != nil {
+				t.Fatalf("%v: unexpected encoding error: %v", file, err)
+			}
+			act, err := decodeModule(enc, file, string(bs))
+			if err != nil {
+				t.Fatalf("%v: unexpected decoding error: %v", file, err)
+			}
+
+			if !exp.Equal(act) {
+				t.Fatalf("%v: expected:\n\n%v\n\ngot:\n\n%v", file, exp, act)
+			}
+			if exp.String() != act.String() {
+				t.Fatalf("%v: expected:\n\n%v\n\ngot:\n\n%v", file, exp, act)
+			}
+			if exp.RegoVersion() != act.RegoVersion() {
+				t.Fatalf("%v: expected rego version %v, got %v", file, exp.RegoVersion(), act.RegoVersion())
+			}
+			if e, a := moduleLocations(exp), moduleLocations(act); !reflect.DeepEqual(e, a) {
+				t.Fatalf("%v: expected locations:\n\n%v\n\ngot:\n\n%v", file, e, a)
+			}
+			for i := range exp.Rules {
+				if act.Rules[i].Module != act {
+					t.Fatalf("%v: expected rule %d to refer to the decoded module", file, i)
+				}
+			}
+
+			count++
+		}
+	}
+
+	if count < 20 {
+		t.Fatalf("expected at least 20 modules to round-trip, got %d", count)
+	}
+}
+
+func TestModuleCacheParse(t *testing.T) {
+	cache := newTestModuleCache()
+	popts := ParserOptions{RegoVersion: RegoV1, ModuleCache: cache}
+
+	module := `package test
+
+import data.foo
+
+p contains x if {
+	some x in input.xs
+	x > data.foo.min
+}
+
+q := {"a": [1, 2], "b": {true}} if every y in input.ys { y != null }
+
+r := 1 if false else := 2
+`
+
+	exp := MustParseModuleWithOpts(module, ParserOptions{RegoVersion: RegoV1})
+
+	for i := 0; i < 2; i++ {
+		act, err := ParseModuleWithOpts("test.rego", module, popts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exp.Equal(act) {
+			t.Fatalf("expected:\n\n%v\n\ngot:\n\n%v", exp, act)
+		}
+	}
+
+	if cache.gets != 2 || cache.hits != 1 || len(cache.entries) != 1 {
+		t.Fatalf("expected one miss and one hit, got %d gets, %d hits, %d entries", cache.gets, cache.hits, len(cache.entries))
+	}
+
+	// A different version of the module, or different options, are not
+	// served from the cache.
+	if _, err := ParseModuleWithOpts("test.rego", module+"\ns := 3\n", popts); err != nil {
+		t.Fatal(err)
+	}
+	popts.AllFutureKeywords = true
+	if _, err := ParseModuleWithOpts("test.rego", module, popts); err != nil {
+		t.Fatal(err)
+	}
+	if cache.hits != 1 || len(cache.entries) != 3 {
+		t.Fatalf("expected no further hits, got %d hits, %d entries", cache.hits, len(cache.entries))
+	}
+
+	// Parse errors are returned, and not cached.
+	if _, err := ParseModuleWithOpts("test.rego", "package test\n\np :=", popts); err == nil {
+		t.Fatal("expected error")
+	}
+	if len(cache.entries) != 3 {
+		t.Fatalf("expected no entry for the invalid module, got %d entries", len(cache.entries))
+	}
+}
+
+func TestModuleCacheCorruptEntries(t *testing.T) {
+	cache := newTestModuleCache()
+	popts := ParserOptions{RegoVersion: RegoV1, ModuleCache: cache}
+	module := "package test\n\np := 1\n"
+
+	if _, err := ParseModuleWithOpts("test.rego", module, popts); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, bs := range cache.entries {
+		for i := 0; i < len(bs); i++ {
+			cache.entries[key] = bs[:i]
+			mod, err := ParseModuleWithOpts("test.rego", module, popts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mod.String() != MustParseModuleWithOpts(module, ParserOptions{RegoVersion: RegoV1}).String() {
+				t.Fatalf("unexpected module after truncating entry to %d bytes: %v", i, mod)
+			}
+		}
+	}
+}
+
+func TestModuleCacheAnnotations(t *testing.T) {
+	cache := newTestModuleCache()
+	popts := ParserOptions{RegoVersion: RegoV1, ProcessAnnotation: true, ModuleCache: cache}
+
+	module := `# METADATA
+# title: test
+package test
+
+p := 1
+`
+
+	mod, err := ParseModuleWithOpts("test.rego", module, popts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mod.Annotations) != 1 {
+		t.Fatalf("expected annotations, got %v", mod.Annotations)
+	}
+	if len(cache.entries) != 0 {
+		t.Fatalf("expected annotated module not to be cached, got %d entries", len(cache.entries))
+	}
+}
@@ -136,7 +136,10 @@ type ParserOptions struct {
 	SkipRules         bool
 	JSONOptions       *astJSON.Options
 	// RegoVersion is the version of Rego to parse for.
-	RegoVersion        RegoVersion
+	RegoVersion RegoVersion
+	// ModuleCache, if set, is used by ParseModuleWithOpts to look up and
+	// store parsed modules. It is not used when JSONOptions are set.
+	ModuleCache        ModuleCache
 	unreleasedKeywords bool // TODO(sr): cleanup
 }
 
@@ -473,6 +473,9 @@ func ParseModule(filename, input string) (*Module, error) {
 // For details on Module objects and their fields, see policy.go.
 // Empty input will return nil, nil.
 func ParseModuleWithOpts(filename, input string, popts ParserOptions) (*Module, error) {
+	if popts.ModuleCache != nil && popts.JSONOptions == nil {
+		return parseModuleCached(filename, input, popts)
+	}
 	stmts, comments, err := ParseStatementsWithOpts(filename, input, popts)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,368 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package ast
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// PrefixRewrite replaces the prefix From of refs with To.
+type PrefixRewrite struct {
+	From Ref
+	To   Ref
+}
+
+// RewriteRefPrefixes rewrites the refs contained in x, e.g., a module or a
+// rule, that start with the From ref of one of the rewrites. If several
+// rewrites apply to a ref, the one with the longest From ref is used. If x is
+// a module, the refs of its rules that use one of its imports are rewritten
+// too, e.g., `foo.allow` with `import data.lib.foo` and a rewrite of
+// `data.lib.foo.allow`, even if the import itself is not rewritten.
+//
+// The rewrite preserves what the formatter needs to keep the comments of x
+// in place: the terms of the new prefixes take the location of the terms
+// they replace. The names of rewritten imports are preserved as well, by
+// adding an alias if the last element of the import path changes, so that the
+// refs using the import still resolve.
+func RewriteRefPrefixes(x interface{}, rewrites ...PrefixRewrite) error {
+	names := map[*Import]Var{}
+	WalkNodes(x, func(n Node) bool {
+		if imp, ok := n.(*Import); ok {
+			names[imp] = imp.Name()
+		}
+		return false
+	})
+
+	// Refs using an import are only rewritten if the import is not.
+	imports := map[Var]Ref{}
+	if m, ok := x.(*Module); ok {
+	outer:
+		for name, path := range ImportedRefs(m) {
+			for _, rw := range rewrites {
+				if path.HasPrefix(rw.From) {
+					continue outer
+				}
+			}
+			imports[name] = path
+		}
+	}
+
+	rewrite := func(ref Ref, imports map[Var]Ref) Ref {
+		full := ref
+		if len(ref) > 1 {
+			if v, ok := ref[0].Value.(Var); ok {
+				if path, ok := imports[v]; ok {
+					full = path.Concat(ref[1:])
+				}
+			}
+		}
+		var match *PrefixRewrite
+		for i := range rewrites {
+			if full.HasPrefix(rewrites[i].From) && (match == nil || len(rewrites[i].From) > len(match.From)) {
+				match = &rewrites[i]
+			}
+		}
+		if match == nil {
+			return nil
+		}
+		prefix := match.To.Copy()
+		for i := range prefix {
+			prefix[i].Location = ref[0].Location
+		}
+		return prefix.Concat(full[len(match.From):])
+	}
+
+	transformer := func(imports map[Var]Ref) Transformer {
+		return NewGenericTransformer(func(x interface{}) (interface{}, error) {
+			if ref, ok := x.(Ref); ok {
+				if r := rewrite(ref, imports); r != nil {
+					return r, nil
+				}
+			}
+			return x, nil
+		})
+	}
+
+	if m, ok := x.(*Module); ok {
+		// The rules are transformed separately from the rest of the module,
+		// because only the refs of rules use the imports.
+		for i := range m.Rules {
+			if _, err := Transform(transformer(imports), m.Rules[i]); err != nil {
+				return err
+			}
+		}
+		rules := m.Rules
+		m.Rules = nil
+		_, err := Transform(transformer(nil), m)
+		m.Rules = rules
+		if err != nil {
+			return err
+		}
+	} else if _, err := Transform(transformer(nil), x); err != nil {
+		return err
+	}
+
+	for imp, name := range names {
+		if imp.Name() != name {
+			imp.Alias = name
+		}
+		if imp.Alias.Equal(importName(imp)) {
+			imp.Alias = ""
+		}
+	}
+	return nil
+}
+
+// ImportedRefs returns the paths of the imports of m, keyed by their names.
+// The future and rego imports are omitted.
+func ImportedRefs(m *Module) map[Var]Ref {
+	result := make(map[Var]Ref, len(m.Imports))
+	for _, imp := range m.Imports {
+		path, ok := imp.Path.Value.(Ref)
+		if !ok || FutureRootDocument.Equal(path[0]) || RegoRootDocument.Equal(path[0]) {
+			continue
+		}
+		result[imp.Name()] = path
+	}
+	return result
+}
+
+// importName returns the name of the import without its alias.
+func importName(imp *Import) Var {
+	cpy := *imp
+	cpy.Alias = ""
+	return cpy.Name()
+}
+
+// RenamePackage changes the package of m to the package at path to, and
+// rewrites the refs of m to the rules of the package.
+func RenamePackage(m *Module, to Ref) error {
+	if len(to) == 0 || !to[0].Equal(DefaultRootDocument) {
+		return fmt.Errorf("invalid package path %v: must start with %v", to, DefaultRootDocument)
+	}
+	return RewriteRefPrefixes(m, PrefixRewrite{From: m.Package.Path.Copy(), To: to})
+}
+
+// AttachedComments returns the comments of m that are attached to x: the
+// comments on the rows of x, and the block of comments directly above x,
+// e.g., the METADATA block of a rule.
+func AttachedComments(m *Module, x Node) []*Comment {
+	loc := x.Loc()
+	if loc == nil {
+		return nil
+	}
+	first, last := loc.Row, loc.Row+bytes.Count(loc.Text, []byte("\n"))
+
+	rows := map[int]*Comment{}
+	for _, c := range m.Comments {
+		rows[c.Location.Row] = c
+	}
+	for {
+		if _, ok := rows[first-1]; !ok {
+			break
+		}
+		first--
+	}
+
+	var result []*Comment
+	for _, c := range m.Comments {
+		if c.Location.Row >= first && c.Location.Row <= last {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// MoveRule moves rule, with its comments and annotations, to the end of dst.
+// Pkg are the modules of the package of the rule. The refs of the rule to the
+// other rules of the package, and to the imports of its module, are resolved
+// to full refs, so that the rule keeps its meaning in the package of dst. The
+// refs of the rules of the package to the moved rule are resolved as well, so
+// that they can be rewritten with RewriteRefPrefixes if the rule is renamed.
+//
+// The locations of the rule and its comments are shifted below the last
+// statement of dst, so that formatting dst keeps the comments in place.
+func MoveRule(pkg []*Module, dst *Module, rule *Rule) error {
+	src := rule.Module
+	idx := -1
+	if src != nil {
+		for i := range src.Rules {
+			if src.Rules[i] == rule {
+				idx = i
+				break
+			}
+		}
+	}
+	if idx < 0 {
+		return fmt.Errorf("rule %v is not defined in its module", rule.Head.Ref())
+	}
+
+	var refs []Ref
+	for _, m := range pkg {
+		if !m.Package.Path.Equal(src.Package.Path) {
+			return fmt.Errorf("module of package %v is not part of package %v", m.Package.Path, src.Package.Path)
+		}
+		for _, r := range m.Rules {
+			refs = append(refs, r.Head.Ref().GroundPrefix())
+		}
+	}
+
+	if dst != src {
+		globals := getGlobals(src.Package, refs, src.Imports)
+		for r := rule; r != nil; r = r.Else {
+			if err := resolveRefsInRule(globals, r); err != nil {
+				return err
+			}
+		}
+	}
+
+	globals := getGlobals(src.Package, []Ref{rule.Head.Ref().GroundPrefix()}, nil)
+	for _, m := range pkg {
+		for _, r := range m.Rules {
+			if r == rule {
+				continue
+			}
+			for ; r != nil; r = r.Else {
+				if err := resolveRefsInRule(globals, r); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if dst == src {
+		return nil
+	}
+
+	comments := AttachedComments(src, rule)
+	var annots []*Annotations
+	for _, a := range src.Annotations {
+		if a.node == rule {
+			annots = append(annots, a)
+		}
+	}
+
+	src.Rules = append(src.Rules[:idx], src.Rules[idx+1:]...)
+	src.Comments = removeComments(src.Comments, comments)
+	src.Annotations = removeAnnotations(src.Annotations, annots)
+	src.stmts = removeStatement(src.stmts, rule)
+	for _, a := range annots {
+		src.stmts = removeStatement(src.stmts, a)
+	}
+
+	first := rule.Location.Row
+	if len(comments) > 0 && comments[0].Location.Row < first {
+		first = comments[0].Location.Row
+	}
+	shiftLocations(rule, comments, annots, lastRow(dst)+2-first, dst.Package.Location)
+
+	for r := rule; r != nil; r = r.Else {
+		r.Module = dst
+	}
+	dst.Rules = append(dst.Rules, rule)
+	dst.Comments = append(dst.Comments, comments...)
+	dst.Annotations = append(dst.Annotations, annots...)
+	for _, a := range annots {
+		dst.stmts = append(dst.stmts, a)
+	}
+	dst.stmts = append(dst.stmts, rule)
+	return nil
+}
+
+// lastRow returns the last row of the statements and comments of m.
+func lastRow(m *Module) int {
+	var row int
+	WalkNodes(m, func(n Node) bool {
+		if loc := n.Loc(); loc != nil {
+			if r := loc.Row + bytes.Count(loc.Text, []byte("\n")); r > row {
+				row = r
+			}
+		}
+		return false
+	})
+	for _, c := range m.Comments {
+		if c.Location.Row > row {
+			row = c.Location.Row
+		}
+	}
+	return row
+}
+
+// shiftLocations moves the locations of rule, its comments and annotations by
+// offset rows, into the file of loc.
+func shiftLocations(rule *Rule, comments []*Comment, annots []*Annotations, offset int, loc *Location) {
+	seen := map[*Location]struct{}{}
+	shift := func(l *Location) {
+		if l == nil {
+			return
+		}
+		if _, ok := seen[l]; ok {
+			return
+		}
+		seen[l] = struct{}{}
+		l.Row += offset
+		if loc != nil {
+			l.File = loc.File
+		}
+	}
+
+	NewGenericVisitor(func(x interface{}) bool {
+		if n, ok := x.(Node); ok {
+			shift(n.Loc())
+		}
+		return false
+	}).Walk(rule)
+
+	for _, c := range comments {
+		shift(c.Location)
+	}
+	for _, a := range annots {
+		shift(a.Location)
+	}
+}
+
+func removeComments(comments []*Comment, remove []*Comment) []*Comment {
+	result := comments[:0]
+	for _, c := range comments {
+		keep := true
+		for _, r := range remove {
+			if c == r {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+func removeAnnotations(annots []*Annotations, remove []*Annotations) []*Annotations {
+	result := annots[:0]
+	for _, a := range annots {
+		keep := true
+		for _, r := range remove {
+			if a == r {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			result = append(result, a)
+		}
+	}
+	return result
+}
+
+func removeStatement(stmts []Statement, stmt Statement) []Statement {
+	for i := range stmts {
+		if stmts[i] == stmt {
+			return append(stmts[:i], stmts[i+1:]...)
+		}
+	}
+	return stmts
+}
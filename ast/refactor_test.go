@@ -0,0 +1,199 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package ast
+
+import (
+	"testing"
+)
+
+func TestRewriteRefPrefixes(t *testing.T) {
+	m := MustParseModule(`package app
+
+import data.lib.foo
+import data.lib.other as o
+
+p {
+	foo.allow         # comment
+	data.lib.foo.x[_]
+	o.y
+	data.lib.foox
+}`)
+
+	err := RewriteRefPrefixes(m,
+		PrefixRewrite{From: MustParseRef("data.lib"), To: MustParseRef("data.x")},
+		PrefixRewrite{From: MustParseRef("data.lib.foo"), To: MustParseRef("data.baz.bar")},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := MustParseModule(`package app
+
+import data.baz.bar as foo
+import data.x.other as o
+
+p {
+	foo.allow
+	data.baz.bar.x[_]
+	o.y
+	data.x.foox
+}`)
+	if !exp.Equal(m) {
+		t.Fatalf("expected:\n%v\n\ngot:\n%v", exp, m)
+	}
+
+	ref := m.Rules[0].Body[1].Terms.(*Term).Value.(Ref)
+	for _, x := range ref {
+		if x.Location == nil || x.Location.Row != 8 {
+			t.Fatalf("expected terms of rewritten ref on row 8, got %v", x.Location)
+		}
+	}
+	if len(m.Comments) != 1 || m.Comments[0].Location.Row != 7 {
+		t.Fatalf("expected comment to be preserved, got %v", m.Comments)
+	}
+}
+
+func TestRewriteRefPrefixesKeepsAlias(t *testing.T) {
+	m := MustParseModule(`package app
+
+import data.lib.foo as bar
+
+p { bar.allow }`)
+
+	if err := RewriteRefPrefixes(m, PrefixRewrite{From: MustParseRef("data.lib.foo"), To: MustParseRef("data.bar")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Imports[0].Alias != "" {
+		t.Fatalf("expected redundant alias to be removed, got %v", m.Imports[0])
+	}
+	if exp := MustParseRef("bar.allow"); !m.Rules[0].Body[0].Terms.(*Term).Value.(Ref).Equal(exp) {
+		t.Fatalf("expected %v, got %v", exp, m.Rules[0].Body[0])
+	}
+}
+
+func TestRenamePackage(t *testing.T) {
+	m := MustParseModule(`package lib.foo
+
+p { data.lib.foo.q }
+
+q = true`)
+
+	if err := RenamePackage(m, MustParseRef("data.baz")); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := MustParseModule(`package baz
+
+p { data.baz.q }
+
+q = true`)
+	if !exp.Equal(m) {
+		t.Fatalf("expected:\n%v\n\ngot:\n%v", exp, m)
+	}
+
+	if err := RenamePackage(m, MustParseRef("input.baz")); err == nil {
+		t.Fatal("expected error for package path outside of data")
+	}
+}
+
+func TestAttachedComments(t *testing.T) {
+	m := MustParseModule(`package app
+
+# not attached
+
+# METADATA
+# title: P
+p {
+	# inside
+	true # trailing
+}
+
+# after`)
+
+	var texts []string
+	for _, c := range AttachedComments(m, m.Rules[0]) {
+		texts = append(texts, string(c.Text))
+	}
+
+	exp := []string{" METADATA", " title: P", " inside", " trailing"}
+	if len(texts) != len(exp) {
+		t.Fatalf("expected %q, got %q", exp, texts)
+	}
+	for i := range exp {
+		if texts[i] != exp[i] {
+			t.Fatalf("expected %q, got %q", exp, texts)
+		}
+	}
+}
+
+func TestMoveRule(t *testing.T) {
+	src := MustParseModuleWithOpts(`package lib
+
+import input.user
+
+# METADATA
+# title: Allow
+allow {
+	is_admin # trailing
+	user.active
+}
+
+is_admin { user.role == "admin" }
+
+deny { not allow }`, ParserOptions{ProcessAnnotation: true})
+
+	dst := MustParseModule(`package app
+
+p = 1`)
+
+	if err := MoveRule([]*Module{src}, dst, src.Rules[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	expSrc := MustParseModule(`package lib
+
+import input.user
+
+is_admin { user.role == "admin" }
+
+deny { not data.lib.allow }`)
+	if !expSrc.Equal(src) {
+		t.Fatalf("expected source:\n%v\n\ngot:\n%v", expSrc, src)
+	}
+
+	expDst := MustParseModuleWithOpts(`package app
+
+p = 1
+
+# METADATA
+# title: Allow
+allow {
+	data.lib.is_admin # trailing
+	input.user.active
+}`, ParserOptions{ProcessAnnotation: true})
+	if !expDst.Equal(dst) {
+		t.Fatalf("expected destination:\n%v\n\ngot:\n%v", expDst, dst)
+	}
+
+	rule := dst.Rules[1]
+	if rule.Module != dst || rule.Location.Row != 7 || rule.Body[0].Location.Row != 8 {
+		t.Fatalf("expected rule of destination module on row 7, got %v", rule.Location)
+	}
+	if len(dst.Annotations) != 1 || dst.Annotations[0].Title != "Allow" || dst.Annotations[0].Location.Row != 5 {
+		t.Fatalf("expected annotations to be moved, got %v", dst.Annotations)
+	}
+
+	var rows []int
+	for _, c := range dst.Comments {
+		rows = append(rows, c.Location.Row)
+	}
+	if len(rows) != 3 || rows[0] != 5 || rows[1] != 6 || rows[2] != 8 {
+		t.Fatalf("expected comments on rows 5, 6 and 8, got %v", rows)
+	}
+	if len(src.Comments) != 0 || len(src.Annotations) != 0 {
+		t.Fatalf("expected comments and annotations to be removed from source, got %v and %v", src.Comments, src.Annotations)
+	}
+}
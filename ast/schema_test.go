@@ -1658,3 +1658,73 @@ const recursiveElements = `{
   }
 }
 `
+
+func TestCompilerInputSchema(t *testing.T) {
+	opts := ParserOptions{ProcessAnnotation: true}
+	modules := func() map[string]*Module {
+		return map[string]*Module{
+			"policy.rego": MustParseModuleWithOpts(`# METADATA
+# schemas:
+#   - input: schema.request
+package policy
+
+# METADATA
+# schemas:
+#   - input: {"type": "object", "required": ["user"]}
+allow {
+	input.user == "admin"
+}
+
+deny {
+	input.method == "DELETE"
+}`, opts),
+			"other.rego": MustParseModuleWithOpts(`package other
+
+p { input.x }`, opts),
+		}
+	}
+
+	ss := NewSchemaSet()
+	ss.Put(MustParseRef("schema.request"), map[string]interface{}{"type": "object", "required": []interface{}{"method"}})
+
+	c := NewCompiler().WithSchemas(ss)
+	c.Compile(modules())
+	if c.Failed() {
+		t.Fatal(c.Errors)
+	}
+
+	tests := []struct {
+		path string
+		exp  string
+	}{
+		{path: "data.policy.allow", exp: `{"type": "object", "required": ["user"]}`},
+		{path: "data.policy.deny", exp: `{"type": "object", "required": ["method"]}`},
+		{path: "data.policy", exp: `{"type": "object", "required": ["method"]}`},
+		{path: "data.other.p"},
+		{path: "data.missing"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.path, func(t *testing.T) {
+			schema, err := c.InputSchema(MustParseRef(tc.path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.exp == "" {
+				if schema != nil {
+					t.Fatalf("expected no schema, got %v", schema)
+				}
+				return
+			}
+			if MustInterfaceToValue(schema).Compare(MustParseTerm(tc.exp).Value) != 0 {
+				t.Fatalf("expected schema %v, got %v", tc.exp, schema)
+			}
+		})
+	}
+
+	c = NewCompiler()
+	c.Compile(modules())
+	if _, err := c.InputSchema(MustParseRef("data.policy.deny")); err == nil || err.Error() != "undefined schema: schema.request" {
+		t.Fatalf("expected undefined schema error, got %v", err)
+	}
+}
@@ -55,6 +55,10 @@ type Value interface {
 
 // InterfaceToValue converts a native Go value x to a Value.
 func InterfaceToValue(x interface{}) (Value, error) {
+	return interfaceToValue(x, nil)
+}
+
+func interfaceToValue(x interface{}, pool *InternPool) (Value, error) {
 	switch x := x.(type) {
 	case nil:
 		return Null{}, nil
@@ -75,39 +79,27 @@ func InterfaceToValue(x interface{}) (Value, error) {
 	case []interface{}:
 		r := make([]*Term, len(x))
 		for i, e := range x {
-			e, err := InterfaceToValue(e)
+			e, err := interfaceToTerm(e, pool)
 			if err != nil {
 				return nil, err
 			}
-			r[i] = &Term{Value: e}
+			r[i] = e
 		}
 		return NewArray(r...), nil
 	case map[string]interface{}:
 		r := newobject(len(x))
 		for k, v := range x {
-			k, err := InterfaceToValue(k)
-			if err != nil {
-				return nil, err
-			}
-			v, err := InterfaceToValue(v)
+			v, err := interfaceToTerm(v, pool)
 			if err != nil {
 				return nil, err
 			}
-			r.Insert(NewTerm(k), NewTerm(v))
+			r.Insert(pool.StringTerm(k), v)
 		}
 		return r, nil
 	case map[string]string:
 		r := newobject(len(x))
 		for k, v := range x {
-			k, err := InterfaceToValue(k)
-			if err != nil {
-				return nil, err
-			}
-			v, err := InterfaceToValue(v)
-			if err != nil {
-				return nil, err
-			}
-			r.Insert(NewTerm(k), NewTerm(v))
+			r.Insert(pool.StringTerm(k), pool.StringTerm(v))
 		}
 		return r, nil
 	default:
@@ -115,10 +107,21 @@ func InterfaceToValue(x interface{}) (Value, error) {
 		if err := util.RoundTrip(ptr); err != nil {
 			return nil, fmt.Errorf("ast: interface conversion: %w", err)
 		}
-		return InterfaceToValue(*ptr)
+		return interfaceToValue(*ptr, pool)
 	}
 }
 
+func interfaceToTerm(x interface{}, pool *InternPool) (*Term, error) {
+	if s, ok := x.(string); ok {
+		return pool.StringTerm(s), nil
+	}
+	v, err := interfaceToValue(x, pool)
+	if err != nil {
+		return nil, err
+	}
+	return &Term{Value: v}, nil
+}
+
 // ValueFromReader returns an AST value from a JSON serialized value in the reader.
 func ValueFromReader(r io.Reader) (Value, error) {
 	var x interface{}
@@ -344,6 +347,8 @@ func (term *Term) Copy() *Term {
 		cpy.Value = v.Copy()
 	case *object:
 		cpy.Value = v.Copy()
+	case *lazyObj:
+		cpy.Value = v.Copy()
 	case *ArrayComprehension:
 		cpy.Value = v.Copy()
 	case *ObjectComprehension:
@@ -1877,11 +1882,12 @@ type lazyObj struct {
 	strict Object
 	cache  map[string]Value
 	native map[string]interface{}
+	pool   *InternPool
 }
 
 func (l *lazyObj) force() Object {
 	if l.strict == nil {
-		l.strict = MustInterfaceToValue(l.native).(Object)
+		l.strict = l.mustInterfaceToValue(l.native).(Object)
 		// NOTE(jf): a possible performance improvement here would be to check how many
 		// entries have been realized to AST in the cache, and if some threshold compared to the
 		// total number of keys is exceeded, realize the remaining entries and set l.strict to l.cache.
@@ -1890,6 +1896,14 @@ func (l *lazyObj) force() Object {
 	return l.strict
 }
 
+func (l *lazyObj) mustInterfaceToValue(x interface{}) Value {
+	v, err := interfaceToValue(x, l.pool)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
 func (l *lazyObj) Compare(other Value) int {
 	o1 := sortOrder(l)
 	o2 := sortOrder(other)
@@ -1901,8 +1915,13 @@ func (l *lazyObj) Compare(other Value) int {
 	return l.force().Compare(other)
 }
 
+// Copy returns a lazy object that converts the same native value, so that
+// changes to the copy, like the ones of the with keyword, don't affect l.
 func (l *lazyObj) Copy() Object {
-	return l
+	if l.strict != nil {
+		return l.strict.Copy()
+	}
+	return &lazyObj{native: l.native, cache: map[string]Value{}, pool: l.pool}
 }
 
 func (l *lazyObj) Diff(other Object) Object {
@@ -1948,6 +1967,9 @@ func (l *lazyObj) MergeWith(other Object, conflictResolver func(v1, v2 *Term) (*
 }
 
 func (l *lazyObj) Len() int {
+	if l.strict != nil {
+		return l.strict.Len()
+	}
 	return len(l.native)
 }
 
@@ -1974,9 +1996,9 @@ func (l *lazyObj) Get(k *Term) *Term {
 			var converted Value
 			switch val := val.(type) {
 			case map[string]interface{}:
-				converted = LazyObject(val)
+				converted = &lazyObj{native: val, cache: map[string]Value{}, pool: l.pool}
 			default:
-				converted = MustInterfaceToValue(val)
+				converted = l.mustInterfaceToValue(val)
 			}
 			l.cache[string(s)] = converted
 			return NewTerm(converted)
@@ -2003,7 +2025,7 @@ func (l *lazyObj) Keys() []*Term {
 	}
 	ret := make([]*Term, 0, len(l.native))
 	for k := range l.native {
-		ret = append(ret, StringTerm(k))
+		ret = append(ret, l.pool.StringTerm(k))
 	}
 	sort.Sort(termSlice(ret))
 	return ret
@@ -2042,9 +2064,9 @@ func (l *lazyObj) Find(path Ref) (Value, error) {
 			var converted Value
 			switch v := v.(type) {
 			case map[string]interface{}:
-				converted = LazyObject(v)
+				converted = &lazyObj{native: v, cache: map[string]Value{}, pool: l.pool}
 			default:
-				converted = MustInterfaceToValue(v)
+				converted = l.mustInterfaceToValue(v)
 			}
 			l.cache[string(p0)] = converted
 			return converted.Find(path[1:])
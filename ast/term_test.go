@@ -1293,6 +1293,32 @@ func TestLazyObjectFind(t *testing.T) {
 	}
 }
 
+func TestLazyObjectCopyIsIndependent(t *testing.T) {
+	x := LazyObject(map[string]interface{}{
+		"a": map[string]interface{}{
+			"b": true,
+		},
+	})
+	x.Get(StringTerm("a"))
+
+	cpy := x.Copy()
+	cpy.Get(StringTerm("a")).Value.(Object).Insert(StringTerm("c"), BooleanTerm(false))
+	cpy.Insert(StringTerm("d"), BooleanTerm(false))
+
+	if x.Get(StringTerm("a")).Value.(Object).Len() != 1 || x.Len() != 1 {
+		t.Errorf("expected changes of copy to leave original unchanged, got %v", x)
+	}
+	assertForced(t, x, false)
+
+	// copies of forced lazy objects are independent too
+	x.Insert(StringTerm("e"), BooleanTerm(true))
+	cpy = x.Copy()
+	cpy.Insert(StringTerm("f"), BooleanTerm(true))
+	if x.Len() != 2 {
+		t.Errorf("expected changes of copy to leave original unchanged, got %v", x)
+	}
+}
+
 func TestLazyObjectFindCache(t *testing.T) {
 	x := LazyObject(map[string]interface{}{
 		"a": []string{
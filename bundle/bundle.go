@@ -72,9 +72,14 @@ type Raw struct {
 }
 
 // Patch contains an array of objects wherein each object represents the patch operation to be
-// applied to the bundle data.
+// applied to the bundle data, and an array of operations adding or removing bundle policies.
 type Patch struct {
-	Data []PatchOperation `json:"data,omitempty"`
+	Data    []PatchOperation       `json:"data,omitempty"`
+	Modules []ModulePatchOperation `json:"modules,omitempty"`
+
+	// BaseRevision is the revision of the bundle the patch applies to. If set,
+	// the patch is rejected when the revision of the activated bundle differs.
+	BaseRevision string `json:"base_revision,omitempty"`
 }
 
 // PatchOperation models a single patch operation against a document.
@@ -84,10 +89,22 @@ type PatchOperation struct {
 	Value interface{} `json:"value"`
 }
 
+// ModulePatchOperation models a single patch operation against the bundle policies. The
+// "upsert" operation adds or replaces the module at the path with the Rego source in
+// Raw, the "remove" operation removes the module at the path.
+type ModulePatchOperation struct {
+	Op     string      `json:"op"`
+	Path   string      `json:"path"`
+	Raw    string      `json:"raw,omitempty"`
+	Parsed *ast.Module `json:"-"`
+}
+
 // SignaturesConfig represents an array of JWTs that encapsulate the signatures for the bundle.
+// Alternatively, the bundle may be signed with a Sigstore keyless signature.
 type SignaturesConfig struct {
-	Signatures []string `json:"signatures,omitempty"`
-	Plugin     string   `json:"plugin,omitempty"`
+	Signatures []string          `json:"signatures,omitempty"`
+	Keyless    *KeylessSignature `json:"keyless,omitempty"`
+	Plugin     string            `json:"plugin,omitempty"`
 }
 
 // isEmpty returns if the SignaturesConfig is empty.
@@ -138,6 +155,10 @@ type Manifest struct {
 	// This allows individual files to override the global Rego version specified by RegoVersion.
 	FileRegoVersions map[string]int         `json:"file_rego_versions,omitempty"`
 	Metadata         map[string]interface{} `json:"metadata,omitempty"`
+	// IndexHints are values that references indexed by rule indices are
+	// frequently looked up with. They are typically derived from an
+	// evaluation profile by `opa build --pgo`.
+	IndexHints []IndexHint `json:"index_hints,omitempty"`
 
 	compiledFileRegoVersions []fileRegoVersion
 }
@@ -154,6 +175,54 @@ type WasmResolver struct {
 	Annotations []*ast.Annotations `json:"annotations,omitempty"`
 }
 
+// IndexHint describes a value that a reference indexed by the rule index of a
+// rule set is frequently looked up with. See ast.IndexHint.
+type IndexHint struct {
+	Path  string      `json:"path"`
+	Ref   string      `json:"ref"`
+	Value interface{} `json:"value"`
+	Count int         `json:"count"`
+}
+
+// NewIndexHints returns the manifest representation of the compiler index
+// hints.
+func NewIndexHints(hints []*ast.IndexHint) ([]IndexHint, error) {
+	result := make([]IndexHint, len(hints))
+	for i, hint := range hints {
+		value, err := ast.JSON(hint.Value)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = IndexHint{
+			Path:  hint.Path.String(),
+			Ref:   hint.Ref.String(),
+			Value: value,
+			Count: hint.Count,
+		}
+	}
+	return result, nil
+}
+
+// Parse returns the compiler index hint represented by h.
+func (h IndexHint) Parse() (*ast.IndexHint, error) {
+	path, err := ast.ParseRef(h.Path)
+	if err != nil {
+		return nil, err
+	}
+	ref, err := ast.ParseRef(h.Ref)
+	if err != nil {
+		return nil, err
+	}
+	value, err := ast.InterfaceToValue(h.Value)
+	if err != nil {
+		return nil, err
+	}
+	if !ast.IsScalar(value) {
+		return nil, fmt.Errorf("index hint value must be scalar")
+	}
+	return &ast.IndexHint{Path: path, Ref: ref, Value: value, Count: h.Count}, nil
+}
+
 // Init initializes the manifest. If you instantiate a manifest
 // manually, call Init to ensure that the roots are set properly.
 func (m *Manifest) Init() {
@@ -208,6 +277,10 @@ func (m Manifest) Equal(other Manifest) bool {
 		return false
 	}
 
+	if !reflect.DeepEqual(m.IndexHints, other.IndexHints) {
+		return false
+	}
+
 	return m.equalWasmResolversAndRoots(other)
 }
 
@@ -226,6 +299,12 @@ func (m Manifest) Copy() Manifest {
 	copy(wasmModules, m.WasmResolvers)
 	m.WasmResolvers = wasmModules
 
+	if m.IndexHints != nil {
+		indexHints := make([]IndexHint, len(m.IndexHints))
+		copy(indexHints, m.IndexHints)
+		m.IndexHints = indexHints
+	}
+
 	metadata := m.Metadata
 
 	if metadata != nil {
@@ -374,6 +453,13 @@ func (m *Manifest) validateAndInjectDefaults(b Bundle) error {
 		wasmModuleToEps[wmConfig.Module] = wmConfig.Entrypoint
 	}
 
+	// Validate index hints in bundle.
+	for _, hint := range m.IndexHints {
+		if _, err := hint.Parse(); err != nil {
+			return fmt.Errorf("manifest has invalid index hint for '%v': %w", hint.Path, err)
+		}
+	}
+
 	// Validate data patches in bundle.
 	for _, patch := range b.Patch.Data {
 		path := strings.Trim(patch.Path, "/")
@@ -382,6 +468,20 @@ func (m *Manifest) validateAndInjectDefaults(b Bundle) error {
 		}
 	}
 
+	// Validate module patches in bundle.
+	for _, patch := range b.Patch.Modules {
+		if patch.Parsed == nil {
+			continue
+		}
+		found := false
+		if path, err := patch.Parsed.Package.Path.Ptr(); err == nil {
+			found = RootPathsContain(roots, path)
+		}
+		if !found {
+			return fmt.Errorf("manifest roots %v do not permit '%v' in module patch '%v'", roots, patch.Parsed.Package, patch.Path)
+		}
+	}
+
 	if b.lazyLoadingMode {
 		return nil
 	}
@@ -450,6 +550,7 @@ type Reader struct {
 	name                  string
 	persist               bool
 	regoVersion           ast.RegoVersion
+	moduleCache           ast.ModuleCache
 }
 
 // NewReader is deprecated. Use NewCustomReader instead.
@@ -558,12 +659,20 @@ func (r *Reader) WithRegoVersion(version ast.RegoVersion) *Reader {
 	return r
 }
 
+// WithModuleCache sets the cache used to look up and store the parsed
+// modules of the bundle.
+func (r *Reader) WithModuleCache(cache ast.ModuleCache) *Reader {
+	r.moduleCache = cache
+	return r
+}
+
 func (r *Reader) ParserOptions() ast.ParserOptions {
 	return ast.ParserOptions{
 		ProcessAnnotation: r.processAnnotations,
 		Capabilities:      r.capabilities,
 		JSONOptions:       r.jsonOptions,
 		RegoVersion:       r.regoVersion,
+		ModuleCache:       r.moduleCache,
 	}
 }
 
@@ -733,6 +842,33 @@ func (r *Reader) Read() (Bundle, error) {
 		if r.persist {
 			return bundle, fmt.Errorf("'persist' property is true in config. persisting delta bundle to disk is not supported")
 		}
+
+		for i := range bundle.Patch.Modules {
+			mp := &bundle.Patch.Modules[i]
+
+			path := "/" + strings.TrimLeft(filepath.ToSlash(mp.Path), "/")
+			if !strings.HasSuffix(path, RegoExt) {
+				return bundle, fmt.Errorf("bad module patch path: %v", mp.Path)
+			}
+			mp.Path = r.fullPath(path)
+
+			switch mp.Op {
+			case "upsert":
+				modulePopts := popts
+				if modulePopts.RegoVersion, err = bundle.RegoVersionForFile(path, popts.RegoVersion); err != nil {
+					return bundle, err
+				}
+				r.metrics.Timer(metrics.RegoModuleParse).Start()
+				mp.Parsed, err = ast.ParseModuleWithOpts(mp.Path, mp.Raw, modulePopts)
+				r.metrics.Timer(metrics.RegoModuleParse).Stop()
+				if err != nil {
+					return bundle, err
+				}
+			case "remove":
+			default:
+				return bundle, fmt.Errorf("bad module patch operation: %v", mp.Op)
+			}
+		}
 	}
 
 	// check if the bundle signatures specify any files that weren't found in the bundle
@@ -805,7 +941,7 @@ func (r *Reader) checkSignaturesAndDescriptors(signatures SignaturesConfig) erro
 		return nil
 	}
 
-	if signatures.isEmpty() && r.verificationConfig != nil && r.verificationConfig.KeyID != "" {
+	if signatures.isEmpty() && r.verificationConfig != nil && (r.verificationConfig.KeyID != "" || r.verificationConfig.Keyless != nil) {
 		return fmt.Errorf("bundle missing .signatures.json file")
 	}
 
@@ -1349,7 +1485,7 @@ func (b *Bundle) readData(key []string) *interface{} {
 
 // Type returns the type of the bundle.
 func (b *Bundle) Type() string {
-	if len(b.Patch.Data) != 0 {
+	if len(b.Patch.Data) != 0 || len(b.Patch.Modules) != 0 {
 		return DeltaBundleType
 	}
 	return SnapshotBundleType
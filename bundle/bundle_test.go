@@ -81,6 +81,12 @@ func TestManifestEqual(t *testing.T) {
 	}
 	assertEqual()
 
+	n.IndexHints = []IndexHint{{Path: "data.a", Ref: "input.x", Value: "y", Count: 1}}
+	assertNotEqual()
+
+	m.IndexHints = []IndexHint{{Path: "data.a", Ref: "input.x", Value: "y", Count: 1}}
+	assertEqual()
+
 	// rego-version
 
 	n.RegoVersion = pointTo(1)
@@ -599,6 +605,84 @@ func TestReadWithPatch(t *testing.T) {
 	}
 }
 
+func TestReadWithModulePatch(t *testing.T) {
+	files := [][2]string{
+		{"/.manifest", `{"revision": "r2", "roots": ["a"]}`},
+		{"/patch.json", `{"base_revision": "r1", "modules": [{"op": "upsert", "path": "a/policy.rego", "raw": "package a\np = 1"}, {"op": "remove", "path": "/a/old.rego"}]}`},
+	}
+
+	buf := archive.MustWriteTarGz(files)
+
+	b, err := NewCustomReader(NewTarballLoaderWithBaseURL(buf, "")).Read()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	if actual := b.Type(); actual != DeltaBundleType {
+		t.Fatalf("Expected delta bundle but got %v", actual)
+	}
+
+	if b.Patch.BaseRevision != "r1" {
+		t.Fatalf("Expected base revision r1 but got %v", b.Patch.BaseRevision)
+	}
+
+	if len(b.Patch.Modules) != 2 {
+		t.Fatalf("Expected two module patch operations but got %v", len(b.Patch.Modules))
+	}
+
+	upsert, remove := b.Patch.Modules[0], b.Patch.Modules[1]
+
+	if upsert.Path != "/a/policy.rego" || upsert.Parsed == nil || !upsert.Parsed.Package.Path.Equal(ast.MustParseRef("data.a")) {
+		t.Fatalf("Unexpected upsert operation %+v", upsert)
+	}
+
+	if remove.Path != "/a/old.rego" || remove.Parsed != nil {
+		t.Fatalf("Unexpected remove operation %+v", remove)
+	}
+}
+
+func TestReadWithModulePatchErrors(t *testing.T) {
+	cases := []struct {
+		note  string
+		patch string
+		err   string
+	}{
+		{
+			note:  "bad op",
+			patch: `{"modules": [{"op": "replace", "path": "a/policy.rego", "raw": "package a"}]}`,
+			err:   "bad module patch operation: replace",
+		},
+		{
+			note:  "bad path",
+			patch: `{"modules": [{"op": "remove", "path": "a/data.json"}]}`,
+			err:   "bad module patch path: a/data.json",
+		},
+		{
+			note:  "outside roots",
+			patch: `{"modules": [{"op": "upsert", "path": "b/policy.rego", "raw": "package b"}]}`,
+			err:   "manifest roots [a] do not permit 'package b' in module patch '/b/policy.rego'",
+		},
+		{
+			note:  "parse error",
+			patch: `{"modules": [{"op": "upsert", "path": "a/policy.rego", "raw": "package"}]}`,
+			err:   "rego_parse_error",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.note, func(t *testing.T) {
+			buf := archive.MustWriteTarGz([][2]string{
+				{"/.manifest", `{"revision": "r2", "roots": ["a"]}`},
+				{"/patch.json", tc.patch},
+			})
+			_, err := NewCustomReader(NewTarballLoaderWithBaseURL(buf, "")).Read()
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("Expected error containing %q but got: %v", tc.err, err)
+			}
+		})
+	}
+}
+
 func TestReadWithPatchExtraFiles(t *testing.T) {
 	cases := []struct {
 		note  string
@@ -924,6 +1008,27 @@ func TestReadRootValidation(t *testing.T) {
 			},
 			err: "manifest roots [a b c/d] do not permit data patch at path 'c/e'",
 		},
+		{
+			note: "index hints",
+			files: [][2]string{
+				{"/.manifest", `{"index_hints": [{"path": "data.a.p", "ref": "input.x", "value": 1, "count": 3}]}`},
+			},
+			err: "",
+		},
+		{
+			note: "err invalid index hint ref",
+			files: [][2]string{
+				{"/.manifest", `{"index_hints": [{"path": "data.a.p", "ref": "input[", "value": 1, "count": 3}]}`},
+			},
+			err: "manifest has invalid index hint for 'data.a.p'",
+		},
+		{
+			note: "err non-scalar index hint value",
+			files: [][2]string{
+				{"/.manifest", `{"index_hints": [{"path": "data.a.p", "ref": "input.x", "value": [1], "count": 3}]}`},
+			},
+			err: "manifest has invalid index hint for 'data.a.p': index hint value must be scalar",
+		},
 	}
 
 	for _, tc := range cases {
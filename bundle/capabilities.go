@@ -0,0 +1,30 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package bundle
+
+import (
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// RequiredCapabilities returns the minimal capabilities required by the
+// modules of b, so that OPAs activating b can be restricted to them. The Wasm
+// ABI versions of this version of OPA are included if b contains Wasm modules.
+func (b *Bundle) RequiredCapabilities() (*ast.Capabilities, error) {
+	modules := make(map[string]*ast.Module, len(b.Modules))
+	for _, m := range b.Modules {
+		modules[m.URL] = m.Parsed
+	}
+
+	c, err := ast.RequiredCapabilities(modules)
+	if err != nil {
+		return nil, err
+	}
+
+	required := *c
+	if len(b.WasmModules) > 0 {
+		required.WasmABIVersions = ast.CapabilitiesForThisVersion().WasmABIVersions
+	}
+	return &required, nil
+}
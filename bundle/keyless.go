@@ -0,0 +1,447 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package bundle provide helpers that assist in verifying Sigstore keyless bundle signatures
+package bundle
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+)
+
+// Fulcio certificate extensions that carry the OIDC issuer of the identity
+// the certificate was issued to. See
+// https://github.com/sigstore/fulcio/blob/main/docs/oid-info.md
+var (
+	oidFulcioIssuer   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	oidFulcioIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+// KeylessConfig represents the configuration used to verify bundles signed
+// with Sigstore keyless signing. Instead of a static public key, the bundle is
+// verified against a short-lived certificate issued by Fulcio to an OIDC
+// identity and, unless disabled, the entry that records the signature in the
+// Rekor transparency log.
+type KeylessConfig struct {
+	FulcioRoots    string            `json:"fulcio_roots"`
+	RekorPublicKey string            `json:"rekor_public_key,omitempty"`
+	IgnoreTlog     bool              `json:"ignore_tlog,omitempty"`
+	Identities     []KeylessIdentity `json:"identities"`
+}
+
+// KeylessIdentity is an OIDC identity that is trusted to sign bundles. The
+// subject is matched against the email and URI SANs of the signing
+// certificate.
+type KeylessIdentity struct {
+	Issuer        string `json:"issuer"`
+	Subject       string `json:"subject,omitempty"`
+	SubjectRegExp string `json:"subject_regex,omitempty"`
+}
+
+// KeylessSignature represents a Sigstore keyless signature of a bundle. The
+// payload is the base64 encoded JSON document that would otherwise be the
+// payload of the bundle signature JWT and the bundle is the output of
+// `cosign sign-blob --bundle` for that document.
+type KeylessSignature struct {
+	Payload string       `json:"payload"`
+	Bundle  CosignBundle `json:"bundle"`
+}
+
+// CosignBundle is the signature bundle generated by cosign.
+type CosignBundle struct {
+	Base64Signature string       `json:"base64Signature"`
+	Cert            string       `json:"cert"`
+	RekorBundle     *RekorBundle `json:"rekorBundle,omitempty"`
+}
+
+// RekorBundle contains the signed entry timestamp that proves the inclusion
+// of a signature in the Rekor transparency log.
+type RekorBundle struct {
+	SignedEntryTimestamp string       `json:"SignedEntryTimestamp"`
+	Payload              RekorPayload `json:"Payload"`
+}
+
+// RekorPayload is the transparency log entry signed by Rekor. Fields are
+// declared in lexicographic order so that the encoded payload matches the
+// canonical JSON form that Rekor signs.
+type RekorPayload struct {
+	Body           string `json:"body"`
+	IntegratedTime int64  `json:"integratedTime"`
+	LogID          string `json:"logID"`
+	LogIndex       int64  `json:"logIndex"`
+}
+
+// hashedRekord is the subset of the Rekor hashedrekord entry type needed to
+// tie a log entry to a signature.
+type hashedRekord struct {
+	Kind string `json:"kind"`
+	Spec struct {
+		Data struct {
+			Hash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash"`
+		} `json:"data"`
+		Signature struct {
+			Content   string `json:"content"`
+			PublicKey struct {
+				Content string `json:"content"`
+			} `json:"publicKey"`
+		} `json:"signature"`
+	} `json:"spec"`
+}
+
+// validateAndInjectDefaults validates the config. PEM values that refer to
+// files are replaced by the file contents.
+func (c *KeylessConfig) validateAndInjectDefaults() error {
+	var err error
+
+	if c.FulcioRoots == "" {
+		return fmt.Errorf("keyless: fulcio roots not provided")
+	}
+
+	if c.FulcioRoots, err = readPEMOrFile(c.FulcioRoots); err != nil {
+		return fmt.Errorf("keyless: %w", err)
+	}
+
+	if _, err := c.roots(); err != nil {
+		return err
+	}
+
+	if c.IgnoreTlog {
+		if c.RekorPublicKey != "" {
+			return fmt.Errorf("keyless: rekor public key cannot be set when the transparency log is ignored")
+		}
+	} else {
+		if c.RekorPublicKey == "" {
+			return fmt.Errorf("keyless: rekor public key not provided")
+		}
+
+		if c.RekorPublicKey, err = readPEMOrFile(c.RekorPublicKey); err != nil {
+			return fmt.Errorf("keyless: %w", err)
+		}
+
+		if _, err := c.rekorKey(); err != nil {
+			return err
+		}
+	}
+
+	if len(c.Identities) == 0 {
+		return fmt.Errorf("keyless: at least one identity must be provided")
+	}
+
+	for i, id := range c.Identities {
+		if id.Issuer == "" {
+			return fmt.Errorf("keyless: identity %d: issuer not provided", i)
+		}
+		if (id.Subject == "") == (id.SubjectRegExp == "") {
+			return fmt.Errorf("keyless: identity %d: exactly one of subject or subject_regex must be provided", i)
+		}
+		if id.SubjectRegExp != "" {
+			if _, err := regexp.Compile(id.SubjectRegExp); err != nil {
+				return fmt.Errorf("keyless: identity %d: invalid subject_regex: %w", i, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *KeylessConfig) roots() (*x509.CertPool, error) {
+	certs, err := parseCertificates([]byte(c.FulcioRoots))
+	if err != nil {
+		return nil, fmt.Errorf("keyless: invalid fulcio roots: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+	return pool, nil
+}
+
+func (c *KeylessConfig) rekorKey() (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(c.RekorPublicKey))
+	if block == nil {
+		return nil, fmt.Errorf("keyless: invalid rekor public key: failed to decode PEM")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("keyless: invalid rekor public key: %w", err)
+	}
+	return key, nil
+}
+
+// matches returns true if the issuer and any of the subjects match one of
+// the configured identities.
+func (c *KeylessConfig) matches(issuer string, subjects []string) bool {
+	for _, id := range c.Identities {
+		if id.Issuer != issuer {
+			continue
+		}
+		for _, s := range subjects {
+			if id.Subject != "" && id.Subject == s {
+				return true
+			}
+			if id.SubjectRegExp != "" && regexp.MustCompile(id.SubjectRegExp).MatchString(s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func verifyKeylessSignature(ks *KeylessSignature, bvc *VerificationConfig) (*DecodedSignature, error) {
+	kc := bvc.Keyless
+	if kc == nil {
+		return nil, fmt.Errorf("keyless verification not configured")
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(ks.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to base64 decode keyless payload: %w", err)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(ks.Bundle.Base64Signature)
+	if err != nil {
+		return nil, fmt.Errorf("failed to base64 decode keyless signature: %w", err)
+	}
+
+	certPEM, err := base64.StdEncoding.DecodeString(ks.Bundle.Cert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to base64 decode keyless certificate: %w", err)
+	}
+
+	chain, err := parseCertificates(certPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid keyless certificate: %w", err)
+	}
+	leaf := chain[0]
+
+	// Fulcio certificates are only valid for a few minutes so the chain is
+	// verified at the time the signature was recorded in the transparency log.
+	// Without the log, the only time available is the issuance time of the
+	// certificate itself.
+	signedAt := leaf.NotBefore
+
+	if !kc.IgnoreTlog {
+		if ks.Bundle.RekorBundle == nil {
+			return nil, fmt.Errorf("keyless signature missing transparency log entry")
+		}
+		if err := verifyRekorBundle(ks.Bundle.RekorBundle, kc, payload, sig, leaf); err != nil {
+			return nil, err
+		}
+		signedAt = time.Unix(ks.Bundle.RekorBundle.Payload.IntegratedTime, 0)
+	}
+
+	roots, err := kc.roots()
+	if err != nil {
+		return nil, err
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err = leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   signedAt,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify keyless certificate: %w", err)
+	}
+
+	issuer, err := certificateIssuer(leaf)
+	if err != nil {
+		return nil, err
+	}
+
+	subjects := append([]string{}, leaf.EmailAddresses...)
+	for _, uri := range leaf.URIs {
+		subjects = append(subjects, uri.String())
+	}
+
+	if !kc.matches(issuer, subjects) {
+		return nil, fmt.Errorf("keyless certificate identity %v (issuer %v) not trusted", subjects, issuer)
+	}
+
+	if err := verifyBlobSignature(leaf.PublicKey, payload, sig); err != nil {
+		return nil, fmt.Errorf("failed to verify keyless signature: %w", err)
+	}
+
+	var ds DecodedSignature
+	if err := json.Unmarshal(payload, &ds); err != nil {
+		return nil, err
+	}
+
+	if ds.Scope != bvc.Scope {
+		return nil, fmt.Errorf("scope mismatch")
+	}
+
+	return &ds, nil
+}
+
+// verifyRekorBundle verifies that the signed entry timestamp was issued by
+// the configured Rekor instance and that the log entry records the given
+// signature of payload by the certificate.
+func verifyRekorBundle(rb *RekorBundle, kc *KeylessConfig, payload, sig []byte, leaf *x509.Certificate) error {
+	key, err := kc.rekorKey()
+	if err != nil {
+		return err
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return err
+	}
+
+	logID := sha256.Sum256(der)
+	if rb.Payload.LogID != hex.EncodeToString(logID[:]) {
+		return fmt.Errorf("transparency log entry not issued by the configured rekor instance")
+	}
+
+	set, err := base64.StdEncoding.DecodeString(rb.SignedEntryTimestamp)
+	if err != nil {
+		return fmt.Errorf("failed to base64 decode signed entry timestamp: %w", err)
+	}
+
+	canonical, err := json.Marshal(rb.Payload)
+	if err != nil {
+		return err
+	}
+
+	if err := verifyBlobSignature(key, canonical, set); err != nil {
+		return fmt.Errorf("failed to verify signed entry timestamp: %w", err)
+	}
+
+	integratedTime := time.Unix(rb.Payload.IntegratedTime, 0)
+	if integratedTime.Before(leaf.NotBefore) || integratedTime.After(leaf.NotAfter) {
+		return fmt.Errorf("transparency log entry created outside the validity period of the keyless certificate")
+	}
+
+	body, err := base64.StdEncoding.DecodeString(rb.Payload.Body)
+	if err != nil {
+		return fmt.Errorf("failed to base64 decode transparency log entry: %w", err)
+	}
+
+	var entry hashedRekord
+	if err := json.Unmarshal(body, &entry); err != nil {
+		return fmt.Errorf("failed to parse transparency log entry: %w", err)
+	}
+
+	if entry.Kind != "hashedrekord" {
+		return fmt.Errorf("unsupported transparency log entry kind '%v'", entry.Kind)
+	}
+
+	digest := sha256.Sum256(payload)
+	if entry.Spec.Data.Hash.Algorithm != "sha256" || entry.Spec.Data.Hash.Value != hex.EncodeToString(digest[:]) {
+		return fmt.Errorf("transparency log entry does not match keyless payload")
+	}
+
+	entrySig, err := base64.StdEncoding.DecodeString(entry.Spec.Signature.Content)
+	if err != nil || !bytes.Equal(entrySig, sig) {
+		return fmt.Errorf("transparency log entry does not match keyless signature")
+	}
+
+	entryCertPEM, err := base64.StdEncoding.DecodeString(entry.Spec.Signature.PublicKey.Content)
+	if err != nil {
+		return fmt.Errorf("transparency log entry does not match keyless certificate")
+	}
+
+	entryCerts, err := parseCertificates(entryCertPEM)
+	if err != nil || !entryCerts[0].Equal(leaf) {
+		return fmt.Errorf("transparency log entry does not match keyless certificate")
+	}
+
+	return nil
+}
+
+func verifyBlobSignature(key crypto.PublicKey, data, sig []byte) error {
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		if !ecdsa.VerifyASN1(key, digest[:], sig) {
+			return errors.New("invalid signature")
+		}
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, data, sig) {
+			return errors.New("invalid signature")
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
+	}
+	return nil
+}
+
+func certificateIssuer(cert *x509.Certificate) (string, error) {
+	for _, ext := range cert.Extensions {
+		switch {
+		case ext.Id.Equal(oidFulcioIssuerV2):
+			var issuer string
+			if _, err := asn1.Unmarshal(ext.Value, &issuer); err != nil {
+				return "", fmt.Errorf("invalid keyless certificate issuer extension: %w", err)
+			}
+			return issuer, nil
+		case ext.Id.Equal(oidFulcioIssuer):
+			return string(ext.Value), nil
+		}
+	}
+	return "", fmt.Errorf("keyless certificate missing issuer extension")
+}
+
+func parseCertificates(bs []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, bs = pem.Decode(bs)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no PEM encoded certificates found")
+	}
+	return certs, nil
+}
+
+func readPEMOrFile(s string) (string, error) {
+	if block, _ := pem.Decode([]byte(s)); block != nil {
+		return s, nil
+	}
+	bs, err := os.ReadFile(s)
+	if err != nil {
+		return "", err
+	}
+	return string(bs), nil
+}
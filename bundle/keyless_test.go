@@ -0,0 +1,416 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package bundle
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type keylessFixture struct {
+	t          *testing.T
+	rootPEM    string
+	root       *x509.Certificate
+	rootKey    *ecdsa.PrivateKey
+	rekorPEM   string
+	rekorKey   *ecdsa.PrivateKey
+	notBefore  time.Time
+	integrated time.Time
+}
+
+func newKeylessFixture(t *testing.T) *keylessFixture {
+	t.Helper()
+
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-fulcio-root"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &rootKey.PublicKey, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rekorKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rekorDER, err := x509.MarshalPKIXPublicKey(&rekorKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &keylessFixture{
+		t:          t,
+		rootPEM:    string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		root:       root,
+		rootKey:    rootKey,
+		rekorPEM:   string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rekorDER})),
+		rekorKey:   rekorKey,
+		notBefore:  now.Add(-20 * time.Minute),
+		integrated: now.Add(-15 * time.Minute),
+	}
+}
+
+func (f *keylessFixture) config() *KeylessConfig {
+	return &KeylessConfig{
+		FulcioRoots:    f.rootPEM,
+		RekorPublicKey: f.rekorPEM,
+		Identities: []KeylessIdentity{
+			{Issuer: "https://accounts.example.com", Subject: "alice@example.com"},
+		},
+	}
+}
+
+// sign returns a keyless signature of payload by a short-lived certificate
+// issued to the given identity, and recorded in the transparency log.
+func (f *keylessFixture) sign(payload []byte, issuer, email string) *KeylessSignature {
+	f.t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	issuerExt, err := asn1.Marshal(issuer)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:    big.NewInt(2),
+		NotBefore:       f.notBefore,
+		NotAfter:        f.notBefore.Add(10 * time.Minute),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		EmailAddresses:  []string{email},
+		URIs:            []*url.URL{{Scheme: "https", Host: "github.com", Path: "/example/repo"}},
+		ExtraExtensions: []pkix.Extension{{Id: oidFulcioIssuerV2, Value: issuerExt}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, f.root, &key.PublicKey, f.rootKey)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	digest := sha256.Sum256(payload)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	var entry hashedRekord
+	entry.Kind = "hashedrekord"
+	entry.Spec.Data.Hash.Algorithm = "sha256"
+	entry.Spec.Data.Hash.Value = hex.EncodeToString(digest[:])
+	entry.Spec.Signature.Content = base64.StdEncoding.EncodeToString(sig)
+	entry.Spec.Signature.PublicKey.Content = base64.StdEncoding.EncodeToString(certPEM)
+
+	body, err := json.Marshal(entry)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	rekorDER, err := x509.MarshalPKIXPublicKey(&f.rekorKey.PublicKey)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	logID := sha256.Sum256(rekorDER)
+
+	rb := &RekorBundle{
+		Payload: RekorPayload{
+			Body:           base64.StdEncoding.EncodeToString(body),
+			IntegratedTime: f.integrated.Unix(),
+			LogID:          hex.EncodeToString(logID[:]),
+			LogIndex:       42,
+		},
+	}
+	f.signEntryTimestamp(rb)
+
+	return &KeylessSignature{
+		Payload: base64.StdEncoding.EncodeToString(payload),
+		Bundle: CosignBundle{
+			Base64Signature: base64.StdEncoding.EncodeToString(sig),
+			Cert:            base64.StdEncoding.EncodeToString(certPEM),
+			RekorBundle:     rb,
+		},
+	}
+}
+
+func (f *keylessFixture) signEntryTimestamp(rb *RekorBundle) {
+	f.t.Helper()
+
+	canonical, err := json.Marshal(rb.Payload)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	digest := sha256.Sum256(canonical)
+	set, err := ecdsa.SignASN1(rand.Reader, f.rekorKey, digest[:])
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	rb.SignedEntryTimestamp = base64.StdEncoding.EncodeToString(set)
+}
+
+func TestVerifyKeylessSignature(t *testing.T) {
+	f := newKeylessFixture(t)
+
+	payload := []byte(`{"files":[{"name":"data.json","hash":"abc","algorithm":"SHA-256"}],"scope":"write"}`)
+
+	tests := map[string]struct {
+		sig    func() *KeylessSignature
+		config func(*KeylessConfig)
+		scope  string
+		err    string
+	}{
+		"valid": {
+			sig:   func() *KeylessSignature { return f.sign(payload, "https://accounts.example.com", "alice@example.com") },
+			scope: "write",
+		},
+		"valid subject regex": {
+			sig: func() *KeylessSignature { return f.sign(payload, "https://accounts.example.com", "bob@example.com") },
+			config: func(c *KeylessConfig) {
+				c.Identities = []KeylessIdentity{{Issuer: "https://accounts.example.com", SubjectRegExp: `^https://github\.com/example/.*$`}}
+			},
+			scope: "write",
+		},
+		"scope mismatch": {
+			sig: func() *KeylessSignature { return f.sign(payload, "https://accounts.example.com", "alice@example.com") },
+			err: "scope mismatch",
+		},
+		"untrusted subject": {
+			sig: func() *KeylessSignature {
+				return f.sign(payload, "https://accounts.example.com", "mallory@example.com")
+			},
+			err: "keyless certificate identity [mallory@example.com https://github.com/example/repo] (issuer https://accounts.example.com) not trusted",
+		},
+		"untrusted issuer": {
+			sig: func() *KeylessSignature { return f.sign(payload, "https://evil.example.com", "alice@example.com") },
+			err: "(issuer https://evil.example.com) not trusted",
+		},
+		"untrusted root": {
+			sig: func() *KeylessSignature { return f.sign(payload, "https://accounts.example.com", "alice@example.com") },
+			config: func(c *KeylessConfig) {
+				c.FulcioRoots = newKeylessFixture(t).rootPEM
+			},
+			err: "failed to verify keyless certificate",
+		},
+		"tampered payload": {
+			sig: func() *KeylessSignature {
+				ks := f.sign(payload, "https://accounts.example.com", "alice@example.com")
+				ks.Payload = base64.StdEncoding.EncodeToString(bytes.Replace(payload, []byte("abc"), []byte("def"), 1))
+				return ks
+			},
+			err: "transparency log entry does not match keyless payload",
+		},
+		"tampered payload without tlog": {
+			sig: func() *KeylessSignature {
+				ks := f.sign(payload, "https://accounts.example.com", "alice@example.com")
+				ks.Payload = base64.StdEncoding.EncodeToString(bytes.Replace(payload, []byte("abc"), []byte("def"), 1))
+				return ks
+			},
+			config: func(c *KeylessConfig) {
+				c.IgnoreTlog = true
+				c.RekorPublicKey = ""
+			},
+			err: "failed to verify keyless signature: invalid signature",
+		},
+		"missing tlog entry": {
+			sig: func() *KeylessSignature {
+				ks := f.sign(payload, "https://accounts.example.com", "alice@example.com")
+				ks.Bundle.RekorBundle = nil
+				return ks
+			},
+			err: "keyless signature missing transparency log entry",
+		},
+		"ignore tlog": {
+			sig: func() *KeylessSignature {
+				ks := f.sign(payload, "https://accounts.example.com", "alice@example.com")
+				ks.Bundle.RekorBundle = nil
+				return ks
+			},
+			config: func(c *KeylessConfig) {
+				c.IgnoreTlog = true
+				c.RekorPublicKey = ""
+			},
+			scope: "write",
+		},
+		"bad signed entry timestamp": {
+			sig: func() *KeylessSignature {
+				ks := f.sign(payload, "https://accounts.example.com", "alice@example.com")
+				ks.Bundle.RekorBundle.Payload.LogIndex++
+				return ks
+			},
+			err: "failed to verify signed entry timestamp: invalid signature",
+		},
+		"other rekor instance": {
+			sig: func() *KeylessSignature { return f.sign(payload, "https://accounts.example.com", "alice@example.com") },
+			config: func(c *KeylessConfig) {
+				c.RekorPublicKey = newKeylessFixture(t).rekorPEM
+			},
+			err: "transparency log entry not issued by the configured rekor instance",
+		},
+		"integrated after certificate expiry": {
+			sig: func() *KeylessSignature {
+				ks := f.sign(payload, "https://accounts.example.com", "alice@example.com")
+				ks.Bundle.RekorBundle.Payload.IntegratedTime = f.notBefore.Add(time.Hour).Unix()
+				f.signEntryTimestamp(ks.Bundle.RekorBundle)
+				return ks
+			},
+			err: "transparency log entry created outside the validity period of the keyless certificate",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			kc := f.config()
+			if tc.config != nil {
+				tc.config(kc)
+			}
+			bvc := &VerificationConfig{Scope: tc.scope, Keyless: kc}
+			if err := bvc.ValidateAndInjectDefaults(nil); err != nil {
+				t.Fatal(err)
+			}
+
+			files, err := VerifyBundleSignature(SignaturesConfig{Keyless: tc.sig()}, bvc)
+			if tc.err != "" {
+				if err == nil {
+					t.Fatalf("Expected error %q but got nil", tc.err)
+				}
+				if !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("Expected error %q but got %q", tc.err, err.Error())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := files["data.json"]; !ok || len(files) != 1 {
+				t.Fatalf("Unexpected files %v", files)
+			}
+		})
+	}
+}
+
+func TestVerifyKeylessSignatureNotConfigured(t *testing.T) {
+	f := newKeylessFixture(t)
+	ks := f.sign([]byte(`{}`), "https://accounts.example.com", "alice@example.com")
+
+	_, err := VerifyBundleSignature(SignaturesConfig{Keyless: ks}, NewVerificationConfig(nil, "", "", nil))
+	if err == nil || err.Error() != "keyless verification not configured" {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	_, err = VerifyBundleSignature(SignaturesConfig{Keyless: ks, Signatures: []string{"foo"}}, &VerificationConfig{Keyless: f.config()})
+	if err == nil || !strings.Contains(err.Error(), "JWT and keyless signatures not supported together") {
+		t.Fatalf("Unexpected error %v", err)
+	}
+}
+
+func TestValidateAndInjectDefaultsKeylessConfig(t *testing.T) {
+	f := newKeylessFixture(t)
+
+	dir := t.TempDir()
+	rootFile := filepath.Join(dir, "root.pem")
+	if err := os.WriteFile(rootFile, []byte(f.rootPEM), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	identities := []KeylessIdentity{{Issuer: "https://accounts.example.com", Subject: "alice@example.com"}}
+
+	tests := map[string]struct {
+		config *VerificationConfig
+		err    string
+	}{
+		"valid": {
+			config: &VerificationConfig{Keyless: &KeylessConfig{FulcioRoots: rootFile, RekorPublicKey: f.rekorPEM, Identities: identities}},
+		},
+		"key id and keyless": {
+			config: &VerificationConfig{KeyID: "foo", Keyless: &KeylessConfig{FulcioRoots: f.rootPEM, RekorPublicKey: f.rekorPEM, Identities: identities}},
+			err:    "key id and keyless verification cannot both be configured",
+		},
+		"missing roots": {
+			config: &VerificationConfig{Keyless: &KeylessConfig{RekorPublicKey: f.rekorPEM, Identities: identities}},
+			err:    "keyless: fulcio roots not provided",
+		},
+		"bad roots": {
+			config: &VerificationConfig{Keyless: &KeylessConfig{FulcioRoots: f.rekorPEM, RekorPublicKey: f.rekorPEM, Identities: identities}},
+			err:    "keyless: invalid fulcio roots: no PEM encoded certificates found",
+		},
+		"missing rekor key": {
+			config: &VerificationConfig{Keyless: &KeylessConfig{FulcioRoots: f.rootPEM, Identities: identities}},
+			err:    "keyless: rekor public key not provided",
+		},
+		"rekor key with ignore tlog": {
+			config: &VerificationConfig{Keyless: &KeylessConfig{FulcioRoots: f.rootPEM, RekorPublicKey: f.rekorPEM, IgnoreTlog: true, Identities: identities}},
+			err:    "keyless: rekor public key cannot be set when the transparency log is ignored",
+		},
+		"missing identities": {
+			config: &VerificationConfig{Keyless: &KeylessConfig{FulcioRoots: f.rootPEM, RekorPublicKey: f.rekorPEM}},
+			err:    "keyless: at least one identity must be provided",
+		},
+		"identity missing issuer": {
+			config: &VerificationConfig{Keyless: &KeylessConfig{FulcioRoots: f.rootPEM, RekorPublicKey: f.rekorPEM, Identities: []KeylessIdentity{{Subject: "alice@example.com"}}}},
+			err:    "keyless: identity 0: issuer not provided",
+		},
+		"identity subject and regex": {
+			config: &VerificationConfig{Keyless: &KeylessConfig{FulcioRoots: f.rootPEM, RekorPublicKey: f.rekorPEM, Identities: []KeylessIdentity{{Issuer: "foo", Subject: "a", SubjectRegExp: "b"}}}},
+			err:    "keyless: identity 0: exactly one of subject or subject_regex must be provided",
+		},
+		"identity bad regex": {
+			config: &VerificationConfig{Keyless: &KeylessConfig{FulcioRoots: f.rootPEM, RekorPublicKey: f.rekorPEM, Identities: []KeylessIdentity{{Issuer: "foo", SubjectRegExp: "("}}}},
+			err:    "keyless: identity 0: invalid subject_regex",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.config.ValidateAndInjectDefaults(map[string]*KeyConfig{"foo": {Key: "secret", Algorithm: "HS256"}})
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("Expected error %q but got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.config.Keyless.FulcioRoots != f.rootPEM {
+				t.Fatalf("Expected fulcio roots to be read from file")
+			}
+		})
+	}
+}
@@ -28,9 +28,10 @@ type KeyConfig = keys.Config
 // VerificationConfig represents the key configuration used to verify a signed bundle
 type VerificationConfig struct {
 	PublicKeys map[string]*KeyConfig
-	KeyID      string   `json:"keyid"`
-	Scope      string   `json:"scope"`
-	Exclude    []string `json:"exclude_files"`
+	KeyID      string         `json:"keyid"`
+	Scope      string         `json:"scope"`
+	Exclude    []string       `json:"exclude_files"`
+	Keyless    *KeylessConfig `json:"keyless,omitempty"`
 }
 
 // NewVerificationConfig return a new VerificationConfig
@@ -60,6 +61,13 @@ func (vc *VerificationConfig) ValidateAndInjectDefaults(keys map[string]*KeyConf
 			return fmt.Errorf("key id %s not found", vc.KeyID)
 		}
 	}
+
+	if vc.Keyless != nil {
+		if vc.KeyID != "" {
+			return fmt.Errorf("key id and keyless verification cannot both be configured")
+		}
+		return vc.Keyless.validateAndInjectDefaults()
+	}
 	return nil
 }
 
@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/open-policy-agent/opa/ast"
 	iCompiler "github.com/open-policy-agent/opa/internal/compiler"
@@ -59,6 +60,10 @@ func metadataPath(name string) storage.Path {
 	return append(BundlesBasePath, name, "manifest", "metadata")
 }
 
+func indexHintsPath(name string) storage.Path {
+	return append(BundlesBasePath, name, "manifest", "index_hints")
+}
+
 // ReadBundleNamesFromStore will return a list of bundle names which have had their metadata stored.
 func ReadBundleNamesFromStore(ctx context.Context, store storage.Store, txn storage.Transaction) ([]string, error) {
 	value, err := store.Read(ctx, txn, BundlesBasePath)
@@ -269,6 +274,28 @@ func readMetadataFromStore(ctx context.Context, store storage.Store, txn storage
 	return data, nil
 }
 
+// ReadBundleIndexHintsFromStore returns the rule index hints in the specified
+// bundle. If the bundle is not activated or has no index hints, this function
+// returns no hints.
+func ReadBundleIndexHintsFromStore(ctx context.Context, store storage.Store, txn storage.Transaction, name string) ([]IndexHint, error) {
+	value, err := store.Read(ctx, txn, indexHintsPath(name))
+	if err != nil {
+		return nil, suppressNotFound(err)
+	}
+
+	bs, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt manifest index hints")
+	}
+
+	var hints []IndexHint
+	if err := util.UnmarshalJSON(bs, &hints); err != nil {
+		return nil, fmt.Errorf("corrupt manifest index hints")
+	}
+
+	return hints, nil
+}
+
 // ReadBundleEtagFromStore returns the etag for the specified bundle.
 // If the bundle is not activated, this function will return
 // storage NotFound error.
@@ -302,6 +329,7 @@ type ActivateOpts struct {
 	ExtraModules             map[string]*ast.Module // Optional
 	AuthorizationDecisionRef ast.Ref
 	ParserOptions            ast.ParserOptions
+	Parallelism              int // Optional, maximum number of goroutines used to parse and type check modules
 
 	legacy bool
 }
@@ -334,7 +362,7 @@ func Deactivate(opts *DeactivateOpts) error {
 			erase[root] = struct{}{}
 		}
 	}
-	_, err := eraseBundles(opts.Ctx, opts.Store, opts.Txn, opts.ParserOptions, opts.BundleNames, erase)
+	_, err := eraseBundles(opts.Ctx, opts.Store, opts.Txn, opts.ParserOptions, 0, opts.BundleNames, erase)
 	return err
 }
 
@@ -375,8 +403,9 @@ func activateBundles(opts *ActivateOpts) error {
 		return err
 	}
 
+	deltaModules := newDeltaModules()
 	if len(deltaBundles) != 0 {
-		err := activateDeltaBundles(opts, deltaBundles)
+		err := activateDeltaBundles(opts, deltaBundles, deltaModules)
 		if err != nil {
 			return err
 		}
@@ -384,7 +413,7 @@ func activateBundles(opts *ActivateOpts) error {
 
 	// Erase data and policies at new + old roots, and remove the old
 	// manifests before activating a new snapshot bundle.
-	remaining, err := eraseBundles(opts.Ctx, opts.Store, opts.Txn, opts.ParserOptions, names, erase)
+	remaining, err := eraseBundles(opts.Ctx, opts.Store, opts.Txn, opts.ParserOptions, opts.Parallelism, names, erase)
 	if err != nil {
 		return err
 	}
@@ -453,8 +482,24 @@ func activateBundles(opts *ActivateOpts) error {
 	for name, mod := range opts.ExtraModules {
 		remainingAndExtra[name] = mod
 	}
+	for name, mod := range deltaModules.upserted {
+		remainingAndExtra[name] = mod
+	}
+
+	if opts.Parallelism > 1 {
+		opts.Compiler = opts.Compiler.WithParallelism(opts.Parallelism)
+	}
 
-	err = compileModules(opts.Compiler, opts.Metrics, snapshotBundles, remainingAndExtra, opts.legacy, opts.AuthorizationDecisionRef)
+	hints, err := readIndexHints(opts, snapshotBundles)
+	if err != nil {
+		return err
+	}
+
+	if len(hints) > 0 {
+		opts.Compiler = opts.Compiler.WithIndexHints(hints)
+	}
+
+	err = compileModules(opts.Compiler, opts.Metrics, snapshotBundles, remainingAndExtra, deltaModules.removed, opts.legacy, opts.AuthorizationDecisionRef)
 	if err != nil {
 		return err
 	}
@@ -484,6 +529,43 @@ func activateBundles(opts *ActivateOpts) error {
 	return nil
 }
 
+// readIndexHints returns the rule index hints of the bundles being activated
+// and of the bundles that remain activated in the store.
+func readIndexHints(opts *ActivateOpts, bundles map[string]*Bundle) ([]*ast.IndexHint, error) {
+	var hints []IndexHint
+
+	for _, b := range bundles {
+		hints = append(hints, b.Manifest.IndexHints...)
+	}
+
+	names, err := ReadBundleNamesFromStore(opts.Ctx, opts.Store, opts.Txn)
+	if suppressNotFound(err) != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		if _, ok := bundles[name]; ok {
+			continue
+		}
+		stored, err := ReadBundleIndexHintsFromStore(opts.Ctx, opts.Store, opts.Txn, name)
+		if err != nil {
+			return nil, err
+		}
+		hints = append(hints, stored...)
+	}
+
+	result := make([]*ast.IndexHint, 0, len(hints))
+	for _, hint := range hints {
+		parsed, err := hint.Parse()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, parsed)
+	}
+
+	return result, nil
+}
+
 func doDFS(obj map[string]json.RawMessage, path string, roots []string) error {
 	if len(roots) == 1 && roots[0] == "" {
 		return nil
@@ -531,14 +613,46 @@ func doDFS(obj map[string]json.RawMessage, path string, roots []string) error {
 	return nil
 }
 
-func activateDeltaBundles(opts *ActivateOpts, bundles map[string]*Bundle) error {
+// DeltaConflictError is returned when the base revision of a delta bundle does
+// not match the revision of the activated bundle.
+type DeltaConflictError struct {
+	Name         string
+	BaseRevision string
+	Revision     string
+}
+
+func (e *DeltaConflictError) Error() string {
+	return fmt.Sprintf("delta bundle '%s' base revision '%s' does not match current revision '%s'", e.Name, e.BaseRevision, e.Revision)
+}
+
+// deltaModules records the modules upserted and removed by delta bundles.
+type deltaModules struct {
+	upserted map[string]*ast.Module
+	removed  map[string]struct{}
+}
+
+func newDeltaModules() *deltaModules {
+	return &deltaModules{
+		upserted: map[string]*ast.Module{},
+		removed:  map[string]struct{}{},
+	}
+}
+
+// activateDeltaBundles applies the data and module patches of the delta bundles
+// to the store. The changed modules are recorded in modules so that they can be
+// included in or excluded from compilation.
+func activateDeltaBundles(opts *ActivateOpts, bundles map[string]*Bundle, modules *deltaModules) error {
 
 	// Check that the manifest roots and wasm resolvers in the delta bundle
-	// match with those currently in the store
+	// match with those currently in the store, and that the patch applies
+	// to the current revision
 	for name, b := range bundles {
 		value, err := opts.Store.Read(opts.Ctx, opts.Txn, ManifestStoragePath(name))
 		if err != nil {
 			if storage.IsNotFound(err) {
+				if b.Patch.BaseRevision != "" {
+					return &DeltaConflictError{Name: name, BaseRevision: b.Patch.BaseRevision}
+				}
 				continue
 			}
 			return err
@@ -556,16 +670,25 @@ func activateDeltaBundles(opts *ActivateOpts, bundles map[string]*Bundle) error
 			return fmt.Errorf("corrupt manifest data: %w", err)
 		}
 
+		if b.Patch.BaseRevision != "" && b.Patch.BaseRevision != manifest.Revision {
+			return &DeltaConflictError{Name: name, BaseRevision: b.Patch.BaseRevision, Revision: manifest.Revision}
+		}
+
 		if !b.Manifest.equalWasmResolversAndRoots(manifest) {
 			return fmt.Errorf("delta bundle '%s' has wasm resolvers or manifest roots that are different from those in the store", name)
 		}
 	}
 
-	for _, b := range bundles {
+	for name, b := range bundles {
 		err := applyPatches(opts.Ctx, opts.Store, opts.Txn, b.Patch.Data)
 		if err != nil {
 			return err
 		}
+
+		err = applyModulePatches(opts, name, b.Patch.Modules, modules)
+		if err != nil {
+			return err
+		}
 	}
 
 	if err := ast.CheckPathConflicts(opts.Compiler, storage.NonEmpty(opts.Ctx, opts.Store, opts.Txn)); len(err) > 0 {
@@ -587,13 +710,13 @@ func activateDeltaBundles(opts *ActivateOpts, bundles map[string]*Bundle) error
 
 // erase bundles by name and roots. This will clear all policies and data at its roots and remove its
 // manifest from storage.
-func eraseBundles(ctx context.Context, store storage.Store, txn storage.Transaction, parserOpts ast.ParserOptions, names map[string]struct{}, roots map[string]struct{}) (map[string]*ast.Module, error) {
+func eraseBundles(ctx context.Context, store storage.Store, txn storage.Transaction, parserOpts ast.ParserOptions, parallelism int, names map[string]struct{}, roots map[string]struct{}) (map[string]*ast.Module, error) {
 
 	if err := eraseData(ctx, store, txn, roots); err != nil {
 		return nil, err
 	}
 
-	remaining, err := erasePolicies(ctx, store, txn, parserOpts, roots)
+	remaining, err := erasePolicies(ctx, store, txn, parserOpts, parallelism, roots)
 	if err != nil {
 		return nil, err
 	}
@@ -635,24 +758,31 @@ func eraseData(ctx context.Context, store storage.Store, txn storage.Transaction
 	return nil
 }
 
-func erasePolicies(ctx context.Context, store storage.Store, txn storage.Transaction, parserOpts ast.ParserOptions, roots map[string]struct{}) (map[string]*ast.Module, error) {
+func erasePolicies(ctx context.Context, store storage.Store, txn storage.Transaction, parserOpts ast.ParserOptions, parallelism int, roots map[string]struct{}) (map[string]*ast.Module, error) {
 
 	ids, err := store.ListPolicies(ctx, txn)
 	if err != nil {
 		return nil, err
 	}
 
-	remaining := map[string]*ast.Module{}
-
-	for _, id := range ids {
+	raw := make([]string, len(ids))
+	for i, id := range ids {
 		bs, err := store.GetPolicy(ctx, txn, id)
 		if err != nil {
 			return nil, err
 		}
-		module, err := ast.ParseModuleWithOpts(id, string(bs), parserOpts)
-		if err != nil {
-			return nil, err
-		}
+		raw[i] = string(bs)
+	}
+
+	modules, err := parseModules(ids, raw, parserOpts, parallelism)
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := map[string]*ast.Module{}
+
+	for i, id := range ids {
+		module := modules[i]
 		path, err := module.Package.Path.Ptr()
 		if err != nil {
 			return nil, err
@@ -675,6 +805,51 @@ func erasePolicies(ctx context.Context, store storage.Store, txn storage.Transac
 	return remaining, nil
 }
 
+// parseModules parses the raw modules with up to n goroutines. If any module
+// fails to parse, the error for the first such module is returned.
+func parseModules(ids []string, raw []string, parserOpts ast.ParserOptions, n int) ([]*ast.Module, error) {
+	modules := make([]*ast.Module, len(ids))
+	errs := make([]error, len(ids))
+
+	if n > len(ids) {
+		n = len(ids)
+	}
+
+	if n < 2 {
+		for i := range ids {
+			if modules[i], errs[i] = ast.ParseModuleWithOpts(ids[i], raw[i], parserOpts); errs[i] != nil {
+				return nil, errs[i]
+			}
+		}
+		return modules, nil
+	}
+
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				modules[i], errs[i] = ast.ParseModuleWithOpts(ids[i], raw[i], parserOpts)
+			}
+		}()
+	}
+
+	for i := range ids {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return modules, nil
+}
+
 func writeManifestToStore(opts *ActivateOpts, name string, manifest Manifest) error {
 	// Always write manifests to the named location. If the plugin is in the older style config
 	// then also write to the old legacy unnamed location.
@@ -759,16 +934,19 @@ func writeData(ctx context.Context, store storage.Store, txn storage.Transaction
 	return nil
 }
 
-func compileModules(compiler *ast.Compiler, m metrics.Metrics, bundles map[string]*Bundle, extraModules map[string]*ast.Module, legacy bool, authorizationDecisionRef ast.Ref) error {
+func compileModules(compiler *ast.Compiler, m metrics.Metrics, bundles map[string]*Bundle, extraModules map[string]*ast.Module, removedModules map[string]struct{}, legacy bool, authorizationDecisionRef ast.Ref) error {
 
 	m.Timer(metrics.RegoModuleCompile).Start()
 	defer m.Timer(metrics.RegoModuleCompile).Stop()
 
 	modules := map[string]*ast.Module{}
 
-	// preserve any modules already on the compiler
+	// preserve any modules already on the compiler, except for those removed
+	// by delta bundles
 	for name, module := range compiler.Modules {
-		modules[name] = module
+		if _, ok := removedModules[name]; !ok {
+			modules[name] = module
+		}
 	}
 
 	// preserve any modules passed in from the store
@@ -966,6 +1144,44 @@ func applyPatches(ctx context.Context, store storage.Store, txn storage.Transact
 	return nil
 }
 
+func applyModulePatches(opts *ActivateOpts, name string, patches []ModulePatchOperation, modules *deltaModules) error {
+	for _, pat := range patches {
+
+		// For backwards compatibility, in legacy mode, policies are stored
+		// at the unprefixed path.
+		id := pat.Path
+		if !opts.legacy {
+			id = modulePathWithPrefix(name, pat.Path)
+		}
+
+		switch pat.Op {
+		case "upsert":
+			module := pat.Parsed
+			if module == nil {
+				var err error
+				if module, err = ast.ParseModuleWithOpts(id, pat.Raw, opts.ParserOptions); err != nil {
+					return err
+				}
+			}
+			if err := opts.Store.UpsertPolicy(opts.Ctx, opts.Txn, id, []byte(pat.Raw)); err != nil {
+				return err
+			}
+			modules.upserted[id] = module
+			delete(modules.removed, id)
+		case "remove":
+			if err := opts.Store.DeletePolicy(opts.Ctx, opts.Txn, id); suppressNotFound(err) != nil {
+				return err
+			}
+			delete(modules.upserted, id)
+			modules.removed[id] = struct{}{}
+		default:
+			return fmt.Errorf("bad module patch operation: %v", pat.Op)
+		}
+	}
+
+	return nil
+}
+
 // Helpers for the older single (unnamed) bundle style manifest storage.
 
 // LegacyManifestStoragePath is the older unnamed bundle path for manifests to be stored.
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -23,6 +24,7 @@ import (
 	"github.com/open-policy-agent/opa/storage"
 	"github.com/open-policy-agent/opa/storage/disk"
 	inmem "github.com/open-policy-agent/opa/storage/inmem/test"
+	"github.com/open-policy-agent/opa/types"
 )
 
 func TestManifestStoreLifecycleSingleBundle(t *testing.T) {
@@ -4019,6 +4021,300 @@ func TestDeltaBundleActivate(t *testing.T) {
 	mockStore.AssertValid(t)
 }
 
+func TestDeltaBundleModulePatches(t *testing.T) {
+
+	ctx := context.Background()
+	mockStore := mock.New()
+
+	compiler := ast.NewCompiler()
+	m := metrics.New()
+
+	policy := "package a\np = true"
+	old := "package a.old\nq = true"
+
+	bundles := map[string]*Bundle{
+		"bundle1": {
+			Manifest: Manifest{
+				Revision: "r1",
+				Roots:    &[]string{"a"},
+			},
+			Modules: []ModuleFile{
+				{Path: "/a/policy.rego", Raw: []byte(policy), Parsed: ast.MustParseModule(policy)},
+				{Path: "/a/old.rego", Raw: []byte(old), Parsed: ast.MustParseModule(old)},
+			},
+		},
+	}
+
+	txn := storage.NewTransactionOrDie(ctx, mockStore, storage.WriteParams)
+
+	err := Activate(&ActivateOpts{Ctx: ctx, Store: mockStore, Txn: txn, Compiler: compiler, Metrics: m, Bundles: bundles})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := mockStore.Commit(ctx, txn); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Patch the modules using the same compiler.
+	updated := "package a\np = false"
+
+	deltaBundles := map[string]*Bundle{
+		"bundle1": {
+			Manifest: Manifest{
+				Revision: "r2",
+				Roots:    &[]string{"a"},
+			},
+			Patch: Patch{
+				BaseRevision: "r1",
+				Modules: []ModulePatchOperation{
+					{Op: "upsert", Path: "/a/policy.rego", Raw: updated, Parsed: ast.MustParseModule(updated)},
+					{Op: "remove", Path: "/a/old.rego"},
+				},
+			},
+		},
+	}
+
+	txn = storage.NewTransactionOrDie(ctx, mockStore, storage.WriteParams)
+
+	err = Activate(&ActivateOpts{Ctx: ctx, Store: mockStore, Txn: txn, Compiler: compiler, Metrics: m, Bundles: deltaBundles})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := mockStore.Commit(ctx, txn); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, ok := compiler.Modules["bundle1/a/old.rego"]; ok {
+		t.Fatal("expected removed module to be removed from the compiler")
+	}
+
+	mod, ok := compiler.Modules["bundle1/a/policy.rego"]
+	if !ok || !mod.Equal(ast.MustParseModule(updated)) {
+		t.Fatalf("expected updated module on the compiler but got %v", mod)
+	}
+
+	txn = storage.NewTransactionOrDie(ctx, mockStore)
+
+	ids, err := mockStore.ListPolicies(ctx, txn)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(ids) != 1 || ids[0] != "bundle1/a/policy.rego" {
+		t.Fatalf("expected only the updated policy in the store but got %v", ids)
+	}
+
+	bs, err := mockStore.GetPolicy(ctx, txn, "bundle1/a/policy.rego")
+	if err != nil || string(bs) != updated {
+		t.Fatalf("expected updated policy in the store but got %q (err: %v)", bs, err)
+	}
+
+	revision, err := ReadBundleRevisionFromStore(ctx, mockStore, txn, "bundle1")
+	if err != nil || revision != "r2" {
+		t.Fatalf("expected revision r2 but got %q (err: %v)", revision, err)
+	}
+
+	mockStore.Abort(ctx, txn)
+
+	// Applying the same patch again conflicts with the current revision.
+	txn = storage.NewTransactionOrDie(ctx, mockStore, storage.WriteParams)
+
+	err = Activate(&ActivateOpts{Ctx: ctx, Store: mockStore, Txn: txn, Compiler: compiler, Metrics: m, Bundles: deltaBundles})
+
+	var conflict *DeltaConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("expected delta conflict error but got %v", err)
+	}
+
+	if exp := "delta bundle 'bundle1' base revision 'r1' does not match current revision 'r2'"; err.Error() != exp {
+		t.Fatalf("expected error %q but got %q", exp, err)
+	}
+
+	mockStore.Abort(ctx, txn)
+
+	mockStore.AssertValid(t)
+}
+
+func TestDeltaBundleBaseRevisionWithoutActivatedBundle(t *testing.T) {
+
+	ctx := context.Background()
+	mockStore := mock.New()
+
+	deltaBundles := map[string]*Bundle{
+		"bundle1": {
+			Manifest: Manifest{
+				Revision: "r2",
+				Roots:    &[]string{"a"},
+			},
+			Patch: Patch{
+				BaseRevision: "r1",
+				Data:         []PatchOperation{{Op: "upsert", Path: "/a/b", Value: 1}},
+			},
+		},
+	}
+
+	txn := storage.NewTransactionOrDie(ctx, mockStore, storage.WriteParams)
+	defer mockStore.Abort(ctx, txn)
+
+	err := Activate(&ActivateOpts{Ctx: ctx, Store: mockStore, Txn: txn, Compiler: ast.NewCompiler(), Metrics: metrics.New(), Bundles: deltaBundles})
+
+	var conflict *DeltaConflictError
+	if !errors.As(err, &conflict) || conflict.Revision != "" {
+		t.Fatalf("expected delta conflict error but got %v", err)
+	}
+}
+
+func TestActivateBundlesParallelism(t *testing.T) {
+
+	ctx := context.Background()
+	mockStore := mock.New()
+
+	txn := storage.NewTransactionOrDie(ctx, mockStore, storage.WriteParams)
+	defer mockStore.Abort(ctx, txn)
+
+	// policies that are not owned by the bundle are parsed from the store
+	// during activation and compiled along with the bundle modules
+	for i := 0; i < 20; i++ {
+		id := fmt.Sprintf("other/policy%d.rego", i)
+		mod := fmt.Sprintf("package other.p%d\n\nq := data.a.p%d", i, i)
+		if err := mockStore.UpsertPolicy(ctx, txn, id, []byte(mod)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var modules []ModuleFile
+	for i := 0; i < 20; i++ {
+		mod := fmt.Sprintf("package a\n\np%d := %d", i, i)
+		modules = append(modules, ModuleFile{
+			Path:   fmt.Sprintf("a/policy%d.rego", i),
+			Raw:    []byte(mod),
+			Parsed: ast.MustParseModule(mod),
+		})
+	}
+
+	bundles := map[string]*Bundle{
+		"bundle1": {
+			Manifest: Manifest{Roots: &[]string{"a"}},
+			Data:     map[string]interface{}{},
+			Modules:  modules,
+		},
+	}
+
+	compiler := ast.NewCompiler()
+
+	err := Activate(&ActivateOpts{
+		Ctx:         ctx,
+		Store:       mockStore,
+		Txn:         txn,
+		Compiler:    compiler,
+		Metrics:     metrics.New(),
+		Bundles:     bundles,
+		Parallelism: 4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(compiler.Modules) != 40 {
+		t.Fatalf("expected 40 modules on the compiler but got %d", len(compiler.Modules))
+	}
+
+	if tpe := compiler.TypeEnv.Get(ast.MustParseRef("data.other.p7.q")); types.Compare(tpe, types.N) != 0 {
+		t.Fatalf("expected number type but got %v", tpe)
+	}
+}
+
+func TestActivateBundlesIndexHints(t *testing.T) {
+
+	ctx := context.Background()
+	mockStore := mock.New()
+
+	txn := storage.NewTransactionOrDie(ctx, mockStore, storage.WriteParams)
+	defer mockStore.Abort(ctx, txn)
+
+	newBundle := func(pkg string, hints []IndexHint) *Bundle {
+		mod := fmt.Sprintf("package %v\n\np { input.x == 1 }", pkg)
+		return &Bundle{
+			Manifest: Manifest{Roots: &[]string{pkg}, IndexHints: hints},
+			Data:     map[string]interface{}{},
+			Modules: []ModuleFile{{
+				Path:   pkg + "/policy.rego",
+				Raw:    []byte(mod),
+				Parsed: ast.MustParseModule(mod),
+			}},
+		}
+	}
+
+	hintsA := []IndexHint{{Path: "data.a.p", Ref: "input.x", Value: json.Number("1"), Count: 3}}
+	hintsB := []IndexHint{{Path: "data.b.p", Ref: "input.x", Value: json.Number("1"), Count: 5}}
+
+	activate := func(name string, b *Bundle) {
+		t.Helper()
+		err := Activate(&ActivateOpts{
+			Ctx:      ctx,
+			Store:    mockStore,
+			Txn:      txn,
+			Compiler: ast.NewCompiler(),
+			Metrics:  metrics.New(),
+			Bundles:  map[string]*Bundle{name: b},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	activate("bundle1", newBundle("a", hintsA))
+
+	stored, err := ReadBundleIndexHintsFromStore(ctx, mockStore, txn, "bundle1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(stored, hintsA) {
+		t.Fatalf("expected stored index hints %v but got %v", hintsA, stored)
+	}
+
+	// Hints of bundles that remain activated are combined with the hints of
+	// the bundles being activated.
+	b := newBundle("b", hintsB)
+	hints, err := readIndexHints(&ActivateOpts{Ctx: ctx, Store: mockStore, Txn: txn}, map[string]*Bundle{"bundle2": b})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hints) != 2 || !hints[0].Path.Equal(ast.MustParseRef("data.b.p")) || !hints[1].Path.Equal(ast.MustParseRef("data.a.p")) {
+		t.Fatalf("expected index hints for data.b.p and data.a.p but got %v", hints)
+	}
+
+	activate("bundle2", b)
+
+	// Bundles without hints have none stored.
+	activate("bundle3", newBundle("c", nil))
+
+	stored, err = ReadBundleIndexHintsFromStore(ctx, mockStore, txn, "bundle3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(stored) != 0 {
+		t.Fatalf("expected no stored index hints but got %v", stored)
+	}
+}
+
+func TestParseModulesParallelError(t *testing.T) {
+	ids := []string{"a.rego", "b.rego", "c.rego", "d.rego"}
+	raw := []string{"package a", "package b\n\np :=", "package c", "package d\n\nq :="}
+
+	for _, n := range []int{0, 4} {
+		_, err := parseModules(ids, raw, ast.ParserOptions{}, n)
+		if err == nil || !strings.Contains(err.Error(), "b.rego:") || strings.Contains(err.Error(), "d.rego:") {
+			t.Fatalf("expected parse error for b.rego with %d goroutines but got %v", n, err)
+		}
+	}
+}
+
 func TestDeltaBundleBadManifest(t *testing.T) {
 
 	ctx := context.Background()
@@ -4294,7 +4590,7 @@ func TestErasePolicies(t *testing.T) {
 			for _, root := range tc.roots {
 				roots[root] = struct{}{}
 			}
-			remaining, err := erasePolicies(ctx, mockStore, txn, ast.ParserOptions{}, roots)
+			remaining, err := erasePolicies(ctx, mockStore, txn, ast.ParserOptions{}, 0, roots)
 			if !tc.expectErr && err != nil {
 				t.Fatalf("unepected error: %s", err)
 			} else if tc.expectErr && err == nil {
@@ -51,7 +51,8 @@ func VerifyBundleSignature(sc SignaturesConfig, bvc *VerificationConfig) (map[st
 }
 
 // DefaultVerifier is the default bundle verification implementation. It verifies bundles by checking
-// the JWT signature using a locally-accessible public key.
+// the JWT signature using a locally-accessible public key, or the Sigstore keyless signature against
+// the configured identities.
 type DefaultVerifier struct{}
 
 // VerifyBundleSignature verifies the bundle signature using the given public keys or secret.
@@ -59,6 +60,22 @@ type DefaultVerifier struct{}
 func (*DefaultVerifier) VerifyBundleSignature(sc SignaturesConfig, bvc *VerificationConfig) (map[string]FileInfo, error) {
 	files := make(map[string]FileInfo)
 
+	if sc.Keyless != nil {
+		if len(sc.Signatures) > 0 {
+			return files, fmt.Errorf(".signatures.json: JWT and keyless signatures not supported together (expected exactly one)")
+		}
+
+		payload, err := verifyKeylessSignature(sc.Keyless, bvc)
+		if err != nil {
+			return files, err
+		}
+
+		for _, file := range payload.Files {
+			files[file.Name] = file
+		}
+		return files, nil
+	}
+
 	if len(sc.Signatures) == 0 {
 		return files, fmt.Errorf(".signatures.json: missing JWT (expected exactly one)")
 	}
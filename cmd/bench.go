@@ -13,6 +13,7 @@ import (
 	"math"
 	"net/http"
 	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -48,6 +49,9 @@ type benchmarkCommandParams struct {
 	gracefulShutdownPeriod int
 	shutdownWaitPeriod     int
 	configFile             string
+	baseline               string
+	regressionThresholds   []string
+	inputDir               string
 }
 
 const (
@@ -89,6 +93,23 @@ To enable more detailed analysis use the --metrics and --benchmem flags.
 To run benchmarks against a running OPA server to evaluate server overhead use the --e2e flag.
 
 The optional "gobench" output format conforms to the Go Benchmark Data Format.
+
+To compare the results against a previous run, save the JSON output of that run and pass it with
+the --baseline flag. For every result a table with the changes of ns/op, B/op, allocs/op and the
+percentiles of the metric histograms is printed. If any of them increases by more than the
+regression threshold (10% by default), the command exits with status code 2:
+
+	opa bench -b ./policy-bundle -i input.json --format json 'data.authz.allow' > baseline.json
+	opa bench -b ./policy-bundle -i input.json --baseline baseline.json 'data.authz.allow'
+
+Thresholds can be set for all metrics (--regression-threshold 5) or for individual ones
+(--regression-threshold allocs/op=0). The flag can be repeated.
+
+To characterize the performance of a query across different inputs, pass a directory of
+JSON or YAML input files with the --input-dir flag. The query is benchmarked against each
+input, and the results for every input are followed by aggregate statistics:
+
+	opa bench -b ./policy-bundle --input-dir ./inputs 'data.authz.allow'
 `,
 
 		PreRunE: func(cmd *cobra.Command, args []string) error {
@@ -115,6 +136,7 @@ The optional "gobench" output format conforms to the Go Benchmark Data Format.
 	addDataFlag(benchCommand.Flags(), &params.dataPaths)
 	addBundleFlag(benchCommand.Flags(), &params.bundlePaths)
 	addInputFlag(benchCommand.Flags(), &params.inputPath)
+	benchCommand.Flags().StringVar(&params.inputDir, "input-dir", "", "set path of a directory of input files to run the benchmark against each of them")
 	addImportFlag(benchCommand.Flags(), &params.imports)
 	addPackageFlag(benchCommand.Flags(), &params.pkg)
 	addQueryStdinFlag(benchCommand.Flags(), &params.stdin)
@@ -135,6 +157,8 @@ The optional "gobench" output format conforms to the Go Benchmark Data Format.
 
 	benchCommand.Flags().IntVar(&params.gracefulShutdownPeriod, "shutdown-grace-period", 10, "set the time (in seconds) that the server will wait to gracefully shut down. This flag is valid in 'e2e' mode only.")
 	benchCommand.Flags().IntVar(&params.shutdownWaitPeriod, "shutdown-wait-period", 0, "set the time (in seconds) that the server will wait before initiating shutdown. This flag is valid in 'e2e' mode only.")
+	benchCommand.Flags().StringVar(&params.baseline, "baseline", "", "set path of a file with JSON benchmark results to compare the results against")
+	benchCommand.Flags().StringArrayVar(&params.regressionThresholds, "regression-threshold", []string{}, "set the maximum increase (in percent) of all metrics, or of a single metric (<metric>=<percent>), compared to the baseline")
 
 	RootCommand.AddCommand(benchCommand)
 }
@@ -147,12 +171,30 @@ func benchMain(args []string, params benchmarkCommandParams, w io.Writer, r benc
 
 	ctx := context.Background()
 
+	baseline, err := newBenchmarkBaseline(params)
+	if err != nil {
+		errRender := renderBenchmarkError(params, err, w)
+		return 1, errRender
+	}
+
+	var inputs []benchmarkInput
+	if params.inputDir != "" {
+		inputs, err = readBenchmarkInputs(params)
+		if err != nil {
+			errRender := renderBenchmarkError(params, err, w)
+			return 1, errRender
+		}
+	}
+
 	if params.e2e {
-		err := benchE2E(ctx, args, params, w)
+		regression, err := benchE2E(ctx, args, params, baseline, w)
 		if err != nil {
 			errRender := renderBenchmarkError(params, err, w)
 			return 1, errRender
 		}
+		if regression {
+			return 2, nil
+		}
 		return 0, nil
 	}
 
@@ -201,7 +243,26 @@ func benchMain(args []string, params benchmarkCommandParams, w io.Writer, r benc
 		}
 	}
 
+	if inputs != nil {
+		for i := 0; i < params.count; i++ {
+			results := make([]benchmarkInputResult, 0, len(inputs))
+			for _, input := range inputs {
+				ictx := *ectx
+				ictx.evalArgs = append(ectx.evalArgs[:len(ectx.evalArgs):len(ectx.evalArgs)], rego.EvalParsedInput(input.value))
+				br, err := r.run(ctx, &ictx, params, benchFunc)
+				if err != nil {
+					errRender := renderBenchmarkError(params, fmt.Errorf("%v: %w", input.name, err), w)
+					return 1, errRender
+				}
+				results = append(results, benchmarkInputResult{Input: input.name, Result: br})
+			}
+			renderBenchmarkMatrix(params, results, w)
+		}
+		return 0, nil
+	}
+
 	// Run the benchmark as many times as specified, re-use the prepared objects for each
+	regression := false
 	for i := 0; i < params.count; i++ {
 		br, err := r.run(ctx, ectx, params, benchFunc)
 		if err != nil {
@@ -209,6 +270,13 @@ func benchMain(args []string, params benchmarkCommandParams, w io.Writer, r benc
 			return 1, errRender
 		}
 		renderBenchmarkResult(params, br, w)
+		if baseline != nil && renderBenchmarkComparison(params, baseline.compare(br), w) {
+			regression = true
+		}
+	}
+
+	if regression {
+		return 2, nil
 	}
 
 	return 0, nil
@@ -277,7 +345,7 @@ func (r *goBenchRunner) run(ctx context.Context, ectx *evalContext, params bench
 	return br, benchErr
 }
 
-func benchE2E(ctx context.Context, args []string, params benchmarkCommandParams, w io.Writer) error {
+func benchE2E(ctx context.Context, args []string, params benchmarkCommandParams, baseline *benchmarkBaseline, w io.Writer) (bool, error) {
 	host := "localhost"
 	port := 0
 
@@ -309,7 +377,7 @@ func benchE2E(ctx context.Context, args []string, params benchmarkCommandParams,
 
 	rt, err := runtime.NewRuntime(ctx, rtParams)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	cctx, cancel := context.WithCancel(ctx)
@@ -325,7 +393,7 @@ func benchE2E(ctx context.Context, args []string, params benchmarkCommandParams,
 	select {
 	case err := <-done:
 		if err != nil {
-			return err
+			return false, err
 		}
 	case <-initChannel:
 		break
@@ -346,23 +414,23 @@ func benchE2E(ctx context.Context, args []string, params benchmarkCommandParams,
 		// We have an address to parse the port from.
 		port, err = strconv.Atoi(strings.Split(rt.Addrs()[0], ":")[1])
 		if err != nil {
-			return err
+			return false, err
 		}
 		break
 	}
 	// Check for port still being unbound after retry loop.
 	if port == 0 {
-		return fmt.Errorf("unable to bind a port for bench testing")
+		return false, fmt.Errorf("unable to bind a port for bench testing")
 	}
 
 	query, err := readQuery(params, args)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	input, err := readInputBytes(params.evalCommandParams)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	// Wrap input in "input" attribute
@@ -370,7 +438,7 @@ func benchE2E(ctx context.Context, args []string, params benchmarkCommandParams,
 
 	if input != nil {
 		if err = util.Unmarshal(input, &inp); err != nil {
-			return err
+			return false, err
 		}
 	}
 
@@ -386,7 +454,7 @@ func benchE2E(ctx context.Context, args []string, params benchmarkCommandParams,
 	} else {
 		_, err := ast.ParseBody(query)
 		if err != nil {
-			return fmt.Errorf("error occurred while parsing query")
+			return false, fmt.Errorf("error occurred while parsing query")
 		}
 
 		if strings.HasPrefix(query, "data.") {
@@ -402,14 +470,18 @@ func benchE2E(ctx context.Context, args []string, params benchmarkCommandParams,
 		url += "?metrics=true"
 	}
 
+	regression := false
 	for i := 0; i < params.count; i++ {
 		br, err := runE2E(params, url, body)
 		if err != nil {
-			return err
+			return false, err
 		}
 		renderBenchmarkResult(params, br, w)
+		if baseline != nil && renderBenchmarkComparison(params, baseline.compare(br), w) {
+			regression = true
+		}
 	}
-	return nil
+	return regression, nil
 }
 
 func runE2E(params benchmarkCommandParams, url string, input map[string]interface{}) (testing.BenchmarkResult, error) {
@@ -619,6 +691,361 @@ func renderBenchmarkResult(params benchmarkCommandParams, br testing.BenchmarkRe
 	}
 }
 
+type benchmarkInput struct {
+	name  string
+	value ast.Value
+}
+
+type benchmarkInputResult struct {
+	Input  string                  `json:"input"`
+	Result testing.BenchmarkResult `json:"result"`
+}
+
+// benchmarkAggregate summarizes the results of a benchmark over multiple
+// inputs. The means weigh every input equally, regardless of its samples.
+type benchmarkAggregate struct {
+	Inputs          int     `json:"inputs"`
+	N               int     `json:"N"`
+	MeanNsPerOp     float64 `json:"mean_ns_per_op"`
+	MinNsPerOp      float64 `json:"min_ns_per_op"`
+	MinInput        string  `json:"min_input"`
+	MaxNsPerOp      float64 `json:"max_ns_per_op"`
+	MaxInput        string  `json:"max_input"`
+	MeanBytesPerOp  float64 `json:"mean_bytes_per_op,omitempty"`
+	MeanAllocsPerOp float64 `json:"mean_allocs_per_op,omitempty"`
+}
+
+type benchmarkMatrix struct {
+	Inputs    []benchmarkInputResult `json:"inputs"`
+	Aggregate benchmarkAggregate     `json:"aggregate"`
+}
+
+// readBenchmarkInputs reads the JSON and YAML files in the input directory,
+// sorted by name.
+func readBenchmarkInputs(params benchmarkCommandParams) ([]benchmarkInput, error) {
+	switch {
+	case params.inputPath != "" || params.stdinInput:
+		return nil, fmt.Errorf("--input-dir cannot be used with --input or --stdin-input")
+	case params.e2e:
+		return nil, fmt.Errorf("--input-dir cannot be used with --e2e")
+	case params.baseline != "":
+		return nil, fmt.Errorf("--input-dir cannot be used with --baseline")
+	}
+
+	entries, err := os.ReadDir(params.inputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var inputs []benchmarkInput
+	for _, entry := range entries {
+		switch filepath.Ext(entry.Name()) {
+		case ".json", ".yaml", ".yml":
+		default:
+			continue
+		}
+		if entry.IsDir() {
+			continue
+		}
+
+		bs, err := os.ReadFile(filepath.Join(params.inputDir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var input interface{}
+		if err := util.Unmarshal(bs, &input); err != nil {
+			return nil, fmt.Errorf("unable to parse input %v: %s", entry.Name(), err.Error())
+		}
+		value, err := ast.InterfaceToValue(input)
+		if err != nil {
+			return nil, fmt.Errorf("unable to process input %v: %s", entry.Name(), err.Error())
+		}
+		inputs = append(inputs, benchmarkInput{name: entry.Name(), value: value})
+	}
+
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("no input files found in %v", params.inputDir)
+	}
+
+	return inputs, nil
+}
+
+func aggregateBenchmarkResults(results []benchmarkInputResult) benchmarkAggregate {
+	agg := benchmarkAggregate{
+		Inputs: len(results),
+	}
+
+	for i, r := range results {
+		agg.N += r.Result.N
+
+		var nsPerOp float64
+		if r.Result.N > 0 {
+			nsPerOp = float64(r.Result.T.Nanoseconds()) / float64(r.Result.N)
+		}
+		agg.MeanNsPerOp += nsPerOp
+		if i == 0 || nsPerOp < agg.MinNsPerOp {
+			agg.MinNsPerOp, agg.MinInput = nsPerOp, r.Input
+		}
+		if i == 0 || nsPerOp > agg.MaxNsPerOp {
+			agg.MaxNsPerOp, agg.MaxInput = nsPerOp, r.Input
+		}
+
+		agg.MeanBytesPerOp += float64(r.Result.AllocedBytesPerOp())
+		agg.MeanAllocsPerOp += float64(r.Result.AllocsPerOp())
+	}
+
+	if n := float64(len(results)); n > 0 {
+		agg.MeanNsPerOp /= n
+		agg.MeanBytesPerOp /= n
+		agg.MeanAllocsPerOp /= n
+	}
+
+	return agg
+}
+
+func renderBenchmarkMatrix(params benchmarkCommandParams, results []benchmarkInputResult, w io.Writer) {
+	agg := aggregateBenchmarkResults(results)
+
+	switch params.outputFormat.String() {
+	case evalJSONOutput:
+		_ = presentation.JSON(w, benchmarkMatrix{Inputs: results, Aggregate: agg})
+	case benchmarkGoBenchOutput:
+		for _, r := range results {
+			fmt.Fprintf(w, "BenchmarkOPAEval/%s\t%s", strings.ReplaceAll(r.Input, " ", "_"), r.Result.String())
+			if params.benchMem {
+				fmt.Fprintf(w, "\t%s", r.Result.MemString())
+			}
+			fmt.Fprintf(w, "\n")
+		}
+	default:
+		for _, r := range results {
+			fmt.Fprintf(w, "input: %s\n", r.Input)
+			renderBenchmarkResult(params, r.Result, w)
+			fmt.Fprintln(w)
+		}
+
+		data := [][]string{
+			{"inputs", fmt.Sprintf("%d", agg.Inputs)},
+			{"samples", fmt.Sprintf("%d", agg.N)},
+			{"ns/op (mean)", prettyFormatFloat(agg.MeanNsPerOp)},
+			{"ns/op (min)", fmt.Sprintf("%s (%s)", prettyFormatFloat(agg.MinNsPerOp), agg.MinInput)},
+			{"ns/op (max)", fmt.Sprintf("%s (%s)", prettyFormatFloat(agg.MaxNsPerOp), agg.MaxInput)},
+		}
+		if params.benchMem {
+			data = append(data,
+				[]string{"B/op (mean)", prettyFormatFloat(agg.MeanBytesPerOp)},
+				[]string{"allocs/op (mean)", prettyFormatFloat(agg.MeanAllocsPerOp)},
+			)
+		}
+
+		fmt.Fprintln(w, "aggregate:")
+		table := tablewriter.NewWriter(w)
+		table.AppendBulk(data)
+		table.Render()
+	}
+}
+
+const defaultRegressionThreshold = 10.0
+
+// benchmarkBaseline holds the (averaged) metrics of previously saved benchmark
+// results and the thresholds for each metric beyond which an increase is
+// considered a regression.
+type benchmarkBaseline struct {
+	metrics          map[string]float64
+	thresholds       map[string]float64
+	defaultThreshold float64
+}
+
+type benchmarkComparison struct {
+	Metrics    []benchmarkDelta `json:"metrics"`
+	Regression bool             `json:"regression"`
+}
+
+type benchmarkDelta struct {
+	Name      string   `json:"name"`
+	Baseline  float64  `json:"baseline"`
+	Current   float64  `json:"current"`
+	Delta     *float64 `json:"delta,omitempty"` // in percent, unset if the baseline is zero
+	Threshold float64  `json:"threshold"`
+	Exceeded  bool     `json:"regression"`
+}
+
+func newBenchmarkBaseline(params benchmarkCommandParams) (*benchmarkBaseline, error) {
+	if params.baseline == "" {
+		if len(params.regressionThresholds) > 0 {
+			return nil, fmt.Errorf("--regression-threshold requires --baseline")
+		}
+		return nil, nil
+	}
+
+	if params.outputFormat.String() == benchmarkGoBenchOutput {
+		return nil, fmt.Errorf("--baseline cannot be used with output format %s", benchmarkGoBenchOutput)
+	}
+
+	baseline := &benchmarkBaseline{
+		metrics:          map[string]float64{},
+		thresholds:       map[string]float64{},
+		defaultThreshold: defaultRegressionThreshold,
+	}
+
+	for _, t := range params.regressionThresholds {
+		name, value, ok := strings.Cut(t, "=")
+		if !ok {
+			name, value = "", t
+		}
+		threshold, err := strconv.ParseFloat(value, 64)
+		if err != nil || threshold < 0 {
+			return nil, fmt.Errorf("invalid regression threshold %q: must be a non-negative percentage", t)
+		}
+		if name == "" {
+			baseline.defaultThreshold = threshold
+		} else {
+			baseline.thresholds[name] = threshold
+		}
+	}
+
+	bs, err := os.ReadFile(params.baseline)
+	if err != nil {
+		return nil, err
+	}
+
+	// The file may contain several results (e.g., from --count) and the
+	// comparisons that are output along with them when a baseline is used.
+	// Only the results are considered and their metrics are averaged.
+	counts := map[string]int{}
+	decoder := util.NewJSONDecoder(bytes.NewReader(bs))
+	for {
+		var br testing.BenchmarkResult
+		if err := decoder.Decode(&br); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid baseline %v: %w", params.baseline, err)
+		}
+		for name, value := range benchmarkMetrics(br) {
+			baseline.metrics[name] += value
+			counts[name]++
+		}
+	}
+
+	if len(counts) == 0 {
+		return nil, fmt.Errorf("invalid baseline %v: no benchmark results found", params.baseline)
+	}
+
+	for name, n := range counts {
+		baseline.metrics[name] /= float64(n)
+	}
+
+	return baseline, nil
+}
+
+// benchmarkMetrics returns the metrics of the benchmark result that are
+// compared against a baseline: the time and allocations per operation and the
+// percentiles of the metric histograms.
+func benchmarkMetrics(br testing.BenchmarkResult) map[string]float64 {
+	m := map[string]float64{}
+	if br.N <= 0 {
+		return m
+	}
+
+	m["ns/op"] = float64(br.T.Nanoseconds()) / float64(br.N)
+	if br.MemAllocs > 0 || br.MemBytes > 0 {
+		m["B/op"] = float64(br.AllocedBytesPerOp())
+		m["allocs/op"] = float64(br.AllocsPerOp())
+	}
+
+	for name, value := range br.Extra {
+		if strings.HasSuffix(name, "_median") || strings.HasSuffix(name, "%") {
+			m[name] = value
+		}
+	}
+
+	return m
+}
+
+func (b *benchmarkBaseline) compare(br testing.BenchmarkResult) benchmarkComparison {
+	current := benchmarkMetrics(br)
+
+	names := make([]string, 0, len(current))
+	for name := range current {
+		if _, ok := b.metrics[name]; ok {
+			names = append(names, name)
+		}
+	}
+
+	// Keep the order of the result table: ns/op and the allocations first,
+	// followed by the histogram percentiles.
+	rank := map[string]int{"ns/op": 0, "B/op": 1, "allocs/op": 2}
+	sort.Slice(names, func(i, j int) bool {
+		ri, oki := rank[names[i]]
+		rj, okj := rank[names[j]]
+		switch {
+		case oki && okj:
+			return ri < rj
+		case oki != okj:
+			return oki
+		default:
+			return names[i] < names[j]
+		}
+	})
+
+	c := benchmarkComparison{
+		Metrics: make([]benchmarkDelta, 0, len(names)),
+	}
+
+	for _, name := range names {
+		d := benchmarkDelta{
+			Name:      name,
+			Baseline:  b.metrics[name],
+			Current:   current[name],
+			Threshold: b.defaultThreshold,
+		}
+		if t, ok := b.thresholds[name]; ok {
+			d.Threshold = t
+		}
+		if d.Baseline != 0 {
+			delta := (d.Current - d.Baseline) / d.Baseline * 100
+			d.Delta = &delta
+			d.Exceeded = delta > d.Threshold
+		} else {
+			d.Exceeded = d.Current > 0
+		}
+		c.Regression = c.Regression || d.Exceeded
+		c.Metrics = append(c.Metrics, d)
+	}
+
+	return c
+}
+
+// renderBenchmarkComparison outputs the comparison of a benchmark result with
+// the baseline and returns true if any metric regressed.
+func renderBenchmarkComparison(params benchmarkCommandParams, c benchmarkComparison, w io.Writer) bool {
+	switch params.outputFormat.String() {
+	case evalJSONOutput:
+		_ = presentation.JSON(w, c)
+	default:
+		data := make([][]string, 0, len(c.Metrics))
+		for _, d := range c.Metrics {
+			delta := "n/a"
+			if d.Delta != nil {
+				delta = fmt.Sprintf("%+.2f%%", *d.Delta)
+			}
+			status := "ok"
+			if d.Exceeded {
+				status = fmt.Sprintf("REGRESSION (> %v%%)", d.Threshold)
+			}
+			data = append(data, []string{d.Name, prettyFormatFloat(d.Baseline), prettyFormatFloat(d.Current), delta, status})
+		}
+
+		table := tablewriter.NewWriter(w)
+		table.SetHeader([]string{"metric", "baseline", "current", "delta", ""})
+		table.SetAutoFormatHeaders(false)
+		table.AppendBulk(data)
+		table.Render()
+	}
+
+	return c.Regression
+}
+
 func renderBenchmarkError(params benchmarkCommandParams, err error, w io.Writer) error {
 	o := presentation.Output{
 		Errors: presentation.NewOutputErrors(err),
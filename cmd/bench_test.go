@@ -14,6 +14,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/bundle"
@@ -1268,6 +1269,418 @@ func testPrettyBenchmarkOutput(t *testing.T, params benchmarkCommandParams) {
 	}
 }
 
+func TestBenchMainWithBaseline(t *testing.T) {
+	// The baseline contains two results, and the comparison output along with
+	// the second one, which must be ignored.
+	base1 := fakeBenchResults()
+	base1.T = base1.T * 9 / 10
+	base2 := fakeBenchResults()
+	base2.T = base2.T * 11 / 10
+
+	var baseline bytes.Buffer
+	_ = presentation.JSON(&baseline, base1)
+	_ = presentation.JSON(&baseline, base2)
+	_ = presentation.JSON(&baseline, benchmarkComparison{Regression: true})
+
+	slower := fakeBenchResults()
+	slower.T = slower.T * 6 / 5
+
+	cases := []struct {
+		note       string
+		result     testing.BenchmarkResult
+		thresholds []string
+		expRC      int
+	}{
+		{
+			note:   "unchanged",
+			result: fakeBenchResults(),
+			expRC:  0,
+		},
+		{
+			note:   "regression",
+			result: slower,
+			expRC:  2,
+		},
+		{
+			note:       "regression within threshold",
+			result:     slower,
+			thresholds: []string{"25"},
+			expRC:      0,
+		},
+		{
+			note:       "regression within metric threshold",
+			result:     slower,
+			thresholds: []string{"0", "ns/op=25"},
+			expRC:      0,
+		},
+		{
+			note:       "regression exceeds metric threshold",
+			result:     slower,
+			thresholds: []string{"25", "ns/op=5"},
+			expRC:      2,
+		},
+	}
+
+	files := map[string]string{
+		"baseline.json": baseline.String(),
+	}
+
+	test.WithTempFS(files, func(root string) {
+		for _, tc := range cases {
+			t.Run(tc.note, func(t *testing.T) {
+				params := testBenchParams()
+				params.baseline = filepath.Join(root, "baseline.json")
+				params.regressionThresholds = tc.thresholds
+
+				mockRunner := &mockBenchRunner{}
+				mockRunner.onRun = func(context.Context, *evalContext, benchmarkCommandParams, func(context.Context, ...rego.EvalOption) error) (testing.BenchmarkResult, error) {
+					return tc.result, nil
+				}
+
+				var buf bytes.Buffer
+				rc, err := benchMain([]string{"1+1"}, params, &buf, mockRunner)
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				if rc != tc.expRC {
+					t.Fatalf("Unexpected return code %d, expected %d:\n%s", rc, tc.expRC, buf.String())
+				}
+
+				decoder := json.NewDecoder(&buf)
+				var br testing.BenchmarkResult
+				if err := decoder.Decode(&br); err != nil {
+					t.Fatal(err)
+				}
+				var c benchmarkComparison
+				if err := decoder.Decode(&c); err != nil {
+					t.Fatal(err)
+				}
+				if c.Regression != (tc.expRC == 2) {
+					t.Fatalf("Unexpected regression in comparison: %+v", c)
+				}
+
+				// ns/op, B/op, allocs/op, 6 percentiles and the median
+				if len(c.Metrics) != 10 || c.Metrics[0].Name != "ns/op" {
+					t.Fatalf("Unexpected metrics in comparison: %+v", c.Metrics)
+				}
+				if c.Metrics[0].Baseline != float64(fakeBenchResults().T.Nanoseconds())/float64(fakeBenchResults().N) {
+					t.Fatalf("Expected baseline to be averaged, got %v", c.Metrics[0].Baseline)
+				}
+			})
+		}
+	})
+}
+
+func TestBenchMainWithBaselineErrors(t *testing.T) {
+	files := map[string]string{
+		"baseline.json": `{"N": 100, "T": 1000}`,
+		"empty.json":    ``,
+		"invalid.json":  `{"N": "foo"}`,
+	}
+
+	cases := []struct {
+		note       string
+		baseline   string
+		format     string
+		thresholds []string
+		expErr     string
+	}{
+		{
+			note:     "missing file",
+			baseline: "missing.json",
+			expErr:   "no such file or directory",
+		},
+		{
+			note:     "empty file",
+			baseline: "empty.json",
+			expErr:   "no benchmark results found",
+		},
+		{
+			note:     "invalid file",
+			baseline: "invalid.json",
+			expErr:   "invalid baseline",
+		},
+		{
+			note:       "invalid threshold",
+			baseline:   "baseline.json",
+			thresholds: []string{"ns/op=fast"},
+			expErr:     `invalid regression threshold \"ns/op=fast\"`,
+		},
+		{
+			note:       "negative threshold",
+			baseline:   "baseline.json",
+			thresholds: []string{"-1"},
+			expErr:     `invalid regression threshold \"-1\"`,
+		},
+		{
+			note:       "threshold without baseline",
+			thresholds: []string{"5"},
+			expErr:     "--regression-threshold requires --baseline",
+		},
+		{
+			note:     "gobench output",
+			baseline: "baseline.json",
+			format:   benchmarkGoBenchOutput,
+			expErr:   "--baseline cannot be used with output format gobench",
+		},
+	}
+
+	test.WithTempFS(files, func(root string) {
+		for _, tc := range cases {
+			t.Run(tc.note, func(t *testing.T) {
+				params := testBenchParams()
+				params.regressionThresholds = tc.thresholds
+				if tc.baseline != "" {
+					params.baseline = filepath.Join(root, tc.baseline)
+				}
+				if tc.format != "" {
+					_ = params.outputFormat.Set(tc.format)
+				}
+
+				var buf bytes.Buffer
+				rc, err := benchMain([]string{"1+1"}, params, &buf, &mockBenchRunner{})
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				if rc != 1 {
+					t.Fatalf("Unexpected return code %d, expected 1", rc)
+				}
+				if !strings.Contains(buf.String(), tc.expErr) {
+					t.Fatalf("Expected error %q, got:\n%s", tc.expErr, buf.String())
+				}
+			})
+		}
+	})
+}
+
+func TestRenderBenchmarkComparisonPrettyOutput(t *testing.T) {
+	params := testBenchParams()
+	_ = params.outputFormat.Set(evalPrettyOutput)
+
+	baseline := &benchmarkBaseline{
+		metrics: map[string]float64{
+			"ns/op":                                  1000,
+			"allocs/op":                              0,
+			"B/op":                                   100,
+			"histogram_timer_rego_query_eval_ns_99%": 2000,
+		},
+		thresholds:       map[string]float64{"B/op": 50},
+		defaultThreshold: 10,
+	}
+
+	br := testing.BenchmarkResult{
+		N:         10,
+		T:         12000,
+		MemAllocs: 10,
+		MemBytes:  1200,
+		Extra: map[string]float64{
+			"histogram_timer_rego_query_eval_ns_99%":   1800,
+			"histogram_timer_rego_query_eval_ns_count": 10,
+		},
+	}
+
+	var buf bytes.Buffer
+	if !renderBenchmarkComparison(params, baseline.compare(br), &buf) {
+		t.Fatal("Expected regression")
+	}
+
+	expected := `+----------------------------------------+------------+---------------+---------+--------------------+
+|                 metric                 |  baseline  |    current    |  delta  |                    |
++----------------------------------------+------------+---------------+---------+--------------------+
+| ns/op                                  |       1000 |          1200 | +20.00% | REGRESSION (> 10%) |
+| B/op                                   |        100 |           120 | +20.00% | ok                 |
+| allocs/op                              |          0 |          1.00 | n/a     | REGRESSION (> 10%) |
+| histogram_timer_rego_query_eval_ns_99% |       2000 |          1800 | -10.00% | ok                 |
++----------------------------------------+------------+---------------+---------+--------------------+
+`
+	if buf.String() != expected {
+		t.Fatalf("\nExpected:\n%s\n\nGot:\n%s\n", expected, buf.String())
+	}
+}
+
+func TestBenchMainWithInputDir(t *testing.T) {
+	files := map[string]string{
+		"inputs/a.json":    `{"x": 1}`,
+		"inputs/b.yaml":    `x: 2`,
+		"inputs/c.yml":     `x: 1`,
+		"inputs/README.md": `not an input`,
+	}
+
+	test.WithTempFS(files, func(root string) {
+		params := testBenchParams()
+		params.inputDir = filepath.Join(root, "inputs")
+		params.fail = true
+		params.count = 2
+
+		var evalErrs []error
+		mockRunner := &mockBenchRunner{}
+		mockRunner.onRun = func(ctx context.Context, ectx *evalContext, _ benchmarkCommandParams, f func(context.Context, ...rego.EvalOption) error) (testing.BenchmarkResult, error) {
+			evalErrs = append(evalErrs, f(ctx, ectx.evalArgs...))
+			n := len(evalErrs)
+			return testing.BenchmarkResult{N: 10, T: time.Duration(n * 1000), MemAllocs: uint64(n * 10), MemBytes: uint64(n * 100)}, nil
+		}
+
+		var buf bytes.Buffer
+		rc, err := benchMain([]string{"input.x = 1"}, params, &buf, mockRunner)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if rc != 0 {
+			t.Fatalf("Unexpected return code %d, expected 0:\n%s", rc, buf.String())
+		}
+
+		// The query is only defined for the inputs where x is 1.
+		if len(evalErrs) != 6 {
+			t.Fatalf("Expected 6 benchmark runs, got %d", len(evalErrs))
+		}
+		for i, err := range evalErrs {
+			if (i%3 == 1) != (err != nil) {
+				t.Fatalf("Unexpected result of run %d: %v", i, err)
+			}
+		}
+
+		decoder := json.NewDecoder(&buf)
+		for i := 0; i < 2; i++ {
+			var m benchmarkMatrix
+			if err := decoder.Decode(&m); err != nil {
+				t.Fatal(err)
+			}
+			if len(m.Inputs) != 3 || m.Inputs[0].Input != "a.json" || m.Inputs[1].Input != "b.yaml" || m.Inputs[2].Input != "c.yml" {
+				t.Fatalf("Unexpected inputs: %+v", m.Inputs)
+			}
+
+			// run n takes n*100 ns/op
+			first := float64(i*3 + 1)
+			exp := benchmarkAggregate{
+				Inputs:          3,
+				N:               30,
+				MeanNsPerOp:     (first + 1) * 100,
+				MinNsPerOp:      first * 100,
+				MinInput:        "a.json",
+				MaxNsPerOp:      (first + 2) * 100,
+				MaxInput:        "c.yml",
+				MeanBytesPerOp:  (first + 1) * 10,
+				MeanAllocsPerOp: first + 1,
+			}
+			if m.Aggregate != exp {
+				t.Fatalf("Expected aggregate:\n%+v\ngot:\n%+v", exp, m.Aggregate)
+			}
+		}
+	})
+}
+
+func TestBenchMainWithInputDirErrors(t *testing.T) {
+	files := map[string]string{
+		"empty/README.md":    `not an input`,
+		"invalid/input.json": `{"x": `,
+		"valid/input.json":   `{"x": 1}`,
+	}
+
+	cases := []struct {
+		note   string
+		dir    string
+		setup  func(*benchmarkCommandParams)
+		expErr string
+	}{
+		{
+			note:   "missing directory",
+			dir:    "missing",
+			expErr: "no such file or directory",
+		},
+		{
+			note:   "no inputs",
+			dir:    "empty",
+			expErr: "no input files found",
+		},
+		{
+			note:   "invalid input",
+			dir:    "invalid",
+			expErr: "unable to parse input input.json",
+		},
+		{
+			note:   "with input",
+			dir:    "valid",
+			setup:  func(p *benchmarkCommandParams) { p.inputPath = "input.json" },
+			expErr: "--input-dir cannot be used with --input or --stdin-input",
+		},
+		{
+			note:   "with e2e",
+			dir:    "valid",
+			setup:  func(p *benchmarkCommandParams) { p.e2e = true },
+			expErr: "--input-dir cannot be used with --e2e",
+		},
+	}
+
+	test.WithTempFS(files, func(root string) {
+		for _, tc := range cases {
+			t.Run(tc.note, func(t *testing.T) {
+				params := testBenchParams()
+				params.inputDir = filepath.Join(root, tc.dir)
+				if tc.setup != nil {
+					tc.setup(&params)
+				}
+
+				var buf bytes.Buffer
+				rc, err := benchMain([]string{"1+1"}, params, &buf, &mockBenchRunner{})
+				if err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				if rc != 1 {
+					t.Fatalf("Unexpected return code %d, expected 1", rc)
+				}
+				if !strings.Contains(buf.String(), tc.expErr) {
+					t.Fatalf("Expected error %q, got:\n%s", tc.expErr, buf.String())
+				}
+			})
+		}
+	})
+}
+
+func TestRenderBenchmarkMatrixPrettyOutput(t *testing.T) {
+	params := testBenchParams()
+	params.metrics = false
+	_ = params.outputFormat.Set(evalPrettyOutput)
+
+	results := []benchmarkInputResult{
+		{Input: "a.json", Result: testing.BenchmarkResult{N: 10, T: 10000, MemAllocs: 100, MemBytes: 1000}},
+		{Input: "b.json", Result: testing.BenchmarkResult{N: 20, T: 60000, MemAllocs: 400, MemBytes: 6000}},
+	}
+
+	var buf bytes.Buffer
+	renderBenchmarkMatrix(params, results, &buf)
+
+	expected := `input: a.json
++-----------+------------+
+| samples   |         10 |
+| ns/op     |       1000 |
+| B/op      |        100 |
+| allocs/op |         10 |
++-----------+------------+
+
+input: b.json
++-----------+------------+
+| samples   |         20 |
+| ns/op     |       3000 |
+| B/op      |        300 |
+| allocs/op |         20 |
++-----------+------------+
+
+aggregate:
++------------------+---------------------+
+| inputs           |                   2 |
+| samples          |                  30 |
+| ns/op (mean)     |                2000 |
+| ns/op (min)      |       1000 (a.json) |
+| ns/op (max)      |       3000 (b.json) |
+| B/op (mean)      |                 200 |
+| allocs/op (mean) |                15.0 |
++------------------+---------------------+
+`
+	if buf.String() != expected {
+		t.Fatalf("\nExpected:\n%s\n\nGot:\n%s\n", expected, buf.String())
+	}
+}
+
 func testBenchParams() benchmarkCommandParams {
 	params := newBenchmarkEvalParams()
 	params.benchMem = true
@@ -3,6 +3,7 @@ package cmd
 import (
 	"archive/tar"
 	"compress/gzip"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -14,6 +15,7 @@ import (
 	"testing"
 
 	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/bundle"
 	"github.com/open-policy-agent/opa/internal/file/archive"
 	"github.com/open-policy-agent/opa/loader"
 	"github.com/open-policy-agent/opa/util"
@@ -72,6 +74,61 @@ func TestBuildProducesBundle(t *testing.T) {
 	})
 }
 
+func TestBuildWithPGO(t *testing.T) {
+
+	files := map[string]string{
+		"test.rego": `package test
+
+p {
+	input.method == "GET"
+}
+
+p {
+	input.method == "POST"
+}`,
+		"empty.json": `{"result": []}`,
+	}
+
+	test.WithTempFS(files, func(root string) {
+		profile := fmt.Sprintf(`{"profile": [
+			{"num_eval": 4, "location": {"file": %[1]q, "row": 4, "col": 2}},
+			{"num_eval": 1, "location": {"file": %[1]q, "row": 8, "col": 2}}
+		]}`, path.Join(root, "test.rego"))
+
+		if err := os.WriteFile(path.Join(root, "profile.json"), []byte(profile), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		params := newBuildParams()
+		params.outputFile = path.Join(root, "bundle.tar.gz")
+		params.pgo = path.Join(root, "profile.json")
+
+		if err := dobuild(params, []string{path.Join(root, "test.rego")}); err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := loader.NewFileLoader().AsBundle(params.outputFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exp := []bundle.IndexHint{
+			{Path: "data.test.p", Ref: "input.method", Value: "GET", Count: 4},
+			{Path: "data.test.p", Ref: "input.method", Value: "POST", Count: 1},
+		}
+
+		if !reflect.DeepEqual(b.Manifest.IndexHints, exp) {
+			t.Fatalf("expected index hints %v but got %v", exp, b.Manifest.IndexHints)
+		}
+
+		params.pgo = path.Join(root, "empty.json")
+		err = dobuild(params, []string{path.Join(root, "test.rego")})
+		if err == nil || !strings.Contains(err.Error(), "does not contain expression statistics") {
+			t.Fatalf("expected error for profile without statistics but got: %v", err)
+		}
+	})
+}
+
 func TestBuildRespectsCapabilities(t *testing.T) {
 	tests := []struct {
 		note       string
@@ -218,6 +275,68 @@ func TestBuildFilesystemModeIgnoresTarGz(t *testing.T) {
 	})
 }
 
+func TestBuildWithCache(t *testing.T) {
+
+	files := map[string]string{
+		"test.rego": `
+			package test
+			p = 1
+		`,
+		"other.rego": `
+			package other
+			q = 2
+		`,
+	}
+
+	test.WithTempFS(files, func(root string) {
+		params := newBuildParams()
+		params.outputFile = path.Join(root, "bundle.tar.gz")
+		params.cacheDir = path.Join(root, ".cache")
+
+		entries := func() []string {
+			t.Helper()
+			entries, err := filepath.Glob(filepath.Join(params.cacheDir, "*", "*", "*"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return entries
+		}
+
+		if err := dobuild(params, []string{root}); err != nil {
+			t.Fatal(err)
+		}
+		if len(entries()) != 2 {
+			t.Fatalf("expected one cache entry per module, got %v", entries())
+		}
+
+		exp, err := os.ReadFile(params.outputFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Builds with cached modules produce the same bundle.
+		if err := dobuild(params, []string{root}); err != nil {
+			t.Fatal(err)
+		}
+		if act, err := os.ReadFile(params.outputFile); err != nil || !reflect.DeepEqual(act, exp) {
+			t.Fatalf("expected same output bundle (err: %v)", err)
+		}
+
+		// Only modified modules are parsed and cached again, and the policies
+		// are always compiled.
+		if err := os.WriteFile(path.Join(root, "test.rego"), []byte("package test\np = x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		err = dobuild(params, []string{root})
+		if err == nil || !strings.Contains(err.Error(), "var x is unsafe") {
+			t.Fatalf("expected compile error, got %v", err)
+		}
+		if len(entries()) != 3 {
+			t.Fatalf("expected three cache entries, got %v", entries())
+		}
+	})
+}
+
 func TestBuildErrorDoesNotWriteFile(t *testing.T) {
 
 	files := map[string]string{
@@ -2002,3 +2121,45 @@ foo contains __local1__1 if {
 		})
 	}
 }
+
+func TestBuildReproducible(t *testing.T) {
+	files := map[string]string{
+		"/roles/policy.rego": "package roles\n p = 1",
+		"/roles/data.json":   `{"roles": {"admins": ["alice", "bob"]}}`,
+		"/roles/.manifest":   `{"roots": ["roles", "admins"]}`,
+		"/other/policy.rego": "package other\n p = 1",
+		"/other/.manifest":   `{"roots": ["other"]}`,
+	}
+
+	test.WithTempFS(files, func(root string) {
+		params := newBuildParams()
+		params.bundleMode = true
+		params.reproducible = true
+
+		var digests []string
+		for i := 0; i < 3; i++ {
+			params.outputFile = path.Join(root, fmt.Sprintf("bundle%d.tar.gz", i))
+			if err := dobuild(params, []string{path.Join(root, "roles"), path.Join(root, "other")}); err != nil {
+				t.Fatal(err)
+			}
+			bs, err := os.ReadFile(params.outputFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			digests = append(digests, fmt.Sprintf("%x", sha256.Sum256(bs)))
+		}
+		for _, d := range digests[1:] {
+			if d != digests[0] {
+				t.Fatalf("expected identical bundles but got digests %v", digests)
+			}
+		}
+
+		params.outputFile = path.Join(root, "signed.tar.gz")
+		params.key = "secret"
+		params.algorithm = "ES256"
+		err := dobuild(params, []string{path.Join(root, "roles")})
+		if err == nil || err.Error() != "signing algorithm ES256 cannot be used for reproducible builds" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
@@ -11,13 +11,23 @@ import (
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/cmd/internal/env"
+	"github.com/open-policy-agent/opa/loader"
 	"github.com/spf13/cobra"
 )
 
 type capabilitiesParams struct {
-	showCurrent bool
-	version     string
-	file        string
+	showCurrent  bool
+	version      string
+	file         string
+	fromBundle   string
+	v1Compatible bool
+}
+
+func (p *capabilitiesParams) regoVersion() ast.RegoVersion {
+	if p.v1Compatible {
+		return ast.RegoV1
+	}
+	return ast.RegoV0
 }
 
 func init() {
@@ -69,6 +79,19 @@ Print the capabilities of a capabilities file
         "wasm_abi_versions": [...]
     }
 
+Print the minimal capabilities required by the policies of a bundle
+
+    $ opa capabilities --from-bundle bundle.tar.gz
+    {
+        "builtins": [...],
+        "future_keywords": [...],
+        "features": [...]
+    }
+
+The minimal capabilities include the built-in functions called, and the future
+keywords and language features used by the policies of the bundle. They can be
+given to OPAs activating the bundle to restrict them to what the bundle needs.
+The bundle may be a bundle archive or a directory.
 `,
 		PreRunE: func(cmd *cobra.Command, _ []string) error {
 			return env.CmdFlags.CheckEnvironmentVariables(cmd)
@@ -85,6 +108,8 @@ Print the capabilities of a capabilities file
 	capabilitiesCommand.Flags().BoolVar(&capabilitiesParams.showCurrent, "current", false, "print current capabilities")
 	capabilitiesCommand.Flags().StringVar(&capabilitiesParams.version, "version", "", "print capabilities of a specific version")
 	capabilitiesCommand.Flags().StringVar(&capabilitiesParams.file, "file", "", "print current capabilities")
+	capabilitiesCommand.Flags().StringVar(&capabilitiesParams.fromBundle, "from-bundle", "", "print the minimal capabilities required by the policies of a bundle")
+	addV1CompatibleFlag(capabilitiesCommand.Flags(), &capabilitiesParams.v1Compatible, false)
 
 	RootCommand.AddCommand(capabilitiesCommand)
 }
@@ -99,6 +124,8 @@ func doCapabilities(params capabilitiesParams) (string, error) {
 		c, err = ast.LoadCapabilitiesVersion(params.version)
 	} else if len(params.file) > 0 {
 		c, err = ast.LoadCapabilitiesFile(params.file)
+	} else if len(params.fromBundle) > 0 {
+		c, err = requiredCapabilities(params.fromBundle, params.regoVersion())
 	} else if params.showCurrent {
 		c = ast.CapabilitiesForThisVersion()
 	} else {
@@ -117,6 +144,17 @@ func doCapabilities(params capabilitiesParams) (string, error) {
 
 }
 
+func requiredCapabilities(path string, regoVersion ast.RegoVersion) (*ast.Capabilities, error) {
+	b, err := loader.NewFileLoader().
+		WithRegoVersion(regoVersion).
+		WithSkipBundleVerification(true).
+		AsBundle(path)
+	if err != nil {
+		return nil, err
+	}
+	return b.RequiredCapabilities()
+}
+
 func showVersions() (string, error) {
 	cvs, err := ast.LoadCapabilitiesVersions()
 	if err != nil {
@@ -6,8 +6,11 @@ package cmd
 
 import (
 	"path"
+	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/util/test"
 )
 
@@ -74,3 +77,63 @@ func TestCapabilitiesFile(t *testing.T) {
 
 	})
 }
+
+func TestCapabilitiesFromBundle(t *testing.T) {
+	files := map[string]string{
+		"bundle/x.rego": `package x
+import future.keywords.if
+import future.keywords.in
+
+allow if {
+	startswith(input.path, "/api")
+	input.method in {"GET", "HEAD"}
+	print("allowed")
+}
+
+a.b.c := 1`,
+	}
+
+	test.WithTempFS(files, func(root string) {
+		out, err := doCapabilities(capabilitiesParams{fromBundle: path.Join(root, "bundle")})
+		if err != nil {
+			t.Fatal("expected success", err)
+		}
+
+		c, err := ast.LoadCapabilitiesJSON(strings.NewReader(out))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var builtins []string
+		for _, bi := range c.Builtins {
+			builtins = append(builtins, bi.Name)
+		}
+		exp := []string{"eq", "internal.member_2", "internal.print", "print", "startswith"}
+		if !reflect.DeepEqual(builtins, exp) {
+			t.Errorf("expected builtins %v but got %v", exp, builtins)
+		}
+		if exp := []string{"if", "in"}; !reflect.DeepEqual(c.FutureKeywords, exp) {
+			t.Errorf("expected future keywords %v but got %v", exp, c.FutureKeywords)
+		}
+		if exp := []string{ast.FeatureRefHeadStringPrefixes}; !reflect.DeepEqual(c.Features, exp) {
+			t.Errorf("expected features %v but got %v", exp, c.Features)
+		}
+		if len(c.WasmABIVersions) != 0 {
+			t.Errorf("expected no wasm ABI versions but got %v", c.WasmABIVersions)
+		}
+	})
+}
+
+func TestCapabilitiesFromBundleUndefinedFunction(t *testing.T) {
+	files := map[string]string{
+		"bundle/x.rego": `package x
+p := custom.fn(1)`,
+	}
+
+	test.WithTempFS(files, func(root string) {
+		_, err := doCapabilities(capabilitiesParams{fromBundle: path.Join(root, "bundle")})
+		if err == nil || !strings.Contains(err.Error(), "undefined function custom.fn") {
+			t.Fatalf("expected undefined function error but got %v", err)
+		}
+	})
+}
@@ -8,12 +8,15 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
 
 	"github.com/spf13/cobra"
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/cmd/internal/env"
 	pr "github.com/open-policy-agent/opa/internal/presentation"
+	"github.com/open-policy-agent/opa/internal/secrets"
+	"github.com/open-policy-agent/opa/internal/ref"
 	"github.com/open-policy-agent/opa/loader"
 	"github.com/open-policy-agent/opa/util"
 )
@@ -26,6 +29,7 @@ type checkParams struct {
 	capabilities *capabilitiesFlag
 	schema       *schemaFlags
 	strict       bool
+	entrypoints  repeatedStringFlag
 	regoV1       bool
 	v1Compatible bool
 }
@@ -33,7 +37,7 @@ type checkParams struct {
 func newCheckParams() checkParams {
 	return checkParams{
 		format: util.NewEnumFlag(checkFormatPretty, []string{
-			checkFormatPretty, checkFormatJSON,
+			checkFormatPretty, checkFormatJSON, checkFormatSARIF,
 		}),
 		capabilities: newcapabilitiesFlag(),
 		schema:       &schemaFlags{},
@@ -54,11 +58,13 @@ func (p *checkParams) regoVersion() ast.RegoVersion {
 const (
 	checkFormatPretty = "pretty"
 	checkFormatJSON   = "json"
+	checkFormatSARIF  = "sarif"
 )
 
 func checkModules(params checkParams, args []string) error {
 
 	modules := map[string]*ast.Module{}
+	var data []map[string]interface{}
 
 	var capabilities *ast.Capabilities
 	// if capabilities are not provided as a cmd flag,
@@ -75,6 +81,15 @@ func checkModules(params checkParams, args []string) error {
 		return err
 	}
 
+	var entrypoints []ast.Ref
+	for _, e := range params.entrypoints.v {
+		r, err := ref.ParseDataPath(e)
+		if err != nil {
+			return fmt.Errorf("entrypoint %v not valid: use <package>/<rule>", e)
+		}
+		entrypoints = append(entrypoints, r)
+	}
+
 	if params.bundleMode {
 		for _, path := range args {
 			b, err := loader.NewFileLoader().
@@ -89,11 +104,19 @@ func checkModules(params checkParams, args []string) error {
 			for name, mod := range b.ParsedModules(path) {
 				modules[name] = mod
 			}
+			data = append(data, b.Data)
+			for _, wr := range b.Manifest.WasmResolvers {
+				r, err := ref.ParseDataPath(wr.Entrypoint)
+				if err != nil {
+					return fmt.Errorf("failed to parse entrypoint in manifest: %w", err)
+				}
+				entrypoints = append(entrypoints, r)
+			}
 		}
 	} else {
 		f := loaderFilter{
 			Ignore:   params.ignore,
-			OnlyRego: true,
+			OnlyRego: !params.strict,
 		}
 
 		result, err := loader.NewFileLoader().
@@ -108,6 +131,7 @@ func checkModules(params checkParams, args []string) error {
 		for _, m := range result.Modules {
 			modules[m.Name] = m.Parsed
 		}
+		data = append(data, result.Documents)
 	}
 
 	compiler := ast.NewCompiler().
@@ -122,9 +146,33 @@ func checkModules(params checkParams, args []string) error {
 	if compiler.Failed() {
 		return compiler.Errors
 	}
+
+	if params.strict {
+		errs := compiler.DeadCode(entrypoints)
+		errs = append(errs, secrets.Modules(sortedModules(modules))...)
+		for _, d := range data {
+			errs = append(errs, secrets.Data(d)...)
+		}
+		if len(errs) > 0 {
+			return errs
+		}
+	}
 	return nil
 }
 
+func sortedModules(modules map[string]*ast.Module) []*ast.Module {
+	names := make([]string, 0, len(modules))
+	for name := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sorted := make([]*ast.Module, len(names))
+	for i, name := range names {
+		sorted[i] = modules[name]
+	}
+	return sorted
+}
+
 func outputErrors(format string, err error) {
 	var out io.Writer
 	if err != nil {
@@ -134,6 +182,12 @@ func outputErrors(format string, err error) {
 	}
 
 	switch format {
+	case checkFormatSARIF:
+		// The SARIF log is written to stdout even if there are errors, so
+		// that it can be uploaded to code scanning tools as is.
+		if err := pr.SARIF(os.Stdout, err); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+		}
 	case checkFormatJSON:
 		result := pr.Output{
 			Errors: pr.NewOutputErrors(err),
@@ -157,7 +211,23 @@ func init() {
 	
 	If the 'check' command succeeds in parsing and compiling the source file(s), no output
 	is produced. If the parsing or compiling fails, 'check' will output the errors
-	and exit with a non-zero exit code.`,
+	and exit with a non-zero exit code.
+
+	In strict mode, 'check' also reports dead code: rule bodies that can never succeed,
+	else branches that are shadowed by an earlier branch that always succeeds, and rules
+	that are not reachable from any entrypoint. Entrypoints are set with the -e flag, or
+	are taken from the manifest in bundle mode and from the entrypoint annotations. Test
+	rules are always considered to be reachable. If there are no entrypoints, rules are
+	not checked for reachability.
+
+	Strict mode also reports string literals in policies and values in data files that
+	look like secrets: AWS access keys, private keys, private JSON Web Keys and passwords
+	in connection strings. Rules and packages whose metadata sets the custom
+	'allow_secrets' key to true are not scanned.
+
+	With the 'sarif' output format, 'check' always outputs a SARIF log to stdout, with a
+	result for each parse, compile and strict-mode error, so that code scanning tools can
+	annotate the policies with them.`,
 
 		PreRunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) == 0 {
@@ -167,8 +237,11 @@ func init() {
 		},
 
 		Run: func(_ *cobra.Command, args []string) {
-			if err := checkModules(checkParams, args); err != nil {
+			err := checkModules(checkParams, args)
+			if err != nil || checkParams.format.String() == checkFormatSARIF {
 				outputErrors(checkParams.format.String(), err)
+			}
+			if err != nil {
 				os.Exit(1)
 			}
 		},
@@ -181,6 +254,7 @@ func init() {
 	addCapabilitiesFlag(checkCommand.Flags(), checkParams.capabilities)
 	addSchemaFlags(checkCommand.Flags(), checkParams.schema)
 	addStrictFlag(checkCommand.Flags(), &checkParams.strict, false)
+	checkCommand.Flags().VarP(&checkParams.entrypoints, "entrypoint", "e", "set slash separated entrypoint path, used to find unreachable rules in strict mode")
 	addRegoV1FlagWithDescription(checkCommand.Flags(), &checkParams.regoV1, false,
 		"check for Rego v1 compatibility (policies must also be compatible with current OPA version)")
 	addV1CompatibleFlag(checkCommand.Flags(), &checkParams.v1Compatible, false)
@@ -0,0 +1,326 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/cmd/internal/env"
+	"github.com/open-policy-agent/opa/debug"
+	"github.com/open-policy-agent/opa/internal/presentation"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/topdown"
+	"github.com/open-policy-agent/opa/util"
+)
+
+type debugCommandParams struct {
+	dataPaths    repeatedStringFlag
+	bundlePaths  repeatedStringFlag
+	inputPath    string
+	breakpoints  repeatedStringFlag
+	stopOnEntry  bool
+	v1Compatible bool
+}
+
+const debugHelp = `Commands:
+  c, continue          resume until the next breakpoint
+  s, step              step to the next event, into rules and functions
+  n, next              step to the next event, over rules and functions
+  o, out               step out of the current rule or function
+  b, break <location>  add a breakpoint at file:line or on a rule ref
+  d, delete <id>       delete a breakpoint
+  bl, breakpoints      list the breakpoints
+  l, locals            print the local variables
+  p, print <var>       print a local variable
+  bt, stack            print the stack
+  h, help              print this help
+  q, quit              stop debugging`
+
+func init() {
+	var params debugCommandParams
+
+	debugCommand := &cobra.Command{
+		Use:   "debug <query>",
+		Short: "Debug the evaluation of a Rego query",
+		Long: `Debug the evaluation of a Rego query.
+
+The 'debug' command evaluates a query step by step. Evaluation is paused when a
+breakpoint is hit, and commands read from stdin control how evaluation continues
+and inspect the local variables and the stack of rules and functions being
+evaluated:
+
+` + debugHelp + `
+
+Breakpoints are set with the --break flag, or the 'break' command, either on a
+line of a file ('policy.rego:12') or on a rule ref ('data.policy.allow'). Line
+breakpoints pause evaluation when an expression on the line is evaluated. Rule
+breakpoints pause evaluation when the rule, or any rule nested under the ref,
+is evaluated.
+
+Example:
+
+    $ opa debug -d policy.rego -i input.json --break policy.rego:12 data.policy.allow
+`,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("specify exactly one query argument")
+			}
+			return env.CmdFlags.CheckEnvironmentVariables(cmd)
+		},
+		Run: func(_ *cobra.Command, args []string) {
+			if err := dodebug(params, args[0], os.Stdin, os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, "error:", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	addDataFlag(debugCommand.Flags(), &params.dataPaths)
+	addBundleFlag(debugCommand.Flags(), &params.bundlePaths)
+	addInputFlag(debugCommand.Flags(), &params.inputPath)
+	debugCommand.Flags().VarP(&params.breakpoints, "break", "", "set a breakpoint at file:line or on a rule ref")
+	debugCommand.Flags().BoolVar(&params.stopOnEntry, "stop-on-entry", false, "pause on the first event of the evaluation")
+	addV1CompatibleFlag(debugCommand.Flags(), &params.v1Compatible, false)
+
+	RootCommand.AddCommand(debugCommand)
+}
+
+func dodebug(params debugCommandParams, query string, stdin io.Reader, stdout io.Writer) error {
+	config := debug.Config{StopOnEntry: params.stopOnEntry}
+	for _, s := range params.breakpoints.v {
+		b, err := debug.ParseBreakpoint(s)
+		if err != nil {
+			return err
+		}
+		config.Breakpoints = append(config.Breakpoints, b)
+	}
+
+	opts := []func(*rego.Rego){
+		rego.Query(query),
+		rego.Load(params.dataPaths.v, loaderFilter{}.Apply),
+	}
+	for _, path := range params.bundlePaths.v {
+		opts = append(opts, rego.LoadBundle(path))
+	}
+	if params.v1Compatible {
+		opts = append(opts, rego.SetRegoVersion(ast.RegoV1))
+	}
+	if params.inputPath != "" {
+		bs, err := os.ReadFile(params.inputPath)
+		if err != nil {
+			return err
+		}
+		var input interface{}
+		if err := util.Unmarshal(bs, &input); err != nil {
+			return fmt.Errorf("unable to parse input: %w", err)
+		}
+		opts = append(opts, rego.Input(input))
+	}
+
+	session := debug.NewSession(context.Background(), config, opts...)
+	defer session.Close()
+
+	d := &debugger{session: session, out: stdout, files: map[string][]string{}}
+
+	state, err := session.Continue()
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(stdin)
+	for {
+		if state.Reason == debug.StopDone {
+			return d.printDone(state)
+		}
+		d.printStop(state)
+
+		next, quit, err := d.prompt(scanner, state)
+		if err != nil || quit {
+			return err
+		}
+		state = next
+	}
+}
+
+// prompt reads and runs commands until evaluation is resumed or the user
+// quits.
+func (d *debugger) prompt(scanner *bufio.Scanner, state *debug.State) (*debug.State, bool, error) {
+	for {
+		fmt.Fprint(d.out, "(debug) ")
+		if !scanner.Scan() {
+			fmt.Fprintln(d.out)
+			return nil, true, scanner.Err()
+		}
+
+		next, quit, err := d.command(state, strings.Fields(scanner.Text()))
+		if err != nil {
+			fmt.Fprintln(d.out, "error:", err)
+		}
+		if quit || next != nil {
+			return next, quit, nil
+		}
+	}
+}
+
+type debugger struct {
+	session *debug.Session
+	out     io.Writer
+	files   map[string][]string
+}
+
+// command runs a command, and returns the new state if evaluation was
+// resumed.
+func (d *debugger) command(state *debug.State, args []string) (*debug.State, bool, error) {
+	if len(args) == 0 {
+		return nil, false, nil
+	}
+
+	switch args[0] {
+	case "c", "continue":
+		next, err := d.session.Continue()
+		return next, false, err
+	case "s", "step":
+		next, err := d.session.StepIn()
+		return next, false, err
+	case "n", "next":
+		next, err := d.session.StepOver()
+		return next, false, err
+	case "o", "out":
+		next, err := d.session.StepOut()
+		return next, false, err
+	case "b", "break":
+		if len(args) != 2 {
+			return nil, false, errors.New("usage: break <file:line|rule ref>")
+		}
+		b, err := debug.ParseBreakpoint(args[1])
+		if err != nil {
+			return nil, false, err
+		}
+		b = d.session.AddBreakpoint(b)
+		fmt.Fprintf(d.out, "breakpoint %d at %v\n", b.ID, b)
+	case "d", "delete":
+		if len(args) != 2 {
+			return nil, false, errors.New("usage: delete <id>")
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil || !d.session.RemoveBreakpoint(id) {
+			return nil, false, fmt.Errorf("unknown breakpoint: %v", args[1])
+		}
+	case "bl", "breakpoints":
+		for _, b := range d.session.Breakpoints() {
+			fmt.Fprintf(d.out, "%d  %v\n", b.ID, b)
+		}
+	case "l", "locals":
+		locals := state.Locals()
+		names := make([]string, 0, len(locals))
+		for name := range locals {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(d.out, "%v = %v\n", name, locals[name])
+		}
+	case "p", "print":
+		if len(args) != 2 {
+			return nil, false, errors.New("usage: print <var>")
+		}
+		value, ok := state.Locals()[args[1]]
+		if !ok {
+			return nil, false, fmt.Errorf("undefined variable: %v", args[1])
+		}
+		fmt.Fprintln(d.out, value)
+	case "bt", "stack":
+		for i, frame := range state.Stack {
+			fmt.Fprintf(d.out, "#%d  %v  %v\n", i, d.location(frame.Event), d.text(frame.Event))
+		}
+	case "h", "help":
+		fmt.Fprintln(d.out, debugHelp)
+	case "q", "quit":
+		return nil, true, nil
+	default:
+		return nil, false, fmt.Errorf("unknown command: %v (type 'help' for a list of commands)", args[0])
+	}
+
+	return nil, false, nil
+}
+
+func (d *debugger) printStop(state *debug.State) {
+	evt := state.Event
+	switch state.Reason {
+	case debug.StopBreakpoint:
+		fmt.Fprintf(d.out, "breakpoint %d: %v %v\n", state.Breakpoint.ID, evt.Op, d.location(evt))
+	default:
+		fmt.Fprintf(d.out, "%v %v\n", evt.Op, d.location(evt))
+	}
+
+	if evt.Location == nil {
+		return
+	}
+	if line, ok := d.line(evt.Location); ok {
+		fmt.Fprintf(d.out, "%5d | %v\n", evt.Location.Row, line)
+	} else {
+		fmt.Fprintf(d.out, "      | %s\n", evt.Location.Text)
+	}
+}
+
+func (d *debugger) printDone(state *debug.State) error {
+	if state.Err != nil {
+		return state.Err
+	}
+	if len(state.Results) == 0 {
+		fmt.Fprintln(d.out, "undefined")
+		return nil
+	}
+	return presentation.JSON(d.out, presentation.Output{Result: state.Results})
+}
+
+func (*debugger) location(evt *topdown.Event) string {
+	if evt.Location == nil {
+		return "<unknown>"
+	}
+	if evt.Location.File == "" {
+		return fmt.Sprintf("<query>:%d", evt.Location.Row)
+	}
+	return fmt.Sprintf("%v:%d", evt.Location.File, evt.Location.Row)
+}
+
+// text returns the first line of the source text of the event's node.
+func (*debugger) text(evt *topdown.Event) string {
+	if evt.Location == nil {
+		return ""
+	}
+	text, _, _ := strings.Cut(string(evt.Location.Text), "\n")
+	return text
+}
+
+// line returns the source line of loc.
+func (d *debugger) line(loc *ast.Location) (string, bool) {
+	if loc.File == "" {
+		return "", false
+	}
+	lines, ok := d.files[loc.File]
+	if !ok {
+		bs, err := os.ReadFile(loc.File)
+		if err == nil {
+			lines = strings.Split(string(bs), "\n")
+		}
+		d.files[loc.File] = lines
+	}
+	if loc.Row < 1 || loc.Row > len(lines) {
+		return "", false
+	}
+	return lines[loc.Row-1], true
+}
@@ -0,0 +1,69 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/util/test"
+)
+
+func TestDebug(t *testing.T) {
+	files := map[string]string{
+		"policy.rego": `package policy
+
+import rego.v1
+
+allow if {
+	some user in input.users
+	is_admin(user)
+}
+
+is_admin(u) if {
+	u == "admin"
+}
+`,
+		"input.json": `{"users": ["alice", "admin"]}`,
+	}
+
+	test.WithTempFS(files, func(root string) {
+		params := debugCommandParams{inputPath: filepath.Join(root, "input.json")}
+		_ = params.dataPaths.Set(filepath.Join(root, "policy.rego"))
+		_ = params.breakpoints.Set("policy.rego:11")
+
+		stdin := strings.NewReader("l\nbt\nd 1\nunknown\nc\n")
+		var stdout bytes.Buffer
+
+		if err := dodebug(params, "data.policy.allow", stdin, &stdout); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, exp := range []string{
+			"breakpoint 1: Eval " + filepath.Join(root, "policy.rego") + ":11",
+			"   11 | \tu == \"admin\"",
+			"u = \"admin\"",
+			"#1  " + filepath.Join(root, "policy.rego") + ":7  is_admin(user)",
+			"error: unknown command: unknown",
+			"\"value\": true",
+		} {
+			if !strings.Contains(stdout.String(), exp) {
+				t.Fatalf("expected %q in output:\n%s", exp, stdout.String())
+			}
+		}
+	})
+}
+
+func TestDebugInvalidBreakpoint(t *testing.T) {
+	params := debugCommandParams{}
+	_ = params.breakpoints.Set("input.x")
+
+	err := dodebug(params, "true", strings.NewReader(""), &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "invalid breakpoint") {
+		t.Fatalf("expected invalid breakpoint error, got %v", err)
+	}
+}
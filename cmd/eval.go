@@ -11,10 +11,14 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
+	"github.com/sergi/go-diff/diffmatchpatch"
 	"github.com/spf13/cobra"
 
 	"github.com/open-policy-agent/opa/ast"
@@ -24,6 +28,7 @@ import (
 	"github.com/open-policy-agent/opa/compile"
 	"github.com/open-policy-agent/opa/cover"
 	fileurl "github.com/open-policy-agent/opa/internal/file/url"
+	"github.com/open-policy-agent/opa/internal/pathwatcher"
 	pr "github.com/open-policy-agent/opa/internal/presentation"
 	"github.com/open-policy-agent/opa/internal/runtime"
 	"github.com/open-policy-agent/opa/loader"
@@ -53,6 +58,7 @@ type evalCommandParams struct {
 	stdin               bool
 	stdinInput          bool
 	explain             *util.EnumFlag
+	explainVirtualCache bool
 	metrics             bool
 	instrument          bool
 	ignore              []string
@@ -72,6 +78,8 @@ type evalCommandParams struct {
 	entrypoints         repeatedStringFlag
 	strict              bool
 	v1Compatible        bool
+	watch               bool
+	stopChan            chan os.Signal
 }
 
 func newEvalCommandParams() evalCommandParams {
@@ -85,6 +93,7 @@ func newEvalCommandParams() evalCommandParams {
 			evalSourceOutput,
 			evalRawOutput,
 			evalDiscardOutput,
+			evalNDJSONOutput,
 		}),
 		explain:         newExplainFlag([]string{explainModeOff, explainModeFull, explainModeNotes, explainModeFails, explainModeDebug}),
 		target:          util.NewEnumFlag(compile.TargetRego, []string{compile.TargetRego, compile.TargetWasm}),
@@ -93,6 +102,7 @@ func newEvalCommandParams() evalCommandParams {
 		profileLimit:    newIntFlag(defaultProfileLimit),
 		prettyLimit:     newIntFlag(defaultPrettyLimit),
 		schema:          &schemaFlags{},
+		stopChan:        make(chan os.Signal, 1),
 	}
 }
 
@@ -119,6 +129,26 @@ func validateEvalParams(p *evalCommandParams, cmdArgs []string) error {
 	} else if !p.partial && of == evalSourceOutput {
 		return errors.New("invalid output format for evaluation")
 	}
+	if of == evalNDJSONOutput {
+		if p.partial {
+			return errors.New("invalid output format for partial evaluation")
+		}
+		if p.count > 1 {
+			return errors.New("specify --count=1 with --format=ndjson")
+		}
+	}
+
+	if p.watch {
+		if len(p.dataPaths.v) == 0 && !p.bundlePaths.isFlagSet() {
+			return errors.New("specify --data or --bundle paths to watch with --watch")
+		}
+		if p.stdin || p.stdinInput {
+			return errors.New("specify --stdin or --stdin-input but not with --watch")
+		}
+		if of == evalNDJSONOutput {
+			return errors.New("invalid output format for --watch")
+		}
+	}
 
 	if p.optimizationLevel > 0 {
 		if len(p.dataPaths.v) > 0 && p.bundlePaths.isFlagSet() {
@@ -146,6 +176,7 @@ const (
 	evalSourceOutput   = "source"
 	evalRawOutput      = "raw"
 	evalDiscardOutput  = "discard"
+	evalNDJSONOutput   = "ndjson"
 
 	// number of profile results to return by default
 	defaultProfileLimit = 10
@@ -215,7 +246,11 @@ See https://www.openpolicyagent.org/docs/latest/management-bundles/ for more det
 on bundle directory structures.
 
 The --data flag can be used to recursively load ALL *.rego, *.json, and
-*.yaml files under the specified directory.
+*.yaml files under the specified directory. The path can also be a glob
+pattern, where '**' matches zero or more directories, and paths prefixed with
+'!' exclude the files and directories they match:
+
+    $ opa eval --data 'policies/**/*.rego' --data '!**/*_test.rego' 'data'
 
 The -O flag controls the optimization level. By default, optimization is disabled (-O=0).
 When optimization is enabled the 'eval' command generates a bundle from the files provided
@@ -237,6 +272,21 @@ Set the output format with the --format flag.
     --format=source    : output partial evaluation results in a source format
     --format=raw       : output the values from query results in a scripting friendly format
     --format=discard   : output the result field as "discarded" when non-nil
+    --format=ndjson    : output each query result as a line of JSON as soon as it is produced
+
+The ndjson format streams results instead of buffering the entire result set in
+memory. If --explain is set, the trace events are written as lines of JSON (with
+"op", "query_id", "parent_id", "node", "location" and "locals" fields) after the
+results. Any errors, metrics, profiles, etc. are written as a final line of JSON
+after the results.
+
+If --explain-virtual-cache is set, each trace event of the ndjson format also
+contains the values of the rules cached when the event occurred (in the
+"virtual_cache" field). Taking these snapshots slows down evaluation.
+
+Built-in function errors do not halt evaluation by default. They are reported
+under the "warnings" key of the JSON output. Use --show-builtin-errors to report
+them as errors instead, or --strict-builtin-errors to make the first one fatal.
 
 Schema
 ------
@@ -268,6 +318,16 @@ permit fetching remote schemas from any host.
 Note that the metaschemas http://json-schema.org/draft-04/schema, http://json-schema.org/draft-06/schema,
 and http://json-schema.org/draft-07/schema, are always available, even without network
 access.
+
+Watch Mode
+----------
+
+The --watch flag can be used to monitor the files loaded with --data and --bundle, and the
+--input file, for changes. When a change is detected, OPA reloads the policy, data and input
+and re-evaluates the query. After the first evaluation, only the lines of the output that
+changed are printed, prefixed with '-' for removed and '+' for added lines.
+
+    $ opa eval --watch --format pretty --data policy.rego --input input.json 'data.example.allow'
 `,
 
 		PreRunE: func(cmd *cobra.Command, args []string) error {
@@ -278,6 +338,14 @@ access.
 		},
 		Run: func(_ *cobra.Command, args []string) {
 
+			if params.watch {
+				if err := evalWatch(args, params, os.Stdout); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(2)
+				}
+				return
+			}
+
 			defined, err := eval(args, params, os.Stdout)
 			if err != nil {
 				if _, ok := err.(regoError); !ok {
@@ -310,6 +378,7 @@ access.
 
 	evalCommand.Flags().IntVarP(&params.optimizationLevel, "optimize", "O", 0, "set optimization level")
 	evalCommand.Flags().VarP(&params.entrypoints, "entrypoint", "e", "set slash separated entrypoint path")
+	evalCommand.Flags().BoolVarP(&params.watch, "watch", "w", false, "watch command line files for changes and re-evaluate the query")
 
 	// Shared flags
 	addCapabilitiesFlag(evalCommand.Flags(), params.capabilities)
@@ -327,6 +396,7 @@ access.
 	addOutputFormat(evalCommand.Flags(), params.outputFormat)
 	addIgnoreFlag(evalCommand.Flags(), &params.ignore)
 	setExplainFlag(evalCommand.Flags(), params.explain)
+	evalCommand.Flags().BoolVarP(&params.explainVirtualCache, "explain-virtual-cache", "", false, "include snapshots of the virtual document cache in the trace events of the ndjson format")
 	addSchemaFlags(evalCommand.Flags(), params.schema)
 	addTargetFlag(evalCommand.Flags(), params.target)
 	addCountFlag(evalCommand.Flags(), &params.count, "benchmark")
@@ -354,6 +424,10 @@ func eval(args []string, params evalCommandParams, w io.Writer) (bool, error) {
 		rego.EnablePrintStatements(true),
 		rego.PrintHook(topdown.NewPrintHook(os.Stderr)))
 
+	if ectx.params.outputFormat.String() == evalNDJSONOutput {
+		ectx.stream = w
+	}
+
 	results := make([]pr.Output, ectx.params.count)
 	profiles := make([][]profiler.ExprStats, ectx.params.count)
 	timers := make([]map[string]interface{}, ectx.params.count)
@@ -370,6 +444,7 @@ func eval(args []string, params evalCommandParams, w io.Writer) (bool, error) {
 
 	if ectx.params.count > 1 {
 		result.Profile = nil
+		result.RuleProfile = nil
 		result.Metrics = nil
 		result.AggregatedProfile = profiler.AggregateProfiles(profiles...)
 		timersAggregated := map[string]interface{}{}
@@ -405,6 +480,19 @@ func eval(args []string, params evalCommandParams, w io.Writer) (bool, error) {
 		err = pr.Raw(w, result)
 	case evalDiscardOutput:
 		err = pr.Discard(w, result)
+	case evalNDJSONOutput:
+		// The results have already been streamed. Emit the trace events (one
+		// per line) followed by a trailer if there is anything else to report.
+		if result.Explanation != nil {
+			if err = topdown.JSONTrace(w, result.Explanation); err != nil {
+				return false, err
+			}
+			result.Explanation = nil
+		}
+		if result.Errors != nil || result.Warnings != nil || result.Metrics != nil || result.Explanation != nil ||
+			result.Profile != nil || result.Coverage != nil {
+			err = pr.NDJSON(w, result)
+		}
 	default:
 		err = pr.JSON(w, result)
 	}
@@ -420,13 +508,128 @@ func eval(args []string, params evalCommandParams, w io.Writer) (bool, error) {
 		// that the command doesn't print the same error twice. The error will
 		// have been printed above by the presentation package.
 		return false, regoError{}
-	} else if len(result.Result) == 0 {
+	} else if len(result.Result) == 0 && ectx.streamed == 0 {
 		return false, nil
 	}
 
 	return true, nil
 }
 
+// evalWatch evaluates the query and then re-evaluates it every time the
+// loaded files change, until a signal is received on the stop channel.
+func evalWatch(args []string, params evalCommandParams, w io.Writer) error {
+	paths := append([]string{}, params.dataPaths.v...)
+	paths = append(paths, params.bundlePaths.v...)
+	if params.inputPath != "" {
+		paths = append(paths, params.inputPath)
+	}
+
+	watcher, err := pathwatcher.CreatePathWatcher(paths)
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	signal.Notify(params.stopChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(params.stopChan)
+
+	prev := evalToString(args, params)
+	fmt.Fprint(w, prev)
+
+	for {
+		fmt.Fprintln(w, strings.Repeat("*", 80))
+		fmt.Fprintln(w, "Watching for changes ...")
+
+		select {
+		case evt := <-watcher.Events:
+			mask := fsnotify.Create | fsnotify.Write | fsnotify.Remove | fsnotify.Rename
+			if (evt.Op & mask) == 0 {
+				continue
+			}
+
+			// Editors commonly generate several events for a single save, so
+			// wait for the events to settle before re-evaluating.
+			drainWatcherEvents(watcher, evalWatchDebounce)
+
+			curr := evalToString(args, params)
+			if diff := diffLines(prev, curr); diff == "" {
+				fmt.Fprintln(w, "No changes in result.")
+			} else {
+				fmt.Fprint(w, diff)
+			}
+			prev = curr
+
+		case err := <-watcher.Errors:
+			fmt.Fprintln(w, "Error watching files:", err)
+
+		case <-params.stopChan:
+			return nil
+		}
+	}
+}
+
+const evalWatchDebounce = 100 * time.Millisecond
+
+func drainWatcherEvents(watcher *fsnotify.Watcher, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case <-watcher.Events:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(d)
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+// evalToString evaluates the query and returns the output, or the error if
+// the evaluation could not be set up.
+func evalToString(args []string, params evalCommandParams) string {
+	var buf bytes.Buffer
+	if _, err := eval(args, params, &buf); err != nil {
+		if _, ok := err.(regoError); !ok {
+			fmt.Fprintln(&buf, err)
+		}
+	}
+	return buf.String()
+}
+
+// diffLines returns the lines removed from old and added in new, prefixed
+// with '-' and '+' respectively.
+func diffLines(old, new string) string {
+	dmp := diffmatchpatch.New()
+	a, b, lines := dmp.DiffLinesToChars(old, new)
+	diffs := dmp.DiffCharsToLines(dmp.DiffMain(a, b, false), lines)
+
+	var sb strings.Builder
+	for _, d := range diffs {
+		var prefix string
+		switch d.Type {
+		case diffmatchpatch.DiffDelete:
+			prefix = "-"
+		case diffmatchpatch.DiffInsert:
+			prefix = "+"
+		default:
+			continue
+		}
+		for _, line := range strings.SplitAfter(d.Text, "\n") {
+			if line == "" {
+				continue
+			}
+			sb.WriteString(prefix)
+			sb.WriteString(line)
+			if !strings.HasSuffix(line, "\n") {
+				sb.WriteString("\n")
+			}
+		}
+	}
+	return sb.String()
+}
+
 func evalOnce(ctx context.Context, ectx *evalContext) pr.Output {
 	var result pr.Output
 	var resultErr error
@@ -438,6 +641,12 @@ func evalOnce(ctx context.Context, ectx *evalContext) pr.Output {
 	if ectx.profiler != nil {
 		ectx.profiler.reset()
 	}
+	if ectx.ruleProfiler != nil {
+		ectx.ruleProfiler.Reset()
+	}
+	if ectx.builtInErrorList != nil {
+		*ectx.builtInErrorList = (*ectx.builtInErrorList)[:0]
+	}
 	r := rego.New(ectx.regoArgs...)
 
 	if !ectx.params.partial {
@@ -445,7 +654,14 @@ func evalOnce(ctx context.Context, ectx *evalContext) pr.Output {
 		pq, resultErr = r.PrepareForEval(ctx)
 		if resultErr == nil {
 			parsedModules = pq.Modules()
-			result.Result, resultErr = pq.Eval(ctx, ectx.evalArgs...)
+			if ectx.stream != nil {
+				resultErr = pq.Iter(ctx, func(r rego.Result) error {
+					ectx.streamed++
+					return pr.NDJSON(ectx.stream, r)
+				}, ectx.evalArgs...)
+			} else {
+				result.Result, resultErr = pq.Eval(ctx, ectx.evalArgs...)
+			}
 		}
 	} else {
 		var pq rego.PreparedPartialQuery
@@ -461,7 +677,11 @@ func evalOnce(ctx context.Context, ectx *evalContext) pr.Output {
 	if ectx.builtInErrorList != nil {
 		for _, err := range *(ectx.builtInErrorList) {
 			err := err
-			result.Errors = append(result.Errors, pr.NewOutputErrors(&err)...)
+			if ectx.params.showBuiltinErrors {
+				result.Errors = append(result.Errors, pr.NewOutputErrors(&err)...)
+			} else {
+				result.Warnings = append(result.Warnings, pr.NewOutputErrors(&err)...)
+			}
 		}
 	}
 
@@ -490,6 +710,11 @@ func evalOnce(ctx context.Context, ectx *evalContext) pr.Output {
 		}
 
 		result.Profile = ectx.profiler.p.ReportTopNResults(ectx.params.profileLimit.v, sortOrder)
+
+		result.RuleProfile = ectx.ruleProfiler.StatsByRef()
+		if len(result.RuleProfile) > ectx.params.profileLimit.v {
+			result.RuleProfile = result.RuleProfile[:ectx.params.profileLimit.v]
+		}
 	}
 
 	if ectx.params.coverage {
@@ -500,15 +725,30 @@ func evalOnce(ctx context.Context, ectx *evalContext) pr.Output {
 	return result
 }
 
+// cacheSnapshotTracer buffers trace events along with snapshots of the virtual
+// document cache, which are included in the JSON representation of traces.
+type cacheSnapshotTracer struct {
+	*topdown.BufferTracer
+}
+
+func (t cacheSnapshotTracer) Config() topdown.TraceConfig {
+	config := t.BufferTracer.Config()
+	config.SnapshotVirtualCache = true
+	return config
+}
+
 type evalContext struct {
 	params           evalCommandParams
 	metrics          metrics.Metrics
 	profiler         *resettableProfiler
+	ruleProfiler     *topdown.RuleProfiler
 	cover            *cover.Cover
 	tracer           *topdown.BufferTracer
 	regoArgs         []func(*rego.Rego)
 	evalArgs         []rego.EvalOption
 	builtInErrorList *[]topdown.Error
+	stream           io.Writer // set when results are streamed as they are produced
+	streamed         int
 }
 
 func setupEval(args []string, params evalCommandParams) (*evalContext, error) {
@@ -609,7 +849,11 @@ func setupEval(args []string, params evalCommandParams) (*evalContext, error) {
 
 	if params.explain != nil && params.explain.String() != explainModeOff {
 		tracer = topdown.NewBufferTracer()
-		evalArgs = append(evalArgs, rego.EvalQueryTracer(tracer))
+		if params.explainVirtualCache && params.outputFormat.String() == evalNDJSONOutput {
+			evalArgs = append(evalArgs, rego.EvalQueryTracer(cacheSnapshotTracer{tracer}))
+		} else {
+			evalArgs = append(evalArgs, rego.EvalQueryTracer(tracer))
+		}
 
 		if params.target.String() == compile.TargetWasm {
 			fmt.Fprintf(os.Stderr, "warning: explain mode \"%v\" is not supported with wasm target\n", params.explain.String())
@@ -631,9 +875,11 @@ func setupEval(args []string, params evalCommandParams) (*evalContext, error) {
 	}
 
 	rp := resettableProfiler{}
+	var ruleProfiler *topdown.RuleProfiler
 	if params.profile {
 		rp.p = profiler.New()
-		evalArgs = append(evalArgs, rego.EvalQueryTracer(&rp))
+		ruleProfiler = topdown.NewRuleProfiler()
+		evalArgs = append(evalArgs, rego.EvalQueryTracer(&rp), rego.EvalRuleProfiler(ruleProfiler))
 	}
 
 	if params.partial {
@@ -656,9 +902,11 @@ func setupEval(args []string, params evalCommandParams) (*evalContext, error) {
 		}
 	}
 
+	// Built-in errors are collected unless they are fatal. They are reported
+	// as errors with --show-builtin-errors and as warnings otherwise.
 	var builtInErrors []topdown.Error
-	if params.showBuiltinErrors {
-		regoArgs = append(regoArgs, rego.BuiltinErrorList(&builtInErrors))
+	if !params.strictBuiltinErrors {
+		evalArgs = append(evalArgs, rego.EvalBuiltinErrorList(&builtInErrors))
 	}
 
 	if params.capabilities != nil {
@@ -677,6 +925,7 @@ func setupEval(args []string, params evalCommandParams) (*evalContext, error) {
 		params:           params,
 		metrics:          m,
 		profiler:         &rp,
+		ruleProfiler:     ruleProfiler,
 		cover:            c,
 		tracer:           tracer,
 		regoArgs:         regoArgs,
@@ -16,7 +16,9 @@ import (
 	"path/filepath"
 	"reflect"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/internal/file/archive"
@@ -59,6 +61,118 @@ func TestEvalExitCode(t *testing.T) {
 	}
 }
 
+func TestEvalWatch(t *testing.T) {
+	files := map[string]string{
+		"policy.rego": "package x\n\nimport rego.v1\n\nallow if input.user == \"alice\"\n\ndeny := \"nope\"",
+		"input.json":  `{"user": "alice"}`,
+	}
+
+	test.WithTempFS(files, func(root string) {
+		buf := test.BlockingWriter{}
+
+		params := newEvalCommandParams()
+		params.watch = true
+		params.dataPaths = newrepeatedStringFlag([]string{filepath.Join(root, "policy.rego")})
+		params.inputPath = filepath.Join(root, "input.json")
+		_ = params.outputFormat.Set(evalPrettyOutput)
+
+		done := make(chan error)
+		go func() {
+			done <- evalWatch([]string{"data.x"}, params, &buf)
+		}()
+
+		expected := `{
+  "allow": true,
+  "deny": "nope"
+}
+********************************************************************************
+Watching for changes ...
+`
+		if !test.Eventually(t, 2*time.Second, func() bool {
+			return buf.String() == expected
+		}) {
+			t.Fatalf("expected:\n\n%q\n\ngot:\n\n%q", expected, buf.String())
+		}
+		buf.Reset()
+
+		// update the input so the rule is no longer defined
+		if err := os.WriteFile(filepath.Join(root, "input.json"), []byte(`{"user": "bob"}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		expected = `-  "allow": true,
+********************************************************************************
+Watching for changes ...
+`
+		if !test.Eventually(t, 2*time.Second, func() bool {
+			return buf.String() == expected
+		}) {
+			t.Fatalf("expected:\n\n%q\n\ngot:\n\n%q", expected, buf.String())
+		}
+		buf.Reset()
+
+		// update the policy
+		if err := os.WriteFile(filepath.Join(root, "policy.rego"), []byte("package x\n\ndeny := \"no way\""), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		expected = `-  "deny": "nope"
++  "deny": "no way"
+********************************************************************************
+Watching for changes ...
+`
+		if !test.Eventually(t, 2*time.Second, func() bool {
+			return buf.String() == expected
+		}) {
+			t.Fatalf("expected:\n\n%q\n\ngot:\n\n%q", expected, buf.String())
+		}
+
+		params.stopChan <- syscall.SIGINT
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestEvalWatchValidation(t *testing.T) {
+	params := newEvalCommandParams()
+	params.watch = true
+	if err := validateEvalParams(&params, []string{"data"}); err == nil {
+		t.Fatal("expected error for --watch without paths")
+	}
+
+	params.dataPaths = newrepeatedStringFlag([]string{"policy.rego"})
+	params.stdinInput = true
+	if err := validateEvalParams(&params, []string{"data"}); err == nil {
+		t.Fatal("expected error for --watch with --stdin-input")
+	}
+
+	params.stdinInput = false
+	if err := params.outputFormat.Set(evalNDJSONOutput); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := validateEvalParams(&params, []string{"data"}); err == nil {
+		t.Fatal("expected error for --watch with ndjson output")
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		old, new, exp string
+	}{
+		{"a\nb\n", "a\nb\n", ""},
+		{"a\nb\n", "a\nc\n", "-b\n+c\n"},
+		{"a\nb", "a\nb\nc", "-b\n+b\n+c\n"},
+		{"", "a\n", "+a\n"},
+	}
+
+	for _, tc := range tests {
+		if act := diffLines(tc.old, tc.new); act != tc.exp {
+			t.Errorf("diffLines(%q, %q): expected %q but got %q", tc.old, tc.new, tc.exp, act)
+		}
+	}
+}
+
 func TestEvalWithShowBuiltinErrors(t *testing.T) {
 	files := map[string]string{
 		"x.rego": `package x
@@ -186,6 +300,14 @@ p {
 				t.Fatalf("Index %v: Expected number of generated expressions %v but got %v", idx, expectedNumGenExpr[idx], actualExprStat.NumGenExpr)
 			}
 		}
+
+		if len(output.RuleProfile) != 1 {
+			t.Fatalf("Expected rule profile for one rule but got %v", output.RuleProfile)
+		}
+
+		if ref := output.RuleProfile[0].Ref.String(); ref != "data.x.p" || output.RuleProfile[0].NumEval != 1 {
+			t.Fatalf("Expected one evaluation of data.x.p but got %v", output.RuleProfile[0])
+		}
 	})
 }
 
@@ -1043,9 +1165,16 @@ func TestEvalWithStrictBuiltinErrors(t *testing.T) {
 		t.Fatal("unexpected error:", err)
 	}
 
-	if buf.String() != "{}\n" {
+	var output presentation.Output
+	if err := util.UnmarshalJSON(buf.Bytes(), &output); err != nil {
+		t.Fatal(err)
+	}
+	if len(output.Result) != 0 || len(output.Errors) != 0 {
 		t.Fatal("expected undefined output but got:", buf.String())
 	}
+	if len(output.Warnings) != 1 || output.Warnings[0].Message != "div: divide by zero" {
+		t.Fatal("expected divide by zero warning but got:", buf.String())
+	}
 }
 
 func assertResultSet(t *testing.T, rs rego.ResultSet, expected string) {
@@ -1479,7 +1608,19 @@ func TestEvalDiscardOutput(t *testing.T) {
 				}
 				return params
 			}(),
-			expected: `{}
+			expected: `{
+  "warnings": [
+    {
+      "code": "eval_builtin_error",
+      "location": {
+        "col": 1,
+        "file": "",
+        "row": 1
+      },
+      "message": "div: divide by zero"
+    }
+  ]
+}
 `},
 		"error example show built-in-errors": {
 			query: "1/0",
@@ -1522,6 +1663,161 @@ func TestEvalDiscardOutput(t *testing.T) {
 	}
 }
 
+func TestEvalNDJSONOutput(t *testing.T) {
+	tests := map[string]struct {
+		query    string
+		strict   bool
+		defined  bool
+		expected string
+	}{
+		"enumeration": {
+			query:   "x := [1, 2, 3][_]",
+			defined: true,
+			expected: `{"expressions":[{"value":true,"text":"x := [1, 2, 3][_]","location":{"row":1,"col":1}}],"bindings":{"x":1}}
+{"expressions":[{"value":true,"text":"x := [1, 2, 3][_]","location":{"row":1,"col":1}}],"bindings":{"x":2}}
+{"expressions":[{"value":true,"text":"x := [1, 2, 3][_]","location":{"row":1,"col":1}}],"bindings":{"x":3}}
+`,
+		},
+		"undefined": {
+			query:    "x := [1, 2, 3][_]; x > 3",
+			expected: "",
+		},
+		"error": {
+			query:  "x := [1, 2, 0][_]; 1/x",
+			strict: true,
+			expected: `{"expressions":[{"value":true,"text":"x := [1, 2, 0][_]","location":{"row":1,"col":1}},{"value":1,"text":"1/x","location":{"row":1,"col":20}}],"bindings":{"x":1}}
+{"expressions":[{"value":true,"text":"x := [1, 2, 0][_]","location":{"row":1,"col":1}},{"value":0.5,"text":"1/x","location":{"row":1,"col":20}}],"bindings":{"x":2}}
+{"errors":[{"message":"div: divide by zero","code":"eval_builtin_error","location":{"file":"","row":1,"col":20}}]}
+`,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			params := newEvalCommandParams()
+			if err := params.outputFormat.Set(evalNDJSONOutput); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			params.strictBuiltinErrors = tc.strict
+
+			var buf bytes.Buffer
+			defined, err := eval([]string{tc.query}, params, &buf)
+			if tc.defined != defined {
+				t.Errorf("expected defined %v, got %v (err: %v)", tc.defined, defined, err)
+			}
+			if actual := buf.String(); actual != tc.expected {
+				t.Errorf("expected output %q\ngot %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestEvalNDJSONOutputWithExplain(t *testing.T) {
+	params := newEvalCommandParams()
+	if err := params.outputFormat.Set(evalNDJSONOutput); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := params.explain.Set(explainModeFull); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := eval([]string{"x := 1"}, params, &buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) < 2 {
+		t.Fatalf("expected result and trace lines, got %q", buf.String())
+	}
+
+	var result map[string]interface{}
+	if err := util.UnmarshalJSON([]byte(lines[0]), &result); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := result["bindings"]; !ok {
+		t.Fatalf("expected first line to contain result, got %q", lines[0])
+	}
+
+	var ops []string
+	for _, line := range lines[1:] {
+		var evt struct {
+			Op       string        `json:"op"`
+			Location *ast.Location `json:"location"`
+		}
+		if err := util.UnmarshalJSON([]byte(line), &evt); err != nil {
+			t.Fatal(err)
+		}
+		if evt.Location == nil {
+			t.Fatalf("expected event to contain location, got %q", line)
+		}
+		ops = append(ops, evt.Op)
+	}
+
+	if exp := []string{"enter", "eval", "exit", "redo", "redo"}; strings.Join(ops, ",") != strings.Join(exp, ",") {
+		t.Fatalf("expected ops %v, got %v", exp, ops)
+	}
+}
+
+func TestEvalExplainVirtualCache(t *testing.T) {
+	for _, withCache := range []bool{false, true} {
+		t.Run(fmt.Sprint(withCache), func(t *testing.T) {
+			params := newEvalCommandParams()
+			if err := params.outputFormat.Set(evalNDJSONOutput); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if err := params.explain.Set(explainModeFull); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			params.explainVirtualCache = withCache
+
+			var buf bytes.Buffer
+			test.WithTempFS(map[string]string{"x.rego": "package x\n\np := 1\n"}, func(path string) {
+				if err := params.dataPaths.Set(filepath.Join(path, "x.rego")); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				if _, err := eval([]string{"data.x.p"}, params, &buf); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+			})
+
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+
+			var evt struct {
+				VirtualCache []struct {
+					Ref ast.Ref `json:"ref"`
+				} `json:"virtual_cache"`
+			}
+			if err := util.UnmarshalJSON([]byte(lines[len(lines)-1]), &evt); err != nil {
+				t.Fatal(err)
+			}
+
+			if !withCache && evt.VirtualCache != nil {
+				t.Fatalf("expected no virtual cache, got %q", lines[len(lines)-1])
+			} else if withCache && (len(evt.VirtualCache) != 1 || !evt.VirtualCache[0].Ref.Equal(ast.MustParseRef("data.x.p"))) {
+				t.Fatalf("expected data.x.p in virtual cache, got %q", lines[len(lines)-1])
+			}
+		})
+	}
+}
+
+func TestEvalNDJSONOutputValidation(t *testing.T) {
+	params := newEvalCommandParams()
+	if err := params.outputFormat.Set(evalNDJSONOutput); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	params.partial = true
+	if err := validateEvalParams(&params, []string{"data"}); err == nil {
+		t.Fatal("expected error for partial evaluation with ndjson output")
+	}
+
+	params.partial = false
+	params.count = 2
+	if err := validateEvalParams(&params, []string{"data"}); err == nil {
+		t.Fatal("expected error for --count > 1 with ndjson output")
+	}
+}
+
 func TestEvalDiscardProfilerOutput(t *testing.T) {
 	params := newEvalCommandParams()
 	err := params.outputFormat.Set(evalDiscardOutput)
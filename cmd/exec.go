@@ -6,7 +6,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 
 	"github.com/spf13/cobra"
 
@@ -50,7 +52,32 @@ After: Decision Logs
 By default, the 'exec' command executes the "default decision" (specified in
 the OPA configuration) against each input file. This can be overridden by
 specifying the --decision argument and pointing at a specific policy decision,
-e.g., opa exec --decision /foo/bar/baz ...`,
+e.g., opa exec --decision /foo/bar/baz ...
+
+By default, the results for all input files are written to stdout. If the
+--output-template argument is specified, the result for each input file is
+written to the file named by executing the template instead. The template is
+a Go template with the following fields of the input file:
+
+	{{.Path}}   path of the input file
+	{{.Rel}}    path of the input file relative to the path it was found under
+	{{.Dir}}    directory of the input file
+	{{.Base}}   base name of the input file
+	{{.Name}}   base name of the input file without its extension
+	{{.Ext}}    extension of the input file
+
+e.g., opa exec --output-template 'results/{{.Rel}}.json' ...
+
+The --format=sarif argument outputs the results as a SARIF log, for code
+scanning tools. Each element of an array result, and any other defined result
+except false, is reported as a SARIF result for the input file. The message of
+a SARIF result is the element if it is a string, or its "msg" field if it is
+an object. The "level" field of an object sets the level of the SARIF result,
+which is "error" by default.
+
+If the --watch argument is specified, the 'exec' command keeps watching the
+paths after executing against them, and executes against the input files that
+are created or changed, until it is interrupted.`,
 
 		Args: cobra.MinimumNArgs(1),
 		PreRunE: func(cmd *cobra.Command, _ []string) error {
@@ -78,6 +105,8 @@ e.g., opa exec --decision /foo/bar/baz ...`,
 	cmd.Flags().VarP(params.LogLevel, "log-level", "l", "set log level")
 	cmd.Flags().Var(params.LogFormat, "log-format", "set log format")
 	cmd.Flags().StringVar(&params.LogTimestampFormat, "log-timestamp-format", "", "set log timestamp format (OPA_LOG_TIMESTAMP_FORMAT environment variable)")
+	cmd.Flags().StringVar(&params.OutputTemplate, "output-template", "", "set the template of the file paths to write the result for each input file to")
+	cmd.Flags().BoolVarP(&params.Watch, "watch", "w", false, "watch the paths for changes and execute against the changed input files")
 	cmd.Flags().DurationVar(&params.Timeout, "timeout", 0, "set exec timeout with a Go-style duration, such as '5m 30s'. (default unlimited)")
 	addV1CompatibleFlag(cmd.Flags(), &params.V1Compatible, false)
 
@@ -91,6 +120,13 @@ func runExec(params *exec.Params) error {
 		ctx, cancel = context.WithTimeout(ctx, params.Timeout)
 		defer cancel()
 	}
+	if params.Watch {
+		// Stop watching on interrupt, so that the decision logs are still
+		// flushed.
+		var stop func()
+		ctx, stop = signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+	}
 	return runExecWithContext(ctx, params)
 }
 
@@ -156,6 +156,199 @@ func TestExecBundleFlag(t *testing.T) {
 	})
 }
 
+func TestExecOutputTemplate(t *testing.T) {
+
+	files := map[string]string{
+		"files/test.json":     `{"foo": 7}`,
+		"files/sub/test.yaml": `bar: 8`,
+		"bundle/x.rego": `package system
+
+		main["hello"]`,
+	}
+
+	test.WithTempFS(files, func(dir string) {
+
+		var buf bytes.Buffer
+		params := exec.NewParams(&buf)
+		_ = params.OutputFormat.Set("json")
+		params.BundlePaths = []string{dir + "/bundle/"}
+		params.Paths = append(params.Paths, dir+"/files/")
+		params.OutputTemplate = dir + "/out/{{.Rel}}.out.json"
+
+		err := runExec(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if buf.Len() != 0 {
+			t.Fatalf("Expected no output but got: %v", buf.String())
+		}
+
+		for _, f := range []string{"test.json", "sub/test.yaml"} {
+			bs, err := os.ReadFile(filepath.Join(dir, "out", f+".out.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			output := util.MustUnmarshalJSON(bytes.ReplaceAll(bs, []byte(dir), nil))
+
+			exp := util.MustUnmarshalJSON([]byte(fmt.Sprintf(`{"result": [{
+				"path": "/files/%s",
+				"result": ["hello"]
+			}]}`, f)))
+
+			if !reflect.DeepEqual(output, exp) {
+				t.Fatal("Expected:", exp, "Got:", output)
+			}
+		}
+
+		// Outputs written next to the inputs are not executed against.
+		params.OutputTemplate = "{{.Dir}}/{{.Name}}.out.json"
+
+		err = runExec(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, f := range []string{"test.out.json", "test.out.out.json"} {
+			_, err := os.Stat(filepath.Join(dir, "files", f))
+			if exp := f == "test.out.json"; exp != (err == nil) {
+				t.Fatalf("Expected %v to exist: %v, got error: %v", f, exp, err)
+			}
+		}
+	})
+}
+
+func TestExecSARIF(t *testing.T) {
+
+	files := map[string]string{
+		"files/a.json": `{"public": true}`,
+		"files/b.json": `{"public": false}`,
+		"files/c.json": `{"public": true, "tags": []}`,
+		"files/d.json": `{"public":`,
+		"bundle/x.rego": `package system
+
+		main contains "bucket is public" if input.public
+
+		main contains {"msg": "bucket has no tags", "level": "warning"} if count(input.tags) == 0`,
+	}
+
+	test.WithTempFS(files, func(dir string) {
+
+		var buf bytes.Buffer
+		params := exec.NewParams(&buf)
+		_ = params.OutputFormat.Set("sarif")
+		params.BundlePaths = []string{dir + "/bundle/"}
+		params.Paths = append(params.Paths, dir+"/files/")
+		params.V1Compatible = true
+
+		err := runExec(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		output := util.MustUnmarshalJSON(bytes.ReplaceAll(buf.Bytes(), []byte(dir), nil)).(map[string]interface{})
+		run := output["runs"].([]interface{})[0].(map[string]interface{})
+		delete(run, "tool")
+
+		location := func(path string) string {
+			return fmt.Sprintf(`[{"physicalLocation": {"artifactLocation": {"uri": %q}}}]`, path)
+		}
+
+		exp := util.MustUnmarshalJSON([]byte(`{
+			"invocations": [{
+				"executionSuccessful": false,
+				"toolExecutionNotifications": [{
+					"level": "error",
+					"message": {"text": "yaml: line 1: did not find expected node content"},
+					"locations": ` + location("/files/d.json") + `
+				}]
+			}],
+			"results": [{
+				"level": "error",
+				"message": {"text": "bucket is public"},
+				"locations": ` + location("/files/a.json") + `
+			}, {
+				"level": "error",
+				"message": {"text": "bucket is public"},
+				"locations": ` + location("/files/c.json") + `
+			}, {
+				"level": "warning",
+				"message": {"text": "bucket has no tags"},
+				"locations": ` + location("/files/c.json") + `
+			}]
+		}`))
+
+		if output["version"] != "2.1.0" || !reflect.DeepEqual(run, exp) {
+			t.Fatal("Expected:", exp, "Got:", output)
+		}
+	})
+}
+
+func TestExecWatch(t *testing.T) {
+
+	files := map[string]string{
+		"files/test.json": `{"foo": 7}`,
+		"bundle/x.rego": `package system
+
+		main := input.foo`,
+	}
+
+	test.WithTempFS(files, func(dir string) {
+
+		var buf bytes.Buffer
+		params := exec.NewParams(&buf)
+		_ = params.OutputFormat.Set("json")
+		params.BundlePaths = []string{dir + "/bundle/"}
+		params.Paths = append(params.Paths, dir+"/files/")
+		params.OutputTemplate = dir + "/out/{{.Name}}.json"
+		params.Watch = true
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- runExecWithContext(ctx, params)
+		}()
+
+		result := func(name string) interface{} {
+			bs, err := os.ReadFile(filepath.Join(dir, "out", name+".json"))
+			if err != nil {
+				return nil
+			}
+			var x struct {
+				Result []struct {
+					Result interface{} `json:"result"`
+				} `json:"result"`
+			}
+			if err := util.Unmarshal(bs, &x); err != nil || len(x.Result) != 1 {
+				return nil
+			}
+			return x.Result[0].Result
+		}
+
+		if !test.Eventually(t, 5*time.Second, func() bool {
+			return fmt.Sprint(result("test")) == "7"
+		}) {
+			t.Fatal("Expected initial result")
+		}
+
+		// The watcher might not be ready yet, so write until the change is seen.
+		if !test.Eventually(t, 5*time.Second, func() bool {
+			if err := os.WriteFile(filepath.Join(dir, "files", "test2.json"), []byte(`{"foo": 8}`), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			return fmt.Sprint(result("test2")) == "8"
+		}) {
+			t.Fatal("Expected result of the created file")
+		}
+
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
 func TestExecV1Compatible(t *testing.T) {
 	tests := []struct {
 		note         string
@@ -712,6 +905,18 @@ func TestInvalidConfigNonEmptyAndFailDefined(t *testing.T) {
 	}
 }
 
+func TestInvalidConfigWatchAndFail(t *testing.T) {
+	var buf bytes.Buffer
+	params := exec.NewParams(&buf)
+	params.Watch = true
+	params.Fail = true
+
+	err := exec.Exec(context.TODO(), nil, params)
+	if err == nil || err.Error() != "specify --watch or --fail, --fail-defined or --fail-non-empty but not both" {
+		t.Fatalf("Expected error '%s' but got '%s'", "specify --watch or --fail, --fail-defined or --fail-non-empty but not both", err)
+	}
+}
+
 func TestFailFlagCases(t *testing.T) {
 
 	var tests = []struct {
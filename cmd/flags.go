@@ -161,6 +161,10 @@ func addV1CompatibleFlag(fs *pflag.FlagSet, v1Compatible *bool, value bool) {
 	fs.BoolVar(v1Compatible, "v1-compatible", value, "opt-in to OPA features and behaviors that will be enabled by default in a future OPA v1.0 release")
 }
 
+func addCacheDirFlag(fs *pflag.FlagSet, dir *string) {
+	fs.StringVar(dir, "cache-dir", "", "set directory to cache parsed modules in, keyed by the module contents")
+}
+
 func addE2EFlag(fs *pflag.FlagSet, e2e *bool, value bool) {
 	fs.BoolVar(e2e, "e2e", value, "run benchmarks against a running OPA server")
 }
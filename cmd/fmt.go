@@ -27,6 +27,8 @@ type fmtCommandParams struct {
 	fail         bool
 	regoV1       bool
 	v1Compatible bool
+	configFile   string
+	styles       map[string]format.Style // styles by directory
 }
 
 var fmtParams = fmtCommandParams{}
@@ -63,7 +65,17 @@ that would change if formatted. The '-l' option will suppress any other output
 to stdout from the 'fmt' command.
 
 If the '--fail' option is supplied, the 'fmt' command will return a non zero exit
-code if a file would be reformatted.`,
+code if a file would be reformatted.
+
+The layout of the output can be configured with a style file. For every file, the
+'fmt' command uses the nearest ` + format.StyleFile + ` file in the directory of the file or one of
+its parents, or the file supplied with the '--config' option. For stdin, the search
+starts in the working directory. The style file supports these settings:
+
+    max_line_width: 100      # break arrays, sets and objects exceeding the width
+    import_grouping: root    # preserve (default), single, or root (rego/future, input, data)
+    align_object_keys: true  # align the values of objects with one key per line
+    indent_width: 2          # indent with spaces instead of tabs`,
 	PreRunE: func(cmd *cobra.Command, _ []string) error {
 		return env.CmdFlags.CheckEnvironmentVariables(cmd)
 	},
@@ -126,8 +138,14 @@ func formatFile(params *fmtCommandParams, out io.Writer, filename string, info o
 		return newError("failed to open file: %v", err)
 	}
 
+	style, err := params.style(filepath.Dir(filename))
+	if err != nil {
+		return newError("failed to load style: %v", err)
+	}
+
 	opts := format.Opts{}
 	opts.RegoVersion = params.regoVersion()
+	opts.Style = style
 	formatted, err := format.SourceWithOpts(filename, contents, opts)
 	if err != nil {
 		return newError("failed to format Rego source file: %v", err)
@@ -189,8 +207,14 @@ func formatStdin(params *fmtCommandParams, r io.Reader, w io.Writer) error {
 		return err
 	}
 
+	style, err := params.style(".")
+	if err != nil {
+		return err
+	}
+
 	opts := format.Opts{}
 	opts.RegoVersion = params.regoVersion()
+	opts.Style = style
 	formatted, err := format.SourceWithOpts("stdin", contents, opts)
 	if err != nil {
 		return err
@@ -200,6 +224,46 @@ func formatStdin(params *fmtCommandParams, r io.Reader, w io.Writer) error {
 	return err
 }
 
+// style returns the style for the files in dir: the style of the --config
+// file, or of the nearest style file in dir or one of its parents.
+func (p *fmtCommandParams) style(dir string) (format.Style, error) {
+	if p.configFile != "" {
+		return p.loadStyle(p.configFile)
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return format.Style{}, err
+	}
+
+	for {
+		path := filepath.Join(dir, format.StyleFile)
+		if _, err := os.Stat(path); err == nil {
+			return p.loadStyle(path)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return format.Style{}, nil
+		}
+		dir = parent
+	}
+}
+
+func (p *fmtCommandParams) loadStyle(path string) (format.Style, error) {
+	if s, ok := p.styles[path]; ok {
+		return s, nil
+	}
+	s, err := format.LoadStyle(path)
+	if err != nil {
+		return s, err
+	}
+	if p.styles == nil {
+		p.styles = map[string]format.Style{}
+	}
+	p.styles[path] = s
+	return s, nil
+}
+
 func doDiff(old, new []byte) (diffString string) {
 	dmp := diffmatchpatch.New()
 	diffs := dmp.DiffMain(string(old), string(new), false)
@@ -229,6 +293,7 @@ func init() {
 	formatCommand.Flags().BoolVar(&fmtParams.fail, "fail", false, "non zero exit code on reformat")
 	addRegoV1FlagWithDescription(formatCommand.Flags(), &fmtParams.regoV1, false, "format module(s) to be compatible with both Rego v1 and current OPA version)")
 	addV1CompatibleFlag(formatCommand.Flags(), &fmtParams.v1Compatible, false)
+	formatCommand.Flags().StringVar(&fmtParams.configFile, "config", "", "set path of the style file, instead of looking up "+format.StyleFile+" files")
 
 	RootCommand.AddCommand(formatCommand)
 }
@@ -743,3 +743,51 @@ q := all([true, false])
 		})
 	}
 }
+
+func TestFmtFormatFileWithStyle(t *testing.T) {
+	files := map[string]string{
+		"policy.rego":                 unformatted,
+		"team/" + format.StyleFile:    "indent_width: 2\n",
+		"team/lib/policy.rego":        unformatted,
+		"styles/four.yaml":            "indent_width: 4\n",
+		"invalid/" + format.StyleFile: "import_grouping: none\n",
+		"invalid/policy.rego":         unformatted,
+	}
+
+	test.WithTempFS(files, func(root string) {
+		for _, tc := range []struct {
+			file   string
+			config string
+			indent string
+		}{
+			{file: "policy.rego", indent: "\t"},
+			{file: "team/lib/policy.rego", indent: "  "},
+			{file: "team/lib/policy.rego", config: "styles/four.yaml", indent: "    "},
+		} {
+			params := fmtCommandParams{}
+			if tc.config != "" {
+				params.configFile = filepath.Join(root, tc.config)
+			}
+
+			var stdout bytes.Buffer
+			policyFile := filepath.Join(root, tc.file)
+			info, err := os.Stat(policyFile)
+			if err := formatFile(&params, &stdout, policyFile, info, err); err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+
+			exp := strings.ReplaceAll(formatted, "\t", tc.indent)
+			if actual := stdout.String(); actual != exp {
+				t.Fatalf("%v: Expected:\n%s\n\nGot:\n%s\n\n", tc.file, exp, actual)
+			}
+		}
+
+		params := fmtCommandParams{}
+		policyFile := filepath.Join(root, "invalid", "policy.rego")
+		info, err := os.Stat(policyFile)
+		err = formatFile(&params, io.Discard, policyFile, info, err)
+		if err == nil || !strings.Contains(err.Error(), `invalid import grouping "none"`) {
+			t.Fatalf("Expected invalid style error, got: %v", err)
+		}
+	})
+}
@@ -19,6 +19,7 @@ import (
 	ib "github.com/open-policy-agent/opa/internal/bundle/inspect"
 	pr "github.com/open-policy-agent/opa/internal/presentation"
 	iStrs "github.com/open-policy-agent/opa/internal/strings"
+	"github.com/open-policy-agent/opa/ir"
 	"github.com/open-policy-agent/opa/util"
 
 	"github.com/olekukonko/tablewriter"
@@ -31,6 +32,10 @@ const pageWidth = 80
 type inspectCommandParams struct {
 	outputFormat    *util.EnumFlag
 	listAnnotations bool
+	listPlans       bool
+	graph           bool
+	data            bool
+	dataDepth       int
 	v1Compatible    bool
 }
 
@@ -48,6 +53,7 @@ func newInspectCommandParams() inspectCommandParams {
 			evalPrettyOutput,
 		}),
 		listAnnotations: false,
+		dataDepth:       2,
 	}
 }
 
@@ -70,6 +76,9 @@ the following:
 * signature data
 * information about the Wasm module files
 * package- and rule annotations
+* the plans of bundles built with 'opa build -t plan', when --plan is set
+* the dependency graph of the rules and packages, when --graph is set
+* the size and shape of the data, when --data is set
 
 Example:
 
@@ -77,6 +86,31 @@ Example:
     bundle.tar.gz
     $ opa inspect bundle.tar.gz
 
+The --plan flag disassembles the plans, i.e., the intermediate representation (IR)
+generated by the planner, into a human-readable listing. It can be used to audit
+what the planner generated for Wasm or IR-based targets:
+
+    $ opa build -t plan -e example/allow example.rego
+    $ opa inspect --plan bundle.tar.gz
+
+The --graph flag exports the dependencies between the rules, and between the packages,
+of the bundle. Rules that no other rule depends on are candidates for removal, and the
+rules that depend, directly or indirectly, on a rule are the ones affected by changing
+it. With the default output format, the graph is printed in the DOT language instead of
+the summary, so that it can be rendered with Graphviz:
+
+    $ opa inspect --graph bundle.tar.gz | dot -Tsvg > graph.svg
+
+With --format=json, the graph is included in the "graph" field of the output. Every rule
+and package lists its dependencies and dependents.
+
+The --data flag reports the size of the data, serialized as compact JSON, the number of
+values it contains, and its maximum nesting depth, for the data and for the documents
+down to --data-depth keys below it, from the largest to the smallest. It helps to find
+the documents that make a bundle large or slow to activate:
+
+    $ opa inspect --data --data-depth 3 bundle.tar.gz
+
 You can provide exactly one OPA bundle or path to the 'inspect' command on the command-line. If you provide a path
 referring to a directory, the 'inspect' command will load that path as a bundle and summarize its structure and contents.
 `,
@@ -96,12 +130,21 @@ referring to a directory, the 'inspect' command will load that path as a bundle
 
 	addOutputFormat(inspectCommand.Flags(), params.outputFormat)
 	addListAnnotations(inspectCommand.Flags(), &params.listAnnotations)
+	inspectCommand.Flags().BoolVar(&params.listPlans, "plan", false, "disassemble the plans of plan bundles")
+	inspectCommand.Flags().BoolVar(&params.graph, "graph", false, "export the dependency graph of the rules and packages")
+	inspectCommand.Flags().BoolVar(&params.data, "data", false, "report the size and shape of the data")
+	inspectCommand.Flags().IntVar(&params.dataDepth, "data-depth", params.dataDepth, "set the number of keys below the root of the data to report the size and shape of documents down to")
 	addV1CompatibleFlag(inspectCommand.Flags(), &params.v1Compatible, false)
 	RootCommand.AddCommand(inspectCommand)
 }
 
 func doInspect(params inspectCommandParams, path string, out io.Writer) error {
-	info, err := ib.FileForRegoVersion(params.regoVersion(), path, params.listAnnotations)
+	dataDepth := -1
+	if params.data {
+		dataDepth = params.dataDepth
+	}
+
+	info, err := ib.FileForRegoVersion(params.regoVersion(), path, params.listAnnotations, params.listPlans, params.graph, dataDepth)
 	if err != nil {
 		return err
 	}
@@ -111,6 +154,11 @@ func doInspect(params inspectCommandParams, path string, out io.Writer) error {
 		return pr.JSON(out, info)
 
 	default:
+		if info.Graph != nil {
+			_, err := io.WriteString(out, info.Graph.DOT())
+			return err
+		}
+
 		if info.Manifest.Revision != "" || len(*info.Manifest.Roots) != 0 || len(info.Manifest.Metadata) != 0 ||
 			info.Manifest.RegoVersion != nil {
 			if err := populateManifest(out, info.Manifest); err != nil {
@@ -130,6 +178,16 @@ func doInspect(params inspectCommandParams, path string, out io.Writer) error {
 			}
 		}
 
+		if len(info.Data) != 0 {
+			populateData(out, info.Data)
+		}
+
+		if len(info.Plans) != 0 {
+			if err := populatePlans(out, info.Plans); err != nil {
+				return err
+			}
+		}
+
 		return nil
 	}
 }
@@ -315,6 +373,31 @@ func populateAnnotations(out io.Writer, refs []*ast.AnnotationsRef) error {
 	return nil
 }
 
+func populateData(out io.Writer, data []*ib.DataInfo) {
+	t := generateTableWithKeys(out, "path", "size", "values", "depth")
+	t.SetAutoMergeCells(false)
+	var lines [][]string
+
+	for _, d := range data {
+		lines = append(lines, []string{truncateTableStr(d.Path), strconv.Itoa(d.Size), strconv.Itoa(d.Values), strconv.Itoa(d.Depth)})
+	}
+
+	t.AppendBulk(lines)
+	fmt.Fprintln(out, "DATA:")
+	t.Render()
+}
+
+func populatePlans(out io.Writer, plans []*ib.PlanModule) error {
+	for _, p := range plans {
+		fmt.Fprintf(out, "PLAN (%v):\n", p.Path)
+		if err := ir.Disassemble(out, p.Policy); err != nil {
+			return err
+		}
+		fmt.Fprintln(out)
+	}
+	return nil
+}
+
 type listEntry struct {
 	key   string
 	value string
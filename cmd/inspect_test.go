@@ -566,6 +566,187 @@ Custom:
 	})
 }
 
+func TestDoInspectPlan(t *testing.T) {
+	files := map[string]string{
+		"test.rego": `package test
+
+p {
+	input.foo == "bar"
+}`,
+	}
+
+	test.WithTempFS(files, func(rootDir string) {
+		bundleFile := filepath.Join(rootDir, "bundle.tar.gz")
+
+		buildParams := newBuildParams()
+		if err := buildParams.target.Set("plan"); err != nil {
+			t.Fatal(err)
+		}
+		if err := buildParams.entrypoints.Set("test/p"); err != nil {
+			t.Fatal(err)
+		}
+		buildParams.outputFile = bundleFile
+
+		if err := dobuild(buildParams, []string{filepath.Join(rootDir, "test.rego")}); err != nil {
+			t.Fatal(err)
+		}
+
+		var out bytes.Buffer
+		params := newInspectCommandParams()
+		params.listPlans = true
+
+		if err := doInspect(params, bundleFile, &out); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		output := out.String()
+		for _, exp := range []string{
+			"PLAN (/plan.json):\n",
+			"plan test/p\n",
+			"func g0.data.test.p(%0, %1) -> %2\n",
+			"    dot %0 \"foo\" -> %4  # ",
+		} {
+			if !strings.Contains(output, exp) {
+				t.Fatalf("Expected output to contain %q, got:\n\n%v", exp, output)
+			}
+		}
+
+		out.Reset()
+		if err := params.outputFormat.Set(evalJSONOutput); err != nil {
+			t.Fatal(err)
+		}
+		if err := doInspect(params, bundleFile, &out); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		var result struct {
+			Plans []struct {
+				Path string `json:"path"`
+				Plan struct {
+					Plans struct {
+						Plans []struct {
+							Name string `json:"name"`
+						} `json:"plans"`
+					} `json:"plans"`
+				} `json:"plan"`
+			} `json:"plans"`
+		}
+		if err := util.Unmarshal(out.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Plans) != 1 || result.Plans[0].Path != "/plan.json" ||
+			len(result.Plans[0].Plan.Plans.Plans) != 1 || result.Plans[0].Plan.Plans.Plans[0].Name != "test/p" {
+			t.Fatalf("Unexpected JSON output: %s", out.String())
+		}
+	})
+}
+
+func TestDoInspectGraph(t *testing.T) {
+	files := map[string]string{
+		"lib.rego": `package lib
+
+allowed {
+	input.user == "admin"
+}`,
+		"policy.rego": `package policy
+
+import data.lib
+
+allow {
+	lib.allowed
+}`,
+	}
+
+	test.WithTempFS(files, func(rootDir string) {
+		var out bytes.Buffer
+		params := newInspectCommandParams()
+		params.graph = true
+
+		if err := doInspect(params, rootDir, &out); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		output := out.String()
+		for _, exp := range []string{
+			"digraph {\n",
+			"\t\t\"data.policy.allow\" [label=\"allow\"];\n",
+			"\t\"data.policy.allow\" -> \"data.lib.allowed\";\n",
+		} {
+			if !strings.Contains(output, exp) {
+				t.Fatalf("Expected output to contain %q, got:\n\n%v", exp, output)
+			}
+		}
+		if strings.Contains(output, "NAMESPACES:") {
+			t.Fatalf("Expected only the graph, got:\n\n%v", output)
+		}
+
+		out.Reset()
+		if err := params.outputFormat.Set(evalJSONOutput); err != nil {
+			t.Fatal(err)
+		}
+		if err := doInspect(params, rootDir, &out); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		var result struct {
+			Graph struct {
+				Rules []struct {
+					Path       string   `json:"path"`
+					Dependents []string `json:"dependents"`
+				} `json:"rules"`
+				Packages []struct {
+					Path         string   `json:"path"`
+					Dependencies []string `json:"dependencies"`
+				} `json:"packages"`
+			} `json:"graph"`
+		}
+		if err := util.Unmarshal(out.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		g := result.Graph
+		if len(g.Rules) != 2 || g.Rules[0].Path != "data.lib.allowed" ||
+			len(g.Rules[0].Dependents) != 1 || g.Rules[0].Dependents[0] != "data.policy.allow" {
+			t.Fatalf("Unexpected JSON output: %s", out.String())
+		}
+		if len(g.Packages) != 2 || g.Packages[1].Path != "data.policy" ||
+			len(g.Packages[1].Dependencies) != 1 || g.Packages[1].Dependencies[0] != "data.lib" {
+			t.Fatalf("Unexpected JSON output: %s", out.String())
+		}
+	})
+}
+
+func TestDoInspectData(t *testing.T) {
+	files := map[string]string{
+		"roles/data.json": `{"admin": ["alice", "bob"], "dev": {"a": {"b": 1}}}`,
+		"users/data.yaml": `x: 1`,
+	}
+
+	test.WithTempFS(files, func(rootDir string) {
+		var out bytes.Buffer
+		params := newInspectCommandParams()
+		params.data = true
+		params.dataDepth = 1
+
+		if err := doInspect(params, rootDir, &out); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		expected := `DATA:
++------------+------+--------+-------+
+|    PATH    | SIZE | VALUES | DEPTH |
++------------+------+--------+-------+
+| data       | 71   | 10     | 4     |
+| data.roles | 45   | 7      | 3     |
+| data.users | 7    | 2      | 1     |
++------------+------+--------+-------+
+`
+
+		if output := out.String(); !strings.HasSuffix(output, expected) {
+			t.Fatalf("Expected output to end with:\n\n%v\n\ngot:\n\n%v", expected, output)
+		}
+	})
+}
+
 func TestDoInspectV1Compatible(t *testing.T) {
 	tests := []struct {
 		note         string
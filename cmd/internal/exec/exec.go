@@ -9,8 +9,13 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
+	"text/template"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/open-policy-agent/opa/internal/pathwatcher"
 	"github.com/open-policy-agent/opa/logging"
 	"github.com/open-policy-agent/opa/sdk"
 	"github.com/open-policy-agent/opa/util"
@@ -19,6 +24,8 @@ import (
 type Params struct {
 	Paths               []string       // file paths to execute against
 	Output              io.Writer      // output stream to write normal output to
+	OutputTemplate      string         // template of the file paths to write the output for each input file to (default: write all outputs to Output)
+	Watch               bool           // watch the file paths for changes and execute against the changed files
 	ConfigFile          string         // OPA configuration file path
 	ConfigOverrides     []string       // OPA configuration overrides (--set arguments)
 	ConfigOverrideFiles []string       // OPA configuration overrides (--set-file arguments)
@@ -39,7 +46,7 @@ type Params struct {
 func NewParams(w io.Writer) *Params {
 	return &Params{
 		Output:       w,
-		OutputFormat: util.NewEnumFlag("pretty", []string{"pretty", "json"}),
+		OutputFormat: util.NewEnumFlag("pretty", []string{"pretty", "json", "sarif"}),
 		LogLevel:     util.NewEnumFlag("error", []string{"debug", "info", "error"}),
 		LogFormat:    util.NewEnumFlag("json", []string{"text", "json", "json-pretty"}),
 	}
@@ -55,14 +62,18 @@ func (p *Params) validateParams() error {
 	if p.FailNonEmpty && p.FailDefined {
 		return errors.New("specify --fail-non-empty or --fail-defined but not both")
 	}
+	if p.Watch && (p.Fail || p.FailDefined || p.FailNonEmpty) {
+		return errors.New("specify --watch or --fail, --fail-defined or --fail-non-empty but not both")
+	}
 	return nil
 }
 
-// Exec executes OPA against the supplied files and outputs each result.
+// Exec executes OPA against the supplied files and outputs each result. If
+// params.Watch is set, Exec then executes OPA against the files that change
+// until ctx is done.
 //
 // NOTE(tsandall): consider expanding functionality:
 //
-//   - specialized output formats (e.g., pretty/non-JSON outputs)
 //   - exit codes set by convention or policy (e.g,. non-empty set => error)
 //   - support for new input file formats beyond JSON and YAML
 func Exec(ctx context.Context, opa *sdk.OPA, params *Params) error {
@@ -72,23 +83,77 @@ func Exec(ctx context.Context, opa *sdk.OPA, params *Params) error {
 		return err
 	}
 
+	e := &executor{opa: opa, params: params, outputs: map[string]struct{}{}}
+	if params.OutputTemplate != "" {
+		e.tmpl, err = template.New("output").Option("missingkey=error").Parse(params.OutputTemplate)
+		if err != nil {
+			return fmt.Errorf("invalid output template: %w", err)
+		}
+	}
+
+	failCount, errorCount, err := e.exec(ctx, params.Paths)
+	if err != nil {
+		return err
+	}
+
+	if (params.Fail || params.FailDefined || params.FailNonEmpty) && (failCount > 0 || errorCount > 0) {
+		if params.Fail {
+			return fmt.Errorf("there were %d failures and %d errors counted in the results list, and --fail is set", failCount, errorCount)
+		}
+		if params.FailDefined {
+			return fmt.Errorf("there were %d failures and %d errors counted in the results list, and --fail-defined is set", failCount, errorCount)
+		}
+		return fmt.Errorf("there were %d failures and %d errors counted in the results list, and --fail-non-empty is set", failCount, errorCount)
+	}
+
+	if params.Watch {
+		return e.watch(ctx)
+	}
+
+	return nil
+}
+
+// executor executes OPA against input files, and reports the results in the
+// output format.
+type executor struct {
+	opa     *sdk.OPA
+	params  *Params
+	tmpl    *template.Template  // template of the output file paths, if any
+	outputs map[string]struct{} // output files written, which are never executed against
+}
+
+// exec executes OPA against the files under paths, and returns the number of
+// failures and errors counted by the fail options.
+func (e *executor) exec(ctx context.Context, paths []string) (int, int, error) {
+
+	params := e.params
 	now := time.Now()
-	r := &jsonReporter{w: params.Output, buf: make([]result, 0)}
+
+	var r reporter
+	if e.tmpl == nil {
+		r = newReporter(params, params.Output)
+	} else {
+		r = &fileReporter{e: e}
+	}
 
 	failCount := 0
 	errorCount := 0
 
-	for item := range listAllPaths(params.Paths) {
+	for item := range listAllPaths(paths) {
 
 		if item.Error != nil {
-			return item.Error
+			return 0, 0, item.Error
+		}
+
+		if _, ok := e.outputs[filepath.Clean(item.Path)]; ok {
+			continue
 		}
 
 		input, err := parse(item.Path)
 
 		if err != nil {
 			if err2 := r.Report(result{Path: item.Path, Error: err}); err2 != nil {
-				return err2
+				return 0, 0, err2
 			}
 			if params.FailDefined || params.Fail || params.FailNonEmpty {
 				errorCount++
@@ -98,14 +163,14 @@ func Exec(ctx context.Context, opa *sdk.OPA, params *Params) error {
 			continue
 		}
 
-		rs, err := opa.Decision(ctx, sdk.DecisionOptions{
+		rs, err := e.opa.Decision(ctx, sdk.DecisionOptions{
 			Path:  params.Decision,
 			Now:   now,
 			Input: input,
 		})
 		if err != nil {
 			if err2 := r.Report(result{Path: item.Path, Error: err}); err2 != nil {
-				return err2
+				return 0, 0, err2
 			}
 			if (params.FailDefined && !sdk.IsUndefinedErr(err)) || (params.Fail && sdk.IsUndefinedErr(err)) || (params.FailNonEmpty && !sdk.IsUndefinedErr(err)) {
 				errorCount++
@@ -114,7 +179,7 @@ func Exec(ctx context.Context, opa *sdk.OPA, params *Params) error {
 		}
 
 		if err := r.Report(result{Path: item.Path, Result: &rs.Result}); err != nil {
-			return err
+			return 0, 0, err
 		}
 
 		if (params.FailDefined && rs.Result != nil) || (params.Fail && rs.Result == nil) {
@@ -130,20 +195,55 @@ func Exec(ctx context.Context, opa *sdk.OPA, params *Params) error {
 		}
 	}
 	if err := r.Close(); err != nil {
+		return 0, 0, err
+	}
+
+	return failCount, errorCount, nil
+}
+
+// watch executes OPA against the files that are created or written under the
+// file paths, until ctx is done.
+func (e *executor) watch(ctx context.Context) error {
+	watcher, err := pathwatcher.CreatePathWatcher(e.params.Paths)
+	if err != nil {
 		return err
 	}
+	defer watcher.Close()
 
-	if (params.Fail || params.FailDefined || params.FailNonEmpty) && (failCount > 0 || errorCount > 0) {
-		if params.Fail {
-			return fmt.Errorf("there were %d failures and %d errors counted in the results list, and --fail is set", failCount, errorCount)
-		}
-		if params.FailDefined {
-			return fmt.Errorf("there were %d failures and %d errors counted in the results list, and --fail-defined is set", failCount, errorCount)
+	for {
+		select {
+		case evt := <-watcher.Events:
+			if evt.Op&(fsnotify.Create|fsnotify.Write) == 0 {
+				continue
+			}
+			if _, ok := e.outputs[filepath.Clean(evt.Name)]; ok {
+				continue
+			}
+			fi, err := os.Stat(evt.Name)
+			if err != nil {
+				// The file was removed since.
+				continue
+			}
+			if fi.IsDir() {
+				if evt.Op&fsnotify.Create != 0 {
+					if err := watcher.Add(evt.Name); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if _, ok := parsers[path.Ext(evt.Name)]; !ok {
+				continue
+			}
+			if _, _, err := e.exec(ctx, []string{evt.Name}); err != nil {
+				return err
+			}
+		case err := <-watcher.Errors:
+			return err
+		case <-ctx.Done():
+			return nil
 		}
-		return fmt.Errorf("there were %d failures and %d errors counted in the results list, and --fail-non-empty is set", failCount, errorCount)
 	}
-
-	return nil
 }
 
 type result struct {
@@ -152,6 +252,91 @@ type result struct {
 	Result *interface{} `json:"result,omitempty"`
 }
 
+type reporter interface {
+	Report(result) error
+	Close() error
+}
+
+func newReporter(params *Params, w io.Writer) reporter {
+	if params.OutputFormat.String() == "sarif" {
+		return newSARIFReporter(w, params.Decision)
+	}
+	return &jsonReporter{w: w, buf: make([]result, 0)}
+}
+
+// outputPath is the data the output template is executed with.
+type outputPath struct {
+	Path string // path of the input file
+	Rel  string // path of the input file relative to the path it was found under
+	Dir  string // directory of the input file
+	Base string // base name of the input file
+	Name string // base name of the input file without its extension
+	Ext  string // extension of the input file
+}
+
+// fileReporter writes the output for each input file to the file that the
+// output template names.
+type fileReporter struct {
+	e *executor
+}
+
+func (fr *fileReporter) Report(r result) error {
+	p := filepath.Clean(r.Path)
+	ext := filepath.Ext(p)
+
+	var buf strings.Builder
+	if err := fr.e.tmpl.Execute(&buf, outputPath{
+		Path: p,
+		Rel:  fr.e.rel(p),
+		Dir:  filepath.Dir(p),
+		Base: filepath.Base(p),
+		Name: strings.TrimSuffix(filepath.Base(p), ext),
+		Ext:  ext,
+	}); err != nil {
+		return fmt.Errorf("invalid output template: %w", err)
+	}
+	out := filepath.Clean(buf.String())
+	if out == p {
+		return fmt.Errorf("output file %v would overwrite the input file", out)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil {
+		return err
+	}
+	fr.e.outputs[out] = struct{}{}
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := newReporter(fr.e.params, f)
+	if err := w.Report(r); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (*fileReporter) Close() error {
+	return nil
+}
+
+// rel returns the path of the input file p relative to the file path it was
+// found under.
+func (e *executor) rel(p string) string {
+	for _, root := range e.params.Paths {
+		rel, err := filepath.Rel(filepath.Clean(root), p)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		return rel
+	}
+	return filepath.Base(p)
+}
+
 type jsonReporter struct {
 	w   io.Writer
 	buf []result
@@ -180,8 +365,8 @@ type fileListItem struct {
 func listAllPaths(roots []string) chan fileListItem {
 	ch := make(chan fileListItem)
 	go func() {
-		for _, path := range roots {
-			err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		for _, root := range roots {
+			err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 				if err != nil {
 					return err
 				}
@@ -192,7 +377,7 @@ func listAllPaths(roots []string) chan fileListItem {
 				return nil
 			})
 			if err != nil {
-				ch <- fileListItem{Path: path, Error: err}
+				ch <- fileListItem{Path: root, Error: err}
 			}
 		}
 		close(ch)
@@ -0,0 +1,102 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/open-policy-agent/opa/internal/sarif"
+	"github.com/open-policy-agent/opa/sdk"
+)
+
+// sarifReporter reports the results as a SARIF log.
+//
+// Each element of an array result, and any other defined result except false,
+// is reported as a SARIF result for the input file. The message of a SARIF
+// result is the element if it is a string, or its "msg" field if it is an
+// object. The "level" field of an object sets the level of the SARIF result,
+// which is "error" by default. Errors other than undefined decisions are
+// reported as tool execution notifications.
+type sarifReporter struct {
+	w      io.Writer
+	ruleID string
+	log    *sarif.Log
+}
+
+func newSARIFReporter(w io.Writer, decision string) *sarifReporter {
+	return &sarifReporter{
+		w:      w,
+		ruleID: strings.Trim(decision, "/"),
+		log:    sarif.New(),
+	}
+}
+
+func (sr *sarifReporter) Report(r result) error {
+	locations := []sarif.Location{sarif.NewLocation(r.Path, 0, 0)}
+
+	if r.Error != nil {
+		if sdk.IsUndefinedErr(r.Error) {
+			return nil
+		}
+		sr.log.AddNotification(&sarif.Notification{
+			Level:     sarif.LevelError,
+			Message:   sarif.Message{Text: r.Error.Error()},
+			Locations: locations,
+		})
+		return nil
+	}
+
+	if r.Result == nil {
+		return nil
+	}
+
+	var items []interface{}
+	switch x := (*r.Result).(type) {
+	case nil:
+	case bool:
+		if x {
+			items = append(items, x)
+		}
+	case []interface{}:
+		items = x
+	default:
+		items = append(items, x)
+	}
+
+	for _, item := range items {
+		res := &sarif.Result{
+			RuleID:    sr.ruleID,
+			Level:     sarif.LevelError,
+			Locations: locations,
+		}
+		switch x := item.(type) {
+		case string:
+			res.Message.Text = x
+		case map[string]interface{}:
+			if msg, ok := x["msg"].(string); ok {
+				res.Message.Text = msg
+			}
+			if level, ok := x["level"].(string); ok && sarif.IsLevel(level) {
+				res.Level = level
+			}
+		}
+		if res.Message.Text == "" {
+			bs, err := json.Marshal(item)
+			if err != nil {
+				return err
+			}
+			res.Message.Text = string(bs)
+		}
+		sr.log.AddResult(res)
+	}
+
+	return nil
+}
+
+func (sr *sarifReporter) Close() error {
+	return sr.log.Write(sr.w)
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/cmd/internal/env"
+	"github.com/open-policy-agent/opa/internal/lsp"
+)
+
+type lspCommandParams struct {
+	capabilities *capabilitiesFlag
+	v1Compatible bool
+}
+
+func init() {
+	params := lspCommandParams{
+		capabilities: newcapabilitiesFlag(),
+	}
+
+	lspCommand := &cobra.Command{
+		Use:   "lsp",
+		Short: "Run a language server for Rego",
+		Long: `Run a language server for Rego.
+
+The 'lsp' command starts a server implementing the Language Server Protocol
+(LSP). The server communicates with the editor over stdin and stdout, and
+provides:
+
+  - diagnostics for parse and compile errors, updated as documents are edited
+  - go-to-definition for rules, functions, imports, and variables
+  - hover information with the types of rules and refs, and the signatures
+    of built-in functions
+  - formatting of documents, like 'opa fmt'
+
+The Rego files found in the workspace folders are loaded when the editor
+initializes the server, so that references to rules in other files can be
+resolved. Hidden directories are skipped.
+
+Editors are configured to start 'opa lsp' as the language server for Rego files,
+for example with Neovim's built-in LSP client:
+
+    vim.lsp.start({ name = "opa", cmd = { "opa", "lsp" }, root_dir = vim.fn.getcwd() })
+`,
+		Args: cobra.NoArgs,
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			return env.CmdFlags.CheckEnvironmentVariables(cmd)
+		},
+		Run: func(*cobra.Command, []string) {
+			if err := dolsp(params); err != nil {
+				fmt.Fprintln(os.Stderr, "error:", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	addCapabilitiesFlag(lspCommand.Flags(), params.capabilities)
+	addV1CompatibleFlag(lspCommand.Flags(), &params.v1Compatible, false)
+
+	RootCommand.AddCommand(lspCommand)
+}
+
+func dolsp(params lspCommandParams) error {
+	opts := lsp.Options{
+		Capabilities: params.capabilities.C,
+		RegoVersion:  ast.RegoV0,
+	}
+	if params.v1Compatible {
+		opts.RegoVersion = ast.RegoV1
+	}
+	return lsp.New(os.Stdin, os.Stdout, opts).Run()
+}
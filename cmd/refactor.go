@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -32,21 +33,27 @@ func init() {
 	var moveCommandParams moveCommandParams
 
 	var refactorCommand = &cobra.Command{
-		Use:    "refactor",
-		Short:  "Refactor Rego file(s)",
-		Hidden: true,
+		Use:   "refactor",
+		Short: "Refactor Rego file(s)",
 	}
 
 	var moveCommand = &cobra.Command{
 		Use:   "move [file-path [...]]",
-		Short: "Rename packages and their references in Rego file(s)",
-		Long: `Rename packages and their references in Rego file(s).
+		Short: "Rename packages and rules, and their references in Rego file(s)",
+		Long: `Rename packages and rules, and their references in Rego file(s).
 
 The 'move' command takes one or more Rego source file(s) and rewrites package paths and other references in them as per
 the mapping defined by the '-p' option. At least one mapping should be provided and should be of the form:
 
 	<from>:<to>
 
+If <from> is the path of a rule, e.g. 'data.lib.foo.allow', the rule is moved to the package that is the longest prefix
+of <to>, and renamed to the remainder of <to>. The package must be defined by one of the file(s). The references of the
+moved rule to its package and imports are rewritten to full references, so that the rule keeps its meaning. Comments and
+annotations are moved with the rule.
+
+References are rewritten in all the file(s), including the references using imports. Comments are preserved.
+
 The 'move' command formats the Rego modules after renaming packages, etc. and prints the formatted modules to stdout by default.
 If the '-w' option is supplied, the 'move' command will overwrite the source file instead.
 
@@ -126,13 +133,19 @@ func doMove(params moveCommandParams, args []string, out io.Writer) error {
 		return err
 	}
 
-	for filename, mod := range movedModules.Result {
-		filename, err = fileurl.Clean(filename)
+	filenames := make([]string, 0, len(movedModules.Result))
+	for filename := range movedModules.Result {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	for _, name := range filenames {
+		filename, err := fileurl.Clean(name)
 		if err != nil {
 			return err
 		}
 
-		formatted, err := format.Ast(mod)
+		formatted, err := format.Ast(movedModules.Result[name])
 		if err != nil {
 			return newError("failed to parse Rego source file: %v", err)
 		}
@@ -143,12 +156,10 @@ func doMove(params moveCommandParams, args []string, out io.Writer) error {
 				return err
 			}
 
-			outfile, err := os.OpenFile(filename, os.O_WRONLY|os.O_TRUNC, info.Mode())
-			if err != nil {
-				return newError("failed to open file for writing: %v", err)
+			if err := os.WriteFile(filename, formatted, info.Mode()); err != nil {
+				return newError("failed to write file: %v", err)
 			}
-			defer outfile.Close()
-			out = outfile
+			continue
 		}
 
 		_, err = out.Write(formatted)
@@ -103,6 +103,75 @@ default allow = false
 	})
 }
 
+func TestDoMoveRuleWithComments(t *testing.T) {
+
+	files := map[string]string{
+		"lib.rego": `package lib.foo
+
+# allow is the decision
+allow {
+	input.admin # trailing
+}
+
+deny {
+	not allow
+}
+`,
+		"policy.rego": `package app
+
+import data.lib.foo
+
+p {
+	foo.allow
+}
+`,
+	}
+
+	test.WithTempFS(files, func(path string) {
+
+		params := moveCommandParams{
+			mapping:   newrepeatedStringFlag([]string{"data.lib.foo.allow:data.app.allowed"}),
+			overwrite: true,
+		}
+
+		if err := doMove(params, []string{path}, &bytes.Buffer{}); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := map[string]string{
+			"lib.rego": `package lib.foo
+
+deny {
+	not data.app.allowed
+}
+`,
+			"policy.rego": `package app
+
+import data.lib.foo
+
+p {
+	data.app.allowed
+}
+
+# allow is the decision
+allowed {
+	input.admin # trailing
+}
+`,
+		}
+
+		for name, exp := range expected {
+			data, err := os.ReadFile(filepath.Join(path, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != exp {
+				t.Fatalf("Expected %v:\n%v\n\nGot:\n%v\n", name, exp, string(data))
+			}
+		}
+	})
+}
+
 func TestParseSrcDstMap(t *testing.T) {
 	actual, err := parseSrcDstMap([]string{"data.lib.foo:data.baz.bar", "data:data.acme"})
 	if err != nil {
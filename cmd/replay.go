@@ -0,0 +1,524 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/cmd/internal/env"
+	"github.com/open-policy-agent/opa/internal/presentation"
+	"github.com/open-policy-agent/opa/logging"
+	"github.com/open-policy-agent/opa/plugins/bundle"
+	"github.com/open-policy-agent/opa/plugins/discovery"
+	"github.com/open-policy-agent/opa/plugins/logs"
+	"github.com/open-policy-agent/opa/sdk"
+	"github.com/open-policy-agent/opa/server/types"
+	"github.com/open-policy-agent/opa/topdown/builtins"
+	"github.com/open-policy-agent/opa/util"
+)
+
+const (
+	replayStatusMatch   = "match"
+	replayStatusDiff    = "diff"
+	replayStatusError   = "error"
+	replayStatusSkipped = "skipped"
+)
+
+type replayCommandParams struct {
+	configFile          string
+	configOverrides     []string
+	configOverrideFiles []string
+	bundlePaths         repeatedStringFlag
+	outputFormat        *util.EnumFlag
+	logLevel            *util.EnumFlag
+	logFormat           *util.EnumFlag
+	logTimestampFormat  string
+	timeout             time.Duration
+	v1Compatible        bool
+	logger              logging.Logger // overrides the logger of the plugins, if set
+}
+
+func newReplayCommandParams() replayCommandParams {
+	return replayCommandParams{
+		outputFormat: util.NewEnumFlag(evalPrettyOutput, []string{evalPrettyOutput, evalJSONOutput}),
+		logLevel:     util.NewEnumFlag("error", []string{"debug", "info", "error"}),
+		logFormat:    util.NewEnumFlag("json", []string{"text", "json", "json-pretty"}),
+	}
+}
+
+func init() {
+
+	params := newReplayCommandParams()
+
+	replayCommand := &cobra.Command{
+		Use:   "replay <decision-log-file> [<decision-log-file> [...]]",
+		Short: "Replay logged decisions",
+		Long: `Replay logged decisions and compare their results.
+
+The 'replay' command re-evaluates the decisions of decision log files locally,
+with the logged path, input, time and non-deterministic built-in cache (e.g.,
+the responses of http.send, if 'decision_logs.nd_builtin_cache' was enabled),
+and reports the decisions whose replayed result differs from the logged one.
+
+The files hold the events either as written to the console, one JSON object
+per line (other log entries are skipped), or as uploaded to a decision log
+service, JSON arrays optionally gzip-compressed.
+
+The policies and data are loaded from the bundles of the --config-file/-c or
+series of --set options, which behave the same way as for 'opa run', and from
+the --bundle/-b paths. The revisions of the loaded bundles are compared with
+the logged ones, so that differences caused by policy changes stand out: to
+reproduce decisions exactly, point the configuration at the logged revision of
+the bundles. Decision logging is disabled during the replay.
+
+Decisions of ad-hoc queries, and decisions whose input or result was masked or
+erased, are skipped.
+
+Example
+-------
+
+	$ opa replay -b bundle.tar.gz decisions.log
+	DIFF 4d9f3c57-5f6e-4a4c-9b9e-0d3f8d1c2a71 (authz/allow)
+	  bundle "authz" revision: logged "v41", replayed "v42"
+	  -true
+	  +false
+
+	12 decisions: 11 matched, 1 differed, 0 failed, 0 skipped
+
+The command exits with a non-zero status if any decision differs or fails to
+be replayed.`,
+		Args: cobra.MinimumNArgs(1),
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			return env.CmdFlags.CheckEnvironmentVariables(cmd)
+		},
+		Run: func(_ *cobra.Command, args []string) {
+			if err := runReplay(args, params, os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	addBundleFlag(replayCommand.Flags(), &params.bundlePaths)
+	addOutputFormat(replayCommand.Flags(), params.outputFormat)
+	addConfigFileFlag(replayCommand.Flags(), &params.configFile)
+	addConfigOverrides(replayCommand.Flags(), &params.configOverrides)
+	addConfigOverrideFiles(replayCommand.Flags(), &params.configOverrideFiles)
+	replayCommand.Flags().VarP(params.logLevel, "log-level", "l", "set log level")
+	replayCommand.Flags().Var(params.logFormat, "log-format", "set log format")
+	replayCommand.Flags().StringVar(&params.logTimestampFormat, "log-timestamp-format", "", "set log timestamp format (OPA_LOG_TIMESTAMP_FORMAT environment variable)")
+	replayCommand.Flags().DurationVar(&params.timeout, "timeout", 0, "set replay timeout with a Go-style duration, such as '5m 30s'. (default unlimited)")
+	addV1CompatibleFlag(replayCommand.Flags(), &params.v1Compatible, false)
+
+	RootCommand.AddCommand(replayCommand)
+}
+
+func runReplay(args []string, params replayCommandParams, w io.Writer) error {
+	ctx := context.Background()
+	if params.timeout != 0 {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, params.timeout)
+		defer cancel()
+	}
+
+	var events []replayEvent
+	for _, path := range args {
+		es, err := readReplayEvents(path)
+		if err != nil {
+			return err
+		}
+		events = append(events, es...)
+	}
+
+	opa, err := newReplayOPA(ctx, params)
+	if err != nil {
+		return err
+	}
+	defer opa.Stop(ctx)
+
+	results := make([]replayResult, len(events))
+	for i := range events {
+		results[i] = replayDecision(ctx, opa, &events[i])
+	}
+
+	return writeReplayResults(w, params.outputFormat.String(), results)
+}
+
+// newReplayOPA returns an OPA instance with the bundles of the configuration
+// activated, and decision logging disabled.
+func newReplayOPA(ctx context.Context, params replayCommandParams) (*sdk.OPA, error) {
+	stdLogger, _, err := setupLogging(params.logLevel.String(), params.logFormat.String(), params.logTimestampFormat)
+	if err != nil {
+		return nil, fmt.Errorf("config error: %w", err)
+	}
+
+	if params.logger != nil {
+		stdLogger = params.logger
+	}
+
+	bs, err := setupConfig(params.configFile, params.configOverrides, params.configOverrideFiles, params.bundlePaths.v)
+	if err != nil {
+		return nil, fmt.Errorf("config error: %w", err)
+	}
+
+	var config map[string]interface{}
+	if err := util.UnmarshalJSON(bs, &config); err != nil {
+		return nil, fmt.Errorf("config error: %w", err)
+	}
+	delete(config, "decision_logs")
+	delete(config, "status")
+
+	bs, err = json.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("config error: %w", err)
+	}
+
+	ready := make(chan struct{})
+
+	opa, err := sdk.New(ctx, sdk.Options{
+		Config:        bytes.NewReader(bs),
+		Logger:        stdLogger,
+		ConsoleLogger: logging.NewNoOpLogger(),
+		Ready:         ready,
+		V1Compatible:  params.v1Compatible,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("runtime error: %w", err)
+	}
+
+	if err := triggerPlugins(ctx, opa, []string{discovery.Name, bundle.Name}); err != nil {
+		opa.Stop(ctx)
+		return nil, fmt.Errorf("runtime error: %w", err)
+	}
+
+	select {
+	case <-ctx.Done():
+		opa.Stop(ctx)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("replay error: timed out before OPA was ready. This can happen when a remote bundle is malformed, or the timeout is set too low for normal OPA initialization")
+		}
+		return nil, ctx.Err()
+	case <-ready:
+	}
+
+	return opa, nil
+}
+
+// replayEvent is the part of a decision log event needed to replay the
+// decision. Unlike logs.EventV1, it can be decoded from the logged JSON.
+type replayEvent struct {
+	Type           string                       `json:"type,omitempty"`
+	DecisionID     string                       `json:"decision_id"`
+	Revision       string                       `json:"revision,omitempty"`
+	Bundles        map[string]logs.BundleInfoV1 `json:"bundles,omitempty"`
+	Path           string                       `json:"path,omitempty"`
+	Query          string                       `json:"query,omitempty"`
+	Input          *interface{}                 `json:"input,omitempty"`
+	Result         *interface{}                 `json:"result,omitempty"`
+	NDBuiltinCache builtins.NDBCache            `json:"nd_builtin_cache,omitempty"`
+	Erased         []string                     `json:"erased,omitempty"`
+	Masked         []string                     `json:"masked,omitempty"`
+	Error          json.RawMessage              `json:"error,omitempty"`
+	Timestamp      time.Time                    `json:"timestamp"`
+}
+
+func (e *replayEvent) failed() bool {
+	return len(e.Error) > 0 && !bytes.Equal(e.Error, []byte("null"))
+}
+
+// unreplayable returns why the decision of e cannot be replayed, if so.
+func (e *replayEvent) unreplayable() string {
+	if e.Path == "" {
+		if e.Query != "" {
+			return "decisions of ad-hoc queries cannot be replayed"
+		}
+		return "decision has no path"
+	}
+	for _, paths := range [][]string{e.Erased, e.Masked} {
+		for _, p := range paths {
+			if p == "/input" || strings.HasPrefix(p, "/input/") {
+				return "input was masked or erased"
+			}
+			if p == "/result" || strings.HasPrefix(p, "/result/") {
+				return "result was masked or erased"
+			}
+		}
+	}
+	return ""
+}
+
+func readReplayEvents(path string) ([]replayEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	events, err := decodeReplayEvents(f)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	return events, nil
+}
+
+// decodeReplayEvents reads the decision log events of r, either JSON objects
+// or JSON arrays of them, optionally gzip-compressed. Other log entries are
+// skipped.
+func decodeReplayEvents(r io.Reader) ([]replayEvent, error) {
+	br := bufio.NewReader(r)
+
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		br = bufio.NewReader(gr)
+	}
+
+	var events []replayEvent
+	decoder := util.NewJSONDecoder(br)
+
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid decision log: %w", err)
+		}
+
+		batch := []json.RawMessage{raw}
+		if bytes.HasPrefix(raw, []byte("[")) {
+			batch = nil
+			if err := util.UnmarshalJSON(raw, &batch); err != nil {
+				return nil, fmt.Errorf("invalid decision log: %w", err)
+			}
+		}
+
+		for _, x := range batch {
+			var e replayEvent
+			if err := util.UnmarshalJSON(x, &e); err != nil {
+				return nil, fmt.Errorf("invalid decision log event: %w", err)
+			}
+			if (e.Type != "" && e.Type != "openpolicyagent.org/decision_logs") || e.DecisionID == "" {
+				continue
+			}
+			events = append(events, e)
+		}
+	}
+
+	return events, nil
+}
+
+// replayResult is the outcome of the replay of a decision.
+type replayResult struct {
+	DecisionID       string                            `json:"decision_id"`
+	Path             string                            `json:"path,omitempty"`
+	Status           string                            `json:"status"`
+	Reason           string                            `json:"reason,omitempty"`
+	Logged           *interface{}                      `json:"logged,omitempty"`
+	Replayed         *interface{}                      `json:"replayed,omitempty"`
+	RevisionMismatch map[string]replayRevisionMismatch `json:"revision_mismatch,omitempty"`
+}
+
+type replayRevisionMismatch struct {
+	Logged   string `json:"logged"`
+	Replayed string `json:"replayed,omitempty"`
+}
+
+func replayDecision(ctx context.Context, opa *sdk.OPA, e *replayEvent) replayResult {
+	result := replayResult{
+		DecisionID: e.DecisionID,
+		Path:       e.Path,
+		Logged:     e.Result,
+	}
+
+	if reason := e.unreplayable(); reason != "" {
+		result.Status = replayStatusSkipped
+		result.Reason = reason
+		return result
+	}
+
+	opts := sdk.DecisionOptions{
+		Now:        e.Timestamp,
+		Path:       e.Path,
+		DecisionID: e.DecisionID,
+	}
+	if e.Input != nil {
+		opts.Input = *e.Input
+	}
+	if e.NDBuiltinCache != nil {
+		opts.NDBCache = e.NDBuiltinCache
+	}
+
+	decision, err := opa.Decision(ctx, opts)
+	if decision != nil {
+		result.RevisionMismatch = revisionMismatch(e, decision.Provenance.Bundles)
+	}
+
+	switch {
+	case sdk.IsUndefinedErr(err):
+		if e.Result == nil && !e.failed() {
+			result.Status = replayStatusMatch
+		} else {
+			result.Status = replayStatusDiff
+			result.Reason = "replayed decision is undefined"
+		}
+	case err != nil:
+		result.Status = replayStatusError
+		if e.failed() {
+			result.Status = replayStatusMatch
+		}
+		result.Reason = err.Error()
+	case e.failed():
+		result.Status = replayStatusDiff
+		result.Reason = "logged decision failed: " + string(e.Error)
+		result.Replayed = &decision.Result
+	case e.Result == nil:
+		result.Status = replayStatusDiff
+		result.Reason = "logged decision is undefined"
+		result.Replayed = &decision.Result
+	default:
+		result.Status = replayStatusDiff
+		result.Replayed = &decision.Result
+		if replayResultsEqual(*e.Result, decision.Result) {
+			result.Status = replayStatusMatch
+		}
+	}
+
+	return result
+}
+
+// revisionMismatch returns the logged bundles whose revision differs from
+// the loaded one. If exactly one bundle is loaded under another name, e.g.,
+// passed with --bundle, it stands in for the logged bundles not loaded.
+func revisionMismatch(e *replayEvent, loaded map[string]types.ProvenanceBundleV1) map[string]replayRevisionMismatch {
+	logged := e.Bundles
+	if len(logged) == 0 && e.Revision != "" {
+		logged = map[string]logs.BundleInfoV1{"": {Revision: e.Revision}}
+	}
+
+	var others []types.ProvenanceBundleV1
+	for name, l := range loaded {
+		if _, ok := logged[name]; !ok {
+			others = append(others, l)
+		}
+	}
+
+	result := map[string]replayRevisionMismatch{}
+
+	for name, b := range logged {
+		l, ok := loaded[name]
+		if !ok && len(others) == 1 {
+			l, ok = others[0], true
+		}
+		if !ok {
+			result[name] = replayRevisionMismatch{Logged: b.Revision}
+		} else if l.Revision != b.Revision {
+			result[name] = replayRevisionMismatch{Logged: b.Revision, Replayed: l.Revision}
+		}
+	}
+
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
+
+func replayResultsEqual(a, b interface{}) bool {
+	x, err := ast.InterfaceToValue(a)
+	if err != nil {
+		return false
+	}
+	y, err := ast.InterfaceToValue(b)
+	if err != nil {
+		return false
+	}
+	return x.Compare(y) == 0
+}
+
+func writeReplayResults(w io.Writer, format string, results []replayResult) error {
+	counts := map[string]int{}
+	for _, r := range results {
+		counts[r.Status]++
+	}
+
+	switch format {
+	case evalJSONOutput:
+		if err := presentation.JSON(w, map[string]interface{}{"result": results}); err != nil {
+			return err
+		}
+	default:
+		for _, r := range results {
+			if r.Status != replayStatusMatch {
+				writeReplayResultPretty(w, r)
+			}
+		}
+		fmt.Fprintf(w, "%d decisions: %d matched, %d differed, %d failed, %d skipped\n",
+			len(results), counts[replayStatusMatch], counts[replayStatusDiff], counts[replayStatusError], counts[replayStatusSkipped])
+	}
+
+	if n := counts[replayStatusDiff] + counts[replayStatusError]; n > 0 {
+		return fmt.Errorf("%d of %d decisions could not be reproduced", n, len(results))
+	}
+
+	return nil
+}
+
+func writeReplayResultPretty(w io.Writer, r replayResult) {
+	fmt.Fprintf(w, "%v %v (%v)\n", strings.ToUpper(r.Status), r.DecisionID, r.Path)
+
+	if r.Reason != "" {
+		fmt.Fprintf(w, "  %v\n", r.Reason)
+	}
+
+	names := make([]string, 0, len(r.RevisionMismatch))
+	for name := range r.RevisionMismatch {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		m := r.RevisionMismatch[name]
+		if m.Replayed == "" {
+			fmt.Fprintf(w, "  bundle %q revision: logged %q, not loaded\n", name, m.Logged)
+		} else {
+			fmt.Fprintf(w, "  bundle %q revision: logged %q, replayed %q\n", name, m.Logged, m.Replayed)
+		}
+	}
+
+	if r.Status == replayStatusDiff && r.Logged != nil && r.Replayed != nil {
+		diff := diffLines(replayIndent(*r.Logged), replayIndent(*r.Replayed))
+		for _, line := range strings.SplitAfter(diff, "\n") {
+			if line != "" {
+				fmt.Fprint(w, "  ", line)
+			}
+		}
+	}
+
+	fmt.Fprintln(w)
+}
+
+func replayIndent(x interface{}) string {
+	bs, err := json.MarshalIndent(x, "", "  ")
+	if err != nil {
+		return fmt.Sprintln(x)
+	}
+	return string(bs) + "\n"
+}
@@ -0,0 +1,132 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	loggingtest "github.com/open-policy-agent/opa/logging/test"
+	"github.com/open-policy-agent/opa/util"
+	"github.com/open-policy-agent/opa/util/test"
+)
+
+const replayTestPolicy = `package authz
+
+default allow := false
+
+allow if input.user == "alice"
+
+lucky := rand.intn("lucky", 100)
+`
+
+func replayTestParams(dir string) replayCommandParams {
+	params := newReplayCommandParams()
+	params.bundlePaths = repeatedStringFlag{v: []string{filepath.Join(dir, "bundle")}, isSet: true}
+	params.v1Compatible = true
+	params.logger = loggingtest.New()
+	return params
+}
+
+func TestReplay(t *testing.T) {
+	files := map[string]string{
+		"bundle/.manifest":   `{"revision": "v2", "roots": ["authz"]}`,
+		"bundle/policy.rego": replayTestPolicy,
+		"decisions.log": `{"level": "info", "msg": "Initializing server."}
+{"type": "openpolicyagent.org/decision_logs", "decision_id": "1", "bundles": {"authz": {"revision": "v2"}}, "path": "authz/allow", "input": {"user": "alice"}, "result": true, "timestamp": "2024-01-01T00:00:00Z"}
+{"type": "openpolicyagent.org/decision_logs", "decision_id": "2", "bundles": {"authz": {"revision": "v1"}}, "path": "authz/allow", "input": {"user": "bob"}, "result": true, "timestamp": "2024-01-01T00:00:00Z"}
+{"type": "openpolicyagent.org/decision_logs", "decision_id": "3", "path": "authz/lucky", "result": 7, "nd_builtin_cache": {"rand.intn": {"[\"lucky\",100]": 7}}, "timestamp": "2024-01-01T00:00:00Z"}
+{"type": "openpolicyagent.org/decision_logs", "decision_id": "4", "path": "authz/allow", "input": {"user": "alice"}, "erased": ["/input/user"], "result": true, "timestamp": "2024-01-01T00:00:00Z"}
+{"type": "openpolicyagent.org/decision_logs", "decision_id": "5", "query": "data.authz.allow", "result": [], "timestamp": "2024-01-01T00:00:00Z"}
+`,
+	}
+
+	test.WithTempFS(files, func(dir string) {
+		var buf bytes.Buffer
+		err := runReplay([]string{filepath.Join(dir, "decisions.log")}, replayTestParams(dir), &buf)
+		if err == nil || err.Error() != "1 of 5 decisions could not be reproduced" {
+			t.Fatalf("Expected error for differing decision but got %v", err)
+		}
+
+		exp := `DIFF 2 (authz/allow)
+  bundle "authz" revision: logged "v1", replayed "v2"
+  -true
+  +false
+
+SKIPPED 4 (authz/allow)
+  input was masked or erased
+
+SKIPPED 5 ()
+  decisions of ad-hoc queries cannot be replayed
+
+5 decisions: 2 matched, 1 differed, 0 failed, 2 skipped
+`
+		if buf.String() != exp {
+			t.Fatalf("Expected:\n%v\nGot:\n%v", exp, buf.String())
+		}
+	})
+}
+
+func TestReplayJSONUploadFormat(t *testing.T) {
+	files := map[string]string{
+		"bundle/.manifest":   `{"revision": "v2", "roots": ["authz"]}`,
+		"bundle/policy.rego": replayTestPolicy,
+	}
+
+	test.WithTempFS(files, func(dir string) {
+		// Decision log uploads are gzip-compressed JSON arrays.
+		var gz bytes.Buffer
+		w := gzip.NewWriter(&gz)
+		_, _ = w.Write([]byte(`[{"decision_id": "1", "bundles": {"authz": {"revision": "v2"}}, "path": "authz/allow", "input": {"user": "alice"}, "result": true, "timestamp": "2024-01-01T00:00:00Z"},
+			{"decision_id": "2", "path": "authz/missing", "timestamp": "2024-01-01T00:00:00Z"}]`))
+		_ = w.Close()
+
+		path := filepath.Join(dir, "upload.gz")
+		if err := os.WriteFile(path, gz.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		params := replayTestParams(dir)
+		_ = params.outputFormat.Set(evalJSONOutput)
+
+		var buf bytes.Buffer
+		if err := runReplay([]string{path}, params, &buf); err != nil {
+			t.Fatal(err)
+		}
+
+		var output struct {
+			Result []replayResult `json:"result"`
+		}
+		if err := util.UnmarshalJSON(buf.Bytes(), &output); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(output.Result) != 2 {
+			t.Fatalf("Expected 2 results but got %v", buf.String())
+		}
+		for _, r := range output.Result {
+			if r.Status != replayStatusMatch {
+				t.Fatalf("Expected all decisions to match but got %v", buf.String())
+			}
+		}
+	})
+}
+
+func TestReplayInvalidLog(t *testing.T) {
+	files := map[string]string{
+		"decisions.log": `level=info msg="Initializing server."`,
+	}
+
+	test.WithTempFS(files, func(dir string) {
+		err := runReplay([]string{filepath.Join(dir, "decisions.log")}, newReplayCommandParams(), &bytes.Buffer{})
+		if err == nil || !strings.Contains(err.Error(), "invalid decision log") {
+			t.Fatalf("Expected invalid decision log error but got %v", err)
+		}
+	})
+}
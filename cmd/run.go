@@ -16,6 +16,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/open-policy-agent/opa/cmd/internal/env"
+	"github.com/open-policy-agent/opa/internal/compilecache"
 	"github.com/open-policy-agent/opa/runtime"
 	"github.com/open-policy-agent/opa/server"
 	"github.com/open-policy-agent/opa/util"
@@ -51,12 +52,13 @@ type runCmdParams struct {
 	skipKnownSchemaCheck bool
 	excludeVerifyFiles   []string
 	cipherSuites         []string
+	cacheDir             string
 }
 
 func newRunParams() runCmdParams {
 	return runCmdParams{
 		rt:             runtime.NewParams(),
-		authentication: util.NewEnumFlag("off", []string{"token", "tls", "off"}),
+		authentication: util.NewEnumFlag("off", []string{"token", "tls", "jwt", "off"}),
 		authorization:  util.NewEnumFlag("off", []string{"basic", "off"}),
 		minTLSVersion:  util.NewEnumFlag("1.2", []string{"1.0", "1.1", "1.2", "1.3"}),
 		logLevel:       util.NewEnumFlag("info", []string{"debug", "info", "error"}),
@@ -98,9 +100,17 @@ files.
 When loading from directories, only files with known extensions are considered.
 The current set of file extensions that OPA will consider are:
 
-    .json          # JSON data
-    .yaml or .yml  # YAML data
-    .rego          # Rego file
+    .json              # JSON data
+    .jsonl or .ndjson  # JSON Lines data, loaded as an array of the values on each line
+    .yaml or .yml      # YAML data, loaded as an array of the documents if there are several
+    .rego              # Rego file
+
+Non-bundle paths can be glob patterns, where '**' matches zero or more
+directories. Paths prefixed with '!' exclude the files and directories they
+match from all other paths, bundles included. Quote them to prevent shell
+expansion:
+
+    $ opa run 'policies/**/*.rego' '!**/*_test.rego'
 
 Non-bundle data file and directory paths can be prefixed with the desired
 destination in the data document with the following syntax:
@@ -180,6 +190,11 @@ OPA will automatically perform type checking based on a schema inferred from kno
 resulting from the schema check. Currently this check is performed on OPA's Authorization Policy Input document and will
 be expanded in the future. To disable this, use the --skip-known-schema-check flag.
 
+The --cache-dir flag enables caching of the modules parsed when loading the files
+on startup in the given directory. Modules are cached individually, keyed by a hash
+of their name, their contents and the parser options, so that restarts only parse
+added or modified modules again.
+
 The --v1-compatible flag can be used to opt-in to OPA features and behaviors that will be enabled by default in a future OPA v1.0 release.
 Current behaviors enabled by this flag include:
 - setting OPA's listening address to "localhost:8181" by default.
@@ -214,15 +229,18 @@ See https://godoc.org/crypto/tls#pkg-constants for more information.
 	runCommand.Flags().BoolVarP(&cmdParams.serverMode, "server", "s", false, "start the runtime in server mode")
 	runCommand.Flags().IntVar(&cmdParams.rt.ReadyTimeout, "ready-timeout", 0, "wait (in seconds) for configured plugins before starting server (value <= 0 disables ready check)")
 	runCommand.Flags().StringVarP(&cmdParams.rt.HistoryPath, "history", "H", historyPath(), "set path of history file")
-	cmdParams.rt.Addrs = runCommand.Flags().StringSliceP("addr", "a", []string{defaultAddr}, "set listening address of the server (e.g., [ip]:<port> for TCP, unix://<path> for UNIX domain socket)")
-	cmdParams.rt.DiagnosticAddrs = runCommand.Flags().StringSlice("diagnostic-addr", []string{}, "set read-only diagnostic listening address of the server for /health and /metric APIs (e.g., [ip]:<port> for TCP, unix://<path> for UNIX domain socket)")
+	cmdParams.rt.Addrs = runCommand.Flags().StringSliceP("addr", "a", []string{defaultAddr}, "set listening address of the server (e.g., [ip]:<port> for TCP, unix://<path> for UNIX domain socket, fd://<name> for systemd socket activation)")
+	cmdParams.rt.DiagnosticAddrs = runCommand.Flags().StringSlice("diagnostic-addr", []string{}, "set read-only diagnostic listening address of the server for /health and /metric APIs (e.g., [ip]:<port> for TCP, unix://<path> for UNIX domain socket, fd://<name> for systemd socket activation)")
+	cmdParams.rt.GRPCAddrs = runCommand.Flags().StringSlice("grpc-addr", []string{}, "set listening address of the server for the gRPC API (e.g., [ip]:<port> for TCP, unix://<path> for UNIX domain socket, fd://<name> for systemd socket activation)")
 	cmdParams.rt.UnixSocketPerm = runCommand.Flags().String("unix-socket-perm", "755", "specify the permissions for the Unix domain socket if used to listen for incoming connections")
 	runCommand.Flags().BoolVar(&cmdParams.rt.H2CEnabled, "h2c", false, "enable H2C for HTTP listeners")
+	runCommand.Flags().BoolVar(&cmdParams.rt.InputSchemaValidation, "validate-input-schema", false, "reject v1 data API requests whose input does not match the input schema annotated on the policy")
 	runCommand.Flags().StringVarP(&cmdParams.rt.OutputFormat, "format", "f", "pretty", "set shell output format, i.e, pretty, json")
 	runCommand.Flags().BoolVarP(&cmdParams.rt.Watch, "watch", "w", false, "watch command line files for changes")
 	addV1CompatibleFlag(runCommand.Flags(), &cmdParams.rt.V1Compatible, false)
 	addMaxErrorsFlag(runCommand.Flags(), &cmdParams.rt.ErrorLimit)
 	runCommand.Flags().BoolVar(&cmdParams.rt.PprofEnabled, "pprof", false, "enables pprof endpoints")
+	runCommand.Flags().BoolVar(&cmdParams.rt.BenchEnabled, "bench-endpoint", false, "enables the /debug/bench endpoint for benchmarking decisions")
 	runCommand.Flags().StringVar(&cmdParams.tlsCertFile, "tls-cert-file", "", "set path of TLS certificate file")
 	runCommand.Flags().StringVar(&cmdParams.tlsPrivateKeyFile, "tls-private-key-file", "", "set path of TLS private key file")
 	runCommand.Flags().StringVar(&cmdParams.tlsCACertFile, "tls-ca-cert-file", "", "set path of TLS CA cert file")
@@ -240,6 +258,7 @@ See https://godoc.org/crypto/tls#pkg-constants for more information.
 	addConfigOverrides(runCommand.Flags(), &cmdParams.rt.ConfigOverrides)
 	addConfigOverrideFiles(runCommand.Flags(), &cmdParams.rt.ConfigOverrideFiles)
 	addBundleModeFlag(runCommand.Flags(), &cmdParams.rt.BundleMode, false)
+	addCacheDirFlag(runCommand.Flags(), &cmdParams.cacheDir)
 
 	runCommand.Flags().BoolVar(&cmdParams.skipVersionCheck, "skip-version-check", false, "disables anonymous version reporting (see: https://www.openpolicyagent.org/docs/latest/privacy)")
 	err := runCommand.Flags().MarkDeprecated("skip-version-check", "\"skip-version-check\" is deprecated. Use \"disable-telemetry\" instead")
@@ -275,6 +294,7 @@ func initRuntime(ctx context.Context, params runCmdParams, args []string, addrSe
 	authenticationSchemes := map[string]server.AuthenticationScheme{
 		"token": server.AuthenticationToken,
 		"tls":   server.AuthenticationTLS,
+		"jwt":   server.AuthenticationJWT,
 		"off":   server.AuthenticationOff,
 	}
 
@@ -327,6 +347,10 @@ func initRuntime(ctx context.Context, params runCmdParams, args []string, addrSe
 		Ignore: params.ignore,
 	}.Apply
 
+	if params.cacheDir != "" {
+		params.rt.ModuleCache = compilecache.New(params.cacheDir)
+	}
+
 	params.rt.EnableVersionCheck = !params.disableTelemetry
 
 	// For backwards compatibility, check if `--skip-version-check` flag set.
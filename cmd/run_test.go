@@ -236,6 +236,38 @@ func TestInitRuntimeSkipKnownSchemaCheck(t *testing.T) {
 	})
 }
 
+func TestInitRuntimeCacheDir(t *testing.T) {
+	fs := map[string]string{
+		"test/policy.rego": `package test
+
+		p := 1`,
+	}
+
+	test.WithTempFS(fs, func(rootDir string) {
+		params := newTestRunParams()
+		params.cacheDir = filepath.Join(rootDir, ".cache")
+
+		for i := 0; i < 2; i++ {
+			rt, err := initRuntime(context.Background(), params, []string{filepath.Join(rootDir, "test")}, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if mods := rt.Manager.GetCompiler().Modules; len(mods) != 1 {
+				t.Fatalf("expected one module, got %v", mods)
+			}
+
+			entries, err := filepath.Glob(filepath.Join(params.cacheDir, "*", "*", "*"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Fatalf("expected one cache entry, got %v", entries)
+			}
+		}
+	})
+}
+
 func TestRunServerCheckLogTimestampFormat(t *testing.T) {
 	for _, format := range []string{time.Kitchen, time.RFC3339Nano} {
 		t.Run(format, func(t *testing.T) {
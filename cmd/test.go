@@ -5,11 +5,14 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"os/signal"
+	"sort"
 	"strings"
 	"syscall"
 	"time"
@@ -25,6 +28,7 @@ import (
 	"github.com/open-policy-agent/opa/cmd/internal/env"
 	"github.com/open-policy-agent/opa/compile"
 	"github.com/open-policy-agent/opa/cover"
+	"github.com/open-policy-agent/opa/internal/compilecache"
 	"github.com/open-policy-agent/opa/internal/runtime"
 	"github.com/open-policy-agent/opa/storage"
 	"github.com/open-policy-agent/opa/storage/inmem"
@@ -37,6 +41,7 @@ import (
 const (
 	testPrettyOutput = "pretty"
 	testJSONOutput   = "json"
+	testSARIFOutput  = "sarif"
 )
 
 type testCommandParams struct {
@@ -58,15 +63,18 @@ type testCommandParams struct {
 	capabilities *capabilitiesFlag
 	schema       *schemaFlags
 	watch        bool
+	parallel     int
+	mutate       bool
 	stopChan     chan os.Signal
 	output       io.Writer
 	errOutput    io.Writer
 	v1Compatible bool
+	cacheDir     string
 }
 
 func newTestCommandParams() testCommandParams {
 	return testCommandParams{
-		outputFormat: util.NewEnumFlag(testPrettyOutput, []string{testPrettyOutput, testJSONOutput, benchmarkGoBenchOutput}),
+		outputFormat: util.NewEnumFlag(testPrettyOutput, []string{testPrettyOutput, testJSONOutput, testSARIFOutput, benchmarkGoBenchOutput}),
 		explain:      newExplainFlag([]string{explainModeFails, explainModeFull, explainModeNotes, explainModeDebug}),
 		target:       util.NewEnumFlag(compile.TargetRego, []string{compile.TargetRego, compile.TargetWasm}),
 		capabilities: newcapabilitiesFlag(),
@@ -74,6 +82,7 @@ func newTestCommandParams() testCommandParams {
 		output:       os.Stdout,
 		errOutput:    os.Stderr,
 		stopChan:     make(chan os.Signal, 1),
+		parallel:     1,
 	}
 }
 
@@ -101,6 +110,27 @@ func opaTest(args []string, testParams testCommandParams) (int, error) {
 		return 1, err
 	}
 
+	if testParams.parallel < 1 {
+		err = fmt.Errorf("parallelism must be at least 1")
+		fmt.Fprintln(testParams.errOutput, err)
+		return 1, err
+	}
+
+	if testParams.mutate {
+		for flag, set := range map[string]bool{
+			"--bench":    testParams.benchmark,
+			"--bundle":   testParams.bundleMode,
+			"--coverage": testParams.coverage || testParams.threshold > 0,
+			"--watch":    testParams.watch,
+		} {
+			if set {
+				err = fmt.Errorf("cannot use --mutate with %v", flag)
+				fmt.Fprintln(testParams.errOutput, err)
+				return 1, err
+			}
+		}
+	}
+
 	filter := loaderFilter{
 		Ignore: testParams.ignore,
 	}
@@ -109,11 +139,16 @@ func opaTest(args []string, testParams testCommandParams) (int, error) {
 	var bundles map[string]*bundle.Bundle
 	var store storage.Store
 
+	var cache ast.ModuleCache
+	if testParams.cacheDir != "" {
+		cache = compilecache.New(testParams.cacheDir)
+	}
+
 	if testParams.bundleMode {
-		bundles, err = tester.LoadBundlesWithRegoVersion(args, filter.Apply, testParams.RegoVersion())
+		bundles, err = tester.LoadBundlesWithModuleCache(args, filter.Apply, testParams.RegoVersion(), cache)
 		store = inmem.NewWithOpts(inmem.OptRoundTripOnWrite(false))
 	} else {
-		modules, store, err = tester.LoadWithRegoVersion(args, filter.Apply, testParams.RegoVersion())
+		modules, store, err = tester.LoadWithModuleCache(args, filter.Apply, testParams.RegoVersion(), cache)
 	}
 
 	if err != nil {
@@ -121,6 +156,24 @@ func opaTest(args []string, testParams testCommandParams) (int, error) {
 		return 1, err
 	}
 
+	var state *watchState
+	if testParams.watch && !testParams.bundleMode {
+		state, err = newWatchState(ctx, store)
+		if err != nil {
+			fmt.Fprintln(testParams.errOutput, err)
+			return 1, err
+		}
+	}
+
+	// Compilation modifies the modules, so mutants are generated from copies.
+	var pristine map[string]*ast.Module
+	if testParams.mutate {
+		pristine = make(map[string]*ast.Module, len(modules))
+		for id, module := range modules {
+			pristine[id] = module.Copy()
+		}
+	}
+
 	txn, err := store.NewTransaction(ctx, storage.WriteParams)
 	if err != nil {
 		fmt.Fprintln(testParams.errOutput, err)
@@ -151,12 +204,16 @@ func opaTest(args []string, testParams testCommandParams) (int, error) {
 		store.Abort(ctx, txn)
 	}
 
+	if testParams.mutate {
+		return runMutationAnalysis(ctx, testParams, store, pristine)
+	}
+
 	if !testParams.watch {
 		return 0, nil
 	}
 
 	done := make(chan struct{})
-	go startWatcher(ctx, testParams, args, inmem.NewWithOpts(inmem.OptRoundTripOnWrite(false)), done)
+	go startWatcher(ctx, testParams, args, inmem.NewWithOpts(inmem.OptRoundTripOnWrite(false)), state, done)
 
 	signal.Notify(testParams.stopChan, syscall.SIGINT, syscall.SIGTERM)
 
@@ -211,6 +268,108 @@ func runTests(ctx context.Context, txn storage.Transaction, runner *tester.Runne
 	return exitCode, err
 }
 
+type mutantResult struct {
+	*tester.Mutant
+	Status   string `json:"status"`
+	KilledBy string `json:"killed_by,omitempty"`
+}
+
+type mutationReport struct {
+	Mutants  []mutantResult `json:"mutants"`
+	Killed   int            `json:"killed"`
+	Survived int            `json:"survived"`
+	Invalid  int            `json:"invalid"`
+	Score    float64        `json:"score"`
+}
+
+const (
+	mutantKilled   = "killed"
+	mutantSurvived = "survived"
+	mutantInvalid  = "invalid"
+)
+
+// runMutationAnalysis runs the tests against every mutant of the policy modules
+// and reports the mutants that are not killed by any test. Mutants that fail to
+// compile are reported as invalid and do not count towards the score.
+func runMutationAnalysis(ctx context.Context, testParams testCommandParams, store storage.Store, modules map[string]*ast.Module) (int, error) {
+	testParams.verbose = false
+
+	report := mutationReport{
+		Mutants: []mutantResult{},
+	}
+
+	for _, mutant := range tester.Mutants(modules) {
+		result := mutantResult{Mutant: mutant, Status: mutantSurvived}
+
+		err := storage.Txn(ctx, store, storage.TransactionParams{}, func(txn storage.Transaction) error {
+			runner, _, err := compileAndSetupTests(ctx, testParams, store, txn, mutant.Apply(modules), nil)
+			if err != nil {
+				return err
+			}
+
+			runCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			ch, err := runner.SetChangedModules([]string{mutant.File}).RunTests(runCtx, txn)
+			if err != nil {
+				result.Status = mutantInvalid
+				return nil
+			}
+
+			for tr := range ch {
+				if result.Status == mutantSurvived && !tr.Pass() && !tr.Skip {
+					result.Status = mutantKilled
+					result.KilledBy = tr.Package + "." + tr.Name
+					cancel()
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintln(testParams.errOutput, err)
+			return 1, err
+		}
+
+		switch result.Status {
+		case mutantKilled:
+			report.Killed++
+		case mutantSurvived:
+			report.Survived++
+		case mutantInvalid:
+			report.Invalid++
+		}
+		report.Mutants = append(report.Mutants, result)
+	}
+
+	if n := report.Killed + report.Survived; n > 0 {
+		report.Score = float64(report.Killed) / float64(n) * 100
+	}
+
+	if testParams.outputFormat.String() == testJSONOutput {
+		bs, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return 1, err
+		}
+		fmt.Fprintln(testParams.output, string(bs))
+		return 0, nil
+	}
+
+	dashes := strings.Repeat("-", 80)
+	fmt.Fprintln(testParams.output)
+	fmt.Fprintln(testParams.output, "SURVIVING MUTANTS")
+	fmt.Fprintln(testParams.output, dashes)
+	for _, result := range report.Mutants {
+		if result.Status == mutantSurvived {
+			fmt.Fprintln(testParams.output, result.Mutant)
+		}
+	}
+	fmt.Fprintln(testParams.output, dashes)
+	fmt.Fprintf(testParams.output, "MUTATION SCORE: %.2f%% (killed: %d, survived: %d, invalid: %d)\n",
+		report.Score, report.Killed, report.Survived, report.Invalid)
+
+	return 0, nil
+}
+
 func filterTrace(params *testCommandParams, trace []*topdown.Event) []*topdown.Event {
 	// If an explain mode was specified, filter based
 	// on the mode. If no explain mode was specified,
@@ -241,16 +400,16 @@ func isThresholdValid(t float64) bool {
 	return 0 <= t && t <= 100
 }
 
-func startWatcher(ctx context.Context, testParams testCommandParams, paths []string, store storage.Store, done chan struct{}) {
+func startWatcher(ctx context.Context, testParams testCommandParams, paths []string, store storage.Store, state *watchState, done chan struct{}) {
 	watcher, err := pathwatcher.CreatePathWatcher(paths)
 	if err != nil {
 		fmt.Fprintln(testParams.errOutput, "Error creating path watcher: ", err)
 		os.Exit(1)
 	}
-	readWatcher(ctx, testParams, watcher, paths, store, done)
+	readWatcher(ctx, testParams, watcher, paths, store, state, done)
 }
 
-func readWatcher(ctx context.Context, testParams testCommandParams, watcher *fsnotify.Watcher, paths []string, store storage.Store, done chan struct{}) {
+func readWatcher(ctx context.Context, testParams testCommandParams, watcher *fsnotify.Watcher, paths []string, store storage.Store, state *watchState, done chan struct{}) {
 	for {
 
 		fmt.Fprintln(testParams.output, strings.Repeat("*", 80))
@@ -264,7 +423,7 @@ func readWatcher(ctx context.Context, testParams testCommandParams, watcher *fsn
 				if (evt.Op & removalMask) != 0 {
 					removed = evt.Name
 				}
-				processWatcherUpdate(ctx, testParams, paths, removed, store)
+				processWatcherUpdate(ctx, testParams, paths, removed, store, state)
 			}
 		case <-done:
 			watcher.Close()
@@ -273,7 +432,73 @@ func readWatcher(ctx context.Context, testParams testCommandParams, watcher *fsn
 	}
 }
 
-func processWatcherUpdate(ctx context.Context, testParams testCommandParams, paths []string, removed string, store storage.Store) {
+// watchState records the modules and data used by the previous run in watch
+// mode so that subsequent runs only execute the tests affected by a change.
+type watchState struct {
+	modules   map[string][]byte
+	documents interface{}
+}
+
+func newWatchState(ctx context.Context, store storage.Store) (*watchState, error) {
+	state := &watchState{
+		modules: map[string][]byte{},
+	}
+
+	err := storage.Txn(ctx, store, storage.TransactionParams{}, func(txn storage.Transaction) error {
+		ids, err := store.ListPolicies(ctx, txn)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			bs, err := store.GetPolicy(ctx, txn, id)
+			if err != nil {
+				return err
+			}
+			// Modules reloaded by the watcher are identified by their
+			// cleaned paths.
+			state.modules[loader.CleanPath(id)] = bs
+		}
+		state.documents, err = store.Read(ctx, txn, storage.Path{})
+		return err
+	})
+
+	return state, err
+}
+
+// diff returns the names of the modules that were added or modified in loaded
+// since the last recorded run. If all tests have to be run again, e.g., because
+// data changed or a module was removed, all is true.
+func (s *watchState) diff(loaded *initload.LoadPathsResult) (changed []string, all bool) {
+	for id, module := range loaded.Files.Modules {
+		if prev, ok := s.modules[id]; !ok || !bytes.Equal(prev, module.Raw) {
+			changed = append(changed, id)
+		}
+	}
+
+	for id := range s.modules {
+		if _, ok := loaded.Files.Modules[id]; !ok {
+			all = true
+		}
+	}
+
+	if util.Compare(s.documents, loaded.Files.Documents) != 0 {
+		all = true
+	}
+
+	sort.Strings(changed)
+	return changed, all
+}
+
+// record makes loaded the baseline that later loads are compared against.
+func (s *watchState) record(loaded *initload.LoadPathsResult) {
+	s.modules = make(map[string][]byte, len(loaded.Files.Modules))
+	for id, module := range loaded.Files.Modules {
+		s.modules[id] = module.Raw
+	}
+	s.documents = loaded.Files.Documents
+}
+
+func processWatcherUpdate(ctx context.Context, testParams testCommandParams, paths []string, removed string, store storage.Store, state *watchState) {
 	filter := loaderFilter{
 		Ignore: testParams.ignore,
 	}
@@ -298,6 +523,20 @@ func processWatcherUpdate(ctx context.Context, testParams testCommandParams, pat
 		return
 	}
 
+	// Only re-run the tests affected by the modules that changed. Coverage
+	// reports always need the results of all tests.
+	var changed []string
+	if state != nil {
+		var all bool
+		changed, all = state.diff(loadResult)
+		if all || testParams.coverage || testParams.threshold > 0 {
+			changed = nil
+		} else if len(changed) == 0 {
+			fmt.Fprintln(testParams.output, "No changes to modules or data.")
+			return
+		}
+	}
+
 	modules := map[string]*ast.Module{}
 	for id, module := range loadResult.Files.Modules {
 		modules[id] = module.Parsed
@@ -308,13 +547,23 @@ func processWatcherUpdate(ctx context.Context, testParams testCommandParams, pat
 		if err != nil {
 			return err
 		}
+		runner.SetChangedModules(changed)
 
 		for i := 0; i < testParams.count; i++ {
 			exitCode, err := runTests(ctx, txn, runner, reporter, testParams)
 			if exitCode != 0 {
+				// Test failures still leave a valid baseline, whereas errors
+				// (e.g., compilation errors) require the next run to consider
+				// everything that changed since the last good one.
+				if exitCode != 1 && state != nil {
+					state.record(loadResult)
+				}
 				return err
 			}
 		}
+		if state != nil {
+			state.record(loadResult)
+		}
 		return nil
 	})
 
@@ -385,12 +634,14 @@ func compileAndSetupTests(ctx context.Context, testParams testCommandParams, sto
 		SetStore(store).
 		CapturePrintOutput(true).
 		EnableTracing(testParams.verbose).
+		EnableFailureLine(testParams.outputFormat.String() == testSARIFOutput).
 		SetCoverageQueryTracer(coverTracer).
 		SetRuntime(info).
 		SetModules(modules).
 		SetBundles(bundles).
 		SetTimeout(timeout).
 		Filter(testParams.runRegex).
+		WithParallelism(testParams.parallel).
 		Target(testParams.target.String())
 
 	var reporter tester.Reporter
@@ -403,6 +654,10 @@ func compileAndSetupTests(ctx context.Context, testParams testCommandParams, sto
 			reporter = tester.JSONReporter{
 				Output: testParams.output,
 			}
+		case testSARIFOutput:
+			reporter = tester.SARIFReporter{
+				Output: testParams.output,
+			}
 		case benchmarkGoBenchOutput:
 			goBench = true
 			fallthrough
@@ -444,6 +699,11 @@ and loaded following standard bundle conventions. The path can be a compressed a
 file or a directory which will be treated as a bundle. Without the '--bundle' flag OPA
 will recursively load ALL *.rego, *.json, and *.yaml files for evaluating the test cases.
 
+Paths can also be glob patterns, where '**' matches zero or more directories,
+and paths prefixed with '!' exclude the files and directories they match:
+
+    $ opa test 'policies/**/*.rego' '!policies/vendor'
+
 Test cases under development may be prefixed "todo_" in order to skip their execution,
 while still getting marked as skipped in the test results.
 
@@ -503,9 +763,36 @@ Example benchmark run:
 
 The optional "gobench" output format conforms to the Go Benchmark Data Format.
 
+The optional "sarif" output format reports the failed tests, located at the
+expression that failed, and the tests that encountered errors as a SARIF log,
+so that code scanning tools can annotate the policies with them.
+
+The --mutate flag enables mutation analysis. After the tests pass, OPA repeatedly
+modifies the policies (negating expressions, swapping comparison operators, and
+removing expressions) and re-runs the affected tests against each of these mutants.
+Mutants that no test fails on ("survivors") point to behaviour of the policies that
+is not verified by the tests. Mutants that fail to compile are reported as invalid
+and do not count towards the mutation score:
+
+	$ opa test --mutate ./example/
+
+The --parallel flag sets the number of test cases that are executed concurrently. Results
+are reported in the same order as when the tests are run sequentially:
+
+	$ opa test --parallel 8 ./example/
+
+The --cache-dir flag enables caching of parsed modules in the given directory.
+Modules are cached individually, keyed by a hash of their name, their contents
+and the parser options, so that only added or modified modules are parsed again.
+The policies are always compiled, and the tests are always run:
+
+	$ opa test --cache-dir .opa-cache ./example/
+
 The --watch flag can be used to monitor policy and data file-system changes. When a change is detected, OPA reloads
-the policy and data and then re-runs the tests. Watching individual files (rather than directories) is generally not
-recommended as some updates might cause them to be dropped by OPA.
+the policy and data and then re-runs the tests. Only the tests affected by the change are re-run: tests defined in
+modules that were added or modified, and tests that depend on rules defined in them. All tests are re-run when data
+changes, when a module is removed, when coverage is reported, or when --bundle is set. Watching individual files
+(rather than directories) is generally not recommended as some updates might cause them to be dropped by OPA.
 `,
 		PreRunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) == 0 {
@@ -536,6 +823,8 @@ recommended as some updates might cause them to be dropped by OPA.
 	testCommand.Flags().BoolVar(&testParams.benchmark, "bench", false, "benchmark the unit tests")
 	testCommand.Flags().StringVarP(&testParams.runRegex, "run", "r", "", "run only test cases matching the regular expression.")
 	testCommand.Flags().BoolVarP(&testParams.watch, "watch", "w", false, "watch command line files for changes")
+	testCommand.Flags().BoolVar(&testParams.mutate, "mutate", false, "run the tests against mutated policies and report mutants that are not detected by any test")
+	testCommand.Flags().IntVar(&testParams.parallel, "parallel", 1, "number of test cases to execute concurrently (benchmarks are always run sequentially)")
 
 	// Shared flags
 	addBundleModeFlag(testCommand.Flags(), &testParams.bundleMode, false)
@@ -548,6 +837,7 @@ recommended as some updates might cause them to be dropped by OPA.
 	addCapabilitiesFlag(testCommand.Flags(), testParams.capabilities)
 	addSchemaFlags(testCommand.Flags(), testParams.schema)
 	addV1CompatibleFlag(testCommand.Flags(), &testParams.v1Compatible, false)
+	addCacheDirFlag(testCommand.Flags(), &testParams.cacheDir)
 
 	RootCommand.AddCommand(testCommand)
 }
@@ -3,6 +3,7 @@ package cmd
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -244,6 +245,57 @@ func TestIgnoreFlag(t *testing.T) {
 	}
 }
 
+func TestCacheDir(t *testing.T) {
+	files := map[string]string{
+		"/test.rego": "package test\n p := input.foo == data.foo\ntest_p {\n p with input.foo as 42\n}",
+		"/data.json": `{"foo": 42}`,
+	}
+
+	test.WithTempFS(files, func(root string) {
+		cacheDir := filepath.Join(root, ".cache")
+
+		run := func() (int, string) {
+			var buf bytes.Buffer
+			testParams := newTestCommandParams()
+			testParams.count = 1
+			testParams.output = &buf
+			testParams.errOutput = io.Discard
+			testParams.cacheDir = cacheDir
+			exitCode, _ := opaTest([]string{root}, testParams)
+			return exitCode, buf.String()
+		}
+
+		exitCode, out := run()
+		if exitCode != 0 || !strings.Contains(out, "PASS: 1/1") {
+			t.Fatalf("unexpected result: %d, %q", exitCode, out)
+		}
+
+		entries, err := filepath.Glob(filepath.Join(cacheDir, "*", "*", "*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 {
+			t.Fatalf("expected one cache entry, got %v", entries)
+		}
+
+		// The tests are run again, with the cached module.
+		if err := os.WriteFile(filepath.Join(root, "data.json"), []byte(`{"foo": 43}`), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if exitCode, out := run(); exitCode != 2 || !strings.Contains(out, "FAIL: 1/1") {
+			t.Fatalf("unexpected result: %d, %q", exitCode, out)
+		}
+
+		entries, err = filepath.Glob(filepath.Join(cacheDir, "*", "*", "*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 {
+			t.Fatalf("expected one cache entry, got %v", entries)
+		}
+	})
+}
+
 // Assert that ignore flag is correctly used when the bundle flag is activated
 func TestIgnoreFlagWithBundleFlag(t *testing.T) {
 	files := map[string]string{
@@ -514,7 +566,8 @@ Watching for changes ...
 			t.Fatal(err)
 		}
 
-		expected = `PASS: 2/2
+		// only the new test is affected by the change
+		expected = `PASS: 1/1
 ********************************************************************************
 Watching for changes ...
 `
@@ -531,6 +584,77 @@ Watching for changes ...
 	})
 }
 
+func TestWatchModeAffectedTests(t *testing.T) {
+
+	files := map[string]string{
+		"/foo.rego":      "package foo\n p := 1",
+		"/foo_test.rego": "package foo\n test_p { p == 1 }",
+		"/bar.rego":      "package bar\n q := data.foo.p + 1",
+		"/bar_test.rego": "package bar\n test_q { q == 2 }\n test_r { true }",
+	}
+
+	test.WithTempFS(files, func(root string) {
+		buf := test.BlockingWriter{}
+
+		testParams := newTestCommandParams()
+		testParams.output = &buf
+		testParams.watch = true
+		testParams.count = 1
+		testParams.verbose = true
+
+		done := make(chan struct{})
+		go func() {
+			_, _ = opaTest([]string{root}, testParams)
+			<-done
+		}()
+
+		expected := "Watching for changes ..."
+		if !test.Eventually(t, 2*time.Second, func() bool {
+			return strings.Contains(buf.String(), expected)
+		}) {
+			t.Fatalf("expected:\n\n%q\n\ngot:\n\n%q", expected, buf.String())
+		}
+		buf.Reset()
+
+		// changing foo affects the tests of both packages that depend on p
+		if err := os.WriteFile(path.Join(root, "foo.rego"), []byte("package foo\n p := 2"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		expected = "FAIL: 2/2"
+		if !test.Eventually(t, 2*time.Second, func() bool {
+			return strings.Contains(buf.String(), expected)
+		}) {
+			t.Fatalf("expected:\n\n%q\n\ngot:\n\n%q", expected, buf.String())
+		}
+		for _, name := range []string{"data.foo.test_p", "data.bar.test_q"} {
+			if !strings.Contains(buf.String(), name) {
+				t.Fatalf("expected %v to be run, got:\n\n%v", name, buf.String())
+			}
+		}
+		if strings.Contains(buf.String(), "data.bar.test_r") {
+			t.Fatalf("expected data.bar.test_r not to be run, got:\n\n%v", buf.String())
+		}
+		buf.Reset()
+
+		// rewriting a module without changes does not run any tests
+		if err := os.WriteFile(path.Join(root, "foo.rego"), []byte("package foo\n p := 2"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		expected = "No changes to modules or data."
+		if !test.Eventually(t, 2*time.Second, func() bool {
+			return strings.Contains(buf.String(), expected)
+		}) {
+			t.Fatalf("expected:\n\n%q\n\ngot:\n\n%q", expected, buf.String())
+		}
+		buf.Reset()
+
+		testParams.stopChan <- syscall.SIGINT
+		done <- struct{}{}
+	})
+}
+
 func TestWatchModeWithDataFile(t *testing.T) {
 
 	files := map[string]string{
@@ -898,6 +1022,140 @@ func TestExitCode(t *testing.T) {
 	}
 }
 
+func TestParallel(t *testing.T) {
+	files := map[string]string{
+		"/policy.rego":      "package foo\n p := data.x",
+		"/policy_test.rego": "package foo\n test_a { p == 1 }\n test_b { p == 2 }\n test_c { print(p); true }\n todo_test_d { false }",
+		"/other_test.rego":  "package bar\n test_e { data.foo.p == 1 }",
+		"/data.json":        `{"x": 1}`,
+	}
+
+	durations := regexp.MustCompile(`\(.*s\)`)
+
+	for _, bundleMode := range []bool{false, true} {
+		t.Run(fmt.Sprintf("bundle=%v", bundleMode), func(t *testing.T) {
+			test.WithTempFS(files, func(root string) {
+				run := func(parallel int) (int, string) {
+					var buf bytes.Buffer
+					testParams := newTestCommandParams()
+					testParams.output = &buf
+					testParams.verbose = true
+					testParams.bundleMode = bundleMode
+					testParams.parallel = parallel
+					testParams.count = 1
+					exitCode, _ := opaTest([]string{root}, testParams)
+					return exitCode, durations.ReplaceAllString(buf.String(), "")
+				}
+
+				expCode, exp := run(1)
+				actCode, act := run(4)
+
+				if expCode != 2 || actCode != expCode {
+					t.Fatalf("expected exit code 2 but got %d (sequential) and %d (parallel)", expCode, actCode)
+				}
+				if act != exp {
+					t.Fatalf("expected:\n\n%s\n\ngot:\n\n%s", exp, act)
+				}
+			})
+		})
+	}
+
+	var errBuf bytes.Buffer
+	testParams := newTestCommandParams()
+	testParams.errOutput = &errBuf
+	testParams.parallel = 0
+	if exitCode, err := opaTest([]string{"."}, testParams); exitCode != 1 || err == nil {
+		t.Fatalf("expected invalid parallelism to be rejected, got exit code %d and err %v", exitCode, err)
+	}
+}
+
+func TestMutate(t *testing.T) {
+	files := map[string]string{
+		"/policy.rego": `package authz
+allow {
+	input.method == "GET"
+	input.age >= 18
+}`,
+		"/policy_test.rego": `package authz
+test_allow { allow with input as {"method": "GET", "age": 20} }
+test_not_allow { not allow with input as {"method": "POST", "age": 20} }`,
+	}
+
+	test.WithTempFS(files, func(root string) {
+		var buf bytes.Buffer
+		testParams := newTestCommandParams()
+		testParams.output = &buf
+		testParams.count = 1
+		testParams.mutate = true
+
+		exitCode, err := opaTest([]string{root}, testParams)
+		if exitCode != 0 || err != nil {
+			t.Fatalf("unexpected exit code %d and error %v", exitCode, err)
+		}
+
+		expected := fmt.Sprintf(`PASS: 2/2
+
+SURVIVING MUTANTS
+--------------------------------------------------------------------------------
+%[1]s/policy.rego:4: replaced >= with > in input.age >= 18
+%[1]s/policy.rego:4: removed input.age >= 18
+--------------------------------------------------------------------------------
+MUTATION SCORE: 66.67%% (killed: 4, survived: 2, invalid: 0)
+`, root)
+		if buf.String() != expected {
+			t.Fatalf("expected:\n\n%s\n\ngot:\n\n%s", expected, buf.String())
+		}
+
+		buf.Reset()
+		testParams.outputFormat.Set(testJSONOutput)
+		if exitCode, err := opaTest([]string{root}, testParams); exitCode != 0 || err != nil {
+			t.Fatalf("unexpected exit code %d and error %v", exitCode, err)
+		}
+
+		dec := json.NewDecoder(&buf)
+		var results []interface{}
+		if err := dec.Decode(&results); err != nil {
+			t.Fatal(err)
+		}
+		var report mutationReport
+		if err := dec.Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+		if report.Killed != 4 || report.Survived != 2 || report.Invalid != 0 || len(report.Mutants) != 6 {
+			t.Fatalf("unexpected report: %+v", report)
+		}
+		if m := report.Mutants[0]; m.Status != mutantKilled || m.KilledBy != "data.authz.test_allow" {
+			t.Fatalf("expected first mutant to be killed by data.authz.test_allow, got %+v", m)
+		}
+	})
+}
+
+func TestMutateInvalidFlags(t *testing.T) {
+	for _, flag := range []string{"--bench", "--bundle", "--coverage", "--watch"} {
+		t.Run(flag, func(t *testing.T) {
+			var errBuf bytes.Buffer
+			testParams := newTestCommandParams()
+			testParams.errOutput = &errBuf
+			testParams.mutate = true
+			switch flag {
+			case "--bench":
+				testParams.benchmark = true
+			case "--bundle":
+				testParams.bundleMode = true
+			case "--coverage":
+				testParams.coverage = true
+			case "--watch":
+				testParams.watch = true
+			}
+
+			exitCode, err := opaTest([]string{"."}, testParams)
+			if exitCode != 1 || err == nil || err.Error() != "cannot use --mutate with "+flag {
+				t.Fatalf("unexpected exit code %d and error %v", exitCode, err)
+			}
+		})
+	}
+}
+
 func TestCoverageThreshold(t *testing.T) {
 	testCases := []struct {
 		note              string
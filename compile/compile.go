@@ -25,12 +25,16 @@ import (
 	"github.com/open-policy-agent/opa/internal/planner"
 	"github.com/open-policy-agent/opa/internal/ref"
 	initload "github.com/open-policy-agent/opa/internal/runtime/init"
+	"github.com/open-policy-agent/opa/internal/secrets"
 	"github.com/open-policy-agent/opa/internal/wasm/encoding"
 	"github.com/open-policy-agent/opa/ir"
 	"github.com/open-policy-agent/opa/loader"
+	"github.com/open-policy-agent/opa/profiler"
 	"github.com/open-policy-agent/opa/rego"
 	"github.com/open-policy-agent/opa/storage"
 	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/open-policy-agent/opa/topdown"
+	"github.com/open-policy-agent/opa/util"
 )
 
 const (
@@ -84,6 +88,14 @@ type Compiler struct {
 	fsys                         fs.FS                      // file system to use when loading paths
 	ns                           string
 	regoVersion                  ast.RegoVersion
+	profile                      []profiler.ExprStats                   // evaluation profile used to derive rule index hints
+	wasi                         bool                                   // whether to emit a WASI module for the wasm target
+	scanSecrets                  bool                                   // whether to fail on possible secrets in policies and data
+	reproducible                 bool                                   // whether to normalize the output bundle for byte-identical builds
+	pruneUnreachable             bool                                   // whether to remove rules and data that are unreachable from the entrypoints
+	supportProvenance            bool                                   // whether to record the origins of support rules in the manifest
+	provenance                   map[string][]topdown.SupportProvenance // origins of support rules keyed by module path
+	moduleCache                  ast.ModuleCache                        // cache of parsed modules used when loading paths
 }
 
 // New returns a new compiler instance that can be invoked.
@@ -122,6 +134,14 @@ func (c *Compiler) WithPruneUnused(enabled bool) *Compiler {
 	return c
 }
 
+// WithPruneUnreachable sets whether rules and base documents that cannot be
+// reached from the entrypoints through the dependency graph are removed from
+// the output bundle. Unlike WithPruneUnused, this affects all targets.
+func (c *Compiler) WithPruneUnreachable(enabled bool) *Compiler {
+	c.pruneUnreachable = enabled
+	return c
+}
+
 // WithEntrypoints sets the policy entrypoints on the compiler. Entrypoints tell the
 // compiler what rules to expect and where optimizations can be targeted. The wasm
 // target requires at least one entrypoint as does optimization.
@@ -248,6 +268,57 @@ func (c *Compiler) WithRegoVersion(v ast.RegoVersion) *Compiler {
 	return c
 }
 
+// WithProfile sets an evaluation profile, e.g., produced by `opa eval
+// --profile`, for profile-guided optimization. The compiler derives hints about
+// frequently looked up values from the profile and includes them in the output
+// bundle manifest. When the bundle is activated, rule indices build
+// specialized tries for these values. Profiles are only supported for the rego
+// target.
+func (c *Compiler) WithProfile(stats []profiler.ExprStats) *Compiler {
+	c.profile = stats
+	return c
+}
+
+// WithWASI sets whether the wasm target emits a WASI module, that reads its
+// request from stdin and writes the result set to stdout, instead of a module
+// for the OPA-specific embedding ABI.
+func (c *Compiler) WithWASI(yes bool) *Compiler {
+	c.wasi = yes
+	return c
+}
+
+// WithScanSecrets sets whether the build fails on string literals in policies
+// and values in data that look like secrets. Rules and packages whose metadata
+// sets the custom "allow_secrets" key to true are not scanned.
+func (c *Compiler) WithScanSecrets(yes bool) *Compiler {
+	c.scanSecrets = yes
+	return c
+}
+
+// WithReproducible sets whether the output bundle is normalized so that
+// identical inputs produce byte-identical bundles, regardless of the order in
+// which files, roots and entrypoints were supplied.
+func (c *Compiler) WithReproducible(yes bool) *Compiler {
+	c.reproducible = yes
+	return c
+}
+
+// WithSupportProvenance sets whether the origins of the support rules
+// generated by optimization are recorded in the manifest metadata under the
+// "support_provenance" key. The origins identify the rules in the original
+// policy that each support rule was derived from.
+func (c *Compiler) WithSupportProvenance(yes bool) *Compiler {
+	c.supportProvenance = yes
+	return c
+}
+
+// WithModuleCache sets the cache used to look up and store the modules parsed
+// when loading the paths. Modules are still compiled on every build.
+func (c *Compiler) WithModuleCache(cache ast.ModuleCache) *Compiler {
+	c.moduleCache = cache
+	return c
+}
+
 func addEntrypointsFromAnnotations(c *Compiler, ar []*ast.AnnotationsRef) error {
 	for _, ref := range ar {
 		var entrypoint ast.Ref
@@ -302,6 +373,12 @@ func (c *Compiler) Build(ctx context.Context) error {
 		return err
 	}
 
+	if c.scanSecrets {
+		if err := c.checkSecrets(); err != nil {
+			return err
+		}
+	}
+
 	// Extract annotations, and generate new entrypoints as needed.
 	if c.useRegoAnnotationEntrypoints {
 		moduleList := make([]*ast.Module, 0, len(c.bundle.Modules))
@@ -332,6 +409,12 @@ func (c *Compiler) Build(ctx context.Context) error {
 		return err
 	}
 
+	if c.pruneUnreachable {
+		if err := c.pruneUnreachableDocuments(); err != nil {
+			return err
+		}
+	}
+
 	if err := c.optimize(ctx); err != nil {
 		return err
 	}
@@ -357,7 +440,11 @@ func (c *Compiler) Build(ctx context.Context) error {
 			Raw:  bs,
 		})
 	case TargetRego:
-		// nop
+		if c.profile != nil {
+			if err := c.buildIndexHints(); err != nil {
+				return err
+			}
+		}
 	}
 
 	if c.revision != nil {
@@ -368,6 +455,12 @@ func (c *Compiler) Build(ctx context.Context) error {
 		c.bundle.Manifest.Metadata = *c.metadata
 	}
 
+	if len(c.provenance) > 0 {
+		if err := c.addSupportProvenance(); err != nil {
+			return err
+		}
+	}
+
 	if c.regoVersion == ast.RegoV1 {
 		if err := c.bundle.FormatModulesForRegoVersion(c.regoVersion, true, false); err != nil {
 			return err
@@ -378,6 +471,12 @@ func (c *Compiler) Build(ctx context.Context) error {
 		}
 	}
 
+	// NOTE: the bundle must be normalized before it is signed, since the
+	// signature covers the manifest.
+	if c.reproducible {
+		normalizeBundle(c.bundle)
+	}
+
 	if c.bsc != nil {
 		if err := c.bundle.GenerateSignature(c.bsc, c.keyID, false); err != nil {
 			return err
@@ -391,6 +490,31 @@ func (c *Compiler) Build(ctx context.Context) error {
 	return bundle.NewWriter(*c.output).Write(*c.bundle)
 }
 
+// normalizeBundle sorts the files and the manifest entries of b, whose order
+// depends on how the inputs were supplied but does not affect the bundle's
+// semantics. The tarball entries and manifest keys are always written in a
+// fixed order and without timestamps.
+func normalizeBundle(b *bundle.Bundle) {
+	sort.SliceStable(b.Modules, func(i, j int) bool {
+		return b.Modules[i].URL < b.Modules[j].URL
+	})
+	sort.SliceStable(b.WasmModules, func(i, j int) bool {
+		return b.WasmModules[i].URL < b.WasmModules[j].URL
+	})
+	sort.SliceStable(b.PlanModules, func(i, j int) bool {
+		return b.PlanModules[i].URL < b.PlanModules[j].URL
+	})
+	if b.Manifest.Roots != nil {
+		roots := make([]string, len(*b.Manifest.Roots))
+		copy(roots, *b.Manifest.Roots)
+		sort.Strings(roots)
+		b.Manifest.Roots = &roots
+	}
+	sort.SliceStable(b.Manifest.WasmResolvers, func(i, j int) bool {
+		return b.Manifest.WasmResolvers[i].Entrypoint < b.Manifest.WasmResolvers[j].Entrypoint
+	})
+}
+
 func (c *Compiler) init() error {
 
 	if c.capabilities == nil {
@@ -409,6 +533,14 @@ func (c *Compiler) init() error {
 		return fmt.Errorf("invalid target %q", c.target)
 	}
 
+	if c.profile != nil && c.target != TargetRego {
+		return fmt.Errorf("profile-guided optimization is not supported for %s target", c.target)
+	}
+
+	if c.wasi && c.target != TargetWasm {
+		return fmt.Errorf("WASI modules are only supported for %s target", TargetWasm)
+	}
+
 	for _, e := range c.entrypoints {
 		r, err := ref.ParseDataPath(e)
 		if err != nil {
@@ -462,6 +594,19 @@ func (c *Compiler) Bundle() *bundle.Bundle {
 	return c.bundle
 }
 
+func (c *Compiler) checkSecrets() error {
+	modules := make([]*ast.Module, 0, len(c.bundle.Modules))
+	for _, mf := range c.bundle.Modules {
+		modules = append(modules, mf.Parsed)
+	}
+	errs := secrets.Modules(modules)
+	errs = append(errs, secrets.Data(c.bundle.Data)...)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
 func (c *Compiler) initBundle(usePath bool) error {
 	// If the bundle is already set, skip file loading.
 	if c.bundle != nil {
@@ -471,7 +616,7 @@ func (c *Compiler) initBundle(usePath bool) error {
 	// TODO(tsandall): the metrics object should passed through here so we that
 	// we can track read and parse times.
 
-	load, err := initload.LoadPathsForRegoVersion(c.regoVersion, c.paths, c.filter, c.asBundle, c.bvc, false, c.useRegoAnnotationEntrypoints, c.capabilities, c.fsys)
+	load, err := initload.LoadPathsForRegoVersion(c.regoVersion, c.paths, c.filter, c.asBundle, c.bvc, false, c.useRegoAnnotationEntrypoints || c.scanSecrets, c.capabilities, c.fsys, c.moduleCache)
 	if err != nil {
 		return fmt.Errorf("load error: %w", err)
 	}
@@ -544,8 +689,10 @@ func (c *Compiler) optimize(ctx context.Context) error {
 		WithEntrypoints(c.entrypointrefs).
 		WithDebug(c.debug.Writer()).
 		WithShallowInlining(c.optimizationLevel <= 1).
+		WithEvalComprehensions(c.optimizationLevel >= 2).
 		WithEnablePrintStatements(c.enablePrintStatements).
-		WithRegoVersion(c.regoVersion)
+		WithRegoVersion(c.regoVersion).
+		WithSupportProvenance(c.supportProvenance)
 
 	if c.ns != "" {
 		o = o.WithPartialNamespace(c.ns)
@@ -557,10 +704,86 @@ func (c *Compiler) optimize(ctx context.Context) error {
 	}
 
 	c.bundle = o.Bundle()
+	c.provenance = o.Provenance()
+
+	return nil
+}
+
+// supportProvenanceMetadataKey is the manifest metadata key under which the
+// origins of support rules are recorded.
+const supportProvenanceMetadataKey = "support_provenance"
+
+// addSupportProvenance records the origins of the support rules in the
+// manifest metadata. The caller's metadata map is not modified.
+func (c *Compiler) addSupportProvenance() error {
+	var provenance interface{}
+	bs, err := json.Marshal(c.provenance)
+	if err != nil {
+		return err
+	}
+	if err := util.UnmarshalJSON(bs, &provenance); err != nil {
+		return err
+	}
+
+	metadata := make(map[string]interface{}, len(c.bundle.Manifest.Metadata)+1)
+	for k, v := range c.bundle.Manifest.Metadata {
+		metadata[k] = v
+	}
+	metadata[supportProvenanceMetadataKey] = provenance
+	c.bundle.Manifest.Metadata = metadata
+
+	return nil
+}
+
+// buildIndexHints derives rule index hints from the evaluation profile and
+// stores them in the bundle manifest.
+func (c *Compiler) buildIndexHints() error {
+
+	// Lazily compile the modules if needed. If optimizations were run, the
+	// AST compiler will not be set.
+	if c.compiler == nil {
+		var err error
+		c.compiler, err = compile(c.capabilities, c.bundle, c.debug, c.enablePrintStatements)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Profiles aggregate statistics by file and row.
+	type key struct {
+		file string
+		row  int
+	}
+
+	counts := map[key]int{}
+	for _, stat := range c.profile {
+		if stat.Location != nil {
+			counts[key{profileFile(stat.Location.File), stat.Location.Row}] += stat.NumEval
+		}
+	}
+
+	hints := c.compiler.IndexHints(func(loc *ast.Location) int {
+		return counts[key{profileFile(loc.File), loc.Row}]
+	})
+
+	c.debug.Printf("derived %d rule index hint(s) from profile", len(hints))
+
+	indexHints, err := bundle.NewIndexHints(hints)
+	if err != nil {
+		return err
+	}
+
+	c.bundle.Manifest.IndexHints = indexHints
 
 	return nil
 }
 
+// profileFile normalizes file names so that profiles match modules regardless
+// of how their paths were specified, e.g., "./x.rego", "x.rego" and "/x.rego".
+func profileFile(file string) string {
+	return strings.TrimPrefix(filepath.ToSlash(filepath.Clean(file)), "/")
+}
+
 func (c *Compiler) compilePlan(context.Context) error {
 
 	// Lazily compile the modules if needed. If optimizations were run, the
@@ -667,7 +890,7 @@ func (c *Compiler) compilePlan(context.Context) error {
 
 func (c *Compiler) compileWasm(ctx context.Context) error {
 
-	compiler := wasm.New()
+	compiler := wasm.New().WithWASI(c.wasi)
 
 	found := false
 	have := compiler.ABIVersion()
@@ -851,6 +1074,165 @@ func pruneBundleEntrypoints(b *bundle.Bundle, entrypointrefs []*ast.Term) error
 	return nil
 }
 
+// pruneUnreachableDocuments removes the rules that cannot be reached from the
+// entrypoints, and the base documents that are not referred to by the
+// reachable rules or the entrypoints, from the bundle.
+func (c *Compiler) pruneUnreachableDocuments() error {
+	if len(c.entrypointrefs) == 0 {
+		return errors.New("pruning unreachable rules requires at least one entrypoint")
+	}
+
+	compiler, err := compile(c.capabilities, c.bundle, c.debug, c.enablePrintStatements)
+	if err != nil {
+		return err
+	}
+
+	refs := make([]ast.Ref, 0, len(c.entrypointrefs))
+	for _, e := range c.entrypointrefs {
+		refs = append(refs, e.Value.(ast.Ref))
+	}
+
+	reachable := map[string]struct{}{}
+	for _, rule := range compiler.ReachableRules(refs) {
+		reachable[rule.Path().String()] = struct{}{}
+		ast.WalkRefs(rule, func(x ast.Ref) bool {
+			if x.HasPrefix(ast.DefaultRootRef) {
+				refs = append(refs, x.ConstantPrefix())
+			}
+			return false
+		})
+	}
+
+	var pruned int
+	modules := make([]bundle.ModuleFile, 0, len(c.bundle.Modules))
+
+	for _, mf := range c.bundle.Modules {
+		var rules []*ast.Rule
+		prunedPaths := ast.NewSet()
+		var prunedRows [][2]int
+		for _, rule := range mf.Parsed.Rules {
+			path := rule.Path()
+			if _, ok := reachable[path.String()]; ok {
+				rules = append(rules, rule)
+				continue
+			}
+			prunedPaths.Add(ast.NewTerm(path))
+			end := rule.Location.Row
+			for r := rule; r != nil; r = r.Else {
+				if row := r.Location.Row + strings.Count(string(r.Location.Text), "\n"); row > end {
+					end = row
+				}
+			}
+			prunedRows = append(prunedRows, [2]int{rule.Location.Row, end})
+		}
+
+		if len(rules) == len(mf.Parsed.Rules) {
+			modules = append(modules, mf)
+			continue
+		}
+
+		pruned += len(mf.Parsed.Rules) - len(rules)
+		if len(rules) == 0 {
+			c.debug.Printf("pruned module %v", mf.URL)
+			continue
+		}
+
+		// Drop the annotations of pruned rules, and the comments of pruned
+		// annotations and rules, including the comments directly above them.
+		commentRows := make(map[int]struct{}, len(mf.Parsed.Comments))
+		for _, comment := range mf.Parsed.Comments {
+			commentRows[comment.Location.Row] = struct{}{}
+		}
+		for i := range prunedRows {
+			for {
+				if _, ok := commentRows[prunedRows[i][0]-1]; !ok {
+					break
+				}
+				prunedRows[i][0]--
+			}
+		}
+
+		var annotations []*ast.Annotations
+		for _, annotation := range mf.Parsed.Annotations {
+			if annotation.Scope != "package" && annotation.Scope != "subpackages" && prunedPaths.Contains(ast.NewTerm(annotation.GetTargetPath())) {
+				prunedRows = append(prunedRows, [2]int{annotation.Location.Row, annotation.EndLoc().Row})
+			} else {
+				annotations = append(annotations, annotation)
+			}
+		}
+
+		var comments []*ast.Comment
+		for _, comment := range mf.Parsed.Comments {
+			keep := true
+			for _, rows := range prunedRows {
+				if comment.Location.Row >= rows[0] && comment.Location.Row <= rows[1] {
+					keep = false
+					break
+				}
+			}
+			if keep {
+				comments = append(comments, comment)
+			}
+		}
+
+		mf.Parsed.Rules = rules
+		mf.Parsed.Annotations = annotations
+		mf.Parsed.Comments = comments
+		// Remove the original raw source, we're editing the AST directly, so
+		// it won't be in sync anymore.
+		mf.Raw = nil
+		modules = append(modules, mf)
+	}
+
+	c.bundle.Modules = modules
+	c.bundle.Data = pruneData(c.bundle.Data, ast.DefaultRootRef, refs)
+
+	c.debug.Printf("pruned %d rule(s) unreachable from the entrypoints", pruned)
+
+	return nil
+}
+
+// pruneData returns the documents of data under path that overlap with one of
+// the refs.
+func pruneData(data map[string]interface{}, path ast.Ref, refs []ast.Ref) map[string]interface{} {
+	if data == nil {
+		return nil
+	}
+
+	result := make(map[string]interface{}, len(data))
+
+	for key, value := range data {
+		p := path.Append(ast.StringTerm(key))
+		var keep, descend bool
+		for _, ref := range refs {
+			if p.HasPrefix(ref) {
+				keep = true
+				break
+			}
+			if ref.HasPrefix(p) {
+				descend = true
+			}
+		}
+
+		if !keep && descend {
+			if obj, ok := value.(map[string]interface{}); ok {
+				// Documents that only become empty by pruning are dropped.
+				if sub := pruneData(obj, p, refs); len(sub) > 0 || len(obj) == 0 {
+					result[key] = sub
+				}
+				continue
+			}
+			keep = true
+		}
+
+		if keep {
+			result[key] = value
+		}
+	}
+
+	return result
+}
+
 type undefinedEntrypointErr struct {
 	Entrypoint *ast.Term
 }
@@ -868,9 +1250,12 @@ type optimizer struct {
 	resultsymprefix       string
 	outputprefix          string
 	shallow               bool
+	comprehensions        bool
 	debug                 debug.Debug
 	enablePrintStatements bool
 	regoVersion           ast.RegoVersion
+	origins               map[*ast.Rule]topdown.SupportProvenance // nil unless support provenance is recorded
+	provenance            map[string][]topdown.SupportProvenance
 }
 
 func newOptimizer(c *ast.Capabilities, b *bundle.Bundle) *optimizer {
@@ -906,6 +1291,11 @@ func (o *optimizer) WithShallowInlining(yes bool) *optimizer {
 	return o
 }
 
+func (o *optimizer) WithEvalComprehensions(yes bool) *optimizer {
+	o.comprehensions = yes
+	return o
+}
+
 func (o *optimizer) WithPartialNamespace(ns string) *optimizer {
 	o.nsprefix = ns
 	return o
@@ -916,6 +1306,15 @@ func (o *optimizer) WithRegoVersion(regoVersion ast.RegoVersion) *optimizer {
 	return o
 }
 
+func (o *optimizer) WithSupportProvenance(yes bool) *optimizer {
+	if yes {
+		o.origins = map[*ast.Rule]topdown.SupportProvenance{}
+	} else {
+		o.origins = nil
+	}
+	return o
+}
+
 func (o *optimizer) Do(ctx context.Context) error {
 
 	// NOTE(tsandall): if there are multiple entrypoints, copy the bundle because
@@ -961,6 +1360,7 @@ func (o *optimizer) Do(ctx context.Context) error {
 			rego.PartialNamespace(o.nsprefix),
 			rego.DisableInlining(required),
 			rego.ShallowInlining(o.shallow),
+			rego.EvalComprehensionsDuringPartial(o.comprehensions),
 			rego.SkipPartialNamespace(true),
 			rego.ParsedUnknowns(unknowns),
 			rego.Compiler(o.compiler),
@@ -973,6 +1373,7 @@ func (o *optimizer) Do(ctx context.Context) error {
 		o.debug.Printf("  partial-namespace: %v", o.nsprefix)
 		o.debug.Printf("  disable-inlining: %v", required)
 		o.debug.Printf("  shallow-inlining: %v", o.shallow)
+		o.debug.Printf("  eval-comprehensions: %v", o.comprehensions)
 
 		for i := range unknowns {
 			o.debug.Printf("  unknown: %v", unknowns[i])
@@ -990,6 +1391,12 @@ func (o *optimizer) Do(ctx context.Context) error {
 			return undefinedEntrypointErr{Entrypoint: e}
 		}
 
+		if o.origins != nil {
+			for _, p := range pq.Provenance {
+				o.origins[p.Rule] = p
+			}
+		}
+
 		if module := o.getSupportForEntrypoint(pq.Queries, e, resultsym); module != nil {
 			pq.Support = append(pq.Support, module)
 		}
@@ -1017,6 +1424,10 @@ func (o *optimizer) Do(ctx context.Context) error {
 	o.bundle.Manifest.AddRoot(o.nsprefix)
 	o.bundle.Manifest.Revision = ""
 
+	if o.origins != nil {
+		o.provenance = o.findProvenance()
+	}
+
 	return nil
 }
 
@@ -1024,6 +1435,35 @@ func (o *optimizer) Bundle() *bundle.Bundle {
 	return o.bundle
 }
 
+// Provenance returns the origins of the support rules in the optimized bundle
+// keyed by the path of the module file that contains them.
+func (o *optimizer) Provenance() map[string][]topdown.SupportProvenance {
+	return o.provenance
+}
+
+// findProvenance locates the support rules in the optimized bundle. The
+// positions reported by partial evaluation are recomputed because support
+// rules are merged into module files and may be pruned by later entrypoints.
+func (o *optimizer) findProvenance() map[string][]topdown.SupportProvenance {
+	result := map[string][]topdown.SupportProvenance{}
+	for _, mf := range o.bundle.Modules {
+		for i, rule := range mf.Parsed.Rules {
+			j := 0
+			for r := rule; r != nil; r, j = r.Else, j+1 {
+				p, ok := o.origins[r]
+				if !ok {
+					continue
+				}
+				p.Ref = mf.Parsed.Package.Path.Extend(rule.Head.Ref().GroundPrefix())
+				p.Index = i
+				p.Else = j
+				result[mf.Path] = append(result[mf.Path], p)
+			}
+		}
+	}
+	return result
+}
+
 func (o *optimizer) findRequiredDocuments(ref *ast.Term) []string {
 
 	keep := map[string]*ast.Location{}
@@ -37,12 +37,17 @@ type Config struct {
 	NDBuiltinCache               bool                       `json:"nd_builtin_cache,omitempty"`
 	PersistenceDirectory         *string                    `json:"persistence_directory,omitempty"`
 	DistributedTracing           json.RawMessage            `json:"distributed_tracing,omitempty"`
+	HTTPSend                     json.RawMessage            `json:"http_send,omitempty"`
 	Server                       *struct {
-		Encoding json.RawMessage `json:"encoding,omitempty"`
-		Metrics  json.RawMessage `json:"metrics,omitempty"`
+		Encoding       json.RawMessage `json:"encoding,omitempty"`
+		Decoding       json.RawMessage `json:"decoding,omitempty"`
+		Metrics        json.RawMessage `json:"metrics,omitempty"`
+		Limits         json.RawMessage `json:"limits,omitempty"`
+		Authentication json.RawMessage `json:"authentication,omitempty"`
 	} `json:"server,omitempty"`
 	Storage *struct {
-		Disk json.RawMessage `json:"disk,omitempty"`
+		Disk  json.RawMessage `json:"disk,omitempty"`
+		Inmem json.RawMessage `json:"inmem,omitempty"`
 	} `json:"storage,omitempty"`
 	Extra map[string]json.RawMessage `json:"-"`
 }
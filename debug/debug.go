@@ -0,0 +1,440 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package debug implements a step debugger for Rego queries. The debugger is
+// built on the query tracer: evaluation runs in the background and is paused
+// on trace events that hit a breakpoint, or that complete a step. Sessions
+// can be driven by a command-line interface, like 'opa debug', or by an IDE
+// integration.
+package debug
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/topdown"
+)
+
+// StopReason identifies why evaluation was paused.
+type StopReason string
+
+const (
+	// StopEntry is the reason for the first stop if Config.StopOnEntry is set.
+	StopEntry StopReason = "entry"
+	// StopBreakpoint is the reason for stops on breakpoints.
+	StopBreakpoint StopReason = "breakpoint"
+	// StopStep is the reason for stops after stepping.
+	StopStep StopReason = "step"
+	// StopDone is the reason for the final stop, after evaluation finished.
+	StopDone StopReason = "done"
+)
+
+// ErrDone is returned when a session is resumed after evaluation finished.
+var ErrDone = errors.New("evaluation finished")
+
+// Breakpoint pauses evaluation when an expression on a line of a file is
+// evaluated, or when a rule is entered. Breakpoints are parsed from strings
+// of the form "file:line", e.g. "policy.rego:12", or rule refs, e.g.
+// "data.policy.allow". Rule refs also match the rules nested under them, so
+// "data.policy" matches all rules of the package.
+type Breakpoint struct {
+	ID   int     `json:"id"`
+	File string  `json:"file,omitempty"`
+	Line int     `json:"line,omitempty"`
+	Ref  ast.Ref `json:"ref,omitempty"`
+}
+
+// ParseBreakpoint parses a breakpoint from a string.
+func ParseBreakpoint(s string) (Breakpoint, error) {
+	if i := strings.LastIndex(s, ":"); i > 0 {
+		if line, err := strconv.Atoi(s[i+1:]); err == nil {
+			if line < 1 {
+				return Breakpoint{}, fmt.Errorf("invalid breakpoint %q: invalid line number", s)
+			}
+			return Breakpoint{File: s[:i], Line: line}, nil
+		}
+	}
+
+	ref, err := ast.ParseRef(s)
+	if err != nil || !ref.HasPrefix(ast.DefaultRootRef) {
+		return Breakpoint{}, fmt.Errorf("invalid breakpoint %q: expected file:line or rule ref", s)
+	}
+	return Breakpoint{Ref: ref}, nil
+}
+
+func (b Breakpoint) String() string {
+	if b.Ref != nil {
+		return b.Ref.String()
+	}
+	return fmt.Sprintf("%v:%d", b.File, b.Line)
+}
+
+// matches returns true if the breakpoint is hit by evt. Line breakpoints are
+// hit by expressions being evaluated and rules being entered. Rule
+// breakpoints are hit by rules being entered.
+func (b Breakpoint) matches(evt *topdown.Event) bool {
+	switch evt.Op {
+	case topdown.EvalOp, topdown.EnterOp:
+	default:
+		return false
+	}
+
+	if b.Ref != nil {
+		rule, ok := evt.Node.(*ast.Rule)
+		if !ok || evt.Op != topdown.EnterOp || rule.Module == nil {
+			return false
+		}
+		return rule.Path().HasPrefix(b.Ref)
+	}
+
+	if evt.Location == nil || evt.Location.Row != b.Line {
+		return false
+	}
+	return sameFile(evt.Location.File, b.File)
+}
+
+// sameFile returns true if file refers to the file at path. Relative file
+// names match the trailing elements of the path.
+func sameFile(path, file string) bool {
+	if path == "" {
+		return false
+	}
+	path, file = filepath.Clean(path), filepath.Clean(file)
+	return path == file || strings.HasSuffix(path, string(filepath.Separator)+file)
+}
+
+// Config contains the options of a debug session.
+type Config struct {
+	Breakpoints []Breakpoint
+	StopOnEntry bool // pause on the first event
+}
+
+// Frame is an entry in the stack of queries being evaluated. The event is
+// the last event of the query.
+type Frame struct {
+	QueryID uint64
+	Event   *topdown.Event
+}
+
+// State describes a stop of the evaluation. For the final stop, the state
+// contains the results of the query, or the evaluation error.
+type State struct {
+	Reason     StopReason
+	Event      *topdown.Event // the event evaluation stopped on; nil for StopDone
+	Breakpoint *Breakpoint    // the breakpoint hit, if any
+	Stack      []Frame        // innermost frame first
+	Results    rego.ResultSet
+	Err        error
+}
+
+// Locals returns the bindings of the local variables at the stop, keyed by
+// the names used in the policy. Variables generated by the compiler are
+// omitted.
+func (s *State) Locals() map[string]*ast.Term {
+	if s.Event == nil {
+		return nil
+	}
+	return Locals(s.Event)
+}
+
+// Locals returns the bindings of the local variables of evt, keyed by the
+// names used in the policy. Variables generated by the compiler are omitted.
+func Locals(evt *topdown.Event) map[string]*ast.Term {
+	result := map[string]*ast.Term{}
+	if evt.Locals == nil {
+		return result
+	}
+	evt.Locals.Iter(func(k, v ast.Value) bool {
+		name, ok := k.(ast.Var)
+		if !ok {
+			return false
+		}
+		if md, ok := evt.LocalMetadata[name]; ok {
+			name = md.Name
+		}
+		if name.IsGenerated() || name.IsWildcard() {
+			return false
+		}
+		result[string(name)] = ast.NewTerm(v)
+		return false
+	})
+	return result
+}
+
+type stepMode int
+
+// maxDepth is the depth before the first stop, so that the first step stops
+// on the first event.
+const maxDepth = int(^uint(0) >> 1)
+
+const (
+	modeContinue stepMode = iota
+	modeStepIn
+	modeStepOver
+	modeStepOut
+)
+
+// Session is the evaluation of a query under the control of the debugger.
+// Sessions are not safe for concurrent use, except for the breakpoint
+// methods.
+type Session struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	opts    []func(*rego.Rego)
+	started bool
+	final   *State
+
+	cmds  chan stepMode
+	stops chan *State
+
+	mu          sync.Mutex
+	breakpoints []Breakpoint
+	nextID      int
+
+	// accessed by the evaluation goroutine only
+	mode    stepMode
+	depth   int
+	entry   bool
+	parents map[uint64]uint64
+	depths  map[uint64]int
+	last    map[uint64]*topdown.Event
+}
+
+// NewSession returns a session evaluating the query built from the rego
+// options, e.g., rego.Query and rego.Module. Evaluation starts with the first
+// call to Continue or one of the step methods.
+func NewSession(ctx context.Context, config Config, opts ...func(*rego.Rego)) *Session {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Session{
+		ctx:     ctx,
+		cancel:  cancel,
+		opts:    opts,
+		cmds:    make(chan stepMode),
+		stops:   make(chan *State),
+		entry:   config.StopOnEntry,
+		depth:   maxDepth,
+		parents: map[uint64]uint64{},
+		depths:  map[uint64]int{},
+		last:    map[uint64]*topdown.Event{},
+	}
+	for _, b := range config.Breakpoints {
+		s.AddBreakpoint(b)
+	}
+	return s
+}
+
+// AddBreakpoint adds a breakpoint to the session, and returns it with its ID
+// assigned.
+func (s *Session) AddBreakpoint(b Breakpoint) Breakpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	b.ID = s.nextID
+	s.breakpoints = append(s.breakpoints, b)
+	return b
+}
+
+// RemoveBreakpoint removes the breakpoint with the given ID. False is
+// returned if there is no such breakpoint.
+func (s *Session) RemoveBreakpoint(id int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.breakpoints {
+		if s.breakpoints[i].ID == id {
+			s.breakpoints = append(s.breakpoints[:i], s.breakpoints[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Breakpoints returns the breakpoints of the session, ordered by ID.
+func (s *Session) Breakpoints() []Breakpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]Breakpoint, len(s.breakpoints))
+	copy(result, s.breakpoints)
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+// Continue resumes evaluation until a breakpoint is hit, or evaluation
+// finishes.
+func (s *Session) Continue() (*State, error) {
+	return s.resume(modeContinue)
+}
+
+// StepIn resumes evaluation until the next event, including the events of
+// queries started by the current one, e.g., when a rule is evaluated.
+func (s *Session) StepIn() (*State, error) {
+	return s.resume(modeStepIn)
+}
+
+// StepOver resumes evaluation until the next event of the current query or
+// one of its parents.
+func (s *Session) StepOver() (*State, error) {
+	return s.resume(modeStepOver)
+}
+
+// StepOut resumes evaluation until the next event of one of the parents of
+// the current query.
+func (s *Session) StepOut() (*State, error) {
+	return s.resume(modeStepOut)
+}
+
+// Close stops the evaluation.
+func (s *Session) Close() {
+	s.cancel()
+	if s.started && s.final == nil {
+		// drain the remaining stops, the tracer no longer waits for commands
+		for state := range s.stops {
+			if state.Reason == StopDone {
+				s.final = state
+			}
+		}
+	}
+}
+
+func (s *Session) resume(mode stepMode) (*State, error) {
+	if s.final != nil {
+		return s.final, ErrDone
+	}
+
+	if !s.started {
+		s.started = true
+		s.mode = mode
+		go s.eval()
+	} else {
+		select {
+		case s.cmds <- mode:
+		case <-s.ctx.Done():
+			return nil, s.ctx.Err()
+		}
+	}
+
+	state := <-s.stops
+	if state.Reason == StopDone {
+		s.final = state
+	}
+	return state, nil
+}
+
+func (s *Session) eval() {
+	defer close(s.stops)
+
+	opts := append([]func(*rego.Rego){}, s.opts...)
+	opts = append(opts, rego.QueryTracer(&tracer{s: s}))
+
+	rs, err := rego.New(opts...).Eval(s.ctx)
+	s.stops <- &State{Reason: StopDone, Results: rs, Err: err}
+}
+
+// trace is called on the evaluation goroutine for every event, and blocks
+// while evaluation is paused.
+func (s *Session) trace(evt topdown.Event) {
+	if s.ctx.Err() != nil {
+		return
+	}
+
+	depth, ok := s.depths[evt.QueryID]
+	if !ok {
+		depth = s.depths[evt.ParentID] + 1
+		s.depths[evt.QueryID] = depth
+		s.parents[evt.QueryID] = evt.ParentID
+	}
+	s.last[evt.QueryID] = &evt
+
+	switch evt.Op {
+	case topdown.EnterOp, topdown.EvalOp, topdown.ExitOp, topdown.FailOp:
+	default:
+		return
+	}
+
+	var reason StopReason
+	var hit *Breakpoint
+
+	s.mu.Lock()
+	for i := range s.breakpoints {
+		if s.breakpoints[i].matches(&evt) {
+			b := s.breakpoints[i]
+			hit = &b
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	switch {
+	case s.entry:
+		reason = StopEntry
+		s.entry = false
+	case hit != nil:
+		reason = StopBreakpoint
+	case s.mode == modeStepIn,
+		s.mode == modeStepOver && depth <= s.depth,
+		s.mode == modeStepOut && depth < s.depth:
+		reason = StopStep
+	default:
+		return
+	}
+
+	state := &State{
+		Reason:     reason,
+		Event:      &evt,
+		Breakpoint: hit,
+		Stack:      s.stack(evt.QueryID),
+	}
+
+	select {
+	case s.stops <- state:
+	case <-s.ctx.Done():
+		return
+	}
+
+	select {
+	case mode := <-s.cmds:
+		s.mode = mode
+		s.depth = depth
+	case <-s.ctx.Done():
+	}
+}
+
+func (s *Session) stack(qid uint64) []Frame {
+	var frames []Frame
+	for {
+		evt, ok := s.last[qid]
+		if !ok {
+			return frames
+		}
+		frames = append(frames, Frame{QueryID: qid, Event: evt})
+		parent, ok := s.parents[qid]
+		if !ok || parent == qid {
+			return frames
+		}
+		qid = parent
+	}
+}
+
+type tracer struct {
+	s *Session
+}
+
+func (*tracer) Enabled() bool {
+	return true
+}
+
+func (t *tracer) TraceEvent(evt topdown.Event) {
+	t.s.trace(evt)
+}
+
+func (*tracer) Config() topdown.TraceConfig {
+	return topdown.TraceConfig{PlugLocalVars: true}
+}
@@ -0,0 +1,228 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package debug
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/topdown"
+)
+
+const testPolicy = `package policy
+
+import rego.v1
+
+allow if {
+	user := input.user
+	is_admin(user)
+}
+
+is_admin(u) if {
+	u == "admin"
+}
+`
+
+func newTestSession(config Config) *Session {
+	return NewSession(context.Background(), config,
+		rego.Query("data.policy.allow"),
+		rego.Module("/policies/policy.rego", testPolicy),
+		rego.Input(map[string]interface{}{"user": "admin"}),
+	)
+}
+
+func row(t *testing.T, state *State) int {
+	t.Helper()
+	if state.Event == nil || state.Event.Location == nil {
+		t.Fatalf("expected event with location, got %+v", state)
+	}
+	return state.Event.Location.Row
+}
+
+func assertDone(t *testing.T, s *Session) {
+	t.Helper()
+	state, err := s.Continue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Reason != StopDone || state.Err != nil || len(state.Results) != 1 || state.Results[0].Expressions[0].Value != true {
+		t.Fatalf("expected results, got %+v", state)
+	}
+	if _, err := s.Continue(); !errors.Is(err, ErrDone) {
+		t.Fatalf("expected ErrDone, got %v", err)
+	}
+}
+
+func TestLineBreakpoint(t *testing.T) {
+	s := newTestSession(Config{Breakpoints: []Breakpoint{{File: "policy.rego", Line: 11}}})
+
+	state, err := s.Continue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Reason != StopBreakpoint || state.Breakpoint.ID != 1 || state.Event.Op != topdown.EvalOp || row(t, state) != 11 {
+		t.Fatalf("unexpected stop: %+v", state)
+	}
+
+	if len(state.Stack) != 3 {
+		t.Fatalf("expected three frames, got %v", state.Stack)
+	}
+	for i, exp := range []int{11, 7, 1} { // the current expression, and the call sites
+		if act := state.Stack[i].Event.Location.Row; act != exp {
+			t.Errorf("frame %d: expected row %d, got %d", i, exp, act)
+		}
+	}
+
+	locals := state.Locals()
+	if len(locals) != 1 || !locals["u"].Equal(ast.StringTerm("admin")) {
+		t.Fatalf("unexpected locals: %v", locals)
+	}
+
+	assertDone(t, s)
+}
+
+func TestRuleBreakpoint(t *testing.T) {
+	for _, bp := range []string{"data.policy.is_admin", "data.policy"} {
+		t.Run(bp, func(t *testing.T) {
+			b, err := ParseBreakpoint(bp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := newTestSession(Config{Breakpoints: []Breakpoint{b}})
+
+			state, err := s.Continue()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state.Reason != StopBreakpoint || state.Event.Op != topdown.EnterOp || !state.Event.HasRule() {
+				t.Fatalf("unexpected stop: %+v", state)
+			}
+			s.Close()
+		})
+	}
+}
+
+func TestStepping(t *testing.T) {
+	s := newTestSession(Config{StopOnEntry: true})
+
+	state, err := s.StepIn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Reason != StopEntry {
+		t.Fatalf("expected entry stop, got %+v", state)
+	}
+
+	// step into the rule, up to the call of the function
+	for row(t, state) != 7 {
+		if state, err = s.StepIn(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !state.Locals()["user"].Equal(ast.StringTerm("admin")) {
+		t.Fatalf("unexpected locals: %v", state.Locals())
+	}
+
+	// stepping over the function call skips the events of its body
+	if state, err = s.StepOver(); err != nil {
+		t.Fatal(err)
+	}
+	if state.Reason != StopStep || state.Event.Op != topdown.ExitOp || row(t, state) != 5 {
+		t.Fatalf("unexpected stop: %v %v", state.Event.Op, row(t, state))
+	}
+
+	assertDone(t, s)
+}
+
+func TestStepOut(t *testing.T) {
+	s := newTestSession(Config{Breakpoints: []Breakpoint{{File: "policy.rego", Line: 11}}})
+
+	if _, err := s.Continue(); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := s.StepOut()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Event.Op != topdown.ExitOp || row(t, state) != 5 || len(state.Stack) != 2 {
+		t.Fatalf("unexpected stop: %v %v", state.Event.Op, row(t, state))
+	}
+
+	assertDone(t, s)
+}
+
+func TestBreakpoints(t *testing.T) {
+	s := newTestSession(Config{})
+
+	b1 := s.AddBreakpoint(Breakpoint{File: "policy.rego", Line: 6})
+	b2 := s.AddBreakpoint(Breakpoint{File: "policy.rego", Line: 11})
+	if b1.ID != 1 || b2.ID != 2 {
+		t.Fatalf("unexpected IDs: %v, %v", b1, b2)
+	}
+
+	if !s.RemoveBreakpoint(b1.ID) || s.RemoveBreakpoint(b1.ID) {
+		t.Fatal("expected breakpoint to be removed once")
+	}
+	if bs := s.Breakpoints(); len(bs) != 1 || bs[0].ID != b2.ID {
+		t.Fatalf("unexpected breakpoints: %v", bs)
+	}
+
+	state, err := s.Continue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row(t, state) != 11 {
+		t.Fatalf("unexpected stop: %+v", state)
+	}
+
+	assertDone(t, s)
+}
+
+func TestClose(t *testing.T) {
+	s := newTestSession(Config{StopOnEntry: true})
+	if _, err := s.StepIn(); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if _, err := s.Continue(); !errors.Is(err, ErrDone) {
+		t.Fatalf("expected ErrDone, got %v", err)
+	}
+}
+
+func TestParseBreakpoint(t *testing.T) {
+	for _, tc := range []struct {
+		input string
+		exp   Breakpoint
+		err   bool
+	}{
+		{input: "policy.rego:12", exp: Breakpoint{File: "policy.rego", Line: 12}},
+		{input: "/a:b/policy.rego:3", exp: Breakpoint{File: "/a:b/policy.rego", Line: 3}},
+		{input: "data.rego:3", exp: Breakpoint{File: "data.rego", Line: 3}},
+		{input: "data.policy.allow", exp: Breakpoint{Ref: ast.MustParseRef("data.policy.allow")}},
+		{input: "policy.rego:0", err: true},
+		{input: "policy.rego", err: true},
+		{input: "input.x", err: true},
+	} {
+		t.Run(tc.input, func(t *testing.T) {
+			act, err := ParseBreakpoint(tc.input)
+			if tc.err {
+				if err == nil {
+					t.Fatalf("expected error, got %v", act)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if act.String() != tc.exp.String() || act.File != tc.exp.File || act.Line != tc.exp.Line {
+				t.Fatalf("expected %v, got %v", tc.exp, act)
+			}
+		})
+	}
+}
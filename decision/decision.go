@@ -0,0 +1,212 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package decision contains helpers for admission-style decisions, where the
+// policy decides whether a request is allowed and may mutate it with a set of
+// RFC6902 JSON Patch operations, e.g. for Kubernetes mutating webhooks.
+package decision
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// JSON Patch operations defined by RFC6902.
+const (
+	OpAdd     = "add"
+	OpRemove  = "remove"
+	OpReplace = "replace"
+	OpMove    = "move"
+	OpCopy    = "copy"
+	OpTest    = "test"
+)
+
+// Decision represents an admission decision returned by a policy:
+//
+//	{"allowed": true, "reason": "...", "patches": [{"op": "add", "path": "/metadata/labels/owner", "value": "alice"}]}
+type Decision struct {
+	Allowed bool        `json:"allowed"`
+	Reason  string      `json:"reason,omitempty"`
+	Patches []Operation `json:"patches,omitempty"`
+}
+
+// Operation represents a single RFC6902 JSON Patch operation. Value is a
+// pointer so that a null value can be told apart from a missing one.
+type Operation struct {
+	Op    string       `json:"op"`
+	Path  string       `json:"path"`
+	From  string       `json:"from,omitempty"`
+	Value *interface{} `json:"value,omitempty"`
+}
+
+// Parse returns the decision represented by v, which is the JSON
+// representation of a policy result, e.g. the value of an expression of a
+// rego.ResultSet. An error is returned if v is not a valid decision.
+func Parse(v interface{}) (*Decision, error) {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("decision must be an object but got %v", typeName(v))
+	}
+
+	var d Decision
+
+	allowed, ok := obj["allowed"].(bool)
+	if !ok {
+		return nil, errors.New("decision must have a boolean \"allowed\" field")
+	}
+	d.Allowed = allowed
+
+	if x, ok := obj["reason"]; ok {
+		reason, ok := x.(string)
+		if !ok {
+			return nil, fmt.Errorf("decision \"reason\" field must be a string but got %v", typeName(x))
+		}
+		d.Reason = reason
+	}
+
+	if x, ok := obj["patches"]; ok && x != nil {
+		patches, err := parsePatches(x)
+		if err != nil {
+			return nil, err
+		}
+		d.Patches = patches
+	}
+
+	return &d, d.Validate()
+}
+
+// Validate returns an error if the decision is not valid. Patches are only
+// valid in decisions allowing the request.
+func (d *Decision) Validate() error {
+	if !d.Allowed && len(d.Patches) > 0 {
+		return errors.New("decision must not have patches if the request is not allowed")
+	}
+	return ValidatePatches(d.Patches)
+}
+
+// JSONPatch returns the patches of the decision encoded as a JSON Patch
+// document, or nil if there are none.
+func (d *Decision) JSONPatch() ([]byte, error) {
+	if len(d.Patches) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(d.Patches)
+}
+
+// ValidatePatches returns an error if any of the operations is not a valid
+// RFC6902 JSON Patch operation.
+func ValidatePatches(patches []Operation) error {
+	for i, op := range patches {
+		if err := op.Validate(); err != nil {
+			return fmt.Errorf("invalid patch %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Validate returns an error if the operation is not a valid RFC6902 JSON Patch
+// operation.
+func (op Operation) Validate() error {
+	switch op.Op {
+	case OpAdd, OpReplace, OpTest:
+		if op.Value == nil {
+			return fmt.Errorf("%q operation requires a value", op.Op)
+		}
+	case OpRemove:
+	case OpMove, OpCopy:
+		if err := validatePointer(op.From); err != nil {
+			return fmt.Errorf("invalid from: %w", err)
+		}
+		// A location cannot be moved into one of its children.
+		if op.Op == OpMove && strings.HasPrefix(op.Path, op.From+"/") {
+			return fmt.Errorf("cannot move %q into one of its children", op.From)
+		}
+	default:
+		return fmt.Errorf("unknown operation %q", op.Op)
+	}
+
+	if err := validatePointer(op.Path); err != nil {
+		return fmt.Errorf("invalid path: %w", err)
+	}
+
+	return nil
+}
+
+func parsePatches(x interface{}) ([]Operation, error) {
+	arr, ok := x.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("decision \"patches\" field must be an array but got %v", typeName(x))
+	}
+
+	patches := make([]Operation, len(arr))
+	for i := range arr {
+		obj, ok := arr[i].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid patch %d: must be an object but got %v", i, typeName(arr[i]))
+		}
+
+		for _, key := range []string{"op", "path", "from"} {
+			if v, ok := obj[key]; ok {
+				if _, ok := v.(string); !ok {
+					return nil, fmt.Errorf("invalid patch %d: %q must be a string but got %v", i, key, typeName(v))
+				}
+			}
+		}
+
+		if _, ok := obj["path"]; !ok {
+			return nil, fmt.Errorf("invalid patch %d: missing path", i)
+		}
+
+		if op := obj["op"]; op == OpMove || op == OpCopy {
+			if _, ok := obj["from"]; !ok {
+				return nil, fmt.Errorf("invalid patch %d: %q operation requires a from", i, op)
+			}
+		}
+
+		patches[i].Op, _ = obj["op"].(string)
+		patches[i].Path, _ = obj["path"].(string)
+		patches[i].From, _ = obj["from"].(string)
+		if v, ok := obj["value"]; ok {
+			patches[i].Value = &v
+		}
+	}
+
+	return patches, nil
+}
+
+// validatePointer returns an error if s is not a valid RFC6901 JSON Pointer.
+func validatePointer(s string) error {
+	if s == "" {
+		return nil
+	}
+	if s[0] != '/' {
+		return fmt.Errorf("%q must be empty or start with /", s)
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] == '~' && (i+1 == len(s) || (s[i+1] != '0' && s[i+1] != '1')) {
+			return fmt.Errorf("%q contains an invalid escape sequence", s)
+		}
+	}
+	return nil
+}
+
+func typeName(x interface{}) string {
+	switch x.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number, int, int64, float64:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", x)
+}
@@ -0,0 +1,164 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package decision
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/util"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		note    string
+		value   string
+		patches int
+		err     string
+	}{
+		{
+			note:  "allowed",
+			value: `{"allowed": true}`,
+		},
+		{
+			note:  "denied with reason",
+			value: `{"allowed": false, "reason": "no owner label"}`,
+		},
+		{
+			note:  "null patches",
+			value: `{"allowed": true, "patches": null}`,
+		},
+		{
+			note: "all operations",
+			value: `{"allowed": true, "patches": [
+				{"op": "add", "path": "/metadata/labels/owner", "value": "alice"},
+				{"op": "add", "path": "/metadata/annotations/x", "value": null},
+				{"op": "remove", "path": "/spec/containers/0/env"},
+				{"op": "replace", "path": "/spec/replicas", "value": 2},
+				{"op": "move", "from": "/a/b", "path": "/a/c"},
+				{"op": "copy", "from": "/a/b", "path": "/a/b/c"},
+				{"op": "test", "path": "/metadata/labels/a~1b~0c", "value": "x"}
+			]}`,
+			patches: 7,
+		},
+		{
+			note:  "not an object",
+			value: `true`,
+			err:   "decision must be an object but got boolean",
+		},
+		{
+			note:  "missing allowed",
+			value: `{"patches": []}`,
+			err:   `decision must have a boolean "allowed" field`,
+		},
+		{
+			note:  "non-string reason",
+			value: `{"allowed": false, "reason": 1}`,
+			err:   `decision "reason" field must be a string but got number`,
+		},
+		{
+			note:  "patches not an array",
+			value: `{"allowed": true, "patches": {}}`,
+			err:   `decision "patches" field must be an array but got object`,
+		},
+		{
+			note:  "patches when denied",
+			value: `{"allowed": false, "patches": [{"op": "remove", "path": "/a"}]}`,
+			err:   "decision must not have patches if the request is not allowed",
+		},
+		{
+			note:  "patch not an object",
+			value: `{"allowed": true, "patches": ["add"]}`,
+			err:   "invalid patch 0: must be an object but got string",
+		},
+		{
+			note:  "unknown operation",
+			value: `{"allowed": true, "patches": [{"op": "append", "path": "/a"}]}`,
+			err:   `invalid patch 0: unknown operation "append"`,
+		},
+		{
+			note:  "missing path",
+			value: `{"allowed": true, "patches": [{"op": "remove"}]}`,
+			err:   "invalid patch 0: missing path",
+		},
+		{
+			note:  "non-string path",
+			value: `{"allowed": true, "patches": [{"op": "remove", "path": ["a"]}]}`,
+			err:   `invalid patch 0: "path" must be a string but got array`,
+		},
+		{
+			note:  "missing value",
+			value: `{"allowed": true, "patches": [{"op": "remove", "path": "/a"}, {"op": "add", "path": "/a"}]}`,
+			err:   `invalid patch 1: "add" operation requires a value`,
+		},
+		{
+			note:  "missing from",
+			value: `{"allowed": true, "patches": [{"op": "move", "path": "/a"}]}`,
+			err:   `invalid patch 0: "move" operation requires a from`,
+		},
+		{
+			note:  "relative path",
+			value: `{"allowed": true, "patches": [{"op": "remove", "path": "a/b"}]}`,
+			err:   `invalid patch 0: invalid path: "a/b" must be empty or start with /`,
+		},
+		{
+			note:  "invalid escape",
+			value: `{"allowed": true, "patches": [{"op": "copy", "from": "/a~2", "path": "/b"}]}`,
+			err:   `invalid patch 0: invalid from: "/a~2" contains an invalid escape sequence`,
+		},
+		{
+			note:  "move into child",
+			value: `{"allowed": true, "patches": [{"op": "move", "from": "/a", "path": "/a/b"}]}`,
+			err:   `invalid patch 0: cannot move "/a" into one of its children`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			d, err := Parse(util.MustUnmarshalJSON([]byte(tc.value)))
+
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("Expected error %q but got: %v", tc.err, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if len(d.Patches) != tc.patches {
+				t.Fatalf("Expected %d patches but got: %v", tc.patches, d.Patches)
+			}
+		})
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	d, err := Parse(util.MustUnmarshalJSON([]byte(`{"allowed": true, "patches": [
+		{"op": "add", "path": "/metadata/labels/owner", "value": "alice"},
+		{"op": "add", "path": "/metadata/annotations/x", "value": null},
+		{"op": "move", "from": "/a", "path": "/b"}
+	]}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bs, err := d.JSONPatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `[{"op":"add","path":"/metadata/labels/owner","value":"alice"},{"op":"add","path":"/metadata/annotations/x","value":null},{"op":"move","path":"/b","from":"/a"}]`
+	if string(bs) != exp {
+		t.Fatalf("Expected %v but got: %v", exp, string(bs))
+	}
+
+	bs, err = (&Decision{Allowed: true}).JSONPatch()
+	if err != nil || bs != nil {
+		t.Fatalf("Expected no patch but got: %v, %v", bs, err)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"sort"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/internal/future"
@@ -28,6 +29,9 @@ type Opts struct {
 
 	// RegoVersion is the version of Rego to format code for.
 	RegoVersion ast.RegoVersion
+
+	// Style configures the layout of the formatted code.
+	Style Style
 }
 
 // defaultLocationFile is the file name used in `Ast()` for terms
@@ -108,6 +112,10 @@ type fmtOpts struct {
 }
 
 func AstWithOpts(x interface{}, opts Opts) ([]byte, error) {
+	if err := opts.Style.Validate(); err != nil {
+		return nil, err
+	}
+
 	// The node has to be deep copied because it may be mutated below. Alternatively,
 	// we could avoid the copy by checking if mutation will occur first. For now,
 	// since format is not latency sensitive, just deep copy in all cases.
@@ -178,7 +186,8 @@ func AstWithOpts(x interface{}, opts Opts) ([]byte, error) {
 	})
 
 	w := &writer{
-		indent: "\t",
+		indent: opts.Style.indent(),
+		style:  opts.Style,
 		errs:   make([]*ast.Error, 0),
 	}
 
@@ -275,11 +284,16 @@ type writer struct {
 	buf bytes.Buffer
 
 	indent    string
+	style     Style
 	level     int
 	inline    bool
 	beforeEnd *ast.Comment
 	delay     bool
 	errs      ast.Errors
+
+	// keyWidth is the width keys of the object being written are padded to,
+	// if its values are aligned.
+	keyWidth int
 }
 
 func (w *writer) writeModule(module *ast.Module, o fmtOpts) {
@@ -924,9 +938,20 @@ func (w *writer) writeObject(obj ast.Object, loc *ast.Location, comments []*ast.
 	defer w.write("}")
 
 	var s []interface{}
+	keyWidth := 0
 	obj.Foreach(func(k, v *ast.Term) {
 		s = append(s, ast.Item(k, v))
+		if w.style.AlignObjectKeys && keyWidth >= 0 {
+			if n, ok := w.termWidth(k); ok && n > keyWidth {
+				keyWidth = n
+			} else if !ok {
+				keyWidth = -1 // multi-line keys are not aligned
+			}
+		}
 	})
+
+	defer func(width int) { w.keyWidth = width }(w.keyWidth)
+	w.keyWidth = max(keyWidth, 0)
 	return w.writeIterable(s, loc, closingLoc(0, 0, '{', '}', loc), comments, w.objectWriter())
 }
 
@@ -1034,7 +1059,23 @@ func (w *writer) writeComprehensionBody(open, close byte, body ast.Body, term, c
 func (w *writer) writeImports(imports []*ast.Import, comments []*ast.Comment) []*ast.Comment {
 	m, comments := mapImportsToComments(imports, comments)
 
-	groups := groupImports(imports)
+	var groups [][]*ast.Import
+	switch w.style.ImportGrouping {
+	case ImportGroupingSingle, ImportGroupingRoot:
+		// The comments preceding the imports are written first, as the
+		// groups do not follow the order of the source.
+		if len(imports) > 0 {
+			comments = w.insertComments(comments, imports[0].Loc())
+		}
+		if w.style.ImportGrouping == ImportGroupingSingle {
+			groups = [][]*ast.Import{imports}
+		} else {
+			groups = groupImportsByRoot(imports)
+		}
+	default:
+		groups = groupImports(imports)
+	}
+
 	for _, group := range groups {
 		comments = w.insertComments(comments, group[0].Loc())
 
@@ -1062,6 +1103,37 @@ type entryWriter func(interface{}, []*ast.Comment) []*ast.Comment
 
 func (w *writer) writeIterable(elements []interface{}, last *ast.Location, close *ast.Location, comments []*ast.Comment, fn entryWriter) []*ast.Comment {
 	lines := groupIterable(elements, last)
+
+	// Values of objects are only aligned if there is one key per line.
+	keyWidth := w.keyWidth
+	defer func() { w.keyWidth = keyWidth }()
+	w.keyWidth = 0
+
+	if len(lines) == 1 && w.style.MaxLineWidth > 0 && len(elements) > 1 {
+		// Write the elements on one line, and start over with one element
+		// per line if the line gets too long.
+		start, errs := w.buf.Len(), len(w.errs)
+		level, inline, beforeEnd, delay := w.level, w.inline, w.beforeEnd, w.delay
+
+		rest := w.writeIterableLine(lines[0], comments, fn)
+		if w.lineWidth(start) <= w.style.MaxLineWidth {
+			return rest
+		}
+
+		w.buf.Truncate(start)
+		w.errs = w.errs[:errs]
+		w.level, w.inline, w.beforeEnd, w.delay = level, inline, beforeEnd, delay
+
+		lines = make([][]interface{}, len(elements))
+		for i := range elements {
+			lines[i] = elements[i : i+1]
+		}
+	}
+
+	if len(lines) > 1 && len(lines) == len(elements) {
+		w.keyWidth = keyWidth
+	}
+
 	if len(lines) > 1 {
 		w.delayBeforeEnd()
 		w.startMultilineSeq()
@@ -1115,12 +1187,16 @@ func (w *writer) objectWriter() entryWriter {
 			w.write("(")
 		}
 
+		start := w.buf.Len()
 		comments = w.writeTerm(entry[0], comments)
 		if paren {
 			w.write(")")
 		}
 
 		w.write(": ")
+		if n := w.keyWidth - utf8.RuneCount(w.buf.Bytes()[start:]) + 2; n > 0 {
+			w.write(strings.Repeat(" ", n))
+		}
 
 		call, isCall = entry[1].Value.(ast.Call)
 		if isCall && ast.Or.Ref().Equal(call[0].Value) && entry[1].Location.Text[0] == 40 { // Starts with "("
@@ -1253,6 +1329,37 @@ func groupImports(imports []*ast.Import) [][]*ast.Import {
 	return groups
 }
 
+// groupImportsByRoot groups imports by their root document: rego and future
+// imports, input imports and data imports.
+func groupImportsByRoot(imports []*ast.Import) [][]*ast.Import {
+	groups := make([][]*ast.Import, 3)
+	for _, imp := range imports {
+		var root ast.Value
+		switch path := imp.Path.Value.(type) {
+		case ast.Ref:
+			root = path[0].Value
+		default:
+			root = path
+		}
+		switch {
+		case ast.InputRootDocument.Value.Compare(root) == 0:
+			groups[1] = append(groups[1], imp)
+		case ast.DefaultRootDocument.Value.Compare(root) == 0:
+			groups[2] = append(groups[2], imp)
+		default:
+			groups[0] = append(groups[0], imp)
+		}
+	}
+
+	result := groups[:0]
+	for _, group := range groups {
+		if len(group) > 0 {
+			result = append(result, group)
+		}
+	}
+	return result
+}
+
 func partitionComments(comments []*ast.Comment, l *ast.Location) (before []*ast.Comment, at *ast.Comment, after []*ast.Comment) {
 	for _, c := range comments {
 		switch cmp := c.Location.Row - l.Row; {
@@ -1399,6 +1506,30 @@ func skipPast(open, close byte, loc *ast.Location) (int, int) {
 	return i, offset
 }
 
+// termWidth returns the width of the term written on one line. False is
+// returned if the term spans multiple lines.
+func (w *writer) termWidth(t *ast.Term) (int, bool) {
+	tw := &writer{indent: w.indent, style: Style{IndentWidth: w.style.IndentWidth}}
+	tw.writeTerm(t, nil)
+	if bytes.ContainsRune(tw.buf.Bytes(), '\n') {
+		return 0, false
+	}
+	return utf8.RuneCount(tw.buf.Bytes()), true
+}
+
+// lineWidth returns the width of the line being written, if it was written
+// on one line since start. Tabs count as four characters.
+func (w *writer) lineWidth(start int) int {
+	bs := w.buf.Bytes()
+	if bytes.IndexByte(bs[start:], '\n') >= 0 {
+		return 0
+	}
+	if i := bytes.LastIndexByte(bs, '\n'); i >= 0 {
+		bs = bs[i+1:]
+	}
+	return utf8.RuneCount(bs) + 3*bytes.Count(bs, []byte("\t"))
+}
+
 // startLine begins a line with the current indentation level.
 func (w *writer) startLine() {
 	w.inline = true
@@ -14,6 +14,7 @@ import (
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/ast/location"
+	"github.com/open-policy-agent/opa/util/test"
 )
 
 func TestFormatNilLocation(t *testing.T) {
@@ -599,3 +600,165 @@ func prefixWithLineNumbers(bs []byte) []byte {
 	}
 	return []byte(strings.Join(lines, "\n"))
 }
+
+func TestFormatSourceWithStyle(t *testing.T) {
+	src := `package test
+
+import rego.v1
+import data.lib.b
+
+import input.user
+import data.lib.a
+
+# roles
+roles := ["admin", "editor", "viewer", "auditor"]
+
+config := {
+	"name": "x",
+	"max_retries": 3,
+	"timeout": {"connect": 1, "read_timeout": 10},
+}
+
+allow if {
+	input.user in roles
+}
+`
+
+	tests := []struct {
+		note  string
+		style Style
+		exp   string
+	}{
+		{
+			note: "default style",
+			exp: `package test
+
+import data.lib.b
+import rego.v1
+
+import data.lib.a
+import input.user
+
+# roles
+roles := ["admin", "editor", "viewer", "auditor"]
+
+config := {
+	"name": "x",
+	"max_retries": 3,
+	"timeout": {"connect": 1, "read_timeout": 10},
+}
+
+allow if {
+	input.user in roles
+}
+`,
+		},
+		{
+			note: "all styles",
+			style: Style{
+				MaxLineWidth:    40,
+				ImportGrouping:  ImportGroupingRoot,
+				AlignObjectKeys: true,
+				IndentWidth:     2,
+			},
+			exp: `package test
+
+import rego.v1
+
+import input.user
+
+import data.lib.a
+import data.lib.b
+
+# roles
+roles := [
+  "admin",
+  "editor",
+  "viewer",
+  "auditor",
+]
+
+config := {
+  "name":        "x",
+  "max_retries": 3,
+  "timeout":     {
+    "connect":      1,
+    "read_timeout": 10,
+  },
+}
+
+allow if {
+  input.user in roles
+}
+`,
+		},
+		{
+			note:  "single import group",
+			style: Style{ImportGrouping: ImportGroupingSingle},
+			exp: `package test
+
+import data.lib.a
+import data.lib.b
+import input.user
+import rego.v1
+
+# roles
+roles := ["admin", "editor", "viewer", "auditor"]
+
+config := {
+	"name": "x",
+	"max_retries": 3,
+	"timeout": {"connect": 1, "read_timeout": 10},
+}
+
+allow if {
+	input.user in roles
+}
+`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			bs, err := SourceWithOpts("test.rego", []byte(src), Opts{Style: tc.style})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(bs) != tc.exp {
+				t.Fatalf("expected:\n\n%v\n\ngot:\n\n%v", tc.exp, string(bs))
+			}
+
+			again, err := SourceWithOpts("test.rego", bs, Opts{Style: tc.style})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(again, bs) {
+				t.Fatalf("expected formatting to be idempotent, got:\n\n%v", string(again))
+			}
+		})
+	}
+}
+
+func TestLoadStyle(t *testing.T) {
+	test.WithTempFS(map[string]string{
+		StyleFile: `max_line_width: 100
+import_grouping: root
+align_object_keys: true
+indent_width: 4
+`,
+		"invalid.yaml": `import_grouping: alphabetical`,
+	}, func(root string) {
+		s, err := LoadStyle(filepath.Join(root, StyleFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp := Style{MaxLineWidth: 100, ImportGrouping: ImportGroupingRoot, AlignObjectKeys: true, IndentWidth: 4}
+		if s != exp {
+			t.Fatalf("expected %+v, got %+v", exp, s)
+		}
+
+		if _, err := LoadStyle(filepath.Join(root, "invalid.yaml")); err == nil || !strings.Contains(err.Error(), `invalid import grouping "alphabetical"`) {
+			t.Fatalf("expected invalid import grouping error, got %v", err)
+		}
+	})
+}
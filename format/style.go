@@ -0,0 +1,96 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package format
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/open-policy-agent/opa/util"
+)
+
+// StyleFile is the name of the file that 'opa fmt' loads the style from. The
+// file applies to the Rego files in its directory and all subdirectories.
+const StyleFile = ".opafmt.yaml"
+
+// Import grouping policies.
+const (
+	// ImportGroupingPreserve keeps the groups of imports, separated by blank
+	// lines, of the source. This is the default.
+	ImportGroupingPreserve = "preserve"
+
+	// ImportGroupingSingle writes all imports in one group.
+	ImportGroupingSingle = "single"
+
+	// ImportGroupingRoot groups imports by their root document: the rego and
+	// future imports first, followed by the input imports, and the data
+	// imports.
+	ImportGroupingRoot = "root"
+)
+
+// Style configures the layout of the formatted code. The zero value is the
+// default style.
+type Style struct {
+	// MaxLineWidth is the width of lines beyond which arrays, sets and
+	// objects are written with one element per line, even if they were
+	// written on one line in the source. Tabs count as four characters. If
+	// zero, the layout of the source is kept.
+	MaxLineWidth int `json:"max_line_width,omitempty"`
+
+	// ImportGrouping is the policy for grouping imports, one of
+	// ImportGroupingPreserve, ImportGroupingSingle and ImportGroupingRoot.
+	// Imports are sorted within each group.
+	ImportGrouping string `json:"import_grouping,omitempty"`
+
+	// AlignObjectKeys aligns the values of objects written with one key per
+	// line, by padding the keys to the width of the widest key.
+	AlignObjectKeys bool `json:"align_object_keys,omitempty"`
+
+	// IndentWidth is the number of spaces used to indent rule bodies and
+	// multi-line values. If zero, tabs are used.
+	IndentWidth int `json:"indent_width,omitempty"`
+}
+
+// LoadStyle reads a style from a YAML or JSON file, e.g., an .opafmt.yaml
+// file.
+func LoadStyle(path string) (Style, error) {
+	var s Style
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return s, err
+	}
+	if err := util.Unmarshal(bs, &s); err != nil {
+		return s, fmt.Errorf("%v: %w", path, err)
+	}
+	if err := s.Validate(); err != nil {
+		return s, fmt.Errorf("%v: %w", path, err)
+	}
+	return s, nil
+}
+
+// Validate returns an error if the style is invalid.
+func (s Style) Validate() error {
+	switch s.ImportGrouping {
+	case "", ImportGroupingPreserve, ImportGroupingSingle, ImportGroupingRoot:
+	default:
+		return fmt.Errorf("invalid import grouping %q: must be one of %v, %v or %v",
+			s.ImportGrouping, ImportGroupingPreserve, ImportGroupingSingle, ImportGroupingRoot)
+	}
+	if s.MaxLineWidth < 0 {
+		return fmt.Errorf("invalid max line width %d: must not be negative", s.MaxLineWidth)
+	}
+	if s.IndentWidth < 0 {
+		return fmt.Errorf("invalid indent width %d: must not be negative", s.IndentWidth)
+	}
+	return nil
+}
+
+func (s Style) indent() string {
+	if s.IndentWidth == 0 {
+		return "\t"
+	}
+	return strings.Repeat(" ", s.IndentWidth)
+}
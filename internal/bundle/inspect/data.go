@@ -0,0 +1,155 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package inspect
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/bundle"
+	"github.com/open-policy-agent/opa/internal/merge"
+	"github.com/open-policy-agent/opa/util"
+)
+
+// DataInfo describes the size and shape of a document in the data of a bundle.
+type DataInfo struct {
+	Path   string `json:"path"`
+	Size   int    `json:"size"`   // size of the document serialized as compact JSON, in bytes
+	Values int    `json:"values"` // number of values in the document, including the document itself
+	Depth  int    `json:"depth"`  // maximum nesting depth of the document, 0 for scalars
+}
+
+// Data returns the size and shape of the data of b, and of the documents down
+// to depth keys below it, ordered by decreasing size. The data of bundles read
+// in lazy loading mode is parsed from their raw data files.
+func Data(b *bundle.Bundle, depth int) ([]*DataInfo, error) {
+	data, err := bundleData(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []*DataInfo
+	measure(ast.DefaultRootRef.Copy(), data, depth, &infos)
+
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Size != infos[j].Size {
+			return infos[i].Size > infos[j].Size
+		}
+		return infos[i].Path < infos[j].Path
+	})
+
+	return infos, nil
+}
+
+// bundleData returns the data of b, which is kept in raw data files instead of
+// b.Data if b was read in lazy loading mode.
+func bundleData(b *bundle.Bundle) (map[string]interface{}, error) {
+	var data map[string]interface{}
+
+	for _, raw := range b.Raw {
+		base := path.Base(raw.Path)
+		if base != "data.json" && base != "data.yaml" && base != "data.yml" {
+			continue
+		}
+
+		var value interface{}
+		if err := util.Unmarshal(raw.Value, &value); err != nil {
+			return nil, fmt.Errorf("failed to parse %v: %w", raw.Path, err)
+		}
+
+		for _, k := range reverse(strings.Split(strings.Trim(path.Dir(raw.Path), "/"), "/")) {
+			if k != "" && k != "." {
+				value = map[string]interface{}{k: value}
+			}
+		}
+
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%v: data at the root of the bundle must be an object", raw.Path)
+		}
+
+		if data, ok = merge.InterfaceMaps(data, obj); !ok {
+			return nil, fmt.Errorf("%v: data conflicts with other data files", raw.Path)
+		}
+	}
+
+	if data == nil {
+		return b.Data, nil
+	}
+	return data, nil
+}
+
+func reverse(s []string) []string {
+	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = s[j], s[i]
+	}
+	return s
+}
+
+// measure adds the info of the document x at ref, and of the documents down
+// to depth keys below it, to infos.
+func measure(ref ast.Ref, x interface{}, depth int, infos *[]*DataInfo) *DataInfo {
+	var info *DataInfo
+	if depth >= 0 {
+		info = &DataInfo{Path: ref.String()}
+		*infos = append(*infos, info)
+	} else {
+		info = &DataInfo{}
+	}
+
+	info.Values = 1
+
+	switch x := x.(type) {
+	case map[string]interface{}:
+		info.Size = 2 + max(len(x)-1, 0) // braces and commas
+		for k, v := range x {
+			var child *DataInfo
+			if depth > 0 {
+				child = measure(ref.Append(ast.StringTerm(k)), v, depth-1, infos)
+			} else {
+				child = measure(nil, v, -1, infos)
+			}
+			info.Size += jsonSize(k) + 1 + child.Size
+			info.Values += child.Values
+			info.Depth = max(info.Depth, child.Depth+1)
+		}
+	case []interface{}:
+		info.Size = 2 + max(len(x)-1, 0) // brackets and commas
+		for _, v := range x {
+			child := measure(nil, v, -1, infos)
+			info.Size += child.Size
+			info.Values += child.Values
+			info.Depth = max(info.Depth, child.Depth+1)
+		}
+	default:
+		info.Size = jsonSize(x)
+	}
+
+	return info
+}
+
+func jsonSize(x interface{}) int {
+	switch x := x.(type) {
+	case nil:
+		return len("null")
+	case bool:
+		return len(strconv.FormatBool(x))
+	case json.Number:
+		return len(x)
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(x); err != nil {
+		return 0
+	}
+	return buf.Len() - 1 // trailing newline
+}
@@ -0,0 +1,64 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package inspect
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/open-policy-agent/opa/bundle"
+	"github.com/open-policy-agent/opa/internal/file/archive"
+	"github.com/open-policy-agent/opa/util"
+)
+
+func TestData(t *testing.T) {
+	files := [][2]string{
+		{"/.manifest", `{"roots": [""]}`},
+		{"/data.json", `{"top": true}`},
+		{"/roles/data.json", `{"admin": ["alice", "bob"], "dev": {"a": {"b": 1}}}`},
+		{"/users/data.yaml", `x: 1`},
+		{"/a.b/data.json", `{"c": "<>"}`},
+	}
+
+	exp := []*DataInfo{
+		{Path: "data", Size: 99, Values: 13, Depth: 4},
+		{Path: "data.roles", Size: 45, Values: 7, Depth: 3},
+		{Path: `data["a.b"]`, Size: 10, Values: 2, Depth: 1},
+		{Path: "data.users", Size: 7, Values: 2, Depth: 1},
+		{Path: "data.top", Size: 4, Values: 1, Depth: 0},
+	}
+
+	for _, lazy := range []bool{false, true} {
+		buf := archive.MustWriteTarGz(files)
+		b, err := bundle.NewReader(buf).WithLazyLoadingMode(lazy).Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		infos, err := Data(&b, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(infos, exp) {
+			t.Fatalf("Lazy loading mode %v, expected %v but got %v", lazy, string(util.MustMarshalJSON(exp)), string(util.MustMarshalJSON(infos)))
+		}
+	}
+}
+
+func TestDataConflict(t *testing.T) {
+	buf := archive.MustWriteTarGz([][2]string{
+		{"/data.json", `{"a": 1}`},
+		{"/a/data.json", `{"b": 2}`},
+	})
+	b, err := bundle.NewReader(buf).WithLazyLoadingMode(true).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Data(&b, 1); err == nil {
+		t.Fatal("Expected error")
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/open-policy-agent/opa/ast/json"
 	"github.com/open-policy-agent/opa/bundle"
 	initload "github.com/open-policy-agent/opa/internal/runtime/init"
+	"github.com/open-policy-agent/opa/ir"
 	"github.com/open-policy-agent/opa/loader"
 	"github.com/open-policy-agent/opa/util"
 )
@@ -28,13 +29,25 @@ type Info struct {
 	Namespaces  map[string][]string      `json:"namespaces,omitempty"`
 	Annotations []*ast.AnnotationsRef    `json:"annotations,omitempty"`
 	Required    *ast.Capabilities        `json:"capabilities,omitempty"`
+	Plans       []*PlanModule            `json:"plans,omitempty"`
+	Graph       *ast.DependencyGraph     `json:"graph,omitempty"`
+	Data        []*DataInfo              `json:"data,omitempty"`
+}
+
+// PlanModule represents a plan contained in a bundle.
+type PlanModule struct {
+	Path   string     `json:"path"`
+	Policy *ir.Policy `json:"plan"`
 }
 
 func File(path string, includeAnnotations bool) (*Info, error) {
-	return FileForRegoVersion(ast.RegoV0, path, includeAnnotations)
+	return FileForRegoVersion(ast.RegoV0, path, includeAnnotations, false, false, -1)
 }
 
-func FileForRegoVersion(regoVersion ast.RegoVersion, path string, includeAnnotations bool) (*Info, error) {
+// FileForRegoVersion returns information about the bundle at path. The size
+// and shape of the data is reported down to dataDepth keys below its root,
+// unless dataDepth is negative.
+func FileForRegoVersion(regoVersion ast.RegoVersion, path string, includeAnnotations bool, includePlans bool, includeGraph bool, dataDepth int) (*Info, error) {
 	b, err := loader.NewFileLoader().
 		WithRegoVersion(regoVersion).
 		WithSkipBundleVerification(true).
@@ -109,6 +122,23 @@ func FileForRegoVersion(regoVersion ast.RegoVersion, path string, includeAnnotat
 	}
 	bi.WasmModules = wasmModules
 
+	if includePlans {
+		for _, p := range b.PlanModules {
+			var policy ir.Policy
+			if err := util.UnmarshalJSON(p.Raw, &policy); err != nil {
+				return nil, fmt.Errorf("failed to parse plan %v: %w", p.Path, err)
+			}
+			bi.Plans = append(bi.Plans, &PlanModule{Path: p.Path, Policy: &policy})
+		}
+	}
+
+	if dataDepth >= 0 {
+		bi.Data, err = Data(b, dataDepth)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	moduleMap := make(map[string]*ast.Module, len(b.Modules))
 	for _, f := range b.Modules {
 		moduleMap[f.URL] = f.Parsed
@@ -123,6 +153,10 @@ func FileForRegoVersion(regoVersion ast.RegoVersion, path string, includeAnnotat
 
 	bi.Required = c.Required
 
+	if includeGraph {
+		bi.Graph = c.DependencyGraph()
+	}
+
 	return bi, nil
 }
 
@@ -0,0 +1,82 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package compilecache implements an on-disk cache for parsed modules, used
+// by commands that load policies, like 'opa build', 'opa test' and 'opa run'.
+// Entries are keyed by the hash of the module's name and contents, and of the
+// parser options. Entries of different OPA versions are kept apart.
+package compilecache
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/version"
+)
+
+// Cache stores entries as files in a directory. A nil *Cache is valid: it has
+// no entries, and discards the entries stored in it.
+type Cache struct {
+	dir string
+}
+
+// New returns a cache storing its entries in dir. The directory is created
+// when the first entry is stored.
+func New(dir string) *Cache {
+	return &Cache{dir: dir}
+}
+
+// Get returns the entry stored for key. If there is no entry for key, or it
+// cannot be read, false is returned.
+func (c *Cache) Get(key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	bs, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	return bs, true
+}
+
+// Put stores bs for key. The entry is written to a temporary file first and
+// renamed afterwards, so concurrent invocations never read partial entries.
+// Since the cache is an optimization, errors are ignored.
+func (c *Cache) Put(key string, bs []byte) {
+	_ = c.put(key, bs)
+}
+
+func (c *Cache) put(key string, bs []byte) error {
+	if c == nil {
+		return nil
+	}
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), key+".tmp*")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(bs)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, version.Version, key[:2], key)
+}
+
+var _ ast.ModuleCache = (*Cache)(nil)
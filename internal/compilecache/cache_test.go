@@ -0,0 +1,102 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package compilecache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/util/test"
+)
+
+func TestCacheGetPut(t *testing.T) {
+	test.WithTempFS(nil, func(root string) {
+		c := New(filepath.Join(root, "cache"))
+
+		key := "0123456789abcdef"
+		if _, ok := c.Get(key); ok {
+			t.Fatal("expected miss")
+		}
+
+		c.Put(key, []byte("foo"))
+		if bs, ok := c.Get(key); !ok || string(bs) != "foo" {
+			t.Fatalf("expected hit, got %q, %v", bs, ok)
+		}
+
+		// overwrite
+		c.Put(key, []byte("bar"))
+		if bs, ok := c.Get(key); !ok || string(bs) != "bar" {
+			t.Fatalf("expected hit, got %q, %v", bs, ok)
+		}
+	})
+}
+
+func TestCachePutError(t *testing.T) {
+	test.WithTempFS(map[string]string{"file": ""}, func(root string) {
+		// The cache directory cannot be created below a file.
+		c := New(filepath.Join(root, "file"))
+
+		c.Put("0123456789abcdef", []byte("foo"))
+		if _, ok := c.Get("0123456789abcdef"); ok {
+			t.Fatal("expected miss")
+		}
+	})
+}
+
+func TestNilCache(t *testing.T) {
+	var c *Cache
+	c.Put("0123456789abcdef", []byte("foo"))
+	if _, ok := c.Get("0123456789abcdef"); ok {
+		t.Fatal("expected miss")
+	}
+}
+
+func TestCacheParseModule(t *testing.T) {
+	test.WithTempFS(nil, func(root string) {
+		dir := filepath.Join(root, "cache")
+		popts := ast.ParserOptions{RegoVersion: ast.RegoV1, ModuleCache: New(dir)}
+		module := "package x\n\np contains 1 if input.y\n"
+
+		exp, err := ast.ParseModuleWithOpts("x.rego", module, popts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		entries, err := filepath.Glob(filepath.Join(dir, "*", "*", "*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 {
+			t.Fatalf("expected one entry, got %v", entries)
+		}
+
+		// A new cache reads the entry stored on disk.
+		popts.ModuleCache = New(dir)
+		act, err := ast.ParseModuleWithOpts("x.rego", module, popts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exp.Equal(act) {
+			t.Fatalf("expected:\n\n%v\n\ngot:\n\n%v", exp, act)
+		}
+
+		// Corrupt entries are replaced.
+		if err := os.WriteFile(entries[0], []byte("corrupt"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		act, err = ast.ParseModuleWithOpts("x.rego", module, popts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exp.Equal(act) {
+			t.Fatalf("expected:\n\n%v\n\ngot:\n\n%v", exp, act)
+		}
+		if bs, err := os.ReadFile(entries[0]); err != nil || string(bs) == "corrupt" {
+			t.Fatalf("expected entry to be replaced, got %q, %v", bs, err)
+		}
+	})
+}
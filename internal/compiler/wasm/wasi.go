@@ -0,0 +1,742 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package wasm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/open-policy-agent/opa/internal/leb128"
+	"github.com/open-policy-agent/opa/internal/wasm/instruction"
+	"github.com/open-policy-agent/opa/internal/wasm/module"
+	"github.com/open-policy-agent/opa/internal/wasm/types"
+	"github.com/open-policy-agent/opa/internal/wasm/util"
+)
+
+// NOTE(sr): A WASI module can be run by any WASI-compatible runtime, like
+// wasmtime or wasmer. It does not import anything from the "env" namespace:
+//
+// a. the host-provided functions (opa_abort, opa_builtin0, ...) are replaced
+//    by functions defined in the module,
+// b. the module has its own memory instead of importing it,
+// c. the exported `_start` function reads an evaluation request from stdin,
+//    evaluates the policy, and writes the result set to stdout.
+//
+// The request is a JSON object with the optional keys "input", "data", and
+// "entrypoint" (the entrypoint's name). If the entrypoint is not provided, the
+// first one is evaluated. Errors are written to stderr, and the process exits
+// with status 1.
+//
+// Policies that require built-in functions not natively implemented in Wasm
+// cannot be compiled for WASI, as there is no host to provide them.
+
+const (
+	wasiModule   = "wasi_snapshot_preview1"
+	wasiFdRead   = "fd_read"
+	wasiFdWrite  = "fd_write"
+	wasiProcExit = "proc_exit"
+
+	wasiStdin  = 0
+	wasiStdout = 1
+	wasiStderr = 2
+
+	wasiStart = "_start"
+
+	// initial size of the buffer holding the request, it's grown as needed
+	wasiReadBufferSize = 65536
+)
+
+const (
+	opaMalloc               = "opa_malloc"
+	opaRealloc              = "opa_realloc"
+	opaStrlen               = "opa_strlen"
+	opaJSONParse            = "opa_json_parse"
+	opaJSONDump             = "opa_json_dump"
+	opaNumberTryInt         = "opa_number_try_int"
+	opaEvalCtxNew           = "opa_eval_ctx_new"
+	opaEvalCtxSetInput      = "opa_eval_ctx_set_input"
+	opaEvalCtxSetData       = "opa_eval_ctx_set_data"
+	opaEvalCtxSetEntrypoint = "opa_eval_ctx_set_entrypoint"
+	opaEvalCtxGetResult     = "opa_eval_ctx_get_result"
+)
+
+var wasiImports = [...]struct {
+	name string
+	tpe  module.FunctionType
+}{
+	{
+		name: wasiFdRead,
+		tpe: module.FunctionType{
+			Params:  []types.ValueType{types.I32, types.I32, types.I32, types.I32},
+			Results: []types.ValueType{types.I32},
+		},
+	},
+	{
+		name: wasiFdWrite,
+		tpe: module.FunctionType{
+			Params:  []types.ValueType{types.I32, types.I32, types.I32, types.I32},
+			Results: []types.ValueType{types.I32},
+		},
+	},
+	{
+		name: wasiProcExit,
+		tpe: module.FunctionType{
+			Params: []types.ValueType{types.I32},
+		},
+	},
+}
+
+// null-terminated strings used by the WASI functions
+const (
+	wasiStrInput = iota
+	wasiStrData
+	wasiStrEntrypoint
+	wasiStrNewline
+	wasiStrReadFailed
+	wasiStrWriteFailed
+	wasiStrInvalidRequest
+	wasiStrUnknownEntrypoint
+)
+
+var wasiStrings = [...]string{
+	wasiStrInput:             "input",
+	wasiStrData:              "data",
+	wasiStrEntrypoint:        "entrypoint",
+	wasiStrNewline:           "\n",
+	wasiStrReadFailed:        "failed to read request from stdin",
+	wasiStrWriteFailed:       "failed to write result to stdout",
+	wasiStrInvalidRequest:    "invalid request: expected JSON object",
+	wasiStrUnknownEntrypoint: "invalid request: unknown entrypoint",
+}
+
+// wasiReplacedFunc is a host-provided function that is defined in the module
+// for WASI.
+type wasiReplacedFunc struct {
+	name string // name in the name section
+	imp  string // import name
+}
+
+// WithWASI sets whether the compiler emits a module for WASI runtimes instead
+// of a module implementing the OPA-specific ABI.
+func (c *Compiler) WithWASI(yes bool) *Compiler {
+	c.wasi = yes
+	return c
+}
+
+// replaceImportsForWASI replaces the function imports of the pre-compiled OPA
+// binary by the WASI imports. The previously imported functions are appended
+// to the module's functions, their code is emitted by compileWASIFuncs.
+func (c *Compiler) replaceImportsForWASI() error {
+	var replaced, rest []module.Import
+	for _, imp := range c.module.Import.Imports {
+		if _, ok := imp.Descriptor.(module.FunctionImport); ok {
+			replaced = append(replaced, imp)
+		} else {
+			rest = append(rest, imp)
+		}
+	}
+
+	// NOTE(sr): The functions are referred to by their names in the name
+	// section, which can differ from their import names (e.g. "opa_abort_").
+	names := make([]string, len(replaced))
+	for i, imp := range replaced {
+		names[i] = imp.Name
+	}
+	for _, nm := range c.module.Names.Functions {
+		if nm.Index < uint32(len(replaced)) {
+			names[nm.Index] = nm.Name
+		}
+	}
+
+	oldCount := uint32(len(replaced))
+	newCount := uint32(len(wasiImports))
+	defined := uint32(len(c.module.Function.TypeIndices))
+	remap := func(idx uint32) uint32 {
+		if idx < oldCount {
+			return newCount + defined + idx
+		}
+		return idx - oldCount + newCount
+	}
+
+	for i := range c.module.Code.Segments {
+		code, err := remapFuncIndices(c.module.Code.Segments[i].Code, remap)
+		if err != nil {
+			return fmt.Errorf("function %d: %w", i, err)
+		}
+		c.module.Code.Segments[i].Code = code
+	}
+	for i := range c.module.Element.Segments {
+		for j, idx := range c.module.Element.Segments[i].Indices {
+			c.module.Element.Segments[i].Indices[j] = remap(idx)
+		}
+	}
+	for i, exp := range c.module.Export.Exports {
+		if exp.Descriptor.Type == module.FunctionExportType {
+			c.module.Export.Exports[i].Descriptor.Index = remap(exp.Descriptor.Index)
+		}
+	}
+	if c.module.Start.FuncIndex != nil {
+		idx := remap(*c.module.Start.FuncIndex)
+		c.module.Start.FuncIndex = &idx
+	}
+	for i, nm := range c.module.Names.Functions {
+		c.module.Names.Functions[i].Index = remap(nm.Index)
+	}
+	for i, lnm := range c.module.Names.Locals {
+		c.module.Names.Locals[i].FuncIndex = remap(lnm.FuncIndex)
+	}
+	for name, idx := range c.funcs {
+		c.funcs[name] = remap(idx)
+	}
+
+	imports := make([]module.Import, 0, len(wasiImports)+len(rest))
+	for i, imp := range wasiImports {
+		imports = append(imports, module.Import{
+			Module:     wasiModule,
+			Name:       imp.name,
+			Descriptor: module.FunctionImport{Func: c.emitFunctionType(imp.tpe)},
+		})
+		c.funcs[imp.name] = uint32(i)
+		c.module.Names.Functions = append(c.module.Names.Functions, module.NameMap{
+			Index: uint32(i),
+			Name:  imp.name,
+		})
+	}
+	c.module.Import.Imports = append(imports, rest...)
+
+	for i, imp := range replaced {
+		c.module.Function.TypeIndices = append(c.module.Function.TypeIndices, imp.Descriptor.(module.FunctionImport).Func)
+		c.module.Code.Segments = append(c.module.Code.Segments, module.RawCodeSegment{})
+		c.wasiReplaced = append(c.wasiReplaced, wasiReplacedFunc{name: names[i], imp: imp.Name})
+	}
+
+	sort.Slice(c.module.Names.Functions, func(i, j int) bool {
+		return c.module.Names.Functions[i].Index < c.module.Names.Functions[j].Index
+	})
+
+	return nil
+}
+
+// addMemoryDeclForWASI ensures that the module's own memory is large enough
+// to hold all data segments. It must run after the last data segment has been
+// added.
+func (c *Compiler) addMemoryDeclForWASI() error {
+	offset, err := getLowestFreeDataSegmentOffset(c.module)
+	if err != nil {
+		return err
+	}
+
+	if len(c.module.Memory.Memories) != 1 {
+		return errors.New("expected exactly one memory in pre-compiled OPA binary")
+	}
+
+	if min := util.Pages(uint32(offset)); c.module.Memory.Memories[0].Lim.Min < min {
+		c.module.Memory.Memories[0].Lim.Min = min
+	}
+
+	return nil
+}
+
+// compileWASIStrings writes the strings used by the WASI functions into the
+// data section of the module.
+func (c *Compiler) compileWASIStrings() error {
+	if !c.wasi {
+		return nil
+	}
+
+	offset, err := getLowestFreeDataSegmentOffset(c.module)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	c.wasiStringAddrs = make([]int32, len(wasiStrings))
+
+	for i, s := range wasiStrings {
+		c.wasiStringAddrs[i] = int32(buf.Len()) + offset
+		buf.WriteString(s)
+		buf.WriteByte(0)
+	}
+
+	c.module.Data.Segments = append(c.module.Data.Segments, module.DataSegment{
+		Index: 0,
+		Offset: module.Expr{
+			Instrs: []instruction.Instruction{
+				instruction.I32Const{
+					Value: offset,
+				},
+			},
+		},
+		Init: buf.Bytes(),
+	})
+
+	return nil
+}
+
+// compileWASIFuncs generates the functions replacing the host-provided
+// functions, and the `_start` function.
+func (c *Compiler) compileWASIFuncs() error {
+	if !c.wasi {
+		return nil
+	}
+
+	for _, fn := range c.wasiReplaced {
+		var err error
+		if fn.imp == opaAbort {
+			err = c.compileWASIAbort(fn.name)
+		} else {
+			// NOTE(sr): Policies depending on host-provided built-in functions
+			// are rejected by compileExternalFuncDecls, so these are never called.
+			err = c.storeFunc(fn.name, &module.CodeEntry{
+				Func: module.Function{
+					Expr: module.Expr{
+						Instrs: []instruction.Instruction{instruction.Unreachable{}},
+					},
+				},
+			})
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := c.compileWASIStart(); err != nil {
+		return err
+	}
+
+	return c.addMemoryDeclForWASI()
+}
+
+// compileWASIAbort generates the replacement for the imported
+// `void opa_abort(const char *msg)`, writing msg to stderr and exiting with
+// status 1.
+func (c *Compiler) compileWASIAbort(name string) error {
+	c.code = &module.CodeEntry{}
+	c.nextLocal = 1 // msg
+
+	liov := c.genLocal()
+
+	c.appendInstrs(c.wasiMallocAligned(20))
+	c.appendInstr(instruction.SetLocal{Index: liov})
+	c.appendInstrs(c.wasiIOVec(liov, 0, []instruction.Instruction{
+		instruction.GetLocal{Index: 0},
+	}, []instruction.Instruction{
+		instruction.GetLocal{Index: 0},
+		instruction.Call{Index: c.function(opaStrlen)},
+	}))
+	c.appendInstrs(c.wasiIOVec(liov, 8, []instruction.Instruction{
+		instruction.I32Const{Value: c.wasiStringAddrs[wasiStrNewline]},
+	}, []instruction.Instruction{
+		instruction.I32Const{Value: 1},
+	}))
+	c.appendInstr(instruction.I32Const{Value: wasiStderr})
+	c.appendInstr(instruction.GetLocal{Index: liov})
+	c.appendInstr(instruction.I32Const{Value: 2})
+	c.appendInstr(instruction.GetLocal{Index: liov})
+	c.appendInstr(instruction.I32Const{Value: 16})
+	c.appendInstr(instruction.I32Add{})
+	c.appendInstr(instruction.Call{Index: c.function(wasiFdWrite)})
+	c.appendInstr(instruction.Drop{})
+	c.appendInstr(instruction.I32Const{Value: 1})
+	c.appendInstr(instruction.Call{Index: c.function(wasiProcExit)})
+	c.appendInstr(instruction.Unreachable{})
+
+	c.code.Func.Locals = []module.LocalDeclaration{
+		{
+			Count: c.nextLocal - 1,
+			Type:  types.I32,
+		},
+	}
+
+	return c.storeFunc(name, c.code)
+}
+
+// compileWASIStart generates the `_start` function: it reads the request from
+// stdin, evaluates the policy, and writes the JSON-encoded result set to stdout.
+func (c *Compiler) compileWASIStart() error {
+	c.code = &module.CodeEntry{}
+	c.nextLocal = 0
+
+	lbuf := c.genLocal()
+	lcap := c.genLocal()
+	llen := c.genLocal()
+	lscratch := c.genLocal() // iovec[2] + nread/nwritten + long long
+	ln := c.genLocal()
+	lreq := c.genLocal()
+	lctx := c.genLocal()
+	lval := c.genLocal()
+	lout := c.genLocal()
+
+	abort := func(str int) []instruction.Instruction {
+		return []instruction.Instruction{
+			instruction.I32Const{Value: c.wasiStringAddrs[str]},
+			instruction.Call{Index: c.function(opaAbort)},
+		}
+	}
+
+	get := func(key int) []instruction.Instruction {
+		return []instruction.Instruction{
+			instruction.GetLocal{Index: lreq},
+			instruction.I32Const{Value: c.wasiStringAddrs[key]},
+			instruction.Call{Index: c.function(opaStringTerminated)},
+			instruction.Call{Index: c.function(opaValueGet)},
+			instruction.TeeLocal{Index: lval},
+		}
+	}
+
+	c.appendInstr(instruction.I32Const{Value: wasiReadBufferSize})
+	c.appendInstr(instruction.TeeLocal{Index: lcap})
+	c.appendInstr(instruction.Call{Index: c.function(opaMalloc)})
+	c.appendInstr(instruction.SetLocal{Index: lbuf})
+	c.appendInstrs(c.wasiMallocAligned(24))
+	c.appendInstr(instruction.SetLocal{Index: lscratch})
+
+	// read stdin until EOF, growing the buffer when it's full
+	var read []instruction.Instruction
+	read = append(read,
+		instruction.GetLocal{Index: llen},
+		instruction.GetLocal{Index: lcap},
+		instruction.I32Eq{},
+		instruction.If{Instrs: []instruction.Instruction{
+			instruction.GetLocal{Index: lcap},
+			instruction.I32Const{Value: 2},
+			instruction.I32Mul{},
+			instruction.SetLocal{Index: lcap},
+			instruction.GetLocal{Index: lbuf},
+			instruction.GetLocal{Index: lcap},
+			instruction.Call{Index: c.function(opaRealloc)},
+			instruction.SetLocal{Index: lbuf},
+		}},
+	)
+	read = append(read, c.wasiIOVec(lscratch, 0, []instruction.Instruction{
+		instruction.GetLocal{Index: lbuf},
+		instruction.GetLocal{Index: llen},
+		instruction.I32Add{},
+	}, []instruction.Instruction{
+		instruction.GetLocal{Index: lcap},
+		instruction.GetLocal{Index: llen},
+		instruction.I32Sub{},
+	})...)
+	read = append(read,
+		instruction.I32Const{Value: wasiStdin},
+		instruction.GetLocal{Index: lscratch},
+		instruction.I32Const{Value: 1},
+		instruction.GetLocal{Index: lscratch},
+		instruction.I32Const{Value: 16},
+		instruction.I32Add{},
+		instruction.Call{Index: c.function(wasiFdRead)},
+		instruction.If{Instrs: abort(wasiStrReadFailed)},
+		instruction.GetLocal{Index: lscratch},
+		instruction.I32Load{Offset: 16, Align: 2},
+		instruction.TeeLocal{Index: ln},
+		instruction.I32Eqz{},
+		instruction.BrIf{Index: 1},
+		instruction.GetLocal{Index: llen},
+		instruction.GetLocal{Index: ln},
+		instruction.I32Add{},
+		instruction.SetLocal{Index: llen},
+		instruction.Br{Index: 0},
+	)
+	c.appendInstr(instruction.Block{Instrs: []instruction.Instruction{
+		instruction.Loop{Instrs: read},
+	}})
+
+	// parse the request
+	c.appendInstr(instruction.GetLocal{Index: lbuf})
+	c.appendInstr(instruction.GetLocal{Index: llen})
+	c.appendInstr(instruction.Call{Index: c.function(opaJSONParse)})
+	c.appendInstr(instruction.TeeLocal{Index: lreq})
+	c.appendInstr(instruction.I32Eqz{})
+	c.appendInstr(instruction.If{Instrs: abort(wasiStrInvalidRequest)})
+	c.appendInstr(instruction.GetLocal{Index: lreq})
+	c.appendInstr(instruction.Call{Index: c.function(opaValueType)})
+	c.appendInstr(instruction.I32Const{Value: opaTypeObject})
+	c.appendInstr(instruction.I32Ne{})
+	c.appendInstr(instruction.If{Instrs: abort(wasiStrInvalidRequest)})
+
+	c.appendInstr(instruction.Call{Index: c.function(opaEvalCtxNew)})
+	c.appendInstr(instruction.SetLocal{Index: lctx})
+
+	// input
+	c.appendInstrs(get(wasiStrInput))
+	c.appendInstr(instruction.If{Instrs: []instruction.Instruction{
+		instruction.GetLocal{Index: lctx},
+		instruction.GetLocal{Index: lval},
+		instruction.Call{Index: c.function(opaEvalCtxSetInput)},
+	}})
+
+	// data, defaults to an empty object
+	c.appendInstrs(get(wasiStrData))
+	c.appendInstr(instruction.I32Eqz{})
+	c.appendInstr(instruction.If{Instrs: []instruction.Instruction{
+		instruction.Call{Index: c.function(opaObject)},
+		instruction.SetLocal{Index: lval},
+	}})
+	c.appendInstr(instruction.GetLocal{Index: lctx})
+	c.appendInstr(instruction.GetLocal{Index: lval})
+	c.appendInstr(instruction.Call{Index: c.function(opaEvalCtxSetData)})
+
+	// entrypoint, looked up by name
+	ep := []instruction.Instruction{
+		instruction.Call{Index: c.function("entrypoints")},
+		instruction.GetLocal{Index: lval},
+		instruction.Call{Index: c.function(opaValueGet)},
+		instruction.TeeLocal{Index: lval},
+		instruction.I32Eqz{},
+		instruction.If{Instrs: abort(wasiStrUnknownEntrypoint)},
+		instruction.GetLocal{Index: lval},
+		instruction.GetLocal{Index: lscratch},
+		instruction.I32Const{Value: 16},
+		instruction.I32Add{},
+		instruction.Call{Index: c.function(opaNumberTryInt)},
+		instruction.If{Instrs: abort(wasiStrUnknownEntrypoint)},
+		instruction.GetLocal{Index: lctx},
+		instruction.GetLocal{Index: lscratch},
+		instruction.I32Load{Offset: 16, Align: 2},
+		instruction.Call{Index: c.function(opaEvalCtxSetEntrypoint)},
+	}
+	c.appendInstrs(get(wasiStrEntrypoint))
+	c.appendInstr(instruction.If{Instrs: ep})
+
+	// evaluate, and write the result set followed by a newline
+	c.appendInstr(instruction.GetLocal{Index: lctx})
+	c.appendInstr(instruction.Call{Index: c.function("eval")})
+	c.appendInstr(instruction.Drop{})
+	c.appendInstr(instruction.GetLocal{Index: lctx})
+	c.appendInstr(instruction.Call{Index: c.function(opaEvalCtxGetResult)})
+	c.appendInstr(instruction.Call{Index: c.function(opaJSONDump)})
+	c.appendInstr(instruction.SetLocal{Index: lout})
+	c.appendInstrs(c.wasiIOVec(lscratch, 0, []instruction.Instruction{
+		instruction.GetLocal{Index: lout},
+	}, []instruction.Instruction{
+		instruction.GetLocal{Index: lout},
+		instruction.Call{Index: c.function(opaStrlen)},
+	}))
+	c.appendInstrs(c.wasiIOVec(lscratch, 8, []instruction.Instruction{
+		instruction.I32Const{Value: c.wasiStringAddrs[wasiStrNewline]},
+	}, []instruction.Instruction{
+		instruction.I32Const{Value: 1},
+	}))
+	c.appendInstr(instruction.I32Const{Value: wasiStdout})
+	c.appendInstr(instruction.GetLocal{Index: lscratch})
+	c.appendInstr(instruction.I32Const{Value: 2})
+	c.appendInstr(instruction.GetLocal{Index: lscratch})
+	c.appendInstr(instruction.I32Const{Value: 16})
+	c.appendInstr(instruction.I32Add{})
+	c.appendInstr(instruction.Call{Index: c.function(wasiFdWrite)})
+	c.appendInstr(instruction.If{Instrs: abort(wasiStrWriteFailed)})
+
+	c.code.Func.Locals = []module.LocalDeclaration{
+		{
+			Count: c.nextLocal,
+			Type:  types.I32,
+		},
+	}
+
+	c.emitFunctionDecl(wasiStart, module.FunctionType{}, true)
+	return c.storeFunc(wasiStart, c.code)
+}
+
+// wasiMallocAligned returns the instructions allocating size bytes aligned
+// to 8 bytes: opa_malloc doesn't align its allocations, but WASI runtimes
+// reject unaligned iovec arrays and result pointers.
+func (c *Compiler) wasiMallocAligned(size int32) []instruction.Instruction {
+	return []instruction.Instruction{
+		instruction.I32Const{Value: size + 7},
+		instruction.Call{Index: c.function(opaMalloc)},
+		instruction.I32Const{Value: 7},
+		instruction.I32Add{},
+		instruction.I32Const{Value: -8},
+		instruction.I32And{},
+	}
+}
+
+// wasiIOVec returns the instructions storing a WASI iovec (buf, len) at the
+// given offset of the address held by local l.
+func (*Compiler) wasiIOVec(l uint32, offset int32, buf, length []instruction.Instruction) []instruction.Instruction {
+	instrs := []instruction.Instruction{instruction.GetLocal{Index: l}}
+	instrs = append(instrs, buf...)
+	instrs = append(instrs, instruction.I32Store{Offset: offset, Align: 2}, instruction.GetLocal{Index: l})
+	instrs = append(instrs, length...)
+	return append(instrs, instruction.I32Store{Offset: offset + 4, Align: 2})
+}
+
+// remapFuncIndices returns a copy of the raw code entry with the function
+// indices referenced by call and ref.func instructions replaced according to
+// remap. All other bytes are copied as-is.
+func remapFuncIndices(code []byte, remap func(uint32) uint32) ([]byte, error) {
+	r := &codeReader{code: code}
+
+	// locals
+	n, err := r.uleb()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < n; i++ {
+		if _, err := r.uleb(); err != nil {
+			return nil, err
+		}
+		if _, err := r.byte(); err != nil {
+			return nil, err
+		}
+	}
+
+	var out bytes.Buffer
+	last := 0
+
+	for r.pos < len(code) {
+		op, _ := r.byte()
+		switch {
+		case op == 0x10 || op == 0xD2: // call, ref.func
+			out.Write(code[last:r.pos])
+			idx, err := r.uleb()
+			if err != nil {
+				return nil, err
+			}
+			if err := leb128.WriteVarUint32(&out, remap(uint32(idx))); err != nil {
+				return nil, err
+			}
+			last = r.pos
+		case op == 0x02 || op == 0x03 || op == 0x04: // block, loop, if
+			err = r.blockType()
+		case op == 0x0C || op == 0x0D: // br, br_if
+			_, err = r.uleb()
+		case op == 0x0E: // br_table
+			err = r.vec(1)
+			if err == nil {
+				_, err = r.uleb()
+			}
+		case op == 0x11: // call_indirect
+			err = r.ulebs(2)
+		case op == 0x1C: // select t*
+			err = r.vec(1)
+		case op >= 0x20 && op <= 0x26: // local.*, global.*, table.get, table.set
+			_, err = r.uleb()
+		case op >= 0x28 && op <= 0x3E: // memory loads and stores
+			err = r.ulebs(2)
+		case op == 0x3F || op == 0x40: // memory.size, memory.grow
+			_, err = r.byte()
+		case op == 0x41 || op == 0x42: // i32.const, i64.const
+			_, err = r.uleb()
+		case op == 0x43: // f32.const
+			err = r.skip(4)
+		case op == 0x44: // f64.const
+			err = r.skip(8)
+		case op == 0xD0: // ref.null
+			_, err = r.byte()
+		case op == 0xFC:
+			err = r.miscImmediates()
+		case op == 0xFD:
+			err = errors.New("unsupported SIMD instruction")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("offset 0x%x: %w", r.pos, err)
+		}
+	}
+
+	out.Write(code[last:])
+	return out.Bytes(), nil
+}
+
+type codeReader struct {
+	code []byte
+	pos  int
+}
+
+func (r *codeReader) byte() (byte, error) {
+	if r.pos >= len(r.code) {
+		return 0, errors.New("unexpected end of code")
+	}
+	b := r.code[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *codeReader) skip(n int) error {
+	if r.pos+n > len(r.code) {
+		return errors.New("unexpected end of code")
+	}
+	r.pos += n
+	return nil
+}
+
+// uleb reads an unsigned LEB128 value. It is also used to skip over signed
+// values, which share the encoding's continuation bits.
+func (r *codeReader) uleb() (uint64, error) {
+	var result uint64
+	var shift uint
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		result |= uint64(b&0x7F) << shift
+		if b&0x80 == 0 {
+			return result, nil
+		}
+		shift += 7
+	}
+}
+
+func (r *codeReader) ulebs(n int) error {
+	for i := 0; i < n; i++ {
+		if _, err := r.uleb(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// vec skips a vector of elements of n LEB128 values each.
+func (r *codeReader) vec(n int) error {
+	count, err := r.uleb()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < count; i++ {
+		if err := r.ulebs(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *codeReader) blockType() error {
+	if r.pos >= len(r.code) {
+		return errors.New("unexpected end of code")
+	}
+	switch r.code[r.pos] {
+	case 0x40, 0x7F, 0x7E, 0x7D, 0x7C, 0x7B, 0x70, 0x6F: // empty, value types
+		r.pos++
+		return nil
+	}
+	_, err := r.uleb() // type index
+	return err
+}
+
+func (r *codeReader) miscImmediates() error {
+	op, err := r.uleb()
+	if err != nil {
+		return err
+	}
+	switch {
+	case op <= 7: // saturating truncations
+		return nil
+	case op == 8: // memory.init
+		return r.ulebs(2)
+	case op == 9, op == 13, op >= 15 && op <= 17: // data.drop, elem.drop, table.grow, table.size, table.fill
+		_, err = r.uleb()
+		return err
+	case op == 10: // memory.copy
+		return r.skip(2)
+	case op == 11: // memory.fill
+		return r.skip(1)
+	case op == 12, op == 14: // table.init, table.copy
+		return r.ulebs(2)
+	}
+	return fmt.Errorf("unsupported instruction 0xfc %d", op)
+}
@@ -0,0 +1,168 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+//go:build opa_wasm
+// +build opa_wasm
+
+package wasm
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	wasmtime "github.com/bytecodealliance/wasmtime-go/v3"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/internal/planner"
+	"github.com/open-policy-agent/opa/internal/wasm/encoding"
+)
+
+func TestCompilerWASIRun(t *testing.T) {
+	c := ast.MustCompileModules(map[string]string{
+		"test.rego": `package test
+
+allow {
+	input.user == data.admins[_]
+}
+
+count_roles := count(input.roles)`,
+	})
+
+	policy, err := planner.New().
+		WithQueries([]planner.QuerySet{
+			{
+				Name:          "test/allow",
+				Queries:       []ast.Body{ast.MustParseBody(`data.test.allow = result`)},
+				RewrittenVars: map[ast.Var]ast.Var{},
+			},
+			{
+				Name:          "test/count_roles",
+				Queries:       []ast.Body{ast.MustParseBody(`data.test.count_roles = result`)},
+				RewrittenVars: map[ast.Var]ast.Var{},
+			},
+		}).
+		WithModules([]*ast.Module{c.Modules["test.rego"]}).
+		WithBuiltinDecls(ast.BuiltinMap).
+		Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := New().WithPolicy(policy).WithWASI(true).Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := encoding.WriteModule(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+
+	engine := wasmtime.NewEngine()
+	mod, err := wasmtime.NewModule(engine, buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		note    string
+		request string
+		exp     string
+		expErr  string
+	}{
+		{
+			note:    "default entrypoint",
+			request: `{"input": {"user": "alice"}, "data": {"admins": ["alice"]}}`,
+			exp:     `[{"result":true}]`,
+		},
+		{
+			note:    "default entrypoint, undefined",
+			request: `{"input": {"user": "bob"}, "data": {"admins": ["alice"]}}`,
+			exp:     `[]`,
+		},
+		{
+			note:    "named entrypoint",
+			request: `{"input": {"roles": ["a", "b"]}, "entrypoint": "test/count_roles"}`,
+			exp:     `[{"result":2}]`,
+		},
+		{
+			note:    "large request",
+			request: `{"input": {"roles": [` + strings.Repeat(`"role",`, 20000) + `"role"]}, "entrypoint": "test/count_roles"}`,
+			exp:     `[{"result":20001}]`,
+		},
+		{
+			note:    "unknown entrypoint",
+			request: `{"entrypoint": "test/unknown"}`,
+			expErr:  "invalid request: unknown entrypoint",
+		},
+		{
+			note:    "invalid request",
+			request: `[]`,
+			expErr:  "invalid request: expected JSON object",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			dir := t.TempDir()
+			stdin := filepath.Join(dir, "stdin")
+			stdout := filepath.Join(dir, "stdout")
+			stderr := filepath.Join(dir, "stderr")
+			if err := os.WriteFile(stdin, []byte(tc.request), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := wasmtime.NewWasiConfig()
+			if err := cfg.SetStdinFile(stdin); err != nil {
+				t.Fatal(err)
+			}
+			if err := cfg.SetStdoutFile(stdout); err != nil {
+				t.Fatal(err)
+			}
+			if err := cfg.SetStderrFile(stderr); err != nil {
+				t.Fatal(err)
+			}
+
+			store := wasmtime.NewStore(engine)
+			store.SetWasi(cfg)
+			linker := wasmtime.NewLinker(engine)
+			if err := linker.DefineWasi(); err != nil {
+				t.Fatal(err)
+			}
+			instance, err := linker.Instantiate(store, mod)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, runErr := instance.GetFunc(store, "_start").Call(store)
+
+			out, err := os.ReadFile(stdout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			errOut, err := os.ReadFile(stderr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.expErr != "" {
+				if runErr == nil {
+					t.Fatalf("expected error, got output %s", out)
+				}
+				if act := strings.TrimSpace(string(errOut)); act != tc.expErr {
+					t.Fatalf("expected stderr %q, got %q", tc.expErr, act)
+				}
+				return
+			}
+			if runErr != nil {
+				t.Fatalf("unexpected error: %v (stderr: %s)", runErr, errOut)
+			}
+			if act := string(out); act != tc.exp+"\n" {
+				t.Fatalf("expected output %s, got %s", tc.exp, act)
+			}
+		})
+	}
+}
@@ -232,6 +232,10 @@ type Compiler struct {
 	lctx      uint32 // local pointing to eval context
 	lrs       uint32 // local pointing to result set
 
+	wasi            bool               // emit a module for WASI runtimes
+	wasiReplaced    []wasiReplacedFunc // host-provided functions replaced for WASI
+	wasiStringAddrs []int32            // addresses of null-terminated strings used by WASI functions
+
 	debug debug.Debug
 }
 
@@ -263,11 +267,13 @@ func New() *Compiler {
 	c.stages = []func() error{
 		c.initModule,
 		c.compileStringsAndBooleans,
+		c.compileWASIStrings,
 		c.addImportMemoryDecl,
 		c.compileExternalFuncDecls,
 		c.compileEntrypointDecls,
 		c.compileFuncs,
 		c.compilePlans,
+		c.compileWASIFuncs,
 		c.emitABIVersionGlobals,
 
 		// "local" optimizations
@@ -345,6 +351,12 @@ func (c *Compiler) initModule() error {
 		c.funcs[name] = fn.Index
 	}
 
+	if c.wasi {
+		if err := c.replaceImportsForWASI(); err != nil {
+			return err
+		}
+	}
+
 	for _, fn := range c.policy.Funcs.Funcs {
 
 		params := make([]types.ValueType, len(fn.Params))
@@ -431,7 +443,13 @@ func (c *Compiler) initModule() error {
 // (a) and (c) are taken care of here.
 //
 // In the future, we could change that, and here would be the place to do so.
+//
+// WASI modules keep their own memory, see addMemoryDeclForWASI.
 func (c *Compiler) addImportMemoryDecl() error {
+	if c.wasi {
+		return nil
+	}
+
 	offset, err := getLowestFreeDataSegmentOffset(c.module)
 	if err != nil {
 		return err
@@ -653,6 +671,9 @@ func (c *Compiler) compileExternalFuncDecls() error {
 
 	for index, decl := range c.policy.Static.BuiltinFuncs {
 		if _, ok := builtinsFunctions[decl.Name]; !ok {
+			if c.wasi {
+				return fmt.Errorf("built-in function %v is not supported in WASI modules", decl.Name)
+			}
 			c.appendInstr(instruction.GetLocal{Index: lobj})
 			c.appendInstr(instruction.I32Const{Value: c.externalFuncNameAddrs[decl.Name]})
 			c.appendInstr(instruction.Call{Index: c.function(opaStringTerminated)})
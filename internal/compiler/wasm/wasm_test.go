@@ -5,6 +5,7 @@
 package wasm
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/open-policy-agent/opa/ast"
@@ -93,3 +94,82 @@ func TestCompilerBadDataSegment(t *testing.T) {
 		t.Fatal("expected 106 but got:", result, "err:", err)
 	}
 }
+
+func TestCompilerWASI(t *testing.T) {
+
+	policy, err := planner.New().
+		WithQueries([]planner.QuerySet{
+			{
+				Name:    "test",
+				Queries: []ast.Body{ast.MustParseBody(`input.foo = 1`)},
+			},
+		}).Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := New().WithPolicy(policy).WithWASI(true).Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imports := map[string]bool{}
+	for _, imp := range m.Import.Imports {
+		if imp.Module != wasiModule {
+			t.Errorf("unexpected import %v.%v", imp.Module, imp.Name)
+		}
+		imports[imp.Name] = true
+	}
+	for _, name := range []string{wasiFdRead, wasiFdWrite, wasiProcExit} {
+		if !imports[name] {
+			t.Errorf("expected import %v.%v", wasiModule, name)
+		}
+	}
+
+	var found bool
+	for _, exp := range m.Export.Exports {
+		if exp.Name == wasiStart && exp.Descriptor.Type == module.FunctionExportType {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected %v export", wasiStart)
+	}
+}
+
+func TestCompilerWASIExternalBuiltins(t *testing.T) {
+
+	policy, err := planner.New().
+		WithQueries([]planner.QuerySet{
+			{
+				Name:    "test",
+				Queries: []ast.Body{ast.MustParseBody(`time.now_ns(x)`)},
+			},
+		}).
+		WithBuiltinDecls(ast.BuiltinMap).
+		Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = New().WithPolicy(policy).WithWASI(true).Compile()
+	if err == nil || err.Error() != "built-in function time.now_ns is not supported in WASI modules" {
+		t.Fatal("unexpected err:", err)
+	}
+}
+
+func TestRemapFuncIndices(t *testing.T) {
+
+	// no locals; call 1; i32.const 1; call 130 (multi-byte index); drop; end
+	code := []byte{0x00, 0x10, 0x01, 0x41, 0x01, 0x10, 0x82, 0x01, 0x1a, 0x0b}
+
+	result, err := remapFuncIndices(code, func(idx uint32) uint32 { return idx + 127 })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []byte{0x00, 0x10, 0x80, 0x01, 0x41, 0x01, 0x10, 0x81, 0x02, 0x1a, 0x0b}
+	if !bytes.Equal(result, exp) {
+		t.Fatalf("expected %x but got %x", exp, result)
+	}
+}
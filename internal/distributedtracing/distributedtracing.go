@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
 	"go.opentelemetry.io/otel"
@@ -62,6 +63,12 @@ type distributedTracingConfig struct {
 	TLSCertFile           string `json:"tls_cert_file,omitempty"`
 	TLSCertPrivateKeyFile string `json:"tls_private_key_file,omitempty"`
 	TLSCACertFile         string `json:"tls_ca_cert_file,omitempty"`
+
+	// BuiltinSpanThresholdMs is the minimum duration of the built-in function
+	// calls recorded as spans, unset if they are not recorded.
+	BuiltinSpanThresholdMs *int64 `json:"builtin_span_threshold_ms,omitempty"`
+
+	Metrics *metricsConfig `json:"metrics,omitempty"`
 }
 
 func Init(ctx context.Context, raw []byte, id string) (*otlptrace.Exporter, *trace.TracerProvider, error) {
@@ -117,6 +124,27 @@ func Init(ctx context.Context, raw []byte, id string) (*otlptrace.Exporter, *tra
 	return traceExporter, traceProvider, nil
 }
 
+// BuiltinSpanThreshold returns the minimum duration of the built-in function
+// calls recorded as spans, or zero if distributed tracing is disabled or the
+// calls are not recorded.
+func BuiltinSpanThreshold(raw []byte, id string) (time.Duration, error) {
+	parsedConfig, err := config.ParseConfig(raw, id)
+	if err != nil {
+		return 0, err
+	}
+
+	distributedTracingConfig, err := parseDistributedTracingConfig(parsedConfig.DistributedTracing)
+	if err != nil {
+		return 0, err
+	}
+
+	if strings.ToLower(distributedTracingConfig.Type) != "grpc" || distributedTracingConfig.BuiltinSpanThresholdMs == nil {
+		return 0, nil
+	}
+
+	return time.Duration(*distributedTracingConfig.BuiltinSpanThresholdMs) * time.Millisecond, nil
+}
+
 func SetupLogging(logger logging.Logger) {
 	otel.SetErrorHandler(&errorHandler{logger: logger})
 	otel.SetLogger(logr.New(&sink{logger: logger}))
@@ -183,6 +211,16 @@ func (c *distributedTracingConfig) validateAndInjectDefaults() error {
 		return fmt.Errorf("unsupported distributed_tracing.sample_percentage '%v'", *c.SampleRatePercentage)
 	}
 
+	if c.BuiltinSpanThresholdMs != nil && *c.BuiltinSpanThresholdMs <= 0 {
+		return fmt.Errorf("unsupported distributed_tracing.builtin_span_threshold_ms '%v'", *c.BuiltinSpanThresholdMs)
+	}
+
+	if c.Metrics != nil {
+		if err := c.Metrics.validateAndInjectDefaults(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -220,9 +258,22 @@ func loadCertPool(tlsCACertFile string) (*x509.CertPool, error) {
 }
 
 func tlsOption(encryptionScheme string, encryptionSkipVerify bool, cert *tls.Certificate, certPool *x509.CertPool) (otlptracegrpc.Option, error) {
-	if encryptionScheme == "off" {
+	tlsConfig, err := tlsConfig(encryptionScheme, encryptionSkipVerify, cert, certPool)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig == nil {
 		return otlptracegrpc.WithInsecure(), nil
 	}
+	return otlptracegrpc.WithTLSCredentials(credentials.NewTLS(tlsConfig)), nil
+}
+
+// tlsConfig returns the TLS configuration of the connections to the collector,
+// or nil if they are not encrypted.
+func tlsConfig(encryptionScheme string, encryptionSkipVerify bool, cert *tls.Certificate, certPool *x509.CertPool) (*tls.Config, error) {
+	if encryptionScheme == "off" {
+		return nil, nil
+	}
 	tlsConfig := &tls.Config{
 		RootCAs:            certPool,
 		InsecureSkipVerify: encryptionSkipVerify,
@@ -233,7 +284,7 @@ func tlsOption(encryptionScheme string, encryptionSkipVerify bool, cert *tls.Cer
 		}
 		tlsConfig.Certificates = []tls.Certificate{*cert}
 	}
-	return otlptracegrpc.WithTLSCredentials(credentials.NewTLS(tlsConfig)), nil
+	return tlsConfig, nil
 }
 
 type errorHandler struct {
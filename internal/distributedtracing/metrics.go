@@ -0,0 +1,391 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package distributedtracing
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/open-policy-agent/opa/config"
+	"github.com/open-policy-agent/opa/logging"
+	"github.com/open-policy-agent/opa/version"
+)
+
+const (
+	defaultMetricsIntervalSeconds = 60
+
+	// exportMetricsMethod is the method of the OTLP metrics service of the
+	// collector.
+	exportMetricsMethod = "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export"
+
+	// instrumentationScope is the name of the scope of the exported metrics.
+	instrumentationScope = "github.com/open-policy-agent/opa"
+)
+
+type metricsConfig struct {
+	IntervalSeconds *int64 `json:"interval_seconds,omitempty"`
+}
+
+func (c *metricsConfig) validateAndInjectDefaults() error {
+	if c.IntervalSeconds == nil {
+		interval := int64(defaultMetricsIntervalSeconds)
+		c.IntervalSeconds = &interval
+	} else if *c.IntervalSeconds <= 0 {
+		return fmt.Errorf("unsupported distributed_tracing.metrics.interval_seconds '%v'", *c.IntervalSeconds)
+	}
+	return nil
+}
+
+// MetricsExporter periodically exports the metrics of a Prometheus registry to
+// the OpenTelemetry collector with OTLP: counters as monotonic sums, gauges as
+// gauges, histograms and summaries as themselves.
+type MetricsExporter struct {
+	address     string
+	credentials credentials.TransportCredentials
+	interval    time.Duration
+	serviceName string
+	gatherer    prometheus.Gatherer
+	logger      logging.Logger
+
+	conn  *grpc.ClientConn
+	start time.Time
+	stop  chan struct{}
+	done  chan struct{}
+	mtx   sync.Mutex
+}
+
+// InitMetrics returns an exporter of the metrics gathered from g if the
+// distributed tracing and its metrics are enabled in the configuration, nil
+// otherwise. The exporter uses the same collector as the traces.
+func InitMetrics(raw []byte, id string, g prometheus.Gatherer, logger logging.Logger) (*MetricsExporter, error) {
+	parsedConfig, err := config.ParseConfig(raw, id)
+	if err != nil {
+		return nil, err
+	}
+
+	distributedTracingConfig, err := parseDistributedTracingConfig(parsedConfig.DistributedTracing)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.ToLower(distributedTracingConfig.Type) != "grpc" || distributedTracingConfig.Metrics == nil {
+		return nil, nil
+	}
+
+	certificate, err := loadCertificate(distributedTracingConfig.TLSCertFile, distributedTracingConfig.TLSCertPrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	certPool, err := loadCertPool(distributedTracingConfig.TLSCACertFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := tlsConfig(distributedTracingConfig.EncryptionScheme, *distributedTracingConfig.EncryptionSkipVerify, certificate, certPool)
+	if err != nil {
+		return nil, err
+	}
+
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	return &MetricsExporter{
+		address:     distributedTracingConfig.Address,
+		credentials: creds,
+		interval:    time.Duration(*distributedTracingConfig.Metrics.IntervalSeconds) * time.Second,
+		serviceName: distributedTracingConfig.ServiceName,
+		gatherer:    g,
+		logger:      logger,
+	}, nil
+}
+
+// Start connects to the collector and starts exporting the metrics
+// periodically.
+func (e *MetricsExporter) Start(context.Context) error {
+	conn, err := grpc.NewClient(e.address, grpc.WithTransportCredentials(e.credentials))
+	if err != nil {
+		return err
+	}
+
+	e.conn = conn
+	e.start = time.Now()
+	e.stop = make(chan struct{})
+	e.done = make(chan struct{})
+
+	go e.loop()
+
+	return nil
+}
+
+// Shutdown exports the metrics a last time and closes the connection to the
+// collector.
+func (e *MetricsExporter) Shutdown(ctx context.Context) error {
+	if e.conn == nil {
+		return nil
+	}
+
+	close(e.stop)
+	<-e.done
+
+	err := e.export(ctx)
+	if cerr := e.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (e *MetricsExporter) loop() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), e.interval)
+			if err := e.export(ctx); err != nil {
+				e.logger.Warn("Distributed tracing: failed to export metrics: %v", err)
+			}
+			cancel()
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+func (e *MetricsExporter) export(ctx context.Context) error {
+	// Exports of the loop and of the shutdown must not be interleaved.
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	families, err := e.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+
+	req := encodeExportMetricsRequest(families, e.serviceName, e.start, time.Now())
+
+	var resp []byte
+	return e.conn.Invoke(ctx, exportMetricsMethod, &req, &resp, grpc.ForceCodec(rawCodec{}))
+}
+
+// rawCodec passes the messages, already encoded, through as-is.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	bs, ok := v.(*[]byte)
+	if !ok {
+		return nil, fmt.Errorf("unexpected message type %T", v)
+	}
+	return *bs, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	bs, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("unexpected message type %T", v)
+	}
+	*bs = append((*bs)[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+// encodeExportMetricsRequest returns the protobuf encoding of the metric
+// families as an opentelemetry.proto.collector.metrics.v1.ExportMetricsServiceRequest
+// message, with a single resource and instrumentation scope.
+func encodeExportMetricsRequest(families []*dto.MetricFamily, serviceName string, start, now time.Time) []byte {
+	var metrics []byte
+	for _, f := range families {
+		if m := encodeMetric(f, uint64(start.UnixNano()), uint64(now.UnixNano())); m != nil {
+			metrics = appendMessage(metrics, 2, m)
+		}
+	}
+
+	// InstrumentationScope { string name = 1; string version = 2; }
+	var scope []byte
+	scope = appendString(scope, 1, instrumentationScope)
+	scope = appendString(scope, 2, version.Version)
+
+	// ScopeMetrics { InstrumentationScope scope = 1; repeated Metric metrics = 2; }
+	var scopeMetrics []byte
+	scopeMetrics = appendMessage(scopeMetrics, 1, scope)
+	scopeMetrics = append(scopeMetrics, metrics...)
+
+	// Resource { repeated KeyValue attributes = 1; }
+	var resource []byte
+	resource = appendMessage(resource, 1, encodeAttribute("service.name", serviceName))
+
+	// ResourceMetrics { Resource resource = 1; repeated ScopeMetrics scope_metrics = 2; }
+	var resourceMetrics []byte
+	resourceMetrics = appendMessage(resourceMetrics, 1, resource)
+	resourceMetrics = appendMessage(resourceMetrics, 2, scopeMetrics)
+
+	// ExportMetricsServiceRequest { repeated ResourceMetrics resource_metrics = 1; }
+	return appendMessage(nil, 1, resourceMetrics)
+}
+
+// Values of the AggregationTemporality enum.
+const aggregationTemporalityCumulative = 2
+
+// encodeMetric returns the encoding of the family as a Metric message, or nil
+// if its type is not supported.
+//
+//	Metric { string name = 1; string description = 2; oneof data { Gauge gauge = 5; Sum sum = 7; Histogram histogram = 9; Summary summary = 11; } }
+func encodeMetric(f *dto.MetricFamily, start, now uint64) []byte {
+	var data []byte
+	var field protowire.Number
+
+	switch f.GetType() {
+	case dto.MetricType_COUNTER:
+		// Sum { repeated NumberDataPoint data_points = 1; AggregationTemporality aggregation_temporality = 2; bool is_monotonic = 3; }
+		field = 7
+		for _, m := range f.GetMetric() {
+			data = appendMessage(data, 1, encodeNumberDataPoint(m, m.GetCounter().GetValue(), start, now))
+		}
+		data = protowire.AppendTag(data, 2, protowire.VarintType)
+		data = protowire.AppendVarint(data, aggregationTemporalityCumulative)
+		data = protowire.AppendTag(data, 3, protowire.VarintType)
+		data = protowire.AppendVarint(data, 1)
+	case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+		// Gauge { repeated NumberDataPoint data_points = 1; }
+		field = 5
+		for _, m := range f.GetMetric() {
+			v := m.GetGauge().GetValue()
+			if f.GetType() == dto.MetricType_UNTYPED {
+				v = m.GetUntyped().GetValue()
+			}
+			data = appendMessage(data, 1, encodeNumberDataPoint(m, v, start, now))
+		}
+	case dto.MetricType_HISTOGRAM:
+		// Histogram { repeated HistogramDataPoint data_points = 1; AggregationTemporality aggregation_temporality = 2; }
+		field = 9
+		for _, m := range f.GetMetric() {
+			data = appendMessage(data, 1, encodeHistogramDataPoint(m, start, now))
+		}
+		data = protowire.AppendTag(data, 2, protowire.VarintType)
+		data = protowire.AppendVarint(data, aggregationTemporalityCumulative)
+	case dto.MetricType_SUMMARY:
+		// Summary { repeated SummaryDataPoint data_points = 1; }
+		field = 11
+		for _, m := range f.GetMetric() {
+			data = appendMessage(data, 1, encodeSummaryDataPoint(m, start, now))
+		}
+	default:
+		return nil
+	}
+
+	var metric []byte
+	metric = appendString(metric, 1, f.GetName())
+	metric = appendString(metric, 2, f.GetHelp())
+	return appendMessage(metric, field, data)
+}
+
+// NumberDataPoint { fixed64 start_time_unix_nano = 2; fixed64 time_unix_nano = 3; double as_double = 4; repeated KeyValue attributes = 7; }
+func encodeNumberDataPoint(m *dto.Metric, v float64, start, now uint64) []byte {
+	var dp []byte
+	dp = appendFixed64(dp, 2, start)
+	dp = appendFixed64(dp, 3, now)
+	dp = appendFixed64(dp, 4, math.Float64bits(v))
+	return appendAttributes(dp, 7, m.GetLabel())
+}
+
+// HistogramDataPoint { fixed64 start_time_unix_nano = 2; fixed64 time_unix_nano = 3; fixed64 count = 4; double sum = 5;
+// repeated fixed64 bucket_counts = 6; repeated double explicit_bounds = 7; repeated KeyValue attributes = 9; }
+//
+// The bucket counts of Prometheus are cumulative, those of OTLP are not, and
+// there is one more of them, for the values above the last bound.
+func encodeHistogramDataPoint(m *dto.Metric, start, now uint64) []byte {
+	h := m.GetHistogram()
+
+	var counts, bounds []byte
+	var prev uint64
+	for _, b := range h.GetBucket() {
+		if math.IsInf(b.GetUpperBound(), +1) {
+			continue
+		}
+		counts = protowire.AppendFixed64(counts, b.GetCumulativeCount()-prev)
+		bounds = protowire.AppendFixed64(bounds, math.Float64bits(b.GetUpperBound()))
+		prev = b.GetCumulativeCount()
+	}
+	counts = protowire.AppendFixed64(counts, h.GetSampleCount()-prev)
+
+	var dp []byte
+	dp = appendFixed64(dp, 2, start)
+	dp = appendFixed64(dp, 3, now)
+	dp = appendFixed64(dp, 4, h.GetSampleCount())
+	dp = appendFixed64(dp, 5, math.Float64bits(h.GetSampleSum()))
+	dp = appendMessage(dp, 6, counts)
+	if len(bounds) > 0 {
+		dp = appendMessage(dp, 7, bounds)
+	}
+	return appendAttributes(dp, 9, m.GetLabel())
+}
+
+// SummaryDataPoint { fixed64 start_time_unix_nano = 2; fixed64 time_unix_nano = 3; fixed64 count = 4; double sum = 5;
+// repeated ValueAtQuantile quantile_values = 6; repeated KeyValue attributes = 7; }
+func encodeSummaryDataPoint(m *dto.Metric, start, now uint64) []byte {
+	s := m.GetSummary()
+
+	var dp []byte
+	dp = appendFixed64(dp, 2, start)
+	dp = appendFixed64(dp, 3, now)
+	dp = appendFixed64(dp, 4, s.GetSampleCount())
+	dp = appendFixed64(dp, 5, math.Float64bits(s.GetSampleSum()))
+	for _, q := range s.GetQuantile() {
+		// ValueAtQuantile { double quantile = 1; double value = 2; }
+		var v []byte
+		v = appendFixed64(v, 1, math.Float64bits(q.GetQuantile()))
+		v = appendFixed64(v, 2, math.Float64bits(q.GetValue()))
+		dp = appendMessage(dp, 6, v)
+	}
+	return appendAttributes(dp, 7, m.GetLabel())
+}
+
+func appendAttributes(b []byte, field protowire.Number, labels []*dto.LabelPair) []byte {
+	for _, l := range labels {
+		b = appendMessage(b, field, encodeAttribute(l.GetName(), l.GetValue()))
+	}
+	return b
+}
+
+// KeyValue { string key = 1; AnyValue value = 2; }, AnyValue { string string_value = 1; }
+func encodeAttribute(key, value string) []byte {
+	var kv []byte
+	kv = appendString(kv, 1, key)
+	return appendMessage(kv, 2, appendString(nil, 1, value))
+}
+
+func appendMessage(b []byte, field protowire.Number, m []byte) []byte {
+	b = protowire.AppendTag(b, field, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
+
+func appendString(b []byte, field protowire.Number, s string) []byte {
+	b = protowire.AppendTag(b, field, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendFixed64(b []byte, field protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, field, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, v)
+}
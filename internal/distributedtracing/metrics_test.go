@@ -0,0 +1,204 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package distributedtracing
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/open-policy-agent/opa/logging"
+)
+
+func TestInitMetrics(t *testing.T) {
+	g := prometheus.NewRegistry()
+
+	for _, tc := range []struct {
+		config  string
+		enabled bool
+		err     string
+	}{
+		{config: `{}`},
+		{config: `{"distributed_tracing": {"metrics": {}}}`},
+		{config: `{"distributed_tracing": {"type": "grpc"}}`},
+		{config: `{"distributed_tracing": {"type": "grpc", "metrics": {}}}`, enabled: true},
+		{
+			config: `{"distributed_tracing": {"type": "grpc", "metrics": {"interval_seconds": 0}}}`,
+			err:    "unsupported distributed_tracing.metrics.interval_seconds '0'",
+		},
+	} {
+		e, err := InitMetrics([]byte(tc.config), "test", g, logging.NewNoOpLogger())
+		if tc.err != "" {
+			if err == nil || err.Error() != tc.err {
+				t.Fatalf("%v: expected error %q but got: %v", tc.config, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", tc.config, err)
+		}
+		if (e != nil) != tc.enabled {
+			t.Fatalf("%v: expected exporter enabled: %v but got %v", tc.config, tc.enabled, e)
+		}
+		if e != nil && e.interval.Seconds() != defaultMetricsIntervalSeconds {
+			t.Fatalf("%v: expected default interval but got %v", tc.config, e.interval)
+		}
+	}
+}
+
+func TestMetricsExporterExport(t *testing.T) {
+	ch := make(chan []byte, 1)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := grpc.NewServer(
+		grpc.ForceServerCodec(rawCodec{}),
+		grpc.UnknownServiceHandler(func(_ interface{}, stream grpc.ServerStream) error {
+			if method, _ := grpc.MethodFromServerStream(stream); method != exportMetricsMethod {
+				return fmt.Errorf("unexpected method %v", method)
+			}
+			var req []byte
+			if err := stream.RecvMsg(&req); err != nil {
+				return err
+			}
+			ch <- req
+			resp := []byte{}
+			return stream.SendMsg(&resp)
+		}),
+	)
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Stop()
+
+	g := prometheus.NewRegistry()
+
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_counter", Help: "A counter."}, []string{"result"})
+	counter.WithLabelValues("hit").Add(2)
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_histogram", Buckets: []float64{1, 10}})
+	histogram.Observe(0.5)
+	histogram.Observe(5)
+	histogram.Observe(50)
+	g.MustRegister(counter, histogram)
+
+	config := fmt.Sprintf(`{"distributed_tracing": {"type": "grpc", "address": %q, "service_name": "opa-test", "metrics": {}}}`, l.Addr().String())
+
+	e, err := InitMetrics([]byte(config), "test", g, logging.NewNoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	if err := e.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Shutdown exports the metrics a last time.
+	if err := e.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	req := <-ch
+
+	resourceMetrics := fields(t, req)[1]
+	resource := fields(t, resourceMetrics[0])[1]
+	scopeMetrics := fields(t, fields(t, resourceMetrics[0])[2][0])
+
+	if attr := fields(t, fields(t, resource[0])[1][0]); string(attr[1][0]) != "service.name" || string(fields(t, attr[2][0])[1][0]) != "opa-test" {
+		t.Fatalf("Unexpected resource attribute %v", attr)
+	}
+
+	if scope := fields(t, scopeMetrics[1][0]); string(scope[1][0]) != instrumentationScope {
+		t.Fatalf("Unexpected scope %v", scope)
+	}
+
+	metrics := scopeMetrics[2]
+	if len(metrics) != 2 {
+		t.Fatalf("Expected 2 metrics but got %d", len(metrics))
+	}
+
+	// Counters are cumulative, monotonic sums.
+	c := fields(t, metrics[0])
+	if string(c[1][0]) != "test_counter" || string(c[2][0]) != "A counter." {
+		t.Fatalf("Unexpected counter %v", c)
+	}
+	sum := fields(t, c[7][0])
+	if sum[2][0][0] != aggregationTemporalityCumulative || sum[3][0][0] != 1 {
+		t.Fatalf("Unexpected sum %v", sum)
+	}
+	dp := fields(t, sum[1][0])
+	if v := math.Float64frombits(fixed64s(dp[4][0])[0]); v != 2 {
+		t.Fatalf("Expected counter value 2 but got %v", v)
+	}
+	if attr := fields(t, dp[7][0]); string(attr[1][0]) != "result" || string(fields(t, attr[2][0])[1][0]) != "hit" {
+		t.Fatalf("Unexpected data point attribute %v", attr)
+	}
+
+	// Histogram bucket counts are not cumulative.
+	h := fields(t, metrics[1])
+	hdp := fields(t, fields(t, h[9][0])[1][0])
+	if count := fixed64s(hdp[4][0])[0]; count != 3 {
+		t.Fatalf("Expected count 3 but got %v", count)
+	}
+	if s := math.Float64frombits(fixed64s(hdp[5][0])[0]); s != 55.5 {
+		t.Fatalf("Expected sum 55.5 but got %v", s)
+	}
+	if counts := fixed64s(hdp[6][0]); !reflect.DeepEqual(counts, []uint64{1, 1, 1}) {
+		t.Fatalf("Unexpected bucket counts %v", counts)
+	}
+	if bounds := fixed64s(hdp[7][0]); !reflect.DeepEqual(bounds, []uint64{math.Float64bits(1), math.Float64bits(10)}) {
+		t.Fatalf("Unexpected bounds %v", bounds)
+	}
+}
+
+// fields returns the values of the fields of the message by number. The values
+// of length-delimited fields are their contents, those of the other fields
+// their encoding.
+func fields(t *testing.T, bs []byte) map[protowire.Number][][]byte {
+	t.Helper()
+
+	result := map[protowire.Number][][]byte{}
+	for len(bs) > 0 {
+		num, typ, n := protowire.ConsumeTag(bs)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		bs = bs[n:]
+
+		var v []byte
+		if typ == protowire.BytesType {
+			v, n = protowire.ConsumeBytes(bs)
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, bs)
+			v = bs[:n]
+		}
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		bs = bs[n:]
+
+		result[num] = append(result[num], v)
+	}
+	return result
+}
+
+func fixed64s(bs []byte) []uint64 {
+	var result []uint64
+	for len(bs) > 0 {
+		v, n := protowire.ConsumeFixed64(bs)
+		result = append(result, v)
+		bs = bs[n:]
+	}
+	return result
+}
@@ -0,0 +1,86 @@
+package jwa
+
+import (
+	"errors"
+	"fmt"
+)
+
+// KeyEncryptionAlgorithm represents the key management algorithms as described in https://tools.ietf.org/html/rfc7518#section-4.1
+type KeyEncryptionAlgorithm string
+
+var keyEncryptionAlg = map[string]struct{}{"A128KW": {}, "A192KW": {}, "A256KW": {}, "RSA-OAEP": {}, "RSA-OAEP-256": {}, "dir": {}}
+
+// Supported values for KeyEncryptionAlgorithm
+const (
+	A128KW     KeyEncryptionAlgorithm = "A128KW"       // AES Key Wrap with default initial value using 128-bit key
+	A192KW     KeyEncryptionAlgorithm = "A192KW"       // AES Key Wrap with default initial value using 192-bit key
+	A256KW     KeyEncryptionAlgorithm = "A256KW"       // AES Key Wrap with default initial value using 256-bit key
+	Direct     KeyEncryptionAlgorithm = "dir"          // Direct use of a shared symmetric key as the CEK
+	RSAOAEP    KeyEncryptionAlgorithm = "RSA-OAEP"     // RSAES OAEP using default parameters
+	RSAOAEP256 KeyEncryptionAlgorithm = "RSA-OAEP-256" // RSAES OAEP using SHA-256 and MGF1 with SHA-256
+)
+
+// Accept is used when conversion from values given by
+// outside sources (such as JSON payloads) is required
+func (alg *KeyEncryptionAlgorithm) Accept(value interface{}) error {
+	var tmp KeyEncryptionAlgorithm
+	switch x := value.(type) {
+	case string:
+		tmp = KeyEncryptionAlgorithm(x)
+	case KeyEncryptionAlgorithm:
+		tmp = x
+	default:
+		return fmt.Errorf("invalid type for jwa.KeyEncryptionAlgorithm: %T", value)
+	}
+	_, ok := keyEncryptionAlg[tmp.String()]
+	if !ok {
+		return errors.New("unknown key encryption algorithm")
+	}
+	*alg = tmp
+	return nil
+}
+
+// String returns the string representation of a KeyEncryptionAlgorithm
+func (alg KeyEncryptionAlgorithm) String() string {
+	return string(alg)
+}
+
+// ContentEncryptionAlgorithm represents the content encryption algorithms as described in https://tools.ietf.org/html/rfc7518#section-5.1
+type ContentEncryptionAlgorithm string
+
+var contentEncryptionAlg = map[string]struct{}{"A128CBC-HS256": {}, "A192CBC-HS384": {}, "A256CBC-HS512": {}, "A128GCM": {}, "A192GCM": {}, "A256GCM": {}}
+
+// Supported values for ContentEncryptionAlgorithm
+const (
+	A128CBCHS256 ContentEncryptionAlgorithm = "A128CBC-HS256" // AES_128_CBC_HMAC_SHA_256 authenticated encryption
+	A192CBCHS384 ContentEncryptionAlgorithm = "A192CBC-HS384" // AES_192_CBC_HMAC_SHA_384 authenticated encryption
+	A256CBCHS512 ContentEncryptionAlgorithm = "A256CBC-HS512" // AES_256_CBC_HMAC_SHA_512 authenticated encryption
+	A128GCM      ContentEncryptionAlgorithm = "A128GCM"       // AES GCM using 128-bit key
+	A192GCM      ContentEncryptionAlgorithm = "A192GCM"       // AES GCM using 192-bit key
+	A256GCM      ContentEncryptionAlgorithm = "A256GCM"       // AES GCM using 256-bit key
+)
+
+// Accept is used when conversion from values given by
+// outside sources (such as JSON payloads) is required
+func (enc *ContentEncryptionAlgorithm) Accept(value interface{}) error {
+	var tmp ContentEncryptionAlgorithm
+	switch x := value.(type) {
+	case string:
+		tmp = ContentEncryptionAlgorithm(x)
+	case ContentEncryptionAlgorithm:
+		tmp = x
+	default:
+		return fmt.Errorf("invalid type for jwa.ContentEncryptionAlgorithm: %T", value)
+	}
+	_, ok := contentEncryptionAlg[tmp.String()]
+	if !ok {
+		return errors.New("unknown content encryption algorithm")
+	}
+	*enc = tmp
+	return nil
+}
+
+// String returns the string representation of a ContentEncryptionAlgorithm
+func (enc ContentEncryptionAlgorithm) String() string {
+	return string(enc)
+}
@@ -0,0 +1,168 @@
+package jwe
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+
+	"github.com/open-policy-agent/opa/internal/jwx/jwa"
+)
+
+var errDecrypt = errors.New("failed to decrypt content")
+
+// contentCipher encrypts and decrypts the content of JWEs with a content
+// encryption key.
+type contentCipher interface {
+	keySize() int
+	ivSize() int
+	encrypt(cek, iv, plaintext, aad []byte) (ciphertext, tag []byte, err error)
+	decrypt(cek, iv, ciphertext, tag, aad []byte) ([]byte, error)
+}
+
+func newContentCipher(enc jwa.ContentEncryptionAlgorithm) (contentCipher, error) {
+	switch enc {
+	case jwa.A128GCM:
+		return gcmCipher{size: 16}, nil
+	case jwa.A192GCM:
+		return gcmCipher{size: 24}, nil
+	case jwa.A256GCM:
+		return gcmCipher{size: 32}, nil
+	case jwa.A128CBCHS256:
+		return cbcHMACCipher{size: 32, hash: sha256.New}, nil
+	case jwa.A192CBCHS384:
+		return cbcHMACCipher{size: 48, hash: sha512.New384}, nil
+	case jwa.A256CBCHS512:
+		return cbcHMACCipher{size: 64, hash: sha512.New}, nil
+	}
+	return nil, fmt.Errorf("unsupported content encryption algorithm: %s", enc)
+}
+
+// gcmCipher implements AES GCM as described in https://tools.ietf.org/html/rfc7518#section-5.3
+type gcmCipher struct {
+	size int
+}
+
+func (c gcmCipher) keySize() int {
+	return c.size
+}
+
+func (gcmCipher) ivSize() int {
+	return 12
+}
+
+func (c gcmCipher) encrypt(cek, iv, plaintext, aad []byte) ([]byte, []byte, error) {
+	aead, err := c.aead(cek)
+	if err != nil {
+		return nil, nil, err
+	}
+	sealed := aead.Seal(nil, iv, plaintext, aad)
+	n := len(sealed) - aead.Overhead()
+	return sealed[:n], sealed[n:], nil
+}
+
+func (c gcmCipher) decrypt(cek, iv, ciphertext, tag, aad []byte) ([]byte, error) {
+	aead, err := c.aead(cek)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != aead.NonceSize() || len(tag) != aead.Overhead() {
+		return nil, errDecrypt
+	}
+	sealed := make([]byte, 0, len(ciphertext)+len(tag))
+	sealed = append(append(sealed, ciphertext...), tag...)
+	plaintext, err := aead.Open(nil, iv, sealed, aad)
+	if err != nil {
+		return nil, errDecrypt
+	}
+	return plaintext, nil
+}
+
+func (c gcmCipher) aead(cek []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// cbcHMACCipher implements AES CBC with HMAC SHA-2 as described in https://tools.ietf.org/html/rfc7518#section-5.2
+type cbcHMACCipher struct {
+	size int
+	hash func() hash.Hash
+}
+
+func (c cbcHMACCipher) keySize() int {
+	return c.size
+}
+
+func (cbcHMACCipher) ivSize() int {
+	return aes.BlockSize
+}
+
+func (c cbcHMACCipher) encrypt(cek, iv, plaintext, aad []byte) ([]byte, []byte, error) {
+	macKey, encKey := cek[:c.size/2], cek[c.size/2:]
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// PKCS #7 padding
+	pad := aes.BlockSize - len(plaintext)%aes.BlockSize
+	ciphertext := make([]byte, len(plaintext)+pad)
+	copy(ciphertext, plaintext)
+	for i := len(plaintext); i < len(ciphertext); i++ {
+		ciphertext[i] = byte(pad)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+
+	return ciphertext, c.tag(macKey, iv, ciphertext, aad), nil
+}
+
+func (c cbcHMACCipher) decrypt(cek, iv, ciphertext, tag, aad []byte) ([]byte, error) {
+	macKey, encKey := cek[:c.size/2], cek[c.size/2:]
+	if len(iv) != aes.BlockSize || len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errDecrypt
+	}
+	// The tag is checked before decrypting, so that padding errors do not
+	// reveal anything about forged ciphertexts.
+	if subtle.ConstantTimeCompare(tag, c.tag(macKey, iv, ciphertext, aad)) != 1 {
+		return nil, errDecrypt
+	}
+
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+
+	pad := int(plaintext[len(plaintext)-1])
+	if pad == 0 || pad > aes.BlockSize {
+		return nil, errDecrypt
+	}
+	for _, b := range plaintext[len(plaintext)-pad:] {
+		if int(b) != pad {
+			return nil, errDecrypt
+		}
+	}
+	return plaintext[:len(plaintext)-pad], nil
+}
+
+func (c cbcHMACCipher) tag(macKey, iv, ciphertext, aad []byte) []byte {
+	var al [8]byte
+	binary.BigEndian.PutUint64(al[:], uint64(len(aad))*8)
+
+	mac := hmac.New(c.hash, macKey)
+	mac.Write(aad)
+	mac.Write(iv)
+	mac.Write(ciphertext)
+	mac.Write(al[:])
+	return mac.Sum(nil)[:c.size/2]
+}
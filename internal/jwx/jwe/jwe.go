@@ -0,0 +1,274 @@
+// Package jwe implements the encryption of content with JSON based data
+// structures as described in https://tools.ietf.org/html/rfc7516
+//
+// Only the compact serialization is supported, with the AES key wrap, RSAES
+// OAEP and direct key management algorithms, and the AES GCM and AES CBC
+// HMAC SHA-2 content encryption algorithms. Compressed content is not
+// supported.
+package jwe
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1" // nolint:gosec // required by RSA-OAEP
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/open-policy-agent/opa/internal/jwx/jwa"
+)
+
+// Headers contains the JWE parameters that encryption and decryption depend on.
+type Headers struct {
+	Algorithm         jwa.KeyEncryptionAlgorithm     // https://tools.ietf.org/html/rfc7516#section-4.1.1
+	ContentEncryption jwa.ContentEncryptionAlgorithm // https://tools.ietf.org/html/rfc7516#section-4.1.2
+	Compression       string                         // https://tools.ietf.org/html/rfc7516#section-4.1.3
+	KeyID             string                         // https://tools.ietf.org/html/rfc7516#section-4.1.6
+	Critical          []string                       // https://tools.ietf.org/html/rfc7516#section-4.1.13
+}
+
+// ParseHeaders parses and validates the protected header hdrBuf.
+func ParseHeaders(hdrBuf []byte) (*Headers, error) {
+	var raw struct {
+		Algorithm         string   `json:"alg"`
+		ContentEncryption string   `json:"enc"`
+		Compression       string   `json:"zip"`
+		KeyID             string   `json:"kid"`
+		Critical          []string `json:"crit"`
+	}
+	if err := json.Unmarshal(hdrBuf, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse JWE header: %w", err)
+	}
+
+	h := &Headers{Compression: raw.Compression, KeyID: raw.KeyID, Critical: raw.Critical}
+	if err := h.Algorithm.Accept(raw.Algorithm); err != nil {
+		return nil, err
+	}
+	if err := h.ContentEncryption.Accept(raw.ContentEncryption); err != nil {
+		return nil, err
+	}
+	if h.Compression != "" {
+		return nil, errors.New("compressed content is not supported")
+	}
+	// RFC7516 4.1.13 no extension is understood
+	if len(h.Critical) > 0 {
+		return nil, fmt.Errorf("unsupported critical header parameters: %v", h.Critical)
+	}
+	return h, nil
+}
+
+// Encrypt encrypts payload for key with the algorithms of the protected header
+// hdrBuf, and serializes it in compact serialization format. The content
+// encryption key and the initialization vector are read from rnd, or from
+// crypto/rand if rnd is nil.
+//
+// key is an *rsa.PublicKey or *rsa.PrivateKey for the RSA-OAEP algorithms, and
+// a []byte for the AES key wrap and direct algorithms.
+func Encrypt(payload []byte, hdrBuf []byte, key interface{}, rnd io.Reader) ([]byte, error) {
+	if rnd == nil {
+		rnd = rand.Reader
+	}
+	h, err := ParseHeaders(hdrBuf)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newContentCipher(h.ContentEncryption)
+	if err != nil {
+		return nil, err
+	}
+
+	var cek, encryptedKey []byte
+	if h.Algorithm == jwa.Direct {
+		cek, err = symmetricKey(key, c.keySize())
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		cek = make([]byte, c.keySize())
+		if _, err := io.ReadFull(rnd, cek); err != nil {
+			return nil, fmt.Errorf("failed to generate content encryption key: %w", err)
+		}
+		encryptedKey, err = encryptKey(h.Algorithm, cek, key, rnd)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	iv := make([]byte, c.ivSize())
+	if _, err := io.ReadFull(rnd, iv); err != nil {
+		return nil, fmt.Errorf("failed to generate initialization vector: %w", err)
+	}
+
+	encodedHdr := base64.RawURLEncoding.EncodeToString(hdrBuf)
+	ciphertext, tag, err := c.encrypt(cek, iv, payload, []byte(encodedHdr))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt payload: %w", err)
+	}
+
+	compactSerialization := strings.Join(
+		[]string{
+			encodedHdr,
+			base64.RawURLEncoding.EncodeToString(encryptedKey),
+			base64.RawURLEncoding.EncodeToString(iv),
+			base64.RawURLEncoding.EncodeToString(ciphertext),
+			base64.RawURLEncoding.EncodeToString(tag),
+		}, ".",
+	)
+	return []byte(compactSerialization), nil
+}
+
+// Message is a JWE in compact serialization format, split into its parts.
+type Message struct {
+	Headers      *Headers
+	encodedHdr   string
+	encryptedKey []byte
+	iv           []byte
+	ciphertext   []byte
+	tag          []byte
+}
+
+// ParseString parses a JWE in compact serialization format.
+func ParseString(s string) (*Message, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 5 {
+		return nil, errors.New("failed to parse JWE: expected 5 parts")
+	}
+
+	hdrBuf, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode JWE header: %w", err)
+	}
+	h, err := ParseHeaders(hdrBuf)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Message{Headers: h, encodedHdr: parts[0]}
+	for i, dst := range []*[]byte{&m.encryptedKey, &m.iv, &m.ciphertext, &m.tag} {
+		if *dst, err = base64.RawURLEncoding.DecodeString(parts[i+1]); err != nil {
+			return nil, fmt.Errorf("failed to decode JWE: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// Decrypt decrypts the payload of m with key.
+//
+// key is an *rsa.PrivateKey for the RSA-OAEP algorithms, and a []byte for the
+// AES key wrap and direct algorithms.
+func (m *Message) Decrypt(key interface{}) ([]byte, error) {
+	c, err := newContentCipher(m.Headers.ContentEncryption)
+	if err != nil {
+		return nil, err
+	}
+
+	var cek []byte
+	if m.Headers.Algorithm == jwa.Direct {
+		if len(m.encryptedKey) != 0 {
+			return nil, errors.New("encrypted key must be empty for direct encryption")
+		}
+		cek, err = symmetricKey(key, c.keySize())
+	} else {
+		cek, err = decryptKey(m.Headers.Algorithm, m.encryptedKey, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(cek) != c.keySize() {
+		return nil, errDecrypt
+	}
+
+	return c.decrypt(cek, m.iv, m.ciphertext, m.tag, []byte(m.encodedHdr))
+}
+
+// Decrypt parses the JWE s in compact serialization format and decrypts its
+// payload with key.
+func Decrypt(s string, key interface{}) ([]byte, error) {
+	m, err := ParseString(s)
+	if err != nil {
+		return nil, err
+	}
+	return m.Decrypt(key)
+}
+
+func encryptKey(alg jwa.KeyEncryptionAlgorithm, cek []byte, key interface{}, rnd io.Reader) ([]byte, error) {
+	switch alg {
+	case jwa.RSAOAEP, jwa.RSAOAEP256:
+		var pub *rsa.PublicKey
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			pub = k
+		case *rsa.PrivateKey:
+			pub = &k.PublicKey
+		default:
+			return nil, fmt.Errorf("invalid key type %T for %s, expected RSA key", key, alg)
+		}
+		return rsa.EncryptOAEP(oaepHash(alg), rnd, pub, cek, nil)
+	case jwa.A128KW, jwa.A192KW, jwa.A256KW:
+		kek, err := symmetricKey(key, keyWrapSize(alg))
+		if err != nil {
+			return nil, err
+		}
+		return keyWrap(kek, cek)
+	}
+	return nil, fmt.Errorf("unsupported key encryption algorithm: %s", alg)
+}
+
+func decryptKey(alg jwa.KeyEncryptionAlgorithm, encryptedKey []byte, key interface{}) ([]byte, error) {
+	switch alg {
+	case jwa.RSAOAEP, jwa.RSAOAEP256:
+		priv, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("invalid key type %T for %s, expected RSA private key", key, alg)
+		}
+		cek, err := rsa.DecryptOAEP(oaepHash(alg), nil, priv, encryptedKey, nil)
+		if err != nil {
+			return nil, errDecrypt
+		}
+		return cek, nil
+	case jwa.A128KW, jwa.A192KW, jwa.A256KW:
+		kek, err := symmetricKey(key, keyWrapSize(alg))
+		if err != nil {
+			return nil, err
+		}
+		cek, err := keyUnwrap(kek, encryptedKey)
+		if err != nil {
+			return nil, errDecrypt
+		}
+		return cek, nil
+	}
+	return nil, fmt.Errorf("unsupported key encryption algorithm: %s", alg)
+}
+
+func oaepHash(alg jwa.KeyEncryptionAlgorithm) hash.Hash {
+	if alg == jwa.RSAOAEP256 {
+		return sha256.New()
+	}
+	return sha1.New() // nolint:gosec // required by RSA-OAEP
+}
+
+func keyWrapSize(alg jwa.KeyEncryptionAlgorithm) int {
+	switch alg {
+	case jwa.A128KW:
+		return 16
+	case jwa.A192KW:
+		return 24
+	}
+	return 32
+}
+
+func symmetricKey(key interface{}, size int) ([]byte, error) {
+	k, ok := key.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("invalid key type %T, expected symmetric key", key)
+	}
+	if len(k) != size {
+		return nil, fmt.Errorf("invalid key size %d bits, expected %d bits", 8*len(k), 8*size)
+	}
+	return k, nil
+}
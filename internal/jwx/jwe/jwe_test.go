@@ -0,0 +1,157 @@
+package jwe
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestKeyWrap(t *testing.T) {
+	// https://tools.ietf.org/html/rfc3394#section-4.1
+	kek, _ := hex.DecodeString("000102030405060708090A0B0C0D0E0F")
+	cek, _ := hex.DecodeString("00112233445566778899AABBCCDDEEFF")
+	exp, _ := hex.DecodeString("1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5")
+
+	wrapped, err := keyWrap(kek, cek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(wrapped, exp) {
+		t.Fatalf("Expected %x but got %x", exp, wrapped)
+	}
+
+	unwrapped, err := keyUnwrap(kek, wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unwrapped, cek) {
+		t.Fatalf("Expected %x but got %x", cek, unwrapped)
+	}
+
+	wrapped[len(wrapped)-1] ^= 1
+	if _, err := keyUnwrap(kek, wrapped); err == nil {
+		t.Fatal("Expected error for corrupted key")
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	// https://tools.ietf.org/html/rfc7516#appendix-A.3
+	key, _ := base64.RawURLEncoding.DecodeString("GawgguFyGrWKav7AX4VKUg")
+	jwe := "eyJhbGciOiJBMTI4S1ciLCJlbmMiOiJBMTI4Q0JDLUhTMjU2In0." +
+		"6KB707dM9YTIgHtLvtgWQ8mKwboJW3of9locizkDTHzBC2IlrT1oOQ." +
+		"AxY8DCtDaGlsbGljb3RoZQ." +
+		"KDlTtXchhZTGufMYmOYGS4HffxPSUrfmqCHXaI9wOGY." +
+		"U0m_YmjN04DJvceFICbCVQ"
+
+	payload, err := Decrypt(jwe, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "Live long and prosper."; string(payload) != exp {
+		t.Fatalf("Expected %q but got %q", exp, payload)
+	}
+
+	// Any change to the header, the key, the IV, the ciphertext or the tag is
+	// detected.
+	for i := range jwe {
+		if jwe[i] == '.' {
+			continue
+		}
+		c := byte('A')
+		if jwe[i] == 'A' {
+			c = 'B'
+		}
+		tampered := jwe[:i] + string(c) + jwe[i+1:]
+		if payload, err := Decrypt(tampered, key); err == nil && string(payload) == "Live long and prosper." {
+			// Changing the last character of a part may only flip padding bits.
+			continue
+		} else if err == nil {
+			t.Fatalf("Expected error for %v", tampered)
+		}
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := func(size int) []byte {
+		return bytes.Repeat([]byte{0x42}, size)
+	}
+
+	tests := []struct {
+		header string
+		encKey interface{}
+		decKey interface{}
+	}{
+		{header: `{"alg":"dir","enc":"A128GCM"}`, encKey: key(16), decKey: key(16)},
+		{header: `{"alg":"dir","enc":"A256CBC-HS512"}`, encKey: key(64), decKey: key(64)},
+		{header: `{"alg":"A128KW","enc":"A192GCM"}`, encKey: key(16), decKey: key(16)},
+		{header: `{"alg":"A192KW","enc":"A192CBC-HS384"}`, encKey: key(24), decKey: key(24)},
+		{header: `{"alg":"A256KW","enc":"A256GCM","kid":"k1"}`, encKey: key(32), decKey: key(32)},
+		{header: `{"alg":"RSA-OAEP","enc":"A128CBC-HS256"}`, encKey: &rsaKey.PublicKey, decKey: rsaKey},
+		{header: `{"alg":"RSA-OAEP-256","enc":"A256GCM"}`, encKey: rsaKey, decKey: rsaKey},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.header, func(t *testing.T) {
+			payload := []byte("a payload that spans several AES blocks")
+			jwe, err := Encrypt(payload, []byte(tc.header), tc.encKey, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parts := strings.Split(string(jwe), "."); len(parts) != 5 {
+				t.Fatalf("Expected 5 parts but got %v", jwe)
+			}
+
+			m, err := ParseString(string(jwe))
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := m.Decrypt(tc.decKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(result, payload) {
+				t.Fatalf("Expected %q but got %q", payload, result)
+			}
+
+			if k, ok := tc.decKey.([]byte); ok {
+				wrong := bytes.Repeat([]byte{0x43}, len(k))
+				if _, err := m.Decrypt(wrong); err == nil {
+					t.Fatal("Expected error for wrong key")
+				}
+			}
+		})
+	}
+}
+
+func TestEncryptErrors(t *testing.T) {
+	tests := []struct {
+		header string
+		key    interface{}
+		err    string
+	}{
+		{header: `{"alg":"RSA1_5","enc":"A128GCM"}`, key: []byte{}, err: "unknown key encryption algorithm"},
+		{header: `{"alg":"dir","enc":"A128CTR"}`, key: []byte{}, err: "unknown content encryption algorithm"},
+		{header: `{"alg":"dir","enc":"A128GCM","zip":"DEF"}`, key: []byte{}, err: "compressed content is not supported"},
+		{header: `{"alg":"dir","enc":"A128GCM","crit":["exp"]}`, key: []byte{}, err: "unsupported critical header parameters"},
+		{header: `{"alg":"dir","enc":"A128GCM"}`, key: make([]byte, 32), err: "invalid key size 256 bits, expected 128 bits"},
+		{header: `{"alg":"A128KW","enc":"A128GCM"}`, key: "key", err: "invalid key type string"},
+		{header: `{"alg":"RSA-OAEP","enc":"A128GCM"}`, key: make([]byte, 16), err: "expected RSA key"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.header, func(t *testing.T) {
+			_, err := Encrypt([]byte("payload"), []byte(tc.header), tc.key, nil)
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("Expected error %q but got %v", tc.err, err)
+			}
+		})
+	}
+}
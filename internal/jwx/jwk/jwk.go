@@ -73,7 +73,7 @@ func New(key interface{}) (Key, error) {
 	}
 }
 
-func parse(jwkSrc string) (*Set, error) {
+func parse(jwkSrc string, skipUnsupported bool) (*Set, error) {
 
 	var jwkKeySet Set
 	var jwkKey Key
@@ -99,7 +99,7 @@ func parse(jwkSrc string) (*Set, error) {
 	} else {
 		for i := range rawKeySetJSON.Keys {
 			rawKeyJSON := rawKeySetJSON.Keys[i]
-			if rawKeyJSON.Algorithm != nil && *rawKeyJSON.Algorithm == jwa.Unsupported {
+			if skipUnsupported && rawKeyJSON.Algorithm != nil && *rawKeyJSON.Algorithm == jwa.Unsupported {
 				continue
 			}
 			jwkKey, err = rawKeyJSON.GenerateKey()
@@ -114,12 +114,19 @@ func parse(jwkSrc string) (*Set, error) {
 
 // ParseBytes parses JWK from the incoming byte buffer.
 func ParseBytes(buf []byte) (*Set, error) {
-	return parse(string(buf[:]))
+	return parse(string(buf[:]), true)
 }
 
 // ParseString parses JWK from the incoming string.
 func ParseString(s string) (*Set, error) {
-	return parse(s)
+	return parse(s, true)
+}
+
+// ParseEncryptionKeysString parses JWK from the incoming string. Unlike
+// ParseString, it keeps the keys of sets whose algorithm is not a signature
+// algorithm, such as the keys of JWE key management algorithms.
+func ParseEncryptionKeysString(s string) (*Set, error) {
+	return parse(s, false)
 }
 
 // GenerateKey creates an internal representation of a key from a raw JWK JSON
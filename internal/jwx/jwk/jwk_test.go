@@ -189,3 +189,26 @@ func TestAppendix(t *testing.T) {
 		}
 	})
 }
+
+func TestParseEncryptionKeys(t *testing.T) {
+	jwkSrc := `{"keys": [
+		{"kty": "oct", "kid": "sig", "alg": "HS256", "k": "GawgguFyGrWKav7AX4VKUg"},
+		{"kty": "oct", "kid": "enc", "alg": "A128KW", "k": "GawgguFyGrWKav7AX4VKUg"}
+	]}`
+
+	set, err := jwk.ParseString(jwkSrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Keys) != 1 || set.Keys[0].GetKeyID() != "sig" {
+		t.Fatalf("Expected only the signing key but got %v", set.Keys)
+	}
+
+	set, err = jwk.ParseEncryptionKeysString(jwkSrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Keys) != 2 || set.Keys[1].GetKeyID() != "enc" {
+		t.Fatalf("Expected both keys but got %v", set.Keys)
+	}
+}
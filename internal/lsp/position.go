@@ -0,0 +1,83 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package lsp
+
+import (
+	"bytes"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// NOTE: Positions in the Language Server Protocol are zero-based lines and
+// characters, and characters are counted in UTF-16 code units. Locations in
+// the AST are one-based rows and columns, and columns are counted in bytes.
+
+// offsetAt returns the byte offset of pos in text. Positions beyond the end of
+// a line refer to the end of the line.
+func offsetAt(text []byte, pos position) int {
+	offset := 0
+	for line := 0; line < pos.Line; line++ {
+		i := bytes.IndexByte(text[offset:], '\n')
+		if i < 0 {
+			return len(text)
+		}
+		offset += i + 1
+	}
+
+	for n := 0; n < pos.Character && offset < len(text) && text[offset] != '\n'; {
+		r, size := utf8.DecodeRune(text[offset:])
+		n += utf16Len(r)
+		offset += size
+	}
+	return offset
+}
+
+// positionAt returns the position of the byte offset in text.
+func positionAt(text []byte, offset int) position {
+	if offset > len(text) {
+		offset = len(text)
+	}
+
+	var pos position
+	for i := 0; i < offset; {
+		r, size := utf8.DecodeRune(text[i:])
+		if r == '\n' {
+			pos.Line++
+			pos.Character = 0
+		} else {
+			pos.Character += utf16Len(r)
+		}
+		i += size
+	}
+	return pos
+}
+
+// rangeOf returns the range of text covered by loc. If text is not available,
+// the columns are assumed to be single-byte characters.
+func rangeOf(text []byte, loc *ast.Location) textRange {
+	if text == nil {
+		start := position{Line: loc.Row - 1, Character: loc.Col - 1}
+		return textRange{Start: start, End: start}
+	}
+
+	start := offsetAt(text, position{Line: loc.Row - 1})
+	start += loc.Col - 1
+	if start > len(text) {
+		start = len(text)
+	}
+	return textRange{
+		Start: positionAt(text, start),
+		End:   positionAt(text, start+len(loc.Text)),
+	}
+}
+
+func utf16Len(r rune) int {
+	if n := len(utf16.Encode([]rune{r})); n > 0 {
+		return n
+	}
+	return 1
+}
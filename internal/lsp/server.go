@@ -0,0 +1,657 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package lsp implements a language server for Rego, speaking the Language
+// Server Protocol over a pair of streams, e.g. stdin and stdout. The server
+// publishes diagnostics from the compiler, answers go-to-definition and hover
+// requests, and formats documents.
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/format"
+	"github.com/open-policy-agent/opa/internal/oracle"
+	"github.com/open-policy-agent/opa/types"
+	"github.com/open-policy-agent/opa/version"
+)
+
+// Options configure the server.
+type Options struct {
+	Capabilities *ast.Capabilities // defaults to the capabilities of this version
+	RegoVersion  ast.RegoVersion
+}
+
+// Server is a language server for Rego. The Rego files found in the workspace
+// folders are loaded on initialization, and documents opened by the client
+// shadow the files on disk until they are closed.
+type Server struct {
+	in          *bufio.Reader
+	out         io.Writer
+	opts        Options
+	builtins    map[string]*ast.Builtin
+	initialized bool
+	shutdown    bool
+	files       map[string]*file // keyed by path
+	published   map[string]struct{}
+	compiler    *ast.Compiler
+}
+
+// file is a Rego file of the workspace. If text cannot be parsed, module is
+// the module last parsed from the file, if any.
+type file struct {
+	text   []byte
+	module *ast.Module
+	err    error
+}
+
+// New returns a server reading requests from r and writing responses to w.
+func New(r io.Reader, w io.Writer, opts Options) *Server {
+	if opts.Capabilities == nil {
+		opts.Capabilities = ast.CapabilitiesForThisVersion()
+	}
+
+	builtins := make(map[string]*ast.Builtin, len(opts.Capabilities.Builtins))
+	for _, bi := range opts.Capabilities.Builtins {
+		builtins[bi.Name] = bi
+	}
+
+	return &Server{
+		in:        bufio.NewReader(r),
+		out:       w,
+		opts:      opts,
+		builtins:  builtins,
+		files:     map[string]*file{},
+		published: map[string]struct{}{},
+	}
+}
+
+// errExitWithoutShutdown is returned by Run if the client sends the exit
+// notification before the shutdown request.
+var errExitWithoutShutdown = errors.New("exit without shutdown")
+
+// Run processes messages until the client sends the exit notification, or
+// the input is closed.
+func (s *Server) Run() error {
+	for {
+		msg, err := readMessage(s.in)
+		if err != nil {
+			var rerr *responseError
+			if errors.As(err, &rerr) {
+				if err := s.writeError(json.RawMessage("null"), rerr); err != nil {
+					return err
+				}
+				continue
+			}
+			if errors.Is(err, io.EOF) && s.shutdown {
+				return nil
+			}
+			return err
+		}
+
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return errExitWithoutShutdown
+			}
+			return nil
+		}
+
+		if msg.ID == nil {
+			if err := s.handleNotification(msg); err != nil {
+				return err
+			}
+			continue
+		}
+
+		result, rerr := s.handleRequest(msg)
+		if rerr != nil {
+			err = s.writeError(*msg.ID, rerr)
+		} else {
+			err = writeMessage(s.out, response{JSONRPC: "2.0", ID: *msg.ID, Result: result})
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) handleRequest(msg *message) (interface{}, *responseError) {
+	if msg.Method == "initialize" {
+		return s.initialize(msg.Params)
+	}
+
+	if !s.initialized {
+		return nil, &responseError{Code: codeServerNotInitialized, Message: "server not initialized"}
+	}
+	if s.shutdown {
+		return nil, &responseError{Code: codeInvalidRequest, Message: "server is shutting down"}
+	}
+
+	switch msg.Method {
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/definition":
+		var params textDocumentPositionParams
+		if err := unmarshalParams(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.definition(params)
+	case "textDocument/hover":
+		var params textDocumentPositionParams
+		if err := unmarshalParams(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.hover(params)
+	case "textDocument/formatting":
+		var params documentFormattingParams
+		if err := unmarshalParams(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.formatting(params)
+	case "textDocument/completion":
+		var params textDocumentPositionParams
+		if err := unmarshalParams(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.completion(params)
+	}
+
+	return nil, &responseError{Code: codeMethodNotFound, Message: fmt.Sprintf("method not found: %v", msg.Method)}
+}
+
+// handleNotification processes a notification. Notifications cannot be
+// answered, so invalid notifications are ignored.
+func (s *Server) handleNotification(msg *message) error {
+	if !s.initialized {
+		return nil
+	}
+
+	switch msg.Method {
+	case "initialized":
+	case "textDocument/didOpen":
+		var params didOpenTextDocumentParams
+		if unmarshalParams(msg.Params, &params) != nil {
+			return nil
+		}
+		path, err := uriToPath(params.TextDocument.URI)
+		if err != nil {
+			return nil
+		}
+		s.update(path, []byte(params.TextDocument.Text))
+	case "textDocument/didChange":
+		var params didChangeTextDocumentParams
+		if unmarshalParams(msg.Params, &params) != nil || len(params.ContentChanges) == 0 {
+			return nil
+		}
+		path, err := uriToPath(params.TextDocument.URI)
+		if err != nil {
+			return nil
+		}
+		// NOTE: The server requests full document syncs, so the last change
+		// holds the complete text.
+		s.update(path, []byte(params.ContentChanges[len(params.ContentChanges)-1].Text))
+	case "textDocument/didClose":
+		var params didCloseTextDocumentParams
+		if unmarshalParams(msg.Params, &params) != nil {
+			return nil
+		}
+		path, err := uriToPath(params.TextDocument.URI)
+		if err != nil {
+			return nil
+		}
+		if bs, err := os.ReadFile(path); err == nil {
+			s.update(path, bs)
+		} else {
+			delete(s.files, path)
+		}
+	default:
+		return nil
+	}
+
+	return s.check()
+}
+
+func (s *Server) initialize(raw json.RawMessage) (interface{}, *responseError) {
+	if s.initialized {
+		return nil, &responseError{Code: codeInvalidRequest, Message: "server already initialized"}
+	}
+
+	var params initializeParams
+	if err := unmarshalParams(raw, &params); err != nil {
+		return nil, err
+	}
+
+	var roots []string
+	for _, folder := range params.WorkspaceFolders {
+		if path, err := uriToPath(folder.URI); err == nil {
+			roots = append(roots, path)
+		}
+	}
+	if len(roots) == 0 && params.RootURI != "" {
+		if path, err := uriToPath(params.RootURI); err == nil {
+			roots = append(roots, path)
+		}
+	}
+
+	for _, root := range roots {
+		s.loadWorkspace(root)
+	}
+
+	s.initialized = true
+
+	return initializeResult{
+		Capabilities: serverCapabilities{
+			TextDocumentSync: textDocumentSyncOptions{
+				OpenClose: true,
+				Change:    textDocumentSyncKindFull,
+			},
+			DefinitionProvider:         true,
+			HoverProvider:              true,
+			DocumentFormattingProvider: true,
+			CompletionProvider: &completionOptions{
+				TriggerCharacters: []string{"."},
+			},
+		},
+		ServerInfo: serverInfo{
+			Name:    "opa",
+			Version: version.Version,
+		},
+	}, nil
+}
+
+// loadWorkspace loads the Rego files found under root. Hidden directories are
+// skipped, and files that cannot be read are ignored.
+func (s *Server) loadWorkspace(root string) {
+	_ = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".rego" {
+			return nil
+		}
+		if bs, err := os.ReadFile(path); err == nil {
+			s.update(path, bs)
+		}
+		return nil
+	})
+}
+
+func (s *Server) update(path string, text []byte) {
+	module, err := ast.ParseModuleWithOpts(path, string(text), ast.ParserOptions{
+		Capabilities:      s.opts.Capabilities,
+		RegoVersion:       s.opts.RegoVersion,
+		ProcessAnnotation: true,
+	})
+	if prev, ok := s.files[path]; ok && err != nil {
+		// NOTE: The previous module is kept to complete refs using its
+		// package and imports while the document is being edited.
+		module = prev.module
+	}
+	s.files[path] = &file{text: text, module: module, err: err}
+}
+
+// modules returns the modules of all files that could be parsed.
+func (s *Server) modules() map[string]*ast.Module {
+	modules := make(map[string]*ast.Module, len(s.files))
+	for path, f := range s.files {
+		if f.err == nil {
+			modules[path] = f.module
+		}
+	}
+	return modules
+}
+
+// check compiles the modules and publishes the parse and compile errors as
+// diagnostics. The diagnostics of files without errors are cleared.
+func (s *Server) check() error {
+	diagnostics := map[string][]diagnostic{}
+
+	for path, f := range s.files {
+		if f.err != nil {
+			diagnostics[path] = append(diagnostics[path], s.diagnostics(path, f.err)...)
+		}
+	}
+
+	s.compiler = ast.NewCompiler().
+		WithCapabilities(s.opts.Capabilities).
+		WithUseTypeCheckAnnotations(true)
+	s.compiler.Compile(s.modules())
+
+	for _, e := range s.compiler.Errors {
+		if e.Location == nil {
+			continue
+		}
+		path := e.Location.File
+		diagnostics[path] = append(diagnostics[path], s.diagnostic(path, e))
+	}
+
+	paths := make([]string, 0, len(diagnostics)+len(s.published))
+	for path := range diagnostics {
+		paths = append(paths, path)
+	}
+	for path := range s.published {
+		if _, ok := diagnostics[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	s.published = make(map[string]struct{}, len(diagnostics))
+	for _, path := range paths {
+		ds := diagnostics[path]
+		if ds == nil {
+			ds = []diagnostic{}
+		} else {
+			s.published[path] = struct{}{}
+		}
+		err := writeMessage(s.out, notification{
+			JSONRPC: "2.0",
+			Method:  "textDocument/publishDiagnostics",
+			Params:  publishDiagnosticsParams{URI: pathToURI(path), Diagnostics: ds},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) diagnostics(path string, err error) []diagnostic {
+	var errs ast.Errors
+	if !errors.As(err, &errs) {
+		return []diagnostic{{
+			Severity: diagnosticSeverityError,
+			Source:   "opa",
+			Message:  err.Error(),
+		}}
+	}
+	result := make([]diagnostic, len(errs))
+	for i := range errs {
+		result[i] = s.diagnostic(path, errs[i])
+	}
+	return result
+}
+
+func (s *Server) diagnostic(path string, e *ast.Error) diagnostic {
+	var r textRange
+	if e.Location != nil {
+		r = rangeOf(s.text(path), e.Location)
+	}
+	return diagnostic{
+		Range:    r,
+		Severity: diagnosticSeverityError,
+		Code:     e.Code,
+		Source:   "opa",
+		Message:  e.Message,
+	}
+}
+
+// text returns the text of the file at path, i.e., the text of the opened
+// document, or the content of the file on disk.
+func (s *Server) text(path string) []byte {
+	if f, ok := s.files[path]; ok {
+		return f.text
+	}
+	bs, _ := os.ReadFile(path)
+	return bs
+}
+
+func (s *Server) definition(params textDocumentPositionParams) (interface{}, *responseError) {
+	path, f, rerr := s.file(params.TextDocument.URI)
+	if rerr != nil {
+		return nil, rerr
+	}
+	if f.err != nil {
+		return nil, nil
+	}
+
+	result, err := oracle.New().FindDefinition(oracle.DefinitionQuery{
+		Filename: path,
+		Pos:      offsetAt(f.text, params.Position),
+		Modules:  s.modules(),
+	})
+	if err != nil || result.Result == nil {
+		// NOTE: No definition is found if the position does not refer to
+		// anything, or if the modules do not compile.
+		return nil, nil
+	}
+
+	loc := result.Result
+	return location{
+		URI:   pathToURI(loc.File),
+		Range: rangeOf(s.text(loc.File), loc),
+	}, nil
+}
+
+// hover returns the type of the rule or ref at the position, or the signature
+// of the built-in function called.
+func (s *Server) hover(params textDocumentPositionParams) (interface{}, *responseError) {
+	path, f, rerr := s.file(params.TextDocument.URI)
+	if rerr != nil {
+		return nil, rerr
+	}
+	if s.compiler == nil {
+		return nil, nil
+	}
+	module, ok := s.compiler.Modules[path]
+	if !ok {
+		return nil, nil
+	}
+
+	offset := offsetAt(f.text, params.Position)
+
+	var text, doc string
+	var loc *ast.Location
+
+	ast.WalkRules(module, func(rule *ast.Rule) bool {
+		if len(rule.Head.Reference) == 0 {
+			return false
+		}
+		if l := rule.Head.Reference[0].Location; contains(l, path, offset) {
+			ref := rule.Path()
+			if tpe := s.compiler.TypeEnv.Get(ref); tpe != nil {
+				text = fmt.Sprintf("%v: %v", ref, types.Sprint(tpe))
+				loc = l
+			}
+			return true
+		}
+		return false
+	})
+
+	if loc == nil {
+		var match *ast.Term
+		ast.WalkTerms(module, func(term *ast.Term) bool {
+			if _, ok := term.Value.(ast.Ref); !ok || !contains(term.Location, path, offset) {
+				return false
+			}
+			if match == nil || len(term.Location.Text) <= len(match.Location.Text) {
+				match = term
+			}
+			return false
+		})
+		if match == nil {
+			return nil, nil
+		}
+
+		ref := match.Value.(ast.Ref)
+		if bi, ok := s.builtins[ref.String()]; ok {
+			text = fmt.Sprintf("%v: %v", bi.Name, types.Sprint(bi.Decl))
+			doc = bi.Description
+		} else if tpe := s.compiler.TypeEnv.Get(ref); tpe != nil {
+			text = fmt.Sprintf("%v: %v", ref, types.Sprint(tpe))
+		} else {
+			return nil, nil
+		}
+		loc = match.Location
+	}
+
+	if loc == nil {
+		return nil, nil
+	}
+
+	value := "```rego\n" + text + "\n```"
+	if doc != "" {
+		value += "\n\n" + doc
+	}
+
+	r := rangeOf(f.text, loc)
+	return hover{
+		Contents: markupContent{
+			Kind:  "markdown",
+			Value: value,
+		},
+		Range: &r,
+	}, nil
+}
+
+func (s *Server) formatting(params documentFormattingParams) (interface{}, *responseError) {
+	path, f, rerr := s.file(params.TextDocument.URI)
+	if rerr != nil {
+		return nil, rerr
+	}
+
+	formatted, err := format.SourceWithOpts(path, f.text, format.Opts{RegoVersion: s.opts.RegoVersion})
+	if err != nil {
+		// NOTE: Documents that cannot be parsed are not formatted, the parse
+		// errors are reported as diagnostics.
+		return nil, nil
+	}
+
+	edits := []textEdit{}
+	if !bytes.Equal(formatted, f.text) {
+		edits = append(edits, textEdit{
+			Range:   textRange{End: positionAt(f.text, len(f.text))},
+			NewText: string(formatted),
+		})
+	}
+	return edits, nil
+}
+
+// completion returns the keys of the document referred to by the ref before
+// the position, e.g., the rules of the package imported as lib for `lib.al`,
+// with their inferred types. Refs without a dot are completed with the rules
+// of the package of the document.
+func (s *Server) completion(params textDocumentPositionParams) (interface{}, *responseError) {
+	_, f, rerr := s.file(params.TextDocument.URI)
+	if rerr != nil {
+		return nil, rerr
+	}
+
+	items := []completionItem{}
+	if f.module == nil || s.compiler == nil || s.compiler.TypeEnv == nil {
+		return items, nil
+	}
+
+	offset := offsetAt(f.text, params.Position)
+	start := offset
+	for start > 0 && isRefByte(f.text[start-1]) {
+		start--
+	}
+
+	parts := strings.Split(string(f.text[start:offset]), ".")
+	partial := parts[len(parts)-1]
+	parts = parts[:len(parts)-1]
+
+	var prefix ast.Ref
+	if len(parts) == 0 {
+		prefix = f.module.Package.Path
+	} else {
+		for _, part := range parts {
+			if !ast.IsVarCompatibleString(part) {
+				return items, nil
+			}
+		}
+		head := ast.Var(parts[0])
+		if path, ok := ast.ImportedRefs(f.module)[head]; ok {
+			prefix = path
+		} else if ast.RootDocumentNames.Contains(ast.NewTerm(head)) {
+			prefix = ast.Ref{ast.NewTerm(head)}
+		} else {
+			prefix = f.module.Package.Path.Append(ast.StringTerm(parts[0]))
+		}
+		for _, part := range parts[1:] {
+			prefix = prefix.Append(ast.StringTerm(part))
+		}
+	}
+
+	for _, child := range s.compiler.TypeEnv.Children(prefix) {
+		key, ok := child.Ref[len(child.Ref)-1].Value.(ast.String)
+		if !ok || !ast.IsVarCompatibleString(string(key)) || !strings.HasPrefix(string(key), partial) {
+			continue
+		}
+		items = append(items, completionItem{
+			Label:  string(key),
+			Kind:   completionItemKindField,
+			Detail: types.Sprint(child.Type),
+		})
+	}
+	return items, nil
+}
+
+// isRefByte returns true if b can be part of a ref written with dots.
+func isRefByte(b byte) bool {
+	return b == '.' || b == '_' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9'
+}
+
+func (s *Server) file(uri string) (string, *file, *responseError) {
+	path, err := uriToPath(uri)
+	if err != nil {
+		return "", nil, &responseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	f, ok := s.files[path]
+	if !ok {
+		return "", nil, &responseError{Code: codeInvalidParams, Message: fmt.Sprintf("unknown document: %v", uri)}
+	}
+	return path, f, nil
+}
+
+func (s *Server) writeError(id json.RawMessage, rerr *responseError) error {
+	return writeMessage(s.out, errorResponse{JSONRPC: "2.0", ID: id, Error: rerr})
+}
+
+func unmarshalParams(raw json.RawMessage, x interface{}) *responseError {
+	if err := json.Unmarshal(raw, x); err != nil {
+		return &responseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+func uriToPath(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("unsupported URI scheme: %v", uri)
+	}
+	return filepath.FromSlash(u.Path), nil
+}
+
+func pathToURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+func contains(loc *ast.Location, path string, offset int) bool {
+	return loc != nil && loc.File == path && loc.Offset <= offset && offset < loc.Offset+len(loc.Text)
+}
@@ -0,0 +1,406 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/util/test"
+)
+
+type testClient struct {
+	t             *testing.T
+	in            chan []byte
+	out           *bufio.Reader
+	id            int
+	notifications []map[string]interface{}
+	done          chan error
+}
+
+func newTestClient(t *testing.T, opts Options) *testClient {
+	t.Helper()
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+
+	c := &testClient{
+		t:    t,
+		in:   make(chan []byte, 16),
+		out:  bufio.NewReader(outR),
+		done: make(chan error, 1),
+	}
+
+	// NOTE: Messages are written asynchronously, as the server may block on
+	// writing its notifications until the client reads them.
+	go func() {
+		for bs := range c.in {
+			if _, err := inW.Write(bs); err != nil {
+				return
+			}
+		}
+	}()
+
+	go func() {
+		err := New(inR, outW, opts).Run()
+		outW.Close()
+		c.done <- err
+	}()
+
+	return c
+}
+
+func (c *testClient) send(msg map[string]interface{}) {
+	c.t.Helper()
+	msg["jsonrpc"] = "2.0"
+	var buf bytes.Buffer
+	if err := writeMessage(&buf, msg); err != nil {
+		c.t.Fatal(err)
+	}
+	c.in <- buf.Bytes()
+}
+
+func (c *testClient) read() map[string]interface{} {
+	c.t.Helper()
+	header, err := c.out.ReadString('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(header, "Content-Length:")))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := c.out.ReadString('\n'); err != nil {
+		c.t.Fatal(err)
+	}
+	bs := make([]byte, n)
+	if _, err := io.ReadFull(c.out, bs); err != nil {
+		c.t.Fatal(err)
+	}
+	var msg map[string]interface{}
+	if err := json.Unmarshal(bs, &msg); err != nil {
+		c.t.Fatal(err)
+	}
+	return msg
+}
+
+// call sends a request and returns the response. Notifications sent by the
+// server before the response are recorded.
+func (c *testClient) call(method string, params interface{}) map[string]interface{} {
+	c.t.Helper()
+	c.id++
+	c.send(map[string]interface{}{"id": c.id, "method": method, "params": params})
+	for {
+		msg := c.read()
+		if _, ok := msg["id"]; ok {
+			if int(msg["id"].(float64)) != c.id {
+				c.t.Fatalf("unexpected response: %v", msg)
+			}
+			return msg
+		}
+		c.notifications = append(c.notifications, msg)
+	}
+}
+
+// notify sends a notification, and returns the diagnostics published in
+// response to it, keyed by URI.
+func (c *testClient) notify(method string, params interface{}) map[string][]interface{} {
+	c.t.Helper()
+	c.send(map[string]interface{}{"method": method, "params": params})
+
+	// NOTE: A request is sent after the notification so that the server's
+	// notifications can be collected up to its response.
+	c.notifications = nil
+	c.call("$/ping", nil)
+
+	result := map[string][]interface{}{}
+	for _, n := range c.notifications {
+		if n["method"] != "textDocument/publishDiagnostics" {
+			continue
+		}
+		params := n["params"].(map[string]interface{})
+		result[params["uri"].(string)] = params["diagnostics"].([]interface{})
+	}
+	return result
+}
+
+func (c *testClient) shutdown() {
+	c.t.Helper()
+	c.call("shutdown", nil)
+	c.send(map[string]interface{}{"method": "exit"})
+	close(c.in)
+	if err := <-c.done; err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func TestServer(t *testing.T) {
+	files := map[string]string{
+		"lib.rego": `package lib
+
+default allowed := false
+
+allowed if input.user == "admin"
+`,
+		"policy.rego": `package policy
+
+import data.lib
+
+allow if {
+	lib.allowed
+	count(input.roles) > 0
+}
+`,
+		".hidden/broken.rego": `package broken {`,
+	}
+
+	test.WithTempFS(files, func(root string) {
+		c := newTestClient(t, Options{RegoVersion: ast.RegoV1})
+
+		resp := c.call("initialize", map[string]interface{}{"rootUri": pathToURI(root)})
+		caps := resp["result"].(map[string]interface{})["capabilities"].(map[string]interface{})
+		for _, cap := range []string{"definitionProvider", "hoverProvider", "documentFormattingProvider"} {
+			if caps[cap] != true {
+				t.Fatalf("expected %v, got %v", cap, caps)
+			}
+		}
+
+		if diags := c.notify("initialized", map[string]interface{}{}); len(diags) != 0 {
+			t.Fatalf("expected no diagnostics, got %v", diags)
+		}
+
+		policy := filepath.Join(root, "policy.rego")
+		policyURI := pathToURI(policy)
+		text := files["policy.rego"]
+
+		// diagnostics
+		broken := strings.Replace(text, "lib.allowed", "lib.allowed(1)", 1)
+		diags := c.notify("textDocument/didOpen", map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": policyURI, "languageId": "rego", "version": 1, "text": broken},
+		})
+		if len(diags[policyURI]) != 1 {
+			t.Fatalf("expected one diagnostic, got %v", diags)
+		}
+		d := diags[policyURI][0].(map[string]interface{})
+		if d["code"] != "rego_type_error" || !strings.Contains(d["message"].(string), "lib.allowed") {
+			t.Fatalf("unexpected diagnostic: %v", d)
+		}
+		if start := d["range"].(map[string]interface{})["start"].(map[string]interface{}); start["line"] != 5.0 {
+			t.Fatalf("unexpected range: %v", d["range"])
+		}
+
+		diags = c.notify("textDocument/didChange", map[string]interface{}{
+			"textDocument":   map[string]interface{}{"uri": policyURI, "version": 2},
+			"contentChanges": []interface{}{map[string]interface{}{"text": text}},
+		})
+		if ds, ok := diags[policyURI]; !ok || len(ds) != 0 {
+			t.Fatalf("expected diagnostics to be cleared, got %v", diags)
+		}
+
+		// go-to-definition
+		resp = c.call("textDocument/definition", map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": policyURI},
+			"position":     map[string]interface{}{"line": 5, "character": 6},
+		})
+		loc := resp["result"].(map[string]interface{})
+		if loc["uri"] != pathToURI(filepath.Join(root, "lib.rego")) {
+			t.Fatalf("unexpected definition: %v", loc)
+		}
+		if start := loc["range"].(map[string]interface{})["start"].(map[string]interface{}); start["line"] != 2.0 || start["character"] != 0.0 {
+			t.Fatalf("unexpected definition: %v", loc)
+		}
+
+		// hover
+		for _, tc := range []struct {
+			note string
+			pos  map[string]interface{}
+			exp  string
+		}{
+			{note: "rule head", pos: map[string]interface{}{"line": 4, "character": 2}, exp: "data.policy.allow: boolean"},
+			{note: "rule ref", pos: map[string]interface{}{"line": 5, "character": 7}, exp: "data.lib.allowed: boolean"},
+			{note: "built-in", pos: map[string]interface{}{"line": 6, "character": 2}, exp: "count: (any<string, array[any], object[any: any], set[any]>) => number"},
+		} {
+			t.Run(tc.note, func(t *testing.T) {
+				resp := c.call("textDocument/hover", map[string]interface{}{
+					"textDocument": map[string]interface{}{"uri": policyURI},
+					"position":     tc.pos,
+				})
+				h, ok := resp["result"].(map[string]interface{})
+				if !ok {
+					t.Fatalf("expected hover, got %v", resp)
+				}
+				value := h["contents"].(map[string]interface{})["value"].(string)
+				if !strings.Contains(value, tc.exp) {
+					t.Fatalf("expected %q in hover, got %q", tc.exp, value)
+				}
+			})
+		}
+
+		resp = c.call("textDocument/hover", map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": policyURI},
+			"position":     map[string]interface{}{"line": 1, "character": 0},
+		})
+		if resp["result"] != nil {
+			t.Fatalf("expected no hover, got %v", resp)
+		}
+
+		// formatting
+		c.notify("textDocument/didChange", map[string]interface{}{
+			"textDocument":   map[string]interface{}{"uri": policyURI, "version": 3},
+			"contentChanges": []interface{}{map[string]interface{}{"text": strings.Replace(text, "if {", "if     {", 1)}},
+		})
+		resp = c.call("textDocument/formatting", map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": policyURI},
+		})
+		edits := resp["result"].([]interface{})
+		if len(edits) != 1 || edits[0].(map[string]interface{})["newText"] != text {
+			t.Fatalf("unexpected edits: %v", edits)
+		}
+
+		// unknown methods and documents
+		resp = c.call("textDocument/unknown", nil)
+		if code := resp["error"].(map[string]interface{})["code"]; code != float64(codeMethodNotFound) {
+			t.Fatalf("unexpected response: %v", resp)
+		}
+		resp = c.call("textDocument/formatting", map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": pathToURI(filepath.Join(root, "missing.rego"))},
+		})
+		if code := resp["error"].(map[string]interface{})["code"]; code != float64(codeInvalidParams) {
+			t.Fatalf("unexpected response: %v", resp)
+		}
+
+		c.shutdown()
+	})
+}
+
+func TestServerCompletion(t *testing.T) {
+	files := map[string]string{
+		"lib.rego": `package lib
+
+config := {"roles": ["admin"], "limit": 10}
+
+allowed if input.user == "admin"
+`,
+		"policy.rego": `package policy
+
+import data.lib
+
+allow if lib.allowed
+`,
+	}
+
+	test.WithTempFS(files, func(root string) {
+		c := newTestClient(t, Options{RegoVersion: ast.RegoV1})
+
+		resp := c.call("initialize", map[string]interface{}{"rootUri": pathToURI(root)})
+		caps := resp["result"].(map[string]interface{})["capabilities"].(map[string]interface{})
+		if _, ok := caps["completionProvider"]; !ok {
+			t.Fatalf("expected completionProvider, got %v", caps)
+		}
+		c.notify("initialized", map[string]interface{}{})
+
+		policyURI := pathToURI(filepath.Join(root, "policy.rego"))
+
+		// NOTE: The document does not parse while the ref is being typed.
+		edited := files["policy.rego"] + "\ndeny if lib.config.\n"
+		c.notify("textDocument/didOpen", map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": policyURI, "languageId": "rego", "version": 1, "text": edited},
+		})
+
+		for _, tc := range []struct {
+			note string
+			uri  string
+			pos  map[string]interface{}
+			exp  map[string]string
+		}{
+			{
+				note: "import",
+				pos:  map[string]interface{}{"line": 4, "character": 14},
+				exp:  map[string]string{"allowed": "boolean"},
+			},
+			{
+				note: "object keys",
+				pos:  map[string]interface{}{"line": 6, "character": 19},
+				exp:  map[string]string{"limit": "number", "roles": "array<string>"},
+			},
+			{
+				note: "package rules",
+				uri:  pathToURI(filepath.Join(root, "lib.rego")),
+				pos:  map[string]interface{}{"line": 4, "character": 3},
+				exp:  map[string]string{"allowed": "boolean"},
+			},
+		} {
+			t.Run(tc.note, func(t *testing.T) {
+				uri := policyURI
+				if tc.uri != "" {
+					uri = tc.uri
+				}
+				resp := c.call("textDocument/completion", map[string]interface{}{
+					"textDocument": map[string]interface{}{"uri": uri},
+					"position":     tc.pos,
+				})
+				items, ok := resp["result"].([]interface{})
+				if !ok || len(items) != len(tc.exp) {
+					t.Fatalf("expected %v, got %v", tc.exp, resp)
+				}
+				for _, x := range items {
+					item := x.(map[string]interface{})
+					if exp, ok := tc.exp[item["label"].(string)]; !ok || item["detail"] != exp {
+						t.Fatalf("expected %v, got %v", tc.exp, items)
+					}
+				}
+			})
+		}
+
+		c.shutdown()
+	})
+}
+
+func TestServerNotInitialized(t *testing.T) {
+	c := newTestClient(t, Options{})
+
+	resp := c.call("textDocument/hover", map[string]interface{}{})
+	if code := resp["error"].(map[string]interface{})["code"]; code != float64(codeServerNotInitialized) {
+		t.Fatalf("unexpected response: %v", resp)
+	}
+
+	c.send(map[string]interface{}{"method": "exit"})
+	if err := <-c.done; err != errExitWithoutShutdown {
+		t.Fatalf("expected exit without shutdown, got %v", err)
+	}
+}
+
+func TestPositions(t *testing.T) {
+	text := []byte("a := \"ü😀\"\nb := 1\n")
+
+	for _, tc := range []struct {
+		pos    position
+		offset int
+	}{
+		{pos: position{Line: 0, Character: 0}, offset: 0},
+		{pos: position{Line: 0, Character: 6}, offset: 6},  // ü
+		{pos: position{Line: 0, Character: 7}, offset: 8},  // 😀, two UTF-16 code units
+		{pos: position{Line: 0, Character: 9}, offset: 12}, // closing quote
+		{pos: position{Line: 1, Character: 0}, offset: 14},
+		{pos: position{Line: 1, Character: 100}, offset: 20}, // beyond the end of the line
+	} {
+		if act := offsetAt(text, tc.pos); act != tc.offset {
+			t.Errorf("offsetAt(%v): expected %d, got %d", tc.pos, tc.offset, act)
+		}
+		if tc.pos.Character == 100 {
+			continue
+		}
+		if act := positionAt(text, tc.offset); act != tc.pos {
+			t.Errorf("positionAt(%d): expected %v, got %v", tc.offset, tc.pos, act)
+		}
+	}
+}
@@ -47,7 +47,7 @@ func ProcessWatcherUpdate(ctx context.Context, paths []string, removed string, s
 
 func ProcessWatcherUpdateForRegoVersion(ctx context.Context, regoVersion ast.RegoVersion, paths []string, removed string, store storage.Store, filter loader.Filter, asBundle bool,
 	f func(context.Context, storage.Transaction, *initload.LoadPathsResult) error) error {
-	loaded, err := initload.LoadPathsForRegoVersion(regoVersion, paths, filter, asBundle, nil, true, false, nil, nil)
+	loaded, err := initload.LoadPathsForRegoVersion(regoVersion, paths, filter, asBundle, nil, true, false, nil, nil, nil)
 	if err != nil {
 		return err
 	}
@@ -95,10 +95,14 @@ func ProcessWatcherUpdateForRegoVersion(ctx context.Context, regoVersion ast.Reg
 func getWatchPaths(rootPaths []string) ([]string, error) {
 	paths := []string{}
 
+	rootPaths, _ = loader.SplitExclusions(rootPaths, nil)
+
 	for _, path := range rootPaths {
 
+		// Glob patterns may match files created later anywhere in the directory
+		// they match files in.
 		_, path = loader.SplitPrefix(path)
-		result, err := loader.Paths(path, true)
+		result, err := loader.Paths(loader.GlobBase(path), true)
 		if err != nil {
 			return nil, err
 		}
@@ -21,6 +21,7 @@ import (
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/cover"
 	"github.com/open-policy-agent/opa/format"
+	"github.com/open-policy-agent/opa/internal/sarif"
 	"github.com/open-policy-agent/opa/loader"
 	"github.com/open-policy-agent/opa/metrics"
 	"github.com/open-policy-agent/opa/profiler"
@@ -110,12 +111,14 @@ func (o DepAnalysisOutput) sort() {
 // Output contains the result of evaluation to be presented.
 type Output struct {
 	Errors            OutputErrors                   `json:"errors,omitempty"`
+	Warnings          OutputErrors                   `json:"warnings,omitempty"`
 	Result            rego.ResultSet                 `json:"result,omitempty"`
 	Partial           *rego.PartialQueries           `json:"partial,omitempty"`
 	Metrics           metrics.Metrics                `json:"metrics,omitempty"`
 	AggregatedMetrics map[string]interface{}         `json:"aggregated_metrics,omitempty"`
 	Explanation       []*topdown.Event               `json:"explanation,omitempty"`
 	Profile           []profiler.ExprStats           `json:"profile,omitempty"`
+	RuleProfile       []topdown.RuleStats            `json:"rule_profile,omitempty"`
 	AggregatedProfile []profiler.ExprStatsAggregated `json:"aggregated_profile,omitempty"`
 	Coverage          *cover.Report                  `json:"coverage,omitempty"`
 	limit             int
@@ -250,6 +253,38 @@ func JSON(w io.Writer, x interface{}) error {
 	return encoder.Encode(x)
 }
 
+// SARIF writes the errors in err to w as a SARIF log. Errors with a code are
+// reported as results, and other errors as tool execution notifications.
+func SARIF(w io.Writer, err error) error {
+	log := sarif.New()
+	for _, e := range NewOutputErrors(err) {
+		var locations []sarif.Location
+		if e.Location != nil && e.Location.File != "" {
+			locations = append(locations, sarif.NewLocation(e.Location.File, e.Location.Row, e.Location.Col))
+		}
+		if e.Code == "" {
+			log.AddNotification(&sarif.Notification{
+				Level:     sarif.LevelError,
+				Message:   sarif.Message{Text: e.Message},
+				Locations: locations,
+			})
+			continue
+		}
+		log.AddResult(&sarif.Result{
+			RuleID:    e.Code,
+			Level:     sarif.LevelError,
+			Message:   sarif.Message{Text: e.Message},
+			Locations: locations,
+		})
+	}
+	return log.Write(w)
+}
+
+// NDJSON writes x to w as a single line of JSON.
+func NDJSON(w io.Writer, x interface{}) error {
+	return json.NewEncoder(w).Encode(x)
+}
+
 // Bindings prints the bindings from r to w.
 func Bindings(w io.Writer, r Output) error {
 	if r.Errors != nil {
@@ -312,6 +347,11 @@ func Pretty(w io.Writer, r Output) error {
 			return err
 		}
 	}
+	if len(r.RuleProfile) > 0 {
+		if err := prettyRuleProfile(w, r.RuleProfile); err != nil {
+			return err
+		}
+	}
 	if len(r.AggregatedMetrics) > 0 {
 		if err := prettyAggregatedMetrics(w, r.AggregatedMetrics, r.limit); err != nil {
 			return err
@@ -529,6 +569,21 @@ func prettyProfile(w io.Writer, profile []profiler.ExprStats) error {
 	return nil
 }
 
+func prettyRuleProfile(w io.Writer, profile []topdown.RuleStats) error {
+	tableProfile := generateTableWithKeys(w, "Time", "Num Eval", "Num Early Exit", "Ref", "Location")
+
+	for _, rs := range profile {
+		timeNs := time.Duration(rs.TotalTimeNs) * time.Nanosecond
+		numEval := strconv.FormatInt(int64(rs.NumEval), 10)
+		numEarlyExit := strconv.FormatInt(int64(rs.NumEarlyExit), 10)
+		tableProfile.Append([]string{timeNs.String(), numEval, numEarlyExit, rs.Ref.String(), rs.Location.String()})
+	}
+	if tableProfile.NumLines() > 0 {
+		tableProfile.Render()
+	}
+	return nil
+}
+
 func prettyAggregatedProfile(w io.Writer, profile []profiler.ExprStatsAggregated) error {
 	tableProfile := generateTableWithKeys(w, append(statKeys, "num eval", "num redo", "num gen expr", "location")...)
 	for _, rs := range profile {
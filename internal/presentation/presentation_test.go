@@ -385,6 +385,65 @@ q {
 	validateJSONOutput(t, err, expected)
 }
 
+func TestSARIF(t *testing.T) {
+	err := error(loader.Errors{
+		ast.NewError(ast.ParseErr, &ast.Location{File: "policy.rego", Row: 3, Col: 5}, "unexpected eof token"),
+		ast.NewError(ast.CompileErr, &ast.Location{Row: 1}, "rego_v1 import is required"),
+		errors.New("stat missing.rego: no such file or directory"),
+	})
+
+	var buf bytes.Buffer
+	if err := SARIF(&buf, err); err != nil {
+		t.Fatal(err)
+	}
+
+	var result map[string]interface{}
+	if err := util.UnmarshalJSON(buf.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	run := result["runs"].([]interface{})[0].(map[string]interface{})
+	delete(run, "tool")
+
+	expected := util.MustUnmarshalJSON([]byte(`{
+		"invocations": [{
+			"executionSuccessful": false,
+			"toolExecutionNotifications": [{
+				"level": "error",
+				"message": {"text": "stat missing.rego: no such file or directory"}
+			}]
+		}],
+		"results": [{
+			"ruleId": "rego_parse_error",
+			"level": "error",
+			"message": {"text": "unexpected eof token"},
+			"locations": [{"physicalLocation": {
+				"artifactLocation": {"uri": "policy.rego"},
+				"region": {"startLine": 3, "startColumn": 5}
+			}}]
+		}, {
+			"ruleId": "rego_compile_error",
+			"level": "error",
+			"message": {"text": "rego_v1 import is required"}
+		}]
+	}`))
+
+	if !reflect.DeepEqual(run, expected) {
+		t.Fatalf("Expected %v but got %v", expected, run)
+	}
+
+	buf.Reset()
+	if err := SARIF(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := util.UnmarshalJSON(buf.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	run = result["runs"].([]interface{})[0].(map[string]interface{})
+	if results := run["results"].([]interface{}); len(results) != 0 {
+		t.Fatalf("Expected no results but got %v", results)
+	}
+}
+
 func TestSource(t *testing.T) {
 
 	buf := new(bytes.Buffer)
@@ -20,6 +20,7 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 
 	"github.com/open-policy-agent/opa/metrics"
 )
@@ -166,6 +167,11 @@ func (p *Provider) MustRegister(cs ...prometheus.Collector) {
 	p.registry.MustRegister(cs...)
 }
 
+// Gather gathers the metrics of the OPA prometheus registry
+func (p *Provider) Gather() ([]*dto.MetricFamily, error) {
+	return p.registry.Gather()
+}
+
 // Unregister unregister the collectors on OPA prometheus registry
 func (p *Provider) Unregister(c prometheus.Collector) bool {
 	return p.registry.Unregister(c)
@@ -124,9 +124,12 @@ func LoadPaths(paths []string,
 	processAnnotations bool,
 	caps *ast.Capabilities,
 	fsys fs.FS) (*LoadPathsResult, error) {
-	return LoadPathsForRegoVersion(ast.RegoV0, paths, filter, asBundle, bvc, skipVerify, processAnnotations, caps, fsys)
+	return LoadPathsForRegoVersion(ast.RegoV0, paths, filter, asBundle, bvc, skipVerify, processAnnotations, caps, fsys, nil)
 }
 
+// LoadPathsForRegoVersion is like LoadPaths, but parses modules for the given
+// Rego version. If cache is not nil, parsed modules are looked up and stored in
+// it.
 func LoadPathsForRegoVersion(regoVersion ast.RegoVersion,
 	paths []string,
 	filter loader.Filter,
@@ -135,12 +138,15 @@ func LoadPathsForRegoVersion(regoVersion ast.RegoVersion,
 	skipVerify bool,
 	processAnnotations bool,
 	caps *ast.Capabilities,
-	fsys fs.FS) (*LoadPathsResult, error) {
+	fsys fs.FS,
+	cache ast.ModuleCache) (*LoadPathsResult, error) {
 
 	if caps == nil {
 		caps = ast.CapabilitiesForThisVersion()
 	}
 
+	paths, filter = loader.SplitExclusions(paths, filter)
+
 	// tar.gz files are automatically loaded as bundles
 	var likelyBundles, nonBundlePaths []string
 	if !asBundle {
@@ -161,6 +167,7 @@ func LoadPathsForRegoVersion(regoVersion ast.RegoVersion,
 				WithProcessAnnotation(processAnnotations).
 				WithCapabilities(caps).
 				WithRegoVersion(regoVersion).
+				WithModuleCache(cache).
 				AsBundle(path)
 			if err != nil {
 				return nil, err
@@ -177,6 +184,7 @@ func LoadPathsForRegoVersion(regoVersion ast.RegoVersion,
 		WithProcessAnnotation(processAnnotations).
 		WithCapabilities(caps).
 		WithRegoVersion(regoVersion).
+		WithModuleCache(cache).
 		Filtered(nonBundlePaths, filter)
 
 	if err != nil {
@@ -207,6 +215,8 @@ func WalkPaths(paths []string, filter loader.Filter, asBundle bool) (*WalkPathsR
 
 	var result WalkPathsResult
 
+	paths, filter = loader.SplitExclusions(paths, filter)
+
 	if asBundle {
 		result.BundlesLoader = make([]BundleLoader, len(paths))
 		for i, path := range paths {
@@ -230,10 +240,14 @@ func WalkPaths(paths []string, filter loader.Filter, asBundle bool) (*WalkPathsR
 			return nil, err
 		}
 
+		// The files matched by glob patterns are relative to the directory they
+		// are matched in.
+		root := loader.GlobBase(path)
+
 		for _, fp := range filePaths {
 			// Trim off the root directory and return path as if chrooted
-			cleanedPath := strings.TrimPrefix(fp, path)
-			if path == "." && filepath.Base(fp) == bundle.ManifestExt {
+			cleanedPath := strings.TrimPrefix(fp, root)
+			if root == "." && filepath.Base(fp) == bundle.ManifestExt {
 				cleanedPath = fp
 			}
 
@@ -242,7 +256,7 @@ func WalkPaths(paths []string, filter loader.Filter, asBundle bool) (*WalkPathsR
 			}
 
 			result.FileDescriptors = append(result.FileDescriptors, &Descriptor{
-				Root: path,
+				Root: root,
 				Path: cleanedPath,
 			})
 		}
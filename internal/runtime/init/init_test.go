@@ -527,3 +527,39 @@ func TestLoadPathsBundleModeWithFilter(t *testing.T) {
 		}
 	})
 }
+
+func TestLoadPathsGlobsAndExclusions(t *testing.T) {
+	files := map[string]string{
+		"a/data.json":        `{"foo": "bar"}`,
+		"a/policy.rego":      "package foo\n p = 1",
+		"a/policy_test.rego": "package foo\n test_p { p }",
+		"a/.manifest":        `{"roots": ["a", "foo"]}`,
+	}
+
+	test.WithTempFS(files, func(rootDir string) {
+
+		// bundle mode
+		loaded, err := LoadPaths([]string{rootDir, "!**/*_test.rego"}, nil, true, nil, true, false, nil, nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+
+		if len(loaded.Bundles[rootDir].Modules) != 1 {
+			t.Fatalf("expected 1 module but got %v", len(loaded.Bundles[rootDir].Modules))
+		}
+
+		// non-bundle mode
+		walked, err := WalkPaths([]string{filepath.Join(rootDir, "*", "*.rego"), "!**/*_test.rego"}, nil, false)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+
+		if len(walked.FileDescriptors) != 1 {
+			t.Fatalf("expected 1 file but got %v", len(walked.FileDescriptors))
+		}
+
+		if d := walked.FileDescriptors[0]; d.Root != rootDir || d.Path != "/a/policy.rego" {
+			t.Fatalf("unexpected file %v in %v", d.Path, d.Root)
+		}
+	})
+}
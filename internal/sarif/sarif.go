@@ -0,0 +1,164 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package sarif implements the subset of the Static Analysis Results
+// Interchange Format (SARIF) that OPA reports results in, see
+// https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html
+package sarif
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+
+	"github.com/open-policy-agent/opa/version"
+)
+
+const (
+	schema        = "https://json.schemastore.org/sarif-2.1.0.json"
+	formatVersion = "2.1.0"
+)
+
+// Levels of results and notifications.
+const (
+	LevelNone    = "none"
+	LevelNote    = "note"
+	LevelWarning = "warning"
+	LevelError   = "error"
+)
+
+// IsLevel returns true if s is a level of results and notifications.
+func IsLevel(s string) bool {
+	switch s {
+	case LevelNone, LevelNote, LevelWarning, LevelError:
+		return true
+	}
+	return false
+}
+
+// Log is a SARIF log with a single run of OPA.
+type Log struct {
+	Schema  string `json:"$schema"`
+	Version string `json:"version"`
+	Runs    []*Run `json:"runs"`
+}
+
+// New returns a log with a single successful run of OPA, without results.
+func New() *Log {
+	return &Log{
+		Schema:  schema,
+		Version: formatVersion,
+		Runs: []*Run{{
+			Tool: Tool{Driver: Driver{
+				Name:           "opa",
+				InformationURI: "https://www.openpolicyagent.org",
+				Version:        version.Version,
+			}},
+			Invocations: []*Invocation{{ExecutionSuccessful: true}},
+			Results:     []*Result{},
+		}},
+	}
+}
+
+// AddResult adds r to the results of the run.
+func (l *Log) AddResult(r *Result) {
+	l.Runs[0].Results = append(l.Runs[0].Results, r)
+}
+
+// AddNotification adds n to the notifications of the run, which then did not
+// execute successfully.
+func (l *Log) AddNotification(n *Notification) {
+	inv := l.Runs[0].Invocations[0]
+	inv.ExecutionSuccessful = false
+	inv.ToolExecutionNotifications = append(inv.ToolExecutionNotifications, n)
+}
+
+// Write writes the log to w.
+func (l *Log) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(l)
+}
+
+// Run is a run of OPA.
+type Run struct {
+	Tool        Tool          `json:"tool"`
+	Invocations []*Invocation `json:"invocations"`
+	Results     []*Result     `json:"results"`
+}
+
+// Tool describes OPA.
+type Tool struct {
+	Driver Driver `json:"driver"`
+}
+
+// Driver describes OPA.
+type Driver struct {
+	Name           string `json:"name"`
+	InformationURI string `json:"informationUri"`
+	Version        string `json:"version"`
+}
+
+// Invocation describes whether OPA executed successfully.
+type Invocation struct {
+	ExecutionSuccessful        bool            `json:"executionSuccessful"`
+	ToolExecutionNotifications []*Notification `json:"toolExecutionNotifications,omitempty"`
+}
+
+// Notification is an error that prevented OPA from producing results.
+type Notification struct {
+	Level     string     `json:"level"`
+	Message   Message    `json:"message"`
+	Locations []Location `json:"locations,omitempty"`
+}
+
+// Result is a problem found by OPA.
+type Result struct {
+	RuleID    string     `json:"ruleId,omitempty"`
+	Level     string     `json:"level"`
+	Message   Message    `json:"message"`
+	Locations []Location `json:"locations,omitempty"`
+}
+
+// Message is the text of a result or notification.
+type Message struct {
+	Text string `json:"text"`
+}
+
+// Location is the location of a result or notification.
+type Location struct {
+	PhysicalLocation PhysicalLocation `json:"physicalLocation"`
+}
+
+// NewLocation returns the location of file, at row and col if they are
+// positive.
+func NewLocation(file string, row, col int) Location {
+	loc := Location{PhysicalLocation: PhysicalLocation{
+		ArtifactLocation: ArtifactLocation{URI: filepath.ToSlash(file)},
+	}}
+	if row > 0 {
+		loc.PhysicalLocation.Region = &Region{StartLine: row}
+		if col > 0 {
+			loc.PhysicalLocation.Region.StartColumn = col
+		}
+	}
+	return loc
+}
+
+// PhysicalLocation is the location of a result or notification in a file.
+type PhysicalLocation struct {
+	ArtifactLocation ArtifactLocation `json:"artifactLocation"`
+	Region           *Region          `json:"region,omitempty"`
+}
+
+// ArtifactLocation is a file.
+type ArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+// Region is a position in a file.
+type Region struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
@@ -0,0 +1,149 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package secrets finds string literals in policies and data that look like
+// credentials, so that they are not leaked through policy bundles.
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// AllowAnnotation is the custom metadata annotation that allows secrets in the
+// rules it applies to:
+//
+//	# METADATA
+//	# custom:
+//	#   allow_secrets: true
+const AllowAnnotation = "allow_secrets"
+
+var patterns = []struct {
+	kind string
+	re   *regexp.Regexp
+}{
+	{"AWS access key ID", regexp.MustCompile(`\b(?:AKIA|ASIA|AIDA|AROA|AGPA|ANPA|ANVA|AIPA)[A-Z2-7]{16}\b`)},
+	{"AWS secret access key", regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?[A-Za-z0-9/+]{40}\b`)},
+	{"private key", regexp.MustCompile(`-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY( BLOCK)?-----`)},
+	{"password in connection string", regexp.MustCompile(`\b[A-Za-z][A-Za-z0-9+.-]*://[^\s/:@]+:[^\s/@]+@[^\s/@]`)},
+}
+
+// Modules returns errors for the strings in the rules of modules that look
+// like secrets, unless the rules are annotated with AllowAnnotation. The
+// annotations of modules must have been processed.
+func Modules(modules []*ast.Module) ast.Errors {
+	as, errs := ast.BuildAnnotationSet(modules)
+	if len(errs) > 0 {
+		return errs
+	}
+
+	for _, module := range modules {
+		// Else branches are walked as part of their rules.
+		ast.WalkRules(module, func(r *ast.Rule) bool {
+			if allowed(as.Chain(r)) {
+				return true
+			}
+			ast.WalkTerms(r, func(t *ast.Term) bool {
+				var kind string
+				switch v := t.Value.(type) {
+				case ast.String:
+					kind = stringKind(string(v))
+				case ast.Object:
+					kind = objectKind(v.Get(ast.StringTerm("kty")) != nil, v.Get(ast.StringTerm("d")) != nil)
+				}
+				if kind != "" {
+					errs = append(errs, ast.NewError(ast.CompileErr, t.Location, "possible secret: %v", kind))
+				}
+				return false
+			})
+			return true
+		})
+	}
+	return errs
+}
+
+// Data returns errors for the strings in data that look like secrets. The
+// errors are reported at the paths of the strings under data.
+func Data(data map[string]interface{}) ast.Errors {
+	var errs ast.Errors
+	walk(ast.DefaultRootRef, data, func(path ast.Ref, kind string) {
+		errs = append(errs, ast.NewError(ast.CompileErr, nil, "possible secret in %v: %v", path, kind))
+	})
+	return errs
+}
+
+func walk(path ast.Ref, x interface{}, report func(ast.Ref, string)) {
+	switch x := x.(type) {
+	case string:
+		if kind := stringKind(x); kind != "" {
+			report(path, kind)
+		}
+	case map[string]interface{}:
+		_, kty := x["kty"]
+		_, d := x["d"]
+		if kind := objectKind(kty, d); kind != "" {
+			report(path, kind)
+		}
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			walk(path.Append(ast.StringTerm(k)), x[k], report)
+		}
+	case []interface{}:
+		for i, v := range x {
+			walk(path.Append(ast.IntNumberTerm(i)), v, report)
+		}
+	}
+}
+
+func stringKind(s string) string {
+	for _, p := range patterns {
+		if p.re.MatchString(s) {
+			return p.kind
+		}
+	}
+	// Private keys are also given as JSON encoded JWKs, e.g., to sign tokens.
+	if strings.HasPrefix(strings.TrimSpace(s), "{") {
+		var x interface{}
+		if err := json.Unmarshal([]byte(s), &x); err == nil {
+			var kind string
+			walk(nil, x, func(_ ast.Ref, k string) {
+				if kind == "" {
+					kind = k
+				}
+			})
+			return kind
+		}
+	}
+	return ""
+}
+
+// objectKind returns the kind of secret of objects with a key type and a
+// private exponent, or private key value, which are private JWKs.
+func objectKind(kty, d bool) string {
+	if kty && d {
+		return "private JSON Web Key"
+	}
+	return ""
+}
+
+func allowed(chain ast.AnnotationsRefSet) bool {
+	for _, ref := range chain {
+		if ref.Annotations == nil {
+			continue
+		}
+		if v, ok := ref.Annotations.Custom[AllowAnnotation]; ok {
+			return fmt.Sprint(v) == "true"
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package ulid implements the generation and parsing of ULIDs, the
+// lexicographically sortable identifiers made of a millisecond timestamp and
+// 80 random bits.
+// ref: https://github.com/ulid/spec
+package ulid
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+const (
+	// EncodedLen is the length of encoded ULIDs.
+	EncodedLen = 26
+
+	alphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+)
+
+var errInvalid = errors.New("invalid ULID")
+
+// decoding maps the characters of the alphabet, in either case, to their
+// values, and the others to 0xff.
+var decoding = func() [256]byte {
+	var d [256]byte
+	for i := range d {
+		d[i] = 0xff
+	}
+	for i := 0; i < len(alphabet); i++ {
+		d[alphabet[i]] = byte(i)
+		if c := alphabet[i]; c >= 'A' && c <= 'Z' {
+			d[c+'a'-'A'] = byte(i)
+		}
+	}
+	return d
+}()
+
+// ULID is the binary representation of a ULID: the Unix time in milliseconds
+// in the first 6 bytes, and the entropy in the last 10.
+type ULID [16]byte
+
+// New returns a ULID of the Unix time t in milliseconds, with the entropy
+// read from r.
+func New(r io.Reader, t time.Time) (ULID, error) {
+	var u ULID
+	if _, err := io.ReadFull(r, u[6:]); err != nil {
+		return u, err
+	}
+	ms := uint64(t.UnixMilli())
+	for i := 0; i < 6; i++ {
+		u[i] = byte(ms >> (40 - 8*i))
+	}
+	return u, nil
+}
+
+// Parse decodes the string s into a ULID. The decoding is case-insensitive.
+func Parse(s string) (ULID, error) {
+	var u ULID
+	if len(s) != EncodedLen || decoding[s[0]] > 7 {
+		return u, errInvalid
+	}
+
+	// The 26 characters hold 130 bits, of which the 2 leading ones must be 0.
+	var acc uint
+	var bits, n int
+	for i := 0; i < len(s); i++ {
+		v := decoding[s[i]]
+		if v == 0xff {
+			return u, errInvalid
+		}
+		acc = acc<<5 | uint(v)
+		bits += 5
+		if i == 0 {
+			bits -= 2
+		}
+		if bits >= 8 {
+			bits -= 8
+			u[n] = byte(acc >> bits)
+			n++
+		}
+	}
+	return u, nil
+}
+
+// Time returns the Unix time of u in milliseconds.
+func (u ULID) Time() int64 {
+	var ms int64
+	for _, b := range u[:6] {
+		ms = ms<<8 | int64(b)
+	}
+	return ms
+}
+
+// Entropy returns the random part of u.
+func (u ULID) Entropy() []byte {
+	return u[6:]
+}
+
+// String returns the canonical, upper-case encoding of u.
+func (u ULID) String() string {
+	var bs [EncodedLen]byte
+
+	// Encode the 128 bits from the end, in groups of 5.
+	var acc uint
+	var bits int
+	j := len(bs) - 1
+	for i := len(u) - 1; i >= 0; i-- {
+		acc |= uint(u[i]) << bits
+		bits += 8
+		for bits >= 5 {
+			bs[j] = alphabet[acc&0x1f]
+			j--
+			acc >>= 5
+			bits -= 5
+		}
+	}
+	bs[0] = alphabet[acc&0x1f]
+	return string(bs[:])
+}
@@ -0,0 +1,52 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package ulid
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	entropy := bytes.Repeat([]byte{0xff}, 10)
+	u, err := New(bytes.NewReader(entropy), time.UnixMilli(1469922850259))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "01ARZ3NDEKZZZZZZZZZZZZZZZZ"; u.String() != exp {
+		t.Fatalf("Expected %v but got %v", exp, u)
+	}
+
+	if _, err := New(bytes.NewReader(nil), time.Now()); err == nil {
+		t.Fatal("Expected error for missing entropy")
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, s := range []string{"01ARZ3NDEKTSV4RRFFQ69G5FAV", "00000000000000000000000000", "7ZZZZZZZZZZZZZZZZZZZZZZZZZ"} {
+		u, err := Parse(s)
+		if err != nil {
+			t.Fatalf("%v: %v", s, err)
+		}
+		if u.String() != s {
+			t.Fatalf("Expected %v to round trip but got %v", s, u)
+		}
+	}
+
+	u, err := Parse("01arz3ndektsv4rrffq69g5fav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Time() != 1469922850259 {
+		t.Fatalf("Expected time 1469922850259 but got %v", u.Time())
+	}
+
+	for _, s := range []string{"", "01ARZ3NDEKTSV4RRFFQ69G5FA", "01ARZ3NDEKTSV4RRFFQ69G5FAVX", "01ARZ3NDEKTSV4RRFFQ69G5FAU", "80000000000000000000000000"} {
+		if _, err := Parse(s); err == nil {
+			t.Fatalf("Expected error for %q", s)
+		}
+	}
+}
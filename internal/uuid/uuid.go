@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -28,6 +29,24 @@ func New(r io.Reader) (string, error) {
 	return fmt.Sprintf("%x-%x-%x-%x-%x", bs[0:4], bs[4:6], bs[6:8], bs[8:10], bs[10:]), nil
 }
 
+// NewV7 Create a version 7 UUID, ordered by the Unix time t in milliseconds
+// and random otherwise
+// ref: https://datatracker.ietf.org/doc/html/rfc9562#section-5.7
+func NewV7(r io.Reader, t time.Time) (string, error) {
+	bs := make([]byte, 16)
+	n, err := io.ReadFull(r, bs[6:])
+	if n != len(bs)-6 || err != nil {
+		return "", err
+	}
+	ms := uint64(t.UnixMilli())
+	for i := 0; i < 6; i++ {
+		bs[i] = byte(ms >> (40 - 8*i))
+	}
+	bs[8] = bs[8]&^0xc0 | 0x80
+	bs[6] = bs[6]&^0xf0 | 0x70
+	return fmt.Sprintf("%x-%x-%x-%x-%x", bs[0:4], bs[4:6], bs[6:8], bs[8:10], bs[10:]), nil
+}
+
 // Parse will use the google/uuid library to parse the string into a uuid
 // if parsing fails, it will return an empty map. It will fill the map
 // with some decoded values with fillMap
@@ -44,7 +63,8 @@ func Parse(s string) (map[string]interface{}, error) {
 
 // Fills the map with values from the uuid. Version and variant for every version.
 // Version 1-2 has decodable values that could be of use, version 4 is random,
-// and version 3,5 is not feasible to extract data. Generated with either MD5 or SHA1 hash
+// and version 3,5 is not feasible to extract data. Generated with either MD5 or SHA1 hash.
+// Version 7 encodes the Unix time in milliseconds
 // ref: https://datatracker.ietf.org/doc/html/rfc4122 about creation of UUIDs
 func fillMap(m map[string]interface{}, u uuid.UUID) {
 	m["version"] = int(u.Version())
@@ -59,6 +79,8 @@ func fillMap(m map[string]interface{}, u uuid.UUID) {
 			m["id"] = int(u.ID())
 			m["domain"] = u.Domain().String()
 		}
+	case 7:
+		m["time"] = unixMilli(u) * int64(time.Millisecond)
 	}
 }
 
@@ -101,6 +123,15 @@ func nanoUnix(t uuid.Time) int64 {
 	return unixsec*BILLION + unixnsec
 }
 
+// unixMilli Returns the time of a version 7 uuid, its first 48 bits
+func unixMilli(u uuid.UUID) int64 {
+	var ms int64
+	for _, b := range u[:6] {
+		ms = ms<<8 | int64(b)
+	}
+	return ms
+}
+
 // Helper function to make map with length based on version of uuid
 // Most are 2 in length (version, variant), but version 1 and 2 have more.
 func getVersionLen(version int) int {
@@ -109,6 +140,8 @@ func getVersionLen(version int) int {
 		return 5
 	case 2:
 		return 7
+	case 7:
+		return 3
 	default:
 		return 2
 	}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestUUID4(t *testing.T) {
@@ -21,6 +22,17 @@ func TestUUID4(t *testing.T) {
 	}
 }
 
+func TestUUID7(t *testing.T) {
+	uuid, err := NewV7(bytes.NewReader(make([]byte, 10)), time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "018cc251-f400-7000-8000-000000000000"
+	if uuid != expect {
+		t.Errorf("Expected %q, got %q", expect, uuid)
+	}
+}
+
 func TestParseTrue(t *testing.T) {
 	var tests = []struct {
 		name  string
@@ -73,6 +85,15 @@ func TestParseTrue(t *testing.T) {
 				"variant": "RFC4122",
 			},
 		},
+		{
+			"Test uuid 7",
+			"018cc251-f400-7cd1-84e0-9bab06a52ece",
+			map[string]interface{}{
+				"version": 7,
+				"variant": "RFC4122",
+				"time":    int64(1704067200000000000),
+			},
+		},
 		{
 			"Test future version and variant",
 			"00000000-0000-fcd1-f4e0-9bab06a52ece",
@@ -197,3 +197,13 @@ type I32Sub struct {
 func (I32Sub) Op() opcode.Opcode {
 	return opcode.I32Sub
 }
+
+// I32And represents the WASM i32.and instruction.
+type I32And struct {
+	NoImmediateArgs
+}
+
+// Op returns the opcode of the instruction.
+func (I32And) Op() opcode.Opcode {
+	return opcode.I32And
+}
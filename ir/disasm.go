@@ -0,0 +1,252 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package ir
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Disassemble writes a human-readable, assembly-like listing of the policy to
+// w. Unlike Pretty, which dumps the IR tree, the listing resolves string
+// constants and source locations, and renders each statement on a single line:
+//
+//	plan test/allow
+//	  block
+//	    call g0.data.test.allow(%0, %1) -> %2  # test.rego:3
+//	    assign_var %2 -> %3
+//	    ...
+//
+// Locals are written as %N, string constants are written as quoted strings.
+func Disassemble(w io.Writer, policy *Policy) error {
+	d := &disassembler{w: w}
+	if policy.Static != nil {
+		d.static = policy.Static
+	} else {
+		d.static = &Static{}
+	}
+
+	d.writeStatic()
+	if policy.Plans != nil {
+		for _, p := range policy.Plans.Plans {
+			d.line(0, "plan %v", p.Name)
+			d.blocks(1, p.Blocks)
+		}
+	}
+	if policy.Funcs != nil {
+		for _, f := range policy.Funcs.Funcs {
+			d.writeFunc(f)
+		}
+	}
+	return d.err
+}
+
+type disassembler struct {
+	w      io.Writer
+	static *Static
+	err    error
+}
+
+func (d *disassembler) line(depth int, f string, a ...interface{}) {
+	if d.err != nil {
+		return
+	}
+	_, d.err = fmt.Fprintf(d.w, strings.Repeat("  ", depth)+f+"\n", a...)
+}
+
+func (d *disassembler) writeStatic() {
+	if len(d.static.Strings) > 0 {
+		d.line(0, "strings")
+		for i, s := range d.static.Strings {
+			d.line(1, "%d %v", i, strconv.Quote(s.Value))
+		}
+	}
+	if len(d.static.BuiltinFuncs) > 0 {
+		d.line(0, "builtin_funcs")
+		for _, f := range d.static.BuiltinFuncs {
+			d.line(1, "%v", f.Name)
+		}
+	}
+	if len(d.static.Files) > 0 {
+		d.line(0, "files")
+		for i, f := range d.static.Files {
+			d.line(1, "%d %v", i, strconv.Quote(f.Value))
+		}
+	}
+}
+
+func (d *disassembler) writeFunc(f *Func) {
+	params := make([]string, len(f.Params))
+	for i, p := range f.Params {
+		params[i] = d.local(p)
+	}
+	d.line(0, "func %v(%v) -> %v", f.Name, strings.Join(params, ", "), d.local(f.Return))
+	if len(f.Path) > 0 {
+		d.line(1, "path %v", strings.Join(f.Path, "."))
+	}
+	d.blocks(1, f.Blocks)
+}
+
+func (d *disassembler) blocks(depth int, blocks []*Block) {
+	for _, b := range blocks {
+		d.block(depth, b)
+	}
+}
+
+func (d *disassembler) block(depth int, b *Block) {
+	d.line(depth, "block")
+	for _, s := range b.Stmts {
+		d.stmt(depth+1, s)
+	}
+}
+
+func (d *disassembler) stmt(depth int, s Stmt) {
+	var text string
+	var blocks []*Block
+
+	switch s := s.(type) {
+	case *ReturnLocalStmt:
+		text = fmt.Sprintf("return_local %v", d.local(s.Source))
+	case *CallStmt:
+		text = fmt.Sprintf("call %v(%v) -> %v", s.Func, d.operands(s.Args), d.local(s.Result))
+	case *CallDynamicStmt:
+		args := make([]string, len(s.Args))
+		for i, a := range s.Args {
+			args[i] = d.local(a)
+		}
+		text = fmt.Sprintf("call_dynamic [%v](%v) -> %v", d.operands(s.Path), strings.Join(args, ", "), d.local(s.Result))
+	case *BlockStmt:
+		text = "block_stmt"
+		blocks = s.Blocks
+	case *BreakStmt:
+		text = fmt.Sprintf("break %d", s.Index)
+	case *DotStmt:
+		text = fmt.Sprintf("dot %v %v -> %v", d.operand(s.Source), d.operand(s.Key), d.local(s.Target))
+	case *LenStmt:
+		text = fmt.Sprintf("len %v -> %v", d.operand(s.Source), d.local(s.Target))
+	case *ScanStmt:
+		text = fmt.Sprintf("scan %v -> %v %v", d.local(s.Source), d.local(s.Key), d.local(s.Value))
+		blocks = []*Block{s.Block}
+	case *NotStmt:
+		text = "not"
+		blocks = []*Block{s.Block}
+	case *AssignIntStmt:
+		text = fmt.Sprintf("assign_int %d -> %v", s.Value, d.local(s.Target))
+	case *AssignVarStmt:
+		text = fmt.Sprintf("assign_var %v -> %v", d.operand(s.Source), d.local(s.Target))
+	case *AssignVarOnceStmt:
+		text = fmt.Sprintf("assign_var_once %v -> %v", d.operand(s.Source), d.local(s.Target))
+	case *ResetLocalStmt:
+		text = fmt.Sprintf("reset_local %v", d.local(s.Target))
+	case *MakeNullStmt:
+		text = fmt.Sprintf("make_null -> %v", d.local(s.Target))
+	case *MakeNumberIntStmt:
+		text = fmt.Sprintf("make_number_int %d -> %v", s.Value, d.local(s.Target))
+	case *MakeNumberRefStmt:
+		text = fmt.Sprintf("make_number_ref %v -> %v", d.str(s.Index), d.local(s.Target))
+	case *MakeArrayStmt:
+		text = fmt.Sprintf("make_array %d -> %v", s.Capacity, d.local(s.Target))
+	case *MakeObjectStmt:
+		text = fmt.Sprintf("make_object -> %v", d.local(s.Target))
+	case *MakeSetStmt:
+		text = fmt.Sprintf("make_set -> %v", d.local(s.Target))
+	case *EqualStmt:
+		text = fmt.Sprintf("equal %v %v", d.operand(s.A), d.operand(s.B))
+	case *NotEqualStmt:
+		text = fmt.Sprintf("not_equal %v %v", d.operand(s.A), d.operand(s.B))
+	case *IsArrayStmt:
+		text = fmt.Sprintf("is_array %v", d.operand(s.Source))
+	case *IsObjectStmt:
+		text = fmt.Sprintf("is_object %v", d.operand(s.Source))
+	case *IsSetStmt:
+		text = fmt.Sprintf("is_set %v", d.operand(s.Source))
+	case *IsDefinedStmt:
+		text = fmt.Sprintf("is_defined %v", d.local(s.Source))
+	case *IsUndefinedStmt:
+		text = fmt.Sprintf("is_undefined %v", d.local(s.Source))
+	case *ArrayAppendStmt:
+		text = fmt.Sprintf("array_append %v -> %v", d.operand(s.Value), d.local(s.Array))
+	case *ObjectInsertStmt:
+		text = fmt.Sprintf("object_insert %v %v -> %v", d.operand(s.Key), d.operand(s.Value), d.local(s.Object))
+	case *ObjectInsertOnceStmt:
+		text = fmt.Sprintf("object_insert_once %v %v -> %v", d.operand(s.Key), d.operand(s.Value), d.local(s.Object))
+	case *ObjectMergeStmt:
+		text = fmt.Sprintf("object_merge %v %v -> %v", d.local(s.A), d.local(s.B), d.local(s.Target))
+	case *SetAddStmt:
+		text = fmt.Sprintf("set_add %v -> %v", d.operand(s.Value), d.local(s.Set))
+	case *WithStmt:
+		path := make([]string, len(s.Path))
+		for i, p := range s.Path {
+			path[i] = d.str(p)
+		}
+		text = fmt.Sprintf("with %v[%v] %v", d.local(s.Local), strings.Join(path, ", "), d.operand(s.Value))
+		blocks = []*Block{s.Block}
+	case *NopStmt:
+		text = "nop"
+	case *ResultSetAddStmt:
+		text = fmt.Sprintf("result_set_add %v", d.local(s.Value))
+	default:
+		text = fmt.Sprintf("%T", s)
+	}
+
+	if loc := d.location(s.GetLocation()); loc != "" {
+		text += "  # " + loc
+	}
+	d.line(depth, "%v", text)
+	d.blocks(depth+1, blocks)
+}
+
+func (*disassembler) local(l Local) string {
+	return "%" + strconv.Itoa(int(l))
+}
+
+func (d *disassembler) str(i int) string {
+	if i >= 0 && i < len(d.static.Strings) {
+		return strconv.Quote(d.static.Strings[i].Value)
+	}
+	return StringIndex(i).String()
+}
+
+func (d *disassembler) operand(o Operand) string {
+	// NOTE: Unmarshaled operands hold pointers to their values.
+	switch v := o.Value.(type) {
+	case Local:
+		return d.local(v)
+	case *Local:
+		return d.local(*v)
+	case StringIndex:
+		return d.str(int(v))
+	case *StringIndex:
+		return d.str(int(*v))
+	case Bool:
+		return strconv.FormatBool(bool(v))
+	case *Bool:
+		return strconv.FormatBool(bool(*v))
+	case nil:
+		return "<nil>"
+	default:
+		return v.String()
+	}
+}
+
+func (d *disassembler) operands(os []Operand) string {
+	strs := make([]string, len(os))
+	for i, o := range os {
+		strs[i] = d.operand(o)
+	}
+	return strings.Join(strs, ", ")
+}
+
+func (d *disassembler) location(loc *Location) string {
+	if loc == nil || loc.Row == 0 {
+		return ""
+	}
+	if loc.File >= 0 && loc.File < len(d.static.Files) {
+		return fmt.Sprintf("%v:%d", d.static.Files[loc.File].Value, loc.Row)
+	}
+	return fmt.Sprintf("%d", loc.Row)
+}
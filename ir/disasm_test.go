@@ -0,0 +1,94 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package ir_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/internal/planner"
+	"github.com/open-policy-agent/opa/ir"
+)
+
+func TestDisassemble(t *testing.T) {
+
+	c := ast.MustCompileModules(map[string]string{
+		"test.rego": `package test
+
+p {
+	input.foo == "bar"
+}`,
+	})
+
+	policy, err := planner.New().
+		WithQueries([]planner.QuerySet{
+			{
+				Name:    "test/p",
+				Queries: []ast.Body{ast.MustParseBody(`data.test.p = result`)},
+			},
+		}).
+		WithModules([]*ast.Module{c.Modules["test.rego"]}).
+		WithBuiltinDecls(ast.BuiltinMap).
+		Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `strings
+  0 "result"
+  1 "foo"
+  2 "bar"
+files
+  0 "test.rego"
+  1 "<query>"
+plan test/p
+  block
+    call g0.data.test.p(%0, %1) -> %2  # <query>:1
+    assign_var %2 -> %3  # <query>:1
+    make_object -> %4  # <query>:1
+    object_insert "result" %3 -> %4  # <query>:1
+    result_set_add %4  # <query>:1
+func g0.data.test.p(%0, %1) -> %2
+  path g0.test.p
+  block
+    reset_local %3  # test.rego:3
+    dot %0 "foo" -> %4  # test.rego:4
+    equal %4 "bar"  # test.rego:4
+    assign_var_once true -> %3  # test.rego:3
+  block
+    is_defined %3  # test.rego:3
+    assign_var_once %3 -> %2  # test.rego:3
+  block
+    return_local %2  # test.rego:3
+`
+
+	var buf bytes.Buffer
+	if err := ir.Disassemble(&buf, policy); err != nil {
+		t.Fatal(err)
+	}
+	if act := buf.String(); act != exp {
+		t.Fatalf("expected:\n\n%v\n\ngot:\n\n%v", exp, act)
+	}
+
+	// unmarshaled policies hold pointers in their operands
+	bs, err := json.Marshal(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cpy ir.Policy
+	if err := json.Unmarshal(bs, &cpy); err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	if err := ir.Disassemble(&buf, &cpy); err != nil {
+		t.Fatal(err)
+	}
+	if act := buf.String(); act != exp {
+		t.Fatalf("expected:\n\n%v\n\ngot:\n\n%v", exp, act)
+	}
+}
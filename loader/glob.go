@@ -0,0 +1,164 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package loader
+
+import (
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// exclusionPrefix marks a path as a pattern of files and directories to exclude.
+const exclusionPrefix = "!"
+
+// SplitExclusions returns the paths other than negative patterns, i.e., the
+// paths prefixed with "!", and a filter that excludes the files and directories
+// excluded by filter or matched by any of the negative patterns. See
+// GlobExcludePath for the syntax of the patterns.
+func SplitExclusions(paths []string, filter Filter) ([]string, Filter) {
+	var rest []string
+	filters := []Filter{filter}
+
+	for _, p := range paths {
+		if pattern, ok := strings.CutPrefix(p, exclusionPrefix); ok {
+			filters = append(filters, GlobExcludePath(pattern))
+		} else {
+			rest = append(rest, p)
+		}
+	}
+
+	return rest, anyFilter(filters...)
+}
+
+// GlobExcludePath excludes files and directories whose path matches pattern.
+// The pattern syntax is that of filepath.Match, except that a "**" element
+// of the pattern matches zero or more directories.
+func GlobExcludePath(pattern string) Filter {
+	elems := splitPath(pattern)
+	return func(p string, _ fs.FileInfo, _ int) bool {
+		return matchPath(elems, splitPath(p))
+	}
+}
+
+// GlobBase returns the directory that the files matched by the glob pattern
+// path are loaded from, i.e., its leading elements without meta characters.
+// Other paths are returned unchanged.
+func GlobBase(p string) string {
+	if !isGlob(p) {
+		return p
+	}
+
+	elems := splitPath(p)
+	i := 0
+	for i < len(elems) && !hasMeta(elems[i]) {
+		i++
+	}
+
+	switch base := strings.Join(elems[:i], "/"); {
+	case base != "":
+		return filepath.FromSlash(base)
+	case i > 0:
+		return string(filepath.Separator)
+	default:
+		return "."
+	}
+}
+
+// globFilter excludes the files whose path does not match the glob pattern, and
+// the directories that cannot contain such files.
+func globFilter(pattern string) Filter {
+	elems := splitPath(pattern)
+	return func(p string, info fs.FileInfo, _ int) bool {
+		if info.IsDir() {
+			return !matchDirPath(elems, splitPath(p))
+		}
+		return !matchPath(elems, splitPath(p))
+	}
+}
+
+// isGlob returns true if p is a glob pattern rather than the path of a file or
+// directory. URLs are never glob patterns.
+func isGlob(p string) bool {
+	return hasMeta(p) && !strings.Contains(p, "://")
+}
+
+func hasMeta(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+func splitPath(p string) []string {
+	return strings.Split(filepath.ToSlash(filepath.Clean(p)), "/")
+}
+
+// matchPath returns true if the elements of a path match the elements of a
+// pattern.
+func matchPath(pattern, elems []string) bool {
+	if len(pattern) == 0 {
+		return len(elems) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(elems); i++ {
+			if matchPath(pattern[1:], elems[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(elems) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], elems[0]); !ok {
+		return false
+	}
+	return matchPath(pattern[1:], elems[1:])
+}
+
+// matchDirPath returns true if the elements of a directory path match leading
+// elements of a pattern, so that files in the directory may match the pattern.
+func matchDirPath(pattern, elems []string) bool {
+	if len(elems) == 1 && elems[0] == "." {
+		return true
+	}
+	for i, e := range elems {
+		if i == len(pattern) {
+			return false
+		}
+		if pattern[i] == "**" {
+			return true
+		}
+		if ok, _ := path.Match(pattern[i], e); !ok {
+			return false
+		}
+	}
+	return len(elems) < len(pattern)
+}
+
+// anyFilter excludes the files and directories excluded by any of filters. It
+// returns nil if all filters are nil.
+func anyFilter(filters ...Filter) Filter {
+	var nonNil []Filter
+	for _, f := range filters {
+		if f != nil {
+			nonNil = append(nonNil, f)
+		}
+	}
+
+	switch len(nonNil) {
+	case 0:
+		return nil
+	case 1:
+		return nonNil[0]
+	}
+
+	return func(p string, info fs.FileInfo, depth int) bool {
+		for _, f := range nonNil {
+			if f(p, info, depth) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -3,6 +3,21 @@
 // license that can be found in the LICENSE file.
 
 // Package loader contains utilities for loading files into OPA.
+//
+// Data is loaded from JSON (.json), JSON Lines (.jsonl and .ndjson) and YAML
+// (.yaml and .yml) files. A JSON Lines file is loaded as an array, with the
+// value on the n-th non-empty line of the file at index n-1. A YAML file with
+// multiple documents separated by "---" is loaded as an array as well, with
+// the n-th document of the file at index n-1. Empty documents are loaded as
+// null. A YAML file with a single document is loaded as that document.
+// Large JSON files are decoded while they are read, rather than read in full
+// first.
+//
+// Paths may be glob patterns, e.g. "policies/**/*.rego", where "**" matches
+// zero or more directories. A glob pattern loads the files it matches as if
+// the directory named by its leading elements without meta characters was
+// loaded. Paths prefixed with "!", e.g. "!**/*_test.rego", are patterns of
+// files and directories to exclude from all other paths.
 package loader
 
 import (
@@ -103,6 +118,7 @@ type FileLoader interface {
 	WithCapabilities(*ast.Capabilities) FileLoader
 	WithJSONOptions(*astJSON.Options) FileLoader
 	WithRegoVersion(ast.RegoVersion) FileLoader
+	WithModuleCache(ast.ModuleCache) FileLoader
 }
 
 // NewFileLoader returns a new FileLoader instance.
@@ -188,6 +204,12 @@ func (fl *fileLoader) WithRegoVersion(version ast.RegoVersion) FileLoader {
 	return fl
 }
 
+// WithModuleCache sets the cache used to look up and store parsed modules.
+func (fl *fileLoader) WithModuleCache(cache ast.ModuleCache) FileLoader {
+	fl.opts.ModuleCache = cache
+	return fl
+}
+
 // All returns a Result object loaded (recursively) from the specified paths.
 func (fl fileLoader) All(paths []string) (*Result, error) {
 	return fl.Filtered(paths, nil)
@@ -199,6 +221,10 @@ func (fl fileLoader) All(paths []string) (*Result, error) {
 func (fl fileLoader) Filtered(paths []string, filter Filter) (*Result, error) {
 	return all(fl.fsys, paths, filter, func(curr *Result, path string, depth int) error {
 
+		if streamJSON(fl.fsys, path) {
+			return loadJSONStream(fl.fsys, curr, path, fl.metrics)
+		}
+
 		var (
 			bs  []byte
 			err error
@@ -257,7 +283,8 @@ func (fl fileLoader) AsBundle(path string) (*bundle.Bundle, error) {
 		WithProcessAnnotations(fl.opts.ProcessAnnotation).
 		WithCapabilities(fl.opts.Capabilities).
 		WithJSONOptions(fl.opts.JSONOptions).
-		WithRegoVersion(fl.opts.RegoVersion)
+		WithRegoVersion(fl.opts.RegoVersion).
+		WithModuleCache(fl.opts.ModuleCache)
 
 	// For bundle directories add the full path in front of module file names
 	// to simplify debugging.
@@ -622,6 +649,8 @@ func all(fsys fs.FS, paths []string, filter Filter, f func(*Result, string, int)
 	errs := Errors{}
 	root := newResult()
 
+	paths, filter = SplitExclusions(paths, filter)
+
 	for _, path := range paths {
 
 		// Paths can be prefixed with a string that specifies where content should be
@@ -635,7 +664,15 @@ func all(fsys fs.FS, paths []string, filter Filter, f func(*Result, string, int)
 			}
 		}
 
-		allRec(fsys, path, filter, &errs, loaded, 0, f)
+		// Glob patterns are loaded like the directory they match files in, without
+		// the files that do not match.
+		pathFilter := filter
+		if isGlob(path) {
+			pathFilter = anyFilter(filter, globFilter(path))
+			path = GlobBase(path)
+		}
+
+		allRec(fsys, path, pathFilter, &errs, loaded, 0, f)
 	}
 
 	if len(errs) > 0 {
@@ -701,6 +738,8 @@ func loadKnownTypes(path string, bs []byte, m metrics.Metrics, opts ast.ParserOp
 	switch filepath.Ext(path) {
 	case ".json":
 		return loadJSON(path, bs, m)
+	case ".jsonl", ".ndjson":
+		return loadJSONLines(path, bs, m)
 	case ".rego":
 		return loadRego(path, bs, m, opts)
 	case ".yaml", ".yml":
@@ -739,6 +778,7 @@ func loadBundleFile(path string, bs []byte, m metrics.Metrics, opts ast.ParserOp
 		WithRegoVersion(opts.RegoVersion).
 		WithJSONOptions(opts.JSONOptions).
 		WithProcessAnnotations(opts.ProcessAnnotation).
+		WithModuleCache(opts.ModuleCache).
 		WithMetrics(m).
 		WithSkipBundleVerification(true).
 		IncludeManifestInData(true)
@@ -774,7 +814,31 @@ func loadJSON(path string, bs []byte, m metrics.Metrics) (interface{}, error) {
 	return x, nil
 }
 
+func loadJSONLines(path string, bs []byte, m metrics.Metrics) (interface{}, error) {
+	m.Timer(metrics.RegoDataParse).Start()
+	defer m.Timer(metrics.RegoDataParse).Stop()
+
+	values := []interface{}{}
+	for i, line := range bytes.Split(bs, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var x interface{}
+		if err := util.UnmarshalJSON(line, &x); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+		values = append(values, x)
+	}
+	return values, nil
+}
+
 func loadYAML(path string, bs []byte, m metrics.Metrics) (interface{}, error) {
+	docs := splitYAMLDocuments(bs)
+	if len(docs) > 1 {
+		return loadYAMLDocuments(path, docs, m)
+	}
+
 	m.Timer(metrics.RegoDataParse).Start()
 	bs, err := yaml.YAMLToJSON(bs)
 	m.Timer(metrics.RegoDataParse).Stop()
@@ -784,6 +848,78 @@ func loadYAML(path string, bs []byte, m metrics.Metrics) (interface{}, error) {
 	return loadJSON(path, bs, m)
 }
 
+func loadYAMLDocuments(path string, docs [][]byte, m metrics.Metrics) (interface{}, error) {
+	values := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		m.Timer(metrics.RegoDataParse).Start()
+		bs, err := yaml.YAMLToJSON(doc)
+		m.Timer(metrics.RegoDataParse).Stop()
+		if err != nil {
+			return nil, fmt.Errorf("%v: document %d: error converting YAML to JSON: %v", path, i+1, err)
+		}
+		if values[i], err = loadJSON(path, bs, m); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// splitYAMLDocuments splits bs into the YAML documents it contains. Documents
+// start with a "---" line, except for the first one, and end at the start of
+// the next document or with a "..." line. YAML does not allow these markers
+// at the start of a line inside of documents.
+func splitYAMLDocuments(bs []byte) [][]byte {
+	var docs [][]byte
+	var doc []byte
+	explicit, ended := false, false
+
+	flush := func() {
+		// Content before the first "---" line only makes up a document if it
+		// is more than comments and directives.
+		if explicit || !isYAMLPreamble(doc) {
+			docs = append(docs, doc)
+		}
+		doc = nil
+	}
+
+	for _, line := range bytes.SplitAfter(bs, []byte("\n")) {
+		switch {
+		case isYAMLMarker(line, "---"):
+			flush()
+			explicit, ended = true, false
+		case isYAMLMarker(line, "..."):
+			ended = true
+			continue
+		case ended:
+			continue
+		}
+		doc = append(doc, line...)
+	}
+	if len(doc) > 0 || explicit {
+		flush()
+	}
+
+	return docs
+}
+
+func isYAMLMarker(line []byte, marker string) bool {
+	if !bytes.HasPrefix(line, []byte(marker)) {
+		return false
+	}
+	rest := line[len(marker):]
+	return len(rest) == 0 || rest[0] == ' ' || rest[0] == '\t' || rest[0] == '\r' || rest[0] == '\n'
+}
+
+func isYAMLPreamble(bs []byte) bool {
+	for _, line := range bytes.Split(bs, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 && line[0] != '#' && line[0] != '%' {
+			return false
+		}
+	}
+	return true
+}
+
 func makeDir(path []string, x interface{}) (map[string]interface{}, bool) {
 	if len(path) == 0 {
 		obj, ok := x.(map[string]interface{})
@@ -10,6 +10,7 @@ import (
 	"encoding/json"
 	"io"
 	"io/fs"
+	"math"
 	"os"
 	"path"
 	"path/filepath"
@@ -67,6 +68,56 @@ p = true { true }`}
 	})
 }
 
+type mapModuleCache map[string][]byte
+
+func (c mapModuleCache) Get(key string) ([]byte, bool) {
+	bs, ok := c[key]
+	return bs, ok
+}
+
+func (c mapModuleCache) Put(key string, value []byte) {
+	c[key] = value
+}
+
+func TestLoadRegoWithModuleCache(t *testing.T) {
+
+	files := map[string]string{
+		"/foo.rego": `package ex
+
+p = true { true }`,
+		"/bundle/bar.rego": `package bar
+
+q = 1`,
+	}
+
+	test.WithTempFS(files, func(rootDir string) {
+		cache := mapModuleCache{}
+		moduleFile := filepath.Join(rootDir, "foo.rego")
+
+		for i := 0; i < 2; i++ {
+			loaded, err := NewFileLoader().WithModuleCache(cache).All([]string{moduleFile})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			expected := ast.MustParseModule(files["/foo.rego"])
+			if !expected.Equal(loaded.Modules[CleanPath(moduleFile)].Parsed) {
+				t.Fatalf("Expected:\n%v\n\nGot:\n%v", expected, loaded.Modules[moduleFile])
+			}
+			if len(cache) != 1 {
+				t.Fatalf("Expected one cache entry, got %d", len(cache))
+			}
+		}
+
+		b, err := NewFileLoader().WithModuleCache(cache).AsBundle(filepath.Join(rootDir, "bundle"))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(b.Modules) != 1 || len(cache) != 2 {
+			t.Fatalf("Expected bundle module to be cached, got %d modules and %d cache entries", len(b.Modules), len(cache))
+		}
+	})
+}
+
 func TestLoadYAML(t *testing.T) {
 
 	files := map[string]string{
@@ -95,6 +146,198 @@ func TestLoadYAML(t *testing.T) {
 	})
 }
 
+func TestLoadJSONLines(t *testing.T) {
+
+	files := map[string]string{
+		"/foo.jsonl":  "{\"a\": 1}\n\n[2]\r\n\"c\"\n",
+		"/bar.ndjson": "{\"b\": 1}",
+		"/bad.jsonl":  "{\"a\": 1}\n{\"a\":\n",
+	}
+
+	test.WithTempFS(files, func(rootDir string) {
+		loaded, err := NewFileLoader().All([]string{
+			"foo:" + filepath.Join(rootDir, "foo.jsonl"),
+			"bar:" + filepath.Join(rootDir, "bar.ndjson"),
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		expected := parseJSON(`{"foo": [{"a": 1}, [2], "c"], "bar": [{"b": 1}]}`)
+		if !reflect.DeepEqual(loaded.Documents, expected) {
+			t.Fatalf("Expected %v but got: %v", expected, loaded.Documents)
+		}
+
+		_, err = NewFileLoader().All([]string{"bad:" + filepath.Join(rootDir, "bad.jsonl")})
+		if err == nil || !strings.Contains(err.Error(), "bad.jsonl:2: ") {
+			t.Fatalf("Expected error on line 2 but got: %v", err)
+		}
+	})
+}
+
+func TestLoadJSONStream(t *testing.T) {
+
+	tests := []struct {
+		note  string
+		files map[string]string
+		paths []string
+		err   string
+	}{
+		{
+			note:  "object",
+			files: map[string]string{"/a/data.json": `{"b": {"c": [1, 2.5, "x", true, null, {}]}, "d": []}`},
+			paths: []string{"a"},
+		},
+		{
+			note:  "array",
+			files: map[string]string{"/a/b/data.json": `[{"c": 1}, [2]]`},
+			paths: []string{"a"},
+		},
+		{
+			note:  "empty object with prefix",
+			files: map[string]string{"/data.json": `{}`},
+			paths: []string{"a.b:data.json"},
+		},
+		{
+			note: "merged objects",
+			files: map[string]string{
+				"/a/x.json": `{"b": {"c": 1}}`,
+				"/a/y.json": `{"b": {"d": 2}, "e": 3}`,
+			},
+			paths: []string{"a"},
+		},
+		{
+			note:  "duplicate keys",
+			files: map[string]string{"/a/data.json": `{"b": 1, "c": {"d": 1}, "b": "x", "c": {"e": 2}}`},
+			paths: []string{"a"},
+		},
+		{
+			note: "conflict",
+			files: map[string]string{
+				"/a/x.json": `{"b": {"c": 1}}`,
+				"/a/y.json": `{"b": {"c": 2}}`,
+			},
+			paths: []string{"a"},
+			err:   "a/y.json: merge error",
+		},
+		{
+			note:  "truncated",
+			files: map[string]string{"/data.json": `{"a": [1, 2`},
+			paths: []string{"data.json"},
+			err:   "data.json: unexpected end of JSON input",
+		},
+		{
+			note:  "syntax error",
+			files: map[string]string{"/data.json": `{"a": [1 2]}`},
+			paths: []string{"data.json"},
+			err:   "data.json: invalid character '2' after array element",
+		},
+		{
+			note:  "trailing value",
+			files: map[string]string{"/data.json": `{"a": 1} 2`},
+			paths: []string{"data.json"},
+			err:   "data.json: invalid character '2' after top-level value",
+		},
+	}
+
+	defer func(threshold int64) { jsonStreamThreshold = threshold }(jsonStreamThreshold)
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			test.WithTempFS(tc.files, func(rootDir string) {
+				fsys := os.DirFS(rootDir)
+
+				jsonStreamThreshold = 0
+				result, err := NewFileLoader().WithFS(fsys).All(tc.paths)
+				if tc.err != "" {
+					if err == nil || !strings.Contains(err.Error(), tc.err) {
+						t.Fatalf("Expected error %q but got %v", tc.err, err)
+					}
+					return
+				} else if err != nil {
+					t.Fatal(err)
+				}
+
+				jsonStreamThreshold = math.MaxInt64
+				exp, err := NewFileLoader().WithFS(fsys).All(tc.paths)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !reflect.DeepEqual(exp.Documents, result.Documents) {
+					t.Fatalf("Expected %v but got %v", exp.Documents, result.Documents)
+				}
+			})
+		})
+	}
+}
+
+func TestLoadYAMLDocuments(t *testing.T) {
+
+	tests := []struct {
+		note     string
+		yaml     string
+		expected string
+		err      string
+	}{
+		{
+			note:     "single document",
+			yaml:     "a: 1\n",
+			expected: `{"a": 1}`,
+		},
+		{
+			note:     "single document with markers",
+			yaml:     "# comment\n%YAML 1.1\n---\na: 1\n...\n",
+			expected: `{"a": 1}`,
+		},
+		{
+			note:     "multiple documents",
+			yaml:     "a: 1\n---\nb: 2\n--- 3\n",
+			expected: `[{"a": 1}, {"b": 2}, 3]`,
+		},
+		{
+			note:     "multiple explicit documents",
+			yaml:     "# comment\n---\na: 1\n...\n# comment\n---\n- b\n",
+			expected: `[{"a": 1}, ["b"]]`,
+		},
+		{
+			note:     "empty documents",
+			yaml:     "---\n---\na: 1\n---\n",
+			expected: `[null, {"a": 1}, null]`,
+		},
+		{
+			note:     "block scalar",
+			yaml:     "--- |\n  a\n  ---\n---\nb\n",
+			expected: `["a\n---\n", "b"]`,
+		},
+		{
+			note: "error",
+			yaml: "a: 1\n---\nb: [\n",
+			err:  "document 2: error converting YAML to JSON",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			test.WithTempFS(map[string]string{"/foo.yaml": tc.yaml}, func(rootDir string) {
+				loaded, err := NewFileLoader().All([]string{"foo:" + filepath.Join(rootDir, "foo.yaml")})
+				if tc.err != "" {
+					if err == nil || !strings.Contains(err.Error(), tc.err) {
+						t.Fatalf("Expected error %q but got: %v", tc.err, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				expected := parseJSON(`{"foo": ` + tc.expected + `}`)
+				if !reflect.DeepEqual(loaded.Documents, expected) {
+					t.Fatalf("Expected %v but got: %v", expected, loaded.Documents)
+				}
+			})
+		})
+	}
+}
+
 func TestLoadGuessYAML(t *testing.T) {
 	files := map[string]string{
 		"/foo": `
@@ -711,6 +954,106 @@ func TestGlobExcludeName(t *testing.T) {
 	})
 }
 
+func TestLoadGlobs(t *testing.T) {
+
+	files := map[string]string{
+		"/policies/a.rego":           "package a\np = 1",
+		"/policies/a_test.rego":      "package a\ntest_p { p == 1 }",
+		"/policies/b/b.rego":         "package b\np = 2",
+		"/policies/b/c/c.rego":       "package c\np = 3",
+		"/policies/b/c/c_test.rego":  "package c\ntest_p { p == 3 }",
+		"/policies/vendor/v.rego":    "package v\np = 4",
+		"/policies/b/data.json":      `{"x": 1}`,
+		"/policies/b/c/data.json":    `{"y": 2}`,
+		"/policies/b/c/d/other.json": `{"z": 3}`,
+	}
+
+	tests := []struct {
+		note    string
+		paths   []string
+		modules []string
+		data    string
+	}{
+		{
+			note:    "single directory",
+			paths:   []string{"policies/*.rego"},
+			modules: []string{"policies/a.rego", "policies/a_test.rego"},
+			data:    `{}`,
+		},
+		{
+			note:    "any directory",
+			paths:   []string{"policies/**/*.rego"},
+			modules: []string{"policies/a.rego", "policies/a_test.rego", "policies/b/b.rego", "policies/b/c/c.rego", "policies/b/c/c_test.rego", "policies/vendor/v.rego"},
+			data:    `{}`,
+		},
+		{
+			note:  "data rooted at the directory of the pattern",
+			paths: []string{"policies/**/data.json"},
+			data:  `{"b": {"x": 1, "c": {"y": 2}}}`,
+		},
+		{
+			note:  "prefix",
+			paths: []string{"foo:policies/b/*/data.json"},
+			data:  `{"foo": {"c": {"y": 2}}}`,
+		},
+		{
+			note:    "exclusions",
+			paths:   []string{"policies", "!**/*_test.rego", "!policies/vendor", "!**/*.json"},
+			modules: []string{"policies/a.rego", "policies/b/b.rego", "policies/b/c/c.rego"},
+			data:    `{}`,
+		},
+		{
+			note:    "glob and exclusions",
+			paths:   []string{"!**/*_test.rego", "policies/b/**/*.rego"},
+			modules: []string{"policies/b/b.rego", "policies/b/c/c.rego"},
+			data:    `{}`,
+		},
+	}
+
+	test.WithTempFS(files, func(rootDir string) {
+		for _, tc := range tests {
+			t.Run(tc.note, func(t *testing.T) {
+				result, err := NewFileLoader().WithFS(os.DirFS(rootDir)).All(tc.paths)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var modules []string
+				for name := range result.Modules {
+					modules = append(modules, name)
+				}
+				sort.Strings(modules)
+				if !reflect.DeepEqual(tc.modules, modules) {
+					t.Fatalf("Expected modules %v but got %v", tc.modules, modules)
+				}
+
+				if exp := parseJSON(tc.data); !reflect.DeepEqual(exp, result.Documents) {
+					t.Fatalf("Expected data %v but got %v", exp, result.Documents)
+				}
+			})
+		}
+	})
+}
+
+func TestGlobBase(t *testing.T) {
+	tests := map[string]string{
+		"policies":             "policies",
+		"policies/*.rego":      "policies",
+		"./policies/**/x.rego": "policies",
+		"*.rego":               ".",
+		"**/*.rego":            ".",
+		"/*.rego":              "/",
+		"/a/b/[xy].rego":       "/a/b",
+		"file:///a/*.rego":     "file:///a/*.rego",
+	}
+
+	for path, exp := range tests {
+		if result := GlobBase(path); result != filepath.FromSlash(exp) {
+			t.Errorf("Expected %v to be matched in %v but got %v", path, exp, result)
+		}
+	}
+}
+
 func TestLoadErrors(t *testing.T) {
 	files := map[string]string{
 		"/x1.json":    `{"x": [1,2,3]}`,
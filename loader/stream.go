@@ -0,0 +1,137 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package loader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/open-policy-agent/opa/loader/extension"
+	"github.com/open-policy-agent/opa/metrics"
+	"github.com/open-policy-agent/opa/util"
+)
+
+// jsonStreamThreshold is the size from which JSON files are decoded while they
+// are read, instead of being read in full before they are decoded.
+var jsonStreamThreshold int64 = 64 * 1024 * 1024
+
+// streamJSON returns true if the file at path is a JSON file large enough to be
+// decoded while it is read. Files are never streamed if a handler is registered
+// for JSON files, since the handler needs the contents of the whole file.
+func streamJSON(fsys fs.FS, path string) bool {
+	if filepath.Ext(path) != ".json" || extension.FindExtension(".json") != nil {
+		return false
+	}
+
+	var info fs.FileInfo
+	var err error
+	if fsys != nil {
+		info, err = fs.Stat(fsys, path)
+	} else {
+		info, err = os.Stat(path)
+	}
+
+	return err == nil && info.Size() >= jsonStreamThreshold
+}
+
+// loadJSONStream loads the JSON file at path into curr while it is read, so that
+// the contents of the file are never held in memory along with the documents
+// decoded from them. Like for files that are read in full, the last of the
+// members of an object with the same key wins.
+func loadJSONStream(fsys fs.FS, curr *Result, path string, m metrics.Metrics) error {
+	m.Timer(metrics.RegoDataParse).Start()
+	defer m.Timer(metrics.RegoDataParse).Stop()
+
+	var f io.ReadCloser
+	var err error
+	if fsys != nil {
+		f, err = fsys.Open(path)
+	} else {
+		f, err = os.Open(path)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := util.NewJSONDecoder(f)
+
+	tok, err := nextJSONToken(dec)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	x, err := decodeJSONValue(dec, tok)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	if tok, err := dec.Token(); err != io.EOF {
+		if err == nil {
+			err = fmt.Errorf("invalid character '%v' after top-level value", tok)
+		}
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return curr.merge(path, x)
+}
+
+// nextJSONValue decodes the next JSON value from dec token by token, so that
+// dec never buffers more than a token of the input.
+func nextJSONValue(dec *json.Decoder) (interface{}, error) {
+	tok, err := nextJSONToken(dec)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSONValue(dec, tok)
+}
+
+// decodeJSONValue decodes the JSON value starting with tok from dec.
+func decodeJSONValue(dec *json.Decoder, tok json.Token) (interface{}, error) {
+	switch tok {
+	case json.Delim('{'):
+		obj := map[string]interface{}{}
+		for dec.More() {
+			k, err := nextJSONToken(dec)
+			if err != nil {
+				return nil, err
+			}
+			v, err := nextJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj[k.(string)] = v
+		}
+		_, err := nextJSONToken(dec)
+		return obj, err
+	case json.Delim('['):
+		arr := []interface{}{}
+		for dec.More() {
+			v, err := nextJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		_, err := nextJSONToken(dec)
+		return arr, err
+	}
+	return tok, nil
+}
+
+// nextJSONToken returns the next token of dec. The end of the input is
+// unexpected, as the tokens of a value are still to be read.
+func nextJSONToken(dec *json.Decoder) (json.Token, error) {
+	tok, err := dec.Token()
+	if errors.Is(err, io.EOF) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return tok, err
+}
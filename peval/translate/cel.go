@@ -0,0 +1,108 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package translate
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/open-policy-agent/opa/rego"
+)
+
+var celMatchFuncs = map[string]string{
+	"startswith": "startsWith",
+	"endswith":   "endsWith",
+	"contains":   "contains",
+}
+
+// CEL translates pq into a Common Expression Language (CEL) expression, e.g.
+//
+//	posts.owner == "alice" || posts.public == true
+//
+// Fields are written as table.field, tables are expected to be declared as
+// variables in the CEL environment.
+func CEL(pq *rego.PartialQueries, opts Options) (string, error) {
+	c, err := parse(pq, TargetCEL, opts)
+	if err != nil {
+		return "", err
+	}
+	var buf strings.Builder
+	writeCEL(&buf, c, true)
+	return buf.String(), nil
+}
+
+func writeCEL(buf *strings.Builder, c cond, top bool) {
+	switch c := c.(type) {
+	case boolCond:
+		buf.WriteString(strconv.FormatBool(bool(c)))
+	case andCond:
+		writeCELJoin(buf, c, " && ", top)
+	case orCond:
+		writeCELJoin(buf, c, " || ", top)
+	case notCond:
+		buf.WriteString("!")
+		switch c.cond.(type) {
+		case andCond, orCond:
+			writeCEL(buf, c.cond, false)
+		default:
+			buf.WriteString("(")
+			writeCEL(buf, c.cond, false)
+			buf.WriteString(")")
+		}
+	case compareCond:
+		buf.WriteString(c.field.String())
+		buf.WriteString(" " + c.op + " ")
+		buf.WriteString(celValue(c.value))
+	case matchCond:
+		buf.WriteString(c.field.String())
+		buf.WriteString("." + celMatchFuncs[c.kind] + "(")
+		buf.WriteString(celValue(c.value))
+		buf.WriteString(")")
+	case inCond:
+		values := make([]string, len(c.values))
+		for i, v := range c.values {
+			values[i] = celValue(v)
+		}
+		buf.WriteString(c.field.String())
+		buf.WriteString(" in [" + strings.Join(values, ", ") + "]")
+	}
+}
+
+func writeCELJoin(buf *strings.Builder, cs []cond, sep string, top bool) {
+	if !top {
+		buf.WriteString("(")
+	}
+	for i, c := range cs {
+		if i > 0 {
+			buf.WriteString(sep)
+		}
+		writeCEL(buf, c, false)
+	}
+	if !top {
+		buf.WriteString(")")
+	}
+}
+
+func celValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return strconv.Quote(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		s := strconv.FormatFloat(v, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eEnN") {
+			s += ".0" // CEL double literal
+		}
+		return s
+	case field:
+		return v.String()
+	}
+	return ""
+}
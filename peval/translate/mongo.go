@@ -0,0 +1,80 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package translate
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/open-policy-agent/opa/rego"
+)
+
+var mongoOps = map[string]string{
+	"==": "$eq",
+	"!=": "$ne",
+	"<":  "$lt",
+	"<=": "$lte",
+	">":  "$gt",
+	">=": "$gte",
+}
+
+// Mongo translates pq into a MongoDB query filter, e.g.
+//
+//	{"$or": [{"owner": {"$eq": "alice"}}, {"public": {"$eq": true}}]}
+//
+// The residual queries must refer to at most one collection, fields are
+// written as dotted paths relative to it. The filter can be encoded as JSON,
+// or converted into BSON documents.
+func Mongo(pq *rego.PartialQueries, opts Options) (map[string]interface{}, error) {
+	c, err := parse(pq, TargetMongo, opts)
+	if err != nil {
+		return nil, err
+	}
+	return mongoFilter(c), nil
+}
+
+func mongoFilter(c cond) map[string]interface{} {
+	switch c := c.(type) {
+	case boolCond:
+		if c {
+			return map[string]interface{}{}
+		}
+		return map[string]interface{}{"$expr": false}
+	case andCond:
+		return map[string]interface{}{"$and": mongoFilters(c)}
+	case orCond:
+		return map[string]interface{}{"$or": mongoFilters(c)}
+	case notCond:
+		return map[string]interface{}{"$nor": []interface{}{mongoFilter(c.cond)}}
+	case compareCond:
+		return mongoField(c.field, mongoOps[c.op], c.value)
+	case matchCond:
+		pattern := regexp.QuoteMeta(c.value)
+		switch c.kind {
+		case "startswith":
+			pattern = "^" + pattern
+		case "endswith":
+			pattern += "$"
+		}
+		return mongoField(c.field, "$regex", pattern)
+	case inCond:
+		return mongoField(c.field, "$in", c.values)
+	}
+	return nil
+}
+
+func mongoFilters(cs []cond) []interface{} {
+	result := make([]interface{}, len(cs))
+	for i, c := range cs {
+		result[i] = mongoFilter(c)
+	}
+	return result
+}
+
+func mongoField(f field, op string, v interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		strings.Join(f.path, "."): map[string]interface{}{op: v},
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package translate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/open-policy-agent/opa/rego"
+)
+
+// Dialect identifies the SQL placeholder syntax.
+type Dialect int
+
+const (
+	// DialectPostgres uses numbered placeholders: $1, $2, ...
+	DialectPostgres Dialect = iota
+
+	// DialectMySQL uses positional placeholders: ?
+	DialectMySQL
+
+	// DialectSQLite uses positional placeholders: ?
+	DialectSQLite
+
+	// DialectSQLServer uses named placeholders: @p1, @p2, ...
+	DialectSQLServer
+)
+
+var sqlOps = map[string]string{
+	"==": "=",
+	"!=": "<>",
+	"<":  "<",
+	"<=": "<=",
+	">":  ">",
+	">=": ">=",
+}
+
+// likeEscape is the escape character used in LIKE patterns. It's not the
+// backslash, as backslashes need to be escaped in MySQL string literals.
+const likeEscape = "!"
+
+// SQL translates pq into a SQL WHERE clause (without the WHERE keyword). Fields
+// are written as table.column. The values are not included in the clause,
+// they are returned as arguments for the placeholders.
+func SQL(pq *rego.PartialQueries, opts Options) (string, []interface{}, error) {
+	c, err := parse(pq, TargetSQL, opts)
+	if err != nil {
+		return "", nil, err
+	}
+	w := &sqlWriter{dialect: opts.Dialect}
+	w.write(c, true)
+	return w.buf.String(), w.args, nil
+}
+
+type sqlWriter struct {
+	dialect Dialect
+	buf     strings.Builder
+	args    []interface{}
+}
+
+func (w *sqlWriter) write(c cond, top bool) {
+	switch c := c.(type) {
+	case boolCond:
+		if c {
+			w.buf.WriteString("TRUE")
+		} else {
+			w.buf.WriteString("FALSE")
+		}
+	case andCond:
+		w.writeJoin(c, " AND ", top)
+	case orCond:
+		w.writeJoin(c, " OR ", top)
+	case notCond:
+		w.buf.WriteString("NOT ")
+		w.writeParens(c.cond)
+	case compareCond:
+		w.buf.WriteString(c.field.String())
+		switch v := c.value.(type) {
+		case nil:
+			if c.op == "==" {
+				w.buf.WriteString(" IS NULL")
+			} else {
+				w.buf.WriteString(" IS NOT NULL")
+			}
+		case field:
+			fmt.Fprintf(&w.buf, " %v %v", sqlOps[c.op], v)
+		default:
+			fmt.Fprintf(&w.buf, " %v %v", sqlOps[c.op], w.arg(v))
+		}
+	case matchCond:
+		pattern := strings.NewReplacer(likeEscape, likeEscape+likeEscape, "%", likeEscape+"%", "_", likeEscape+"_").Replace(c.value)
+		switch c.kind {
+		case "startswith":
+			pattern += "%"
+		case "endswith":
+			pattern = "%" + pattern
+		default:
+			pattern = "%" + pattern + "%"
+		}
+		fmt.Fprintf(&w.buf, "%v LIKE %v ESCAPE '%v'", c.field, w.arg(pattern), likeEscape)
+	case inCond:
+		if len(c.values) == 0 {
+			w.buf.WriteString("FALSE")
+			return
+		}
+		placeholders := make([]string, len(c.values))
+		for i, v := range c.values {
+			placeholders[i] = w.arg(v)
+		}
+		fmt.Fprintf(&w.buf, "%v IN (%v)", c.field, strings.Join(placeholders, ", "))
+	}
+}
+
+func (w *sqlWriter) writeJoin(cs []cond, sep string, top bool) {
+	if !top {
+		w.buf.WriteString("(")
+	}
+	for i, c := range cs {
+		if i > 0 {
+			w.buf.WriteString(sep)
+		}
+		w.write(c, false)
+	}
+	if !top {
+		w.buf.WriteString(")")
+	}
+}
+
+func (w *sqlWriter) writeParens(c cond) {
+	switch c.(type) {
+	case andCond, orCond:
+		w.write(c, false)
+	default:
+		w.buf.WriteString("(")
+		w.write(c, false)
+		w.buf.WriteString(")")
+	}
+}
+
+// arg adds v to the arguments, and returns its placeholder.
+func (w *sqlWriter) arg(v interface{}) string {
+	w.args = append(w.args, v)
+	n := strconv.Itoa(len(w.args))
+	switch w.dialect {
+	case DialectMySQL, DialectSQLite:
+		return "?"
+	case DialectSQLServer:
+		return "@p" + n
+	default:
+		return "$" + n
+	}
+}
@@ -0,0 +1,479 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package translate converts the residual queries produced by partial
+// evaluation (see rego.Rego#Partial) into filters for data stores: SQL WHERE
+// clauses, MongoDB query filters, and CEL expressions.
+//
+// The residual queries are treated as a disjunction of conjunctions: the filter
+// matches a record if any of the queries is satisfied. Each unknown, e.g.
+// data.posts, refers to a table (or collection), and refs with the unknown as
+// their prefix, e.g. data.posts.owner, refer to its fields. The following
+// expressions are supported:
+//
+//	field == value, field != value, field < value, field <= value, ...
+//	field                      (the field is true)
+//	field in {value, ...}      (internal.member_2)
+//	startswith(field, value), endswith(field, value), contains(field, value)
+//	not expr
+//
+// Values must be scalars. Table and field names must be identifiers matching
+// [A-Za-z_][A-Za-z0-9_]*, as they are written into the filters verbatim.
+// Support rules, with modifiers, and any other expressions cannot be
+// translated, use Check to detect them up front.
+package translate
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+)
+
+// Target identifies the language that residual queries are translated into.
+type Target string
+
+const (
+	// TargetSQL translates into SQL WHERE clauses.
+	TargetSQL Target = "sql"
+
+	// TargetMongo translates into MongoDB query filters.
+	TargetMongo Target = "mongo"
+
+	// TargetCEL translates into Common Expression Language (CEL) expressions.
+	TargetCEL Target = "cel"
+)
+
+// Options controls the translation.
+type Options struct {
+	// Unknowns are the refs that were unknown during partial evaluation, e.g.
+	// "data.posts". The last element of each ref names the table or collection.
+	Unknowns []string
+
+	// Dialect controls the SQL placeholder syntax. Defaults to Postgres.
+	Dialect Dialect
+}
+
+// Error represents an expression that cannot be translated.
+type Error struct {
+	Target   Target        `json:"target"`
+	Message  string        `json:"message"`
+	Location *ast.Location `json:"location,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if e.Location != nil {
+		return fmt.Sprintf("%v: %v: %v", e.Location, e.Target, e.Message)
+	}
+	return fmt.Sprintf("%v: %v", e.Target, e.Message)
+}
+
+// Errors represents a list of expressions that cannot be translated.
+type Errors []*Error
+
+func (errs Errors) Error() string {
+	if len(errs) == 0 {
+		return "no error(s)"
+	}
+	if len(errs) == 1 {
+		return fmt.Sprintf("1 error occurred: %v", errs[0].Error())
+	}
+	buf := []string{fmt.Sprintf("%v errors occurred:", len(errs))}
+	for _, err := range errs {
+		buf = append(buf, err.Error())
+	}
+	return strings.Join(buf, "\n")
+}
+
+// Check returns the expressions of pq that cannot be translated for target, or
+// nil if pq can be translated.
+func Check(pq *rego.PartialQueries, target Target, opts Options) error {
+	_, err := parse(pq, target, opts)
+	return err
+}
+
+// field is a reference to a field of a table.
+type field struct {
+	table string
+	path  []string
+}
+
+func (f field) String() string {
+	return f.table + "." + strings.Join(f.path, ".")
+}
+
+// The conditions that residual queries are translated into.
+type (
+	cond interface{}
+
+	// boolCond is a constant condition: it matches all records if true, none
+	// otherwise.
+	boolCond bool
+
+	andCond []cond
+
+	orCond []cond
+
+	notCond struct {
+		cond cond
+	}
+
+	// compareCond compares a field with a value, or another field.
+	compareCond struct {
+		op    string // one of "==", "!=", "<", "<=", ">", ">="
+		field field
+		value interface{} // nil, bool, string, int64, float64 or field
+	}
+
+	// matchCond matches a string field against a prefix, suffix or substring.
+	matchCond struct {
+		kind  string // one of "startswith", "endswith", "contains"
+		field field
+		value string
+	}
+
+	inCond struct {
+		field  field
+		values []interface{}
+	}
+)
+
+var compareOps = map[string]string{
+	ast.Equality.Name:      "==",
+	ast.Equal.Name:         "==",
+	ast.NotEqual.Name:      "!=",
+	ast.LessThan.Name:      "<",
+	ast.LessThanEq.Name:    "<=",
+	ast.GreaterThan.Name:   ">",
+	ast.GreaterThanEq.Name: ">=",
+}
+
+// flippedOps maps an operator to the one used when swapping its operands.
+var flippedOps = map[string]string{
+	"==": "==",
+	"!=": "!=",
+	"<":  ">",
+	"<=": ">=",
+	">":  "<",
+	">=": "<=",
+}
+
+// identifierRegexp matches the table and field names that can be written into
+// filters without quoting.
+var identifierRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+var matchFuncs = map[string]struct{}{
+	ast.StartsWith.Name: {},
+	ast.EndsWith.Name:   {},
+	ast.Contains.Name:   {},
+}
+
+type parser struct {
+	target   Target
+	unknowns []ast.Ref
+	tables   map[string]struct{}
+	errs     Errors
+}
+
+func parse(pq *rego.PartialQueries, target Target, opts Options) (cond, error) {
+	p := &parser{target: target, tables: map[string]struct{}{}}
+
+	for _, u := range opts.Unknowns {
+		ref, err := ast.ParseRef(u)
+		if err != nil {
+			return nil, fmt.Errorf("invalid unknown %q: %w", u, err)
+		}
+		if !ref.IsGround() || len(ref) < 2 {
+			return nil, fmt.Errorf("invalid unknown %q: expected ground ref like data.table", u)
+		}
+		if s, ok := ref[len(ref)-1].Value.(ast.String); !ok {
+			return nil, fmt.Errorf("invalid unknown %q: expected table name", u)
+		} else if !identifierRegexp.MatchString(string(s)) {
+			return nil, fmt.Errorf("invalid unknown %q: table name %q is not an identifier", u, s)
+		}
+		p.unknowns = append(p.unknowns, ref)
+	}
+
+	for _, m := range pq.Support {
+		for _, r := range m.Rules {
+			p.errorf(r.Location, "support rules are not supported: %v", r.Head.Ref())
+		}
+	}
+
+	or := make(orCond, 0, len(pq.Queries))
+	for _, q := range pq.Queries {
+		and := make(andCond, 0, len(q))
+		for _, expr := range q {
+			if c := p.expr(expr); c != nil {
+				and = append(and, c)
+			}
+		}
+		or = append(or, and)
+	}
+
+	if p.target == TargetMongo && len(p.tables) > 1 {
+		p.errorf(nil, "queries must refer to at most one collection")
+	}
+
+	if len(p.errs) > 0 {
+		return nil, p.errs
+	}
+
+	return simplify(or), nil
+}
+
+func (p *parser) errorf(loc *ast.Location, f string, a ...interface{}) {
+	p.errs = append(p.errs, &Error{
+		Target:   p.target,
+		Message:  fmt.Sprintf(f, a...),
+		Location: loc,
+	})
+}
+
+func (p *parser) expr(expr *ast.Expr) cond {
+	if len(expr.With) > 0 {
+		p.errorf(expr.Location, "with modifiers are not supported: %v", expr)
+		return nil
+	}
+
+	c := p.exprTerms(expr)
+	if c == nil {
+		return nil
+	}
+	if expr.Negated {
+		return notCond{cond: c}
+	}
+	return c
+}
+
+func (p *parser) exprTerms(expr *ast.Expr) cond {
+	switch terms := expr.Terms.(type) {
+	case *ast.Term:
+		if f, ok := p.field(terms); ok {
+			if !p.checkField(expr, f) {
+				return nil
+			}
+			return compareCond{op: "==", field: f, value: true}
+		}
+		if b, ok := terms.Value.(ast.Boolean); ok {
+			return boolCond(b)
+		}
+	case []*ast.Term:
+		op := expr.Operator().String()
+		args := expr.Operands()
+		if cmp, ok := compareOps[op]; ok && len(args) == 2 {
+			return p.compare(expr, cmp, args[0], args[1])
+		}
+		if _, ok := matchFuncs[op]; ok && len(args) == 2 {
+			return p.match(expr, op, args[0], args[1])
+		}
+		if op == ast.Member.Name && len(args) == 2 {
+			return p.in(expr, args[0], args[1])
+		}
+	}
+	p.errorf(expr.Location, "expression is not supported: %v", expr)
+	return nil
+}
+
+func (p *parser) compare(expr *ast.Expr, op string, a, b *ast.Term) cond {
+	if _, ok := p.field(a); !ok {
+		a, b = b, a
+		op = flippedOps[op]
+	}
+	f, ok := p.field(a)
+	if !ok {
+		p.errorf(expr.Location, "expected field operand: %v", expr)
+		return nil
+	}
+	if g, ok := p.field(b); ok {
+		if p.target == TargetMongo {
+			p.errorf(expr.Location, "comparing fields is not supported: %v", expr)
+			return nil
+		}
+		if !p.checkField(expr, f) || !p.checkField(expr, g) {
+			return nil
+		}
+		return compareCond{op: op, field: f, value: g}
+	}
+	if !p.checkField(expr, f) {
+		return nil
+	}
+	v, ok := p.scalar(b)
+	if !ok {
+		p.errorf(expr.Location, "expected scalar operand: %v", expr)
+		return nil
+	}
+	if v == nil && op != "==" && op != "!=" {
+		p.errorf(expr.Location, "null can only be compared for equality: %v", expr)
+		return nil
+	}
+	return compareCond{op: op, field: f, value: v}
+}
+
+func (p *parser) match(expr *ast.Expr, op string, a, b *ast.Term) cond {
+	f, ok := p.field(a)
+	if !ok {
+		p.errorf(expr.Location, "expected field operand: %v", expr)
+		return nil
+	}
+	if !p.checkField(expr, f) {
+		return nil
+	}
+	s, ok := b.Value.(ast.String)
+	if !ok {
+		p.errorf(expr.Location, "expected string operand: %v", expr)
+		return nil
+	}
+	return matchCond{kind: op, field: f, value: string(s)}
+}
+
+func (p *parser) in(expr *ast.Expr, a, b *ast.Term) cond {
+	f, ok := p.field(a)
+	if !ok {
+		p.errorf(expr.Location, "expected field operand: %v", expr)
+		return nil
+	}
+	if !p.checkField(expr, f) {
+		return nil
+	}
+
+	var elems []*ast.Term
+	switch coll := b.Value.(type) {
+	case *ast.Array:
+		coll.Foreach(func(t *ast.Term) { elems = append(elems, t) })
+	case ast.Set:
+		coll.Sorted().Foreach(func(t *ast.Term) { elems = append(elems, t) })
+	default:
+		p.errorf(expr.Location, "expected array or set operand: %v", expr)
+		return nil
+	}
+
+	values := make([]interface{}, 0, len(elems))
+	for _, t := range elems {
+		v, ok := p.scalar(t)
+		if !ok || v == nil {
+			p.errorf(expr.Location, "expected non-null scalar elements: %v", expr)
+			return nil
+		}
+		values = append(values, v)
+	}
+	return inCond{field: f, values: values}
+}
+
+// field returns the field the term refers to, if it's a ref prefixed by one
+// of the unknowns.
+func (p *parser) field(t *ast.Term) (field, bool) {
+	ref, ok := t.Value.(ast.Ref)
+	if !ok {
+		return field{}, false
+	}
+	for _, u := range p.unknowns {
+		if !ref.HasPrefix(u) || len(ref) == len(u) {
+			continue
+		}
+		path := make([]string, 0, len(ref)-len(u))
+		for _, t := range ref[len(u):] {
+			s, ok := t.Value.(ast.String)
+			if !ok {
+				return field{}, false
+			}
+			path = append(path, string(s))
+		}
+		return field{table: string(u[len(u)-1].Value.(ast.String)), path: path}, true
+	}
+	return field{}, false
+}
+
+// checkField records the table of f, and returns false if f cannot be
+// translated for the target.
+func (p *parser) checkField(expr *ast.Expr, f field) bool {
+	for _, name := range f.path {
+		if !identifierRegexp.MatchString(name) {
+			p.errorf(expr.Location, "field name %q is not an identifier: %v", name, expr)
+			return false
+		}
+	}
+	if p.target == TargetSQL && len(f.path) > 1 {
+		p.errorf(expr.Location, "nested fields are not supported: %v", expr)
+		return false
+	}
+	p.tables[f.table] = struct{}{}
+	return true
+}
+
+// scalar returns the Go value of a scalar term: nil, bool, string, int64 or
+// float64.
+func (*parser) scalar(t *ast.Term) (interface{}, bool) {
+	switch v := t.Value.(type) {
+	case ast.Null:
+		return nil, true
+	case ast.Boolean:
+		return bool(v), true
+	case ast.String:
+		return string(v), true
+	case ast.Number:
+		if i, ok := v.Int64(); ok {
+			return i, true
+		}
+		if f, err := json.Number(v).Float64(); err == nil {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// simplify removes constant conditions, and unwraps single-element
+// conjunctions and disjunctions.
+func simplify(c cond) cond {
+	switch c := c.(type) {
+	case orCond:
+		result := make(orCond, 0, len(c))
+		for _, x := range c {
+			x = simplify(x)
+			if b, ok := x.(boolCond); ok {
+				if b {
+					return boolCond(true)
+				}
+				continue
+			}
+			result = append(result, x)
+		}
+		switch len(result) {
+		case 0:
+			return boolCond(false)
+		case 1:
+			return result[0]
+		}
+		return result
+	case andCond:
+		result := make(andCond, 0, len(c))
+		for _, x := range c {
+			x = simplify(x)
+			if b, ok := x.(boolCond); ok {
+				if !b {
+					return boolCond(false)
+				}
+				continue
+			}
+			result = append(result, x)
+		}
+		switch len(result) {
+		case 0:
+			return boolCond(true)
+		case 1:
+			return result[0]
+		}
+		return result
+	case notCond:
+		x := simplify(c.cond)
+		if b, ok := x.(boolCond); ok {
+			return !b
+		}
+		return notCond{cond: x}
+	}
+	return c
+}
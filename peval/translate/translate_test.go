@@ -0,0 +1,371 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package translate
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+)
+
+var testUnknowns = []string{"data.posts", "data.users"}
+
+func queries(qs ...string) *rego.PartialQueries {
+	pq := &rego.PartialQueries{}
+	for _, q := range qs {
+		if q == "" {
+			pq.Queries = append(pq.Queries, ast.Body{})
+			continue
+		}
+		pq.Queries = append(pq.Queries, ast.MustParseBody(q))
+	}
+	return pq
+}
+
+func TestSQL(t *testing.T) {
+	tests := []struct {
+		note    string
+		queries []string
+		dialect Dialect
+		exp     string
+		expArgs []interface{}
+	}{
+		{
+			note:    "no queries",
+			exp:     "FALSE",
+			expArgs: nil,
+		},
+		{
+			note:    "unconditional query",
+			queries: []string{`"bob" = data.posts.owner`, ""},
+			exp:     "TRUE",
+		},
+		{
+			note:    "comparison",
+			queries: []string{`"bob" = data.posts.owner`},
+			exp:     "posts.owner = $1",
+			expArgs: []interface{}{"bob"},
+		},
+		{
+			note:    "flipped comparison",
+			queries: []string{`lt(10, data.posts.views)`},
+			exp:     "posts.views > $1",
+			expArgs: []interface{}{int64(10)},
+		},
+		{
+			note:    "disjunction of conjunctions",
+			queries: []string{`data.posts.public; neq(data.posts.status, "draft")`, `data.posts.owner == "bob"`},
+			exp:     "(posts.public = $1 AND posts.status <> $2) OR posts.owner = $3",
+			expArgs: []interface{}{true, "draft", "bob"},
+		},
+		{
+			note:    "null, negation",
+			queries: []string{`not data.posts.deleted_at = null; data.posts.rating != null`},
+			exp:     "NOT (posts.deleted_at IS NULL) AND posts.rating IS NOT NULL",
+		},
+		{
+			note:    "like, escaped",
+			queries: []string{`startswith(data.posts.title, "100%_!")`, `contains(data.posts.title, "x")`},
+			exp:     "posts.title LIKE $1 ESCAPE '!' OR posts.title LIKE $2 ESCAPE '!'",
+			expArgs: []interface{}{"100!%!_!!%", "%x%"},
+		},
+		{
+			note:    "in, mysql",
+			queries: []string{`internal.member_2(data.posts.category, {"news", "blog"}); data.posts.views >= 1.5`},
+			dialect: DialectMySQL,
+			exp:     "posts.category IN (?, ?) AND posts.views >= ?",
+			expArgs: []interface{}{"blog", "news", 1.5},
+		},
+		{
+			note:    "fields, sql server",
+			queries: []string{`data.posts.owner = data.users.name; data.users.active = true`},
+			dialect: DialectSQLServer,
+			exp:     "posts.owner = users.name AND users.active = @p1",
+			expArgs: []interface{}{true},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			where, args, err := SQL(queries(tc.queries...), Options{Unknowns: testUnknowns, Dialect: tc.dialect})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if where != tc.exp {
+				t.Errorf("expected %q, got %q", tc.exp, where)
+			}
+			if !reflect.DeepEqual(args, tc.expArgs) {
+				t.Errorf("expected args %v, got %v", tc.expArgs, args)
+			}
+		})
+	}
+}
+
+func TestMongo(t *testing.T) {
+	tests := []struct {
+		note    string
+		queries []string
+		exp     string
+	}{
+		{
+			note: "no queries",
+			exp:  `{"$expr": false}`,
+		},
+		{
+			note:    "unconditional query",
+			queries: []string{""},
+			exp:     `{}`,
+		},
+		{
+			note:    "disjunction of conjunctions",
+			queries: []string{`data.posts.public; neq(data.posts.status, "draft")`, `"bob" = data.posts.owner`},
+			exp: `{"$or": [
+				{"$and": [{"public": {"$eq": true}}, {"status": {"$ne": "draft"}}]},
+				{"owner": {"$eq": "bob"}}
+			]}`,
+		},
+		{
+			note:    "nested fields, negation, regex, in",
+			queries: []string{`not lte(data.posts.meta.views, 10); endswith(data.posts.title, "(1)"); internal.member_2(data.posts.category, ["news", "blog"])`},
+			exp: `{"$and": [
+				{"$nor": [{"meta.views": {"$lte": 10}}]},
+				{"title": {"$regex": "\\(1\\)$"}},
+				{"category": {"$in": ["news", "blog"]}}
+			]}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			filter, err := Mongo(queries(tc.queries...), Options{Unknowns: testUnknowns})
+			if err != nil {
+				t.Fatal(err)
+			}
+			bs, err := json.Marshal(filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var act, exp interface{}
+			if err := json.Unmarshal(bs, &act); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tc.exp), &exp); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(act, exp) {
+				t.Errorf("expected %v, got %s", tc.exp, bs)
+			}
+		})
+	}
+}
+
+func TestCEL(t *testing.T) {
+	tests := []struct {
+		note    string
+		queries []string
+		exp     string
+	}{
+		{
+			note: "no queries",
+			exp:  "false",
+		},
+		{
+			note:    "disjunction of conjunctions",
+			queries: []string{`data.posts.public; neq(data.posts.status, "draft")`, `"bob" = data.posts.owner`},
+			exp:     `(posts.public == true && posts.status != "draft") || posts.owner == "bob"`,
+		},
+		{
+			note:    "functions, negation, in",
+			queries: []string{`not startswith(data.posts.title, "\"x\""); data.posts.meta.views > 2.0; internal.member_2(data.posts.category, {"news"})`},
+			exp:     `!(posts.title.startsWith("\"x\"")) && posts.meta.views > 2.0 && posts.category in ["news"]`,
+		},
+		{
+			note:    "negated conjunction, null, fields",
+			queries: []string{`data.posts.deleted_at = null; data.posts.owner = data.users.name`},
+			exp:     `posts.deleted_at == null && posts.owner == users.name`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			expr, err := CEL(queries(tc.queries...), Options{Unknowns: testUnknowns})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expr != tc.exp {
+				t.Errorf("expected %v, got %v", tc.exp, expr)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		note    string
+		pq      *rego.PartialQueries
+		target  Target
+		expErrs []string
+	}{
+		{
+			note:   "supported",
+			pq:     queries(`data.posts.owner = "bob"`),
+			target: TargetSQL,
+		},
+		{
+			note:    "unsupported function",
+			pq:      queries(`lower(data.posts.owner, "bob")`, `count(data.posts.tags, 2)`),
+			target:  TargetCEL,
+			expErrs: []string{"cel: expression is not supported: lower(data.posts.owner, \"bob\")", "cel: expression is not supported: count(data.posts.tags, 2)"},
+		},
+		{
+			note:    "unknown not referenced",
+			pq:      queries(`data.comments.owner = "bob"`),
+			target:  TargetCEL,
+			expErrs: []string{`cel: expected field operand: data.comments.owner = "bob"`},
+		},
+		{
+			note:    "non-scalar value",
+			pq:      queries(`data.posts.tags = ["a"]`),
+			target:  TargetCEL,
+			expErrs: []string{`cel: expected scalar operand: data.posts.tags = ["a"]`},
+		},
+		{
+			note:    "nested field, sql",
+			pq:      queries(`data.posts.meta.views > 10`),
+			target:  TargetSQL,
+			expErrs: []string{"sql: nested fields are not supported: gt(data.posts.meta.views, 10)"},
+		},
+		{
+			note:   "nested field, cel",
+			pq:     queries(`data.posts.meta.views > 10`),
+			target: TargetCEL,
+		},
+		{
+			note:    "fields, mongo",
+			pq:      queries(`data.posts.owner = data.users.name`),
+			target:  TargetMongo,
+			expErrs: []string{"mongo: comparing fields is not supported: data.posts.owner = data.users.name"},
+		},
+		{
+			note:    "multiple collections, mongo",
+			pq:      queries(`data.posts.owner = "bob"`, `data.users.name = "bob"`),
+			target:  TargetMongo,
+			expErrs: []string{"mongo: queries must refer to at most one collection"},
+		},
+		{
+			note:    "injected field name, sql",
+			pq:      queries(`data.posts["owner = 'bob' OR 1=1 --"] = "bob"`),
+			target:  TargetSQL,
+			expErrs: []string{`sql: field name "owner = 'bob' OR 1=1 --" is not an identifier: data.posts["owner = 'bob' OR 1=1 --"] = "bob"`},
+		},
+		{
+			note:    "injected field name, cel",
+			pq:      queries(`data.posts["owner || true"] = "bob"`),
+			target:  TargetCEL,
+			expErrs: []string{`cel: field name "owner || true" is not an identifier: data.posts["owner || true"] = "bob"`},
+		},
+		{
+			note:    "operator field name, mongo",
+			pq:      queries(`data.posts["$where"] = "bob"`),
+			target:  TargetMongo,
+			expErrs: []string{`mongo: field name "$where" is not an identifier: data.posts["$where"] = "bob"`},
+		},
+		{
+			note:    "dotted field name, mongo",
+			pq:      queries(`startswith(data.posts.meta["a.b"], "x")`),
+			target:  TargetMongo,
+			expErrs: []string{`mongo: field name "a.b" is not an identifier: startswith(data.posts.meta["a.b"], "x")`},
+		},
+		{
+			note:    "field name starting with digit, sql",
+			pq:      queries(`data.posts.owner = data.users["1name"]`),
+			target:  TargetSQL,
+			expErrs: []string{`sql: field name "1name" is not an identifier: data.posts.owner = data.users["1name"]`},
+		},
+		{
+			note: "support rules",
+			pq: &rego.PartialQueries{
+				Queries: []ast.Body{ast.MustParseBody(`data.partial.p`)},
+				Support: []*ast.Module{ast.MustParseModule("package partial\n\np { data.posts.owner = \"bob\" }")},
+			},
+			target: TargetSQL,
+			expErrs: []string{
+				"sql: support rules are not supported: p",
+				"sql: expression is not supported: data.partial.p",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			err := Check(tc.pq, tc.target, Options{Unknowns: testUnknowns})
+			if len(tc.expErrs) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			errs, ok := err.(Errors)
+			if !ok {
+				t.Fatalf("expected errors, got %v", err)
+			}
+			act := make([]string, len(errs))
+			for i, e := range errs {
+				e.Location = nil
+				act[i] = e.Error()
+			}
+			if !reflect.DeepEqual(act, tc.expErrs) {
+				t.Fatalf("expected %q, got %q", tc.expErrs, act)
+			}
+		})
+	}
+}
+
+func TestInvalidUnknowns(t *testing.T) {
+	for _, u := range []string{"data", "data.posts[x]", "data[1]", "...", `data["posts; DROP TABLE users"]`, `data["po-sts"]`} {
+		if err := Check(queries(), TargetSQL, Options{Unknowns: []string{u}}); err == nil {
+			t.Errorf("expected error for %q", u)
+		}
+	}
+}
+
+func TestPartialEval(t *testing.T) {
+	pq, err := rego.New(
+		rego.Query("data.filters.allow == true"),
+		rego.Module("test.rego", `package filters
+
+allow {
+	data.posts.owner == input.user
+}
+
+allow {
+	data.posts.public
+}
+
+allow {
+	input.user == "admin"
+}`),
+		rego.Input(map[string]interface{}{"user": "bob"}),
+		rego.Unknowns([]string{"data.posts"}),
+	).Partial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	where, args, err := SQL(pq, Options{Unknowns: []string{"data.posts"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "posts.owner = $1 OR posts.public = $2"; where != exp {
+		t.Errorf("expected %q, got %q", exp, where)
+	}
+	if exp := []interface{}{"bob", true}; !reflect.DeepEqual(args, exp) {
+		t.Errorf("expected args %v, got %v", exp, args)
+	}
+}
@@ -227,6 +227,11 @@ func TestParseAndValidateBundlesConfig(t *testing.T) {
 			services:  []string{"s1"},
 			wantError: true,
 		},
+		{
+			conf:      `{"b1":{"service": "s1", "signing": {"scope": "write", "keyless": {"ignore_tlog": true, "identities": [{"issuer": "https://accounts.example.com", "subject": "alice@example.com"}]}}}}`,
+			services:  []string{"s1"},
+			wantError: true,
+		},
 	}
 
 	keys := map[string]*keys.Config{"foo": {Key: "secret"}}
@@ -14,6 +14,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"runtime"
 	"strings"
 	"sync"
 	"time"
@@ -504,6 +505,14 @@ func (p *Plugin) process(ctx context.Context, name string, u download.Update) {
 			p.status[name].SetError(err)
 			if !p.stopped {
 				etag := p.etags[name]
+
+				// The delta bundle does not apply to the activated bundle. Drop
+				// the etag so that the server sends a full snapshot.
+				var conflict *bundle.DeltaConflictError
+				if errors.As(err, &conflict) {
+					etag = ""
+				}
+
 				p.downloaders[name].SetCache(etag)
 			}
 			return
@@ -580,10 +589,10 @@ func (p *Plugin) activate(ctx context.Context, name string, b *bundle.Bundle) er
 
 		// Compile the bundle modules with a new compiler and set it on the
 		// transaction params for use by onCommit hooks.
-		// If activating a delta bundle, use the manager's compiler which should have
-		// the polices compiled on it.
+		// If activating a delta bundle that only patches data, use the manager's
+		// compiler which should have the polices compiled on it.
 		var compiler *ast.Compiler
-		if b.Type() == bundle.DeltaBundleType {
+		if b.Type() == bundle.DeltaBundleType && len(b.Patch.Modules) == 0 {
 			compiler = p.manager.GetCompiler()
 		}
 
@@ -605,6 +614,7 @@ func (p *Plugin) activate(ctx context.Context, name string, b *bundle.Bundle) er
 			Metrics:       p.status[name].Metrics,
 			Bundles:       map[string]*bundle.Bundle{name: b},
 			ParserOptions: p.manager.ParserOptions(),
+			Parallelism:   runtime.GOMAXPROCS(0),
 		}
 
 		if p.manager.Info != nil {
@@ -621,12 +631,20 @@ func (p *Plugin) activate(ctx context.Context, name string, b *bundle.Bundle) er
 			}
 		}
 
+		if err := p.manager.BeforeBundleActivation(ctx, txn, opts.Bundles); err != nil {
+			return err
+		}
+
 		if p.config.IsMultiBundle() {
 			activateErr = bundle.Activate(opts)
 		} else {
 			activateErr = bundle.ActivateLegacy(opts)
 		}
 
+		if activateErr == nil {
+			activateErr = p.manager.AfterBundleActivation(ctx, txn, opts.Bundles)
+		}
+
 		plugins.SetCompilerOnContext(params.Context, compiler)
 
 		resolvers, err := bundleUtils.LoadWasmResolversFromStore(ctx, p.manager.Store, txn, nil)
@@ -787,6 +805,7 @@ func (fl *fileLoader) oneShot(ctx context.Context) {
 		WithBundleVerificationConfig(fl.bvc).
 		WithSizeLimitBytes(fl.sizeLimitBytes).
 		WithRegoVersion(fl.bundleParserOpts.RegoVersion).
+		WithProcessAnnotations(fl.bundleParserOpts.ProcessAnnotation).
 		Read()
 	u.Error = err
 	if err == nil {
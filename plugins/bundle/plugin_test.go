@@ -8,6 +8,7 @@ package bundle
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -111,6 +112,97 @@ func TestPluginOneShot(t *testing.T) {
 	}
 }
 
+type testActivationHook struct {
+	store  storage.Store
+	calls  []string
+	reads  []interface{}
+	err    error
+	bundle []string
+}
+
+func (h *testActivationHook) BeforeBundleActivation(ctx context.Context, txn storage.Transaction, bundles map[string]*bundle.Bundle) error {
+	return h.record(ctx, txn, "before", bundles)
+}
+
+func (h *testActivationHook) AfterBundleActivation(ctx context.Context, txn storage.Transaction, bundles map[string]*bundle.Bundle) error {
+	if err := h.record(ctx, txn, "after", bundles); err != nil {
+		return err
+	}
+	return h.err
+}
+
+func (h *testActivationHook) record(ctx context.Context, txn storage.Transaction, call string, bundles map[string]*bundle.Bundle) error {
+	h.calls = append(h.calls, call)
+	for name := range bundles {
+		h.bundle = append(h.bundle, name)
+	}
+	v, err := h.store.Read(ctx, txn, storage.MustParsePath("/foo/bar"))
+	if err != nil && !storage.IsNotFound(err) {
+		return err
+	}
+	h.reads = append(h.reads, v)
+	return nil
+}
+
+func TestPluginOneShotActivationHooks(t *testing.T) {
+
+	ctx := context.Background()
+	manager := getTestManager()
+	hook := &testActivationHook{store: manager.Store}
+	manager.RegisterBundleActivationHook(hook)
+
+	plugin := New(&Config{}, manager)
+	bundleName := "test-bundle"
+	plugin.status[bundleName] = &Status{Name: bundleName, Metrics: metrics.New()}
+	plugin.downloaders[bundleName] = download.New(download.Config{}, plugin.manager.Client(""), bundleName)
+
+	b := bundle.Bundle{
+		Manifest: bundle.Manifest{Revision: "quickbrownfaux"},
+		Data:     util.MustUnmarshalJSON([]byte(`{"foo": {"bar": 1}}`)).(map[string]interface{}),
+	}
+	b.Manifest.Init()
+
+	plugin.oneShot(ctx, bundleName, download.Update{Bundle: &b, Metrics: metrics.New()})
+
+	ensurePluginState(t, plugin, plugins.StateOK)
+
+	// The hooks see the store before and after the bundle was activated.
+	if !reflect.DeepEqual(hook.calls, []string{"before", "after"}) {
+		t.Fatalf("Expected hooks to be called before and after activation but got %v", hook.calls)
+	}
+	if !reflect.DeepEqual(hook.reads, []interface{}{nil, json.Number("1")}) {
+		t.Fatalf("Unexpected reads in hooks: %v", hook.reads)
+	}
+	if !reflect.DeepEqual(hook.bundle, []string{bundleName, bundleName}) {
+		t.Fatalf("Unexpected bundles passed to hooks: %v", hook.bundle)
+	}
+
+	// An error returned by a hook aborts the activation.
+	hook.err = errors.New("hook failed")
+
+	b2 := bundle.Bundle{
+		Manifest: bundle.Manifest{Revision: "quickbrownfaux-2"},
+		Data:     util.MustUnmarshalJSON([]byte(`{"foo": {"bar": 2}}`)).(map[string]interface{}),
+	}
+	b2.Manifest.Init()
+
+	plugin.oneShot(ctx, bundleName, download.Update{Bundle: &b2, Metrics: metrics.New()})
+
+	if status := plugin.status[bundleName]; status.Message != "hook failed" || status.ActiveRevision != "quickbrownfaux" {
+		t.Fatalf("Expected activation to fail but got status %+v", status)
+	}
+
+	txn := storage.NewTransactionOrDie(ctx, manager.Store)
+	defer manager.Store.Abort(ctx, txn)
+
+	v, err := manager.Store.Read(ctx, txn, storage.MustParsePath("/foo/bar"))
+	if err != nil {
+		t.Fatal(err)
+	} else if v != json.Number("1") {
+		t.Fatalf("Expected data of the previous activation but got %v", v)
+	}
+}
+
 func TestPluginOneShotV1Compatible(t *testing.T) {
 	// Note: modules are parsed before passed to plugin, so any expected errors must be triggered by the compiler stage.
 	tests := []struct {
@@ -981,6 +1073,82 @@ func TestPluginOneShotDiskStorageMetrics(t *testing.T) {
 	})
 }
 
+func TestPluginOneShotDeltaBundleModules(t *testing.T) {
+
+	ctx := context.Background()
+	manager := getTestManager()
+	plugin := New(&Config{}, manager)
+	bundleName := "test-bundle"
+	plugin.status[bundleName] = &Status{Name: bundleName, Metrics: metrics.New()}
+	plugin.downloaders[bundleName] = download.New(download.Config{}, plugin.manager.Client(""), bundleName)
+
+	module := "package a\n\ncorge=1"
+
+	b := bundle.Bundle{
+		Manifest: bundle.Manifest{Revision: "r1", Roots: &[]string{"a"}},
+		Data:     map[string]interface{}{},
+		Modules: []bundle.ModuleFile{
+			{
+				Path:   "a/policy.rego",
+				Parsed: ast.MustParseModule(module),
+				Raw:    []byte(module),
+			},
+		},
+	}
+
+	plugin.oneShot(ctx, bundleName, download.Update{Bundle: &b, Metrics: metrics.New()})
+
+	ensurePluginState(t, plugin, plugins.StateOK)
+
+	// simulate a delta bundle download that replaces the policy
+
+	updated := "package a\n\ngrault=2"
+
+	b2 := bundle.Bundle{
+		Manifest: bundle.Manifest{Revision: "r2", Roots: &[]string{"a"}},
+		Patch: bundle.Patch{
+			BaseRevision: "r1",
+			Modules: []bundle.ModulePatchOperation{
+				{Op: "remove", Path: "a/policy.rego"},
+				{Op: "upsert", Path: "a/other.rego", Raw: updated, Parsed: ast.MustParseModule(updated)},
+			},
+		},
+		Etag: "foo",
+	}
+
+	plugin.process(ctx, bundleName, download.Update{Bundle: &b2, Metrics: metrics.New()})
+
+	if errs := plugin.status[bundleName].Errors; len(errs) > 0 || plugin.status[bundleName].Message != "" {
+		t.Fatalf("Unexpected status %+v", plugin.status[bundleName])
+	}
+
+	txn := storage.NewTransactionOrDie(ctx, manager.Store)
+
+	ids, err := manager.Store.ListPolicies(ctx, txn)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(ids) != 1 || ids[0] != "test-bundle/a/other.rego" {
+		t.Fatalf("Expected only the upserted policy but got %v", ids)
+	}
+
+	bs, err := manager.Store.GetPolicy(ctx, txn, ids[0])
+	if err != nil {
+		t.Fatal(err)
+	} else if string(bs) != updated {
+		t.Fatalf("Bad policy content. Exp:\n%v\n\nGot:\n\n%v", updated, string(bs))
+	}
+
+	manager.Store.Abort(ctx, txn)
+
+	// a delta bundle for an older revision is rejected
+
+	plugin.process(ctx, bundleName, download.Update{Bundle: &b2, Metrics: metrics.New()})
+
+	if status := plugin.status[bundleName]; status.Code != errCode || !strings.Contains(status.Message, "base revision 'r1' does not match current revision 'r2'") {
+		t.Fatalf("Expected delta conflict error but got %+v", status)
+	}
+}
+
 func TestPluginOneShotDeltaBundle(t *testing.T) {
 
 	ctx := context.Background()
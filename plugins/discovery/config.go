@@ -27,6 +27,23 @@ type Config struct {
 	Resource        *string                    `json:"resource,omitempty"` // the resource path which will be downloaded from the service
 	Signing         *bundle.VerificationConfig `json:"signing,omitempty"`  // configuration used to verify a signed bundle
 	Persist         bool                       `json:"persist"`            // control whether to persist activated discovery bundle to disk
+	Overlays        []*OverlayConfig           `json:"overlays,omitempty"` // additional discovery bundles merged into the discovered configuration, in order
+	MergeStrategy   *string                    `json:"merge_strategy,omitempty"`
+
+	service string
+	path    string
+	query   string
+}
+
+// OverlayConfig represents the configuration of an additional discovery
+// bundle, whose configuration is merged into the one of the discovery bundle.
+type OverlayConfig struct {
+	download.Config                            // bundle downloader configuration
+	Name            string                     `json:"name"`              // name of the overlay, used in logs and status messages
+	Decision        *string                    `json:"decision"`          // the name of the query to run on the bundle to get the config
+	Service         string                     `json:"service"`           // the name of the service used to download the overlay bundle from
+	Resource        *string                    `json:"resource"`          // the resource path which will be downloaded from the service
+	Signing         *bundle.VerificationConfig `json:"signing,omitempty"` // configuration used to verify a signed bundle
 
 	service string
 	path    string
@@ -125,14 +142,110 @@ func (c *Config) validateAndInjectDefaults(services []string, confKeys map[strin
 	c.service = service
 
 	if c.Decision != nil {
-		c.query = fmt.Sprintf("%v.%v", ast.DefaultRootDocument, strings.Replace(strings.Trim(*c.Decision, "/"), "/", ".", -1))
+		c.query = decisionQuery(*c.Decision)
 	} else if c.Name != nil {
-		c.query = fmt.Sprintf("%v.%v", ast.DefaultRootDocument, strings.Replace(strings.Trim(*c.Name, "/"), "/", ".", -1))
+		c.query = decisionQuery(*c.Name)
 	} else {
 		c.query = ast.DefaultRootDocument.String()
 	}
 
-	return c.Config.ValidateAndInjectDefaults()
+	if err := c.Config.ValidateAndInjectDefaults(); err != nil {
+		return err
+	}
+
+	return c.validateOverlays(services, confKeys)
+}
+
+func (c *Config) validateOverlays(services []string, confKeys map[string]*keys.Config) error {
+
+	if c.MergeStrategy == nil {
+		s := MergeStrategyOverride
+		c.MergeStrategy = &s
+	}
+
+	switch *c.MergeStrategy {
+	case MergeStrategyOverride, MergeStrategyPreserve:
+	default:
+		return fmt.Errorf("invalid discovery.merge_strategy %q (want %q or %q)", *c.MergeStrategy, MergeStrategyOverride, MergeStrategyPreserve)
+	}
+
+	// A bundle listed more than once would have its configuration merged into
+	// itself, so every discovery bundle must be unique.
+	names := map[string]struct{}{}
+	sources := map[string]string{c.service + ":" + c.path: "discovery"}
+
+	for i, o := range c.Overlays {
+		if o == nil || o.Name == "" {
+			return fmt.Errorf("missing required discovery.overlays[%d].name field", i)
+		}
+
+		if _, ok := names[o.Name]; ok {
+			return fmt.Errorf("discovery overlay %q is configured more than once", o.Name)
+		}
+		names[o.Name] = struct{}{}
+
+		if err := o.validateAndInjectDefaults(c, services, confKeys); err != nil {
+			return fmt.Errorf("invalid configuration for discovery overlay %q: %s", o.Name, err.Error())
+		}
+
+		key := o.service + ":" + o.path
+		if other, ok := sources[key]; ok {
+			return fmt.Errorf("discovery overlay %q downloads the same bundle as %s", o.Name, other)
+		}
+		sources[key] = fmt.Sprintf("discovery overlay %q", o.Name)
+	}
+
+	return nil
+}
+
+func (o *OverlayConfig) validateAndInjectDefaults(parent *Config, services []string, confKeys map[string]*keys.Config) error {
+
+	if o.Resource == nil {
+		return fmt.Errorf("missing required resource field")
+	}
+
+	// make a copy of the keys map
+	cpy := map[string]*keys.Config{}
+	for key, kc := range confKeys {
+		cpy[key] = kc
+	}
+
+	if o.Signing != nil {
+		if err := o.Signing.ValidateAndInjectDefaults(cpy); err != nil {
+			return err
+		}
+	} else if len(confKeys) > 0 {
+		o.Signing = bundle.NewVerificationConfig(cpy, "", "", nil)
+	}
+
+	o.path = *o.Resource
+
+	service, err := parent.getServiceFromList(o.Service, services)
+	if err != nil {
+		return err
+	}
+
+	o.service = service
+
+	if o.Decision != nil {
+		o.query = decisionQuery(*o.Decision)
+	} else {
+		o.query = ast.DefaultRootDocument.String()
+	}
+
+	// Overlays are downloaded alongside the discovery bundle, so they share
+	// its trigger mode.
+	if o.Trigger == nil {
+		o.Trigger = parent.Trigger
+	} else if *o.Trigger != *parent.Trigger {
+		return fmt.Errorf("trigger mode %q does not match discovery.trigger %q", *o.Trigger, *parent.Trigger)
+	}
+
+	return o.Config.ValidateAndInjectDefaults()
+}
+
+func decisionQuery(decision string) string {
+	return fmt.Sprintf("%v.%v", ast.DefaultRootDocument, strings.Replace(strings.Trim(decision, "/"), "/", ".", -1))
 }
 
 func (c *Config) getServiceFromList(service string, services []string) (string, error) {
@@ -152,4 +265,12 @@ func (c *Config) getServiceFromList(service string, services []string) (string,
 
 const (
 	defaultDiscoveryPathPrefix = "bundles"
+
+	// MergeStrategyOverride makes the configuration of discovery overlays
+	// override the configuration discovered before them.
+	MergeStrategyOverride = "override"
+
+	// MergeStrategyPreserve makes the configuration of discovery overlays
+	// only add to the configuration discovered before them.
+	MergeStrategyPreserve = "preserve"
 )
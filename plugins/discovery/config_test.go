@@ -183,3 +183,88 @@ func TestConfigPath(t *testing.T) {
 		})
 	}
 }
+
+func TestConfigOverlays(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantErr string
+	}{
+		{
+			input: `{"resource": "org.tar.gz", "overlays": [{"name": "team", "resource": "team.tar.gz", "service": "s1"}]}`,
+		},
+		{
+			input:   `{"resource": "org.tar.gz", "merge_strategy": "merge"}`,
+			wantErr: `invalid discovery.merge_strategy "merge" (want "override" or "preserve")`,
+		},
+		{
+			input:   `{"resource": "org.tar.gz", "overlays": [{"resource": "team.tar.gz"}]}`,
+			wantErr: "missing required discovery.overlays[0].name field",
+		},
+		{
+			input:   `{"resource": "org.tar.gz", "overlays": [{"name": "team"}]}`,
+			wantErr: `invalid configuration for discovery overlay "team": missing required resource field`,
+		},
+		{
+			input:   `{"resource": "org.tar.gz", "overlays": [{"name": "team", "resource": "team.tar.gz", "service": "s3"}]}`,
+			wantErr: `invalid configuration for discovery overlay "team": service name "s3" not found`,
+		},
+		{
+			input:   `{"resource": "org.tar.gz", "overlays": [{"name": "team", "resource": "a.tar.gz"}, {"name": "team", "resource": "b.tar.gz"}]}`,
+			wantErr: `discovery overlay "team" is configured more than once`,
+		},
+		{
+			input:   `{"resource": "org.tar.gz", "overlays": [{"name": "team", "resource": "org.tar.gz"}]}`,
+			wantErr: `discovery overlay "team" downloads the same bundle as discovery`,
+		},
+		{
+			input:   `{"resource": "org.tar.gz", "overlays": [{"name": "a", "resource": "team.tar.gz"}, {"name": "b", "resource": "team.tar.gz"}]}`,
+			wantErr: `discovery overlay "b" downloads the same bundle as discovery overlay "a"`,
+		},
+		{
+			input:   `{"resource": "org.tar.gz", "overlays": [{"name": "team", "resource": "team.tar.gz", "trigger": "manual"}]}`,
+			wantErr: `invalid configuration for discovery overlay "team": trigger mode "manual" does not match discovery.trigger "periodic"`,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("TestConfigOverlays_case_%d", i), func(t *testing.T) {
+			_, err := NewConfigBuilder().WithBytes([]byte(test.input)).WithServices([]string{"s1"}).Parse()
+			if test.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error while parsing config: %s", err)
+			}
+			if test.wantErr != "" && (err == nil || err.Error() != test.wantErr) {
+				t.Fatalf("expected error %q but got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestConfigOverlayDefaults(t *testing.T) {
+	c, err := NewConfigBuilder().WithBytes([]byte(`{
+		"service": "s1",
+		"resource": "org.tar.gz",
+		"trigger": "manual",
+		"overlays": [
+			{"name": "a", "service": "s2", "resource": "a.tar.gz", "decision": "team/config"},
+			{"name": "b", "service": "s1", "resource": "b.tar.gz"}
+		]
+	}`)).WithServices([]string{"s1", "s2"}).Parse()
+	if err != nil {
+		t.Fatalf("unexpected error while parsing config: %s", err)
+	}
+
+	if *c.MergeStrategy != MergeStrategyOverride {
+		t.Fatalf("expected merge strategy %q but got %q", MergeStrategyOverride, *c.MergeStrategy)
+	}
+
+	a, b := c.Overlays[0], c.Overlays[1]
+	if a.service != "s2" || a.path != "a.tar.gz" || a.query != "data.team.config" {
+		t.Fatalf("unexpected overlay config: %+v", a)
+	}
+	if b.service != "s1" || b.path != "b.tar.gz" || b.query != "data" {
+		t.Fatalf("unexpected overlay config: %+v", b)
+	}
+	if *a.Trigger != *c.Trigger || *b.Trigger != *c.Trigger {
+		t.Fatalf("expected overlays to inherit trigger mode %q", *c.Trigger)
+	}
+}
@@ -66,8 +66,22 @@ type Discovery struct {
 	hooks                hooks.Hooks
 	bootConfig           map[string]interface{}
 	overriddenConfigKeys []string
+	overlays             []*overlay        // additional discovery bundles, in merge order
+	current              *bundleApi.Bundle // last discovery bundle received, merged with the overlays
+	mtx                  sync.Mutex        // serializes the processing of discovery and overlay updates
 }
 
+// overlay holds the state of an additional discovery bundle.
+type overlay struct {
+	config     *OverlayConfig
+	downloader bundle.Loader
+	bundle     *bundleApi.Bundle // last overlay bundle received
+}
+
+// errOverlaysPending is returned when the configuration cannot be discovered
+// until all overlays have been downloaded once.
+var errOverlaysPending = errors.New("discovery overlays not downloaded yet")
+
 // Factories provides a set of factory functions to use for
 // instantiating custom plugins.
 func Factories(fs map[string]plugins.Factory) func(*Discovery) {
@@ -118,25 +132,14 @@ func New(manager *plugins.Manager, opts ...func(*Discovery)) (*Discovery, error)
 	}
 
 	result.config = config
-	restClient := manager.Client(config.service)
-	if strings.ToLower(restClient.Config().Type) == "oci" {
-		ociStorePath := filepath.Join(os.TempDir(), "opa", "oci") // use temporary folder /tmp/opa/oci
-		if manager.Config.PersistenceDirectory != nil {
-			ociStorePath = filepath.Join(*manager.Config.PersistenceDirectory, "oci")
-		}
-		result.downloader = download.NewOCI(config.Config, restClient, config.path, ociStorePath).
-			WithCallback(result.oneShot).
-			WithBundleVerificationConfig(config.Signing).
-			WithBundlePersistence(config.Persist).
-			WithBundleParserOpts(manager.ParserOptions())
-	} else {
-		d := download.New(config.Config, restClient, config.path).
-			WithCallback(result.oneShot).
-			WithBundleVerificationConfig(config.Signing).
-			WithBundlePersistence(config.Persist).
-			WithBundleParserOpts(manager.ParserOptions())
-		result.downloader = d
+	result.downloader = newDownloader(manager, config.Config, config.service, config.path, config.Signing, config.Persist, result.oneShot)
+
+	for _, oc := range config.Overlays {
+		o := &overlay{config: oc}
+		o.downloader = newDownloader(manager, oc.Config, oc.service, oc.path, oc.Signing, false, result.overlayOneShot(o))
+		result.overlays = append(result.overlays, o)
 	}
+
 	result.status = &bundle.Status{
 		Name: Name,
 	}
@@ -147,6 +150,26 @@ func New(manager *plugins.Manager, opts ...func(*Discovery)) (*Discovery, error)
 	return result, nil
 }
 
+func newDownloader(manager *plugins.Manager, conf download.Config, service, path string, signing *bundleApi.VerificationConfig, persist bool, f func(context.Context, download.Update)) bundle.Loader {
+	restClient := manager.Client(service)
+	if strings.ToLower(restClient.Config().Type) == "oci" {
+		ociStorePath := filepath.Join(os.TempDir(), "opa", "oci") // use temporary folder /tmp/opa/oci
+		if manager.Config.PersistenceDirectory != nil {
+			ociStorePath = filepath.Join(*manager.Config.PersistenceDirectory, "oci")
+		}
+		return download.NewOCI(conf, restClient, path, ociStorePath).
+			WithCallback(f).
+			WithBundleVerificationConfig(signing).
+			WithBundlePersistence(persist).
+			WithBundleParserOpts(manager.ParserOptions())
+	}
+	return download.New(conf, restClient, path).
+		WithCallback(f).
+		WithBundleVerificationConfig(signing).
+		WithBundlePersistence(persist).
+		WithBundleParserOpts(manager.ParserOptions())
+}
+
 // Start starts the dynamic discovery process if configured.
 func (c *Discovery) Start(ctx context.Context) error {
 
@@ -160,6 +183,9 @@ func (c *Discovery) Start(ctx context.Context) error {
 
 	if c.downloader != nil {
 		c.downloader.Start(ctx)
+		for _, o := range c.overlays {
+			o.downloader.Start(ctx)
+		}
 	} else {
 		// If there is no dynamic discovery then update the status to OK.
 		c.manager.UpdatePluginStatus(Name, &plugins.Status{State: plugins.StateOK})
@@ -173,6 +199,10 @@ func (c *Discovery) Stop(ctx context.Context) {
 		c.downloader.Stop(ctx)
 	}
 
+	for _, o := range c.overlays {
+		o.downloader.Stop(ctx)
+	}
+
 	c.manager.UpdatePluginStatus(Name, &plugins.Status{State: plugins.StateNotReady})
 }
 
@@ -199,7 +229,15 @@ func (c *Discovery) Trigger(ctx context.Context) error {
 	if c.downloader == nil {
 		return nil
 	}
-	return c.downloader.Trigger(ctx)
+	if err := c.downloader.Trigger(ctx); err != nil {
+		return err
+	}
+	for _, o := range c.overlays {
+		if err := o.downloader.Trigger(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (c *Discovery) RegisterListener(name interface{}, f func(bundle.Status)) {
@@ -239,7 +277,13 @@ func (c *Discovery) loadAndActivateBundleFromDisk(ctx context.Context) {
 		for retry := 0; retry < maxActivationRetry; retry++ {
 
 			ps, err := c.processBundle(ctx, b)
-			if err != nil {
+			if errors.Is(err, errOverlaysPending) {
+				// Overlays are not persisted, the bundle is activated once they
+				// have been downloaded.
+				c.current = b
+				c.logger.Debug("Discovery bundle loaded from disk, waiting for discovery overlays.")
+				return
+			} else if err != nil {
 				c.logger.Error("Discovery bundle processing error occurred: %v", err)
 				c.status.SetError(err)
 				continue
@@ -257,6 +301,7 @@ func (c *Discovery) loadAndActivateBundleFromDisk(ctx context.Context) {
 				p.Plugin.Reconfigure(ctx, p.Config)
 			}
 
+			c.current = b
 			c.status.SetError(nil)
 			c.status.SetActivateSuccess(b.Manifest.Revision)
 
@@ -305,7 +350,14 @@ func saveCurrentBundleToDisk(path string, raw io.Reader) (string, error) {
 
 func (c *Discovery) oneShot(ctx context.Context, u download.Update) {
 
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
 	c.processUpdate(ctx, u)
+	c.notifyStatus()
+}
+
+func (c *Discovery) notifyStatus() {
 
 	if p := status.Lookup(c.manager); p != nil {
 		p.UpdateDiscoveryStatus(*c.status)
@@ -336,7 +388,12 @@ func (c *Discovery) processUpdate(ctx context.Context, u download.Update) {
 		c.status.LastSuccessfulDownload = c.status.LastSuccessfulRequest
 		c.status.SetBundleSize(u.Size)
 
-		if err := c.reconfigure(ctx, u); err != nil {
+		if err := c.reconfigure(ctx, u); errors.Is(err, errOverlaysPending) {
+			c.current = u.Bundle
+			c.etag = u.ETag
+			c.logger.Info("Discovery bundle downloaded, waiting for discovery overlays.")
+			return
+		} else if err != nil {
 			c.logger.Error("Discovery reconfiguration error occurred: %v", err)
 			c.status.SetError(err)
 			c.downloader.ClearCache()
@@ -356,6 +413,7 @@ func (c *Discovery) processUpdate(ctx context.Context, u download.Update) {
 			c.logger.Debug("Discovery bundle persisted to disk successfully at path %v.", filepath.Join(c.bundlePersistPath, c.discoveryBundleDirName()))
 		}
 
+		c.current = u.Bundle
 		c.status.SetError(nil)
 		c.status.SetActivateSuccess(u.Bundle.Manifest.Revision)
 
@@ -388,6 +446,69 @@ func (c *Discovery) processUpdate(ctx context.Context, u download.Update) {
 	}
 }
 
+// overlayOneShot returns the download callback of the overlay o. The
+// configuration is discovered again from the last discovery bundle whenever
+// an overlay is updated.
+func (c *Discovery) overlayOneShot(o *overlay) func(context.Context, download.Update) {
+	return func(ctx context.Context, u download.Update) {
+
+		c.mtx.Lock()
+		defer c.mtx.Unlock()
+
+		c.processOverlayUpdate(ctx, o, u)
+		c.notifyStatus()
+	}
+}
+
+func (c *Discovery) processOverlayUpdate(ctx context.Context, o *overlay, u download.Update) {
+
+	if u.Error != nil {
+		c.logger.Error("Discovery overlay %q download failed: %v", o.config.Name, u.Error)
+		c.status.SetError(fmt.Errorf("discovery overlay %q: %w", o.config.Name, u.Error))
+		o.downloader.ClearCache()
+		return
+	}
+
+	if u.Bundle == nil {
+		return
+	}
+
+	prev := o.bundle
+	o.bundle = u.Bundle
+
+	if c.current == nil {
+		c.logger.Info("Discovery overlay %q downloaded, waiting for discovery bundle.", o.config.Name)
+		return
+	}
+
+	if err := c.reconfigure(ctx, download.Update{Bundle: c.current}); errors.Is(err, errOverlaysPending) {
+		c.logger.Info("Discovery overlay %q downloaded, waiting for discovery overlays.", o.config.Name)
+		return
+	} else if err != nil {
+		c.logger.Error("Discovery overlay %q reconfiguration error occurred: %v", o.config.Name, err)
+		c.status.SetError(fmt.Errorf("discovery overlay %q: %w", o.config.Name, err))
+		o.bundle = prev
+		o.downloader.ClearCache()
+		return
+	}
+
+	c.status.SetError(nil)
+	c.status.SetActivateSuccess(c.current.Manifest.Revision)
+
+	if len(c.overriddenConfigKeys) != 0 {
+		msg := fmt.Sprintf("Keys in the discovered configuration overridden by boot configuration: %v", strings.Join(c.overriddenConfigKeys, ", "))
+		c.logger.Debug(msg)
+		c.status.Message = msg
+	}
+	c.overriddenConfigKeys = nil
+
+	c.readyOnce.Do(func() {
+		c.manager.UpdatePluginStatus(Name, &plugins.Status{State: plugins.StateOK})
+	})
+
+	c.logger.Info("Discovery overlay %q update processed successfully.", o.config.Name)
+}
+
 func (c *Discovery) reconfigure(ctx context.Context, u download.Update) error {
 
 	ps, err := c.processBundle(ctx, u.Bundle)
@@ -437,7 +558,7 @@ func (c *Discovery) applyLocalPluginConfigOverride(conf *config.Config) (*config
 
 func (c *Discovery) processBundle(ctx context.Context, b *bundleApi.Bundle) (*pluginSet, error) {
 
-	config, err := evaluateBundle(ctx, c.manager.ID, c.manager.Info, b, c.config.query)
+	config, err := c.evaluateConfig(ctx, b)
 	if err != nil {
 		return nil, err
 	}
@@ -472,10 +593,17 @@ func (c *Discovery) processBundle(ctx context.Context, b *bundleApi.Bundle) (*pl
 		return nil, err
 	}
 
-	if client, ok := services[c.config.service]; ok {
-		dClient := c.manager.Client(c.config.service)
-		if !client.Config().Equal(dClient.Config()) {
-			return nil, fmt.Errorf("updates to the discovery service are not allowed")
+	discoveryServices := []string{c.config.service}
+	for _, o := range c.overlays {
+		discoveryServices = append(discoveryServices, o.config.service)
+	}
+
+	for _, name := range discoveryServices {
+		if client, ok := services[name]; ok {
+			dClient := c.manager.Client(name)
+			if !client.Config().Equal(dClient.Config()) {
+				return nil, fmt.Errorf("updates to the discovery service are not allowed")
+			}
 		}
 	}
 
@@ -485,9 +613,17 @@ func (c *Discovery) processBundle(ctx context.Context, b *bundleApi.Bundle) (*pl
 		return nil, err
 	}
 
-	if c.config.Signing != nil {
+	signings := []*bundleApi.VerificationConfig{c.config.Signing}
+	for _, o := range c.overlays {
+		signings = append(signings, o.config.Signing)
+	}
+
+	for _, signing := range signings {
+		if signing == nil {
+			continue
+		}
 		for key, kc := range keys {
-			if curr, ok := c.config.Signing.PublicKeys[key]; ok {
+			if curr, ok := signing.PublicKeys[key]; ok {
 				if !curr.Equal(kc) {
 					return nil, fmt.Errorf("updates to keys specified in the boot configuration are not allowed")
 				}
@@ -523,8 +659,69 @@ func (c *Discovery) discoveryBundleDirName() string {
 	return Name
 }
 
+// evaluateConfig returns the configuration discovered from the discovery
+// bundle b, merged with the configuration discovered from each overlay.
+func (c *Discovery) evaluateConfig(ctx context.Context, b *bundleApi.Bundle) (*config.Config, error) {
+
+	if len(c.overlays) == 0 {
+		return evaluateBundle(ctx, c.manager.ID, c.manager.Info, b, c.config.query)
+	}
+
+	for _, o := range c.overlays {
+		if o.bundle == nil {
+			return nil, errOverlaysPending
+		}
+	}
+
+	result, err := evaluateDocument(ctx, c.manager.Info, b, c.config.query)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, o := range c.overlays {
+		doc, err := evaluateDocument(ctx, c.manager.Info, o.bundle, o.config.query)
+		if err != nil {
+			return nil, fmt.Errorf("discovery overlay %q: %w", o.config.Name, err)
+		}
+		result = mergeDocuments(result, doc, *c.config.MergeStrategy)
+	}
+
+	bs, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+
+	return config.ParseConfig(bs, c.manager.ID)
+}
+
+// mergeDocuments merges the configuration discovered from an overlay into
+// the configuration discovered before it, according to strategy.
+func mergeDocuments(base, overlay map[string]interface{}, strategy string) map[string]interface{} {
+	if strategy == MergeStrategyPreserve {
+		merged, _ := mergeValuesAndListOverrides(overlay, base, "")
+		return merged
+	}
+	merged, _ := mergeValuesAndListOverrides(base, overlay, "")
+	return merged
+}
+
 func evaluateBundle(ctx context.Context, id string, info *ast.Term, b *bundleApi.Bundle, query string) (*config.Config, error) {
 
+	doc, err := evaluateDocument(ctx, info, b, query)
+	if err != nil {
+		return nil, err
+	}
+
+	bs, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return config.ParseConfig(bs, id)
+}
+
+func evaluateDocument(ctx context.Context, info *ast.Term, b *bundleApi.Bundle, query string) (map[string]interface{}, error) {
+
 	modules := b.ParsedModules("discovery")
 
 	compiler := ast.NewCompiler()
@@ -551,12 +748,12 @@ func evaluateBundle(ctx context.Context, id string, info *ast.Term, b *bundleApi
 		return nil, fmt.Errorf("undefined configuration")
 	}
 
-	bs, err := json.Marshal(rs[0].Expressions[0].Value)
-	if err != nil {
-		return nil, err
+	doc, ok := rs[0].Expressions[0].Value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("configuration must be an object")
 	}
 
-	return config.ParseConfig(bs, id)
+	return doc, nil
 }
 
 type pluginSet struct {
@@ -1453,6 +1453,117 @@ func TestReconfigure(t *testing.T) {
 	}
 }
 
+func TestReconfigureWithOverlays(t *testing.T) {
+
+	tests := []struct {
+		strategy string
+		decision string
+		labels   map[string]string
+	}{
+		{
+			strategy: MergeStrategyOverride,
+			decision: "data.team.allow",
+			labels:   map[string]string{"org": "acme", "team": "payments"},
+		},
+		{
+			strategy: MergeStrategyPreserve,
+			decision: "data.org.allow",
+			labels:   map[string]string{"org": "acme", "team": "platform"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.strategy, func(t *testing.T) {
+
+			manager, err := plugins.New([]byte(fmt.Sprintf(`{
+				"services": {
+					"localhost": {
+						"url": "http://localhost:9999"
+					}
+				},
+				"discovery": {
+					"resource": "org.tar.gz",
+					"merge_strategy": %q,
+					"overlays": [{"name": "team", "resource": "team.tar.gz"}]
+				},
+			}`, tc.strategy)), "test-id", inmem.New())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			testPlugin := &reconfigureTestPlugin{counts: map[string]int{}}
+			testFactory := testFactory{p: testPlugin}
+
+			disco, err := New(manager, Factories(map[string]plugins.Factory{"test_plugin": testFactory}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := context.Background()
+
+			orgBundle := makeDataBundle(1, `
+				{
+					"labels": {"org": "acme", "team": "platform"},
+					"default_decision": "org/allow",
+					"plugins": {
+						"test_plugin": {"a": "b"}
+					}
+				}
+			`)
+
+			disco.oneShot(ctx, download.Update{Bundle: orgBundle})
+
+			// The configuration is not activated until the overlay is downloaded.
+			ensurePluginState(t, disco, plugins.StateNotReady)
+			if len(testPlugin.counts) != 0 {
+				t.Fatalf("Expected no plugin start but got %v", testPlugin.counts)
+			}
+
+			teamBundle := makeDataBundle(1, `
+				{
+					"labels": {"team": "payments"},
+					"default_decision": "team/allow"
+				}
+			`)
+
+			disco.overlayOneShot(disco.overlays[0])(ctx, download.Update{Bundle: teamBundle})
+
+			ensurePluginState(t, disco, plugins.StateOK)
+
+			if disco.status.Code != "" || disco.status.ActiveRevision != "test-revision-1" {
+				t.Fatalf("Unexpected status: %+v", disco.status)
+			}
+
+			if !reflect.DeepEqual(testPlugin.counts, map[string]int{"start": 1}) {
+				t.Errorf("Expected exactly one plugin start but got %v", testPlugin.counts)
+			}
+
+			if !manager.Config.DefaultDecisionRef().Equal(ast.MustParseRef(tc.decision)) {
+				t.Errorf("Expected default decision to be %v but got %v", tc.decision, manager.Config.DefaultDecisionRef())
+			}
+
+			exp := map[string]string{"id": "test-id", "version": version.Version}
+			for k, v := range tc.labels {
+				exp[k] = v
+			}
+			if !reflect.DeepEqual(manager.Labels(), exp) {
+				t.Errorf("Expected labels %v but got %v", exp, manager.Labels())
+			}
+
+			// An invalid overlay update is rejected and the last one is kept.
+			invalidBundle := makeDataBundle(2, `{"plugins": {"unknown_plugin": {}}}`)
+			disco.overlayOneShot(disco.overlays[0])(ctx, download.Update{Bundle: invalidBundle})
+
+			if disco.status.Code == "" {
+				t.Fatal("Expected status error")
+			}
+			if disco.overlays[0].bundle != teamBundle {
+				t.Fatal("Expected last valid overlay bundle to be kept")
+			}
+		})
+	}
+}
+
 func TestReconfigureV1Compatible(t *testing.T) {
 	popts := ast.ParserOptions{RegoVersion: ast.RegoV1}
 
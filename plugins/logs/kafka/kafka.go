@@ -0,0 +1,434 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package kafka implements a Kafka producer for publishing decision log events
+// directly to a Kafka topic.
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+
+	"github.com/open-policy-agent/opa/logging"
+	"github.com/open-policy-agent/opa/metrics"
+)
+
+const (
+	defaultClientID          = "opa"
+	defaultRequiredAcks      = -1
+	defaultBatchSize         = 100
+	defaultFlushIntervalMS   = 1000
+	defaultMaxBufferedEvents = 10000
+	defaultMaxRetries        = 3
+	defaultTimeoutSeconds    = 10
+	defaultRetryBackoff      = 100 * time.Millisecond
+
+	// BackpressureDrop drops events when the buffer is full.
+	BackpressureDrop = "drop"
+
+	// BackpressureBlock blocks the caller until the buffer has room for the
+	// event.
+	BackpressureBlock = "block"
+
+	// CompressionNone publishes uncompressed record batches.
+	CompressionNone = "none"
+
+	// CompressionGzip publishes gzip compressed record batches.
+	CompressionGzip = "gzip"
+
+	// SaslMechanismPlain authenticates with a username and password.
+	SaslMechanismPlain = "PLAIN"
+
+	deliveryFailureCounterName = "decision_logs_kafka_delivery_failure"
+)
+
+// ErrBufferFull is returned by Send when the event is dropped because the
+// buffer is full.
+var ErrBufferFull = errors.New("kafka buffer full")
+
+// ErrStopped is returned by Send after the producer has been stopped.
+var ErrStopped = errors.New("kafka producer stopped")
+
+// Config represents the Kafka producer configuration.
+type Config struct {
+	Brokers           []string    `json:"brokers"`
+	Topic             string      `json:"topic"`
+	ClientID          string      `json:"client_id,omitempty"`
+	RequiredAcks      *int        `json:"required_acks,omitempty"`       // -1 (all in-sync replicas), 0 (none) or 1 (leader)
+	BatchSize         *int        `json:"batch_size,omitempty"`          // max number of events per produce request
+	FlushIntervalMS   *int64      `json:"flush_interval_ms,omitempty"`   // max time events wait before being published
+	MaxBufferedEvents *int        `json:"max_buffered_events,omitempty"` // max number of events waiting to be published
+	Backpressure      string      `json:"backpressure,omitempty"`        // behaviour when the buffer is full
+	MaxRetries        *int        `json:"max_retries,omitempty"`
+	TimeoutSeconds    *int64      `json:"timeout_seconds,omitempty"`
+	Compression       string      `json:"compression,omitempty"` // compression codec of the record batches
+	TLS               *TLSConfig  `json:"tls,omitempty"`
+	SASL              *SASLConfig `json:"sasl,omitempty"`
+}
+
+// TLSConfig represents the TLS configuration for connecting to the brokers.
+type TLSConfig struct {
+	CACert             string `json:"ca_cert,omitempty"`
+	Cert               string `json:"cert,omitempty"`
+	PrivateKey         string `json:"private_key,omitempty"`
+	ServerName         string `json:"server_name,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+}
+
+// SASLConfig represents the SASL configuration for authenticating with the
+// brokers. Since SASL/PLAIN sends the password in the clear, it should only be
+// used together with TLS.
+type SASLConfig struct {
+	Mechanism string `json:"mechanism,omitempty"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+}
+
+// ValidateAndInjectDefaults validates the config and injects default values.
+func (c *Config) ValidateAndInjectDefaults() error {
+	if len(c.Brokers) == 0 {
+		return fmt.Errorf("missing brokers")
+	}
+
+	if c.Topic == "" {
+		return fmt.Errorf("missing topic")
+	}
+
+	if c.ClientID == "" {
+		c.ClientID = defaultClientID
+	}
+
+	acks := defaultRequiredAcks
+	if c.RequiredAcks != nil {
+		acks = *c.RequiredAcks
+		if acks < -1 || acks > 1 {
+			return fmt.Errorf("invalid required_acks %d, must be one of -1, 0 or 1", acks)
+		}
+	}
+	c.RequiredAcks = &acks
+
+	batchSize := defaultBatchSize
+	if c.BatchSize != nil {
+		batchSize = *c.BatchSize
+		if batchSize <= 0 {
+			return fmt.Errorf("invalid batch_size %d, must be positive", batchSize)
+		}
+	}
+	c.BatchSize = &batchSize
+
+	flushInterval := int64(defaultFlushIntervalMS)
+	if c.FlushIntervalMS != nil {
+		flushInterval = *c.FlushIntervalMS
+		if flushInterval <= 0 {
+			return fmt.Errorf("invalid flush_interval_ms %d, must be positive", flushInterval)
+		}
+	}
+	c.FlushIntervalMS = &flushInterval
+
+	maxBuffered := defaultMaxBufferedEvents
+	if c.MaxBufferedEvents != nil {
+		maxBuffered = *c.MaxBufferedEvents
+		if maxBuffered <= 0 {
+			return fmt.Errorf("invalid max_buffered_events %d, must be positive", maxBuffered)
+		}
+	}
+	c.MaxBufferedEvents = &maxBuffered
+
+	switch c.Backpressure {
+	case "":
+		c.Backpressure = BackpressureDrop
+	case BackpressureDrop, BackpressureBlock:
+	default:
+		return fmt.Errorf("invalid backpressure %q, must be %q or %q", c.Backpressure, BackpressureDrop, BackpressureBlock)
+	}
+
+	retries := defaultMaxRetries
+	if c.MaxRetries != nil {
+		retries = *c.MaxRetries
+		if retries < 0 {
+			return fmt.Errorf("invalid max_retries %d, must be non-negative", retries)
+		}
+	}
+	c.MaxRetries = &retries
+
+	timeout := int64(defaultTimeoutSeconds)
+	if c.TimeoutSeconds != nil {
+		timeout = *c.TimeoutSeconds
+		if timeout <= 0 {
+			return fmt.Errorf("invalid timeout_seconds %d, must be positive", timeout)
+		}
+	}
+	c.TimeoutSeconds = &timeout
+
+	if c.TLS != nil {
+		if (c.TLS.Cert == "") != (c.TLS.PrivateKey == "") {
+			return fmt.Errorf("tls requires both cert and private_key")
+		}
+		if _, err := c.TLS.tlsConfig(); err != nil {
+			return fmt.Errorf("invalid tls: %w", err)
+		}
+	}
+
+	switch c.Compression {
+	case "":
+		c.Compression = CompressionNone
+	case CompressionNone, CompressionGzip:
+	default:
+		return fmt.Errorf("invalid compression %q, must be %q or %q", c.Compression, CompressionNone, CompressionGzip)
+	}
+
+	if c.SASL != nil {
+		switch c.SASL.Mechanism {
+		case "":
+			c.SASL.Mechanism = SaslMechanismPlain
+		case SaslMechanismPlain:
+		default:
+			return fmt.Errorf("invalid sasl mechanism %q, must be %q", c.SASL.Mechanism, SaslMechanismPlain)
+		}
+		if c.SASL.Username == "" {
+			return fmt.Errorf("sasl requires username")
+		}
+	}
+
+	return nil
+}
+
+func (c *TLSConfig) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{ServerName: c.ServerName, InsecureSkipVerify: c.InsecureSkipVerify} // #nosec G402
+
+	if c.CACert != "" {
+		caCert, err := os.ReadFile(c.CACert)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, errors.New("unable to parse and append CA certificate to certificate pool")
+		}
+		config.RootCAs = pool
+	}
+
+	if c.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(c.Cert, c.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// Producer publishes messages to a Kafka topic. Messages are buffered and
+// published in batches by a background goroutine. Messages with a key are
+// assigned to partitions the same way as by the default partitioner of the
+// Kafka Java client, other messages are spread across the partitions.
+type Producer struct {
+	config   Config
+	logger   logging.Logger
+	metrics  metrics.Metrics
+	client   *kgo.Client
+	events   chan *kgo.Record
+	quit     chan struct{}
+	exited   chan struct{}
+	stopOnce sync.Once
+}
+
+// New returns a new Producer with the given config. The config must have been
+// validated with ValidateAndInjectDefaults.
+func New(config *Config, logger logging.Logger) (*Producer, error) {
+	return newProducer(config, logger)
+}
+
+// newProducer returns a new Producer whose client is additionally configured
+// with opts.
+func newProducer(config *Config, logger logging.Logger, opts ...kgo.Opt) (*Producer, error) {
+	timeout := time.Duration(*config.TimeoutSeconds) * time.Second
+
+	opts = append([]kgo.Opt{
+		kgo.SeedBrokers(config.Brokers...),
+		kgo.ClientID(config.ClientID),
+		kgo.DefaultProduceTopic(config.Topic),
+		kgo.DialTimeout(timeout),
+		kgo.ProduceRequestTimeout(timeout),
+		kgo.RecordRetries(*config.MaxRetries + 1),
+		kgo.RetryBackoffFn(func(tries int) time.Duration {
+			return defaultRetryBackoff * time.Duration(tries)
+		}),
+	}, opts...)
+
+	switch *config.RequiredAcks {
+	case -1:
+		opts = append(opts, kgo.RequiredAcks(kgo.AllISRAcks()))
+	case 0:
+		// Idempotent writes require acknowledgements from all in-sync replicas.
+		opts = append(opts, kgo.RequiredAcks(kgo.NoAck()), kgo.DisableIdempotentWrite())
+	case 1:
+		opts = append(opts, kgo.RequiredAcks(kgo.LeaderAck()), kgo.DisableIdempotentWrite())
+	}
+
+	switch config.Compression {
+	case CompressionGzip:
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.GzipCompression()))
+	default:
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.NoCompression()))
+	}
+
+	if config.TLS != nil {
+		tlsConfig, err := config.TLS.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, kgo.DialTLSConfig(tlsConfig))
+	}
+
+	if config.SASL != nil {
+		auth := plain.Auth{User: config.SASL.Username, Pass: config.SASL.Password}
+		opts = append(opts, kgo.SASL(auth.AsMechanism()))
+	}
+
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Producer{
+		config: *config,
+		logger: logger,
+		client: client,
+		events: make(chan *kgo.Record, *config.MaxBufferedEvents),
+		quit:   make(chan struct{}),
+		exited: make(chan struct{}),
+	}, nil
+}
+
+// WithMetrics sets the metrics provider used to count failed deliveries.
+func (p *Producer) WithMetrics(m metrics.Metrics) *Producer {
+	p.metrics = m
+	return p
+}
+
+// Start starts publishing messages in the background.
+func (p *Producer) Start() {
+	go p.loop()
+}
+
+// Stop publishes the buffered messages and stops the producer. Stop returns
+// early, possibly with messages still buffered, when ctx is done.
+func (p *Producer) Stop(ctx context.Context) {
+	p.stopOnce.Do(func() {
+		close(p.quit)
+	})
+	select {
+	case <-p.exited:
+	case <-ctx.Done():
+	}
+}
+
+// Send buffers a message for publishing. If the buffer is full, the message
+// is dropped and ErrBufferFull is returned, unless the producer is configured
+// to apply backpressure, in which case Send blocks until there is room in the
+// buffer or ctx is done.
+func (p *Producer) Send(ctx context.Context, key, value []byte) error {
+	r := &kgo.Record{Key: key, Value: value, Timestamp: time.Now()}
+
+	select {
+	case <-p.quit:
+		return ErrStopped
+	default:
+	}
+
+	if p.config.Backpressure == BackpressureBlock {
+		select {
+		case p.events <- r:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-p.quit:
+			return ErrStopped
+		}
+	}
+
+	select {
+	case p.events <- r:
+		return nil
+	default:
+		return ErrBufferFull
+	}
+}
+
+func (p *Producer) loop() {
+	defer close(p.exited)
+	defer p.client.Close()
+
+	batchSize := *p.config.BatchSize
+	ticker := time.NewTicker(time.Duration(*p.config.FlushIntervalMS) * time.Millisecond)
+	defer ticker.Stop()
+
+	batch := make([]*kgo.Record, 0, batchSize)
+
+	for {
+		select {
+		case r := <-p.events:
+			batch = append(batch, r)
+			if len(batch) >= batchSize {
+				p.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				p.flush(batch)
+				batch = batch[:0]
+			}
+		case <-p.quit:
+			for {
+				select {
+				case r := <-p.events:
+					batch = append(batch, r)
+					if len(batch) >= batchSize {
+						p.flush(batch)
+						batch = batch[:0]
+					}
+				default:
+					if len(batch) > 0 {
+						p.flush(batch)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush publishes records and waits until they have been acknowledged. The
+// client retries failed records up to the configured number of retries.
+func (p *Producer) flush(records []*kgo.Record) {
+	var failed int
+	var err error
+
+	for _, result := range p.client.ProduceSync(context.Background(), records...) {
+		if result.Err != nil {
+			failed++
+			err = result.Err
+		}
+	}
+
+	if failed == 0 {
+		return
+	}
+
+	p.logger.Error("Failed to publish %d decision log events to Kafka: %v.", failed, err)
+	if p.metrics != nil {
+		p.metrics.Counter(deliveryFailureCounterName).Add(uint64(failed))
+	}
+}
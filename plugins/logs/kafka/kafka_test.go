@@ -0,0 +1,768 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package kafka
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kbin"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+
+	"github.com/open-policy-agent/opa/logging"
+	"github.com/open-policy-agent/opa/metrics"
+)
+
+type fakeRecord struct {
+	key   []byte
+	value string
+}
+
+// fakeBroker implements the subset of the Kafka protocol used by the producer.
+type fakeBroker struct {
+	t          *testing.T
+	l          net.Listener
+	topic      string
+	partitions int32
+
+	// The fields below are set before the broker starts serving and are read
+	// only afterwards.
+	tlsConfig          *tls.Config
+	username, password string // if set, clients must authenticate with SASL/PLAIN
+
+	mtx             sync.Mutex
+	records         map[int32][]fakeRecord
+	codecs          []int16 // compression codecs of the received record batches
+	produceRequests int
+	failProduce     int // number of produce requests to fail
+}
+
+type fakeBrokerOpt func(*fakeBroker)
+
+func withTLS(config *tls.Config) fakeBrokerOpt {
+	return func(b *fakeBroker) {
+		b.tlsConfig = config
+	}
+}
+
+func withCredentials(username, password string) fakeBrokerOpt {
+	return func(b *fakeBroker) {
+		b.username, b.password = username, password
+	}
+}
+
+func withFailedProduces(n int) fakeBrokerOpt {
+	return func(b *fakeBroker) {
+		b.failProduce = n
+	}
+}
+
+func newFakeBroker(t *testing.T, topic string, partitions int32, opts ...fakeBrokerOpt) *fakeBroker {
+	t.Helper()
+
+	b := &fakeBroker{t: t, topic: topic, partitions: partitions, records: map[int32][]fakeRecord{}}
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	var err error
+	if b.tlsConfig != nil {
+		b.l, err = tls.Listen("tcp", "127.0.0.1:0", b.tlsConfig)
+	} else {
+		b.l, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go b.serve()
+	t.Cleanup(func() { b.l.Close() })
+	return b
+}
+
+func (b *fakeBroker) addr() string {
+	return b.l.Addr().String()
+}
+
+func (b *fakeBroker) serve() {
+	for {
+		conn, err := b.l.Accept()
+		if err != nil {
+			return
+		}
+		go b.handle(conn)
+	}
+}
+
+func (b *fakeBroker) handle(conn net.Conn) {
+	defer conn.Close()
+
+	authenticated := b.username == ""
+	handshaked := false
+
+	for {
+		size := make([]byte, 4)
+		if _, err := io.ReadFull(conn, size); err != nil {
+			return
+		}
+		buf := make([]byte, binary.BigEndian.Uint32(size))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+
+		r := kbin.Reader{Src: buf}
+		apiKey := r.Int16()
+		apiVersion := r.Int16()
+		correlationID := r.Int32()
+		_ = r.NullableString() // client id
+
+		req := kmsg.RequestForKey(apiKey)
+		if req == nil {
+			b.t.Errorf("unexpected api key %d", apiKey)
+			return
+		}
+		req.SetVersion(apiVersion)
+		if req.IsFlexible() {
+			kmsg.SkipTags(&r)
+		}
+		if err := req.ReadFrom(r.Src); !r.Ok() || err != nil {
+			b.t.Errorf("failed to decode request with api key %d: %v", apiKey, err)
+			return
+		}
+
+		if !authenticated {
+			switch req.(type) {
+			case *kmsg.ApiVersionsRequest, *kmsg.SASLHandshakeRequest, *kmsg.SASLAuthenticateRequest:
+			default:
+				b.t.Errorf("unexpected api key %d before authentication", apiKey)
+				return
+			}
+		}
+
+		var resp kmsg.Response
+		switch req := req.(type) {
+		case *kmsg.ApiVersionsRequest:
+			resp = b.apiVersions(req)
+		case *kmsg.SASLHandshakeRequest:
+			r := req.ResponseKind().(*kmsg.SASLHandshakeResponse)
+			r.SupportedMechanisms = []string{SaslMechanismPlain}
+			if req.Mechanism == SaslMechanismPlain {
+				handshaked = true
+			} else {
+				r.ErrorCode = 33 // UNSUPPORTED_SASL_MECHANISM
+			}
+			resp = r
+		case *kmsg.SASLAuthenticateRequest:
+			r := req.ResponseKind().(*kmsg.SASLAuthenticateResponse)
+			// RFC 4616: message = [authzid] UTF8NUL authcid UTF8NUL passwd
+			exp := []byte("\x00" + b.username + "\x00" + b.password)
+			switch {
+			case !handshaked:
+				r.ErrorCode = 34 // ILLEGAL_SASL_STATE
+				r.ErrorMessage = kmsg.StringPtr("handshake required")
+			case !bytes.Equal(req.SASLAuthBytes, exp):
+				r.ErrorCode = 58 // SASL_AUTHENTICATION_FAILED
+				r.ErrorMessage = kmsg.StringPtr("invalid credentials")
+			default:
+				authenticated = true
+			}
+			resp = r
+		case *kmsg.MetadataRequest:
+			resp = b.metadata(req)
+		case *kmsg.InitProducerIDRequest:
+			r := req.ResponseKind().(*kmsg.InitProducerIDResponse)
+			r.ProducerID = 1
+			resp = r
+		case *kmsg.ProduceRequest:
+			resp = b.produce(req)
+			if resp == nil {
+				continue
+			}
+		default:
+			b.t.Errorf("unexpected api key %d", apiKey)
+			return
+		}
+
+		body := kbin.AppendInt32(nil, correlationID)
+		// The response header of ApiVersions never has tagged fields.
+		if resp.IsFlexible() && apiKey != 18 {
+			body = append(body, 0)
+		}
+		body = resp.AppendTo(body)
+
+		if _, err := conn.Write(append(kbin.AppendInt32(nil, int32(len(body))), body...)); err != nil {
+			return
+		}
+	}
+}
+
+func (b *fakeBroker) apiVersions(req *kmsg.ApiVersionsRequest) kmsg.Response {
+	resp := req.ResponseKind().(*kmsg.ApiVersionsResponse)
+	for _, req := range []kmsg.Request{
+		new(kmsg.ProduceRequest),
+		new(kmsg.MetadataRequest),
+		new(kmsg.SASLHandshakeRequest),
+		new(kmsg.ApiVersionsRequest),
+		new(kmsg.InitProducerIDRequest),
+		new(kmsg.SASLAuthenticateRequest),
+	} {
+		key := kmsg.NewApiVersionsResponseApiKey()
+		key.ApiKey = req.Key()
+		key.MaxVersion = req.MaxVersion()
+		resp.ApiKeys = append(resp.ApiKeys, key)
+	}
+	return resp
+}
+
+func (b *fakeBroker) metadata(req *kmsg.MetadataRequest) kmsg.Response {
+	host, port, _ := net.SplitHostPort(b.addr())
+	portNum, _ := strconv.Atoi(port)
+
+	resp := req.ResponseKind().(*kmsg.MetadataResponse)
+	broker := kmsg.NewMetadataResponseBroker()
+	broker.NodeID = 1
+	broker.Host = host
+	broker.Port = int32(portNum)
+	resp.Brokers = append(resp.Brokers, broker)
+	resp.ControllerID = 1
+
+	topic := kmsg.NewMetadataResponseTopic()
+	topic.Topic = kmsg.StringPtr(b.topic)
+	for i := int32(0); i < b.partitions; i++ {
+		partition := kmsg.NewMetadataResponseTopicPartition()
+		partition.Partition = i
+		partition.Leader = 1
+		partition.Replicas = []int32{1}
+		partition.ISR = []int32{1}
+		topic.Partitions = append(topic.Partitions, partition)
+	}
+	resp.Topics = append(resp.Topics, topic)
+
+	return resp
+}
+
+// produce stores the records of the produce request and returns the response,
+// or nil if no response must be sent.
+func (b *fakeBroker) produce(req *kmsg.ProduceRequest) kmsg.Response {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.produceRequests++
+	var code int16
+	if b.failProduce > 0 {
+		b.failProduce--
+		code = 19 // NOT_ENOUGH_REPLICAS
+	}
+
+	resp := req.ResponseKind().(*kmsg.ProduceResponse)
+	for _, t := range req.Topics {
+		if t.Topic != b.topic {
+			b.t.Errorf("unexpected topic %q", t.Topic)
+		}
+		topic := kmsg.NewProduceResponseTopic()
+		topic.Topic = t.Topic
+		for _, p := range t.Partitions {
+			records, codec := decodeRecordBatch(b.t, p.Records)
+			b.codecs = append(b.codecs, codec)
+
+			partition := kmsg.NewProduceResponseTopicPartition()
+			partition.Partition = p.Partition
+			partition.ErrorCode = code
+			if code == 0 {
+				partition.BaseOffset = int64(len(b.records[p.Partition]))
+				b.records[p.Partition] = append(b.records[p.Partition], records...)
+			}
+			topic.Partitions = append(topic.Partitions, partition)
+		}
+		resp.Topics = append(resp.Topics, topic)
+	}
+
+	if req.Acks == 0 {
+		return nil
+	}
+	return resp
+}
+
+func (b *fakeBroker) received() map[int32][]fakeRecord {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	result := make(map[int32][]fakeRecord, len(b.records))
+	for k, v := range b.records {
+		result[k] = v
+	}
+	return result
+}
+
+func decodeRecordBatch(t *testing.T, bs []byte) ([]fakeRecord, int16) {
+	var batch kmsg.RecordBatch
+	if err := batch.ReadFrom(bs); err != nil {
+		t.Errorf("failed to decode record batch: %v", err)
+		return nil, 0
+	}
+	if batch.Magic != 2 {
+		t.Errorf("unexpected magic %d", batch.Magic)
+	}
+
+	codec := batch.Attributes & 0x07
+	raw := batch.Records
+	switch codec {
+	case 0: // none
+	case 1: // gzip
+		r, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if raw, err = io.ReadAll(r); err != nil {
+			t.Fatal(err)
+		}
+	default:
+		t.Errorf("unexpected compression codec %d", codec)
+	}
+
+	var records []fakeRecord
+	for i := 0; i < int(batch.NumRecords); i++ {
+		// Each record is prefixed by its varint encoded length.
+		r := kbin.Reader{Src: raw}
+		n := int(r.Varint()) + len(raw) - len(r.Src)
+		if !r.Ok() || n > len(raw) {
+			t.Errorf("truncated record %d", i)
+			return records, codec
+		}
+
+		record := kmsg.NewRecord()
+		if err := record.ReadFrom(raw[:n]); err != nil {
+			t.Errorf("failed to decode record %d: %v", i, err)
+		}
+		if record.OffsetDelta != int32(i) {
+			t.Errorf("unexpected offset delta %d", record.OffsetDelta)
+		}
+		records = append(records, fakeRecord{key: record.Key, value: string(record.Value)})
+		raw = raw[n:]
+	}
+
+	if int(batch.LastOffsetDelta) != len(records)-1 {
+		t.Errorf("unexpected last offset delta %d", batch.LastOffsetDelta)
+	}
+
+	return records, codec
+}
+
+// retryMetadataRefresh lets the client refresh the metadata right away when
+// retrying failed produce requests, instead of waiting for the metadata to be
+// at least 5 seconds old.
+var retryMetadataRefresh = kgo.MetadataMinAge(10 * time.Millisecond)
+
+func testConfig(t *testing.T, brokers []string, f func(*Config)) *Config {
+	t.Helper()
+	config := &Config{Brokers: brokers, Topic: "decisions"}
+	if f != nil {
+		f(config)
+	}
+	if err := config.ValidateAndInjectDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestProducer(t *testing.T) {
+	broker := newFakeBroker(t, "decisions", 3)
+
+	config := testConfig(t, []string{"127.0.0.1:1", broker.addr()}, func(c *Config) {
+		batchSize := 4
+		c.BatchSize = &batchSize
+	})
+
+	p, err := New(config, logging.NewNoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Start()
+
+	keys := []string{"authz/allow", "authz/deny", "system/main", ""}
+	for i := 0; i < 10; i++ {
+		var key []byte
+		if k := keys[i%len(keys)]; k != "" {
+			key = []byte(k)
+		}
+		if err := p.Send(context.Background(), key, []byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	p.Stop(ctx)
+
+	if err := p.Send(context.Background(), nil, []byte("x")); !errors.Is(err, ErrStopped) {
+		t.Fatalf("Expected stopped error but got %v", err)
+	}
+
+	// Partitions assigned to the keys by the default partitioner of the Kafka
+	// Java client.
+	partitions := map[string]int32{"authz/allow": 1, "authz/deny": 2, "system/main": 2}
+
+	seen := map[string]bool{}
+	for partition, records := range broker.received() {
+		for _, r := range records {
+			seen[r.value] = true
+			if r.key != nil {
+				if exp := partitions[string(r.key)]; exp != partition {
+					t.Errorf("Expected key %q in partition %d but got %d", r.key, exp, partition)
+				}
+			}
+		}
+	}
+
+	if len(seen) != 10 {
+		t.Fatalf("Expected 10 records but got %v", seen)
+	}
+}
+
+func TestProducerRetry(t *testing.T) {
+	broker := newFakeBroker(t, "decisions", 1, withFailedProduces(1))
+
+	m := metrics.New()
+	p, err := newProducer(testConfig(t, []string{broker.addr()}, nil), logging.NewNoOpLogger(), retryMetadataRefresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.WithMetrics(m)
+	p.Start()
+
+	for i := 0; i < 3; i++ {
+		if err := p.Send(context.Background(), []byte("authz/allow"), []byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	p.Stop(ctx)
+
+	if exp, act := 3, len(broker.received()[0]); exp != act {
+		t.Fatalf("Expected %d records but got %d", exp, act)
+	}
+	if broker.produceRequests != 2 {
+		t.Fatalf("Expected 2 produce requests but got %d", broker.produceRequests)
+	}
+	if act := m.Counter(deliveryFailureCounterName).Value(); act != uint64(0) {
+		t.Fatalf("Expected no delivery failures but got %d", act)
+	}
+}
+
+func TestProducerDeliveryFailure(t *testing.T) {
+	broker := newFakeBroker(t, "decisions", 1, withFailedProduces(2))
+
+	m := metrics.New()
+	p, err := newProducer(testConfig(t, []string{broker.addr()}, func(c *Config) {
+		retries := 1
+		c.MaxRetries = &retries
+	}), logging.NewNoOpLogger(), retryMetadataRefresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.WithMetrics(m)
+	p.Start()
+
+	if err := p.Send(context.Background(), nil, []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	p.Stop(ctx)
+
+	if act := m.Counter(deliveryFailureCounterName).Value(); act != uint64(1) {
+		t.Fatalf("Expected 1 delivery failure but got %d", act)
+	}
+}
+
+func TestProducerBackpressure(t *testing.T) {
+	maxBuffered := func(c *Config) {
+		n := 1
+		c.MaxBufferedEvents = &n
+	}
+
+	// The producers are not started so that the buffer fills up.
+	p, err := New(testConfig(t, []string{"127.0.0.1:1"}, maxBuffered), logging.NewNoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Send(context.Background(), nil, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Send(context.Background(), nil, []byte("b")); !errors.Is(err, ErrBufferFull) {
+		t.Fatalf("Expected buffer full error but got %v", err)
+	}
+
+	p, err = New(testConfig(t, []string{"127.0.0.1:1"}, func(c *Config) {
+		maxBuffered(c)
+		c.Backpressure = BackpressureBlock
+	}), logging.NewNoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Send(context.Background(), nil, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := p.Send(ctx, nil, []byte("b")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected deadline exceeded error but got %v", err)
+	}
+}
+
+func TestProducerRequiredAcksNone(t *testing.T) {
+	broker := newFakeBroker(t, "decisions", 2)
+
+	p, err := New(testConfig(t, []string{broker.addr()}, func(c *Config) {
+		acks := 0
+		c.RequiredAcks = &acks
+	}), logging.NewNoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Start()
+
+	for i := 0; i < 4; i++ {
+		if err := p.Send(context.Background(), nil, []byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	p.Stop(ctx)
+
+	// Without acks the producer does not wait for the broker to process the
+	// request, so poll for the records.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		received := broker.received()
+		if len(received[0])+len(received[1]) == 4 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 4 records but got %v", received)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestProducerCompression(t *testing.T) {
+	broker := newFakeBroker(t, "decisions", 1)
+
+	p, err := New(testConfig(t, []string{broker.addr()}, func(c *Config) {
+		c.Compression = CompressionGzip
+	}), logging.NewNoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Start()
+
+	// Record batches that do not shrink when compressed are published
+	// uncompressed, so use compressible values.
+	value := func(i int) string {
+		return strings.Repeat(fmt.Sprint(i), 100)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := p.Send(context.Background(), []byte("authz/allow"), []byte(value(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	p.Stop(ctx)
+
+	records := broker.received()[0]
+	if len(records) != 3 || records[0].value != value(0) || records[2].value != value(2) {
+		t.Fatalf("Unexpected records %v", records)
+	}
+	for _, codec := range broker.codecs {
+		if codec != 1 {
+			t.Fatalf("Expected gzip compressed record batches but got codec %d", codec)
+		}
+	}
+}
+
+func TestProducerSASLPlain(t *testing.T) {
+	tests := []struct {
+		note     string
+		password string
+		exp      int
+	}{
+		{note: "valid credentials", password: "secret", exp: 1},
+		{note: "invalid credentials", password: "wrong", exp: 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			broker := newFakeBroker(t, "decisions", 1, withCredentials("alice", "secret"))
+
+			m := metrics.New()
+			p, err := New(testConfig(t, []string{broker.addr()}, func(c *Config) {
+				retries := 0
+				c.MaxRetries = &retries
+				c.SASL = &SASLConfig{Username: "alice", Password: tc.password}
+			}), logging.NewNoOpLogger())
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.WithMetrics(m)
+			p.Start()
+
+			if err := p.Send(context.Background(), nil, []byte("x")); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			p.Stop(ctx)
+
+			if act := len(broker.received()[0]); act != tc.exp {
+				t.Fatalf("Expected %d records but got %d", tc.exp, act)
+			}
+			if act := m.Counter(deliveryFailureCounterName).Value(); act != uint64(1-tc.exp) {
+				t.Fatalf("Expected %d delivery failures but got %d", 1-tc.exp, act)
+			}
+		})
+	}
+}
+
+func TestProducerTLS(t *testing.T) {
+	certPEM, keyPEM := generateCert(t, "kafka.example.com")
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broker := newFakeBroker(t, "decisions", 1,
+		withTLS(&tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}),
+		withCredentials("alice", "secret"))
+
+	caCert := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caCert, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := New(testConfig(t, []string{broker.addr()}, func(c *Config) {
+		c.TLS = &TLSConfig{CACert: caCert, ServerName: "kafka.example.com"}
+		c.SASL = &SASLConfig{Username: "alice", Password: "secret"}
+	}), logging.NewNoOpLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Start()
+
+	if err := p.Send(context.Background(), nil, []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	p.Stop(ctx)
+
+	if exp, act := 1, len(broker.received()[0]); exp != act {
+		t.Fatalf("Expected %d records but got %d", exp, act)
+	}
+}
+
+// generateCert returns a self-signed certificate for host and its key.
+func generateCert(t *testing.T, host string) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: host},
+		DNSNames:              []string{host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestConfigValidateAndInjectDefaults(t *testing.T) {
+	tests := []struct {
+		note    string
+		config  Config
+		wantErr bool
+	}{
+		{note: "defaults", config: Config{Brokers: []string{"localhost:9092"}, Topic: "t"}},
+		{note: "missing brokers", config: Config{Topic: "t"}, wantErr: true},
+		{note: "missing topic", config: Config{Brokers: []string{"localhost:9092"}}, wantErr: true},
+		{note: "invalid backpressure", config: Config{Brokers: []string{"localhost:9092"}, Topic: "t", Backpressure: "wait"}, wantErr: true},
+		{note: "cert without key", config: Config{Brokers: []string{"localhost:9092"}, Topic: "t", TLS: &TLSConfig{Cert: "cert.pem"}}, wantErr: true},
+		{note: "missing ca cert", config: Config{Brokers: []string{"localhost:9092"}, Topic: "t", TLS: &TLSConfig{CACert: "does-not-exist.pem"}}, wantErr: true},
+		{note: "invalid compression", config: Config{Brokers: []string{"localhost:9092"}, Topic: "t", Compression: "lz4"}, wantErr: true},
+		{note: "sasl plain", config: Config{Brokers: []string{"localhost:9092"}, Topic: "t", SASL: &SASLConfig{Username: "u", Password: "p"}}},
+		{note: "sasl without username", config: Config{Brokers: []string{"localhost:9092"}, Topic: "t", SASL: &SASLConfig{Password: "p"}}, wantErr: true},
+		{note: "unsupported sasl mechanism", config: Config{Brokers: []string{"localhost:9092"}, Topic: "t", SASL: &SASLConfig{Mechanism: "SCRAM-SHA-256", Username: "u"}}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			err := tc.config.ValidateAndInjectDefaults()
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("Expected error")
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.config.ClientID != defaultClientID || *tc.config.RequiredAcks != defaultRequiredAcks ||
+				*tc.config.BatchSize != defaultBatchSize || tc.config.Backpressure != BackpressureDrop ||
+				tc.config.Compression != CompressionNone || (tc.config.SASL != nil && tc.config.SASL.Mechanism != SaslMechanismPlain) {
+				t.Fatalf("Unexpected defaults %+v", tc.config)
+			}
+		})
+	}
+}
@@ -19,9 +19,10 @@ const (
 	maskOPRemove maskOP = "remove"
 	maskOPUpsert maskOP = "upsert"
 
-	partInput    = "input"
-	partResult   = "result"
-	partNDBCache = "nd_builtin_cache"
+	partInput        = "input"
+	partResult       = "result"
+	partMappedResult = "mapped_result"
+	partNDBCache     = "nd_builtin_cache"
 )
 
 var (
@@ -38,9 +39,11 @@ type maskRule struct {
 }
 
 type maskRuleSet struct {
-	OnRuleError  func(*maskRule, error)
-	Rules        []*maskRule
-	resultCopied bool
+	OnRuleError        func(*maskRule, error)
+	Rules              []*maskRule
+	resultCopied       bool
+	mappedResultCopied bool
+	ndbCacheCopied     bool
 }
 
 func (r maskRule) String() string {
@@ -64,7 +67,7 @@ func newMaskRule(path string, opts ...maskRuleOption) (*maskRule, error) {
 	parts := strings.Split(path[1:], "/")
 
 	switch parts[0] {
-	case partInput, partResult, partNDBCache: // OK
+	case partInput, partResult, partMappedResult, partNDBCache: // OK
 	default:
 		return nil, fmt.Errorf("mask prefix not allowed: %v", parts[0])
 	}
@@ -127,8 +130,8 @@ func withFailUndefinedPath() maskRuleOption {
 
 func (r maskRule) Mask(event *EventV1) error {
 
-	var maskObj *interface{}     // pointer to event Input|Result|NDBCache object
-	var maskObjPtr **interface{} // pointer to the event Input|Result|NDBCache pointer itself
+	var maskObj *interface{}     // pointer to event Input|Result|MappedResult|NDBCache object
+	var maskObjPtr **interface{} // pointer to the event Input|Result|MappedResult|NDBCache pointer itself
 
 	switch p := r.escapedParts[0]; p {
 	case partInput:
@@ -149,6 +152,15 @@ func (r maskRule) Mask(event *EventV1) error {
 		}
 		maskObj = event.Result
 		maskObjPtr = &event.Result
+	case partMappedResult:
+		if event.MappedResult == nil {
+			if r.failUndefinedPath {
+				return errMaskInvalidObject
+			}
+			return nil
+		}
+		maskObj = event.MappedResult
+		maskObjPtr = &event.MappedResult
 	case partNDBCache:
 		if event.NDBuiltinCache == nil {
 			if r.failUndefinedPath {
@@ -193,12 +205,7 @@ func (r maskRule) Mask(event *EventV1) error {
 		if r.modifyFullObj {
 			*maskObjPtr = &r.Value
 		} else {
-			inputObj, ok := (*maskObj).(map[string]interface{})
-			if !ok {
-				return nil
-			}
-
-			if err := r.mkdirp(inputObj, r.escapedParts[1:len(r.escapedParts)], r.Value); err != nil {
+			if err := r.mkdirp(*maskObj, r.escapedParts[1:len(r.escapedParts)], r.Value); err != nil {
 				if r.failUndefinedPath {
 					return err
 				}
@@ -225,11 +232,9 @@ func (r maskRule) lookup(p []string, node interface{}) (interface{}, error) {
 				return nil, errMaskInvalidObject
 			}
 		case []interface{}:
-			idx, err := strconv.Atoi(p[i])
+			idx, err := arrayIndex(v, p[i])
 			if err != nil {
-				return nil, errMaskInvalidObject
-			} else if idx < 0 || idx >= len(v) {
-				return nil, errMaskInvalidObject
+				return nil, err
 			}
 			node = v[idx]
 		default:
@@ -240,35 +245,58 @@ func (r maskRule) lookup(p []string, node interface{}) (interface{}, error) {
 	return node, nil
 }
 
-func (r maskRule) mkdirp(node map[string]interface{}, path []string, value interface{}) error {
+// mkdirp sets value at path in node. Missing object keys along the path are
+// created. Array elements along the path must exist, they are never appended.
+func (r maskRule) mkdirp(node interface{}, path []string, value interface{}) error {
 	if len(path) == 0 {
 		return nil
 	}
 
 	// create intermediate nodes
 	for i := 0; i < len(path)-1; i++ {
-		child, ok := node[path[i]]
-
-		if !ok {
-			child := map[string]interface{}{}
-			node[path[i]] = child
-			node = child
-			continue
-		}
-
-		switch obj := child.(type) {
+		switch obj := node.(type) {
 		case map[string]interface{}:
-			node = obj
+			child, ok := obj[path[i]]
+			if !ok {
+				child = map[string]interface{}{}
+				obj[path[i]] = child
+			}
+			node = child
+		case []interface{}:
+			idx, err := arrayIndex(obj, path[i])
+			if err != nil {
+				return err
+			}
+			node = obj[idx]
 		default:
 			return errMaskInvalidObject
 		}
+	}
 
+	switch obj := node.(type) {
+	case map[string]interface{}:
+		obj[path[len(path)-1]] = value
+	case []interface{}:
+		idx, err := arrayIndex(obj, path[len(path)-1])
+		if err != nil {
+			return err
+		}
+		obj[idx] = value
+	default:
+		return errMaskInvalidObject
 	}
 
-	node[path[len(path)-1]] = value
 	return nil
 }
 
+func arrayIndex(arr []interface{}, s string) (int, error) {
+	idx, err := strconv.Atoi(s)
+	if err != nil || idx < 0 || idx >= len(arr) {
+		return 0, errMaskInvalidObject
+	}
+	return idx, nil
+}
+
 func newMaskRuleSet(rv interface{}, onRuleError func(*maskRule, error)) (*maskRuleSet, error) {
 	var mRuleSet = &maskRuleSet{
 		OnRuleError: onRuleError,
@@ -332,13 +360,16 @@ func newMaskRuleSet(rv interface{}, onRuleError func(*maskRule, error)) (*maskRu
 
 func (rs maskRuleSet) Mask(event *EventV1) {
 	for _, mRule := range rs.Rules {
-		// result must be deep copied if there are any mask rules
-		// targeting it, to avoid modifying the result sent
-		// to the consumer
-		if mRule.escapedParts[0] == partResult && event.Result != nil && !rs.resultCopied {
-			resultCopy := deepcopy.DeepCopy(*event.Result)
-			event.Result = &resultCopy
-			rs.resultCopied = true
+		// results and the ND builtin cache must be deep copied if
+		// there are any mask rules targeting them, to avoid modifying
+		// the values sent to the consumer
+		switch mRule.escapedParts[0] {
+		case partResult:
+			copyOnce(&event.Result, &rs.resultCopied)
+		case partMappedResult:
+			copyOnce(&event.MappedResult, &rs.mappedResultCopied)
+		case partNDBCache:
+			copyOnce(&event.NDBuiltinCache, &rs.ndbCacheCopied)
 		}
 		err := mRule.Mask(event)
 		if err != nil {
@@ -347,6 +378,14 @@ func (rs maskRuleSet) Mask(event *EventV1) {
 	}
 }
 
+func copyOnce(x **interface{}, copied *bool) {
+	if *x != nil && !*copied {
+		cpy := deepcopy.DeepCopy(**x)
+		*x = &cpy
+		*copied = true
+	}
+}
+
 // bool return means the field was set, if the string is still "", the
 // value was invalid
 func getString(x map[string]any, key string) (string, bool) {
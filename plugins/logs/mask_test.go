@@ -496,13 +496,63 @@ func TestMaskRuleMask(t *testing.T) {
 			exp:   `{"input": {"foo": [1]}}`,
 		},
 		{
-			note: "upsert: unsupported nested object type (array) #2",
+			note: "upsert: array element",
 			ptr: &maskRule{
-				OP:   maskOPUpsert,
-				Path: "/input/foo/0",
+				OP:    maskOPUpsert,
+				Path:  "/input/foo/0",
+				Value: "upserted",
 			},
 			event: `{"input": {"foo": [1]}}`,
-			exp:   `{"input": {"foo": [1]}}`,
+			exp:   `{"input": {"foo": ["upserted"]}, "masked": ["/input/foo/0"]}`,
+		},
+		{
+			note: "upsert: nested in array element",
+			ptr: &maskRule{
+				OP:    maskOPUpsert,
+				Path:  "/input/foo/0/bar",
+				Value: "upserted",
+			},
+			event: `{"input": {"foo": [{"baz": 1}]}}`,
+			exp:   `{"input": {"foo": [{"baz": 1, "bar": "upserted"}]}, "masked": ["/input/foo/0/bar"]}`,
+		},
+		{
+			note: "upsert result: nested in array element",
+			ptr: &maskRule{
+				OP:    maskOPUpsert,
+				Path:  "/result/users/1/ssn",
+				Value: "**REDACTED**",
+			},
+			event: `{"result": {"users": [{"name": "alice"}, {"name": "bob", "ssn": "123-45-6789"}]}}`,
+			exp:   `{"result": {"users": [{"name": "alice"}, {"name": "bob", "ssn": "**REDACTED**"}]}, "masked": ["/result/users/1/ssn"]}`,
+		},
+		{
+			note: "upsert result: not an object",
+			ptr: &maskRule{
+				OP:    maskOPUpsert,
+				Path:  "/result/foo",
+				Value: "upserted",
+			},
+			event: `{"result": true}`,
+			exp:   `{"result": true}`,
+		},
+		{
+			note: "erase mapped result",
+			ptr: &maskRule{
+				OP:   maskOPRemove,
+				Path: "/mapped_result/email",
+			},
+			event: `{"mapped_result": {"email": "bob@example.com", "allow": true}}`,
+			exp:   `{"mapped_result": {"allow": true}, "erased": ["/mapped_result/email"]}`,
+		},
+		{
+			note: "upsert nd builtin cache",
+			ptr: &maskRule{
+				OP:    maskOPUpsert,
+				Path:  "/nd_builtin_cache/http.send",
+				Value: "**REDACTED**",
+			},
+			event: `{"nd_builtin_cache": {"http.send": {"[{\"url\": \"https://example.com\"}]": {"body": "secret"}}, "time.now_ns": {"[]": 1}}}`,
+			exp:   `{"nd_builtin_cache": {"http.send": "**REDACTED**", "time.now_ns": {"[]": 1}}, "masked": ["/nd_builtin_cache/http.send"]}`,
 		},
 		{
 			note: "erase: object key",
@@ -686,6 +736,27 @@ func TestMaskRuleSetMask(t *testing.T) {
 			event: `{"input":{"a":{"b":"removeme","y":"stillhere"}},"result":{"c":{"d":"removeme","z":"stillhere"}}}`,
 			exp:   `{"input":{"a":{"y":"stillhere"}},"result":{"c":{"z":"stillhere"}},"erased":["/input/a/b", "/result/c/d"]}`,
 		},
+		{
+			note: "upsert result, mapped result and nd builtin cache",
+			rules: []*maskRule{
+				{
+					OP:    maskOPUpsert,
+					Path:  "/result/user/email",
+					Value: "**REDACTED**",
+				},
+				{
+					OP:    maskOPUpsert,
+					Path:  "/mapped_result/user/email",
+					Value: "**REDACTED**",
+				},
+				{
+					OP:   maskOPRemove,
+					Path: "/nd_builtin_cache/http.send",
+				},
+			},
+			event: `{"result":{"user":{"email":"bob@example.com"}},"mapped_result":{"user":{"email":"bob@example.com"}},"nd_builtin_cache":{"http.send":{"x":"y"},"time.now_ns":{"[]":1}}}`,
+			exp:   `{"result":{"user":{"email":"**REDACTED**"}},"mapped_result":{"user":{"email":"**REDACTED**"}},"nd_builtin_cache":{"time.now_ns":{"[]":1}},"masked":["/result/user/email","/mapped_result/user/email"],"erased":["/nd_builtin_cache/http.send"]}`,
+		},
 		{
 			note: "expected rule error",
 			rules: []*maskRule{
@@ -753,6 +824,14 @@ func TestMaskRuleSetMask(t *testing.T) {
 				t.Fatal("Expected event.Result to be deep copied during masking, so that the event's original Result is not modified")
 			}
 
+			if origEvent.MappedResult != nil && reflect.DeepEqual(origEvent.MappedResult, event.MappedResult) {
+				t.Fatal("Expected event.MappedResult to be deep copied during masking, so that the event's original MappedResult is not modified")
+			}
+
+			if origEvent.NDBuiltinCache != nil && reflect.DeepEqual(origEvent.NDBuiltinCache, event.NDBuiltinCache) {
+				t.Fatal("Expected event.NDBuiltinCache to be deep copied during masking, so that the event's original NDBuiltinCache is not modified")
+			}
+
 			if tc.expErr != nil {
 				if ruleErr == nil {
 					t.Fatalf("Expected: %s\nGot:%s", tc.expErr.Error(), "nil")
@@ -8,6 +8,7 @@ package logs
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"math/rand"
@@ -24,6 +25,7 @@ import (
 	"github.com/open-policy-agent/opa/logging"
 	"github.com/open-policy-agent/opa/metrics"
 	"github.com/open-policy-agent/opa/plugins"
+	"github.com/open-policy-agent/opa/plugins/logs/kafka"
 	lstat "github.com/open-policy-agent/opa/plugins/logs/status"
 	"github.com/open-policy-agent/opa/plugins/rest"
 	"github.com/open-policy-agent/opa/plugins/status"
@@ -258,6 +260,7 @@ const (
 	logNDBDropCounterName               = "decision_logs_nd_builtin_cache_dropped"
 	logBufferSizeLimitExDropCounterName = "decision_logs_dropped_buffer_size_limit_bytes_exceeded"
 	logEncodingFailureCounterName       = "decision_logs_encoding_failure"
+	logKafkaBufferFullDropCounterName   = "decision_logs_kafka_dropped_buffer_full"
 	defaultResourcePath                 = "/logs"
 )
 
@@ -289,6 +292,7 @@ type Config struct {
 	MaskDecision    *string              `json:"mask_decision"`
 	DropDecision    *string              `json:"drop_decision"`
 	ConsoleLogs     bool                 `json:"console"`
+	Kafka           *kafka.Config        `json:"kafka,omitempty"`
 	Resource        *string              `json:"resource"`
 	NDBuiltinCache  bool                 `json:"nd_builtin_cache,omitempty"`
 	maskDecisionRef ast.Ref
@@ -308,11 +312,11 @@ func (c *Config) validateAndInjectDefaults(services []string, pluginsList []stri
 		if !found {
 			return fmt.Errorf("invalid plugin name %q in decision_logs", *c.Plugin)
 		}
-	} else if c.Service == "" && len(services) != 0 && !c.ConsoleLogs {
+	} else if c.Service == "" && len(services) != 0 && !c.ConsoleLogs && c.Kafka == nil {
 		// For backwards compatibility allow defaulting to the first
-		// service listed, but only if console logging is disabled. If enabled
-		// we can't tell if the deployer wanted to use only console logs or
-		// both console logs and the default service option.
+		// service listed, but only if console and Kafka logging are disabled.
+		// If enabled we can't tell if the deployer wanted to use only those
+		// sinks or both those sinks and the default service option.
 		c.Service = services[0]
 	} else if c.Service != "" {
 		found := false
@@ -329,6 +333,12 @@ func (c *Config) validateAndInjectDefaults(services []string, pluginsList []stri
 		}
 	}
 
+	if c.Kafka != nil {
+		if err := c.Kafka.ValidateAndInjectDefaults(); err != nil {
+			return fmt.Errorf("invalid kafka config in decision_logs: %w", err)
+		}
+	}
+
 	t, err := plugins.ValidateAndInjectDefaultsForTriggerMode(trigger, c.Reporting.Trigger)
 	if err != nil {
 		return fmt.Errorf("invalid decision_log config: %w", err)
@@ -428,6 +438,7 @@ type Plugin struct {
 	metrics      metrics.Metrics
 	logger       logging.Logger
 	status       *lstat.Status
+	kafka        *kafka.Producer
 }
 
 type prepareOnce struct {
@@ -518,7 +529,7 @@ func (b *ConfigBuilder) Parse() (*Config, error) {
 		return nil, err
 	}
 
-	if parsedConfig.Plugin == nil && parsedConfig.Service == "" && len(b.services) == 0 && !parsedConfig.ConsoleLogs {
+	if parsedConfig.Plugin == nil && parsedConfig.Service == "" && len(b.services) == 0 && !parsedConfig.ConsoleLogs && parsedConfig.Kafka == nil {
 		// Nothing to validate or inject
 		return nil, nil
 	}
@@ -551,6 +562,8 @@ func New(parsedConfig *Config, manager *plugins.Manager) *Plugin {
 		plugin.limiter = rate.NewLimiter(rate.Limit(limit), int(math.Max(1, limit)))
 	}
 
+	plugin.kafka = plugin.newKafkaProducer(parsedConfig.Kafka)
+
 	manager.RegisterCompilerTrigger(plugin.compilerUpdated)
 
 	manager.UpdatePluginStatus(Name, &plugins.Status{State: plugins.StateNotReady})
@@ -562,6 +575,9 @@ func New(parsedConfig *Config, manager *plugins.Manager) *Plugin {
 func (p *Plugin) WithMetrics(m metrics.Metrics) *Plugin {
 	p.metrics = m
 	p.enc.WithMetrics(m)
+	if p.kafka != nil {
+		p.kafka.WithMetrics(m)
+	}
 	return p
 }
 
@@ -579,6 +595,9 @@ func Lookup(manager *plugins.Manager) *Plugin {
 // Start starts the plugin.
 func (p *Plugin) Start(_ context.Context) error {
 	p.logger.Info("Starting decision logger.")
+	if p.kafka != nil {
+		p.kafka.Start()
+	}
 	go p.loop()
 	p.manager.UpdatePluginStatus(Name, &plugins.Status{State: plugins.StateOK})
 	return nil
@@ -597,6 +616,15 @@ func (p *Plugin) Stop(ctx context.Context) {
 	done := make(chan struct{})
 	p.stop <- done
 	<-done
+
+	p.mtx.Lock()
+	producer := p.kafka
+	p.kafka = nil
+	p.mtx.Unlock()
+	if producer != nil {
+		producer.Stop(ctx)
+	}
+
 	p.manager.UpdatePluginStatus(Name, &plugins.Status{State: plugins.StateNotReady})
 }
 
@@ -713,6 +741,14 @@ func (p *Plugin) Log(ctx context.Context, decision *server.Info) error {
 		}
 	}
 
+	p.mtx.Lock()
+	producer := p.kafka
+	p.mtx.Unlock()
+
+	if producer != nil {
+		p.publishEvent(ctx, producer, event)
+	}
+
 	if p.config.Service != "" {
 		p.mtx.Lock()
 		p.encodeAndBufferEvent(event)
@@ -920,9 +956,72 @@ func (p *Plugin) reconfigure(config interface{}) {
 	}
 
 	p.logger.Info("Decision log uploader configuration changed.")
+
+	if !reflect.DeepEqual(p.config.Kafka, newConfig.Kafka) {
+		producer := p.newKafkaProducer(newConfig.Kafka)
+		if producer != nil {
+			producer.Start()
+		}
+
+		p.mtx.Lock()
+		old := p.kafka
+		p.kafka = producer
+		p.mtx.Unlock()
+
+		if old != nil {
+			old.Stop(context.Background())
+		}
+	}
+
 	p.config = *newConfig
 }
 
+// newKafkaProducer returns a new producer for the Kafka sink or nil if the
+// sink is not configured or the producer cannot be created.
+func (p *Plugin) newKafkaProducer(config *kafka.Config) *kafka.Producer {
+	if config == nil {
+		return nil
+	}
+
+	producer, err := kafka.New(config, p.logger)
+	if err != nil {
+		p.logger.Error("Failed to create Kafka producer: %v.", err)
+		return nil
+	}
+
+	if p.metrics != nil {
+		producer.WithMetrics(p.metrics)
+	}
+
+	return producer
+}
+
+// publishEvent sends the event to Kafka. The decision path is used as the key
+// so that events for the same decision are published to the same partition.
+func (p *Plugin) publishEvent(ctx context.Context, producer *kafka.Producer, event EventV1) {
+	bs, err := json.Marshal(&event)
+	if err != nil {
+		p.logger.Error("Failed to encode decision log event for Kafka: %v.", err)
+		return
+	}
+
+	var key []byte
+	if event.Path != "" {
+		key = []byte(event.Path)
+	}
+
+	switch err := producer.Send(ctx, key, bs); {
+	case err == nil:
+	case errors.Is(err, kafka.ErrBufferFull):
+		if p.metrics != nil {
+			p.metrics.Counter(logKafkaBufferFullDropCounterName).Incr()
+		}
+		p.logger.Debug("Decision log event to path %v dropped, Kafka buffer full.", event.Path)
+	default:
+		p.logger.Error("Failed to send decision log event to Kafka: %v.", err)
+	}
+}
+
 // NOTE(philipc): Because ND builtins caching can cause unbounded growth in
 // decision log entry size, we do best-effort event encoding here, and when we
 // run out of space, we drop the ND builtins cache, and try encoding again.
@@ -27,6 +27,7 @@ import (
 	"github.com/open-policy-agent/opa/metrics"
 	"github.com/open-policy-agent/opa/plugins"
 	"github.com/open-policy-agent/opa/plugins/bundle"
+	"github.com/open-policy-agent/opa/plugins/logs/kafka"
 	"github.com/open-policy-agent/opa/plugins/status"
 	"github.com/open-policy-agent/opa/rego"
 	"github.com/open-policy-agent/opa/server"
@@ -2676,6 +2677,57 @@ func TestParseConfigDefaultServiceWithConsole(t *testing.T) {
 	}
 }
 
+func TestParseConfigKafka(t *testing.T) {
+	services := []string{"s0"}
+
+	config, err := ParseConfig([]byte(`{"kafka": {"brokers": ["localhost:9092"], "topic": "decisions"}}`), services, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if config.Service != "" {
+		t.Errorf("Expected no service in config, actual = '%s'", config.Service)
+	}
+
+	if config.Kafka.Backpressure != kafka.BackpressureDrop || *config.Kafka.BatchSize != 100 {
+		t.Errorf("Expected Kafka defaults to be injected, actual = %+v", config.Kafka)
+	}
+
+	config, err = ParseConfig([]byte(`{"kafka": {"brokers": ["localhost:9092"], "topic": "decisions"}}`), nil, nil)
+	if err != nil || config == nil {
+		t.Fatalf("Expected config without services but got %v, %v", config, err)
+	}
+
+	_, err = ParseConfig([]byte(`{"kafka": {"topic": "decisions"}}`), services, nil)
+	if err == nil || err.Error() != "invalid kafka config in decision_logs: missing brokers" {
+		t.Fatalf("Expected missing brokers error but got %v", err)
+	}
+}
+
+func TestPluginKafkaBufferFull(t *testing.T) {
+	ctx := context.Background()
+	manager, _ := plugins.New(nil, "test-instance-id", inmem.New())
+
+	config, err := ParseConfig([]byte(`{"kafka": {"brokers": ["127.0.0.1:1"], "topic": "decisions", "max_buffered_events": 1}}`), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The plugin is not started so that events are not consumed from the buffer.
+	m := metrics.New()
+	plugin := New(config, manager).WithMetrics(m)
+
+	for i := 0; i < 3; i++ {
+		if err := plugin.Log(ctx, &server.Info{Path: "authz/allow"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if exp, act := uint64(2), m.Counter(logKafkaBufferFullDropCounterName).Value(); exp != act {
+		t.Fatalf("Expected %d dropped events but got %v", exp, act)
+	}
+}
+
 func TestParseConfigTriggerMode(t *testing.T) {
 	cases := []struct {
 		note     string
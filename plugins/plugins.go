@@ -112,6 +112,27 @@ type Triggerable interface {
 	Trigger(context.Context) error
 }
 
+// HealthCheck defines the function plugins use to contribute a named check to
+// the health API. A non-nil error marks the check, and OPA, as unhealthy.
+type HealthCheck func(ctx context.Context) error
+
+// BundleActivationHook defines the interface plugins use to be notified
+// synchronously when bundles are activated. Both methods are called within
+// the activation transaction, so that plugins can read the store consistently
+// with the activated bundles. Returning an error aborts the activation.
+type BundleActivationHook interface {
+	BeforeBundleActivation(ctx context.Context, txn storage.Transaction, bundles map[string]*bundle.Bundle) error
+	AfterBundleActivation(ctx context.Context, txn storage.Transaction, bundles map[string]*bundle.Bundle) error
+}
+
+// DataWriteHook defines the interface plugins use to be notified
+// synchronously when data is written through the Data API. Both methods are
+// called within the write transaction. Returning an error aborts the write.
+type DataWriteHook interface {
+	BeforeDataWrite(ctx context.Context, txn storage.Transaction, op storage.PatchOp, path storage.Path, value interface{}) error
+	AfterDataWrite(ctx context.Context, txn storage.Transaction, op storage.PatchOp, path storage.Path, value interface{}) error
+}
+
 // State defines the state that a Plugin instance is currently
 // in with pre-defined states.
 type State string
@@ -182,9 +203,12 @@ type Manager struct {
 	keys                         map[string]*keys.Config
 	plugins                      []namedplugin
 	registeredTriggers           []func(storage.Transaction)
+	registeredActivationHooks    []BundleActivationHook
+	registeredDataWriteHooks     []DataWriteHook
 	mtx                          sync.Mutex
 	pluginStatus                 map[string]*Status
 	pluginStatusListeners        map[string]StatusListener
+	healthChecks                 map[string]HealthCheck
 	initBundles                  map[string]*bundle.Bundle
 	initFiles                    loader.Result
 	maxErrors                    int
@@ -431,6 +455,7 @@ func New(raw []byte, id string, store storage.Store, opts ...func(*Manager)) (*M
 		ID:                    id,
 		pluginStatus:          map[string]*Status{},
 		pluginStatusListeners: map[string]StatusListener{},
+		healthChecks:          map[string]HealthCheck{},
 		maxErrors:             -1,
 		serverInitialized:     make(chan struct{}),
 		bootstrapConfigLabels: parsedConfig.Labels,
@@ -660,6 +685,78 @@ func (m *Manager) RegisterCompilerTrigger(f func(storage.Transaction)) {
 	m.registeredTriggers = append(m.registeredTriggers, f)
 }
 
+// RegisterBundleActivationHook registers h to be called before and after
+// bundles are activated. Hooks are called in registration order.
+func (m *Manager) RegisterBundleActivationHook(h BundleActivationHook) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.registeredActivationHooks = append(m.registeredActivationHooks, h)
+}
+
+// RegisterDataWriteHook registers h to be called before and after data is
+// written through the Data API. Hooks are called in registration order.
+func (m *Manager) RegisterDataWriteHook(h DataWriteHook) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.registeredDataWriteHooks = append(m.registeredDataWriteHooks, h)
+}
+
+// BeforeBundleActivation calls the registered bundle activation hooks before
+// bundles are activated in txn.
+func (m *Manager) BeforeBundleActivation(ctx context.Context, txn storage.Transaction, bundles map[string]*bundle.Bundle) error {
+	for _, h := range m.activationHooks() {
+		if err := h.BeforeBundleActivation(ctx, txn, bundles); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AfterBundleActivation calls the registered bundle activation hooks after
+// bundles have been activated in txn.
+func (m *Manager) AfterBundleActivation(ctx context.Context, txn storage.Transaction, bundles map[string]*bundle.Bundle) error {
+	for _, h := range m.activationHooks() {
+		if err := h.AfterBundleActivation(ctx, txn, bundles); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BeforeDataWrite calls the registered data write hooks before value is
+// written at path in txn.
+func (m *Manager) BeforeDataWrite(ctx context.Context, txn storage.Transaction, op storage.PatchOp, path storage.Path, value interface{}) error {
+	for _, h := range m.dataWriteHooks() {
+		if err := h.BeforeDataWrite(ctx, txn, op, path, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AfterDataWrite calls the registered data write hooks after value has been
+// written at path in txn.
+func (m *Manager) AfterDataWrite(ctx context.Context, txn storage.Transaction, op storage.PatchOp, path storage.Path, value interface{}) error {
+	for _, h := range m.dataWriteHooks() {
+		if err := h.AfterDataWrite(ctx, txn, op, path, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Manager) activationHooks() []BundleActivationHook {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.registeredActivationHooks
+}
+
+func (m *Manager) dataWriteHooks() []DataWriteHook {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.registeredDataWriteHooks
+}
+
 // GetWasmResolvers returns the manager's set of Wasm Resolvers.
 func (m *Manager) GetWasmResolvers() []*wasm.Resolver {
 	m.wasmResolversMtx.RLock()
@@ -835,6 +932,35 @@ func (m *Manager) UnregisterPluginStatusListener(name string) {
 	delete(m.pluginStatusListeners, name)
 }
 
+// RegisterHealthCheck registers a HealthCheck to be run by the health API
+// under name. A check registered with the same name is replaced.
+func (m *Manager) RegisterHealthCheck(name string, check HealthCheck) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.healthChecks[name] = check
+}
+
+// UnregisterHealthCheck removes the HealthCheck registered with the same name.
+func (m *Manager) UnregisterHealthCheck(name string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	delete(m.healthChecks, name)
+}
+
+// HealthChecks returns a copy of the registered health checks, by name.
+func (m *Manager) HealthChecks() map[string]HealthCheck {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	result := make(map[string]HealthCheck, len(m.healthChecks))
+	for k, v := range m.healthChecks {
+		result[k] = v
+	}
+	return result
+}
+
 // UpdatePluginStatus updates a named plugins status. Any registered
 // listeners will be called with a copy of the new state of all
 // plugins.
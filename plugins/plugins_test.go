@@ -7,6 +7,7 @@ package plugins
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -104,6 +105,73 @@ func TestManagerNDCacheTriggers(t *testing.T) {
 	}
 }
 
+type testHook struct {
+	name  string
+	calls *[]string
+	err   error
+}
+
+func (h testHook) BeforeBundleActivation(context.Context, storage.Transaction, map[string]*bundle.Bundle) error {
+	*h.calls = append(*h.calls, "before activation "+h.name)
+	return h.err
+}
+
+func (h testHook) AfterBundleActivation(context.Context, storage.Transaction, map[string]*bundle.Bundle) error {
+	*h.calls = append(*h.calls, "after activation "+h.name)
+	return h.err
+}
+
+func (h testHook) BeforeDataWrite(context.Context, storage.Transaction, storage.PatchOp, storage.Path, interface{}) error {
+	*h.calls = append(*h.calls, "before write "+h.name)
+	return h.err
+}
+
+func (h testHook) AfterDataWrite(context.Context, storage.Transaction, storage.PatchOp, storage.Path, interface{}) error {
+	*h.calls = append(*h.calls, "after write "+h.name)
+	return h.err
+}
+
+func TestManagerActivationAndDataWriteHooks(t *testing.T) {
+	ctx := context.Background()
+	m, err := New([]byte{}, "test", inmem.New())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	var calls []string
+	hookErr := errors.New("hook failed")
+
+	for _, h := range []testHook{{name: "h1", calls: &calls}, {name: "h2", calls: &calls, err: hookErr}, {name: "h3", calls: &calls}} {
+		m.RegisterBundleActivationHook(h)
+		m.RegisterDataWriteHook(h)
+	}
+
+	txn := storage.NewTransactionOrDie(ctx, m.Store, storage.WriteParams)
+	defer m.Store.Abort(ctx, txn)
+
+	// Hooks are called in registration order, until one fails.
+	for _, err := range []error{
+		m.BeforeBundleActivation(ctx, txn, nil),
+		m.AfterBundleActivation(ctx, txn, nil),
+		m.BeforeDataWrite(ctx, txn, storage.AddOp, storage.Path{"a"}, 1),
+		m.AfterDataWrite(ctx, txn, storage.AddOp, storage.Path{"a"}, 1),
+	} {
+		if err != hookErr {
+			t.Fatalf("Expected hook error but got %v", err)
+		}
+	}
+
+	exp := []string{
+		"before activation h1", "before activation h2",
+		"after activation h1", "after activation h2",
+		"before write h1", "before write h2",
+		"after write h1", "after write h2",
+	}
+	if !reflect.DeepEqual(calls, exp) {
+		t.Fatalf("Expected calls %v but got %v", exp, calls)
+	}
+}
+
 func TestManagerPluginStatusListener(t *testing.T) {
 	m, err := New([]byte{}, "test", inmem.New())
 	if err != nil {
@@ -0,0 +1,106 @@
+package authentication
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/open-policy-agent/opa/util"
+)
+
+const (
+	defaultJWKSRefreshSeconds = 3600
+)
+
+// Config represents the configuration for the Server.Authentication settings
+type Config struct {
+	JWT *JWT `json:"jwt,omitempty"`
+}
+
+// JWT represents the configuration of the bearer JWT authentication: the
+// issuers whose tokens are accepted, and how their signing keys are fetched.
+type JWT struct {
+	Issuers            []*Issuer `json:"issuers,omitempty"`
+	JWKSRefreshSeconds *int64    `json:"jwks_refresh_seconds,omitempty"` // how long fetched keys are cached
+	LeewaySeconds      int64     `json:"leeway_seconds,omitempty"`       // the clock skew tolerated on exp and nbf
+}
+
+// Issuer represents an issuer of tokens, identified by the iss claim.
+type Issuer struct {
+	Issuer    string   `json:"issuer"`
+	JWKSURL   string   `json:"jwks_url"`
+	Audiences []string `json:"audiences,omitempty"` // one of them must be in the aud claim, if set
+}
+
+// ConfigBuilder assists in the construction of the plugin configuration.
+type ConfigBuilder struct {
+	raw []byte
+}
+
+// NewConfigBuilder returns a new ConfigBuilder to build and parse the server config
+func NewConfigBuilder() *ConfigBuilder {
+	return &ConfigBuilder{}
+}
+
+// WithBytes sets the raw server config
+func (b *ConfigBuilder) WithBytes(config []byte) *ConfigBuilder {
+	b.raw = config
+	return b
+}
+
+// Parse returns a valid Config object with defaults injected.
+func (b *ConfigBuilder) Parse() (*Config, error) {
+	if b.raw == nil {
+		return &Config{}, nil
+	}
+
+	var result Config
+
+	if err := util.Unmarshal(b.raw, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, result.validateAndInjectDefaults()
+}
+
+func (c *Config) validateAndInjectDefaults() error {
+	if c.JWT == nil {
+		return nil
+	}
+
+	if len(c.JWT.Issuers) == 0 {
+		return fmt.Errorf("invalid value for server.authentication.jwt.issuers field, should be a non-empty array")
+	}
+
+	issuers := map[string]struct{}{}
+
+	for i, iss := range c.JWT.Issuers {
+		if iss == nil {
+			return fmt.Errorf("invalid value for server.authentication.jwt.issuers[%d] field, should be an object", i)
+		}
+
+		if iss.Issuer == "" {
+			return fmt.Errorf("invalid value for server.authentication.jwt.issuers[%d].issuer field, should be a non-empty string", i)
+		}
+		if _, ok := issuers[iss.Issuer]; ok {
+			return fmt.Errorf("invalid value for server.authentication.jwt.issuers[%d].issuer field, duplicate issuer %q", i, iss.Issuer)
+		}
+		issuers[iss.Issuer] = struct{}{}
+
+		if u, err := url.Parse(iss.JWKSURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid value for server.authentication.jwt.issuers[%d].jwks_url field, should be an HTTP(S) URL", i)
+		}
+	}
+
+	if c.JWT.JWKSRefreshSeconds == nil {
+		refresh := int64(defaultJWKSRefreshSeconds)
+		c.JWT.JWKSRefreshSeconds = &refresh
+	} else if *c.JWT.JWKSRefreshSeconds <= 0 {
+		return fmt.Errorf("invalid value for server.authentication.jwt.jwks_refresh_seconds field, should be a positive number")
+	}
+
+	if c.JWT.LeewaySeconds < 0 {
+		return fmt.Errorf("invalid value for server.authentication.jwt.leeway_seconds field, should be a non-negative number")
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package authentication
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestConfigValidation(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantErr bool
+	}{
+		{
+			input:   `{}`,
+			wantErr: false,
+		},
+		{
+			input:   `{"jwt": {"issuers": [{"issuer": "https://issuer.example.com", "jwks_url": "https://issuer.example.com/keys", "audiences": ["opa"]}], "jwks_refresh_seconds": 60, "leeway_seconds": 5}}`,
+			wantErr: false,
+		},
+		{
+			input:   `{"jwt": {}}`,
+			wantErr: true,
+		},
+		{
+			input:   `{"jwt": {"issuers": [null]}}`,
+			wantErr: true,
+		},
+		{
+			input:   `{"jwt": {"issuers": [{"jwks_url": "https://issuer.example.com/keys"}]}}`,
+			wantErr: true,
+		},
+		{
+			input:   `{"jwt": {"issuers": [{"issuer": "a", "jwks_url": "https://a/keys"}, {"issuer": "a", "jwks_url": "https://b/keys"}]}}`,
+			wantErr: true,
+		},
+		{
+			input:   `{"jwt": {"issuers": [{"issuer": "a"}]}}`,
+			wantErr: true,
+		},
+		{
+			input:   `{"jwt": {"issuers": [{"issuer": "a", "jwks_url": "file:///keys"}]}}`,
+			wantErr: true,
+		},
+		{
+			input:   `{"jwt": {"issuers": [{"issuer": "a", "jwks_url": "https://a/keys"}], "jwks_refresh_seconds": 0}}`,
+			wantErr: true,
+		},
+		{
+			input:   `{"jwt": {"issuers": [{"issuer": "a", "jwks_url": "https://a/keys"}], "leeway_seconds": -1}}`,
+			wantErr: true,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("TestConfigValidation_case_%d", i), func(t *testing.T) {
+			_, err := NewConfigBuilder().WithBytes([]byte(test.input)).Parse()
+			if err != nil && !test.wantErr {
+				t.Fail()
+			}
+			if err == nil && test.wantErr {
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestConfigValue(t *testing.T) {
+	config, err := NewConfigBuilder().WithBytes([]byte(`{"jwt": {"issuers": [{"issuer": "a", "jwks_url": "https://a/keys"}]}}`)).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r := config.JWT.JWKSRefreshSeconds; r == nil || *r != defaultJWKSRefreshSeconds {
+		t.Fatalf("expected default refresh of %d, got %v", defaultJWKSRefreshSeconds, r)
+	}
+
+	config, err = NewConfigBuilder().Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.JWT != nil {
+		t.Fatalf("expected no JWT config, got %v", config.JWT)
+	}
+}
@@ -0,0 +1,74 @@
+package decoding
+
+import (
+	"fmt"
+
+	"github.com/open-policy-agent/opa/util"
+)
+
+var defaultInterningMaxStringLength = 64
+
+// Config represents the configuration for the Server.Decoding settings
+type Config struct {
+	Interning *Interning `json:"interning,omitempty"`
+}
+
+// Interning represents the configuration for the Server.Decoding.Interning
+// settings. The strings of the inputs are interned when MaxEntries is set.
+type Interning struct {
+	MaxEntries      *int `json:"max_entries,omitempty"`       // the number of strings held by the pool
+	MaxStringLength *int `json:"max_string_length,omitempty"` // the length of the longest string interned, in bytes
+}
+
+// ConfigBuilder assists in the construction of the plugin configuration.
+type ConfigBuilder struct {
+	raw []byte
+}
+
+// NewConfigBuilder returns a new ConfigBuilder to build and parse the server config
+func NewConfigBuilder() *ConfigBuilder {
+	return &ConfigBuilder{}
+}
+
+// WithBytes sets the raw server config
+func (b *ConfigBuilder) WithBytes(config []byte) *ConfigBuilder {
+	b.raw = config
+	return b
+}
+
+// Parse returns a valid Config object with defaults injected.
+func (b *ConfigBuilder) Parse() (*Config, error) {
+	if b.raw == nil {
+		return &Config{}, nil
+	}
+
+	var result Config
+
+	if err := util.Unmarshal(b.raw, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, result.validateAndInjectDefaults()
+}
+
+func (c *Config) validateAndInjectDefaults() error {
+	if c.Interning == nil {
+		return nil
+	}
+
+	if c.Interning.MaxEntries == nil {
+		return fmt.Errorf("invalid value for server.decoding.interning field, max_entries must be set")
+	}
+	if *c.Interning.MaxEntries <= 0 {
+		return fmt.Errorf("invalid value for server.decoding.interning.max_entries field, should be a positive number")
+	}
+
+	if c.Interning.MaxStringLength == nil {
+		maxStringLength := defaultInterningMaxStringLength
+		c.Interning.MaxStringLength = &maxStringLength
+	} else if *c.Interning.MaxStringLength <= 0 {
+		return fmt.Errorf("invalid value for server.decoding.interning.max_string_length field, should be a positive number")
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package decoding
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestConfigValidation(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantErr bool
+	}{
+		{
+			input:   `{}`,
+			wantErr: false,
+		},
+		{
+			input:   `{"interning": {"max_entries": 10000, "max_string_length": 32}}`,
+			wantErr: false,
+		},
+		{
+			input:   `{"interning": {}}`,
+			wantErr: true,
+		},
+		{
+			input:   `{"interning": {"max_entries": "10000"}}`,
+			wantErr: true,
+		},
+		{
+			input:   `{"interning": {"max_entries": 0}}`,
+			wantErr: true,
+		},
+		{
+			input:   `{"interning": {"max_entries": 10000, "max_string_length": -1}}`,
+			wantErr: true,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("TestConfigValidation_case_%d", i), func(t *testing.T) {
+			_, err := NewConfigBuilder().WithBytes([]byte(test.input)).Parse()
+			if err != nil && !test.wantErr {
+				t.Fail()
+			}
+			if err == nil && test.wantErr {
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestConfigValue(t *testing.T) {
+	config, err := NewConfigBuilder().WithBytes([]byte(`{"interning": {"max_entries": 100}}`)).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *config.Interning.MaxStringLength != defaultInterningMaxStringLength {
+		t.Fatalf("expected default max string length, got %v", *config.Interning.MaxStringLength)
+	}
+
+	config, err = NewConfigBuilder().Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Interning != nil {
+		t.Fatalf("expected interning to be disabled, got %+v", config.Interning)
+	}
+}
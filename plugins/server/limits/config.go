@@ -0,0 +1,116 @@
+package limits
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/open-policy-agent/opa/util"
+)
+
+// Config represents the configuration for the Server.Limits settings
+type Config struct {
+	Decisions []*Decision `json:"decisions,omitempty"`
+	Query     *Query      `json:"query,omitempty"`
+}
+
+// Decision represents the configuration of the limits for the decisions at
+// and beneath a path, e.g., "authz" for decisions at /v1/data/authz/allow.
+// The limits are shared by all decisions beneath the path. Unset limits are
+// not enforced.
+type Decision struct {
+	Path                 string   `json:"path"`
+	RequestsPerSecond    *float64 `json:"requests_per_second,omitempty"`    // the sustained rate of requests
+	Burst                *int     `json:"burst,omitempty"`                  // the number of requests above the rate allowed at once
+	MaxInFlight          *int     `json:"max_in_flight,omitempty"`          // the number of concurrent evaluations
+	MaxEvaluationSeconds *float64 `json:"max_evaluation_seconds,omitempty"` // the duration after which evaluations are cancelled
+}
+
+// Query represents the configuration of the limits for the ad-hoc queries of
+// the Query API. Unset limits are not enforced.
+type Query struct {
+	MaxResults     *int   `json:"max_results,omitempty"`      // the number of results of a query
+	MaxResultBytes *int64 `json:"max_result_bytes,omitempty"` // the size of the results of a query
+}
+
+// ConfigBuilder assists in the construction of the plugin configuration.
+type ConfigBuilder struct {
+	raw []byte
+}
+
+// NewConfigBuilder returns a new ConfigBuilder to build and parse the server config
+func NewConfigBuilder() *ConfigBuilder {
+	return &ConfigBuilder{}
+}
+
+// WithBytes sets the raw server config
+func (b *ConfigBuilder) WithBytes(config []byte) *ConfigBuilder {
+	b.raw = config
+	return b
+}
+
+// Parse returns a valid Config object with defaults injected.
+func (b *ConfigBuilder) Parse() (*Config, error) {
+	if b.raw == nil {
+		return &Config{}, nil
+	}
+
+	var result Config
+
+	if err := util.Unmarshal(b.raw, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, result.validateAndInjectDefaults()
+}
+
+func (c *Config) validateAndInjectDefaults() error {
+	paths := map[string]struct{}{}
+
+	for i, d := range c.Decisions {
+		if d == nil {
+			return fmt.Errorf("invalid value for server.limits.decisions[%d] field, should be an object", i)
+		}
+
+		d.Path = strings.Trim(d.Path, "/")
+		if _, ok := paths[d.Path]; ok {
+			return fmt.Errorf("invalid value for server.limits.decisions[%d].path field, duplicate path %q", i, d.Path)
+		}
+		paths[d.Path] = struct{}{}
+
+		if d.RequestsPerSecond != nil && *d.RequestsPerSecond <= 0 {
+			return fmt.Errorf("invalid value for server.limits.decisions[%d].requests_per_second field, should be a positive number", i)
+		}
+
+		if d.Burst != nil {
+			if d.RequestsPerSecond == nil {
+				return fmt.Errorf("invalid value for server.limits.decisions[%d].burst field, requires requests_per_second", i)
+			}
+			if *d.Burst <= 0 {
+				return fmt.Errorf("invalid value for server.limits.decisions[%d].burst field, should be a positive number", i)
+			}
+		} else if d.RequestsPerSecond != nil {
+			burst := int(math.Ceil(*d.RequestsPerSecond))
+			d.Burst = &burst
+		}
+
+		if d.MaxInFlight != nil && *d.MaxInFlight <= 0 {
+			return fmt.Errorf("invalid value for server.limits.decisions[%d].max_in_flight field, should be a positive number", i)
+		}
+
+		if d.MaxEvaluationSeconds != nil && *d.MaxEvaluationSeconds <= 0 {
+			return fmt.Errorf("invalid value for server.limits.decisions[%d].max_evaluation_seconds field, should be a positive number", i)
+		}
+	}
+
+	if c.Query != nil {
+		if c.Query.MaxResults != nil && *c.Query.MaxResults <= 0 {
+			return fmt.Errorf("invalid value for server.limits.query.max_results field, should be a positive number")
+		}
+		if c.Query.MaxResultBytes != nil && *c.Query.MaxResultBytes <= 0 {
+			return fmt.Errorf("invalid value for server.limits.query.max_result_bytes field, should be a positive number")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package limits
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestConfigValidation(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantErr bool
+	}{
+		{
+			input:   `{}`,
+			wantErr: false,
+		},
+		{
+			input:   `{"decisions": [{"path": "authz", "requests_per_second": 10, "burst": 5, "max_in_flight": 2, "max_evaluation_seconds": 0.5}]}`,
+			wantErr: false,
+		},
+		{
+			input:   `{"decisions": [{"path": "authz"}, {"path": "/authz/"}]}`,
+			wantErr: true,
+		},
+		{
+			input:   `{"decisions": [{"path": "authz", "requests_per_second": 0}]}`,
+			wantErr: true,
+		},
+		{
+			input:   `{"decisions": [{"path": "authz", "requests_per_second": "10"}]}`,
+			wantErr: true,
+		},
+		{
+			input:   `{"decisions": [{"path": "authz", "burst": 5}]}`,
+			wantErr: true,
+		},
+		{
+			input:   `{"decisions": [{"path": "authz", "requests_per_second": 1, "burst": 0}]}`,
+			wantErr: true,
+		},
+		{
+			input:   `{"decisions": [{"path": "authz", "max_in_flight": -1}]}`,
+			wantErr: true,
+		},
+		{
+			input:   `{"decisions": [{"path": "authz", "max_evaluation_seconds": 0}]}`,
+			wantErr: true,
+		},
+		{
+			input:   `{"decisions": [null]}`,
+			wantErr: true,
+		},
+		{
+			input:   `{"query": {"max_results": 100, "max_result_bytes": 1048576}}`,
+			wantErr: false,
+		},
+		{
+			input:   `{"query": {"max_results": 0}}`,
+			wantErr: true,
+		},
+		{
+			input:   `{"query": {"max_result_bytes": -1}}`,
+			wantErr: true,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("TestConfigValidation_case_%d", i), func(t *testing.T) {
+			_, err := NewConfigBuilder().WithBytes([]byte(test.input)).Parse()
+			if err != nil && !test.wantErr {
+				t.Fail()
+			}
+			if err == nil && test.wantErr {
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestConfigValue(t *testing.T) {
+	config, err := NewConfigBuilder().WithBytes([]byte(`{"decisions": [{"path": "/authz/", "requests_per_second": 2.5}]}`)).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := config.Decisions[0]
+	if d.Path != "authz" {
+		t.Fatalf("expected path authz, got %q", d.Path)
+	}
+	if d.Burst == nil || *d.Burst != 3 {
+		t.Fatalf("expected default burst of 3, got %v", d.Burst)
+	}
+	if d.MaxInFlight != nil || d.MaxEvaluationSeconds != nil {
+		t.Fatalf("expected unset limits, got %+v", d)
+	}
+
+	config, err = NewConfigBuilder().Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Decisions) != 0 {
+		t.Fatalf("expected no limits, got %v", config.Decisions)
+	}
+}
@@ -62,6 +62,7 @@ type Plugin struct {
 	metrics                metrics.Metrics
 	logger                 logging.Logger
 	trigger                chan trigger
+	remoteWriter           *remoteWriter
 }
 
 // Config contains configuration for the plugin.
@@ -72,6 +73,14 @@ type Config struct {
 	ConsoleLogs   bool                 `json:"console"`
 	Prometheus    bool                 `json:"prometheus"`
 	Trigger       *plugins.TriggerMode `json:"trigger,omitempty"` // trigger mode
+
+	PrometheusRemoteWrite *RemoteWriteConfig `json:"prometheus_remote_write,omitempty"`
+}
+
+// prometheusEnabled returns true if the status metrics are exposed on the
+// /metrics endpoint or pushed to a remote-write endpoint.
+func (c *Config) prometheusEnabled() bool {
+	return c.Prometheus || c.PrometheusRemoteWrite != nil
 }
 
 type reconfigure struct {
@@ -97,7 +106,7 @@ func (c *Config) validateAndInjectDefaults(services []string, pluginsList []stri
 		if !found {
 			return fmt.Errorf("invalid plugin name %q in status", *c.Plugin)
 		}
-	} else if c.Service == "" && len(services) != 0 && !(c.ConsoleLogs || c.Prometheus) {
+	} else if c.Service == "" && len(services) != 0 && !(c.ConsoleLogs || c.prometheusEnabled()) {
 		// For backwards compatibility allow defaulting to the first
 		// service listed, but only if console logging is disabled. If enabled
 		// we can't tell if the deployer wanted to use only console logs or
@@ -118,6 +127,12 @@ func (c *Config) validateAndInjectDefaults(services []string, pluginsList []stri
 		}
 	}
 
+	if c.PrometheusRemoteWrite != nil {
+		if err := c.PrometheusRemoteWrite.validateAndInjectDefaults(services); err != nil {
+			return err
+		}
+	}
+
 	t, err := plugins.ValidateAndInjectDefaultsForTriggerMode(trigger, c.Trigger)
 	if err != nil {
 		return fmt.Errorf("invalid status config: %w", err)
@@ -182,7 +197,7 @@ func (b *ConfigBuilder) Parse() (*Config, error) {
 		return nil, err
 	}
 
-	if parsedConfig.Plugin == nil && parsedConfig.Service == "" && len(b.services) == 0 && !parsedConfig.ConsoleLogs && !parsedConfig.Prometheus {
+	if parsedConfig.Plugin == nil && parsedConfig.Service == "" && len(b.services) == 0 && !parsedConfig.ConsoleLogs && !parsedConfig.prometheusEnabled() {
 		// Nothing to validate or inject
 		return nil, nil
 	}
@@ -239,13 +254,17 @@ func Lookup(manager *plugins.Manager) *Plugin {
 func (p *Plugin) Start(ctx context.Context) error {
 	p.logger.Info("Starting status reporter.")
 
+	if p.config.PrometheusRemoteWrite != nil {
+		p.remoteWriter = startRemoteWriter(p.manager, *p.config.PrometheusRemoteWrite, p.logger)
+	}
+
 	go p.loop(ctx)
 
 	// Setup a listener for plugin statuses, but only after starting the loop
 	// to prevent blocking threads pushing the plugin updates.
 	p.manager.RegisterPluginStatusListener(Name, p.UpdatePluginStatus)
 
-	if p.config.Prometheus {
+	if p.config.prometheusEnabled() {
 		p.registerAll()
 	}
 
@@ -421,6 +440,9 @@ func (p *Plugin) loop(ctx context.Context) {
 			}
 			close(update.done)
 		case done := <-p.stop:
+			if p.remoteWriter != nil {
+				p.remoteWriter.Stop()
+			}
 			cancel()
 			done <- struct{}{}
 			return
@@ -439,7 +461,7 @@ func (p *Plugin) oneShot(ctx context.Context) error {
 		}
 	}
 
-	if p.config.Prometheus {
+	if p.config.prometheusEnabled() {
 		updatePrometheusMetrics(req)
 	}
 
@@ -479,12 +501,22 @@ func (p *Plugin) reconfigure(config interface{}) {
 
 	p.logger.Info("Status reporter configuration changed.")
 
-	if newConfig.Prometheus && !p.config.Prometheus {
+	if newConfig.prometheusEnabled() && !p.config.prometheusEnabled() {
 		p.registerAll()
-	} else if !newConfig.Prometheus && p.config.Prometheus {
+	} else if !newConfig.prometheusEnabled() && p.config.prometheusEnabled() {
 		p.unregisterAll()
 	}
 
+	if !reflect.DeepEqual(p.config.PrometheusRemoteWrite, newConfig.PrometheusRemoteWrite) {
+		if p.remoteWriter != nil {
+			p.remoteWriter.Stop()
+			p.remoteWriter = nil
+		}
+		if newConfig.PrometheusRemoteWrite != nil {
+			p.remoteWriter = startRemoteWriter(p.manager, *newConfig.PrometheusRemoteWrite, p.logger)
+		}
+	}
+
 	p.config = *newConfig
 }
 
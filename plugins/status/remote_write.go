@@ -0,0 +1,292 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package status
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/snappy"
+	prom "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/open-policy-agent/opa/logging"
+	"github.com/open-policy-agent/opa/plugins"
+	"github.com/open-policy-agent/opa/util"
+)
+
+const (
+	defaultRemoteWriteResource        = "/api/v1/write"
+	defaultRemoteWriteIntervalSeconds = 60
+)
+
+// RemoteWriteConfig contains the configuration for pushing the Prometheus
+// metrics to a remote-write endpoint, in addition to exposing them on the
+// /metrics endpoint.
+type RemoteWriteConfig struct {
+	Service         string            `json:"service"`
+	Resource        string            `json:"resource,omitempty"`
+	IntervalSeconds *int64            `json:"interval_seconds,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"` // added to the labels of the OPA instance
+}
+
+func (c *RemoteWriteConfig) validateAndInjectDefaults(services []string) error {
+	found := false
+	for _, svc := range services {
+		if svc == c.Service {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("invalid service name %q in status.prometheus_remote_write", c.Service)
+	}
+
+	if c.Resource == "" {
+		c.Resource = defaultRemoteWriteResource
+	}
+
+	if c.IntervalSeconds == nil {
+		interval := int64(defaultRemoteWriteIntervalSeconds)
+		c.IntervalSeconds = &interval
+	} else if *c.IntervalSeconds <= 0 {
+		return fmt.Errorf("invalid value for status.prometheus_remote_write.interval_seconds field, should be a positive number")
+	}
+
+	return nil
+}
+
+// remoteWriter periodically pushes the metrics gathered from the Prometheus
+// registry of the manager with the Prometheus remote-write protocol.
+type remoteWriter struct {
+	manager *plugins.Manager
+	config  RemoteWriteConfig
+	logger  logging.Logger
+	stop    chan chan struct{}
+}
+
+func startRemoteWriter(manager *plugins.Manager, config RemoteWriteConfig, logger logging.Logger) *remoteWriter {
+	w := &remoteWriter{
+		manager: manager,
+		config:  config,
+		logger:  logger,
+		stop:    make(chan chan struct{}),
+	}
+	go w.loop()
+	return w
+}
+
+func (w *remoteWriter) Stop() {
+	done := make(chan struct{})
+	w.stop <- done
+	<-done
+}
+
+func (w *remoteWriter) loop() {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ticker := time.NewTicker(time.Duration(*w.config.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := w.push(ctx); err != nil {
+				w.logger.Error("%v.", err)
+			} else {
+				w.logger.Debug("Metrics pushed successfully to remote-write endpoint.")
+			}
+		case done := <-w.stop:
+			cancel()
+			close(done)
+			return
+		}
+	}
+}
+
+func (w *remoteWriter) push(ctx context.Context) error {
+	gatherer, ok := w.manager.PrometheusRegister().(prom.Gatherer)
+	if !ok {
+		return errors.New("remote write failed, metrics cannot be gathered from the prometheus registry")
+	}
+
+	families, err := gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("remote write failed to gather metrics: %w", err)
+	}
+
+	labels := make(map[string]string, len(w.config.Labels))
+	for k, v := range w.manager.Labels() {
+		labels[k] = v
+	}
+	for k, v := range w.config.Labels {
+		labels[k] = v
+	}
+
+	body := encodeWriteRequest(timeSeries(families, labels, time.Now()))
+
+	resp, err := w.manager.Client(w.config.Service).
+		WithHeader("Content-Type", "application/x-protobuf").
+		WithHeader("Content-Encoding", "snappy").
+		WithHeader("X-Prometheus-Remote-Write-Version", "0.1.0").
+		WithBytes(snappy.Encode(nil, body)).
+		Do(ctx, "POST", w.config.Resource)
+	if err != nil {
+		return fmt.Errorf("remote write failed: %w", err)
+	}
+
+	defer util.Close(resp)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("remote write failed, server replied with HTTP %v %v", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+
+	return nil
+}
+
+type label struct {
+	name, value string
+}
+
+type series struct {
+	labels    []label
+	value     float64
+	timestamp int64 // milliseconds
+}
+
+// timeSeries flattens the metric families into samples, the way they are
+// exposed in the text format: histograms and summaries become their
+// _bucket/quantile, _sum and _count series. The external labels are added
+// to every series that does not have them already.
+func timeSeries(families []*dto.MetricFamily, external map[string]string, now time.Time) []series {
+	var result []series
+
+	for _, f := range families {
+		name := f.GetName()
+
+		for _, m := range f.GetMetric() {
+			ts := now.UnixMilli()
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+
+			add := func(name string, value float64, extra ...label) {
+				result = append(result, series{
+					labels:    seriesLabels(name, m.GetLabel(), extra, external),
+					value:     value,
+					timestamp: ts,
+				})
+			}
+
+			switch f.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add(name, m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add(name, m.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				h := m.GetHistogram()
+				for _, b := range h.GetBucket() {
+					add(name+"_bucket", float64(b.GetCumulativeCount()), label{"le", formatFloat(b.GetUpperBound())})
+				}
+				add(name+"_bucket", float64(h.GetSampleCount()), label{"le", "+Inf"})
+				add(name+"_sum", h.GetSampleSum())
+				add(name+"_count", float64(h.GetSampleCount()))
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					add(name, q.GetValue(), label{"quantile", formatFloat(q.GetQuantile())})
+				}
+				add(name+"_sum", s.GetSampleSum())
+				add(name+"_count", float64(s.GetSampleCount()))
+			}
+		}
+	}
+
+	return result
+}
+
+// seriesLabels returns the labels of a series sorted by name, as required by
+// the remote-write protocol.
+func seriesLabels(name string, pairs []*dto.LabelPair, extra []label, external map[string]string) []label {
+	labels := make([]label, 0, 1+len(pairs)+len(extra)+len(external))
+	seen := make(map[string]struct{}, cap(labels))
+
+	labels = append(labels, label{"__name__", name})
+	seen["__name__"] = struct{}{}
+
+	for _, p := range pairs {
+		labels = append(labels, label{p.GetName(), p.GetValue()})
+		seen[p.GetName()] = struct{}{}
+	}
+	for _, l := range extra {
+		labels = append(labels, l)
+		seen[l.name] = struct{}{}
+	}
+	for k, v := range external {
+		if _, ok := seen[k]; !ok {
+			labels = append(labels, label{k, v})
+		}
+	}
+
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].name < labels[j].name
+	})
+
+	return labels
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, +1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// encodeWriteRequest returns the protobuf encoding of the series as a
+// prometheus.WriteRequest message:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(series []series) []byte {
+	var buf, ts, msg []byte
+
+	for _, s := range series {
+		ts = ts[:0]
+
+		for _, l := range s.labels {
+			msg = msg[:0]
+			msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+			msg = protowire.AppendString(msg, l.name)
+			msg = protowire.AppendTag(msg, 2, protowire.BytesType)
+			msg = protowire.AppendString(msg, l.value)
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, msg)
+		}
+
+		msg = msg[:0]
+		msg = protowire.AppendTag(msg, 1, protowire.Fixed64Type)
+		msg = protowire.AppendFixed64(msg, math.Float64bits(s.value))
+		msg = protowire.AppendTag(msg, 2, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, uint64(s.timestamp))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, msg)
+
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, ts)
+	}
+
+	return buf
+}
@@ -0,0 +1,265 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package status
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/open-policy-agent/opa/plugins"
+	inmem "github.com/open-policy-agent/opa/storage/inmem/test"
+)
+
+func TestParseConfigPrometheusRemoteWrite(t *testing.T) {
+	services := []string{"prom"}
+
+	config, err := ParseConfig([]byte(`{"prometheus_remote_write": {"service": "prom"}}`), services, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Service != "" {
+		t.Fatalf("Expected no status service but got %q", config.Service)
+	}
+
+	rw := config.PrometheusRemoteWrite
+	if rw.Resource != defaultRemoteWriteResource || *rw.IntervalSeconds != defaultRemoteWriteIntervalSeconds {
+		t.Fatalf("Expected defaults to be injected but got %+v", rw)
+	}
+
+	for _, tc := range []struct {
+		config string
+		err    string
+	}{
+		{
+			config: `{"prometheus_remote_write": {"service": "missing"}}`,
+			err:    `invalid service name "missing" in status.prometheus_remote_write`,
+		},
+		{
+			config: `{"prometheus_remote_write": {"service": "prom", "interval_seconds": 0}}`,
+			err:    "invalid value for status.prometheus_remote_write.interval_seconds field",
+		},
+	} {
+		_, err := ParseConfig([]byte(tc.config), services, nil)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Fatalf("Expected error %q but got: %v", tc.err, err)
+		}
+	}
+}
+
+func TestRemoteWriterPush(t *testing.T) {
+	type request struct {
+		headers http.Header
+		series  []series
+	}
+
+	ch := make(chan request, 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/push" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		bs, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bs, err = snappy.Decode(nil, bs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := decodeWriteRequest(bs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ch <- request{headers: r.Header, series: s}
+	}))
+	defer ts.Close()
+
+	registry := prometheus.NewRegistry()
+
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_counter"}, []string{"name"})
+	counter.WithLabelValues("foo").Add(3)
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_histogram", Buckets: []float64{1, 10}})
+	histogram.Observe(5)
+	registry.MustRegister(counter, histogram)
+
+	managerConfig := []byte(fmt.Sprintf(`{
+		"labels": {"app": "example-app"},
+		"services": [{"name": "prom", "url": %q}]
+	}`, ts.URL))
+
+	manager, err := plugins.New(managerConfig, "test-instance-id", inmem.New(), plugins.WithPrometheusRegister(registry))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ParseConfig([]byte(`{"prometheus_remote_write": {"service": "prom", "resource": "/push", "labels": {"cluster": "c1", "name": "ignored"}}}`), manager.Services(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := &remoteWriter{manager: manager, config: *config.PrometheusRemoteWrite, logger: manager.Logger()}
+	if err := w.push(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	req := <-ch
+
+	if req.headers.Get("Content-Encoding") != "snappy" || req.headers.Get("Content-Type") != "application/x-protobuf" || req.headers.Get("X-Prometheus-Remote-Write-Version") != "0.1.0" {
+		t.Fatalf("Unexpected headers: %v", req.headers)
+	}
+
+	external := []label{
+		{"app", "example-app"},
+		{"cluster", "c1"},
+		{"id", "test-instance-id"},
+		{"version", manager.Labels()["version"]},
+	}
+
+	// The external labels do not override the labels of the series.
+	withExternal := func(labels ...label) []label {
+		result := append(labels, external...)
+		if len(labels) == 1 || labels[1].name != "name" {
+			result = append(result, label{"name", "ignored"})
+		}
+		sortLabels(result)
+		return result
+	}
+
+	exp := []series{
+		{labels: withExternal(label{"__name__", "test_counter"}, label{"name", "foo"}), value: 3},
+		{labels: withExternal(label{"__name__", "test_histogram_bucket"}, label{"le", "1"}), value: 0},
+		{labels: withExternal(label{"__name__", "test_histogram_bucket"}, label{"le", "10"}), value: 1},
+		{labels: withExternal(label{"__name__", "test_histogram_bucket"}, label{"le", "+Inf"}), value: 1},
+		{labels: withExternal(label{"__name__", "test_histogram_sum"}), value: 5},
+		{labels: withExternal(label{"__name__", "test_histogram_count"}), value: 1},
+	}
+
+	if len(req.series) != len(exp) {
+		t.Fatalf("Expected %d series but got %d: %v", len(exp), len(req.series), req.series)
+	}
+
+	for i := range exp {
+		if req.series[i].timestamp == 0 {
+			t.Fatalf("Expected series %d to have a timestamp", i)
+		}
+		req.series[i].timestamp = 0
+		if !reflect.DeepEqual(req.series[i], exp[i]) {
+			t.Fatalf("Expected series %d to be %v but got %v", i, exp[i], req.series[i])
+		}
+	}
+}
+
+func TestRemoteWriterPushError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	manager, err := plugins.New([]byte(fmt.Sprintf(`{"services": [{"name": "prom", "url": %q}]}`, ts.URL)), "test-instance-id", inmem.New(), plugins.WithPrometheusRegister(prometheus.NewRegistry()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := &remoteWriter{manager: manager, config: RemoteWriteConfig{Service: "prom", Resource: "/"}, logger: manager.Logger()}
+
+	err = w.push(context.Background())
+	if err == nil || err.Error() != "remote write failed, server replied with HTTP 400 Bad Request" {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func sortLabels(labels []label) {
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].name < labels[j].name
+	})
+}
+
+// decodeWriteRequest is the inverse of encodeWriteRequest.
+func decodeWriteRequest(bs []byte) ([]series, error) {
+	var result []series
+
+	err := decodeMessage(bs, func(num protowire.Number, _ protowire.Type, v []byte, _ uint64) error {
+		if num != 1 {
+			return nil
+		}
+		var s series
+		err := decodeMessage(v, func(num protowire.Number, _ protowire.Type, v []byte, _ uint64) error {
+			switch num {
+			case 1:
+				var l label
+				err := decodeMessage(v, func(num protowire.Number, _ protowire.Type, v []byte, _ uint64) error {
+					if num == 1 {
+						l.name = string(v)
+					} else {
+						l.value = string(v)
+					}
+					return nil
+				})
+				s.labels = append(s.labels, l)
+				return err
+			case 2:
+				return decodeMessage(v, func(num protowire.Number, _ protowire.Type, _ []byte, n uint64) error {
+					if num == 1 {
+						s.value = math.Float64frombits(n)
+					} else {
+						s.timestamp = int64(n)
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+		result = append(result, s)
+		return err
+	})
+
+	return result, err
+}
+
+func decodeMessage(bs []byte, f func(protowire.Number, protowire.Type, []byte, uint64) error) error {
+	for len(bs) > 0 {
+		num, typ, n := protowire.ConsumeTag(bs)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		bs = bs[n:]
+
+		var v []byte
+		var x uint64
+		switch typ {
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(bs)
+		case protowire.Fixed64Type:
+			x, n = protowire.ConsumeFixed64(bs)
+		case protowire.VarintType:
+			x, n = protowire.ConsumeVarint(bs)
+		default:
+			return fmt.Errorf("unexpected wire type %v", typ)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		bs = bs[n:]
+
+		if err := f(num, typ, v, x); err != nil {
+			return err
+		}
+	}
+	return nil
+}
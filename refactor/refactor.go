@@ -7,6 +7,7 @@ package refactor
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/open-policy-agent/opa/ast"
 )
@@ -69,48 +70,48 @@ func (mqr *MoveQueryResult) validate() error {
 
 // Move rewrites Rego code by updating package paths and other references in q's modules as per
 // the mapping specified in q.
+//
+// Sources of the mapping are either packages, or the paths of rules. Rules are moved to the
+// package that is the longest prefix of their destination, together with their comments and
+// annotations, and renamed to the remainder of the destination. The comments of the modules are
+// preserved.
 func (r *Refactor) Move(q MoveQuery) (*MoveQueryResult, error) {
 
-	for _, module := range q.Modules {
-		t := ast.NewGenericTransformer(func(x interface{}) (interface{}, error) {
-			if s, ok := x.(ast.Ref); ok {
-				for k, v := range q.SrcDstMapping {
-					other, err := ast.ParseRef(k)
-					if err != nil {
-						return nil, err
-					}
-
-					if s.HasPrefix(other) {
-						newRef, err := ast.ParseRef(v)
-						if err != nil {
-							return nil, err
-						}
-						return newRef.Concat(s[len(other):]), nil
-					}
-
-					// check if a reference in the policy is a prefix of a source reference
-					// example: policy_reference = data.foo
-					//          mapping: {"data.foo.bar": "data.baz"}
-					// In this scenario, we can relocate data.foo.bar but everything under data.foo
-					// (e.g., data.foo.baz, data.foo.qux, etc.) can't be relocated
-					r := s.ConstantPrefix()
-					if len(r) != 0 && other.HasPrefix(r) {
-						msg := fmt.Sprintf("cannot rewrite `%v`: constant prefix `%v` of `%v` is too short", s, r, s)
-						x := Error{Message: msg, Location: s[len(s)-1].Loc()}
-						return nil, x
-					}
-				}
-			}
-			return x, nil
-		})
-		_, err := ast.Transform(t, module)
+	rewrites := make([]ast.PrefixRewrite, 0, len(q.SrcDstMapping))
+	for k, v := range q.SrcDstMapping {
+		from, err := ast.ParseRef(k)
 		if err != nil {
-			switch err.(type) {
-			case Error:
-				return nil, err
-			default:
-				return nil, Error{Message: err.Error()}
-			}
+			return nil, Error{Message: err.Error()}
+		}
+		to, err := ast.ParseRef(v)
+		if err != nil {
+			return nil, Error{Message: err.Error()}
+		}
+		rewrites = append(rewrites, ast.PrefixRewrite{From: from, To: to})
+	}
+	sort.Slice(rewrites, func(i, j int) bool { return rewrites[i].From.Compare(rewrites[j].From) < 0 })
+
+	names := make([]string, 0, len(q.Modules))
+	for name := range q.Modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := checkPrefixes(q.Modules[name], rewrites); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, rw := range rewrites {
+		if err := moveRules(q.Modules, names, rw); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range names {
+		if err := ast.RewriteRefPrefixes(q.Modules[name], rewrites...); err != nil {
+			return nil, Error{Message: err.Error()}
 		}
 	}
 
@@ -123,3 +124,119 @@ func (r *Refactor) Move(q MoveQuery) (*MoveQueryResult, error) {
 	}
 	return result, nil
 }
+
+// checkPrefixes returns an error if the rules of the module contain a ref that cannot be rewritten
+// because it may refer to one of the sources, but not all of the documents it refers to are moved.
+// Refs using imports are checked with the path of the import.
+//
+// example: policy_reference = data.foo
+//
+//	mapping: {"data.foo.bar": "data.baz"}
+//
+// In this scenario, we can relocate data.foo.bar but everything under data.foo
+// (e.g., data.foo.baz, data.foo.qux, etc.) can't be relocated
+func checkPrefixes(module *ast.Module, rewrites []ast.PrefixRewrite) error {
+	var err error
+	imports := ast.ImportedRefs(module)
+	check := func(s ast.Ref) bool {
+		if err != nil {
+			return true
+		}
+		if v, ok := s[0].Value.(ast.Var); ok {
+			if path, ok := imports[v]; ok {
+				s = path.Concat(s[1:])
+			}
+		}
+		for _, rw := range rewrites {
+			if s.HasPrefix(rw.From) {
+				return false
+			}
+		}
+		prefix := s.ConstantPrefix()
+		for _, rw := range rewrites {
+			if len(prefix) != 0 && rw.From.HasPrefix(prefix) {
+				msg := fmt.Sprintf("cannot rewrite `%v`: constant prefix `%v` of `%v` is too short", s, prefix, s)
+				err = Error{Message: msg, Location: s[len(s)-1].Loc()}
+				return true
+			}
+		}
+		return false
+	}
+	for _, rule := range module.Rules {
+		ast.WalkRefs(rule, check)
+	}
+	return err
+}
+
+// moveRules moves the rules with the path of the rewrite's source to the package of its
+// destination. Nothing is moved if the source is a package.
+func moveRules(modules map[string]*ast.Module, names []string, rw ast.PrefixRewrite) error {
+	var rules []*ast.Rule
+	var pkg []*ast.Module
+	var dst *ast.Module
+
+	for _, name := range names {
+		m := modules[name]
+		if m.Package.Path.HasPrefix(rw.From) {
+			return nil
+		}
+		if rw.From.HasPrefix(m.Package.Path) {
+			for _, r := range m.Rules {
+				if r.Path().Equal(rw.From) {
+					rules = append(rules, r)
+				}
+			}
+		}
+		if len(m.Package.Path) < len(rw.To) && rw.To.HasPrefix(m.Package.Path) &&
+			(dst == nil || len(m.Package.Path) > len(dst.Package.Path)) {
+			dst = m
+		}
+	}
+
+	if len(rules) == 0 {
+		return nil
+	}
+	if dst == nil {
+		return Error{Message: fmt.Sprintf("cannot move `%v` to `%v`: no package is a prefix of `%v`", rw.From, rw.To, rw.To), Location: rules[0].Location}
+	}
+
+	head := rw.To[len(dst.Package.Path):].Copy()
+	name, ok := head[0].Value.(ast.String)
+	if !ok || !ast.IsVarCompatibleString(string(name)) {
+		return Error{Message: fmt.Sprintf("cannot move `%v` to `%v`: invalid rule name `%v`", rw.From, rw.To, head[0]), Location: rules[0].Location}
+	}
+	head[0] = ast.VarTerm(string(name)).SetLocation(head[0].Location)
+
+	for _, name := range names {
+		if m := modules[name]; m.Package.Path.Equal(rules[0].Module.Package.Path) {
+			pkg = append(pkg, m)
+		}
+	}
+
+	for _, rule := range rules {
+		if rule.Module != dst {
+			if err := ast.MoveRule(pkg, dst, rule); err != nil {
+				return Error{Message: err.Error(), Location: rule.Location}
+			}
+		}
+		for r := rule; r != nil; r = r.Else {
+			renameRule(r, head)
+		}
+	}
+	return nil
+}
+
+// renameRule replaces the ground prefix of the rule's head ref with head.
+func renameRule(rule *ast.Rule, head ast.Ref) {
+	ref := rule.Head.Ref()
+	loc := ref[0].Location
+	n := len(ref.GroundPrefix())
+	newRef := head.Copy().Concat(ref[n:])
+	for i := range head {
+		newRef[i].Location = loc
+	}
+	rule.Head.SetRef(newRef)
+	if rule.Head.Name != "" || len(newRef) == 1 {
+		rule.Head.Name = newRef[0].Value.(ast.Var)
+	}
+}
@@ -380,3 +380,99 @@ allow {
 		t.Fatal("Expected error but got nil")
 	}
 }
+
+func TestMoveRule(t *testing.T) {
+	module1 := ast.MustParseModule(`package lib.foo
+
+import input.user
+
+default allow = false
+
+allow {
+	is_admin
+	user.active
+}
+
+is_admin { user.role == "admin" }
+
+deny { not allow }`)
+
+	module2 := ast.MustParseModule(`package app
+
+import data.lib.foo
+
+p { foo.allow }`)
+
+	modules := map[string]*ast.Module{
+		"policy1.rego": module1,
+		"policy2.rego": module2,
+	}
+
+	mappings := map[string]string{
+		"data.lib.foo.allow": "data.app.allowed",
+	}
+
+	result, err := New().Move(MoveQuery{
+		Modules:       modules,
+		SrcDstMapping: mappings,
+	}.WithValidation(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected1 := ast.MustParseModule(`package lib.foo
+
+import input.user
+
+is_admin { user.role == "admin" }
+
+deny { not data.app.allowed }`)
+
+	expected2 := ast.MustParseModule(`package app
+
+import data.lib.foo
+
+p { data.app.allowed }
+
+default allowed = false
+
+allowed {
+	data.lib.foo.is_admin
+	input.user.active
+}`)
+
+	if actual := result.Result["policy1.rego"]; !expected1.Equal(actual) {
+		t.Fatalf("Expected module:\n%v\n\nGot:\n%v\n", expected1, actual)
+	}
+
+	if actual := result.Result["policy2.rego"]; !expected2.Equal(actual) {
+		t.Fatalf("Expected module:\n%v\n\nGot:\n%v\n", expected2, actual)
+	}
+}
+
+func TestMoveRuleNoDestinationPackage(t *testing.T) {
+	module := ast.MustParseModule(`package lib.foo
+
+allow = true`)
+
+	modules := map[string]*ast.Module{
+		"policy.rego": module,
+	}
+
+	mappings := map[string]string{
+		"data.lib.foo.allow": "data.missing.allow",
+	}
+
+	_, err := New().Move(MoveQuery{
+		Modules:       modules,
+		SrcDstMapping: mappings,
+	})
+	if err == nil {
+		t.Fatal("Expected error but got nil")
+	}
+
+	errMsg := "cannot move `data.lib.foo.allow` to `data.missing.allow`: no package is a prefix of `data.missing.allow`"
+	if !strings.Contains(err.Error(), errMsg) {
+		t.Fatalf("Expected error message %v but got %v", errMsg, err.Error())
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package rego
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/metrics"
+	"github.com/open-policy-agent/opa/storage"
+)
+
+// CompileCache caches compilers by the fingerprint of the modules they
+// compiled and of the options they were created with. Cached compilers are
+// shared by the Rego objects that use them, and must not be modified.
+type CompileCache interface {
+	Get(fingerprint string) (*ast.Compiler, bool)
+	Put(fingerprint string, c *ast.Compiler)
+}
+
+// NewCompileCache returns a CompileCache that holds at most maxEntries
+// compilers, evicting the oldest ones first. If maxEntries is not positive,
+// the number of compilers is unlimited.
+func NewCompileCache(maxEntries int) CompileCache {
+	return &compileCache{
+		maxEntries: maxEntries,
+		compilers:  map[string]*ast.Compiler{},
+	}
+}
+
+type compileCache struct {
+	mtx        sync.Mutex
+	maxEntries int
+	compilers  map[string]*ast.Compiler
+	keys       []string
+}
+
+func (c *compileCache) Get(fingerprint string) (*ast.Compiler, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	compiler, ok := c.compilers[fingerprint]
+	return compiler, ok
+}
+
+func (c *compileCache) Put(fingerprint string, compiler *ast.Compiler) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if _, ok := c.compilers[fingerprint]; !ok {
+		c.keys = append(c.keys, fingerprint)
+	}
+	c.compilers[fingerprint] = compiler
+	for c.maxEntries > 0 && len(c.keys) > c.maxEntries {
+		delete(c.compilers, c.keys[0])
+		c.keys = c.keys[1:]
+	}
+}
+
+// WithCompileCache sets the cache of compilers used by the Rego object. When
+// the cache holds a compiler for the same modules and compiler options, the
+// modules are neither parsed nor compiled again. The cache is only used for
+// modules given with the Module option, or stored in the store, when no
+// Compiler, ParsedModule, Load, LoadBundle, ParsedBundle or Schemas option is
+// given; and it is not used by PartialResult, which modifies the compiler.
+func WithCompileCache(c CompileCache) func(r *Rego) {
+	return func(r *Rego) {
+		r.compileCache = c
+	}
+}
+
+// useCompileCache reports whether the compiler of r can be obtained from its
+// compile cache when preparing queries of type qType.
+func (r *Rego) useCompileCache(qType queryType) bool {
+	return r.compileCache != nil &&
+		r.ownCompiler &&
+		qType != partialResultQueryType &&
+		len(r.modules) > 0 &&
+		len(r.parsedModules) == 0 &&
+		len(r.bundles) == 0 &&
+		len(r.loadPaths.paths) == 0 &&
+		r.schemaSet == nil
+}
+
+// compileFromCache replaces the compiler of r with the compiler cached for
+// the fingerprint of its modules, and returns the fingerprint to cache the
+// compiler of r with if there is none.
+func (r *Rego) compileFromCache(ctx context.Context, txn storage.Transaction, m metrics.Metrics) (string, bool, error) {
+	fingerprint, err := r.compileFingerprint(ctx, txn)
+	if err != nil {
+		return "", false, err
+	}
+	compiler, ok := r.compileCache.Get(fingerprint)
+	if !ok {
+		m.Counter(metrics.RegoCompileCacheMiss).Incr()
+		return fingerprint, false, nil
+	}
+	m.Counter(metrics.RegoCompileCacheHit).Incr()
+
+	r.compiler = compiler
+	for id, module := range compiler.Modules {
+		r.parsedModules[id] = module
+	}
+	return fingerprint, true, nil
+}
+
+// compileFingerprint hashes the modules of r and of its store, and the options
+// of its compiler.
+func (r *Rego) compileFingerprint(ctx context.Context, txn storage.Transaction) (string, error) {
+	h := sha256.New()
+
+	unsafeBuiltins := make([]string, 0, len(r.unsafeBuiltins))
+	for name := range r.unsafeBuiltins {
+		unsafeBuiltins = append(unsafeBuiltins, name)
+	}
+	sort.Strings(unsafeBuiltins)
+	builtinDecls := make([]string, 0, len(r.builtinDecls))
+	for name, decl := range r.builtinDecls {
+		builtinDecls = append(builtinDecls, fmt.Sprintf("%s:%v", name, decl.Decl))
+	}
+	sort.Strings(builtinDecls)
+	capabilities, err := json.Marshal(r.capabilities)
+	if err != nil {
+		return "", err
+	}
+	opts, err := json.Marshal([]interface{}{
+		r.regoVersion,
+		r.target,
+		r.strict,
+		r.enablePrintStatements,
+		unsafeBuiltins,
+		builtinDecls,
+		json.RawMessage(capabilities),
+	})
+	if err != nil {
+		return "", err
+	}
+	writeFingerprintField(h, string(opts))
+
+	ids, err := r.store.ListPolicies(ctx, txn)
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(ids)
+	writeFingerprintField(h, fmt.Sprint(len(ids)))
+	for _, id := range ids {
+		bs, err := r.store.GetPolicy(ctx, txn, id)
+		if err != nil {
+			return "", err
+		}
+		writeFingerprintField(h, id)
+		writeFingerprintField(h, string(bs))
+	}
+
+	for _, module := range r.modules {
+		writeFingerprintField(h, module.filename)
+		writeFingerprintField(h, module.module)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeFingerprintField writes s prefixed with its length, so that different
+// sequences of fields do not hash the same.
+func writeFingerprintField(w io.Writer, s string) {
+	fmt.Fprintf(w, "%d:%s", len(s), s)
+}
@@ -5,6 +5,7 @@
 package rego_test
 
 import (
+	"context"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -37,3 +38,63 @@ func TestPrepareOption(t *testing.T) {
 		}
 	})
 }
+
+func TestPrepareWithBuiltinOverrides(t *testing.T) {
+	ctx := context.Background()
+
+	override := func(s string) topdown.BuiltinFunc {
+		return func(_ topdown.BuiltinContext, _ []*ast.Term, iter func(*ast.Term) error) error {
+			return iter(ast.StringTerm(s))
+		}
+	}
+
+	r := rego.New(
+		rego.Query(`x := [upper("a"), custom("b")]`),
+		rego.Function1(&rego.Function{
+			Name: "custom",
+			Decl: types.NewFunction(types.Args(types.S), types.S),
+		}, func(_ rego.BuiltinContext, a *ast.Term) (*ast.Term, error) {
+			return a, nil
+		}),
+	)
+
+	pq1, err := r.PrepareForEval(ctx, rego.WithBuiltinOverrides(map[string]topdown.BuiltinFunc{
+		"upper":  override("tenant1-upper"),
+		"custom": override("tenant1-custom"),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pq2, err := r.PrepareForEval(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		pq  rego.PreparedEvalQuery
+		exp string
+	}{
+		{pq: pq1, exp: `["tenant1-upper", "tenant1-custom"]`},
+		{pq: pq2, exp: `["A", "b"]`},
+	} {
+		rs, err := tc.pq.Eval(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		act, err := ast.InterfaceToValue(rs[0].Bindings["x"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exp := ast.MustParseTerm(tc.exp).Value; act.Compare(exp) != 0 {
+			t.Errorf("expected %v but got %v", exp, act)
+		}
+	}
+
+	_, err = r.PrepareForEval(ctx, rego.WithBuiltinOverrides(map[string]topdown.BuiltinFunc{
+		"undefined": override("x"),
+	}))
+	if err == nil || err.Error() != "cannot override undefined built-in function undefined" {
+		t.Fatalf("expected error but got %v", err)
+	}
+}
@@ -60,6 +60,10 @@ type CompileResult struct {
 type PartialQueries struct {
 	Queries []ast.Body    `json:"queries,omitempty"`
 	Support []*ast.Module `json:"modules,omitempty"`
+
+	// Provenance identifies the rules in the original policy that the support
+	// rules were derived from. Synthetic support rules are not included.
+	Provenance []topdown.SupportProvenance `json:"provenance,omitempty"`
 }
 
 // PartialResult represents the result of partial evaluation. The result can be
@@ -70,12 +74,20 @@ type PartialResult struct {
 	body         ast.Body
 	builtinDecls map[string]*ast.Builtin
 	builtinFuncs map[string]*topdown.Builtin
+	provenance   []topdown.SupportProvenance
+}
+
+// Provenance returns the rules in the original policy that the support rules
+// of the partial evaluation were derived from.
+func (pr PartialResult) Provenance() []topdown.SupportProvenance {
+	return pr.provenance
 }
 
 // Rego returns an object that can be evaluated to produce a query result.
 func (pr PartialResult) Rego(options ...func(*Rego)) *Rego {
 	options = append(options, Compiler(pr.compiler), Store(pr.store), ParsedQuery(pr.body))
 	r := New(options...)
+	r.supportProvenance = pr.provenance
 
 	// Propagate any custom builtins.
 	for k, v := range pr.builtinDecls {
@@ -117,13 +129,26 @@ type EvalContext struct {
 	indexing               bool
 	earlyExit              bool
 	interQueryBuiltinCache cache.InterQueryCache
+	interQueryRuleCache    cache.InterQueryRuleCache
+	interQueryBaseCache    cache.InterQueryBaseCache
+	httpTransportPool      *topdown.HTTPTransportPool
 	ndBuiltinCache         builtins.NDBCache
+	ruleProfiler           *topdown.RuleProfiler
+	parallelism            int
+	memoryPooling          bool
+	maxEvalDepth           int
+	maxResultCount         int
+	maxResultBytes         int64
+	bodyReordering         bool
 	resolvers              []refResolver
 	sortSets               bool
 	copyMaps               bool
 	printHook              print.Hook
 	capabilities           *ast.Capabilities
 	strictBuiltinErrors    bool
+	strictBuiltins         []string
+	builtinErrorList       *[]topdown.Error
+	builtinOverrides       map[string]topdown.BuiltinFunc
 }
 
 func (e *EvalContext) RawInput() *interface{} {
@@ -158,6 +183,10 @@ func (e *EvalContext) StrictBuiltinErrors() bool {
 	return e.strictBuiltinErrors
 }
 
+func (e *EvalContext) StrictBuiltinErrorsFor() []string {
+	return e.strictBuiltins
+}
+
 func (e *EvalContext) NDBCache() builtins.NDBCache {
 	return e.ndBuiltinCache
 }
@@ -185,7 +214,9 @@ func EvalInput(input interface{}) EvalOption {
 	}
 }
 
-// EvalParsedInput configures the input for a Prepared Query's evaluation
+// EvalParsedInput configures the input for a Prepared Query's evaluation.
+// Large inputs decoded from JSON can be passed as ast.LazyObject, so that
+// only the parts read during evaluation are converted to AST values.
 func EvalParsedInput(input ast.Value) EvalOption {
 	return func(e *EvalContext) {
 		e.parsedInput = input
@@ -233,6 +264,63 @@ func EvalQueryTracer(tracer topdown.QueryTracer) EvalOption {
 	}
 }
 
+// EvalRuleProfiler sets the profiler that records per-rule evaluation
+// statistics during evaluation.
+func EvalRuleProfiler(p *topdown.RuleProfiler) EvalOption {
+	return func(e *EvalContext) {
+		e.ruleProfiler = p
+	}
+}
+
+// EvalParallelism sets the maximum number of goroutines used to evaluate the
+// rules that contribute to the same partial set or object (see
+// topdown.Query.WithParallelism.)
+func EvalParallelism(n int) EvalOption {
+	return func(e *EvalContext) {
+		e.parallelism = n
+	}
+}
+
+// EvalMemoryPooling makes evaluation allocate its variable bindings from a
+// pool (see topdown.Query.WithMemoryPooling.)
+func EvalMemoryPooling(yes bool) EvalOption {
+	return func(e *EvalContext) {
+		e.memoryPooling = yes
+	}
+}
+
+// EvalMaxDepth sets the maximum evaluation depth, beyond which evaluation
+// stops with an error (see topdown.Query.WithMaxEvalDepth.)
+func EvalMaxDepth(n int) EvalOption {
+	return func(e *EvalContext) {
+		e.maxEvalDepth = n
+	}
+}
+
+// EvalMaxResultCount sets the maximum number of results of the query (see
+// topdown.Query.WithMaxResultCount.)
+func EvalMaxResultCount(n int) EvalOption {
+	return func(e *EvalContext) {
+		e.maxResultCount = n
+	}
+}
+
+// EvalMaxResultBytes sets the maximum size of the results of the query (see
+// topdown.Query.WithMaxResultBytes.)
+func EvalMaxResultBytes(n int64) EvalOption {
+	return func(e *EvalContext) {
+		e.maxResultBytes = n
+	}
+}
+
+// EvalBodyReordering makes evaluation reorder the expressions of rule bodies
+// by their estimated cost (see topdown.Query.WithBodyReordering.)
+func EvalBodyReordering(yes bool) EvalOption {
+	return func(e *EvalContext) {
+		e.bodyReordering = yes
+	}
+}
+
 // EvalPartialNamespace returns an argument that sets the namespace to use for
 // partial evaluation results. The namespace must be a valid package path
 // component.
@@ -306,6 +394,30 @@ func EvalInterQueryBuiltinCache(c cache.InterQueryCache) EvalOption {
 	}
 }
 
+// EvalInterQueryRuleCache sets the inter-query cache that holds the values of
+// complete virtual documents during evaluation.
+func EvalInterQueryRuleCache(c cache.InterQueryRuleCache) EvalOption {
+	return func(e *EvalContext) {
+		e.interQueryRuleCache = c
+	}
+}
+
+// EvalInterQueryBaseCache sets the inter-query cache that holds base documents
+// read out of storage during evaluation.
+func EvalInterQueryBaseCache(c cache.InterQueryBaseCache) EvalOption {
+	return func(e *EvalContext) {
+		e.interQueryBaseCache = c
+	}
+}
+
+// EvalHTTPTransportPool sets the pool of transports that the http.send built-in
+// function uses to reuse connections across calls and queries.
+func EvalHTTPTransportPool(p *topdown.HTTPTransportPool) EvalOption {
+	return func(e *EvalContext) {
+		e.httpTransportPool = p
+	}
+}
+
 // EvalNDBuiltinCache sets the non-deterministic builtin cache that built-in functions can
 // use during evaluation.
 func EvalNDBuiltinCache(c builtins.NDBCache) EvalOption {
@@ -342,6 +454,16 @@ func EvalPrintHook(ph print.Hook) EvalOption {
 	}
 }
 
+// EvalBuiltinErrorList supplies an error slice to store the built-in function
+// errors encountered during this evaluation. Unless strict built-in errors are
+// enabled, these errors do not halt evaluation; collecting them lets callers
+// report them as warnings alongside the ResultSet.
+func EvalBuiltinErrorList(list *[]topdown.Error) EvalOption {
+	return func(e *EvalContext) {
+		e.builtinErrorList = list
+	}
+}
+
 func (pq preparedQuery) Modules() map[string]*ast.Module {
 	mods := make(map[string]*ast.Module)
 
@@ -382,6 +504,12 @@ func (pq preparedQuery) newEvalContext(ctx context.Context, options []EvalOption
 		printHook:           pq.r.printHook,
 		capabilities:        pq.r.capabilities,
 		strictBuiltinErrors: pq.r.strictBuiltinErrors,
+		strictBuiltins:      pq.r.strictBuiltins,
+		builtinErrorList:    pq.r.builtinErrorList,
+	}
+
+	if pq.cfg != nil {
+		ectx.builtinOverrides = pq.cfg.builtinOverrides
 	}
 
 	for _, o := range options {
@@ -464,6 +592,75 @@ func (pq PreparedEvalQuery) Eval(ctx context.Context, options ...EvalOption) (Re
 	return pq.r.eval(ctx, ectx)
 }
 
+// Iter evaluates this PreparedEvalQuery's Rego object with additional eval
+// options and invokes iter with each Result as soon as it is produced. Unlike
+// Eval, results are not accumulated into a ResultSet, which keeps memory usage
+// bounded for queries that enumerate large numbers of results. If iter returns
+// an error, evaluation is halted and the error is returned to the caller.
+// If options are provided they will override the original Rego options respective value.
+func (pq PreparedEvalQuery) Iter(ctx context.Context, iter func(Result) error, options ...EvalOption) error {
+	ectx, finish, err := pq.newEvalContext(ctx, options)
+	if err != nil {
+		return err
+	}
+	defer finish(ctx)
+
+	ectx.compiledQuery = pq.r.compiledQueries[evalQueryType]
+
+	return pq.r.iter(ctx, ectx, iter)
+}
+
+// PreparedEvalQueryOption defines a function to set an option on a
+// PreparedEvalQuery derived with PreparedEvalQuery.With.
+type PreparedEvalQueryOption func(*Rego)
+
+// PreparedStore binds the derived query to the store s. The policies of s are
+// not compiled: the store only provides the base documents for evaluations.
+func PreparedStore(s storage.Store) PreparedEvalQueryOption {
+	return func(r *Rego) {
+		r.store = s
+	}
+}
+
+// PreparedInput sets the default input document of the derived query. Input
+// should be a native Go value representing the input document.
+func PreparedInput(x interface{}) PreparedEvalQueryOption {
+	return func(r *Rego) {
+		r.rawInput = &x
+		r.parsedInput = nil
+	}
+}
+
+// PreparedParsedInput sets the default input document of the derived query.
+func PreparedParsedInput(x ast.Value) PreparedEvalQueryOption {
+	return func(r *Rego) {
+		r.rawInput = nil
+		r.parsedInput = x
+	}
+}
+
+// With returns a PreparedEvalQuery that shares the compiled state of pq, but
+// is bound to the store and default input document set by options. Deriving
+// queries is cheap, so the same policies can be evaluated against many
+// isolated data snapshots without preparing them again. The input set with
+// EvalInput and EvalParsedInput still takes precedence over the default input
+// document.
+//
+// The store of queries prepared for the wasm target, or for target plugins,
+// cannot be changed, as they load the data of the store while preparing.
+func (pq PreparedEvalQuery) With(options ...PreparedEvalQueryOption) (PreparedEvalQuery, error) {
+	r := *pq.r
+	for _, o := range options {
+		o(&r)
+	}
+
+	if r.store != pq.r.store && (r.target == targetWasm || r.targetPlugin(r.target) != nil) {
+		return PreparedEvalQuery{}, fmt.Errorf("cannot change the store of queries prepared for the %v target", r.target)
+	}
+
+	return PreparedEvalQuery{preparedQuery{&r, pq.cfg}}, nil
+}
+
 // PreparedPartialQuery holds the prepared Rego state that has been pre-processed
 // for partial evaluations.
 type PreparedPartialQuery struct {
@@ -551,15 +748,19 @@ type Rego struct {
 	disableInlining        []string
 	shallowInlining        bool
 	skipPartialNamespace   bool
+	partialComprehensions  bool
 	partialNamespace       string
 	modules                []rawModule
 	parsedModules          map[string]*ast.Module
 	compiler               *ast.Compiler
 	store                  storage.Store
 	ownStore               bool
+	ownCompiler            bool
+	compileCache           CompileCache
 	txn                    storage.Transaction
 	metrics                metrics.Metrics
 	queryTracers           []topdown.QueryTracer
+	ruleProfiler           *topdown.RuleProfiler
 	tracebuf               *topdown.BufferTracer
 	trace                  bool
 	instrumentation        *topdown.Instrumentation
@@ -574,13 +775,19 @@ type Rego struct {
 	builtinDecls           map[string]*ast.Builtin
 	builtinFuncs           map[string]*topdown.Builtin
 	unsafeBuiltins         map[string]struct{}
+	supportProvenance      []topdown.SupportProvenance // set on Rego objects created from partial results
 	loadPaths              loadPaths
 	bundlePaths            []string
 	bundles                map[string]*bundle.Bundle
 	skipBundleVerification bool
 	interQueryBuiltinCache cache.InterQueryCache
+	interQueryRuleCache    cache.InterQueryRuleCache
+	interQueryBaseCache    cache.InterQueryBaseCache
+	httpTransportPool      *topdown.HTTPTransportPool
 	ndBuiltinCache         builtins.NDBCache
 	strictBuiltinErrors    bool
+	enforceCapabilities    bool
+	strictBuiltins         []string
 	builtinErrorList       *[]topdown.Error
 	resolvers              []refResolver
 	schemaSet              *ast.SchemaSet
@@ -590,7 +797,10 @@ type Rego struct {
 	printHook              print.Hook
 	enablePrintStatements  bool
 	distributedTacingOpts  tracing.Options
+	builtinSpanThreshold   time.Duration
 	strict                 bool
+	maxResultCount         int
+	maxResultBytes         int64
 	pluginMgr              *plugins.Manager
 	plugins                []TargetPlugin
 	targetPrepState        TargetPluginEval
@@ -625,6 +835,13 @@ type (
 
 	// BuiltinDyn defines a built-in function  that accepts a list of arguments.
 	BuiltinDyn func(bctx BuiltinContext, terms []*ast.Term) (*ast.Term, error)
+
+	// BuiltinStream defines a built-in function that accepts a list of
+	// arguments and produces zero or more results. The implementation calls
+	// yield once per result; evaluation of the enclosing expression continues
+	// for each result before yield returns. If yield returns an error, the
+	// implementation must stop producing results and return it.
+	BuiltinStream func(bctx BuiltinContext, terms []*ast.Term, yield func(*ast.Term) error) error
 )
 
 // RegisterBuiltin1 adds a built-in function globally inside the OPA runtime.
@@ -697,6 +914,19 @@ func RegisterBuiltinDyn(decl *Function, impl BuiltinDyn) {
 	})
 }
 
+// RegisterBuiltinStream adds a built-in function that produces a stream of
+// results globally inside the OPA runtime. Memoization is not supported for
+// streaming built-in functions.
+func RegisterBuiltinStream(decl *Function, impl BuiltinStream) {
+	ast.RegisterBuiltin(&ast.Builtin{
+		Name:             decl.Name,
+		Description:      decl.Description,
+		Decl:             decl.Decl,
+		Nondeterministic: decl.Nondeterministic,
+	})
+	topdown.RegisterBuiltinFunc(decl.Name, streamFunction(decl, impl))
+}
+
 // Function1 returns an option that adds a built-in function to the Rego object.
 func Function1(decl *Function, f Builtin1) func(*Rego) {
 	return newFunction(decl, func(bctx BuiltinContext, terms []*ast.Term, iter func(*ast.Term) error) error {
@@ -737,6 +967,15 @@ func FunctionDyn(decl *Function, f BuiltinDyn) func(*Rego) {
 	})
 }
 
+// FunctionStream returns an option that adds a built-in function that produces
+// a stream of results to the Rego object. Results are passed to the rest of
+// the query as they are produced, e.g., a function that fetches pages from a
+// remote API can yield the items on each page without materializing all of
+// them first. Memoization is not supported for streaming built-in functions.
+func FunctionStream(decl *Function, f BuiltinStream) func(*Rego) {
+	return newFunction(decl, streamFunction(decl, f))
+}
+
 // FunctionDecl returns an option that adds a custom-built-in function
 // __declaration__. NO implementation is provided. This is used for
 // non-interpreter execution envs (e.g., Wasm).
@@ -894,6 +1133,15 @@ func ShallowInlining(yes bool) func(r *Rego) {
 	}
 }
 
+// EvalComprehensionsDuringPartial enables evaluation of comprehensions that do
+// not depend on unknowns during partial evaluation. The values of those
+// comprehensions are inlined into the partial evaluation results.
+func EvalComprehensionsDuringPartial(yes bool) func(r *Rego) {
+	return func(r *Rego) {
+		r.partialComprehensions = yes
+	}
+}
+
 // SkipPartialNamespace disables namespacing of partial evalution results for support
 // rules generated from policy. Synthetic support rules are still namespaced.
 func SkipPartialNamespace(yes bool) func(r *Rego) {
@@ -1037,6 +1285,14 @@ func QueryTracer(t topdown.QueryTracer) func(r *Rego) {
 	}
 }
 
+// RuleProfiler returns an argument that sets the profiler that records
+// per-rule evaluation statistics when the query is evaluated with Eval.
+func RuleProfiler(p *topdown.RuleProfiler) func(r *Rego) {
+	return func(r *Rego) {
+		r.ruleProfiler = p
+	}
+}
+
 // Runtime returns an argument that sets the runtime data to provide to the
 // evaluation engine.
 func Runtime(term *ast.Term) func(r *Rego) {
@@ -1105,6 +1361,32 @@ func InterQueryBuiltinCache(c cache.InterQueryCache) func(r *Rego) {
 	}
 }
 
+// InterQueryRuleCache sets the inter-query cache that holds the values of
+// complete virtual documents during evaluation. The cache must be invalidated
+// whenever the data in the store changes.
+func InterQueryRuleCache(c cache.InterQueryRuleCache) func(r *Rego) {
+	return func(r *Rego) {
+		r.interQueryRuleCache = c
+	}
+}
+
+// InterQueryBaseCache sets the inter-query cache that holds base documents read
+// out of storage during evaluation. The cache must be invalidated whenever the
+// data in the store changes.
+func InterQueryBaseCache(c cache.InterQueryBaseCache) func(r *Rego) {
+	return func(r *Rego) {
+		r.interQueryBaseCache = c
+	}
+}
+
+// HTTPTransportPool sets the pool of transports that the http.send built-in
+// function uses to reuse connections across calls and queries.
+func HTTPTransportPool(p *topdown.HTTPTransportPool) func(r *Rego) {
+	return func(r *Rego) {
+		r.httpTransportPool = p
+	}
+}
+
 // NDBuiltinCache sets the non-deterministic builtins cache.
 func NDBuiltinCache(c builtins.NDBCache) func(r *Rego) {
 	return func(r *Rego) {
@@ -1119,6 +1401,44 @@ func StrictBuiltinErrors(yes bool) func(r *Rego) {
 	}
 }
 
+// EnforceCapabilities tells the evaluator to check the capabilities before
+// calling built-in functions, including the built-in functions called through
+// 'with' statements, so that built-in functions registered after the policies
+// were compiled cannot bypass them. Calls to built-in functions that the
+// capabilities do not allow halt evaluation with a topdown.CapabilityErr error.
+func EnforceCapabilities(yes bool) func(r *Rego) {
+	return func(r *Rego) {
+		r.enforceCapabilities = yes
+	}
+}
+
+// StrictBuiltinErrorsFor tells the evaluator to treat errors from the named
+// built-in functions as fatal errors. Evaluation halts on the first such error.
+// Errors from other built-in functions are handled according to
+// StrictBuiltinErrors.
+func StrictBuiltinErrorsFor(names []string) func(r *Rego) {
+	return func(r *Rego) {
+		r.strictBuiltins = names
+	}
+}
+
+// MaxResultCount sets the maximum number of results of the query. Evaluation
+// fails when the query produces more results.
+func MaxResultCount(n int) func(r *Rego) {
+	return func(r *Rego) {
+		r.maxResultCount = n
+	}
+}
+
+// MaxResultBytes sets the maximum size of the results of the query, as the
+// approximate length of their JSON encoding. Evaluation fails when the results
+// exceed it.
+func MaxResultBytes(n int64) func(r *Rego) {
+	return func(r *Rego) {
+		r.maxResultBytes = n
+	}
+}
+
 // BuiltinErrorList supplies an error slice to store built-in function errors.
 func BuiltinErrorList(list *[]topdown.Error) func(r *Rego) {
 	return func(r *Rego) {
@@ -1177,6 +1497,14 @@ func DistributedTracingOpts(tr tracing.Options) func(r *Rego) {
 	}
 }
 
+// BuiltinSpanThreshold sets the minimum duration of the built-in function calls
+// that are recorded as child spans of the span in the evaluation context.
+func BuiltinSpanThreshold(d time.Duration) func(r *Rego) {
+	return func(r *Rego) {
+		r.builtinSpanThreshold = d
+	}
+}
+
 // EnablePrintStatements enables print() calls. If this option is not provided,
 // print() calls will be erased from the policy. This option only applies to
 // queries and policies that passed as raw strings, i.e., this function will not
@@ -1217,6 +1545,7 @@ func New(options ...func(r *Rego)) *Rego {
 	}
 
 	if r.compiler == nil {
+		r.ownCompiler = true
 		r.compiler = ast.NewCompiler().
 			WithUnsafeBuiltins(r.unsafeBuiltins).
 			WithBuiltins(r.builtinDecls).
@@ -1300,7 +1629,12 @@ func (r *Rego) Eval(ctx context.Context) (ResultSet, error) {
 		EvalInstrument(r.instrument),
 		EvalTime(r.time),
 		EvalInterQueryBuiltinCache(r.interQueryBuiltinCache),
+		EvalInterQueryRuleCache(r.interQueryRuleCache),
+		EvalInterQueryBaseCache(r.interQueryBaseCache),
+		EvalHTTPTransportPool(r.httpTransportPool),
 		EvalSeed(r.seed),
+		EvalMaxResultCount(r.maxResultCount),
+		EvalMaxResultBytes(r.maxResultBytes),
 	}
 
 	if r.ndBuiltinCache != nil {
@@ -1311,6 +1645,10 @@ func (r *Rego) Eval(ctx context.Context) (ResultSet, error) {
 		evalArgs = append(evalArgs, EvalQueryTracer(qt))
 	}
 
+	if r.ruleProfiler != nil {
+		evalArgs = append(evalArgs, EvalRuleProfiler(r.ruleProfiler))
+	}
+
 	for i := range r.resolvers {
 		evalArgs = append(evalArgs, EvalResolver(r.resolvers[i].ref, r.resolvers[i].r))
 	}
@@ -1352,6 +1690,7 @@ func (r *Rego) PartialResult(ctx context.Context) (PartialResult, error) {
 		body:         pq.r.parsedQuery,
 		builtinDecls: pq.r.builtinDecls,
 		builtinFuncs: pq.r.builtinFuncs,
+		provenance:   pq.r.supportProvenance,
 	}
 
 	return pr, nil
@@ -1524,9 +1863,10 @@ type PrepareOption func(*PrepareConfig)
 // PrepareConfig holds settings to control the behavior of the
 // Prepare call.
 type PrepareConfig struct {
-	doPartialEval   bool
-	disableInlining *[]string
-	builtinFuncs    map[string]*topdown.Builtin
+	doPartialEval    bool
+	disableInlining  *[]string
+	builtinFuncs     map[string]*topdown.Builtin
+	builtinOverrides map[string]topdown.BuiltinFunc
 }
 
 // WithPartialEval configures an option for PrepareForEval
@@ -1558,6 +1898,23 @@ func WithBuiltinFuncs(bis map[string]*topdown.Builtin) PrepareOption {
 	}
 }
 
+// WithBuiltinOverrides replaces the implementations of built-in functions for
+// the prepared query. The map is keyed by the name of the built-in function to
+// override, which must be a standard built-in function or one added with
+// rego.Function{1,2,3,Dyn}. Unlike topdown.RegisterBuiltinFunc, the overrides
+// only apply to evaluations of the prepared query, so embedders can provide
+// different implementations, e.g., per tenant, without global state.
+func WithBuiltinOverrides(overrides map[string]topdown.BuiltinFunc) PrepareOption {
+	return func(p *PrepareConfig) {
+		if p.builtinOverrides == nil {
+			p.builtinOverrides = make(map[string]topdown.BuiltinFunc, len(overrides))
+		}
+		for k, v := range overrides {
+			p.builtinOverrides[k] = v
+		}
+	}
+}
+
 // BuiltinFuncs allows retrieving the builtin funcs set via PrepareOption
 // WithBuiltinFuncs.
 func (p *PrepareConfig) BuiltinFuncs() map[string]*topdown.Builtin {
@@ -1576,6 +1933,14 @@ func (r *Rego) PrepareForEval(ctx context.Context, opts ...PrepareOption) (Prepa
 		o(pCfg)
 	}
 
+	for name := range pCfg.builtinOverrides {
+		if _, ok := ast.BuiltinMap[name]; !ok {
+			if _, ok := r.builtinDecls[name]; !ok {
+				return PreparedEvalQuery{}, fmt.Errorf("cannot override undefined built-in function %v", name)
+			}
+		}
+	}
+
 	var err error
 	var txnClose transactionCloser
 	r.txn, txnClose, err = r.getTxn(ctx)
@@ -1594,7 +1959,7 @@ func (r *Rego) PrepareForEval(ctx context.Context, opts ...PrepareOption) (Prepa
 		}
 
 		// Prepare the new query using the result of partial evaluation
-		pq, err := pr.Rego(Transaction(r.txn)).PrepareForEval(ctx)
+		pq, err := pr.Rego(Transaction(r.txn)).PrepareForEval(ctx, WithBuiltinOverrides(pCfg.builtinOverrides))
 		txnErr := txnClose(ctx, err)
 		if err != nil {
 			return pq, err
@@ -1744,18 +2109,33 @@ func (r *Rego) prepare(ctx context.Context, qType queryType, extras []extraStage
 		return err
 	}
 
-	err = r.parseModules(ctx, r.txn, r.metrics)
-	if err != nil {
-		return err
+	var fingerprint string
+	var cached bool
+	if r.useCompileCache(qType) {
+		fingerprint, cached, err = r.compileFromCache(ctx, r.txn, r.metrics)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !cached {
+		err = r.parseModules(ctx, r.txn, r.metrics)
+		if err != nil {
+			return err
+		}
 	}
 
 	// Compile the modules *before* the query, else functions
 	// defined in the module won't be found...
-	err = r.compileModules(ctx, r.txn, r.metrics)
+	err = r.compileModules(ctx, r.txn, r.metrics, cached)
 	if err != nil {
 		return err
 	}
 
+	if fingerprint != "" && !cached {
+		r.compileCache.Put(fingerprint, r.compiler)
+	}
+
 	imports, err := r.prepareImports()
 	if err != nil {
 		return err
@@ -1953,10 +2333,10 @@ func parserOptionsFromRegoVersionImport(imports []*ast.Import, popts ast.ParserO
 	return popts, nil
 }
 
-func (r *Rego) compileModules(ctx context.Context, txn storage.Transaction, m metrics.Metrics) error {
+func (r *Rego) compileModules(ctx context.Context, txn storage.Transaction, m metrics.Metrics, cached bool) error {
 
 	// Only compile again if there are new modules.
-	if len(r.bundles) > 0 || len(r.parsedModules) > 0 {
+	if !cached && (len(r.bundles) > 0 || len(r.parsedModules) > 0) {
 
 		// The bundle.Activate call will activate any bundles passed in
 		// (ie compile + handle data store changes), and include any of
@@ -2069,19 +2449,52 @@ func (r *Rego) compileQuery(query ast.Body, imports []*ast.Import, _ metrics.Met
 }
 
 func (r *Rego) eval(ctx context.Context, ectx *EvalContext) (ResultSet, error) {
+	var rs ResultSet
+	err := r.iter(ctx, ectx, func(result Result) error {
+		rs = append(rs, result)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rs) == 0 {
+		return nil, nil
+	}
+
+	return rs, nil
+}
+
+// iter evaluates the compiled query and invokes iter with each result. Targets
+// that do not support incremental evaluation (e.g., wasm and target plugins)
+// produce the full result set up front before it is passed to iter.
+func (r *Rego) iter(ctx context.Context, ectx *EvalContext, iter func(Result) error) error {
+	var rs ResultSet
+	var err error
+
 	switch {
 	case r.targetPrepState != nil: // target plugin flow
 		var val ast.Value
 		if r.runtime != nil {
 			val = r.runtime.Value
 		}
-		s, err := r.targetPrepState.Eval(ctx, ectx, val)
+		var s ast.Value
+		s, err = r.targetPrepState.Eval(ctx, ectx, val)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		rs, err = r.valueToQueryResult(s, ectx)
+		if err != nil {
+			return err
 		}
-		return r.valueToQueryResult(s, ectx)
+		return iterResultSet(rs, iter)
 	case r.target == targetWasm:
-		return r.evalWasm(ctx, ectx)
+		rs, err = r.evalWasm(ctx, ectx)
+		if err != nil {
+			return err
+		}
+		return iterResultSet(rs, iter)
 	case r.target == targetRego: // continue
 	}
 
@@ -2091,17 +2504,31 @@ func (r *Rego) eval(ctx context.Context, ectx *EvalContext) (ResultSet, error) {
 		WithStore(r.store).
 		WithTransaction(ectx.txn).
 		WithBuiltins(r.builtinFuncs).
+		WithBuiltinOverrides(ectx.builtinOverrides).
 		WithMetrics(ectx.metrics).
 		WithInstrumentation(ectx.instrumentation).
 		WithRuntime(r.runtime).
 		WithIndexing(ectx.indexing).
 		WithEarlyExit(ectx.earlyExit).
 		WithInterQueryBuiltinCache(ectx.interQueryBuiltinCache).
+		WithInterQueryRuleCache(ectx.interQueryRuleCache).
+		WithInterQueryBaseCache(ectx.interQueryBaseCache).
+		WithHTTPTransportPool(ectx.httpTransportPool).
+		WithRuleProfiler(ectx.ruleProfiler).
+		WithParallelism(ectx.parallelism).
+		WithMemoryPooling(ectx.memoryPooling).
+		WithMaxEvalDepth(ectx.maxEvalDepth).
+		WithMaxResultCount(ectx.maxResultCount).
+		WithMaxResultBytes(ectx.maxResultBytes).
+		WithBodyReordering(ectx.bodyReordering).
 		WithStrictBuiltinErrors(r.strictBuiltinErrors).
-		WithBuiltinErrorList(r.builtinErrorList).
+		WithStrictBuiltinErrorsFor(r.strictBuiltins).
+		WithEnforceCapabilities(r.enforceCapabilities).
+		WithBuiltinErrorList(ectx.builtinErrorList).
 		WithSeed(ectx.seed).
 		WithPrintHook(ectx.printHook).
-		WithDistributedTracingOpts(r.distributedTacingOpts)
+		WithDistributedTracingOpts(r.distributedTacingOpts).
+		WithBuiltinSpanThreshold(r.builtinSpanThreshold)
 
 	if !ectx.time.IsZero() {
 		q = q.WithTime(ectx.time)
@@ -2132,25 +2559,22 @@ func (r *Rego) eval(ctx context.Context, ectx *EvalContext) (ResultSet, error) {
 		c.Cancel()
 	})
 
-	var rs ResultSet
-	err := q.Iter(ctx, func(qr topdown.QueryResult) error {
+	return q.Iter(ctx, func(qr topdown.QueryResult) error {
 		result, err := r.generateResult(qr, ectx)
 		if err != nil {
 			return err
 		}
-		rs = append(rs, result)
-		return nil
+		return iter(result)
 	})
+}
 
-	if err != nil {
-		return nil, err
-	}
-
-	if len(rs) == 0 {
-		return nil, nil
+func iterResultSet(rs ResultSet, iter func(Result) error) error {
+	for i := range rs {
+		if err := iter(rs[i]); err != nil {
+			return err
+		}
 	}
-
-	return rs, nil
+	return nil
 }
 
 func (r *Rego) evalWasm(ctx context.Context, ectx *EvalContext) (ResultSet, error) {
@@ -2281,6 +2705,8 @@ func (r *Rego) partialResult(ctx context.Context, pCfg *PrepareConfig) (PartialR
 		resolvers:           r.resolvers,
 		capabilities:        r.capabilities,
 		strictBuiltinErrors: r.strictBuiltinErrors,
+		strictBuiltins:      r.strictBuiltins,
+		builtinErrorList:    r.builtinErrorList,
 	}
 
 	disableInlining := r.disableInlining
@@ -2340,6 +2766,7 @@ func (r *Rego) partialResult(ctx context.Context, pCfg *PrepareConfig) (PartialR
 		body:         ast.MustParseBody(fmt.Sprintf("data.%v.__result__", ectx.partialNamespace)),
 		builtinDecls: r.builtinDecls,
 		builtinFuncs: r.builtinFuncs,
+		provenance:   pq.Provenance,
 	}
 
 	return result, nil
@@ -2382,10 +2809,18 @@ func (r *Rego) partial(ctx context.Context, ectx *EvalContext) (*PartialQueries,
 		WithPartialNamespace(ectx.partialNamespace).
 		WithSkipPartialNamespace(r.skipPartialNamespace).
 		WithShallowInlining(r.shallowInlining).
+		WithEvalComprehensionsDuringPartial(r.partialComprehensions).
 		WithInterQueryBuiltinCache(ectx.interQueryBuiltinCache).
 		WithStrictBuiltinErrors(ectx.strictBuiltinErrors).
+		WithStrictBuiltinErrorsFor(ectx.strictBuiltins).
+		WithEnforceCapabilities(r.enforceCapabilities).
+		WithBuiltinErrorList(ectx.builtinErrorList).
 		WithSeed(ectx.seed).
-		WithPrintHook(ectx.printHook)
+		WithPrintHook(ectx.printHook).
+		WithMaxEvalDepth(ectx.maxEvalDepth)
+
+	var provenance []topdown.SupportProvenance
+	q = q.WithSupportProvenance(&provenance)
 
 	if !ectx.time.IsZero() {
 		q = q.WithTime(ectx.time)
@@ -2469,8 +2904,9 @@ func (r *Rego) partial(ctx context.Context, ectx *EvalContext) (*PartialQueries,
 	}
 
 	pq := &PartialQueries{
-		Queries: queries,
-		Support: support,
+		Queries:    queries,
+		Support:    support,
+		Provenance: provenance,
 	}
 
 	return pq, nil
@@ -2777,6 +3213,47 @@ func finishFunction(name string, bctx topdown.BuiltinContext, result *ast.Term,
 	return iter(result)
 }
 
+// streamFunction adapts a BuiltinStream to the topdown built-in function
+// signature. Cancellation is checked before each result is yielded so that
+// long-running streams stop promptly. Errors returned by the rest of the query
+// are passed through unchanged.
+func streamFunction(decl *Function, f BuiltinStream) topdown.BuiltinFunc {
+	return func(bctx BuiltinContext, terms []*ast.Term, iter func(*ast.Term) error) error {
+		var iterErr error
+		err := f(bctx, terms, func(result *ast.Term) error {
+			if iterErr != nil {
+				return iterErr
+			}
+			if bctx.Cancel != nil && bctx.Cancel.Cancelled() {
+				iterErr = topdown.Halt{Err: streamCancelErr(decl.Name, bctx.Cancel)}
+			} else {
+				iterErr = iter(result)
+			}
+			return iterErr
+		})
+		if iterErr != nil {
+			return iterErr
+		}
+		return finishFunction(decl.Name, bctx, nil, err, iter)
+	}
+}
+
+// streamCancelErr returns the error that halts a stream of the named built-in
+// function once c is cancelled. Cancellations caused by the query timeout are
+// reported as such.
+func streamCancelErr(name string, c topdown.Cancel) error {
+	if tc, ok := c.(interface{ TimedOut() bool }); ok && tc.TimedOut() {
+		return &topdown.Error{
+			Code:    topdown.TimeoutErr,
+			Message: fmt.Sprintf("%v: query evaluation timed out", name),
+		}
+	}
+	return &topdown.Error{
+		Code:    topdown.CancelErr,
+		Message: fmt.Sprintf("%v: caller cancelled query execution", name),
+	}
+}
+
 // helper function to return an option that sets a custom built-in function.
 func newFunction(decl *Function, f topdown.BuiltinFunc) func(*Rego) {
 	return func(r *Rego) {
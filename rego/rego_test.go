@@ -27,6 +27,7 @@ import (
 	"github.com/open-policy-agent/opa/bundle"
 	"github.com/open-policy-agent/opa/internal/storage/mock"
 	"github.com/open-policy-agent/opa/metrics"
+	"github.com/open-policy-agent/opa/resolver"
 	"github.com/open-policy-agent/opa/storage"
 	"github.com/open-policy-agent/opa/storage/inmem"
 	"github.com/open-policy-agent/opa/topdown"
@@ -292,6 +293,175 @@ func TestRegoCustomBuiltinHalt(t *testing.T) {
 	}
 }
 
+func TestRegoEnforceCapabilities(t *testing.T) {
+	funOpt := Function1(
+		&Function{
+			Name: "greet",
+			Decl: types.NewFunction(types.Args(types.S), types.S),
+		},
+		func(_ BuiltinContext, a *ast.Term) (*ast.Term, error) {
+			return ast.StringTerm("hello " + string(a.Value.(ast.String))), nil
+		},
+	)
+	caps := &ast.Capabilities{Builtins: []*ast.Builtin{ast.Assign, ast.Equality}}
+
+	// Built-in functions supplied with the Function options are allowed.
+	rs, err := New(
+		Query(`x := greet("bob")`),
+		Capabilities(caps),
+		EnforceCapabilities(true),
+		funOpt,
+	).Eval(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "hello bob"; len(rs) != 1 || rs[0].Bindings["x"] != exp {
+		t.Fatalf("expected %v but got %v", exp, rs)
+	}
+
+	compiler := ast.NewCompiler().WithCapabilities(caps).WithAllowUndefinedFunctionCalls(true)
+	compiler.Compile(map[string]*ast.Module{
+		"test.rego": ast.MustParseModule(`package test
+p := upper("a")`),
+	})
+	if compiler.Failed() {
+		t.Fatal(compiler.Errors)
+	}
+	_, err = New(
+		Query(`data.test.p`),
+		Compiler(compiler),
+		EnforceCapabilities(true),
+	).Eval(context.Background())
+	if !topdown.IsCapabilityErr(err) {
+		t.Fatalf("expected capability error but got %v", err)
+	}
+}
+
+func TestRegoCustomBuiltinStream(t *testing.T) {
+
+	pages := [][]string{{"a", "b"}, {"c"}, {}, {"d", "e"}}
+	var fetched int
+
+	funOpt := FunctionStream(
+		&Function{
+			Name: "test.paginate",
+			Decl: types.NewFunction(
+				types.Args(types.S),
+				types.S,
+			),
+		},
+		func(_ BuiltinContext, terms []*ast.Term, yield func(*ast.Term) error) error {
+			for _, page := range pages {
+				fetched++
+				for _, item := range page {
+					if err := yield(ast.StringTerm(string(terms[0].Value.(ast.String)) + item)); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	)
+
+	r := New(Query(`x := {y | y := test.paginate("item-")}`), funOpt)
+	rs, err := r.Eval(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []interface{}{"item-a", "item-b", "item-c", "item-d", "item-e"}
+	if len(rs) != 1 || !reflect.DeepEqual(rs[0].Bindings["x"], exp) {
+		t.Fatalf("Expected %v but got %v", exp, rs)
+	}
+
+	if fetched != len(pages) {
+		t.Fatalf("Expected %d pages to be fetched but got %d", len(pages), fetched)
+	}
+
+	// Errors returned by the implementation are reported like other custom built-in functions.
+	errOpt := FunctionStream(
+		&Function{
+			Name: "test.fail",
+			Decl: types.NewFunction(types.Args(), types.S),
+		},
+		func(_ BuiltinContext, _ []*ast.Term, yield func(*ast.Term) error) error {
+			if err := yield(ast.StringTerm("a")); err != nil {
+				return err
+			}
+			return fmt.Errorf("page unavailable")
+		},
+	)
+
+	r = New(Query(`x := {y | y := test.fail()}`), errOpt, StrictBuiltinErrors(true))
+	_, err = r.Eval(context.Background())
+	var tdErr *topdown.Error
+	if !errors.As(err, &tdErr) || tdErr.Code != topdown.BuiltinErr || tdErr.Message != "test.fail: page unavailable" {
+		t.Fatalf("Expected built-in error but got: %v", err)
+	}
+}
+
+func TestRegoCustomBuiltinStreamCancellation(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	funOpt := FunctionStream(
+		&Function{
+			Name: "test.forever",
+			Decl: types.NewFunction(types.Args(), types.N),
+		},
+		func(_ BuiltinContext, _ []*ast.Term, yield func(*ast.Term) error) error {
+			for i := 0; ; i++ {
+				if i == 10 {
+					cancel()
+				}
+				if err := yield(ast.IntNumberTerm(i)); err != nil {
+					return err
+				}
+			}
+		},
+	)
+
+	r := New(Query(`x := {y | y := test.forever()}`), funOpt)
+	_, err := r.Eval(ctx)
+	if !topdown.IsCancel(err) {
+		t.Fatalf("Expected cancellation error but got: %v", err)
+	}
+}
+
+func TestRegoCustomBuiltinStreamTimeout(t *testing.T) {
+
+	decl := &Function{
+		Name: "test.forever",
+		Decl: types.NewFunction(types.Args(), types.N),
+	}
+
+	stream := streamFunction(decl, func(_ BuiltinContext, _ []*ast.Term, yield func(*ast.Term) error) error {
+		for i := 0; ; i++ {
+			if err := yield(ast.IntNumberTerm(i)); err != nil {
+				return err
+			}
+		}
+	})
+
+	builtin := &ast.Builtin{Name: decl.Name, Decl: decl.Decl}
+	compiler := ast.NewCompiler().WithBuiltins(map[string]*ast.Builtin{decl.Name: builtin})
+	query, err := compiler.QueryCompiler().Compile(ast.MustParseBody(`x := {y | y := test.forever()}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = topdown.NewQuery(query).
+		WithCompiler(compiler).
+		WithStore(inmem.New()).
+		WithBuiltins(map[string]*topdown.Builtin{decl.Name: {Decl: builtin, Func: stream}}).
+		WithTimeout(10 * time.Millisecond).
+		Run(context.Background())
+	if !topdown.IsTimeout(err) {
+		t.Fatalf("Expected timeout error but got: %v", err)
+	}
+}
+
 func TestRegoMetrics(t *testing.T) {
 	m := metrics.New()
 	r := New(Query("foo = 1"), Module("foo.rego", "package x"), Metrics(m))
@@ -719,6 +889,143 @@ func TestPartialRewriteEquals(t *testing.T) {
 	}
 }
 
+func TestPartialProvenance(t *testing.T) {
+	mod := `package test
+
+default p = false
+
+p {
+	q[input.x]
+}
+
+q[x] {
+	x := input.y
+}
+
+f(x) = 1 {
+	x == input.z
+} else = 2 {
+	input.w
+}
+
+r {
+	f(input.a) == 1
+}
+`
+	r := New(
+		Query("data.test.p = true; data.test.r = true"),
+		Module("test.rego", mod),
+		ShallowInlining(true),
+	)
+
+	pq, err := r.Partial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows := map[string][]int{}
+	for _, p := range pq.Provenance {
+		if !p.Package.Equal(ast.MustParseRef("data.test")) {
+			t.Fatalf("expected package data.test but got %v", p.Package)
+		}
+		if p.Location.File != "test.rego" {
+			t.Fatalf("expected location in test.rego but got %v", p.Location)
+		}
+		name := p.Rule.Head.Ref().String()
+		rows[name] = append(rows[name], p.Location.Row)
+	}
+
+	exp := map[string][]int{
+		"p": {3, 5},
+		"q": {9},
+		"f": {13, 15},
+		"r": {19},
+	}
+
+	if !reflect.DeepEqual(rows, exp) {
+		t.Fatalf("expected provenance %v but got %v", exp, rows)
+	}
+
+	var numRules int
+	for _, module := range pq.Support {
+		ast.WalkRules(module, func(*ast.Rule) bool {
+			numRules++
+			return false
+		})
+	}
+
+	if numRules != len(pq.Provenance) {
+		t.Fatalf("expected provenance for all %d support rules but got %d", numRules, len(pq.Provenance))
+	}
+
+	for _, p := range pq.Provenance {
+		rule := pq.Support[0].Rules[p.Index]
+		for i := 0; i < p.Else; i++ {
+			rule = rule.Else
+		}
+		if rule != p.Rule {
+			t.Fatalf("expected index %d and else %d to locate %v", p.Index, p.Else, p.Rule)
+		}
+	}
+
+	bs, err := json.Marshal(pq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result struct {
+		Provenance []struct {
+			Ref      string        `json:"ref"`
+			Index    int           `json:"index"`
+			Package  string        `json:"package"`
+			Location *ast.Location `json:"location"`
+		} `json:"provenance"`
+	}
+	if err := util.Unmarshal(bs, &result); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Provenance) != len(pq.Provenance) {
+		t.Fatalf("expected %d serialized provenance entries but got: %s", len(pq.Provenance), bs)
+	}
+
+	for i, p := range result.Provenance {
+		if p.Ref != pq.Provenance[i].Ref.String() || p.Package != "data.test" || p.Location.Row != pq.Provenance[i].Location.Row {
+			t.Fatalf("unexpected serialized provenance: %s", bs)
+		}
+	}
+}
+
+func TestPartialResultProvenance(t *testing.T) {
+	mod := `package test
+
+p {
+	q[input.x]
+}
+
+q[x] {
+	x := input.y
+}
+`
+	pr, err := New(
+		Query("data.test.p"),
+		Module("test.rego", mod),
+		DisableInlining([]string{"data.test.q"}),
+	).PartialResult(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provenance := pr.Provenance()
+	if len(provenance) != 1 || provenance[0].Location.Row != 7 || !provenance[0].Package.Equal(ast.MustParseRef("data.test")) {
+		t.Fatalf("expected provenance for q but got %v", provenance)
+	}
+
+	if exp := pr.Rego().supportProvenance; !reflect.DeepEqual(exp, provenance) {
+		t.Fatalf("expected provenance to be propagated but got %v", exp)
+	}
+}
+
 // NOTE(sr): https://github.com/open-policy-agent/opa/issues/4345
 func TestPrepareAndEvalRaceConditions(t *testing.T) {
 	tests := []struct {
@@ -796,6 +1103,128 @@ func TestPrepareAndEvalNewInput(t *testing.T) {
 	}, "[[1]]")
 }
 
+func TestPreparedEvalQueryWith(t *testing.T) {
+	module := `
+	package test
+	x = [data.foo.y, input.y]
+	`
+
+	pq, err := New(
+		Query("data.test.x"),
+		Module("", module),
+		Store(inmem.NewFromObject(map[string]interface{}{"foo": map[string]interface{}{"y": 1}})),
+		Input(map[string]interface{}{"y": "a"}),
+	).PrepareForEval(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	derived, err := pq.With(
+		PreparedStore(inmem.NewFromObject(map[string]interface{}{"foo": map[string]interface{}{"y": 2}})),
+		PreparedInput(map[string]interface{}{"y": "b"}),
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	assertPreparedEvalQueryEval(t, derived, nil, `[[[2, "b"]]]`)
+	assertPreparedEvalQueryEval(t, derived, []EvalOption{EvalInput(map[string]interface{}{"y": "c"})}, `[[[2, "c"]]]`)
+
+	// The original query is not modified.
+	assertPreparedEvalQueryEval(t, pq, nil, `[[[1, "a"]]]`)
+
+	parsed, err := derived.With(PreparedParsedInput(ast.MustParseTerm(`{"y": "d"}`).Value))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	assertPreparedEvalQueryEval(t, parsed, nil, `[[[2, "d"]]]`)
+
+	raw, err := parsed.With(PreparedInput(map[string]interface{}{"y": "e"}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	assertPreparedEvalQueryEval(t, raw, nil, `[[[2, "e"]]]`)
+}
+
+func TestCompileCache(t *testing.T) {
+	module := `
+	package test
+	x = input.y
+	`
+	ctx := context.Background()
+	cache := NewCompileCache(0)
+
+	var compilers []*ast.Compiler
+	for i, tc := range []struct {
+		module string
+		hit    bool
+	}{
+		{module: module},
+		{module: module, hit: true},
+		{module: module + "y = 1\n"},
+	} {
+		m := metrics.New()
+		r := New(
+			Query("data.test.x"),
+			Module("test.rego", tc.module),
+			Metrics(m),
+			WithCompileCache(cache),
+		)
+		pq, err := r.PrepareForEval(ctx)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		assertPreparedEvalQueryEval(t, pq, []EvalOption{EvalInput(map[string]int{"y": i})}, fmt.Sprintf("[[%d]]", i))
+
+		var exp uint64
+		if tc.hit {
+			exp = 1
+		}
+		if hits := m.Counter(metrics.RegoCompileCacheHit).Value(); hits != exp {
+			t.Fatalf("case %d: expected hit %v but got %v hits", i, tc.hit, hits)
+		}
+		if len(pq.Modules()) != 1 {
+			t.Fatalf("case %d: expected 1 module but got %v", i, pq.Modules())
+		}
+		compilers = append(compilers, r.compiler)
+	}
+
+	if compilers[0] != compilers[1] || compilers[0] == compilers[2] {
+		t.Fatal("expected compilers to be shared for the same modules only")
+	}
+
+	// Compiler options are part of the fingerprint.
+	m := metrics.New()
+	_, err := New(
+		Query("data.test.x"),
+		Module("test.rego", module),
+		Metrics(m),
+		Strict(true),
+		WithCompileCache(cache),
+	).PrepareForEval(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if hits := m.Counter(metrics.RegoCompileCacheHit).Value(); hits != uint64(0) {
+		t.Fatalf("expected no hit for different compiler options but got %v", hits)
+	}
+}
+
+func TestCompileCacheMaxEntries(t *testing.T) {
+	cache := NewCompileCache(2)
+	for _, fp := range []string{"a", "b", "c"} {
+		cache.Put(fp, ast.NewCompiler())
+	}
+	if _, ok := cache.Get("a"); ok {
+		t.Fatal("expected oldest compiler to be evicted")
+	}
+	for _, fp := range []string{"b", "c"} {
+		if _, ok := cache.Get(fp); !ok {
+			t.Fatalf("expected compiler for %q", fp)
+		}
+	}
+}
+
 func TestPrepareAndEvalNewMetrics(t *testing.T) {
 	module := `
 	package test
@@ -1760,6 +2189,97 @@ func TestPreparedQueryGetModules(t *testing.T) {
 	}
 }
 
+func TestPreparedEvalQueryIter(t *testing.T) {
+	ctx := context.Background()
+
+	pq, err := New(
+		Module("test.rego", "package test\np[x] { x := numbers.range(1, 5)[_] }"),
+		Query("data.test.p[x]"),
+	).PrepareForEval(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	var xs []interface{}
+	err = pq.Iter(ctx, func(r Result) error {
+		xs = append(xs, r.Bindings["x"])
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(xs) != 5 {
+		t.Fatalf("Expected 5 results, got %d: %v", len(xs), xs)
+	}
+
+	rs, err := pq.Eval(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	for i := range rs {
+		if !reflect.DeepEqual(rs[i].Bindings["x"], xs[i]) {
+			t.Fatalf("Expected Iter and Eval results to match, got %v and %v", xs, rs)
+		}
+	}
+
+	// Errors returned by the iterator halt evaluation.
+	stop := errors.New("stop")
+	var n int
+	err = pq.Iter(ctx, func(Result) error {
+		n++
+		return stop
+	})
+	if err != stop {
+		t.Fatalf("Expected stop error, got %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("Expected iteration to halt after first result, got %d", n)
+	}
+}
+
+func TestPreparedEvalQueryLazyParsedInput(t *testing.T) {
+	ctx := context.Background()
+
+	pq, err := New(
+		Module("test.rego", `package test
+			images := {c.image | c := input.request.object.spec.containers[_]}
+			p := {"images": images, "patched": patched, "kind": input.request.kind}
+			patched := x { x := images with input.request.object.spec.containers as [{"image": "patched"}] }`),
+		Query("data.test.p"),
+	).PrepareForEval(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	input := ast.LazyObject(map[string]interface{}{
+		"request": map[string]interface{}{
+			"kind": "Pod",
+			"object": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{"image": "nginx"},
+					},
+				},
+			},
+		},
+	})
+
+	exp := util.MustUnmarshalJSON([]byte(`{"images": ["nginx"], "patched": ["patched"], "kind": "Pod"}`))
+
+	// Evaluating twice asserts that the with keyword did not change the input.
+	for i := 0; i < 2; i++ {
+		rs, err := pq.Eval(ctx, EvalParsedInput(input))
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if len(rs) != 1 || !reflect.DeepEqual(rs[0].Expressions[0].Value, exp) {
+			t.Fatalf("Expected %v but got %v", exp, rs)
+		}
+	}
+}
+
 func TestRegoEvalWithFile(t *testing.T) {
 	files := map[string]string{
 		"x/x.rego": "package x\np = 1",
@@ -2572,6 +3092,11 @@ func TestNDBCacheMarshalUnmarshalJSON(t *testing.T) {
 	if !bytes.Equal(jOriginal, jOther) {
 		t.Fatalf("JSONified values of NDBCaches do not match; expected %s, got %s", string(jOriginal), string(jOther))
 	}
+
+	// Check that the operands of the cached calls are restored for lookups.
+	if _, ok := other.Get("time.now_ns", ast.NewArray()); !ok {
+		t.Fatal("expected cached value to be found after unmarshalling")
+	}
 }
 
 func TestStrictBuiltinErrors(t *testing.T) {
@@ -2593,6 +3118,44 @@ func TestStrictBuiltinErrors(t *testing.T) {
 	}
 }
 
+func TestStrictBuiltinErrorsFor(t *testing.T) {
+	ctx := context.Background()
+
+	var buf []topdown.Error
+	rs, err := New(
+		Query(`x := to_number("a")`),
+		StrictBuiltinErrorsFor([]string{"to_number"}),
+		BuiltinErrorList(&buf),
+	).Eval(ctx)
+	if err == nil {
+		t.Fatalf("expected error but got: %v", rs)
+	}
+	topdownErr, ok := err.(*topdown.Error)
+	if !ok {
+		t.Fatal("expected topdown error but got:", err)
+	}
+	if topdownErr.Message != `to_number: strconv.ParseFloat: parsing "a": invalid syntax` {
+		t.Fatal("expected to_number error but got:", topdownErr.Message)
+	}
+
+	// Errors from other built-in functions remain non-fatal.
+	buf = nil
+	rs, err = New(
+		Query(`x := 1/0`),
+		StrictBuiltinErrorsFor([]string{"to_number"}),
+		BuiltinErrorList(&buf),
+	).Eval(ctx)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if len(rs) != 0 {
+		t.Fatal("expected undefined result but got:", rs)
+	}
+	if len(buf) != 1 || buf[0].Message != "div: divide by zero" {
+		t.Fatal("expected divide by zero error in buffer but got:", buf)
+	}
+}
+
 func TestBuiltinErrorList(t *testing.T) {
 	var buf []topdown.Error
 
@@ -2610,6 +3173,34 @@ func TestBuiltinErrorList(t *testing.T) {
 	}
 }
 
+func TestEvalBuiltinErrorList(t *testing.T) {
+	ctx := context.Background()
+
+	pq, err := New(Query("x := 1/input.y")).PrepareForEval(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		input  int
+		expErr int
+	}{
+		{input: 0, expErr: 1},
+		{input: 1, expErr: 0},
+	} {
+		var buf []topdown.Error
+		if _, err := pq.Eval(ctx, EvalInput(map[string]interface{}{"y": tc.input}), EvalBuiltinErrorList(&buf)); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		if len(buf) != tc.expErr {
+			t.Fatalf("expected %d errors in buffer for input %d but got: %v", tc.expErr, tc.input, buf)
+		}
+		if tc.expErr > 0 && buf[0].Message != "div: divide by zero" {
+			t.Fatal("expected divide by zero error but got:", buf[0].Message)
+		}
+	}
+}
+
 func TestTimeSeedingOptions(t *testing.T) {
 
 	ctx := context.Background()
@@ -2958,6 +3549,89 @@ func TestDescriptionRegisterBuiltinDyn(t *testing.T) {
 	}
 }
 
+type usersResolver struct {
+	users   map[string]interface{}
+	fetched map[string]int
+}
+
+// Eval fetches the referenced user only, or all users if no user is referenced.
+func (r *usersResolver) Eval(_ context.Context, in resolver.Input) (resolver.Result, error) {
+	if len(in.Path) == 0 {
+		r.fetched[""]++
+		return resolver.Result{Value: ast.MustInterfaceToValue(r.users)}, nil
+	}
+	key, ok := in.Path[0].Value.(ast.String)
+	if !ok {
+		return resolver.Result{}, nil
+	}
+	r.fetched[string(key)]++
+	user, ok := r.users[string(key)]
+	if !ok {
+		return resolver.Result{}, nil
+	}
+	return resolver.Result{Value: ast.MustInterfaceToValue(user), Path: in.Path[:1]}, nil
+}
+
+func TestResolverPullThrough(t *testing.T) {
+	r := &usersResolver{
+		users: map[string]interface{}{
+			"alice": map[string]interface{}{"role": "admin"},
+			"bob":   map[string]interface{}{"role": "dev"},
+		},
+		fetched: map[string]int{},
+	}
+	cache := resolver.NewCache(r, time.Minute, 0)
+
+	tests := []struct {
+		query string
+		exp   string
+	}{
+		{`x := data.users.alice.role`, `[[{"x": "admin"}]]`},
+		{`x := data.users.alice`, `[[{"x": {"role": "admin"}}]]`},
+		{`x := data.users.bob.role`, `[[{"x": "dev"}]]`},
+		{`x := data.users.carol`, `[]`},
+		{`x := {k | data.users[k]}`, `[[{"x": ["alice", "bob"]}]]`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.query, func(t *testing.T) {
+			rs, err := New(
+				Query(tc.query),
+				Resolver(ast.MustParseRef("data.users"), cache),
+			).Eval(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []interface{}
+			for _, result := range rs {
+				got = append(got, []interface{}{result.Bindings})
+			}
+			if got == nil {
+				got = []interface{}{}
+			}
+			if exp := util.MustUnmarshalJSON([]byte(tc.exp)); !reflect.DeepEqual(util.MustUnmarshalJSON(util.MustMarshalJSON(got)), exp) {
+				t.Fatalf("expected %v but got %v", exp, got)
+			}
+		})
+	}
+
+	exp := map[string]int{"alice": 1, "bob": 1, "carol": 1, "": 1}
+	if !reflect.DeepEqual(r.fetched, exp) {
+		t.Fatalf("expected fetches %v but got %v", exp, r.fetched)
+	}
+}
+
+func TestResolverWithStatement(t *testing.T) {
+	r := &usersResolver{users: map[string]interface{}{}, fetched: map[string]int{}}
+	_, err := New(
+		Query(`x := data.users.alice with data.roles as {}`),
+		Resolver(ast.MustParseRef("data.users"), r),
+	).Eval(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "external resolver for data.users cannot be evaluated when 'with' statements are in-scope") {
+		t.Fatalf("expected with statement error but got %v", err)
+	}
+}
+
 // unregisterBuiltin removes the builtin of the given name from ast.Builtins. This assists in
 // cleaning up custom functions added as part of certain test cases.
 func unregisterBuiltin(name string) {
@@ -49,6 +49,7 @@ type REPL struct {
 	txn                 storage.Transaction
 	metrics             metrics.Metrics
 	profiler            bool
+	ruleProfiler        *topdown.RuleProfiler
 	strictBuiltinErrors bool
 	capabilities        *ast.Capabilities
 	v1Compatible        bool
@@ -271,6 +272,10 @@ func (r *REPL) OneShot(ctx context.Context, line string) error {
 				return r.cmdUnset(ctx, cmd.args)
 			case "unset-package":
 				return r.cmdUnsetPackage(ctx, cmd.args)
+			case "save":
+				return r.cmdSave(cmd.args)
+			case "load":
+				return r.cmdLoad(ctx, cmd.args)
 			case "pretty":
 				return r.cmdFormat("pretty")
 			case "pretty-limit":
@@ -297,7 +302,7 @@ func (r *REPL) OneShot(ctx context.Context, line string) error {
 			case "instrument":
 				return r.cmdInstrument()
 			case "profile":
-				return r.cmdProfile()
+				return r.cmdProfile(ctx, strings.TrimSpace(strings.TrimSpace(line)[len(cmd.op):]))
 			case "types":
 				return r.cmdTypes()
 			case "unknown":
@@ -406,12 +411,75 @@ func (r *REPL) complete(line string) []string {
 		}
 	}
 
+	// add documents beneath the ref being completed, e.g., the keys of an
+	// object produced by a rule
+	for _, path := range r.completeRef(line, mods) {
+		set[path] = struct{}{}
+	}
+
 	for path := range set {
 		c = append(c, path)
 	}
 	return c
 }
 
+// completeRef returns the refs directly beneath the ref preceding the last dot
+// in line that start with line. The refs are obtained from the type
+// environment of the compiled modules, so they include the static keys of the
+// objects produced by rules.
+func (r *REPL) completeRef(line string, mods map[string]*ast.Module) []string {
+	i := strings.LastIndex(line, ".")
+	if i <= 0 {
+		return nil
+	}
+
+	term, err := ast.ParseTerm(line[:i])
+	if err != nil {
+		return nil
+	}
+
+	var parent ast.Ref
+	switch v := term.Value.(type) {
+	case ast.Ref:
+		parent = v
+	case ast.Var:
+		parent = ast.Ref{term}
+	default:
+		return nil
+	}
+
+	prefix := parent
+	if v, ok := parent[0].Value.(ast.Var); ok {
+		if mod, ok := r.modules[r.currentModuleID]; ok {
+			if path, ok := ast.ImportedRefs(mod)[v]; ok {
+				prefix = path.Concat(parent[1:])
+			}
+		}
+	}
+
+	policies := make(map[string]*ast.Module, len(mods)+len(r.modules))
+	for id, mod := range mods {
+		policies[id] = mod
+	}
+	for id, mod := range r.modules {
+		policies[id] = mod
+	}
+
+	compiler := ast.NewCompiler().WithCapabilities(r.capabilities)
+	if compiler.Compile(policies); compiler.Failed() {
+		return nil
+	}
+
+	var result []string
+	for _, child := range compiler.TypeEnv.Children(prefix) {
+		path := parent.Append(child.Ref[len(child.Ref)-1]).String()
+		if path != line && strings.HasPrefix(path, line) {
+			result = append(result, path)
+		}
+	}
+	return result
+}
+
 func (r *REPL) cmdDump(ctx context.Context, args []string) error {
 	if len(args) == 0 {
 		return r.cmdDumpOutput(ctx)
@@ -432,6 +500,97 @@ func (r *REPL) cmdDumpPath(ctx context.Context, filename string) error {
 	return dumpStorage(ctx, r.store, r.txn, f)
 }
 
+// session is the snapshot of a REPL session written by the save command: the
+// modules defined in the REPL, keyed by package path, and the active package.
+type session struct {
+	Package string            `json:"package,omitempty"`
+	Modules map[string]string `json:"modules"`
+}
+
+func (r *REPL) cmdSave(args []string) error {
+	if len(args) != 1 {
+		return newBadArgsErr("save <path>: expects exactly one argument")
+	}
+
+	opts := format.Opts{}
+	if r.v1Compatible {
+		opts.RegoVersion = ast.RegoV1
+	}
+
+	snapshot := session{
+		Package: r.currentModuleID,
+		Modules: make(map[string]string, len(r.modules)),
+	}
+	for id, mod := range r.modules {
+		bs, err := format.AstWithOpts(mod, opts)
+		if err != nil {
+			return err
+		}
+		snapshot.Modules[id] = string(bs)
+	}
+
+	bs, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(args[0], append(bs, '\n'), 0o644)
+}
+
+func (r *REPL) cmdLoad(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return newBadArgsErr("load <path>: expects exactly one argument")
+	}
+
+	bs, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	var snapshot session
+	if err := json.Unmarshal(bs, &snapshot); err != nil {
+		return fmt.Errorf("%v: invalid session: %w", args[0], err)
+	}
+
+	popts := ast.ParserOptions{}
+	if r.v1Compatible {
+		popts.RegoVersion = ast.RegoV1
+	}
+
+	modules := make(map[string]*ast.Module, len(snapshot.Modules))
+	for id, src := range snapshot.Modules {
+		mod, err := ast.ParseModuleWithOpts(id, src, popts)
+		if err != nil {
+			return err
+		}
+		modules[mod.Package.Path.String()] = mod
+	}
+
+	if _, ok := modules[snapshot.Package]; snapshot.Package != "" && !ok {
+		return fmt.Errorf("%v: invalid session: no module for package %v", args[0], snapshot.Package)
+	}
+
+	policies, err := r.loadModules(ctx, r.txn)
+	if err != nil {
+		return err
+	}
+	for id, mod := range modules {
+		policies[id] = mod
+	}
+
+	compiler := ast.NewCompiler().
+		SetErrorLimit(r.errLimit).
+		WithEnablePrintStatements(true).
+		WithCapabilities(r.capabilities)
+
+	if compiler.Compile(policies); compiler.Failed() {
+		return compiler.Errors
+	}
+
+	r.modules = modules
+	r.currentModuleID = snapshot.Package
+	return nil
+}
+
 func (r *REPL) cmdExit() error {
 	return stop{}
 }
@@ -566,13 +725,32 @@ func (r *REPL) profilerEnabled() bool {
 	return r.profiler
 }
 
-func (r *REPL) cmdProfile() error {
-	if r.profiler {
-		r.profiler = false
-	} else {
-		r.profiler = true
+// cmdProfile toggles the profiler, or, if a query is given, evaluates the
+// query once with the profiler and prints the time spent in each rule and
+// expression.
+func (r *REPL) cmdProfile(ctx context.Context, query string) error {
+	if query == "" {
+		r.profiler = !r.profiler
+		return nil
 	}
-	return nil
+
+	profiler, explain := r.profiler, r.explain
+	r.profiler, r.explain = true, explainOff
+	r.ruleProfiler = topdown.NewRuleProfiler()
+	defer func() {
+		r.profiler, r.explain = profiler, explain
+		r.ruleProfiler = nil
+	}()
+
+	popts, err := r.parserOptions()
+	if err != nil {
+		return err
+	}
+	body, err := ast.ParseBodyWithOpts(query, popts)
+	if err != nil {
+		return err
+	}
+	return r.evalStatement(ctx, body)
 }
 
 func (r *REPL) cmdStrictBuiltinErrors() error {
@@ -1045,6 +1223,10 @@ func (r *REPL) evalBody(ctx context.Context, compiler *ast.Compiler, input ast.V
 		args = append(args, rego.QueryTracer(prof))
 	}
 
+	if r.ruleProfiler != nil {
+		args = append(args, rego.RuleProfiler(r.ruleProfiler))
+	}
+
 	eval := rego.New(args...)
 	rs, err := eval.Eval(ctx)
 
@@ -1058,6 +1240,10 @@ func (r *REPL) evalBody(ctx context.Context, compiler *ast.Compiler, input ast.V
 		output.Profile = prof.ReportTopNResults(-1, pr.DefaultProfileSortOrder)
 	}
 
+	if r.ruleProfiler != nil {
+		output.RuleProfile = r.ruleProfiler.StatsByRef()
+	}
+
 	output = output.WithLimit(r.prettyLimit)
 
 	switch r.explain {
@@ -1354,11 +1540,13 @@ var builtin = [...]commandDesc{
 	{"fails", []string{}, "toggle fails trace"},
 	{"metrics", []string{}, "toggle metrics"},
 	{"instrument", []string{}, "toggle instrumentation"},
-	{"profile", []string{}, "toggle profiler and turns off trace"},
+	{"profile", []string{"[query]"}, "toggle profiler and turns off trace, or profile query"},
 	{"types", []string{}, "toggle type information"},
 	{"unknown", []string{"[ref-1 [ref-2 [...]]]"}, "toggle partial evaluation mode"},
 	{"strict-builtin-errors", []string{}, "toggle strict built-in error mode"},
 	{"dump", []string{"[path]"}, "dump raw data in storage"},
+	{"save", []string{"<path>"}, "save rules and imports defined in the session"},
+	{"load", []string{"<path>"}, "load rules and imports saved by save"},
 	{"help", []string{"[topic]"}, "print this message"},
 	{"target", []string{"[mode]"}, "set the runtime to exercise {rego,wasm} (default rego)"},
 	{"exit", []string{}, "exit out of shell (or ctrl+d)"},
@@ -257,6 +257,50 @@ r = 3 { true }`)
 	}
 }
 
+func TestCompleteObjectKeys(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore()
+	txn := storage.NewTransactionOrDie(ctx, store, storage.WriteParams)
+
+	mod := []byte(`package a
+
+p := {"foo": 1, "bar": {"baz": true}}`)
+
+	if err := store.UpsertPolicy(ctx, txn, "mod", mod); err != nil {
+		panic(err)
+	}
+
+	if err := store.Commit(ctx, txn); err != nil {
+		panic(err)
+	}
+
+	var buf bytes.Buffer
+	repl := newRepl(store, &buf)
+
+	tests := map[string][]string{
+		"data.a.p.":     {"data.a.p.bar", "data.a.p.foo"},
+		"data.a.p.b":    {"data.a.p.bar"},
+		"data.a.p.bar.": {"data.a.p.bar.baz"},
+	}
+
+	for line, expected := range tests {
+		result := repl.complete(line)
+		sort.Strings(result)
+		if !reflect.DeepEqual(result, expected) {
+			t.Fatalf("Expected %v for %q but got: %v", expected, line, result)
+		}
+	}
+
+	if err := repl.OneShot(ctx, "import data.a.p as obj"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	result := repl.complete("obj.f")
+	if expected := []string{"obj.foo"}; !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %v but got: %v", expected, result)
+	}
+}
+
 func TestDump(t *testing.T) {
 	ctx := context.Background()
 	input := `{"a": [1,2,3,4]}`
@@ -2724,6 +2768,54 @@ default allow = false
 	buffer.Reset()
 }
 
+func TestProfileQuery(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore()
+	txn := storage.NewTransactionOrDie(ctx, store, storage.WriteParams)
+
+	mod := []byte(`package test
+
+p { q[x]; x > 1 }
+
+q[x] { x := [1, 2, 3][_] }`)
+
+	if err := store.UpsertPolicy(ctx, txn, "mod", mod); err != nil {
+		panic(err)
+	}
+	if err := store.Commit(ctx, txn); err != nil {
+		panic(err)
+	}
+
+	var buffer bytes.Buffer
+	repl := newRepl(store, &buffer)
+	if err := repl.OneShot(ctx, "profile data.test.p"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	result := buffer.String()
+	for _, exp := range []string{"true", "NUM EARLY EXIT", "data.test.q", "NUM GEN EXPR", "mod:3"} {
+		if !strings.Contains(result, exp) {
+			t.Fatalf("Expected %q in output but got:\n%v", exp, result)
+		}
+	}
+
+	// The profiler is only enabled for the profiled query.
+	buffer.Reset()
+	if err := repl.OneShot(ctx, "data.test.p"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if buffer.String() != "true\n" {
+		t.Fatalf("Expected no profile but got:\n%v", buffer.String())
+	}
+	if repl.profilerEnabled() {
+		t.Fatal("Expected profiler to be disabled")
+	}
+
+	if err := repl.OneShot(ctx, "profile data.test["); err == nil {
+		t.Fatal("Expected parse error")
+	}
+}
+
 func TestStrictBuiltinErrors(t *testing.T) {
 	ctx := context.Background()
 	store := newTestStore()
@@ -2930,6 +3022,56 @@ func TestTruncatePrettyOutput(t *testing.T) {
 	}
 }
 
+func TestSaveLoad(t *testing.T) {
+	ctx := context.Background()
+	store := inmem.New()
+	var buffer bytes.Buffer
+	repl := newRepl(store, &buffer)
+
+	path := filepath.Join(t.TempDir(), "session.json")
+
+	for _, line := range []string{
+		"package lib",
+		"double(x) = y { y := x * 2 }",
+		"package a",
+		"import data.lib",
+		"p := lib.double(2)",
+		"save " + path,
+	} {
+		if err := repl.OneShot(ctx, line); err != nil {
+			t.Fatalf("Unexpected error on %q: %v", line, err)
+		}
+	}
+
+	buffer.Reset()
+	other := newRepl(store, &buffer)
+	if err := other.OneShot(ctx, "load "+path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := other.OneShot(ctx, "p"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectOutput(t, buffer.String(), "4\n")
+
+	buffer.Reset()
+	if err := other.OneShot(ctx, "show"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertREPLText(t, buffer, `package a
+
+import data.lib
+
+p := lib.double(2)
+`)
+
+	if err := other.OneShot(ctx, "load"); err == nil {
+		t.Fatal("Expected error for missing path")
+	}
+	if err := other.OneShot(ctx, "load "+filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Fatal("Expected error for missing file")
+	}
+}
+
 func TestUnsetPackage(t *testing.T) {
 	ctx := context.Background()
 	store := inmem.New()
@@ -0,0 +1,167 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package resolver
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	cacheHitCounter  = "rego_external_resolve_cache_hit"
+	cacheMissCounter = "rego_external_resolve_cache_miss"
+)
+
+// Cache is a Resolver that caches the results of another Resolver for a time
+// to live. It turns resolvers that fetch documents from external systems on
+// demand into pull-through caches: documents are fetched when first
+// referenced, and fetched again once their results expire.
+//
+// Results are cached by the ref of the referenced document, so the input of
+// evaluations must not change them. Errors are not cached. Concurrent
+// evaluations referencing a document that is not cached wait for a single
+// call to the wrapped Resolver, or for their context to be done.
+type Cache struct {
+	r          Resolver
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	mtx     sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+type cacheEntry struct {
+	done    chan struct{}
+	result  Result
+	err     error
+	expires time.Time
+}
+
+// NewCache returns a Cache of the results of r that expire after ttl. If
+// maxEntries is positive, at most maxEntries results are cached.
+func NewCache(r Resolver, ttl time.Duration, maxEntries int) *Cache {
+	return &Cache{
+		r:          r,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        time.Now,
+		entries:    map[string]*cacheEntry{},
+	}
+}
+
+// Eval returns the cached result for the document referenced by in, calling
+// the wrapped Resolver if there is none or it has expired. Results holding
+// documents that contain the referenced document are used as well.
+func (c *Cache) Eval(ctx context.Context, in Input) (Result, error) {
+	key := in.Ref.Concat(in.Path).String()
+
+	c.mtx.Lock()
+	entry, ok := c.lookup(key, in)
+	if ok {
+		c.mtx.Unlock()
+		select {
+		case <-entry.done:
+		case <-ctx.Done():
+			return Result{}, ctx.Err()
+		}
+		if entry.err != nil {
+			return Result{}, entry.err
+		}
+		incr(in, cacheHitCounter)
+		return entry.result, nil
+	}
+	entry = &cacheEntry{done: make(chan struct{})}
+	c.evict()
+	c.entries[key] = entry
+	c.mtx.Unlock()
+
+	incr(in, cacheMissCounter)
+	entry.result, entry.err = c.r.Eval(ctx, in)
+
+	c.mtx.Lock()
+	entry.expires = c.now().Add(c.ttl)
+	if c.entries[key] == entry {
+		delete(c.entries, key)
+	}
+	if entry.err == nil {
+		// Results are cached by the ref of the document they hold, so that
+		// they are used for all the documents it contains.
+		if entry.result.Value != nil && in.Path.HasPrefix(entry.result.Path) {
+			key = in.Ref.Concat(entry.result.Path).String()
+		}
+		c.entries[key] = entry
+	}
+	close(entry.done)
+	c.mtx.Unlock()
+
+	return entry.result, entry.err
+}
+
+// lookup returns the entry for key, which may still be fetched, or else the
+// unexpired entry holding a document that contains the document referenced by
+// in. c.mtx must be held.
+func (c *Cache) lookup(key string, in Input) (*cacheEntry, bool) {
+	if entry, ok := c.entries[key]; ok && !c.expired(entry) {
+		return entry, true
+	}
+	for n := len(in.Path) - 1; n >= 0; n-- {
+		entry, ok := c.entries[in.Ref.Concat(in.Path[:n]).String()]
+		if ok && c.fetched(entry) && !c.expired(entry) && entry.result.Value != nil {
+			return entry, true
+		}
+	}
+	return nil, false
+}
+
+// Clear removes all cached results.
+func (c *Cache) Clear() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.entries = map[string]*cacheEntry{}
+}
+
+// expired reports whether entry has been fetched and has expired. c.mtx must
+// be held.
+func (c *Cache) expired(entry *cacheEntry) bool {
+	return c.fetched(entry) && !c.now().Before(entry.expires)
+}
+
+func (*Cache) fetched(entry *cacheEntry) bool {
+	select {
+	case <-entry.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// evict makes room for a new entry by removing expired entries, and then
+// arbitrary fetched entries if there are still too many. c.mtx must be held.
+func (c *Cache) evict() {
+	if c.maxEntries <= 0 || len(c.entries) < c.maxEntries {
+		return
+	}
+	for key, entry := range c.entries {
+		if c.expired(entry) {
+			delete(c.entries, key)
+		}
+	}
+	for key, entry := range c.entries {
+		if len(c.entries) < c.maxEntries {
+			return
+		}
+		if c.fetched(entry) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func incr(in Input, name string) {
+	if in.Metrics != nil {
+		in.Metrics.Counter(name).Incr()
+	}
+}
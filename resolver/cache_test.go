@@ -0,0 +1,157 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package resolver
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/metrics"
+)
+
+type countingResolver struct {
+	mtx   sync.Mutex
+	calls map[string]int
+	err   error
+	block chan struct{}
+}
+
+func (r *countingResolver) Eval(_ context.Context, in Input) (Result, error) {
+	if r.block != nil {
+		<-r.block
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.calls[in.Ref.Concat(in.Path).String()]++
+	if r.err != nil {
+		return Result{}, r.err
+	}
+	return Result{Value: ast.String(in.Path.String()), Path: in.Path}, nil
+}
+
+func (r *countingResolver) count(ref string) int {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.calls[ref]
+}
+
+func TestCacheTTL(t *testing.T) {
+	r := &countingResolver{calls: map[string]int{}}
+	c := NewCache(r, time.Minute, 0)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	m := metrics.New()
+	in := Input{Ref: ast.MustParseRef("data.users"), Path: ast.Ref{ast.StringTerm("alice")}, Metrics: m}
+	other := Input{Ref: ast.MustParseRef("data.users"), Path: ast.Ref{ast.StringTerm("bob")}, Metrics: m}
+
+	for i := 0; i < 3; i++ {
+		result, err := c.Eval(context.Background(), in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Path.Equal(in.Path) {
+			t.Fatalf("expected path %v but got %v", in.Path, result.Path)
+		}
+	}
+	if _, err := c.Eval(context.Background(), other); err != nil {
+		t.Fatal(err)
+	}
+	if n := r.count(`data.users.alice`); n != 1 {
+		t.Fatalf("expected 1 call for alice but got %d", n)
+	}
+	if n := r.count(`data.users.bob`); n != 1 {
+		t.Fatalf("expected 1 call for bob but got %d", n)
+	}
+	if hits := m.Counter(cacheHitCounter).Value(); hits != uint64(2) {
+		t.Fatalf("expected 2 cache hits but got %v", hits)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := c.Eval(context.Background(), in); err != nil {
+		t.Fatal(err)
+	}
+	if n := r.count(`data.users.alice`); n != 2 {
+		t.Fatalf("expected alice to be fetched again after expiry but got %d calls", n)
+	}
+}
+
+func TestCacheErrorsNotCached(t *testing.T) {
+	r := &countingResolver{calls: map[string]int{}, err: errors.New("unavailable")}
+	c := NewCache(r, time.Minute, 0)
+	in := Input{Ref: ast.MustParseRef("data.users")}
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.Eval(context.Background(), in); err == nil || err.Error() != "unavailable" {
+			t.Fatalf("expected error but got %v", err)
+		}
+	}
+	if n := r.count("data.users"); n != 2 {
+		t.Fatalf("expected 2 calls but got %d", n)
+	}
+}
+
+func TestCacheMaxEntries(t *testing.T) {
+	r := &countingResolver{calls: map[string]int{}}
+	c := NewCache(r, time.Minute, 2)
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		in := Input{Ref: ast.MustParseRef("data.users"), Path: ast.Ref{ast.StringTerm(key)}}
+		if _, err := c.Eval(context.Background(), in); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(c.entries); n != 2 {
+		t.Fatalf("expected 2 entries but got %d", n)
+	}
+
+	c.Clear()
+	if n := len(c.entries); n != 0 {
+		t.Fatalf("expected no entries but got %d", n)
+	}
+}
+
+func TestCacheConcurrentMisses(t *testing.T) {
+	r := &countingResolver{calls: map[string]int{}, block: make(chan struct{})}
+	c := NewCache(r, time.Minute, 0)
+	in := Input{Ref: ast.MustParseRef("data.users")}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Eval(context.Background(), in); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	// Waiting evaluations stop waiting once their context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for {
+		c.mtx.Lock()
+		_, ok := c.entries["data.users"]
+		c.mtx.Unlock()
+		if ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := c.Eval(ctx, in); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled but got %v", err)
+	}
+
+	close(r.block)
+	wg.Wait()
+	if n := r.count("data.users"); n != 1 {
+		t.Fatalf("expected 1 call but got %d", n)
+	}
+}
@@ -2,6 +2,9 @@
 // Use of this source code is governed by an Apache2
 // license that can be found in the LICENSE file.
 
+// Package resolver defines the interface of external value resolvers, which
+// OPA evaluations call to read documents under the refs they are registered
+// for, instead of reading them from the store.
 package resolver
 
 import (
@@ -18,12 +21,22 @@ type Resolver interface {
 
 // Input as provided to a Resolver instance when evaluating.
 type Input struct {
-	Ref     ast.Ref
+	// Ref is the ref the Resolver is registered for.
+	Ref ast.Ref
+	// Path is the path of the referenced document relative to Ref. It is
+	// empty when the whole document under Ref is referenced.
+	Path    ast.Ref
 	Input   *ast.Term
 	Metrics metrics.Metrics
 }
 
 // Result of resolving a ref.
 type Result struct {
+	// Value is the document under Ref, or under Ref extended with Path.
 	Value ast.Value
+	// Path is the path relative to Ref of the document that Value holds, and
+	// must be a prefix of the Path of the Input. Resolvers that only fetch
+	// the referenced document set it to the Path of the Input; when it is
+	// empty, Value holds the whole document under Ref.
+	Path ast.Ref
 }
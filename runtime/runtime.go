@@ -85,17 +85,28 @@ type Params struct {
 	// the runtime will generate one.
 	ID string
 
-	// Addrs are the listening addresses that the OPA server will bind to.
+	// Addrs are the listening addresses that the OPA server will bind to. The
+	// addresses are TCP addresses, e.g. localhost:8181, UNIX domain sockets,
+	// e.g. unix:///run/opa.sock, or sockets passed by systemd socket
+	// activation, e.g. fd://<name>.
 	Addrs *[]string
 
 	// DiagnosticAddrs are the listening addresses that the OPA server will bind to
 	// for read-only diagnostic API's (/health, /metrics, etc)
 	DiagnosticAddrs *[]string
 
+	// GRPCAddrs are the listening addresses that the OPA server will bind to
+	// for the gRPC API.
+	GRPCAddrs *[]string
+
 	// H2CEnabled flag controls whether OPA will allow H2C (HTTP/2 cleartext) on
 	// HTTP listeners.
 	H2CEnabled bool
 
+	// InputSchemaValidation flag controls whether the inputs of the v1 data
+	// API are validated against the input schemas annotated on the policies.
+	InputSchemaValidation bool
+
 	// Authentication is the type of authentication scheme to use.
 	Authentication server.AuthenticationScheme
 
@@ -143,6 +154,10 @@ type Params struct {
 	// loading all data & policy files.
 	BundleMode bool
 
+	// ModuleCache, if set, is used to look up and store the modules parsed
+	// when loading the Paths on startup.
+	ModuleCache ast.ModuleCache
+
 	// Watch flag controls whether OPA will watch the Paths files for changes.
 	// If this flag is true, OPA will watch the Paths files for changes and
 	// reload the storage layer each time they change. This is useful for
@@ -156,6 +171,9 @@ type Params struct {
 	// PprofEnabled flag controls whether pprof endpoints are enabled
 	PprofEnabled bool
 
+	// BenchEnabled flag controls whether the benchmark endpoint is enabled
+	BenchEnabled bool
+
 	// DecisionIDFactory generates decision IDs to include in API responses
 	// sent by the server (in response to Data API queries.)
 	DecisionIDFactory func() string
@@ -220,6 +238,11 @@ type Params struct {
 	// It can also be enabled via config, and this runtime field takes precedence.
 	DiskStorage *disk.Options
 
+	// InmemStorage, if set, configures the in-memory store that the runtime
+	// instantiates if the disk store is not enabled. It can also be set via
+	// config, and this runtime field takes precedence.
+	InmemStorage *inmem.Config
+
 	DistributedTracingOpts tracing.Options
 
 	// Check if default Addr is set or the user has changed it.
@@ -265,6 +288,9 @@ type Runtime struct {
 	reporter      *report.Reporter
 	traceExporter *otlptrace.Exporter
 
+	metricsExporter      *internal_tracing.MetricsExporter
+	builtinSpanThreshold time.Duration
+
 	serverInitialized bool
 	serverInitMtx     sync.RWMutex
 	done              chan struct{}
@@ -343,10 +369,6 @@ func NewRuntime(ctx context.Context, params Params) (*Runtime, error) {
 	} else {
 		regoVersion = ast.RegoV0
 	}
-	loaded, err := initload.LoadPathsForRegoVersion(regoVersion, params.Paths, params.Filter, params.BundleMode, params.BundleVerificationConfig, params.SkipBundleVerification, false, nil, nil)
-	if err != nil {
-		return nil, fmt.Errorf("load error: %w", err)
-	}
 
 	isAuthorizationEnabled := params.Authorization != server.AuthorizationOff
 
@@ -386,13 +408,32 @@ func NewRuntime(ctx context.Context, params Params) (*Runtime, error) {
 			return nil, fmt.Errorf("initialize disk store: %w", err)
 		}
 	} else {
-		store = inmem.NewWithOpts(inmem.OptRoundTripOnWrite(false))
+		if params.InmemStorage == nil {
+			params.InmemStorage, err = inmem.ConfigFromRaw(config, params.ID)
+			if err != nil {
+				return nil, fmt.Errorf("parse inmem store configuration: %w", err)
+			}
+		}
+
+		opts := []inmem.Opt{inmem.OptRoundTripOnWrite(false)}
+		if params.InmemStorage != nil {
+			opts = append(opts, params.InmemStorage.Opts()...)
+		}
+		store = inmem.NewWithOpts(opts...)
 	}
 
 	traceExporter, tracerProvider, err := internal_tracing.Init(ctx, config, params.ID)
 	if err != nil {
 		return nil, fmt.Errorf("config error: %w", err)
 	}
+	metricsExporter, err := internal_tracing.InitMetrics(config, params.ID, metrics, logger)
+	if err != nil {
+		return nil, fmt.Errorf("config error: %w", err)
+	}
+	builtinSpanThreshold, err := internal_tracing.BuiltinSpanThreshold(config, params.ID)
+	if err != nil {
+		return nil, fmt.Errorf("config error: %w", err)
+	}
 	if tracerProvider != nil {
 		params.DistributedTracingOpts = tracing.NewOptions(
 			otelhttp.WithTracerProvider(tracerProvider),
@@ -400,6 +441,15 @@ func NewRuntime(ctx context.Context, params Params) (*Runtime, error) {
 		)
 	}
 
+	// Annotations declare the input schemas and the span attributes of the
+	// decisions, so they are only processed if either is used.
+	processAnnotations := params.InputSchemaValidation || len(params.DistributedTracingOpts) > 0
+
+	loaded, err := initload.LoadPathsForRegoVersion(regoVersion, params.Paths, params.Filter, params.BundleMode, params.BundleVerificationConfig, params.SkipBundleVerification, processAnnotations, nil, nil, params.ModuleCache)
+	if err != nil {
+		return nil, fmt.Errorf("load error: %w", err)
+	}
+
 	manager, err := plugins.New(config,
 		params.ID,
 		store,
@@ -416,7 +466,7 @@ func NewRuntime(ctx context.Context, params Params) (*Runtime, error) {
 		plugins.WithPrometheusRegister(metrics),
 		plugins.WithTracerProvider(tracerProvider),
 		plugins.WithEnableTelemetry(params.EnableVersionCheck),
-		plugins.WithParserOptions(ast.ParserOptions{RegoVersion: regoVersion}))
+		plugins.WithParserOptions(ast.ParserOptions{RegoVersion: regoVersion, ProcessAnnotation: processAnnotations}))
 	if err != nil {
 		return nil, fmt.Errorf("config error: %w", err)
 	}
@@ -445,14 +495,16 @@ func NewRuntime(ctx context.Context, params Params) (*Runtime, error) {
 	manager.Register(discovery.Name, disco)
 
 	rt := &Runtime{
-		Store:             manager.Store,
-		Params:            params,
-		Manager:           manager,
-		logger:            logger,
-		metrics:           metrics,
-		reporter:          reporter,
-		serverInitialized: false,
-		traceExporter:     traceExporter,
+		Store:                manager.Store,
+		Params:               params,
+		Manager:              manager,
+		logger:               logger,
+		metrics:              metrics,
+		reporter:             reporter,
+		serverInitialized:    false,
+		traceExporter:        traceExporter,
+		metricsExporter:      metricsExporter,
+		builtinSpanThreshold: builtinSpanThreshold,
 	}
 
 	return rt, nil
@@ -490,7 +542,7 @@ func (rt *Runtime) StartServer(ctx context.Context) {
 
 // Serve will start a new REST API server and listen for requests. This
 // will block until either: an error occurs, the context is canceled, or
-// a SIGTERM or SIGKILL signal is sent.
+// a SIGTERM or SIGKILL signal is sent, or a drain is requested.
 func (rt *Runtime) Serve(ctx context.Context) error {
 	if rt.Params.Addrs == nil {
 		return fmt.Errorf("at least one address must be configured in runtime parameters")
@@ -505,12 +557,17 @@ func (rt *Runtime) Serve(ctx context.Context) error {
 		rt.Params.DiagnosticAddrs = &[]string{}
 	}
 
-	rt.logger.WithFields(map[string]interface{}{
+	fields := map[string]interface{}{
 		"addrs":            *rt.Params.Addrs,
 		"diagnostic-addrs": *rt.Params.DiagnosticAddrs,
-	}).Info(serverInitializingMessage)
+	}
+	if rt.Params.GRPCAddrs != nil && len(*rt.Params.GRPCAddrs) > 0 {
+		fields["grpc-addrs"] = *rt.Params.GRPCAddrs
+	}
+
+	rt.logger.WithFields(fields).Info(serverInitializingMessage)
 
-	if rt.Params.Authorization == server.AuthorizationOff && rt.Params.Authentication == server.AuthenticationToken {
+	if rt.Params.Authorization == server.AuthorizationOff && (rt.Params.Authentication == server.AuthenticationToken || rt.Params.Authentication == server.AuthenticationJWT) {
 		rt.logger.Error("Token authentication enabled without authorization. Authentication will be ineffective. See https://www.openpolicyagent.org/docs/latest/security/#authentication-and-authorization for more information.")
 	}
 
@@ -542,14 +599,23 @@ func (rt *Runtime) Serve(ctx context.Context) error {
 		}
 	}
 
+	if rt.metricsExporter != nil {
+		if err := rt.metricsExporter.Start(ctx); err != nil {
+			rt.logger.WithFields(map[string]interface{}{"err": err}).Error("Failed to start OpenTelemetry metrics exporter.")
+			return err
+		}
+	}
+
 	rt.server = server.New().
 		WithRouter(rt.Params.Router).
 		WithStore(rt.Store).
 		WithManager(rt.Manager).
 		WithCompilerErrorLimit(rt.Params.ErrorLimit).
 		WithPprofEnabled(rt.Params.PprofEnabled).
+		WithBenchEnabled(rt.Params.BenchEnabled).
 		WithAddresses(*rt.Params.Addrs).
 		WithH2CEnabled(rt.Params.H2CEnabled).
+		WithInputSchemaValidation(rt.Params.InputSchemaValidation).
 		// always use the initial values for the certificate and ca pool, reloading behavior is configured below
 		WithCertificate(rt.Params.Certificate).
 		WithCertPool(rt.Params.CertPool).
@@ -561,7 +627,8 @@ func (rt *Runtime) Serve(ctx context.Context) error {
 		WithMetrics(rt.metrics).
 		WithMinTLSVersion(rt.Params.MinTLSVersion).
 		WithCipherSuites(rt.Params.CipherSuites).
-		WithDistributedTracingOpts(rt.Params.DistributedTracingOpts)
+		WithDistributedTracingOpts(rt.Params.DistributedTracingOpts).
+		WithBuiltinSpanThreshold(rt.builtinSpanThreshold)
 
 	// If decision_logging plugin enabled, check to see if we opted in to the ND builtins cache.
 	if lp := logs.Lookup(rt.Manager); lp != nil {
@@ -572,6 +639,10 @@ func (rt *Runtime) Serve(ctx context.Context) error {
 		rt.server = rt.server.WithDiagnosticAddresses(*rt.Params.DiagnosticAddrs)
 	}
 
+	if rt.Params.GRPCAddrs != nil {
+		rt.server = rt.server.WithGRPCAddresses(*rt.Params.GRPCAddrs)
+	}
+
 	if rt.Params.UnixSocketPerm != nil {
 		rt.server = rt.server.WithUnixSocketPermission(rt.Params.UnixSocketPerm)
 	}
@@ -649,6 +720,15 @@ func (rt *Runtime) Serve(ctx context.Context) error {
 	signalc := make(chan os.Signal, 1)
 	signal.Notify(signalc, syscall.SIGINT, syscall.SIGTERM)
 
+	// SIGHUP reloads the TLS certificate and CA cert pool files, e.g. after
+	// they have been rotated where file changes are not observed.
+	var hupc chan os.Signal
+	if rt.Params.CertificateFile != "" || rt.Params.CertPoolFile != "" {
+		hupc = make(chan os.Signal, 1)
+		signal.Notify(hupc, syscall.SIGHUP)
+		defer signal.Stop(hupc)
+	}
+
 	// Note that there is a small chance the socket of the server listener is still
 	// closed by the time this block is executed, due to the serverLoop above
 	// executing in a goroutine.
@@ -665,6 +745,15 @@ func (rt *Runtime) Serve(ctx context.Context) error {
 			return rt.gracefulServerShutdown(rt.server)
 		case <-signalc:
 			return rt.gracefulServerShutdown(rt.server)
+		case <-rt.server.DrainRequested():
+			rt.logger.Info("Drain requested.")
+			return rt.gracefulServerShutdown(rt.server)
+		case <-hupc:
+			if err := rt.server.ReloadTLSConfig(); err != nil {
+				rt.logger.WithFields(map[string]interface{}{"err": err}).Error("Failed to reload TLS config.")
+			} else {
+				rt.logger.Info("TLS config reloaded.")
+			}
 		case err := <-errc:
 			rt.logger.WithFields(map[string]interface{}{"err": err}).Error("Listener failed.")
 			os.Exit(1)
@@ -696,6 +785,17 @@ func (rt *Runtime) DiagnosticAddrs() []string {
 	return rt.server.DiagnosticAddrs()
 }
 
+// GRPCAddrs returns a list of addresses that the runtime is listening on for
+// the gRPC API (when in server mode). Returns an empty list if it hasn't
+// started listening.
+func (rt *Runtime) GRPCAddrs() []string {
+	if rt.server == nil {
+		return nil
+	}
+
+	return rt.server.GRPCAddrs()
+}
+
 // StartREPL starts the runtime in REPL mode. This function will block the calling goroutine.
 func (rt *Runtime) StartREPL(ctx context.Context) {
 	if err := rt.Manager.Start(ctx); err != nil {
@@ -842,6 +942,10 @@ func (rt *Runtime) getBanner() string {
 }
 
 func (rt *Runtime) gracefulServerShutdown(s *server.Server) error {
+	// The server reports itself as draining in the health API during the
+	// shutdown wait period, but keeps serving decisions.
+	s.RequestDrain()
+
 	if rt.Params.ShutdownWaitPeriod > 0 {
 		rt.logger.Info("Waiting %vs before initiating shutdown...", rt.Params.ShutdownWaitPeriod)
 		time.Sleep(time.Duration(rt.Params.ShutdownWaitPeriod) * time.Second)
@@ -850,6 +954,13 @@ func (rt *Runtime) gracefulServerShutdown(s *server.Server) error {
 	rt.logger.Info("Shutting down...")
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(rt.Params.GracefulShutdownPeriod)*time.Second)
 	defer cancel()
+
+	if err := s.Drain(ctx); err != nil {
+		rt.logger.WithFields(map[string]interface{}{"err": err}).Warn("Failed to drain decisions in flight.")
+	} else {
+		rt.logger.Info("Decisions drained.")
+	}
+
 	err := s.Shutdown(ctx)
 	if err != nil {
 		rt.logger.WithFields(map[string]interface{}{"err": err}).Error("Failed to shutdown server gracefully.")
@@ -863,6 +974,13 @@ func (rt *Runtime) gracefulServerShutdown(s *server.Server) error {
 			rt.logger.WithFields(map[string]interface{}{"err": err}).Error("Failed to shutdown OpenTelemetry trace exporter gracefully.")
 		}
 	}
+
+	if rt.metricsExporter != nil {
+		err = rt.metricsExporter.Shutdown(ctx)
+		if err != nil {
+			rt.logger.WithFields(map[string]interface{}{"err": err}).Error("Failed to shutdown OpenTelemetry metrics exporter gracefully.")
+		}
+	}
 	return nil
 }
 
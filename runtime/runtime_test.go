@@ -763,6 +763,38 @@ func TestServerInitialized(t *testing.T) {
 	}
 }
 
+func TestServeDrainRequested(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	params := NewParams()
+	params.Addrs = &[]string{"localhost:0"}
+	params.GracefulShutdownPeriod = 1
+	params.Logger = logging.NewNoOpLogger()
+
+	rt, err := NewRuntime(ctx, params)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- rt.Serve(ctx)
+	}()
+
+	<-rt.Manager.ServerInitializedChannel()
+	rt.server.RequestDrain()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("expected server to shut down once drained")
+	}
+}
+
 func TestServerInitializedWithRegoV1(t *testing.T) {
 	tests := []struct {
 		note         string
@@ -1475,3 +1507,37 @@ func TestRuntimeWithExplicitBadMetricConfiguration(t *testing.T) {
 		}
 	})
 }
+
+func TestRuntimeWithInmemSnapshotReads(t *testing.T) {
+	fs := map[string]string{
+		"/config.yaml": `{"storage": {"inmem": {"snapshot_reads": true}}}`,
+	}
+
+	test.WithTempFS(fs, func(testDirRoot string) {
+		ctx := context.Background()
+		params := NewParams()
+		params.ConfigFile = filepath.Join(testDirRoot, "/config.yaml")
+
+		rt, err := NewRuntime(ctx, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		txn := storage.NewTransactionOrDie(ctx, rt.Store)
+		defer rt.Store.Abort(ctx, txn)
+
+		done := make(chan error)
+		go func() {
+			done <- storage.WriteOne(ctx, rt.Store, storage.AddOp, storage.MustParsePath("/x"), 1)
+		}()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected write not to wait for the open read transaction")
+		}
+	})
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// activation holds the sockets passed to the process by systemd socket
+// activation, see sd_listen_fds(3).
+var activation = &activatedSockets{start: 3}
+
+type activatedSockets struct {
+	start     int // first file descriptor
+	once      sync.Once
+	mtx       sync.Mutex
+	listeners []net.Listener
+	names     []string
+	err       error
+}
+
+// load takes over the file descriptors of the activated sockets.
+func (a *activatedSockets) load() error {
+	a.once.Do(func() {
+		if pid := os.Getenv("LISTEN_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+			return
+		}
+
+		n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		if err != nil || n < 1 {
+			return
+		}
+
+		a.names = strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+		a.listeners = make([]net.Listener, n)
+
+		for i := range a.listeners {
+			f := os.NewFile(uintptr(a.start+i), "LISTEN_FD_"+strconv.Itoa(a.start+i))
+			// NOTE: The listener uses a duplicate of the file descriptor, so
+			// the original one is closed.
+			a.listeners[i], err = net.FileListener(f)
+			f.Close()
+			if err != nil {
+				a.err = fmt.Errorf("activated socket %d: %w", i, err)
+				return
+			}
+		}
+	})
+	return a.err
+}
+
+// listen returns the listener of the activated socket selected by the host of
+// u: fd:// selects the first socket, fd://<n> the socket at index n, and
+// fd://<name> the socket named with FileDescriptorName=<name> in the socket
+// unit. Each socket can only be selected once.
+func (a *activatedSockets) listen(u *url.URL) (net.Listener, error) {
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	selector := u.Host + u.Path
+	index := -1
+	switch i, err := strconv.Atoi(selector); {
+	case selector == "":
+		index = 0
+	case err == nil:
+		index = i
+	default:
+		for i, name := range a.names {
+			if name == selector {
+				index = i
+				break
+			}
+		}
+	}
+
+	if index < 0 || index >= len(a.listeners) {
+		return nil, fmt.Errorf("no activated socket for address %v", u)
+	}
+
+	l := a.listeners[index]
+	if l == nil {
+		return nil, fmt.Errorf("activated socket for address %v is already in use", u)
+	}
+	a.listeners[index] = nil
+	return l, nil
+}
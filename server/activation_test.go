@@ -0,0 +1,73 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestActivatedSocketListener(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("socket activation is not supported on windows")
+	}
+
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	file, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev := activation
+	activation = &activatedSockets{start: int(file.Fd())}
+	t.Cleanup(func() { activation = prev })
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_FDNAMES", "opa")
+
+	f := newFixture(t, func(s *Server) {
+		s.WithAddresses([]string{"fd://opa"})
+	})
+
+	loops, err := f.server.Listeners()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, loop := range loops {
+		go func(loop Loop) { _ = loop() }(loop)
+	}
+	defer f.server.Shutdown(context.Background())
+
+	resp, err := http.Get("http://" + l.Addr().String() + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 but got: %v", resp.StatusCode)
+	}
+
+	for addr, msg := range map[string]string{
+		"fd://opa":   "already in use",
+		"fd://1":     "no activated socket",
+		"fd://other": "no activated socket",
+	} {
+		if _, _, err := f.server.getListener(addr, f.server.Handler, defaultListenerType); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%v: expected error %q but got: %v", addr, msg, err)
+		}
+	}
+}
@@ -76,8 +76,15 @@ func InterQueryCache(interQueryCache cache.InterQueryCache) func(*Basic) {
 
 // NewBasic returns a new Basic object.
 func NewBasic(inner http.Handler, compiler func() *ast.Compiler, store storage.Store, opts ...func(*Basic)) http.Handler {
+	b := New(compiler, store, opts...)
+	b.inner = inner
+	return b
+}
+
+// New returns a new Basic object that authorizes requests with Authorize,
+// e.g., requests received by other transports than HTTP.
+func New(compiler func() *ast.Compiler, store storage.Store, opts ...func(*Basic)) *Basic {
 	b := &Basic{
-		inner:    inner,
 		compiler: compiler,
 		store:    store,
 	}
@@ -99,6 +106,18 @@ func (h *Basic) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if status, err := h.Authorize(r.Context(), input); err != nil {
+		writer.Error(w, status, err)
+		return
+	}
+
+	h.inner.ServeHTTP(w, r)
+}
+
+// Authorize evaluates the authorization decision for the input document of a
+// request. If the request is not allowed, the status and the error of the
+// response rejecting it are returned.
+func (h *Basic) Authorize(ctx context.Context, input interface{}) (int, *types.ErrorV1) {
 	rego := rego.New(
 		rego.Query(h.decision().String()),
 		rego.Compiler(h.compiler()),
@@ -110,44 +129,38 @@ func (h *Basic) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		rego.InterQueryBuiltinCache(h.interQueryCache),
 	)
 
-	rs, err := rego.Eval(r.Context())
+	rs, err := rego.Eval(ctx)
 
 	if err != nil {
-		writer.ErrorAuto(w, err)
-		return
+		return writer.AutoError(err)
 	}
 
 	if len(rs) == 0 {
 		// Authorizer was configured but no policy defined. This indicates an internal error or misconfiguration.
-		writer.Error(w, http.StatusInternalServerError, types.NewErrorV1(types.CodeInternal, types.MsgUnauthorizedUndefinedError))
-		return
+		return http.StatusInternalServerError, types.NewErrorV1(types.CodeInternal, types.MsgUnauthorizedUndefinedError)
 	}
 
 	switch allowed := rs[0].Expressions[0].Value.(type) {
 	case bool:
 		if allowed {
-			h.inner.ServeHTTP(w, r)
-			return
+			return http.StatusOK, nil
 		}
 	case map[string]interface{}:
 		if decision, ok := allowed["allowed"]; ok {
 			if allow, ok := decision.(bool); ok && allow {
-				h.inner.ServeHTTP(w, r)
-				return
+				return http.StatusOK, nil
 			}
 			if reason, ok := allowed["reason"]; ok {
 				message, ok := reason.(string)
 				if ok {
-					writer.Error(w, http.StatusUnauthorized, types.NewErrorV1(types.CodeUnauthorized, message))
-					return
+					return http.StatusUnauthorized, types.NewErrorV1(types.CodeUnauthorized, message)
 				}
 			}
 		} else {
-			writer.Error(w, http.StatusInternalServerError, types.NewErrorV1(types.CodeInternal, types.MsgUndefinedError))
-			return
+			return http.StatusInternalServerError, types.NewErrorV1(types.CodeInternal, types.MsgUndefinedError)
 		}
 	}
-	writer.Error(w, http.StatusUnauthorized, types.NewErrorV1(types.CodeUnauthorized, types.MsgUnauthorizedError))
+	return http.StatusUnauthorized, types.NewErrorV1(types.CodeUnauthorized, types.MsgUnauthorizedError)
 }
 
 func makeInput(r *http.Request) (*http.Request, interface{}, error) {
@@ -199,6 +212,11 @@ func makeInput(r *http.Request) (*http.Request, interface{}, error) {
 		input["identity"] = identity
 	}
 
+	identityClaims, ok := identifier.IdentityClaims(r)
+	if ok {
+		input["identity_claims"] = identityClaims
+	}
+
 	clientCertificates, ok := identifier.ClientCertificates(r)
 	if ok {
 		input["client_certificates"] = clientCertificates
@@ -220,6 +238,9 @@ func expectBody(method string, path []interface{}) bool {
 		} else if len(path) >= 2 {
 			s1 := path[0].(string)
 			s2 := path[1].(string)
+			if s1 == "v1" && (s2 == "batch" || s2 == "subscribe") && len(path) >= 3 {
+				return path[2].(string) == "data"
+			}
 			return dataAPIVersions[s1] && s2 == "data"
 		}
 	}
@@ -311,6 +311,7 @@ func TestMakeInput(t *testing.T) {
 	req.URL.RawQuery = query.Encode()
 
 	req = identifier.SetIdentity(req, "bob")
+	req = identifier.SetIdentityClaims(req, map[string]interface{}{"sub": "bob"})
 
 	_, result, err := makeInput(req)
 	if err != nil {
@@ -322,6 +323,7 @@ func TestMakeInput(t *testing.T) {
 		  "path": ["foo","bar"],
 		  "method": "GET",
 		  "identity": "bob",
+		  "identity_claims": {"sub": "bob"},
 		  "headers": {
 			"X-Custom": ["foo", "bar"],
 			"X-Custom-2": ["baz"],
@@ -373,6 +375,12 @@ func TestMakeInputWithBody(t *testing.T) {
 			body:             `{"foo": "bar"}`,
 			assertBodyExists: true,
 		},
+		{
+			method:           "POST",
+			path:             "/v1/batch/data",
+			body:             `{"inputs": []}`,
+			assertBodyExists: true,
+		},
 		{
 			method:                 "PUT",
 			path:                   "/v1/data",
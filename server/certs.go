@@ -27,6 +27,13 @@ func (s *Server) getCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error
 	return s.cert, nil
 }
 
+// ReloadTLSConfig reloads the certificate, key and CA cert pool files of the
+// server, if their contents have changed. New connections are served with the
+// reloaded files, established connections are not interrupted.
+func (s *Server) ReloadTLSConfig() error {
+	return s.reloadTLSConfig(s.manager.Logger())
+}
+
 // reloadTLSConfig reloads the TLS config if the cert, key files or cert pool contents have changed.
 func (s *Server) reloadTLSConfig(logger logging.Logger) error {
 	s.tlsConfigMtx.Lock()
@@ -0,0 +1,133 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed certificate with the serial number and
+// its key to the files.
+func writeCertificate(t *testing.T, serial int64, certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	caFile := filepath.Join(dir, "ca.pem")
+	caKeyFile := filepath.Join(dir, "ca-key.pem")
+
+	writeCertificate(t, 1, certFile, keyFile)
+	writeCertificate(t, 100, caFile, caKeyFile)
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := newFixture(t, func(s *Server) {
+		s.WithCertificate(&cert).WithTLSConfig(&TLSConfig{
+			CertFile:     certFile,
+			KeyFile:      keyFile,
+			CertPoolFile: caFile,
+		})
+	})
+
+	serial := func() int64 {
+		cert, err := f.server.getCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf.SerialNumber.Int64()
+	}
+
+	pool := func() *x509.CertPool {
+		cfg, err := f.server.serverTLSConfig().GetConfigForClient(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cfg.ClientCAs
+	}
+
+	if err := f.server.ReloadTLSConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if serial() != 1 {
+		t.Fatalf("Expected certificate 1 but got %d", serial())
+	}
+	initialPool := pool()
+
+	writeCertificate(t, 2, certFile, keyFile)
+	writeCertificate(t, 200, caFile, caKeyFile)
+
+	if err := f.server.ReloadTLSConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if serial() != 2 {
+		t.Fatalf("Expected reloaded certificate 2 but got %d", serial())
+	}
+	if pool().Equal(initialPool) {
+		t.Fatal("Expected reloaded CA cert pool")
+	}
+
+	// Invalid files are not loaded, the previous certificate remains in use.
+	if err := os.WriteFile(certFile, []byte("invalid"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.server.ReloadTLSConfig(); err == nil {
+		t.Fatal("Expected error")
+	}
+	if serial() != 2 {
+		t.Fatalf("Expected certificate 2 but got %d", serial())
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/open-policy-agent/opa/server/types"
+	"github.com/open-policy-agent/opa/server/writer"
+)
+
+// errDraining is returned for the decisions requested while the server drains.
+var errDraining = errors.New("server is draining")
+
+// drainer tracks the decisions in flight, and rejects new decisions once the
+// server drains.
+type drainer struct {
+	mtx       sync.Mutex
+	inFlight  int
+	requested chan struct{} // closed once a drain is requested
+	rejecting bool          // set once the server drains
+	idle      chan struct{} // closed once no decision is in flight while rejecting
+}
+
+func newDrainer() *drainer {
+	return &drainer{requested: make(chan struct{})}
+}
+
+// begin admits a new decision, unless the server is draining. end must be
+// called once the decision is complete.
+func (d *drainer) begin() bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.rejecting {
+		return false
+	}
+	d.inFlight++
+	return true
+}
+
+func (d *drainer) end() {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.inFlight--
+	if d.rejecting && d.inFlight == 0 {
+		close(d.idle)
+	}
+}
+
+func (d *drainer) request() {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	select {
+	case <-d.requested:
+	default:
+		close(d.requested)
+	}
+}
+
+// reject rejects new decisions, and returns a channel that is closed once no
+// decision is in flight.
+func (d *drainer) reject() <-chan struct{} {
+	d.request()
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if !d.rejecting {
+		d.rejecting = true
+		d.idle = make(chan struct{})
+		if d.inFlight == 0 {
+			close(d.idle)
+		}
+	}
+	return d.idle
+}
+
+// drain rejects new decisions, and waits until the decisions in flight are
+// complete or ctx is done.
+func (d *drainer) drain(ctx context.Context) error {
+	select {
+	case <-d.reject():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// status returns whether a drain was requested, and the number of decisions
+// in flight.
+func (d *drainer) status() (bool, int) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	select {
+	case <-d.requested:
+		return true, d.inFlight
+	default:
+		return false, d.inFlight
+	}
+}
+
+// RequestDrain marks the server as draining. The Health API reports the server
+// as unhealthy from then on, so that no new traffic is routed to it, and the
+// channel returned by DrainRequested is closed. Decisions are still served
+// until Drain is called.
+func (s *Server) RequestDrain() {
+	s.drainer.request()
+}
+
+// DrainRequested returns a channel that is closed once a drain is requested,
+// through RequestDrain or the Drain API.
+func (s *Server) DrainRequested() <-chan struct{} {
+	return s.drainer.requested
+}
+
+// Drain rejects new decisions and subscriptions, ends the streams of the
+// subscriptions, and waits until the decisions in flight are complete or ctx is
+// done. It returns the error of ctx if the decisions in flight did not complete
+// in time.
+func (s *Server) Drain(ctx context.Context) error {
+	s.drainer.reject()
+	s.subscriptions.close()
+	return s.drainer.drain(ctx)
+}
+
+// trackDecisions returns a handler that tracks the decisions in flight while
+// calling handler, and rejects the decisions requested while the server is
+// draining with status 503. Subscriptions are in flight until their stream
+// ends.
+func (s *Server) trackDecisions(handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.drainer.begin() {
+			writer.ErrorString(w, http.StatusServiceUnavailable, types.CodeUnavailable, errDraining)
+			return
+		}
+		defer s.drainer.end()
+
+		handler(w, r)
+	}
+}
+
+func (s *Server) v1DrainPost(w http.ResponseWriter, r *http.Request) {
+	s.RequestDrain()
+	_, inFlight := s.drainer.status()
+	writer.JSON(w, http.StatusAccepted, types.DrainResponseV1{Result: types.DrainV1{Draining: true, InFlightDecisions: inFlight}}, pretty(r))
+}
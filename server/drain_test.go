@@ -0,0 +1,165 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestDrainerWaitsForDecisionsInFlight(t *testing.T) {
+	d := newDrainer()
+
+	if !d.begin() {
+		t.Fatal("Expected decision to be admitted")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := d.drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected drain to time out but got %v", err)
+	}
+
+	if d.begin() {
+		t.Fatal("Expected decision to be rejected while draining")
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- d.drain(context.Background())
+	}()
+
+	d.end()
+
+	if err := <-done; err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	if draining, inFlight := d.status(); !draining || inFlight != 0 {
+		t.Fatalf("Unexpected status: draining %v, in flight %d", draining, inFlight)
+	}
+}
+
+func TestDrainAPI(t *testing.T) {
+	f := newFixture(t)
+
+	if err := f.v1(http.MethodPut, "/data/test", `{"p": 1}`, 204, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.executeRequest(newReqUnversioned(http.MethodGet, "/health", ""), 200, `{}`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.v1(http.MethodPost, "/drain", "", 202, `{"result": {"draining": true, "in_flight_decisions": 0}}`); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-f.server.DrainRequested():
+	default:
+		t.Fatal("Expected drain to be requested")
+	}
+
+	// The server reports itself as draining, but keeps serving decisions until
+	// it is drained.
+	if err := f.executeRequest(newReqUnversioned(http.MethodGet, "/health", ""), 500, `{
+		"error": "server is draining",
+		"drain": {"draining": true, "in_flight_decisions": 0}
+	}`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.v1(http.MethodGet, "/data/test/p", "", 200, `{"result": 1}`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.server.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.v1(http.MethodGet, "/data/test/p", "", 503, `{"code": "unavailable", "message": "server is draining"}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.v1(http.MethodPost, "/data/test/p", "", 503, `{"code": "unavailable", "message": "server is draining"}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.v1(http.MethodPost, "/subscribe/data/test/p", "", 503, `{"code": "unavailable", "message": "server is draining"}`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Other APIs are still served.
+	if err := f.v1(http.MethodGet, "/bundles", "", 200, `{"result": {}}`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDrainSubscriptions(t *testing.T) {
+	f := newFixture(t)
+
+	srv := httptest.NewServer(f.server.Handler)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/v1/subscribe/data/test/p", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	events := bufio.NewReader(resp.Body)
+	if _, err := events.ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+
+	// The subscription is in flight until its stream ends.
+	if _, inFlight := f.server.drainer.status(); inFlight != 1 {
+		t.Fatalf("Expected subscription to be in flight but got %d", inFlight)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := f.server.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		if _, err := events.ReadString('\n'); err != nil {
+			break
+		}
+	}
+}
+
+func TestDrainGRPCHealth(t *testing.T) {
+	f := newFixture(t)
+	conn := newGRPCClient(t, f.server)
+	ctx := context.Background()
+	health := grpc_health_v1.NewHealthClient(conn)
+
+	check := func(exp grpc_health_v1.HealthCheckResponse_ServingStatus) {
+		t.Helper()
+		resp, err := health.Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != exp {
+			t.Fatalf("Expected %v but got: %v", exp, resp.Status)
+		}
+	}
+
+	check(grpc_health_v1.HealthCheckResponse_SERVING)
+
+	f.server.RequestDrain()
+	check(grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+}
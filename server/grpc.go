@@ -0,0 +1,739 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/logging"
+	"github.com/open-policy-agent/opa/metrics"
+	"github.com/open-policy-agent/opa/plugins"
+	"github.com/open-policy-agent/opa/server/authorizer"
+	"github.com/open-policy-agent/opa/server/identifier"
+	"github.com/open-policy-agent/opa/server/pb"
+	"github.com/open-policy-agent/opa/server/types"
+	"github.com/open-policy-agent/opa/server/writer"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/util"
+)
+
+// grpcServer implements the services of the gRPC API. The services are
+// equivalent to the REST API, and share its authorization, decision limits and
+// decision logging.
+type grpcServer struct {
+	pb.UnimplementedDataServer
+	pb.UnimplementedQueryServer
+	pb.UnimplementedPolicyServer
+	grpc_health_v1.UnimplementedHealthServer
+
+	s *Server
+}
+
+func (s *Server) newGRPCServer() *grpc.Server {
+	var opts []grpc.ServerOption
+
+	if s.cert != nil {
+		config := s.serverTLSConfig()
+		getConfigForClient := config.GetConfigForClient
+		config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg, err := getConfigForClient(hello)
+			if err != nil {
+				return nil, err
+			}
+			cfg.NextProtos = []string{"h2"}
+			return cfg, nil
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(config)))
+	}
+
+	var authz *authorizer.Basic
+	if s.authorization == AuthorizationBasic {
+		authz = authorizer.New(
+			s.getCompiler,
+			s.store,
+			authorizer.Runtime(s.runtime),
+			authorizer.Decision(s.manager.Config.DefaultAuthorizationDecisionRef),
+			authorizer.PrintHook(s.manager.PrintHook()),
+			authorizer.EnablePrintStatements(s.manager.EnablePrintStatements()),
+			authorizer.InterQueryCache(s.interQueryBuiltinCache))
+	}
+	opts = append(opts, grpc.UnaryInterceptor(s.grpcInterceptor(authz)))
+
+	srv := grpc.NewServer(opts...)
+	g := &grpcServer{s: s}
+	pb.RegisterDataServer(srv, g)
+	pb.RegisterQueryServer(srv, g)
+	pb.RegisterPolicyServer(srv, g)
+	grpc_health_v1.RegisterHealthServer(srv, g)
+	return srv
+}
+
+func (s *Server) getGRPCListener(addr string) (Loop, httpListener, error) {
+	parsedURL, err := parseURL(addr, s.cert != nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	l := &grpcListener{s: s.newGRPCServer()}
+
+	switch parsedURL.Scheme {
+	case "unix":
+		l.l, err = s.listenUNIXSocket(parsedURL)
+	case "fd":
+		l.l, err = activation.listen(parsedURL)
+	case "http":
+		l.address = parsedURL.Host
+	case "https":
+		if s.cert == nil {
+			return nil, nil, fmt.Errorf("TLS certificate required but not supplied")
+		}
+		l.address = parsedURL.Host
+	default:
+		return nil, nil, fmt.Errorf("invalid url scheme %q", parsedURL.Scheme)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return l.ListenAndServe, l, nil
+}
+
+// grpcListener serves the gRPC API on a single address. Unix domain sockets
+// and activated sockets are listened on before serving, and TCP addresses on
+// serving.
+type grpcListener struct {
+	s       *grpc.Server
+	l       net.Listener
+	address string
+	addr    string
+	addrMtx sync.RWMutex
+}
+
+var _ httpListener = (*grpcListener)(nil)
+
+func (g *grpcListener) Addr() string {
+	g.addrMtx.RLock()
+	defer g.addrMtx.RUnlock()
+	return g.addr
+}
+
+func (g *grpcListener) ListenAndServe() error {
+	if g.l != nil {
+		return g.s.Serve(g.l)
+	}
+
+	l, err := net.Listen("tcp", g.address)
+	if err != nil {
+		return err
+	}
+
+	g.addrMtx.Lock()
+	g.addr = l.Addr().String()
+	g.addrMtx.Unlock()
+
+	return g.s.Serve(l)
+}
+
+// ListenAndServeTLS serves the API like ListenAndServe. The TLS configuration
+// is part of the gRPC server.
+func (g *grpcListener) ListenAndServeTLS(string, string) error {
+	return g.ListenAndServe()
+}
+
+func (g *grpcListener) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		g.s.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		g.s.Stop()
+		return ctx.Err()
+	}
+}
+
+func (*grpcListener) Type() httpListenerType {
+	return grpcListenerType
+}
+
+// grpcInterceptor returns an interceptor that authorizes the calls with authz,
+// if set. The input document of the authorization decision is the one of the
+// equivalent REST API request.
+func (s *Server) grpcInterceptor(authz *authorizer.Basic) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if authz == nil {
+			return handler(ctx, req)
+		}
+
+		method, path, body := grpcRequestV1(req)
+		if method == "" {
+			return nil, status.Errorf(codes.PermissionDenied, "%v is not authorized", info.FullMethod)
+		}
+
+		headers := http.Header{}
+		md, _ := metadata.FromIncomingContext(ctx)
+		for k, vs := range md {
+			headers[http.CanonicalHeaderKey(k)] = vs
+		}
+
+		input := map[string]interface{}{
+			"path":    path,
+			"method":  method,
+			"params":  map[string][]string{},
+			"headers": headers,
+		}
+
+		if body != nil {
+			input["body"] = body
+		}
+
+		switch s.authentication {
+		case AuthenticationToken:
+			if token, ok := identifier.BearerToken(headers.Get("Authorization")); ok {
+				input["identity"] = token
+			}
+		case AuthenticationJWT:
+			if token, ok := identifier.BearerToken(headers.Get("Authorization")); ok {
+				claims, err := s.jwtVerifier.Verify(ctx, token)
+				if err != nil {
+					s.manager.Logger().Debug("Invalid bearer token: %v.", err)
+				} else {
+					input["identity"] = token
+					input["identity_claims"] = claims
+				}
+			}
+		case AuthenticationTLS:
+			if p, ok := peer.FromContext(ctx); ok {
+				if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+					if certs := info.State.PeerCertificates; len(certs) > 0 {
+						input["identity"] = certs[0].Subject.ToRDNSequence().String()
+						input["client_certificates"] = certs
+					}
+				}
+			}
+		}
+
+		if _, err := authz.Authorize(ctx, input); err != nil {
+			return nil, grpcError(err)
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// grpcRequestV1 returns the method, path and body of the REST API request
+// equivalent to req, or an empty method if there is none.
+func grpcRequestV1(req interface{}) (string, []interface{}, interface{}) {
+	path := func(prefix []interface{}, p string) []interface{} {
+		if p = strings.Trim(p, "/"); p != "" {
+			for _, part := range strings.Split(p, "/") {
+				prefix = append(prefix, part)
+			}
+		}
+		return prefix
+	}
+
+	switch req := req.(type) {
+	case *pb.GetDataRequest:
+		var body interface{}
+		if input, err := fromProtoValue(req.Input); err == nil && req.Input != nil {
+			body = map[string]interface{}{"input": input}
+		}
+		return http.MethodPost, path([]interface{}{"v1", "data"}, req.Path), body
+	case *pb.PutDataRequest:
+		return http.MethodPut, path([]interface{}{"v1", "data"}, req.Path), nil
+	case *pb.PatchDataRequest:
+		return http.MethodPatch, path([]interface{}{"v1", "data"}, req.Path), nil
+	case *pb.DeleteDataRequest:
+		return http.MethodDelete, path([]interface{}{"v1", "data"}, req.Path), nil
+	case *pb.QueryRequest:
+		return http.MethodPost, []interface{}{"v1", "query"}, nil
+	case *pb.ListPoliciesRequest:
+		return http.MethodGet, []interface{}{"v1", "policies"}, nil
+	case *pb.GetPolicyRequest:
+		return http.MethodGet, path([]interface{}{"v1", "policies"}, req.Id), nil
+	case *pb.PutPolicyRequest:
+		return http.MethodPut, path([]interface{}{"v1", "policies"}, req.Id), nil
+	case *pb.DeletePolicyRequest:
+		return http.MethodDelete, path([]interface{}{"v1", "policies"}, req.Id), nil
+	case *grpc_health_v1.HealthCheckRequest:
+		return http.MethodGet, []interface{}{"health"}, nil
+	}
+	return "", nil, nil
+}
+
+func (g *grpcServer) GetData(ctx context.Context, req *pb.GetDataRequest) (*pb.GetDataResponse, error) {
+	urlPath := strings.Trim(req.Path, "/")
+
+	if !g.s.drainer.begin() {
+		return nil, status.Error(codes.Unavailable, errDraining.Error())
+	}
+	defer g.s.drainer.end()
+
+	if limit := g.s.decisionLimits.find(urlPath); limit != nil {
+		var release func()
+		var err *limitError
+		ctx, release, err = limit.acquire(ctx)
+		if err != nil {
+			return nil, status.Error(codes.ResourceExhausted, err.msg)
+		}
+		defer release()
+	}
+
+	var goInput *interface{}
+	if req.Input != nil {
+		input, err := fromProtoValue(req.Input)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		goInput = &input
+	}
+
+	txn, state, err := g.s.newTransaction(ctx)
+	if err != nil {
+		return nil, grpcAutoError(err)
+	}
+
+	defer g.s.store.Abort(ctx, txn)
+
+	br, err := getRevisions(ctx, g.s.store, txn)
+	if err != nil {
+		return nil, grpcAutoError(err)
+	}
+
+	item := g.s.evalDecision(ctx, txn, state, g.s.getDecisionLogger(br), urlPath, goInput, req.StrictBuiltinErrors, req.Instrument, req.Metrics)
+	if item.Error != nil {
+		return nil, grpcError(item.Error)
+	}
+
+	resp := &pb.GetDataResponse{DecisionId: item.DecisionID}
+
+	if item.Result != nil {
+		if resp.Result, err = toProtoValue(*item.Result); err != nil {
+			return nil, grpcAutoError(err)
+		}
+	}
+
+	if item.Metrics != nil {
+		if resp.Metrics, err = toProtoStruct(item.Metrics); err != nil {
+			return nil, grpcAutoError(err)
+		}
+	}
+
+	if req.Provenance {
+		if resp.Provenance, err = toProtoStruct(g.s.getProvenance(br)); err != nil {
+			return nil, grpcAutoError(err)
+		}
+	}
+
+	return resp, nil
+}
+
+func (g *grpcServer) PutData(ctx context.Context, req *pb.PutDataRequest) (*pb.PutDataResponse, error) {
+	m := metrics.New()
+	m.Timer(metrics.ServerHandler).Start()
+
+	value, err := fromProtoValue(req.Value)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	path, ok := storage.ParsePathEscaped("/" + strings.Trim(req.Path, "/"))
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "bad path: %v", req.Path)
+	}
+
+	if _, err := g.s.putData(ctx, m, path, value, false); err != nil {
+		return nil, grpcError(err)
+	}
+
+	m.Timer(metrics.ServerHandler).Stop()
+
+	resp := &pb.PutDataResponse{}
+	if req.Metrics {
+		resp.Metrics, err = toProtoStruct(m.All())
+	}
+	return resp, grpcAutoError(err)
+}
+
+func (g *grpcServer) PatchData(ctx context.Context, req *pb.PatchDataRequest) (*pb.PatchDataResponse, error) {
+	m := metrics.New()
+	m.Timer(metrics.ServerHandler).Start()
+
+	ops := make([]types.PatchV1, len(req.Operations))
+	for i, op := range req.Operations {
+		value, err := fromProtoValue(op.Value)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		ops[i] = types.PatchV1{Op: op.Op, Path: op.Path, Value: value}
+	}
+
+	if _, err := g.s.patchData(ctx, m, req.Path, ops); err != nil {
+		return nil, grpcError(err)
+	}
+
+	m.Timer(metrics.ServerHandler).Stop()
+
+	resp := &pb.PatchDataResponse{}
+	var err error
+	if req.Metrics {
+		resp.Metrics, err = toProtoStruct(m.All())
+	}
+	return resp, grpcAutoError(err)
+}
+
+func (g *grpcServer) DeleteData(ctx context.Context, req *pb.DeleteDataRequest) (*pb.DeleteDataResponse, error) {
+	m := metrics.New()
+	m.Timer(metrics.ServerHandler).Start()
+
+	path, ok := storage.ParsePathEscaped("/" + strings.Trim(req.Path, "/"))
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "bad path: %v", req.Path)
+	}
+
+	if _, err := g.s.deleteData(ctx, m, path); err != nil {
+		return nil, grpcError(err)
+	}
+
+	m.Timer(metrics.ServerHandler).Stop()
+
+	resp := &pb.DeleteDataResponse{}
+	var err error
+	if req.Metrics {
+		resp.Metrics, err = toProtoStruct(m.All())
+	}
+	return resp, grpcAutoError(err)
+}
+
+func (g *grpcServer) Query(ctx context.Context, req *pb.QueryRequest) (*pb.QueryResponse, error) {
+	m := metrics.New()
+	m.Timer(metrics.ServerHandler).Start()
+
+	decisionID := g.s.generateDecisionID()
+	ctx = logging.WithDecisionID(ctx, decisionID)
+	annotateSpan(ctx, decisionID)
+
+	parsedQuery, err := validateQuery(req.Query)
+	if err != nil {
+		return nil, grpcQueryError(types.MsgParseQueryError, err)
+	}
+
+	var rawInput *interface{}
+	var input ast.Value
+	if req.Input != nil {
+		x, err := fromProtoValue(req.Input)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if input, err = inputValue(x, g.s.inputPool); err != nil {
+			return nil, grpcAutoError(err)
+		}
+		rawInput = &x
+	}
+
+	params := storage.TransactionParams{Context: storage.NewContext().WithMetrics(m)}
+	txn, err := g.s.store.NewTransaction(ctx, params)
+	if err != nil {
+		return nil, grpcAutoError(err)
+	}
+
+	defer g.s.store.Abort(ctx, txn)
+
+	br, err := getRevisions(ctx, g.s.store, txn)
+	if err != nil {
+		return nil, grpcAutoError(err)
+	}
+
+	results, err := g.s.execQuery(ctx, br, txn, parsedQuery, input, rawInput, m, types.ExplainOffV1, req.Metrics, req.Instrument, false)
+	if err != nil {
+		return nil, grpcQueryError(types.MsgCompileQueryError, err)
+	}
+
+	m.Timer(metrics.ServerHandler).Stop()
+
+	resp := &pb.QueryResponse{
+		Result: make([]*structpb.Struct, len(results.Result)),
+	}
+
+	for i, bindings := range results.Result {
+		v, err := toProtoValue(bindings)
+		if err != nil {
+			return nil, grpcAutoError(err)
+		}
+		resp.Result[i] = v.GetStructValue()
+	}
+
+	if req.Metrics || req.Instrument {
+		if resp.Metrics, err = toProtoStruct(m.All()); err != nil {
+			return nil, grpcAutoError(err)
+		}
+	}
+
+	return resp, nil
+}
+
+func (g *grpcServer) ListPolicies(ctx context.Context, _ *pb.ListPoliciesRequest) (*pb.ListPoliciesResponse, error) {
+	txn, err := g.s.store.NewTransaction(ctx)
+	if err != nil {
+		return nil, grpcAutoError(err)
+	}
+
+	defer g.s.store.Abort(ctx, txn)
+
+	ids, err := g.s.store.ListPolicies(ctx, txn)
+	if err != nil {
+		return nil, grpcAutoError(err)
+	}
+
+	resp := &pb.ListPoliciesResponse{
+		Result: make([]*pb.PolicyModule, len(ids)),
+	}
+
+	for i, id := range ids {
+		bs, err := g.s.store.GetPolicy(ctx, txn, id)
+		if err != nil {
+			return nil, grpcAutoError(err)
+		}
+		resp.Result[i] = &pb.PolicyModule{Id: id, Raw: string(bs)}
+	}
+
+	return resp, nil
+}
+
+func (g *grpcServer) GetPolicy(ctx context.Context, req *pb.GetPolicyRequest) (*pb.GetPolicyResponse, error) {
+	txn, err := g.s.store.NewTransaction(ctx)
+	if err != nil {
+		return nil, grpcAutoError(err)
+	}
+
+	defer g.s.store.Abort(ctx, txn)
+
+	bs, err := g.s.store.GetPolicy(ctx, txn, req.Id)
+	if err != nil {
+		return nil, grpcAutoError(err)
+	}
+
+	return &pb.GetPolicyResponse{Result: &pb.PolicyModule{Id: req.Id, Raw: string(bs)}}, nil
+}
+
+func (g *grpcServer) PutPolicy(ctx context.Context, req *pb.PutPolicyRequest) (*pb.PutPolicyResponse, error) {
+	m := metrics.New()
+
+	if _, err := g.s.putPolicy(ctx, m, req.Id, []byte(req.Raw)); err != nil {
+		return nil, grpcError(err)
+	}
+
+	resp := &pb.PutPolicyResponse{}
+	var err error
+	if req.Metrics {
+		resp.Metrics, err = toProtoStruct(m.All())
+	}
+	return resp, grpcAutoError(err)
+}
+
+func (g *grpcServer) DeletePolicy(ctx context.Context, req *pb.DeletePolicyRequest) (*pb.DeletePolicyResponse, error) {
+	m := metrics.New()
+
+	if _, err := g.s.deletePolicy(ctx, m, req.Id); err != nil {
+		return nil, grpcError(err)
+	}
+
+	resp := &pb.DeletePolicyResponse{}
+	var err error
+	if req.Metrics {
+		resp.Metrics, err = toProtoStruct(m.All())
+	}
+	return resp, grpcAutoError(err)
+}
+
+// Check reports the health like the Health API. The service "bundles" also
+// requires the bundles to be activated, the service "plugins" all plugins to
+// be OK, the service "checks" all health checks registered by plugins to pass,
+// and the service "ready" the readiness predicate to be true. All services are
+// reported as not serving while the server drains.
+func (g *grpcServer) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	serving := &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}
+	notServing := &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_NOT_SERVING}
+
+	switch req.Service {
+	case "", "bundles", "plugins", "checks", "ready":
+	default:
+		return nil, status.Errorf(codes.NotFound, "unknown service %q", req.Service)
+	}
+
+	if draining, _ := g.s.drainer.status(); draining || !g.s.canEval(ctx) {
+		return notServing, nil
+	}
+
+	pluginStatuses := g.s.manager.PluginStatus()
+
+	switch req.Service {
+	case "bundles":
+		if !g.s.bundlesReady(pluginStatuses) {
+			return notServing, nil
+		}
+	case "plugins":
+		for _, status := range pluginStatuses {
+			if status != nil && status.State != plugins.StateOK {
+				return notServing, nil
+			}
+		}
+	case "checks", "ready":
+		for _, check := range g.s.healthChecks(ctx, req.Service == "checks", req.Service == "ready", nil) {
+			if !check.OK {
+				return notServing, nil
+			}
+		}
+	}
+
+	return serving, nil
+}
+
+// grpcError returns the status of the gRPC API equivalent to err.
+func grpcError(err *types.ErrorV1) error {
+	var code codes.Code
+	switch err.Code {
+	case types.CodeInvalidParameter, types.CodeInvalidOperation:
+		code = codes.InvalidArgument
+	case types.CodeUnauthorized:
+		code = codes.PermissionDenied
+	case types.CodeResourceNotFound, types.CodeUndefinedDocument:
+		code = codes.NotFound
+	case types.CodeResourceConflict:
+		code = codes.Aborted
+	case types.CodeTooManyRequests:
+		code = codes.ResourceExhausted
+	default:
+		code = codes.Internal
+	}
+
+	msg := err.Message
+	for _, e := range err.Errors {
+		msg += ": " + e.Error()
+	}
+	return status.Error(code, msg)
+}
+
+// grpcAutoError returns the status of the gRPC API equivalent to err, or nil if
+// err is nil.
+func grpcAutoError(err error) error {
+	if err == nil {
+		return nil
+	}
+	_, e := writer.AutoError(err)
+	return grpcError(e)
+}
+
+func grpcQueryError(msg string, err error) error {
+	if errs, ok := err.(ast.Errors); ok {
+		return grpcError(types.NewErrorV1(types.CodeInvalidParameter, msg).WithASTErrors(errs))
+	}
+	return grpcAutoError(err)
+}
+
+// fromProtoValue returns the JSON value of v, with numbers represented like in
+// the decoded request bodies of the REST API.
+func fromProtoValue(v *structpb.Value) (interface{}, error) {
+	switch k := v.GetKind().(type) {
+	case *structpb.Value_NumberValue:
+		if math.IsInf(k.NumberValue, 0) || math.IsNaN(k.NumberValue) {
+			return nil, fmt.Errorf("invalid number: %v", k.NumberValue)
+		}
+		return json.Number(strconv.FormatFloat(k.NumberValue, 'g', -1, 64)), nil
+	case *structpb.Value_StringValue:
+		return k.StringValue, nil
+	case *structpb.Value_BoolValue:
+		return k.BoolValue, nil
+	case *structpb.Value_StructValue:
+		result := make(map[string]interface{}, len(k.StructValue.GetFields()))
+		for key, x := range k.StructValue.GetFields() {
+			var err error
+			if result[key], err = fromProtoValue(x); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	case *structpb.Value_ListValue:
+		result := make([]interface{}, len(k.ListValue.GetValues()))
+		for i, x := range k.ListValue.GetValues() {
+			var err error
+			if result[i], err = fromProtoValue(x); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	}
+	return nil, nil
+}
+
+// toProtoValue returns the protocol buffer value of the JSON value x. Numbers
+// are converted to doubles.
+func toProtoValue(x interface{}) (*structpb.Value, error) {
+	switch x := x.(type) {
+	case json.Number:
+		f, err := x.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return structpb.NewNumberValue(f), nil
+	case map[string]interface{}:
+		fields := make(map[string]*structpb.Value, len(x))
+		for k, v := range x {
+			var err error
+			if fields[k], err = toProtoValue(v); err != nil {
+				return nil, err
+			}
+		}
+		return structpb.NewStructValue(&structpb.Struct{Fields: fields}), nil
+	case []interface{}:
+		values := make([]*structpb.Value, len(x))
+		for i, v := range x {
+			var err error
+			if values[i], err = toProtoValue(v); err != nil {
+				return nil, err
+			}
+		}
+		return structpb.NewListValue(&structpb.ListValue{Values: values}), nil
+	}
+	return structpb.NewValue(x)
+}
+
+// toProtoStruct returns the protocol buffer struct of the JSON object x, that
+// may be any value serializable to a JSON object.
+func toProtoStruct(x interface{}) (*structpb.Struct, error) {
+	if err := util.RoundTrip(&x); err != nil {
+		return nil, err
+	}
+	v, err := toProtoValue(x)
+	if err != nil {
+		return nil, err
+	}
+	return v.GetStructValue(), nil
+}
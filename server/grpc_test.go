@@ -0,0 +1,324 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/open-policy-agent/opa/plugins"
+	"github.com/open-policy-agent/opa/server/pb"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/open-policy-agent/opa/util"
+)
+
+// newGRPCClient serves the gRPC API of s on a random port and returns a
+// connection to it.
+func newGRPCClient(t *testing.T, s *Server) *grpc.ClientConn {
+	t.Helper()
+
+	loop, listener, err := s.getGRPCListener("localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() { _ = loop() }()
+	t.Cleanup(func() { _ = listener.Shutdown(context.Background()) })
+
+	for listener.Addr() == "" {
+		time.Sleep(time.Millisecond)
+	}
+
+	conn, err := grpc.NewClient(listener.Addr(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func mustProtoValue(t *testing.T, s string) *structpb.Value {
+	t.Helper()
+	var x interface{}
+	if err := util.UnmarshalJSON([]byte(s), &x); err != nil {
+		t.Fatal(err)
+	}
+	v, err := toProtoValue(x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func assertProtoValue(t *testing.T, v *structpb.Value, expected string) {
+	t.Helper()
+	bs, err := v.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if util.Compare(util.MustUnmarshalJSON(bs), util.MustUnmarshalJSON([]byte(expected))) != 0 {
+		t.Fatalf("Expected %v but got %s", expected, bs)
+	}
+}
+
+func assertCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+	if status.Code(err) != code {
+		t.Fatalf("Expected %v but got: %v", code, err)
+	}
+}
+
+func TestGRPCDataAPI(t *testing.T) {
+	f := newFixture(t)
+	conn := newGRPCClient(t, f.server)
+	ctx := context.Background()
+
+	policies := pb.NewPolicyClient(conn)
+	data := pb.NewDataClient(conn)
+	query := pb.NewQueryClient(conn)
+
+	policy := `package test
+
+p := input.x + data.a.b.c`
+
+	if _, err := policies.PutPolicy(ctx, &pb.PutPolicyRequest{Id: "test", Raw: policy}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := policies.PutPolicy(ctx, &pb.PutPolicyRequest{Id: "bad", Raw: "package bad\n\np := x"})
+	assertCode(t, err, codes.InvalidArgument)
+
+	list, err := policies.ListPolicies(ctx, &pb.ListPoliciesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Result) != 1 || list.Result[0].Id != "test" || list.Result[0].Raw != policy {
+		t.Fatalf("Unexpected policies: %v", list.Result)
+	}
+
+	if _, err := data.PutData(ctx, &pb.PutDataRequest{Path: "a/b", Value: mustProtoValue(t, `{"c": 1}`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := data.GetData(ctx, &pb.GetDataRequest{Path: "test/p", Input: mustProtoValue(t, `{"x": 2}`), Metrics: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertProtoValue(t, resp.Result, `3`)
+	if len(resp.Metrics.GetFields()) == 0 {
+		t.Fatal("Expected metrics")
+	}
+
+	if _, err := data.PatchData(ctx, &pb.PatchDataRequest{Path: "a", Operations: []*pb.PatchOperation{
+		{Op: "replace", Path: "b/c", Value: mustProtoValue(t, `10`)},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err = data.GetData(ctx, &pb.GetDataRequest{Path: "/test/p", Input: mustProtoValue(t, `{"x": 2}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertProtoValue(t, resp.Result, `12`)
+
+	q, err := query.Query(ctx, &pb.QueryRequest{Query: "x := data.a.b.c + input.y", Input: mustProtoValue(t, `{"y": 1}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q.Result) != 1 {
+		t.Fatalf("Expected one result but got: %v", q.Result)
+	}
+	assertProtoValue(t, structpb.NewStructValue(q.Result[0]), `{"x": 11}`)
+
+	_, err = query.Query(ctx, &pb.QueryRequest{Query: "x := "})
+	assertCode(t, err, codes.InvalidArgument)
+
+	if _, err := data.DeleteData(ctx, &pb.DeleteDataRequest{Path: "a/b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = data.DeleteData(ctx, &pb.DeleteDataRequest{Path: "a/b"})
+	assertCode(t, err, codes.NotFound)
+
+	resp, err = data.GetData(ctx, &pb.GetDataRequest{Path: "test/p", Input: mustProtoValue(t, `{"x": 2}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Result != nil {
+		t.Fatalf("Expected undefined result but got: %v", resp.Result)
+	}
+
+	if _, err := policies.DeletePolicy(ctx, &pb.DeletePolicyRequest{Id: "test"}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = policies.GetPolicy(ctx, &pb.GetPolicyRequest{Id: "test"})
+	assertCode(t, err, codes.NotFound)
+}
+
+func TestGRPCDecisionLogging(t *testing.T) {
+	var infos []*Info
+	f := newFixture(t,
+		func(s *Server) {
+			s.WithDecisionIDFactory(func() string { return "xyz" })
+		},
+		func(s *Server) {
+			s.WithDecisionLoggerWithErr(func(_ context.Context, info *Info) error {
+				infos = append(infos, info)
+				return nil
+			})
+		})
+	conn := newGRPCClient(t, f.server)
+	ctx := context.Background()
+
+	resp, err := pb.NewDataClient(conn).GetData(ctx, &pb.GetDataRequest{Path: "a", Input: mustProtoValue(t, `{"x": 1.5}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.DecisionId != "xyz" {
+		t.Fatalf("Expected decision ID xyz but got: %v", resp.DecisionId)
+	}
+
+	if len(infos) != 1 || infos[0].DecisionID != "xyz" || infos[0].Path != "a" {
+		t.Fatalf("Unexpected decision logs: %v", infos)
+	}
+	if bs := util.MustMarshalJSON(*infos[0].Input); string(bs) != `{"x":1.5}` {
+		t.Fatalf("Unexpected input: %s", bs)
+	}
+}
+
+func TestGRPCDecisionLimits(t *testing.T) {
+	f := newFixtureWithConfig(t, `{"server": {"limits": {"decisions": [
+		{"path": "test", "requests_per_second": 0.001, "burst": 1}
+	]}}}`)
+	conn := newGRPCClient(t, f.server)
+	ctx := context.Background()
+	data := pb.NewDataClient(conn)
+
+	if _, err := data.GetData(ctx, &pb.GetDataRequest{Path: "test/p"}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := data.GetData(ctx, &pb.GetDataRequest{Path: "test/p"})
+	assertCode(t, err, codes.ResourceExhausted)
+}
+
+func TestGRPCHealthChecks(t *testing.T) {
+	f := newFixture(t)
+	conn := newGRPCClient(t, f.server)
+	ctx := context.Background()
+	health := grpc_health_v1.NewHealthClient(conn)
+
+	failing := errors.New("unreachable")
+	var checkErr error
+	f.server.manager.RegisterHealthCheck("db", func(context.Context) error {
+		return checkErr
+	})
+
+	for _, tc := range []struct {
+		service string
+		err     error
+		exp     grpc_health_v1.HealthCheckResponse_ServingStatus
+	}{
+		{service: "checks", exp: grpc_health_v1.HealthCheckResponse_SERVING},
+		{service: "checks", err: failing, exp: grpc_health_v1.HealthCheckResponse_NOT_SERVING},
+		{service: "", err: failing, exp: grpc_health_v1.HealthCheckResponse_SERVING},
+		{service: "ready", exp: grpc_health_v1.HealthCheckResponse_NOT_SERVING},
+	} {
+		checkErr = tc.err
+		check, err := health.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: tc.service})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if check.Status != tc.exp {
+			t.Fatalf("Expected %v for service %q but got: %v", tc.exp, tc.service, check.Status)
+		}
+	}
+}
+
+func TestGRPCAuthorization(t *testing.T) {
+	ctx := context.Background()
+	store := inmem.New()
+	m, err := plugins.New([]byte{}, "test", store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	authzPolicy := `package system.authz
+
+default allow := false
+
+allow {
+	input.identity == "bob"
+}
+
+allow {
+	input.method == "POST"
+	input.path == ["v1", "data", "test"]
+	input.body.input.user == "alice"
+}`
+
+	txn := storage.NewTransactionOrDie(ctx, store, storage.WriteParams)
+	if err := store.UpsertPolicy(ctx, txn, "authz", []byte(authzPolicy)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Commit(ctx, txn); err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := New().
+		WithStore(store).
+		WithManager(m).
+		WithAuthentication(AuthenticationToken).
+		WithAuthorization(AuthorizationBasic).
+		Init(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := newGRPCClient(t, server)
+	data := pb.NewDataClient(conn)
+	health := grpc_health_v1.NewHealthClient(conn)
+
+	_, err = health.Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	assertCode(t, err, codes.PermissionDenied)
+
+	bob := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer bob")
+	check, err := health.Check(bob, &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Fatalf("Expected SERVING but got: %v", check.Status)
+	}
+
+	_, err = health.Check(bob, &grpc_health_v1.HealthCheckRequest{Service: "unknown"})
+	assertCode(t, err, codes.NotFound)
+
+	if _, err := data.GetData(ctx, &pb.GetDataRequest{Path: "test", Input: mustProtoValue(t, `{"user": "alice"}`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = data.GetData(ctx, &pb.GetDataRequest{Path: "test", Input: mustProtoValue(t, `{"user": "eve"}`)})
+	assertCode(t, err, codes.PermissionDenied)
+
+	_, err = data.PutData(ctx, &pb.PutDataRequest{Path: "test", Value: mustProtoValue(t, `{"user": "alice"}`)})
+	assertCode(t, err, codes.PermissionDenied)
+}
@@ -166,7 +166,7 @@ func (w *compressResponseWriter) writeHeader() {
 func isDataEndpoint(req *http.Request) bool {
 	isPostOrGetMethod := isPostMethod(req) || isGetMethod(req)
 	isV1rV0 := strings.HasPrefix(req.URL.Path, "/v1/data") || strings.HasPrefix(req.URL.Path, "/v0/data")
-	return isPostOrGetMethod && isV1rV0
+	return isPostOrGetMethod && isV1rV0 || isPostMethod(req) && strings.HasPrefix(req.URL.Path, "/v1/batch/data")
 }
 
 func isCompileEndpoint(req *http.Request) bool {
@@ -28,3 +28,18 @@ func Identity(r *http.Request) (string, bool) {
 func SetIdentity(r *http.Request, v string) *http.Request {
 	return r.WithContext(context.WithValue(r.Context(), identity, v))
 }
+
+type identityClaimsKey string
+
+const identityClaims = identityClaimsKey("org.openpolicyagent/identity-claims")
+
+// IdentityClaims returns the verified claims of the caller associated with ctx.
+func IdentityClaims(r *http.Request) (map[string]interface{}, bool) {
+	v, ok := r.Context().Value(identityClaims).(map[string]interface{})
+	return v, ok
+}
+
+// SetIdentityClaims returns a new http.Request with the identity claims set to v.
+func SetIdentityClaims(r *http.Request, v map[string]interface{}) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), identityClaims, v))
+}
@@ -0,0 +1,351 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package identifier
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/open-policy-agent/opa/internal/jwx/jwa"
+	"github.com/open-policy-agent/opa/internal/jwx/jwk"
+	"github.com/open-policy-agent/opa/internal/jwx/jws"
+	"github.com/open-policy-agent/opa/logging"
+	"github.com/open-policy-agent/opa/plugins/server/authentication"
+	"github.com/open-policy-agent/opa/util"
+)
+
+// jwksMinRefetch is the minimum duration between two fetches of the keys of an
+// issuer caused by tokens signed with unknown keys, so that such tokens cannot
+// be used to flood the issuer with requests.
+var jwksMinRefetch = 10 * time.Second
+
+// JWTBased verifies Bearer JWTs in the request. The identity and claims of the
+// caller are set if the token is valid, otherwise the request is passed on
+// without them.
+type JWTBased struct {
+	inner    http.Handler
+	verifier *JWTVerifier
+	logger   logging.Logger
+}
+
+// NewJWTBased returns a new JWTBased object.
+func NewJWTBased(inner http.Handler, verifier *JWTVerifier, logger logging.Logger) *JWTBased {
+	return &JWTBased{
+		inner:    inner,
+		verifier: verifier,
+		logger:   logger,
+	}
+}
+
+func (h *JWTBased) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if token, ok := BearerToken(r.Header.Get("Authorization")); ok {
+		claims, err := h.verifier.Verify(r.Context(), token)
+		if err != nil {
+			h.logger.Debug("Invalid bearer token: %v.", err)
+		} else {
+			r = SetIdentity(r, token)
+			r = SetIdentityClaims(r, claims)
+		}
+	}
+
+	h.inner.ServeHTTP(w, r)
+}
+
+// BearerToken returns the Bearer token of the Authorization header value, if
+// any.
+func BearerToken(value string) (string, bool) {
+	match := bearerTokenRegexp.FindStringSubmatch(value)
+	if len(match) > 0 {
+		return match[1], true
+	}
+	return "", false
+}
+
+// JWTVerifier verifies JWTs signed by the configured issuers with the keys
+// published at their JWKS URLs. The keys are cached and refetched when they
+// have expired or a token is signed with an unknown key.
+type JWTVerifier struct {
+	issuers map[string]*jwtIssuer
+	refresh time.Duration
+	leeway  time.Duration
+	client  *http.Client
+}
+
+type jwtIssuer struct {
+	config  *authentication.Issuer
+	mtx     sync.Mutex
+	keys    []jwk.Key
+	fetched time.Time
+	fetch   *jwksFetch // nil if the keys are not being fetched
+}
+
+// jwksFetch is a fetch of the keys of an issuer, shared by the requests that
+// need the keys while it is in progress.
+type jwksFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// NewJWTVerifier returns a new JWTVerifier for the configured issuers. The
+// client is used to fetch the keys.
+func NewJWTVerifier(config *authentication.JWT, client *http.Client) *JWTVerifier {
+	v := &JWTVerifier{
+		issuers: make(map[string]*jwtIssuer, len(config.Issuers)),
+		refresh: time.Duration(*config.JWKSRefreshSeconds) * time.Second,
+		leeway:  time.Duration(config.LeewaySeconds) * time.Second,
+		client:  client,
+	}
+	for _, iss := range config.Issuers {
+		v.issuers[iss.Issuer] = &jwtIssuer{config: iss}
+	}
+	return v
+}
+
+// Verify returns the claims of the token if it has been signed by one of the
+// issuers, is currently valid and intended for one of the audiences of the
+// issuer.
+func (v *JWTVerifier) Verify(ctx context.Context, token string) (map[string]interface{}, error) {
+	msg, err := jws.ParseString(token)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := msg.GetSignatures()[0].ProtectedHeaders()
+	alg := headers.GetAlgorithm()
+	switch alg {
+	case jwa.RS256, jwa.RS384, jwa.RS512, jwa.PS256, jwa.PS384, jwa.PS512, jwa.ES256, jwa.ES384, jwa.ES512:
+	default:
+		return nil, fmt.Errorf("unsupported signature algorithm %q", alg)
+	}
+
+	var kid string
+	if x, ok := headers.Get(jws.KeyIDKey); ok {
+		kid, _ = x.(string)
+	}
+
+	var claims map[string]interface{}
+	if err := util.UnmarshalJSON(msg.GetPayload(), &claims); err != nil {
+		return nil, fmt.Errorf("invalid claims: %w", err)
+	}
+
+	iss, _ := claims["iss"].(string)
+	issuer, ok := v.issuers[iss]
+	if !ok {
+		return nil, fmt.Errorf("unknown issuer %q", iss)
+	}
+
+	keys, err := issuer.getKeys(ctx, v.client, kid, v.refresh)
+	if err != nil {
+		return nil, err
+	}
+
+	if !verifyWithKeys([]byte(token), alg, kid, keys) {
+		return nil, errors.New("signature verification failed")
+	}
+
+	now := time.Now()
+	if exp, ok := claims["exp"]; ok {
+		t, err := numericDate(exp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exp claim: %w", err)
+		}
+		if !now.Before(t.Add(v.leeway)) {
+			return nil, errors.New("token has expired")
+		}
+	}
+	if nbf, ok := claims["nbf"]; ok {
+		t, err := numericDate(nbf)
+		if err != nil {
+			return nil, fmt.Errorf("invalid nbf claim: %w", err)
+		}
+		if now.Add(v.leeway).Before(t) {
+			return nil, errors.New("token is not valid yet")
+		}
+	}
+
+	if len(issuer.config.Audiences) > 0 && !hasAudience(claims["aud"], issuer.config.Audiences) {
+		return nil, errors.New("token is not intended for any of the audiences")
+	}
+
+	return claims, nil
+}
+
+// getKeys returns the keys of the issuer, fetching them if they have expired or
+// none of them has the key ID kid. The keys are fetched without holding the
+// lock, and concurrent requests share a single fetch. The fetch is not tied to
+// the request that started it: it is bounded by the timeout of the client, and
+// the requests stop waiting for it when their ctx is done. While the keys are
+// being fetched, requests for keys that are known already do not wait for the
+// fetch.
+func (i *jwtIssuer) getKeys(ctx context.Context, client *http.Client, kid string, refresh time.Duration) ([]jwk.Key, error) {
+	i.mtx.Lock()
+
+	age := time.Since(i.fetched)
+	if i.keys != nil && age < refresh && (age < jwksMinRefetch || hasKeyID(i.keys, kid)) {
+		defer i.mtx.Unlock()
+		return i.keys, nil
+	}
+
+	f := i.fetch
+	switch {
+	case f == nil:
+		f = &jwksFetch{done: make(chan struct{})}
+		i.fetch = f
+		go i.fetchKeys(context.WithoutCancel(ctx), client, f)
+	case i.keys != nil && hasKeyID(i.keys, kid):
+		defer i.mtx.Unlock()
+		return i.keys, nil
+	}
+	i.mtx.Unlock()
+
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+
+	// The previous keys remain in use until they can be fetched.
+	if i.keys == nil {
+		return nil, f.err
+	}
+	return i.keys, nil
+}
+
+// fetchKeys completes f with the keys fetched from the JWKS URL of the issuer.
+func (i *jwtIssuer) fetchKeys(ctx context.Context, client *http.Client, f *jwksFetch) {
+	keys, err := fetchKeys(ctx, client, i.config.JWKSURL)
+
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+
+	if err == nil {
+		i.keys = keys
+		i.fetched = time.Now()
+	}
+	f.err = err
+	i.fetch = nil
+	close(f.done)
+}
+
+func fetchKeys(ctx context.Context, client *http.Client, url string) ([]jwk.Key, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch keys: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch keys: unexpected status %v from %v", resp.StatusCode, url)
+	}
+
+	bs, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch keys: %w", err)
+	}
+
+	set, err := jwk.ParseBytes(bs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse keys from %v: %w", url, err)
+	}
+
+	return set.Keys, nil
+}
+
+func hasKeyID(keys []jwk.Key, kid string) bool {
+	if kid == "" {
+		return true
+	}
+	for _, key := range keys {
+		if key.GetKeyID() == kid {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyWithKeys returns true if the token has been signed with alg by one of
+// the signing keys, or the key with ID kid if set.
+func verifyWithKeys(token []byte, alg jwa.SignatureAlgorithm, kid string, keys []jwk.Key) bool {
+	for _, key := range keys {
+		if kid != "" && key.GetKeyID() != kid {
+			continue
+		}
+		if use := key.GetKeyUsage(); use != "" && use != "sig" {
+			continue
+		}
+		if a := key.GetAlgorithm(); a != jwa.NoValue && a != alg {
+			continue
+		}
+
+		raw, err := key.Materialize()
+		if err != nil {
+			continue
+		}
+
+		// Only public keys are used, symmetric keys are never published.
+		switch k := raw.(type) {
+		case *rsa.PrivateKey:
+			raw = &k.PublicKey
+		case *ecdsa.PrivateKey:
+			raw = &k.PublicKey
+		case *rsa.PublicKey, *ecdsa.PublicKey:
+		default:
+			continue
+		}
+
+		if _, err := jws.Verify(token, alg, raw); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func numericDate(x interface{}) (time.Time, error) {
+	n, ok := x.(json.Number)
+	if !ok {
+		return time.Time{}, errors.New("not a number")
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(f), 0), nil
+}
+
+func hasAudience(aud interface{}, audiences []string) bool {
+	var values []interface{}
+	switch aud := aud.(type) {
+	case string:
+		values = []interface{}{aud}
+	case []interface{}:
+		values = aud
+	}
+
+	for _, v := range values {
+		for _, a := range audiences {
+			if v == a {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,285 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package identifier_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/opa/internal/jwx/jwa"
+	"github.com/open-policy-agent/opa/internal/jwx/jws"
+	"github.com/open-policy-agent/opa/logging"
+	"github.com/open-policy-agent/opa/plugins/server/authentication"
+	"github.com/open-policy-agent/opa/server/identifier"
+)
+
+func rsaJWK(kid string, key *rsa.PrivateKey) string {
+	return fmt.Sprintf(`{"kty": "RSA", "kid": %q, "use": "sig", "n": %q, "e": %q}`, kid,
+		base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()))
+}
+
+func signJWT(t *testing.T, alg jwa.SignatureAlgorithm, kid string, key interface{}, claims string) string {
+	t.Helper()
+	hdr := fmt.Sprintf(`{"alg": %q, "kid": %q}`, alg, kid)
+	token, err := jws.SignLiteral([]byte(claims), alg, key, []byte(hdr), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(token)
+}
+
+func TestJWTBased(t *testing.T) {
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fetches int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		fmt.Fprintf(w, `{"keys": [%s]}`, rsaJWK("k1", key))
+	}))
+	defer ts.Close()
+
+	config, err := authentication.NewConfigBuilder().WithBytes([]byte(fmt.Sprintf(`{"jwt": {
+		"issuers": [{"issuer": "https://issuer.example.com", "jwks_url": %q, "audiences": ["opa"]}],
+		"leeway_seconds": 60
+	}}`, ts.URL))).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock := &mockHandler{}
+	handler := identifier.NewJWTBased(mock, identifier.NewJWTVerifier(config.JWT, ts.Client()), logging.NewNoOpLogger())
+
+	now := time.Now().Unix()
+	valid := fmt.Sprintf(`{"iss": "https://issuer.example.com", "sub": "alice", "aud": ["opa", "other"], "exp": %d}`, now+60)
+
+	tests := []struct {
+		note  string
+		token string
+		valid bool
+	}{
+		{
+			note:  "no token",
+			token: "",
+		},
+		{
+			note:  "valid",
+			token: signJWT(t, jwa.RS256, "k1", key, valid),
+			valid: true,
+		},
+		{
+			note:  "valid within leeway",
+			token: signJWT(t, jwa.PS256, "k1", key, fmt.Sprintf(`{"iss": "https://issuer.example.com", "aud": "opa", "exp": %d, "nbf": %d}`, now-30, now+30)),
+			valid: true,
+		},
+		{
+			note:  "expired",
+			token: signJWT(t, jwa.RS256, "k1", key, fmt.Sprintf(`{"iss": "https://issuer.example.com", "aud": "opa", "exp": %d}`, now-120)),
+		},
+		{
+			note:  "not valid yet",
+			token: signJWT(t, jwa.RS256, "k1", key, fmt.Sprintf(`{"iss": "https://issuer.example.com", "aud": "opa", "nbf": %d}`, now+120)),
+		},
+		{
+			note:  "other audience",
+			token: signJWT(t, jwa.RS256, "k1", key, `{"iss": "https://issuer.example.com", "aud": "other"}`),
+		},
+		{
+			note:  "unknown issuer",
+			token: signJWT(t, jwa.RS256, "k1", key, `{"iss": "https://other.example.com", "aud": "opa"}`),
+		},
+		{
+			note:  "unknown key",
+			token: signJWT(t, jwa.RS256, "k2", otherKey, valid),
+		},
+		{
+			note:  "wrong signature",
+			token: signJWT(t, jwa.RS256, "k1", otherKey, valid),
+		},
+		{
+			note:  "symmetric",
+			token: signJWT(t, jwa.HS256, "k1", []byte("secret"), valid),
+		},
+		{
+			note:  "malformed",
+			token: "not-a-jwt",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/foo/bar/baz", nil)
+			if err != nil {
+				t.Fatalf("Unexpected error creating request: %v", err)
+			}
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+
+			handler.ServeHTTP(nil, req)
+
+			if mock.identityDefined != tc.valid || mock.identityClaimsDefined != tc.valid {
+				t.Fatalf("Expected identity and claims to be defined: %v but got: %v, %v", tc.valid, mock.identityDefined, mock.identityClaimsDefined)
+			}
+
+			if tc.valid && (mock.identity != tc.token || mock.identityClaims["iss"] != "https://issuer.example.com") {
+				t.Fatalf("Unexpected identity %v and claims %v", mock.identity, mock.identityClaims)
+			}
+		})
+	}
+
+	// The keys are cached, tokens signed with unknown keys do not cause them
+	// to be fetched again immediately.
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("Expected keys to be fetched once but got %d fetches", n)
+	}
+}
+
+func TestJWTVerifierConcurrentFetches(t *testing.T) {
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fetches int32
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if atomic.AddInt32(&fetches, 1) == 2 {
+			close(blocked)
+			<-release
+		}
+		fmt.Fprintf(w, `{"keys": [%s]}`, rsaJWK("k1", key))
+	}))
+	defer ts.Close()
+
+	config, err := authentication.NewConfigBuilder().WithBytes([]byte(fmt.Sprintf(`{"jwt": {
+		"issuers": [{"issuer": "https://issuer.example.com", "jwks_url": %q}],
+		"jwks_refresh_seconds": 1
+	}}`, ts.URL))).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := identifier.NewJWTVerifier(config.JWT, ts.Client())
+	token := signJWT(t, jwa.RS256, "k1", key, `{"iss": "https://issuer.example.com"}`)
+
+	// Concurrent requests share the initial fetch.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := verifier.Verify(context.Background(), token); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("Expected keys to be fetched once but got %d fetches", n)
+	}
+
+	// Once the keys have expired, one request fetches them again. The other
+	// requests keep using the previous keys meanwhile.
+	time.Sleep(time.Second)
+
+	done := make(chan error)
+	go func() {
+		_, err := verifier.Verify(context.Background(), token)
+		done <- err
+	}()
+	<-blocked
+
+	if _, err := verifier.Verify(context.Background(), token); err != nil {
+		t.Fatal(err)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Fatalf("Expected keys to be fetched twice but got %d fetches", n)
+	}
+}
+
+func TestJWTVerifierFetchOutlivesRequest(t *testing.T) {
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fetches int32
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if atomic.AddInt32(&fetches, 1) == 1 {
+			close(blocked)
+			<-release
+		}
+		fmt.Fprintf(w, `{"keys": [%s]}`, rsaJWK("k1", key))
+	}))
+	defer ts.Close()
+
+	config, err := authentication.NewConfigBuilder().WithBytes([]byte(fmt.Sprintf(`{"jwt": {
+		"issuers": [{"issuer": "https://issuer.example.com", "jwks_url": %q}]
+	}}`, ts.URL))).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := identifier.NewJWTVerifier(config.JWT, ts.Client())
+	token := signJWT(t, jwa.RS256, "k1", key, `{"iss": "https://issuer.example.com"}`)
+
+	// The request that starts the fetch stops waiting for it when its context
+	// is canceled, but the fetch goes on for the other requests.
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := verifier.Verify(ctx, token)
+		done <- err
+	}()
+	<-blocked
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("Expected context canceled error but got %v", err)
+	}
+
+	go func() {
+		_, err := verifier.Verify(context.Background(), token)
+		done <- err
+	}()
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("Expected keys to be fetched once but got %d fetches", n)
+	}
+}
@@ -11,11 +11,15 @@ type mockHandler struct {
 	identity        string
 	identityDefined bool
 
+	identityClaims        map[string]interface{}
+	identityClaimsDefined bool
+
 	clientCertificates        []*x509.Certificate
 	clientCertificatesDefined bool
 }
 
 func (h *mockHandler) ServeHTTP(_ http.ResponseWriter, r *http.Request) {
 	h.identity, h.identityDefined = identifier.Identity(r)
+	h.identityClaims, h.identityClaimsDefined = identifier.IdentityClaims(r)
 	h.clientCertificates, h.clientCertificatesDefined = identifier.ClientCertificates(r)
 }
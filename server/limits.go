@@ -0,0 +1,141 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/time/rate"
+
+	"github.com/open-policy-agent/opa/plugins/server/limits"
+	"github.com/open-policy-agent/opa/server/types"
+	"github.com/open-policy-agent/opa/server/writer"
+)
+
+// decisionLimits enforces the limits configured for the decisions beneath
+// paths. The limits are sorted by decreasing length of their paths, so that the
+// first limit matching a decision path is the most specific one.
+type decisionLimits []*decisionLimit
+
+type decisionLimit struct {
+	path          string
+	rate          *rate.Limiter // nil if unlimited
+	inFlight      chan struct{} // nil if unlimited
+	maxEvaluation time.Duration // zero if unlimited
+}
+
+func newDecisionLimits(config *limits.Config) decisionLimits {
+	result := make(decisionLimits, 0, len(config.Decisions))
+	for _, d := range config.Decisions {
+		limit := &decisionLimit{path: d.Path}
+		if d.RequestsPerSecond != nil {
+			limit.rate = rate.NewLimiter(rate.Limit(*d.RequestsPerSecond), *d.Burst)
+		}
+		if d.MaxInFlight != nil {
+			limit.inFlight = make(chan struct{}, *d.MaxInFlight)
+		}
+		if d.MaxEvaluationSeconds != nil {
+			limit.maxEvaluation = time.Duration(*d.MaxEvaluationSeconds * float64(time.Second))
+		}
+		result = append(result, limit)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return len(result[i].path) > len(result[j].path)
+	})
+	return result
+}
+
+// find returns the limit of the decision at path, or nil if the decision is not
+// limited.
+func (l decisionLimits) find(path string) *decisionLimit {
+	path = strings.Trim(path, "/")
+	for _, limit := range l {
+		if limit.path == "" || path == limit.path || strings.HasPrefix(path, limit.path+"/") {
+			return limit
+		}
+	}
+	return nil
+}
+
+// limitDecisions returns a handler that enforces the limit of the decision
+// path of the request before calling handler. Requests exceeding the rate or
+// the number of concurrent evaluations are rejected with status 429, and the
+// evaluations exceeding the maximum duration are cancelled.
+func (s *Server) limitDecisions(handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := s.decisionLimits.find(mux.Vars(r)["path"])
+		if limit == nil {
+			handler(w, r)
+			return
+		}
+
+		ctx, release, err := limit.acquire(r.Context())
+		if err != nil {
+			tooManyRequests(w, err.retryAfter, err.msg)
+			return
+		}
+		defer release()
+
+		handler(w, r.WithContext(ctx))
+	}
+}
+
+// limitError describes a request rejected by a decision limit.
+type limitError struct {
+	retryAfter time.Duration
+	msg        string
+}
+
+// acquire admits a decision under the limit. The decision must be evaluated
+// with the returned context, and release must be called once it is complete.
+func (l *decisionLimit) acquire(ctx context.Context) (context.Context, func(), *limitError) {
+	if l.rate != nil {
+		reservation := l.rate.Reserve()
+		if delay := reservation.Delay(); delay > 0 {
+			reservation.Cancel()
+			return nil, nil, &limitError{retryAfter: delay, msg: "decision rate limit exceeded"}
+		}
+	}
+
+	if l.inFlight != nil {
+		select {
+		case l.inFlight <- struct{}{}:
+		default:
+			return nil, nil, &limitError{retryAfter: time.Second, msg: "too many concurrent decisions"}
+		}
+	}
+
+	cancel := func() {}
+	if l.maxEvaluation > 0 {
+		ctx, cancel = context.WithTimeout(ctx, l.maxEvaluation)
+	}
+
+	return ctx, func() {
+		cancel()
+		if l.inFlight != nil {
+			<-l.inFlight
+		}
+	}, nil
+}
+
+// tooManyRequests writes a response with status 429, that asks the client to
+// retry after the delay, rounded up to seconds.
+func tooManyRequests(w http.ResponseWriter, delay time.Duration, msg string) {
+	seconds := int(math.Ceil(delay.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writer.ErrorString(w, http.StatusTooManyRequests, types.CodeTooManyRequests, errors.New(msg))
+}
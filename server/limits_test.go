@@ -0,0 +1,181 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/open-policy-agent/opa/plugins/server/limits"
+	"github.com/open-policy-agent/opa/topdown"
+)
+
+func TestDecisionLimitsRate(t *testing.T) {
+	f := newFixtureWithConfig(t, `{"server": {"limits": {"decisions": [
+		{"path": "test", "requests_per_second": 0.001, "burst": 2},
+		{"path": "test/q", "max_in_flight": 1}
+	]}}}`)
+
+	policy := `package test
+
+p := 1
+
+q := 2`
+
+	if err := f.v1(http.MethodPut, "/policies/test", policy, 200, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := f.executeRequest(newReqV1(http.MethodGet, "/data/test/p", ""), 200, `{"result": 1}`); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := f.executeRequest(newReqV1(http.MethodGet, "/data/test/p", ""), 429, `{"code": "too_many_requests", "message": "decision rate limit exceeded"}`); err != nil {
+		t.Fatal(err)
+	}
+	if retry := f.recorder.Header().Get("Retry-After"); retry != "1000" {
+		t.Fatalf("Expected Retry-After of 1000 seconds, got %q", retry)
+	}
+
+	// The most specific limit applies, and other decisions are not limited.
+	if err := f.executeRequest(newReqV1(http.MethodGet, "/data/test/q", ""), 200, `{"result": 2}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.executeRequest(newReqV1(http.MethodGet, "/data/testing", ""), 200, `{}`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecisionLimitsBatch(t *testing.T) {
+	f := newFixtureWithConfig(t, `{"server": {"limits": {"decisions": [
+		{"path": "test", "requests_per_second": 0.001, "burst": 1},
+		{"path": "test/q", "requests_per_second": 0.001, "burst": 1}
+	]}}}`)
+
+	policy := `package test
+
+p := 1
+
+q := 2`
+
+	if err := f.v1(http.MethodPut, "/policies/test", policy, 200, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// The batch is admitted under the limit of the URL path, and decisions
+	// under more specific limits are limited individually.
+	body := `{"inputs": [{"path": "p"}, {"path": "q"}, {"path": "q"}]}`
+	exp := `{"responses": [
+		{"result": 1, "warning": {"code": "api_usage_warning", "message": "'input' key missing from the request"}},
+		{"result": 2, "warning": {"code": "api_usage_warning", "message": "'input' key missing from the request"}},
+		{"error": {"code": "too_many_requests", "message": "decision rate limit exceeded"}}
+	]}`
+	if err := f.v1(http.MethodPost, "/batch/data/test", body, 200, exp); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.executeRequest(newReqV1(http.MethodPost, "/batch/data/test", body), 429, `{"code": "too_many_requests", "message": "decision rate limit exceeded"}`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecisionLimitsInFlight(t *testing.T) {
+	one := 1
+	s := &Server{decisionLimits: newDecisionLimits(&limits.Config{
+		Decisions: []*limits.Decision{{Path: "test", MaxInFlight: &one}},
+	})}
+
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := s.limitDecisions(func(w http.ResponseWriter, _ *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+
+	request := func() *http.Request {
+		return mux.SetURLVars(httptest.NewRequest(http.MethodPost, "/v1/data/test/p", nil), map[string]string{"path": "test/p"})
+	}
+
+	first := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		handler(first, request())
+		close(done)
+	}()
+	<-started
+
+	second := httptest.NewRecorder()
+	handler(second, request())
+	if second.Code != http.StatusTooManyRequests || second.Header().Get("Retry-After") != "1" {
+		t.Fatalf("Expected 429 with Retry-After, got %v: %v", second.Code, second.Header())
+	}
+
+	close(release)
+	<-done
+	if first.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %v", first.Code)
+	}
+
+	go func() { <-started }()
+	third := httptest.NewRecorder()
+	handler(third, request())
+	if third.Code != http.StatusOK {
+		t.Fatalf("Expected 200 after the first decision completed, got %v", third.Code)
+	}
+}
+
+func TestDecisionLimitsMaxEvaluation(t *testing.T) {
+	f := newFixtureWithConfig(t, `{"server": {"limits": {"decisions": [
+		{"path": "test", "max_evaluation_seconds": 0.01}
+	]}}}`)
+
+	policy := `package test
+
+p {
+	numbers.range(1, 100000000)[_] < 0
+}`
+
+	if err := f.v1(http.MethodPut, "/policies/test", policy, 200, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.executeRequest(newReqV1(http.MethodPost, "/data/test/p", ""), 500, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(f.recorder.Body.String(), topdown.CancelErr) {
+		t.Fatalf("Expected evaluation to be cancelled, got: %v", f.recorder.Body.String())
+	}
+}
+
+func TestQueryLimits(t *testing.T) {
+	f := newFixtureWithConfig(t, `{"server": {"limits": {"query": {"max_results": 10, "max_result_bytes": 100}}}}`)
+
+	if err := f.v1(http.MethodGet, "/query?q=x=numbers.range(1,10)[_]", "", 200, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, q := range []string{"x=numbers.range(1,11)[_]", "x=numbers.range(1,100)"} {
+		if err := f.executeRequest(newReqV1(http.MethodGet, "/query?q="+q, ""), 500, ""); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(f.recorder.Body.String(), topdown.ResultLimitErr) {
+			t.Fatalf("Expected result limit error for %v, got: %v", q, f.recorder.Body.String())
+		}
+	}
+
+	// Decisions are not limited.
+	if err := f.v1(http.MethodPut, "/policies/test", "package test\n\np := numbers.range(1, 100)", 200, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.executeRequest(newReqV1(http.MethodGet, "/data/test/p", ""), 200, ""); err != nil {
+		t.Fatal(err)
+	}
+}
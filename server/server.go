@@ -20,14 +20,20 @@ import (
 	"net/http/pprof"
 	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	serverAuthenticationPlugin "github.com/open-policy-agent/opa/plugins/server/authentication"
+	serverDecodingPlugin "github.com/open-policy-agent/opa/plugins/server/decoding"
 	serverEncodingPlugin "github.com/open-policy-agent/opa/plugins/server/encoding"
+	serverLimitsPlugin "github.com/open-policy-agent/opa/plugins/server/limits"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
 
@@ -36,6 +42,8 @@ import (
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/bundle"
+	"github.com/open-policy-agent/opa/decision"
+	"github.com/open-policy-agent/opa/internal/gojsonschema"
 	"github.com/open-policy-agent/opa/internal/json/patch"
 	"github.com/open-policy-agent/opa/logging"
 	"github.com/open-policy-agent/opa/metrics"
@@ -67,6 +75,7 @@ const (
 	AuthenticationOff AuthenticationScheme = iota
 	AuthenticationToken
 	AuthenticationTLS
+	AuthenticationJWT
 )
 
 var supportedTLSVersions = []uint16{tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13}
@@ -85,21 +94,36 @@ const defaultMinTLSVersion = tls.VersionTLS12
 
 // Set of handlers for use in the "handler" dimension of the duration metric.
 const (
-	PromHandlerV0Data     = "v0/data"
-	PromHandlerV1Data     = "v1/data"
-	PromHandlerV1Query    = "v1/query"
-	PromHandlerV1Policies = "v1/policies"
-	PromHandlerV1Compile  = "v1/compile"
-	PromHandlerV1Config   = "v1/config"
-	PromHandlerV1Status   = "v1/status"
-	PromHandlerIndex      = "index"
-	PromHandlerCatch      = "catchall"
-	PromHandlerHealth     = "health"
-	PromHandlerAPIAuthz   = "authz"
+	PromHandlerV0Data      = "v0/data"
+	PromHandlerV1Data      = "v1/data"
+	PromHandlerV1Batch     = "v1/batch/data"
+	PromHandlerV1Subscribe = "v1/subscribe/data"
+	PromHandlerV1Query     = "v1/query"
+	PromHandlerV1Policies  = "v1/policies"
+	PromHandlerV1Compile   = "v1/compile"
+	PromHandlerV1Config    = "v1/config"
+	PromHandlerV1Status    = "v1/status"
+	PromHandlerV1Bundles   = "v1/bundles"
+	PromHandlerV1Drain     = "v1/drain"
+	PromHandlerIndex       = "index"
+	PromHandlerCatch       = "catchall"
+	PromHandlerHealth      = "health"
+	PromHandlerAPIAuthz    = "authz"
+	PromHandlerDebugBench  = "debug/bench"
 )
 
 const pqMaxCacheSize = 100
 
+// Timeout of the requests fetching the signing keys of JWT issuers.
+const jwksFetchTimeout = 10 * time.Second
+
+// Bounds for benchmarks run through the benchmark endpoint.
+const (
+	benchDefaultIterations = 100
+	benchMaxIterations     = 10000
+	benchMaxDuration       = 10 * time.Second
+)
+
 // OpenTelemetry attributes
 const otelDecisionIDAttr = "opa.decision_id"
 
@@ -114,6 +138,7 @@ type Server struct {
 	router                 *mux.Router
 	addrs                  []string
 	diagAddrs              []string
+	grpcAddrs              []string
 	h2cEnabled             bool
 	authentication         AuthenticationScheme
 	authorization          AuthorizationScheme
@@ -129,24 +154,36 @@ type Server struct {
 	certPoolFileHash       []byte
 	minTLSVersion          uint16
 	mtx                    sync.RWMutex
-	partials               map[string]rego.PartialResult
-	preparedEvalQueries    *cache
+	stateMtx               sync.RWMutex
+	state                  *serverState
+	cacheConfig            *iCache.Config
 	store                  storage.Store
 	manager                *plugins.Manager
 	decisionIDFactory      func() string
 	logger                 func(context.Context, *Info) error
 	errLimit               int
 	pprofEnabled           bool
+	benchEnabled           bool
+	benchMtx               sync.Mutex
 	runtime                *ast.Term
 	httpListeners          []httpListener
 	metrics                Metrics
-	defaultDecisionPath    string
 	interQueryBuiltinCache iCache.InterQueryCache
+	httpTransportPool      *topdown.HTTPTransportPool
 	allPluginsOkOnce       bool
 	distributedTracingOpts tracing.Options
+	builtinSpanThreshold   time.Duration
 	ndbCacheEnabled        bool
 	unixSocketPerm         *string
 	cipherSuites           *[]uint16
+	inputSchemaValidation  bool
+	subscriptions          subscriptions
+	decisionLimits         decisionLimits
+	drainer                *drainer
+	queryLimits            *serverLimitsPlugin.Query
+	inputPool              *ast.InternPool
+	jwtVerifier            *identifier.JWTVerifier
+	queryCacheLookups      *prometheus.CounterVec
 }
 
 // Metrics defines the interface that the server requires for recording HTTP
@@ -178,13 +215,19 @@ type Loop func() error
 
 // New returns a new Server.
 func New() *Server {
-	s := Server{}
+	s := Server{drainer: newDrainer()}
 	return &s
 }
 
 // Init initializes the server. This function MUST be called before starting any loops
 // from s.Listeners().
 func (s *Server) Init(ctx context.Context) (*Server, error) {
+	var err error
+	s.interQueryBuiltinCache, err = s.initInterQueryBuiltinCache(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	s.initRouters(ctx)
 
 	txn, err := s.store.NewTransaction(ctx, storage.WriteParams)
@@ -202,13 +245,28 @@ func (s *Server) Init(ctx context.Context) (*Server, error) {
 		return nil, err
 	}
 
-	s.partials = map[string]rego.PartialResult{}
-	s.preparedEvalQueries = newCache(pqMaxCacheSize)
-	s.defaultDecisionPath = s.generateDefaultDecisionPath()
 	s.manager.RegisterNDCacheTrigger(s.updateNDCache)
 
+	if s.authentication == AuthenticationJWT {
+		s.jwtVerifier, err = s.initJWTVerifier()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	s.Handler = s.initHandlerAuthn(s.Handler)
 
+	if err := s.initLimits(); err != nil {
+		return nil, err
+	}
+
+	s.inputPool, err = s.initInputInterning()
+	if err != nil {
+		return nil, err
+	}
+
+	s.queryCacheLookups = s.initQueryCacheLookups()
+
 	// compression handler
 	s.Handler, err = s.initHandlerCompression(s.Handler)
 	if err != nil {
@@ -216,6 +274,11 @@ func (s *Server) Init(ctx context.Context) (*Server, error) {
 	}
 	s.DiagnosticHandler = s.initHandlerAuthn(s.DiagnosticHandler)
 
+	s.httpTransportPool, err = s.initHTTPTransportPool()
+	if err != nil {
+		return nil, err
+	}
+
 	return s, s.store.Commit(ctx, txn)
 }
 
@@ -223,6 +286,10 @@ func (s *Server) Init(ctx context.Context) (*Server, error) {
 // currently in use by the OPA Server. If any exceed the deadline specified
 // by the context an error will be returned.
 func (s *Server) Shutdown(ctx context.Context) error {
+	// NOTE: The subscriptions are closed first, as the listeners wait for
+	// their responses to complete.
+	s.subscriptions.close()
+
 	errChan := make(chan error)
 	for _, srvr := range s.httpListeners {
 		go func(s httpListener) {
@@ -261,6 +328,13 @@ func (s *Server) WithDiagnosticAddresses(addrs []string) *Server {
 	return s
 }
 
+// WithGRPCAddresses sets the listening addresses that the server will bind to
+// and serve the gRPC API on.
+func (s *Server) WithGRPCAddresses(addrs []string) *Server {
+	s.grpcAddrs = addrs
+	return s
+}
+
 // WithAuthentication sets authentication scheme to use on the server.
 func (s *Server) WithAuthentication(scheme AuthenticationScheme) *Server {
 	s.authentication = scheme
@@ -339,6 +413,12 @@ func (s *Server) WithPprofEnabled(pprofEnabled bool) *Server {
 	return s
 }
 
+// WithBenchEnabled sets whether the benchmark endpoint is enabled
+func (s *Server) WithBenchEnabled(benchEnabled bool) *Server {
+	s.benchEnabled = benchEnabled
+	return s
+}
+
 // WithH2CEnabled sets whether h2c ("HTTP/2 cleartext") is enabled for the http listener
 func (s *Server) WithH2CEnabled(enabled bool) *Server {
 	s.h2cEnabled = enabled
@@ -395,12 +475,31 @@ func (s *Server) WithDistributedTracingOpts(opts tracing.Options) *Server {
 	return s
 }
 
+// WithBuiltinSpanThreshold sets the minimum duration of the built-in function
+// calls recorded as child spans of the request spans. Calls are not recorded
+// if the threshold is zero, which is the default.
+func (s *Server) WithBuiltinSpanThreshold(d time.Duration) *Server {
+	s.builtinSpanThreshold = d
+	return s
+}
+
 // WithNDBCacheEnabled sets whether the ND builtins cache is to be used.
 func (s *Server) WithNDBCacheEnabled(ndbCacheEnabled bool) *Server {
 	s.ndbCacheEnabled = ndbCacheEnabled
 	return s
 }
 
+// WithInputSchemaValidation sets whether the inputs of the v1 data API are
+// validated against the input schema annotated on the requested rules or
+// package. Requests with invalid inputs are rejected with a 400 response
+// describing the violations of the schema, instead of being evaluated. The
+// schemas are declared with annotations, so the parser options of the plugin
+// manager must enable annotation processing.
+func (s *Server) WithInputSchemaValidation(enabled bool) *Server {
+	s.inputSchemaValidation = enabled
+	return s
+}
+
 // WithCipherSuites sets the list of enabled TLS 1.0–1.2 cipher suites.
 func (s *Server) WithCipherSuites(cipherSuites *[]uint16) *Server {
 	s.cipherSuites = cipherSuites
@@ -437,6 +536,31 @@ func (s *Server) Listeners() ([]Loop, error) {
 		}
 	}
 
+	for _, addr := range s.grpcAddrs {
+		l, listener, err := s.getGRPCListener(addr)
+		if err != nil {
+			return nil, err
+		}
+		s.httpListeners = append(s.httpListeners, listener)
+		loops = append(loops, l)
+	}
+
+	// The TLS config is shared by the listeners, and reloaded by a single loop.
+	if s.cert != nil {
+		logger := s.manager.Logger().WithFields(map[string]interface{}{
+			"cert-file":     s.certFile,
+			"cert-key-file": s.certKeyFile,
+		})
+
+		// if a manual cert refresh period has been set, then use the polling behavior,
+		// otherwise use the fsnotify default behavior
+		if s.certRefresh > 0 {
+			loops = append(loops, s.certLoopPolling(logger))
+		} else if s.certFile != "" || s.certPoolFile != "" {
+			loops = append(loops, s.certLoopNotify(logger))
+		}
+	}
+
 	return loops, nil
 }
 
@@ -453,6 +577,13 @@ func (s *Server) DiagnosticAddrs() []string {
 	return s.addrsForType(diagnosticListenerType)
 }
 
+// GRPCAddrs returns a list of addresses that the server is listening on for
+// the gRPC API.
+// If the server hasn't been started it will not return an address.
+func (s *Server) GRPCAddrs() []string {
+	return s.addrsForType(grpcListenerType)
+}
+
 func (s *Server) addrsForType(t httpListenerType) []string {
 	var addrs []string
 	for _, l := range s.httpListeners {
@@ -489,6 +620,7 @@ type httpListenerType int
 const (
 	defaultListenerType httpListenerType = iota
 	diagnosticListenerType
+	grpcListenerType
 )
 
 type httpListener interface {
@@ -597,23 +729,15 @@ func (s *Server) getListener(addr string, h http.Handler, t httpListenerType) ([
 	case "unix":
 		loop, listener, err = s.getListenerForUNIXSocket(parsedURL, h, t)
 		loops = []Loop{loop}
+	case "fd":
+		loop, listener, err = s.getListenerForActivatedSocket(parsedURL, h, t)
+		loops = []Loop{loop}
 	case "http":
 		loop, listener, err = s.getListenerForHTTPServer(parsedURL, h, t)
 		loops = []Loop{loop}
 	case "https":
 		loop, listener, err = s.getListenerForHTTPSServer(parsedURL, h, t)
-		logger := s.manager.Logger().WithFields(map[string]interface{}{
-			"cert-file":     s.certFile,
-			"cert-key-file": s.certKeyFile,
-		})
-
-		// if a manual cert refresh period has been set, then use the polling behavior,
-		// otherwise use the fsnotify default behavior
-		if s.certRefresh > 0 {
-			loops = []Loop{loop, s.certLoopPolling(logger)}
-		} else if s.certFile != "" || s.certPoolFile != "" {
-			loops = []Loop{loop, s.certLoopNotify(logger)}
-		}
+		loops = []Loop{loop}
 	default:
 		err = fmt.Errorf("invalid url scheme %q", parsedURL.Scheme)
 	}
@@ -642,7 +766,23 @@ func (s *Server) getListenerForHTTPSServer(u *url.URL, h http.Handler, t httpLis
 		return nil, nil, fmt.Errorf("TLS certificate required but not supplied")
 	}
 
-	tlsConfig := tls.Config{
+	httpsServer := http.Server{
+		Addr:      u.Host,
+		Handler:   h,
+		TLSConfig: s.serverTLSConfig(),
+	}
+
+	l := newHTTPListener(&httpsServer, t)
+
+	httpsLoop := func() error { return l.ListenAndServeTLS("", "") }
+
+	return httpsLoop, l, nil
+}
+
+// serverTLSConfig returns the TLS configuration of the HTTPS and gRPC listeners.
+// Each connection is served with the latest certificate and cert pool.
+func (s *Server) serverTLSConfig() *tls.Config {
+	return &tls.Config{
 		GetCertificate: s.getCertificate,
 		// GetConfigForClient is used to ensure that a fresh config is provided containing the latest cert pool.
 		// This is not required, but appears to be how connect time updates config should be done:
@@ -673,21 +813,25 @@ func (s *Server) getListenerForHTTPSServer(u *url.URL, h http.Handler, t httpLis
 			return cfg, nil
 		},
 	}
+}
 
-	httpsServer := http.Server{
-		Addr:      u.Host,
-		Handler:   h,
-		TLSConfig: &tlsConfig,
+func (s *Server) getListenerForUNIXSocket(u *url.URL, h http.Handler, t httpListenerType) (Loop, httpListener, error) {
+	unixListener, err := s.listenUNIXSocket(u)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	l := newHTTPListener(&httpsServer, t)
+	domainSocketServer := http.Server{Handler: h}
 
-	httpsLoop := func() error { return l.ListenAndServeTLS("", "") }
+	l := newHTTPUnixSocketListener(&domainSocketServer, unixListener, t)
 
-	return httpsLoop, l, nil
+	domainSocketLoop := func() error { return domainSocketServer.Serve(unixListener) }
+	return domainSocketLoop, l, nil
 }
 
-func (s *Server) getListenerForUNIXSocket(u *url.URL, h http.Handler, t httpListenerType) (Loop, httpListener, error) {
+// listenUNIXSocket listens on the socket at the path of u, with the configured
+// permissions.
+func (s *Server) listenUNIXSocket(u *url.URL) (net.Listener, error) {
 	socketPath := u.Host + u.Path
 
 	// Recover @ prefix for abstract Unix sockets.
@@ -698,27 +842,39 @@ func (s *Server) getListenerForUNIXSocket(u *url.URL, h http.Handler, t httpList
 		os.Remove(socketPath)
 	}
 
-	domainSocketServer := http.Server{Handler: h}
 	unixListener, err := net.Listen("unix", socketPath)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	if s.unixSocketPerm != nil {
 		modeVal, err := strconv.ParseUint(*s.unixSocketPerm, 8, 32)
 		if err != nil {
-			return nil, nil, err
+			unixListener.Close()
+			return nil, err
 		}
 
 		if err := os.Chmod(socketPath, os.FileMode(modeVal)); err != nil {
-			return nil, nil, err
+			unixListener.Close()
+			return nil, err
 		}
 	}
 
-	l := newHTTPUnixSocketListener(&domainSocketServer, unixListener, t)
+	return unixListener, nil
+}
 
-	domainSocketLoop := func() error { return domainSocketServer.Serve(unixListener) }
-	return domainSocketLoop, l, nil
+func (s *Server) getListenerForActivatedSocket(u *url.URL, h http.Handler, t httpListenerType) (Loop, httpListener, error) {
+	activatedListener, err := activation.listen(u)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	activatedServer := http.Server{Handler: h}
+
+	l := newHTTPUnixSocketListener(&activatedServer, activatedListener, t)
+
+	activatedLoop := func() error { return activatedServer.Serve(activatedListener) }
+	return activatedLoop, l, nil
 }
 
 func (s *Server) initHandlerAuthn(handler http.Handler) http.Handler {
@@ -727,6 +883,8 @@ func (s *Server) initHandlerAuthn(handler http.Handler) http.Handler {
 		handler = identifier.NewTokenBased(handler)
 	case AuthenticationTLS:
 		handler = identifier.NewTLSBased(handler)
+	case AuthenticationJWT:
+		handler = identifier.NewJWTBased(handler, s.jwtVerifier, s.manager.Logger())
 	}
 
 	return handler
@@ -768,6 +926,85 @@ func (s *Server) initHandlerCompression(handler http.Handler) (http.Handler, err
 	return compressHandler, nil
 }
 
+// initQueryCacheLookups returns the counter of the lookups of prepared queries
+// in the cache, by result, registered on the Prometheus registry of the manager
+// if any.
+func (s *Server) initQueryCacheLookups() *prometheus.CounterVec {
+	lookups := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "server_query_cache_lookups",
+		Help: "A count of lookups of prepared queries in the cache of the server, by result.",
+	}, []string{"result"})
+
+	if r := s.manager.PrometheusRegister(); r != nil {
+		if err := r.Register(lookups); err != nil {
+			var are prometheus.AlreadyRegisteredError
+			if errors.As(err, &are) {
+				if existing, ok := are.ExistingCollector.(*prometheus.CounterVec); ok {
+					return existing
+				}
+			}
+			s.manager.Logger().Error("Failed to register query cache metric: %v.", err)
+		}
+	}
+
+	return lookups
+}
+
+func (s *Server) initLimits() error {
+	var limitsRawConfig json.RawMessage
+	serverConfig := s.manager.Config.Server
+	if serverConfig != nil {
+		limitsRawConfig = serverConfig.Limits
+	}
+	limitsConfig, err := serverLimitsPlugin.NewConfigBuilder().WithBytes(limitsRawConfig).Parse()
+	if err != nil {
+		return err
+	}
+	s.decisionLimits = newDecisionLimits(limitsConfig)
+	s.queryLimits = limitsConfig.Query
+	return nil
+}
+
+func (s *Server) initInputInterning() (*ast.InternPool, error) {
+	var decodingRawConfig json.RawMessage
+	serverConfig := s.manager.Config.Server
+	if serverConfig != nil {
+		decodingRawConfig = serverConfig.Decoding
+	}
+	decodingConfig, err := serverDecodingPlugin.NewConfigBuilder().WithBytes(decodingRawConfig).Parse()
+	if err != nil {
+		return nil, err
+	}
+	if decodingConfig.Interning == nil {
+		return nil, nil
+	}
+	return ast.NewInternPool(*decodingConfig.Interning.MaxEntries, *decodingConfig.Interning.MaxStringLength), nil
+}
+
+func (s *Server) initJWTVerifier() (*identifier.JWTVerifier, error) {
+	var authnRawConfig json.RawMessage
+	serverConfig := s.manager.Config.Server
+	if serverConfig != nil {
+		authnRawConfig = serverConfig.Authentication
+	}
+	authnConfig, err := serverAuthenticationPlugin.NewConfigBuilder().WithBytes(authnRawConfig).Parse()
+	if err != nil {
+		return nil, err
+	}
+	if authnConfig.JWT == nil {
+		return nil, errors.New("jwt authentication requires server.authentication.jwt configuration")
+	}
+	return identifier.NewJWTVerifier(authnConfig.JWT, &http.Client{Timeout: jwksFetchTimeout}), nil
+}
+
+func (s *Server) initHTTPTransportPool() (*topdown.HTTPTransportPool, error) {
+	transportConfig, err := topdown.ParseHTTPTransportConfig(s.manager.Config.HTTPSend)
+	if err != nil {
+		return nil, fmt.Errorf("invalid http_send configuration: %w", err)
+	}
+	return topdown.NewHTTPTransportPool(transportConfig), nil
+}
+
 func (s *Server) initRouters(ctx context.Context) {
 	mainRouter := s.router
 	if mainRouter == nil {
@@ -777,7 +1014,8 @@ func (s *Server) initRouters(ctx context.Context) {
 	diagRouter := mux.NewRouter()
 
 	// authorizer, if configured, needs the iCache to be set up already
-	s.interQueryBuiltinCache = iCache.NewInterQueryCacheWithContext(ctx, s.manager.InterQueryBuiltinCacheConfig())
+	s.cacheConfig = s.manager.InterQueryBuiltinCacheConfig()
+	s.state = s.newState()
 	s.manager.RegisterCacheTrigger(s.updateCacheConfig)
 
 	// Add authorization handler. This must come BEFORE authentication handler
@@ -817,18 +1055,26 @@ func (s *Server) initRouters(ctx context.Context) {
 		mainRouter.HandleFunc("/debug/pprof/trace", pprof.Trace)
 	}
 
+	if s.benchEnabled {
+		mainRouter.Handle("/debug/bench/{path:.+}", s.instrumentHandler(s.debugBenchPost, PromHandlerDebugBench)).Methods(http.MethodPost)
+	}
+
 	// Only the main mainRouter gets the OPA API's (data, policies, query, etc)
-	mainRouter.Handle("/v0/data/{path:.+}", s.instrumentHandler(s.v0DataPost, PromHandlerV0Data)).Methods(http.MethodPost)
-	mainRouter.Handle("/v0/data", s.instrumentHandler(s.v0DataPost, PromHandlerV0Data)).Methods(http.MethodPost)
+	mainRouter.Handle("/v0/data/{path:.+}", s.instrumentHandler(s.trackDecisions(s.limitDecisions(s.v0DataPost)), PromHandlerV0Data)).Methods(http.MethodPost)
+	mainRouter.Handle("/v0/data", s.instrumentHandler(s.trackDecisions(s.limitDecisions(s.v0DataPost)), PromHandlerV0Data)).Methods(http.MethodPost)
 	mainRouter.Handle("/v1/data/{path:.+}", s.instrumentHandler(s.v1DataDelete, PromHandlerV1Data)).Methods(http.MethodDelete)
 	mainRouter.Handle("/v1/data/{path:.+}", s.instrumentHandler(s.v1DataPut, PromHandlerV1Data)).Methods(http.MethodPut)
 	mainRouter.Handle("/v1/data", s.instrumentHandler(s.v1DataPut, PromHandlerV1Data)).Methods(http.MethodPut)
-	mainRouter.Handle("/v1/data/{path:.+}", s.instrumentHandler(s.v1DataGet, PromHandlerV1Data)).Methods(http.MethodGet)
-	mainRouter.Handle("/v1/data", s.instrumentHandler(s.v1DataGet, PromHandlerV1Data)).Methods(http.MethodGet)
+	mainRouter.Handle("/v1/data/{path:.+}", s.instrumentHandler(s.trackDecisions(s.limitDecisions(s.v1DataGet)), PromHandlerV1Data)).Methods(http.MethodGet)
+	mainRouter.Handle("/v1/data", s.instrumentHandler(s.trackDecisions(s.limitDecisions(s.v1DataGet)), PromHandlerV1Data)).Methods(http.MethodGet)
 	mainRouter.Handle("/v1/data/{path:.+}", s.instrumentHandler(s.v1DataPatch, PromHandlerV1Data)).Methods(http.MethodPatch)
 	mainRouter.Handle("/v1/data", s.instrumentHandler(s.v1DataPatch, PromHandlerV1Data)).Methods(http.MethodPatch)
-	mainRouter.Handle("/v1/data/{path:.+}", s.instrumentHandler(s.v1DataPost, PromHandlerV1Data)).Methods(http.MethodPost)
-	mainRouter.Handle("/v1/data", s.instrumentHandler(s.v1DataPost, PromHandlerV1Data)).Methods(http.MethodPost)
+	mainRouter.Handle("/v1/data/{path:.+}", s.instrumentHandler(s.trackDecisions(s.limitDecisions(s.v1DataPost)), PromHandlerV1Data)).Methods(http.MethodPost)
+	mainRouter.Handle("/v1/data", s.instrumentHandler(s.trackDecisions(s.limitDecisions(s.v1DataPost)), PromHandlerV1Data)).Methods(http.MethodPost)
+	mainRouter.Handle("/v1/batch/data/{path:.+}", s.instrumentHandler(s.trackDecisions(s.limitDecisions(s.v1BatchDataPost)), PromHandlerV1Batch)).Methods(http.MethodPost)
+	mainRouter.Handle("/v1/batch/data", s.instrumentHandler(s.trackDecisions(s.limitDecisions(s.v1BatchDataPost)), PromHandlerV1Batch)).Methods(http.MethodPost)
+	mainRouter.Handle("/v1/subscribe/data/{path:.+}", s.instrumentHandler(s.trackDecisions(s.v1SubscribeDataPost), PromHandlerV1Subscribe)).Methods(http.MethodPost)
+	mainRouter.Handle("/v1/subscribe/data", s.instrumentHandler(s.trackDecisions(s.v1SubscribeDataPost), PromHandlerV1Subscribe)).Methods(http.MethodPost)
 	mainRouter.Handle("/v1/policies", s.instrumentHandler(s.v1PoliciesList, PromHandlerV1Policies)).Methods(http.MethodGet)
 	mainRouter.Handle("/v1/policies/{path:.+}", s.instrumentHandler(s.v1PoliciesDelete, PromHandlerV1Policies)).Methods(http.MethodDelete)
 	mainRouter.Handle("/v1/policies/{path:.+}", s.instrumentHandler(s.v1PoliciesGet, PromHandlerV1Policies)).Methods(http.MethodGet)
@@ -838,7 +1084,9 @@ func (s *Server) initRouters(ctx context.Context) {
 	mainRouter.Handle("/v1/compile", s.instrumentHandler(s.v1CompilePost, PromHandlerV1Compile)).Methods(http.MethodPost)
 	mainRouter.Handle("/v1/config", s.instrumentHandler(s.v1ConfigGet, PromHandlerV1Config)).Methods(http.MethodGet)
 	mainRouter.Handle("/v1/status", s.instrumentHandler(s.v1StatusGet, PromHandlerV1Status)).Methods(http.MethodGet)
-	mainRouter.Handle("/", s.instrumentHandler(s.unversionedPost, PromHandlerIndex)).Methods(http.MethodPost)
+	mainRouter.Handle("/v1/bundles", s.instrumentHandler(s.v1BundlesGet, PromHandlerV1Bundles)).Methods(http.MethodGet)
+	mainRouter.Handle("/v1/drain", s.instrumentHandler(s.v1DrainPost, PromHandlerV1Drain)).Methods(http.MethodPost)
+	mainRouter.Handle("/", s.instrumentHandler(s.trackDecisions(s.limitDecisions(s.unversionedPost)), PromHandlerIndex)).Methods(http.MethodPost)
 	mainRouter.Handle("/", s.instrumentHandler(s.indexGet, PromHandlerIndex)).Methods(http.MethodGet)
 
 	// These are catch all handlers that respond http.StatusMethodNotAllowed for resources that exist but the method is not allowed
@@ -910,12 +1158,23 @@ func (s *Server) execQuery(ctx context.Context, br bundleRevisions, txn storage.
 		rego.Runtime(s.runtime),
 		rego.UnsafeBuiltins(unsafeBuiltinsMap),
 		rego.InterQueryBuiltinCache(s.interQueryBuiltinCache),
+		rego.HTTPTransportPool(s.httpTransportPool),
 		rego.PrintHook(s.manager.PrintHook()),
 		rego.EnablePrintStatements(s.manager.EnablePrintStatements()),
 		rego.DistributedTracingOpts(s.distributedTracingOpts),
+		rego.BuiltinSpanThreshold(s.builtinSpanThreshold),
 		rego.NDBuiltinCache(ndbCache),
 	}
 
+	if s.queryLimits != nil {
+		if s.queryLimits.MaxResults != nil {
+			opts = append(opts, rego.MaxResultCount(*s.queryLimits.MaxResults))
+		}
+		if s.queryLimits.MaxResultBytes != nil {
+			opts = append(opts, rego.MaxResultBytes(*s.queryLimits.MaxResultBytes))
+		}
+	}
+
 	for _, r := range s.manager.GetWasmResolvers() {
 		for _, entrypoint := range r.Entrypoints() {
 			opts = append(opts, rego.Resolver(entrypoint, r))
@@ -997,19 +1256,71 @@ func getRevisions(ctx context.Context, store storage.Store, txn storage.Transact
 	return br, nil
 }
 
-func (s *Server) reload(context.Context, storage.Transaction, storage.TriggerEvent) {
+func (s *Server) reload(_ context.Context, _ storage.Transaction, event storage.TriggerEvent) {
+
+	// NOTE: Read transactions do not provide critical sections in the server,
+	// as stores may commit while they are open (see inmem.OptSnapshotReads).
+	// The state that is derived from the store is therefore replaced rather
+	// than reset, and must be obtained through newTransaction.
+	s.stateMtx.Lock()
+	s.state = s.newState()
+	s.stateMtx.Unlock()
+
+	s.subscriptions.notify(event)
+}
+
+// serverState is the state of the server that is derived from the policies and
+// data in the store. A new state replaces the state each time the store
+// changes, so that decisions read on snapshots that predate the change never
+// fill the caches of the new state.
+type serverState struct {
+	partials            map[string]rego.PartialResult
+	preparedEvalQueries *cache
+	inputSchemas        *cache
+	spanAttributes      *cache
+	defaultDecisionPath string
+	interQueryRuleCache iCache.InterQueryRuleCache
+	interQueryBaseCache iCache.InterQueryBaseCache
+}
+
+// newState returns a new state. The caller must hold stateMtx, or have
+// exclusive access to the server.
+func (s *Server) newState() *serverState {
+	return &serverState{
+		partials:            map[string]rego.PartialResult{},
+		preparedEvalQueries: newCache(pqMaxCacheSize),
+		inputSchemas:        newCache(pqMaxCacheSize),
+		spanAttributes:      newCache(pqMaxCacheSize),
+		defaultDecisionPath: s.generateDefaultDecisionPath(),
+		interQueryRuleCache: iCache.NewInterQueryRuleCache(s.cacheConfig),
+		interQueryBaseCache: iCache.NewInterQueryBaseCache(),
+	}
+}
 
-	// NOTE(tsandall): We currently rely on the storage txn to provide
-	// critical sections in the server.
-	//
-	// If you modify this function to change any other state on the server, you must
-	// review the other places in the server where that state is accessed to avoid data
-	// races--the state must be accessed _after_ a txn has been opened.
+func (s *Server) getState() *serverState {
+	s.stateMtx.RLock()
+	defer s.stateMtx.RUnlock()
+	return s.state
+}
 
-	// reset some cached info
-	s.partials = map[string]rego.PartialResult{}
-	s.preparedEvalQueries = newCache(pqMaxCacheSize)
-	s.defaultDecisionPath = s.generateDefaultDecisionPath()
+// newTransaction opens a read transaction, and returns it along with the state
+// of the server that was derived from the policies and data it reads. The state
+// is obtained before and after the transaction is opened, and the transaction
+// is opened again if a commit replaced the state in between. This relies on
+// stores running the triggers of a commit before transactions opened after it
+// can read its changes.
+func (s *Server) newTransaction(ctx context.Context, params ...storage.TransactionParams) (storage.Transaction, *serverState, error) {
+	for {
+		state := s.getState()
+		txn, err := s.store.NewTransaction(ctx, params...)
+		if err != nil {
+			return nil, nil, err
+		}
+		if s.getState() == state {
+			return txn, state, nil
+		}
+		s.store.Abort(ctx, txn)
+	}
 }
 
 func (s *Server) unversionedPost(w http.ResponseWriter, r *http.Request) {
@@ -1028,14 +1339,14 @@ func (s *Server) v0QueryPath(w http.ResponseWriter, r *http.Request, urlPath str
 	ctx := logging.WithDecisionID(r.Context(), decisionID)
 	annotateSpan(ctx, decisionID)
 
-	input, goInput, err := readInputV0(r)
+	input, goInput, err := readInputV0(r, s.inputPool)
 	if err != nil {
 		writer.ErrorString(w, http.StatusBadRequest, types.CodeInvalidParameter, fmt.Errorf("unexpected parse error for input: %w", err))
 		return
 	}
 
 	// Prepare for query.
-	txn, err := s.store.NewTransaction(ctx)
+	txn, state, err := s.newTransaction(ctx)
 	if err != nil {
 		writer.ErrorAuto(w, err)
 		return
@@ -1055,13 +1366,15 @@ func (s *Server) v0QueryPath(w http.ResponseWriter, r *http.Request, urlPath str
 
 	logger := s.getDecisionLogger(br)
 
+	s.annotateDecisionSpan(ctx, state, urlPath)
+
 	var ndbCache builtins.NDBCache
 	if s.ndbCacheEnabled {
 		ndbCache = builtins.NDBCache{}
 	}
 
 	pqID := "v0QueryPath::" + urlPath
-	preparedQuery, ok := s.getCachedPreparedEvalQuery(pqID, m)
+	preparedQuery, ok := s.getCachedPreparedEvalQuery(state, pqID, m)
 	if !ok {
 		opts := []func(*rego.Rego){
 			rego.Compiler(s.getCompiler()),
@@ -1090,7 +1403,7 @@ func (s *Server) v0QueryPath(w http.ResponseWriter, r *http.Request, urlPath str
 			return
 		}
 		preparedQuery = &pq
-		s.preparedEvalQueries.Insert(pqID, preparedQuery)
+		state.preparedEvalQueries.Insert(pqID, preparedQuery)
 	}
 
 	evalOpts := []rego.EvalOption{
@@ -1098,6 +1411,9 @@ func (s *Server) v0QueryPath(w http.ResponseWriter, r *http.Request, urlPath str
 		rego.EvalParsedInput(input),
 		rego.EvalMetrics(m),
 		rego.EvalInterQueryBuiltinCache(s.interQueryBuiltinCache),
+		rego.EvalInterQueryRuleCache(s.ruleCache(state)),
+		rego.EvalInterQueryBaseCache(s.baseCache(state)),
+		rego.EvalHTTPTransportPool(s.httpTransportPool),
 		rego.EvalNDBuiltinCache(ndbCache),
 	}
 
@@ -1140,13 +1456,15 @@ func (s *Server) v0QueryPath(w http.ResponseWriter, r *http.Request, urlPath str
 	writer.JSONOK(w, rs[0].Expressions[0].Value, pretty(r))
 }
 
-func (s *Server) getCachedPreparedEvalQuery(key string, m metrics.Metrics) (*rego.PreparedEvalQuery, bool) {
-	pq, ok := s.preparedEvalQueries.Get(key)
+func (s *Server) getCachedPreparedEvalQuery(state *serverState, key string, m metrics.Metrics) (*rego.PreparedEvalQuery, bool) {
+	pq, ok := state.preparedEvalQueries.Get(key)
 	m.Counter(metrics.ServerQueryCacheHit) // Creates the counter on the metrics if it doesn't exist, starts at 0
 	if ok {
 		m.Counter(metrics.ServerQueryCacheHit).Incr() // Increment counter on hit
+		s.queryCacheLookups.WithLabelValues("hit").Inc()
 		return pq.(*rego.PreparedEvalQuery), true
 	}
+	s.queryCacheLookups.WithLabelValues("miss").Inc()
 	return nil, false
 }
 
@@ -1212,6 +1530,22 @@ func (s *Server) unversionedGetHealth(w http.ResponseWriter, r *http.Request) {
 	for _, name := range excludePlugin {
 		excludePluginMap[name] = struct{}{}
 	}
+	includeChecks := getBoolParam(r.URL, types.ParamChecksV1, true)
+	includeReady := getBoolParam(r.URL, types.ParamReadyV1, true)
+	excludeCheckMap := map[string]struct{}{}
+	for _, name := range getStringSliceParam(r.URL, types.ParamExcludeCheckV1) {
+		excludeCheckMap[name] = struct{}{}
+	}
+
+	// Report the server as unhealthy while it drains, so that no new traffic
+	// is routed to it
+	if draining, inFlight := s.drainer.status(); draining {
+		writer.JSON(w, http.StatusInternalServerError, types.HealthResponseV1{
+			Error: errDraining.Error(),
+			Drain: &types.DrainV1{Draining: true, InFlightDecisions: inFlight},
+		}, false)
+		return
+	}
 
 	// Ensure the server can evaluate a simple query
 	if !s.canEval(ctx) {
@@ -1219,6 +1553,11 @@ func (s *Server) unversionedGetHealth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var checks map[string]types.HealthCheckV1
+	if includeChecks || includeReady {
+		checks = s.healthChecks(ctx, includeChecks, includeReady, excludeCheckMap)
+	}
+
 	pluginStatuses := s.manager.PluginStatus()
 
 	// Ensure that bundles (if configured, and requested to be included in the result)
@@ -1226,7 +1565,7 @@ func (s *Server) unversionedGetHealth(w http.ResponseWriter, r *http.Request) {
 	// normal bundles that are configured.
 	if includeBundleStatus && !s.bundlesReady(pluginStatuses) {
 		// For backwards compatibility we don't return a payload with statuses for the bundle endpoint
-		writeHealthResponse(w, errors.New("one or more bundles are not activated"))
+		writeHealthResponseWithChecks(w, errors.New("one or more bundles are not activated"), checks)
 		return
 	}
 
@@ -1243,14 +1582,61 @@ func (s *Server) unversionedGetHealth(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 		if hasErr {
-			writeHealthResponse(w, errors.New("one or more plugins are not up"))
+			writeHealthResponseWithChecks(w, errors.New("one or more plugins are not up"), checks)
+			return
+		}
+	}
+
+	for _, check := range checks {
+		if !check.OK {
+			writeHealthResponseWithChecks(w, errors.New("one or more health checks failed"), checks)
 			return
 		}
 	}
-	writeHealthResponse(w, nil)
+	writeHealthResponseWithChecks(w, nil, checks)
+}
+
+// healthReadyCheck is the name of the readiness predicate in the results of
+// the health API.
+const healthReadyCheck = "system.health.ready"
+
+// healthChecks runs the health checks registered by plugins, except the
+// excluded ones, if includeChecks is set, and the readiness predicate if
+// includeReady is set. It returns the results of the checks by name.
+func (s *Server) healthChecks(ctx context.Context, includeChecks, includeReady bool, exclude map[string]struct{}) map[string]types.HealthCheckV1 {
+	result := map[string]types.HealthCheckV1{}
+
+	if includeChecks {
+		for name, check := range s.manager.HealthChecks() {
+			if _, ok := exclude[name]; ok {
+				continue
+			}
+			result[name] = newHealthCheckV1(check(ctx))
+		}
+	}
+
+	if includeReady {
+		result[healthReadyCheck] = newHealthCheckV1(s.evalHealthPolicy(ctx, "ready"))
+	}
+
+	return result
+}
+
+func newHealthCheckV1(err error) types.HealthCheckV1 {
+	if err != nil {
+		return types.HealthCheckV1{Error: err.Error()}
+	}
+	return types.HealthCheckV1{OK: true}
 }
 
 func (s *Server) unversionedGetHealthWithPolicy(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	writeHealthResponse(w, s.evalHealthPolicy(r.Context(), vars["path"]))
+}
+
+// evalHealthPolicy evaluates the health policy rule at data.system.health
+// followed by urlPath, and returns an error unless it is true.
+func (s *Server) evalHealthPolicy(ctx context.Context, urlPath string) error {
 	pluginStatus := s.manager.PluginStatus()
 	pluginState := map[string]string{}
 
@@ -1278,14 +1664,15 @@ func (s *Server) unversionedGetHealthWithPolicy(w http.ResponseWriter, r *http.R
 			s.allPluginsOkOnce = true
 		}
 
+		draining, _ := s.drainer.status()
+
 		return map[string]interface{}{
 			"plugin_state":  pluginState,
 			"plugins_ready": s.allPluginsOkOnce,
+			"draining":      draining,
 		}
 	}()
 
-	vars := mux.Vars(r)
-	urlPath := vars["path"]
 	healthDataPath := fmt.Sprintf("/system/health/%s", urlPath)
 	healthDataPath = stringPathToDataRef(healthDataPath).String()
 
@@ -1298,33 +1685,34 @@ func (s *Server) unversionedGetHealthWithPolicy(w http.ResponseWriter, r *http.R
 		rego.PrintHook(s.manager.PrintHook()),
 	)
 
-	rs, err := rego.Eval(r.Context())
+	rs, err := rego.Eval(ctx)
 	if err != nil {
-		writeHealthResponse(w, err)
-		return
+		return err
 	}
 
 	if len(rs) == 0 {
-		writeHealthResponse(w, fmt.Errorf("health check (%v) was undefined", healthDataPath))
-		return
+		return fmt.Errorf("health check (%v) was undefined", healthDataPath)
 	}
 
 	result, ok := rs[0].Expressions[0].Value.(bool)
 	if ok && result {
-		writeHealthResponse(w, nil)
-		return
+		return nil
 	}
 
-	writeHealthResponse(w, fmt.Errorf("health check (%v) returned unexpected value", healthDataPath))
+	return fmt.Errorf("health check (%v) returned unexpected value", healthDataPath)
 }
 
 func writeHealthResponse(w http.ResponseWriter, err error) {
+	writeHealthResponseWithChecks(w, err, nil)
+}
+
+func writeHealthResponseWithChecks(w http.ResponseWriter, err error, checks map[string]types.HealthCheckV1) {
 	if err != nil {
-		writer.JSON(w, http.StatusInternalServerError, types.HealthResponseV1{Error: err.Error()}, false)
+		writer.JSON(w, http.StatusInternalServerError, types.HealthResponseV1{Error: err.Error(), Checks: checks}, false)
 		return
 	}
 
-	writer.JSONOK(w, types.HealthResponseV1{}, false)
+	writer.JSONOK(w, types.HealthResponseV1{Checks: checks}, false)
 }
 
 func (s *Server) v1CompilePost(w http.ResponseWriter, r *http.Request) {
@@ -1406,8 +1794,9 @@ func (s *Server) v1CompilePost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var i interface{} = types.PartialEvaluationResultV1{
-		Queries: pq.Queries,
-		Support: pq.Support,
+		Queries:    pq.Queries,
+		Support:    pq.Support,
+		Provenance: pq.Provenance,
 	}
 
 	result.Result = &i
@@ -1440,7 +1829,7 @@ func (s *Server) v1DataGet(w http.ResponseWriter, r *http.Request) {
 
 	if len(inputs) > 0 {
 		var err error
-		input, goInput, err = readInputGetV1(inputs[len(inputs)-1])
+		input, goInput, err = readInputGetV1(inputs[len(inputs)-1], s.inputPool)
 		if err != nil {
 			writer.ErrorString(w, http.StatusBadRequest, types.CodeInvalidParameter, err)
 			return
@@ -1451,7 +1840,7 @@ func (s *Server) v1DataGet(w http.ResponseWriter, r *http.Request) {
 
 	// Prepare for query.
 	c := storage.NewContext().WithMetrics(m)
-	txn, err := s.store.NewTransaction(ctx, storage.TransactionParams{Context: c})
+	txn, state, err := s.newTransaction(ctx, storage.TransactionParams{Context: c})
 	if err != nil {
 		writer.ErrorAuto(w, err)
 		return
@@ -1471,6 +1860,18 @@ func (s *Server) v1DataGet(w http.ResponseWriter, r *http.Request) {
 		ndbCache = builtins.NDBCache{}
 	}
 
+	s.annotateDecisionSpan(ctx, state, urlPath)
+
+	if err := s.validateInput(state, urlPath, goInput); err != nil {
+		_ = logger.Log(ctx, txn, urlPath, "", goInput, input, nil, ndbCache, err, m)
+		status := http.StatusBadRequest
+		if err.Code == types.CodeInternal {
+			status = http.StatusInternalServerError
+		}
+		writer.Error(w, status, err)
+		return
+	}
+
 	var buf *topdown.BufferTracer
 
 	if explainMode != types.ExplainOffV1 {
@@ -1482,7 +1883,7 @@ func (s *Server) v1DataGet(w http.ResponseWriter, r *http.Request) {
 		pqID += "strict-builtin-errors::"
 	}
 	pqID += urlPath
-	preparedQuery, ok := s.getCachedPreparedEvalQuery(pqID, m)
+	preparedQuery, ok := s.getCachedPreparedEvalQuery(state, pqID, m)
 	if !ok {
 		opts := []func(*rego.Rego){
 			rego.Compiler(s.getCompiler()),
@@ -1509,7 +1910,7 @@ func (s *Server) v1DataGet(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		preparedQuery = &pq
-		s.preparedEvalQueries.Insert(pqID, preparedQuery)
+		state.preparedEvalQueries.Insert(pqID, preparedQuery)
 	}
 
 	evalOpts := []rego.EvalOption{
@@ -1518,6 +1919,9 @@ func (s *Server) v1DataGet(w http.ResponseWriter, r *http.Request) {
 		rego.EvalMetrics(m),
 		rego.EvalQueryTracer(buf),
 		rego.EvalInterQueryBuiltinCache(s.interQueryBuiltinCache),
+		rego.EvalInterQueryRuleCache(s.ruleCache(state)),
+		rego.EvalInterQueryBaseCache(s.baseCache(state)),
+		rego.EvalHTTPTransportPool(s.httpTransportPool),
 		rego.EvalInstrument(includeInstrumentation),
 		rego.EvalNDBuiltinCache(ndbCache),
 	}
@@ -1594,52 +1998,58 @@ func (s *Server) v1DataPatch(w http.ResponseWriter, r *http.Request) {
 	}
 	m.Timer(metrics.RegoInputParse).Stop()
 
-	patches, err := s.prepareV1PatchSlice(vars["path"], ops)
-	if err != nil {
-		writer.ErrorAuto(w, err)
+	if status, err := s.patchData(ctx, m, vars["path"], ops); err != nil {
+		writer.Error(w, status, err)
+		return
+	}
+
+	if includeMetrics(r) {
+		result := types.DataResponseV1{
+			Metrics: m.All(),
+		}
+		writer.JSONOK(w, result, false)
 		return
 	}
 
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// patchData applies the patch operations to the documents beneath root.
+func (s *Server) patchData(ctx context.Context, m metrics.Metrics, root string, ops []types.PatchV1) (int, *types.ErrorV1) {
+	patches, err := s.prepareV1PatchSlice(root, ops)
+	if err != nil {
+		return writer.AutoError(err)
+	}
+
 	params := storage.WriteParams
 	params.Context = storage.NewContext().WithMetrics(m)
 	txn, err := s.store.NewTransaction(ctx, params)
 	if err != nil {
-		writer.ErrorAuto(w, err)
-		return
+		return writer.AutoError(err)
 	}
 
 	for _, patch := range patches {
 		if err := s.checkPathScope(ctx, txn, patch.path); err != nil {
-			s.abortAuto(ctx, txn, w, err)
-			return
+			s.store.Abort(ctx, txn)
+			return writer.AutoError(err)
 		}
 
-		if err := s.store.Write(ctx, txn, patch.op, patch.path, patch.value); err != nil {
-			s.abortAuto(ctx, txn, w, err)
-			return
+		if err := s.writeData(ctx, txn, patch.op, patch.path, patch.value); err != nil {
+			s.store.Abort(ctx, txn)
+			return writer.AutoError(err)
 		}
 	}
 
 	if err := ast.CheckPathConflicts(s.getCompiler(), storage.NonEmpty(ctx, s.store, txn)); len(err) > 0 {
 		s.store.Abort(ctx, txn)
-		writer.ErrorString(w, http.StatusBadRequest, types.CodeInvalidParameter, err)
-		return
+		return http.StatusBadRequest, types.NewErrorV1(types.CodeInvalidParameter, err.Error())
 	}
 
 	if err := s.store.Commit(ctx, txn); err != nil {
-		writer.ErrorAuto(w, err)
-		return
-	}
-
-	if includeMetrics(r) {
-		result := types.DataResponseV1{
-			Metrics: m.All(),
-		}
-		writer.JSONOK(w, result, false)
-		return
+		return writer.AutoError(err)
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	return http.StatusNoContent, nil
 }
 
 func (s *Server) v1DataPost(w http.ResponseWriter, r *http.Request) {
@@ -1656,10 +2066,11 @@ func (s *Server) v1DataPost(w http.ResponseWriter, r *http.Request) {
 	includeInstrumentation := getBoolParam(r.URL, types.ParamInstrumentV1, true)
 	provenance := getBoolParam(r.URL, types.ParamProvenanceV1, true)
 	strictBuiltinErrors := getBoolParam(r.URL, types.ParamStrictBuiltinErrors, true)
+	admission := getBoolParam(r.URL, types.ParamAdmissionV1, true)
 
 	m.Timer(metrics.RegoInputParse).Start()
 
-	input, goInput, err := readInputPostV1(r)
+	input, goInput, err := readInputPostV1(r, s.inputPool)
 	if err != nil {
 		writer.ErrorString(w, http.StatusBadRequest, types.CodeInvalidParameter, err)
 		return
@@ -1667,7 +2078,7 @@ func (s *Server) v1DataPost(w http.ResponseWriter, r *http.Request) {
 
 	m.Timer(metrics.RegoInputParse).Stop()
 
-	txn, err := s.store.NewTransaction(ctx, storage.TransactionParams{Context: storage.NewContext().WithMetrics(m)})
+	txn, state, err := s.newTransaction(ctx, storage.TransactionParams{Context: storage.NewContext().WithMetrics(m)})
 	if err != nil {
 		writer.ErrorAuto(w, err)
 		return
@@ -1688,9 +2099,21 @@ func (s *Server) v1DataPost(w http.ResponseWriter, r *http.Request) {
 		ndbCache = builtins.NDBCache{}
 	}
 
-	var buf *topdown.BufferTracer
+	s.annotateDecisionSpan(ctx, state, urlPath)
 
-	if explainMode != types.ExplainOffV1 {
+	if err := s.validateInput(state, urlPath, goInput); err != nil {
+		_ = logger.Log(ctx, txn, urlPath, "", goInput, input, nil, ndbCache, err, m)
+		status := http.StatusBadRequest
+		if err.Code == types.CodeInternal {
+			status = http.StatusInternalServerError
+		}
+		writer.Error(w, status, err)
+		return
+	}
+
+	var buf *topdown.BufferTracer
+
+	if explainMode != types.ExplainOffV1 {
 		buf = topdown.NewBufferTracer()
 	}
 
@@ -1699,7 +2122,7 @@ func (s *Server) v1DataPost(w http.ResponseWriter, r *http.Request) {
 		pqID += "strict-builtin-errors::"
 	}
 	pqID += urlPath
-	preparedQuery, ok := s.getCachedPreparedEvalQuery(pqID, m)
+	preparedQuery, ok := s.getCachedPreparedEvalQuery(state, pqID, m)
 	if !ok {
 		opts := []func(*rego.Rego){
 			rego.Compiler(s.getCompiler()),
@@ -1728,7 +2151,7 @@ func (s *Server) v1DataPost(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		preparedQuery = &pq
-		s.preparedEvalQueries.Insert(pqID, preparedQuery)
+		state.preparedEvalQueries.Insert(pqID, preparedQuery)
 	}
 
 	evalOpts := []rego.EvalOption{
@@ -1737,6 +2160,9 @@ func (s *Server) v1DataPost(w http.ResponseWriter, r *http.Request) {
 		rego.EvalMetrics(m),
 		rego.EvalQueryTracer(buf),
 		rego.EvalInterQueryBuiltinCache(s.interQueryBuiltinCache),
+		rego.EvalInterQueryRuleCache(s.ruleCache(state)),
+		rego.EvalInterQueryBaseCache(s.baseCache(state)),
+		rego.EvalHTTPTransportPool(s.httpTransportPool),
 		rego.EvalInstrument(includeInstrumentation),
 		rego.EvalNDBuiltinCache(ndbCache),
 	}
@@ -1790,6 +2216,14 @@ func (s *Server) v1DataPost(w http.ResponseWriter, r *http.Request) {
 
 	result.Result = &rs[0].Expressions[0].Value
 
+	if admission {
+		if _, err := decision.Parse(*result.Result); err != nil {
+			_ = logger.Log(ctx, txn, urlPath, "", goInput, input, result.Result, ndbCache, err, m)
+			writer.ErrorString(w, http.StatusInternalServerError, types.CodeInvalidDecision, err)
+			return
+		}
+	}
+
 	if explainMode != types.ExplainOffV1 {
 		result.Explanation = s.getExplainResponse(explainMode, *buf, pretty(r))
 	}
@@ -1801,6 +2235,208 @@ func (s *Server) v1DataPost(w http.ResponseWriter, r *http.Request) {
 	writer.JSONOK(w, result, pretty(r))
 }
 
+// v1BatchDataPost evaluates the decisions of a batch of inputs against the
+// same snapshot of the store. Every decision gets its own decision ID and is
+// logged separately. Errors of single decisions are reported in their
+// responses, so that the other decisions of the batch are not affected.
+//
+// The paths of the decisions are relative to the path of the request URL, so
+// that the authorization of the request covers all decisions of the batch.
+func (s *Server) v1BatchDataPost(w http.ResponseWriter, r *http.Request) {
+	m := metrics.New()
+	m.Timer(metrics.ServerHandler).Start()
+
+	ctx := r.Context()
+	urlPath := mux.Vars(r)["path"]
+	includeInstrumentation := getBoolParam(r.URL, types.ParamInstrumentV1, true)
+	provenance := getBoolParam(r.URL, types.ParamProvenanceV1, true)
+	strictBuiltinErrors := getBoolParam(r.URL, types.ParamStrictBuiltinErrors, true)
+
+	m.Timer(metrics.RegoInputParse).Start()
+
+	request, err := readBatchRequestV1(r)
+	if err != nil {
+		writer.ErrorString(w, http.StatusBadRequest, types.CodeInvalidParameter, err)
+		return
+	}
+
+	m.Timer(metrics.RegoInputParse).Stop()
+
+	txn, state, err := s.newTransaction(ctx, storage.TransactionParams{Context: storage.NewContext().WithMetrics(m)})
+	if err != nil {
+		writer.ErrorAuto(w, err)
+		return
+	}
+
+	defer s.store.Abort(ctx, txn)
+
+	br, err := getRevisions(ctx, s.store, txn)
+	if err != nil {
+		writer.ErrorAuto(w, err)
+		return
+	}
+
+	logger := s.getDecisionLogger(br)
+
+	result := types.BatchDataResponseV1{
+		Responses: make([]types.BatchDataResponseItemV1, len(request.Inputs)),
+	}
+
+	// NOTE: The request was admitted under the limit of the URL path already.
+	admitted := s.decisionLimits.find(urlPath)
+
+	for i, item := range request.Inputs {
+		path := batchItemPath(urlPath, item.Path)
+		result.Responses[i] = s.evalLimitedDecision(ctx, txn, state, logger, admitted, path, item.Input, strictBuiltinErrors, includeInstrumentation, includeMetrics(r))
+	}
+
+	m.Timer(metrics.ServerHandler).Stop()
+
+	if includeMetrics(r) || includeInstrumentation {
+		result.Metrics = m.All()
+	}
+
+	if provenance {
+		result.Provenance = s.getProvenance(br)
+	}
+
+	writer.JSONOK(w, result, pretty(r))
+}
+
+// batchItemPath returns the path of a decision of a batch, relative to the
+// path of the request URL.
+func batchItemPath(urlPath, itemPath string) string {
+	itemPath = strings.Trim(itemPath, "/")
+	urlPath = strings.Trim(urlPath, "/")
+	switch {
+	case itemPath == "":
+		return urlPath
+	case urlPath == "":
+		return itemPath
+	}
+	return urlPath + "/" + itemPath
+}
+
+// evalLimitedDecision evaluates a decision of a batch under the limit of its
+// path, unless it is the limit the batch request was admitted under. Decisions
+// exceeding their limit are not evaluated, and their responses contain an
+// error.
+func (s *Server) evalLimitedDecision(ctx context.Context, txn storage.Transaction, state *serverState, logger decisionLogger, admitted *decisionLimit, path string, goInput *interface{}, strictBuiltinErrors, includeInstrumentation, includeMetrics bool) types.BatchDataResponseItemV1 {
+	if limit := s.decisionLimits.find(path); limit != nil && limit != admitted {
+		limitCtx, release, err := limit.acquire(ctx)
+		if err != nil {
+			return types.BatchDataResponseItemV1{Error: types.NewErrorV1(types.CodeTooManyRequests, "%s", err.msg)}
+		}
+		defer release()
+		ctx = limitCtx
+	}
+	return s.evalDecision(ctx, txn, state, logger, path, goInput, strictBuiltinErrors, includeInstrumentation, includeMetrics)
+}
+
+// evalDecision evaluates and logs a single decision of a batch or a
+// subscription.
+func (s *Server) evalDecision(ctx context.Context, txn storage.Transaction, state *serverState, logger decisionLogger, urlPath string, goInput *interface{}, strictBuiltinErrors, includeInstrumentation, includeMetrics bool) types.BatchDataResponseItemV1 {
+	m := metrics.New()
+
+	decisionID := s.generateDecisionID()
+	ctx = logging.WithDecisionID(ctx, decisionID)
+
+	result := types.BatchDataResponseItemV1{
+		DecisionID: decisionID,
+	}
+
+	fail := func(input ast.Value, err error) types.BatchDataResponseItemV1 {
+		_ = logger.Log(ctx, txn, urlPath, "", goInput, input, nil, nil, err, m)
+		if e, ok := err.(*types.ErrorV1); ok {
+			result.Error = e
+		} else {
+			_, result.Error = writer.AutoError(err)
+		}
+		return result
+	}
+
+	var input ast.Value
+	if goInput != nil {
+		var err error
+		input, err = inputValue(*goInput, s.inputPool)
+		if err != nil {
+			return fail(nil, types.BadRequestErr(err.Error()))
+		}
+	} else {
+		result.Warning = types.NewWarning(types.CodeAPIUsageWarn, types.MsgInputKeyMissing)
+	}
+
+	if err := s.validateInput(state, urlPath, goInput); err != nil {
+		return fail(input, err)
+	}
+
+	var ndbCache builtins.NDBCache
+	if s.ndbCacheEnabled {
+		ndbCache = builtins.NDBCache{}
+	}
+
+	// NOTE: The prepared queries are shared with v1DataPost.
+	pqID := "v1DataPost::"
+	if strictBuiltinErrors {
+		pqID += "strict-builtin-errors::"
+	}
+	pqID += urlPath
+	preparedQuery, ok := s.getCachedPreparedEvalQuery(state, pqID, m)
+	if !ok {
+		opts := []func(*rego.Rego){
+			rego.Compiler(s.getCompiler()),
+			rego.Store(s.store),
+		}
+
+		for _, r := range s.manager.GetWasmResolvers() {
+			for _, entrypoint := range r.Entrypoints() {
+				opts = append(opts, rego.Resolver(entrypoint, r))
+			}
+		}
+
+		rego, err := s.makeRego(ctx, strictBuiltinErrors, txn, input, urlPath, m, includeInstrumentation, nil, opts)
+		if err != nil {
+			return fail(input, err)
+		}
+
+		pq, err := rego.PrepareForEval(ctx)
+		if err != nil {
+			return fail(input, err)
+		}
+		preparedQuery = &pq
+		state.preparedEvalQueries.Insert(pqID, preparedQuery)
+	}
+
+	rs, err := preparedQuery.Eval(
+		ctx,
+		rego.EvalTransaction(txn),
+		rego.EvalParsedInput(input),
+		rego.EvalMetrics(m),
+		rego.EvalInterQueryBuiltinCache(s.interQueryBuiltinCache),
+		rego.EvalInterQueryRuleCache(s.ruleCache(state)),
+		rego.EvalInterQueryBaseCache(s.baseCache(state)),
+		rego.EvalHTTPTransportPool(s.httpTransportPool),
+		rego.EvalInstrument(includeInstrumentation),
+		rego.EvalNDBuiltinCache(ndbCache),
+	)
+	if err != nil {
+		return fail(input, err)
+	}
+
+	if includeMetrics || includeInstrumentation {
+		result.Metrics = m.All()
+	}
+
+	if len(rs) > 0 {
+		result.Result = &rs[0].Expressions[0].Value
+	}
+
+	if err := logger.Log(ctx, txn, urlPath, "", goInput, input, result.Result, ndbCache, nil, m); err != nil {
+		_, result.Error = writer.AutoError(err)
+	}
+	return result
+}
+
 func (s *Server) v1DataPut(w http.ResponseWriter, r *http.Request) {
 	m := metrics.New()
 	m.Timer(metrics.ServerHandler).Start()
@@ -1823,62 +2459,74 @@ func (s *Server) v1DataPut(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	status, err := s.putData(ctx, m, path, value, r.Header.Get("If-None-Match") == "*")
+	if err != nil {
+		writer.Error(w, status, err)
+		return
+	} else if status == http.StatusNotModified {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if includeMetrics(r) {
+		result := types.DataResponseV1{
+			Metrics: m.All(),
+		}
+		writer.JSONOK(w, result, false)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// putData writes value at path, creating the missing parent documents. If
+// ifNoneMatch is true, an existing document is not overwritten and the status
+// is http.StatusNotModified.
+func (s *Server) putData(ctx context.Context, m metrics.Metrics, path storage.Path, value interface{}, ifNoneMatch bool) (int, *types.ErrorV1) {
 	params := storage.WriteParams
 	params.Context = storage.NewContext().WithMetrics(m)
 	txn, err := s.store.NewTransaction(ctx, params)
 	if err != nil {
-		writer.ErrorAuto(w, err)
-		return
+		return writer.AutoError(err)
 	}
 
 	if err := s.checkPathScope(ctx, txn, path); err != nil {
-		s.abortAuto(ctx, txn, w, err)
-		return
+		s.store.Abort(ctx, txn)
+		return writer.AutoError(err)
 	}
 
 	_, err = s.store.Read(ctx, txn, path)
 	if err != nil {
 		if !storage.IsNotFound(err) {
-			s.abortAuto(ctx, txn, w, err)
-			return
+			s.store.Abort(ctx, txn)
+			return writer.AutoError(err)
 		}
 		if len(path) > 0 {
 			if err := storage.MakeDir(ctx, s.store, txn, path[:len(path)-1]); err != nil {
-				s.abortAuto(ctx, txn, w, err)
-				return
+				s.store.Abort(ctx, txn)
+				return writer.AutoError(err)
 			}
 		}
-	} else if r.Header.Get("If-None-Match") == "*" {
+	} else if ifNoneMatch {
 		s.store.Abort(ctx, txn)
-		w.WriteHeader(http.StatusNotModified)
-		return
+		return http.StatusNotModified, nil
 	}
 
-	if err := s.store.Write(ctx, txn, storage.AddOp, path, value); err != nil {
-		s.abortAuto(ctx, txn, w, err)
-		return
+	if err := s.writeData(ctx, txn, storage.AddOp, path, value); err != nil {
+		s.store.Abort(ctx, txn)
+		return writer.AutoError(err)
 	}
 
 	if err := ast.CheckPathConflicts(s.getCompiler(), storage.NonEmpty(ctx, s.store, txn)); len(err) > 0 {
 		s.store.Abort(ctx, txn)
-		writer.ErrorString(w, http.StatusBadRequest, types.CodeInvalidParameter, err)
-		return
+		return http.StatusBadRequest, types.NewErrorV1(types.CodeInvalidParameter, err.Error())
 	}
 
 	if err := s.store.Commit(ctx, txn); err != nil {
-		writer.ErrorAuto(w, err)
-		return
+		return writer.AutoError(err)
 	}
 
-	if includeMetrics(r) {
-		result := types.DataResponseV1{
-			Metrics: m.All(),
-		}
-		writer.JSONOK(w, result, false)
-		return
-	}
-
-	w.WriteHeader(http.StatusNoContent)
+	return http.StatusNoContent, nil
 }
 
 func (s *Server) v1DataDelete(w http.ResponseWriter, r *http.Request) {
@@ -1895,44 +2543,64 @@ func (s *Server) v1DataDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if status, err := s.deleteData(ctx, m, path); err != nil {
+		writer.Error(w, status, err)
+		return
+	}
+
+	if includeMetrics(r) {
+		result := types.DataResponseV1{
+			Metrics: m.All(),
+		}
+		writer.JSONOK(w, result, false)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteData removes the document at path.
+func (s *Server) deleteData(ctx context.Context, m metrics.Metrics, path storage.Path) (int, *types.ErrorV1) {
 	params := storage.WriteParams
 	params.Context = storage.NewContext().WithMetrics(m)
 	txn, err := s.store.NewTransaction(ctx, params)
 	if err != nil {
-		writer.ErrorAuto(w, err)
-		return
+		return writer.AutoError(err)
 	}
 
 	if err := s.checkPathScope(ctx, txn, path); err != nil {
-		s.abortAuto(ctx, txn, w, err)
-		return
+		s.store.Abort(ctx, txn)
+		return writer.AutoError(err)
 	}
 
 	_, err = s.store.Read(ctx, txn, path)
 	if err != nil {
-		s.abortAuto(ctx, txn, w, err)
-		return
+		s.store.Abort(ctx, txn)
+		return writer.AutoError(err)
 	}
 
-	if err := s.store.Write(ctx, txn, storage.RemoveOp, path, nil); err != nil {
-		s.abortAuto(ctx, txn, w, err)
-		return
+	if err := s.writeData(ctx, txn, storage.RemoveOp, path, nil); err != nil {
+		s.store.Abort(ctx, txn)
+		return writer.AutoError(err)
 	}
 
 	if err := s.store.Commit(ctx, txn); err != nil {
-		writer.ErrorAuto(w, err)
-		return
+		return writer.AutoError(err)
 	}
 
-	if includeMetrics(r) {
-		result := types.DataResponseV1{
-			Metrics: m.All(),
-		}
-		writer.JSONOK(w, result, false)
-		return
-	}
+	return http.StatusNoContent, nil
+}
 
-	w.WriteHeader(http.StatusNoContent)
+// writeData writes value at path in txn, and notifies the data write hooks
+// registered on the plugin manager.
+func (s *Server) writeData(ctx context.Context, txn storage.Transaction, op storage.PatchOp, path storage.Path, value interface{}) error {
+	if err := s.manager.BeforeDataWrite(ctx, txn, op, path, value); err != nil {
+		return err
+	}
+	if err := s.store.Write(ctx, txn, op, path, value); err != nil {
+		return err
+	}
+	return s.manager.AfterDataWrite(ctx, txn, op, path, value)
 }
 
 func (s *Server) v1PoliciesDelete(w http.ResponseWriter, r *http.Request) {
@@ -1946,23 +2614,39 @@ func (s *Server) v1PoliciesDelete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	m := metrics.New()
+
+	if status, err := s.deletePolicy(ctx, m, id); err != nil {
+		writer.Error(w, status, err)
+		return
+	}
+
+	resp := types.PolicyDeleteResponseV1{}
+	if includeMetrics(r) {
+		resp.Metrics = m.All()
+	}
+
+	writer.JSONOK(w, resp, pretty(r))
+}
+
+// deletePolicy deletes the policy module id, unless the remaining modules fail
+// to compile without it.
+func (s *Server) deletePolicy(ctx context.Context, m metrics.Metrics, id string) (int, *types.ErrorV1) {
 	params := storage.WriteParams
 	params.Context = storage.NewContext().WithMetrics(m)
 	txn, err := s.store.NewTransaction(ctx, params)
 	if err != nil {
-		writer.ErrorAuto(w, err)
-		return
+		return writer.AutoError(err)
 	}
 
 	if err := s.checkPolicyIDScope(ctx, txn, id); err != nil {
-		s.abortAuto(ctx, txn, w, err)
-		return
+		s.store.Abort(ctx, txn)
+		return writer.AutoError(err)
 	}
 
 	modules, err := s.loadModules(ctx, txn)
 	if err != nil {
-		s.abortAuto(ctx, txn, w, err)
-		return
+		s.store.Abort(ctx, txn)
+		return writer.AutoError(err)
 	}
 
 	delete(modules, id)
@@ -1972,30 +2656,22 @@ func (s *Server) v1PoliciesDelete(w http.ResponseWriter, r *http.Request) {
 	m.Timer(metrics.RegoModuleCompile).Start()
 
 	if c.Compile(modules); c.Failed() {
-		s.abort(ctx, txn, func() {
-			writer.Error(w, http.StatusBadRequest, types.NewErrorV1(types.CodeInvalidOperation, types.MsgCompileModuleError).WithASTErrors(c.Errors))
-		})
-		return
+		s.store.Abort(ctx, txn)
+		return http.StatusBadRequest, types.NewErrorV1(types.CodeInvalidOperation, types.MsgCompileModuleError).WithASTErrors(c.Errors)
 	}
 
 	m.Timer(metrics.RegoModuleCompile).Stop()
 
 	if err := s.store.DeletePolicy(ctx, txn, id); err != nil {
-		s.abortAuto(ctx, txn, w, err)
-		return
+		s.store.Abort(ctx, txn)
+		return writer.AutoError(err)
 	}
 
 	if err := s.store.Commit(ctx, txn); err != nil {
-		writer.ErrorAuto(w, err)
-		return
-	}
-
-	resp := types.PolicyDeleteResponseV1{}
-	if includeMetrics(r) {
-		resp.Metrics = m.All()
+		return writer.AutoError(err)
 	}
 
-	writer.JSONOK(w, resp, pretty(r))
+	return http.StatusOK, nil
 }
 
 func (s *Server) v1PoliciesGet(w http.ResponseWriter, r *http.Request) {
@@ -2097,64 +2773,73 @@ func (s *Server) v1PoliciesPut(w http.ResponseWriter, r *http.Request) {
 
 	m.Timer("server_read_bytes").Stop()
 
+	if status, err := s.putPolicy(ctx, m, id, buf); err != nil {
+		writer.Error(w, status, err)
+		return
+	}
+
+	resp := types.PolicyPutResponseV1{}
+
+	if includeMetrics {
+		resp.Metrics = m.All()
+	}
+
+	writer.JSONOK(w, resp, pretty(r))
+}
+
+// putPolicy creates or updates the policy module id, if the modules compile
+// with it.
+func (s *Server) putPolicy(ctx context.Context, m metrics.Metrics, id string, buf []byte) (int, *types.ErrorV1) {
 	params := storage.WriteParams
 	params.Context = storage.NewContext().WithMetrics(m)
 	txn, err := s.store.NewTransaction(ctx, params)
 	if err != nil {
-		writer.ErrorAuto(w, err)
-		return
+		return writer.AutoError(err)
 	}
 
 	if err := s.checkPolicyIDScope(ctx, txn, id); err != nil && !storage.IsNotFound(err) {
-		s.abortAuto(ctx, txn, w, err)
-		return
+		s.store.Abort(ctx, txn)
+		return writer.AutoError(err)
 	}
 
 	if bs, err := s.store.GetPolicy(ctx, txn, id); err != nil {
 		if !storage.IsNotFound(err) {
-			s.abortAuto(ctx, txn, w, err)
-			return
+			s.store.Abort(ctx, txn)
+			return writer.AutoError(err)
 		}
 	} else if bytes.Equal(buf, bs) {
 		s.store.Abort(ctx, txn)
-		resp := types.PolicyPutResponseV1{}
-		if includeMetrics {
-			resp.Metrics = m.All()
-		}
-		writer.JSONOK(w, resp, pretty(r))
-		return
+		return http.StatusOK, nil
 	}
 
 	m.Timer(metrics.RegoModuleParse).Start()
-	parsedMod, err := ast.ParseModule(id, string(buf))
+	parsedMod, err := ast.ParseModuleWithOpts(id, string(buf), s.parserOptions())
 	m.Timer(metrics.RegoModuleParse).Stop()
 
 	if err != nil {
 		s.store.Abort(ctx, txn)
 		switch err := err.(type) {
 		case ast.Errors:
-			writer.Error(w, http.StatusBadRequest, types.NewErrorV1(types.CodeInvalidParameter, types.MsgCompileModuleError).WithASTErrors(err))
+			return http.StatusBadRequest, types.NewErrorV1(types.CodeInvalidParameter, types.MsgCompileModuleError).WithASTErrors(err)
 		default:
-			writer.ErrorString(w, http.StatusBadRequest, types.CodeInvalidParameter, err)
+			return http.StatusBadRequest, types.NewErrorV1(types.CodeInvalidParameter, err.Error())
 		}
-		return
 	}
 
 	if parsedMod == nil {
 		s.store.Abort(ctx, txn)
-		writer.Error(w, http.StatusBadRequest, types.NewErrorV1(types.CodeInvalidParameter, "empty module"))
-		return
+		return http.StatusBadRequest, types.NewErrorV1(types.CodeInvalidParameter, "empty module")
 	}
 
 	if err := s.checkPolicyPackageScope(ctx, txn, parsedMod.Package); err != nil {
-		s.abortAuto(ctx, txn, w, err)
-		return
+		s.store.Abort(ctx, txn)
+		return writer.AutoError(err)
 	}
 
 	modules, err := s.loadModules(ctx, txn)
 	if err != nil {
-		s.abortAuto(ctx, txn, w, err)
-		return
+		s.store.Abort(ctx, txn)
+		return writer.AutoError(err)
 	}
 
 	modules[id] = parsedMod
@@ -2167,31 +2852,22 @@ func (s *Server) v1PoliciesPut(w http.ResponseWriter, r *http.Request) {
 	m.Timer(metrics.RegoModuleCompile).Start()
 
 	if c.Compile(modules); c.Failed() {
-		s.abort(ctx, txn, func() {
-			writer.Error(w, http.StatusBadRequest, types.NewErrorV1(types.CodeInvalidParameter, types.MsgCompileModuleError).WithASTErrors(c.Errors))
-		})
-		return
+		s.store.Abort(ctx, txn)
+		return http.StatusBadRequest, types.NewErrorV1(types.CodeInvalidParameter, types.MsgCompileModuleError).WithASTErrors(c.Errors)
 	}
 
 	m.Timer(metrics.RegoModuleCompile).Stop()
 
 	if err := s.store.UpsertPolicy(ctx, txn, id, buf); err != nil {
-		s.abortAuto(ctx, txn, w, err)
-		return
+		s.store.Abort(ctx, txn)
+		return writer.AutoError(err)
 	}
 
 	if err := s.store.Commit(ctx, txn); err != nil {
-		writer.ErrorAuto(w, err)
-		return
-	}
-
-	resp := types.PolicyPutResponseV1{}
-
-	if includeMetrics {
-		resp.Metrics = m.All()
+		return writer.AutoError(err)
 	}
 
-	writer.JSONOK(w, resp, pretty(r))
+	return http.StatusOK, nil
 }
 
 func (s *Server) v1QueryGet(w http.ResponseWriter, r *http.Request) {
@@ -2287,7 +2963,7 @@ func (s *Server) v1QueryPost(w http.ResponseWriter, r *http.Request) {
 	var input ast.Value
 
 	if request.Input != nil {
-		input, err = ast.InterfaceToValue(*request.Input)
+		input, err = inputValue(*request.Input, s.inputPool)
 		if err != nil {
 			writer.ErrorAuto(w, err)
 			return
@@ -2349,6 +3025,162 @@ func (s *Server) v1StatusGet(w http.ResponseWriter, r *http.Request) {
 	writer.JSONOK(w, types.StatusResponseV1{Result: &st}, pretty(r))
 }
 
+func (s *Server) v1BundlesGet(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	txn, err := s.store.NewTransaction(ctx)
+	if err != nil {
+		writer.ErrorAuto(w, err)
+		return
+	}
+
+	defer s.store.Abort(ctx, txn)
+
+	names, err := bundle.ReadBundleNamesFromStore(ctx, s.store, txn)
+	if err != nil && !storage.IsNotFound(err) {
+		writer.ErrorAuto(w, err)
+		return
+	}
+
+	result := make(map[string]types.BundleV1, len(names))
+
+	for _, name := range names {
+		var b types.BundleV1
+		b.Revision, err = bundle.ReadBundleRevisionFromStore(ctx, s.store, txn, name)
+		if err != nil && !storage.IsNotFound(err) {
+			writer.ErrorAuto(w, err)
+			return
+		}
+		b.Roots, err = bundle.ReadBundleRootsFromStore(ctx, s.store, txn, name)
+		if err != nil && !storage.IsNotFound(err) {
+			writer.ErrorAuto(w, err)
+			return
+		}
+		b.Metadata, err = bundle.ReadBundleMetadataFromStore(ctx, s.store, txn, name)
+		if err != nil {
+			writer.ErrorAuto(w, err)
+			return
+		}
+		if b.Roots == nil {
+			b.Roots = []string{}
+		}
+		if b.Metadata == nil {
+			b.Metadata = map[string]interface{}{}
+		}
+		result[name] = b
+	}
+
+	writer.JSONOK(w, types.BundlesResponseV1{Result: result}, pretty(r))
+}
+
+// debugBenchPost evaluates the decision at the requested path repeatedly and
+// responds with a histogram of the evaluation latencies. Benchmarks are
+// bounded by a maximum number of iterations and a maximum duration, and only
+// one benchmark runs at a time.
+func (s *Server) debugBenchPost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	urlPath := mux.Vars(r)["path"]
+
+	request, err := readBenchRequest(r)
+	if err != nil {
+		writer.ErrorString(w, http.StatusBadRequest, types.CodeInvalidParameter, err)
+		return
+	}
+
+	input, iterations, duration := request.input, request.iterations, request.duration
+
+	if !s.benchMtx.TryLock() {
+		writer.ErrorString(w, http.StatusConflict, types.CodeResourceConflict, errors.New("benchmark already in progress"))
+		return
+	}
+	defer s.benchMtx.Unlock()
+
+	txn, state, err := s.newTransaction(ctx)
+	if err != nil {
+		writer.ErrorAuto(w, err)
+		return
+	}
+
+	defer s.store.Abort(ctx, txn)
+
+	pqID := "debugBenchPost::" + urlPath
+	preparedQuery, ok := s.getCachedPreparedEvalQuery(state, pqID, metrics.New())
+	if !ok {
+		opts := []func(*rego.Rego){
+			rego.Compiler(s.getCompiler()),
+			rego.Store(s.store),
+		}
+
+		for _, r := range s.manager.GetWasmResolvers() {
+			for _, entrypoint := range r.Entrypoints() {
+				opts = append(opts, rego.Resolver(entrypoint, r))
+			}
+		}
+
+		rego, err := s.makeRego(ctx, false, txn, input, urlPath, metrics.New(), false, nil, opts)
+		if err != nil {
+			writer.ErrorAuto(w, err)
+			return
+		}
+
+		pq, err := rego.PrepareForEval(ctx)
+		if err != nil {
+			writer.ErrorAuto(w, err)
+			return
+		}
+		preparedQuery = &pq
+		state.preparedEvalQueries.Insert(pqID, preparedQuery)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	hist := metrics.New().Histogram("latency")
+	start := time.Now()
+
+	var i int
+	for i = 0; i < iterations && ctx.Err() == nil; i++ {
+		var ndbCache builtins.NDBCache
+		if s.ndbCacheEnabled {
+			ndbCache = builtins.NDBCache{}
+		}
+
+		t0 := time.Now()
+		_, err := preparedQuery.Eval(
+			ctx,
+			rego.EvalTransaction(txn),
+			rego.EvalParsedInput(input),
+			rego.EvalInterQueryBuiltinCache(s.interQueryBuiltinCache),
+			rego.EvalInterQueryRuleCache(s.ruleCache(state)),
+			rego.EvalInterQueryBaseCache(s.baseCache(state)),
+			rego.EvalHTTPTransportPool(s.httpTransportPool),
+			rego.EvalNDBuiltinCache(ndbCache),
+		)
+		if err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				// The evaluation was cancelled because the benchmark ran out
+				// of time. It is not counted towards the results.
+				break
+			}
+			writer.ErrorAuto(w, err)
+			return
+		}
+		hist.Update(time.Since(t0).Nanoseconds())
+	}
+
+	result := types.BenchResponseV1{
+		Iterations: i,
+		DurationNs: time.Since(start).Nanoseconds(),
+		Latency:    hist.Value(),
+	}
+
+	if input == nil {
+		result.Warning = types.NewWarning(types.CodeAPIUsageWarn, types.MsgInputKeyMissing)
+	}
+
+	writer.JSONOK(w, result, pretty(r))
+}
+
 func (s *Server) checkPolicyIDScope(ctx context.Context, txn storage.Transaction, id string) error {
 
 	bs, err := s.store.GetPolicy(ctx, txn, id)
@@ -2356,7 +3188,7 @@ func (s *Server) checkPolicyIDScope(ctx context.Context, txn storage.Transaction
 		return err
 	}
 
-	module, err := ast.ParseModule(id, string(bs))
+	module, err := ast.ParseModuleWithOpts(id, string(bs), s.parserOptions())
 	if err != nil {
 		return err
 	}
@@ -2464,15 +3296,6 @@ func (s *Server) getExplainResponse(explainMode types.ExplainModeV1, trace []*to
 	return explanation
 }
 
-func (s *Server) abort(ctx context.Context, txn storage.Transaction, finish func()) {
-	s.store.Abort(ctx, txn)
-	finish()
-}
-
-func (s *Server) abortAuto(ctx context.Context, txn storage.Transaction, w http.ResponseWriter, err error) {
-	s.abort(ctx, txn, func() { writer.ErrorAuto(w, err) })
-}
-
 func (s *Server) loadModules(ctx context.Context, txn storage.Transaction) (map[string]*ast.Module, error) {
 
 	ids, err := s.store.ListPolicies(ctx, txn)
@@ -2488,7 +3311,7 @@ func (s *Server) loadModules(ctx context.Context, txn storage.Transaction) (map[
 			return nil, err
 		}
 
-		parsed, err := ast.ParseModule(id, string(bs))
+		parsed, err := ast.ParseModuleWithOpts(id, string(bs), s.parserOptions())
 		if err != nil {
 			return nil, err
 		}
@@ -2503,6 +3326,57 @@ func (s *Server) getCompiler() *ast.Compiler {
 	return s.manager.GetCompiler()
 }
 
+// parserOptions returns the options for parsing the modules of the policy
+// API. Annotations are processed if input schema validation or distributed
+// tracing is enabled.
+func (s *Server) parserOptions() ast.ParserOptions {
+	return ast.ParserOptions{ProcessAnnotation: s.inputSchemaValidation || len(s.distributedTracingOpts) > 0}
+}
+
+// validateInput validates the input against the input schema of the rules or
+// package at urlPath, if input schema validation is enabled. The compiled
+// schemas are cached until the policies change.
+func (s *Server) validateInput(state *serverState, urlPath string, input *interface{}) *types.ErrorV1 {
+	if !s.inputSchemaValidation || input == nil {
+		return nil
+	}
+
+	var schema *gojsonschema.Schema
+	if x, ok := state.inputSchemas.Get(urlPath); ok {
+		schema, _ = x.(*gojsonschema.Schema)
+	} else {
+		raw, err := s.getCompiler().InputSchema(stringPathToDataRef(urlPath))
+		if err != nil {
+			return types.NewErrorV1(types.CodeInternal, "invalid input schema: %v", err)
+		}
+		if raw != nil {
+			schema, err = gojsonschema.NewSchema(gojsonschema.NewGoLoader(raw))
+			if err != nil {
+				return types.NewErrorV1(types.CodeInternal, "invalid input schema: %v", err)
+			}
+		}
+		state.inputSchemas.Insert(urlPath, schema)
+	}
+
+	if schema == nil {
+		return nil
+	}
+
+	result, err := schema.Validate(gojsonschema.NewGoLoader(*input))
+	if err != nil {
+		return types.NewErrorV1(types.CodeInvalidParameter, "%v: %v", types.MsgInputSchemaError, err)
+	}
+	if result.Valid() {
+		return nil
+	}
+
+	e := types.NewErrorV1(types.CodeInvalidParameter, types.MsgInputSchemaError)
+	for _, re := range result.Errors() {
+		e = e.WithError(&types.SchemaErrorV1{Type: re.Type(), Field: re.Field(), Message: re.Description()})
+	}
+	return e
+}
+
 func (s *Server) makeRego(_ context.Context,
 	strictBuiltinErrors bool,
 	txn storage.Transaction,
@@ -2528,6 +3402,7 @@ func (s *Server) makeRego(_ context.Context,
 		rego.StrictBuiltinErrors(strictBuiltinErrors),
 		rego.PrintHook(s.manager.PrintHook()),
 		rego.DistributedTracingOpts(s.distributedTracingOpts),
+		rego.BuiltinSpanThreshold(s.builtinSpanThreshold),
 	)
 
 	return rego.New(opts...), nil
@@ -2630,8 +3505,66 @@ func isPathOwned(path, root []string) bool {
 	return true
 }
 
+// initInterQueryBuiltinCache returns the inter-query cache of the built-in
+// functions. If configured, the cache is persisted in the persistence
+// directory, so that it survives restarts, or shared with other instances
+// through a backend.
+func (s *Server) initInterQueryBuiltinCache(ctx context.Context) (iCache.InterQueryCache, error) {
+	config := s.manager.InterQueryBuiltinCacheConfig()
+	if config != nil && config.InterQueryBuiltinCache.Backend != nil {
+		backend, err := iCache.NewBackend(config.InterQueryBuiltinCache.Backend)
+		if err != nil {
+			return nil, fmt.Errorf("initialize inter-query cache: %w", err)
+		}
+		return iCache.NewDistributedInterQueryCache(ctx, config, backend, s.manager.Logger()), nil
+	}
+	if config == nil || !config.InterQueryBuiltinCache.Persist {
+		return iCache.NewInterQueryCacheWithContext(ctx, config), nil
+	}
+
+	dir, err := s.manager.Config.GetPersistenceDirectory()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := iCache.NewPersistentInterQueryCache(ctx, config, filepath.Join(dir, "cache"), s.manager.Logger())
+	if err != nil {
+		return nil, fmt.Errorf("initialize inter-query cache: %w", err)
+	}
+	return c, nil
+}
+
 func (s *Server) updateCacheConfig(cacheConfig *iCache.Config) {
 	s.interQueryBuiltinCache.UpdateConfig(cacheConfig)
+
+	if cacheConfig == nil {
+		return
+	}
+
+	// NOTE: The config is kept along with the state, so that the rule caches of
+	// the states created later use it too.
+	s.stateMtx.Lock()
+	defer s.stateMtx.Unlock()
+	s.cacheConfig = cacheConfig
+	s.state.interQueryRuleCache.UpdateConfig(cacheConfig)
+}
+
+// ruleCache returns the inter-query rule cache of state if it has been enabled
+// in the caching configuration.
+func (s *Server) ruleCache(state *serverState) iCache.InterQueryRuleCache {
+	if c := s.manager.InterQueryBuiltinCacheConfig(); c != nil && c.InterQueryRuleCache.Enabled {
+		return state.interQueryRuleCache
+	}
+	return nil
+}
+
+// baseCache returns the inter-query base document cache of state if it has
+// been enabled in the caching configuration.
+func (s *Server) baseCache(state *serverState) iCache.InterQueryBaseCache {
+	if c := s.manager.InterQueryBuiltinCacheConfig(); c != nil && c.InterQueryBaseCache.Enabled {
+		return state.interQueryBaseCache
+	}
+	return nil
 }
 
 func (s *Server) updateNDCache(enabled bool) {
@@ -2723,11 +3656,11 @@ func getExplain(p []string, zero types.ExplainModeV1) types.ExplainModeV1 {
 	return zero
 }
 
-func readInputV0(r *http.Request) (ast.Value, *interface{}, error) {
+func readInputV0(r *http.Request, pool *ast.InternPool) (ast.Value, *interface{}, error) {
 
 	parsed, ok := authorizer.GetBodyOnContext(r.Context())
 	if ok {
-		v, err := ast.InterfaceToValue(parsed)
+		v, err := inputValue(parsed, pool)
 		return v, &parsed, err
 	}
 
@@ -2756,26 +3689,26 @@ func readInputV0(r *http.Request) (ast.Value, *interface{}, error) {
 		}
 	}
 
-	v, err := ast.InterfaceToValue(x)
+	v, err := inputValue(x, pool)
 	return v, &x, err
 }
 
-func readInputGetV1(str string) (ast.Value, *interface{}, error) {
+func readInputGetV1(str string, pool *ast.InternPool) (ast.Value, *interface{}, error) {
 	var input interface{}
 	if err := util.UnmarshalJSON([]byte(str), &input); err != nil {
 		return nil, nil, fmt.Errorf("parameter contains malformed input document: %w", err)
 	}
-	v, err := ast.InterfaceToValue(input)
+	v, err := inputValue(input, pool)
 	return v, &input, err
 }
 
-func readInputPostV1(r *http.Request) (ast.Value, *interface{}, error) {
+func readInputPostV1(r *http.Request, pool *ast.InternPool) (ast.Value, *interface{}, error) {
 
 	parsed, ok := authorizer.GetBodyOnContext(r.Context())
 	if ok {
 		if obj, ok := parsed.(map[string]interface{}); ok {
 			if input, ok := obj["input"]; ok {
-				v, err := ast.InterfaceToValue(input)
+				v, err := inputValue(input, pool)
 				return v, &input, err
 			}
 		}
@@ -2814,10 +3747,105 @@ func readInputPostV1(r *http.Request) (ast.Value, *interface{}, error) {
 		return nil, nil, nil
 	}
 
-	v, err := ast.InterfaceToValue(*request.Input)
+	v, err := inputValue(*request.Input, pool)
 	return v, request.Input, err
 }
 
+// inputValue converts the decoded input document x to AST. Objects are
+// converted lazily, so that only the parts of large inputs that are read by
+// the policy are converted. The strings of the input are interned with pool,
+// which may be nil.
+func inputValue(x interface{}, pool *ast.InternPool) (ast.Value, error) {
+	if obj, ok := x.(map[string]interface{}); ok {
+		return pool.LazyObject(obj), nil
+	}
+	return pool.InterfaceToValue(x)
+}
+
+func readBatchRequestV1(r *http.Request) (*types.BatchDataRequestV1, error) {
+	var request types.BatchDataRequestV1
+
+	if parsed, ok := authorizer.GetBodyOnContext(r.Context()); ok {
+		bs, err := json.Marshal(parsed)
+		if err != nil {
+			return nil, err
+		}
+		if err := util.UnmarshalJSON(bs, &request); err != nil {
+			return nil, fmt.Errorf("body contains malformed batch request: %w", err)
+		}
+		return &request, nil
+	}
+
+	body, err := readPlainBody(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not decompress the body: %w", err)
+	}
+
+	if strings.Contains(r.Header.Get("Content-Type"), "yaml") {
+		bs, err := io.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		if err := util.Unmarshal(bs, &request); err != nil {
+			return nil, fmt.Errorf("body contains malformed batch request: %w", err)
+		}
+	} else if err := util.NewJSONDecoder(body).Decode(&request); err != nil {
+		return nil, fmt.Errorf("body contains malformed batch request: %w", err)
+	}
+
+	return &request, nil
+}
+
+type benchRequest struct {
+	input      ast.Value
+	iterations int
+	duration   time.Duration
+}
+
+func readBenchRequest(r *http.Request) (*benchRequest, error) {
+	var request types.BenchRequestV1
+
+	body, err := readPlainBody(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not decompress the body: %w", err)
+	}
+
+	dec := util.NewJSONDecoder(body)
+	if err := dec.Decode(&request); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("body contains malformed benchmark request: %w", err)
+	}
+
+	result := benchRequest{
+		iterations: benchDefaultIterations,
+		duration:   benchMaxDuration,
+	}
+
+	if request.Iterations < 0 || request.Iterations > benchMaxIterations {
+		return nil, fmt.Errorf("iterations must be between 1 and %d", benchMaxIterations)
+	} else if request.Iterations > 0 {
+		result.iterations = request.Iterations
+	}
+
+	if request.Duration != "" {
+		result.duration, err = time.ParseDuration(request.Duration)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration: %w", err)
+		}
+		if result.duration <= 0 || result.duration > benchMaxDuration {
+			return nil, fmt.Errorf("duration must be positive and at most %v", benchMaxDuration)
+		}
+	}
+
+	if request.Input != nil {
+		result.input, err = ast.InterfaceToValue(*request.Input)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &result, nil
+}
+
 type compileRequest struct {
 	Query    ast.Body
 	Input    ast.Value
@@ -3015,6 +4043,79 @@ func parseURL(s string, useHTTPSByDefault bool) (*url.URL, error) {
 	return url.Parse(s)
 }
 
+// annotateDecisionSpan sets the attributes declared under the
+// telemetry.attributes key of the custom metadata of the rules or package at
+// urlPath on the span of the request, if it is recorded. The attributes are
+// cached until the policies change.
+func (s *Server) annotateDecisionSpan(ctx context.Context, state *serverState, urlPath string) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+
+	var attrs []attribute.KeyValue
+	if x, ok := state.spanAttributes.Get(urlPath); ok {
+		attrs, _ = x.([]attribute.KeyValue)
+	} else {
+		attrs = telemetryAttributes(s.getCompiler().DecisionAnnotations(stringPathToDataRef(urlPath)))
+		state.spanAttributes.Insert(urlPath, attrs)
+	}
+
+	span.SetAttributes(attrs...)
+}
+
+// telemetryAttributes returns the span attributes declared by the annotations,
+// sorted by key. Attributes of later annotations override those of earlier
+// ones, so that narrower scopes take precedence.
+func telemetryAttributes(annots []*ast.Annotations) []attribute.KeyValue {
+	values := map[string]interface{}{}
+	for _, a := range annots {
+		telemetry, _ := a.Custom["telemetry"].(map[string]interface{})
+		attrs, _ := telemetry["attributes"].(map[string]interface{})
+		for k, v := range attrs {
+			values[k] = v
+		}
+	}
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	result := make([]attribute.KeyValue, 0, len(keys))
+	for _, k := range keys {
+		switch v := values[k].(type) {
+		case string:
+			result = append(result, attribute.String(k, v))
+		case bool:
+			result = append(result, attribute.Bool(k, v))
+		case int:
+			result = append(result, attribute.Int(k, v))
+		case int64:
+			result = append(result, attribute.Int64(k, v))
+		case float64:
+			result = append(result, attribute.Float64(k, v))
+		case json.Number:
+			if i, err := v.Int64(); err == nil {
+				result = append(result, attribute.Int64(k, i))
+			} else if f, err := v.Float64(); err == nil {
+				result = append(result, attribute.Float64(k, f))
+			} else {
+				result = append(result, attribute.String(k, v.String()))
+			}
+		default:
+			result = append(result, attribute.String(k, string(util.MustMarshalJSON(v))))
+		}
+	}
+
+	return result
+}
+
 func annotateSpan(ctx context.Context, decisionID string) {
 	if decisionID == "" {
 		return
@@ -692,6 +692,79 @@ func TestUnversionedGetHealthWithPolicyUsingPlugins(t *testing.T) {
 	validateDiagnosticRequest(t, f, readyReq, 200, `{}`)
 }
 
+func TestUnversionedGetHealthChecks(t *testing.T) {
+	ctx := context.Background()
+	store := inmem.New()
+	txn := storage.NewTransactionOrDie(ctx, store, storage.WriteParams)
+	healthPolicy := `package system.health
+
+  default ready = false
+
+  ready {
+    data.required.present
+  }
+  `
+
+	if err := store.UpsertPolicy(ctx, txn, "test", []byte(healthPolicy)); err != nil {
+		panic(err)
+	}
+
+	if err := store.Commit(ctx, txn); err != nil {
+		panic(err)
+	}
+
+	f := newFixtureWithStore(t, store)
+	f.server.manager.RegisterHealthCheck("db", func(context.Context) error {
+		return nil
+	})
+	f.server.manager.RegisterHealthCheck("cache", func(context.Context) error {
+		return errors.New("cache unreachable")
+	})
+
+	// checks are only run when requested
+	req := newReqUnversioned(http.MethodGet, "/health", "")
+	validateDiagnosticRequest(t, f, req, 200, `{}`)
+
+	req = newReqUnversioned(http.MethodGet, "/health?checks", "")
+	validateDiagnosticRequest(t, f, req, 500, `{
+		"error": "one or more health checks failed",
+		"checks": {
+			"db": {"ok": true},
+			"cache": {"ok": false, "error": "cache unreachable"}
+		}
+	}`)
+
+	req = newReqUnversioned(http.MethodGet, "/health?checks&exclude-check=cache", "")
+	validateDiagnosticRequest(t, f, req, 200, `{"checks": {"db": {"ok": true}}}`)
+
+	// the readiness predicate gates readiness on the required data
+	req = newReqUnversioned(http.MethodGet, "/health?ready", "")
+	validateDiagnosticRequest(t, f, req, 500, `{
+		"error": "one or more health checks failed",
+		"checks": {
+			"system.health.ready": {"ok": false, "error": "health check (data.system.health.ready) returned unexpected value"}
+		}
+	}`)
+
+	if err := f.v1(http.MethodPut, "/data/required", `{"present": true}`, 204, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	req = newReqUnversioned(http.MethodGet, "/health?ready&checks&exclude-check=cache", "")
+	validateDiagnosticRequest(t, f, req, 200, `{
+		"checks": {
+			"db": {"ok": true},
+			"system.health.ready": {"ok": true}
+		}
+	}`)
+
+	// unregistered checks are not run anymore
+	f.server.manager.UnregisterHealthCheck("cache")
+
+	req = newReqUnversioned(http.MethodGet, "/health?checks", "")
+	validateDiagnosticRequest(t, f, req, 200, `{"checks": {"db": {"ok": true}}}`)
+}
+
 func TestDataV0(t *testing.T) {
 	testMod1 := `package test
 
@@ -1078,6 +1151,211 @@ func TestCompileV1UnsafeBuiltin(t *testing.T) {
 	}
 }
 
+func TestCompileV1Provenance(t *testing.T) {
+	f := newFixture(t)
+
+	err := f.v1(http.MethodPut, "/policies/test", `package test
+
+p { q[input.x] }
+
+q[x] { x := input.y }`, 200, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compileReq := newReqV1(http.MethodPost, "/compile", `{
+		"query": "data.test.p = true",
+		"unknowns": ["input"],
+		"options": {"disableInlining": ["data.test.q"]}
+	}`)
+
+	f.reset()
+	f.server.Handler.ServeHTTP(f.recorder, compileReq)
+
+	var response struct {
+		Result struct {
+			Provenance []struct {
+				Ref      string        `json:"ref"`
+				Package  string        `json:"package"`
+				Location *ast.Location `json:"location"`
+			} `json:"provenance"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(f.recorder.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+
+	provenance := response.Result.Provenance
+	if len(provenance) != 1 || provenance[0].Ref != "data.partial.test.q" || provenance[0].Package != "data.test" || provenance[0].Location.Row != 5 {
+		t.Fatalf("unexpected provenance: %+v", provenance)
+	}
+}
+
+func TestDataV1SnapshotReads(t *testing.T) {
+	ctx := context.Background()
+	store := inmem.NewWithOpts(inmem.OptSnapshotReads(true))
+	f := newFixtureWithStore(t, store)
+
+	if err := f.v1(http.MethodPut, "/data/x", `1`, 204, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// The read transaction stands in for a decision that is being evaluated.
+	txn := storage.NewTransactionOrDie(ctx, store)
+	defer store.Abort(ctx, txn)
+
+	done := make(chan error)
+	go func() {
+		recorder := httptest.NewRecorder()
+		f.server.Handler.ServeHTTP(recorder, newReqV1(http.MethodPut, "/data/x", `2`))
+		if recorder.Code != 204 {
+			done <- fmt.Errorf("expected 204 but got %v: %v", recorder.Code, recorder.Body)
+			return
+		}
+		done <- nil
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected write not to wait for the open read transaction")
+	}
+
+	if err := f.v1(http.MethodGet, "/data/x", "", 200, `{"result": 2}`); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, err := store.Read(ctx, txn, storage.MustParsePath("/x")); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(value, json.Number("1")) {
+		t.Fatalf("expected the open read transaction to observe 1 but got %v", value)
+	}
+}
+
+// commitAfterOpenStore commits a write after it opens the next read
+// transaction, as if the write was committed concurrently.
+type commitAfterOpenStore struct {
+	storage.Store
+	commit func()
+}
+
+func (s *commitAfterOpenStore) NewTransaction(ctx context.Context, params ...storage.TransactionParams) (storage.Transaction, error) {
+	txn, err := s.Store.NewTransaction(ctx, params...)
+	if err == nil && (len(params) == 0 || !params[0].Write) && s.commit != nil {
+		commit := s.commit
+		s.commit = nil
+		commit()
+	}
+	return txn, err
+}
+
+func TestNewTransactionMatchesState(t *testing.T) {
+	ctx := context.Background()
+	inner := inmem.NewWithOpts(inmem.OptSnapshotReads(true))
+	store := &commitAfterOpenStore{Store: inner}
+	f := newFixtureWithStore(t, store)
+
+	if err := f.v1(http.MethodPut, "/data/x", `1`, 204, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	state := f.server.getState()
+	store.commit = func() {
+		if err := storage.WriteOne(ctx, inner, storage.AddOp, storage.MustParsePath("/x"), 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	txn, txnState, err := f.server.newTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Abort(ctx, txn)
+
+	// The first transaction read the snapshot before the commit, so it was
+	// opened again.
+	if txnState == state || txnState != f.server.getState() {
+		t.Fatal("expected the state of the commit")
+	}
+
+	if value, err := store.Read(ctx, txn, storage.MustParsePath("/x")); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(value, json.Number("2")) {
+		t.Fatalf("expected the transaction to observe 2 but got %v", value)
+	}
+}
+
+func TestReloadDuringSnapshotReads(t *testing.T) {
+	store := inmem.NewWithOpts(inmem.OptSnapshotReads(true))
+	f := newFixtureWithConfig(t, `{"caching": {
+		"inter_query_rule_cache": {"enabled": true},
+		"inter_query_base_cache": {"enabled": true}
+	}}`, func(s *Server) {
+		s.WithStore(store)
+	})
+
+	policy := func(i int) string {
+		return fmt.Sprintf("package test\n\nversion := %d\n\nx := data.x", i)
+	}
+
+	if err := f.v1(http.MethodPut, "/policies/test", policy(0), 200, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.v1(http.MethodPut, "/data/x", `0`, 204, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		f.server.Handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	stop := make(chan struct{})
+	errc := make(chan error, 4)
+	for i := 0; i < cap(errc); i++ {
+		go func() {
+			for {
+				select {
+				case <-stop:
+					errc <- nil
+					return
+				default:
+				}
+				if rec := serve(newReqV1(http.MethodGet, "/data/test", "")); rec.Code != 200 {
+					errc <- fmt.Errorf("expected 200 but got %v: %v", rec.Code, rec.Body)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 1; i <= 50; i++ {
+		if rec := serve(newReqV1(http.MethodPut, "/policies/test", policy(i))); rec.Code != 200 {
+			t.Fatalf("expected 200 but got %v: %v", rec.Code, rec.Body)
+		}
+		if rec := serve(newReqV1(http.MethodPut, "/data/x", fmt.Sprint(i))); rec.Code != 204 {
+			t.Fatalf("expected 204 but got %v: %v", rec.Code, rec.Body)
+		}
+	}
+
+	close(stop)
+	for i := 0; i < cap(errc); i++ {
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Decisions that read snapshots before the last change did not fill the
+	// caches of the server after it.
+	if err := f.v1(http.MethodGet, "/data/test", "", 200, `{"result": {"version": 50, "x": 50}}`); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestDataV1Redirection(t *testing.T) {
 	f := newFixture(t)
 	// Testing redirect at the root level
@@ -1555,6 +1833,37 @@ func TestDataV1Metrics(t *testing.T) {
 	})
 }
 
+func TestBundlesV1(t *testing.T) {
+	f := newFixture(t)
+
+	if err := f.v1(http.MethodGet, "/bundles", "", 200, `{"result": {}}`); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	err := storage.Txn(ctx, f.server.store, storage.WriteParams, func(txn storage.Transaction) error {
+		if err := bundle.WriteManifestToStore(ctx, f.server.store, txn, "b1", bundle.Manifest{
+			Revision: "r1",
+			Roots:    &[]string{"a", "b"},
+			Metadata: map[string]interface{}{"environment": "production"},
+		}); err != nil {
+			return err
+		}
+		return bundle.WriteManifestToStore(ctx, f.server.store, txn, "b2", bundle.Manifest{Revision: "r2"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `{"result": {
+		"b1": {"revision": "r1", "roots": ["a", "b"], "metadata": {"environment": "production"}},
+		"b2": {"revision": "r2", "roots": [], "metadata": {}}
+	}}`
+	if err := f.v1(http.MethodGet, "/bundles", "", 200, exp); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestConfigV1(t *testing.T) {
 	f := newFixture(t)
 
@@ -1682,6 +1991,227 @@ func TestDataPutV1IfNoneMatch(t *testing.T) {
 	}
 }
 
+type testDataWriteHook struct {
+	calls []string
+}
+
+func (h *testDataWriteHook) BeforeDataWrite(_ context.Context, _ storage.Transaction, op storage.PatchOp, path storage.Path, _ interface{}) error {
+	if path.String() == "/a/forbidden" {
+		return errors.New("forbidden write")
+	}
+	h.calls = append(h.calls, fmt.Sprintf("before %v %v", op, path))
+	return nil
+}
+
+func (h *testDataWriteHook) AfterDataWrite(_ context.Context, _ storage.Transaction, op storage.PatchOp, path storage.Path, _ interface{}) error {
+	h.calls = append(h.calls, fmt.Sprintf("after %v %v", op, path))
+	return nil
+}
+
+func TestDataV1WriteHooks(t *testing.T) {
+	f := newFixture(t)
+	hook := &testDataWriteHook{}
+	f.server.manager.RegisterDataWriteHook(hook)
+
+	if err := f.v1(http.MethodPut, "/data/a/b", `{"c": 1}`, 204, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.v1(http.MethodPatch, "/data/a/b", `[{"op": "replace", "path": "c", "value": 2}]`, 204, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.v1(http.MethodDelete, "/data/a/b/c", "", 204, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{
+		"before 0 /a/b", "after 0 /a/b",
+		"before 2 /a/b/c", "after 2 /a/b/c",
+		"before 1 /a/b/c", "after 1 /a/b/c",
+	}
+	if !reflect.DeepEqual(hook.calls, exp) {
+		t.Fatalf("Expected hook calls %v but got %v", exp, hook.calls)
+	}
+
+	// An error returned by a hook aborts the write.
+	if err := f.v1(http.MethodPut, "/data/a/forbidden", "1", 500, `{"code": "internal_error", "message": "forbidden write"}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.v1(http.MethodGet, "/data/a", "", 200, `{"result": {"b": {}}}`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDataV1InputSchemaValidation(t *testing.T) {
+	ctx := context.Background()
+	store := inmem.New()
+	m, err := plugins.New([]byte{}, "test", store, plugins.WithParserOptions(ast.ParserOptions{ProcessAnnotation: true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	server, err := New().
+		WithAddresses([]string{"localhost:8182"}).
+		WithStore(store).
+		WithManager(m).
+		WithInputSchemaValidation(true).
+		Init(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fixture{server: server, recorder: httptest.NewRecorder(), t: t}
+
+	policy := `package test
+
+# METADATA
+# schemas:
+#   - input: {"type": "object", "properties": {"user": {"type": "string"}}, "required": ["user"]}
+allow {
+	input.user == "alice"
+}
+
+p = true`
+
+	if err := f.v1(http.MethodPut, "/policies/test", policy, 200, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := `{
+		"code": "invalid_parameter",
+		"message": "input does not match schema",
+		"errors": [
+			{"type": "required", "field": "(Root)", "message": "user is required"}
+		]
+	}`
+
+	if err := f.v1TestRequests([]tr{
+		{http.MethodPost, "/data/test/allow", `{"input": {"user": "alice"}}`, 200, `{"result": true}`},
+		{http.MethodPost, "/data/test/allow", `{"input": {"name": "alice"}}`, 400, invalid},
+		{http.MethodGet, `/data/test/allow?input={"name":"alice"}`, "", 400, invalid},
+		{http.MethodPost, "/data/test/allow", `{"input": {"user": 7}}`, 400, `{
+			"code": "invalid_parameter",
+			"message": "input does not match schema",
+			"errors": [
+				{"type": "invalid_type", "field": "user", "message": "Invalid type. Expected: string, given: integer"}
+			]
+		}`},
+		{http.MethodPost, "/data/test/allow", `{}`, 200, `{"warning": {"code": "api_usage_warning", "message": "'input' key missing from the request"}}`},
+		{http.MethodPost, "/data/test/p", `{"input": {"name": "alice"}}`, 200, `{"result": true}`},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The schemas are recompiled when the policies change.
+	if err := f.v1(http.MethodPut, "/policies/test", "package test\n\nallow = true", 200, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.v1(http.MethodPost, "/data/test/allow", `{"input": {"name": "alice"}}`, 200, `{"result": true}`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDataV1InputSchemaValidationDisabled(t *testing.T) {
+	f := newFixture(t)
+
+	policy := `package test
+
+# METADATA
+# schemas:
+#   - input: {"type": "object", "required": ["user"]}
+allow {
+	input.user == "alice"
+}`
+
+	if err := f.v1(http.MethodPut, "/policies/test", policy, 200, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.v1(http.MethodPost, "/data/test/allow", `{"input": {"name": "alice"}}`, 200, `{}`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBatchDataV1(t *testing.T) {
+	f := newFixture(t)
+
+	policy := `package test
+
+allow {
+	input.user == "alice"
+}
+
+role := input.role
+
+fail {
+	1 / input.x
+}`
+
+	if err := f.v1(http.MethodPut, "/policies/test", policy, 200, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"inputs": [
+		{"path": "allow", "input": {"user": "alice"}},
+		{"path": "allow", "input": {"user": "bob"}},
+		{"path": "role", "input": {"role": "admin"}},
+		{"path": "/fail/", "input": {"x": 0}},
+		{"path": "allow"}
+	]}`
+	exp := `{"responses": [
+		{"result": true},
+		{},
+		{"result": "admin"},
+		{"error": {"code": "internal_error", "message": "error(s) occurred while evaluating query", "errors": [{"code": "eval_builtin_error", "message": "div: divide by zero", "location": {"file": "test", "row": 10, "col": 2}}]}},
+		{"warning": {"code": "api_usage_warning", "message": "'input' key missing from the request"}}
+	]}`
+	if err := f.v1(http.MethodPost, "/batch/data/test?strict-builtin-errors", body, 200, exp); err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries without a path get the document at the path of the request URL.
+	if err := f.v1(http.MethodPost, "/batch/data/test/allow", `{"inputs": [{"input": {"user": "alice"}}]}`, 200, `{"responses": [{"result": true}]}`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.v1(http.MethodPost, "/batch/data", `{"inputs": [{"path": "test/role", "input": {"role": "dev"}}]}`, 200, `{"responses": [{"result": "dev"}]}`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.v1(http.MethodPost, "/batch/data/test/allow", `{"inputs": {}}`, 400, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBatchDataV1DecisionLogging(t *testing.T) {
+	f := newFixture(t)
+
+	var decisions []*Info
+	var nextID int
+
+	f.server = f.server.WithDecisionIDFactory(func() string {
+		nextID++
+		return fmt.Sprint(nextID)
+	}).WithDecisionLoggerWithErr(func(_ context.Context, info *Info) error {
+		decisions = append(decisions, info)
+		return nil
+	})
+
+	body := `{"inputs": [{"input": 1}, {"path": "b", "input": 2}]}`
+	exp := `{"responses": [{"decision_id": "1"}, {"decision_id": "2"}]}`
+	if err := f.v1(http.MethodPost, "/batch/data/a", body, 200, exp); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(decisions) != 2 {
+		t.Fatalf("Expected exactly 2 decisions but got: %d", len(decisions))
+	}
+	for i, path := range []string{"a", "a/b"} {
+		if decisions[i].DecisionID != fmt.Sprint(i+1) || decisions[i].Path != path {
+			t.Fatalf("Unexpected decision %d: %+v", i, decisions[i])
+		}
+	}
+}
+
 func TestDataPostV0CompressedResponse(t *testing.T) {
 	tests := []struct {
 		gzipMinLength      int
@@ -2404,6 +2934,137 @@ p = [1, 2, 3, 4] { true }`, 200, "")
 	}
 }
 
+func TestDataPostInputInterning(t *testing.T) {
+	f := newFixtureWithConfig(t, `{"server": {"decoding": {"interning": {"max_entries": 100}}}}`)
+
+	if err := f.v1(http.MethodPut, "/policies/test", `package test
+
+p := [k | input.headers[k] == "json"]`, 200, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := f.v1(http.MethodPost, "/data/test/p", `{"input": {"headers": {"accept": "json", "x-request-id": "1"}}}`, 200, `{"result": ["accept"]}`); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := f.server.inputPool.Len(); n != 4 {
+		t.Fatalf("Expected 4 interned strings but got %d", n)
+	}
+
+	store := inmem.New()
+	m, err := plugins.New([]byte(`{"server": {"decoding": {"interning": {"max_entries": 0}}}}`), "test", store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = New().WithStore(store).WithManager(m).Init(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "server.decoding.interning.max_entries") {
+		t.Fatalf("Expected config error but got %v", err)
+	}
+}
+
+func TestDataPostAdmission(t *testing.T) {
+	f := newFixture(t)
+
+	err := f.v1(http.MethodPut, "/policies/test", `package test
+
+mutate := {"allowed": true, "patches": [{"op": "add", "path": "/metadata/labels/owner", "value": input.user}]}
+
+deny := {"allowed": false, "reason": "denied"}
+
+invalid := {"allowed": true, "patches": [{"op": "add", "path": "metadata"}]}`, 200, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		code int
+		resp string
+	}{
+		{
+			path: "/data/test/mutate?admission",
+			code: 200,
+			resp: `{"result": {"allowed": true, "patches": [{"op": "add", "path": "/metadata/labels/owner", "value": "alice"}]}}`,
+		},
+		{
+			path: "/data/test/deny?admission",
+			code: 200,
+			resp: `{"result": {"allowed": false, "reason": "denied"}}`,
+		},
+		{
+			path: "/data/test/undefined?admission",
+			code: 200,
+			resp: `{}`,
+		},
+		{
+			path: "/data/test/invalid?admission",
+			code: 500,
+			resp: `{"code": "invalid_decision", "message": "invalid patch 0: \"add\" operation requires a value"}`,
+		},
+		{
+			// Results are not checked unless requested.
+			path: "/data/test/invalid",
+			code: 200,
+			resp: `{"result": {"allowed": true, "patches": [{"op": "add", "path": "metadata"}]}}`,
+		},
+	}
+
+	for _, tc := range tests {
+		if err := f.v1(http.MethodPost, tc.path, `{"input": {"user": "alice"}}`, tc.code, tc.resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestQueryCacheLookupsMetric(t *testing.T) {
+	ctx := context.Background()
+	store := inmem.New()
+
+	prom := prometheus.New(metrics.New(), nil, []float64{1})
+
+	m, err := plugins.New([]byte(`{}`), "test", store, plugins.WithPrometheusRegister(prom))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := New().
+		WithStore(store).
+		WithManager(m).
+		WithAddresses([]string{"localhost:8182"}).
+		Init(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fixture{server: server, recorder: httptest.NewRecorder(), t: t}
+
+	for i := 0; i < 3; i++ {
+		if err := f.v1(http.MethodPost, "/data/x", `{"input": {}}`, 200, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	families, err := prom.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lookups := map[string]float64{}
+	for _, f := range families {
+		if f.GetName() == "server_query_cache_lookups" {
+			for _, m := range f.GetMetric() {
+				lookups[m.GetLabel()[0].GetValue()] = m.GetCounter().GetValue()
+			}
+		}
+	}
+
+	if exp := map[string]float64{"hit": 2, "miss": 1}; !reflect.DeepEqual(lookups, exp) {
+		t.Fatalf("Expected lookups %v but got %v", exp, lookups)
+	}
+}
+
 func TestDataPostExplain(t *testing.T) {
 	f := newFixture(t)
 
@@ -4101,6 +4762,30 @@ func TestAuthorization(t *testing.T) {
 	}
 }
 
+func TestAuthenticationJWTConfig(t *testing.T) {
+	ctx := context.Background()
+
+	for _, config := range []string{
+		`{}`,
+		`{"server": {"authentication": {"jwt": {"issuers": []}}}}`,
+	} {
+		store := inmem.New()
+		m, err := plugins.New([]byte(config), "test", store)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = New().
+			WithStore(store).
+			WithManager(m).
+			WithAuthentication(AuthenticationJWT).
+			Init(ctx)
+		if err == nil {
+			t.Fatalf("Expected error for config %v", config)
+		}
+	}
+}
+
 func TestAuthorizationUsesInterQueryCache(t *testing.T) {
 
 	ctx := context.Background()
@@ -4204,7 +4889,7 @@ func TestServerUsesAuthorizerParsedBody(t *testing.T) {
 	})
 
 	// Check that v1 reader function behaves correctly.
-	inp, goInp, err := readInputPostV1(req.WithContext(ctx))
+	inp, goInp, err := readInputPostV1(req.WithContext(ctx), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -4224,7 +4909,7 @@ func TestServerUsesAuthorizerParsedBody(t *testing.T) {
 		"foo": "good",
 	})
 
-	inp, goInp, err = readInputV0(req.WithContext(ctx))
+	inp, goInp, err = readInputV0(req.WithContext(ctx), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -4944,6 +5629,192 @@ func TestDiagnosticRoutes(t *testing.T) {
 
 }
 
+func TestDebugBench(t *testing.T) {
+	policy := `package test
+
+	allow {
+		input.x == 1
+	}`
+
+	f := newFixture(t, func(s *Server) {
+		s.WithBenchEnabled(true)
+	})
+
+	if err := f.v1(http.MethodPut, "/policies/test", policy, 200, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	benchReq := func(path, body string) *http.Request {
+		req, err := http.NewRequest(http.MethodPost, "/debug/bench"+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+
+	tests := []struct {
+		note       string
+		path       string
+		body       string
+		code       int
+		iterations int
+		warning    bool
+		err        string
+	}{
+		{
+			note:       "defaults",
+			path:       "/test/allow",
+			body:       `{"input": {"x": 1}}`,
+			code:       http.StatusOK,
+			iterations: benchDefaultIterations,
+		},
+		{
+			note:       "iterations",
+			path:       "/test/allow",
+			body:       `{"input": {"x": 1}, "iterations": 10, "duration": "5s"}`,
+			code:       http.StatusOK,
+			iterations: 10,
+		},
+		{
+			note:       "missing input",
+			path:       "/test/allow",
+			body:       `{"iterations": 5}`,
+			code:       http.StatusOK,
+			iterations: 5,
+			warning:    true,
+		},
+		{
+			note: "too many iterations",
+			path: "/test/allow",
+			body: `{"iterations": 10001}`,
+			code: http.StatusBadRequest,
+			err:  "iterations must be between 1 and 10000",
+		},
+		{
+			note: "duration too long",
+			path: "/test/allow",
+			body: `{"duration": "1m"}`,
+			code: http.StatusBadRequest,
+			err:  "duration must be positive and at most 10s",
+		},
+		{
+			note: "invalid duration",
+			path: "/test/allow",
+			body: `{"duration": "foo"}`,
+			code: http.StatusBadRequest,
+			err:  "invalid duration",
+		},
+		{
+			note: "malformed body",
+			path: "/test/allow",
+			body: `{`,
+			code: http.StatusBadRequest,
+			err:  "body contains malformed benchmark request",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			f.reset()
+			f.server.Handler.ServeHTTP(f.recorder, benchReq(tc.path, tc.body))
+			if f.recorder.Code != tc.code {
+				t.Fatalf("expected code %d but got %d: %v", tc.code, f.recorder.Code, f.recorder.Body)
+			}
+
+			if tc.err != "" {
+				if !strings.Contains(f.recorder.Body.String(), tc.err) {
+					t.Fatalf("expected error containing %q but got: %v", tc.err, f.recorder.Body)
+				}
+				return
+			}
+
+			var result struct {
+				Iterations int                    `json:"iterations"`
+				DurationNs int64                  `json:"duration_ns"`
+				Latency    map[string]interface{} `json:"latency_ns"`
+				Warning    *types.Warning         `json:"warning"`
+			}
+			if err := util.UnmarshalJSON(f.recorder.Body.Bytes(), &result); err != nil {
+				t.Fatal(err)
+			}
+
+			if result.Iterations != tc.iterations {
+				t.Errorf("expected %d iterations but got %d", tc.iterations, result.Iterations)
+			}
+			if count, ok := result.Latency["count"].(json.Number); !ok || count.String() != fmt.Sprint(tc.iterations) {
+				t.Errorf("expected latency count of %d but got %v", tc.iterations, result.Latency["count"])
+			}
+			for _, k := range []string{"min", "max", "mean", "median", "99%"} {
+				if _, ok := result.Latency[k]; !ok {
+					t.Errorf("expected %q in latency histogram but got %v", k, result.Latency)
+				}
+			}
+			if result.DurationNs <= 0 {
+				t.Errorf("expected positive duration but got %d", result.DurationNs)
+			}
+			if (result.Warning != nil) != tc.warning {
+				t.Errorf("expected warning: %v but got %v", tc.warning, result.Warning)
+			}
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		f := newFixture(t)
+		if err := f.executeRequest(benchReq("/test/allow", `{}`), http.StatusNotFound, ""); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("in progress", func(t *testing.T) {
+		f.server.benchMtx.Lock()
+		defer f.server.benchMtx.Unlock()
+		if err := f.executeRequest(benchReq("/test/allow", `{}`), http.StatusConflict, ""); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestDebugBenchAuthorization(t *testing.T) {
+	ctx := context.Background()
+	store := inmem.New()
+
+	authzPolicy := `package system.authz
+
+	default allow = false
+
+	allow {
+		input.identity = "bob"
+		input.path[0] = "debug"
+	}`
+
+	if err := storage.Txn(ctx, store, storage.WriteParams, func(txn storage.Transaction) error {
+		return store.UpsertPolicy(ctx, txn, "test", []byte(authzPolicy))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	f := newFixtureWithStore(t, store, func(s *Server) {
+		s.WithAuthorization(AuthorizationBasic)
+		s.WithBenchEnabled(true)
+	})
+
+	for _, tc := range []struct {
+		identity string
+		code     int
+	}{
+		{"bob", http.StatusOK},
+		{"alice", http.StatusUnauthorized},
+	} {
+		req, err := http.NewRequest(http.MethodPost, "/debug/bench/system/authz/allow", strings.NewReader(`{"iterations": 1}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.executeRequest(identifier.SetIdentity(req, tc.identity), tc.code, ""); err != nil {
+			t.Errorf("%v: %v", tc.identity, err)
+		}
+	}
+}
+
 func TestDistributedTracingEnabled(t *testing.T) {
 	c := []byte(`{"distributed_tracing": {
 		"type": "grpc"
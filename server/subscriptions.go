@@ -0,0 +1,227 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/mux"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/dependencies"
+	"github.com/open-policy-agent/opa/server/types"
+	"github.com/open-policy-agent/opa/server/writer"
+	"github.com/open-policy-agent/opa/storage"
+)
+
+// subscriptions are the decision subscriptions of the clients of the server.
+// The zero value has no subscriptions.
+type subscriptions struct {
+	mtx    sync.Mutex
+	subs   map[*subscription]struct{}
+	closed bool
+}
+
+// subscription is the decision subscription of a client. The subscription is
+// notified when the policies, or the data the decision depends on, change.
+type subscription struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	events chan struct{}
+
+	mtx  sync.Mutex
+	deps []ast.Ref // nil if the dependencies are unknown
+}
+
+func (ss *subscriptions) add(ctx context.Context) *subscription {
+	sub := &subscription{events: make(chan struct{}, 1)}
+	sub.ctx, sub.cancel = context.WithCancel(ctx)
+
+	ss.mtx.Lock()
+	defer ss.mtx.Unlock()
+
+	if ss.closed {
+		sub.cancel()
+		return sub
+	}
+	if ss.subs == nil {
+		ss.subs = map[*subscription]struct{}{}
+	}
+	ss.subs[sub] = struct{}{}
+	return sub
+}
+
+func (ss *subscriptions) remove(sub *subscription) {
+	ss.mtx.Lock()
+	defer ss.mtx.Unlock()
+	delete(ss.subs, sub)
+	sub.cancel()
+}
+
+// notify notifies the subscriptions affected by the changes of a commit.
+//
+// NOTE: notify is called by the commit trigger of the store, so it must not
+// block or read the store.
+func (ss *subscriptions) notify(event storage.TriggerEvent) {
+	ss.mtx.Lock()
+	defer ss.mtx.Unlock()
+
+	for sub := range ss.subs {
+		if !sub.affectedBy(event) {
+			continue
+		}
+		select {
+		case sub.events <- struct{}{}:
+		default: // the subscription is already pending
+		}
+	}
+}
+
+// close closes the subscriptions, and refuses new ones.
+func (ss *subscriptions) close() {
+	ss.mtx.Lock()
+	defer ss.mtx.Unlock()
+
+	ss.closed = true
+	for sub := range ss.subs {
+		sub.cancel()
+	}
+	ss.subs = nil
+}
+
+func (sub *subscription) setDependencies(deps []ast.Ref) {
+	sub.mtx.Lock()
+	defer sub.mtx.Unlock()
+	sub.deps = deps
+}
+
+// affectedBy returns true if the decision of the subscription may change due
+// to the event: if policies were changed, or data that overlaps with the
+// dependencies of the decision.
+func (sub *subscription) affectedBy(event storage.TriggerEvent) bool {
+	if event.PolicyChanged() {
+		return true
+	}
+
+	sub.mtx.Lock()
+	defer sub.mtx.Unlock()
+
+	if sub.deps == nil {
+		return event.DataChanged()
+	}
+
+	for _, data := range event.Data {
+		path := data.Path.Ref(ast.DefaultRootDocument)
+		for _, dep := range sub.deps {
+			if dep.HasPrefix(path) || path.HasPrefix(dep) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// v1SubscribeDataPost streams the decision for the input of the request as
+// server-sent events. The decision is evaluated again when the policies, or the
+// data it depends on, change, and an event is sent if the result of the
+// decision changed. The stream ends when the client disconnects or the server
+// shuts down.
+func (s *Server) v1SubscribeDataPost(w http.ResponseWriter, r *http.Request) {
+	urlPath := mux.Vars(r)["path"]
+	includeInstrumentation := getBoolParam(r.URL, types.ParamInstrumentV1, true)
+	strictBuiltinErrors := getBoolParam(r.URL, types.ParamStrictBuiltinErrors, true)
+
+	_, goInput, err := readInputPostV1(r, s.inputPool)
+	if err != nil {
+		writer.ErrorString(w, http.StatusBadRequest, types.CodeInvalidParameter, err)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writer.ErrorString(w, http.StatusInternalServerError, types.CodeInternal, errors.New("streaming not supported"))
+		return
+	}
+
+	sub := s.subscriptions.add(r.Context())
+	defer s.subscriptions.remove(sub)
+
+	headers := w.Header()
+	headers.Set("Content-Type", "text/event-stream")
+	headers.Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var last []byte
+	for sub.ctx.Err() == nil {
+		result, err := s.evalSubscription(sub, urlPath, goInput, strictBuiltinErrors, includeInstrumentation, includeMetrics(r))
+		if err != nil {
+			_, result.Error = writer.AutoError(err)
+		}
+
+		bs, err := json.Marshal(result)
+		if err != nil {
+			return
+		}
+
+		// NOTE: The decision ID and metrics differ between evaluations, so
+		// only the result and error are compared.
+		key, err := json.Marshal([]interface{}{result.Result, result.Error})
+		if err != nil {
+			return
+		}
+
+		if last == nil || string(key) != string(last) {
+			event := "result"
+			if result.Error != nil {
+				event = "error"
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, bs); err != nil {
+				return
+			}
+			flusher.Flush()
+			last = key
+		}
+
+		select {
+		case <-sub.ctx.Done():
+		case <-sub.events:
+		}
+	}
+}
+
+// evalSubscription evaluates the decision of a subscription, and updates the
+// dependencies of the subscription while the transaction is open, so that no
+// commit is missed between the evaluation and the update.
+func (s *Server) evalSubscription(sub *subscription, urlPath string, goInput *interface{}, strictBuiltinErrors, includeInstrumentation, includeMetrics bool) (types.BatchDataResponseItemV1, error) {
+	ctx := sub.ctx
+
+	txn, state, err := s.newTransaction(ctx)
+	if err != nil {
+		return types.BatchDataResponseItemV1{}, err
+	}
+	defer s.store.Abort(ctx, txn)
+
+	br, err := getRevisions(ctx, s.store, txn)
+	if err != nil {
+		return types.BatchDataResponseItemV1{}, err
+	}
+
+	ref := stringPathToDataRef(urlPath)
+	deps, err := dependencies.Base(s.getCompiler(), ast.NewBody(ast.NewExpr(ast.NewTerm(ref))))
+	if err != nil {
+		deps = nil
+	} else {
+		deps = append(deps, ref)
+	}
+	sub.setDependencies(deps)
+
+	return s.evalDecision(ctx, txn, state, s.getDecisionLogger(br), urlPath, goInput, strictBuiltinErrors, includeInstrumentation, includeMetrics), nil
+}
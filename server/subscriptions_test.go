@@ -0,0 +1,142 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/storage"
+)
+
+func TestSubscribeDataV1(t *testing.T) {
+	f := newFixture(t)
+
+	policy := `package test
+
+allow {
+	data.users[input.user].admin
+}`
+
+	if err := f.v1(http.MethodPut, "/policies/test", policy, 200, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.v1(http.MethodPut, "/data/users", `{"alice": {"admin": false}}`, 204, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(f.server.Handler)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/v1/subscribe/data/test/allow", "application/json", strings.NewReader(`{"input": {"user": "alice"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Unexpected response: %v", resp)
+	}
+
+	events := bufio.NewReader(resp.Body)
+	next := func() string {
+		t.Helper()
+		var lines []string
+		for {
+			line, err := events.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line == "\n" {
+				return strings.Join(lines, "")
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	if event := next(); event != "event: result\ndata: {}\n" {
+		t.Fatalf("Unexpected event: %q", event)
+	}
+
+	// Data the decision does not depend on, and changes that do not change the
+	// result, do not produce events.
+	if err := f.v1(http.MethodPut, "/data/other", `{}`, 204, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.v1(http.MethodPut, "/data/users/bob", `{"admin": true}`, 204, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.v1(http.MethodPut, "/data/users/alice/admin", `true`, 204, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if event := next(); event != "event: result\ndata: {\"result\":true}\n" {
+		t.Fatalf("Unexpected event: %q", event)
+	}
+
+	if err := f.v1(http.MethodPut, "/policies/test", `package test
+
+allow := "yes"`, 200, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if event := next(); event != "event: result\ndata: {\"result\":\"yes\"}\n" {
+		t.Fatalf("Unexpected event: %q", event)
+	}
+
+	f.server.subscriptions.close()
+
+	if _, err := events.ReadString('\n'); err == nil {
+		t.Fatal("Expected stream to end")
+	}
+}
+
+func TestSubscriptionAffectedBy(t *testing.T) {
+	sub := &subscription{}
+
+	dataEvent := func(path string) storage.TriggerEvent {
+		return storage.TriggerEvent{Data: []storage.DataEvent{{Path: storage.MustParsePath(path)}}}
+	}
+
+	if !sub.affectedBy(dataEvent("/a")) {
+		t.Fatal("Expected subscription with unknown dependencies to be affected")
+	}
+	if sub.affectedBy(storage.TriggerEvent{}) {
+		t.Fatal("Expected subscription not to be affected by empty event")
+	}
+
+	sub.setDependencies([]ast.Ref{ast.MustParseRef("data.a.b"), ast.MustParseRef("input.x")})
+
+	for path, exp := range map[string]bool{
+		"/":      true,
+		"/a":     true,
+		"/a/b/c": true,
+		"/a/c":   false,
+		"/x":     false,
+	} {
+		if sub.affectedBy(dataEvent(path)) != exp {
+			t.Errorf("Expected affected by %v to be %v", path, exp)
+		}
+	}
+
+	if !sub.affectedBy(storage.TriggerEvent{Policy: []storage.PolicyEvent{{ID: "x"}}}) {
+		t.Fatal("Expected subscription to be affected by policy changes")
+	}
+
+	var ss subscriptions
+	sub = ss.add(context.Background())
+	ss.close()
+	if sub.ctx.Err() == nil {
+		t.Fatal("Expected subscription to be closed")
+	}
+	if sub = ss.add(context.Background()); sub.ctx.Err() == nil {
+		t.Fatal("Expected subscription to be refused")
+	}
+}
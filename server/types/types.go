@@ -26,6 +26,9 @@ const (
 	CodeResourceNotFound  = "resource_not_found"
 	CodeResourceConflict  = "resource_conflict"
 	CodeUndefinedDocument = "undefined_document"
+	CodeTooManyRequests   = "too_many_requests"
+	CodeInvalidDecision   = "invalid_decision"
+	CodeUnavailable       = "unavailable"
 )
 
 // ErrorV1 models an error response sent to the client.
@@ -63,6 +66,18 @@ func (e *ErrorV1) WithASTErrors(errors []*ast.Error) *ErrorV1 {
 	return e
 }
 
+// SchemaErrorV1 models a violation of the input schema. The type and the field
+// are those reported by the json.match_schema built-in function.
+type SchemaErrorV1 struct {
+	Type    string `json:"type"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *SchemaErrorV1) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
 // Bytes marshals e with indentation for readability.
 func (e *ErrorV1) Bytes() []byte {
 	bs, _ := json.MarshalIndent(e, "", "  ")
@@ -81,6 +96,7 @@ const (
 	MsgMissingError               = "document missing"
 	MsgFoundUndefinedError        = "document undefined"
 	MsgPluginConfigError          = "error(s) occurred while configuring plugin(s)"
+	MsgInputSchemaError           = "input does not match schema"
 )
 
 // PatchV1 models a single patch operation against a document.
@@ -152,6 +168,55 @@ type DataResponseV1 struct {
 	Warning     *Warning      `json:"warning,omitempty"`
 }
 
+// BatchDataRequestV1 models the request message for batch Data API POST
+// operations.
+type BatchDataRequestV1 struct {
+	Inputs []BatchDataRequestItemV1 `json:"inputs"`
+}
+
+// BatchDataRequestItemV1 models a single decision requested by a batch Data
+// API POST operation. Path is relative to the path of the request URL. If it
+// is empty, the path of the request URL is used.
+type BatchDataRequestItemV1 struct {
+	Path  string       `json:"path,omitempty"`
+	Input *interface{} `json:"input"`
+}
+
+// BatchDataResponseV1 models the response message for batch Data API POST
+// operations. The responses are in the order of the inputs of the request.
+type BatchDataResponseV1 struct {
+	Provenance *ProvenanceV1             `json:"provenance,omitempty"`
+	Metrics    MetricsV1                 `json:"metrics,omitempty"`
+	Responses  []BatchDataResponseItemV1 `json:"responses"`
+}
+
+// BatchDataResponseItemV1 models the result of a single decision of a batch
+// Data API POST operation, or of an event of a decision subscription. If the
+// decision failed, Error is set.
+type BatchDataResponseItemV1 struct {
+	DecisionID string       `json:"decision_id,omitempty"`
+	Metrics    MetricsV1    `json:"metrics,omitempty"`
+	Result     *interface{} `json:"result,omitempty"`
+	Warning    *Warning     `json:"warning,omitempty"`
+	Error      *ErrorV1     `json:"error,omitempty"`
+}
+
+// BenchRequestV1 models the request message for the benchmark API.
+type BenchRequestV1 struct {
+	Input      *interface{} `json:"input"`
+	Iterations int          `json:"iterations,omitempty"`
+	Duration   string       `json:"duration,omitempty"`
+}
+
+// BenchResponseV1 models the response message for the benchmark API. Latencies
+// are reported in nanoseconds.
+type BenchResponseV1 struct {
+	Iterations int         `json:"iterations"`
+	DurationNs int64       `json:"duration_ns"`
+	Latency    interface{} `json:"latency_ns"`
+	Warning    *Warning    `json:"warning,omitempty"`
+}
+
 // Warning models DataResponse warnings
 type Warning struct {
 	Code    string `json:"code,omitempty"`
@@ -386,8 +451,9 @@ type CompileResponseV1 struct {
 // PartialEvaluationResultV1 represents the output of partial evaluation and is
 // included in Compile API responses.
 type PartialEvaluationResultV1 struct {
-	Queries []ast.Body    `json:"queries,omitempty"`
-	Support []*ast.Module `json:"support,omitempty"`
+	Queries    []ast.Body                  `json:"queries,omitempty"`
+	Support    []*ast.Module               `json:"support,omitempty"`
+	Provenance []topdown.SupportProvenance `json:"provenance,omitempty"`
 }
 
 // QueryRequestV1 models the request message for Query API operations.
@@ -401,6 +467,29 @@ type ConfigResponseV1 struct {
 	Result *interface{} `json:"result,omitempty"`
 }
 
+// BundlesResponseV1 models the response message for Bundles API operations.
+type BundlesResponseV1 struct {
+	Result map[string]BundleV1 `json:"result"`
+}
+
+// BundleV1 models the manifest of a bundle that is activated in the store.
+type BundleV1 struct {
+	Revision string                 `json:"revision"`
+	Roots    []string               `json:"roots"`
+	Metadata map[string]interface{} `json:"metadata"`
+}
+
+// DrainResponseV1 models the response message for Drain API operations.
+type DrainResponseV1 struct {
+	Result DrainV1 `json:"result"`
+}
+
+// DrainV1 models the drain status of the server.
+type DrainV1 struct {
+	Draining          bool `json:"draining"`
+	InFlightDecisions int  `json:"in_flight_decisions"`
+}
+
 // StatusResponseV1 models the response message for Status API (pull) operations.
 type StatusResponseV1 struct {
 	Result *interface{} `json:"result,omitempty"`
@@ -408,6 +497,14 @@ type StatusResponseV1 struct {
 
 // HealthResponseV1 models the response message for Health API operations.
 type HealthResponseV1 struct {
+	Error  string                   `json:"error,omitempty"`
+	Checks map[string]HealthCheckV1 `json:"checks,omitempty"`
+	Drain  *DrainV1                 `json:"drain,omitempty"`
+}
+
+// HealthCheckV1 models the result of a single check of the Health API.
+type HealthCheckV1 struct {
+	OK    bool   `json:"ok"`
 	Error string `json:"error,omitempty"`
 }
 
@@ -463,9 +560,28 @@ const (
 	// of the health API for the specified plugin(s)
 	ParamExcludePluginV1 = "exclude-plugin"
 
+	// ParamChecksV1 defines the name of the HTTP URL parameter that
+	// indicates the client wants to include the health checks registered by
+	// plugins in the results of the health API.
+	ParamChecksV1 = "checks"
+
+	// ParamExcludeCheckV1 defines the name of the HTTP URL parameter that
+	// indicates the client wants to exclude the specified health check(s)
+	// from the results of the health API.
+	ParamExcludeCheckV1 = "exclude-check"
+
+	// ParamReadyV1 defines the name of the HTTP URL parameter that indicates
+	// the client wants the readiness predicate (data.system.health.ready) to
+	// be included in the results of the health API.
+	ParamReadyV1 = "ready"
+
 	// ParamStrictBuiltinErrors names the HTTP URL parameter that indicates the client
 	// wants built-in function errors to be treated as fatal.
 	ParamStrictBuiltinErrors = "strict-builtin-errors"
+
+	// ParamAdmissionV1 names the HTTP URL parameter that indicates the client
+	// expects the result to be an admission decision with valid patches.
+	ParamAdmissionV1 = "admission"
 )
 
 // BadRequestErr represents an error condition raised if the caller passes
@@ -487,7 +487,9 @@ func (db *Store) Commit(ctx context.Context, txn storage.Transaction) error {
 		xid := atomic.AddUint64(&db.xid, uint64(1))
 		readTxn := newTransaction(xid, write, readOnly, nil, db.pm, db.partitions, db)
 		for h := range db.triggers {
-			h.cb(ctx, readTxn, event)
+			if event, ok := h.config.Filter(event); ok {
+				h.config.OnCommit(ctx, readTxn, event)
+			}
 		}
 
 		// cleanup backup db
@@ -600,7 +602,7 @@ func (db *Store) Register(_ context.Context, txn storage.Transaction, config sto
 			Message: "triggers must be registered with a write transaction",
 		}
 	}
-	h := &handle{db: db, cb: config.OnCommit}
+	h := &handle{db: db, config: config}
 	db.triggers[h] = struct{}{}
 	return h, nil
 }
@@ -614,6 +616,16 @@ func (db *Store) Read(ctx context.Context, txn storage.Transaction, path storage
 	return underlying.Read(ctx, path)
 }
 
+// ReadBatch implements the storage.BatchReader interface. All paths are read
+// in the same transaction.
+func (db *Store) ReadBatch(ctx context.Context, txn storage.Transaction, paths []storage.Path) ([]storage.BatchReadResult, error) {
+	underlying, err := db.underlying(txn)
+	if err != nil {
+		return nil, err
+	}
+	return underlying.ReadBatch(ctx, paths)
+}
+
 // Write implements the storage.Store interface.
 func (db *Store) Write(ctx context.Context, txn storage.Transaction, op storage.PatchOp, path storage.Path, value interface{}) error {
 	underlying, err := db.underlying(txn)
@@ -651,8 +663,8 @@ func (db *Store) underlying(txn storage.Transaction) (*transaction, error) {
 }
 
 type handle struct {
-	db *Store
-	cb func(context.Context, storage.Transaction, storage.TriggerEvent)
+	db     *Store
+	config storage.TriggerConfig
 }
 
 func (h *handle) Unregister(_ context.Context, txn storage.Transaction) {
@@ -1535,6 +1535,51 @@ func executeTestWrite(ctx context.Context, t *testing.T, s storage.Store, x test
 	}
 }
 
+func TestReadBatch(t *testing.T) {
+	test.WithTempFS(map[string]string{}, func(dir string) {
+		ctx := context.Background()
+		store, err := New(ctx, logging.NewNoOpLogger(), nil, Options{Dir: dir, Partitions: []storage.Path{
+			storage.MustParsePath("/foo"),
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close(ctx)
+
+		executeTestWrite(ctx, t, store, testWrite{op: storage.AddOp, path: "/foo/bar", value: `{"x": 7}`})
+		executeTestWrite(ctx, t, store, testWrite{op: storage.AddOp, path: "/baz", value: `{"y": 1, "z": [2]}`})
+
+		paths := []storage.Path{
+			storage.MustParsePath("/foo/bar/x"),
+			storage.MustParsePath("/foo"),
+			storage.MustParsePath("/baz/y"),
+			storage.MustParsePath("/baz/z/0"),
+			storage.MustParsePath("/baz/w"),
+			storage.MustParsePath("/foo/qux"),
+			storage.MustParsePath("/deadbeef"),
+		}
+
+		txn := storage.NewTransactionOrDie(ctx, store)
+		defer store.Abort(ctx, txn)
+
+		results, err := store.ReadBatch(ctx, txn, paths)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The results match the results of reading the paths one by one.
+		for i, path := range paths {
+			exp, expErr := store.Read(ctx, txn, path)
+			if storage.IsNotFound(expErr) != storage.IsNotFound(results[i].Err) {
+				t.Fatalf("%v: expected error %v but got %v", path, expErr, results[i].Err)
+			}
+			if !reflect.DeepEqual(exp, results[i].Value) {
+				t.Fatalf("%v: expected %v but got %v", path, exp, results[i].Value)
+			}
+		}
+	})
+}
+
 func TestDiskTriggers(t *testing.T) {
 	test.WithTempFS(map[string]string{}, func(dir string) {
 		ctx := context.Background()
@@ -1593,7 +1638,7 @@ func TestDiskTriggers(t *testing.T) {
 			t.Fatalf("Expected policy and data change but got: %v", event)
 		}
 
-		expData := storage.DataEvent{Path: modifiedPath, Data: expectedValue, Removed: false}
+		expData := storage.DataEvent{Path: modifiedPath, Data: expectedValue, Removed: false, Op: storage.ReplaceOp}
 		if d := event.Data[0]; !reflect.DeepEqual(expData, d) {
 			t.Fatalf("Expected data event %v, got %v", expData, d)
 		}
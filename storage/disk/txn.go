@@ -134,6 +134,52 @@ func (txn *transaction) Read(ctx context.Context, path storage.Path) (interface{
 	return txn.readMultiple(ctx, i, key)
 }
 
+// ReadBatch reads the documents at paths. Documents stored under the same key
+// are served by reading and decoding the key once.
+func (txn *transaction) ReadBatch(ctx context.Context, paths []storage.Path) ([]storage.BatchReadResult, error) {
+	txn.metrics.Timer(readTimer).Start()
+	defer txn.metrics.Timer(readTimer).Stop()
+
+	values := map[string]storage.BatchReadResult{}
+	results := make([]storage.BatchReadResult, len(paths))
+
+	for i, path := range paths {
+		j, node := txn.partitions.Find(path)
+
+		if node == nil {
+			key, err := txn.pm.DataPath2Key(path[:j])
+			if err != nil {
+				return nil, err
+			}
+
+			value, ok := values[string(key)]
+			if !ok {
+				value.Value, value.Err = txn.readOne(key)
+				values[string(key)] = value
+			}
+
+			if value.Err != nil {
+				results[i].Err = value.Err
+			} else {
+				results[i].Value, results[i].Err = ptr.Ptr(value.Value, path[j:])
+			}
+		} else {
+			key, err := txn.pm.DataPrefix2Key(path[:j])
+			if err != nil {
+				return nil, err
+			}
+
+			results[i].Value, results[i].Err = txn.readMultiple(ctx, j, key)
+		}
+
+		if results[i].Err != nil && !storage.IsNotFound(results[i].Err) {
+			return nil, results[i].Err
+		}
+	}
+
+	return results, nil
+}
+
 func (txn *transaction) readMultiple(ctx context.Context, offset int, prefix []byte) (interface{}, error) {
 
 	result := map[string]interface{}{}
@@ -219,7 +265,6 @@ func (txn *transaction) readOne(key []byte) (interface{}, error) {
 type update struct {
 	key    []byte
 	value  []byte
-	data   interface{}
 	delete bool
 }
 
@@ -244,13 +289,14 @@ func (txn *transaction) Write(_ context.Context, op storage.PatchOp, path storag
 			}
 			txn.metrics.Counter(writtenKeysCounter).Add(1)
 		}
-
-		txn.event.Data = append(txn.event.Data, storage.DataEvent{
-			Path:    path,   // ?
-			Data:    u.data, // nil if delete == true
-			Removed: u.delete,
-		})
 	}
+
+	txn.event.Data = append(txn.event.Data, storage.DataEvent{
+		Path:    path,
+		Data:    value, // nil if op == storage.RemoveOp
+		Removed: op == storage.RemoveOp,
+		Op:      op,
+	})
 	return nil
 }
 
@@ -293,7 +339,7 @@ func (txn *transaction) partitionWrite(op storage.PatchOp, path storage.Path, va
 		if err != nil {
 			return nil, err
 		}
-		return []update{{key: key, value: bs, data: modified}}, nil
+		return []update{{key: key, value: bs}}, nil
 	}
 
 	key, err := txn.pm.DataPrefix2Key(path)
@@ -374,7 +420,7 @@ func (txn *transaction) doPartitionWriteMultiple(node *partitionTrie, path stora
 		if err != nil {
 			return nil, err
 		}
-		result = append(result, update{key: key, value: bs, data: v})
+		result = append(result, update{key: key, value: bs})
 	}
 
 	return result, nil
@@ -395,7 +441,7 @@ func (txn *transaction) partitionWriteOne(op storage.PatchOp, path storage.Path,
 		return nil, err
 	}
 
-	return []update{{key: key, value: val, data: value}}, nil
+	return []update{{key: key, value: val}}, nil
 }
 
 func (txn *transaction) ListPolicies(ctx context.Context) ([]string, error) {
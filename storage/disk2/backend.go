@@ -0,0 +1,52 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package disk2
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrKeyNotFound is returned by BackendTxn.Get if the key does not exist.
+var ErrKeyNotFound = errors.New("key not found")
+
+// Backend defines the interface for the key-value stores that the Store can
+// persist policies and data in.
+type Backend interface {
+	// NewTransaction returns a new transaction on the backend. Transactions
+	// must observe a consistent snapshot of the backend as of the time they
+	// were created, i.e., commits of write transactions must not be visible to
+	// transactions that were created earlier. Write transactions must observe
+	// their own pending writes.
+	NewTransaction(write bool) (BackendTxn, error)
+
+	// Compact reclaims space occupied by deleted or overwritten values.
+	Compact(ctx context.Context) error
+
+	// Close releases the resources held by the backend.
+	Close() error
+}
+
+// BackendTxn defines the interface for transactions on a Backend.
+type BackendTxn interface {
+	// Get returns the value of key or ErrKeyNotFound if key does not exist.
+	Get(key []byte) ([]byte, error)
+
+	// Set sets the value of key.
+	Set(key, value []byte) error
+
+	// Delete removes key.
+	Delete(key []byte) error
+
+	// Iterate calls f for each key with the given prefix in ascending order.
+	// The slices passed to f are only valid until f returns.
+	Iterate(prefix []byte, f func(key, value []byte) error) error
+
+	// Commit persists the writes performed by the transaction.
+	Commit() error
+
+	// Discard releases the transaction without persisting any writes.
+	Discard()
+}
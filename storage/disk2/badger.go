@@ -0,0 +1,110 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package disk2
+
+import (
+	"context"
+	"errors"
+
+	badger "github.com/dgraph-io/badger/v3"
+)
+
+// a value log file be rewritten if half the space can be discarded
+const valueLogGCDiscardRatio = 0.5
+
+// BadgerOptions contains parameters that configure the badger backend.
+type BadgerOptions struct {
+	Dir      string // specifies directory to store data inside of
+	Badger   string // badger-internal configurables
+	ReadOnly bool   // open the database in read-only mode
+}
+
+type badgerBackend struct {
+	db *badger.DB
+}
+
+// NewBadgerBackend returns a Backend that persists values in a badger database.
+func NewBadgerBackend(opts BadgerOptions) (Backend, error) {
+	options := badger.DefaultOptions("").
+		FromSuperFlag(opts.Badger).
+		WithDir(opts.Dir).
+		WithValueDir(opts.Dir).
+		WithReadOnly(opts.ReadOnly).
+		WithLogger(nil).
+		WithDetectConflicts(false) // The store only allows one write txn at a time.
+
+	db, err := badger.Open(options)
+	if err != nil {
+		return nil, err
+	}
+	return &badgerBackend{db: db}, nil
+}
+
+func (b *badgerBackend) NewTransaction(write bool) (BackendTxn, error) {
+	return &badgerTxn{txn: b.db.NewTransaction(write)}, nil
+}
+
+func (b *badgerBackend) Compact(ctx context.Context) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := b.db.RunValueLogGC(valueLogGCDiscardRatio)
+		if errors.Is(err, badger.ErrNoRewrite) || errors.Is(err, badger.ErrRejected) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func (b *badgerBackend) Close() error {
+	return b.db.Close()
+}
+
+type badgerTxn struct {
+	txn *badger.Txn
+}
+
+func (t *badgerTxn) Get(key []byte) ([]byte, error) {
+	item, err := t.txn.Get(key)
+	if err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil, ErrKeyNotFound
+		}
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+func (t *badgerTxn) Set(key, value []byte) error {
+	return t.txn.Set(key, value)
+}
+
+func (t *badgerTxn) Delete(key []byte) error {
+	return t.txn.Delete(key)
+}
+
+func (t *badgerTxn) Iterate(prefix []byte, f func(key, value []byte) error) error {
+	it := t.txn.NewIterator(badger.IteratorOptions{Prefix: prefix, PrefetchValues: true, PrefetchSize: 100})
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		if err := item.Value(func(value []byte) error {
+			return f(item.Key(), value)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *badgerTxn) Commit() error {
+	return t.txn.Commit()
+}
+
+func (t *badgerTxn) Discard() {
+	t.txn.Discard()
+}
@@ -0,0 +1,151 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package disk2
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// name of the database file inside of the bbolt backend directory
+	boltFileName = "opa.db"
+
+	// time to wait for the file lock held by other processes
+	boltOpenTimeout = 5 * time.Second
+
+	// DefaultBoltInitialMmapSize is the default initial size of the memory map
+	// of the bbolt database file.
+	DefaultBoltInitialMmapSize = 256 << 20
+)
+
+// all keys are stored in a single bucket
+var boltBucket = []byte("opa")
+
+// BoltOptions contains parameters that configure the bbolt backend.
+type BoltOptions struct {
+	Dir             string // specifies directory to store data inside of
+	ReadOnly        bool   // open the database in read-only mode
+	InitialMmapSize int    // initial size of the memory map in bytes, defaults to DefaultBoltInitialMmapSize
+}
+
+type boltBackend struct {
+	db *bolt.DB
+}
+
+// NewBoltBackend returns a Backend that persists values in a bbolt database.
+//
+// Commits of write transactions that grow the database beyond the memory map
+// wait for open read transactions to finish, since the file has to be mapped
+// again. A goroutine that commits a write transaction while it holds a read
+// transaction will deadlock in that case. The memory map only reserves
+// address space (except on Windows, where the file is grown to its size), so
+// InitialMmapSize should exceed the expected size of the database.
+func NewBoltBackend(opts BoltOptions) (Backend, error) {
+	mmapSize := opts.InitialMmapSize
+	if mmapSize <= 0 {
+		mmapSize = DefaultBoltInitialMmapSize
+	}
+
+	db, err := bolt.Open(filepath.Join(opts.Dir, boltFileName), 0600, &bolt.Options{
+		Timeout:         boltOpenTimeout,
+		ReadOnly:        opts.ReadOnly,
+		InitialMmapSize: mmapSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !opts.ReadOnly {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(boltBucket)
+			return err
+		}); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
+
+	return &boltBackend{db: db}, nil
+}
+
+func (b *boltBackend) NewTransaction(write bool) (BackendTxn, error) {
+	tx, err := b.db.Begin(write)
+	if err != nil {
+		return nil, err
+	}
+	return &boltTxn{tx: tx, bucket: tx.Bucket(boltBucket)}, nil
+}
+
+// Compact is a no-op: bbolt reuses the pages freed by deletes and overwrites
+// instead of reclaiming them.
+func (*boltBackend) Compact(ctx context.Context) error {
+	return ctx.Err()
+}
+
+func (b *boltBackend) Close() error {
+	return b.db.Close()
+}
+
+type boltTxn struct {
+	tx     *bolt.Tx
+	bucket *bolt.Bucket // nil if the database was opened read-only before it was initialized
+}
+
+func (t *boltTxn) Get(key []byte) ([]byte, error) {
+	if t.bucket == nil {
+		return nil, ErrKeyNotFound
+	}
+	value := t.bucket.Get(key)
+	if value == nil {
+		return nil, ErrKeyNotFound
+	}
+	// NOTE: values returned by bbolt are only valid for the life of the transaction.
+	return bytes.Clone(value), nil
+}
+
+func (t *boltTxn) Set(key, value []byte) error {
+	if t.bucket == nil {
+		return bolt.ErrTxNotWritable
+	}
+	// NOTE: bbolt requires keys and values to stay unmodified until the
+	// transaction is committed.
+	return t.bucket.Put(bytes.Clone(key), bytes.Clone(value))
+}
+
+func (t *boltTxn) Delete(key []byte) error {
+	if t.bucket == nil {
+		return bolt.ErrTxNotWritable
+	}
+	return t.bucket.Delete(key)
+}
+
+func (t *boltTxn) Iterate(prefix []byte, f func(key, value []byte) error) error {
+	if t.bucket == nil {
+		return nil
+	}
+	c := t.bucket.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		if err := f(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *boltTxn) Commit() error {
+	if !t.tx.Writable() {
+		return t.tx.Rollback()
+	}
+	return t.tx.Commit()
+}
+
+func (t *boltTxn) Discard() {
+	_ = t.tx.Rollback() // fails if the transaction has been committed already
+}
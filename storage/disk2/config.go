@@ -0,0 +1,76 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package disk2
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/open-policy-agent/opa/logging"
+)
+
+// Names of the backends that can be selected in the configuration.
+const (
+	BackendBadger = "badger"
+	BackendBolt   = "bbolt"
+)
+
+// Config contains the parameters that select and configure the backend of a
+// disk2 store.
+type Config struct {
+	Dir                 string        // specifies directory to store data inside of
+	Backend             string        // name of the backend, defaults to BackendBadger
+	Badger              string        // badger-internal configurables, only used by the badger backend
+	CompactInterval     time.Duration // period between compactions of the backend, zero disables them
+	BoltInitialMmapSize int           // initial size of the memory map in bytes, only used by the bbolt backend
+}
+
+func (c Config) validate() error {
+	switch c.Backend {
+	case "", BackendBadger, BackendBolt:
+	default:
+		return fmt.Errorf("unknown backend %q (expected %q or %q)", c.Backend, BackendBadger, BackendBolt)
+	}
+	if c.Badger != "" && c.Backend == BackendBolt {
+		return fmt.Errorf("badger options cannot be used with the %q backend", BackendBolt)
+	}
+	if c.CompactInterval < 0 {
+		return fmt.Errorf("compact interval must be non-negative")
+	}
+	if c.BoltInitialMmapSize < 0 {
+		return fmt.Errorf("bbolt initial mmap size must be non-negative")
+	}
+	return nil
+}
+
+// NewFromConfig opens the backend selected by c and returns a new store on
+// top of it. Closing the store closes the backend.
+func NewFromConfig(ctx context.Context, logger logging.Logger, c Config) (*Store, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	var backend Backend
+	var err error
+
+	switch c.Backend {
+	case BackendBolt:
+		backend, err = NewBoltBackend(BoltOptions{Dir: c.Dir, InitialMmapSize: c.BoltInitialMmapSize})
+	default:
+		backend, err = NewBadgerBackend(BadgerOptions{Dir: c.Dir, Badger: c.Badger})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := New(ctx, logger, Options{Backend: backend, CompactInterval: c.CompactInterval})
+	if err != nil {
+		_ = backend.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package disk2
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/opa/logging"
+	"github.com/open-policy-agent/opa/storage"
+)
+
+func TestConfigValidate(t *testing.T) {
+	for _, tc := range []struct {
+		note   string
+		config Config
+		errMsg string
+	}{
+		{
+			note:   "defaults",
+			config: Config{Dir: "/tmp"},
+		},
+		{
+			note:   "bbolt backend",
+			config: Config{Dir: "/tmp", Backend: BackendBolt, CompactInterval: time.Minute, BoltInitialMmapSize: 1 << 20},
+		},
+		{
+			note:   "unknown backend",
+			config: Config{Dir: "/tmp", Backend: "leveldb"},
+			errMsg: `unknown backend "leveldb" (expected "badger" or "bbolt")`,
+		},
+		{
+			note:   "badger options with bbolt backend",
+			config: Config{Dir: "/tmp", Backend: BackendBolt, Badger: "nummemtables=1"},
+			errMsg: `badger options cannot be used with the "bbolt" backend`,
+		},
+		{
+			note:   "negative compact interval",
+			config: Config{Dir: "/tmp", CompactInterval: -time.Second},
+			errMsg: "compact interval must be non-negative",
+		},
+		{
+			note:   "negative bbolt initial mmap size",
+			config: Config{Dir: "/tmp", Backend: BackendBolt, BoltInitialMmapSize: -1},
+			errMsg: "bbolt initial mmap size must be non-negative",
+		},
+	} {
+		t.Run(tc.note, func(t *testing.T) {
+			err := tc.config.validate()
+			if tc.errMsg == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tc.errMsg {
+				t.Fatalf("err: expected %v, got %v", tc.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestNewFromConfig(t *testing.T) {
+	ctx := context.Background()
+
+	for _, backend := range []string{BackendBadger, BackendBolt} {
+		t.Run(backend, func(t *testing.T) {
+			dir := t.TempDir()
+
+			store, err := NewFromConfig(ctx, logging.NewNoOpLogger(), Config{Dir: dir, Backend: backend})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := storage.WriteOne(ctx, store, storage.AddOp, storage.MustParsePath("/x"), "y"); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Close(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := os.Stat(filepath.Join(dir, boltFileName)); (err == nil) != (backend == BackendBolt) {
+				t.Fatalf("expected bbolt database file only for the bbolt backend, got %v", err)
+			}
+
+			store, err = NewFromConfig(ctx, logging.NewNoOpLogger(), Config{Dir: dir, Backend: backend})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close(ctx)
+
+			if result, err := storage.ReadOne(ctx, store, storage.MustParsePath("/x")); err != nil {
+				t.Fatal(err)
+			} else if result != "y" {
+				t.Fatalf("expected y but got %v", result)
+			}
+		})
+	}
+}
@@ -0,0 +1,730 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package disk2 provides a disk-based implementation of the storage.Store
+// interface on top of a pluggable key-value backend.
+//
+// Policy modules are stored as raw byte strings with one module per key. Data
+// is stored with one key per top-level document under /data (e.g., the full
+// extent of /data/foo is stored under one key.) Reads and writes that target
+// a nested path load and decode the top-level document that contains it.
+//
+// Unlike the disk package, read transactions operate on snapshots of the
+// backend: committing a write transaction does not wait for in-flight read
+// transactions to finish, and read transactions that were opened before the
+// commit do not observe its changes. This allows large writes, like bundle
+// activations, to proceed while decisions are being evaluated.
+//
+// The backend is supplied by the caller (see Backend.) NewBadgerBackend
+// returns a backend that persists values in a badger database and
+// NewBoltBackend returns one that persists values in a bbolt database. Commits
+// on the bbolt backend can wait for read transactions when the database file
+// grows beyond its memory map (see NewBoltBackend.)
+package disk2
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/open-policy-agent/opa/internal/merge"
+	"github.com/open-policy-agent/opa/logging"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/internal/errors"
+	"github.com/open-policy-agent/opa/storage/internal/ptr"
+	"github.com/open-policy-agent/opa/util"
+)
+
+const (
+	dataPrefix   = "/data/"
+	policyPrefix = "/policies/"
+)
+
+// Options contains parameters that configure the disk-based store.
+type Options struct {
+	Backend         Backend       // key-value store to persist policies and data in
+	CompactInterval time.Duration // period between compactions of the backend, zero disables them
+}
+
+// Store provides a disk-based implementation of the storage.Store interface.
+type Store struct {
+	backend  Backend              // underlying key-value store
+	xid      uint64               // next transaction id
+	wmu      sync.Mutex           // writer lock
+	tmu      sync.Mutex           // trigger lock
+	triggers map[*handle]struct{} // registered triggers
+	close    chan struct{}        // close-only channel for stopping the compaction goroutine
+}
+
+// New returns a new disk-based store based on the provided options.
+func New(_ context.Context, logger logging.Logger, opts Options) (*Store, error) {
+	if opts.Backend == nil {
+		return nil, &storage.Error{Code: storage.InternalErr, Message: "backend not configured"}
+	}
+
+	store := &Store{
+		backend:  opts.Backend,
+		triggers: map[*handle]struct{}{},
+		close:    make(chan struct{}),
+	}
+
+	if opts.CompactInterval > 0 {
+		go store.compactPeriodically(logger, opts.CompactInterval)
+	}
+
+	return store, nil
+}
+
+func (db *Store) compactPeriodically(logger logging.Logger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.close:
+			return
+		case <-ticker.C:
+			if err := db.Compact(context.Background()); err != nil {
+				logger.Debug("Compact: err=%v", err)
+			}
+		}
+	}
+}
+
+// Compact reclaims space in the backend occupied by deleted or overwritten
+// values.
+func (db *Store) Compact(ctx context.Context) error {
+	return wrapError(db.backend.Compact(ctx))
+}
+
+// Close stops the compaction routine and closes the backend.
+func (db *Store) Close(context.Context) error {
+	close(db.close)
+	return wrapError(db.backend.Close())
+}
+
+// NewTransaction implements the storage.Store interface.
+func (db *Store) NewTransaction(_ context.Context, params ...storage.TransactionParams) (storage.Transaction, error) {
+	var write bool
+	var context *storage.Context
+
+	if len(params) > 0 {
+		write = params[0].Write
+		context = params[0].Context
+	}
+
+	if write {
+		db.wmu.Lock() // only one concurrent write txn
+	}
+
+	underlying, err := db.backend.NewTransaction(write)
+	if err != nil {
+		if write {
+			db.wmu.Unlock()
+		}
+		return nil, wrapError(err)
+	}
+
+	xid := atomic.AddUint64(&db.xid, uint64(1))
+	return &transaction{xid: xid, write: write, underlying: underlying, context: context, db: db}, nil
+}
+
+// Commit implements the storage.Store interface.
+func (db *Store) Commit(ctx context.Context, txn storage.Transaction) error {
+	underlying, err := db.underlying(txn)
+	if err != nil {
+		return err
+	}
+	if !underlying.write {
+		underlying.stale = true
+		underlying.underlying.Discard()
+		return nil
+	}
+
+	defer db.wmu.Unlock()
+	underlying.stale = true
+
+	if err := underlying.underlying.Commit(); err != nil {
+		return wrapError(err)
+	}
+
+	event := storage.TriggerEvent{
+		Policy:  underlying.policies,
+		Data:    underlying.updates,
+		Context: underlying.context,
+	}
+
+	readOnly, err := db.backend.NewTransaction(false)
+	if err != nil {
+		return wrapError(err)
+	}
+	defer readOnly.Discard()
+
+	xid := atomic.AddUint64(&db.xid, uint64(1))
+	readTxn := &transaction{xid: xid, underlying: readOnly, db: db}
+
+	db.tmu.Lock()
+	defer db.tmu.Unlock()
+	for h := range db.triggers {
+		if event, ok := h.config.Filter(event); ok {
+			h.config.OnCommit(ctx, readTxn, event)
+		}
+	}
+
+	return nil
+}
+
+// Abort implements the storage.Store interface.
+func (db *Store) Abort(_ context.Context, txn storage.Transaction) {
+	underlying, err := db.underlying(txn)
+	if err != nil {
+		panic(err)
+	}
+	underlying.stale = true
+	underlying.underlying.Discard()
+	if underlying.write {
+		db.wmu.Unlock()
+	}
+}
+
+// Truncate implements the storage.Store interface. This method must be called
+// within a write transaction.
+func (db *Store) Truncate(ctx context.Context, txn storage.Transaction, params storage.TransactionParams, it storage.Iterator) error {
+	underlying, err := db.writable(txn)
+	if err != nil {
+		return err
+	}
+
+	var update *storage.Update
+	mergedData := map[string]interface{}{}
+
+	for {
+		update, err = it.Next()
+		if err != nil {
+			break
+		}
+
+		if update.IsPolicy {
+			if err := underlying.UpsertPolicy(strings.TrimLeft(update.Path.String(), "/"), update.Value); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var value interface{}
+		if err := util.Unmarshal(update.Value, &value); err != nil {
+			return err
+		}
+
+		if value != nil {
+			obj, err := mktree(update.Path, value)
+			if err != nil {
+				return err
+			}
+
+			merged, ok := merge.InterfaceMaps(mergedData, obj)
+			if !ok {
+				return fmt.Errorf("failed to insert data file from path %s", update.Path)
+			}
+			mergedData = merged
+		}
+	}
+
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	if params.RootOverwrite {
+		return underlying.Write(storage.AddOp, storage.Path{}, mergedData)
+	}
+
+	for _, root := range params.BasePaths {
+		newPath, ok := storage.ParsePathEscaped("/" + root)
+		if !ok {
+			return fmt.Errorf("storage path invalid: %v", newPath)
+		}
+
+		value, err := ptr.Ptr(mergedData, newPath)
+		if err != nil {
+			continue
+		}
+		if len(newPath) > 0 {
+			if err := db.MakeDir(ctx, txn, newPath[:len(newPath)-1]); err != nil {
+				return err
+			}
+		}
+		if err := underlying.Write(storage.AddOp, newPath, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Read implements the storage.Store interface.
+func (db *Store) Read(_ context.Context, txn storage.Transaction, path storage.Path) (interface{}, error) {
+	underlying, err := db.underlying(txn)
+	if err != nil {
+		return nil, err
+	}
+	return underlying.Read(path)
+}
+
+// ReadBatch implements the storage.BatchReader interface. All paths are read
+// from the same snapshot and each top-level document is only decoded once.
+func (db *Store) ReadBatch(_ context.Context, txn storage.Transaction, paths []storage.Path) ([]storage.BatchReadResult, error) {
+	underlying, err := db.underlying(txn)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := map[string]interface{}{}
+	results := make([]storage.BatchReadResult, len(paths))
+	for i, path := range paths {
+		if len(path) == 0 {
+			results[i].Value, results[i].Err = underlying.Read(path)
+		} else {
+			doc, ok := docs[path[0]]
+			if !ok {
+				doc, err = underlying.readDoc(path[0])
+				if err != nil && !storage.IsNotFound(err) {
+					return nil, err
+				}
+				docs[path[0]] = doc
+			}
+			if doc == nil {
+				results[i].Err = errors.NewNotFoundError(path)
+				continue
+			}
+			results[i].Value, results[i].Err = ptr.Ptr(doc, path[1:])
+		}
+		if results[i].Err != nil && !storage.IsNotFound(results[i].Err) {
+			return nil, results[i].Err
+		}
+	}
+	return results, nil
+}
+
+// Write implements the storage.Store interface.
+func (db *Store) Write(_ context.Context, txn storage.Transaction, op storage.PatchOp, path storage.Path, value interface{}) error {
+	underlying, err := db.writable(txn)
+	if err != nil {
+		return err
+	}
+	val := util.Reference(value)
+	if err := util.RoundTrip(val); err != nil {
+		return wrapError(err)
+	}
+	return underlying.Write(op, path, *val)
+}
+
+// MakeDir makes Store a storage.MakeDirer so that the parent object of a
+// nested path can be created without reading the entire top-level document
+// repeatedly.
+func (db *Store) MakeDir(_ context.Context, txn storage.Transaction, path storage.Path) error {
+	underlying, err := db.writable(txn)
+	if err != nil {
+		return err
+	}
+	for i := 1; i <= len(path); i++ {
+		node, err := underlying.Read(path[:i])
+		if err != nil {
+			if !storage.IsNotFound(err) {
+				return err
+			}
+			if err := underlying.Write(storage.AddOp, path[:i], map[string]interface{}{}); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, ok := node.(map[string]interface{}); !ok {
+			return errors.NewWriteConflictError(path[:i])
+		}
+	}
+	return nil
+}
+
+// ListPolicies implements the storage.Policy interface.
+func (db *Store) ListPolicies(_ context.Context, txn storage.Transaction) ([]string, error) {
+	underlying, err := db.underlying(txn)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	if err := underlying.underlying.Iterate([]byte(policyPrefix), func(key, _ []byte) error {
+		ids = append(ids, string(bytes.TrimPrefix(key, []byte(policyPrefix))))
+		return nil
+	}); err != nil {
+		return nil, wrapError(err)
+	}
+	return ids, nil
+}
+
+// GetPolicy implements the storage.Policy interface.
+func (db *Store) GetPolicy(_ context.Context, txn storage.Transaction, id string) ([]byte, error) {
+	underlying, err := db.underlying(txn)
+	if err != nil {
+		return nil, err
+	}
+	bs, err := underlying.underlying.Get([]byte(policyPrefix + id))
+	if err != nil {
+		if err == ErrKeyNotFound {
+			return nil, errors.NewNotFoundErrorf("policy id %q", id)
+		}
+		return nil, wrapError(err)
+	}
+	return bs, nil
+}
+
+// UpsertPolicy implements the storage.Policy interface.
+func (db *Store) UpsertPolicy(_ context.Context, txn storage.Transaction, id string, bs []byte) error {
+	underlying, err := db.writable(txn)
+	if err != nil {
+		return err
+	}
+	return underlying.UpsertPolicy(id, bs)
+}
+
+// DeletePolicy implements the storage.Policy interface.
+func (db *Store) DeletePolicy(ctx context.Context, txn storage.Transaction, id string) error {
+	underlying, err := db.writable(txn)
+	if err != nil {
+		return err
+	}
+	if _, err := db.GetPolicy(ctx, txn, id); err != nil {
+		return err
+	}
+	if err := underlying.underlying.Delete([]byte(policyPrefix + id)); err != nil {
+		return wrapError(err)
+	}
+	underlying.policies = append(underlying.policies, storage.PolicyEvent{ID: id, Removed: true})
+	return nil
+}
+
+// Register implements the storage.Trigger interface.
+func (db *Store) Register(_ context.Context, txn storage.Transaction, config storage.TriggerConfig) (storage.TriggerHandle, error) {
+	if _, err := db.writable(txn); err != nil {
+		return nil, err
+	}
+	h := &handle{db: db, config: config}
+	db.tmu.Lock()
+	defer db.tmu.Unlock()
+	db.triggers[h] = struct{}{}
+	return h, nil
+}
+
+type handle struct {
+	db     *Store
+	config storage.TriggerConfig
+}
+
+// Unregister implements the storage.TriggerHandle interface.
+func (h *handle) Unregister(_ context.Context, txn storage.Transaction) {
+	if _, err := h.db.writable(txn); err != nil {
+		panic(err)
+	}
+	h.db.tmu.Lock()
+	defer h.db.tmu.Unlock()
+	delete(h.db.triggers, h)
+}
+
+func (db *Store) underlying(txn storage.Transaction) (*transaction, error) {
+	underlying, ok := txn.(*transaction)
+	if !ok {
+		return nil, &storage.Error{
+			Code:    storage.InvalidTransactionErr,
+			Message: "unexpected transaction type",
+		}
+	}
+	if underlying.db != db {
+		return nil, &storage.Error{
+			Code:    storage.InvalidTransactionErr,
+			Message: "unknown transaction",
+		}
+	}
+	if underlying.stale {
+		return nil, &storage.Error{
+			Code:    storage.InvalidTransactionErr,
+			Message: "stale transaction",
+		}
+	}
+	return underlying, nil
+}
+
+func (db *Store) writable(txn storage.Transaction) (*transaction, error) {
+	underlying, err := db.underlying(txn)
+	if err != nil {
+		return nil, err
+	}
+	if !underlying.write {
+		return nil, &storage.Error{
+			Code:    storage.InvalidTransactionErr,
+			Message: "transaction must be write",
+		}
+	}
+	return underlying, nil
+}
+
+type transaction struct {
+	xid        uint64
+	write      bool
+	stale      bool
+	underlying BackendTxn
+	context    *storage.Context
+	updates    []storage.DataEvent
+	policies   []storage.PolicyEvent
+	db         *Store
+}
+
+func (txn *transaction) ID() uint64 {
+	return txn.xid
+}
+
+func (txn *transaction) Read(path storage.Path) (interface{}, error) {
+	if len(path) == 0 {
+		result := map[string]interface{}{}
+		if err := txn.underlying.Iterate([]byte(dataPrefix), func(key, value []byte) error {
+			var doc interface{}
+			if err := util.UnmarshalJSON(value, &doc); err != nil {
+				return err
+			}
+			result[string(bytes.TrimPrefix(key, []byte(dataPrefix)))] = doc
+			return nil
+		}); err != nil {
+			return nil, wrapError(err)
+		}
+		return result, nil
+	}
+
+	doc, err := txn.readDoc(path[0])
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, errors.NewNotFoundError(path)
+	}
+	return ptr.Ptr(doc, path[1:])
+}
+
+// readDoc returns the top-level document stored under key or nil if it does
+// not exist.
+func (txn *transaction) readDoc(key string) (interface{}, error) {
+	bs, err := txn.underlying.Get([]byte(dataPrefix + key))
+	if err != nil {
+		if err == ErrKeyNotFound {
+			return nil, nil
+		}
+		return nil, wrapError(err)
+	}
+	var doc interface{}
+	if err := util.UnmarshalJSON(bs, &doc); err != nil {
+		return nil, wrapError(err)
+	}
+	return doc, nil
+}
+
+func (txn *transaction) writeDoc(key string, doc interface{}) error {
+	bs, err := json.Marshal(doc)
+	if err != nil {
+		return wrapError(err)
+	}
+	return wrapError(txn.underlying.Set([]byte(dataPrefix+key), bs))
+}
+
+func (txn *transaction) Write(op storage.PatchOp, path storage.Path, value interface{}) error {
+	if len(path) == 0 {
+		return txn.writeRoot(op, value)
+	}
+
+	doc, err := txn.readDoc(path[0])
+	if err != nil {
+		return err
+	}
+
+	if len(path) == 1 {
+		switch op {
+		case storage.AddOp:
+			doc = value
+		case storage.ReplaceOp:
+			if doc == nil {
+				return errors.NewNotFoundError(path)
+			}
+			doc = value
+		case storage.RemoveOp:
+			if doc == nil {
+				return errors.NewNotFoundError(path)
+			}
+			if err := txn.underlying.Delete([]byte(dataPrefix + path[0])); err != nil {
+				return wrapError(err)
+			}
+			txn.updates = append(txn.updates, storage.DataEvent{Path: path, Removed: true, Op: op})
+			return nil
+		}
+	} else {
+		if doc == nil {
+			return errors.NewNotFoundError(path)
+		}
+		doc, err = patch(doc, op, path, 1, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := txn.writeDoc(path[0], doc); err != nil {
+		return err
+	}
+	txn.updates = append(txn.updates, storage.DataEvent{Path: path, Data: value, Removed: op == storage.RemoveOp, Op: op})
+	return nil
+}
+
+func (txn *transaction) writeRoot(op storage.PatchOp, value interface{}) error {
+	if op == storage.RemoveOp {
+		return &storage.Error{Code: storage.InvalidPatchErr, Message: "root cannot be removed"}
+	}
+	obj, ok := value.(map[string]interface{})
+	if !ok {
+		return &storage.Error{Code: storage.InvalidPatchErr, Message: "root must be object"}
+	}
+	if err := txn.deletePrefix([]byte(dataPrefix)); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := txn.writeDoc(k, obj[k]); err != nil {
+			return err
+		}
+	}
+	txn.updates = append(txn.updates, storage.DataEvent{Path: storage.Path{}, Data: value, Op: op})
+	return nil
+}
+
+func (txn *transaction) deletePrefix(prefix []byte) error {
+	var keys [][]byte
+	if err := txn.underlying.Iterate(prefix, func(key, _ []byte) error {
+		keys = append(keys, append([]byte(nil), key...))
+		return nil
+	}); err != nil {
+		return wrapError(err)
+	}
+	for _, key := range keys {
+		if err := txn.underlying.Delete(key); err != nil {
+			return wrapError(err)
+		}
+	}
+	return nil
+}
+
+func (txn *transaction) UpsertPolicy(id string, bs []byte) error {
+	if err := txn.underlying.Set([]byte(policyPrefix+id), bs); err != nil {
+		return wrapError(err)
+	}
+	txn.policies = append(txn.policies, storage.PolicyEvent{ID: id, Data: bs})
+	return nil
+}
+
+func mktree(path storage.Path, value interface{}) (map[string]interface{}, error) {
+	if len(path) == 0 {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, &storage.Error{Code: storage.InvalidPatchErr, Message: "root must be object"}
+		}
+		return obj, nil
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		value = map[string]interface{}{path[i]: value}
+	}
+	return value.(map[string]interface{}), nil
+}
+
+// patch applies op to the value at path[i:] inside of node and returns the
+// patched node.
+func patch(node interface{}, op storage.PatchOp, path storage.Path, i int, value interface{}) (interface{}, error) {
+	key := path[i]
+	last := i == len(path)-1
+
+	switch curr := node.(type) {
+	case map[string]interface{}:
+		child, ok := curr[key]
+		if !last {
+			if !ok {
+				return nil, errors.NewNotFoundError(path)
+			}
+			child, err := patch(child, op, path, i+1, value)
+			if err != nil {
+				return nil, err
+			}
+			curr[key] = child
+			return curr, nil
+		}
+		switch op {
+		case storage.AddOp:
+			curr[key] = value
+		case storage.ReplaceOp:
+			if !ok {
+				return nil, errors.NewNotFoundError(path)
+			}
+			curr[key] = value
+		case storage.RemoveOp:
+			if !ok {
+				return nil, errors.NewNotFoundError(path)
+			}
+			delete(curr, key)
+		}
+		return curr, nil
+
+	case []interface{}:
+		if last && op == storage.AddOp {
+			if key == "-" {
+				return append(curr, value), nil
+			}
+			pos, err := ptr.ValidateArrayIndexForWrite(curr, key, i+1, path)
+			if err != nil {
+				return nil, err
+			}
+			curr = append(curr, nil)
+			copy(curr[pos+1:], curr[pos:])
+			curr[pos] = value
+			return curr, nil
+		}
+		pos, err := ptr.ValidateArrayIndex(curr, key, path)
+		if err != nil {
+			return nil, err
+		}
+		if !last {
+			child, err := patch(curr[pos], op, path, i+1, value)
+			if err != nil {
+				return nil, err
+			}
+			curr[pos] = child
+			return curr, nil
+		}
+		if op == storage.RemoveOp {
+			return append(curr[:pos], curr[pos+1:]...), nil
+		}
+		curr[pos] = value
+		return curr, nil
+	}
+
+	return nil, errors.NewNotFoundError(path)
+}
+
+func wrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*storage.Error); ok {
+		return err
+	}
+	return &storage.Error{Code: storage.InternalErr, Message: err.Error()}
+}
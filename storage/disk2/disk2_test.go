@@ -0,0 +1,400 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package disk2
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/open-policy-agent/opa/bundle"
+	"github.com/open-policy-agent/opa/internal/file/archive"
+	"github.com/open-policy-agent/opa/logging"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/util"
+)
+
+func newTestStore(t *testing.T, dir string) *Store {
+	t.Helper()
+	backend, err := NewBadgerBackend(BadgerOptions{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := New(context.Background(), logging.NewNoOpLogger(), Options{Backend: backend})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestDataReadWrite(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		note    string
+		op      storage.PatchOp
+		path    string
+		value   string
+		read    string
+		exp     string
+		errCode string
+	}{
+		{note: "add top-level", op: storage.AddOp, path: "/x", value: `{"y": 1}`, read: "/x", exp: `{"y": 1}`},
+		{note: "add nested", op: storage.AddOp, path: "/a/b/d", value: `3`, read: "/a/b", exp: `{"c": [1, 2], "d": 3}`},
+		{note: "add array append", op: storage.AddOp, path: "/a/b/c/-", value: `3`, read: "/a/b/c", exp: `[1, 2, 3]`},
+		{note: "add array insert", op: storage.AddOp, path: "/a/b/c/0", value: `0`, read: "/a/b/c", exp: `[0, 1, 2]`},
+		{note: "replace nested", op: storage.ReplaceOp, path: "/a/b/c/1", value: `4`, read: "/a/b/c", exp: `[1, 4]`},
+		{note: "remove nested", op: storage.RemoveOp, path: "/a/b/c/0", read: "/a/b/c", exp: `[2]`},
+		{note: "remove top-level", op: storage.RemoveOp, path: "/a", read: "/", exp: `{"e": "f"}`},
+		{note: "replace root", op: storage.ReplaceOp, path: "/", value: `{"z": true}`, read: "/", exp: `{"z": true}`},
+		{note: "add missing parent", op: storage.AddOp, path: "/a/x/y", value: `1`, errCode: storage.NotFoundErr},
+		{note: "replace missing", op: storage.ReplaceOp, path: "/q", value: `1`, errCode: storage.NotFoundErr},
+		{note: "remove array out of range", op: storage.RemoveOp, path: "/a/b/c/7", errCode: storage.NotFoundErr},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			store := newTestStore(t, t.TempDir())
+			defer store.Close(ctx)
+
+			if err := storage.WriteOne(ctx, store, storage.AddOp, storage.Path{}, util.MustUnmarshalJSON([]byte(`{"a": {"b": {"c": [1, 2]}}, "e": "f"}`))); err != nil {
+				t.Fatal(err)
+			}
+
+			var value interface{}
+			if tc.value != "" {
+				value = util.MustUnmarshalJSON([]byte(tc.value))
+			}
+
+			err := storage.WriteOne(ctx, store, tc.op, storage.MustParsePath(tc.path), value)
+			if tc.errCode != "" {
+				if e, ok := err.(*storage.Error); !ok || e.Code != tc.errCode {
+					t.Fatalf("Expected %v error but got %v", tc.errCode, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := storage.ReadOne(ctx, store, storage.MustParsePath(tc.read))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if exp := util.MustUnmarshalJSON([]byte(tc.exp)); !reflect.DeepEqual(result, exp) {
+				t.Fatalf("Expected %v but got %v", exp, result)
+			}
+		})
+	}
+}
+
+func TestDataPersisted(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	store := newTestStore(t, dir)
+	if err := storage.WriteOne(ctx, store, storage.AddOp, storage.MustParsePath("/x"), map[string]interface{}{"y": "z"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Txn(ctx, store, storage.WriteParams, func(txn storage.Transaction) error {
+		return store.UpsertPolicy(ctx, txn, "test.rego", []byte("package test"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	store = newTestStore(t, dir)
+	defer store.Close(ctx)
+
+	result, err := storage.ReadOne(ctx, store, storage.MustParsePath("/x/y"))
+	if err != nil {
+		t.Fatal(err)
+	} else if result != "z" {
+		t.Fatalf("Expected z but got %v", result)
+	}
+
+	txn := storage.NewTransactionOrDie(ctx, store)
+	defer store.Abort(ctx, txn)
+
+	ids, err := store.ListPolicies(ctx, txn)
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(ids, []string{"test.rego"}) {
+		t.Fatalf("Expected [test.rego] but got %v", ids)
+	}
+
+	bs, err := store.GetPolicy(ctx, txn, "test.rego")
+	if err != nil {
+		t.Fatal(err)
+	} else if string(bs) != "package test" {
+		t.Fatalf("Unexpected policy %q", bs)
+	}
+}
+
+func TestSnapshotIsolation(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t, t.TempDir())
+	defer store.Close(ctx)
+
+	if err := storage.WriteOne(ctx, store, storage.AddOp, storage.MustParsePath("/x"), "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Commits do not wait for open read transactions and are not visible to them.
+	txn := storage.NewTransactionOrDie(ctx, store)
+	defer store.Abort(ctx, txn)
+
+	if err := storage.WriteOne(ctx, store, storage.ReplaceOp, storage.MustParsePath("/x"), "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if result, err := store.Read(ctx, txn, storage.MustParsePath("/x")); err != nil {
+		t.Fatal(err)
+	} else if result != "a" {
+		t.Fatalf("Expected a but got %v", result)
+	}
+
+	if result, err := storage.ReadOne(ctx, store, storage.MustParsePath("/x")); err != nil {
+		t.Fatal(err)
+	} else if result != "b" {
+		t.Fatalf("Expected b but got %v", result)
+	}
+}
+
+func TestTriggers(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t, t.TempDir())
+	defer store.Close(ctx)
+
+	var events []storage.TriggerEvent
+	if err := storage.Txn(ctx, store, storage.WriteParams, func(txn storage.Transaction) error {
+		_, err := store.Register(ctx, txn, storage.TriggerConfig{
+			OnCommit: func(ctx context.Context, txn storage.Transaction, event storage.TriggerEvent) {
+				if event.IsZero() {
+					return
+				}
+				if _, err := store.Read(ctx, txn, storage.MustParsePath("/x")); err != nil {
+					t.Errorf("Expected committed value to be readable: %v", err)
+				}
+				events = append(events, event)
+			},
+		})
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.WriteOne(ctx, store, storage.AddOp, storage.MustParsePath("/x"), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 1 || !events[0].DataChanged() || events[0].PolicyChanged() {
+		t.Fatalf("Unexpected events: %v", events)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t, t.TempDir())
+	defer store.Close(ctx)
+
+	if err := storage.WriteOne(ctx, store, storage.AddOp, storage.Path{}, util.MustUnmarshalJSON([]byte(`{"a": {"b": 1}, "c": 2}`))); err != nil {
+		t.Fatal(err)
+	}
+
+	var archiveFiles = map[string]string{
+		"/a/b/data.json": `{"x": 1}`,
+		"/policy.rego":   "package a.b",
+	}
+
+	var files [][2]string
+	for name, content := range archiveFiles {
+		files = append(files, [2]string{name, content})
+	}
+
+	buf := archive.MustWriteTarGz(files)
+	b, err := bundle.NewReader(buf).WithLazyLoadingMode(true).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iterator := bundle.NewIterator(b.Raw)
+
+	params := storage.WriteParams
+	params.BasePaths = []string{"a/b"}
+
+	if err := storage.Txn(ctx, store, params, func(txn storage.Transaction) error {
+		return store.Truncate(ctx, txn, params, iterator)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := storage.ReadOne(ctx, store, storage.Path{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := util.MustUnmarshalJSON([]byte(`{"a": {"b": {"x": 1}}, "c": 2}`)); !reflect.DeepEqual(result, exp) {
+		t.Fatalf("Expected %v but got %v", exp, result)
+	}
+
+	txn := storage.NewTransactionOrDie(ctx, store)
+	defer store.Abort(ctx, txn)
+
+	bs, err := store.GetPolicy(ctx, txn, "policy.rego")
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(bs, []byte("package a.b")) {
+		t.Fatalf("Unexpected policy %q", bs)
+	}
+}
+
+func TestReadBatch(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t, t.TempDir())
+	defer store.Close(ctx)
+
+	if err := storage.WriteOne(ctx, store, storage.AddOp, storage.Path{}, util.MustUnmarshalJSON([]byte(`{"a": {"b": 1, "c": 2}}`))); err != nil {
+		t.Fatal(err)
+	}
+
+	txn := storage.NewTransactionOrDie(ctx, store)
+	defer store.Abort(ctx, txn)
+
+	results, err := storage.ReadBatch(ctx, store, txn, []storage.Path{
+		storage.MustParsePath("/a/b"),
+		storage.MustParsePath("/a/c"),
+		storage.MustParsePath("/x/y"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 3 || !storage.IsNotFound(results[2].Err) {
+		t.Fatalf("Unexpected results: %v", results)
+	}
+	for i, exp := range []interface{}{util.MustUnmarshalJSON([]byte(`1`)), util.MustUnmarshalJSON([]byte(`2`))} {
+		if results[i].Err != nil || !reflect.DeepEqual(results[i].Value, exp) {
+			t.Fatalf("Expected %v but got %v", exp, results[i])
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t, t.TempDir())
+	defer store.Close(ctx)
+
+	if err := storage.WriteOne(ctx, store, storage.AddOp, storage.MustParsePath("/x"), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Compact(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackends(t *testing.T) {
+	backends := map[string]func(dir string) (Backend, error){
+		BackendBadger: func(dir string) (Backend, error) { return NewBadgerBackend(BadgerOptions{Dir: dir}) },
+		BackendBolt:   func(dir string) (Backend, error) { return NewBoltBackend(BoltOptions{Dir: dir}) },
+	}
+
+	for name, newBackend := range backends {
+		t.Run(name, func(t *testing.T) {
+			backend, err := newBackend(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer backend.Close()
+
+			write := func(f func(BackendTxn) error) {
+				t.Helper()
+				txn, err := backend.NewTransaction(true)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := f(txn); err != nil {
+					txn.Discard()
+					t.Fatal(err)
+				}
+				if err := txn.Commit(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			iterate := func(txn BackendTxn, prefix string) map[string]string {
+				t.Helper()
+				result := map[string]string{}
+				if err := txn.Iterate([]byte(prefix), func(key, value []byte) error {
+					result[string(key)] = string(value)
+					return nil
+				}); err != nil {
+					t.Fatal(err)
+				}
+				return result
+			}
+
+			write(func(txn BackendTxn) error {
+				for _, k := range []string{"/a/1", "/a/2", "/b/1"} {
+					if err := txn.Set([]byte(k), []byte(k)); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+
+			snapshot, err := backend.NewTransaction(false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer snapshot.Discard()
+
+			// Write transactions observe their own pending writes.
+			write(func(txn BackendTxn) error {
+				if err := txn.Delete([]byte("/a/1")); err != nil {
+					return err
+				}
+				if err := txn.Set([]byte("/a/3"), []byte("x")); err != nil {
+					return err
+				}
+				if _, err := txn.Get([]byte("/a/1")); err != ErrKeyNotFound {
+					t.Fatalf("expected deleted key to be missing, got %v", err)
+				}
+				if exp, result := map[string]string{"/a/2": "/a/2", "/a/3": "x"}, iterate(txn, "/a/"); !reflect.DeepEqual(exp, result) {
+					t.Fatalf("expected %v but got %v", exp, result)
+				}
+				return nil
+			})
+
+			// Read transactions observe the snapshot as of their creation.
+			if value, err := snapshot.Get([]byte("/a/1")); err != nil || string(value) != "/a/1" {
+				t.Fatalf("expected snapshot value, got %q, %v", value, err)
+			}
+			if exp, result := map[string]string{"/a/1": "/a/1", "/a/2": "/a/2"}, iterate(snapshot, "/a/"); !reflect.DeepEqual(exp, result) {
+				t.Fatalf("expected %v but got %v", exp, result)
+			}
+
+			txn, err := backend.NewTransaction(false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer txn.Discard()
+
+			if exp, result := map[string]string{"/a/2": "/a/2", "/a/3": "x"}, iterate(txn, "/a/"); !reflect.DeepEqual(exp, result) {
+				t.Fatalf("expected %v but got %v", exp, result)
+			}
+			if _, err := txn.Get([]byte("/c")); err != ErrKeyNotFound {
+				t.Fatalf("expected missing key, got %v", err)
+			}
+			if err := backend.Compact(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package inmem
+
+import (
+	"github.com/open-policy-agent/opa/config"
+	"github.com/open-policy-agent/opa/util"
+)
+
+// Config contains the parameters of the in-memory store that can be set in
+// the OPA configuration.
+type Config struct {
+	SnapshotReads bool `json:"snapshot_reads"` // see OptSnapshotReads
+}
+
+// ConfigFromRaw parses the passed config and extracts the in-memory storage
+// settings. If the in-memory store is not configured, nil is returned.
+func ConfigFromRaw(raw []byte, id string) (*Config, error) {
+	parsedConfig, err := config.ParseConfig(raw, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if parsedConfig.Storage == nil || len(parsedConfig.Storage.Inmem) == 0 {
+		return nil, nil
+	}
+
+	var c Config
+	if err := util.Unmarshal(parsedConfig.Storage.Inmem, &c); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// Opts returns the options that configure a store according to c.
+func (c Config) Opts() []Opt {
+	return []Opt{OptSnapshotReads(c.SnapshotReads)}
+}
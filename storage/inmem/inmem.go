@@ -17,12 +17,14 @@ package inmem
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"path/filepath"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"unicode/utf8"
 
 	"github.com/open-policy-agent/opa/internal/merge"
 	"github.com/open-policy-agent/opa/storage"
@@ -101,6 +103,11 @@ type store struct {
 	// roundTripOnWrite, if true, means that every call to Write round trips the
 	// data through JSON before adding the data to the store. Defaults to true.
 	roundTripOnWrite bool
+
+	// snapshotReads, if true, means that read transactions operate on
+	// immutable snapshots of the data and policies and do not block commits.
+	// Defaults to false.
+	snapshotReads bool
 }
 
 type handle struct {
@@ -117,6 +124,12 @@ func (db *store) NewTransaction(_ context.Context, params ...storage.Transaction
 	xid := atomic.AddUint64(&db.xid, uint64(1))
 	if write {
 		db.wmu.Lock()
+	} else if db.snapshotReads {
+		db.rmu.RLock()
+		defer db.rmu.RUnlock()
+		txn := newTransaction(xid, write, ctx, db)
+		txn.snapshot = &snapshot{data: db.data, policies: db.policies}
+		return txn, nil
 	} else {
 		db.rmu.RLock()
 	}
@@ -220,7 +233,7 @@ func (db *store) Commit(ctx context.Context, txn storage.Transaction) error {
 		underlying.stale = true
 		db.rmu.Unlock()
 		db.wmu.Unlock()
-	} else {
+	} else if underlying.snapshot == nil {
 		db.rmu.RUnlock()
 	}
 	return nil
@@ -234,7 +247,7 @@ func (db *store) Abort(_ context.Context, txn storage.Transaction) {
 	underlying.stale = true
 	if underlying.write {
 		db.wmu.Unlock()
-	} else {
+	} else if underlying.snapshot == nil {
 		db.rmu.RUnlock()
 	}
 }
@@ -305,9 +318,11 @@ func (db *store) Write(_ context.Context, txn storage.Transaction, op storage.Pa
 	}
 	val := util.Reference(value)
 	if db.roundTripOnWrite {
-		if err := util.RoundTrip(val); err != nil {
+		x, err := roundTrip(*val)
+		if err != nil {
 			return err
 		}
+		*val = x
 	}
 	return underlying.Write(op, path, *val)
 }
@@ -328,7 +343,9 @@ func (h *handle) Unregister(_ context.Context, txn storage.Transaction) {
 
 func (db *store) runOnCommitTriggers(ctx context.Context, txn storage.Transaction, event storage.TriggerEvent) {
 	for _, t := range db.triggers {
-		t.OnCommit(ctx, txn, event)
+		if event, ok := t.Filter(event); ok {
+			t.OnCommit(ctx, txn, event)
+		}
 	}
 }
 
@@ -365,6 +382,74 @@ func invalidPatchError(f string, a ...interface{}) *storage.Error {
 	}
 }
 
+// maxRoundTripDepth is the depth below which documents are round tripped in
+// full, which detects the cycles of self-referential documents.
+const maxRoundTripDepth = 1000
+
+// roundTrip returns a copy of x, with the values that are not of the types that
+// JSON is decoded to round tripped through JSON. Objects and arrays are copied
+// member by member, so that large documents are never serialized in full.
+func roundTrip(x interface{}) (interface{}, error) {
+	return roundTripDepth(x, 0)
+}
+
+func roundTripDepth(x interface{}, depth int) (interface{}, error) {
+	if depth == maxRoundTripDepth {
+		if err := util.RoundTrip(&x); err != nil {
+			return nil, err
+		}
+		return x, nil
+	}
+
+	switch x := x.(type) {
+	case nil, bool:
+		return x, nil
+	case json.Number:
+		// Marshaling validates the contents of the number, which are decoded
+		// unchanged.
+		if _, err := json.Marshal(x); err != nil {
+			return nil, err
+		}
+		return x, nil
+	case string:
+		if utf8.ValidString(x) {
+			return x, nil
+		}
+	case map[string]interface{}:
+		cpy := make(map[string]interface{}, len(x))
+		for k, v := range x {
+			if !utf8.ValidString(k) {
+				rk, err := roundTripDepth(k, depth)
+				if err != nil {
+					return nil, err
+				}
+				k = rk.(string)
+			}
+			rv, err := roundTripDepth(v, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			cpy[k] = rv
+		}
+		return cpy, nil
+	case []interface{}:
+		cpy := make([]interface{}, len(x))
+		for i, v := range x {
+			rv, err := roundTripDepth(v, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			cpy[i] = rv
+		}
+		return cpy, nil
+	}
+
+	if err := util.RoundTrip(&x); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
 func mktree(path []string, value interface{}) (map[string]interface{}, error) {
 	if len(path) == 0 {
 		// For 0 length path the value is the full tree.
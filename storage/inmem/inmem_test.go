@@ -130,70 +130,72 @@ func TestInMemoryWrite(t *testing.T) {
 	ctx := context.Background()
 
 	for i, tc := range tests {
-		data := loadSmallTestData()
-		store := NewFromObject(data)
-
-		// Perform patch and check result
-		value := loadExpectedSortedResult(tc.value)
-
-		var op storage.PatchOp
-		switch tc.op {
-		case "add":
-			op = storage.AddOp
-		case "remove":
-			op = storage.RemoveOp
-		case "replace":
-			op = storage.ReplaceOp
-		default:
-			panic(fmt.Sprintf("illegal value: %v", tc.op))
-		}
-
-		err := storage.WriteOne(ctx, store, op, storage.MustParsePath(tc.path), value)
-		if tc.expected == nil {
-			if err != nil {
-				t.Errorf("Test case %d (%v): unexpected patch error: %v", i+1, tc.note, err)
-				continue
-			}
-		} else {
-			if err == nil {
-				t.Errorf("Test case %d (%v): expected patch error, but got nil instead", i+1, tc.note)
-				continue
-			}
-			if !reflect.DeepEqual(err, tc.expected) {
-				t.Errorf("Test case %d (%v): expected patch error %v but got: %v", i+1, tc.note, tc.expected, err)
-				continue
+		for _, opts := range [][]Opt{nil, {OptSnapshotReads(true)}} {
+			data := loadSmallTestData()
+			store := NewFromObjectWithOpts(data, opts...)
+
+			// Perform patch and check result
+			value := loadExpectedSortedResult(tc.value)
+
+			var op storage.PatchOp
+			switch tc.op {
+			case "add":
+				op = storage.AddOp
+			case "remove":
+				op = storage.RemoveOp
+			case "replace":
+				op = storage.ReplaceOp
+			default:
+				panic(fmt.Sprintf("illegal value: %v", tc.op))
 			}
-		}
 
-		if tc.getPath == "" {
-			continue
-		}
-
-		// Perform get and verify result
-		result, err := storage.ReadOne(ctx, store, storage.MustParsePath(tc.getPath))
-		switch expected := tc.getExpected.(type) {
-		case error:
-			if err == nil {
-				t.Errorf("Test case %d (%v): expected get error but got: %v", i+1, tc.note, result)
-				continue
-			}
-			if !reflect.DeepEqual(err, expected) {
-				t.Errorf("Test case %d (%v): expected get error %v but got: %v", i+1, tc.note, expected, err)
-				continue
+			err := storage.WriteOne(ctx, store, op, storage.MustParsePath(tc.path), value)
+			if tc.expected == nil {
+				if err != nil {
+					t.Errorf("Test case %d (%v): unexpected patch error: %v", i+1, tc.note, err)
+					continue
+				}
+			} else {
+				if err == nil {
+					t.Errorf("Test case %d (%v): expected patch error, but got nil instead", i+1, tc.note)
+					continue
+				}
+				if !reflect.DeepEqual(err, tc.expected) {
+					t.Errorf("Test case %d (%v): expected patch error %v but got: %v", i+1, tc.note, tc.expected, err)
+					continue
+				}
 			}
-		case string:
-			if err != nil {
-				t.Errorf("Test case %d (%v): unexpected get error: %v", i+1, tc.note, err)
+
+			if tc.getPath == "" {
 				continue
 			}
 
-			e := loadExpectedResult(expected)
-
-			if !reflect.DeepEqual(result, e) {
-				t.Errorf("Test case %d (%v): expected get result %v but got: %v", i+1, tc.note, e, result)
+			// Perform get and verify result
+			result, err := storage.ReadOne(ctx, store, storage.MustParsePath(tc.getPath))
+			switch expected := tc.getExpected.(type) {
+			case error:
+				if err == nil {
+					t.Errorf("Test case %d (%v): expected get error but got: %v", i+1, tc.note, result)
+					continue
+				}
+				if !reflect.DeepEqual(err, expected) {
+					t.Errorf("Test case %d (%v): expected get error %v but got: %v", i+1, tc.note, expected, err)
+					continue
+				}
+			case string:
+				if err != nil {
+					t.Errorf("Test case %d (%v): unexpected get error: %v", i+1, tc.note, err)
+					continue
+				}
+
+				e := loadExpectedResult(expected)
+
+				if !reflect.DeepEqual(result, e) {
+					t.Errorf("Test case %d (%v): expected get result %v but got: %v", i+1, tc.note, e, result)
+				}
 			}
-		}
 
+		}
 	}
 
 }
@@ -710,6 +712,79 @@ func TestInMemoryTxnPolicies(t *testing.T) {
 
 }
 
+func TestInMemoryTriggersPaths(t *testing.T) {
+	ctx := context.Background()
+	store := NewFromObject(util.MustUnmarshalJSON([]byte(`{"a": {"b": 1, "c": [1]}, "x": 1}`)).(map[string]interface{}))
+
+	var events []storage.TriggerEvent
+	if err := storage.Txn(ctx, store, storage.WriteParams, func(txn storage.Transaction) error {
+		_, err := store.Register(ctx, txn, storage.TriggerConfig{
+			Paths: []storage.Path{storage.MustParsePath("/a")},
+			OnCommit: func(_ context.Context, _ storage.Transaction, event storage.TriggerEvent) {
+				events = append(events, event)
+			},
+		})
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes to other paths do not invoke the trigger.
+	if err := storage.WriteOne(ctx, store, storage.ReplaceOp, storage.MustParsePath("/x"), 2); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("Expected no events but got %v", events)
+	}
+
+	if err := storage.Txn(ctx, store, storage.WriteParams, func(txn storage.Transaction) error {
+		for _, w := range []struct {
+			op    storage.PatchOp
+			path  string
+			value interface{}
+		}{
+			{storage.ReplaceOp, "/a/b", 2},
+			{storage.AddOp, "/a/d", "e"},
+			{storage.AddOp, "/a/c/-", 2},
+			{storage.AddOp, "/y", 3},
+		} {
+			if err := store.Write(ctx, txn, w.op, storage.MustParsePath(w.path), w.value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.WriteOne(ctx, store, storage.RemoveOp, storage.MustParsePath("/a"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events but got %v", events)
+	}
+
+	var patches [][]storage.PatchOperation
+	for _, e := range events {
+		patches = append(patches, e.Patch())
+	}
+
+	exp := [][]storage.PatchOperation{
+		{
+			{Op: "replace", Path: "/a/c", Value: []interface{}{json.Number("1"), json.Number("2")}},
+			{Op: "add", Path: "/a/d", Value: "e"},
+			{Op: "replace", Path: "/a/b", Value: json.Number("2")},
+		},
+		{
+			{Op: "remove", Path: "/a"},
+		},
+	}
+
+	if !reflect.DeepEqual(exp, patches) {
+		t.Fatalf("Expected %v but got %v", exp, patches)
+	}
+}
+
 func TestInMemoryTriggers(t *testing.T) {
 
 	ctx := context.Background()
@@ -762,7 +837,7 @@ func TestInMemoryTriggers(t *testing.T) {
 		t.Fatalf("Expected policy and data change but got: %v", event)
 	}
 
-	expData := storage.DataEvent{Path: modifiedPath, Data: expectedValue, Removed: false}
+	expData := storage.DataEvent{Path: modifiedPath, Data: expectedValue, Removed: false, Op: storage.ReplaceOp}
 	if d := event.Data[0]; !reflect.DeepEqual(expData, d) {
 		t.Fatalf("Expected data event %v, got %v", expData, d)
 	}
@@ -945,6 +1020,11 @@ func TestOptRoundTripOnWrite(t *testing.T) {
 		opts:    []Opt{OptRoundTripOnWrite(true)},
 		obj:     invalidObject,
 		wantErr: true,
+	}, {
+		name:    "failure on invalid number round trip enabled",
+		opts:    []Opt{OptRoundTripOnWrite(true)},
+		obj:     map[string]interface{}{"foo": []interface{}{json.Number("not a number")}},
+		wantErr: true,
 	}, {
 		// While this represents a bad use case, it's how we know the round-tripping
 		// has been disabled.
@@ -973,3 +1053,108 @@ func TestOptRoundTripOnWrite(t *testing.T) {
 		})
 	}
 }
+
+func TestRoundTripOnWrite(t *testing.T) {
+	type point struct {
+		X int `json:"x"`
+	}
+
+	obj := map[string]interface{}{
+		"a": []interface{}{1, "x", true, nil, point{X: 2}},
+		"b": map[string]interface{}{"c": 1.5, "d\xff": "e\xff"},
+		"f": map[string]string{"g": "h"},
+	}
+
+	ctx := context.Background()
+	store := New()
+
+	if err := storage.WriteOne(ctx, store, storage.AddOp, storage.MustParsePath("/x"), obj); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := storage.ReadOne(ctx, store, storage.MustParsePath("/x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := util.MustUnmarshalJSON([]byte(`{
+		"a": [1, "x", true, null, {"x": 2}],
+		"b": {"c": 1.5, "d\ufffd": "e\ufffd"},
+		"f": {"g": "h"}
+	}`))
+
+	if !reflect.DeepEqual(exp, result) {
+		t.Fatalf("Expected %v but got %v", exp, result)
+	}
+
+	// The written object is copied, not modified.
+	if _, ok := obj["a"].([]interface{})[0].(int); !ok {
+		t.Fatalf("Expected written object to be unmodified but got %v", obj)
+	}
+}
+
+func TestOptSnapshotReads(t *testing.T) {
+	ctx := context.Background()
+	store := NewFromObjectWithOpts(map[string]interface{}{
+		"a": map[string]interface{}{"b": []interface{}{"c", "d"}},
+	}, OptSnapshotReads(true))
+
+	if err := storage.Txn(ctx, store, storage.WriteParams, func(txn storage.Transaction) error {
+		return store.UpsertPolicy(ctx, txn, "p1", []byte("package p1"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read transactions do not block commits and do not observe them.
+	txn := storage.NewTransactionOrDie(ctx, store)
+	defer store.Abort(ctx, txn)
+
+	if err := storage.WriteOne(ctx, store, storage.ReplaceOp, storage.MustParsePath("/a/b/0"), "x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.WriteOne(ctx, store, storage.AddOp, storage.MustParsePath("/a/e"), "y"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Txn(ctx, store, storage.WriteParams, func(txn storage.Transaction) error {
+		return store.DeletePolicy(ctx, txn, "p1")
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := store.Read(ctx, txn, storage.MustParsePath("/a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := util.MustUnmarshalJSON([]byte(`{"b": ["c", "d"]}`)); !reflect.DeepEqual(result, exp) {
+		t.Fatalf("Expected snapshot to be unchanged %v but got %v", exp, result)
+	}
+	if ids, err := store.ListPolicies(ctx, txn); err != nil || !reflect.DeepEqual(ids, []string{"p1"}) {
+		t.Fatalf("Expected snapshot policies to be unchanged but got %v, %v", ids, err)
+	}
+
+	result, err = storage.ReadOne(ctx, store, storage.MustParsePath("/a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := util.MustUnmarshalJSON([]byte(`{"b": ["x", "d"], "e": "y"}`)); !reflect.DeepEqual(result, exp) {
+		t.Fatalf("Expected %v but got %v", exp, result)
+	}
+}
+
+func TestConfigFromRaw(t *testing.T) {
+	c, err := ConfigFromRaw([]byte(`{}`), "id")
+	if err != nil || c != nil {
+		t.Fatalf("expected no config but got %v, %v", c, err)
+	}
+
+	c, err = ConfigFromRaw([]byte(`{"storage": {"inmem": {"snapshot_reads": true}}}`), "id")
+	if err != nil {
+		t.Fatal(err)
+	} else if c == nil || !c.SnapshotReads {
+		t.Fatalf("expected snapshot reads to be enabled but got %v", c)
+	}
+
+	if s := NewWithOpts(c.Opts()...).(*store); !s.snapshotReads {
+		t.Fatal("expected store with snapshot reads")
+	}
+}
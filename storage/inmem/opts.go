@@ -23,3 +23,20 @@ func OptRoundTripOnWrite(enabled bool) Opt {
 		s.roundTripOnWrite = enabled
 	}
 }
+
+// OptSnapshotReads sets whether read transactions operate on immutable
+// snapshots of the store.
+//
+// By default, read transactions hold a lock on the store that prevents write
+// transactions from committing until all reads have finished. If snapshot
+// reads are enabled, read transactions capture the data and policies present
+// when they are opened and do not block commits. Commits are applied by
+// copying the objects and arrays along the paths that are written instead of
+// modifying them in place, so the cost of a write grows with the size of the
+// objects and arrays that contain the written path. The triggers of a commit
+// run before transactions opened after the commit can read its changes.
+func OptSnapshotReads(enabled bool) Opt {
+	return func(s *store) {
+		s.snapshotReads = enabled
+	}
+}
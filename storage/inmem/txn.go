@@ -31,7 +31,9 @@ import (
 // - Otherwise, new update is added.
 //
 // Read transactions do not require any special handling and simply passthrough
-// to the underlying store. Read transactions do not support upgrade.
+// to the underlying store. If the store has snapshot reads enabled, read
+// transactions passthrough to the data and policies that were present when
+// they were opened instead. Read transactions do not support upgrade.
 type transaction struct {
 	xid      uint64
 	write    bool
@@ -40,6 +42,15 @@ type transaction struct {
 	updates  *list.List
 	policies map[string]policyUpdate
 	context  *storage.Context
+	snapshot *snapshot
+}
+
+// snapshot contains the data and policies of the store at the time a read
+// transaction was opened. Snapshots are never modified: commits on stores with
+// snapshot reads enabled replace the data and policies instead.
+type snapshot struct {
+	data     map[string]interface{}
+	policies map[string][]byte
 }
 
 type policyUpdate struct {
@@ -112,7 +123,11 @@ func (txn *transaction) Write(op storage.PatchOp, path storage.Path, value inter
 			if err != nil {
 				return err
 			}
-			update.value = newUpdate.Apply(update.value)
+			if txn.db.snapshotReads {
+				update.value = newUpdate.ApplyCopyOnWrite(update.value)
+			} else {
+				update.value = newUpdate.Apply(update.value)
+			}
 			return nil
 		}
 
@@ -146,15 +161,39 @@ func (txn *transaction) updateRoot(op storage.PatchOp, value interface{}) error
 
 func (txn *transaction) Commit() (result storage.TriggerEvent) {
 	result.Context = txn.context
+	if txn.db.snapshotReads && len(txn.policies) > 0 {
+		policies := make(map[string][]byte, len(txn.db.policies))
+		for id, bs := range txn.db.policies {
+			policies[id] = bs
+		}
+		txn.db.policies = policies
+	}
 	for curr := txn.updates.Front(); curr != nil; curr = curr.Next() {
 		action := curr.Value.(*update)
-		updated := action.Apply(txn.db.data)
+
+		// Updates replace or remove the whole document at their path, which is
+		// added if it did not exist.
+		var op storage.PatchOp = storage.RemoveOp
+		if !action.remove {
+			op = storage.ReplaceOp
+			if _, err := ptr.Ptr(txn.db.data, action.path); err != nil {
+				op = storage.AddOp
+			}
+		}
+
+		var updated interface{}
+		if txn.db.snapshotReads {
+			updated = action.ApplyCopyOnWrite(txn.db.data)
+		} else {
+			updated = action.Apply(txn.db.data)
+		}
 		txn.db.data = updated.(map[string]interface{})
 
 		result.Data = append(result.Data, storage.DataEvent{
 			Path:    action.path,
 			Data:    action.value,
 			Removed: action.remove,
+			Op:      op,
 		})
 	}
 	for id, update := range txn.policies {
@@ -176,6 +215,9 @@ func (txn *transaction) Commit() (result storage.TriggerEvent) {
 func (txn *transaction) Read(path storage.Path) (interface{}, error) {
 
 	if !txn.write {
+		if txn.snapshot != nil {
+			return ptr.Ptr(txn.snapshot.data, path)
+		}
 		return ptr.Ptr(txn.db.data, path)
 	}
 
@@ -216,9 +258,17 @@ func (txn *transaction) Read(path storage.Path) (interface{}, error) {
 	return cpy, nil
 }
 
+// basePolicies returns the committed policies visible to the transaction.
+func (txn *transaction) basePolicies() map[string][]byte {
+	if txn.snapshot != nil {
+		return txn.snapshot.policies
+	}
+	return txn.db.policies
+}
+
 func (txn *transaction) ListPolicies() []string {
 	var ids []string
-	for id := range txn.db.policies {
+	for id := range txn.basePolicies() {
 		if _, ok := txn.policies[id]; !ok {
 			ids = append(ids, id)
 		}
@@ -238,7 +288,7 @@ func (txn *transaction) GetPolicy(id string) ([]byte, error) {
 		}
 		return nil, errors.NewNotFoundErrorf("policy id %q", id)
 	}
-	if exist, ok := txn.db.policies[id]; ok {
+	if exist, ok := txn.basePolicies()[id]; ok {
 		return exist, nil
 	}
 	return nil, errors.NewNotFoundErrorf("policy id %q", id)
@@ -389,6 +439,43 @@ func (u *update) Apply(data interface{}) interface{} {
 	return data
 }
 
+// ApplyCopyOnWrite is like Apply except that data is not modified: the objects
+// and arrays along the path of the update are copied and the copy of data is
+// returned.
+func (u *update) ApplyCopyOnWrite(data interface{}) interface{} {
+	return applyCopyOnWrite(data, u.path, u)
+}
+
+func applyCopyOnWrite(data interface{}, path storage.Path, u *update) interface{} {
+	if len(path) == 0 {
+		return u.value
+	}
+	key := path[0]
+	switch data := data.(type) {
+	case map[string]interface{}:
+		cpy := make(map[string]interface{}, len(data)+1)
+		for k, v := range data {
+			cpy[k] = v
+		}
+		if len(path) == 1 && u.remove {
+			delete(cpy, key)
+		} else {
+			cpy[key] = applyCopyOnWrite(data[key], path[1:], u)
+		}
+		return cpy
+	case []interface{}:
+		idx, err := strconv.Atoi(key)
+		if err != nil {
+			panic(err)
+		}
+		cpy := make([]interface{}, len(data))
+		copy(cpy, data)
+		cpy[idx] = applyCopyOnWrite(data[idx], path[1:], u)
+		return cpy
+	}
+	panic(errors.NewNotFoundError(u.path))
+}
+
 func (u *update) Relative(path storage.Path) *update {
 	cpy := *u
 	cpy.path = cpy.path[len(path):]
@@ -6,6 +6,8 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
+	"strings"
 
 	"github.com/open-policy-agent/opa/metrics"
 )
@@ -49,6 +51,22 @@ type MakeDirer interface {
 	MakeDir(context.Context, Transaction, Path) error
 }
 
+// BatchReader defines the interface a Store could realize to read multiple
+// documents in a single operation. Stores backed by remote or disk storage
+// can implement it to reduce the number of round trips performed when many
+// documents are read at once (see storage.ReadBatch.)
+type BatchReader interface {
+	ReadBatch(context.Context, Transaction, []Path) ([]BatchReadResult, error)
+}
+
+// BatchReadResult describes the outcome of reading one of the paths passed to
+// BatchReader.ReadBatch. If the document does not exist, Err is a NotFound
+// error.
+type BatchReadResult struct {
+	Value interface{}
+	Err   error
+}
+
 // TransactionParams describes a new transaction.
 type TransactionParams struct {
 
@@ -178,6 +196,55 @@ type DataEvent struct {
 	Path    Path
 	Data    interface{}
 	Removed bool
+	Op      PatchOp // operation applied at Path, see Patch
+}
+
+// Patch returns the change as a JSON Patch operation, see
+// https://datatracker.ietf.org/doc/html/rfc6902.
+func (e DataEvent) Patch() PatchOperation {
+	op := PatchOperation{Path: jsonPointer(e.Path), Value: e.Data}
+	switch {
+	case e.Removed || e.Op == RemoveOp:
+		op.Op = "remove"
+		op.Value = nil
+	case e.Op == ReplaceOp:
+		op.Op = "replace"
+	default:
+		op.Op = "add"
+	}
+	return op
+}
+
+// PatchOperation is a JSON Patch operation.
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// MarshalJSON omits the value of remove operations.
+func (op PatchOperation) MarshalJSON() ([]byte, error) {
+	if op.Op == "remove" {
+		return json.Marshal(struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		}{op.Op, op.Path})
+	}
+	type patchOperation PatchOperation
+	return json.Marshal(patchOperation(op))
+}
+
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// jsonPointer returns path as a JSON Pointer, see
+// https://datatracker.ietf.org/doc/html/rfc6901.
+func jsonPointer(path Path) string {
+	var sb strings.Builder
+	for _, p := range path {
+		sb.WriteByte('/')
+		sb.WriteString(jsonPointerEscaper.Replace(p))
+	}
+	return sb.String()
 }
 
 // TriggerEvent describes the changes that caused the trigger to be invoked.
@@ -203,6 +270,16 @@ func (e TriggerEvent) DataChanged() bool {
 	return len(e.Data) > 0
 }
 
+// Patch returns the data changes as a JSON Patch, in the order they were
+// applied.
+func (e TriggerEvent) Patch() []PatchOperation {
+	patch := make([]PatchOperation, len(e.Data))
+	for i := range e.Data {
+		patch[i] = e.Data[i].Patch()
+	}
+	return patch
+}
+
 // TriggerConfig contains the trigger registration configuration.
 type TriggerConfig struct {
 
@@ -210,6 +287,32 @@ type TriggerConfig struct {
 	// callback is invoked with a handle to the write transaction that
 	// successfully committed before other clients see the changes.
 	OnCommit func(context.Context, Transaction, TriggerEvent)
+
+	// Paths restricts the data changes OnCommit is invoked with to the changes
+	// at, below or above any of the paths, if not empty. OnCommit is not
+	// invoked for transactions that change neither such data nor policies.
+	Paths []Path
+}
+
+// Filter returns the changes of event that the trigger is registered for, and
+// false if OnCommit must not be invoked because there are none.
+func (c TriggerConfig) Filter(event TriggerEvent) (TriggerEvent, bool) {
+	if len(c.Paths) == 0 {
+		return event, true
+	}
+
+	filtered := event
+	filtered.Data = nil
+	for _, e := range event.Data {
+		for _, p := range c.Paths {
+			if e.Path.HasPrefix(p) || p.HasPrefix(e.Path) {
+				filtered.Data = append(filtered.Data, e)
+				break
+			}
+		}
+	}
+
+	return filtered, !filtered.IsZero()
 }
 
 // Trigger defines the interface that stores implement to register for change
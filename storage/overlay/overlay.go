@@ -0,0 +1,591 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package overlay provides an implementation of the storage.Store interface
+// that layers a writable in-memory store over read-only base stores.
+//
+// Reads are resolved through the chain of stores: the documents written
+// through the overlay take precedence over the documents of the bases, and the
+// documents of a base over those of the bases after it. Objects found at the
+// same path in several stores are merged; for any other value, the first store
+// in the chain with a value at the path takes precedence.
+//
+// Writes, and changes to policies, only ever modify the overlay. This allows
+// evaluating policies against hypothetical changes to the data of a store
+// without modifying it. A write through an array of the bases copies the array
+// into the overlay first, since the documents of the overlay and of the bases
+// are only merged in objects. The bases are not expected to change while the
+// overlay is used.
+package overlay
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/open-policy-agent/opa/storage/internal/errors"
+)
+
+// Store layers a writable in-memory store over read-only base stores.
+type Store struct {
+	top        storage.Store   // documents and policies written through the overlay
+	bases      []storage.Store // read-only stores, in order of precedence
+	xid        uint64          // last generated transaction id
+	mtx        sync.RWMutex    // guards state against commits
+	state      *state          // committed state
+	committing *transaction    // write transaction being committed, passed to triggers
+}
+
+// state describes the documents and policies of the bases that the overlay
+// masks. A state is never modified once it is committed.
+type state struct {
+	masked    []storage.Path      // paths where the overlay replaces the documents of the bases, none below another
+	removed   map[string]struct{} // policies of the bases removed through the overlay
+	truncated bool                // whether the policies of the bases are all removed
+}
+
+// New returns a store that layers a new in-memory store over bases.
+func New(bases ...storage.Store) *Store {
+	return &Store{
+		top:   inmem.New(),
+		bases: bases,
+		state: &state{},
+	}
+}
+
+type transaction struct {
+	xid    uint64
+	write  bool
+	stale  bool
+	top    storage.Transaction
+	bases  []storage.Transaction
+	state  *state
+	copied bool // whether state is a copy owned by the transaction
+}
+
+func (txn *transaction) ID() uint64 {
+	return txn.xid
+}
+
+// mutableState returns the state of txn, which is copied from the committed
+// state on the first change.
+func (txn *transaction) mutableState() *state {
+	if !txn.copied {
+		cpy := &state{
+			masked:    append([]storage.Path(nil), txn.state.masked...),
+			removed:   make(map[string]struct{}, len(txn.state.removed)),
+			truncated: txn.state.truncated,
+		}
+		for id := range txn.state.removed {
+			cpy.removed[id] = struct{}{}
+		}
+		txn.state = cpy
+		txn.copied = true
+	}
+	return txn.state
+}
+
+// NewTransaction implements the storage.Store interface.
+func (s *Store) NewTransaction(ctx context.Context, params ...storage.TransactionParams) (storage.Transaction, error) {
+	var write bool
+	if len(params) > 0 {
+		write = params[0].Write
+	}
+
+	// Write transactions are opened on the overlay before the state is read,
+	// so that they wait for the write transaction being committed, if any.
+	// Read transactions are opened while the state is read, so that they
+	// observe the state that matches the documents of the overlay.
+	var top storage.Transaction
+	var err error
+	if write {
+		if top, err = s.top.NewTransaction(ctx, params...); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mtx.RLock()
+	if !write {
+		if top, err = s.top.NewTransaction(ctx, params...); err != nil {
+			s.mtx.RUnlock()
+			return nil, err
+		}
+	}
+	txn := &transaction{
+		xid:   atomic.AddUint64(&s.xid, 1),
+		write: write,
+		top:   top,
+		state: s.state,
+	}
+	s.mtx.RUnlock()
+
+	for _, base := range s.bases {
+		btxn, err := base.NewTransaction(ctx)
+		if err != nil {
+			s.abort(ctx, txn)
+			return nil, err
+		}
+		txn.bases = append(txn.bases, btxn)
+	}
+
+	return txn, nil
+}
+
+// Commit implements the storage.Store interface.
+func (s *Store) Commit(ctx context.Context, txn storage.Transaction) error {
+	underlying, err := s.underlying(txn)
+	if err != nil {
+		return err
+	}
+	if !underlying.write {
+		s.abortBases(ctx, underlying)
+		underlying.stale = true
+		return s.top.Commit(ctx, underlying.top)
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	// Triggers read through the transaction being committed, so the
+	// transactions on the bases are only closed once they have run.
+	s.committing = underlying
+	err = s.top.Commit(ctx, underlying.top)
+	s.committing = nil
+	s.abortBases(ctx, underlying)
+	underlying.stale = true
+	if err != nil {
+		return err
+	}
+	s.state = underlying.state
+	return nil
+}
+
+// Abort implements the storage.Store interface.
+func (s *Store) Abort(ctx context.Context, txn storage.Transaction) {
+	underlying, err := s.underlying(txn)
+	if err != nil {
+		panic(err)
+	}
+	s.abort(ctx, underlying)
+}
+
+func (s *Store) abort(ctx context.Context, txn *transaction) {
+	txn.stale = true
+	s.abortBases(ctx, txn)
+	s.top.Abort(ctx, txn.top)
+}
+
+func (s *Store) abortBases(ctx context.Context, txn *transaction) {
+	for i, btxn := range txn.bases {
+		s.bases[i].Abort(ctx, btxn)
+	}
+	txn.bases = nil
+}
+
+// Truncate implements the storage.Store interface. The documents and policies
+// of the bases are all masked by the ones written to the overlay.
+func (s *Store) Truncate(ctx context.Context, txn storage.Transaction, params storage.TransactionParams, it storage.Iterator) error {
+	underlying, err := s.writable(txn)
+	if err != nil {
+		return err
+	}
+	if err := s.top.Truncate(ctx, underlying.top, params, it); err != nil {
+		return err
+	}
+	st := underlying.mutableState()
+	st.masked = []storage.Path{{}}
+	st.removed = map[string]struct{}{}
+	st.truncated = true
+	return nil
+}
+
+// Read implements the storage.Store interface.
+func (s *Store) Read(ctx context.Context, txn storage.Transaction, path storage.Path) (interface{}, error) {
+	underlying, err := s.underlying(txn)
+	if err != nil {
+		return nil, err
+	}
+	if underlying.maskedAt(path) {
+		return s.top.Read(ctx, underlying.top, path)
+	}
+
+	var result interface{}
+	found := false
+
+	for i, base := range s.bases {
+		value, err := base.Read(ctx, underlying.bases[i], path)
+		if err != nil {
+			if storage.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if !found {
+			result, found = value, true
+			if _, ok := value.(map[string]interface{}); !ok {
+				break
+			}
+		} else if obj, ok := value.(map[string]interface{}); ok {
+			result = mergeObjects(result.(map[string]interface{}), obj)
+		}
+	}
+
+	if masked := underlying.maskedBelow(path); len(masked) > 0 {
+		return s.mask(ctx, underlying, path, result, masked)
+	}
+
+	if !found {
+		if len(path) == 0 {
+			return map[string]interface{}{}, nil
+		}
+		return nil, errors.NewNotFoundError(path)
+	}
+	return result, nil
+}
+
+// mask returns a copy of the object x at path, with the documents of the
+// overlay at the masked paths below path.
+func (s *Store) mask(ctx context.Context, txn *transaction, path storage.Path, x interface{}, masked []storage.Path) (interface{}, error) {
+	obj, _ := x.(map[string]interface{})
+	cpy := make(map[string]interface{}, len(obj)+len(masked))
+	for k, v := range obj {
+		cpy[k] = v
+	}
+
+	children := map[string][]storage.Path{}
+	for _, p := range masked {
+		children[p[len(path)]] = append(children[p[len(path)]], p)
+	}
+
+	for k, masked := range children {
+		child := append(path[:len(path):len(path)], k)
+
+		// Masked paths are never below each other, so a masked path at child is
+		// the only one in the subtree.
+		if len(masked[0]) > len(child) {
+			value, err := s.mask(ctx, txn, child, cpy[k], masked)
+			if err != nil {
+				return nil, err
+			}
+			cpy[k] = value
+			continue
+		}
+
+		value, err := s.top.Read(ctx, txn.top, child)
+		if err != nil {
+			if !storage.IsNotFound(err) {
+				return nil, err
+			}
+			delete(cpy, k)
+		} else {
+			cpy[k] = value
+		}
+	}
+
+	return cpy, nil
+}
+
+// mergeObjects returns the merge of a and b, where the values of a take
+// precedence over the values of b, other than objects which are merged.
+func mergeObjects(a, b map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(a)+len(b))
+	for k, v := range b {
+		merged[k] = v
+	}
+	for k, v := range a {
+		objA, okA := v.(map[string]interface{})
+		objB, okB := merged[k].(map[string]interface{})
+		if okA && okB {
+			merged[k] = mergeObjects(objA, objB)
+		} else {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// Write implements the storage.Store interface.
+func (s *Store) Write(ctx context.Context, txn storage.Transaction, op storage.PatchOp, path storage.Path, value interface{}) error {
+	underlying, err := s.writable(txn)
+	if err != nil {
+		return err
+	}
+	if underlying.maskedAt(path) {
+		return s.top.Write(ctx, underlying.top, op, path, value)
+	}
+
+	// Arrays are copied into the overlay, and the write is applied to the copy.
+	for i := 1; i < len(path); i++ {
+		if !isArrayIndex(path[i]) || len(underlying.maskedBelow(path[:i])) > 0 {
+			continue
+		}
+		x, found, err := s.readBase(ctx, underlying, path[:i])
+		if err != nil {
+			return err
+		}
+		if _, ok := x.([]interface{}); ok && found {
+			if err := s.maskPath(ctx, underlying, path[:i], x, false); err != nil {
+				return err
+			}
+			return s.top.Write(ctx, underlying.top, op, path, value)
+		}
+	}
+
+	if len(path) > 0 {
+		parent := path[:len(path)-1]
+		if len(parent) > 0 && len(underlying.maskedBelow(parent)) == 0 {
+			x, _, err := s.readBase(ctx, underlying, parent)
+			if err != nil {
+				return err
+			}
+			if _, ok := x.(map[string]interface{}); !ok {
+				return errors.NewNotFoundError(path)
+			}
+		}
+
+		if op != storage.AddOp && len(underlying.maskedBelow(path)) == 0 {
+			if _, found, err := s.readBase(ctx, underlying, path); err != nil {
+				return err
+			} else if !found {
+				return errors.NewNotFoundError(path)
+			}
+		}
+	}
+
+	return s.maskPath(ctx, underlying, path, value, op == storage.RemoveOp)
+}
+
+// readBase returns the value at path in the first base that has one.
+func (s *Store) readBase(ctx context.Context, txn *transaction, path storage.Path) (interface{}, bool, error) {
+	for i, base := range s.bases {
+		value, err := base.Read(ctx, txn.bases[i], path)
+		if err != nil {
+			if storage.IsNotFound(err) {
+				continue
+			}
+			return nil, false, err
+		}
+		return value, true, nil
+	}
+	return nil, false, nil
+}
+
+// maskPath replaces the documents of the bases at path by value in the overlay,
+// or removes them.
+func (s *Store) maskPath(ctx context.Context, txn *transaction, path storage.Path, value interface{}, remove bool) error {
+	if len(path) == 0 {
+		if err := s.top.Write(ctx, txn.top, storage.ReplaceOp, path, value); err != nil {
+			return err
+		}
+	} else {
+		if err := storage.MakeDir(ctx, s.top, txn.top, path[:len(path)-1]); err != nil {
+			return err
+		}
+
+		// The overlay may hold the documents masked below path already.
+		if _, err := s.top.Read(ctx, txn.top, path); err == nil && remove {
+			if err := s.top.Write(ctx, txn.top, storage.RemoveOp, path, nil); err != nil {
+				return err
+			}
+		} else if err != nil && !storage.IsNotFound(err) {
+			return err
+		}
+
+		if !remove {
+			if err := s.top.Write(ctx, txn.top, storage.AddOp, path, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	st := txn.mutableState()
+	masked := st.masked[:0]
+	for _, p := range st.masked {
+		if !p.HasPrefix(path) {
+			masked = append(masked, p)
+		}
+	}
+	st.masked = append(masked, path)
+	return nil
+}
+
+// maskedAt returns true if path is at or below a masked path.
+func (txn *transaction) maskedAt(path storage.Path) bool {
+	for _, p := range txn.state.masked {
+		if path.HasPrefix(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// maskedBelow returns the masked paths below path.
+func (txn *transaction) maskedBelow(path storage.Path) []storage.Path {
+	var masked []storage.Path
+	for _, p := range txn.state.masked {
+		if len(p) > len(path) && p.HasPrefix(path) {
+			masked = append(masked, p)
+		}
+	}
+	return masked
+}
+
+func isArrayIndex(s string) bool {
+	if s == "-" {
+		return true
+	}
+	_, err := strconv.ParseUint(s, 10, 64)
+	return err == nil
+}
+
+// ListPolicies implements the storage.Policy interface.
+func (s *Store) ListPolicies(ctx context.Context, txn storage.Transaction) ([]string, error) {
+	underlying, err := s.underlying(txn)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := s.top.ListPolicies(ctx, underlying.top)
+	if err != nil {
+		return nil, err
+	}
+
+	if !underlying.state.truncated {
+		seen := make(map[string]struct{}, len(ids))
+		for _, id := range ids {
+			seen[id] = struct{}{}
+		}
+		for i, base := range s.bases {
+			baseIDs, err := base.ListPolicies(ctx, underlying.bases[i])
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range baseIDs {
+				if _, ok := seen[id]; ok {
+					continue
+				}
+				if _, ok := underlying.state.removed[id]; ok {
+					continue
+				}
+				seen[id] = struct{}{}
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// GetPolicy implements the storage.Policy interface.
+func (s *Store) GetPolicy(ctx context.Context, txn storage.Transaction, id string) ([]byte, error) {
+	underlying, err := s.underlying(txn)
+	if err != nil {
+		return nil, err
+	}
+
+	bs, err := s.top.GetPolicy(ctx, underlying.top, id)
+	if err == nil || !storage.IsNotFound(err) {
+		return bs, err
+	}
+
+	return s.getBasePolicy(ctx, underlying, id)
+}
+
+func (s *Store) getBasePolicy(ctx context.Context, txn *transaction, id string) ([]byte, error) {
+	if _, ok := txn.state.removed[id]; !ok && !txn.state.truncated {
+		for i, base := range s.bases {
+			bs, err := base.GetPolicy(ctx, txn.bases[i], id)
+			if err == nil || !storage.IsNotFound(err) {
+				return bs, err
+			}
+		}
+	}
+	return nil, errors.NewNotFoundErrorf("policy id %q", id)
+}
+
+// UpsertPolicy implements the storage.Policy interface.
+func (s *Store) UpsertPolicy(ctx context.Context, txn storage.Transaction, id string, bs []byte) error {
+	underlying, err := s.writable(txn)
+	if err != nil {
+		return err
+	}
+	return s.top.UpsertPolicy(ctx, underlying.top, id, bs)
+}
+
+// DeletePolicy implements the storage.Policy interface. Policies of the bases
+// are removed from the overlay, not from the bases.
+func (s *Store) DeletePolicy(ctx context.Context, txn storage.Transaction, id string) error {
+	underlying, err := s.writable(txn)
+	if err != nil {
+		return err
+	}
+
+	_, topErr := s.top.GetPolicy(ctx, underlying.top, id)
+	if topErr != nil && !storage.IsNotFound(topErr) {
+		return topErr
+	}
+
+	_, baseErr := s.getBasePolicy(ctx, underlying, id)
+	if baseErr != nil && !storage.IsNotFound(baseErr) {
+		return baseErr
+	}
+
+	if topErr != nil && baseErr != nil {
+		return baseErr
+	}
+
+	if topErr == nil {
+		if err := s.top.DeletePolicy(ctx, underlying.top, id); err != nil {
+			return err
+		}
+	}
+	if baseErr == nil {
+		underlying.mutableState().removed[id] = struct{}{}
+	}
+	return nil
+}
+
+// Register implements the storage.Trigger interface. Triggers are invoked with
+// the transaction being committed on the overlay.
+func (s *Store) Register(ctx context.Context, txn storage.Transaction, config storage.TriggerConfig) (storage.TriggerHandle, error) {
+	underlying, err := s.underlying(txn)
+	if err != nil {
+		return nil, err
+	}
+	onCommit := config.OnCommit
+	config.OnCommit = func(ctx context.Context, _ storage.Transaction, event storage.TriggerEvent) {
+		onCommit(ctx, s.committing, event)
+	}
+	return s.top.Register(ctx, underlying.top, config)
+}
+
+func (s *Store) underlying(txn storage.Transaction) (*transaction, error) {
+	underlying, ok := txn.(*transaction)
+	if !ok || underlying.stale {
+		return nil, &storage.Error{
+			Code:    storage.InvalidTransactionErr,
+			Message: "stale transaction",
+		}
+	}
+	return underlying, nil
+}
+
+func (s *Store) writable(txn storage.Transaction) (*transaction, error) {
+	underlying, err := s.underlying(txn)
+	if err != nil {
+		return nil, err
+	}
+	if !underlying.write {
+		return nil, &storage.Error{
+			Code:    storage.InvalidTransactionErr,
+			Message: "write during read transaction",
+		}
+	}
+	return underlying, nil
+}
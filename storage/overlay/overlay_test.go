@@ -0,0 +1,376 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package overlay
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/open-policy-agent/opa/bundle"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/open-policy-agent/opa/util"
+)
+
+const (
+	testBase1 = `{
+		"a": {"b": 1, "c": {"d": 2}},
+		"arr": [1, 2, 3],
+		"s": "base1"
+	}`
+	testBase2 = `{
+		"a": {"b": 10, "c": {"e": 3}, "f": 4},
+		"s": "base2",
+		"t": "base2"
+	}`
+)
+
+func newTestBase(data string) storage.Store {
+	return inmem.NewFromObject(util.MustUnmarshalJSON([]byte(data)).(map[string]interface{}))
+}
+
+func TestOverlayRead(t *testing.T) {
+	store := New(newTestBase(testBase1), newTestBase(testBase2))
+	ctx := context.Background()
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/", `{"a": {"b": 1, "c": {"d": 2, "e": 3}, "f": 4}, "arr": [1, 2, 3], "s": "base1", "t": "base2"}`},
+		{"/a", `{"b": 1, "c": {"d": 2, "e": 3}, "f": 4}`},
+		{"/a/c", `{"d": 2, "e": 3}`},
+		{"/a/b", `1`},
+		{"/a/f", `4`},
+		{"/arr/1", `2`},
+		{"/t", `"base2"`},
+		{"/x", ``},
+		{"/a/c/x", ``},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.path, func(t *testing.T) {
+			result, err := storage.ReadOne(ctx, store, storage.MustParsePath(tc.path))
+			if tc.expected == "" {
+				if !storage.IsNotFound(err) {
+					t.Fatalf("Expected not found error but got %v, %v", result, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(util.MustUnmarshalJSON([]byte(tc.expected)), result) {
+				t.Fatalf("Expected %v but got %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestOverlayReadEmpty(t *testing.T) {
+	store := New()
+	ctx := context.Background()
+
+	result, err := storage.ReadOne(ctx, store, storage.Path{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, map[string]interface{}{}) {
+		t.Fatalf("Expected empty object but got %v", result)
+	}
+}
+
+func TestOverlayWrite(t *testing.T) {
+	tests := []struct {
+		note     string
+		op       storage.PatchOp
+		path     string
+		value    string
+		readPath string
+		expected string
+		err      bool
+	}{
+		{"add to base object", storage.AddOp, "/a/g", `5`, "/a", `{"b": 1, "c": {"d": 2, "e": 3}, "f": 4, "g": 5}`, false},
+		{"replace base value", storage.ReplaceOp, "/a/b", `5`, "/a", `{"b": 5, "c": {"d": 2, "e": 3}, "f": 4}`, false},
+		{"replace masked base value", storage.ReplaceOp, "/s", `"x"`, "/s", `"x"`, false},
+		{"replace base object", storage.AddOp, "/a/c", `{"x": 1}`, "/a", `{"b": 1, "c": {"x": 1}, "f": 4}`, false},
+		{"remove base value", storage.RemoveOp, "/a/f", ``, "/a", `{"b": 1, "c": {"d": 2, "e": 3}}`, false},
+		{"remove merged object", storage.RemoveOp, "/a/c", ``, "/a", `{"b": 1, "f": 4}`, false},
+		{"add new root key", storage.AddOp, "/x", `{"y": 1}`, "/x", `{"y": 1}`, false},
+		{"append to base array", storage.AddOp, "/arr/-", `4`, "/arr", `[1, 2, 3, 4]`, false},
+		{"replace in base array", storage.ReplaceOp, "/arr/0", `0`, "/arr", `[0, 2, 3]`, false},
+		{"remove from base array", storage.RemoveOp, "/arr/1", ``, "/arr", `[1, 3]`, false},
+		{"replace root", storage.AddOp, "/", `{"x": 1}`, "/", `{"x": 1}`, false},
+		{"add to missing parent", storage.AddOp, "/x/y", `1`, "", "", true},
+		{"add to scalar", storage.AddOp, "/s/y", `1`, "", "", true},
+		{"replace missing", storage.ReplaceOp, "/a/x", `1`, "", "", true},
+		{"remove missing", storage.RemoveOp, "/a/x", ``, "", "", true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			base1, base2 := newTestBase(testBase1), newTestBase(testBase2)
+			store := New(base1, base2)
+			ctx := context.Background()
+
+			var value interface{}
+			if tc.value != "" {
+				value = util.MustUnmarshalJSON([]byte(tc.value))
+			}
+
+			err := storage.WriteOne(ctx, store, tc.op, storage.MustParsePath(tc.path), value)
+			if tc.err {
+				if !storage.IsNotFound(err) {
+					t.Fatalf("Expected not found error but got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := storage.ReadOne(ctx, store, storage.MustParsePath(tc.readPath))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(util.MustUnmarshalJSON([]byte(tc.expected)), result) {
+				t.Fatalf("Expected %v but got %v", tc.expected, result)
+			}
+
+			for _, b := range []struct {
+				store storage.Store
+				data  string
+			}{{base1, testBase1}, {base2, testBase2}} {
+				result, err := storage.ReadOne(ctx, b.store, storage.Path{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(util.MustUnmarshalJSON([]byte(b.data)), result) {
+					t.Fatalf("Expected base to be unchanged but got %v", result)
+				}
+			}
+		})
+	}
+}
+
+func TestOverlayWriteMasked(t *testing.T) {
+	store := New(newTestBase(testBase1), newTestBase(testBase2))
+	ctx := context.Background()
+
+	writes := []struct {
+		op    storage.PatchOp
+		path  string
+		value string
+	}{
+		{storage.AddOp, "/a/c/x", `1`},
+		{storage.RemoveOp, "/a/c/d", ``},
+		{storage.AddOp, "/a/c/y", `{"z": 2}`},
+		{storage.AddOp, "/a/c/y/w", `3`},
+		{storage.RemoveOp, "/t", ``},
+	}
+
+	for _, w := range writes {
+		var value interface{}
+		if w.value != "" {
+			value = util.MustUnmarshalJSON([]byte(w.value))
+		}
+		if err := storage.WriteOne(ctx, store, w.op, storage.MustParsePath(w.path), value); err != nil {
+			t.Fatalf("%v %v: %v", w.op, w.path, err)
+		}
+	}
+
+	result, err := storage.ReadOne(ctx, store, storage.Path{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := util.MustUnmarshalJSON([]byte(`{
+		"a": {"b": 1, "c": {"e": 3, "x": 1, "y": {"z": 2, "w": 3}}, "f": 4},
+		"arr": [1, 2, 3],
+		"s": "base1"
+	}`))
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("Expected %v but got %v", expected, result)
+	}
+
+	// Adding back a removed document does not restore the documents of the bases.
+	if err := storage.WriteOne(ctx, store, storage.AddOp, storage.MustParsePath("/a/c/d"), map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.ReadOne(ctx, store, storage.MustParsePath("/t")); !storage.IsNotFound(err) {
+		t.Fatalf("Expected not found error but got %v", err)
+	}
+}
+
+func TestOverlayAbort(t *testing.T) {
+	store := New(newTestBase(testBase1))
+	ctx := context.Background()
+
+	txn := storage.NewTransactionOrDie(ctx, store, storage.WriteParams)
+	if err := store.Write(ctx, txn, storage.RemoveOp, storage.MustParsePath("/a"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Read(ctx, txn, storage.MustParsePath("/a")); !storage.IsNotFound(err) {
+		t.Fatalf("Expected not found error but got %v", err)
+	}
+	store.Abort(ctx, txn)
+
+	result, err := storage.ReadOne(ctx, store, storage.MustParsePath("/a/b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(util.MustUnmarshalJSON([]byte(`1`)), result) {
+		t.Fatalf("Expected 1 but got %v", result)
+	}
+}
+
+func TestOverlayTxnReadFailures(t *testing.T) {
+	store := New(newTestBase(testBase1))
+	ctx := context.Background()
+
+	txn := storage.NewTransactionOrDie(ctx, store)
+	err := store.Write(ctx, txn, storage.AddOp, storage.MustParsePath("/x"), nil)
+	if !storage.IsInvalidTransaction(err) {
+		t.Fatalf("Expected invalid transaction error but got %v", err)
+	}
+	if err := store.Commit(ctx, txn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Read(ctx, txn, storage.Path{}); !storage.IsInvalidTransaction(err) {
+		t.Fatalf("Expected invalid transaction error but got %v", err)
+	}
+}
+
+func TestOverlayPolicies(t *testing.T) {
+	ctx := context.Background()
+	base := newTestBase(`{}`)
+
+	txn := storage.NewTransactionOrDie(ctx, base, storage.WriteParams)
+	for _, id := range []string{"p1", "p2"} {
+		if err := base.UpsertPolicy(ctx, txn, id, []byte("package "+id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := base.Commit(ctx, txn); err != nil {
+		t.Fatal(err)
+	}
+
+	store := New(base)
+
+	txn = storage.NewTransactionOrDie(ctx, store, storage.WriteParams)
+	if err := store.UpsertPolicy(ctx, txn, "p1", []byte("package overlay")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UpsertPolicy(ctx, txn, "p3", []byte("package p3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeletePolicy(ctx, txn, "p2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeletePolicy(ctx, txn, "p2"); !storage.IsNotFound(err) {
+		t.Fatalf("Expected not found error but got %v", err)
+	}
+	if err := store.Commit(ctx, txn); err != nil {
+		t.Fatal(err)
+	}
+
+	txn = storage.NewTransactionOrDie(ctx, store)
+	defer store.Abort(ctx, txn)
+
+	ids, err := store.ListPolicies(ctx, txn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(ids)
+	if !reflect.DeepEqual(ids, []string{"p1", "p3"}) {
+		t.Fatalf("Expected [p1 p3] but got %v", ids)
+	}
+
+	bs, err := store.GetPolicy(ctx, txn, "p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != "package overlay" {
+		t.Fatalf("Expected policy from overlay but got %q", bs)
+	}
+
+	if _, err := store.GetPolicy(ctx, txn, "p2"); !storage.IsNotFound(err) {
+		t.Fatalf("Expected not found error but got %v", err)
+	}
+
+	btxn := storage.NewTransactionOrDie(ctx, base)
+	defer base.Abort(ctx, btxn)
+
+	ids, err = base.ListPolicies(ctx, btxn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(ids)
+	if !reflect.DeepEqual(ids, []string{"p1", "p2"}) {
+		t.Fatalf("Expected base policies to be unchanged but got %v", ids)
+	}
+}
+
+func TestOverlayTriggers(t *testing.T) {
+	store := New(newTestBase(testBase1))
+	ctx := context.Background()
+
+	var events []storage.TriggerEvent
+	var reads []interface{}
+
+	txn := storage.NewTransactionOrDie(ctx, store, storage.WriteParams)
+	_, err := store.Register(ctx, txn, storage.TriggerConfig{
+		OnCommit: func(ctx context.Context, txn storage.Transaction, event storage.TriggerEvent) {
+			events = append(events, event)
+			result, err := store.Read(ctx, txn, storage.MustParsePath("/a"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			reads = append(reads, result)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Commit(ctx, txn); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.WriteOne(ctx, store, storage.AddOp, storage.MustParsePath("/a/x"), "y"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 2 || !events[1].DataChanged() {
+		t.Fatalf("Expected data change event but got %v", events)
+	}
+
+	expected := util.MustUnmarshalJSON([]byte(`{"b": 1, "c": {"d": 2}, "x": "y"}`))
+	if !reflect.DeepEqual(reads[1], expected) {
+		t.Fatalf("Expected %v but got %v", expected, reads[1])
+	}
+}
+
+func TestOverlayTruncate(t *testing.T) {
+	store := New(newTestBase(testBase1))
+	ctx := context.Background()
+
+	txn := storage.NewTransactionOrDie(ctx, store, storage.WriteParams)
+	if err := store.Truncate(ctx, txn, storage.WriteParams, bundle.NewIterator(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Commit(ctx, txn); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := storage.ReadOne(ctx, store, storage.Path{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, map[string]interface{}{}) {
+		t.Fatalf("Expected empty object but got %v", result)
+	}
+}
@@ -49,6 +49,25 @@ func WriteOne(ctx context.Context, store Store, op PatchOp, path Path, value int
 	return store.Commit(ctx, txn)
 }
 
+// ReadBatch reads the documents referred to by paths. The results are returned
+// in the same order as paths. If the Store implements the BatchReader interface
+// the documents are read in a single operation, otherwise they are read one by
+// one.
+func ReadBatch(ctx context.Context, store Store, txn Transaction, paths []Path) ([]BatchReadResult, error) {
+	if br, ok := store.(BatchReader); ok {
+		return br.ReadBatch(ctx, txn, paths)
+	}
+
+	results := make([]BatchReadResult, len(paths))
+	for i := range paths {
+		results[i].Value, results[i].Err = store.Read(ctx, txn, paths[i])
+		if results[i].Err != nil && !IsNotFound(results[i].Err) {
+			return nil, results[i].Err
+		}
+	}
+	return results, nil
+}
+
 // MakeDir inserts an empty object at path. If the parent path does not exist,
 // MakeDir will create it recursively.
 func MakeDir(ctx context.Context, store Store, txn Transaction, path Path) error {
@@ -3,6 +3,8 @@ package storage_test
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
 	"strings"
 	"testing"
 
@@ -71,3 +73,105 @@ func TestNonEmpty(t *testing.T) {
 	}
 
 }
+
+func TestReadBatch(t *testing.T) {
+	ctx := context.Background()
+	store := inmem.NewFromReader(bytes.NewBufferString(`{"a": {"b": 1}, "c": 2}`))
+	txn := storage.NewTransactionOrDie(ctx, store)
+	defer store.Abort(ctx, txn)
+
+	results, err := storage.ReadBatch(ctx, store, txn, []storage.Path{
+		storage.MustParsePath("/a/b"),
+		storage.MustParsePath("/x"),
+		storage.MustParsePath("/c"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results but got %v", results)
+	}
+	if results[0].Err != nil || fmt.Sprint(results[0].Value) != "1" {
+		t.Errorf("Expected /a/b to be 1 but got %v, %v", results[0].Value, results[0].Err)
+	}
+	if !storage.IsNotFound(results[1].Err) {
+		t.Errorf("Expected /x to be not found but got %v, %v", results[1].Value, results[1].Err)
+	}
+	if results[2].Err != nil || fmt.Sprint(results[2].Value) != "2" {
+		t.Errorf("Expected /c to be 2 but got %v, %v", results[2].Value, results[2].Err)
+	}
+}
+
+func TestTriggerConfigFilter(t *testing.T) {
+	event := storage.TriggerEvent{
+		Data: []storage.DataEvent{
+			{Path: storage.MustParsePath("/a/b/c")},
+			{Path: storage.MustParsePath("/a")},
+			{Path: storage.MustParsePath("/x/y")},
+			{Path: storage.MustParsePath("/ab")},
+		},
+	}
+
+	tests := []struct {
+		note  string
+		paths []string
+		exp   []string
+		ok    bool
+	}{
+		{note: "no paths", exp: []string{"/a/b/c", "/a", "/x/y", "/ab"}, ok: true},
+		{note: "below and above", paths: []string{"/a/b"}, exp: []string{"/a/b/c", "/a"}, ok: true},
+		{note: "several paths", paths: []string{"/x", "/ab"}, exp: []string{"/x/y", "/ab"}, ok: true},
+		{note: "none", paths: []string{"/z"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			config := storage.TriggerConfig{}
+			for _, p := range tc.paths {
+				config.Paths = append(config.Paths, storage.MustParsePath(p))
+			}
+
+			filtered, ok := config.Filter(event)
+			if ok != tc.ok {
+				t.Fatalf("Expected ok to be %v", tc.ok)
+			}
+
+			var result []string
+			for _, e := range filtered.Data {
+				result = append(result, e.Path.String())
+			}
+			if fmt.Sprint(result) != fmt.Sprint(tc.exp) {
+				t.Fatalf("Expected %v but got %v", tc.exp, result)
+			}
+		})
+	}
+
+	// Policy changes are never filtered.
+	event.Policy = []storage.PolicyEvent{{ID: "test"}}
+	config := storage.TriggerConfig{Paths: []storage.Path{storage.MustParsePath("/z")}}
+	if filtered, ok := config.Filter(event); !ok || filtered.DataChanged() || !filtered.PolicyChanged() {
+		t.Fatalf("Expected policy changes only but got %v", filtered)
+	}
+}
+
+func TestTriggerEventPatch(t *testing.T) {
+	event := storage.TriggerEvent{
+		Data: []storage.DataEvent{
+			{Path: storage.MustParsePath("/a/b"), Data: 1, Op: storage.AddOp},
+			{Path: storage.Path{"c/d", "e~f"}, Data: nil, Op: storage.ReplaceOp},
+			{Path: storage.MustParsePath("/g"), Removed: true, Op: storage.RemoveOp},
+			{Path: storage.Path{}, Data: map[string]interface{}{}},
+		},
+	}
+
+	bs, err := json.Marshal(event.Patch())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `[{"op":"add","path":"/a/b","value":1},{"op":"replace","path":"/c~1d/e~0f","value":null},{"op":"remove","path":"/g"},{"op":"add","path":"","value":{}}]`
+	if string(bs) != exp {
+		t.Fatalf("Expected %v but got %v", exp, string(bs))
+	}
+}
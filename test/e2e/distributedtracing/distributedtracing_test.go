@@ -145,6 +145,55 @@ func TestServerSpan(t *testing.T) {
 	})
 }
 
+func TestServerSpanWithTelemetryAnnotations(t *testing.T) {
+	spanExporter.Reset()
+
+	policy := `# METADATA
+# custom:
+#   telemetry:
+#     attributes:
+#       team: payments
+#       tier: 2
+package annotated
+
+# METADATA
+# custom:
+#   telemetry:
+#     attributes:
+#       tier: 1
+#       critical: true
+#       owners: [alice, bob]
+allow {
+	input.user == "alice"
+}
+`
+
+	if err := testRuntime.UploadPolicy("annotated", strings.NewReader(policy)); err != nil {
+		t.Fatal(err)
+	}
+	spanExporter.Reset()
+
+	mr, err := http.Post(testRuntime.URL()+"/v1/data/annotated/allow", "application/json", strings.NewReader(`{"input": {"user": "alice"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr.Body.Close()
+
+	spans := spanExporter.GetSpans()
+	if got, expected := len(spans), 1; got != expected {
+		t.Fatalf("got %d span(s), expected %d", got, expected)
+	}
+
+	// Rule annotations take precedence over package annotations.
+	expected := []attribute.KeyValue{
+		attribute.String("team", "payments"),
+		attribute.Int64("tier", 1),
+		attribute.Bool("critical", true),
+		attribute.String("owners", `["alice","bob"]`),
+	}
+	compareSpanAttributes(t, expected, attribute.NewSet(spans[0].Attributes...))
+}
+
 func TestServerSpanWithDecisionLogging(t *testing.T) {
 	// setup
 	spanExp := tracetest.NewInMemoryExporter()
@@ -43,9 +43,14 @@ func TestShutdownWaitPeriod(t *testing.T) {
 
 	time.Sleep(1500 * time.Millisecond)
 
-	// Ensure that OPA is still running
+	// Ensure that OPA is still running and serving decisions, but reports
+	// itself as draining
+	if _, err := testRuntime.GetDataWithInput("data", nil); err != nil {
+		t.Fatalf("Expected decisions to be served but got:\n\n%v", err)
+	}
+
 	err = testRuntime.HealthCheck(testRuntime.URL())
-	if err != nil {
-		t.Fatalf("Expected health endpoint to be up but got:\n\n%v", err)
+	if err == nil {
+		t.Fatal("Expected health endpoint to report draining")
 	}
 }
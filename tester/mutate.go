@@ -0,0 +1,201 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package tester
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+type mutationKind int
+
+const (
+	mutationNegate mutationKind = iota
+	mutationReplaceOperator
+	mutationRemove
+)
+
+// comparisonMutations maps comparison operators to the operator they are
+// replaced with when mutating policies.
+var comparisonMutations = map[string]*ast.Builtin{
+	ast.Equal.Name:         ast.NotEqual,
+	ast.NotEqual.Name:      ast.Equal,
+	ast.LessThan.Name:      ast.LessThanEq,
+	ast.LessThanEq.Name:    ast.LessThan,
+	ast.GreaterThan.Name:   ast.GreaterThanEq,
+	ast.GreaterThanEq.Name: ast.GreaterThan,
+}
+
+// Mutant is a systematic modification of a single expression in a policy
+// module. A test suite "kills" a mutant if at least one of its tests fails when
+// run against the mutated policy; mutants that survive indicate behaviour of
+// the policy that is not verified by any test.
+type Mutant struct {
+	File        string        `json:"file"`
+	Location    *ast.Location `json:"location"`
+	Description string        `json:"description"`
+
+	kind      mutationKind
+	rule      int
+	elseDepth int
+	expr      int
+	op        *ast.Builtin
+}
+
+func (m *Mutant) String() string {
+	if m.Location != nil {
+		return fmt.Sprintf("%v:%d: %v", m.File, m.Location.Row, m.Description)
+	}
+	return fmt.Sprintf("%v: %v", m.File, m.Description)
+}
+
+// Mutants returns the mutants of the policy modules in modules, sorted by file
+// and location. Modules that contain test rules are not mutated. For every
+// expression in a rule body the following mutants are generated:
+//
+//   - the expression is negated,
+//   - comparison operators are swapped (== and !=, < and <=, > and >=),
+//   - the expression is removed (if the body contains other expressions).
+//
+// Only the top-level expressions of rule bodies are mutated. The modules must
+// not be compiled.
+func Mutants(modules map[string]*ast.Module) []*Mutant {
+	files := make([]string, 0, len(modules))
+	for file, module := range modules {
+		if !isTestModule(module) {
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+
+	var mutants []*Mutant
+	for _, file := range files {
+		for i, rule := range modules[file].Rules {
+			if rule.Default {
+				continue
+			}
+			for depth := 0; rule != nil; depth++ {
+				mutants = append(mutants, bodyMutants(file, i, depth, rule.Body)...)
+				rule = rule.Else
+			}
+		}
+	}
+
+	return mutants
+}
+
+func bodyMutants(file string, rule, depth int, body ast.Body) []*Mutant {
+	var mutants []*Mutant
+
+	for i, expr := range body {
+		if !isMutable(expr) {
+			continue
+		}
+
+		newMutant := func(kind mutationKind, desc string) *Mutant {
+			return &Mutant{
+				File:        file,
+				Location:    expr.Location,
+				Description: desc,
+				kind:        kind,
+				rule:        rule,
+				elseDepth:   depth,
+				expr:        i,
+			}
+		}
+
+		if expr.Negated {
+			mutants = append(mutants, newMutant(mutationNegate, fmt.Sprintf("removed negation from %v", exprText(expr))))
+		} else if !expr.IsAssignment() {
+			mutants = append(mutants, newMutant(mutationNegate, fmt.Sprintf("negated %v", exprText(expr))))
+		}
+
+		if expr.IsCall() {
+			if op, ok := comparisonMutations[expr.Operator().String()]; ok {
+				m := newMutant(mutationReplaceOperator, fmt.Sprintf("replaced %v with %v in %v", infix(expr), op.Infix, exprText(expr)))
+				m.op = op
+				mutants = append(mutants, m)
+			}
+		}
+
+		if len(body) > 1 {
+			mutants = append(mutants, newMutant(mutationRemove, fmt.Sprintf("removed %v", exprText(expr))))
+		}
+	}
+
+	return mutants
+}
+
+// Apply returns copies of modules with the mutation applied. The original
+// modules are not modified.
+func (m *Mutant) Apply(modules map[string]*ast.Module) map[string]*ast.Module {
+	result := make(map[string]*ast.Module, len(modules))
+	for file, module := range modules {
+		result[file] = module.Copy()
+	}
+
+	rule := result[m.File].Rules[m.rule]
+	for i := 0; i < m.elseDepth; i++ {
+		rule = rule.Else
+	}
+
+	switch m.kind {
+	case mutationNegate:
+		rule.Body[m.expr].Negated = !rule.Body[m.expr].Negated
+	case mutationReplaceOperator:
+		terms := rule.Body[m.expr].Terms.([]*ast.Term)
+		terms[0] = ast.NewTerm(m.op.Ref()).SetLocation(terms[0].Location)
+	case mutationRemove:
+		body := make(ast.Body, 0, len(rule.Body)-1)
+		body = append(body, rule.Body[:m.expr]...)
+		body = append(body, rule.Body[m.expr+1:]...)
+		for i := range body {
+			body[i].Index = i
+		}
+		rule.Body = body
+	}
+
+	return result
+}
+
+func isTestModule(module *ast.Module) bool {
+	for _, rule := range module.Rules {
+		name := ruleName(rule.Head)
+		if strings.HasPrefix(name, TestPrefix) || strings.HasPrefix(name, SkipTestPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isMutable returns false for expressions that cannot be meaningfully mutated,
+// like variable declarations and the constant bodies of rules without a body.
+func isMutable(expr *ast.Expr) bool {
+	switch terms := expr.Terms.(type) {
+	case *ast.SomeDecl, *ast.Every:
+		return false
+	case *ast.Term:
+		return !terms.Equal(ast.BooleanTerm(true))
+	}
+	return true
+}
+
+// exprText returns the source text of the expression if it is known.
+func exprText(expr *ast.Expr) string {
+	if expr.Location != nil && len(expr.Location.Text) > 0 {
+		return string(expr.Location.Text)
+	}
+	return expr.String()
+}
+
+func infix(expr *ast.Expr) string {
+	if bi, ok := ast.BuiltinMap[expr.Operator().String()]; ok && bi.Infix != "" {
+		return bi.Infix
+	}
+	return expr.Operator().String()
+}
@@ -0,0 +1,137 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package tester_test
+
+import (
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/tester"
+)
+
+func TestMutants(t *testing.T) {
+	modules := map[string]*ast.Module{
+		"policy.rego": ast.MustParseModule(`package foo
+default allow := false
+allow {
+	some x
+	input.x < 1
+	not input.y
+	z := input.z
+}
+p := 1
+q { false } else { input.a == 1 }`),
+		"policy_test.rego": ast.MustParseModule(`package foo
+helper { input.x == 0 }
+test_allow { allow with input as {"x": 0} }`),
+	}
+
+	mutants := tester.Mutants(modules)
+
+	exp := []struct {
+		desc   string
+		module string
+	}{
+		{
+			desc: "negated input.x < 1",
+			module: `package foo
+default allow := false
+allow { some x; not input.x < 1; not input.y; z := input.z }
+p := 1
+q { false } else { input.a == 1 }`,
+		},
+		{
+			desc: "replaced < with <= in input.x < 1",
+			module: `package foo
+default allow := false
+allow { some x; input.x <= 1; not input.y; z := input.z }
+p := 1
+q { false } else { input.a == 1 }`,
+		},
+		{
+			desc: "removed input.x < 1",
+			module: `package foo
+default allow := false
+allow { some x; not input.y; z := input.z }
+p := 1
+q { false } else { input.a == 1 }`,
+		},
+		{
+			desc: "removed negation from not input.y",
+			module: `package foo
+default allow := false
+allow { some x; input.x < 1; input.y; z := input.z }
+p := 1
+q { false } else { input.a == 1 }`,
+		},
+		{
+			desc: "removed not input.y",
+			module: `package foo
+default allow := false
+allow { some x; input.x < 1; z := input.z }
+p := 1
+q { false } else { input.a == 1 }`,
+		},
+		{
+			desc: "removed z := input.z",
+			module: `package foo
+default allow := false
+allow { some x; input.x < 1; not input.y }
+p := 1
+q { false } else { input.a == 1 }`,
+		},
+		{
+			desc: "negated false",
+			module: `package foo
+default allow := false
+allow { some x; input.x < 1; not input.y; z := input.z }
+p := 1
+q { not false } else { input.a == 1 }`,
+		},
+		{
+			desc: "negated input.a == 1",
+			module: `package foo
+default allow := false
+allow { some x; input.x < 1; not input.y; z := input.z }
+p := 1
+q { false } else { not input.a == 1 }`,
+		},
+		{
+			desc: "replaced == with != in input.a == 1",
+			module: `package foo
+default allow := false
+allow { some x; input.x < 1; not input.y; z := input.z }
+p := 1
+q { false } else { input.a != 1 }`,
+		},
+	}
+
+	if len(mutants) != len(exp) {
+		for _, m := range mutants {
+			t.Log(m)
+		}
+		t.Fatalf("expected %d mutants but got %d", len(exp), len(mutants))
+	}
+
+	for i, m := range mutants {
+		if m.File != "policy.rego" || m.Description != exp[i].desc {
+			t.Errorf("expected mutant %d to be %q in policy.rego but got %q in %v", i, exp[i].desc, m.Description, m.File)
+			continue
+		}
+
+		mutated := m.Apply(modules)
+		if expMod := ast.MustParseModule(exp[i].module); !mutated["policy.rego"].Equal(expMod) {
+			t.Errorf("%v: expected:\n\n%v\n\ngot:\n\n%v", m.Description, expMod, mutated["policy.rego"])
+		}
+		if !mutated["policy_test.rego"].Equal(modules["policy_test.rego"]) {
+			t.Errorf("%v: expected test module to be unchanged", m.Description)
+		}
+	}
+
+	// The original modules must not be modified.
+	if mutants := tester.Mutants(modules); len(mutants) != len(exp) {
+		t.Fatalf("expected %d mutants after applying mutations but got %d", len(exp), len(mutants))
+	}
+}
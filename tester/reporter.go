@@ -13,6 +13,7 @@ import (
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/cover"
+	"github.com/open-policy-agent/opa/internal/sarif"
 	"github.com/open-policy-agent/opa/topdown"
 )
 
@@ -175,6 +176,50 @@ func (r JSONReporter) Report(ch chan *Result) error {
 	return nil
 }
 
+// SARIF rule IDs of test results.
+const (
+	SARIFRuleTestFailure = "test_failure"
+	SARIFRuleTestError   = "test_error"
+)
+
+// SARIFReporter reports the failed tests and the tests that encountered
+// errors as a SARIF log. Failures are located at the expression that failed,
+// and errors at their location, if known.
+type SARIFReporter struct {
+	Output io.Writer
+}
+
+// Report prints the test report to the reporter's output.
+func (r SARIFReporter) Report(ch chan *Result) error {
+	log := sarif.New()
+	for tr := range ch {
+		loc := tr.Location
+		res := &sarif.Result{Level: sarif.LevelError}
+		switch {
+		case tr.Error != nil:
+			res.RuleID = SARIFRuleTestError
+			res.Message.Text = fmt.Sprintf("%v.%v: %v", tr.Package, tr.Name, tr.Error)
+			var topdownErr *topdown.Error
+			if errors.As(tr.Error, &topdownErr) && topdownErr.Location != nil {
+				loc = topdownErr.Location
+			}
+		case tr.Fail:
+			res.RuleID = SARIFRuleTestFailure
+			res.Message.Text = fmt.Sprintf("%v.%v failed", tr.Package, tr.Name)
+			if tr.FailedAt != nil && tr.FailedAt.Location != nil {
+				loc = tr.FailedAt.Location
+			}
+		default:
+			continue
+		}
+		if loc != nil && loc.File != "" {
+			res.Locations = []sarif.Location{sarif.NewLocation(loc.File, loc.Row, loc.Col)}
+		}
+		log.AddResult(res)
+	}
+	return log.Write(r.Output)
+}
+
 // JSONCoverageReporter reports coverage as a JSON structure.
 type JSONCoverageReporter struct {
 	Cover     *cover.Cover
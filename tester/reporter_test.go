@@ -450,6 +450,85 @@ func TestJSONReporter(t *testing.T) {
 	}
 }
 
+func TestSARIFReporter(t *testing.T) {
+	var buf bytes.Buffer
+	ts := []*Result{
+		{
+			Package:  "data.foo.bar",
+			Name:     "test_baz",
+			Location: &ast.Location{File: "policy1.rego", Row: 3, Col: 1},
+		},
+		{
+			Package:  "data.foo.bar",
+			Name:     "test_qux",
+			Location: &ast.Location{File: "policy1.rego", Row: 7, Col: 1},
+			Error:    fmt.Errorf("some err"),
+		},
+		{
+			Package:  "data.foo.bar",
+			Name:     "test_conflict",
+			Location: &ast.Location{File: "policy1.rego", Row: 9, Col: 1},
+			Error: &topdown.Error{
+				Code:     topdown.ConflictErr,
+				Message:  "functions must not produce multiple outputs for same inputs",
+				Location: &ast.Location{File: "policy2.rego", Row: 4, Col: 2},
+			},
+		},
+		{
+			Package:  "data.foo.bar",
+			Name:     "test_corge",
+			Location: &ast.Location{File: "policy1.rego", Row: 11, Col: 1},
+			Fail:     true,
+			FailedAt: &ast.Expr{Location: &ast.Location{File: "policy1.rego", Row: 12, Col: 2}},
+		},
+		{
+			Package:  "data.foo.bar",
+			Name:     "todo_test_qux",
+			Location: &ast.Location{File: "policy1.rego", Row: 15, Col: 1},
+			Skip:     true,
+		},
+	}
+
+	r := SARIFReporter{
+		Output: &buf,
+	}
+
+	if err := r.Report(resultsChan(ts)); err != nil {
+		t.Fatal(err)
+	}
+
+	var result map[string]interface{}
+	if err := util.UnmarshalJSON(buf.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	run := result["runs"].([]interface{})[0].(map[string]interface{})
+
+	location := func(file string, row, col int) string {
+		return fmt.Sprintf(`[{"physicalLocation": {"artifactLocation": {"uri": %q}, "region": {"startLine": %d, "startColumn": %d}}}]`, file, row, col)
+	}
+
+	exp := util.MustUnmarshalJSON([]byte(`[{
+		"ruleId": "test_error",
+		"level": "error",
+		"message": {"text": "data.foo.bar.test_qux: some err"},
+		"locations": ` + location("policy1.rego", 7, 1) + `
+	}, {
+		"ruleId": "test_error",
+		"level": "error",
+		"message": {"text": "data.foo.bar.test_conflict: policy2.rego:4: eval_conflict_error: functions must not produce multiple outputs for same inputs"},
+		"locations": ` + location("policy2.rego", 4, 2) + `
+	}, {
+		"ruleId": "test_failure",
+		"level": "error",
+		"message": {"text": "data.foo.bar.test_corge failed"},
+		"locations": ` + location("policy1.rego", 12, 2) + `
+	}]`))
+
+	if !reflect.DeepEqual(run["results"], exp) {
+		t.Fatalf("Expected %v but got %v", exp, run["results"])
+	}
+}
+
 func TestPrettyReporterVerboseBenchmark(t *testing.T) {
 	var buf bytes.Buffer
 
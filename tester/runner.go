@@ -12,6 +12,7 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -117,6 +118,7 @@ type Runner struct {
 	store                 storage.Store
 	cover                 topdown.QueryTracer
 	trace                 bool
+	failureLine           bool
 	enablePrintStatements bool
 	raiseBuiltinErrors    bool
 	runtime               *ast.Term
@@ -124,6 +126,8 @@ type Runner struct {
 	modules               map[string]*ast.Module
 	bundles               map[string]*bundle.Bundle
 	filter                string
+	changed               map[string]struct{}
+	parallelism           int
 	target                string // target type (wasm, rego, etc.)
 	customBuiltins        []*Builtin
 }
@@ -196,6 +200,14 @@ func (r *Runner) CapturePrintOutput(yes bool) *Runner {
 	return r
 }
 
+// EnableFailureLine enables tracing of evaluation to set the expression that
+// failed on the results of the tests that fail. Like tracing, it is currently
+// mutually exclusive with coverage.
+func (r *Runner) EnableFailureLine(yes bool) *Runner {
+	r.failureLine = yes
+	return r
+}
+
 // EnableTracing enables tracing of evaluation and includes traces in results.
 // Tracing is currently mutually exclusive with coverage.
 func (r *Runner) EnableTracing(yes bool) *Runner {
@@ -239,6 +251,33 @@ func (r *Runner) Filter(regex string) *Runner {
 	return r
 }
 
+// SetChangedModules restricts the runner to the test cases affected by changes
+// to the named modules. A test case is affected if it is defined in one of the
+// changed modules or if it depends, directly or transitively, on a rule defined
+// in one of them. If names is nil, all test cases are run.
+func (r *Runner) SetChangedModules(names []string) *Runner {
+	if names == nil {
+		r.changed = nil
+		return r
+	}
+	r.changed = make(map[string]struct{}, len(names))
+	for _, name := range names {
+		r.changed[name] = struct{}{}
+	}
+	return r
+}
+
+// WithParallelism sets the number of test cases that are executed concurrently.
+// Results are still reported in the order of the test cases. If the runner is
+// not given a transaction, each test case is evaluated in its own read
+// transaction; otherwise the given transaction is shared (read-only) by all
+// test cases. Benchmarks are always run sequentially. Values less than two
+// disable concurrent execution.
+func (r *Runner) WithParallelism(n int) *Runner {
+	r.parallelism = n
+	return r
+}
+
 // Target sets the output target type to use.
 func (r *Runner) Target(target string) *Runner {
 	r.target = target
@@ -269,20 +308,30 @@ func (r *Runner) Run(ctx context.Context, modules map[string]*ast.Module) (ch ch
 
 // RunTests executes tests found in either modules or bundles loaded on the runner.
 func (r *Runner) RunTests(ctx context.Context, txn storage.Transaction) (ch chan *Result, err error) {
-	return r.runTests(ctx, txn, true, r.runTest)
+	return r.runTests(ctx, txn, true, r.parallelism, r.runTest)
 }
 
 // RunBenchmarks executes tests similar to tester.Runner#RunTests but will repeat
 // a number of times to get stable performance metrics.
 func (r *Runner) RunBenchmarks(ctx context.Context, txn storage.Transaction, options BenchmarkOptions) (ch chan *Result, err error) {
-	return r.runTests(ctx, txn, false, func(ctx context.Context, txn storage.Transaction, module *ast.Module, rule *ast.Rule) (result *Result, b bool) {
+	return r.runTests(ctx, txn, false, 1, func(ctx context.Context, txn storage.Transaction, module *ast.Module, rule *ast.Rule) (result *Result, b bool) {
 		return r.runBenchmark(ctx, txn, module, rule, options)
 	})
 }
 
 type run func(context.Context, storage.Transaction, *ast.Module, *ast.Rule) (*Result, bool)
 
-func (r *Runner) runTests(ctx context.Context, txn storage.Transaction, enablePrintStatements bool, runFunc run) (chan *Result, error) {
+type testCase struct {
+	module *ast.Module
+	rule   *ast.Rule
+}
+
+type testCaseResult struct {
+	result *Result
+	stop   bool
+}
+
+func (r *Runner) runTests(ctx context.Context, txn storage.Transaction, enablePrintStatements bool, parallelism int, runFunc run) (chan *Result, error) {
 	var testRegex *regexp.Regexp
 	var err error
 
@@ -361,25 +410,42 @@ func (r *Runner) runTests(ctx context.Context, txn storage.Transaction, enablePr
 
 	sort.Strings(filenames)
 
+	var affected map[*ast.Rule]struct{}
+	if r.changed != nil {
+		affected = r.affectedRules()
+	}
+
+	var cases []testCase
+	for _, name := range filenames {
+		module := r.compiler.Modules[name]
+		for _, rule := range module.Rules {
+			if !r.shouldRun(rule, testRegex) {
+				continue
+			}
+			if affected != nil && !isAffected(rule, affected) {
+				continue
+			}
+			cases = append(cases, testCase{module: module, rule: rule})
+		}
+	}
+
 	ch := make(chan *Result)
 
+	if parallelism > 1 && len(cases) > 1 {
+		if _, ok := r.cover.(*lockedQueryTracer); r.cover != nil && !ok {
+			r.cover = &lockedQueryTracer{tracer: r.cover}
+		}
+		go r.runTestCasesParallel(ctx, txn, cases, parallelism, runFunc, ch)
+		return ch, nil
+	}
+
 	go func() {
 		defer close(ch)
-		for _, name := range filenames {
-			module := r.compiler.Modules[name]
-			for _, rule := range module.Rules {
-				if !r.shouldRun(rule, testRegex) {
-					continue
-				}
-				tr, stop := func() (*Result, bool) {
-					runCtx, cancel := context.WithTimeout(ctx, r.timeout)
-					defer cancel()
-					return runFunc(runCtx, txn, module, rule)
-				}()
-				ch <- tr
-				if stop {
-					return
-				}
+		for _, tc := range cases {
+			tr, stop := r.runTestCase(ctx, txn, tc, runFunc)
+			ch <- tr
+			if stop {
+				return
 			}
 		}
 	}()
@@ -387,6 +453,93 @@ func (r *Runner) runTests(ctx context.Context, txn storage.Transaction, enablePr
 	return ch, nil
 }
 
+func (r *Runner) runTestCase(ctx context.Context, txn storage.Transaction, tc testCase, runFunc run) (*Result, bool) {
+	runCtx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	return runFunc(runCtx, txn, tc.module, tc.rule)
+}
+
+// runTestCasesParallel executes the test cases on n workers and sends the
+// results to ch in the order of the test cases. Execution stops at the first
+// result that requests it (e.g., because the context was cancelled.)
+func (r *Runner) runTestCasesParallel(ctx context.Context, txn storage.Transaction, cases []testCase, n int, runFunc run, ch chan<- *Result) {
+	defer close(ch)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	next := make(chan int, len(cases))
+	for i := range cases {
+		next <- i
+	}
+	close(next)
+
+	results := make([]chan testCaseResult, len(cases))
+	for i := range results {
+		results[i] = make(chan testCaseResult, 1)
+	}
+
+	if n > len(cases) {
+		n = len(cases)
+	}
+
+	for w := 0; w < n; w++ {
+		go func() {
+			for i := range next {
+				tr, stop := r.runIsolatedTestCase(ctx, txn, cases[i], runFunc)
+				results[i] <- testCaseResult{result: tr, stop: stop}
+			}
+		}()
+	}
+
+	for i := range cases {
+		res := <-results[i]
+		ch <- res.result
+		if res.stop {
+			return
+		}
+	}
+}
+
+// runIsolatedTestCase executes the test case in its own read transaction
+// unless the caller provided a transaction to use.
+func (r *Runner) runIsolatedTestCase(ctx context.Context, txn storage.Transaction, tc testCase, runFunc run) (*Result, bool) {
+	if txn != nil {
+		return r.runTestCase(ctx, txn, tc, runFunc)
+	}
+
+	txn, err := r.store.NewTransaction(ctx)
+	if err != nil {
+		tr := newResult(tc.rule.Loc(), tc.module.Package.Path.String(), tc.rule.Head.Ref().String(), 0, nil, nil)
+		tr.Error = err
+		return tr, false
+	}
+	defer r.store.Abort(ctx, txn)
+
+	return r.runTestCase(ctx, txn, tc, runFunc)
+}
+
+// lockedQueryTracer serializes the events of concurrently executed test cases
+// for tracers that are shared between them, like the coverage tracer.
+type lockedQueryTracer struct {
+	mu     sync.Mutex
+	tracer topdown.QueryTracer
+}
+
+func (t *lockedQueryTracer) Enabled() bool {
+	return t.tracer.Enabled()
+}
+
+func (t *lockedQueryTracer) Config() topdown.TraceConfig {
+	return t.tracer.Config()
+}
+
+func (t *lockedQueryTracer) TraceEvent(evt topdown.Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tracer.TraceEvent(evt)
+}
+
 func (r *Runner) shouldRun(rule *ast.Rule, testRegex *regexp.Regexp) bool {
 	ruleName := ruleName(rule.Head)
 
@@ -404,6 +557,50 @@ func (r *Runner) shouldRun(rule *ast.Rule, testRegex *regexp.Regexp) bool {
 	return true
 }
 
+// affectedRules returns the rules defined in the changed modules along with
+// all rules that depend on them according to the compiler's rule graph.
+func (r *Runner) affectedRules() map[*ast.Rule]struct{} {
+	affected := map[*ast.Rule]struct{}{}
+	var queue []*ast.Rule
+
+	for name := range r.changed {
+		module, ok := r.compiler.Modules[name]
+		if !ok {
+			continue
+		}
+		ast.WalkRules(module, func(rule *ast.Rule) bool {
+			affected[rule] = struct{}{}
+			queue = append(queue, rule)
+			return false
+		})
+	}
+
+	for len(queue) > 0 {
+		rule := queue[0]
+		queue = queue[1:]
+		for dep := range r.compiler.Graph.Dependents(rule) {
+			d := dep.(*ast.Rule)
+			if _, ok := affected[d]; !ok {
+				affected[d] = struct{}{}
+				queue = append(queue, d)
+			}
+		}
+	}
+
+	return affected
+}
+
+// isAffected returns true if the rule, or any of its else branches, is in the
+// set of affected rules.
+func isAffected(rule *ast.Rule, affected map[*ast.Rule]struct{}) bool {
+	for ; rule != nil; rule = rule.Else {
+		if _, ok := affected[rule]; ok {
+			return true
+		}
+	}
+	return false
+}
+
 // rewriteDuplicateTestNames will rewrite duplicate test names to have a numbered suffix.
 // This uses a global "count" of each to ensure compiling more than once as new modules
 // are added can't introduce duplicates again.
@@ -459,6 +656,12 @@ func (r *Runner) runTest(ctx context.Context, txn storage.Transaction, mod *ast.
 	} else if r.trace {
 		bufferTracer = topdown.NewBufferTracer()
 		tracer = bufferTracer
+		if r.failureLine {
+			bufFailureLineTracer = bufferTracer
+		}
+	} else if r.failureLine {
+		bufFailureLineTracer = topdown.NewBufferTracer()
+		tracer = bufFailureLineTracer
 	}
 
 	ruleName := ruleName(rule.Head)
@@ -612,9 +815,16 @@ func Load(args []string, filter loader.Filter) (map[string]*ast.Module, storage.
 // LoadWithRegoVersion returns modules and an in-memory store for running tests.
 // Modules are parsed in accordance with the given RegoVersion.
 func LoadWithRegoVersion(args []string, filter loader.Filter, regoVersion ast.RegoVersion) (map[string]*ast.Module, storage.Store, error) {
+	return LoadWithModuleCache(args, filter, regoVersion, nil)
+}
+
+// LoadWithModuleCache is like LoadWithRegoVersion, but looks up and stores the
+// parsed modules in cache.
+func LoadWithModuleCache(args []string, filter loader.Filter, regoVersion ast.RegoVersion, cache ast.ModuleCache) (map[string]*ast.Module, storage.Store, error) {
 	loaded, err := loader.NewFileLoader().
 		WithRegoVersion(regoVersion).
 		WithProcessAnnotation(true).
+		WithModuleCache(cache).
 		Filtered(args, filter)
 	if err != nil {
 		return nil, nil, err
@@ -647,11 +857,18 @@ func LoadBundles(args []string, filter loader.Filter) (map[string]*bundle.Bundle
 // LoadBundlesWithRegoVersion will load the given args as bundles, either tarball or directory is OK.
 // Bundles are parsed in accordance with the given RegoVersion.
 func LoadBundlesWithRegoVersion(args []string, filter loader.Filter, regoVersion ast.RegoVersion) (map[string]*bundle.Bundle, error) {
+	return LoadBundlesWithModuleCache(args, filter, regoVersion, nil)
+}
+
+// LoadBundlesWithModuleCache is like LoadBundlesWithRegoVersion, but looks up
+// and stores the parsed modules in cache.
+func LoadBundlesWithModuleCache(args []string, filter loader.Filter, regoVersion ast.RegoVersion, cache ast.ModuleCache) (map[string]*bundle.Bundle, error) {
 	bundles := map[string]*bundle.Bundle{}
 	for _, bundleDir := range args {
 		b, err := loader.NewFileLoader().
 			WithRegoVersion(regoVersion).
 			WithProcessAnnotation(true).
+			WithModuleCache(cache).
 			WithSkipBundleVerification(true).
 			WithFilter(filter).
 			AsBundle(bundleDir)
@@ -8,6 +8,9 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"regexp"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -15,6 +18,7 @@ import (
 	"github.com/open-policy-agent/opa/cover"
 	"github.com/open-policy-agent/opa/rego"
 	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
 	"github.com/open-policy-agent/opa/tester"
 	"github.com/open-policy-agent/opa/topdown"
 	"github.com/open-policy-agent/opa/types"
@@ -29,6 +33,10 @@ func TestRunBenchmark(t *testing.T) {
 	testRun(t, testRunConfig{bench: true})
 }
 
+func TestRunParallel(t *testing.T) {
+	testRun(t, testRunConfig{parallelism: 4})
+}
+
 func TestRunWithCoverage(t *testing.T) {
 	cov := cover.New()
 	modules := testRun(t, testRunConfig{coverTracer: cov})
@@ -52,6 +60,7 @@ type testRunConfig struct {
 	bench       bool
 	filter      string
 	coverTracer topdown.QueryTracer
+	parallelism int
 }
 
 type expectedTestResults map[[2]string]expectedTestResult
@@ -133,7 +142,8 @@ func doTestRunWithTmpDir(t *testing.T, dir string, conf testRunConfig) ([]*teste
 		SetModules(modules).
 		Filter(conf.filter).
 		SetTimeout(60 * time.Second).
-		SetCoverageQueryTracer(conf.coverTracer)
+		SetCoverageQueryTracer(conf.coverTracer).
+		WithParallelism(conf.parallelism)
 
 	var ch chan *tester.Result
 	if conf.bench {
@@ -337,6 +347,143 @@ func TestRunWithFilterRegex(t *testing.T) {
 	})
 }
 
+func TestRunWithChangedModules(t *testing.T) {
+	files := map[string]string{
+		"a.rego": `package a
+			p := 1
+			f(x) := x + 1`,
+		"a_test.rego": `package a
+			test_p { p == 1 }`,
+		"b.rego": `package b
+			q := data.a.p + 1`,
+		"b_test.rego": `package b
+			test_q { q == 2 }
+			test_f { data.a.f(1) == 2 }
+			test_else { false } else = true { q == 2 }`,
+		"c_test.rego": `package c
+			test_r { true }`,
+	}
+
+	cases := []struct {
+		note    string
+		changed []string
+		exp     []string
+	}{
+		{
+			note:    "all tests",
+			changed: nil,
+			exp:     []string{"data.a.test_p", "data.b.test_else", "data.b.test_f", "data.b.test_q", "data.c.test_r"},
+		},
+		{
+			note:    "no changes",
+			changed: []string{},
+			exp:     nil,
+		},
+		{
+			note:    "test module",
+			changed: []string{"c_test.rego"},
+			exp:     []string{"data.c.test_r"},
+		},
+		{
+			note:    "transitive dependents",
+			changed: []string{"a.rego"},
+			exp:     []string{"data.a.test_p", "data.b.test_else", "data.b.test_f", "data.b.test_q"},
+		},
+		{
+			note:    "else branch",
+			changed: []string{"b.rego"},
+			exp:     []string{"data.b.test_else", "data.b.test_q"},
+		},
+		{
+			note:    "unknown module",
+			changed: []string{"d.rego"},
+			exp:     nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.note, func(t *testing.T) {
+			modules := map[string]*ast.Module{}
+			for name, src := range files {
+				modules[name] = ast.MustParseModule(src)
+			}
+
+			ch, err := tester.NewRunner().
+				SetModules(modules).
+				SetChangedModules(tc.changed).
+				RunTests(context.Background(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var names []string
+			for tr := range ch {
+				if !tr.Pass() {
+					t.Errorf("unexpected result for %v", tr)
+				}
+				names = append(names, tr.Package+"."+tr.Name)
+			}
+			sort.Strings(names)
+
+			if !reflect.DeepEqual(names, tc.exp) {
+				t.Fatalf("expected tests %v but got %v", tc.exp, names)
+			}
+		})
+	}
+}
+
+func TestRunnerParallelism(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("package foo\n")
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(&b, "test_%02d { data.x == %d }\n", i, i%2)
+	}
+
+	ctx := context.Background()
+	store := inmem.NewFromObject(map[string]interface{}{"x": 0})
+
+	run := func(parallelism int) []string {
+		module, err := ast.ParseModule("test.rego", b.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		modules := map[string]*ast.Module{"test.rego": module}
+		cov := cover.New()
+		ch, err := tester.NewRunner().
+			SetStore(store).
+			SetModules(modules).
+			SetCoverageQueryTracer(cov).
+			WithParallelism(parallelism).
+			RunTests(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var results []string
+		for tr := range ch {
+			results = append(results, tr.String())
+		}
+		if report := cov.Report(modules); report.Coverage == 0 {
+			t.Error("expected test coverage")
+		}
+		return results
+	}
+
+	strip := regexp.MustCompile(` \(.*\)$`)
+	exp := run(1)
+	act := run(8)
+
+	if len(exp) != 50 || len(act) != 50 {
+		t.Fatalf("expected 50 results, got %d and %d", len(exp), len(act))
+	}
+
+	for i := range exp {
+		e, a := strip.ReplaceAllString(exp[i], ""), strip.ReplaceAllString(act[i], "")
+		if e != a {
+			t.Fatalf("expected result %d to be %q but got %q", i, e, a)
+		}
+	}
+}
+
 func TestRunnerCancel(t *testing.T) {
 	testCancel(t, false)
 }
@@ -515,6 +662,60 @@ func TestRunnerPrintOutput(t *testing.T) {
 	})
 }
 
+func TestRunnerFailureLine(t *testing.T) {
+
+	files := map[string]string{
+		"/test.rego": `package test
+
+		p := 1
+
+		test_a {
+			p == 1
+			p == 2
+		}
+
+		test_b { p == 1 }`,
+	}
+
+	ctx := context.Background()
+
+	for _, trace := range []bool{false, true} {
+		t.Run(fmt.Sprintf("trace=%v", trace), func(t *testing.T) {
+			test.WithTempFS(files, func(d string) {
+				modules, store, err := tester.Load([]string{d}, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				txn := storage.NewTransactionOrDie(ctx, store)
+				runner := tester.NewRunner().SetStore(store).SetModules(modules).EnableTracing(trace).EnableFailureLine(true)
+				ch, err := runner.RunTests(ctx, txn)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				got := map[string]string{}
+				for tr := range ch {
+					if tr.FailedAt != nil {
+						got[tr.Name] = fmt.Sprintf("%v at %d", tr.FailedAt, tr.FailedAt.Location.Row)
+					} else {
+						got[tr.Name] = ""
+					}
+				}
+
+				exp := map[string]string{
+					"test_a": "data.test.p = 2 at 7",
+					"test_b": "",
+				}
+
+				if !reflect.DeepEqual(exp, got) {
+					t.Fatal("expected:", exp, "got:", got)
+				}
+			})
+		})
+	}
+}
+
 func registerSleepBuiltin() {
 	ast.RegisterBuiltin(&ast.Builtin{
 		Name: "test.sleep",
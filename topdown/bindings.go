@@ -7,6 +7,7 @@ package topdown
 import (
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/open-policy-agent/opa/ast"
 )
@@ -39,6 +40,48 @@ func newBindings(id uint64, instr *Instrumentation) *bindings {
 	return &bindings{id, values, instr}
 }
 
+var bindingsPool = sync.Pool{
+	New: func() interface{} {
+		return &bindings{}
+	},
+}
+
+// bindingsArena allocates the bindings of a query from a pool and returns them
+// to it once the query is done. The bindings must not be referenced after the
+// arena is released, so the arena is only used when nothing retains them, e.g.,
+// trace events. A nil arena allocates the bindings on the heap.
+type bindingsArena struct {
+	mtx sync.Mutex
+	bs  []*bindings
+}
+
+func (a *bindingsArena) newBindings(id uint64, instr *Instrumentation) *bindings {
+	if a == nil {
+		return newBindings(id, instr)
+	}
+	b := bindingsPool.Get().(*bindings)
+	b.id = id
+	b.instr = instr
+	a.mtx.Lock()
+	a.bs = append(a.bs, b)
+	a.mtx.Unlock()
+	return b
+}
+
+func (a *bindingsArena) release() {
+	if a == nil {
+		return
+	}
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	for _, b := range a.bs {
+		b.instr = nil
+		b.values.reset()
+		bindingsPool.Put(b)
+	}
+	a.bs = nil
+}
+
 func (u *bindings) Iter(caller *bindings, iter func(*ast.Term, *ast.Term) error) error {
 
 	var err error
@@ -308,6 +351,15 @@ func newBindingsArrayHashmap() bindingsArrayHashmap {
 	return bindingsArrayHashmap{}
 }
 
+// reset removes all entries, keeping the array for reuse.
+func (b *bindingsArrayHashmap) reset() {
+	if b.a != nil {
+		*b.a = [maxLinearScan]bindingArrayKeyValue{}
+	}
+	b.n = 0
+	b.m = nil
+}
+
 func (b *bindingsArrayHashmap) Put(key *ast.Term, value value) {
 	if b.m == nil {
 		if b.a == nil {
@@ -10,13 +10,16 @@ import (
 	"fmt"
 	"io"
 	"math/rand"
+	"time"
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/metrics"
+	"github.com/open-policy-agent/opa/storage"
 	"github.com/open-policy-agent/opa/topdown/builtins"
 	"github.com/open-policy-agent/opa/topdown/cache"
 	"github.com/open-policy-agent/opa/topdown/print"
 	"github.com/open-policy-agent/opa/tracing"
+	"github.com/open-policy-agent/opa/util"
 )
 
 type (
@@ -52,7 +55,11 @@ type (
 		ParentID               uint64                // identifies parent of query being evaluated
 		PrintHook              print.Hook            // provides callback function to use for printing
 		DistributedTracingOpts tracing.Options       // options to be used by distributed tracing.
+		SpanThreshold          time.Duration         // minimum duration of built-in calls recorded as spans, zero if disabled
+		HTTPTransportPool      *HTTPTransportPool    // transports shared by http.send calls
 		rand                   *rand.Rand            // randomization source for non-security-sensitive operations
+		store                  storage.Store         // store that the query is evaluated against
+		txn                    storage.Transaction   // transaction that the query is evaluated in
 		Capabilities           *ast.Capabilities
 	}
 
@@ -220,3 +227,22 @@ func getResult(fn BuiltinFunc, operands ...*ast.Term) (*ast.Term, error) {
 	}
 	return result, nil
 }
+
+// getValueKeyedCache returns the map held under key in the intra-query cache
+// of bctx, for state that built-in functions derive from operand values. The
+// map is keyed on ast.Values. Without an intra-query cache, e.g., when the
+// built-in function is called directly, the map is not retained.
+func getValueKeyedCache(bctx BuiltinContext, key interface{}) *util.HashMap {
+	if raw, ok := bctx.Cache.Get(key); ok {
+		return raw.(*util.HashMap)
+	}
+	m := util.NewHashMap(func(a, b util.T) bool {
+		return a.(ast.Value).Compare(b.(ast.Value)) == 0
+	}, func(x util.T) int {
+		return x.(ast.Value).Hash()
+	})
+	if bctx.Cache != nil {
+		bctx.Cache.Put(key, m)
+	}
+	return m
+}
@@ -94,7 +94,7 @@ func (c *NDBCache) UnmarshalJSON(data []byte) error {
 	if source, ok := nestedObject.(ast.Object); ok {
 		err = source.Iter(func(k, v *ast.Term) error {
 			if obj, ok := v.Value.(ast.Object); ok {
-				out[string(k.Value.(ast.String))] = obj
+				out[string(k.Value.(ast.String))] = ndbCacheOperandKeys(obj)
 				return nil
 			}
 			return fmt.Errorf("expected Object, got other Value type in conversion")
@@ -109,6 +109,24 @@ func (c *NDBCache) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// ndbCacheOperandKeys restores the keys of obj, the operands of the cached
+// calls, that JSON encodes as strings.
+func ndbCacheOperandKeys(obj ast.Object) ast.Object {
+	out := ast.NewObject()
+	obj.Foreach(func(k, v *ast.Term) {
+		if s, ok := k.Value.(ast.String); ok && strings.HasPrefix(string(s), "[") {
+			var operands []interface{}
+			if err := util.UnmarshalJSON([]byte(s), &operands); err == nil {
+				if x, err := ast.InterfaceToValue(operands); err == nil {
+					k = ast.NewTerm(x)
+				}
+			}
+		}
+		out.Insert(k, v)
+	})
+	return out
+}
+
 // ErrOperand represents an invalid operand has been passed to a built-in
 // function. Built-ins should return ErrOperand to indicate a type error has
 // occurred.
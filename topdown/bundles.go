@@ -0,0 +1,70 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"errors"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/storage"
+)
+
+// bundlesBasePath is the storage path that the manifests of activated bundles
+// are written to. See bundle.BundlesBasePath.
+var bundlesBasePath = storage.MustParsePath("/system/bundles")
+
+func builtinBundlesStatus(bctx BuiltinContext, _ []*ast.Term, iter func(*ast.Term) error) error {
+	result := ast.NewObject()
+
+	if bctx.store == nil {
+		return iter(ast.NewTerm(result))
+	}
+
+	value, err := bctx.store.Read(bctx.Context, bctx.txn, bundlesBasePath)
+	if err != nil {
+		if storage.IsNotFound(err) {
+			return iter(ast.NewTerm(result))
+		}
+		return err
+	}
+
+	bundles, ok := value.(map[string]interface{})
+	if !ok {
+		return errors.New("corrupt bundle manifests")
+	}
+
+	for name, b := range bundles {
+		var manifest map[string]interface{}
+		if m, ok := b.(map[string]interface{}); ok {
+			manifest, _ = m["manifest"].(map[string]interface{})
+		}
+
+		revision, _ := manifest["revision"].(string)
+		roots, ok := manifest["roots"].([]interface{})
+		if !ok {
+			roots = []interface{}{}
+		}
+		metadata, ok := manifest["metadata"].(map[string]interface{})
+		if !ok {
+			metadata = map[string]interface{}{}
+		}
+
+		status, err := ast.InterfaceToValue(map[string]interface{}{
+			"revision": revision,
+			"roots":    roots,
+			"metadata": metadata,
+		})
+		if err != nil {
+			return err
+		}
+		result.Insert(ast.StringTerm(name), ast.NewTerm(status))
+	}
+
+	return iter(ast.NewTerm(result))
+}
+
+func init() {
+	RegisterBuiltinFunc(ast.BundlesStatus.Name, builtinBundlesStatus)
+}
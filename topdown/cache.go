@@ -5,12 +5,23 @@
 package topdown
 
 import (
+	"sort"
+
 	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/topdown/cache"
 	"github.com/open-policy-agent/opa/util"
 )
 
 type virtualCache struct {
-	stack []*virtualCacheElem
+	stack    []*virtualCacheElem
+	snapshot []VirtualCacheEntry // last snapshot, nil if the cache changed since
+}
+
+// VirtualCacheEntry is an entry of a snapshot of the virtual document cache.
+// Value is nil if the document at Ref is undefined.
+type VirtualCacheEntry struct {
+	Ref   ast.Ref
+	Value *ast.Term
 }
 
 type virtualCacheElem struct {
@@ -27,10 +38,25 @@ func newVirtualCache() *virtualCache {
 
 func (c *virtualCache) Push() {
 	c.stack = append(c.stack, newVirtualCacheElem())
+	c.snapshot = nil
 }
 
 func (c *virtualCache) Pop() {
 	c.stack = c.stack[:len(c.stack)-1]
+	c.snapshot = nil
+}
+
+// Snapshot returns the entries of the cache, sorted by ref. Snapshots are
+// reused until the cache changes, so they must not be modified.
+func (c *virtualCache) Snapshot() []VirtualCacheEntry {
+	if c.snapshot == nil {
+		c.snapshot = []VirtualCacheEntry{}
+		c.stack[len(c.stack)-1].appendEntries(nil, &c.snapshot)
+		sort.Slice(c.snapshot, func(i, j int) bool {
+			return c.snapshot[i].Ref.Compare(c.snapshot[j].Ref) < 0
+		})
+	}
+	return c.snapshot
 }
 
 // Returns the resolved value of the AST term and a flag indicating if the value
@@ -75,6 +101,17 @@ func (c *virtualCache) Put(ref ast.Ref, value *ast.Term) {
 	} else {
 		node.undefined = true
 	}
+	c.snapshot = nil
+}
+
+func (e *virtualCacheElem) appendEntries(ref ast.Ref, entries *[]VirtualCacheEntry) {
+	if e.value != nil || e.undefined {
+		*entries = append(*entries, VirtualCacheEntry{Ref: ref.Copy(), Value: e.value})
+	}
+	e.children.Iter(func(k, v util.T) bool {
+		v.(*virtualCacheElem).appendEntries(append(ref, k.(*ast.Term)), entries)
+		return false
+	})
 }
 
 func newVirtualCacheElem() *virtualCacheElem {
@@ -307,3 +344,124 @@ func (s *functionMocksStack) Get(f ast.Ref) (*ast.Term, bool) {
 	}
 	return nil, false
 }
+
+// interQueryRuleCacheState wraps the inter-query rule cache supplied by the
+// caller with a memo of the rules that have been checked for cacheability during
+// the current query.
+type interQueryRuleCacheState struct {
+	c         cache.InterQueryRuleCache
+	cacheable map[*ast.Rule]bool
+}
+
+func newInterQueryRuleCacheState(c cache.InterQueryRuleCache) *interQueryRuleCacheState {
+	if c == nil {
+		return nil
+	}
+	return &interQueryRuleCacheState{c: c, cacheable: map[*ast.Rule]bool{}}
+}
+
+// interQueryRuleCacheable returns true if the value of the complete document
+// defined by ir can be shared with other queries. Values may only be shared if
+// they are computed solely from policy and base documents: the rules (and
+// everything they depend on) must not refer to the input document, call
+// non-deterministic, custom or overridden built-in functions, or use with
+// statements. The cache is bypassed while tracing so that traces remain
+// complete.
+func (e *eval) interQueryRuleCacheable(ir *ast.IndexResult) bool {
+	if e.interQueryRuleCache == nil || e.compiler == nil || e.partial() || e.traceEnabled ||
+		e.data != nil || len(e.external.children) > 0 || len(e.virtualCache.stack) > 1 {
+		return false
+	}
+
+	rules := make([]*ast.Rule, 0, len(ir.Rules)+1)
+	for _, rule := range ir.Rules {
+		rules = append(rules, rule)
+		rules = append(rules, ir.Else[rule]...)
+	}
+	if ir.Default != nil {
+		rules = append(rules, ir.Default)
+	}
+
+	for _, rule := range rules {
+		if !e.ruleCacheable(rule, map[*ast.Rule]struct{}{}) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *eval) ruleCacheable(rule *ast.Rule, visited map[*ast.Rule]struct{}) bool {
+	if result, ok := e.interQueryRuleCache.cacheable[rule]; ok {
+		return result
+	}
+	if _, ok := visited[rule]; ok {
+		return true
+	}
+	visited[rule] = struct{}{}
+
+	result := e.ruleBodyCacheable(rule)
+	if result {
+		for dep := range e.compiler.Graph.Dependencies(rule) {
+			if !e.ruleCacheable(dep.(*ast.Rule), visited) {
+				result = false
+				break
+			}
+		}
+	}
+
+	e.interQueryRuleCache.cacheable[rule] = result
+	return result
+}
+
+func (e *eval) ruleBodyCacheable(rule *ast.Rule) bool {
+	result := true
+	stop := false
+	vis := ast.NewGenericVisitor(func(x interface{}) bool {
+		if !result {
+			return true
+		}
+		switch x := x.(type) {
+		case *ast.Rule:
+			// Else clauses are checked separately.
+			if stop {
+				return true
+			}
+			stop = true
+		case *ast.Expr:
+			if len(x.With) > 0 {
+				result = false
+			} else if x.IsCall() {
+				result = e.callCacheable(x.Operator())
+			}
+		case ast.Call:
+			result = e.callCacheable(x[0].Value.(ast.Ref))
+		case ast.Ref:
+			if x[0].Equal(ast.InputRootDocument) {
+				result = false
+			}
+		case ast.Var:
+			if x.Equal(ast.InputRootDocument.Value) {
+				result = false
+			}
+		}
+		return !result
+	})
+	vis.Walk(rule)
+	return result
+}
+
+func (e *eval) callCacheable(operator ast.Ref) bool {
+	if operator.HasPrefix(ast.DefaultRootRef) {
+		// User-defined functions are covered by the rule dependency graph.
+		return true
+	}
+	name := operator.String()
+	if _, ok := e.builtins[name]; ok {
+		return false
+	}
+	if _, ok := e.builtinOverrides[name]; ok {
+		return false
+	}
+	bi, ok := ast.BuiltinMap[name]
+	return ok && !bi.Nondeterministic && name != ast.Print.Name && name != ast.InternalPrint.Name
+}
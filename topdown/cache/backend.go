@@ -0,0 +1,303 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/logging"
+)
+
+const (
+	defaultBackendKeyPrefix      = "opa:"
+	defaultBackendTimeoutMs      = int64(100)
+	defaultStampedeLockTTLMs     = int64(1000)
+	stampedeLockPollInterval     = 20 * time.Millisecond
+	stampedeLockKeySuffix        = ":lock"
+	backendValueKeyHashSeparator = "iqc:"
+)
+
+// BackendConfig represents the configuration of the backend that the
+// inter-query caches of several OPA instances share.
+// Redis - the Redis server to use as backend
+// KeyPrefix - prefix of the keys of the cached values in the backend
+// TimeoutMs - timeout of the operations on the backend
+// StampedeLockTTLMs - duration that a cache miss is reserved for one instance, others wait for its value in the meantime; zero disables the protection
+type BackendConfig struct {
+	Redis             *RedisConfig `json:"redis,omitempty"`
+	KeyPrefix         *string      `json:"key_prefix,omitempty"`
+	TimeoutMs         *int64       `json:"timeout_ms,omitempty"`
+	StampedeLockTTLMs *int64       `json:"stampede_lock_ttl_ms,omitempty"`
+}
+
+func (c *BackendConfig) validateAndInjectDefaults() error {
+	if c.Redis == nil {
+		return fmt.Errorf("invalid inter_query_builtin_cache.backend, redis must be configured")
+	}
+	if err := c.Redis.validate(); err != nil {
+		return err
+	}
+	if c.KeyPrefix == nil {
+		prefix := defaultBackendKeyPrefix
+		c.KeyPrefix = &prefix
+	}
+	if c.TimeoutMs == nil {
+		timeout := defaultBackendTimeoutMs
+		c.TimeoutMs = &timeout
+	} else if *c.TimeoutMs <= 0 {
+		return fmt.Errorf("invalid timeout_ms %v", *c.TimeoutMs)
+	}
+	if c.StampedeLockTTLMs == nil {
+		ttl := defaultStampedeLockTTLMs
+		c.StampedeLockTTLMs = &ttl
+	} else if *c.StampedeLockTTLMs < 0 {
+		return fmt.Errorf("invalid stampede_lock_ttl_ms %v", *c.StampedeLockTTLMs)
+	}
+	return nil
+}
+
+// Backend defines the interface for the key-value stores that the inter-query
+// caches of several OPA instances share, e.g., Redis.
+type Backend interface {
+	// Get returns the value of key, if any.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+
+	// Set sets the value of key. The value expires after ttl, unless ttl is
+	// zero.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+
+	// Delete deletes the value of key, if any.
+	Delete(ctx context.Context, key string) error
+
+	// Lock acquires the lock named key for ttl, unless another client holds
+	// it already. The returned token releases the lock with Unlock.
+	Lock(ctx context.Context, key string, ttl time.Duration) (token string, acquired bool, err error)
+
+	// Unlock releases the lock named key if it is still held with token.
+	Unlock(ctx context.Context, key string, token string) error
+
+	// Close releases the resources of the backend.
+	Close() error
+}
+
+// NewBackend returns the backend configured in config.
+func NewBackend(config *BackendConfig) (Backend, error) {
+	if config == nil || config.Redis == nil {
+		return nil, fmt.Errorf("no inter-query cache backend configured")
+	}
+	return NewRedisBackend(*config.Redis)
+}
+
+// NewDistributedInterQueryCache returns a new inter-query cache like
+// NewInterQueryCacheWithContext, that shares its persistent values with other
+// OPA instances through backend. Values are looked up in memory first, then
+// in the backend, and are written to both.
+//
+// When several instances miss the same value at the same time, e.g., after a
+// deployment, only the first gets to compute it: the others wait for it to be
+// inserted, for up to the stampede lock TTL of the configuration. The backend
+// is closed when ctx is done.
+func NewDistributedInterQueryCache(ctx context.Context, config *Config, backend Backend, logger logging.Logger) InterQueryCache {
+	c := &distributedCache{
+		cache:   newCache(config),
+		backend: backend,
+		locks:   map[string]string{},
+		logger:  logger,
+	}
+
+	if b := config.InterQueryBuiltinCache.Backend; b != nil {
+		c.prefix = *b.KeyPrefix
+		c.timeout = time.Duration(*b.TimeoutMs) * time.Millisecond
+		c.lockTTL = time.Duration(*b.StampedeLockTTLMs) * time.Millisecond
+	} else {
+		c.prefix = defaultBackendKeyPrefix
+		c.timeout = time.Duration(defaultBackendTimeoutMs) * time.Millisecond
+		c.lockTTL = time.Duration(defaultStampedeLockTTLMs) * time.Millisecond
+	}
+
+	c.cache.startStaleEntryEviction(ctx)
+
+	go func() {
+		<-ctx.Done()
+		if err := backend.Close(); err != nil {
+			logger.Warn("Failed to close inter-query cache backend: %v.", err)
+		}
+	}()
+
+	return c
+}
+
+type distributedCache struct {
+	*cache
+	backend Backend
+	prefix  string
+	timeout time.Duration
+	lockTTL time.Duration
+	locks   map[string]string // tokens of the stampede locks held, by key
+	lmtx    sync.Mutex
+	logger  logging.Logger
+}
+
+// backendKey returns the key of the value of k in the backend. Keys are
+// hashed, as the keys of the inter-query cache can be arbitrarily large.
+func (c *distributedCache) backendKey(k ast.Value) string {
+	sum := sha256.Sum256([]byte(k.String()))
+	return c.prefix + backendValueKeyHashSeparator + hex.EncodeToString(sum[:])
+}
+
+func (c *distributedCache) Get(k ast.Value) (InterQueryCacheValue, bool) {
+	if v, ok := c.cache.Get(k); ok {
+		return v, true
+	}
+
+	key := c.backendKey(k)
+
+	if v, ok := c.fetch(k, key); ok {
+		return v, true
+	}
+
+	if c.lockTTL <= 0 {
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	token, acquired, err := c.backend.Lock(ctx, key+stampedeLockKeySuffix, c.lockTTL)
+	cancel()
+	if err != nil {
+		c.logger.Debug("Failed to lock inter-query cache value: %v.", err)
+		return nil, false
+	}
+
+	if acquired {
+		// The caller computes the value, the lock is released when it is
+		// inserted, or expires.
+		c.lmtx.Lock()
+		c.locks[key] = token
+		c.lmtx.Unlock()
+		return nil, false
+	}
+
+	deadline := time.Now().Add(c.lockTTL)
+	for time.Now().Before(deadline) {
+		time.Sleep(stampedeLockPollInterval)
+		if v, ok := c.fetch(k, key); ok {
+			return v, true
+		}
+	}
+
+	return nil, false
+}
+
+// fetch returns the value of k from the backend, and inserts it into memory.
+func (c *distributedCache) fetch(k ast.Value, key string) (InterQueryCacheValue, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	bs, ok, err := c.backend.Get(ctx, key)
+	if err != nil {
+		c.logger.Debug("Failed to get inter-query cache value from backend: %v.", err)
+		return nil, false
+	} else if !ok {
+		return nil, false
+	}
+
+	var record journalRecord
+	if err := json.Unmarshal(bs, &record); err != nil {
+		c.logger.Debug("Ignoring invalid inter-query cache value from backend: %v.", err)
+		return nil, false
+	}
+
+	var expiresAt time.Time
+	if record.ExpiresAt != nil {
+		expiresAt = *record.ExpiresAt
+	}
+
+	decode, ok := persistentDecoder(record.Type)
+	if !ok {
+		return nil, false
+	}
+
+	v, err := decode(record.Value)
+	if err != nil {
+		c.logger.Debug("Ignoring inter-query cache value of type %v from backend: %v.", record.Type, err)
+		return nil, false
+	}
+
+	c.cache.InsertWithExpiry(k, v, expiresAt)
+
+	return v, true
+}
+
+func (c *distributedCache) Insert(k ast.Value, v InterQueryCacheValue) int {
+	return c.InsertWithExpiry(k, v, time.Time{})
+}
+
+func (c *distributedCache) InsertWithExpiry(k ast.Value, v InterQueryCacheValue, expiresAt time.Time) int {
+	dropped := c.cache.InsertWithExpiry(k, v, expiresAt)
+
+	key := c.backendKey(k)
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	if pv, ok := v.(PersistentInterQueryCacheValue); ok {
+		if err := c.store(ctx, k, key, pv, expiresAt); err != nil {
+			c.logger.Debug("Failed to set inter-query cache value in backend: %v.", err)
+		}
+	}
+
+	c.lmtx.Lock()
+	token, ok := c.locks[key]
+	delete(c.locks, key)
+	c.lmtx.Unlock()
+
+	if ok {
+		if err := c.backend.Unlock(ctx, key+stampedeLockKeySuffix, token); err != nil {
+			c.logger.Debug("Failed to unlock inter-query cache value: %v.", err)
+		}
+	}
+
+	return dropped
+}
+
+func (c *distributedCache) store(ctx context.Context, k ast.Value, key string, v PersistentInterQueryCacheValue, expiresAt time.Time) error {
+	var ttl time.Duration
+	if !expiresAt.IsZero() {
+		ttl = time.Until(expiresAt)
+		if ttl <= 0 {
+			return nil
+		}
+	}
+
+	record, err := newJournalRecord(k, v, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	bs, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return c.backend.Set(ctx, key, bs, ttl)
+}
+
+func (c *distributedCache) Delete(k ast.Value) {
+	c.cache.Delete(k)
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	if err := c.backend.Delete(ctx, c.backendKey(k)); err != nil {
+		c.logger.Debug("Failed to delete inter-query cache value from backend: %v.", err)
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/logging"
+)
+
+// memoryBackend is a backend that keeps its values in memory, like Redis
+// would, and can be shared by several caches.
+type memoryBackend struct {
+	mtx    sync.Mutex
+	values map[string]memoryBackendValue
+	gets   int
+}
+
+type memoryBackendValue struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{values: map[string]memoryBackendValue{}}
+}
+
+func (b *memoryBackend) unsafeGet(key string) ([]byte, bool) {
+	v, ok := b.values[key]
+	if !ok || (!v.expiresAt.IsZero() && v.expiresAt.Before(time.Now())) {
+		return nil, false
+	}
+	return v.value, true
+}
+
+func (b *memoryBackend) Get(_ context.Context, key string) ([]byte, bool, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.gets++
+	v, ok := b.unsafeGet(key)
+	return v, ok, nil
+}
+
+func (b *memoryBackend) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	v := memoryBackendValue{value: value}
+	if ttl > 0 {
+		v.expiresAt = time.Now().Add(ttl)
+	}
+	b.values[key] = v
+	return nil
+}
+
+func (b *memoryBackend) Delete(_ context.Context, key string) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	delete(b.values, key)
+	return nil
+}
+
+func (b *memoryBackend) Lock(_ context.Context, key string, ttl time.Duration) (string, bool, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if _, ok := b.unsafeGet(key); ok {
+		return "", false, nil
+	}
+	token := fmt.Sprintf("token-%d", len(b.values))
+	b.values[key] = memoryBackendValue{value: []byte(token), expiresAt: time.Now().Add(ttl)}
+	return token, true, nil
+}
+
+func (b *memoryBackend) Unlock(_ context.Context, key string, token string) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if v, ok := b.unsafeGet(key); ok && string(v) == token {
+		delete(b.values, key)
+	}
+	return nil
+}
+
+func (*memoryBackend) Close() error {
+	return nil
+}
+
+func newDistributedCache(t *testing.T, config string, backend Backend) InterQueryCache {
+	t.Helper()
+
+	c, err := ParseCachingConfig([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	return NewDistributedInterQueryCache(ctx, c, backend, logging.NewNoOpLogger())
+}
+
+const testBackendConfig = `{"inter_query_builtin_cache": {"backend": {"redis": {"address": "localhost:6379"}, "stampede_lock_ttl_ms": %d}}}`
+
+func TestDistributedInterQueryCacheShared(t *testing.T) {
+	backend := newMemoryBackend()
+
+	c1 := newDistributedCache(t, fmt.Sprintf(testBackendConfig, 0), backend)
+	c2 := newDistributedCache(t, fmt.Sprintf(testBackendConfig, 0), backend)
+
+	c1.InsertWithExpiry(ast.String("a"), &testPersistentValue{data: []byte("a")}, time.Now().Add(time.Hour))
+	c1.Insert(ast.String("memory"), newInterQueryCacheValue(ast.String("b"), 1))
+
+	v, ok := c2.Get(ast.String("a"))
+	if !ok || string(v.(*testPersistentValue).data) != "a" {
+		t.Fatalf("Expected value inserted by other cache but got %v", v)
+	}
+
+	// The value is held in memory once fetched.
+	gets := backend.gets
+	if _, ok := c2.Get(ast.String("a")); !ok || backend.gets != gets {
+		t.Fatal("Expected value to be served from memory")
+	}
+
+	if _, ok := c2.Get(ast.String("memory")); ok {
+		t.Fatal("Expected values that are not persistent to stay in memory")
+	}
+
+	c1.Delete(ast.String("a"))
+	c3 := newDistributedCache(t, fmt.Sprintf(testBackendConfig, 0), backend)
+	if _, ok := c3.Get(ast.String("a")); ok {
+		t.Fatal("Expected deleted value to be deleted from backend")
+	}
+
+	c1.InsertWithExpiry(ast.String("expired"), &testPersistentValue{data: []byte("c")}, time.Now().Add(-time.Second))
+	if _, ok := c3.Get(ast.String("expired")); ok {
+		t.Fatal("Expected expired value not to be shared")
+	}
+}
+
+func TestDistributedInterQueryCacheStampede(t *testing.T) {
+	backend := newMemoryBackend()
+
+	const n = 5
+	var misses atomic.Int32
+	var wg sync.WaitGroup
+
+	for i := 0; i < n; i++ {
+		c := newDistributedCache(t, fmt.Sprintf(testBackendConfig, 5000), backend)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, ok := c.Get(ast.String("k"))
+			if !ok {
+				misses.Add(1)
+				// computing the value, e.g., sending the request
+				time.Sleep(50 * time.Millisecond)
+				c.Insert(ast.String("k"), &testPersistentValue{data: []byte("v")})
+				return
+			}
+			if string(v.(*testPersistentValue).data) != "v" {
+				t.Errorf("Expected value of other cache but got %v", v)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if m := misses.Load(); m != 1 {
+		t.Fatalf("Expected exactly one miss but got %d", m)
+	}
+
+	for k := range backend.values {
+		if strings.HasSuffix(k, stampedeLockKeySuffix) {
+			t.Fatalf("Expected lock to be released but found %v", k)
+		}
+	}
+}
+
+func TestDistributedInterQueryCacheStampedeLockExpiry(t *testing.T) {
+	backend := newMemoryBackend()
+
+	c1 := newDistributedCache(t, fmt.Sprintf(testBackendConfig, 100), backend)
+	c2 := newDistributedCache(t, fmt.Sprintf(testBackendConfig, 100), backend)
+
+	if _, ok := c1.Get(ast.String("k")); ok {
+		t.Fatal("Expected miss")
+	}
+
+	// c1 never inserts the value, c2 waits for the lock to expire at most.
+	start := time.Now()
+	if _, ok := c2.Get(ast.String("k")); ok {
+		t.Fatal("Expected miss")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Expected wait to be bounded by the lock TTL but waited %v", d)
+	}
+}
+
+func TestBackendConfigValidation(t *testing.T) {
+	tests := []struct {
+		config string
+		err    string
+	}{
+		{`{"inter_query_builtin_cache": {"backend": {}}}`, "redis must be configured"},
+		{`{"inter_query_builtin_cache": {"backend": {"redis": {}}}}`, "address must be set"},
+		{`{"inter_query_builtin_cache": {"backend": {"redis": {"address": "a:1", "username": "u"}}}}`, "username requires a password"},
+		{`{"inter_query_builtin_cache": {"backend": {"redis": {"address": "a:1"}, "timeout_ms": 0}}}`, "invalid timeout_ms 0"},
+		{`{"inter_query_builtin_cache": {"backend": {"redis": {"address": "a:1"}, "stampede_lock_ttl_ms": -1}}}`, "invalid stampede_lock_ttl_ms -1"},
+		{`{"inter_query_builtin_cache": {"persist": true, "backend": {"redis": {"address": "a:1"}}}}`, "mutually exclusive"},
+		{`{"inter_query_builtin_cache": {"backend": {"redis": {"address": "a:1", "tls": {"cert": "cert.pem"}}}}}`, "requires both cert and private_key"},
+		{`{"inter_query_builtin_cache": {"backend": {"redis": {"address": "a:1", "tls": {"ca_cert": "does-not-exist.pem"}}}}}`, "invalid inter_query_builtin_cache.backend.redis.tls"},
+	}
+
+	for _, tc := range tests {
+		_, err := ParseCachingConfig([]byte(tc.config))
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("Expected error containing %q for %v but got %v", tc.err, tc.config, err)
+		}
+	}
+
+	c, err := ParseCachingConfig([]byte(`{"inter_query_builtin_cache": {"backend": {"redis": {"address": "a:1"}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := c.InterQueryBuiltinCache.Backend
+	if *b.KeyPrefix != defaultBackendKeyPrefix || *b.TimeoutMs != defaultBackendTimeoutMs || *b.StampedeLockTTLMs != defaultStampedeLockTTLMs {
+		t.Fatalf("Expected defaults to be injected but got %+v", b)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"sync"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// InterQueryBaseCache defines the interface for the inter-query base document
+// cache. The cache holds the AST values of base documents read out of storage
+// so that the conversion from JSON is not repeated for every query. Entries
+// are keyed by storage path and tagged with the generation of the cache that
+// was current when they were read; invalidating the cache starts a new
+// generation. The cache must be invalidated whenever the data in the store
+// changes (see InvalidateOnCommit). Invalidating the cache is only sufficient
+// for stores that do not commit writes while reads are in progress (e.g., the
+// in-memory store without snapshot reads.) With other stores, a query reading
+// a snapshot that predates a commit may fill the cache after it was
+// invalidated, so a new cache must be used for each change of the store
+// instead.
+type InterQueryBaseCache interface {
+	// Generation returns the current generation of the cache. Callers should
+	// obtain the generation when the transaction they read with is opened.
+	Generation() uint64
+	// Get returns the cached value for ref or nil if ref is not cached or
+	// generation is not current. If a prefix of ref is cached, the value is
+	// looked up inside the cached value.
+	Get(generation uint64, ref ast.Ref) ast.Value
+	// Put caches value for ref. Values cached for refs prefixed by ref are
+	// replaced. The value is dropped if generation is not current, i.e., the
+	// value may have been read before the data in the store changed.
+	Put(generation uint64, ref ast.Ref, value ast.Value)
+	// Invalidate removes all values from the cache and starts a new
+	// generation.
+	Invalidate()
+}
+
+// NewInterQueryBaseCache returns a new inter-query base document cache.
+func NewInterQueryBaseCache() InterQueryBaseCache {
+	return &baseCache{root: newBaseCacheElem()}
+}
+
+type baseCache struct {
+	root       *baseCacheElem
+	generation uint64
+	mtx        sync.RWMutex
+}
+
+type baseCacheElem struct {
+	value    ast.Value
+	children map[ast.Value]*baseCacheElem
+}
+
+func newBaseCacheElem() *baseCacheElem {
+	return &baseCacheElem{children: map[ast.Value]*baseCacheElem{}}
+}
+
+func (c *baseCache) Generation() uint64 {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return c.generation
+}
+
+func (c *baseCache) Get(generation uint64, ref ast.Ref) ast.Value {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	if generation != c.generation {
+		return nil
+	}
+	node := c.root
+	for i := 0; i < len(ref); i++ {
+		node = node.children[ref[i].Value]
+		if node == nil {
+			return nil
+		} else if node.value != nil {
+			result, err := node.value.Find(ref[i+1:])
+			if err != nil {
+				return nil
+			}
+			return result
+		}
+	}
+	return nil
+}
+
+func (c *baseCache) Put(generation uint64, ref ast.Ref, value ast.Value) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if generation != c.generation {
+		return
+	}
+	node := c.root
+	for i := 0; i < len(ref); i++ {
+		child, ok := node.children[ref[i].Value]
+		if !ok {
+			child = newBaseCacheElem()
+			node.children[ref[i].Value] = child
+		}
+		node = child
+	}
+	node.value = value
+	node.children = map[ast.Value]*baseCacheElem{}
+}
+
+func (c *baseCache) Invalidate() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.root = newBaseCacheElem()
+	c.generation++
+}
@@ -0,0 +1,39 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+func TestInterQueryBaseCache(t *testing.T) {
+	c := NewInterQueryBaseCache()
+	gen := c.Generation()
+
+	c.Put(gen, ast.MustParseRef("data.a"), ast.MustParseTerm(`{"b": {"c": 1}}`).Value)
+
+	if value := c.Get(gen, ast.MustParseRef("data.a.b.c")); value == nil || value.Compare(ast.Number("1")) != 0 {
+		t.Fatalf("Expected data.a.b.c to be found in cached prefix but got %v", value)
+	}
+
+	if value := c.Get(gen, ast.MustParseRef("data.x")); value != nil {
+		t.Fatalf("Expected data.x not to be cached but got %v", value)
+	}
+
+	c.Invalidate()
+
+	if value := c.Get(gen, ast.MustParseRef("data.a")); value != nil {
+		t.Fatalf("Expected data.a to be invalidated but got %v", value)
+	}
+
+	// Values read before the cache was invalidated are dropped.
+	c.Put(gen, ast.MustParseRef("data.a"), ast.IntNumberTerm(1).Value)
+
+	if value := c.Get(c.Generation(), ast.MustParseRef("data.a")); value != nil {
+		t.Fatalf("Expected stale value to be dropped but got %v", value)
+	}
+}
@@ -26,16 +26,36 @@ const (
 // Config represents the configuration of the inter-query cache.
 type Config struct {
 	InterQueryBuiltinCache InterQueryBuiltinCacheConfig `json:"inter_query_builtin_cache"`
+	InterQueryRuleCache    InterQueryRuleCacheConfig    `json:"inter_query_rule_cache"`
+	InterQueryBaseCache    InterQueryBaseCacheConfig    `json:"inter_query_base_cache"`
 }
 
 // InterQueryBuiltinCacheConfig represents the configuration of the inter-query cache that built-in functions can utilize.
 // MaxSizeBytes - max capacity of cache in bytes
 // ForcedEvictionThresholdPercentage - capacity usage in percentage after which forced FIFO eviction starts
 // StaleEntryEvictionPeriodSeconds - time period between end of previous and start of new stale entry eviction routine
+// Persist - persists the values of the cache to disk, so that they survive restarts
+// Backend - shares the values of the cache with other OPA instances through a backend like Redis
 type InterQueryBuiltinCacheConfig struct {
-	MaxSizeBytes                      *int64 `json:"max_size_bytes,omitempty"`
-	ForcedEvictionThresholdPercentage *int64 `json:"forced_eviction_threshold_percentage,omitempty"`
-	StaleEntryEvictionPeriodSeconds   *int64 `json:"stale_entry_eviction_period_seconds,omitempty"`
+	MaxSizeBytes                      *int64         `json:"max_size_bytes,omitempty"`
+	ForcedEvictionThresholdPercentage *int64         `json:"forced_eviction_threshold_percentage,omitempty"`
+	StaleEntryEvictionPeriodSeconds   *int64         `json:"stale_entry_eviction_period_seconds,omitempty"`
+	Persist                           bool           `json:"persist,omitempty"`
+	Backend                           *BackendConfig `json:"backend,omitempty"`
+}
+
+// InterQueryRuleCacheConfig represents the configuration of the inter-query cache that holds rule values.
+// Enabled - enables caching of rule values across queries
+// MaxNumEntries - max number of rule values to cache, a zero or unset value means unlimited
+type InterQueryRuleCacheConfig struct {
+	Enabled       bool `json:"enabled,omitempty"`
+	MaxNumEntries *int `json:"max_num_entries,omitempty"`
+}
+
+// InterQueryBaseCacheConfig represents the configuration of the inter-query cache that holds base documents.
+// Enabled - enables caching of base documents read out of storage across queries
+type InterQueryBaseCacheConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
 }
 
 // ParseCachingConfig returns the config for the inter-query cache.
@@ -89,6 +109,17 @@ func (c *Config) validateAndInjectDefaults() error {
 			return fmt.Errorf("invalid stale_entry_eviction_period_seconds %v", period)
 		}
 	}
+	if b := c.InterQueryBuiltinCache.Backend; b != nil {
+		if c.InterQueryBuiltinCache.Persist {
+			return fmt.Errorf("invalid inter_query_builtin_cache, persist and backend are mutually exclusive")
+		}
+		if err := b.validateAndInjectDefaults(); err != nil {
+			return err
+		}
+	}
+	if n := c.InterQueryRuleCache.MaxNumEntries; n != nil && *n < 0 {
+		return fmt.Errorf("invalid max_num_entries %v", *n)
+	}
 	return nil
 }
 
@@ -128,26 +159,32 @@ func NewInterQueryCache(config *Config) InterQueryCache {
 //	config - to configure the InterQueryCache
 func NewInterQueryCacheWithContext(ctx context.Context, config *Config) InterQueryCache {
 	iqCache := newCache(config)
-	if iqCache.staleEntryEvictionTimePeriodSeconds() > 0 {
-		cleanupTicker := time.NewTicker(time.Duration(iqCache.staleEntryEvictionTimePeriodSeconds()) * time.Second)
-		go func() {
-			for {
-				select {
-				case <-cleanupTicker.C:
-					cleanupTicker.Stop()
-					iqCache.cleanStaleValues()
-					cleanupTicker = time.NewTicker(time.Duration(iqCache.staleEntryEvictionTimePeriodSeconds()) * time.Second)
-				case <-ctx.Done():
-					cleanupTicker.Stop()
-					return
-				}
-			}
-		}()
-	}
-
+	iqCache.startStaleEntryEviction(ctx)
 	return iqCache
 }
 
+// startStaleEntryEviction starts the periodic cleanup routine of the stale
+// entries, if configured, until ctx is done.
+func (c *cache) startStaleEntryEviction(ctx context.Context) {
+	if c.staleEntryEvictionTimePeriodSeconds() <= 0 {
+		return
+	}
+	cleanupTicker := time.NewTicker(time.Duration(c.staleEntryEvictionTimePeriodSeconds()) * time.Second)
+	go func() {
+		for {
+			select {
+			case <-cleanupTicker.C:
+				cleanupTicker.Stop()
+				c.cleanStaleValues()
+				cleanupTicker = time.NewTicker(time.Duration(c.staleEntryEvictionTimePeriodSeconds()) * time.Second)
+			case <-ctx.Done():
+				cleanupTicker.Stop()
+				return
+			}
+		}
+	}()
+}
+
 type cacheItem struct {
 	value      InterQueryCacheValue
 	expiresAt  time.Time
@@ -155,11 +192,12 @@ type cacheItem struct {
 }
 
 type cache struct {
-	items  map[string]cacheItem
-	usage  int64
-	config *Config
-	l      *list.List
-	mtx    sync.Mutex
+	items   map[string]cacheItem
+	usage   int64
+	config  *Config
+	l       *list.List
+	journal *journal // nil unless the cache is persistent
+	mtx     sync.Mutex
 }
 
 func newCache(config *Config) *cache {
@@ -243,6 +281,7 @@ func (c *cache) unsafeInsert(k ast.Value, v InterQueryCacheValue, expiresAt time
 		keyElement: c.l.PushBack(k),
 	}
 	c.usage += size
+	c.persist(k, v, expiresAt)
 	return dropped
 }
 
@@ -260,6 +299,7 @@ func (c *cache) unsafeDelete(k ast.Value) {
 	c.usage -= cacheItem.value.SizeInBytes()
 	delete(c.items, k.String())
 	c.l.Remove(cacheItem.keyElement)
+	c.unpersist(k)
 }
 
 func (c *cache) unsafeClone(value InterQueryCacheValue) (InterQueryCacheValue, error) {
@@ -0,0 +1,353 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/logging"
+)
+
+const (
+	journalFileName = "inter_query_builtin_cache.jsonl"
+
+	// The journal is compacted when it holds more than journalCompactionRatio
+	// records per entry of the cache, and at least journalCompactionMinRecords.
+	journalCompactionRatio      = 2
+	journalCompactionMinRecords = 1000
+)
+
+// PersistentInterQueryCacheValue is implemented by the values of the inter-query
+// cache that can be persisted to disk. Other values are only held in memory.
+type PersistentInterQueryCacheValue interface {
+	InterQueryCacheValue
+
+	// PersistentType returns the name of the type the value is decoded with,
+	// as registered with RegisterPersistentType.
+	PersistentType() string
+
+	// MarshalPersistent returns the encoding of the value that is passed to
+	// the decoder of its type.
+	MarshalPersistent() ([]byte, error)
+}
+
+var persistentTypes = struct {
+	sync.RWMutex
+	decoders map[string]func([]byte) (InterQueryCacheValue, error)
+}{decoders: map[string]func([]byte) (InterQueryCacheValue, error){}}
+
+// RegisterPersistentType registers the decoder of the persisted values of the
+// named type. Persisted values of unregistered types are dropped when the cache
+// is loaded.
+func RegisterPersistentType(name string, decode func([]byte) (InterQueryCacheValue, error)) {
+	persistentTypes.Lock()
+	defer persistentTypes.Unlock()
+	persistentTypes.decoders[name] = decode
+}
+
+func persistentDecoder(name string) (func([]byte) (InterQueryCacheValue, error), bool) {
+	persistentTypes.RLock()
+	defer persistentTypes.RUnlock()
+	decode, ok := persistentTypes.decoders[name]
+	return decode, ok
+}
+
+// NewPersistentInterQueryCache returns a new inter-query cache like
+// NewInterQueryCacheWithContext, whose persistent values are also written to a
+// journal in dir, so that they survive restarts.
+//
+// The journal is loaded when the cache is created: the values that expired
+// in the meantime, or cannot be decoded anymore, are dropped, and the size
+// limits of the configuration are applied again. The journal is then
+// rewritten with the remaining values, and compacted the same way whenever it
+// mostly holds outdated records. It is closed when ctx is done.
+func NewPersistentInterQueryCache(ctx context.Context, config *Config, dir string, logger logging.Logger) (InterQueryCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("create inter-query cache directory: %w", err)
+	}
+
+	c := newCache(config)
+	path := filepath.Join(dir, journalFileName)
+
+	loaded, dropped, err := c.load(path, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	c.journal = &journal{path: path, logger: logger}
+	if err := c.unsafeCompact(); err != nil {
+		return nil, err
+	}
+
+	logger.Debug("Loaded %d values of the inter-query cache from %v, dropped %d.", loaded, path, dropped)
+
+	c.startStaleEntryEviction(ctx)
+
+	go func() {
+		<-ctx.Done()
+		c.mtx.Lock()
+		defer c.mtx.Unlock()
+		c.journal.close()
+		c.journal = nil
+	}()
+
+	return c, nil
+}
+
+// journalRecord is a line of the journal. Records without a type delete the
+// value of their key.
+type journalRecord struct {
+	Key       string     `json:"key"`
+	Type      string     `json:"type,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Value     []byte     `json:"value,omitempty"`
+}
+
+func newJournalRecord(k ast.Value, v PersistentInterQueryCacheValue, expiresAt time.Time) (*journalRecord, error) {
+	bs, err := v.MarshalPersistent()
+	if err != nil {
+		return nil, err
+	}
+	r := journalRecord{Key: k.String(), Type: v.PersistentType(), Value: bs}
+	if !expiresAt.IsZero() {
+		r.ExpiresAt = &expiresAt
+	}
+	return &r, nil
+}
+
+// journal appends the changes of the persistent values of a cache to a file.
+// Write errors disable the journal, the cache itself keeps working.
+type journal struct {
+	path    string
+	file    *os.File
+	records int
+	live    map[string]struct{}
+	logger  logging.Logger
+}
+
+func (j *journal) put(k ast.Value, v PersistentInterQueryCacheValue, expiresAt time.Time) {
+	r, err := newJournalRecord(k, v, expiresAt)
+	if err != nil {
+		j.logger.Warn("Failed to persist inter-query cache value: %v.", err)
+		return
+	}
+	if j.write(r) {
+		j.live[r.Key] = struct{}{}
+	}
+}
+
+func (j *journal) delete(k ast.Value) {
+	key := k.String()
+	if _, ok := j.live[key]; !ok {
+		return
+	}
+	if j.write(&journalRecord{Key: key}) {
+		delete(j.live, key)
+	}
+}
+
+func (j *journal) write(r *journalRecord) bool {
+	if j.file == nil {
+		return false
+	}
+	bs, err := json.Marshal(r)
+	if err != nil {
+		j.logger.Warn("Failed to persist inter-query cache value: %v.", err)
+		return false
+	}
+	if _, err := j.file.Write(append(bs, '\n')); err != nil {
+		j.logger.Error("Failed to write inter-query cache journal, values are not persisted anymore: %v.", err)
+		j.close()
+		return false
+	}
+	j.records++
+	return true
+}
+
+func (j *journal) needsCompaction() bool {
+	return j.file != nil && j.records >= journalCompactionMinRecords && j.records > journalCompactionRatio*len(j.live)
+}
+
+func (j *journal) close() {
+	if j.file != nil {
+		_ = j.file.Close()
+		j.file = nil
+	}
+}
+
+// persist records the insertion of v into the cache, if it is persistent.
+func (c *cache) persist(k ast.Value, v InterQueryCacheValue, expiresAt time.Time) {
+	if c.journal == nil {
+		return
+	}
+	if pv, ok := v.(PersistentInterQueryCacheValue); ok {
+		c.journal.put(k, pv, expiresAt)
+	}
+	if c.journal.needsCompaction() {
+		if err := c.unsafeCompact(); err != nil {
+			c.journal.logger.Error("Failed to compact inter-query cache journal, values are not persisted anymore: %v.", err)
+			c.journal.close()
+		}
+	}
+}
+
+// unpersist records the deletion of the value of k from the cache.
+func (c *cache) unpersist(k ast.Value) {
+	if c.journal != nil {
+		c.journal.delete(k)
+	}
+}
+
+// unsafeCompact replaces the journal with a new one that holds exactly the
+// persistent values of the cache, in insertion order.
+func (c *cache) unsafeCompact() error {
+	j := c.journal
+	j.close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), journalFileName+".*")
+	if err != nil {
+		return fmt.Errorf("compact inter-query cache journal: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	live := map[string]struct{}{}
+
+	for e := c.l.Front(); e != nil; e = e.Next() {
+		k := e.Value.(ast.Value)
+		item := c.items[k.String()]
+		v, ok := item.value.(PersistentInterQueryCacheValue)
+		if !ok {
+			continue
+		}
+		r, err := newJournalRecord(k, v, item.expiresAt)
+		if err != nil {
+			j.logger.Warn("Failed to persist inter-query cache value: %v.", err)
+			continue
+		}
+		if err := enc.Encode(r); err != nil {
+			_ = tmp.Close()
+			return fmt.Errorf("compact inter-query cache journal: %w", err)
+		}
+		live[r.Key] = struct{}{}
+	}
+
+	err = w.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), j.path)
+	}
+	if err != nil {
+		return fmt.Errorf("compact inter-query cache journal: %w", err)
+	}
+
+	j.file, err = os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("open inter-query cache journal: %w", err)
+	}
+	j.records = len(live)
+	j.live = live
+	return nil
+}
+
+// load inserts the values of the journal at path into the cache, in the
+// order they were last inserted. Values that expired, or whose type is not
+// registered or cannot be decoded, are dropped. A truncated or corrupt record
+// ends the journal, e.g., after a crash during a write.
+func (c *cache) load(path string, logger logging.Logger) (loaded, dropped int, err error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, 0, nil
+	} else if err != nil {
+		return 0, 0, fmt.Errorf("open inter-query cache journal: %w", err)
+	}
+	defer f.Close()
+
+	order := list.New()
+	records := map[string]*list.Element{}
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				logger.Warn("Ignoring truncated record at the end of inter-query cache journal %v.", path)
+			}
+			break
+		} else if err != nil {
+			return 0, 0, fmt.Errorf("read inter-query cache journal: %w", err)
+		}
+
+		var record journalRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			logger.Warn("Ignoring corrupt records at the end of inter-query cache journal %v: %v.", path, err)
+			break
+		}
+
+		if e, ok := records[record.Key]; ok {
+			order.Remove(e)
+			delete(records, record.Key)
+		}
+		if record.Type != "" {
+			records[record.Key] = order.PushBack(&record)
+		}
+	}
+
+	now := time.Now()
+
+	for e := order.Front(); e != nil; e = e.Next() {
+		record := e.Value.(*journalRecord)
+
+		var expiresAt time.Time
+		if record.ExpiresAt != nil {
+			expiresAt = *record.ExpiresAt
+			if expiresAt.Before(now) {
+				dropped++
+				continue
+			}
+		}
+
+		decode, ok := persistentDecoder(record.Type)
+		if !ok {
+			dropped++
+			continue
+		}
+
+		v, err := decode(record.Value)
+		if err != nil {
+			logger.Debug("Dropping inter-query cache value of type %v: %v.", record.Type, err)
+			dropped++
+			continue
+		}
+
+		k, err := ast.ParseTerm(record.Key)
+		if err != nil {
+			logger.Debug("Dropping inter-query cache value with invalid key: %v.", err)
+			dropped++
+			continue
+		}
+
+		dropped += c.unsafeInsert(k.Value, v, expiresAt)
+	}
+
+	return len(c.items), dropped, nil
+}
@@ -0,0 +1,189 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/logging"
+)
+
+const testPersistentType = "test"
+
+type testPersistentValue struct {
+	data []byte
+}
+
+func (p testPersistentValue) SizeInBytes() int64 {
+	return int64(len(p.data))
+}
+
+func (p testPersistentValue) Clone() (InterQueryCacheValue, error) {
+	return &testPersistentValue{data: append([]byte(nil), p.data...)}, nil
+}
+
+func (testPersistentValue) PersistentType() string {
+	return testPersistentType
+}
+
+func (p testPersistentValue) MarshalPersistent() ([]byte, error) {
+	return p.data, nil
+}
+
+func init() {
+	RegisterPersistentType(testPersistentType, func(bs []byte) (InterQueryCacheValue, error) {
+		return &testPersistentValue{data: bs}, nil
+	})
+}
+
+func openPersistentCache(t *testing.T, config string, dir string) (*cache, context.CancelFunc) {
+	t.Helper()
+
+	c, err := ParseCachingConfig([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	iqc, err := NewPersistentInterQueryCache(ctx, c, dir, logging.NewNoOpLogger())
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+
+	// wait for the journal to be closed, so that the next cache does not
+	// share it
+	return iqc.(*cache), func() {
+		cancel()
+		for {
+			iqc.(*cache).mtx.Lock()
+			closed := iqc.(*cache).journal == nil
+			iqc.(*cache).mtx.Unlock()
+			if closed {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func TestPersistentInterQueryCacheRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	c, closeCache := openPersistentCache(t, `{"inter_query_builtin_cache": {}}`, dir)
+
+	c.Insert(ast.String("kept"), &testPersistentValue{data: []byte("a")})
+	c.InsertWithExpiry(ast.String("fresh"), &testPersistentValue{data: []byte("b")}, time.Now().Add(time.Hour))
+	c.InsertWithExpiry(ast.String("expired"), &testPersistentValue{data: []byte("c")}, time.Now().Add(-time.Second))
+	c.Insert(ast.String("deleted"), &testPersistentValue{data: []byte("d")})
+	c.Delete(ast.String("deleted"))
+	c.Insert(ast.String("memory"), newInterQueryCacheValue(ast.String("e"), 1))
+	c.Insert(ast.MustParseTerm(`{"url": "https://example.com", "method": "get"}`).Value, &testPersistentValue{data: []byte("f")})
+
+	closeCache()
+
+	c, closeCache = openPersistentCache(t, `{"inter_query_builtin_cache": {}}`, dir)
+	defer closeCache()
+
+	exp := map[string]string{
+		`"kept"`:  "a",
+		`"fresh"`: "b",
+		`{"method": "get", "url": "https://example.com"}`: "f",
+	}
+
+	if len(c.items) != len(exp) {
+		t.Fatalf("Expected %d values but got %d", len(exp), len(c.items))
+	}
+
+	for k, v := range exp {
+		x, ok := c.Get(ast.MustParseTerm(k).Value)
+		if !ok {
+			t.Fatalf("Expected value of %v to be loaded", k)
+		}
+		if string(x.(*testPersistentValue).data) != v {
+			t.Fatalf("Expected value of %v to be %q but got %q", k, v, x.(*testPersistentValue).data)
+		}
+	}
+
+	if c.items[`"fresh"`].expiresAt.IsZero() {
+		t.Fatal("Expected expiry of loaded value to be kept")
+	}
+}
+
+func TestPersistentInterQueryCacheSizeLimit(t *testing.T) {
+	dir := t.TempDir()
+
+	c, closeCache := openPersistentCache(t, `{"inter_query_builtin_cache": {}}`, dir)
+	for _, k := range []string{"a", "b", "c", "d"} {
+		c.Insert(ast.String(k), &testPersistentValue{data: []byte("xx")})
+	}
+	closeCache()
+
+	// The oldest values are dropped when the cache is loaded with a smaller
+	// limit, and the journal is rewritten without them.
+	c, closeCache = openPersistentCache(t, `{"inter_query_builtin_cache": {"max_size_bytes": 4}}`, dir)
+	closeCache()
+
+	if _, ok := c.Get(ast.String("b")); ok || len(c.items) != 2 {
+		t.Fatalf("Expected only the last two values to be loaded but got %v", c.items)
+	}
+
+	bs, err := os.ReadFile(filepath.Join(dir, journalFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(bs, []byte("\n")); n != 2 {
+		t.Fatalf("Expected 2 records in journal but got %d", n)
+	}
+}
+
+func TestPersistentInterQueryCacheCompaction(t *testing.T) {
+	dir := t.TempDir()
+
+	c, closeCache := openPersistentCache(t, `{"inter_query_builtin_cache": {}}`, dir)
+	defer closeCache()
+
+	for i := 0; i < journalCompactionMinRecords; i++ {
+		c.Insert(ast.String("k"), &testPersistentValue{data: []byte("v")})
+	}
+
+	c.mtx.Lock()
+	records := c.journal.records
+	c.mtx.Unlock()
+
+	if records >= journalCompactionMinRecords {
+		t.Fatalf("Expected journal to be compacted but it holds %d records", records)
+	}
+}
+
+func TestPersistentInterQueryCacheTruncatedJournal(t *testing.T) {
+	dir := t.TempDir()
+
+	c, closeCache := openPersistentCache(t, `{"inter_query_builtin_cache": {}}`, dir)
+	c.Insert(ast.String("a"), &testPersistentValue{data: []byte("a")})
+	closeCache()
+
+	f, err := os.OpenFile(filepath.Join(dir, journalFileName), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"key": "\"b\"", "type": "te`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	c, closeCache = openPersistentCache(t, `{"inter_query_builtin_cache": {}}`, dir)
+	defer closeCache()
+
+	if _, ok := c.Get(ast.String("a")); !ok || len(c.items) != 1 {
+		t.Fatalf("Expected only the complete record to be loaded but got %v", c.items)
+	}
+}
@@ -0,0 +1,387 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRedisMaxIdleConns = 8
+
+	// redisUnlockScript deletes the lock only if it still holds the token of
+	// the caller, i.e., it did not expire and was acquired by another client
+	// in the meantime.
+	redisUnlockScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
+)
+
+// RedisConfig represents the configuration of a Redis server used as backend
+// of the inter-query cache.
+// Address - host and port of the server
+// Username - username to authenticate with, requires a password
+// Password - password to authenticate with
+// DB - number of the database to use
+// MaxIdleConns - maximum number of idle connections kept open to the server
+// TLS - TLS configuration of the connections, if set
+type RedisConfig struct {
+	Address      string          `json:"address"`
+	Username     string          `json:"username,omitempty"`
+	Password     string          `json:"password,omitempty"`
+	DB           int             `json:"db,omitempty"`
+	MaxIdleConns *int            `json:"max_idle_conns,omitempty"`
+	TLS          *RedisTLSConfig `json:"tls,omitempty"`
+}
+
+// RedisTLSConfig represents the TLS configuration for connecting to a Redis
+// server.
+// CACert - path of a CA certificate used to verify the server
+// Cert - path of a client certificate presented to the server
+// PrivateKey - path of the private key of the client certificate
+// ServerName - name used to verify the server certificate, defaults to the host of the address
+// InsecureSkipVerify - skip verification of the server certificate
+type RedisTLSConfig struct {
+	CACert             string `json:"ca_cert,omitempty"`
+	Cert               string `json:"cert,omitempty"`
+	PrivateKey         string `json:"private_key,omitempty"`
+	ServerName         string `json:"server_name,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+}
+
+func (c *RedisTLSConfig) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{ServerName: c.ServerName, InsecureSkipVerify: c.InsecureSkipVerify} // #nosec G402
+
+	if c.CACert != "" {
+		caCert, err := os.ReadFile(c.CACert)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, errors.New("unable to parse and append CA certificate to certificate pool")
+		}
+		config.RootCAs = pool
+	}
+
+	if c.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(c.Cert, c.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+func (c *RedisConfig) validate() error {
+	if c.Address == "" {
+		return fmt.Errorf("invalid inter_query_builtin_cache.backend.redis, address must be set")
+	}
+	if c.Username != "" && c.Password == "" {
+		return fmt.Errorf("invalid inter_query_builtin_cache.backend.redis, username requires a password")
+	}
+	if c.DB < 0 {
+		return fmt.Errorf("invalid db %v", c.DB)
+	}
+	if c.MaxIdleConns != nil && *c.MaxIdleConns < 0 {
+		return fmt.Errorf("invalid max_idle_conns %v", *c.MaxIdleConns)
+	}
+	if c.TLS != nil {
+		if (c.TLS.Cert == "") != (c.TLS.PrivateKey == "") {
+			return fmt.Errorf("invalid inter_query_builtin_cache.backend.redis.tls, requires both cert and private_key")
+		}
+		if _, err := c.TLS.tlsConfig(); err != nil {
+			return fmt.Errorf("invalid inter_query_builtin_cache.backend.redis.tls: %w", err)
+		}
+	}
+	return nil
+}
+
+// NewRedisBackend returns a backend that stores the values of the inter-query
+// cache in the Redis server of config. Connections are opened on demand, and
+// kept open for reuse.
+func NewRedisBackend(config RedisConfig) (Backend, error) {
+	maxIdle := defaultRedisMaxIdleConns
+	if config.MaxIdleConns != nil {
+		maxIdle = *config.MaxIdleConns
+	}
+
+	var tlsConfig *tls.Config
+	if config.TLS != nil {
+		var err error
+		tlsConfig, err = config.TLS.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &redisBackend{config: config, tls: tlsConfig, maxIdle: maxIdle}, nil
+}
+
+type redisBackend struct {
+	config  RedisConfig
+	tls     *tls.Config // nil if TLS is not used
+	maxIdle int
+	mtx     sync.Mutex
+	idle    []*redisConn
+	closed  bool
+}
+
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// errRedisNil is the reply of Redis for missing values.
+var errRedisNil = errors.New("redis: nil")
+
+// redisError is an error reply of Redis.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+func (b *redisBackend) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	reply, err := b.do(ctx, "GET", key)
+	if errors.Is(err, errRedisNil) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	bs, ok := reply.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("redis: unexpected reply to GET: %v", reply)
+	}
+	return bs, true, nil
+}
+
+func (b *redisBackend) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	args := []string{"SET", key, string(value)}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(ttlMillis(ttl), 10))
+	}
+	_, err := b.do(ctx, args...)
+	return err
+}
+
+func (b *redisBackend) Delete(ctx context.Context, key string) error {
+	_, err := b.do(ctx, "DEL", key)
+	return err
+}
+
+func (b *redisBackend) Lock(ctx context.Context, key string, ttl time.Duration) (string, bool, error) {
+	var bs [16]byte
+	if _, err := rand.Read(bs[:]); err != nil {
+		return "", false, err
+	}
+	token := hex.EncodeToString(bs[:])
+
+	_, err := b.do(ctx, "SET", key, token, "NX", "PX", strconv.FormatInt(ttlMillis(ttl), 10))
+	if errors.Is(err, errRedisNil) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	return token, true, nil
+}
+
+func (b *redisBackend) Unlock(ctx context.Context, key string, token string) error {
+	_, err := b.do(ctx, "EVAL", redisUnlockScript, "1", key, token)
+	return err
+}
+
+func (b *redisBackend) Close() error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.closed = true
+	var err error
+	for _, c := range b.idle {
+		if cerr := c.conn.Close(); err == nil {
+			err = cerr
+		}
+	}
+	b.idle = nil
+	return err
+}
+
+// do sends the command args to the server, and returns its reply. Error
+// replies are returned as errors.
+func (b *redisBackend) do(ctx context.Context, args ...string) (interface{}, error) {
+	c, err := b.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = c.conn.SetDeadline(deadline)
+	} else {
+		_ = c.conn.SetDeadline(time.Time{})
+	}
+
+	reply, err := c.do(args...)
+
+	var rerr redisError
+	if err != nil && !errors.Is(err, errRedisNil) && !errors.As(err, &rerr) {
+		// The state of the connection is unknown after I/O errors.
+		_ = c.conn.Close()
+		return nil, err
+	}
+
+	b.release(c)
+	return reply, err
+}
+
+func (b *redisBackend) conn(ctx context.Context) (*redisConn, error) {
+	b.mtx.Lock()
+	if b.closed {
+		b.mtx.Unlock()
+		return nil, fmt.Errorf("redis: backend closed")
+	}
+	if n := len(b.idle); n > 0 {
+		c := b.idle[n-1]
+		b.idle = b.idle[:n-1]
+		b.mtx.Unlock()
+		return c, nil
+	}
+	b.mtx.Unlock()
+
+	var conn net.Conn
+	var err error
+	if b.tls != nil {
+		d := tls.Dialer{Config: b.tls}
+		conn, err = d.DialContext(ctx, "tcp", b.config.Address)
+	} else {
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, "tcp", b.config.Address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if b.config.Password != "" {
+		args := []string{"AUTH", b.config.Password}
+		if b.config.Username != "" {
+			args = []string{"AUTH", b.config.Username, b.config.Password}
+		}
+		if _, err := c.do(args...); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	if b.config.DB != 0 {
+		if _, err := c.do("SELECT", strconv.Itoa(b.config.DB)); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func (b *redisBackend) release(c *redisConn) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.closed || len(b.idle) >= b.maxIdle {
+		_ = c.conn.Close()
+		return
+	}
+	b.idle = append(b.idle, c)
+}
+
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	return readRedisReply(c.r)
+}
+
+// readRedisReply reads a reply of the RESP2 protocol: simple strings and
+// errors, integers, bulk strings and arrays.
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: invalid reply %q", line)
+	}
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid reply %q", line)
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		bs := make([]byte, n+2)
+		if _, err := io.ReadFull(r, bs); err != nil {
+			return nil, err
+		}
+		return bs[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid reply %q", line)
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		xs := make([]interface{}, n)
+		for i := range xs {
+			x, err := readRedisReply(r)
+			if err != nil && !errors.Is(err, errRedisNil) {
+				return nil, err
+			}
+			xs[i] = x
+		}
+		return xs, nil
+	}
+
+	return nil, fmt.Errorf("redis: invalid reply %q", line)
+}
+
+// ttlMillis rounds ttl up to milliseconds, as Redis rejects zero TTLs.
+func ttlMillis(ttl time.Duration) int64 {
+	ms := int64((ttl + time.Millisecond - 1) / time.Millisecond)
+	if ms < 1 {
+		ms = 1
+	}
+	return ms
+}
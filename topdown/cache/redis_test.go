@@ -0,0 +1,342 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis serves the subset of the Redis protocol used by the backend.
+type fakeRedis struct {
+	ln       net.Listener
+	mtx      sync.Mutex
+	values   map[string]string
+	ttls     map[string]string
+	password string
+	commands []string
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+
+	return newFakeRedisTLS(t, password, nil)
+}
+
+// newFakeRedisTLS is like newFakeRedis but serves TLS with config, unless
+// config is nil.
+func newFakeRedisTLS(t *testing.T, password string, config *tls.Config) *fakeRedis {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config != nil {
+		ln = tls.NewListener(ln, config)
+	}
+
+	s := &fakeRedis{ln: ln, values: map[string]string{}, ttls: map[string]string{}, password: password}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	authenticated := s.password == ""
+
+	for {
+		x, err := readRedisReply(r)
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, arg := range x.([]interface{}) {
+			args = append(args, string(arg.([]byte)))
+		}
+
+		s.mtx.Lock()
+		s.commands = append(s.commands, args[0])
+
+		var reply string
+		switch {
+		case args[0] == "AUTH":
+			if args[len(args)-1] == s.password {
+				authenticated = true
+				reply = "+OK\r\n"
+			} else {
+				reply = "-WRONGPASS invalid password\r\n"
+			}
+		case !authenticated:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case args[0] == "SELECT":
+			reply = "+OK\r\n"
+		case args[0] == "GET":
+			if v, ok := s.values[args[1]]; ok {
+				reply = fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+			} else {
+				reply = "$-1\r\n"
+			}
+		case args[0] == "SET":
+			_, exists := s.values[args[1]]
+			nx := len(args) > 3 && args[3] == "NX"
+			if nx && exists {
+				reply = "$-1\r\n"
+				break
+			}
+			s.values[args[1]] = args[2]
+			delete(s.ttls, args[1])
+			for i := 3; i < len(args)-1; i++ {
+				if args[i] == "PX" {
+					s.ttls[args[1]] = args[i+1]
+				}
+			}
+			reply = "+OK\r\n"
+		case args[0] == "DEL":
+			_, ok := s.values[args[1]]
+			delete(s.values, args[1])
+			if ok {
+				reply = ":1\r\n"
+			} else {
+				reply = ":0\r\n"
+			}
+		case args[0] == "EVAL":
+			if s.values[args[3]] == args[4] {
+				delete(s.values, args[3])
+				reply = ":1\r\n"
+			} else {
+				reply = ":0\r\n"
+			}
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+		s.mtx.Unlock()
+
+		if _, err := conn.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
+
+func (s *fakeRedis) lookup(m map[string]string, key string) (string, bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	v, ok := m[key]
+	return v, ok
+}
+
+func TestRedisBackend(t *testing.T) {
+	s := newFakeRedis(t, "secret")
+	b, err := NewRedisBackend(RedisConfig{Address: s.ln.Addr().String(), Password: "secret", DB: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, ok, err := b.Get(ctx, "k"); err != nil || ok {
+		t.Fatalf("Expected miss but got %v, %v", ok, err)
+	}
+
+	if err := b.Set(ctx, "k", []byte("v\r\nwith newline"), 1500*time.Microsecond); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, _ := s.lookup(s.ttls, "k"); ttl != "2" {
+		t.Fatalf("Expected TTL to be rounded up to 2ms but got %q", ttl)
+	}
+
+	v, ok, err := b.Get(ctx, "k")
+	if err != nil || !ok || string(v) != "v\r\nwith newline" {
+		t.Fatalf("Expected value but got %q, %v, %v", v, ok, err)
+	}
+
+	if err := b.Delete(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := b.Get(ctx, "k"); ok {
+		t.Fatal("Expected value to be deleted")
+	}
+
+	token, ok, err := b.Lock(ctx, "lock", time.Second)
+	if err != nil || !ok {
+		t.Fatalf("Expected lock to be acquired but got %v, %v", ok, err)
+	}
+	if _, ok, err := b.Lock(ctx, "lock", time.Second); err != nil || ok {
+		t.Fatalf("Expected lock to be held but got %v, %v", ok, err)
+	}
+	if err := b.Unlock(ctx, "lock", "other"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.lookup(s.values, "lock"); !ok {
+		t.Fatal("Expected lock not to be released with other token")
+	}
+	if err := b.Unlock(ctx, "lock", token); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.lookup(s.values, "lock"); ok {
+		t.Fatal("Expected lock to be released")
+	}
+
+	// The connection is reused, so the handshake happens once.
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if got := strings.Join(s.commands[:2], " "); got != "AUTH SELECT" {
+		t.Fatalf("Expected handshake but got %v", got)
+	}
+	for _, c := range s.commands[2:] {
+		if c == "AUTH" {
+			t.Fatalf("Expected connection to be reused but got %v", s.commands)
+		}
+	}
+}
+
+func TestRedisBackendErrors(t *testing.T) {
+	s := newFakeRedis(t, "secret")
+
+	b, err := NewRedisBackend(RedisConfig{Address: s.ln.Addr().String(), Password: "wrong"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if _, _, err := b.Get(context.Background(), "k"); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Fatalf("Expected authentication error but got %v", err)
+	}
+
+	b.Close()
+	if _, _, err := b.Get(context.Background(), "k"); err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Fatalf("Expected closed error but got %v", err)
+	}
+}
+
+func TestRedisBackendTLS(t *testing.T) {
+	dir := t.TempDir()
+
+	serverCert, serverKey := generateCert(t, "redis.test")
+	clientCert, clientKey := generateCert(t, "client")
+	files := map[string][]byte{"ca.pem": serverCert, "cert.pem": clientCert, "key.pem": clientKey}
+	for name, bs := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), bs, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cert, err := tls.X509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AppendCertsFromPEM(clientCert)
+
+	s := newFakeRedisTLS(t, "", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	})
+
+	tlsConfig := &RedisTLSConfig{
+		CACert:     filepath.Join(dir, "ca.pem"),
+		Cert:       filepath.Join(dir, "cert.pem"),
+		PrivateKey: filepath.Join(dir, "key.pem"),
+		ServerName: "redis.test",
+	}
+
+	b, err := NewRedisBackend(RedisConfig{Address: s.ln.Addr().String(), TLS: tlsConfig})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := b.Set(ctx, "k", []byte("v"), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok, err := b.Get(ctx, "k"); err != nil || !ok || string(v) != "v" {
+		t.Fatalf("Expected value but got %q, %v, %v", v, ok, err)
+	}
+
+	// The server name defaults to the host of the address, which the server
+	// certificate is not valid for.
+	b2, err := NewRedisBackend(RedisConfig{Address: s.ln.Addr().String(), TLS: &RedisTLSConfig{
+		CACert:     tlsConfig.CACert,
+		Cert:       tlsConfig.Cert,
+		PrivateKey: tlsConfig.PrivateKey,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b2.Close()
+
+	if _, _, err := b2.Get(ctx, "k"); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Fatalf("Expected certificate error but got %v", err)
+	}
+}
+
+func generateCert(t *testing.T, host string) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: host},
+		DNSNames:              []string{host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
@@ -0,0 +1,135 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/storage"
+)
+
+// InterQueryRuleCache defines the interface for the inter-query rule cache. The
+// rule cache holds the values of complete virtual documents so that they can be
+// reused across queries. Cached values are only valid for the compiler and the
+// data they were produced with: entries inserted with a different compiler are
+// never returned, and the cache must be invalidated whenever the data in the
+// store changes (see InvalidateOnCommit.) Like for InterQueryBaseCache, a new
+// cache must be used for each change of stores that commit writes while reads
+// are in progress.
+type InterQueryRuleCache interface {
+	// Get returns the value cached for ref. If found is true and value is nil,
+	// ref was cached as undefined.
+	Get(compiler *ast.Compiler, ref ast.Ref) (value *ast.Term, found bool)
+	// Insert caches value for ref. A nil value caches ref as undefined.
+	Insert(compiler *ast.Compiler, ref ast.Ref, value *ast.Term)
+	// Invalidate removes all values from the cache.
+	Invalidate()
+	UpdateConfig(config *Config)
+}
+
+// NewInterQueryRuleCache returns a new inter-query rule cache. The cache uses a
+// FIFO eviction policy when it reaches the configured maximum number of entries.
+func NewInterQueryRuleCache(config *Config) InterQueryRuleCache {
+	return &ruleCache{
+		items:  map[string]ruleCacheItem{},
+		l:      list.New(),
+		config: config,
+	}
+}
+
+// InvalidateOnCommit registers a trigger on store that invalidates c each time
+// a write transaction is committed. c may be an InterQueryRuleCache or an
+// InterQueryBaseCache. The returned handle can be used to unregister the
+// trigger.
+func InvalidateOnCommit(ctx context.Context, store storage.Store, txn storage.Transaction, c interface{ Invalidate() }) (storage.TriggerHandle, error) {
+	return store.Register(ctx, txn, storage.TriggerConfig{
+		OnCommit: func(context.Context, storage.Transaction, storage.TriggerEvent) {
+			c.Invalidate()
+		},
+	})
+}
+
+type ruleCacheItem struct {
+	value      *ast.Term
+	keyElement *list.Element
+}
+
+type ruleCache struct {
+	compiler *ast.Compiler
+	items    map[string]ruleCacheItem
+	l        *list.List
+	config   *Config
+	mtx      sync.Mutex
+}
+
+func (c *ruleCache) Get(compiler *ast.Compiler, ref ast.Ref) (*ast.Term, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if compiler != c.compiler {
+		return nil, false
+	}
+	item, ok := c.items[ref.String()]
+	return item.value, ok
+}
+
+func (c *ruleCache) Insert(compiler *ast.Compiler, ref ast.Ref, value *ast.Term) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	// Values produced by different compilers cannot be mixed, so the first
+	// insert with a new compiler drops all existing entries.
+	if compiler != c.compiler {
+		c.unsafeInvalidate()
+		c.compiler = compiler
+	}
+
+	key := ref.String()
+	if item, ok := c.items[key]; ok {
+		c.l.Remove(item.keyElement)
+		delete(c.items, key)
+	}
+
+	if limit := c.maxNumEntries(); limit > 0 {
+		for front := c.l.Front(); front != nil && len(c.items) >= limit; front = c.l.Front() {
+			delete(c.items, front.Value.(string))
+			c.l.Remove(front)
+		}
+	}
+
+	c.items[key] = ruleCacheItem{
+		value:      value,
+		keyElement: c.l.PushBack(key),
+	}
+}
+
+func (c *ruleCache) Invalidate() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.unsafeInvalidate()
+}
+
+func (c *ruleCache) UpdateConfig(config *Config) {
+	if config == nil {
+		return
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.config = config
+}
+
+func (c *ruleCache) unsafeInvalidate() {
+	c.items = map[string]ruleCacheItem{}
+	c.l.Init()
+}
+
+func (c *ruleCache) maxNumEntries() int {
+	if c.config == nil || c.config.InterQueryRuleCache.MaxNumEntries == nil {
+		return 0
+	}
+	return *c.config.InterQueryRuleCache.MaxNumEntries
+}
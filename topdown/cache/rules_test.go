@@ -0,0 +1,68 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+func TestInterQueryRuleCache(t *testing.T) {
+	config, err := ParseCachingConfig([]byte(`{"inter_query_rule_cache": {"enabled": true, "max_num_entries": 2}}`))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	c := NewInterQueryRuleCache(config)
+	compiler := ast.NewCompiler()
+
+	c.Insert(compiler, ast.MustParseRef("data.a"), ast.IntNumberTerm(1))
+	c.Insert(compiler, ast.MustParseRef("data.b"), nil)
+
+	if value, found := c.Get(compiler, ast.MustParseRef("data.a")); !found || !value.Equal(ast.IntNumberTerm(1)) {
+		t.Fatalf("Expected data.a to be cached but got %v, %v", value, found)
+	}
+
+	if value, found := c.Get(compiler, ast.MustParseRef("data.b")); !found || value != nil {
+		t.Fatalf("Expected data.b to be cached as undefined but got %v, %v", value, found)
+	}
+
+	// Entries are only returned for the compiler they were produced with.
+	if _, found := c.Get(ast.NewCompiler(), ast.MustParseRef("data.a")); found {
+		t.Fatal("Expected data.a not to be found for other compiler")
+	}
+
+	// Inserting beyond max_num_entries drops the oldest entry.
+	c.Insert(compiler, ast.MustParseRef("data.c"), ast.IntNumberTerm(3))
+
+	if _, found := c.Get(compiler, ast.MustParseRef("data.a")); found {
+		t.Fatal("Expected data.a to be evicted")
+	}
+
+	if _, found := c.Get(compiler, ast.MustParseRef("data.c")); !found {
+		t.Fatal("Expected data.c to be cached")
+	}
+
+	// Inserting with another compiler drops all entries.
+	other := ast.NewCompiler()
+	c.Insert(other, ast.MustParseRef("data.d"), ast.IntNumberTerm(4))
+
+	if _, found := c.Get(compiler, ast.MustParseRef("data.c")); found {
+		t.Fatal("Expected data.c to be dropped")
+	}
+
+	c.Invalidate()
+
+	if _, found := c.Get(other, ast.MustParseRef("data.d")); found {
+		t.Fatal("Expected data.d to be invalidated")
+	}
+}
+
+func TestParseCachingConfigInterQueryRuleCache(t *testing.T) {
+	if _, err := ParseCachingConfig([]byte(`{"inter_query_rule_cache": {"max_num_entries": -1}}`)); err == nil {
+		t.Fatal("Expected error but got nil")
+	}
+}
@@ -5,9 +5,15 @@
 package topdown
 
 import (
+	"context"
+	"math/rand"
 	"testing"
 
 	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/metrics"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/open-policy-agent/opa/topdown/cache"
 )
 
 func TestVirtualCacheCompositeKey(t *testing.T) {
@@ -51,3 +57,162 @@ func TestBaseCacheGetExactMatch(t *testing.T) {
 		t.Fatalf("Expected bar but got %v", result)
 	}
 }
+
+func TestInterQueryRuleCache(t *testing.T) {
+	ctx := context.Background()
+
+	compiler := compileModules([]string{`package test
+
+	p := count(data.values)
+
+	q := x {
+		x := input.x
+	}
+
+	r := rand.intn("r", 100)
+
+	s := x {
+		x := q + 1
+	}
+
+	t := p + 1
+	`})
+
+	store := inmem.NewFromObject(map[string]interface{}{"values": []interface{}{1, 2, 3}})
+	c := cache.NewInterQueryRuleCache(nil)
+
+	countOverride := map[string]BuiltinFunc{
+		ast.Count.Name: func(_ BuiltinContext, _ []*ast.Term, iter func(*ast.Term) error) error {
+			return iter(ast.IntNumberTerm(10))
+		},
+	}
+
+	tests := []struct {
+		note      string
+		query     string
+		overrides map[string]BuiltinFunc
+		expected  string
+		hits      uint64
+		misses    uint64
+	}{
+		{note: "no dependency on input", query: "data.test.t = x", expected: `4`, hits: 0, misses: 2},
+		{note: "cached", query: "data.test.t = x", expected: `4`, hits: 1, misses: 0},
+		{note: "cached dependency", query: "data.test.p = x", expected: `3`, hits: 1, misses: 0},
+		{note: "input", query: "data.test.q = x", expected: `1`, hits: 0, misses: 0},
+		{note: "transitive input", query: "data.test.s = x", expected: `2`, hits: 0, misses: 0},
+		{note: "non-deterministic", query: "data.test.r = x", hits: 0, misses: 0},
+		{note: "with", query: "data.test.t = x with data.values as []", expected: `1`, hits: 0, misses: 0},
+		{note: "overridden built-in", query: "data.test.t = x", overrides: countOverride, expected: `11`, hits: 0, misses: 0},
+		{note: "cached after override", query: "data.test.t = x", expected: `4`, hits: 1, misses: 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			m := metrics.New()
+			txn := storage.NewTransactionOrDie(ctx, store)
+			defer store.Abort(ctx, txn)
+
+			qrs, err := NewQuery(ast.MustParseBody(tc.query)).
+				WithCompiler(compiler).
+				WithStore(store).
+				WithTransaction(txn).
+				WithInput(ast.MustParseTerm(`{"x": 1}`)).
+				WithSeed(rand.New(rand.NewSource(0))).
+				WithInstrumentation(NewInstrumentation(m)).
+				WithInterQueryRuleCache(c).
+				WithBuiltinOverrides(tc.overrides).
+				Run(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(qrs) != 1 {
+				t.Fatalf("expected exactly one result but got %v", qrs)
+			}
+			if tc.expected != "" && !qrs[0][ast.Var("x")].Equal(ast.MustParseTerm(tc.expected)) {
+				t.Fatalf("expected %v but got %v", tc.expected, qrs[0][ast.Var("x")])
+			}
+
+			if hits := m.Counter(evalOpInterQueryRuleCacheHit).Value(); hits != tc.hits {
+				t.Errorf("expected %d cache hits but got %v", tc.hits, hits)
+			}
+			if misses := m.Counter(evalOpInterQueryRuleCacheMiss).Value(); misses != tc.misses {
+				t.Errorf("expected %d cache misses but got %v", tc.misses, misses)
+			}
+		})
+	}
+
+	// Writes to the store invalidate the cache.
+	txn := storage.NewTransactionOrDie(ctx, store, storage.WriteParams)
+	if _, err := cache.InvalidateOnCommit(ctx, store, txn, c); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Write(ctx, txn, storage.AddOp, storage.MustParsePath("/values/-"), 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Commit(ctx, txn); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, found := c.Get(compiler, ast.MustParseRef("data.test.p")); found {
+		t.Fatal("expected cache to be invalidated")
+	}
+}
+
+func TestInterQueryBaseCache(t *testing.T) {
+	ctx := context.Background()
+
+	compiler := compileModules([]string{`package test
+
+	p := count(data.values)
+	`})
+
+	store := inmem.NewFromObject(map[string]interface{}{"values": []interface{}{1, 2, 3}})
+	c := cache.NewInterQueryBaseCache()
+
+	if err := storage.Txn(ctx, store, storage.WriteParams, func(txn storage.Transaction) error {
+		_, err := cache.InvalidateOnCommit(ctx, store, txn, c)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	eval := func(expected string, hits, misses uint64) {
+		t.Helper()
+		m := metrics.New()
+		txn := storage.NewTransactionOrDie(ctx, store)
+		defer store.Abort(ctx, txn)
+
+		qrs, err := NewQuery(ast.MustParseBody("data.test.p = x")).
+			WithCompiler(compiler).
+			WithStore(store).
+			WithTransaction(txn).
+			WithInstrumentation(NewInstrumentation(m)).
+			WithInterQueryBaseCache(c).
+			Run(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(qrs) != 1 || !qrs[0][ast.Var("x")].Equal(ast.MustParseTerm(expected)) {
+			t.Fatalf("expected %v but got %v", expected, qrs)
+		}
+		if n := m.Counter(evalOpInterQueryBaseCacheHit).Value(); n != hits {
+			t.Errorf("expected %d cache hits but got %v", hits, n)
+		}
+		if n := m.Counter(evalOpInterQueryBaseCacheMiss).Value(); n != misses {
+			t.Errorf("expected %d cache misses but got %v", misses, n)
+		}
+	}
+
+	eval(`3`, 0, 1)
+	eval(`3`, 1, 0)
+
+	// Writes to the store invalidate the cache.
+	if err := storage.WriteOne(ctx, store, storage.AddOp, storage.MustParsePath("/values/-"), 4); err != nil {
+		t.Fatal(err)
+	}
+
+	eval(`4`, 0, 1)
+	eval(`4`, 1, 0)
+}
@@ -6,6 +6,7 @@ package topdown
 
 import (
 	"sync/atomic"
+	"time"
 )
 
 // Cancel defines the interface for cancelling topdown queries. Cancel
@@ -31,3 +32,38 @@ func (c *cancel) Cancel() {
 func (c *cancel) Cancelled() bool {
 	return atomic.LoadInt32(&c.flag) != 0
 }
+
+// timeoutCancel wraps the (optional) caller supplied Cancel object and is
+// additionally cancelled once the query timeout elapses. The timedOut flag lets
+// the evaluator distinguish timeouts from cancellation by the caller.
+type timeoutCancel struct {
+	parent   Cancel
+	timedOut int32
+	timer    *time.Timer
+}
+
+func newTimeoutCancel(parent Cancel, timeout time.Duration) *timeoutCancel {
+	c := &timeoutCancel{parent: parent}
+	c.timer = time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&c.timedOut, 1)
+	})
+	return c
+}
+
+func (c *timeoutCancel) Cancel() {
+	if c.parent != nil {
+		c.parent.Cancel()
+	}
+}
+
+func (c *timeoutCancel) Cancelled() bool {
+	return c.TimedOut() || (c.parent != nil && c.parent.Cancelled())
+}
+
+func (c *timeoutCancel) TimedOut() bool {
+	return atomic.LoadInt32(&c.timedOut) != 0
+}
+
+func (c *timeoutCancel) Stop() {
+	c.timer.Stop()
+}
@@ -167,17 +167,40 @@ func evalNetCIDRContainsMatchesOperand(operand int, a *ast.Term, iter func(cidr,
 	return nil
 }
 
-func builtinNetCIDRContainsMatches(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+func builtinNetCIDRContainsMatches(bctx BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	trie, err := getCIDRTrie(bctx, operands[0])
+	if err != nil {
+		return err
+	}
+
 	result := ast.NewSet()
-	err := evalNetCIDRContainsMatchesOperand(1, operands[0], func(cidr1 *ast.Term, index1 *ast.Term) error {
-		return evalNetCIDRContainsMatchesOperand(2, operands[1], func(cidr2 *ast.Term, index2 *ast.Term) error {
-			if v, err := getResult(builtinNetCIDRContains, cidr1, cidr2); err != nil {
-				return err
-			} else if vb, ok := v.Value.(ast.Boolean); ok && bool(vb) {
-				result.Add(ast.ArrayTerm(index1, index2))
-			}
+	if trie.empty() {
+		return iter(ast.NewTerm(result))
+	}
+
+	err = evalNetCIDRContainsMatchesOperand(2, operands[1], func(cidr2 *ast.Term, index2 *ast.Term) error {
+		if trie.err != nil {
+			return trie.err
+		}
+		add := func(index1 *ast.Term) {
+			result.Add(ast.ArrayTerm(index1, index2))
+		}
+
+		// cidr2 could be either an IP address or CIDR string, like the second
+		// operand of net.cidr_contains.
+		s, err := builtins.StringOperand(cidr2.Value, 1)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(string(s)); ip != nil {
+			trie.matchIP(ip, add)
 			return nil
-		})
+		}
+		cidrnet, err := getNetFromOperand(cidr2.Value)
+		if err != nil {
+			return fmt.Errorf("not a valid textual representation of an IP address or CIDR: %s", string(s))
+		}
+		return trie.matchCIDR(cidrnet, add)
 	})
 	if err == nil {
 		return iter(ast.NewTerm(result))
@@ -2,12 +2,16 @@ package topdown
 
 import (
 	"context"
+	"fmt"
+	"math/rand"
 	"testing"
 	"time"
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/storage"
 	inmem "github.com/open-policy-agent/opa/storage/inmem/test"
+	"github.com/open-policy-agent/opa/topdown/builtins"
+	"github.com/open-policy-agent/opa/topdown/cache"
 )
 
 func TestNetCIDRExpandCancellation(t *testing.T) {
@@ -44,3 +48,142 @@ func TestNetCIDRExpandCancellation(t *testing.T) {
 	}
 
 }
+
+func randomCIDRMatchOperand(r *rand.Rand, n int, cidrs bool) *ast.Term {
+	arr := make([]*ast.Term, n)
+	for i := range arr {
+		var s string
+		// Addresses are drawn from small ranges so that many of them match.
+		switch r.Intn(3) {
+		case 0:
+			s = fmt.Sprintf("10.%d.%d.%d", r.Intn(4), r.Intn(4), r.Intn(256))
+			if cidrs || r.Intn(2) == 0 {
+				s += fmt.Sprintf("/%d", r.Intn(33))
+			}
+		case 1:
+			s = fmt.Sprintf("::ffff:10.%d.%d.%d", r.Intn(4), r.Intn(4), r.Intn(256))
+			if cidrs || r.Intn(2) == 0 {
+				s += fmt.Sprintf("/%d", 90+r.Intn(39))
+			}
+		case 2:
+			s = fmt.Sprintf("2001:db8:%x::%x", r.Intn(4), r.Intn(65536))
+			if cidrs || r.Intn(2) == 0 {
+				s += fmt.Sprintf("/%d", r.Intn(129))
+			}
+		}
+		arr[i] = ast.StringTerm(s)
+	}
+	return ast.ArrayTerm(arr...)
+}
+
+func TestNetCIDRContainsMatchesTrie(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+
+	for i := 0; i < 20; i++ {
+		cidrs := randomCIDRMatchOperand(r, 50, true)
+		addrs := randomCIDRMatchOperand(r, 50, false)
+
+		// The matches are the pairs net.cidr_contains holds for.
+		exp := ast.NewSet()
+		cidrs.Value.(*ast.Array).Foreach(func(cidr *ast.Term) {
+			addrs.Value.(*ast.Array).Foreach(func(addr *ast.Term) {
+				if v, err := getResult(builtinNetCIDRContains, cidr, addr); err != nil {
+					t.Fatal(err)
+				} else if v.Value.Compare(ast.Boolean(true)) == 0 {
+					exp.Add(ast.ArrayTerm(ast.IntNumberTerm(indexOf(cidrs, cidr)), ast.IntNumberTerm(indexOf(addrs, addr))))
+				}
+			})
+		})
+
+		bctx := BuiltinContext{Cache: builtins.Cache{}}
+		result, err := getResult(func(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+			return builtinNetCIDRContainsMatches(bctx, operands, iter)
+		}, cidrs, addrs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if exp.Len() == 0 || result.Value.Compare(exp) != 0 {
+			t.Fatalf("Expected %v but got %v", exp, result)
+		}
+	}
+}
+
+func indexOf(arr *ast.Term, x *ast.Term) int {
+	a := arr.Value.(*ast.Array)
+	for i := 0; i < a.Len(); i++ {
+		if a.Elem(i) == x {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestNetCIDRContainsMatchesInterQueryCache(t *testing.T) {
+	ctx := context.Background()
+
+	compiler := compileModules([]string{
+		`
+		package test
+
+		p := net.cidr_contains_matches(data.cidrs, input.addr)
+		`,
+	})
+
+	store := inmem.NewFromObject(map[string]interface{}{
+		"cidrs": map[string]interface{}{"a": "10.0.0.0/8", "b": []interface{}{"10.1.0.0/16", "x"}, "c": "192.168.0.0/16"},
+	})
+	iqCache := cache.NewInterQueryCache(nil)
+
+	for _, tc := range []struct {
+		addr string
+		exp  string
+	}{
+		{addr: "10.1.2.3", exp: `{["a", "10.1.2.3"], ["b", "10.1.2.3"]}`},
+		{addr: "192.168.0.1", exp: `{["c", "192.168.0.1"]}`},
+	} {
+		var qrs QueryResultSet
+		err := storage.Txn(ctx, store, storage.TransactionParams{}, func(txn storage.Transaction) error {
+			var err error
+			qrs, err = NewQuery(ast.MustParseBody("data.test.p = x")).
+				WithCompiler(compiler).
+				WithStore(store).
+				WithTransaction(txn).
+				WithInput(ast.NewTerm(ast.MustInterfaceToValue(map[string]interface{}{"addr": tc.addr}))).
+				WithInterQueryBuiltinCache(iqCache).
+				Run(ctx)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exp := ast.MustParseTerm(tc.exp); len(qrs) != 1 || !qrs[0][ast.Var("x")].Equal(exp) {
+			t.Fatalf("Expected %v but got %v", exp, qrs)
+		}
+	}
+
+	cidrs := ast.MustParseTerm(`{"a": "10.0.0.0/8", "b": ["10.1.0.0/16", "x"], "c": "192.168.0.0/16"}`)
+	if _, ok := iqCache.Get(ast.NewArray(ast.StringTerm(ast.NetCIDRContainsMatches.Name), ast.IntNumberTerm(cidrs.Value.Hash()))); !ok {
+		t.Fatal("Expected trie in inter-query cache")
+	}
+}
+
+func BenchmarkNetCIDRContainsMatches(b *testing.B) {
+	r := rand.New(rand.NewSource(0))
+	cidrs := make([]*ast.Term, 20000)
+	for i := range cidrs {
+		cidrs[i] = ast.StringTerm(fmt.Sprintf("%d.%d.%d.0/%d", r.Intn(256), r.Intn(256), r.Intn(256), 16+r.Intn(9)))
+	}
+	operands := []*ast.Term{ast.ArrayTerm(cidrs...), ast.StringTerm("10.1.2.3")}
+	iqCache := cache.NewInterQueryCache(nil)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		bctx := BuiltinContext{Cache: builtins.Cache{}, InterQueryBuiltinCache: iqCache}
+		err := builtinNetCIDRContainsMatches(bctx, operands, func(*ast.Term) error { return nil })
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
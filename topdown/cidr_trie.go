@@ -0,0 +1,256 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"math/bits"
+	"net"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/topdown/cache"
+)
+
+// cidrTrieNodeSize is the approximate size of a node of the trie, used to
+// account for tries in the inter-query cache.
+const cidrTrieNodeSize = 96
+
+type cidrTrieCacheKey string
+
+// cidrTrie is a path-compressed binary trie of the CIDRs of the first operand
+// of net.cidr_contains_matches, that finds the CIDRs containing an address or
+// CIDR in time proportional to the length of its prefix. IPv4 and IPv6 CIDRs
+// are held in separate tries, as they never contain each other. Tries are not
+// modified once built.
+type cidrTrie struct {
+	v4, v6 *cidrTrieNode
+	nodes  int
+
+	// err is the error of the first invalid CIDR of the operand, returned if
+	// the trie is matched against.
+	err error
+}
+
+// cidrTrieNode holds the indices of the CIDRs with the prefix of the node, and
+// the nodes of the longer prefixes by their next bit. The addresses of the
+// nodes are masked with their prefix.
+type cidrTrieNode struct {
+	addr     [net.IPv6len]byte
+	ones     int
+	indices  []*ast.Term
+	children [2]*cidrTrieNode
+}
+
+// getCIDRTrie returns the trie of the CIDRs of a, cached within the query and
+// in the inter-query cache if enabled.
+func getCIDRTrie(bctx BuiltinContext, a *ast.Term) (*cidrTrie, error) {
+	if _, ok := a.Value.(ast.String); ok {
+		return newCIDRTrie(a)
+	}
+
+	intraQueryCache := getValueKeyedCache(bctx, cidrTrieCacheKey(ast.NetCIDRContainsMatches.Name))
+	if v, ok := intraQueryCache.Get(a.Value); ok {
+		return v.(*cidrTrieValue).trie, nil
+	}
+
+	// The inter-query cache is keyed on the hash of the collection rather than
+	// on the collection itself, whose string representation would take longer
+	// to build than the lookups.
+	var interQueryKey ast.Value
+	if bctx.InterQueryBuiltinCache != nil {
+		interQueryKey = ast.NewArray(ast.StringTerm(ast.NetCIDRContainsMatches.Name), ast.IntNumberTerm(a.Value.Hash()))
+		if v, ok := bctx.InterQueryBuiltinCache.Get(interQueryKey); ok {
+			if cached, ok := v.(*cidrTrieValue); ok && cached.cidrs.Compare(a.Value) == 0 {
+				intraQueryCache.Put(a.Value, cached)
+				return cached.trie, nil
+			}
+		}
+	}
+
+	t, err := newCIDRTrie(a)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.err == nil {
+		cached := &cidrTrieValue{cidrs: a.Value, trie: t}
+		intraQueryCache.Put(a.Value, cached)
+		if interQueryKey != nil {
+			bctx.InterQueryBuiltinCache.Insert(interQueryKey, cached)
+		}
+	}
+
+	return t, nil
+}
+
+// newCIDRTrie returns the trie of the CIDRs of a, indexed like the results of
+// net.cidr_contains_matches.
+func newCIDRTrie(a *ast.Term) (*cidrTrie, error) {
+	t := &cidrTrie{}
+	err := evalNetCIDRContainsMatchesOperand(1, a, func(cidr, index *ast.Term) error {
+		if t.err != nil {
+			return nil
+		}
+		n, err := getNetFromOperand(cidr.Value)
+		if err != nil {
+			t.err = err
+			return nil
+		}
+		addr, ones, v4 := cidrTrieKey(n.IP, n.Mask)
+		if v4 {
+			t.insert(&t.v4, addr, ones, index)
+		} else {
+			t.insert(&t.v6, addr, ones, index)
+		}
+		return nil
+	})
+	return t, err
+}
+
+// cidrTrieKey returns the address, the prefix length and the family of the
+// network ip/mask, with IPv4-mapped IPv6 networks treated as IPv4 networks
+// like net.IPNet.Contains does.
+func cidrTrieKey(ip net.IP, mask net.IPMask) ([net.IPv6len]byte, int, bool) {
+	var addr [net.IPv6len]byte
+	ones, size := mask.Size()
+	if ip4 := ip.To4(); ip4 != nil {
+		if size == 8*net.IPv6len {
+			ones -= 8 * (net.IPv6len - net.IPv4len)
+		}
+		copy(addr[:], ip4)
+		return addr, ones, true
+	}
+	copy(addr[:], ip)
+	return addr, ones, false
+}
+
+func (t *cidrTrie) empty() bool {
+	return t.v4 == nil && t.v6 == nil && t.err == nil
+}
+
+func (t *cidrTrie) insert(root **cidrTrieNode, addr [net.IPv6len]byte, ones int, index *ast.Term) {
+	n := root
+	for {
+		cur := *n
+		if cur == nil {
+			*n = t.newNode(addr, ones, index)
+			return
+		}
+
+		common := min(commonPrefixLen(cur.addr, addr), cur.ones, ones)
+		if common == cur.ones {
+			if ones == cur.ones {
+				cur.indices = append(cur.indices, index)
+				return
+			}
+			n = &cur.children[addrBit(addr, cur.ones)]
+			continue
+		}
+
+		// The prefixes diverge above the node: the node moves beneath the new
+		// prefix, or beneath a new branch if the prefix does not contain it.
+		if common == ones {
+			parent := t.newNode(addr, ones, index)
+			parent.children[addrBit(cur.addr, ones)] = cur
+			*n = parent
+			return
+		}
+		branch := t.newNode(maskAddr(addr, common), common, nil)
+		branch.children[addrBit(cur.addr, common)] = cur
+		branch.children[addrBit(addr, common)] = t.newNode(addr, ones, index)
+		*n = branch
+		return
+	}
+}
+
+func (t *cidrTrie) newNode(addr [net.IPv6len]byte, ones int, index *ast.Term) *cidrTrieNode {
+	t.nodes++
+	n := &cidrTrieNode{addr: addr, ones: ones}
+	if index != nil {
+		n.indices = []*ast.Term{index}
+	}
+	return n
+}
+
+// matchIP calls iter with the indices of the CIDRs containing ip.
+func (t *cidrTrie) matchIP(ip net.IP, iter func(*ast.Term)) {
+	var addr [net.IPv6len]byte
+	if ip4 := ip.To4(); ip4 != nil {
+		copy(addr[:], ip4)
+		match(t.v4, addr, 8*net.IPv4len, iter)
+		return
+	}
+	copy(addr[:], ip)
+	match(t.v6, addr, 8*net.IPv6len, iter)
+}
+
+// matchCIDR calls iter with the indices of the CIDRs containing both the first
+// and the last address of n.
+func (t *cidrTrie) matchCIDR(n *net.IPNet, iter func(*ast.Term)) error {
+	lastIP, err := getLastIP(n)
+	if err != nil {
+		return err
+	}
+	// A network spanning both families is not contained in any CIDR.
+	if (n.IP.To4() == nil) != (lastIP.To4() == nil) {
+		return nil
+	}
+	addr, ones, v4 := cidrTrieKey(n.IP, n.Mask)
+	if v4 {
+		match(t.v4, addr, ones, iter)
+	} else {
+		match(t.v6, addr, ones, iter)
+	}
+	return nil
+}
+
+func match(n *cidrTrieNode, addr [net.IPv6len]byte, ones int, iter func(*ast.Term)) {
+	for n != nil && n.ones <= ones && commonPrefixLen(n.addr, addr) >= n.ones {
+		for _, index := range n.indices {
+			iter(index)
+		}
+		if n.ones == ones {
+			return
+		}
+		n = n.children[addrBit(addr, n.ones)]
+	}
+}
+
+func commonPrefixLen(a, b [net.IPv6len]byte) int {
+	for i := range a {
+		if x := a[i] ^ b[i]; x != 0 {
+			return 8*i + bits.LeadingZeros8(x)
+		}
+	}
+	return 8 * net.IPv6len
+}
+
+func addrBit(addr [net.IPv6len]byte, i int) int {
+	return int(addr[i/8]>>(7-i%8)) & 1
+}
+
+func maskAddr(addr [net.IPv6len]byte, ones int) [net.IPv6len]byte {
+	var masked [net.IPv6len]byte
+	copy(masked[:ones/8], addr[:])
+	if ones%8 != 0 {
+		masked[ones/8] = addr[ones/8] & ^byte(0xff>>(ones%8))
+	}
+	return masked
+}
+
+// cidrTrieValue holds a trie and the collection of CIDRs it was built from in
+// the inter-query cache. Tries are immutable, so they are shared rather than
+// copied.
+type cidrTrieValue struct {
+	cidrs ast.Value
+	trie  *cidrTrie
+}
+
+func (v *cidrTrieValue) SizeInBytes() int64 {
+	return int64(v.trie.nodes) * cidrTrieNodeSize
+}
+
+func (v *cidrTrieValue) Clone() (cache.InterQueryCacheValue, error) {
+	return v, nil
+}
@@ -0,0 +1,216 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// numericConstraint is a comparison of a ref with a number saved during
+// partial evaluation, e.g., `input.x > 3`, normalized so that the ref is the
+// left operand.
+type numericConstraint struct {
+	index int // index of the expression in the body
+	op    string
+	num   *ast.Term
+}
+
+var flippedComparisons = map[string]string{
+	ast.GreaterThan.Name:   ast.LessThan.Name,
+	ast.GreaterThanEq.Name: ast.LessThanEq.Name,
+	ast.LessThan.Name:      ast.GreaterThan.Name,
+	ast.LessThanEq.Name:    ast.GreaterThanEq.Name,
+	ast.Equal.Name:         ast.Equal.Name,
+	ast.Equality.Name:      ast.Equality.Name,
+	ast.NotEqual.Name:      ast.NotEqual.Name,
+}
+
+// simplifyNumericConstraints merges the comparisons of the same ref with
+// numbers in body, e.g., `input.x > 3; input.x > 7` becomes `input.x > 7`. If
+// the comparisons contradict each other, e.g., `input.x > 7; input.x < 3`, the
+// body can never succeed, and false is returned.
+//
+// NOTE(sr): Comparisons in Rego are defined for all values, as values of
+// different types are ordered by their type. So `input.x > 3; input.x < 7`
+// restricts input.x to the numbers between 3 and 7, while `input.x > 3` alone
+// is also satisfied by strings, for example. The bounds are merged like
+// intervals in this total order.
+func simplifyNumericConstraints(body ast.Body) (ast.Body, bool) {
+	var groups map[string][]numericConstraint
+	var order []string
+
+	for i, expr := range body {
+		term, c, ok := numericConstraintFromExpr(expr)
+		if !ok {
+			continue
+		}
+		c.index = i
+		if groups == nil {
+			groups = map[string][]numericConstraint{}
+		}
+		key := term.String()
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], c)
+	}
+
+	if len(order) == 0 {
+		return body, true
+	}
+
+	var drop map[int]struct{}
+	for _, key := range order {
+		cs := groups[key]
+		if len(cs) < 2 {
+			continue
+		}
+		redundant, ok := mergeNumericConstraints(cs)
+		if !ok {
+			return nil, false
+		}
+		for _, i := range redundant {
+			if drop == nil {
+				drop = map[int]struct{}{}
+			}
+			drop[i] = struct{}{}
+		}
+	}
+
+	if len(drop) == 0 {
+		return body, true
+	}
+
+	result := ast.NewBody()
+	for i, expr := range body {
+		if _, ok := drop[i]; !ok {
+			result.Append(expr)
+		}
+	}
+	return result, true
+}
+
+func numericConstraintFromExpr(expr *ast.Expr) (*ast.Term, numericConstraint, bool) {
+	if expr.Negated || len(expr.With) > 0 || !expr.IsCall() || len(expr.Operands()) != 2 {
+		return nil, numericConstraint{}, false
+	}
+
+	op := expr.Operator().String()
+	flipped, ok := flippedComparisons[op]
+	if !ok {
+		return nil, numericConstraint{}, false
+	}
+
+	a, b := expr.Operand(0), expr.Operand(1)
+	if _, ok := a.Value.(ast.Number); ok {
+		a, b = b, a
+		op = flipped
+	}
+
+	// NOTE(sr): Only ground refs are considered, e.g. `input.x`, as dropping
+	// expressions on vars could affect the order in which they are bound.
+	if _, ok := a.Value.(ast.Ref); !ok || !a.IsGround() {
+		return nil, numericConstraint{}, false
+	}
+	if _, ok := b.Value.(ast.Number); !ok {
+		return nil, numericConstraint{}, false
+	}
+
+	if op == ast.Equality.Name {
+		op = ast.Equal.Name
+	}
+	return a, numericConstraint{op: op, num: b}, true
+}
+
+// mergeNumericConstraints returns the indices of the redundant constraints in
+// cs, or false if cs contradict each other.
+func mergeNumericConstraints(cs []numericConstraint) ([]int, bool) {
+	var eq, lower, upper *numericConstraint
+
+	for i := range cs {
+		c := &cs[i]
+		switch c.op {
+		case ast.Equal.Name:
+			if eq != nil && eq.num.Value.Compare(c.num.Value) != 0 {
+				return nil, false
+			}
+			if eq == nil {
+				eq = c
+			}
+		case ast.GreaterThan.Name, ast.GreaterThanEq.Name:
+			if lower == nil || tighterLowerBound(c, lower) {
+				lower = c
+			}
+		case ast.LessThan.Name, ast.LessThanEq.Name:
+			if upper == nil || tighterUpperBound(c, upper) {
+				upper = c
+			}
+		}
+	}
+
+	// check the bounds against each other, and against the equality
+	if lower != nil && upper != nil {
+		cmp := lower.num.Value.Compare(upper.num.Value)
+		if cmp > 0 || cmp == 0 && (lower.op == ast.GreaterThan.Name || upper.op == ast.LessThan.Name) {
+			return nil, false
+		}
+	}
+	for _, c := range cs {
+		if eq != nil && !satisfiesNumericConstraint(eq.num, c) {
+			return nil, false
+		}
+	}
+
+	var redundant []int
+	for i := range cs {
+		c := &cs[i]
+		switch {
+		case eq != nil:
+			if c != eq {
+				redundant = append(redundant, c.index)
+			}
+		case c.op == ast.NotEqual.Name:
+			// x != n is implied by bounds excluding n
+			if lower != nil && !satisfiesNumericConstraint(c.num, *lower) ||
+				upper != nil && !satisfiesNumericConstraint(c.num, *upper) {
+				redundant = append(redundant, c.index)
+			}
+		case c != lower && c != upper:
+			redundant = append(redundant, c.index)
+		}
+	}
+
+	return redundant, true
+}
+
+func tighterLowerBound(a, b *numericConstraint) bool {
+	cmp := a.num.Value.Compare(b.num.Value)
+	return cmp > 0 || cmp == 0 && a.op == ast.GreaterThan.Name && b.op == ast.GreaterThanEq.Name
+}
+
+func tighterUpperBound(a, b *numericConstraint) bool {
+	cmp := a.num.Value.Compare(b.num.Value)
+	return cmp < 0 || cmp == 0 && a.op == ast.LessThan.Name && b.op == ast.LessThanEq.Name
+}
+
+// satisfiesNumericConstraint returns true if n satisfies c.
+func satisfiesNumericConstraint(n *ast.Term, c numericConstraint) bool {
+	cmp := n.Value.Compare(c.num.Value)
+	switch c.op {
+	case ast.Equal.Name:
+		return cmp == 0
+	case ast.NotEqual.Name:
+		return cmp != 0
+	case ast.GreaterThan.Name:
+		return cmp > 0
+	case ast.GreaterThanEq.Name:
+		return cmp >= 0
+	case ast.LessThan.Name:
+		return cmp < 0
+	case ast.LessThanEq.Name:
+		return cmp <= 0
+	}
+	return true
+}
@@ -671,22 +671,23 @@ func getPrivateKeysFromPEMData(pemData string) ([]crypto.PrivateKey, error) {
 }
 
 // addCACertsFromFile adds CA certificates from filePath into the given pool.
-// If pool is nil, it creates a new x509.CertPool. pool is returned.
-func addCACertsFromFile(pool *x509.CertPool, filePath string) (*x509.CertPool, error) {
+// If pool is nil, it creates a new x509.CertPool. pool and the contents of the
+// file are returned.
+func addCACertsFromFile(pool *x509.CertPool, filePath string) (*x509.CertPool, []byte, error) {
 	if pool == nil {
 		pool = x509.NewCertPool()
 	}
 
 	caCert, err := readCertFromFile(filePath)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if ok := pool.AppendCertsFromPEM(caCert); !ok {
-		return nil, fmt.Errorf("could not append CA certificates from %q", filePath)
+		return nil, nil, fmt.Errorf("could not append CA certificates from %q", filePath)
 	}
 
-	return pool, nil
+	return pool, caCert, nil
 }
 
 // addCACertsFromBytes adds CA certificates from pemBytes into the given pool.
@@ -705,14 +706,15 @@ func addCACertsFromBytes(pool *x509.CertPool, pemBytes []byte) (*x509.CertPool,
 
 // addCACertsFromEnv adds CA certificates from the environment variable named
 // by envName into the given pool. If pool is nil, it creates a new x509.CertPool.
-// pool is returned.
-func addCACertsFromEnv(pool *x509.CertPool, envName string) (*x509.CertPool, error) {
-	pool, err := addCACertsFromBytes(pool, []byte(os.Getenv(envName)))
+// pool and the value of the environment variable are returned.
+func addCACertsFromEnv(pool *x509.CertPool, envName string) (*x509.CertPool, []byte, error) {
+	caCert := []byte(os.Getenv(envName))
+	pool, err := addCACertsFromBytes(pool, caCert)
 	if err != nil {
-		return nil, fmt.Errorf("could not add CA certificates from envvar %q: %w", envName, err)
+		return nil, nil, fmt.Errorf("could not add CA certificates from envvar %q: %w", envName, err)
 	}
 
-	return pool, err
+	return pool, caCert, err
 }
 
 // ReadCertFromFile reads a cert from file
@@ -40,6 +40,11 @@ const (
 	// CancelErr indicates the evaluation process was cancelled.
 	CancelErr string = "eval_cancel_error"
 
+	// TimeoutErr indicates the evaluation process was cancelled because the
+	// query exceeded its evaluation timeout (see Query.WithTimeout). Timeouts
+	// are a form of cancellation, so IsCancel also returns true for them.
+	TimeoutErr string = "eval_timeout_error"
+
 	// ConflictErr indicates a conflict was encountered during evaluation. For
 	// instance, a conflict occurs if a rule produces multiple, differing values
 	// for the same key in an object. Conflict errors indicate the policy does
@@ -57,6 +62,22 @@ const (
 
 	// WithMergeErr indicates that the real and replacement data could not be merged.
 	WithMergeErr string = "eval_with_merge_error"
+
+	// RecursionLimitErr indicates evaluation stopped because expressions and
+	// unifications were nested deeper than the maximum evaluation depth (see
+	// Query.WithMaxEvalDepth), e.g., because of deeply nested comprehensions or
+	// input values.
+	RecursionLimitErr string = "eval_recursion_limit_error"
+
+	// ResultLimitErr indicates evaluation stopped because the query produced
+	// more results, or larger results, than allowed (see
+	// Query.WithMaxResultCount and Query.WithMaxResultBytes.)
+	ResultLimitErr string = "eval_result_limit_error"
+
+	// CapabilityErr indicates evaluation stopped because a built-in function
+	// not allowed by the capabilities was called (see
+	// Query.WithEnforceCapabilities.)
+	CapabilityErr string = "eval_capability_error"
 )
 
 // IsError returns true if the err is an Error.
@@ -67,7 +88,31 @@ func IsError(err error) bool {
 
 // IsCancel returns true if err was caused by cancellation.
 func IsCancel(err error) bool {
-	return errors.Is(err, &Error{Code: CancelErr})
+	return errors.Is(err, &Error{Code: CancelErr}) || IsTimeout(err)
+}
+
+// IsTimeout returns true if err was caused by the query exceeding its
+// evaluation timeout.
+func IsTimeout(err error) bool {
+	return errors.Is(err, &Error{Code: TimeoutErr})
+}
+
+// IsRecursionLimit returns true if err was caused by the query exceeding its
+// maximum evaluation depth.
+func IsRecursionLimit(err error) bool {
+	return errors.Is(err, &Error{Code: RecursionLimitErr})
+}
+
+// IsResultLimit returns true if err was caused by the query exceeding its
+// maximum number or size of results.
+func IsResultLimit(err error) bool {
+	return errors.Is(err, &Error{Code: ResultLimitErr})
+}
+
+// IsCapabilityErr returns true if err was caused by a call to a built-in
+// function that the capabilities do not allow.
+func IsCapabilityErr(err error) bool {
+	return errors.Is(err, &Error{Code: CapabilityErr})
 }
 
 // Is allows matching topdown errors using errors.Is (see IsCancel).
@@ -132,6 +177,14 @@ func unsupportedBuiltinErr(loc *ast.Location) error {
 	}
 }
 
+func capabilityErr(loc *ast.Location, name string) error {
+	return &Error{
+		Code:     CapabilityErr,
+		Location: loc,
+		Message:  fmt.Sprintf("built-in function %v not allowed by capabilities", name),
+	}
+}
+
 func mergeConflictErr(loc *ast.Location) error {
 	return &Error{
 		Code:     WithMergeErr,
@@ -8,6 +8,9 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/metrics"
@@ -32,9 +35,7 @@ type queryIDFactory struct {
 
 // Note: The first call to Next() returns 0.
 func (f *queryIDFactory) Next() uint64 {
-	curr := f.curr
-	f.curr++
-	return curr
+	return atomic.AddUint64(&f.curr, 1) - 1
 }
 
 type builtinErrors struct {
@@ -73,6 +74,8 @@ type eval struct {
 	indexing               bool
 	earlyExit              bool
 	bindings               *bindings
+	arena                  *bindingsArena
+	reorderer              *bodyReorderer
 	store                  storage.Store
 	baseCache              *baseCache
 	txn                    storage.Transaction
@@ -82,31 +85,46 @@ type eval struct {
 	external               *resolverTrie
 	targetStack            *refStack
 	tracers                []QueryTracer
+	traceFilter            *traceFilter
 	traceEnabled           bool
 	traceLastLocation      *ast.Location // Last location of a trace event.
 	plugTraceVars          bool
+	snapshotVirtualCache   bool
 	instr                  *Instrumentation
 	builtins               map[string]*Builtin
+	builtinOverrides       map[string]BuiltinFunc
 	builtinCache           builtins.Cache
 	ndBuiltinCache         builtins.NDBCache
 	functionMocks          *functionMocksStack
 	virtualCache           *virtualCache
 	comprehensionCache     *comprehensionCache
 	interQueryBuiltinCache cache.InterQueryCache
+	interQueryRuleCache    *interQueryRuleCacheState
+	interQueryBaseCache    cache.InterQueryBaseCache
+	interQueryBaseCacheGen uint64
+	ruleProfiler           *RuleProfiler
 	saveSet                *saveSet
 	saveStack              *saveStack
 	saveSupport            *saveSupport
 	saveNamespace          *ast.Term
 	skipSaveNamespace      bool
+	partialComprehensions  bool
 	inliningControl        *inliningControl
 	genvarprefix           string
 	genvarid               int
 	runtime                *ast.Term
 	builtinErrors          *builtinErrors
+	strictBuiltins         map[string]struct{}
 	printHook              print.Hook
 	tracingOpts            tracing.Options
+	builtinSpanThreshold   time.Duration
+	httpTransportPool      *HTTPTransportPool
 	findOne                bool
 	strictObjects          bool
+	allowedBuiltins        map[string]struct{}
+	parallelism            int
+	maxDepth               int
+	depth                  *int // shared by the evals of a goroutine
 }
 
 func (e *eval) Run(iter evalIterator) error {
@@ -137,6 +155,9 @@ func (e *eval) string(s *strings.Builder) {
 func (e *eval) builtinFunc(name string) (*ast.Builtin, BuiltinFunc, bool) {
 	decl, ok := ast.BuiltinMap[name]
 	if ok {
+		if f, ok := e.builtinOverrides[name]; ok {
+			return decl, f, true
+		}
 		f, ok := builtinFunctions[name]
 		if ok {
 			return decl, f, true
@@ -144,6 +165,9 @@ func (e *eval) builtinFunc(name string) (*ast.Builtin, BuiltinFunc, bool) {
 	} else {
 		bi, ok := e.builtins[name]
 		if ok {
+			if f, ok := e.builtinOverrides[name]; ok {
+				return bi.Decl, f, true
+			}
 			return bi.Decl, bi.Func, true
 		}
 	}
@@ -165,12 +189,42 @@ func (e *eval) child(query ast.Body) *eval {
 	cpy.index = 0
 	cpy.query = query
 	cpy.queryID = cpy.queryIDFact.Next()
-	cpy.bindings = newBindings(cpy.queryID, e.instr)
+	cpy.bindings = e.arena.newBindings(cpy.queryID, e.instr)
 	cpy.parent = e
 	cpy.findOne = false
 	return &cpy
 }
 
+// fork returns a child of e that can be evaluated concurrently with e and its
+// other forks. Caches and stacks that are modified during evaluation are not
+// shared with e.
+func (e *eval) fork(query ast.Body) *eval {
+	cpy := e.child(query)
+	cpy.baseCache = newBaseCache()
+	cpy.targetStack = newRefStack()
+	cpy.builtinCache = builtins.Cache{}
+	cpy.functionMocks = newFunctionMocksStack()
+	cpy.virtualCache = newVirtualCache()
+	cpy.comprehensionCache = newComprehensionCache()
+	cpy.builtinErrors = &builtinErrors{}
+	if e.depth != nil {
+		depth := *e.depth
+		cpy.depth = &depth
+	}
+	if e.interQueryRuleCache != nil {
+		cpy.interQueryRuleCache = newInterQueryRuleCacheState(e.interQueryRuleCache.c)
+	}
+	return cpy
+}
+
+func (e *eval) depthLimitErr(loc *ast.Location) error {
+	return &Error{
+		Code:     RecursionLimitErr,
+		Message:  fmt.Sprintf("evaluation exceeded maximum depth of %d", e.maxDepth),
+		Location: loc,
+	}
+}
+
 func (e *eval) next(iter evalIterator) error {
 	e.index++
 	err := e.evalExpr(iter)
@@ -239,7 +293,7 @@ func (e *eval) traceWasm(x ast.Node, target *ast.Ref) {
 
 func (e *eval) traceEvent(op Op, x ast.Node, msg string, target *ast.Ref) {
 
-	if !e.traceEnabled {
+	if !e.traceEnabled || !e.traceFilter.Enabled(op) {
 		return
 	}
 
@@ -304,12 +358,21 @@ func (e *eval) traceEvent(op Op, x ast.Node, msg string, target *ast.Ref) {
 		})
 	}
 
+	if e.snapshotVirtualCache {
+		evt.virtualCache = e.virtualCache.Snapshot()
+	}
+
 	for i := range e.tracers {
-		e.tracers[i].TraceEvent(evt)
+		if e.traceFilter.EnabledFor(i, op) {
+			e.tracers[i].TraceEvent(evt)
+		}
 	}
 }
 
 func (e *eval) eval(iter evalIterator) error {
+	if err := e.prefetch(e.query); err != nil {
+		return err
+	}
 	return e.evalExpr(iter)
 }
 
@@ -317,18 +380,37 @@ func (e *eval) evalExpr(iter evalIterator) error {
 	wrapErr := func(err error) error {
 		if !e.findOne {
 			// The current rule/function doesn't support EE, but a caller (somewhere down the call stack) does.
+			e.instr.counterIncr(evalOpEarlyExitDeferred)
 			return &deferredEarlyExitError{prev: err, e: e}
 		}
 		return &earlyExitError{prev: err, e: e}
 	}
 
 	if e.cancel != nil && e.cancel.Cancelled() {
+		if tc, ok := e.cancel.(*timeoutCancel); ok && tc.TimedOut() {
+			return &Error{
+				Code:    TimeoutErr,
+				Message: "query evaluation timed out",
+			}
+		}
 		return &Error{
 			Code:    CancelErr,
 			Message: "caller cancelled query execution",
 		}
 	}
 
+	if e.maxDepth > 0 {
+		if *e.depth >= e.maxDepth {
+			var loc *ast.Location
+			if e.index < len(e.query) {
+				loc = e.query[e.index].Location
+			}
+			return e.depthLimitErr(loc)
+		}
+		*e.depth++
+		defer func() { *e.depth-- }()
+	}
+
 	if e.index >= len(e.query) {
 		err := iter(e)
 
@@ -344,6 +426,7 @@ func (e *eval) evalExpr(iter evalIterator) error {
 		}
 
 		if e.findOne && !e.partial() { // we've found one!
+			e.instr.counterIncr(evalOpEarlyExit)
 			return &earlyExitError{e: e}
 		}
 		return nil
@@ -791,6 +874,12 @@ func (e *eval) evalCall(terms []*ast.Term, iter unifyIterator) error {
 		return e.evalCallValue(len(bi.Decl.Args()), terms, mock, iter)
 	}
 
+	if e.allowedBuiltins != nil {
+		if _, ok := e.allowedBuiltins[builtinName]; !ok {
+			return capabilityErr(e.query[e.index].Location, builtinName)
+		}
+	}
+
 	if e.unknown(e.query[e.index], e.bindings) {
 		return e.saveCall(len(bi.Decl.Args()), terms, iter)
 	}
@@ -822,6 +911,10 @@ func (e *eval) evalCall(terms []*ast.Term, iter unifyIterator) error {
 		ParentID:               parentID,
 		PrintHook:              e.printHook,
 		DistributedTracingOpts: e.tracingOpts,
+		SpanThreshold:          e.builtinSpanThreshold,
+		HTTPTransportPool:      e.httpTransportPool,
+		store:                  e.store,
+		txn:                    e.txn,
 		Capabilities:           capabilities,
 	}
 
@@ -855,6 +948,13 @@ func (e *eval) unify(a, b *ast.Term, iter unifyIterator) error {
 }
 
 func (e *eval) biunify(a, b *ast.Term, b1, b2 *bindings, iter unifyIterator) error {
+	if e.maxDepth > 0 {
+		if *e.depth >= e.maxDepth {
+			return e.depthLimitErr(a.Location)
+		}
+		*e.depth++
+		defer func() { *e.depth-- }()
+	}
 	a, b1 = b1.apply(a)
 	b, b2 = b2.apply(b)
 	if e.traceEnabled {
@@ -955,8 +1055,8 @@ func (e *eval) biunifyObjectsRec(a, b ast.Object, b1, b2 *bindings, iter unifyIt
 func (e *eval) biunifyValues(a, b *ast.Term, b1, b2 *bindings, iter unifyIterator) error {
 	// Try to evaluate refs and comprehensions. If partial evaluation is
 	// enabled, then skip evaluation (and save the expression) if the term is
-	// in the save set. Currently, comprehensions are not evaluated during
-	// partial eval. This could be improved in the future.
+	// in the save set. Comprehensions unified with terms in the save set are
+	// only evaluated if enabled on the query (and they do not depend on unknowns.)
 
 	var saveA, saveB bool
 
@@ -983,6 +1083,15 @@ func (e *eval) biunifyValues(a, b *ast.Term, b1, b2 *bindings, iter unifyIterato
 	}
 
 	if saveA || saveB {
+		if e.partialComprehensions {
+			var err error
+			if a, err = e.partialEvalComprehension(a, b1); err != nil {
+				return err
+			}
+			if b, err = e.partialEvalComprehension(b, b2); err != nil {
+				return err
+			}
+		}
 		return e.saveUnify(a, b, b1, b2, iter)
 	}
 
@@ -1100,16 +1209,12 @@ func (e *eval) biunifyComprehension(a, b *ast.Term, b1, b2 *bindings, swap bool,
 
 	e.instr.counterIncr(evalOpComprehensionCacheMiss)
 
-	switch a := a.Value.(type) {
-	case *ast.ArrayComprehension:
-		return e.biunifyComprehensionArray(a, b, b1, b2, iter)
-	case *ast.SetComprehension:
-		return e.biunifyComprehensionSet(a, b, b1, b2, iter)
-	case *ast.ObjectComprehension:
-		return e.biunifyComprehensionObject(a, b, b1, b2, iter)
+	value, err = e.evalComprehension(a)
+	if err != nil {
+		return err
 	}
 
-	return internalErr(e.query[e.index].Location, "illegal comprehension type")
+	return e.biunify(value, b, b1, b2, iter)
 }
 
 func (e *eval) buildComprehensionCache(a *ast.Term) (*ast.Term, error) {
@@ -1270,49 +1375,58 @@ func (e *eval) amendComprehension(a *ast.Term, b1 *bindings) (*ast.Term, error)
 	return cpyA, nil
 }
 
-func (e *eval) biunifyComprehensionArray(x *ast.ArrayComprehension, b *ast.Term, b1, b2 *bindings, iter unifyIterator) error {
-	result := ast.NewArray()
-	child := e.closure(x.Body)
-	err := child.Run(func(child *eval) error {
-		result = result.Append(child.bindings.Plug(x.Term))
-		return nil
-	})
-	if err != nil {
-		return err
+// partialEvalComprehension returns the value of a if it is a comprehension that
+// does not depend on unknowns. Otherwise, a is returned as-is.
+func (e *eval) partialEvalComprehension(a *ast.Term, b1 *bindings) (*ast.Term, error) {
+	if !ast.IsComprehension(a.Value) || e.unknown(a, b1) {
+		return a, nil
 	}
-	return e.biunify(ast.NewTerm(result), b, b1, b2, iter)
+
+	return e.evalComprehension(a)
 }
 
-func (e *eval) biunifyComprehensionSet(x *ast.SetComprehension, b *ast.Term, b1, b2 *bindings, iter unifyIterator) error {
-	result := ast.NewSet()
-	child := e.closure(x.Body)
-	err := child.Run(func(child *eval) error {
-		result.Add(child.bindings.Plug(x.Term))
-		return nil
-	})
-	if err != nil {
-		return err
+// evalComprehension evaluates the comprehension a and returns its value.
+func (e *eval) evalComprehension(a *ast.Term) (*ast.Term, error) {
+	var result ast.Value
+	var err error
+
+	switch x := a.Value.(type) {
+	case *ast.ArrayComprehension:
+		arr := ast.NewArray()
+		err = e.closure(x.Body).Run(func(child *eval) error {
+			arr = arr.Append(child.bindings.Plug(x.Term))
+			return nil
+		})
+		result = arr
+	case *ast.SetComprehension:
+		set := ast.NewSet()
+		err = e.closure(x.Body).Run(func(child *eval) error {
+			set.Add(child.bindings.Plug(x.Term))
+			return nil
+		})
+		result = set
+	case *ast.ObjectComprehension:
+		obj := ast.NewObject()
+		err = e.closure(x.Body).Run(func(child *eval) error {
+			key := child.bindings.Plug(x.Key)
+			value := child.bindings.Plug(x.Value)
+			exist := obj.Get(key)
+			if exist != nil && !exist.Equal(value) {
+				return objectDocKeyConflictErr(x.Key.Location)
+			}
+			obj.Insert(key, value)
+			return nil
+		})
+		result = obj
+	default:
+		return nil, internalErr(e.query[e.index].Location, "illegal comprehension type")
 	}
-	return e.biunify(ast.NewTerm(result), b, b1, b2, iter)
-}
 
-func (e *eval) biunifyComprehensionObject(x *ast.ObjectComprehension, b *ast.Term, b1, b2 *bindings, iter unifyIterator) error {
-	result := ast.NewObject()
-	child := e.closure(x.Body)
-	err := child.Run(func(child *eval) error {
-		key := child.bindings.Plug(x.Key)
-		value := child.bindings.Plug(x.Value)
-		exist := result.Get(key)
-		if exist != nil && !exist.Equal(value) {
-			return objectDocKeyConflictErr(x.Key.Location)
-		}
-		result.Insert(key, value)
-		return nil
-	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return e.biunify(ast.NewTerm(result), b, b1, b2, iter)
+
+	return ast.NewTerm(result), nil
 }
 
 func (e *eval) saveExpr(expr *ast.Expr, b *bindings, iter unifyIterator) error {
@@ -1547,6 +1661,17 @@ func (e *evalResolver) Resolve(ref ast.Ref) (ast.Value, error) {
 			if !ok {
 				err = mergeConflictErr(ref[0].Location)
 			}
+		} else if realValue = e.e.interQueryBaseCacheGet(ref); realValue != nil {
+			e.e.baseCache.Put(ref, realValue)
+			if repValue == nil {
+				e.e.instr.stopTimer(evalOpResolve)
+				return realValue, nil
+			}
+			var ok bool
+			merged, ok = merge(repValue, realValue)
+			if !ok {
+				err = mergeConflictErr(ref[0].Location)
+			}
 		} else { // baseCache miss
 			e.e.instr.counterIncr(evalOpBaseCacheMiss)
 			merged, err = e.e.resolveReadFromStorage(ref, repValue)
@@ -1558,6 +1683,20 @@ func (e *evalResolver) Resolve(ref ast.Ref) (ast.Value, error) {
 	return nil, fmt.Errorf("illegal ref")
 }
 
+// interQueryBaseCacheGet returns the value cached for ref in the inter-query
+// base document cache or nil if the cache is not used or the value is missing.
+func (e *eval) interQueryBaseCacheGet(ref ast.Ref) ast.Value {
+	if e.interQueryBaseCache == nil || refContainsNonScalar(ref) {
+		return nil
+	}
+	if v := e.interQueryBaseCache.Get(e.interQueryBaseCacheGen, ref); v != nil {
+		e.instr.counterIncr(evalOpInterQueryBaseCacheHit)
+		return v
+	}
+	e.instr.counterIncr(evalOpInterQueryBaseCacheMiss)
+	return nil
+}
+
 func (e *eval) resolveReadFromStorage(ref ast.Ref, a ast.Value) (ast.Value, error) {
 	if refContainsNonScalar(ref) {
 		return a, nil
@@ -1612,18 +1751,9 @@ func (e *eval) resolveReadFromStorage(ref ast.Ref, a ast.Value) (ast.Value, erro
 			}
 		}
 
-		switch blob := blob.(type) {
-		case ast.Value:
-			v = blob
-		default:
-			if blob, ok := blob.(map[string]interface{}); ok && !e.strictObjects {
-				v = ast.LazyObject(blob)
-				break
-			}
-			v, err = ast.InterfaceToValue(blob)
-			if err != nil {
-				return nil, err
-			}
+		v, err = e.storageValue(ref, blob)
+		if err != nil {
+			return nil, err
 		}
 	}
 
@@ -1640,6 +1770,123 @@ func (e *eval) resolveReadFromStorage(ref ast.Ref, a ast.Value) (ast.Value, erro
 	return merged, nil
 }
 
+// storageValue converts blob read out of storage at ref into an AST value and
+// inserts it into the inter-query base document cache, if one is used.
+func (e *eval) storageValue(ref ast.Ref, blob interface{}) (ast.Value, error) {
+	var v ast.Value
+	switch blob := blob.(type) {
+	case ast.Value:
+		v = blob
+	default:
+		// Lazy objects are not safe for concurrent use, so values shared
+		// through the inter-query cache are converted eagerly.
+		if blob, ok := blob.(map[string]interface{}); ok && !e.strictObjects && e.interQueryBaseCache == nil {
+			v = ast.LazyObject(blob)
+			break
+		}
+		var err error
+		v, err = ast.InterfaceToValue(blob)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if e.interQueryBaseCache != nil {
+		e.interQueryBaseCache.Put(e.interQueryBaseCacheGen, ref, v)
+	}
+	return v, nil
+}
+
+// prefetch reads the base documents referred to by the ground prefixes of the
+// refs to data in query in a single batch, and inserts them into the base cache
+// so that they are not read one by one during evaluation. Prefetching is only
+// performed if the store implements storage.BatchReader. Refs that are already
+// cached, replaced by with statements, defined by rules, or that may be served
+// by external resolvers are skipped.
+func (e *eval) prefetch(query ast.Body) error {
+	if _, ok := e.store.(storage.BatchReader); !ok || len(e.external.children) > 0 || e.partial() {
+		return nil
+	}
+
+	var refs []ast.Ref
+	ast.WalkRefs(query, func(ref ast.Ref) bool {
+		if !ref.HasPrefix(ast.DefaultRootRef) {
+			return false
+		}
+		prefix := ref.GroundPrefix()
+		if len(prefix) < 2 || refContainsNonScalar(prefix) || e.targetStack.Prefixed(prefix) || e.virtual(prefix) || e.baseCache.Get(prefix) != nil {
+			return false
+		}
+		refs = append(refs, prefix)
+		return false
+	})
+
+	if len(refs) < 2 {
+		return nil
+	}
+
+	// Documents under other prefixes are read along with them.
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Compare(refs[j]) < 0
+	})
+
+	var keep []ast.Ref
+	var paths []storage.Path
+	for _, ref := range refs {
+		if len(keep) > 0 && ref.HasPrefix(keep[len(keep)-1]) {
+			continue
+		}
+		path, err := storage.NewPathForRef(ref)
+		if err != nil {
+			continue
+		}
+		keep = append(keep, ref)
+		paths = append(paths, path)
+	}
+
+	if len(paths) < 2 {
+		return nil
+	}
+
+	e.instr.startTimer(evalOpPrefetch)
+	defer e.instr.stopTimer(evalOpPrefetch)
+
+	results, err := storage.ReadBatch(e.ctx, e.store, e.txn, paths)
+	if err != nil {
+		return err
+	}
+
+	for i := range results {
+		if results[i].Err != nil {
+			if storage.IsNotFound(results[i].Err) {
+				continue
+			}
+			return results[i].Err
+		}
+		v, err := e.storageValue(keep[i], results[i].Value)
+		if err != nil {
+			return err
+		}
+		e.baseCache.Put(keep[i], v)
+	}
+
+	return nil
+}
+
+// virtual returns true if ref refers to a document defined by rules, or to a
+// document under one.
+func (e *eval) virtual(ref ast.Ref) bool {
+	node := e.compiler.RuleTree
+	for _, term := range ref {
+		if node = node.Child(term.Value); node == nil {
+			return false
+		} else if len(node.Values) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 func (e *eval) generateVar(suffix string) *ast.Term {
 	return ast.VarTerm(fmt.Sprintf("%v_%v", e.genvarprefix, suffix))
 }
@@ -1749,10 +1996,13 @@ func (e evalBuiltin) eval(iter unifyIterator) error {
 		e.e.instr.startTimer(evalOpBuiltinCall)
 	}
 
+	span := startBuiltinSpan(e.bctx, e.bi.Name)
+
 	// Normal unification flow for builtins:
 	err = e.f(e.bctx, operands, func(output *ast.Term) error {
 
 		e.e.instr.stopTimer(evalOpBuiltinCall)
+		span.end(nil)
 
 		var err error
 
@@ -1785,10 +2035,12 @@ func (e evalBuiltin) eval(iter unifyIterator) error {
 		return err
 	})
 
+	span.end(err)
+
 	if err != nil {
 		if t, ok := err.(Halt); ok {
 			err = t.Err
-		} else {
+		} else if _, ok := e.e.strictBuiltins[e.bi.Name]; !ok {
 			e.e.builtinErrors.errs = append(e.e.builtinErrors.errs, err)
 			err = nil
 		}
@@ -1819,9 +2071,9 @@ func (e evalFunc) eval(iter unifyIterator) error {
 	}
 
 	if len(e.ir.Else) > 0 && e.e.unknown(e.e.query[e.e.index], e.e.bindings) {
-		// Partial evaluation of ordered rules is not supported currently. Save the
-		// expression and continue. This could be revisited in the future.
-		return e.e.saveCall(argCount, e.terms, iter)
+		// Ordered rules cannot be inlined. Generate support rules that preserve
+		// the order of the else clauses instead.
+		return e.partialEvalSupport(argCount, iter)
 	}
 
 	if e.e.partial() && (e.e.inliningControl.shallow || e.e.inliningControl.Disabled(e.ref, false)) {
@@ -1854,7 +2106,7 @@ func (e evalFunc) evalValue(iter unifyIterator, argCount int, findOne bool) erro
 
 	var prev *ast.Term
 
-	return withSuppressEarlyExit(func() error {
+	return e.e.withSuppressEarlyExit(func() error {
 		var outerEe *deferredEarlyExitError
 		for _, rule := range e.ir.Rules {
 			next, err := e.evalOneRule(iter, rule, cacheKey, prev, findOne)
@@ -1932,7 +2184,7 @@ func (e evalFunc) evalCache(argCount int, iter unifyIterator) (ast.Ref, bool, er
 
 func (e evalFunc) evalOneRule(iter unifyIterator, rule *ast.Rule, cacheKey ast.Ref, prev *ast.Term, findOne bool) (*ast.Term, error) {
 
-	child := e.e.child(rule.Body)
+	child := e.e.child(e.e.ruleBody(rule))
 	child.findOne = findOne
 
 	args := make([]*ast.Term, len(e.terms)-1)
@@ -1945,10 +2197,13 @@ func (e evalFunc) evalOneRule(iter unifyIterator, rule *ast.Rule, cacheKey ast.R
 	var result *ast.Term
 
 	child.traceEnter(rule)
+	timer := e.e.ruleProfiler.start(rule)
 
 	err := child.biunifyArrays(ast.NewArray(e.terms[1:]...), ast.NewArray(args...), e.e.bindings, child.bindings, func() error {
 		return child.eval(func(child *eval) error {
 			child.traceExit(rule)
+			timer.pause()
+			defer timer.resume()
 
 			// Partial evaluation must save an expression that tests the output value if the output value
 			// was not captured to handle the case where the output value may be `false`.
@@ -1997,6 +2252,7 @@ func (e evalFunc) evalOneRule(iter unifyIterator, rule *ast.Rule, cacheKey ast.R
 			return nil
 		})
 	})
+	timer.stop(err)
 
 	return result, err
 }
@@ -2007,11 +2263,26 @@ func (e evalFunc) partialEvalSupport(declArgsLen int, iter unifyIterator) error
 	term := ast.NewTerm(path)
 
 	if !e.e.saveSupport.Exists(path) {
+		var support []*ast.Rule
 		for _, rule := range e.ir.Rules {
-			err := e.partialEvalSupportRule(rule, path)
-			if err != nil {
-				return err
+			branches := [][]*ast.Rule{}
+			for _, r := range append([]*ast.Rule{rule}, e.ir.Else[rule]...) {
+				rules, err := e.partialEvalSupportRule(r)
+				if err != nil {
+					return err
+				}
+				branches = append(branches, rules)
+			}
+			chain, ok := elseChain(branches)
+			if !ok {
+				// The order of the else clauses cannot be preserved. Save the
+				// call to the original function instead.
+				return e.e.saveCall(declArgsLen, e.terms, iter)
 			}
+			support = append(support, chain...)
+		}
+		for _, rule := range support {
+			e.e.saveSupport.Insert(path, rule)
 		}
 	}
 
@@ -2022,12 +2293,15 @@ func (e evalFunc) partialEvalSupport(declArgsLen int, iter unifyIterator) error
 	return e.e.saveCall(declArgsLen, append([]*ast.Term{term}, e.terms[1:]...), iter)
 }
 
-func (e evalFunc) partialEvalSupportRule(rule *ast.Rule, path ast.Ref) error {
+// partialEvalSupportRule partially evaluates the body of rule and returns the
+// support rules generated for it.
+func (e evalFunc) partialEvalSupportRule(rule *ast.Rule) ([]*ast.Rule, error) {
 
-	child := e.e.child(rule.Body)
+	child := e.e.child(e.e.ruleBody(rule))
 	child.traceEnter(rule)
 
 	e.e.saveStack.PushQuery(nil)
+	var support []*ast.Rule
 
 	// treat the function arguments as unknown during rule body evaluation
 	var args []*ast.Term
@@ -2056,10 +2330,12 @@ func (e evalFunc) partialEvalSupportRule(rule *ast.Rule, path ast.Ref) error {
 				head.Args[i] = child.bindings.PlugNamespaced(a, e.e.caller.bindings)
 			}
 
-			e.e.saveSupport.Insert(path, &ast.Rule{
+			r := &ast.Rule{
 				Head: head,
 				Body: plugged,
-			})
+			}
+			e.e.saveSupport.SetOrigin(r, rule)
+			support = append(support, r)
 		}
 		child.traceRedo(rule)
 		e.e.saveStack.PushQuery(current)
@@ -2068,7 +2344,75 @@ func (e evalFunc) partialEvalSupportRule(rule *ast.Rule, path ast.Ref) error {
 
 	e.e.saveSet.Pop()
 	e.e.saveStack.PopQuery()
-	return err
+	return support, err
+}
+
+// elseChain links the support rules generated for the branches of an ordered
+// rule (i.e., the rule followed by its else clauses) into a single rule. Branches
+// that did not generate any support rules can never be taken and are dropped.
+// If a branch generated more than one support rule, the order of the branches
+// cannot be expressed with else clauses and ok is false.
+func elseChain(branches [][]*ast.Rule) (chain []*ast.Rule, ok bool) {
+	if len(branches) == 1 {
+		return branches[0], true
+	}
+	var last *ast.Rule
+	for _, rules := range branches {
+		switch len(rules) {
+		case 0:
+			continue
+		case 1:
+		default:
+			return nil, false
+		}
+		if last == nil {
+			chain = rules
+		} else {
+			if !renameElseArgs(chain[0], rules[0]) {
+				return nil, false
+			}
+			last.Else = rules[0]
+		}
+		last = rules[0]
+	}
+	return chain, true
+}
+
+// renameElseArgs rewrites the arguments of the else clause so that they match
+// the arguments of the function that it belongs to. Each branch is evaluated
+// with its own bindings, so the namespaced argument vars differ between them.
+func renameElseArgs(rule, elseRule *ast.Rule) bool {
+	if len(rule.Head.Args) != len(elseRule.Head.Args) {
+		return false
+	}
+	rename := map[ast.Var]ast.Var{}
+	for i := range rule.Head.Args {
+		if rule.Head.Args[i].Equal(elseRule.Head.Args[i]) {
+			continue
+		}
+		v1, ok1 := rule.Head.Args[i].Value.(ast.Var)
+		v2, ok2 := elseRule.Head.Args[i].Value.(ast.Var)
+		if !ok1 || !ok2 {
+			return false
+		}
+		if _, ok := rename[v2]; ok {
+			return false
+		}
+		rename[v2] = v1
+	}
+	if len(rename) == 0 {
+		return true
+	}
+	f := func(v ast.Var) (ast.Value, error) {
+		if r, ok := rename[v]; ok {
+			return r, nil
+		}
+		return v, nil
+	}
+	// The head is copied as it shares the rule reference with the original rule.
+	elseRule.Head = elseRule.Head.Copy()
+	_, err := ast.TransformVars(elseRule, f)
+	return err == nil
 }
 
 type evalTree struct {
@@ -2319,9 +2663,10 @@ func (e evalVirtual) eval(iter unifyIterator) error {
 		return err
 	}
 
-	// Partial evaluation of ordered rules is not supported currently. Save the
-	// expression and continue. This could be revisited in the future.
-	if len(ir.Else) > 0 && e.e.unknown(e.ref, e.bindings) {
+	// Partial evaluation of ordered rules is only supported for complete
+	// documents (see evalVirtualComplete.partialEvalSupport.) Save the
+	// expression and continue otherwise.
+	if len(ir.Else) > 0 && e.e.unknown(e.ref, e.bindings) && !(ir.Kind == ast.SingleValue && ir.OnlyGroundRefs) {
 		return e.e.saveUnify(ast.NewTerm(e.ref), e.rterm, e.bindings, e.rbindings, iter)
 	}
 
@@ -2510,13 +2855,18 @@ func (e evalVirtualPartial) evalAllRules(iter unifyIterator, rules []*ast.Rule)
 }
 
 func (e evalVirtualPartial) evalAllRulesNoCache(rules []*ast.Rule) (*ast.Term, error) {
+	if e.parallel(rules) {
+		return e.evalAllRulesParallel(rules)
+	}
+
 	result := e.empty
 
 	var visitedRefs []ast.Ref
 
 	for _, rule := range rules {
-		child := e.e.child(rule.Body)
+		child := e.e.child(e.e.ruleBody(rule))
 		child.traceEnter(rule)
+		timer := e.e.ruleProfiler.start(rule)
 		err := child.eval(func(*eval) error {
 			child.traceExit(rule)
 			var err error
@@ -2528,6 +2878,7 @@ func (e evalVirtualPartial) evalAllRulesNoCache(rules []*ast.Rule) (*ast.Term, e
 			child.traceRedo(rule)
 			return nil
 		})
+		timer.stop(err)
 
 		if err != nil {
 			return nil, err
@@ -2537,6 +2888,86 @@ func (e evalVirtualPartial) evalAllRulesNoCache(rules []*ast.Rule) (*ast.Term, e
 	return result, nil
 }
 
+// parallel returns true if the rules can be evaluated concurrently. Concurrent
+// evaluation is only performed when it has been enabled on the query and the
+// evaluation does not depend on state that cannot be shared between goroutines
+// (e.g., partial evaluation, tracing, instrumentation, and with statements.)
+func (e evalVirtualPartial) parallel(rules []*ast.Rule) bool {
+	return e.e.parallelism > 1 && len(rules) > 1 && !e.e.partial() && !e.e.traceEnabled &&
+		e.e.instr == nil && e.e.ndBuiltinCache == nil && len(e.e.virtualCache.stack) == 1
+}
+
+// evalAllRulesParallel evaluates the rule bodies on a bounded number of
+// goroutines. Each rule body produces the plugged heads of the rule for every
+// solution. The heads are reduced into the result in rule order afterwards so
+// that the result (and conflict errors) are the same as in serial evaluation.
+func (e evalVirtualPartial) evalAllRulesParallel(rules []*ast.Rule) (*ast.Term, error) {
+	heads := make([][]*ast.Head, len(rules))
+	errs := make([]error, len(rules))
+	forks := make([]*eval, len(rules))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, e.e.parallelism)
+
+	for i := range rules {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			rule := rules[i]
+			child := e.e.fork(e.e.ruleBody(rule))
+			forks[i] = child
+			timer := e.e.ruleProfiler.start(rule)
+			errs[i] = child.eval(func(*eval) error {
+				heads[i] = append(heads[i], plugHead(rule.Head, child.bindings))
+				return nil
+			})
+			timer.stop(errs[i])
+		}(i)
+	}
+
+	wg.Wait()
+
+	result := e.empty
+	var visitedRefs []ast.Ref
+	b := newBindings(0, nil)
+
+	for i, rule := range rules {
+		e.e.builtinErrors.errs = append(e.e.builtinErrors.errs, forks[i].builtinErrors.errs...)
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		for _, head := range heads[i] {
+			var err error
+			result, _, err = e.reduce(&ast.Rule{Head: head, Module: rule.Module}, b, result, &visitedRefs)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// plugHead returns a copy of head where the key, value and reference have been
+// plugged with b.
+func plugHead(head *ast.Head, b *bindings) *ast.Head {
+	cpy := *head
+	if head.Key != nil {
+		cpy.Key = b.Plug(head.Key)
+	}
+	if head.Value != nil {
+		cpy.Value = b.Plug(head.Value)
+	}
+	if len(head.Reference) > 0 {
+		cpy.Reference = b.Plug(ast.NewTerm(head.Reference)).Value.(ast.Ref)
+	}
+	return &cpy
+}
+
 func wrapInObjects(leaf *ast.Term, ref ast.Ref) *ast.Term {
 	// We build the nested objects leaf-to-root to preserve ground:ness
 	if len(ref) == 0 {
@@ -2549,9 +2980,10 @@ func wrapInObjects(leaf *ast.Term, ref ast.Ref) *ast.Term {
 
 func (e evalVirtualPartial) evalOneRulePreUnify(iter unifyIterator, rule *ast.Rule, result *ast.Term, unknown bool, visitedRefs *[]ast.Ref) (*ast.Term, error) {
 
-	child := e.e.child(rule.Body)
+	child := e.e.child(e.e.ruleBody(rule))
 
 	child.traceEnter(rule)
+	timer := e.e.ruleProfiler.start(rule)
 	var defined bool
 
 	headKey := rule.Head.Key
@@ -2565,6 +2997,8 @@ func (e evalVirtualPartial) evalOneRulePreUnify(iter unifyIterator, rule *ast.Ru
 		return child.eval(func(child *eval) error {
 
 			child.traceExit(rule)
+			timer.pause()
+			defer timer.resume()
 
 			term := rule.Head.Value
 			if term == nil {
@@ -2602,6 +3036,7 @@ func (e evalVirtualPartial) evalOneRulePreUnify(iter unifyIterator, rule *ast.Ru
 			return nil
 		})
 	})
+	timer.stop(err)
 
 	if err != nil {
 		return nil, err
@@ -2641,17 +3076,21 @@ func (e *eval) biunifyDynamicRef(pos int, a, b ast.Ref, b1, b2 *bindings, iter u
 }
 
 func (e evalVirtualPartial) evalOneRulePostUnify(iter unifyIterator, rule *ast.Rule) error {
-	child := e.e.child(rule.Body)
+	child := e.e.child(e.e.ruleBody(rule))
 
 	child.traceEnter(rule)
+	timer := e.e.ruleProfiler.start(rule)
 	var defined bool
 
 	err := child.eval(func(child *eval) error {
 		defined = true
+		timer.pause()
+		defer timer.resume()
 		return e.e.biunifyRuleHead(e.pos+1, e.ref, rule, e.bindings, child.bindings, func(_ int) error {
 			return e.evalOneRuleContinue(iter, rule, child)
 		})
 	})
+	timer.stop(err)
 
 	if err != nil {
 		return err
@@ -2726,7 +3165,7 @@ func (e evalVirtualPartial) partialEvalSupport(iter unifyIterator) error {
 
 func (e evalVirtualPartial) partialEvalSupportRule(rule *ast.Rule, _ ast.Ref) (bool, error) {
 
-	child := e.e.child(rule.Body)
+	child := e.e.child(e.e.ruleBody(rule))
 	child.traceEnter(rule)
 
 	e.e.saveStack.PushQuery(nil)
@@ -2775,11 +3214,13 @@ func (e evalVirtualPartial) partialEvalSupportRule(rule *ast.Rule, _ ast.Ref) (b
 				plugged = applyCopyPropagation(cp, e.e.instr, plugged)
 			}
 
-			e.e.saveSupport.InsertByPkg(pkg, &ast.Rule{
+			r := &ast.Rule{
 				Head:    head,
 				Body:    plugged,
 				Default: rule.Default,
-			})
+			}
+			e.e.saveSupport.SetOrigin(r, rule)
+			e.e.saveSupport.InsertByPkg(pkg, r)
 		}
 		child.traceRedo(rule)
 		e.e.saveStack.PushQuery(current)
@@ -2988,7 +3429,8 @@ func (e evalVirtualComplete) eval(iter unifyIterator) error {
 		generateSupport = !ast.IsConstant(rterm.Value) || e.ir.Default.Head.Value.Equal(rterm)
 	}
 
-	if generateSupport || e.e.inliningControl.shallow || e.e.inliningControl.Disabled(e.plugged[:e.pos+1], false) {
+	// Ordered rules cannot be inlined, so support rules must be produced for them.
+	if generateSupport || len(e.ir.Else) > 0 || e.e.inliningControl.shallow || e.e.inliningControl.Disabled(e.plugged[:e.pos+1], false) {
 		return e.partialEvalSupport(iter)
 	}
 
@@ -3009,7 +3451,32 @@ func (e evalVirtualComplete) evalValue(iter unifyIterator, findOne bool) error {
 		return e.evalTerm(iter, cached, e.bindings)
 	}
 
-	return withSuppressEarlyExit(func() error {
+	interQueryCacheable := e.e.interQueryRuleCacheable(e.ir)
+	if interQueryCacheable {
+		if cached, found := e.e.interQueryRuleCache.c.Get(e.e.compiler, e.plugged[:e.pos+1]); found {
+			e.e.instr.counterIncr(evalOpInterQueryRuleCacheHit)
+			e.e.virtualCache.Put(e.plugged[:e.pos+1], cached)
+			if cached == nil {
+				return nil
+			}
+			return e.evalTerm(iter, cached, e.bindings)
+		}
+		e.e.instr.counterIncr(evalOpInterQueryRuleCacheMiss)
+	}
+
+	err := e.evalValueRules(iter, findOne)
+	if err == nil && interQueryCacheable {
+		// Only values computed to completion are shared with other queries.
+		if result, undefined := e.e.virtualCache.Get(e.plugged[:e.pos+1]); result != nil || undefined {
+			e.e.interQueryRuleCache.c.Insert(e.e.compiler, e.plugged[:e.pos+1], result)
+		}
+	}
+
+	return err
+}
+
+func (e evalVirtualComplete) evalValueRules(iter unifyIterator, findOne bool) error {
+	return e.e.withSuppressEarlyExit(func() error {
 		e.e.instr.counterIncr(evalOpVirtualCacheMiss)
 
 		var prev *ast.Term
@@ -3067,12 +3534,15 @@ func (e evalVirtualComplete) evalValue(iter unifyIterator, findOne bool) error {
 
 func (e evalVirtualComplete) evalValueRule(iter unifyIterator, rule *ast.Rule, prev *ast.Term, findOne bool) (*ast.Term, error) {
 
-	child := e.e.child(rule.Body)
+	child := e.e.child(e.e.ruleBody(rule))
 	child.findOne = findOne
 	child.traceEnter(rule)
+	timer := e.e.ruleProfiler.start(rule)
 	var result *ast.Term
 	err := child.eval(func(child *eval) error {
 		child.traceExit(rule)
+		timer.pause()
+		defer timer.resume()
 
 		result = child.bindings.Plug(rule.Head.Value)
 
@@ -3097,6 +3567,7 @@ func (e evalVirtualComplete) evalValueRule(iter unifyIterator, rule *ast.Rule, p
 		child.traceRedo(rule)
 		return nil
 	})
+	timer.stop(err)
 
 	return result, err
 }
@@ -3104,7 +3575,7 @@ func (e evalVirtualComplete) evalValueRule(iter unifyIterator, rule *ast.Rule, p
 func (e evalVirtualComplete) partialEval(iter unifyIterator) error {
 
 	for _, rule := range e.ir.Rules {
-		child := e.e.child(rule.Body)
+		child := e.e.child(e.e.ruleBody(rule))
 		child.traceEnter(rule)
 
 		err := child.eval(func(child *eval) error {
@@ -3138,24 +3609,42 @@ func (e evalVirtualComplete) partialEvalSupport(iter unifyIterator) error {
 	if e.e.saveSupport.Exists(path) {
 		defined = true
 	} else {
-		for i := range e.ir.Rules {
-			ok, err := e.partialEvalSupportRule(e.ir.Rules[i], path)
-			if err != nil {
-				return err
+		var support []*ast.Rule
+		for _, rule := range e.ir.Rules {
+			branches := [][]*ast.Rule{}
+			for _, r := range append([]*ast.Rule{rule}, e.ir.Else[rule]...) {
+				rules, ok, err := e.partialEvalSupportRule(r, path)
+				if err != nil {
+					return err
+				}
+				if ok {
+					defined = true
+				}
+				branches = append(branches, rules)
 			}
-			if ok {
-				defined = true
+			chain, ok := elseChain(branches)
+			if !ok {
+				// The order of the else clauses cannot be preserved. Save the
+				// reference to the original document instead.
+				return e.e.saveUnify(ast.NewTerm(e.ref), e.rterm, e.bindings, e.rbindings, iter)
 			}
+			support = append(support, chain...)
 		}
 
 		if e.ir.Default != nil {
-			ok, err := e.partialEvalSupportRule(e.ir.Default, path)
+			rules, ok, err := e.partialEvalSupportRule(e.ir.Default, path)
 			if err != nil {
 				return err
 			}
 			if ok {
 				defined = true
 			}
+			support = append(support, rules...)
+		}
+
+		pkg, _ := splitPackageAndRule(path)
+		for _, rule := range support {
+			e.e.saveSupport.InsertByPkg(pkg, rule)
 		}
 	}
 
@@ -3166,13 +3655,18 @@ func (e evalVirtualComplete) partialEvalSupport(iter unifyIterator) error {
 	return e.e.saveUnify(term, e.rterm, e.bindings, e.rbindings, iter)
 }
 
-func (e evalVirtualComplete) partialEvalSupportRule(rule *ast.Rule, path ast.Ref) (bool, error) {
+// partialEvalSupportRule partially evaluates the body of rule and returns the
+// support rules generated for it. The defined flag is set if the body could be
+// satisfied, even if the support rules were discarded because they failed to
+// type-check.
+func (e evalVirtualComplete) partialEvalSupportRule(rule *ast.Rule, path ast.Ref) ([]*ast.Rule, bool, error) {
 
-	child := e.e.child(rule.Body)
+	child := e.e.child(e.e.ruleBody(rule))
 	child.traceEnter(rule)
 
 	e.e.saveStack.PushQuery(nil)
 	var defined bool
+	var support []*ast.Rule
 
 	err := child.eval(func(child *eval) error {
 		child.traceExit(rule)
@@ -3183,7 +3677,7 @@ func (e evalVirtualComplete) partialEvalSupportRule(rule *ast.Rule, path ast.Ref
 		// Skip this rule body if it fails to type-check.
 		// Type-checking failure means the rule body will never succeed.
 		if e.e.compiler.PassesTypeCheck(plugged) {
-			pkg, ruleRef := splitPackageAndRule(path)
+			_, ruleRef := splitPackageAndRule(path)
 			head := ast.RefHead(ruleRef, child.bindings.PlugNamespaced(rule.Head.Value, e.e.caller.bindings))
 
 			if !e.e.inliningControl.shallow {
@@ -3193,18 +3687,20 @@ func (e evalVirtualComplete) partialEvalSupportRule(rule *ast.Rule, path ast.Ref
 				plugged = applyCopyPropagation(cp, e.e.instr, plugged)
 			}
 
-			e.e.saveSupport.InsertByPkg(pkg, &ast.Rule{
+			r := &ast.Rule{
 				Head:    head,
 				Body:    plugged,
 				Default: rule.Default,
-			})
+			}
+			e.e.saveSupport.SetOrigin(r, rule)
+			support = append(support, r)
 		}
 		child.traceRedo(rule)
 		e.e.saveStack.PushQuery(current)
 		return nil
 	})
 	e.e.saveStack.PopQuery()
-	return defined, err
+	return support, defined, err
 }
 
 func (e evalVirtualComplete) evalTerm(iter unifyIterator, term *ast.Term, termbindings *bindings) error {
@@ -3437,7 +3933,7 @@ func (e evalEvery) eval(iter unifyIterator) error {
 		child.traceRedo(e.expr)
 
 		// We don't want to abort the generator domain enumeration with EE.
-		return suppressEarlyExit(err)
+		return e.e.suppressEarlyExit(err)
 	})
 
 	if err != nil {
@@ -3777,18 +4273,20 @@ func refContainsNonScalar(ref ast.Ref) bool {
 	return false
 }
 
-func suppressEarlyExit(err error) error {
+func (e *eval) suppressEarlyExit(err error) error {
 	if ee, ok := err.(*earlyExitError); ok {
+		e.instr.counterIncr(evalOpEarlyExitSuppressed)
 		return ee.prev
 	} else if oee, ok := err.(*deferredEarlyExitError); ok {
+		e.instr.counterIncr(evalOpEarlyExitSuppressed)
 		return oee.prev
 	}
 	return err
 }
 
-func withSuppressEarlyExit(f func() error) error {
+func (e *eval) withSuppressEarlyExit(f func() error) error {
 	if err := f(); err != nil {
-		return suppressEarlyExit(err)
+		return e.suppressEarlyExit(err)
 	}
 	return nil
 }
@@ -7,6 +7,7 @@ package topdown
 import (
 	"context"
 	"encoding/json"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -1446,3 +1447,146 @@ func TestPartialRule(t *testing.T) {
 		})
 	}
 }
+
+func TestEnumerationOrder(t *testing.T) {
+	ctx := context.Background()
+
+	// Sets and objects are enumerated in sorted order regardless of the order
+	// that elements/keys were inserted in. This keeps traces and partial
+	// evaluation output stable between runs.
+	tests := []struct {
+		note  string
+		query string
+		exp   []string
+	}{
+		{
+			note:  "set",
+			query: `x = {"c", "a", "d", "b"}[_]`,
+			exp:   []string{`"a"`, `"b"`, `"c"`, `"d"`},
+		},
+		{
+			note:  "object",
+			query: `{"c": 1, "a": 2, "d": 3, "b": 4}[x]`,
+			exp:   []string{`"a"`, `"b"`, `"c"`, `"d"`},
+		},
+		{
+			note:  "base document",
+			query: `data.obj[x]`,
+			exp:   []string{`"a"`, `"b"`, `"c"`, `"d"`},
+		},
+		{
+			note:  "mixed types",
+			query: `x = {"a", 1, true, null, [1]}[_]`,
+			exp:   []string{`null`, `true`, `1`, `"a"`, `[1]`},
+		},
+	}
+
+	store := inmem.NewFromObject(map[string]interface{}{
+		"obj": map[string]interface{}{"d": 1, "c": 2, "b": 3, "a": 4},
+	})
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			compiler := ast.NewCompiler()
+			query, err := compiler.QueryCompiler().Compile(ast.MustParseBody(tc.query))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 10; i++ {
+				txn := storage.NewTransactionOrDie(ctx, store)
+				var act []string
+				err := NewQuery(query).
+					WithCompiler(compiler).
+					WithStore(store).
+					WithTransaction(txn).
+					Iter(ctx, func(qr QueryResult) error {
+						act = append(act, qr[ast.Var("x")].String())
+						return nil
+					})
+				store.Abort(ctx, txn)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if strings.Join(act, ",") != strings.Join(tc.exp, ",") {
+					t.Fatalf("Expected %v but got %v", tc.exp, act)
+				}
+			}
+		})
+	}
+}
+
+// batchStore counts the individual reads and the batches of reads performed
+// on the underlying store.
+type batchStore struct {
+	storage.Store
+	reads   []storage.Path
+	batches [][]storage.Path
+}
+
+func (s *batchStore) Read(ctx context.Context, txn storage.Transaction, path storage.Path) (interface{}, error) {
+	s.reads = append(s.reads, path)
+	return s.Store.Read(ctx, txn, path)
+}
+
+func (s *batchStore) ReadBatch(ctx context.Context, txn storage.Transaction, paths []storage.Path) ([]storage.BatchReadResult, error) {
+	s.batches = append(s.batches, paths)
+	results := make([]storage.BatchReadResult, len(paths))
+	for i := range paths {
+		results[i].Value, results[i].Err = s.Store.Read(ctx, txn, paths[i])
+	}
+	return results, nil
+}
+
+func TestPrefetchBatchReader(t *testing.T) {
+	ctx := context.Background()
+
+	compiler := compileModules([]string{
+		`package test
+
+		p := data.users[input.user].name
+
+		q := count(data.roles)
+
+		r { data.config.enabled }`,
+	})
+
+	store := &batchStore{
+		Store: inmem.NewFromObject(map[string]interface{}{
+			"users":  map[string]interface{}{"alice": map[string]interface{}{"name": "Alice"}},
+			"roles":  []interface{}{"admin", "dev"},
+			"config": map[string]interface{}{"enabled": true},
+		}),
+	}
+
+	txn := storage.NewTransactionOrDie(ctx, store)
+	defer store.Abort(ctx, txn)
+
+	query, err := compiler.QueryCompiler().Compile(ast.MustParseBody(`x = [data.test.p, data.test.q, data.test.r]; data.roles[_] = "dev"; data.users.alice`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	qrs, err := NewQuery(query).
+		WithCompiler(compiler).
+		WithStore(store).
+		WithTransaction(txn).
+		WithInput(ast.MustParseTerm(`{"user": "alice"}`)).
+		Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(qrs) != 1 || !qrs[0][ast.Var("x")].Equal(ast.MustParseTerm(`["Alice", 2, true]`)) {
+		t.Fatalf("Expected [\"Alice\", 2, true] but got %v", qrs)
+	}
+
+	// The query reads data.roles and data.users.alice at once. The rules read
+	// data.users and data.roles from the cache, and data.config on its own.
+	exp := [][]storage.Path{{storage.MustParsePath("/roles"), storage.MustParsePath("/users/alice")}}
+	if !reflect.DeepEqual(store.batches, exp) {
+		t.Fatalf("Expected batches %v but got %v", exp, store.batches)
+	}
+	if len(store.reads) != 1 || !store.reads[0].Equal(storage.MustParsePath("/config/enabled")) {
+		t.Fatalf("Expected only /config/enabled to be read individually but got %v", store.reads)
+	}
+}
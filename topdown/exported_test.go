@@ -45,6 +45,38 @@ func TestRegoWithNDBCache(t *testing.T) {
 	}
 }
 
+func TestRegoWithParallelism(t *testing.T) {
+	for _, tc := range cases.MustLoad("../test/cases/testdata").Sorted().Cases {
+		t.Run(tc.Note, func(t *testing.T) {
+			testRun(t, tc, func(q *Query) *Query {
+				q.tracers = nil // tracing disables concurrent evaluation
+				return q.WithParallelism(4)
+			})
+		})
+	}
+}
+
+func TestRegoWithMemoryPooling(t *testing.T) {
+	for _, tc := range cases.MustLoad("../test/cases/testdata").Sorted().Cases {
+		t.Run(tc.Note, func(t *testing.T) {
+			testRun(t, tc, func(q *Query) *Query {
+				q.tracers = nil // tracing disables pooling
+				return q.WithMemoryPooling(true).WithParallelism(4)
+			})
+		})
+	}
+}
+
+func TestRegoWithBodyReordering(t *testing.T) {
+	for _, tc := range cases.MustLoad("../test/cases/testdata").Sorted().Cases {
+		t.Run(tc.Note, func(t *testing.T) {
+			testRun(t, tc, func(q *Query) *Query {
+				return q.WithBodyReordering(true)
+			})
+		})
+	}
+}
+
 type opt func(*Query) *Query
 
 func testRun(t *testing.T, tc cases.TestCase, opts ...opt) {
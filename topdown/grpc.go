@@ -0,0 +1,653 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/topdown/builtins"
+	"github.com/open-policy-agent/opa/topdown/cache"
+	"github.com/open-policy-agent/opa/util"
+)
+
+const (
+	// GRPCSendInternalErr represents a runtime evaluation error.
+	GRPCSendInternalErr string = "eval_grpc_send_internal_error"
+
+	// grpcSendBuiltinCacheKey is the key in the builtin context cache that
+	// points to the grpc.send() specific cache resides at.
+	grpcSendBuiltinCacheKey grpcSendKey = "GRPC_SEND_CACHE_KEY"
+
+	// defaultGRPCCacheDuration is the freshness of responses cached across
+	// queries if cache_duration_seconds is not set.
+	defaultGRPCCacheDuration = time.Minute
+)
+
+type grpcSendKey string
+
+var (
+	grpcAllowedKeys = ast.NewSet(
+		ast.StringTerm("target"),
+		ast.StringTerm("method"),
+		ast.StringTerm("message"),
+		ast.StringTerm("metadata"),
+		ast.StringTerm("timeout"),
+		ast.StringTerm("plaintext"),
+		ast.StringTerm("descriptor_set_file"),
+		ast.StringTerm("tls_ca_cert"),
+		ast.StringTerm("tls_client_cert"),
+		ast.StringTerm("tls_client_key"),
+		ast.StringTerm("tls_insecure_skip_verify"),
+		ast.StringTerm("tls_server_name"),
+		ast.StringTerm("cache"),
+		ast.StringTerm("cache_duration_seconds"),
+		ast.StringTerm("raise_error"),
+	)
+	grpcRequiredKeys = ast.NewSet(ast.StringTerm("target"), ast.StringTerm("method"))
+
+	grpcSendLatencyMetricKey    = "rego_builtin_" + strings.ReplaceAll(ast.GRPCSend.Name, ".", "_")
+	grpcSendInterQueryCacheHits = grpcSendLatencyMetricKey + "_interquery_cache_hits"
+)
+
+// grpcRequest holds the parameters of a grpc.send request.
+type grpcRequest struct {
+	target            string
+	service           string
+	method            string
+	message           []byte
+	metadata          metadata.MD
+	timeout           time.Duration
+	plaintext         bool
+	descriptorSetFile string
+	tlsCaCert         []byte
+	tlsClientCert     []byte
+	tlsClientKey      []byte
+	tlsInsecure       bool
+	tlsServerName     string
+	cache             bool
+	cacheDuration     time.Duration
+}
+
+func builtinGRPCSend(bctx BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+
+	obj, err := builtins.ObjectOperand(operands[0].Value, 1)
+	if err != nil {
+		return handleBuiltinErr(ast.GRPCSend.Name, bctx.Location, err)
+	}
+
+	raiseError, err := getRaiseErrorValue(obj)
+	if err != nil {
+		return handleBuiltinErr(ast.GRPCSend.Name, bctx.Location, err)
+	}
+
+	result, err := getGRPCResponse(bctx, obj)
+	if err != nil {
+		if raiseError {
+			return handleGRPCSendErr(bctx, err)
+		}
+		result = generateGRPCRaiseErrorResult(err)
+	}
+
+	return iter(result)
+}
+
+func handleGRPCSendErr(bctx BuiltinContext, err error) error {
+	if err := bctx.Context.Err(); err != nil {
+		return Halt{
+			Err: &Error{
+				Code:    CancelErr,
+				Message: fmt.Sprintf("grpc.send: timed out (%s)", err.Error()),
+			},
+		}
+	}
+	return handleBuiltinErr(ast.GRPCSend.Name, bctx.Location, err)
+}
+
+func generateGRPCRaiseErrorResult(err error) *ast.Term {
+	errObj := ast.NewObject(
+		[2]*ast.Term{ast.StringTerm("code"), ast.StringTerm(GRPCSendInternalErr)},
+		[2]*ast.Term{ast.StringTerm("message"), ast.StringTerm(err.Error())},
+	)
+	return ast.ObjectTerm(
+		[2]*ast.Term{ast.StringTerm("status_code"), ast.IntNumberTerm(-1)},
+		[2]*ast.Term{ast.StringTerm("error"), ast.NewTerm(errObj)},
+	)
+}
+
+func getGRPCResponse(bctx BuiltinContext, obj ast.Object) (*ast.Term, error) {
+
+	req, err := parseGRPCRequest(bctx, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	bctx.Metrics.Timer(grpcSendLatencyMetricKey).Start()
+	defer bctx.Metrics.Timer(grpcSendLatencyMetricKey).Stop()
+
+	// Responses are always cached within the query. The raise_error field
+	// does not affect the response and is not part of the key.
+	key := obj.Copy()
+	key.Insert(ast.StringTerm("raise_error"), ast.NullTerm())
+
+	intraQueryCache := getGRPCSendCache(bctx)
+	if v := intraQueryCache.Get(key); v != nil {
+		return ast.NewTerm(v), nil
+	}
+
+	var interQueryKey ast.Value
+	if req.cache && bctx.InterQueryBuiltinCache != nil {
+		interQueryKey = ast.NewArray(ast.StringTerm(ast.GRPCSend.Name), ast.NewTerm(key))
+		if v, ok := bctx.InterQueryBuiltinCache.Get(interQueryKey); ok {
+			if cached, ok := v.(*grpcInterQueryCacheValue); ok {
+				resp, err := cached.value()
+				if err != nil {
+					return nil, err
+				}
+				bctx.Metrics.Counter(grpcSendInterQueryCacheHits).Incr()
+				intraQueryCache.Put(key, resp)
+				return ast.NewTerm(resp), nil
+			}
+		}
+	}
+
+	resp, err := executeGRPCRequest(bctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	intraQueryCache.Put(key, resp)
+
+	if interQueryKey != nil {
+		cached, err := newGRPCInterQueryCacheValue(resp)
+		if err != nil {
+			return nil, err
+		}
+		bctx.InterQueryBuiltinCache.InsertWithExpiry(interQueryKey, cached, time.Now().Add(req.cacheDuration))
+	}
+
+	return ast.NewTerm(resp), nil
+}
+
+func parseGRPCRequest(bctx BuiltinContext, obj ast.Object) (*grpcRequest, error) {
+
+	keys := ast.NewSet(obj.Keys()...)
+
+	if invalidKeys := keys.Diff(grpcAllowedKeys); invalidKeys.Len() != 0 {
+		return nil, builtins.NewOperandErr(1, "invalid request parameters(s): %v", invalidKeys)
+	}
+
+	if missingKeys := grpcRequiredKeys.Diff(keys); missingKeys.Len() != 0 {
+		return nil, builtins.NewOperandErr(1, "missing required request parameters(s): %v", missingKeys)
+	}
+
+	req := grpcRequest{
+		timeout:       defaultHTTPRequestTimeout,
+		cacheDuration: defaultGRPCCacheDuration,
+		message:       []byte("{}"),
+		metadata:      metadata.MD{},
+	}
+
+	var err error
+
+	for _, k := range obj.Keys() {
+		key := string(k.Value.(ast.String))
+		val := obj.Get(k).Value
+
+		switch key {
+		case "target", "method", "descriptor_set_file", "tls_ca_cert", "tls_client_cert", "tls_client_key", "tls_server_name":
+			s, ok := val.(ast.String)
+			if !ok {
+				return nil, fmt.Errorf("%q must be a string", key)
+			}
+			switch key {
+			case "target":
+				req.target = string(s)
+			case "method":
+				req.service, req.method, err = parseGRPCMethod(string(s))
+				if err != nil {
+					return nil, err
+				}
+			case "descriptor_set_file":
+				req.descriptorSetFile = string(s)
+			case "tls_ca_cert":
+				req.tlsCaCert = []byte(s)
+			case "tls_client_cert":
+				req.tlsClientCert = []byte(s)
+			case "tls_client_key":
+				req.tlsClientKey = []byte(s)
+			case "tls_server_name":
+				req.tlsServerName = string(s)
+			}
+		case "plaintext", "tls_insecure_skip_verify", "cache":
+			b, ok := val.(ast.Boolean)
+			if !ok {
+				return nil, fmt.Errorf("%q must be a boolean", key)
+			}
+			switch key {
+			case "plaintext":
+				req.plaintext = bool(b)
+			case "tls_insecure_skip_verify":
+				req.tlsInsecure = bool(b)
+			case "cache":
+				req.cache = bool(b)
+			}
+		case "message":
+			if _, ok := val.(ast.Object); !ok {
+				return nil, fmt.Errorf("%q must be an object", key)
+			}
+			x, err := ast.JSON(val)
+			if err != nil {
+				return nil, err
+			}
+			if req.message, err = json.Marshal(x); err != nil {
+				return nil, err
+			}
+		case "metadata":
+			var md map[string]string
+			if err := ast.As(val, &md); err != nil {
+				return nil, fmt.Errorf("%q must be an object of strings", key)
+			}
+			for k, v := range md {
+				req.metadata.Append(k, v)
+			}
+		case "timeout":
+			if req.timeout, err = parseTimeout(val); err != nil {
+				return nil, err
+			}
+		case "cache_duration_seconds":
+			n, err := getNumberValFromReqObj(obj, k)
+			if err != nil {
+				return nil, err
+			}
+			req.cacheDuration = time.Duration(n) * time.Second
+		}
+	}
+
+	host, _, err := net.SplitHostPort(req.target)
+	if err != nil {
+		host = req.target
+	}
+	if err := verifyHost(bctx, host); err != nil {
+		return nil, err
+	}
+
+	return &req, nil
+}
+
+// parseGRPCMethod splits a method name of the form "package.Service/Method"
+// (optionally with a leading slash) into the service and method names.
+func parseGRPCMethod(s string) (string, string, error) {
+	s = strings.TrimPrefix(s, "/")
+	i := strings.LastIndex(s, "/")
+	if i <= 0 || i == len(s)-1 {
+		return "", "", fmt.Errorf("invalid method %q, must be of the form \"package.Service/Method\"", s)
+	}
+	return s[:i], s[i+1:], nil
+}
+
+func executeGRPCRequest(bctx BuiltinContext, req *grpcRequest) (ast.Value, error) {
+
+	creds, err := grpcTransportCredentials(req)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if len(bctx.DistributedTracingOpts) > 0 {
+		opts = append(opts, grpc.WithUnaryInterceptor(grpcTracingInterceptor))
+	}
+
+	conn, err := grpc.NewClient(req.target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(bctx.Context, req.timeout)
+	defer cancel()
+
+	files, err := resolveGRPCDescriptors(ctx, conn, req)
+	if err != nil {
+		return nil, err
+	}
+
+	md, err := findGRPCMethod(files, req.service, req.method)
+	if err != nil {
+		return nil, err
+	}
+
+	types := dynamicpb.NewTypes(files)
+
+	in := dynamicpb.NewMessage(md.Input())
+	if err := (protojson.UnmarshalOptions{Resolver: types}).Unmarshal(req.message, in); err != nil {
+		return nil, fmt.Errorf("invalid message for %v: %w", md.Input().FullName(), err)
+	}
+
+	out := dynamicpb.NewMessage(md.Output())
+
+	var header, trailer metadata.MD
+	ctx = metadata.NewOutgoingContext(ctx, req.metadata)
+	invokeErr := conn.Invoke(ctx, fmt.Sprintf("/%s/%s", req.service, req.method), in, out, grpc.Header(&header), grpc.Trailer(&trailer))
+
+	st, ok := status.FromError(invokeErr)
+	if !ok {
+		return nil, invokeErr
+	}
+
+	resp := ast.NewObject(
+		[2]*ast.Term{ast.StringTerm("status_code"), ast.IntNumberTerm(int(st.Code()))},
+		[2]*ast.Term{ast.StringTerm("status"), ast.StringTerm(st.Code().String())},
+		[2]*ast.Term{ast.StringTerm("headers"), ast.NewTerm(grpcMetadataToValue(header))},
+		[2]*ast.Term{ast.StringTerm("trailers"), ast.NewTerm(grpcMetadataToValue(trailer))},
+	)
+
+	if invokeErr != nil {
+		resp.Insert(ast.StringTerm("error"), ast.ObjectTerm(
+			[2]*ast.Term{ast.StringTerm("message"), ast.StringTerm(st.Message())},
+		))
+		return resp, nil
+	}
+
+	bs, err := (protojson.MarshalOptions{Resolver: types, UseProtoNames: true, EmitUnpopulated: true}).Marshal(out)
+	if err != nil {
+		return nil, err
+	}
+
+	var x interface{}
+	if err := util.UnmarshalJSON(bs, &x); err != nil {
+		return nil, err
+	}
+
+	msg, err := ast.InterfaceToValue(x)
+	if err != nil {
+		return nil, err
+	}
+	resp.Insert(ast.StringTerm("message"), ast.NewTerm(msg))
+
+	return resp, nil
+}
+
+func grpcTransportCredentials(req *grpcRequest) (credentials.TransportCredentials, error) {
+	if req.plaintext {
+		return insecure.NewCredentials(), nil
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: req.tlsInsecure, // nolint: gosec // explicitly requested by the caller
+		ServerName:         req.tlsServerName,
+	}
+
+	if len(req.tlsCaCert) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(req.tlsCaCert) {
+			return nil, fmt.Errorf("could not append CA certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if len(req.tlsClientCert) > 0 || len(req.tlsClientKey) > 0 {
+		cert, err := tls.X509KeyPair(req.tlsClientCert, req.tlsClientKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// resolveGRPCDescriptors returns the file descriptors for the requested
+// service, either from the descriptor set file or through server reflection.
+func resolveGRPCDescriptors(ctx context.Context, conn *grpc.ClientConn, req *grpcRequest) (*protoregistry.Files, error) {
+	var set descriptorpb.FileDescriptorSet
+
+	if req.descriptorSetFile != "" {
+		bs, err := os.ReadFile(req.descriptorSetFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := proto.Unmarshal(bs, &set); err != nil {
+			return nil, fmt.Errorf("invalid descriptor set file %v: %w", req.descriptorSetFile, err)
+		}
+		return protodesc.NewFiles(&set)
+	}
+
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("server reflection: %w", err)
+	}
+	defer stream.CloseSend() // nolint: errcheck
+
+	seen := map[string]struct{}{}
+	queue := []*rpb.ServerReflectionRequest{{
+		MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: req.service},
+	}}
+
+	for len(queue) > 0 {
+		if err := stream.Send(queue[0]); err != nil {
+			return nil, fmt.Errorf("server reflection: %w", err)
+		}
+		queue = queue[1:]
+
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("server reflection: %w", err)
+		}
+
+		if e := resp.GetErrorResponse(); e != nil {
+			return nil, fmt.Errorf("server reflection: %v", e.GetErrorMessage())
+		}
+
+		for _, bs := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			var fd descriptorpb.FileDescriptorProto
+			if err := proto.Unmarshal(bs, &fd); err != nil {
+				return nil, fmt.Errorf("server reflection: %w", err)
+			}
+			if _, ok := seen[fd.GetName()]; ok {
+				continue
+			}
+			seen[fd.GetName()] = struct{}{}
+			set.File = append(set.File, &fd)
+		}
+
+		// Servers may omit dependencies that they have already sent on the
+		// stream or that they expect the client to know, request any that are
+		// still missing by name.
+		if len(queue) == 0 {
+			for _, fd := range set.File {
+				for _, dep := range fd.GetDependency() {
+					if _, ok := seen[dep]; ok {
+						continue
+					}
+					seen[dep] = struct{}{}
+					queue = append(queue, &rpb.ServerReflectionRequest{
+						MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{FileByFilename: dep},
+					})
+				}
+			}
+		}
+	}
+
+	return protodesc.NewFiles(&set)
+}
+
+func findGRPCMethod(files *protoregistry.Files, service, method string) (protoreflect.MethodDescriptor, error) {
+	d, err := files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, fmt.Errorf("service %v not found: %w", service, err)
+	}
+
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%v is not a service", service)
+	}
+
+	md := sd.Methods().ByName(protoreflect.Name(method))
+	if md == nil {
+		return nil, fmt.Errorf("method %v not found in service %v", method, service)
+	}
+
+	if md.IsStreamingClient() || md.IsStreamingServer() {
+		return nil, fmt.Errorf("method %v/%v is not unary", service, method)
+	}
+
+	return md, nil
+}
+
+func grpcMetadataToValue(md metadata.MD) ast.Value {
+	obj := ast.NewObject()
+	for k, vs := range md {
+		arr := make([]*ast.Term, len(vs))
+		for i, v := range vs {
+			arr[i] = ast.StringTerm(v)
+		}
+		obj.Insert(ast.StringTerm(k), ast.ArrayTerm(arr...))
+	}
+	return obj
+}
+
+// grpcTracingInterceptor records a client span for the call and propagates the
+// trace context to the server. Spans are created with the tracer provider of
+// the span in the evaluation context, e.g., the span of the server request
+// that the query is evaluated for.
+func grpcTracingInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	tracer := trace.SpanFromContext(ctx).TracerProvider().Tracer(tracerName)
+	ctx, span := tracer.Start(ctx, method, trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	propagation.TraceContext{}.Inject(ctx, grpcMetadataCarrier(md))
+
+	err := invoker(metadata.NewOutgoingContext(ctx, md), method, req, reply, cc, opts...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+type grpcMetadataCarrier metadata.MD
+
+func (c grpcMetadataCarrier) Get(key string) string {
+	if vs := metadata.MD(c).Get(key); len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
+
+func (c grpcMetadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c grpcMetadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// In the BuiltinContext cache we only store a single entry that points to
+// our ValueMap which is the "real" grpc.send() cache.
+func getGRPCSendCache(bctx BuiltinContext) *ast.ValueMap {
+	raw, ok := bctx.Cache.Get(grpcSendBuiltinCacheKey)
+	if !ok {
+		c := ast.NewValueMap()
+		bctx.Cache.Put(grpcSendBuiltinCacheKey, c)
+		return c
+	}
+	return raw.(*ast.ValueMap)
+}
+
+// grpcInterQueryCacheValue holds a serialized grpc.send response in the
+// inter-query cache.
+type grpcInterQueryCacheValue struct {
+	Data []byte
+}
+
+func newGRPCInterQueryCacheValue(resp ast.Value) (*grpcInterQueryCacheValue, error) {
+	x, err := ast.JSON(resp)
+	if err != nil {
+		return nil, err
+	}
+	bs, err := json.Marshal(x)
+	if err != nil {
+		return nil, err
+	}
+	return &grpcInterQueryCacheValue{Data: bs}, nil
+}
+
+func (v *grpcInterQueryCacheValue) value() (ast.Value, error) {
+	var x interface{}
+	if err := util.UnmarshalJSON(v.Data, &x); err != nil {
+		return nil, err
+	}
+	return ast.InterfaceToValue(x)
+}
+
+func (v grpcInterQueryCacheValue) SizeInBytes() int64 {
+	return int64(len(v.Data))
+}
+
+func (v grpcInterQueryCacheValue) Clone() (cache.InterQueryCacheValue, error) {
+	dup := make([]byte, len(v.Data))
+	copy(dup, v.Data)
+	return &grpcInterQueryCacheValue{Data: dup}, nil
+}
+
+func (grpcInterQueryCacheValue) PersistentType() string {
+	return ast.GRPCSend.Name
+}
+
+func (v grpcInterQueryCacheValue) MarshalPersistent() ([]byte, error) {
+	return v.Data, nil
+}
+
+func decodeGRPCInterQueryCacheValue(bs []byte) (cache.InterQueryCacheValue, error) {
+	v := &grpcInterQueryCacheValue{Data: bs}
+	if _, err := v.value(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func init() {
+	RegisterBuiltinFunc(ast.GRPCSend.Name, builtinGRPCSend)
+	cache.RegisterPersistentType(ast.GRPCSend.Name, decodeGRPCInterQueryCacheValue)
+}
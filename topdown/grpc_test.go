@@ -0,0 +1,260 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/metrics"
+	iCache "github.com/open-policy-agent/opa/topdown/cache"
+)
+
+var grpcTestFile = &descriptorpb.FileDescriptorProto{
+	Name:    proto.String("test/greeter.proto"),
+	Package: proto.String("test"),
+	Syntax:  proto.String("proto3"),
+	MessageType: []*descriptorpb.DescriptorProto{
+		{
+			Name: proto.String("HelloRequest"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("name"), JsonName: proto.String("name"), Number: proto.Int32(1), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()},
+			},
+		},
+		{
+			Name: proto.String("HelloReply"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("message"), JsonName: proto.String("message"), Number: proto.Int32(1), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()},
+				{Name: proto.String("request_count"), JsonName: proto.String("requestCount"), Number: proto.Int32(2), Type: descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()},
+			},
+		},
+	},
+	Service: []*descriptorpb.ServiceDescriptorProto{
+		{
+			Name: proto.String("Greeter"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				{Name: proto.String("SayHello"), InputType: proto.String(".test.HelloRequest"), OutputType: proto.String(".test.HelloReply")},
+			},
+		},
+	},
+}
+
+type grpcTestServer struct {
+	addr     string
+	requests int32
+}
+
+type grpcTestServices struct{}
+
+func (grpcTestServices) GetServiceInfo() map[string]grpc.ServiceInfo {
+	return map[string]grpc.ServiceInfo{"test.Greeter": {}}
+}
+
+// startGRPCTestServer starts a server for the test.Greeter service. The server
+// is implemented with dynamic messages so that no generated code is needed.
+func startGRPCTestServer(t *testing.T, withReflection bool) *grpcTestServer {
+	t.Helper()
+
+	fd, err := protodesc.NewFile(grpcTestFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := &protoregistry.Files{}
+	if err := files.RegisterFile(fd); err != nil {
+		t.Fatal(err)
+	}
+	md := fd.Services().Get(0).Methods().Get(0)
+
+	ts := &grpcTestServer{}
+
+	s := grpc.NewServer(grpc.UnknownServiceHandler(func(_ interface{}, stream grpc.ServerStream) error {
+		if method, _ := grpc.MethodFromServerStream(stream); method != "/test.Greeter/SayHello" {
+			return status.Errorf(codes.Unimplemented, "unknown method %v", method)
+		}
+
+		in := dynamicpb.NewMessage(md.Input())
+		if err := stream.RecvMsg(in); err != nil {
+			return err
+		}
+
+		name := in.Get(md.Input().Fields().ByName("name")).String()
+		if name == "" {
+			return status.Error(codes.InvalidArgument, "name is required")
+		}
+
+		count := atomic.AddInt32(&ts.requests, 1)
+
+		if err := stream.SetHeader(metadata.Pairs("x-greeting", "yes")); err != nil {
+			return err
+		}
+
+		out := dynamicpb.NewMessage(md.Output())
+		out.Set(md.Output().Fields().ByName("message"), protoreflect.ValueOfString("hello "+name))
+		out.Set(md.Output().Fields().ByName("request_count"), protoreflect.ValueOfInt32(count))
+		return stream.SendMsg(out)
+	}))
+
+	if withReflection {
+		rpb.RegisterServerReflectionServer(s, reflection.NewServerV1(reflection.ServerOptions{
+			Services:           grpcTestServices{},
+			DescriptorResolver: files,
+		}))
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		_ = s.Serve(l)
+	}()
+	t.Cleanup(s.Stop)
+
+	ts.addr = l.Addr().String()
+	return ts
+}
+
+func runGRPCSendQuery(t *testing.T, query string, interQueryCache iCache.InterQueryCache, m metrics.Metrics) ast.Value {
+	t.Helper()
+
+	compiled, err := ast.NewCompiler().QueryCompiler().Compile(ast.MustParseBody(fmt.Sprintf("x = %v", query)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := NewQuery(compiled).
+		WithInterQueryBuiltinCache(interQueryCache).
+		WithStrictBuiltinErrors(true)
+	if m != nil {
+		q = q.WithMetrics(m)
+	}
+
+	qrs, err := q.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if len(qrs) != 1 {
+		t.Fatalf("Expected one result but got %v", qrs)
+	}
+
+	return qrs[0][ast.Var("x")].Value
+}
+
+func TestGRPCSend(t *testing.T) {
+	ts := startGRPCTestServer(t, true)
+
+	result := runGRPCSendQuery(t, fmt.Sprintf(`grpc.send({"target": %q, "method": "test.Greeter/SayHello", "message": {"name": "bob"}, "plaintext": true})`, ts.addr), nil, nil)
+
+	exp := ast.MustParseTerm(`{"status_code": 0, "status": "OK", "message": {"message": "hello bob", "request_count": 1}, "headers": {"content-type": ["application/grpc"], "x-greeting": ["yes"]}, "trailers": {}}`)
+	if result.Compare(exp.Value) != 0 {
+		t.Fatalf("Expected %v but got %v", exp, result)
+	}
+
+	result = runGRPCSendQuery(t, fmt.Sprintf(`grpc.send({"target": %q, "method": "/test.Greeter/SayHello", "message": {}, "plaintext": true})`, ts.addr), nil, nil)
+
+	obj := result.(ast.Object)
+	if code := obj.Get(ast.StringTerm("status_code")); !code.Equal(ast.IntNumberTerm(int(codes.InvalidArgument))) {
+		t.Fatalf("Expected InvalidArgument status but got %v", result)
+	}
+	if msg := obj.Get(ast.StringTerm("error")); !msg.Equal(ast.MustParseTerm(`{"message": "name is required"}`)) {
+		t.Fatalf("Unexpected error %v", msg)
+	}
+}
+
+func TestGRPCSendDescriptorSetFile(t *testing.T) {
+	ts := startGRPCTestServer(t, false)
+
+	bs, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{grpcTestFile}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "greeter.protoset")
+	if err := os.WriteFile(path, bs, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result := runGRPCSendQuery(t, fmt.Sprintf(`grpc.send({"target": %q, "method": "test.Greeter/SayHello", "message": {"name": "alice"}, "plaintext": true, "descriptor_set_file": %q}).message`, ts.addr, path), nil, nil)
+
+	if exp := ast.MustParseTerm(`{"message": "hello alice", "request_count": 1}`); result.Compare(exp.Value) != 0 {
+		t.Fatalf("Expected %v but got %v", exp, result)
+	}
+
+	// Without reflection or a descriptor set, the method cannot be resolved.
+	result = runGRPCSendQuery(t, fmt.Sprintf(`grpc.send({"target": %q, "method": "test.Greeter/SayHello", "plaintext": true, "raise_error": false}).error.code`, ts.addr), nil, nil)
+	if result.Compare(ast.String(GRPCSendInternalErr)) != 0 {
+		t.Fatalf("Expected internal error but got %v", result)
+	}
+}
+
+func TestGRPCSendCaching(t *testing.T) {
+	ts := startGRPCTestServer(t, true)
+
+	config, _ := iCache.ParseCachingConfig(nil)
+	interQueryCache := iCache.NewInterQueryCache(config)
+
+	query := fmt.Sprintf(`grpc.send({"target": %q, "method": "test.Greeter/SayHello", "message": {"name": "bob"}, "plaintext": true, "cache": true}).message.request_count`, ts.addr)
+
+	// Identical requests are sent once per query.
+	result := runGRPCSendQuery(t, fmt.Sprintf("[%v, %v]", query, query), nil, nil)
+	if exp := ast.MustParseTerm(`[1, 1]`); result.Compare(exp.Value) != 0 {
+		t.Fatalf("Expected %v but got %v", exp, result)
+	}
+
+	// Responses are reused across queries when the inter-query cache is set.
+	m := metrics.New()
+	for i := 0; i < 2; i++ {
+		result = runGRPCSendQuery(t, query, interQueryCache, m)
+		if exp := ast.IntNumberTerm(2); result.Compare(exp.Value) != 0 {
+			t.Fatalf("Expected %v but got %v", exp, result)
+		}
+	}
+
+	if exp, act := uint64(1), m.Counter(grpcSendInterQueryCacheHits).Value(); exp != act {
+		t.Fatalf("Expected %d cache hits but got %d", exp, act)
+	}
+}
+
+func TestGRPCSendInvalidRequest(t *testing.T) {
+	tests := []struct {
+		note    string
+		request string
+		exp     string
+	}{
+		{note: "missing method", request: `{"target": "localhost:1"}`, exp: "missing required request parameters(s)"},
+		{note: "invalid key", request: `{"target": "localhost:1", "method": "a.B/C", "foo": 1}`, exp: "invalid request parameters(s)"},
+		{note: "invalid method", request: `{"target": "localhost:1", "method": "C"}`, exp: `invalid method "C"`},
+		{note: "invalid message", request: `{"target": "localhost:1", "method": "a.B/C", "message": "x"}`, exp: `"message" must be an object`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			q := NewQuery(ast.MustParseBody(fmt.Sprintf("grpc.send(%v)", tc.request))).WithStrictBuiltinErrors(true)
+			_, err := q.Run(context.Background())
+			if err == nil || !strings.Contains(err.Error(), tc.exp) {
+				t.Fatalf("Expected error containing %q but got %v", tc.exp, err)
+			}
+		})
+	}
+}
@@ -36,6 +36,10 @@ const (
 	defaultHTTPRequestTimeoutEnv             = "HTTP_SEND_TIMEOUT"
 	defaultCachingMode           cachingMode = "serialized"
 	cachingModeDeserialized      cachingMode = "deserialized"
+
+	// types of the http.send values of the inter-query cache when persisted
+	httpSendPersistentType             = "http.send"
+	httpSendDeserializedPersistentType = "http.send/deserialized"
 )
 
 var defaultHTTPRequestTimeout = time.Second * 5
@@ -246,6 +250,8 @@ func init() {
 	createCacheableHTTPStatusCodes()
 	initDefaults()
 	RegisterBuiltinFunc(ast.HTTPSend.Name, builtinHTTPSend)
+	cache.RegisterPersistentType(httpSendPersistentType, decodeInterQueryCacheValue)
+	cache.RegisterPersistentType(httpSendDeserializedPersistentType, decodeInterQueryCacheData)
 }
 
 func handleHTTPSendErr(bctx BuiltinContext, err error) error {
@@ -584,6 +590,10 @@ func createHTTPRequest(bctx BuiltinContext, obj ast.Object) (*http.Request, *htt
 		tlsUseSystemCerts = &trueValue
 	}
 
+	// rootCAs identifies the certificates added to tlsConfig.RootCAs, which
+	// cannot be compared when selecting a pooled transport.
+	var rootCAs [][]byte
+
 	// Check the system certificates config first so that we
 	// load additional certificated into the correct pool.
 	if tlsUseSystemCerts != nil && *tlsUseSystemCerts && runtime.GOOS != "windows" {
@@ -594,6 +604,7 @@ func createHTTPRequest(bctx BuiltinContext, obj ast.Object) (*http.Request, *htt
 
 		isTLS = true
 		tlsConfig.RootCAs = pool
+		rootCAs = append(rootCAs, []byte("system"))
 	}
 
 	if len(tlsCaCert) != 0 {
@@ -605,32 +616,41 @@ func createHTTPRequest(bctx BuiltinContext, obj ast.Object) (*http.Request, *htt
 
 		isTLS = true
 		tlsConfig.RootCAs = pool
+		rootCAs = append(rootCAs, tlsCaCert)
 	}
 
 	if tlsCaCertFile != "" {
-		pool, err := addCACertsFromFile(tlsConfig.RootCAs, tlsCaCertFile)
+		pool, caCert, err := addCACertsFromFile(tlsConfig.RootCAs, tlsCaCertFile)
 		if err != nil {
 			return nil, nil, err
 		}
 
 		isTLS = true
 		tlsConfig.RootCAs = pool
+		rootCAs = append(rootCAs, caCert)
 	}
 
 	if tlsCaCertEnvVar != "" {
-		pool, err := addCACertsFromEnv(tlsConfig.RootCAs, tlsCaCertEnvVar)
+		pool, caCert, err := addCACertsFromEnv(tlsConfig.RootCAs, tlsCaCertEnvVar)
 		if err != nil {
 			return nil, nil, err
 		}
 
 		isTLS = true
 		tlsConfig.RootCAs = pool
+		rootCAs = append(rootCAs, caCert)
 	}
 
+	// The pooled transport is selected after the TLS server name has been
+	// determined below.
+	usePool := false
+
 	if isTLS {
 		if ok, parsedURL, tr := useSocket(url, &tlsConfig); ok {
 			client.Transport = tr
 			url = parsedURL
+		} else if bctx.HTTPTransportPool != nil {
+			usePool = true
 		} else {
 			tr := http.DefaultTransport.(*http.Transport).Clone()
 			tr.TLSClientConfig = &tlsConfig
@@ -641,6 +661,8 @@ func createHTTPRequest(bctx BuiltinContext, obj ast.Object) (*http.Request, *htt
 		if ok, parsedURL, tr := useSocket(url, nil); ok {
 			client.Transport = tr
 			url = parsedURL
+		} else if bctx.HTTPTransportPool != nil {
+			usePool = true
 		}
 	}
 
@@ -704,6 +726,14 @@ func createHTTPRequest(bctx BuiltinContext, obj ast.Object) (*http.Request, *htt
 		tlsConfig.ServerName = tlsServerName
 	}
 
+	if usePool {
+		if isTLS {
+			client.Transport = bctx.HTTPTransportPool.transport(&tlsConfig, rootCAs...)
+		} else {
+			client.Transport = bctx.HTTPTransportPool.transport(nil)
+		}
+	}
+
 	if len(bctx.DistributedTracingOpts) > 0 {
 		client.Transport = tracing.NewTransport(client.Transport, bctx.DistributedTracingOpts)
 	}
@@ -1111,6 +1141,22 @@ func (cb interQueryCacheValue) SizeInBytes() int64 {
 	return int64(len(cb.Data))
 }
 
+func (cb interQueryCacheValue) PersistentType() string {
+	return httpSendPersistentType
+}
+
+func (cb interQueryCacheValue) MarshalPersistent() ([]byte, error) {
+	return cb.Data, nil
+}
+
+func decodeInterQueryCacheValue(bs []byte) (cache.InterQueryCacheValue, error) {
+	cb := &interQueryCacheValue{Data: bs}
+	if _, err := cb.copyCacheData(); err != nil {
+		return nil, err
+	}
+	return cb, nil
+}
+
 func (cb *interQueryCacheValue) copyCacheData() (*interQueryCacheData, error) {
 	var res interQueryCacheData
 	err := util.UnmarshalJSON(cb.Data, &res)
@@ -1202,6 +1248,22 @@ func (c *interQueryCacheData) Clone() (cache.InterQueryCacheValue, error) {
 		Headers:    c.Headers.Clone()}, nil
 }
 
+func (c *interQueryCacheData) PersistentType() string {
+	return httpSendDeserializedPersistentType
+}
+
+func (c *interQueryCacheData) MarshalPersistent() ([]byte, error) {
+	return json.Marshal(c)
+}
+
+func decodeInterQueryCacheData(bs []byte) (cache.InterQueryCacheValue, error) {
+	var c interQueryCacheData
+	if err := util.UnmarshalJSON(bs, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
 type responseHeaders struct {
 	etag         string // identifier for a specific version of the response
 	lastModified string // date and time response was last modified as per origin server
@@ -27,6 +27,7 @@ import (
 	"time"
 
 	"github.com/open-policy-agent/opa/internal/version"
+	"github.com/open-policy-agent/opa/logging"
 	"github.com/open-policy-agent/opa/metrics"
 	"github.com/open-policy-agent/opa/storage"
 	"github.com/open-policy-agent/opa/topdown/builtins"
@@ -3279,6 +3280,59 @@ func TestIntraQueryCache_ClientError(t *testing.T) {
 	}
 }
 
+func TestHTTPSendInterQueryCachePersistence(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "max-age=300")
+		w.Header().Set("Date", time.Now().UTC().Format(http.TimeFormat))
+		_, _ = w.Write([]byte(`{"x": 1}`))
+	}))
+	defer ts.Close()
+
+	for _, mode := range []string{"serialized", "deserialized"} {
+		t.Run(mode, func(t *testing.T) {
+			requests = 0
+			dir := t.TempDir()
+			query := fmt.Sprintf(`http.send({"method": "get", "url": %q, "cache": true, "caching_mode": %q}, x)`, ts.URL, mode)
+
+			eval := func() *ast.Term {
+				config, _ := iCache.ParseCachingConfig([]byte(`{"inter_query_builtin_cache": {"persist": true}}`))
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+
+				interQueryCache, err := iCache.NewPersistentInterQueryCache(ctx, config, dir, logging.NewNoOpLogger())
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				qs, err := NewQuery(ast.MustParseBody(query)).
+					WithCompiler(ast.NewCompiler()).
+					WithStore(inmem.New()).
+					WithInterQueryBuiltinCache(interQueryCache).
+					Run(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(qs) != 1 {
+					t.Fatalf("Expected one result but got %v", qs)
+				}
+				return qs[0][ast.Var("x")]
+			}
+
+			first := eval()
+			second := eval() // with a new cache, loaded from disk
+
+			if requests != 1 {
+				t.Fatalf("Expected 1 request but got %d", requests)
+			}
+			if !first.Equal(second) {
+				t.Fatalf("Expected cached response %v but got %v", first, second)
+			}
+		})
+	}
+}
+
 func TestInterQueryCache_ClientError(t *testing.T) {
 	data := loadSmallTestData()
 
@@ -0,0 +1,237 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/open-policy-agent/opa/util"
+)
+
+const (
+	defaultHTTPDialTimeout      = 30 * time.Second
+	defaultHTTPKeepAlive        = 30 * time.Second
+	defaultHTTPMaxTLSTransports = 100
+)
+
+// HTTPTransportConfig configures the connection pooling of the transports used
+// by the http.send built-in function. Unset fields keep the defaults of the Go
+// standard library.
+type HTTPTransportConfig struct {
+	MaxIdleConns           *int   `json:"max_idle_conns,omitempty"`
+	MaxIdleConnsPerHost    *int   `json:"max_idle_conns_per_host,omitempty"`
+	MaxConnsPerHost        *int   `json:"max_conns_per_host,omitempty"`
+	IdleConnTimeoutSeconds *int64 `json:"idle_conn_timeout_seconds,omitempty"`
+	KeepAliveSeconds       *int64 `json:"keep_alive_seconds,omitempty"`
+	TLSSessionCacheSize    *int   `json:"tls_session_cache_size,omitempty"`
+	MaxTLSTransports       *int   `json:"max_tls_transports,omitempty"`
+}
+
+// ParseHTTPTransportConfig returns the transport configuration contained in
+// raw. If raw is nil, the zero configuration is returned.
+func ParseHTTPTransportConfig(raw []byte) (*HTTPTransportConfig, error) {
+	var config HTTPTransportConfig
+	if raw == nil {
+		return &config, nil
+	}
+
+	if err := util.Unmarshal(raw, &config); err != nil {
+		return nil, err
+	}
+
+	for name, value := range map[string]*int{
+		"max_idle_conns":          config.MaxIdleConns,
+		"max_idle_conns_per_host": config.MaxIdleConnsPerHost,
+		"max_conns_per_host":      config.MaxConnsPerHost,
+		"tls_session_cache_size":  config.TLSSessionCacheSize,
+		"max_tls_transports":      config.MaxTLSTransports,
+	} {
+		if value != nil && *value < 0 {
+			return nil, fmt.Errorf("invalid %v %d, must be non-negative", name, *value)
+		}
+	}
+
+	for name, value := range map[string]*int64{
+		"idle_conn_timeout_seconds": config.IdleConnTimeoutSeconds,
+		"keep_alive_seconds":        config.KeepAliveSeconds,
+	} {
+		if value != nil && *value < 0 {
+			return nil, fmt.Errorf("invalid %v %d, must be non-negative", name, *value)
+		}
+	}
+
+	return &config, nil
+}
+
+// HTTPTransportPool holds the transports used by the http.send built-in
+// function so that connections are reused across calls and queries. Without a
+// pool, requests that configure TLS options (including the default use of the
+// system certificate pool) create a new transport and connection every time.
+// Transports are shared between requests with equal TLS settings. The least
+// recently used transports are dropped when the number of distinct TLS
+// settings exceeds the configured maximum.
+type HTTPTransportPool struct {
+	config HTTPTransportConfig
+	max    int // maximum number of TLS transports, zero for no limit
+	mtx    sync.Mutex
+	plain  *http.Transport
+	tls    map[tlsTransportKey]*list.Element // values are *pooledHTTPTransport
+	lru    *list.List                        // most recently used first
+}
+
+// tlsTransportKey is a digest of the TLS settings of a transport.
+type tlsTransportKey [sha256.Size]byte
+
+type pooledHTTPTransport struct {
+	key       tlsTransportKey
+	transport *http.Transport
+}
+
+// NewHTTPTransportPool returns a new pool of transports configured by config.
+// If config is nil, the zero configuration is used.
+func NewHTTPTransportPool(config *HTTPTransportConfig) *HTTPTransportPool {
+	p := &HTTPTransportPool{
+		max: defaultHTTPMaxTLSTransports,
+		tls: map[tlsTransportKey]*list.Element{},
+		lru: list.New(),
+	}
+	if config != nil {
+		p.config = *config
+		if config.MaxTLSTransports != nil {
+			p.max = *config.MaxTLSTransports
+		}
+	}
+	return p
+}
+
+// CloseIdleConnections closes the idle connections of all transports in the
+// pool.
+func (p *HTTPTransportPool) CloseIdleConnections() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if p.plain != nil {
+		p.plain.CloseIdleConnections()
+	}
+	for e := p.lru.Front(); e != nil; e = e.Next() {
+		e.Value.(*pooledHTTPTransport).transport.CloseIdleConnections()
+	}
+}
+
+// transport returns the pooled transport for tlsConfig. If tlsConfig is nil,
+// the transport uses the default TLS settings. Since the root CAs of a
+// certificate pool cannot be compared, rootCAs must identify the root CAs of
+// tlsConfig, e.g., by the PEM encoded certificates that were added to it.
+func (p *HTTPTransportPool) transport(tlsConfig *tls.Config, rootCAs ...[]byte) *http.Transport {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if tlsConfig == nil {
+		if p.plain == nil {
+			p.plain = p.newTransport(nil)
+		}
+		return p.plain
+	}
+
+	key := newTLSTransportKey(tlsConfig, rootCAs)
+	if e, ok := p.tls[key]; ok {
+		p.lru.MoveToFront(e)
+		return e.Value.(*pooledHTTPTransport).transport
+	}
+
+	t := &pooledHTTPTransport{key: key, transport: p.newTransport(tlsConfig)}
+	p.tls[key] = p.lru.PushFront(t)
+
+	if p.max > 0 && p.lru.Len() > p.max {
+		oldest := p.lru.Remove(p.lru.Back()).(*pooledHTTPTransport)
+		delete(p.tls, oldest.key)
+		// Requests in flight on the dropped transport are not interrupted.
+		oldest.transport.CloseIdleConnections()
+	}
+
+	return t.transport
+}
+
+func (p *HTTPTransportPool) newTransport(tlsConfig *tls.Config) *http.Transport {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+
+	dialer := &net.Dialer{Timeout: defaultHTTPDialTimeout, KeepAlive: defaultHTTPKeepAlive}
+	if p.config.KeepAliveSeconds != nil {
+		dialer.KeepAlive = time.Duration(*p.config.KeepAliveSeconds) * time.Second
+	}
+	tr.DialContext = dialer.DialContext
+
+	if p.config.MaxIdleConns != nil {
+		tr.MaxIdleConns = *p.config.MaxIdleConns
+	}
+	if p.config.MaxIdleConnsPerHost != nil {
+		tr.MaxIdleConnsPerHost = *p.config.MaxIdleConnsPerHost
+	}
+	if p.config.MaxConnsPerHost != nil {
+		tr.MaxConnsPerHost = *p.config.MaxConnsPerHost
+	}
+	if p.config.IdleConnTimeoutSeconds != nil {
+		tr.IdleConnTimeout = time.Duration(*p.config.IdleConnTimeoutSeconds) * time.Second
+	}
+
+	if tlsConfig != nil {
+		tr.TLSClientConfig = tlsConfig.Clone()
+	} else {
+		tr.TLSClientConfig = &tls.Config{}
+	}
+	if p.config.TLSSessionCacheSize != nil && *p.config.TLSSessionCacheSize > 0 {
+		tr.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(*p.config.TLSSessionCacheSize)
+	}
+
+	return tr
+}
+
+// newTLSTransportKey returns the digest of the settings of tlsConfig that
+// http.send configures and of the root CAs identified by rootCAs.
+func newTLSTransportKey(tlsConfig *tls.Config, rootCAs [][]byte) tlsTransportKey {
+	h := sha256.New()
+
+	if tlsConfig.InsecureSkipVerify {
+		h.Write([]byte{1})
+	} else {
+		h.Write([]byte{0})
+	}
+	writeLengthPrefixed(h, []byte(tlsConfig.ServerName))
+
+	writeLength(h, len(rootCAs))
+	for _, bs := range rootCAs {
+		writeLengthPrefixed(h, bs)
+	}
+
+	writeLength(h, len(tlsConfig.Certificates))
+	for _, cert := range tlsConfig.Certificates {
+		writeLength(h, len(cert.Certificate))
+		for _, der := range cert.Certificate {
+			writeLengthPrefixed(h, der)
+		}
+	}
+
+	var key tlsTransportKey
+	h.Sum(key[:0])
+	return key
+}
+
+func writeLength(h hash.Hash, n int) {
+	var buf [binary.MaxVarintLen64]byte
+	h.Write(buf[:binary.PutUvarint(buf[:], uint64(n))])
+}
+
+func writeLengthPrefixed(h hash.Hash, bs []byte) {
+	writeLength(h, len(bs))
+	h.Write(bs)
+}
@@ -0,0 +1,152 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+func TestParseHTTPTransportConfig(t *testing.T) {
+	tests := []struct {
+		note    string
+		raw     string
+		wantErr bool
+	}{
+		{note: "empty", raw: ``},
+		{note: "all fields", raw: `{"max_idle_conns": 100, "max_idle_conns_per_host": 10, "max_conns_per_host": 20, "idle_conn_timeout_seconds": 90, "keep_alive_seconds": 15, "tls_session_cache_size": 64, "max_tls_transports": 10}`},
+		{note: "negative limit", raw: `{"max_conns_per_host": -1}`, wantErr: true},
+		{note: "negative timeout", raw: `{"keep_alive_seconds": -1}`, wantErr: true},
+		{note: "negative max TLS transports", raw: `{"max_tls_transports": -1}`, wantErr: true},
+		{note: "bad type", raw: `{"max_idle_conns": "a"}`, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			var raw []byte
+			if tc.raw != "" {
+				raw = []byte(tc.raw)
+			}
+			config, err := ParseHTTPTransportConfig(raw)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("Expected error")
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			tr := NewHTTPTransportPool(config).transport(nil)
+			if config.MaxConnsPerHost != nil && tr.MaxConnsPerHost != *config.MaxConnsPerHost {
+				t.Fatalf("Expected max conns per host %d but got %d", *config.MaxConnsPerHost, tr.MaxConnsPerHost)
+			}
+			if config.IdleConnTimeoutSeconds != nil && tr.IdleConnTimeout != time.Duration(*config.IdleConnTimeoutSeconds)*time.Second {
+				t.Fatalf("Unexpected idle connection timeout %v", tr.IdleConnTimeout)
+			}
+			if config.TLSSessionCacheSize != nil && tr.TLSClientConfig.ClientSessionCache == nil {
+				t.Fatal("Expected TLS session cache")
+			}
+		})
+	}
+}
+
+func TestHTTPTransportPool(t *testing.T) {
+	var conns int32
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	ts.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	query := func(pool *HTTPTransportPool, path string) {
+		t.Helper()
+		q := NewQuery(ast.MustParseBody(fmt.Sprintf(`http.send({"method": "get", "url": "%s/%s", "tls_insecure_skip_verify": true})`, ts.URL, path))).
+			WithHTTPTransportPool(pool)
+		if _, err := q.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("without pool", func(t *testing.T) {
+		atomic.StoreInt32(&conns, 0)
+		for i := 0; i < 3; i++ {
+			query(nil, fmt.Sprint(i))
+		}
+		if exp, act := int32(3), atomic.LoadInt32(&conns); exp != act {
+			t.Fatalf("Expected %d connections but got %d", exp, act)
+		}
+	})
+
+	t.Run("with pool", func(t *testing.T) {
+		atomic.StoreInt32(&conns, 0)
+		pool := NewHTTPTransportPool(nil)
+		defer pool.CloseIdleConnections()
+		for i := 0; i < 3; i++ {
+			query(pool, fmt.Sprint(i))
+		}
+		if exp, act := int32(1), atomic.LoadInt32(&conns); exp != act {
+			t.Fatalf("Expected %d connections but got %d", exp, act)
+		}
+	})
+}
+
+func TestHTTPTransportPoolTLSConfigs(t *testing.T) {
+	pool := NewHTTPTransportPool(nil)
+
+	a := pool.transport(&tls.Config{InsecureSkipVerify: true})
+	b := pool.transport(&tls.Config{InsecureSkipVerify: true})
+	c := pool.transport(&tls.Config{InsecureSkipVerify: true, ServerName: "example.com"})
+	d := pool.transport(nil)
+	e := pool.transport(&tls.Config{InsecureSkipVerify: true}, []byte("ca"))
+	f := pool.transport(&tls.Config{InsecureSkipVerify: true}, []byte("ca"))
+	g := pool.transport(&tls.Config{InsecureSkipVerify: true}, []byte("c"), []byte("a"))
+
+	if a != b || e != f {
+		t.Fatal("Expected transport to be shared for equal TLS configurations")
+	}
+	for _, pair := range [][2]*http.Transport{{a, c}, {a, d}, {c, d}, {a, e}, {e, g}} {
+		if pair[0] == pair[1] {
+			t.Fatal("Expected separate transports for different TLS configurations")
+		}
+	}
+}
+
+func TestHTTPTransportPoolMaxTLSTransports(t *testing.T) {
+	maxTransports := 2
+	pool := NewHTTPTransportPool(&HTTPTransportConfig{MaxTLSTransports: &maxTransports})
+
+	a := pool.transport(&tls.Config{ServerName: "a"})
+	b := pool.transport(&tls.Config{ServerName: "b"})
+	if pool.transport(&tls.Config{ServerName: "a"}) != a { // a is now the most recently used transport
+		t.Fatal("Expected transport to be shared for equal TLS configurations")
+	}
+	pool.transport(&tls.Config{ServerName: "c"}) // evicts b
+
+	if exp, act := maxTransports, pool.lru.Len(); exp != act {
+		t.Fatalf("Expected %d transports but got %d", exp, act)
+	}
+	if pool.transport(&tls.Config{ServerName: "a"}) != a {
+		t.Fatal("Expected recently used transport to be kept")
+	}
+	if pool.transport(&tls.Config{ServerName: "b"}) == b {
+		t.Fatal("Expected least recently used transport to be evicted")
+	}
+}
@@ -9,16 +9,24 @@ import "github.com/open-policy-agent/opa/metrics"
 const (
 	evalOpPlug                    = "eval_op_plug"
 	evalOpResolve                 = "eval_op_resolve"
+	evalOpPrefetch                = "eval_op_prefetch"
 	evalOpRuleIndex               = "eval_op_rule_index"
 	evalOpBuiltinCall             = "eval_op_builtin_call"
 	evalOpVirtualCacheHit         = "eval_op_virtual_cache_hit"
 	evalOpVirtualCacheMiss        = "eval_op_virtual_cache_miss"
+	evalOpInterQueryRuleCacheHit  = "eval_op_inter_query_rule_cache_hit"
+	evalOpInterQueryRuleCacheMiss = "eval_op_inter_query_rule_cache_miss"
+	evalOpInterQueryBaseCacheHit  = "eval_op_inter_query_base_cache_hit"
+	evalOpInterQueryBaseCacheMiss = "eval_op_inter_query_base_cache_miss"
 	evalOpBaseCacheHit            = "eval_op_base_cache_hit"
 	evalOpBaseCacheMiss           = "eval_op_base_cache_miss"
 	evalOpComprehensionCacheSkip  = "eval_op_comprehension_cache_skip"
 	evalOpComprehensionCacheBuild = "eval_op_comprehension_cache_build"
 	evalOpComprehensionCacheHit   = "eval_op_comprehension_cache_hit"
 	evalOpComprehensionCacheMiss  = "eval_op_comprehension_cache_miss"
+	evalOpEarlyExit               = "eval_op_early_exit"
+	evalOpEarlyExitDeferred       = "eval_op_early_exit_deferred"
+	evalOpEarlyExitSuppressed     = "eval_op_early_exit_suppressed"
 	partialOpSaveUnify            = "partial_op_save_unify"
 	partialOpSaveSetContains      = "partial_op_save_set_contains"
 	partialOpSaveSetContainsRec   = "partial_op_save_set_contains_rec"
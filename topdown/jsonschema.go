@@ -10,8 +10,28 @@ import (
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/internal/gojsonschema"
+	"github.com/open-policy-agent/opa/topdown/cache"
 )
 
+type jsonSchemaCacheKey string
+
+// compiledJSONSchema holds a compiled schema and the value it was compiled
+// from in the inter-query cache. Compiled schemas are not modified by
+// validation, so they are shared rather than copied.
+type compiledJSONSchema struct {
+	value  ast.Value
+	schema *gojsonschema.Schema
+	size   int64
+}
+
+func (s *compiledJSONSchema) SizeInBytes() int64 {
+	return s.size
+}
+
+func (s *compiledJSONSchema) Clone() (cache.InterQueryCacheValue, error) {
+	return s, nil
+}
+
 // astValueToJSONSchemaLoader converts a value to JSON Loader.
 // Value can be ast.String or ast.Object.
 func astValueToJSONSchemaLoader(value ast.Value) (gojsonschema.JSONLoader, error) {
@@ -43,21 +63,55 @@ func astValueToJSONSchemaLoader(value ast.Value) (gojsonschema.JSONLoader, error
 	return loader, nil
 }
 
+// getCompiledJSONSchema returns the schema compiled from value, cached within
+// the query and in the inter-query cache if enabled.
+func getCompiledJSONSchema(bctx BuiltinContext, value ast.Value) (*gojsonschema.Schema, error) {
+	intraQueryCache := getValueKeyedCache(bctx, jsonSchemaCacheKey("jsonschema"))
+	if v, ok := intraQueryCache.Get(value); ok {
+		return v.(*compiledJSONSchema).schema, nil
+	}
+
+	// The inter-query cache is keyed on the hash of the schema, as large
+	// schemas would otherwise be serialized for every lookup.
+	var interQueryKey ast.Value
+	if bctx.InterQueryBuiltinCache != nil {
+		interQueryKey = ast.NewArray(ast.StringTerm("jsonschema"), ast.IntNumberTerm(value.Hash()))
+		if v, ok := bctx.InterQueryBuiltinCache.Get(interQueryKey); ok {
+			if cached, ok := v.(*compiledJSONSchema); ok && cached.value.Compare(value) == 0 {
+				intraQueryCache.Put(value, cached)
+				return cached.schema, nil
+			}
+		}
+	}
+
+	loader, err := astValueToJSONSchemaLoader(value)
+	if err != nil {
+		return nil, err
+	}
+
+	schema, err := gojsonschema.NewSchema(loader)
+	if err != nil {
+		return nil, err
+	}
+
+	cached := &compiledJSONSchema{value: value, schema: schema, size: int64(len(value.String()))}
+	intraQueryCache.Put(value, cached)
+	if interQueryKey != nil {
+		bctx.InterQueryBuiltinCache.Insert(interQueryKey, cached)
+	}
+
+	return schema, nil
+}
+
 func newResultTerm(valid bool, data *ast.Term) *ast.Term {
 	return ast.ArrayTerm(ast.BooleanTerm(valid), data)
 }
 
 // builtinJSONSchemaVerify accepts 1 argument which can be string or object and checks if it is valid JSON schema.
 // Returns array [false, <string>] with error string at index 1, or [true, ""] with empty string at index 1 otherwise.
-func builtinJSONSchemaVerify(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
-	// Take first argument and make JSON Loader from it.
-	loader, err := astValueToJSONSchemaLoader(operands[0].Value)
-	if err != nil {
-		return iter(newResultTerm(false, ast.StringTerm("jsonschema: "+err.Error())))
-	}
-
+func builtinJSONSchemaVerify(bctx BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
 	// Check that schema is correct and parses without errors.
-	if _, err = gojsonschema.NewSchema(loader); err != nil {
+	if _, err := getCompiledJSONSchema(bctx, operands[0].Value); err != nil {
 		return iter(newResultTerm(false, ast.StringTerm("jsonschema: "+err.Error())))
 	}
 
@@ -67,7 +121,7 @@ func builtinJSONSchemaVerify(_ BuiltinContext, operands []*ast.Term, iter func(*
 // builtinJSONMatchSchema accepts 2 arguments both can be string or object and verifies if the document matches the JSON schema.
 // Returns an array where first element is a boolean indicating a successful match, and the second is an array of errors that is empty on success and populated on failure.
 // In case of internal error returns empty array.
-func builtinJSONMatchSchema(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+func builtinJSONMatchSchema(bctx BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
 	// Take first argument and make JSON Loader from it.
 	// This is a JSON document made from Rego JSON string or object.
 	documentLoader, err := astValueToJSONSchemaLoader(operands[0].Value)
@@ -75,15 +129,16 @@ func builtinJSONMatchSchema(_ BuiltinContext, operands []*ast.Term, iter func(*a
 		return err
 	}
 
-	// Take second argument and make JSON Loader from it.
-	// This is a JSON schema made from Rego JSON string or object.
-	schemaLoader, err := astValueToJSONSchemaLoader(operands[1].Value)
+	// Take second argument and compile it, or reuse the schema compiled from
+	// it before. This is a JSON schema made from Rego JSON string or object.
+	schema, err := getCompiledJSONSchema(bctx, operands[1].Value)
 	if err != nil {
 		return err
 	}
 
-	// Use schema to validate document.
-	result, err := gojsonschema.Validate(schemaLoader, documentLoader)
+	// Use schema to validate document. String values are checked against
+	// the `format` of the schema, e.g., date-time, email or uri.
+	result, err := schema.Validate(documentLoader)
 	if err != nil {
 		return err
 	}
@@ -5,9 +5,12 @@
 package topdown
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/topdown/builtins"
+	"github.com/open-policy-agent/opa/topdown/cache"
 )
 
 func TestAstValueToJSONSchemaLoader(t *testing.T) {
@@ -273,3 +276,79 @@ func TestBuiltinJSONMatchSchema(t *testing.T) {
 		})
 	}
 }
+
+func TestBuiltinJSONMatchSchemaInterQueryCache(t *testing.T) {
+	iqCache := cache.NewInterQueryCache(nil)
+	schema := ast.MustParseTerm(`{"properties": {"id": {"type": "integer"}}}`)
+
+	for _, tc := range []struct {
+		document string
+		valid    bool
+	}{
+		{document: `{"id": 5}`, valid: true},
+		{document: `{"id": "test"}`, valid: false},
+	} {
+		bctx := BuiltinContext{Cache: builtins.Cache{}, InterQueryBuiltinCache: iqCache}
+		var result *ast.Term
+		err := builtinJSONMatchSchema(bctx, []*ast.Term{ast.StringTerm(tc.document), schema}, func(term *ast.Term) error {
+			result = term
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if valid := result.Value.(*ast.Array).Elem(0).Value.Compare(ast.Boolean(tc.valid)) == 0; !valid {
+			t.Fatalf("Expected valid to be %v but got %v", tc.valid, result)
+		}
+	}
+
+	v, ok := iqCache.Get(ast.NewArray(ast.StringTerm("jsonschema"), ast.IntNumberTerm(schema.Value.Hash())))
+	if !ok {
+		t.Fatal("Expected compiled schema in inter-query cache")
+	}
+
+	// A schema with a colliding hash is compiled rather than matched with
+	// the cached one.
+	other := ast.MustParseTerm(`{"properties": {"id": {"type": "string"}}}`)
+	iqCache.Insert(ast.NewArray(ast.StringTerm("jsonschema"), ast.IntNumberTerm(other.Value.Hash())), v)
+
+	bctx := BuiltinContext{Cache: builtins.Cache{}, InterQueryBuiltinCache: iqCache}
+	var result *ast.Term
+	err := builtinJSONMatchSchema(bctx, []*ast.Term{ast.StringTerm(`{"id": "test"}`), other}, func(term *ast.Term) error {
+		result = term
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Value.(*ast.Array).Elem(0).Value.Compare(ast.Boolean(true)) != 0 {
+		t.Fatalf("Expected document to match but got %v", result)
+	}
+}
+
+func BenchmarkBuiltinJSONMatchSchema(b *testing.B) {
+	props := ast.NewObject()
+	for i := 0; i < 200; i++ {
+		props.Insert(ast.StringTerm(fmt.Sprintf("p%d", i)), ast.MustParseTerm(`{"type": "string", "pattern": "^[a-z]+$", "maxLength": 10}`))
+	}
+	schema := ast.NewTerm(ast.NewObject(ast.Item(ast.StringTerm("properties"), ast.NewTerm(props))))
+	document := ast.MustParseTerm(`{"p0": "abc", "p1": "def"}`)
+
+	for _, tc := range []struct {
+		note    string
+		iqCache cache.InterQueryCache
+	}{
+		{note: "no inter-query cache"},
+		{note: "inter-query cache", iqCache: cache.NewInterQueryCache(nil)},
+	} {
+		b.Run(tc.note, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				bctx := BuiltinContext{Cache: builtins.Cache{}, InterQueryBuiltinCache: tc.iqCache}
+				err := builtinJSONMatchSchema(bctx, []*ast.Term{document, schema}, func(*ast.Term) error { return nil })
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/internal/jwx/jwe"
+	"github.com/open-policy-agent/opa/internal/jwx/jwk"
+	"github.com/open-policy-agent/opa/topdown/builtins"
+)
+
+var jweKidKey = ast.StringTerm("kid")
+
+func builtinJWEEncrypt(bctx BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	headers, err := builtins.ObjectOperand(operands[0].Value, 1)
+	if err != nil {
+		return err
+	}
+	payload, err := builtins.StringOperand(operands[1].Value, 2)
+	if err != nil {
+		return err
+	}
+
+	var kid string
+	if v := headers.Get(jweKidKey); v != nil {
+		s, ok := v.Value.(ast.String)
+		if !ok {
+			return builtins.NewOperandErr(1, "kid header must be a string")
+		}
+		kid = string(s)
+	}
+	key, err := getJWKByKid(operands[2].Value, 3, kid, jwk.ParseEncryptionKeysString)
+	if err != nil {
+		return err
+	}
+	if kid == "" && key.GetKeyID() != "" {
+		headers = headers.Copy()
+		headers.Insert(jweKidKey, ast.StringTerm(key.GetKeyID()))
+	}
+
+	material, err := key.Materialize()
+	if err != nil {
+		return err
+	}
+	hdrs, err := jsonString(headers)
+	if err != nil {
+		return err
+	}
+
+	compact, err := jwe.Encrypt([]byte(payload), []byte(hdrs), material, bctx.Seed)
+	if err != nil {
+		return err
+	}
+	return iter(ast.StringTerm(string(compact)))
+}
+
+func builtinJWEDecrypt(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	s, err := builtins.StringOperand(operands[0].Value, 1)
+	if err != nil {
+		return err
+	}
+	m, err := jwe.ParseString(string(s))
+	if err != nil {
+		return err
+	}
+
+	key, err := getJWKByKid(operands[1].Value, 2, m.Headers.KeyID, jwk.ParseEncryptionKeysString)
+	if err != nil {
+		return err
+	}
+	material, err := key.Materialize()
+	if err != nil {
+		return err
+	}
+
+	payload, err := m.Decrypt(material)
+	if err != nil {
+		return err
+	}
+	return iter(ast.StringTerm(string(payload)))
+}
+
+func init() {
+	RegisterBuiltinFunc(ast.JWEEncrypt.Name, builtinJWEEncrypt)
+	RegisterBuiltinFunc(ast.JWEDecrypt.Name, builtinJWEDecrypt)
+}
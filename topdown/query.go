@@ -3,6 +3,7 @@ package topdown
 import (
 	"context"
 	"crypto/rand"
+	"fmt"
 	"io"
 	"sort"
 	"time"
@@ -30,6 +31,7 @@ type Query struct {
 	seed                   io.Reader
 	time                   time.Time
 	cancel                 Cancel
+	timeout                time.Duration
 	query                  ast.Body
 	queryCompiler          ast.QueryCompiler
 	compiler               *ast.Compiler
@@ -39,25 +41,42 @@ type Query struct {
 	external               *resolverTrie
 	tracers                []QueryTracer
 	plugTraceVars          bool
+	snapshotVirtualCache   bool
 	unknowns               []*ast.Term
 	partialNamespace       string
 	skipSaveNamespace      bool
 	metrics                metrics.Metrics
 	instr                  *Instrumentation
+	ruleProfiler           *RuleProfiler
 	disableInlining        []ast.Ref
 	shallowInlining        bool
+	partialComprehensions  bool
 	genvarprefix           string
 	runtime                *ast.Term
 	builtins               map[string]*Builtin
+	builtinOverrides       map[string]BuiltinFunc
 	indexing               bool
 	earlyExit              bool
 	interQueryBuiltinCache cache.InterQueryCache
+	interQueryRuleCache    cache.InterQueryRuleCache
+	interQueryBaseCache    cache.InterQueryBaseCache
 	ndBuiltinCache         builtins.NDBCache
 	strictBuiltinErrors    bool
+	strictBuiltins         map[string]struct{}
 	builtinErrorList       *[]Error
+	supportProvenance      *[]SupportProvenance
 	strictObjects          bool
+	enforceCapabilities    bool
+	parallelism            int
+	memoryPooling          bool
+	maxEvalDepth           int
+	maxResultCount         int
+	maxResultBytes         int64
+	bodyReordering         bool
 	printHook              print.Hook
 	tracingOpts            tracing.Options
+	builtinSpanThreshold   time.Duration
+	httpTransportPool      *HTTPTransportPool
 }
 
 // Builtin represents a built-in function that queries can call.
@@ -96,7 +115,10 @@ func (q *Query) WithStore(store storage.Store) *Query {
 }
 
 // WithTransaction sets the transaction to use for the query. All queries
-// should be performed over a consistent snapshot of the storage layer.
+// should be performed over a consistent snapshot of the storage layer. Read
+// transactions on stores that support snapshot reads (see
+// inmem.OptSnapshotReads) are immutable snapshots that do not block writers
+// while the query is evaluated.
 func (q *Query) WithTransaction(txn storage.Transaction) *Query {
 	q.txn = txn
 	return q
@@ -109,6 +131,15 @@ func (q *Query) WithCancel(cancel Cancel) *Query {
 	return q
 }
 
+// WithTimeout sets the maximum amount of time the query is allowed to evaluate
+// for. If the timeout elapses, evaluation is aborted with an error that has the
+// TimeoutErr code (see IsTimeout.) The timeout is enforced independently of any
+// Cancel object set on the query. A zero or negative timeout disables the limit.
+func (q *Query) WithTimeout(timeout time.Duration) *Query {
+	q.timeout = timeout
+	return q
+}
+
 // WithInput sets the input object to use for the query. References rooted at
 // input will be evaluated against this value. This is optional.
 func (q *Query) WithInput(input *ast.Term) *Query {
@@ -141,6 +172,9 @@ func (q *Query) WithQueryTracer(tracer QueryTracer) *Query {
 	if conf.PlugLocalVars {
 		q.plugTraceVars = true
 	}
+	if conf.SnapshotVirtualCache {
+		q.snapshotVirtualCache = true
+	}
 
 	return q
 }
@@ -159,6 +193,73 @@ func (q *Query) WithInstrumentation(instr *Instrumentation) *Query {
 	return q
 }
 
+// WithRuleProfiler sets the profiler that records per-rule evaluation
+// statistics. By default, rules are not profiled.
+func (q *Query) WithRuleProfiler(p *RuleProfiler) *Query {
+	q.ruleProfiler = p
+	return q
+}
+
+// WithParallelism sets the maximum number of goroutines used to evaluate the
+// rules that contribute to the same partial set or object. By default, rules are
+// evaluated serially. Rules are only evaluated concurrently if the query is not
+// traced, instrumented, or partially evaluated. The store must support
+// concurrent reads within the same transaction and the print hook (if set) must
+// be safe for concurrent use.
+func (q *Query) WithParallelism(n int) *Query {
+	q.parallelism = n
+	return q
+}
+
+// WithMemoryPooling tells the evaluator to allocate the variable bindings of
+// the query from a pool, and to return them to it when Iter or Run returns,
+// which reduces the garbage produced by evaluation. Pooling is not applied
+// when the query is traced, or partially evaluated.
+func (q *Query) WithMemoryPooling(yes bool) *Query {
+	q.memoryPooling = yes
+	return q
+}
+
+// WithMaxEvalDepth sets the maximum number of expressions and unifications
+// that may be nested during evaluation, e.g., through comprehensions, rules
+// and functions calling each other, or the unification of deeply nested
+// values. Evaluation stops with a RecursionLimitErr when the limit is
+// exceeded, instead of growing the stack further. By default, the depth is not
+// limited.
+func (q *Query) WithMaxEvalDepth(n int) *Query {
+	q.maxEvalDepth = n
+	return q
+}
+
+// WithMaxResultCount sets the maximum number of results the query may
+// produce. Evaluation stops with a ResultLimitErr when the query produces
+// another result. By default, the number of results is not limited.
+func (q *Query) WithMaxResultCount(n int) *Query {
+	q.maxResultCount = n
+	return q
+}
+
+// WithMaxResultBytes sets the maximum size of the results of the query, as the
+// approximate length of their JSON encoding. Evaluation stops with a
+// ResultLimitErr when the results produced exceed it. By default, the size of
+// the results is not limited.
+func (q *Query) WithMaxResultBytes(n int64) *Query {
+	q.maxResultBytes = n
+	return q
+}
+
+// WithBodyReordering tells the evaluator to reorder the expressions of rule
+// bodies by the estimated number of values they enumerate, so that the most
+// selective expressions are evaluated first. The estimates are based on the
+// size of the base documents and input, and the number of rules of virtual
+// documents. Expressions that are negated, have with modifiers, contain
+// closures or have side effects are not moved, nor are expressions moved
+// across them. By default, bodies are evaluated in the order of the compiler.
+func (q *Query) WithBodyReordering(yes bool) *Query {
+	q.bodyReordering = yes
+	return q
+}
+
 // WithUnknowns sets the initial set of variables or references to treat as
 // unknown during query evaluation. This is required for partial evaluation.
 func (q *Query) WithUnknowns(terms []*ast.Term) *Query {
@@ -198,6 +299,14 @@ func (q *Query) WithShallowInlining(yes bool) *Query {
 	return q
 }
 
+// WithEvalComprehensionsDuringPartial enables evaluation of comprehensions that
+// do not depend on unknowns during partial evaluation. The comprehension values
+// are inlined into the saved expressions instead of the comprehensions themselves.
+func (q *Query) WithEvalComprehensionsDuringPartial(yes bool) *Query {
+	q.partialComprehensions = yes
+	return q
+}
+
 // WithRuntime sets the runtime data to execute the query with. The runtime data
 // can be returned by the `opa.runtime` built-in function.
 func (q *Query) WithRuntime(runtime *ast.Term) *Query {
@@ -212,6 +321,16 @@ func (q *Query) WithBuiltins(builtins map[string]*Builtin) *Query {
 	return q
 }
 
+// WithBuiltinOverrides replaces the implementations of built-in functions for
+// the query. The map is keyed by the name of the built-in function to override,
+// which may refer to a standard built-in function or one added with
+// WithBuiltins. The overrides only apply to this query; the global registry
+// (see RegisterBuiltinFunc) is not modified.
+func (q *Query) WithBuiltinOverrides(overrides map[string]BuiltinFunc) *Query {
+	q.builtinOverrides = overrides
+	return q
+}
+
 // WithIndexing will enable or disable using rule indexing for the evaluation
 // of the query. The default is enabled.
 func (q *Query) WithIndexing(enabled bool) *Query {
@@ -245,6 +364,26 @@ func (q *Query) WithInterQueryBuiltinCache(c cache.InterQueryCache) *Query {
 	return q
 }
 
+// WithInterQueryRuleCache sets the inter-query cache that holds the values of
+// complete virtual documents. Only documents that do not depend on the input,
+// non-deterministic built-in functions, or with statements are cached. The
+// cache must be invalidated whenever the data in the store changes and should
+// only be used with read transactions.
+func (q *Query) WithInterQueryRuleCache(c cache.InterQueryRuleCache) *Query {
+	q.interQueryRuleCache = c
+	return q
+}
+
+// WithInterQueryBaseCache sets the inter-query cache that holds base documents
+// read out of storage so that they are not converted into AST values for each
+// query. Values read through external resolvers are not cached. The cache
+// must be invalidated whenever the data in the store changes and should only
+// be used with read transactions.
+func (q *Query) WithInterQueryBaseCache(c cache.InterQueryBaseCache) *Query {
+	q.interQueryBaseCache = c
+	return q
+}
+
 // WithNDBuiltinCache sets the non-deterministic builtin cache.
 func (q *Query) WithNDBuiltinCache(c builtins.NDBCache) *Query {
 	q.ndBuiltinCache = c
@@ -257,6 +396,17 @@ func (q *Query) WithStrictBuiltinErrors(yes bool) *Query {
 	return q
 }
 
+// WithStrictBuiltinErrorsFor tells the evaluator to halt with an error when
+// one of the named built-in functions returns an error. Errors from other
+// built-in functions are handled as usual.
+func (q *Query) WithStrictBuiltinErrorsFor(names []string) *Query {
+	q.strictBuiltins = make(map[string]struct{}, len(names))
+	for _, name := range names {
+		q.strictBuiltins[name] = struct{}{}
+	}
+	return q
+}
+
 // WithBuiltinErrorList supplies a pointer to an Error slice to store built-in function errors
 // encountered during evaluation. This error slice can be inspected after evaluation to determine
 // which built-in function errors occurred.
@@ -265,6 +415,15 @@ func (q *Query) WithBuiltinErrorList(list *[]Error) *Query {
 	return q
 }
 
+// WithSupportProvenance supplies a pointer to a slice to store the origins of
+// the support rules generated by partial evaluation. The slice can be inspected
+// after PartialRun to map support rules back to the rules in the original
+// policy they were derived from.
+func (q *Query) WithSupportProvenance(p *[]SupportProvenance) *Query {
+	q.supportProvenance = p
+	return q
+}
+
 // WithResolver configures an external resolver to use for the given ref.
 func (q *Query) WithResolver(ref ast.Ref, r resolver.Resolver) *Query {
 	q.external.Put(ref, r)
@@ -282,6 +441,21 @@ func (q *Query) WithDistributedTracingOpts(tr tracing.Options) *Query {
 	return q
 }
 
+// WithBuiltinSpanThreshold sets the minimum duration of the built-in function
+// calls that are recorded as child spans of the span in the evaluation context.
+// Calls are not recorded if the threshold is zero, which is the default.
+func (q *Query) WithBuiltinSpanThreshold(d time.Duration) *Query {
+	q.builtinSpanThreshold = d
+	return q
+}
+
+// WithHTTPTransportPool sets the pool of transports that http.send uses to
+// reuse connections across calls and queries.
+func (q *Query) WithHTTPTransportPool(p *HTTPTransportPool) *Query {
+	q.httpTransportPool = p
+	return q
+}
+
 // WithStrictObjects tells the evaluator to avoid the "lazy object" optimization
 // applied when reading objects from the store. It will result in higher memory
 // usage and should only be used temporarily while adjusting code that breaks
@@ -291,6 +465,47 @@ func (q *Query) WithStrictObjects(yes bool) *Query {
 	return q
 }
 
+// WithEnforceCapabilities tells the evaluator to check that the capabilities of
+// the compiler allow each built-in function before calling it, including the
+// built-in functions called through 'with' statements, and to halt with a
+// capability error otherwise. This prevents policies compiled with undefined
+// function calls allowed from calling built-in functions registered after
+// compilation. The built-in functions supplied with WithBuiltins are always
+// allowed.
+func (q *Query) WithEnforceCapabilities(yes bool) *Query {
+	q.enforceCapabilities = yes
+	return q
+}
+
+// allowedBuiltins returns the set of built-in functions that evaluation may
+// call, or nil if capabilities are not enforced.
+func (q *Query) allowedBuiltins() map[string]struct{} {
+	if !q.enforceCapabilities || q.compiler == nil || q.compiler.Capabilities() == nil {
+		return nil
+	}
+	caps := q.compiler.Capabilities()
+	allowed := make(map[string]struct{}, len(caps.Builtins)+len(q.builtins))
+	for _, bi := range caps.Builtins {
+		allowed[bi.Name] = struct{}{}
+	}
+	for name := range q.builtins {
+		allowed[name] = struct{}{}
+	}
+	return allowed
+}
+
+// cancelWithTimeout returns the Cancel object to use for evaluation. If a
+// timeout has been set, the caller supplied Cancel is wrapped so that the query
+// is also cancelled when the timeout elapses. The returned function must be
+// called once evaluation is complete.
+func (q *Query) cancelWithTimeout() (Cancel, func()) {
+	if q.timeout <= 0 {
+		return q.cancel, func() {}
+	}
+	c := newTimeoutCancel(q.cancel, q.timeout)
+	return c, c.Stop
+}
+
 // PartialRun executes partial evaluation on the query with respect to unknown
 // values. Partial evaluation attempts to evaluate as much of the query as
 // possible without requiring values for the unknowns set on the query. The
@@ -311,14 +526,17 @@ func (q *Query) PartialRun(ctx context.Context) (partials []ast.Body, support []
 	if q.metrics == nil {
 		q.metrics = metrics.New()
 	}
+	cancel, stop := q.cancelWithTimeout()
+	defer stop()
 	f := &queryIDFactory{}
 	b := newBindings(0, q.instr)
+	tracers, filter := sampleTracers(q.tracers)
 	e := &eval{
 		ctx:                    ctx,
 		metrics:                q.metrics,
 		seed:                   q.seed,
 		time:                   ast.NumberTerm(int64ToJSONNumber(q.time.UnixNano())),
-		cancel:                 q.cancel,
+		cancel:                 cancel,
 		query:                  q.query,
 		queryCompiler:          q.queryCompiler,
 		queryIDFact:            f,
@@ -331,11 +549,14 @@ func (q *Query) PartialRun(ctx context.Context) (partials []ast.Body, support []
 		txn:                    q.txn,
 		input:                  q.input,
 		external:               q.external,
-		tracers:                q.tracers,
-		traceEnabled:           len(q.tracers) > 0,
+		tracers:                tracers,
+		traceFilter:            filter,
+		traceEnabled:           len(tracers) > 0,
 		plugTraceVars:          q.plugTraceVars,
+		snapshotVirtualCache:   q.snapshotVirtualCache,
 		instr:                  q.instr,
 		builtins:               q.builtins,
+		builtinOverrides:       q.builtinOverrides,
 		builtinCache:           builtins.Cache{},
 		functionMocks:          newFunctionMocksStack(),
 		interQueryBuiltinCache: q.interQueryBuiltinCache,
@@ -347,16 +568,21 @@ func (q *Query) PartialRun(ctx context.Context) (partials []ast.Body, support []
 		saveSupport:            newSaveSupport(),
 		saveNamespace:          ast.StringTerm(q.partialNamespace),
 		skipSaveNamespace:      q.skipSaveNamespace,
+		partialComprehensions:  q.partialComprehensions,
 		inliningControl: &inliningControl{
 			shallow: q.shallowInlining,
 		},
-		genvarprefix:  q.genvarprefix,
-		runtime:       q.runtime,
-		indexing:      q.indexing,
-		earlyExit:     q.earlyExit,
-		builtinErrors: &builtinErrors{},
-		printHook:     q.printHook,
-		strictObjects: q.strictObjects,
+		genvarprefix:    q.genvarprefix,
+		runtime:         q.runtime,
+		indexing:        q.indexing,
+		earlyExit:       q.earlyExit,
+		builtinErrors:   &builtinErrors{},
+		strictBuiltins:  q.strictBuiltins,
+		printHook:       q.printHook,
+		strictObjects:   q.strictObjects,
+		allowedBuiltins: q.allowedBuiltins(),
+		maxDepth:        q.maxEvalDepth,
+		depth:           new(int),
 	}
 
 	if len(q.disableInlining) > 0 {
@@ -420,6 +646,12 @@ func (q *Query) PartialRun(ctx context.Context) (partials []ast.Body, support []
 
 		if !q.shallowInlining {
 			body = applyCopyPropagation(p, e.instr, body)
+
+			// Skip this rule body if its numeric constraints contradict each other.
+			var ok bool
+			if body, ok = simplifyNumericConstraints(body); !ok {
+				return nil
+			}
 		}
 
 		partials = append(partials, body)
@@ -455,6 +687,10 @@ func (q *Query) PartialRun(ctx context.Context) (partials []ast.Body, support []
 		})
 	}
 
+	if q.supportProvenance != nil {
+		*(q.supportProvenance) = append(*(q.supportProvenance), e.saveSupport.Provenance(support)...)
+	}
+
 	return partials, support, err
 }
 
@@ -488,18 +724,36 @@ func (q *Query) Iter(ctx context.Context, iter func(QueryResult) error) error {
 	if q.metrics == nil {
 		q.metrics = metrics.New()
 	}
+	cancel, stop := q.cancelWithTimeout()
+	defer stop()
 	f := &queryIDFactory{}
+	tracers, filter := sampleTracers(q.tracers)
+	var baseCacheGen uint64
+	if q.interQueryBaseCache != nil {
+		baseCacheGen = q.interQueryBaseCache.Generation()
+	}
+	var reorderer *bodyReorderer
+	if q.bodyReordering {
+		reorderer = newBodyReorderer()
+	}
+	var arena *bindingsArena
+	if q.memoryPooling && len(tracers) == 0 {
+		arena = &bindingsArena{}
+		defer arena.release()
+	}
 	e := &eval{
 		ctx:                    ctx,
 		metrics:                q.metrics,
 		seed:                   q.seed,
 		time:                   ast.NumberTerm(int64ToJSONNumber(q.time.UnixNano())),
-		cancel:                 q.cancel,
+		cancel:                 cancel,
 		query:                  q.query,
 		queryCompiler:          q.queryCompiler,
 		queryIDFact:            f,
 		queryID:                f.Next(),
-		bindings:               newBindings(0, q.instr),
+		bindings:               arena.newBindings(0, q.instr),
+		arena:                  arena,
+		reorderer:              reorderer,
 		compiler:               q.compiler,
 		store:                  q.store,
 		baseCache:              newBaseCache(),
@@ -507,14 +761,21 @@ func (q *Query) Iter(ctx context.Context, iter func(QueryResult) error) error {
 		txn:                    q.txn,
 		input:                  q.input,
 		external:               q.external,
-		tracers:                q.tracers,
-		traceEnabled:           len(q.tracers) > 0,
+		tracers:                tracers,
+		traceFilter:            filter,
+		traceEnabled:           len(tracers) > 0,
 		plugTraceVars:          q.plugTraceVars,
+		snapshotVirtualCache:   q.snapshotVirtualCache,
 		instr:                  q.instr,
 		builtins:               q.builtins,
+		builtinOverrides:       q.builtinOverrides,
 		builtinCache:           builtins.Cache{},
 		functionMocks:          newFunctionMocksStack(),
 		interQueryBuiltinCache: q.interQueryBuiltinCache,
+		interQueryRuleCache:    newInterQueryRuleCacheState(q.interQueryRuleCache),
+		interQueryBaseCache:    q.interQueryBaseCache,
+		interQueryBaseCacheGen: baseCacheGen,
+		ruleProfiler:           q.ruleProfiler,
 		ndBuiltinCache:         q.ndBuiltinCache,
 		virtualCache:           newVirtualCache(),
 		comprehensionCache:     newComprehensionCache(),
@@ -523,18 +784,48 @@ func (q *Query) Iter(ctx context.Context, iter func(QueryResult) error) error {
 		indexing:               q.indexing,
 		earlyExit:              q.earlyExit,
 		builtinErrors:          &builtinErrors{},
+		strictBuiltins:         q.strictBuiltins,
 		printHook:              q.printHook,
 		tracingOpts:            q.tracingOpts,
+		builtinSpanThreshold:   q.builtinSpanThreshold,
+		httpTransportPool:      q.httpTransportPool,
 		strictObjects:          q.strictObjects,
+		allowedBuiltins:        q.allowedBuiltins(),
+		parallelism:            q.parallelism,
+		maxDepth:               q.maxEvalDepth,
+		depth:                  new(int),
 	}
 	e.caller = e
 	q.metrics.Timer(metrics.RegoQueryEval).Start()
+	var resultCount int
+	var resultBytes int64
 	err := e.Run(func(e *eval) error {
 		qr := QueryResult{}
 		_ = e.bindings.Iter(nil, func(k, v *ast.Term) error {
 			qr[k.Value.(ast.Var)] = v
 			return nil
 		}) // cannot return error
+		if q.maxResultCount > 0 {
+			if resultCount++; resultCount > q.maxResultCount {
+				return &Error{
+					Code:    ResultLimitErr,
+					Message: fmt.Sprintf("query produced more than %d results", q.maxResultCount),
+				}
+			}
+		}
+		if q.maxResultBytes > 0 {
+			for k, v := range qr {
+				if q.resultVar(k) {
+					resultBytes += resultSize(v.Value)
+				}
+			}
+			if resultBytes > q.maxResultBytes {
+				return &Error{
+					Code:    ResultLimitErr,
+					Message: fmt.Sprintf("query results exceeded %d bytes", q.maxResultBytes),
+				}
+			}
+		}
 		return iter(qr)
 	})
 
@@ -562,3 +853,65 @@ func (q *Query) Iter(ctx context.Context, iter func(QueryResult) error) error {
 	q.metrics.Timer(metrics.RegoQueryEval).Stop()
 	return err
 }
+
+// resultVar returns true if the binding of v is part of the results of the
+// query, as opposed to the wildcards and variables generated by the compiler.
+func (q *Query) resultVar(v ast.Var) bool {
+	if v.IsWildcard() {
+		return false
+	}
+	if v.IsGenerated() {
+		if q.queryCompiler == nil {
+			return false
+		}
+		_, ok := q.queryCompiler.RewrittenVars()[v]
+		return ok
+	}
+	return true
+}
+
+// resultSize returns the approximate length of the JSON encoding of v.
+func resultSize(v ast.Value) int64 {
+	switch v := v.(type) {
+	case ast.Null:
+		return 4
+	case ast.Boolean:
+		if v {
+			return 4
+		}
+		return 5
+	case ast.Number:
+		return int64(len(v))
+	case ast.String:
+		return int64(len(v)) + 2
+	case *ast.Array:
+		n := resultDelimiters(v.Len())
+		for i := 0; i < v.Len(); i++ {
+			n += resultSize(v.Elem(i).Value)
+		}
+		return n
+	case ast.Set:
+		n := resultDelimiters(v.Len())
+		v.Foreach(func(x *ast.Term) {
+			n += resultSize(x.Value)
+		})
+		return n
+	case ast.Object:
+		n := resultDelimiters(v.Len()) + int64(v.Len()) // colons
+		v.Foreach(func(k, x *ast.Term) {
+			n += resultSize(k.Value) + resultSize(x.Value)
+		})
+		return n
+	default:
+		return int64(len(v.String()))
+	}
+}
+
+// resultDelimiters returns the number of brackets and commas of a collection of
+// n elements.
+func resultDelimiters(n int) int64 {
+	if n == 0 {
+		return 2
+	}
+	return int64(n) + 1
+}
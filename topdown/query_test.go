@@ -6,6 +6,8 @@ package topdown
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"reflect"
 	"strings"
 	"testing"
@@ -215,6 +217,136 @@ func TestRegoMetadataBuiltinCall(t *testing.T) {
 	}
 }
 
+func TestQueryStrictBuiltinErrorsFor(t *testing.T) {
+	tests := []struct {
+		note   string
+		strict []string
+		expErr string
+	}{
+		{
+			note: "no strict builtins",
+		},
+		{
+			note:   "other strict builtins",
+			strict: []string{"http.send"},
+		},
+		{
+			note:   "strict builtin",
+			strict: []string{"http.send", "to_number"},
+			expErr: `eval_builtin_error: to_number: strconv.ParseFloat: parsing "a": invalid syntax`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			c := ast.NewCompiler()
+			c.Compile(map[string]*ast.Module{
+				"test.rego": ast.MustParseModule(`package test
+p { x := ["a", "1"][_]; to_number(x) }`),
+			})
+			if c.Failed() {
+				t.Fatal(c.Errors)
+			}
+
+			q := NewQuery(ast.MustParseBody("data.test.p")).
+				WithCompiler(c).
+				WithStore(inmem.New()).
+				WithStrictBuiltinErrorsFor(tc.strict)
+			qrs, err := q.Run(context.Background())
+
+			if tc.expErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if len(qrs) != 1 {
+					t.Fatalf("expected one result but got: %v", qrs)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected error but got: %v", qrs)
+			}
+			if !strings.Contains(err.Error(), tc.expErr) {
+				t.Fatalf("expected error:\n\n%s\n\ngot:\n\n%s", tc.expErr, err.Error())
+			}
+		})
+	}
+}
+
+func TestQueryEnforceCapabilities(t *testing.T) {
+	tests := []struct {
+		note    string
+		module  string
+		enforce bool
+		exp     string
+		expErr  string
+	}{
+		{
+			note:   "allowed",
+			module: `package test
+p := count([1])`,
+			enforce: true,
+			exp:     `1`,
+		},
+		{
+			note:   "not enforced",
+			module: `package test
+p := upper("a")`,
+			exp: `"A"`,
+		},
+		{
+			note:   "registered after compilation",
+			module: `package test
+p := upper("a")`,
+			enforce: true,
+			expErr:  "eval_capability_error: built-in function upper not allowed by capabilities",
+		},
+		{
+			note:   "with statement",
+			module: `package test
+f(x) := x
+p := y { y := f([1]) with f as count }`,
+			enforce: true,
+			exp:     `1`,
+		},
+	}
+
+	caps := &ast.Capabilities{Builtins: []*ast.Builtin{ast.Assign, ast.Equality, ast.Count}}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			c := ast.NewCompiler().
+				WithCapabilities(caps).
+				WithAllowUndefinedFunctionCalls(true)
+			c.Compile(map[string]*ast.Module{
+				"test.rego": ast.MustParseModule(tc.module),
+			})
+			if c.Failed() {
+				t.Fatal(c.Errors)
+			}
+
+			qrs, err := NewQuery(ast.MustParseBody("data.test.p = x")).
+				WithCompiler(c).
+				WithStore(inmem.New()).
+				WithEnforceCapabilities(tc.enforce).
+				Run(context.Background())
+
+			if tc.expErr != "" {
+				if !IsCapabilityErr(err) || !strings.Contains(err.Error(), tc.expErr) {
+					t.Fatalf("expected error %q but got %v", tc.expErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(qrs) != 1 || !qrs[0][ast.Var("x")].Equal(ast.MustParseTerm(tc.exp)) {
+				t.Fatalf("expected %v but got %v", tc.exp, qrs)
+			}
+		})
+	}
+}
+
 func TestWithCompilerErrors(t *testing.T) {
 	store := inmem.New()
 	ctx := context.Background()
@@ -306,3 +438,167 @@ func (n *testLegacyTracer) Enabled() bool {
 func (n *testLegacyTracer) Trace(e *Event) {
 	n.events = append(n.events, e)
 }
+
+func TestQueryMemoryPoolingResultsOutliveQuery(t *testing.T) {
+	c := ast.NewCompiler()
+	c.Compile(map[string]*ast.Module{
+		"test.rego": ast.MustParseModule(`package test
+import rego.v1
+
+f(x) := [x, y] if y := x + 1
+
+p contains f(x) if some x in numbers.range(1, 3)`),
+	})
+	if c.Failed() {
+		t.Fatal(c.Errors)
+	}
+
+	run := func(query string) QueryResultSet {
+		t.Helper()
+		qrs, err := NewQuery(ast.MustParseBody(query)).
+			WithCompiler(c).
+			WithStore(inmem.New()).
+			WithMemoryPooling(true).
+			Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return qrs
+	}
+
+	qrs := run("x = data.test.p; a = 1")
+
+	// The bindings of the first query are reused by the following ones.
+	for i := 0; i < 10; i++ {
+		run("x = data.test.p; a = 2")
+	}
+
+	exp := ast.MustParseTerm(`{[1, 2], [2, 3], [3, 4]}`)
+	if len(qrs) != 1 || !qrs[0][ast.Var("x")].Equal(exp) || !qrs[0][ast.Var("a")].Equal(ast.IntNumberTerm(1)) {
+		t.Fatalf("Expected results to be kept after the query but got %v", qrs)
+	}
+}
+
+func TestQueryMaxEvalDepth(t *testing.T) {
+	c := ast.NewCompiler()
+	c.Compile(map[string]*ast.Module{
+		"test.rego": ast.MustParseModule(`package test
+import rego.v1
+
+nested := [[[[[[[[x]]]]]]]] if x := 1
+
+p := [y | y := [z | z := [w | w := nested[_]]]]
+
+unify if [[[[[[[[a]]]]]]]] = nested`),
+	})
+	if c.Failed() {
+		t.Fatal(c.Errors)
+	}
+
+	tests := []struct {
+		note     string
+		query    string
+		maxDepth int
+		partial  bool
+		exp      bool
+	}{
+		{note: "unlimited", query: "data.test.p", exp: false},
+		{note: "within limit", query: "data.test.p", maxDepth: 100, exp: false},
+		{note: "comprehensions", query: "data.test.p", maxDepth: 10, exp: true},
+		{note: "unification within limit", query: "data.test.unify", maxDepth: 100, exp: false},
+		{note: "unification", query: "data.test.unify", maxDepth: 12, exp: true},
+		{note: "partial", query: "data.test.p", maxDepth: 10, partial: true, exp: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			q := NewQuery(ast.MustParseBody(tc.query)).
+				WithCompiler(c).
+				WithStore(inmem.New()).
+				WithMaxEvalDepth(tc.maxDepth)
+
+			var err error
+			if tc.partial {
+				_, _, err = q.PartialRun(context.Background())
+			} else {
+				_, err = q.Run(context.Background())
+			}
+
+			if !tc.exp {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if !IsRecursionLimit(err) {
+				t.Fatalf("Expected recursion limit error but got %v", err)
+			}
+			if exp := fmt.Sprintf("evaluation exceeded maximum depth of %d", tc.maxDepth); !strings.Contains(err.Error(), exp) {
+				t.Fatalf("Expected error %q but got %v", exp, err)
+			}
+		})
+	}
+}
+
+func TestQueryMaxResults(t *testing.T) {
+	c := ast.NewCompiler()
+	c.Compile(map[string]*ast.Module{
+		"test.rego": ast.MustParseModule(`package test
+
+ns := numbers.range(1, 100)
+
+xs := ["abc", [1, true]]`),
+	})
+	if c.Failed() {
+		t.Fatal(c.Errors)
+	}
+
+	tests := []struct {
+		note     string
+		query    string
+		maxCount int
+		maxBytes int64
+		exp      string
+	}{
+		{note: "unlimited", query: "x = data.test.ns[_]"},
+		{note: "count within limit", query: "x = data.test.ns[_]", maxCount: 100},
+		{note: "count exceeded", query: "x = data.test.ns[_]", maxCount: 10, exp: "query produced more than 10 results"},
+		// {"x": "abc", "i": 0} and {"x": [1, true], "i": 1}
+		{note: "bytes within limit", query: "x = data.test.xs[i]", maxBytes: 15},
+		{note: "bytes exceeded", query: "x = data.test.xs[i]", maxBytes: 14, exp: "query results exceeded 14 bytes"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			_, err := NewQuery(ast.MustParseBody(tc.query)).
+				WithCompiler(c).
+				WithStore(inmem.New()).
+				WithMaxResultCount(tc.maxCount).
+				WithMaxResultBytes(tc.maxBytes).
+				Run(context.Background())
+
+			if tc.exp == "" {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if !IsResultLimit(err) || !strings.Contains(err.Error(), tc.exp) {
+				t.Fatalf("Expected error %q but got %v", tc.exp, err)
+			}
+		})
+	}
+}
+
+func TestResultSize(t *testing.T) {
+	for _, s := range []string{`null`, `true`, `false`, `1.5`, `"abc"`, `[]`, `[1, "a"]`, `{}`, `{"a": {"b": [null]}, "c": false}`} {
+		v := ast.MustParseTerm(s).Value
+		bs, err := json.Marshal(ast.MustJSON(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := resultSize(v); n != int64(len(bs)) {
+			t.Errorf("Expected size of %v to be %d but got %d", s, len(bs), n)
+		}
+	}
+}
@@ -5,10 +5,22 @@
 package topdown
 
 import (
+	"fmt"
+
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/topdown/builtins"
 )
 
+const (
+	// reachablePathsMaxResults is the maximum number of paths returned by
+	// graph.reachable_paths.
+	reachablePathsMaxResults = 100000
+
+	// transitiveClosureMaxResults is the maximum number of pairs of vertices
+	// returned by graph.transitive_closure.
+	transitiveClosureMaxResults = 1000000
+)
+
 // Helper: sets of vertices can be represented as Arrays or Sets.
 func foreachVertex(collection *ast.Term, f func(*ast.Term)) {
 	switch v := collection.Value.(type) {
@@ -73,7 +85,11 @@ func builtinReachable(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Ter
 }
 
 // pathBuilder is called recursively to build a Set of paths that are reachable from the root
-func pathBuilder(graph ast.Object, root *ast.Term, path []*ast.Term, edgeRslt ast.Set, reached ast.Set) {
+func pathBuilder(bctx BuiltinContext, graph ast.Object, root *ast.Term, path []*ast.Term, edgeRslt ast.Set, reached ast.Set) error {
+	if err := checkGraphLimit(bctx, ast.ReachablePathsBuiltin.Name, edgeRslt.Len(), reachablePathsMaxResults); err != nil {
+		return err
+	}
+
 	paths := []*ast.Term{}
 
 	if edges := graph.Get(root); edges != nil {
@@ -81,7 +97,11 @@ func pathBuilder(graph ast.Object, root *ast.Term, path []*ast.Term, edgeRslt as
 
 		if numberOfEdges(edges) >= 1 {
 
+			var err error
 			foreachVertex(edges, func(neighbor *ast.Term) {
+				if err != nil {
+					return
+				}
 
 				if reached.Contains(neighbor) {
 					// If we've already reached this node, return current path (avoid infinite recursion)
@@ -89,17 +109,16 @@ func pathBuilder(graph ast.Object, root *ast.Term, path []*ast.Term, edgeRslt as
 					edgeRslt.Add(ast.ArrayTerm(paths...))
 				} else {
 					reached.Add(root)
-					pathBuilder(graph, neighbor, path, edgeRslt, reached)
-
+					err = pathBuilder(bctx, graph, neighbor, path, edgeRslt, reached)
 				}
 
 			})
-
-		} else {
-			paths = append(paths, path...)
-			edgeRslt.Add(ast.ArrayTerm(paths...))
+			return err
 
 		}
+		paths = append(paths, path...)
+		edgeRslt.Add(ast.ArrayTerm(paths...))
+
 	} else {
 		// Node is nonexistent (not in graph). Commit the current path (without adding this root)
 		paths = append(paths, path...)
@@ -107,9 +126,31 @@ func pathBuilder(graph ast.Object, root *ast.Term, path []*ast.Term, edgeRslt as
 
 	}
 
+	return nil
+}
+
+// checkGraphLimit returns an error if the n results of the graph built-in
+// function name exceed max, or if the query was cancelled.
+func checkGraphLimit(bctx BuiltinContext, name string, n, max int) error {
+	if n > max {
+		return fmt.Errorf("result exceeds %d elements", max)
+	}
+	return checkGraphCancel(bctx, name)
+}
+
+func checkGraphCancel(bctx BuiltinContext, name string) error {
+	if bctx.Cancel != nil && bctx.Cancel.Cancelled() {
+		return Halt{
+			Err: &Error{
+				Code:    CancelErr,
+				Message: fmt.Sprintf("%s: timed out before traversing the graph", name),
+			},
+		}
+	}
+	return nil
 }
 
-func builtinReachablePaths(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+func builtinReachablePaths(bctx BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
 	var traceResult = ast.NewSet()
 	// Error on wrong types for args.
 	graph, err := builtins.ObjectOperand(operands[0].Value, 1)
@@ -133,19 +174,136 @@ func builtinReachablePaths(_ BuiltinContext, operands []*ast.Term, iter func(*as
 		// Find reachable paths from edges in root node in queue and append arrays to the results set
 		if edges := graph.Get(node); edges != nil {
 			if numberOfEdges(edges) >= 1 {
+				var err error
 				foreachVertex(edges, func(neighbor *ast.Term) {
-					pathBuilder(graph, neighbor, []*ast.Term{node}, traceResult, ast.NewSet(node))
+					if err == nil {
+						err = pathBuilder(bctx, graph, neighbor, []*ast.Term{node}, traceResult, ast.NewSet(node))
+					}
 				})
+				if err != nil {
+					return err
+				}
 			} else {
 				traceResult.Add(ast.ArrayTerm(node))
 			}
 		}
 	}
 
+	if err := checkGraphLimit(bctx, ast.ReachablePathsBuiltin.Name, traceResult.Len(), reachablePathsMaxResults); err != nil {
+		return err
+	}
+
 	return iter(ast.NewTerm(traceResult))
 }
 
+func builtinShortestPath(bctx BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	graph, err := builtins.ObjectOperand(operands[0].Value, 1)
+	if err != nil {
+		return err
+	}
+	from, to := operands[1], operands[2]
+
+	if from.Equal(to) {
+		return iter(ast.ArrayTerm(from))
+	}
+
+	// Breadth-first search, recording the vertex each vertex was first
+	// reached from. Neighbors are visited in the order of their collection,
+	// so that the result is deterministic when several paths are shortest.
+	parents := ast.NewValueMap()
+	parents.Put(from.Value, from.Value)
+	queue := []*ast.Term{from}
+
+	for len(queue) > 0 {
+		if err := checkGraphCancel(bctx, ast.ShortestPathBuiltin.Name); err != nil {
+			return err
+		}
+
+		node := queue[0]
+		queue = queue[1:]
+
+		edges := graph.Get(node)
+		if edges == nil {
+			continue
+		}
+
+		found := false
+		foreachVertex(edges, func(neighbor *ast.Term) {
+			if found || parents.Get(neighbor.Value) != nil {
+				return
+			}
+			parents.Put(neighbor.Value, node.Value)
+			if neighbor.Equal(to) {
+				found = true
+				return
+			}
+			queue = append(queue, neighbor)
+		})
+
+		if found {
+			path := []*ast.Term{to}
+			for v := to.Value; v.Compare(from.Value) != 0; {
+				v = parents.Get(v)
+				path = append(path, ast.NewTerm(v))
+			}
+			for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+				path[i], path[j] = path[j], path[i]
+			}
+			return iter(ast.ArrayTerm(path...))
+		}
+	}
+
+	// There is no path from one vertex to the other.
+	return nil
+}
+
+func builtinTransitiveClosure(bctx BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	graph, err := builtins.ObjectOperand(operands[0].Value, 1)
+	if err != nil {
+		return err
+	}
+
+	result := ast.NewObject()
+	n := 0
+	err = graph.Iter(func(root, _ *ast.Term) error {
+		// The vertices reachable from root through at least one edge, which
+		// include root only if it is part of a cycle.
+		reached := ast.NewSet()
+		queue := []*ast.Term{root}
+		for len(queue) > 0 {
+			if err := checkGraphLimit(bctx, ast.TransitiveClosureBuiltin.Name, n, transitiveClosureMaxResults); err != nil {
+				return err
+			}
+
+			node := queue[0]
+			queue = queue[1:]
+			if edges := graph.Get(node); edges != nil {
+				foreachVertex(edges, func(neighbor *ast.Term) {
+					if !reached.Contains(neighbor) {
+						reached.Add(neighbor)
+						queue = append(queue, neighbor)
+						n++
+					}
+				})
+			}
+		}
+		result.Insert(root, ast.NewTerm(reached))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := checkGraphLimit(bctx, ast.TransitiveClosureBuiltin.Name, n, transitiveClosureMaxResults); err != nil {
+		return err
+	}
+
+	return iter(ast.NewTerm(result))
+}
+
 func init() {
 	RegisterBuiltinFunc(ast.ReachableBuiltin.Name, builtinReachable)
 	RegisterBuiltinFunc(ast.ReachablePathsBuiltin.Name, builtinReachablePaths)
+	RegisterBuiltinFunc(ast.ShortestPathBuiltin.Name, builtinShortestPath)
+	RegisterBuiltinFunc(ast.TransitiveClosureBuiltin.Name, builtinTransitiveClosure)
 }
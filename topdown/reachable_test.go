@@ -0,0 +1,77 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// layeredGraph returns a graph of n layers of two vertices, each connected to
+// both vertices of the next layer.
+func layeredGraph(n int) *ast.Term {
+	graph := ast.NewObject()
+	for i := 0; i < n; i++ {
+		next := ast.ArrayTerm(ast.IntNumberTerm(2*i+2), ast.IntNumberTerm(2*i+3))
+		if i == n-1 {
+			next = ast.ArrayTerm()
+		}
+		graph.Insert(ast.IntNumberTerm(2*i), next)
+		graph.Insert(ast.IntNumberTerm(2*i+1), next)
+	}
+	return ast.NewTerm(graph)
+}
+
+func TestReachablePathsLimit(t *testing.T) {
+	// A star with one path to each leaf.
+	graph := ast.NewObject()
+	leaves := make([]*ast.Term, reachablePathsMaxResults+1)
+	for i := range leaves {
+		leaves[i] = ast.IntNumberTerm(i + 1)
+		graph.Insert(leaves[i], ast.ArrayTerm())
+	}
+	graph.Insert(ast.IntNumberTerm(0), ast.ArrayTerm(leaves...))
+
+	operands := []*ast.Term{ast.NewTerm(graph), ast.ArrayTerm(ast.IntNumberTerm(0))}
+	err := builtinReachablePaths(BuiltinContext{}, operands, func(*ast.Term) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("result exceeds %d elements", reachablePathsMaxResults)) {
+		t.Fatalf("Expected limit error but got %v", err)
+	}
+
+	operands = []*ast.Term{ast.NewTerm(graph), ast.ArrayTerm(ast.IntNumberTerm(1))}
+	if err := builtinReachablePaths(BuiltinContext{}, operands, func(*ast.Term) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGraphBuiltinsCancel(t *testing.T) {
+	c := NewCancel()
+	c.Cancel()
+	bctx := BuiltinContext{Cancel: c}
+	graph := layeredGraph(3)
+
+	tests := map[string]func() error{
+		"graph.reachable_paths": func() error {
+			return builtinReachablePaths(bctx, []*ast.Term{graph, ast.ArrayTerm(ast.IntNumberTerm(0))}, func(*ast.Term) error { return nil })
+		},
+		"graph.shortest_path": func() error {
+			return builtinShortestPath(bctx, []*ast.Term{graph, ast.IntNumberTerm(0), ast.IntNumberTerm(5)}, func(*ast.Term) error { return nil })
+		},
+		"graph.transitive_closure": func() error {
+			return builtinTransitiveClosure(bctx, []*ast.Term{graph}, func(*ast.Term) error { return nil })
+		},
+	}
+
+	for name, f := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := f(); !IsCancel(err) {
+				t.Fatalf("Expected cancel error but got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"sync"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/storage"
+)
+
+// unknownCardinality is the number of values assumed for the collections whose
+// size cannot be estimated, e.g., the ones reached through bound variables.
+const unknownCardinality = 100
+
+// bodyReorderer reorders the expressions of rule bodies so that the ones
+// expected to produce the fewest solutions are evaluated first. The number of
+// solutions of an expression is estimated with the size of the collections it
+// enumerates: the number of rules of virtual documents, and the length of base
+// documents and of the input.
+//
+// Expressions are only moved within the runs of expressions that are free of
+// side effects and closures, and only after the variables they depend on are
+// bound, so the reordered bodies produce the same results. Bodies are
+// reordered once per query, since the estimates depend on the data it reads.
+type bodyReorderer struct {
+	mtx    sync.Mutex
+	bodies map[*ast.Rule]ast.Body
+	sizes  map[string]int
+}
+
+func newBodyReorderer() *bodyReorderer {
+	return &bodyReorderer{
+		bodies: map[*ast.Rule]ast.Body{},
+		sizes:  map[string]int{},
+	}
+}
+
+// ruleBody returns the body of rule to evaluate, reordered if enabled on the
+// query. Bodies are not reordered during partial evaluation, as the order of
+// the saved expressions is part of its output.
+func (e *eval) ruleBody(rule *ast.Rule) ast.Body {
+	if e.reorderer == nil || e.partial() {
+		return rule.Body
+	}
+	return e.reorderer.body(e, rule)
+}
+
+func (r *bodyReorderer) body(e *eval, rule *ast.Rule) ast.Body {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if body, ok := r.bodies[rule]; ok {
+		return body
+	}
+
+	safe := ast.ReservedVars.Copy()
+	for _, arg := range rule.Head.Args {
+		safe.Update(arg.Vars())
+	}
+
+	body := make(ast.Body, 0, len(rule.Body))
+	var run ast.Body
+	for _, expr := range rule.Body {
+		if reorderable(expr) {
+			run = append(run, expr)
+			continue
+		}
+		body = append(body, r.reorderRun(e, run, safe)...)
+		body = append(body, expr)
+		safe.Update(ast.OutputVarsFromExpr(e.compiler, expr, safe))
+		run = nil
+	}
+	body = append(body, r.reorderRun(e, run, safe)...)
+
+	r.bodies[rule] = body
+	return body
+}
+
+// reorderRun orders the expressions of run greedily: of the expressions whose
+// variables can be bound, the one with the lowest estimated number of
+// solutions is evaluated next. Ties keep the order of the body. The variables
+// bound by the expressions are added to safe.
+func (r *bodyReorderer) reorderRun(e *eval, run ast.Body, safe ast.VarSet) ast.Body {
+	result := make(ast.Body, 0, len(run))
+	placed := make([]bool, len(run))
+
+	for len(result) < len(run) {
+		next, nextCost := -1, 0
+		for i, expr := range run {
+			if placed[i] {
+				continue
+			}
+			outputs := ast.OutputVarsFromExpr(e.compiler, expr, safe)
+			if !expr.Vars(ast.SafetyCheckVisitorParams).Diff(safe).Diff(outputs).Equal(ast.NewVarSet()) {
+				continue
+			}
+			if cost := r.cost(e, expr, safe, outputs); next == -1 || cost < nextCost {
+				next, nextCost = i, cost
+			}
+		}
+
+		if next == -1 {
+			// Cannot happen for bodies the compiler accepted, as the order of
+			// the body binds all variables. Keep the remaining order as is.
+			for i, expr := range run {
+				if !placed[i] {
+					result = append(result, expr)
+				}
+			}
+			return result
+		}
+
+		placed[next] = true
+		result = append(result, run[next])
+		safe.Update(ast.OutputVarsFromExpr(e.compiler, run[next], safe))
+	}
+
+	return result
+}
+
+// reorderable returns true if expr can be moved within its body: it must not
+// be negated, have with modifiers, contain closures whose variables depend on
+// the order of the body, or call built-in functions with side effects.
+func reorderable(expr *ast.Expr) bool {
+	if expr.Negated || len(expr.With) > 0 {
+		return false
+	}
+
+	switch terms := expr.Terms.(type) {
+	case *ast.Term:
+	case []*ast.Term:
+		if bi, ok := ast.BuiltinMap[terms[0].String()]; ok {
+			if bi.Nondeterministic || bi.Name == ast.Print.Name || bi.Name == ast.InternalPrint.Name || bi.Name == ast.Trace.Name {
+				return false
+			}
+		}
+	default:
+		return false
+	}
+
+	ok := true
+	ast.WalkClosures(expr, func(interface{}) bool {
+		ok = false
+		return true
+	})
+	return ok
+}
+
+// cost returns the estimated number of solutions of expr, given the variables
+// that are already bound and the ones the expression binds.
+func (r *bodyReorderer) cost(e *eval, expr *ast.Expr, safe, outputs ast.VarSet) int {
+	if len(outputs) == 0 {
+		return 1
+	}
+
+	cost := 1
+	ast.WalkRefs(expr, func(ref ast.Ref) bool {
+		cost *= r.refCardinality(e, ref, safe)
+		return false
+	})
+
+	// Membership tests, as in `some x in xs`, enumerate their collection.
+	if terms, ok := expr.Terms.([]*ast.Term); ok && (expr.Operator().Equal(ast.Member.Ref()) || expr.Operator().Equal(ast.MemberWithKey.Ref())) {
+		if ref, ok := terms[len(terms)-1].Value.(ast.Ref); ok {
+			cost *= r.size(e, ref)
+		}
+	}
+
+	return cost
+}
+
+// refCardinality returns the estimated number of values ref enumerates,
+// i.e., the size of the collection at the first variable of ref that is not
+// bound yet, or 1 if all variables are bound. The collections beneath bound
+// variables cannot be estimated, as their values are not known beforehand.
+func (r *bodyReorderer) refCardinality(e *eval, ref ast.Ref, safe ast.VarSet) int {
+	for i := 1; i < len(ref); i++ {
+		if v, ok := ref[i].Value.(ast.Var); ok && !safe.Contains(v) {
+			return r.size(e, ref[:i])
+		}
+	}
+	return 1
+}
+
+// size returns the estimated size of the collection at ref.
+func (r *bodyReorderer) size(e *eval, ref ast.Ref) int {
+	if !ref.IsGround() {
+		return unknownCardinality
+	}
+
+	key := ref.String()
+	if n, ok := r.sizes[key]; ok {
+		return n
+	}
+
+	n := unknownCardinality
+	switch {
+	case ref[0].Equal(ast.InputRootDocument):
+		if e.input != nil {
+			if v, err := e.input.Value.Find(ref[1:]); err == nil {
+				n = collectionSize(v)
+			}
+		}
+	case ref[0].Equal(ast.DefaultRootDocument):
+		if node := e.compiler.RuleTree.Find(ref); node != nil {
+			if len(node.Values) > 0 {
+				n = len(node.Values)
+			} else {
+				n = len(node.Children)
+			}
+		} else if path, err := storage.NewPathForRef(ref); err == nil {
+			if v, err := e.store.Read(e.ctx, e.txn, path); err == nil {
+				n = storedSize(v)
+			}
+		}
+	}
+
+	r.sizes[key] = n
+	return n
+}
+
+func collectionSize(v ast.Value) int {
+	switch v := v.(type) {
+	case *ast.Array:
+		return v.Len()
+	case ast.Object:
+		return v.Len()
+	case ast.Set:
+		return v.Len()
+	}
+	return 1
+}
+
+func storedSize(v interface{}) int {
+	switch v := v.(type) {
+	case []interface{}:
+		return len(v)
+	case map[string]interface{}:
+		return len(v)
+	case ast.Value:
+		return collectionSize(v)
+	}
+	return 1
+}
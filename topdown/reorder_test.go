@@ -0,0 +1,149 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/storage"
+	inmem "github.com/open-policy-agent/opa/storage/inmem/test"
+	"github.com/open-policy-agent/opa/util"
+)
+
+func TestBodyReordering(t *testing.T) {
+	tests := []struct {
+		note   string
+		module string
+		exp    []string
+	}{
+		{
+			note: "smaller base document first",
+			module: `package test
+p contains [x, y] if { data.big[x] = a; data.small[y] = a }`,
+			exp: []string{`data.small[y] = a`, `data.big[x] = a`},
+		},
+		{
+			note: "virtual document with fewer rules first",
+			module: `package test
+q contains 1
+q contains 2
+r contains 1
+p contains [x, y] if { data.test.q[x]; data.test.r[y] }`,
+			exp: []string{`data.test.r[y]`, `data.test.q[x]`},
+		},
+		{
+			note: "input",
+			module: `package test
+p contains [x, y] if { data.big[x] = a; input.items[y] = a }`,
+			exp: []string{`input.items[y] = a`, `data.big[x] = a`},
+		},
+		{
+			note: "expressions run once their variables are bound",
+			module: `package test
+p contains x if { data.big[x] = a; data.small[y] = b; a == b }`,
+			exp: []string{`data.small[y] = b`, `a = b`, `data.big[x] = a`},
+		},
+		{
+			note: "not moved across negation",
+			module: `package test
+p contains [x, y] if { data.big[x] = a; not a == 2; data.small[y] = a }`,
+			exp: []string{`data.big[x] = a`, `not a = 2`, `data.small[y] = a`},
+		},
+		{
+			note: "side effects not moved",
+			module: `package test
+p contains [x, y] if { data.big[x] = a; print(a); data.small[y] = a }`,
+			exp: []string{`data.big[x] = a`, `__local1__ = {__local0__ | __local0__ = a}`, `internal.print`, `data.small[y] = a`},
+		},
+		{
+			note: "function arguments bound",
+			module: `package test
+f(z) if { data.big[x] = a; data.small[z] = a }`,
+			exp: []string{`data.small[__local0__] = a`, `data.big[x] = a`},
+		},
+	}
+
+	store := inmem.NewFromObject(map[string]interface{}{
+		"big":   []interface{}{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+		"small": []interface{}{2, 3},
+	})
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			c := ast.NewCompiler().WithEnablePrintStatements(true)
+			c.Compile(map[string]*ast.Module{"test.rego": ast.MustParseModuleWithOpts(tc.module, ast.ParserOptions{RegoVersion: ast.RegoV1})})
+			if c.Failed() {
+				t.Fatal(c.Errors)
+			}
+
+			ctx := context.Background()
+			txn := storage.NewTransactionOrDie(ctx, store)
+			defer store.Abort(ctx, txn)
+
+			e := &eval{
+				ctx:       ctx,
+				compiler:  c,
+				store:     store,
+				txn:       txn,
+				input:     ast.MustParseTerm(`{"items": [3]}`),
+				reorderer: newBodyReorderer(),
+			}
+
+			rules := c.GetRulesExact(ast.MustParseRef("data.test.p"))
+			if len(rules) == 0 {
+				rules = c.GetRulesExact(ast.MustParseRef("data.test.f"))
+			}
+			body := e.ruleBody(rules[0])
+
+			if len(body) != len(tc.exp) {
+				t.Fatalf("Expected %d expressions but got %v", len(tc.exp), body)
+			}
+			for i := range tc.exp {
+				if !strings.HasPrefix(body[i].String(), tc.exp[i]) {
+					t.Fatalf("Expected %v at %d but got %v", tc.exp[i], i, body)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryBodyReordering(t *testing.T) {
+	c := ast.MustCompileModules(map[string]string{
+		"test.rego": `package test
+
+p[[x, y]] { data.big[x] = a; data.small[y] = a }`,
+	})
+
+	var data map[string]interface{}
+	if err := util.UnmarshalJSON([]byte(`{"big": [1, 2, 3], "small": [3]}`), &data); err != nil {
+		t.Fatal(err)
+	}
+	store := inmem.NewFromObject(data)
+
+	ctx := context.Background()
+
+	for _, reorder := range []bool{false, true} {
+		var qrs QueryResultSet
+		err := storage.Txn(ctx, store, storage.TransactionParams{}, func(txn storage.Transaction) error {
+			var err error
+			qrs, err = NewQuery(ast.MustParseBody("x = data.test.p")).
+				WithCompiler(c).
+				WithStore(store).
+				WithTransaction(txn).
+				WithBodyReordering(reorder).
+				Run(ctx)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exp := ast.MustParseTerm(`{[2, 0]}`); len(qrs) != 1 || !qrs[0][ast.Var("x")].Equal(exp) {
+			t.Fatalf("Expected %v but got %v", exp, qrs)
+		}
+	}
+}
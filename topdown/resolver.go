@@ -5,6 +5,8 @@
 package topdown
 
 import (
+	"fmt"
+
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/metrics"
 	"github.com/open-policy-agent/opa/resolver"
@@ -45,12 +47,13 @@ func (t *resolverTrie) Resolve(e *eval, ref ast.Ref) (ast.Value, error) {
 		if node.r != nil {
 			in := resolver.Input{
 				Ref:     ref[:i+1],
+				Path:    ref[i+1:],
 				Input:   e.input,
 				Metrics: e.metrics,
 			}
 			e.traceWasm(e.query[e.index], &in.Ref)
 			if e.data != nil {
-				return nil, errInScopeWithStmt
+				return nil, errInScopeWithStmt(in.Ref)
 			}
 			result, err := node.r.Eval(e.ctx, in)
 			if err != nil {
@@ -59,7 +62,15 @@ func (t *resolverTrie) Resolve(e *eval, ref ast.Ref) (ast.Value, error) {
 			if result.Value == nil {
 				return nil, nil
 			}
-			val, err := result.Value.Find(ref[i+1:])
+			// Resolvers may return the referenced document only, instead of
+			// the whole document under the ref they are registered for.
+			if !in.Path.HasPrefix(result.Path) {
+				return nil, &Error{
+					Code:    InternalErr,
+					Message: fmt.Sprintf("resolver for %v returned a document at %v, which does not contain %v", in.Ref, result.Path, in.Path),
+				}
+			}
+			val, err := result.Value.Find(in.Path[len(result.Path):])
 			if err != nil {
 				return nil, nil
 			}
@@ -77,7 +88,7 @@ func (t *resolverTrie) mktree(e *eval, in resolver.Input) (ast.Value, error) {
 	if t.r != nil {
 		e.traceWasm(e.query[e.index], &in.Ref)
 		if e.data != nil {
-			return nil, errInScopeWithStmt
+			return nil, errInScopeWithStmt(in.Ref)
 		}
 		result, err := t.r.Eval(e.ctx, in)
 		if err != nil {
@@ -101,7 +112,9 @@ func (t *resolverTrie) mktree(e *eval, in resolver.Input) (ast.Value, error) {
 	return obj, nil
 }
 
-var errInScopeWithStmt = &Error{
-	Code:    InternalErr,
-	Message: "wasm cannot be executed when 'with' statements are in-scope",
+func errInScopeWithStmt(ref ast.Ref) *Error {
+	return &Error{
+		Code:    InternalErr,
+		Message: fmt.Sprintf("external resolver for %v cannot be evaluated when 'with' statements are in-scope", ref),
+	}
 }
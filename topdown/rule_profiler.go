@@ -0,0 +1,167 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// RuleProfiler collects per-rule evaluation statistics. The time recorded for
+// a rule includes the time spent evaluating its body (including any rules and
+// functions the body refers to) but excludes the time spent by the caller
+// processing the rule's results. A RuleProfiler can be shared by multiple
+// queries.
+type RuleProfiler struct {
+	mtx   sync.Mutex
+	stats map[*ast.Rule]*RuleStats
+}
+
+// RuleStats represents the evaluation statistics recorded for a single rule.
+type RuleStats struct {
+	Ref          ast.Ref       `json:"ref"`
+	Location     *ast.Location `json:"location"`
+	TotalTimeNs  int64         `json:"total_time_ns"`
+	NumEval      int           `json:"num_eval"`
+	NumEarlyExit int           `json:"num_early_exit"`
+}
+
+// NewRuleProfiler returns a new RuleProfiler.
+func NewRuleProfiler() *RuleProfiler {
+	return &RuleProfiler{stats: map[*ast.Rule]*RuleStats{}}
+}
+
+// Reset clears the statistics recorded so far.
+func (p *RuleProfiler) Reset() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.stats = map[*ast.Rule]*RuleStats{}
+}
+
+// Stats returns the statistics recorded for all rules that have been
+// evaluated, sorted by decreasing total time.
+func (p *RuleProfiler) Stats() []RuleStats {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	result := make([]RuleStats, 0, len(p.stats))
+	for _, s := range p.stats {
+		result = append(result, *s)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].TotalTimeNs != result[j].TotalTimeNs {
+			return result[i].TotalTimeNs > result[j].TotalTimeNs
+		}
+		return result[i].Location.Compare(result[j].Location) < 0
+	})
+
+	return result
+}
+
+// StatsByRef returns the statistics recorded for all rules aggregated by the
+// ref of the rules, sorted by decreasing total time. The location of an
+// aggregated entry is the location of the first rule defining the ref.
+func (p *RuleProfiler) StatsByRef() []RuleStats {
+	byRef := map[string]*RuleStats{}
+	var keys []string
+
+	for _, s := range p.Stats() {
+		key := s.Ref.String()
+		agg, ok := byRef[key]
+		if !ok {
+			cpy := s
+			byRef[key] = &cpy
+			keys = append(keys, key)
+			continue
+		}
+		agg.TotalTimeNs += s.TotalTimeNs
+		agg.NumEval += s.NumEval
+		agg.NumEarlyExit += s.NumEarlyExit
+		if s.Location.Compare(agg.Location) < 0 {
+			agg.Location = s.Location
+		}
+	}
+
+	result := make([]RuleStats, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, *byRef[key])
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].TotalTimeNs > result[j].TotalTimeNs
+	})
+
+	return result
+}
+
+func (p *RuleProfiler) record(rule *ast.Rule, d time.Duration, earlyExit bool) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	s, ok := p.stats[rule]
+	if !ok {
+		ref := rule.Head.Ref().GroundPrefix()
+		if rule.Module != nil {
+			ref = rule.Path()
+		}
+		s = &RuleStats{Ref: ref, Location: rule.Location}
+		p.stats[rule] = s
+	}
+
+	s.TotalTimeNs += d.Nanoseconds()
+	s.NumEval++
+	if earlyExit {
+		s.NumEarlyExit++
+	}
+}
+
+// ruleTimer measures the time spent evaluating a single rule body. The timer
+// is paused while the rule's results are handed back to the caller.
+type ruleTimer struct {
+	p       *RuleProfiler
+	rule    *ast.Rule
+	start   time.Time
+	elapsed time.Duration
+}
+
+func (p *RuleProfiler) start(rule *ast.Rule) *ruleTimer {
+	if p == nil {
+		return nil
+	}
+	return &ruleTimer{p: p, rule: rule, start: time.Now()}
+}
+
+func (t *ruleTimer) pause() {
+	if t == nil {
+		return
+	}
+	t.elapsed += time.Since(t.start)
+}
+
+func (t *ruleTimer) resume() {
+	if t == nil {
+		return
+	}
+	t.start = time.Now()
+}
+
+func (t *ruleTimer) stop(err error) {
+	if t == nil {
+		return
+	}
+	t.elapsed += time.Since(t.start)
+
+	var earlyExit bool
+	switch err.(type) {
+	case *earlyExitError, *deferredEarlyExitError:
+		earlyExit = true
+	}
+
+	t.p.record(t.rule, t.elapsed, earlyExit)
+}
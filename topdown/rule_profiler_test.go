@@ -0,0 +1,101 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"context"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+)
+
+func TestRuleProfiler(t *testing.T) {
+	ctx := context.Background()
+
+	compiler := compileModules([]string{`package test
+
+	p {
+		q[_]
+		r == 1
+		f(1)
+	}
+
+	q[x] {
+		x := [1, 2, 3][_]
+	}
+
+	q[x] {
+		x := "a"
+	}
+
+	r := 1
+
+	f(x) {
+		x == 1
+	}
+
+	f(x) {
+		x == 2
+	}
+	`})
+
+	store := inmem.New()
+	txn := storage.NewTransactionOrDie(ctx, store)
+	defer store.Abort(ctx, txn)
+
+	p := NewRuleProfiler()
+
+	qrs, err := NewQuery(ast.MustParseBody("data.test.p")).
+		WithCompiler(compiler).
+		WithStore(store).
+		WithTransaction(txn).
+		WithRuleProfiler(p).
+		Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(qrs) != 1 {
+		t.Fatalf("expected one result but got %v", qrs)
+	}
+
+	exp := map[string]struct {
+		numEval      int
+		numEarlyExit int
+	}{
+		"data.test.p": {numEval: 1, numEarlyExit: 1},
+		"data.test.q": {numEval: 2},
+		"data.test.r": {numEval: 1, numEarlyExit: 1},
+		"data.test.f": {numEval: 1, numEarlyExit: 1}, // f(x) { x == 2 } is skipped by rule indexing
+	}
+
+	stats := p.StatsByRef()
+	if len(stats) != len(exp) {
+		t.Fatalf("expected stats for %d refs but got %v", len(exp), stats)
+	}
+
+	for _, s := range stats {
+		e, ok := exp[s.Ref.String()]
+		if !ok {
+			t.Fatalf("unexpected ref %v", s.Ref)
+		}
+		if s.NumEval != e.numEval || s.NumEarlyExit != e.numEarlyExit {
+			t.Errorf("%v: expected %d evals and %d early exits but got %d and %d", s.Ref, e.numEval, e.numEarlyExit, s.NumEval, s.NumEarlyExit)
+		}
+		if s.Location == nil {
+			t.Errorf("%v: expected location", s.Ref)
+		}
+	}
+
+	if n := len(p.Stats()); n != 5 {
+		t.Fatalf("expected stats for 5 rules but got %d", n)
+	}
+
+	p.Reset()
+	if n := len(p.Stats()); n != 0 {
+		t.Fatalf("expected no stats after reset but got %d", n)
+	}
+}
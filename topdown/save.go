@@ -2,7 +2,9 @@ package topdown
 
 import (
 	"container/list"
+	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/open-policy-agent/opa/ast"
@@ -270,19 +272,84 @@ func (e saveStackElem) Plug(caller *bindings) *ast.Expr {
 // soon as each one finishes.
 type saveSupport struct {
 	modules map[string]*ast.Module
+	origins map[*ast.Rule]*ast.Rule
 }
 
 func newSaveSupport() *saveSupport {
 	return &saveSupport{
 		modules: map[string]*ast.Module{},
+		origins: map[*ast.Rule]*ast.Rule{},
 	}
 }
 
+// SupportProvenance identifies the rule in the original policy that a support
+// rule generated by partial evaluation was derived from.
+type SupportProvenance struct {
+	Rule     *ast.Rule     // The support rule generated by partial evaluation.
+	Ref      ast.Ref       // The path of the support rule.
+	Index    int           // The index of the support rule in its module. Else clauses share the index of their rule.
+	Else     int           // The position of the support rule in the else chain of its rule, 0 for the rule itself.
+	Package  ast.Ref       // The package of the original rule.
+	Location *ast.Location // The location of the original rule.
+}
+
+// MarshalJSON returns the JSON representation of the provenance, which
+// identifies the support rule by its path and position in its module.
+func (p SupportProvenance) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Ref      string        `json:"ref"`
+		Index    int           `json:"index"`
+		Else     int           `json:"else,omitempty"`
+		Package  string        `json:"package,omitempty"`
+		Location *ast.Location `json:"location,omitempty"`
+	}{
+		Ref:      p.Ref.String(),
+		Index:    p.Index,
+		Else:     p.Else,
+		Package:  p.Package.String(),
+		Location: p.Location,
+	})
+}
+
+// SetOrigin records that the support rule was derived from the original rule.
+// Support rules without an origin are synthetic (e.g., generated for negated
+// expressions.)
+func (s *saveSupport) SetOrigin(rule, origin *ast.Rule) {
+	s.origins[rule] = origin
+}
+
+// Provenance returns the origins of the support rules in modules (including
+// else clauses) in the order they appear.
+func (s *saveSupport) Provenance(modules []*ast.Module) []SupportProvenance {
+	var result []SupportProvenance
+	for _, module := range modules {
+		for i, rule := range module.Rules {
+			ref := module.Package.Path.Extend(rule.Head.Ref().GroundPrefix())
+			j := 0
+			for r := rule; r != nil; r, j = r.Else, j+1 {
+				origin, ok := s.origins[r]
+				if !ok {
+					continue
+				}
+				p := SupportProvenance{Rule: r, Ref: ref, Index: i, Else: j, Location: origin.Location}
+				if origin.Module != nil {
+					p.Package = origin.Module.Package.Path
+				}
+				result = append(result, p)
+			}
+		}
+	}
+	return result
+}
+
 func (s *saveSupport) List() []*ast.Module {
 	result := make([]*ast.Module, 0, len(s.modules))
 	for _, module := range s.modules {
 		result = append(result, module)
 	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Package.Path.Compare(result[j].Package.Path) < 0
+	})
 	return result
 }
 
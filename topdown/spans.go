@@ -0,0 +1,81 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+const tracerName = "github.com/open-policy-agent/opa/topdown"
+
+// builtinsWithSpans are the built-in functions that record client spans of
+// their own, so their calls are not recorded again.
+var builtinsWithSpans = map[string]struct{}{
+	ast.HTTPSend.Name: {},
+	ast.GRPCSend.Name: {},
+}
+
+// builtinSpan records a call of a built-in function as a child span of the span
+// in the evaluation context, if the call takes at least the span threshold of
+// the built-in context. The span is created once the call is over, so that
+// faster calls only cost reading the clock.
+type builtinSpan struct {
+	bctx  BuiltinContext
+	name  string
+	start time.Time
+	done  bool
+}
+
+// startBuiltinSpan returns nil if calls of the built-in function cannot be
+// recorded, e.g., if the span in the evaluation context is not sampled.
+func startBuiltinSpan(bctx BuiltinContext, name string) *builtinSpan {
+	if bctx.SpanThreshold <= 0 || bctx.Context == nil {
+		return nil
+	}
+	if _, ok := builtinsWithSpans[name]; ok {
+		return nil
+	}
+	if !trace.SpanFromContext(bctx.Context).IsRecording() {
+		return nil
+	}
+	return &builtinSpan{bctx: bctx, name: name, start: time.Now()}
+}
+
+// end ends the call when the built-in function returns its first output, or
+// an error, or no output at all. Subsequent calls are no-ops, so that the
+// evaluation of the query following the call is not included.
+func (s *builtinSpan) end(err error) {
+	if s == nil || s.done {
+		return
+	}
+	s.done = true
+
+	end := time.Now()
+	if end.Sub(s.start) < s.bctx.SpanThreshold {
+		return
+	}
+
+	attrs := []attribute.KeyValue{attribute.String("opa.builtin", s.name)}
+	if loc := s.bctx.Location; loc != nil {
+		attrs = append(attrs, attribute.String("code.filepath", loc.File), attribute.Int("code.lineno", loc.Row))
+	}
+
+	tracer := trace.SpanFromContext(s.bctx.Context).TracerProvider().Tracer(tracerName)
+	_, span := tracer.Start(s.bctx.Context, s.name, trace.WithTimestamp(s.start), trace.WithAttributes(attrs...))
+	if err != nil {
+		if h, ok := err.(Halt); ok {
+			err = h.Err
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End(trace.WithTimestamp(end))
+}
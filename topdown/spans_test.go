@@ -0,0 +1,92 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/types"
+)
+
+func TestBuiltinSpans(t *testing.T) {
+	builtin := func(name string, d time.Duration, err error) *Builtin {
+		return &Builtin{
+			Decl: &ast.Builtin{
+				Name: name,
+				Decl: types.NewFunction(types.Args(types.N), types.N),
+			},
+			Func: func(_ BuiltinContext, terms []*ast.Term, iter func(*ast.Term) error) error {
+				time.Sleep(d)
+				if err != nil {
+					return err
+				}
+				return iter(terms[0])
+			},
+		}
+	}
+
+	builtins := map[string]*Builtin{
+		"slow":   builtin("slow", 20*time.Millisecond, nil),
+		"fast":   builtin("fast", 0, nil),
+		"broken": builtin("broken", 20*time.Millisecond, errors.New("broken")),
+	}
+
+	tests := []struct {
+		note      string
+		threshold time.Duration
+		exp       []string
+	}{
+		{note: "disabled", threshold: 0},
+		{note: "above threshold", threshold: 10 * time.Millisecond, exp: []string{"slow", "broken"}},
+		{note: "below threshold", threshold: time.Second},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			exporter := tracetest.NewInMemoryExporter()
+			tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+			ctx, root := tp.Tracer("test").Start(context.Background(), "root")
+
+			query := NewQuery(ast.MustParseBody("slow(1, x); fast(x, y); broken(y, z)")).
+				WithBuiltins(builtins).
+				WithBuiltinSpanThreshold(tc.threshold)
+
+			if _, err := query.Run(ctx); err != nil {
+				t.Fatal(err)
+			}
+			root.End()
+
+			spans := exporter.GetSpans()
+			if len(spans) != len(tc.exp)+1 {
+				t.Fatalf("Expected %d spans but got %d: %v", len(tc.exp)+1, len(spans), spans)
+			}
+
+			for i, name := range tc.exp {
+				span := spans[i]
+				if span.Name != name {
+					t.Fatalf("Expected span %q but got %q", name, span.Name)
+				}
+				if span.Parent.SpanID() != root.SpanContext().SpanID() {
+					t.Fatalf("Expected span %q to be a child of the root span", name)
+				}
+				if d := span.EndTime.Sub(span.StartTime); d < tc.threshold {
+					t.Fatalf("Expected span %q to last at least %v but got %v", name, tc.threshold, d)
+				}
+				if name == "broken" && (span.Status.Code != codes.Error || span.Status.Description != "broken") {
+					t.Fatalf("Expected error status but got %v", span.Status)
+				}
+			}
+		})
+	}
+}
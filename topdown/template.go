@@ -2,13 +2,23 @@ package topdown
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"strings"
 	"text/template"
+	"text/template/parse"
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/topdown/builtins"
 )
 
-func renderTemplate(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+// renderTemplateMaxBytes is the maximum size of the output of
+// strings.render_template.
+const renderTemplateMaxBytes = 1 << 20
+
+var errRenderTemplateSize = fmt.Errorf("rendered template exceeds %d bytes", renderTemplateMaxBytes)
+
+func renderTemplate(bctx BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
 	preContentTerm, err := builtins.StringOperand(operands[0].Value, 1)
 	if err != nil {
 		return err
@@ -25,19 +35,120 @@ func renderTemplate(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term)
 		return err
 	}
 
-	tmpl, err := template.New("template").Parse(string(preContentTerm))
+	tmpl, err := template.New("template").
+		Funcs(template.FuncMap{"printf": templatePrintf}).
+		Parse(string(preContentTerm))
 	if err != nil {
 		return err
 	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			if err := validateTemplateNode(t.Tree.Root); err != nil {
+				return err
+			}
+		}
+	}
 
 	// Do not attempt to render if template variable keys are missing
 	tmpl.Option("missingkey=error")
-	var buf bytes.Buffer
-	if err := tmpl.Execute(&buf, templateVariables); err != nil {
+	w := &templateWriter{bctx: bctx}
+	if err := tmpl.Execute(w, templateVariables); err != nil {
+		return err
+	}
+
+	return iter(ast.StringTerm(w.buf.String()))
+}
+
+// templateWriter bounds the output of templates, and stops their execution
+// once the query is cancelled.
+type templateWriter struct {
+	bctx BuiltinContext
+	buf  bytes.Buffer
+}
+
+func (w *templateWriter) Write(p []byte) (int, error) {
+	if w.bctx.Cancel != nil && w.bctx.Cancel.Cancelled() {
+		return 0, Halt{
+			Err: &Error{
+				Code:    CancelErr,
+				Message: "strings.render_template: timed out before rendering the template",
+			},
+		}
+	}
+	if w.buf.Len()+len(p) > renderTemplateMaxBytes {
+		return 0, errRenderTemplateSize
+	}
+	return w.buf.Write(p)
+}
+
+// validateTemplateNode rejects ranges over number literals, which iterate
+// without producing output as many times as requested.
+func validateTemplateNode(node parse.Node) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := validateTemplateNode(child); err != nil {
+				return err
+			}
+		}
+	case *parse.RangeNode:
+		if cmds := n.Pipe.Cmds; len(cmds) > 0 {
+			if args := cmds[len(cmds)-1].Args; len(args) == 1 {
+				if _, ok := args[0].(*parse.NumberNode); ok {
+					return errors.New("range over numbers is not supported")
+				}
+			}
+		}
+		return validateTemplateBranch(&n.BranchNode)
+	case *parse.IfNode:
+		return validateTemplateBranch(&n.BranchNode)
+	case *parse.WithNode:
+		return validateTemplateBranch(&n.BranchNode)
+	}
+	return nil
+}
+
+func validateTemplateBranch(n *parse.BranchNode) error {
+	if err := validateTemplateNode(n.List); err != nil {
 		return err
 	}
+	return validateTemplateNode(n.ElseList)
+}
 
-	return iter(ast.StringTerm(buf.String()))
+// templatePrintf is fmt.Sprintf, without widths and precisions that would
+// format values larger than the output of templates can be.
+func templatePrintf(format string, args ...interface{}) (string, error) {
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		for i++; i < len(format); i++ {
+			c := format[i]
+			if c >= '0' && c <= '9' {
+				n := 0
+				for ; i < len(format) && format[i] >= '0' && format[i] <= '9'; i++ {
+					if n = 10*n + int(format[i]-'0'); n > renderTemplateMaxBytes {
+						return "", errRenderTemplateSize
+					}
+				}
+				i--
+			} else if c != '.' && c != '+' && c != '-' && c != '#' && c != ' ' && c != '[' && c != ']' && c != '*' {
+				break
+			}
+		}
+	}
+	// Widths and precisions given as arguments are bounded the same way.
+	if strings.Contains(format, "*") {
+		for _, arg := range args {
+			if n, ok := arg.(int); ok && (n > renderTemplateMaxBytes || n < -renderTemplateMaxBytes) {
+				return "", errRenderTemplateSize
+			}
+		}
+	}
+	return fmt.Sprintf(format, args...), nil
 }
 
 func init() {
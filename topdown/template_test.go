@@ -0,0 +1,22 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+func TestRenderTemplateCancel(t *testing.T) {
+	c := NewCancel()
+	c.Cancel()
+
+	operands := []*ast.Term{ast.StringTerm("{{.a}}"), ast.MustParseTerm(`{"a": "b"}`)}
+	err := renderTemplate(BuiltinContext{Cancel: c}, operands, func(*ast.Term) error { return nil })
+	if !IsCancel(err) {
+		t.Fatalf("Expected cancel error but got %v", err)
+	}
+}
@@ -9,7 +9,9 @@ import (
 	"fmt"
 	"math"
 	"math/big"
+	"regexp"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 	_ "time/tzdata" // this is needed to have LoadLocation when no filesystem tzdata is available
@@ -82,13 +84,78 @@ func builtinParseDurationNanos(_ BuiltinContext, operands []*ast.Term, iter func
 	if err != nil {
 		return err
 	}
-	value, err := time.ParseDuration(string(duration))
+	value, err := parseDuration(string(duration))
 	if err != nil {
 		return err
 	}
 	return iter(ast.NumberTerm(int64ToJSONNumber(int64(value))))
 }
 
+var durationComponentRegexp = regexp.MustCompile(`([0-9]*(?:\.[0-9]*)?)([^0-9.]+)`)
+
+// durationDayUnits are the units accepted by time.parse_duration_ns in
+// addition to those of time.ParseDuration. They are fixed multiples of hours,
+// regardless of daylight saving time.
+var durationDayUnits = map[string]time.Duration{
+	"d": 24,
+	"w": 7 * 24,
+}
+
+// parseDuration is time.ParseDuration, with days and weeks as units.
+func parseDuration(s string) (time.Duration, error) {
+	if !strings.ContainsAny(s, "dw") {
+		return time.ParseDuration(s)
+	}
+	invalid := fmt.Errorf("time: invalid duration %q", s)
+
+	neg := false
+	if s != "" && (s[0] == '-' || s[0] == '+') {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+
+	var total time.Duration
+	matches := durationComponentRegexp.FindAllStringSubmatchIndex(s, -1)
+	end := 0
+	for _, m := range matches {
+		if m[0] != end {
+			return 0, invalid
+		}
+		end = m[1]
+
+		num, unit := s[m[2]:m[3]], s[m[4]:m[5]]
+		var v time.Duration
+		var err error
+		if hours, ok := durationDayUnits[unit]; ok {
+			v, err = time.ParseDuration(num + "h")
+			if err != nil {
+				return 0, invalid
+			}
+			if v > math.MaxInt64/hours {
+				return 0, invalid
+			}
+			v *= hours
+		} else {
+			v, err = time.ParseDuration(num + unit)
+			if err != nil {
+				return 0, invalid
+			}
+		}
+		if total > math.MaxInt64-v {
+			return 0, invalid
+		}
+		total += v
+	}
+	if end != len(s) || len(matches) == 0 {
+		return 0, invalid
+	}
+
+	if neg {
+		total = -total
+	}
+	return total, nil
+}
+
 // Represent exposed constants for formatting from the stdlib time pkg
 var acceptedTimeFormats = map[string]string{
 	"ANSIC":       time.ANSIC,
@@ -176,6 +243,106 @@ func builtinAddDate(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term)
 	return toSafeUnixNano(result, iter)
 }
 
+// maxAddMonths is the number of months beyond which the results of
+// time.add_months are always outside of the valid time range.
+const maxAddMonths = 12 * 600
+
+func builtinAddMonths(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	t, _, err := tzTime(operands[0].Value)
+	if err != nil {
+		return err
+	}
+
+	months, err := builtins.IntOperand(operands[1].Value, 2)
+	if err != nil {
+		return err
+	}
+	if months > maxAddMonths || months < -maxAddMonths {
+		return fmt.Errorf("time outside of valid range")
+	}
+
+	year, month, day := t.Date()
+	m := int(month) - 1 + months
+	year += m / 12
+	if m %= 12; m < 0 {
+		m += 12
+		year--
+	}
+
+	// The day is clamped to the last day of the resulting month, rather than
+	// normalized into the next month.
+	if last := daysIn(time.Month(m+1), year); day > last {
+		day = last
+	}
+	hour, minute, sec := t.Clock()
+	result := time.Date(year, time.Month(m+1), day, hour, minute, sec, t.Nanosecond(), t.Location())
+
+	return toSafeUnixNano(result, iter)
+}
+
+func daysIn(month time.Month, year int) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+func builtinStartOf(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	t, _, err := tzTime(operands[0].Value)
+	if err != nil {
+		return err
+	}
+
+	unit, err := builtins.StringOperand(operands[1].Value, 2)
+	if err != nil {
+		return err
+	}
+
+	tz, err := builtins.StringOperand(operands[2].Value, 3)
+	if err != nil {
+		return err
+	}
+	loc, err := loadLocation(string(tz))
+	if err != nil {
+		return err
+	}
+	t = t.In(loc)
+
+	year, month, day := t.Date()
+	switch unit {
+	case "day":
+	case "week":
+		// Weeks start on Monday, as in ISO 8601.
+		day -= (int(t.Weekday()) + 6) % 7
+	case "month":
+		day = 1
+	case "quarter":
+		month -= (month - 1) % 3
+		day = 1
+	case "year":
+		month, day = time.January, 1
+	default:
+		return builtins.NewOperandErr(2, "unit must be one of day, week, month, quarter or year")
+	}
+
+	// Midnight is resolved in the timezone, so that daylight saving time
+	// transitions between t and the start of the period are accounted for.
+	result := time.Date(year, month, day, 0, 0, 0, 0, loc)
+
+	return toSafeUnixNano(result, iter)
+}
+
+func builtinISOWeek(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	t, _, err := tzTime(operands[0].Value)
+	if err != nil {
+		return err
+	}
+	year, week := t.ISOWeek()
+	weekday := int(t.Weekday())
+	if weekday == 0 {
+		weekday = 7
+	}
+	result := ast.NewArray(ast.IntNumberTerm(year), ast.IntNumberTerm(week), ast.IntNumberTerm(weekday))
+	return iter(ast.NewTerm(result))
+}
+
 func builtinDiff(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
 	t1, _, err := tzTime(operands[0].Value)
 	if err != nil {
@@ -263,30 +430,9 @@ func tzTime(a ast.Value) (t time.Time, lay string, err error) {
 				return time.Time{}, layout, err
 			}
 
-			tzName := string(tzVal)
-
-			switch tzName {
-			case "", "UTC":
-				// loc is already UTC
-
-			case "Local":
-				loc = time.Local
-
-			default:
-				var ok bool
-
-				tzCacheMutex.Lock()
-				loc, ok = tzCache[tzName]
-
-				if !ok {
-					loc, err = time.LoadLocation(tzName)
-					if err != nil {
-						tzCacheMutex.Unlock()
-						return time.Time{}, layout, err
-					}
-					tzCache[tzName] = loc
-				}
-				tzCacheMutex.Unlock()
+			loc, err = loadLocation(string(tzVal))
+			if err != nil {
+				return time.Time{}, layout, err
 			}
 		}
 
@@ -321,6 +467,32 @@ func tzTime(a ast.Value) (t time.Time, lay string, err error) {
 	return t, layout, nil
 }
 
+// loadLocation returns the location of the timezone tzName, which defaults to
+// UTC.
+func loadLocation(tzName string) (*time.Location, error) {
+	switch tzName {
+	case "", "UTC":
+		return time.UTC, nil
+
+	case "Local":
+		return time.Local, nil
+	}
+
+	tzCacheMutex.Lock()
+	defer tzCacheMutex.Unlock()
+
+	loc, ok := tzCache[tzName]
+	if !ok {
+		var err error
+		loc, err = time.LoadLocation(tzName)
+		if err != nil {
+			return nil, err
+		}
+		tzCache[tzName] = loc
+	}
+	return loc, nil
+}
+
 func int64ToJSONNumber(i int64) json.Number {
 	return json.Number(strconv.FormatInt(i, 10))
 }
@@ -335,6 +507,9 @@ func init() {
 	RegisterBuiltinFunc(ast.Clock.Name, builtinClock)
 	RegisterBuiltinFunc(ast.Weekday.Name, builtinWeekday)
 	RegisterBuiltinFunc(ast.AddDate.Name, builtinAddDate)
+	RegisterBuiltinFunc(ast.AddMonths.Name, builtinAddMonths)
+	RegisterBuiltinFunc(ast.StartOf.Name, builtinStartOf)
+	RegisterBuiltinFunc(ast.ISOWeek.Name, builtinISOWeek)
 	RegisterBuiltinFunc(ast.Diff.Name, builtinDiff)
 	tzCacheMutex = &sync.Mutex{}
 	tzCache = make(map[string]*time.Location)
@@ -917,11 +917,16 @@ func commonBuiltinJWTEncodeSign(bctx BuiltinContext, inputHeaders, jwsPayload, j
 	if err != nil {
 		return err
 	}
-	key, err := keys.Keys[0].Materialize()
+	return jwtEncodeSignWithKey(bctx, inputHeaders, jwsPayload, keys.Keys[0], iter)
+}
+
+func jwtEncodeSignWithKey(bctx BuiltinContext, inputHeaders, jwsPayload string, jwkKey jwk.Key, iter func(*ast.Term) error) error {
+
+	key, err := jwkKey.Materialize()
 	if err != nil {
 		return err
 	}
-	if jwk.GetKeyTypeFromKey(key) != keys.Keys[0].GetKeyType() {
+	if jwk.GetKeyTypeFromKey(key) != jwkKey.GetKeyType() {
 		return fmt.Errorf("JWK derived key type and keyType parameter do not match")
 	}
 
@@ -976,6 +981,89 @@ func builtinJWTEncodeSignRaw(bctx BuiltinContext, operands []*ast.Term, iter fun
 	return commonBuiltinJWTEncodeSign(bctx, string(inputHeaders), string(jwsPayload), string(jwkSrc), iter)
 }
 
+func builtinJWTEncodeSignJWKS(bctx BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+
+	headers, err := builtins.ObjectOperand(operands[0].Value, 1)
+	if err != nil {
+		return err
+	}
+	payload, err := builtins.ObjectOperand(operands[1].Value, 2)
+	if err != nil {
+		return err
+	}
+	kid, err := builtins.StringOperand(operands[3].Value, 4)
+	if err != nil {
+		return err
+	}
+	key, err := getJWKByKid(operands[2].Value, 3, string(kid), jwk.ParseString)
+	if err != nil {
+		return err
+	}
+
+	headers = headers.Copy()
+	headers.Insert(ast.StringTerm(jws.KeyIDKey), ast.NewTerm(kid))
+	if alg := key.GetAlgorithm(); headers.Get(ast.StringTerm(jws.AlgorithmKey)) == nil && alg != jwa.NoValue && alg != jwa.Unsupported {
+		headers.Insert(ast.StringTerm(jws.AlgorithmKey), ast.StringTerm(alg.String()))
+	}
+
+	inputHeaders, err := jsonString(headers)
+	if err != nil {
+		return err
+	}
+	jwsPayload, err := jsonString(payload)
+	if err != nil {
+		return err
+	}
+	return jwtEncodeSignWithKey(bctx, inputHeaders, jwsPayload, key, iter)
+}
+
+// getJWKByKid returns the key with ID kid of the JWK or JWK Set v, given as an
+// object or a JSON string. A single key without ID is returned for any kid.
+func getJWKByKid(v ast.Value, pos int, kid string, parse func(string) (*jwk.Set, error)) (jwk.Key, error) {
+	var src string
+	switch v := v.(type) {
+	case ast.String:
+		src = string(v)
+	case ast.Object:
+		var err error
+		src, err = jsonString(v)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, builtins.NewOperandTypeErr(pos, v, "string", "object")
+	}
+
+	set, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+	if len(set.Keys) == 1 && (kid == "" || set.Keys[0].GetKeyID() == "") {
+		return set.Keys[0], nil
+	}
+	for _, key := range set.Keys {
+		if key.GetKeyID() == kid {
+			return key, nil
+		}
+	}
+	if kid == "" {
+		return nil, fmt.Errorf("key ID required to select a key of the JWK Set")
+	}
+	return nil, fmt.Errorf("no key with key ID %q in the JWK Set", kid)
+}
+
+func jsonString(v ast.Value) (string, error) {
+	x, err := ast.JSON(v)
+	if err != nil {
+		return "", err
+	}
+	bs, err := json.Marshal(x)
+	if err != nil {
+		return "", err
+	}
+	return string(bs), nil
+}
+
 // Implements full JWT decoding, validation and verification.
 func builtinJWTDecodeVerify(bctx BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
 	// io.jwt.decode_verify(string, constraints, [valid, header, payload])
@@ -1212,4 +1300,5 @@ func init() {
 	RegisterBuiltinFunc(ast.JWTDecodeVerify.Name, builtinJWTDecodeVerify)
 	RegisterBuiltinFunc(ast.JWTEncodeSignRaw.Name, builtinJWTEncodeSignRaw)
 	RegisterBuiltinFunc(ast.JWTEncodeSign.Name, builtinJWTEncodeSign)
+	RegisterBuiltinFunc(ast.JWTEncodeSignJWKS.Name, builtinJWTEncodeSignJWKS)
 }
@@ -992,3 +992,36 @@ func BenchmarkObjectGetFromBaseDoc(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkMemoryPooling(b *testing.B) {
+	ctx := context.Background()
+	store := inmem.New()
+	compiler := ast.MustCompileModules(map[string]string{
+		"test.rego": `package test
+
+f(x) = y { y := x * 2 }
+
+p[y] { x := numbers.range(1, 100)[_]; y := f(x) }`,
+	})
+	query := ast.MustParseBody("data.test.p")
+
+	for _, pooling := range []bool{false, true} {
+		b.Run(fmt.Sprintf("pooling=%v", pooling), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				err := storage.Txn(ctx, store, storage.TransactionParams{}, func(txn storage.Transaction) error {
+					_, err := NewQuery(query).
+						WithCompiler(compiler).
+						WithStore(store).
+						WithTransaction(txn).
+						WithMemoryPooling(pooling).
+						Run(ctx)
+					return err
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
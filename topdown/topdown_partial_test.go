@@ -24,6 +24,7 @@ func TestTopDownPartialEval(t *testing.T) {
 		disableInlining      []string
 		shallow              bool
 		skipPartialNamespace bool
+		comprehensions       bool
 		query                string
 		modules              []string
 		moduleASTs           []*ast.Module
@@ -74,6 +75,37 @@ func TestTopDownPartialEval(t *testing.T) {
 				`input.x = 1; 2 = input.y; x = 1; y = 2`,
 			},
 		},
+		{
+			note:        "numeric constraints: merge bounds",
+			query:       "input.x > 3; input.x > 7; input.x < 10; input.x <= 12; input.y > 1",
+			wantQueries: []string{`input.x > 7; input.x < 10; input.y > 1`},
+		},
+		{
+			note:        "numeric constraints: flipped operands, strict bound",
+			query:       "3 < input.x; input.x >= 3; input.x != 1; input.x != 5",
+			wantQueries: []string{`3 < input.x; input.x != 5`},
+		},
+		{
+			note:        "numeric constraints: equality",
+			query:       "input.x > 3; input.x = 5; input.x != 4; input.x == 5.0",
+			wantQueries: []string{`input.x = 5`},
+		},
+		{
+			note:        "numeric constraints: contradiction",
+			query:       "input.x > 7; input.x <= 7",
+			wantQueries: []string{},
+		},
+		{
+			note:  "numeric constraints: contradiction in rule body",
+			query: "data.test.p = true; input.x < 5",
+			modules: []string{
+				`package test
+				p { input.x > 7 }
+				p { input.x < 2 }
+				p { input.x = 1; input.x != 1 }`,
+			},
+			wantQueries: []string{`input.x < 2`},
+		},
 		{
 			note:  "complete: substitute",
 			query: "input.x = data.test.p; data.test.q = input.y",
@@ -1766,8 +1798,13 @@ func TestTopDownPartialEval(t *testing.T) {
 				f(x) = true { x = 1 }
 				else = false { x = 2 }`},
 			wantQueries: []string{
-				`input = x; data.test.f(x)`,
+				`input = x; data.partial.test.f(x)`,
 			},
+			wantSupport: []string{`
+				package partial.test
+
+				f(__local0__1) = true { __local0__1 = 1 } else = false { __local0__1 = 2 }
+			`},
 		},
 		{
 			note:  "save: with but no unknowns",
@@ -1806,7 +1843,58 @@ func TestTopDownPartialEval(t *testing.T) {
 				q = 100 { input.x = 1 } else = 200 { true }`,
 			},
 			wantQueries: []string{
-				`data.test.q = x`,
+				`data.partial.test.q = x`,
+			},
+			wantSupport: []string{`
+				package partial.test
+
+				q = 100 { input.x = 1 } else = 200 { true }
+			`},
+		},
+		{
+			note:  "else: undefined branches dropped",
+			query: "data.test.p = x",
+			modules: []string{
+				`package test
+				p = 1 { input.x = 1 } else = 2 { false } else = 3 { input.y = 1 }`,
+			},
+			wantQueries: []string{
+				`data.partial.test.p = x`,
+			},
+			wantSupport: []string{`
+				package partial.test
+
+				p = 1 { input.x = 1 } else = 3 { input.y = 1 }
+			`},
+		},
+		{
+			note:  "else: with default",
+			query: "data.test.p = x",
+			modules: []string{
+				`package test
+				default p = 0
+				p = 1 { input.x = 1 } else = 2 { input.x = 2 }`,
+			},
+			wantQueries: []string{
+				`data.partial.test.p = x`,
+			},
+			wantSupport: []string{`
+				package partial.test
+
+				p = 1 { input.x = 1 } else = 2 { input.x = 2 }
+				default p = 0
+			`},
+		},
+		{
+			note:  "else: disjunction in branch saved",
+			query: "data.test.p = x",
+			modules: []string{
+				`package test
+				p = 1 { q[input.x] } else = 2 { true }
+				q = {"a", "b"}`,
+			},
+			wantQueries: []string{
+				`data.test.p = x`,
 			},
 		},
 		{
@@ -1819,8 +1907,13 @@ func TestTopDownPartialEval(t *testing.T) {
 				f(x) { x > 1 } else = false { x < 0 }`,
 			},
 			wantQueries: []string{
-				`data.test.f([input], true)`,
+				`data.partial.test.f([input], true)`,
 			},
+			wantSupport: []string{`
+				package partial.test
+
+				f(__local0__2) = true { gt(__local0__2, 1) } else = false { lt(__local0__2, 0) }
+			`},
 		},
 		{
 			note:  "save: ignore ast",
@@ -4014,6 +4107,38 @@ func TestTopDownPartialEval(t *testing.T) {
 				__local0__1 = input.y
 			}`},
 		},
+		{
+			note:  "comprehensions: not evaluated by default",
+			query: "data.test.p = true",
+			modules: []string{`package test
+			allowed := {"a", "b"}
+			p { input.x == [y | y := allowed[_]] }`},
+			wantQueries: []string{`input.x = [__local0__ | __local0__ = data.test.allowed[_]]`},
+		},
+		{
+			note:           "comprehensions: evaluated",
+			query:          "data.test.p = true",
+			comprehensions: true,
+			modules: []string{`package test
+			allowed := {"a", "b"}
+			p { input.x == [y | y := allowed[_]] }
+			p { input.y == {k: v | v := allowed[k]} }
+			p { input.z == {y | y := allowed[_]; y != "a"} }`},
+			wantQueries: []string{
+				`input.x = ["a", "b"]`,
+				`input.y = {"a": "a", "b": "b"}`,
+				`input.z = {"b"}`,
+			},
+		},
+		{
+			note:           "comprehensions: unknowns in body",
+			query:          "data.test.p = true",
+			comprehensions: true,
+			modules: []string{`package test
+			allowed := {"a", "b"}
+			p { input.x == [y | y := allowed[_]; y != input.z] }`},
+			wantQueries: []string{`input.x = [__local0__ | __local0__ = data.test.allowed[_]; __local1__ = input.z; neq(__local0__, __local1__)]`},
+		},
 		{
 			note:  "ref heads: special characters in ref var",
 			query: `data.test.p.q[input.z]`,
@@ -4086,7 +4211,8 @@ func TestTopDownPartialEval(t *testing.T) {
 				WithUnknowns(unknowns).
 				WithDisableInlining(disableInlining).
 				WithSkipPartialNamespace(tc.skipPartialNamespace).
-				WithShallowInlining(tc.shallow)
+				WithShallowInlining(tc.shallow).
+				WithEvalComprehensionsDuringPartial(tc.comprehensions)
 
 			// Set genvarprefix so that tests can refer to vars in generated
 			// expressions.
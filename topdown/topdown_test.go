@@ -26,6 +26,7 @@ import (
 	iCache "github.com/open-policy-agent/opa/topdown/cache"
 
 	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/metrics"
 	"github.com/open-policy-agent/opa/storage"
 	inmem "github.com/open-policy-agent/opa/storage/inmem/test"
 	"github.com/open-policy-agent/opa/types"
@@ -229,6 +230,72 @@ func TestTopDownQueryCancellation(t *testing.T) {
 	<-done
 }
 
+func TestTopDownQueryTimeout(t *testing.T) {
+
+	ctx := context.Background()
+
+	compiler := compileModules([]string{
+		`
+		package test
+
+		p { data.arr[_] = x; test.sleep("10ms"); x == 999 }
+		`,
+	})
+
+	arr := make([]interface{}, 1000)
+	for i := 0; i < 1000; i++ {
+		arr[i] = i
+	}
+	data := map[string]interface{}{
+		"arr": arr,
+	}
+
+	store := inmem.NewFromObject(data)
+	txn := storage.NewTransactionOrDie(ctx, store)
+
+	query := NewQuery(ast.MustParseBody("data.test.p")).
+		WithCompiler(compiler).
+		WithStore(store).
+		WithTransaction(txn).
+		WithCancel(NewCancel()).
+		WithTimeout(50 * time.Millisecond)
+
+	qrs, err := query.Run(ctx)
+	if err == nil || err.(*Error).Code != TimeoutErr {
+		t.Fatalf("Expected timeout error but got: %v (err: %v)", qrs, err)
+	}
+
+	if !IsTimeout(err) || !IsCancel(err) {
+		t.Fatalf("Expected error to be reported as timeout and cancellation: %v", err)
+	}
+
+	// Queries that complete within the timeout are not affected.
+	qrs, err = NewQuery(ast.MustParseBody("x = 1")).
+		WithCompiler(compiler).
+		WithStore(store).
+		WithTransaction(txn).
+		WithTimeout(time.Second).
+		Run(ctx)
+	if err != nil || len(qrs) != 1 {
+		t.Fatalf("Expected one result but got: %v (err: %v)", qrs, err)
+	}
+
+	// Cancellation by the caller is still reported as such.
+	cancel := NewCancel()
+	cancel.Cancel()
+
+	_, err = NewQuery(ast.MustParseBody("data.test.p")).
+		WithCompiler(compiler).
+		WithStore(store).
+		WithTransaction(txn).
+		WithCancel(cancel).
+		WithTimeout(time.Second).
+		Run(ctx)
+	if !IsCancel(err) || IsTimeout(err) {
+		t.Fatalf("Expected cancel error but got: %v", err)
+	}
+}
+
 func TestTopDownQueryCancellationEvery(t *testing.T) {
 	ctx := context.Background()
 
@@ -1551,6 +1618,83 @@ arr := [1, 2, 3, 4, 5]
 	}
 }
 
+func TestTopDownEarlyExitInstrumentation(t *testing.T) {
+	tests := []struct {
+		note       string
+		module     string
+		exit       uint64
+		deferred   uint64
+		suppressed uint64
+	}{
+		{
+			note: "complete doc",
+			module: `package test
+				p { data.arr[_] = 1 }`,
+			exit:       1,
+			suppressed: 1,
+		},
+		{
+			note: "complete doc, multiple rules",
+			module: `package test
+				p { true }
+				p { data.arr[_] }`,
+			exit:       1,
+			suppressed: 1,
+		},
+		{
+			note: "complete doc, deferred by function without early exit",
+			module: `package test
+				p { data.arr[_] = x; f(x) == x }
+				f(x) := y { y := x }`,
+			exit:       1,
+			deferred:   1,
+			suppressed: 2, // f + p
+		},
+		{
+			note: "partial set, no early exit",
+			module: `package test
+				p[x] { data.arr_small[x] }`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			ctx := context.Background()
+			compiler := compileModules([]string{tc.module})
+			store := inmem.NewFromObject(map[string]interface{}{
+				"arr":       []int{1, 2, 3},
+				"arr_small": []int{1, 2},
+			})
+			txn := storage.NewTransactionOrDie(ctx, store)
+			defer store.Abort(ctx, txn)
+			m := metrics.New()
+
+			query := NewQuery(ast.MustParseBody("data.test.p")).
+				WithCompiler(compiler).
+				WithStore(store).
+				WithTransaction(txn).
+				WithInstrumentation(NewInstrumentation(m))
+
+			if _, err := query.Run(ctx); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			counter := func(name string) uint64 {
+				return m.Counter(name).Value().(uint64)
+			}
+			if exp, act := tc.exit, counter(evalOpEarlyExit); exp != act {
+				t.Errorf("expected %d early exits, got %d", exp, act)
+			}
+			if exp, act := tc.deferred, counter(evalOpEarlyExitDeferred); exp != act {
+				t.Errorf("expected %d deferred early exits, got %d", exp, act)
+			}
+			if exp, act := tc.suppressed, counter(evalOpEarlyExitSuppressed); exp != act {
+				t.Errorf("expected %d suppressed early exits, got %d", exp, act)
+			}
+		})
+	}
+}
+
 func TestTopDownEvery(t *testing.T) {
 	n := func(ns ...string) []string { return ns }
 
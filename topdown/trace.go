@@ -5,8 +5,11 @@
 package topdown
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
+	"math/rand"
+	"sort"
 	"strings"
 
 	iStrs "github.com/open-policy-agent/opa/internal/strings"
@@ -84,8 +87,9 @@ type Event struct {
 	Message       string                  // Contains message for Note events.
 	Ref           *ast.Ref                // Identifies the subject ref for the event. Only applies to Index and Wasm operations.
 
-	input    *ast.Term
-	bindings *bindings
+	input        *ast.Term
+	bindings     *bindings
+	virtualCache []VirtualCacheEntry
 }
 
 // HasRule returns true if the Event contains an ast.Rule.
@@ -132,6 +136,12 @@ func (evt *Event) Input() *ast.Term {
 	return evt.input
 }
 
+// VirtualCache returns the virtual documents cached when the event occurred.
+// Nil if the cache was not included in the trace event.
+func (evt *Event) VirtualCache() []VirtualCacheEntry {
+	return evt.virtualCache
+}
+
 // Plug plugs event bindings into the provided ast.Term. Because bindings are mutable, this only makes sense to do when
 // the event is emitted rather than on recorded trace events as the bindings are going to be different by then.
 func (evt *Event) Plug(term *ast.Term) *ast.Term {
@@ -176,7 +186,100 @@ type QueryTracer interface {
 
 // TraceConfig defines some common configuration for Tracer implementations
 type TraceConfig struct {
-	PlugLocalVars bool // Indicate whether to plug local variable bindings before calling into the tracer.
+	PlugLocalVars        bool // Indicate whether to plug local variable bindings before calling into the tracer.
+	SnapshotVirtualCache bool // Indicate whether to include a snapshot of the virtual document cache in events.
+}
+
+// FilteredQueryTracer is an optional extension of the QueryTracer interface for
+// tracers that are only interested in a subset of the events. Events for other
+// ops are not constructed unless another tracer requires them, which keeps the
+// overhead of lightweight tracers (e.g., always-on tracing in servers) low.
+type FilteredQueryTracer interface {
+	QueryTracer
+
+	// Ops returns the ops that the tracer should receive events for. If no ops
+	// are returned, the tracer receives events for all ops.
+	Ops() []Op
+
+	// SampleRate returns the fraction of queries (from 0 to 1) that the tracer
+	// should receive events for. Queries are sampled as a whole so that the
+	// tracer either receives all of the (filtered) events of a query or none.
+	SampleRate() float64
+}
+
+// traceFilter determines which tracers receive the events for each op.
+type traceFilter struct {
+	all bool              // true if any tracer receives all ops
+	ops map[Op]struct{}   // union of ops the tracers receive
+	per []map[Op]struct{} // ops each tracer receives; nil means all ops
+}
+
+// sampleTracers returns the tracers that have been sampled for a query along
+// with the filter to apply to their events. The filter is nil if all of the
+// sampled tracers receive all events.
+func sampleTracers(tracers []QueryTracer) ([]QueryTracer, *traceFilter) {
+	filtered := false
+	for _, t := range tracers {
+		if _, ok := t.(FilteredQueryTracer); ok {
+			filtered = true
+			break
+		}
+	}
+	if !filtered {
+		return tracers, nil
+	}
+
+	var sampled []QueryTracer
+	filter := &traceFilter{ops: map[Op]struct{}{}}
+
+	for _, t := range tracers {
+		ft, ok := t.(FilteredQueryTracer)
+		if !ok {
+			sampled = append(sampled, t)
+			filter.per = append(filter.per, nil)
+			filter.all = true
+			continue
+		}
+		if rate := ft.SampleRate(); rate < 1 && (rate <= 0 || rand.Float64() >= rate) {
+			continue
+		}
+		var ops map[Op]struct{}
+		if xs := ft.Ops(); len(xs) > 0 {
+			ops = make(map[Op]struct{}, len(xs))
+			for _, op := range xs {
+				ops[op] = struct{}{}
+				filter.ops[op] = struct{}{}
+			}
+		} else {
+			filter.all = true
+		}
+		sampled = append(sampled, t)
+		filter.per = append(filter.per, ops)
+	}
+
+	if len(sampled) == 0 || filter.all && len(filter.ops) == 0 {
+		return sampled, nil
+	}
+
+	return sampled, filter
+}
+
+// Enabled returns true if any of the tracers receive events for op.
+func (f *traceFilter) Enabled(op Op) bool {
+	if f == nil || f.all {
+		return true
+	}
+	_, ok := f.ops[op]
+	return ok
+}
+
+// EnabledFor returns true if the i-th tracer receives events for op.
+func (f *traceFilter) EnabledFor(i int, op Op) bool {
+	if f == nil || f.per[i] == nil {
+		return true
+	}
+	_, ok := f.per[i][op]
+	return ok
 }
 
 // legacyTracer Implements the QueryTracer interface by wrapping an older Tracer instance.
@@ -244,6 +347,100 @@ func PrettyTraceWithLocation(w io.Writer, trace []*Event) {
 	prettyTraceWith(w, trace, true)
 }
 
+// JSONTraceEvent is the machine-readable representation of a trace event
+// written by JSONTrace.
+type JSONTraceEvent struct {
+	Op           string                `json:"op"`
+	QueryID      uint64                `json:"query_id"`
+	ParentID     uint64                `json:"parent_id"`
+	Type         string                `json:"type,omitempty"`
+	Node         ast.Node              `json:"node,omitempty"`
+	Location     *ast.Location         `json:"location,omitempty"`
+	Locals       []JSONTraceLocal      `json:"locals,omitempty"`
+	Message      string                `json:"message,omitempty"`
+	Ref          *ast.Ref              `json:"ref,omitempty"`
+	VirtualCache []JSONTraceCacheEntry `json:"virtual_cache,omitempty"`
+}
+
+// JSONTraceLocal represents a local variable of a trace event. Name is the
+// name of the variable in the original policy if it was rewritten by the
+// compiler. Value is nil if the variable is not bound.
+type JSONTraceLocal struct {
+	Var   ast.Var   `json:"var"`
+	Name  ast.Var   `json:"name,omitempty"`
+	Value *ast.Term `json:"value,omitempty"`
+}
+
+// JSONTraceCacheEntry represents a virtual document cached when a trace event
+// occurred.
+type JSONTraceCacheEntry struct {
+	Ref       ast.Ref   `json:"ref"`
+	Value     *ast.Term `json:"value,omitempty"`
+	Undefined bool      `json:"undefined,omitempty"`
+}
+
+// NewJSONTraceEvent returns the machine-readable representation of evt.
+func NewJSONTraceEvent(evt *Event) JSONTraceEvent {
+	result := JSONTraceEvent{
+		Op:       strings.ToLower(string(evt.Op)),
+		QueryID:  evt.QueryID,
+		ParentID: evt.ParentID,
+		Location: evt.Location,
+		Message:  evt.Message,
+		Ref:      evt.Ref,
+	}
+
+	if evt.Node != nil {
+		result.Type = ast.TypeName(evt.Node)
+		result.Node = evt.Node
+	}
+
+	if evt.Locals != nil {
+		evt.Locals.Iter(func(k, v ast.Value) bool {
+			local := JSONTraceLocal{Var: k.(ast.Var), Value: ast.NewTerm(v)}
+			if md, ok := evt.LocalMetadata[local.Var]; ok && md.Name != local.Var {
+				local.Name = md.Name
+			}
+			result.Locals = append(result.Locals, local)
+			return false
+		})
+	}
+
+	// Rewritten variables that are not bound yet are included so that their
+	// original names are known.
+	for v, md := range evt.LocalMetadata {
+		if md.Name != v && (evt.Locals == nil || evt.Locals.Get(v) == nil) {
+			result.Locals = append(result.Locals, JSONTraceLocal{Var: v, Name: md.Name})
+		}
+	}
+
+	sort.Slice(result.Locals, func(i, j int) bool {
+		return result.Locals[i].Var.Compare(result.Locals[j].Var) < 0
+	})
+
+	for _, entry := range evt.virtualCache {
+		result.VirtualCache = append(result.VirtualCache, JSONTraceCacheEntry{
+			Ref:       entry.Ref,
+			Value:     entry.Value,
+			Undefined: entry.Value == nil,
+		})
+	}
+
+	return result
+}
+
+// JSONTrace writes the trace to the writer as newline-delimited JSON. Each
+// line contains one event (see JSONTraceEvent.)
+func JSONTrace(w io.Writer, trace []*Event) error {
+	enc := json.NewEncoder(w)
+	for _, evt := range trace {
+		if err := enc.Encode(NewJSONTraceEvent(evt)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func prettyTraceWith(w io.Writer, trace []*Event, locations bool) {
 	depths := depths{}
 
@@ -607,6 +607,204 @@ func TestMultipleTracers(t *testing.T) {
 
 }
 
+type filteredTracer struct {
+	*BufferTracer
+	ops  []Op
+	rate float64
+}
+
+func (f filteredTracer) Ops() []Op {
+	return f.ops
+}
+
+func (f filteredTracer) SampleRate() float64 {
+	return f.rate
+}
+
+func TestFilteredQueryTracer(t *testing.T) {
+
+	ctx := context.Background()
+
+	all := NewBufferTracer()
+	filtered := filteredTracer{BufferTracer: NewBufferTracer(), ops: []Op{FailOp, IndexOp}, rate: 1}
+	unsampled := filteredTracer{BufferTracer: NewBufferTracer(), rate: 0}
+
+	q := NewQuery(ast.MustParseBody("a = [1, 2, 3][_]; a > 1")).
+		WithTracer(all).
+		WithQueryTracer(filtered).
+		WithQueryTracer(unsampled)
+
+	if _, err := q.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var exp []*Event
+	for _, evt := range *all {
+		if evt.Op == FailOp || evt.Op == IndexOp {
+			exp = append(exp, evt)
+		}
+	}
+
+	if len(exp) == 0 || len(exp) != len(*filtered.BufferTracer) {
+		t.Fatalf("Expected %d events but got %d", len(exp), len(*filtered.BufferTracer))
+	}
+
+	for i := range exp {
+		if !exp[i].Equal((*filtered.BufferTracer)[i]) {
+			t.Fatalf("Expected events to be equal but at index %d got %v and %v", i, exp[i], (*filtered.BufferTracer)[i])
+		}
+	}
+
+	if len(*unsampled.BufferTracer) != 0 {
+		t.Fatalf("Expected no events for unsampled tracer but got %d", len(*unsampled.BufferTracer))
+	}
+}
+
+func TestFilteredQueryTracerOnlyUnsampled(t *testing.T) {
+
+	ctx := context.Background()
+
+	unsampled := filteredTracer{BufferTracer: NewBufferTracer(), rate: 0}
+
+	q := NewQuery(ast.MustParseBody("a = 1")).WithQueryTracer(unsampled)
+
+	tracers, _ := sampleTracers(q.tracers)
+	if len(tracers) != 0 {
+		t.Fatalf("Expected tracer to be sampled out but got %v", tracers)
+	}
+
+	if _, err := q.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(*unsampled.BufferTracer) != 0 {
+		t.Fatalf("Expected no events but got %d", len(*unsampled.BufferTracer))
+	}
+}
+
+func TestJSONTrace(t *testing.T) {
+	ctx := context.Background()
+	compiler := compileModules([]string{`package test
+
+	p { x := 1; x > 0 }`})
+
+	tracer := NewBufferTracer()
+	_, err := NewQuery(ast.MustParseBody("data.test.p")).
+		WithCompiler(compiler).
+		WithStore(inmem.New()).
+		WithTracer(tracer).
+		Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := JSONTrace(&buf, *tracer); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(*tracer) {
+		t.Fatalf("Expected %d lines but got %d", len(*tracer), len(lines))
+	}
+
+	var found bool
+	for i, line := range lines {
+		var evt struct {
+			Op       string `json:"op"`
+			QueryID  uint64 `json:"query_id"`
+			ParentID uint64 `json:"parent_id"`
+			Type     string `json:"type"`
+			Location struct {
+				Row int `json:"row"`
+			} `json:"location"`
+			Locals []struct {
+				Var   string      `json:"var"`
+				Name  string      `json:"name"`
+				Value interface{} `json:"value"`
+			} `json:"locals"`
+		}
+		if err := util.UnmarshalJSON([]byte(line), &evt); err != nil {
+			t.Fatal(err)
+		}
+		orig := (*tracer)[i]
+		if evt.Op != strings.ToLower(string(orig.Op)) || evt.QueryID != orig.QueryID || evt.ParentID != orig.ParentID {
+			t.Fatalf("Expected event %v but got %v", orig, line)
+		}
+		if evt.Type != ast.TypeName(orig.Node) || evt.Location.Row != orig.Location.Row {
+			t.Fatalf("Expected event %v but got %v", orig, line)
+		}
+		for _, l := range evt.Locals {
+			if l.Name == "x" {
+				found = true
+			}
+		}
+	}
+
+	if !found {
+		t.Fatal("Expected to find local with rewritten var 'x'")
+	}
+}
+
+type cacheSnapshotTracer struct {
+	*BufferTracer
+}
+
+func (cacheSnapshotTracer) Config() TraceConfig {
+	return TraceConfig{PlugLocalVars: true, SnapshotVirtualCache: true}
+}
+
+func TestJSONTraceVirtualCache(t *testing.T) {
+	ctx := context.Background()
+	compiler := compileModules([]string{`package test
+
+	p { q; not r }
+
+	q := true
+
+	r { false }`})
+
+	tracer := cacheSnapshotTracer{BufferTracer: NewBufferTracer()}
+	_, err := NewQuery(ast.MustParseBody("data.test.p")).
+		WithCompiler(compiler).
+		WithStore(inmem.New()).
+		WithQueryTracer(tracer).
+		Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := *tracer.BufferTracer
+	if len(events[0].VirtualCache()) != 0 {
+		t.Fatalf("Expected empty cache in first event but got %v", events[0].VirtualCache())
+	}
+
+	var buf bytes.Buffer
+	if err := JSONTrace(&buf, events[len(events)-1:]); err != nil {
+		t.Fatal(err)
+	}
+
+	var evt struct {
+		VirtualCache []JSONTraceCacheEntry `json:"virtual_cache"`
+	}
+	if err := util.UnmarshalJSON(buf.Bytes(), &evt); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{"data.test.p=true", "data.test.q=true", "data.test.r=undefined"}
+	var result []string
+	for _, entry := range evt.VirtualCache {
+		if entry.Undefined {
+			result = append(result, entry.Ref.String()+"=undefined")
+		} else {
+			result = append(result, entry.Ref.String()+"="+entry.Value.String())
+		}
+	}
+	if !reflect.DeepEqual(result, exp) {
+		t.Fatalf("Expected cache %v but got %v", exp, result)
+	}
+}
+
 func TestTraceRewrittenQueryVars(t *testing.T) {
 	module := `package test
 
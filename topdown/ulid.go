@@ -0,0 +1,57 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"encoding/hex"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/internal/ulid"
+	"github.com/open-policy-agent/opa/topdown/builtins"
+)
+
+type ulidCachingKey string
+
+func builtinULIDNew(bctx BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+
+	var key = ulidCachingKey(operands[0].Value.String())
+
+	val, ok := bctx.Cache.Get(key)
+	if ok {
+		return iter(val.(*ast.Term))
+	}
+
+	u, err := ulid.New(bctx.Seed, getCurrentTime(bctx))
+	if err != nil {
+		return err
+	}
+
+	result := ast.StringTerm(u.String())
+	bctx.Cache.Put(key, result)
+
+	return iter(result)
+}
+
+func builtinULIDParse(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	str, err := builtins.StringOperand(operands[0].Value, 1)
+	if err != nil {
+		return err
+	}
+
+	u, err := ulid.Parse(string(str))
+	if err != nil {
+		return nil
+	}
+
+	return iter(ast.ObjectTerm(
+		ast.Item(ast.StringTerm("time"), ast.UIntNumberTerm(uint64(u.Time())*1000000)),
+		ast.Item(ast.StringTerm("randomness"), ast.StringTerm(hex.EncodeToString(u.Entropy()))),
+	))
+}
+
+func init() {
+	RegisterBuiltinFunc(ast.ULIDNew.Name, builtinULIDNew)
+	RegisterBuiltinFunc(ast.ULIDParse.Name, builtinULIDParse)
+}
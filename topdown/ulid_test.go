@@ -0,0 +1,65 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/topdown/builtins"
+)
+
+func TestTimeOrderedIDsSeedingAndCaching(t *testing.T) {
+
+	for _, tc := range []struct {
+		builtin string
+		prefix  string
+	}{
+		{builtin: "uuid.rfc9562_v7", prefix: "018cc251-f400-7"},
+		{builtin: "ulid.new", prefix: "01HK153X00"},
+	} {
+		t.Run(tc.builtin, func(t *testing.T) {
+			query := ast.MustParseBody(tc.builtin + `("x", x); ` + tc.builtin + `("y", y); ` + tc.builtin + `("x", x2)`)
+			now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			ndbc := builtins.NDBCache{}
+
+			run := func(seed int64) QueryResult {
+				qrs, err := NewQuery(query).
+					WithSeed(rand.New(rand.NewSource(seed))).
+					WithTime(now).
+					WithNDBuiltinCache(ndbc).
+					WithCompiler(ast.NewCompiler()).
+					Run(context.Background())
+				if err != nil {
+					t.Fatal(err)
+				} else if len(qrs) != 1 {
+					t.Fatal("expected exactly one result but got:", qrs)
+				}
+				return qrs[0]
+			}
+
+			qr := run(0)
+			x, y := string(qr[ast.Var("x")].Value.(ast.String)), string(qr[ast.Var("y")].Value.(ast.String))
+			if !strings.HasPrefix(x, tc.prefix) || !strings.HasPrefix(y, tc.prefix) {
+				t.Fatalf("expected IDs with prefix %v but got %v and %v", tc.prefix, x, y)
+			}
+			if x == y || !qr[ast.Var("x")].Equal(qr[ast.Var("x2")]) {
+				t.Fatalf("expected IDs to be consistent per key but got: %v", qr)
+			}
+
+			// The IDs are replayed from the NDBCache regardless of the seed.
+			if _, ok := ndbc[tc.builtin]; !ok {
+				t.Fatalf("expected %v in NDBCache but got %v", tc.builtin, ndbc)
+			}
+			if replayed := run(1); !replayed[ast.Var("x")].Equal(qr[ast.Var("x")]) || !replayed[ast.Var("y")].Equal(qr[ast.Var("y")]) {
+				t.Fatalf("expected %v but got %v", qr, replayed)
+			}
+		})
+	}
+}
@@ -12,6 +12,8 @@ import (
 
 type uuidCachingKey string
 
+type uuidV7CachingKey string
+
 func builtinUUIDRFC4122(bctx BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
 
 	var key = uuidCachingKey(operands[0].Value.String())
@@ -32,6 +34,26 @@ func builtinUUIDRFC4122(bctx BuiltinContext, operands []*ast.Term, iter func(*as
 	return iter(result)
 }
 
+func builtinUUIDRFC9562V7(bctx BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+
+	var key = uuidV7CachingKey(operands[0].Value.String())
+
+	val, ok := bctx.Cache.Get(key)
+	if ok {
+		return iter(val.(*ast.Term))
+	}
+
+	s, err := uuid.NewV7(bctx.Seed, getCurrentTime(bctx))
+	if err != nil {
+		return err
+	}
+
+	result := ast.NewTerm(ast.String(s))
+	bctx.Cache.Put(key, result)
+
+	return iter(result)
+}
+
 func builtinUUIDParse(_ BuiltinContext, operands []*ast.Term, iter func(term *ast.Term) error) error {
 	str, err := builtins.StringOperand(operands[0].Value, 1)
 	if err != nil {
@@ -52,5 +74,6 @@ func builtinUUIDParse(_ BuiltinContext, operands []*ast.Term, iter func(term *as
 
 func init() {
 	RegisterBuiltinFunc(ast.UUIDRFC4122.Name, builtinUUIDRFC4122)
+	RegisterBuiltinFunc(ast.UUIDRFC9562V7.Name, builtinUUIDRFC9562V7)
 	RegisterBuiltinFunc(ast.UUIDParse.Name, builtinUUIDParse)
 }
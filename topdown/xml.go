@@ -0,0 +1,349 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/topdown/builtins"
+)
+
+// xmlMaxDepth is the maximum nesting depth of the elements of XML documents.
+const xmlMaxDepth = 256
+
+var (
+	errXMLDirective = errors.New("DTDs and other directives are not allowed")
+	errXMLDepth     = fmt.Errorf("elements are nested deeper than %d levels", xmlMaxDepth)
+	errXMLNoRoot    = errors.New("document has no root element")
+	errXMLRoots     = errors.New("document has more than one root element")
+	errXMLElement   = errors.New("invalid XML element")
+)
+
+type xmlCacheKey string
+
+type xmlNodeKind int
+
+const (
+	xmlRootNode xmlNodeKind = iota
+	xmlElementNode
+	xmlAttributeNode
+	xmlTextNode
+)
+
+// xmlNode is a node of the tree that XPath expressions are evaluated on. The
+// nodes are numbered in document order.
+type xmlNode struct {
+	kind      xmlNodeKind
+	name      string // local name of elements and attributes
+	namespace string // namespace URI of elements and attributes
+	value     string // value of attributes and text nodes
+	attrs     []*xmlNode
+	children  []*xmlNode
+	parent    *xmlNode
+	order     int
+	term      *ast.Term // value of elements, as returned by xml.unmarshal
+}
+
+// parseXML parses the document s. Only the predefined entities are expanded:
+// documents with DTDs, which could declare entities or fetch external ones,
+// are rejected.
+func parseXML(s string) (*xmlNode, error) {
+	d := xml.NewDecoder(strings.NewReader(s))
+	d.Strict = true
+
+	root := &xmlNode{kind: xmlRootNode}
+	stack := []*xmlNode{root}
+
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		parent := stack[len(stack)-1]
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			if parent == root && len(root.children) > 0 {
+				return nil, errXMLRoots
+			}
+			if len(stack) > xmlMaxDepth {
+				return nil, errXMLDepth
+			}
+			n := &xmlNode{kind: xmlElementNode, name: tok.Name.Local, namespace: tok.Name.Space, parent: parent}
+			for _, attr := range tok.Attr {
+				if attr.Name.Space == "xmlns" || attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+					continue
+				}
+				n.attrs = append(n.attrs, &xmlNode{kind: xmlAttributeNode, name: attr.Name.Local, namespace: attr.Name.Space, value: attr.Value, parent: n})
+			}
+			parent.children = append(parent.children, n)
+			stack = append(stack, n)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if parent == root {
+				continue
+			}
+			// Adjacent text and CDATA sections are merged into a single text
+			// node. Whitespace between elements is not kept.
+			if last := len(parent.children) - 1; last >= 0 && parent.children[last].kind == xmlTextNode {
+				parent.children[last].value += string(tok)
+				continue
+			}
+			if strings.TrimSpace(string(tok)) == "" {
+				continue
+			}
+			parent.children = append(parent.children, &xmlNode{kind: xmlTextNode, value: string(tok), parent: parent})
+		case xml.Directive:
+			return nil, errXMLDirective
+		}
+	}
+
+	if len(root.children) == 0 {
+		return nil, errXMLNoRoot
+	}
+
+	root.number(0)
+	root.children[0].toTerm()
+	return root, nil
+}
+
+// number assigns the document order to n and its descendants, starting at
+// order, and returns the next order.
+func (n *xmlNode) number(order int) int {
+	n.order = order
+	order++
+	for _, attr := range n.attrs {
+		attr.order = order
+		order++
+	}
+	for _, child := range n.children {
+		order = child.number(order)
+	}
+	return order
+}
+
+// toTerm sets the values of element n and its descendants:
+//
+//	{"name": ..., "namespace": ..., "attributes": {...}, "children": [...]}
+//
+// Namespaced attributes are keyed as {namespace}name.
+func (n *xmlNode) toTerm() *ast.Term {
+	if n.kind == xmlTextNode {
+		return ast.StringTerm(n.value)
+	}
+	attrs := ast.NewObject()
+	for _, attr := range n.attrs {
+		attrs.Insert(ast.StringTerm(attr.qualifiedName()), ast.StringTerm(attr.value))
+	}
+	children := make([]*ast.Term, len(n.children))
+	for i, child := range n.children {
+		children[i] = child.toTerm()
+	}
+	n.term = ast.ObjectTerm(
+		ast.Item(ast.StringTerm("name"), ast.StringTerm(n.name)),
+		ast.Item(ast.StringTerm("namespace"), ast.StringTerm(n.namespace)),
+		ast.Item(ast.StringTerm("attributes"), ast.NewTerm(attrs)),
+		ast.Item(ast.StringTerm("children"), ast.ArrayTerm(children...)),
+	)
+	return n.term
+}
+
+func (n *xmlNode) qualifiedName() string {
+	if n.namespace == "" {
+		return n.name
+	}
+	return "{" + n.namespace + "}" + n.name
+}
+
+// xmlTreeFromTerm returns the tree of the element t, as returned by
+// xml.unmarshal.
+func xmlTreeFromTerm(t *ast.Term) (*xmlNode, error) {
+	root := &xmlNode{kind: xmlRootNode}
+	n, err := xmlNodeFromTerm(t, root, 1)
+	if err != nil {
+		return nil, err
+	}
+	root.children = []*xmlNode{n}
+	root.number(0)
+	return root, nil
+}
+
+func xmlNodeFromTerm(t *ast.Term, parent *xmlNode, depth int) (*xmlNode, error) {
+	if s, ok := t.Value.(ast.String); ok && parent.kind == xmlElementNode {
+		return &xmlNode{kind: xmlTextNode, value: string(s), parent: parent}, nil
+	}
+	obj, ok := t.Value.(ast.Object)
+	if !ok {
+		return nil, errXMLElement
+	}
+	if depth > xmlMaxDepth {
+		return nil, errXMLDepth
+	}
+
+	n := &xmlNode{kind: xmlElementNode, parent: parent, term: t}
+	name := obj.Get(ast.StringTerm("name"))
+	if name == nil {
+		return nil, errXMLElement
+	}
+	s, ok := name.Value.(ast.String)
+	if !ok {
+		return nil, errXMLElement
+	}
+	n.name = string(s)
+	if ns := obj.Get(ast.StringTerm("namespace")); ns != nil {
+		s, ok := ns.Value.(ast.String)
+		if !ok {
+			return nil, errXMLElement
+		}
+		n.namespace = string(s)
+	}
+
+	if attrs := obj.Get(ast.StringTerm("attributes")); attrs != nil {
+		o, ok := attrs.Value.(ast.Object)
+		if !ok {
+			return nil, errXMLElement
+		}
+		err := o.Iter(func(k, v *ast.Term) error {
+			ks, ok1 := k.Value.(ast.String)
+			vs, ok2 := v.Value.(ast.String)
+			if !ok1 || !ok2 {
+				return errXMLElement
+			}
+			attr := &xmlNode{kind: xmlAttributeNode, name: string(ks), value: string(vs), parent: n}
+			if i := strings.LastIndexByte(attr.name, '}'); strings.HasPrefix(attr.name, "{") && i > 0 {
+				attr.namespace, attr.name = attr.name[1:i], attr.name[i+1:]
+			}
+			n.attrs = append(n.attrs, attr)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if children := obj.Get(ast.StringTerm("children")); children != nil {
+		arr, ok := children.Value.(*ast.Array)
+		if !ok {
+			return nil, errXMLElement
+		}
+		for i := 0; i < arr.Len(); i++ {
+			child, err := xmlNodeFromTerm(arr.Elem(i), n, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			n.children = append(n.children, child)
+		}
+	}
+
+	return n, nil
+}
+
+// getXMLTree returns the tree of the document operand, either a string or
+// an element returned by xml.unmarshal, cached within the query.
+func getXMLTree(bctx BuiltinContext, operand *ast.Term) (*xmlNode, error) {
+	c := getValueKeyedCache(bctx, xmlCacheKey("tree"))
+	if v, ok := c.Get(operand.Value); ok {
+		return v.(*xmlNode), nil
+	}
+
+	var root *xmlNode
+	var err error
+	switch v := operand.Value.(type) {
+	case ast.String:
+		root, err = parseXML(string(v))
+	case ast.Object:
+		root, err = xmlTreeFromTerm(operand)
+	default:
+		return nil, builtins.NewOperandTypeErr(1, operand.Value, "string", "object")
+	}
+	if err != nil {
+		return nil, builtins.NewOperandErr(1, err.Error())
+	}
+
+	c.Put(operand.Value, root)
+	return root, nil
+}
+
+func builtinXMLUnmarshal(bctx BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	if _, err := builtins.StringOperand(operands[0].Value, 1); err != nil {
+		return err
+	}
+
+	root, err := getXMLTree(bctx, operands[0])
+	if err != nil {
+		return err
+	}
+
+	return iter(root.children[0].term)
+}
+
+func builtinXMLXPath(bctx BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	root, err := getXMLTree(bctx, operands[0])
+	if err != nil {
+		return err
+	}
+
+	s, err := builtins.StringOperand(operands[1].Value, 2)
+	if err != nil {
+		return err
+	}
+
+	c := getValueKeyedCache(bctx, xmlCacheKey("xpath"))
+	var expr xpathExpr
+	if v, ok := c.Get(s); ok {
+		expr = v.(xpathExpr)
+	} else {
+		expr, err = parseXPath(string(s))
+		if err != nil {
+			return builtins.NewOperandErr(2, err.Error())
+		}
+		c.Put(s, expr)
+	}
+
+	switch v := expr.eval(xpathContext{node: root, position: 1, size: 1}).(type) {
+	case []*xmlNode:
+		result := make([]*ast.Term, len(v))
+		for i, n := range v {
+			result[i] = n.valueTerm()
+		}
+		return iter(ast.ArrayTerm(result...))
+	case string:
+		return iter(ast.StringTerm(v))
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil
+		}
+		return iter(ast.FloatNumberTerm(v))
+	case bool:
+		return iter(ast.BooleanTerm(v))
+	}
+	return nil
+}
+
+// valueTerm returns the value of n as returned by xml.xpath: elements as
+// returned by xml.unmarshal, and the values of attributes and text nodes.
+func (n *xmlNode) valueTerm() *ast.Term {
+	switch n.kind {
+	case xmlRootNode:
+		return n.children[0].term
+	case xmlElementNode:
+		return n.term
+	}
+	return ast.StringTerm(n.value)
+}
+
+func init() {
+	RegisterBuiltinFunc(ast.XMLUnmarshal.Name, builtinXMLUnmarshal)
+	RegisterBuiltinFunc(ast.XMLXPath.Name, builtinXMLXPath)
+}
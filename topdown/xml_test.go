@@ -0,0 +1,195 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/topdown/builtins"
+)
+
+const xmlTestDocument = `<?xml version="1.0"?>
+<!-- a comment -->
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion">
+  <soap:Body>
+    <saml:Assertion ID="a1" Version="2.0">
+      <saml:Issuer>https://idp.example.com</saml:Issuer>
+      <saml:AttributeStatement>
+        <saml:Attribute Name="role"><saml:AttributeValue>admin</saml:AttributeValue><saml:AttributeValue>dev</saml:AttributeValue></saml:Attribute>
+        <saml:Attribute Name="age"><saml:AttributeValue>42</saml:AttributeValue></saml:Attribute>
+      </saml:AttributeStatement>
+    </saml:Assertion>
+  </soap:Body>
+</soap:Envelope>`
+
+func TestXMLUnmarshal(t *testing.T) {
+	tests := []struct {
+		note string
+		doc  string
+		exp  string
+		err  string
+	}{
+		{
+			note: "elements, attributes and text",
+			doc:  `<a x="1" xmlns:p="urn:p" p:y="2"><b>text &amp; <![CDATA[<cdata>]]></b>  <c/><?pi?></a>`,
+			exp: `{"name": "a", "namespace": "", "attributes": {"x": "1", "{urn:p}y": "2"}, "children": [
+				{"name": "b", "namespace": "", "attributes": {}, "children": ["text & <cdata>"]},
+				{"name": "c", "namespace": "", "attributes": {}, "children": []}
+			]}`,
+		},
+		{
+			note: "default namespace",
+			doc:  `<a xmlns="urn:a"><b/></a>`,
+			exp: `{"name": "a", "namespace": "urn:a", "attributes": {}, "children": [
+				{"name": "b", "namespace": "urn:a", "attributes": {}, "children": []}
+			]}`,
+		},
+		{
+			note: "entity expansion",
+			doc: `<?xml version="1.0"?>
+<!DOCTYPE lolz [<!ENTITY lol "lol"><!ENTITY lol1 "&lol;&lol;&lol;&lol;&lol;">]>
+<lolz>&lol1;</lolz>`,
+			err: "DTDs and other directives are not allowed",
+		},
+		{
+			note: "external entity",
+			doc:  `<!DOCTYPE foo [<!ENTITY xxe SYSTEM "file:///etc/passwd">]><foo>&xxe;</foo>`,
+			err:  "DTDs and other directives are not allowed",
+		},
+		{
+			note: "undefined entity",
+			doc:  `<foo>&xxe;</foo>`,
+			err:  "invalid character entity &xxe;",
+		},
+		{
+			note: "nesting",
+			doc:  strings.Repeat("<a>", xmlMaxDepth+1) + strings.Repeat("</a>", xmlMaxDepth+1),
+			err:  "elements are nested deeper than 256 levels",
+		},
+		{
+			note: "no root",
+			doc:  `<!-- empty -->`,
+			err:  "document has no root element",
+		},
+		{
+			note: "several roots",
+			doc:  `<a/><b/>`,
+			err:  "document has more than one root element",
+		},
+		{
+			note: "malformed",
+			doc:  `<a><b></a>`,
+			err:  "element <b> closed by </a>",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			var result *ast.Term
+			err := builtinXMLUnmarshal(BuiltinContext{}, []*ast.Term{ast.StringTerm(tc.doc)}, func(x *ast.Term) error {
+				result = x
+				return nil
+			})
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("Expected error %q but got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exp := ast.MustParseTerm(tc.exp); !exp.Equal(result) {
+				t.Fatalf("Expected %v but got %v", exp, result)
+			}
+		})
+	}
+}
+
+func TestXMLXPath(t *testing.T) {
+	tests := []struct {
+		note string
+		expr string
+		exp  string
+		err  string
+	}{
+		{note: "absolute path", expr: "/Envelope/Body/Assertion/Issuer/text()", exp: `["https://idp.example.com"]`},
+		{note: "prefixed names", expr: "/soap:Envelope/soap:Body/saml:Assertion/@ID", exp: `["a1"]`},
+		{note: "descendants", expr: "//AttributeValue/text()", exp: `["admin", "dev", "42"]`},
+		{note: "attribute predicate", expr: "//Attribute[@Name='role']/AttributeValue/text()", exp: `["admin", "dev"]`},
+		{note: "position", expr: "//Attribute[1]/AttributeValue[2]/text()", exp: `["dev"]`},
+		{note: "position per parent", expr: "//AttributeValue[1]/text()", exp: `["admin", "42"]`},
+		{note: "last", expr: "//AttributeValue[last()]/text()", exp: `["dev", "42"]`},
+		{note: "node set comparison", expr: "//Attribute[AttributeValue = 'dev']/@Name", exp: `["role"]`},
+		{note: "numeric comparison", expr: "//Attribute[AttributeValue > 40]/@Name", exp: `["age"]`},
+		{note: "and or not", expr: "//Attribute[not(@Name = 'age') and (count(AttributeValue) = 2 or false())]/@Name", exp: `["role"]`},
+		{note: "functions", expr: "//*[starts-with(local-name(), 'Attr') and contains(string(), 'min')]/@Name", exp: `["role"]`},
+		{note: "parent", expr: "//Issuer/../@Version", exp: `["2.0"]`},
+		{note: "axes", expr: "/child::Envelope/descendant::Attribute/attribute::Name", exp: `["role", "age"]`},
+		{note: "self", expr: "//Attribute/self::node()[@Name='age']/@Name", exp: `["age"]`},
+		{note: "wildcards", expr: "/*/*/saml:*/@*", exp: `["a1", "2.0"]`},
+		{note: "union in document order", expr: "//Issuer/text() | //Assertion/@ID", exp: `["a1", "https://idp.example.com"]`},
+		{note: "elements", expr: "//Attribute[@Name='age']", exp: `[{"name": "Attribute", "namespace": "urn:oasis:names:tc:SAML:2.0:assertion", "attributes": {"Name": "age"}, "children": [
+			{"name": "AttributeValue", "namespace": "urn:oasis:names:tc:SAML:2.0:assertion", "attributes": {}, "children": ["42"]}
+		]}]`},
+		{note: "root", expr: "count(/)", exp: `1`},
+		{note: "count", expr: "count(//AttributeValue)", exp: `3`},
+		{note: "string", expr: "normalize-space(string(//Issuer))", exp: `"https://idp.example.com"`},
+		{note: "number", expr: "number(//Attribute[@Name='age'])", exp: `42`},
+		{note: "boolean", expr: "//Issuer = 'https://idp.example.com'", exp: `true`},
+		{note: "no match", expr: "//Missing", exp: `[]`},
+		{note: "unsupported function", expr: "//a[sum(b)]", err: "unsupported function sum()"},
+		{note: "unsupported axis", expr: "following::a", err: "unsupported axis following"},
+		{note: "arity", expr: "count()", err: "wrong number of arguments to count()"},
+		{note: "unterminated", expr: "//a[@b='c]", err: "unterminated string"},
+		{note: "trailing", expr: "//a]", err: `unexpected "]"`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			for _, doc := range []bool{false, true} {
+				operand := ast.StringTerm(xmlTestDocument)
+				if doc {
+					// Elements returned by xml.unmarshal are queried alike.
+					var err error
+					operand, err = getResult(builtinXMLUnmarshal, operand)
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				var result *ast.Term
+				bctx := BuiltinContext{Cache: builtins.Cache{}}
+				err := builtinXMLXPath(bctx, []*ast.Term{operand, ast.StringTerm(tc.expr)}, func(x *ast.Term) error {
+					result = x
+					return nil
+				})
+				if tc.err != "" {
+					if err == nil || !strings.Contains(err.Error(), tc.err) {
+						t.Fatalf("Expected error %q but got %v", tc.err, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if exp := ast.MustParseTerm(tc.exp); !exp.Equal(result) {
+					t.Fatalf("Expected %v but got %v", exp, result)
+				}
+			}
+		})
+	}
+}
+
+func TestXMLXPathInvalidElement(t *testing.T) {
+	for _, doc := range []string{`{"children": []}`, `{"name": "a", "attributes": {"b": 1}}`, `{"name": "a", "children": [1]}`, `["a"]`} {
+		err := builtinXMLXPath(BuiltinContext{}, []*ast.Term{ast.MustParseTerm(doc), ast.StringTerm("/a")}, func(*ast.Term) error { return nil })
+		if err == nil {
+			t.Fatalf("Expected error for %v", doc)
+		}
+	}
+}
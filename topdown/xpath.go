@@ -0,0 +1,811 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// This file implements the subset of XPath 1.0 supported by xml.xpath:
+//
+//   - location paths, absolute or relative, with the abbreviated syntax
+//     (//, ., .., @) and the child, descendant, descendant-or-self, self,
+//     parent and attribute axes,
+//   - name tests, matched against local names as documents carry no prefix
+//     bindings, * and the node() and text() node tests,
+//   - predicates, with the =, !=, <, <=, >, >=, and and or operators,
+//   - unions of paths, and
+//   - the last, position, count, name, local-name, string, number,
+//     normalize-space, contains, starts-with, not, true and false functions.
+//
+// Values are node sets ([]*xmlNode in document order), strings, numbers
+// (float64) and booleans.
+
+type xpathContext struct {
+	node     *xmlNode
+	position int
+	size     int
+}
+
+type xpathExpr interface {
+	eval(c xpathContext) interface{}
+}
+
+type xpathAxis int
+
+const (
+	xpathChild xpathAxis = iota
+	xpathDescendant
+	xpathDescendantOrSelf
+	xpathSelf
+	xpathParent
+	xpathAttribute
+)
+
+var xpathAxes = map[string]xpathAxis{
+	"child":              xpathChild,
+	"descendant":         xpathDescendant,
+	"descendant-or-self": xpathDescendantOrSelf,
+	"self":               xpathSelf,
+	"parent":             xpathParent,
+	"attribute":          xpathAttribute,
+}
+
+type xpathNodeTest int
+
+const (
+	xpathNameTest xpathNodeTest = iota
+	xpathAnyNameTest
+	xpathTextTest
+	xpathNodeTypeTest
+)
+
+type xpathStep struct {
+	axis       xpathAxis
+	test       xpathNodeTest
+	name       string
+	predicates []xpathExpr
+}
+
+type xpathPath struct {
+	absolute bool
+	steps    []*xpathStep
+}
+
+type xpathUnion []xpathExpr
+
+type xpathBinary struct {
+	op          string
+	left, right xpathExpr
+}
+
+type xpathLiteral struct {
+	value interface{}
+}
+
+type xpathCall struct {
+	name string
+	args []xpathExpr
+}
+
+// xpathFuncs holds the arities of the supported functions.
+var xpathFuncs = map[string][2]int{
+	"last":            {0, 0},
+	"position":        {0, 0},
+	"count":           {1, 1},
+	"name":            {0, 1},
+	"local-name":      {0, 1},
+	"string":          {0, 1},
+	"number":          {0, 1},
+	"normalize-space": {0, 1},
+	"contains":        {2, 2},
+	"starts-with":     {2, 2},
+	"not":             {1, 1},
+	"true":            {0, 0},
+	"false":           {0, 0},
+}
+
+func (p *xpathPath) eval(c xpathContext) interface{} {
+	nodes := []*xmlNode{c.node}
+	if p.absolute {
+		root := c.node
+		for root.parent != nil {
+			root = root.parent
+		}
+		nodes = []*xmlNode{root}
+	}
+	for _, step := range p.steps {
+		nodes = step.eval(nodes)
+	}
+	return nodes
+}
+
+func (s *xpathStep) eval(nodes []*xmlNode) []*xmlNode {
+	var result []*xmlNode
+	seen := map[*xmlNode]struct{}{}
+	for _, n := range nodes {
+		var candidates []*xmlNode
+		s.collect(n, func(x *xmlNode) {
+			if s.matches(x) {
+				candidates = append(candidates, x)
+			}
+		})
+		for _, pred := range s.predicates {
+			var filtered []*xmlNode
+			for i, x := range candidates {
+				c := xpathContext{node: x, position: i + 1, size: len(candidates)}
+				v := pred.eval(c)
+				if f, ok := v.(float64); ok {
+					if f == float64(c.position) {
+						filtered = append(filtered, x)
+					}
+				} else if xpathBoolean(v) {
+					filtered = append(filtered, x)
+				}
+			}
+			candidates = filtered
+		}
+		for _, x := range candidates {
+			if _, ok := seen[x]; !ok {
+				seen[x] = struct{}{}
+				result = append(result, x)
+			}
+		}
+	}
+	sortXMLNodes(result)
+	return result
+}
+
+// collect calls f with the nodes on the axis of s from n, in the order of
+// the axis.
+func (s *xpathStep) collect(n *xmlNode, f func(*xmlNode)) {
+	switch s.axis {
+	case xpathChild:
+		for _, child := range n.children {
+			f(child)
+		}
+	case xpathDescendant, xpathDescendantOrSelf:
+		if s.axis == xpathDescendantOrSelf {
+			f(n)
+		}
+		var walk func(*xmlNode)
+		walk = func(x *xmlNode) {
+			for _, child := range x.children {
+				f(child)
+				walk(child)
+			}
+		}
+		walk(n)
+	case xpathSelf:
+		f(n)
+	case xpathParent:
+		if n.parent != nil {
+			f(n.parent)
+		}
+	case xpathAttribute:
+		for _, attr := range n.attrs {
+			f(attr)
+		}
+	}
+}
+
+func (s *xpathStep) matches(n *xmlNode) bool {
+	// The principal node type of the attribute axis is attribute, and
+	// element for the others.
+	principal := xmlElementNode
+	if s.axis == xpathAttribute {
+		principal = xmlAttributeNode
+	}
+	switch s.test {
+	case xpathNameTest:
+		return n.kind == principal && n.name == s.name
+	case xpathAnyNameTest:
+		return n.kind == principal
+	case xpathTextTest:
+		return n.kind == xmlTextNode
+	}
+	return true
+}
+
+func (u xpathUnion) eval(c xpathContext) interface{} {
+	var result []*xmlNode
+	seen := map[*xmlNode]struct{}{}
+	for _, expr := range u {
+		nodes, _ := expr.eval(c).([]*xmlNode)
+		for _, n := range nodes {
+			if _, ok := seen[n]; !ok {
+				seen[n] = struct{}{}
+				result = append(result, n)
+			}
+		}
+	}
+	sortXMLNodes(result)
+	return result
+}
+
+func (b *xpathBinary) eval(c xpathContext) interface{} {
+	switch b.op {
+	case "or":
+		return xpathBoolean(b.left.eval(c)) || xpathBoolean(b.right.eval(c))
+	case "and":
+		return xpathBoolean(b.left.eval(c)) && xpathBoolean(b.right.eval(c))
+	}
+	return xpathCompare(b.op, b.left.eval(c), b.right.eval(c))
+}
+
+func (l *xpathLiteral) eval(xpathContext) interface{} {
+	return l.value
+}
+
+func (f *xpathCall) eval(c xpathContext) interface{} {
+	// The functions that take an optional node set default to the context
+	// node.
+	arg := func(i int) interface{} {
+		if i < len(f.args) {
+			return f.args[i].eval(c)
+		}
+		return []*xmlNode{c.node}
+	}
+
+	switch f.name {
+	case "last":
+		return float64(c.size)
+	case "position":
+		return float64(c.position)
+	case "count":
+		nodes, _ := arg(0).([]*xmlNode)
+		return float64(len(nodes))
+	case "name", "local-name":
+		nodes, _ := arg(0).([]*xmlNode)
+		if len(nodes) == 0 || nodes[0].kind == xmlRootNode || nodes[0].kind == xmlTextNode {
+			return ""
+		}
+		return nodes[0].name
+	case "string":
+		return xpathString(arg(0))
+	case "number":
+		return xpathNumber(arg(0))
+	case "normalize-space":
+		return strings.Join(strings.Fields(xpathString(arg(0))), " ")
+	case "contains":
+		return strings.Contains(xpathString(arg(0)), xpathString(arg(1)))
+	case "starts-with":
+		return strings.HasPrefix(xpathString(arg(0)), xpathString(arg(1)))
+	case "not":
+		return !xpathBoolean(arg(0))
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return nil
+}
+
+func sortXMLNodes(nodes []*xmlNode) {
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].order < nodes[j].order
+	})
+}
+
+// stringValue returns the string-value of n: the concatenation of the text
+// of its descendants for root and element nodes.
+func (n *xmlNode) stringValue() string {
+	if n.kind == xmlAttributeNode || n.kind == xmlTextNode {
+		return n.value
+	}
+	var sb strings.Builder
+	var walk func(*xmlNode)
+	walk = func(x *xmlNode) {
+		for _, child := range x.children {
+			if child.kind == xmlTextNode {
+				sb.WriteString(child.value)
+			} else {
+				walk(child)
+			}
+		}
+	}
+	walk(n)
+	return sb.String()
+}
+
+func xpathString(v interface{}) string {
+	switch v := v.(type) {
+	case []*xmlNode:
+		if len(v) == 0 {
+			return ""
+		}
+		return v[0].stringValue()
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return "NaN"
+		case math.IsInf(v, 0):
+			if v > 0 {
+				return "Infinity"
+			}
+			return "-Infinity"
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return v
+	}
+	return ""
+}
+
+func xpathNumber(v interface{}) float64 {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(xpathString(v)), 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return f
+}
+
+func xpathBoolean(v interface{}) bool {
+	switch v := v.(type) {
+	case []*xmlNode:
+		return len(v) > 0
+	case string:
+		return v != ""
+	case float64:
+		return v != 0 && !math.IsNaN(v)
+	case bool:
+		return v
+	}
+	return false
+}
+
+// xpathCompare compares a and b as specified by XPath 1.0: a comparison
+// involving node sets holds if it holds for any of their nodes.
+func xpathCompare(op string, a, b interface{}) bool {
+	as, aIsSet := a.([]*xmlNode)
+	bs, bIsSet := b.([]*xmlNode)
+	switch {
+	case aIsSet && bIsSet:
+		for _, x := range as {
+			for _, y := range bs {
+				if xpathCompareAtoms(op, x.stringValue(), y.stringValue()) {
+					return true
+				}
+			}
+		}
+		return false
+	case aIsSet:
+		if _, ok := b.(bool); ok {
+			return xpathCompareAtoms(op, xpathBoolean(a), b)
+		}
+		for _, x := range as {
+			if xpathCompareAtoms(op, xpathAtom(x, b), b) {
+				return true
+			}
+		}
+		return false
+	case bIsSet:
+		if _, ok := a.(bool); ok {
+			return xpathCompareAtoms(op, a, xpathBoolean(b))
+		}
+		for _, y := range bs {
+			if xpathCompareAtoms(op, a, xpathAtom(y, a)) {
+				return true
+			}
+		}
+		return false
+	}
+	return xpathCompareAtoms(op, a, b)
+}
+
+// xpathAtom returns the value of n to compare with other.
+func xpathAtom(n *xmlNode, other interface{}) interface{} {
+	if _, ok := other.(float64); ok {
+		return xpathNumber(n.stringValue())
+	}
+	return n.stringValue()
+}
+
+func xpathCompareAtoms(op string, a, b interface{}) bool {
+	if op == "=" || op == "!=" {
+		var eq bool
+		_, aBool := a.(bool)
+		_, bBool := b.(bool)
+		_, aNum := a.(float64)
+		_, bNum := b.(float64)
+		switch {
+		case aBool || bBool:
+			eq = xpathBoolean(a) == xpathBoolean(b)
+		case aNum || bNum:
+			eq = xpathNumber(a) == xpathNumber(b)
+		default:
+			eq = xpathString(a) == xpathString(b)
+		}
+		return eq == (op == "=")
+	}
+
+	x, y := xpathNumber(a), xpathNumber(b)
+	switch op {
+	case "<":
+		return x < y
+	case "<=":
+		return x <= y
+	case ">":
+		return x > y
+	case ">=":
+		return x >= y
+	}
+	return false
+}
+
+// parseXPath parses the expression s.
+func parseXPath(s string) (xpathExpr, error) {
+	tokens, err := xpathTokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &xpathParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("invalid XPath expression: unexpected %q", p.tokens[p.pos].text)
+	}
+	return expr, nil
+}
+
+type xpathTokenKind int
+
+const (
+	xpathOperatorToken xpathTokenKind = iota
+	xpathNameToken
+	xpathStringToken
+	xpathNumberToken
+)
+
+type xpathToken struct {
+	kind xpathTokenKind
+	text string
+}
+
+func xpathTokenize(s string) ([]xpathToken, error) {
+	var tokens []xpathToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '\'':
+			j := strings.IndexByte(s[i+1:], c)
+			if j < 0 {
+				return nil, fmt.Errorf("invalid XPath expression: unterminated string")
+			}
+			tokens = append(tokens, xpathToken{kind: xpathStringToken, text: s[i+1 : i+1+j]})
+			i += j + 2
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9':
+			j := i + 1
+			for j < len(s) && (s[j] >= '0' && s[j] <= '9' || s[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, xpathToken{kind: xpathNumberToken, text: s[i:j]})
+			i = j
+		case isXPathNameStart(rune(c)) || c >= 0x80:
+			j := i
+			for j < len(s) && (isXPathNameChar(rune(s[j])) || s[j] >= 0x80) {
+				// A single colon separates a prefix from the local name, two
+				// separate an axis from the node test.
+				if s[j] == ':' && (j+1 >= len(s) || s[j+1] == ':' || j > i && s[j-1] == ':') {
+					break
+				}
+				j++
+			}
+			// Include the wildcard of prefix:* name tests.
+			if s[j-1] == ':' && j < len(s) && s[j] == '*' {
+				j++
+			}
+			tokens = append(tokens, xpathToken{kind: xpathNameToken, text: s[i:j]})
+			i = j
+		default:
+			op := ""
+			for _, candidate := range []string{"//", "..", "::", "!=", "<=", ">=", "/", ".", "@", "[", "]", "(", ")", ",", "|", "*", "=", "<", ">"} {
+				if strings.HasPrefix(s[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("invalid XPath expression: unexpected %q", c)
+			}
+			tokens = append(tokens, xpathToken{kind: xpathOperatorToken, text: op})
+			i += len(op)
+		}
+	}
+	return tokens, nil
+}
+
+func isXPathNameStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isXPathNameChar(r rune) bool {
+	return isXPathNameStart(r) || unicode.IsDigit(r) || r == '-' || r == '.' || r == ':'
+}
+
+type xpathParser struct {
+	tokens []xpathToken
+	pos    int
+}
+
+func (p *xpathParser) peek(offset int) *xpathToken {
+	if p.pos+offset < len(p.tokens) {
+		return &p.tokens[p.pos+offset]
+	}
+	return nil
+}
+
+// accept consumes the next token if it is the operator or operator name op.
+func (p *xpathParser) accept(op string) bool {
+	if t := p.peek(0); t != nil && (t.kind == xpathOperatorToken || t.kind == xpathNameToken) && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *xpathParser) expect(op string) error {
+	if !p.accept(op) {
+		if t := p.peek(0); t != nil {
+			return fmt.Errorf("invalid XPath expression: expected %q but got %q", op, t.text)
+		}
+		return fmt.Errorf("invalid XPath expression: expected %q", op)
+	}
+	return nil
+}
+
+func (p *xpathParser) parseBinary(ops []string, next func() (xpathExpr, error)) (xpathExpr, error) {
+	left, err := next()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op string
+		for _, candidate := range ops {
+			if p.accept(candidate) {
+				op = candidate
+				break
+			}
+		}
+		if op == "" {
+			return left, nil
+		}
+		right, err := next()
+		if err != nil {
+			return nil, err
+		}
+		left = &xpathBinary{op: op, left: left, right: right}
+	}
+}
+
+func (p *xpathParser) parseOr() (xpathExpr, error) {
+	return p.parseBinary([]string{"or"}, p.parseAnd)
+}
+
+func (p *xpathParser) parseAnd() (xpathExpr, error) {
+	return p.parseBinary([]string{"and"}, p.parseEquality)
+}
+
+func (p *xpathParser) parseEquality() (xpathExpr, error) {
+	return p.parseBinary([]string{"=", "!="}, p.parseRelational)
+}
+
+func (p *xpathParser) parseRelational() (xpathExpr, error) {
+	return p.parseBinary([]string{"<=", ">=", "<", ">"}, p.parseUnion)
+}
+
+func (p *xpathParser) parseUnion() (xpathExpr, error) {
+	expr, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(0); t == nil || t.kind != xpathOperatorToken || t.text != "|" {
+		return expr, nil
+	}
+	union := xpathUnion{expr}
+	for p.accept("|") {
+		expr, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		union = append(union, expr)
+	}
+	return union, nil
+}
+
+func (p *xpathParser) parsePrimary() (xpathExpr, error) {
+	t := p.peek(0)
+	if t == nil {
+		return nil, fmt.Errorf("invalid XPath expression: unexpected end")
+	}
+
+	switch t.kind {
+	case xpathStringToken:
+		p.pos++
+		return &xpathLiteral{value: t.text}, nil
+	case xpathNumberToken:
+		p.pos++
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid XPath expression: invalid number %q", t.text)
+		}
+		return &xpathLiteral{value: f}, nil
+	case xpathOperatorToken:
+		if t.text == "(" {
+			p.pos++
+			expr, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return expr, p.expect(")")
+		}
+	case xpathNameToken:
+		// Names followed by parentheses are function calls, except for the
+		// node type tests.
+		if next := p.peek(1); next != nil && next.kind == xpathOperatorToken && next.text == "(" && t.text != "text" && t.text != "node" {
+			return p.parseCall()
+		}
+	}
+
+	return p.parsePath()
+}
+
+func (p *xpathParser) parseCall() (xpathExpr, error) {
+	name := p.tokens[p.pos].text
+	arity, ok := xpathFuncs[name]
+	if !ok {
+		return nil, fmt.Errorf("invalid XPath expression: unsupported function %v()", name)
+	}
+	p.pos += 2
+
+	call := &xpathCall{name: name}
+	if !p.accept(")") {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+			if p.accept(")") {
+				break
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(call.args) < arity[0] || len(call.args) > arity[1] {
+		return nil, fmt.Errorf("invalid XPath expression: wrong number of arguments to %v()", name)
+	}
+	return call, nil
+}
+
+func (p *xpathParser) parsePath() (xpathExpr, error) {
+	path := &xpathPath{}
+
+	switch {
+	case p.accept("//"):
+		path.absolute = true
+		path.steps = append(path.steps, &xpathStep{axis: xpathDescendantOrSelf, test: xpathNodeTypeTest})
+	case p.accept("/"):
+		path.absolute = true
+		// The root alone, unless followed by a step.
+		if !p.atStep() {
+			return path, nil
+		}
+	}
+
+	for {
+		step, err := p.parseStep()
+		if err != nil {
+			return nil, err
+		}
+		path.steps = append(path.steps, step)
+
+		switch {
+		case p.accept("//"):
+			path.steps = append(path.steps, &xpathStep{axis: xpathDescendantOrSelf, test: xpathNodeTypeTest})
+		case p.accept("/"):
+		default:
+			return path, nil
+		}
+	}
+}
+
+func (p *xpathParser) atStep() bool {
+	t := p.peek(0)
+	if t == nil {
+		return false
+	}
+	if t.kind == xpathNameToken {
+		return true
+	}
+	return t.kind == xpathOperatorToken && (t.text == "." || t.text == ".." || t.text == "@" || t.text == "*")
+}
+
+func (p *xpathParser) parseStep() (*xpathStep, error) {
+	switch {
+	case p.accept("."):
+		return &xpathStep{axis: xpathSelf, test: xpathNodeTypeTest}, nil
+	case p.accept(".."):
+		return &xpathStep{axis: xpathParent, test: xpathNodeTypeTest}, nil
+	}
+
+	step := &xpathStep{axis: xpathChild}
+	if p.accept("@") {
+		step.axis = xpathAttribute
+	} else if t, next := p.peek(0), p.peek(1); t != nil && t.kind == xpathNameToken && next != nil && next.text == "::" {
+		axis, ok := xpathAxes[t.text]
+		if !ok {
+			return nil, fmt.Errorf("invalid XPath expression: unsupported axis %v", t.text)
+		}
+		step.axis = axis
+		p.pos += 2
+	}
+
+	t := p.peek(0)
+	switch {
+	case t == nil:
+		return nil, fmt.Errorf("invalid XPath expression: unexpected end")
+	case t.kind == xpathOperatorToken && t.text == "*":
+		p.pos++
+		step.test = xpathAnyNameTest
+	case t.kind == xpathNameToken && (t.text == "text" || t.text == "node") && p.peek(1) != nil && p.peek(1).text == "(":
+		p.pos += 2
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		step.test = xpathNodeTypeTest
+		if t.text == "text" {
+			step.test = xpathTextTest
+		}
+	case t.kind == xpathNameToken:
+		p.pos++
+		step.test = xpathNameTest
+		step.name = t.text
+		if i := strings.IndexByte(step.name, ':'); i >= 0 {
+			step.name = step.name[i+1:]
+		}
+		if step.name == "*" || step.name == "" {
+			step.test = xpathAnyNameTest
+		}
+	default:
+		return nil, fmt.Errorf("invalid XPath expression: unexpected %q", t.text)
+	}
+
+	for p.accept("[") {
+		pred, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		step.predicates = append(step.predicates, pred)
+	}
+
+	return step, nil
+}